@@ -642,6 +642,7 @@ func TestClusterRestoreFailCleanup(t *testing.T) {
 				{"comments"},
 				{"database_role_settings"},
 				{"external_connections"},
+				{"large_objects"},
 				{"locations"},
 				{"privileges"},
 				{"role_id_seq"},
@@ -735,6 +736,7 @@ func TestClusterRestoreFailCleanup(t *testing.T) {
 				{"comments"},
 				{"database_role_settings"},
 				{"external_connections"},
+				{"large_objects"},
 				{"locations"},
 				{"privileges"},
 				{"role_id_seq"},
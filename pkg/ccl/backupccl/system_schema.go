@@ -548,6 +548,18 @@ var systemTableBackupConfiguration = map[string]systemBackupConfiguration{
 		customRestoreFunc:            roleIDSeqRestoreFunc,
 		restoreInOrder:               roleIDSequenceRestoreOrder,
 	},
+	systemschema.LargeObjectsTable.GetName(): {
+		shouldIncludeInClusterBackup: optInToClusterBackup, // No desc ID columns.
+	},
+	systemschema.LargeObjectOIDSequence.GetName(): {
+		// LargeObjectCreate skips the OIDs which are already in use, so the
+		// sequence does not need to be restored along with the large objects.
+		shouldIncludeInClusterBackup: optOutOfClusterBackup,
+	},
+	systemschema.AdvisoryLocksTable.GetName(): {
+		// The advisory locks are held by the sessions of the backed up cluster.
+		shouldIncludeInClusterBackup: optOutOfClusterBackup,
+	},
 }
 
 func rekeySystemTable(
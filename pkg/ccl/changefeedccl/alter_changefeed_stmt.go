@@ -623,6 +623,18 @@ func generateNewProgress(
 	haveCheckpoint := changefeedProgress != nil && changefeedProgress.Checkpoint != nil &&
 		len(changefeedProgress.Checkpoint.Spans) != 0
 
+	// The emitted stats are cumulative, the retry log describes the history of
+	// the changefeed, and the changefeed is still paused, so they are carried
+	// over to the new progress.
+	var prevEmittedStats []jobspb.ChangefeedEmittedStats
+	var prevRetryLog []jobspb.ChangefeedRetryLogEntry
+	var prevPausedAt hlc.Timestamp
+	if changefeedProgress != nil {
+		prevEmittedStats = changefeedProgress.EmittedStats
+		prevRetryLog = changefeedProgress.RetryLog
+		prevPausedAt = changefeedProgress.PausedAt
+	}
+
 	// Check if the progress does not need to be updated. The progress does not
 	// need to be updated if:
 	// * the high watermark is empty, and we would like to perform an initial scan.
@@ -660,6 +672,9 @@ func generateNewProgress(
 					Checkpoint: &jobspb.ChangefeedProgress_Checkpoint{
 						Spans: existingTargetSpans,
 					},
+					EmittedStats: prevEmittedStats,
+					RetryLog:     prevRetryLog,
+					PausedAt:     prevPausedAt,
 				},
 			},
 		}
@@ -689,6 +704,9 @@ func generateNewProgress(
 				Checkpoint: &jobspb.ChangefeedProgress_Checkpoint{
 					Spans: mergedSpanGroup.Slice(),
 				},
+				EmittedStats: prevEmittedStats,
+				RetryLog:     prevRetryLog,
+				PausedAt:     prevPausedAt,
 			},
 		},
 	}
@@ -9,12 +9,16 @@
 package changefeedccl
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"math"
 	"math/big"
 	"time"
 
 	"github.com/cockroachdb/apd/v3"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/geo"
 	"github.com/cockroachdb/cockroach/pkg/geo/geopb"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
@@ -65,12 +69,24 @@ const (
 	avroSchemaBoolean = `boolean`
 	avroSchemaBytes   = `bytes`
 	avroSchemaDouble  = `double`
+	avroSchemaFixed   = `fixed`
 	avroSchemaInt     = `int`
 	avroSchemaLong    = `long`
 	avroSchemaNull    = `null`
+	avroSchemaRecord  = `record`
 	avroSchemaString  = `string`
 )
 
+const (
+	// avroLogicalTypeDuration is the logical type of the fixed values holding
+	// the months, days and milliseconds of a duration.
+	avroLogicalTypeDuration = `duration`
+	// avroLogicalTypeJSON annotates the strings holding JSON documents. It is
+	// not part of the Avro specification, so the readers which do not know it
+	// read the values as plain strings.
+	avroLogicalTypeJSON = `json`
+)
+
 type avroLogicalType struct {
 	SchemaType  avroSchemaType `json:"type"`
 	LogicalType string         `json:"logicalType"`
@@ -83,14 +99,31 @@ type avroArrayType struct {
 	Items      avroSchemaType `json:"items"`
 }
 
+// avroFixedType is the schema of the values of a fixed number of bytes. Like
+// records, fixed types are named, and their names must be unique within a
+// schema.
+type avroFixedType struct {
+	SchemaType  avroSchemaType `json:"type"`
+	Name        string         `json:"name"`
+	Size        int            `json:"size"`
+	LogicalType string         `json:"logicalType,omitempty"`
+}
+
 func avroUnionKey(t avroSchemaType) string {
 	switch s := t.(type) {
 	case string:
 		return s
 	case avroLogicalType:
+		if s.LogicalType == avroLogicalTypeJSON {
+			// goavro ignores the logical types it does not know, and names the
+			// members of the unions after their underlying type.
+			return avroUnionKey(s.SchemaType)
+		}
 		return avroUnionKey(s.SchemaType) + `.` + s.LogicalType
 	case avroArrayType:
 		return avroUnionKey(s.SchemaType)
+	case avroFixedType:
+		return s.Name
 	case *avroRecord:
 		if s.Namespace == "" {
 			return s.Name
@@ -156,6 +189,9 @@ type avroDataRecord struct {
 
 	colIdxByFieldIdx map[int]int
 	fieldIdxByName   map[string]int
+	// fieldIdxByColumn maps the names of the columns to their fields, which
+	// may be named differently (see changefeedbase.FieldNamer).
+	fieldIdxByColumn map[string]int
 	// Allocate Go native representation once, to avoid repeated map allocation
 	// when encoding.
 	native map[string]interface{}
@@ -168,6 +204,11 @@ type avroMetadata map[string]interface{}
 type avroEnvelopeOpts struct {
 	beforeField, afterField     bool
 	updatedField, resolvedField bool
+	// debeziumFields adds the source, op and ts_ms fields of the debezium
+	// envelope, which is then named like the envelopes of Debezium.
+	debeziumFields bool
+	// metadataColumns adds a field per metadata column, named after it.
+	metadataColumns changefeedbase.MetadataColumns
 }
 
 // avroEnvelopeRecord is an `avroRecord` that wraps a changed SQL row and some
@@ -177,10 +218,45 @@ type avroEnvelopeRecord struct {
 
 	opts          avroEnvelopeOpts
 	before, after *avroDataRecord
+	source        *avroRecord
+}
+
+// avroDecimalConfig configures the encoding of the DECIMAL columns (see
+// changefeedbase.OptAvroDecimalMode). The zero value fails to encode the
+// columns without a precision and the values which do not fit their column.
+type avroDecimalConfig struct {
+	mode changefeedbase.AvroDecimalMode
+	// precision and scale are the precision and scale of the columns without
+	// a precision, with changefeedbase.OptAvroDecimalFixed.
+	precision, scale int
+}
+
+func makeAvroDecimalConfig(opts changefeedbase.EncodingOptions) avroDecimalConfig {
+	return avroDecimalConfig{
+		mode:      opts.AvroDecimalMode,
+		precision: opts.AvroDecimalPrecision,
+		scale:     opts.AvroDecimalScale,
+	}
+}
+
+// fallback returns the string encoding of the decimal, which does not fit its
+// column, unless such values are not encoded as strings, in which case it
+// returns the error.
+func (c avroDecimalConfig) fallback(d tree.Datum, err error) (interface{}, error) {
+	switch c.mode {
+	case changefeedbase.OptAvroDecimalString, changefeedbase.OptAvroDecimalFixed:
+		return d.String(), nil
+	default:
+		return nil, err
+	}
 }
 
-// typeToAvroSchema converts a database type to an avro field
-func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
+// typeToAvroSchema converts a database type to an avro field. The named types
+// of the schema, such as the records of tuples, are named after name, which
+// must be a full name unique within the schema of the row.
+func typeToAvroSchema(
+	typ *types.T, name string, decimals avroDecimalConfig,
+) (*avroSchemaField, error) {
 	schema := &avroSchemaField{
 		typ: typ,
 	}
@@ -477,33 +553,61 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 			},
 		)
 	case types.IntervalFamily:
-		setNullable(
-			// This would ideally be the avro Duration logical type
-			// However, the spec is not implemented in most tooling
-			// and is problematic--it requires 32-bit integers
-			// representing months, days, and milliseconds, meaning
-			// it can't encode everything we can with our int64 years.
-			// String encoding is still fairly terse and arguably the
-			// only semantically exact representation.
-			// Using ISO 8601 format (https://en.wikipedia.org/wiki/ISO_8601#Durations)
-			// because it's the tersest of the input formats we support
-			// and isn't golang-specific.
-			avroSchemaString,
+		// The avro duration logical type holds unsigned 32-bit integers
+		// representing months, days, and milliseconds, meaning it can't encode
+		// everything we can with our int64 months, days and nanoseconds. The
+		// intervals it can't represent exactly, such as the negative intervals,
+		// fall back to strings, which are arguably the only semantically exact
+		// representation. Using ISO 8601 format
+		// (https://en.wikipedia.org/wiki/ISO_8601#Durations) because it's the
+		// tersest of the input formats we support and isn't golang-specific.
+		durationType := avroFixedType{
+			SchemaType:  avroSchemaFixed,
+			Name:        name,
+			Size:        avroDurationSize,
+			LogicalType: avroLogicalTypeDuration,
+		}
+		setNullableWithStringFallback(
+			durationType,
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
-				return d.(*tree.DInterval).ValueAsISO8601String(), nil
+				dInterval := d.(*tree.DInterval)
+				if b, ok := durationToAvro(dInterval.Duration); ok {
+					return b, nil
+				}
+				return dInterval.ValueAsISO8601String(), nil
 			},
 			func(x interface{}) (tree.Datum, error) {
-				return tree.ParseDInterval(duration.IntervalStyle_ISO_8601, x.(string))
+				unionMap := x.(map[string]interface{})
+				if b, ok := unionMap[avroUnionKey(durationType)]; ok {
+					return &tree.DInterval{Duration: durationFromAvro(b.([]byte))}, nil
+				}
+				return tree.ParseDInterval(duration.IntervalStyle_ISO_8601, unionMap[avroUnionKey(avroSchemaString)].(string))
 			},
 		)
 	case types.DecimalFamily:
-		if typ.Precision() == 0 {
-			return nil, errors.Errorf(
-				`decimal with no precision not yet supported with avro`)
-		}
-
 		width := int(typ.Width())
 		prec := int(typ.Precision())
+		if prec == 0 {
+			switch decimals.mode {
+			case changefeedbase.OptAvroDecimalFixed:
+				prec, width = decimals.precision, decimals.scale
+			case changefeedbase.OptAvroDecimalString:
+				setNullable(
+					avroSchemaString,
+					func(d tree.Datum, _ interface{}) (interface{}, error) {
+						return d.String(), nil
+					},
+					func(x interface{}) (tree.Datum, error) {
+						return tree.ParseDDecimal(x.(string))
+					},
+				)
+				return schema, nil
+			default:
+				return nil, errors.Errorf(
+					`decimal with no precision not yet supported with avro`)
+			}
+		}
+
 		decimalType := avroLogicalType{
 			SchemaType:  avroSchemaBytes,
 			LogicalType: `decimal`,
@@ -519,14 +623,21 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 					return d.String(), nil
 				}
 
+				// The decimal may not fit the column, such as the previous
+				// value of a column whose type was altered.
+				if int32(width) < -dec.Exponent {
+					return decimals.fallback(d, errors.Errorf(
+						`%s will not roundtrip at scale %d`, &dec, width))
+				}
+
 				// If the decimal happens to fit a smaller width than the
 				// column allows, add trailing zeroes so the scale is constant
-				if typ.Width() > -dec.Exponent {
+				if int32(width) > -dec.Exponent {
 					_, err := tree.DecimalCtx.WithPrecision(uint32(prec)).Quantize(&dec, &dec, -int32(width))
 					if err != nil {
 						// This should always be possible without rounding since we're using the column def,
 						// but if it's not, WithPrecision will force it to error.
-						return nil, err
+						return decimals.fallback(d, err)
 					}
 				}
 
@@ -537,7 +648,7 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 				// using and that's too scary leading up to 2.1.0.
 				rat, err := decimalToRat(dec, int32(width))
 				if err != nil {
-					return nil, err
+					return decimals.fallback(d, err)
 				}
 				return &rat, nil
 			},
@@ -574,7 +685,10 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 		)
 	case types.JsonFamily:
 		setNullable(
-			avroSchemaString,
+			avroLogicalType{
+				SchemaType:  avroSchemaString,
+				LogicalType: avroLogicalTypeJSON,
+			},
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
 				return d.(*tree.DJSON).JSON.String(), nil
 			},
@@ -593,7 +707,7 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 			},
 		)
 	case types.ArrayFamily:
-		itemSchema, err := typeToAvroSchema(typ.ArrayContents())
+		itemSchema, err := typeToAvroSchema(typ.ArrayContents(), name, decimals)
 		if err != nil {
 			return nil, errors.Wrapf(err, `could not create item schema for %s`,
 				typ)
@@ -674,6 +788,43 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 				return datumArr, nil
 			},
 		)
+	case types.TupleFamily:
+		record, fieldSchemas, err := tupleToAvroRecord(typ, name, decimals)
+		if err != nil {
+			return nil, err
+		}
+		setNullable(
+			record,
+			func(d tree.Datum, _ interface{}) (interface{}, error) {
+				// The fields are encoded without memoization, since the
+				// tuples of an array are encoded before the array is.
+				tuple := d.(*tree.DTuple)
+				native := make(map[string]interface{}, len(fieldSchemas))
+				for i, field := range fieldSchemas {
+					if tuple.D[i] == tree.DNull {
+						native[field.Name] = nil
+						continue
+					}
+					encoded, err := field.encodeDatum(tuple.D[i], nil /* memo */)
+					if err != nil {
+						return nil, err
+					}
+					native[field.Name] = map[string]interface{}{field.unionKeyOf(encoded): encoded}
+				}
+				return native, nil
+			},
+			func(x interface{}) (tree.Datum, error) {
+				native := x.(map[string]interface{})
+				datums := make(tree.Datums, len(fieldSchemas))
+				for i, field := range fieldSchemas {
+					var err error
+					if datums[i], err = field.decodeFn(native[field.Name]); err != nil {
+						return nil, err
+					}
+				}
+				return tree.NewDTuple(typ, datums...), nil
+			},
+		)
 
 	default:
 		return nil, errors.Errorf(`type %s not yet supported with avro`,
@@ -683,10 +834,81 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 	return schema, nil
 }
 
+// avroDurationSize is the size of the values of the avro duration logical
+// type: three little-endian unsigned 32-bit integers, holding months, days and
+// milliseconds.
+const avroDurationSize = 12
+
+// durationToAvro encodes the duration as a value of the avro duration logical
+// type. It returns false if the duration cannot be represented exactly.
+func durationToAvro(d duration.Duration) ([]byte, bool) {
+	nanos := d.Nanos()
+	if d.Months < 0 || d.Months > math.MaxUint32 || d.Days < 0 || d.Days > math.MaxUint32 ||
+		nanos < 0 || nanos%int64(time.Millisecond) != 0 || nanos/int64(time.Millisecond) > math.MaxUint32 {
+		return nil, false
+	}
+	b := make([]byte, avroDurationSize)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(d.Months))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(d.Days))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(nanos/int64(time.Millisecond)))
+	return b, true
+}
+
+// durationFromAvro decodes a value of the avro duration logical type.
+func durationFromAvro(b []byte) duration.Duration {
+	return duration.MakeDuration(
+		int64(binary.LittleEndian.Uint32(b[8:12]))*int64(time.Millisecond),
+		int64(binary.LittleEndian.Uint32(b[4:8])),
+		int64(binary.LittleEndian.Uint32(b[0:4])),
+	)
+}
+
+// tupleToAvroRecord returns the schema of the tuples of the type, which are
+// records named name, whose fields are named after the labels of the tuple or,
+// for the unlabeled tuples, after their position, as in f1, f2.
+func tupleToAvroRecord(
+	typ *types.T, name string, decimals avroDecimalConfig,
+) (*avroRecord, []*avroSchemaField, error) {
+	record := &avroRecord{
+		SchemaType: avroSchemaRecord,
+		Name:       name,
+	}
+	labels := typ.TupleLabels()
+	fieldSchemas := make([]*avroSchemaField, len(typ.TupleContents()))
+	for i, contents := range typ.TupleContents() {
+		fieldName := fmt.Sprintf(`f%d`, i+1)
+		if i < len(labels) && labels[i] != `` {
+			fieldName = SQLNameToAvroName(labels[i])
+		}
+		field, err := typeToAvroSchema(contents, name+`_`+fieldName, decimals)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, `tuple field %s`, fieldName)
+		}
+		field.Name = fieldName
+		field.Default = nil
+		fieldSchemas[i] = field
+		record.Fields = append(record.Fields, field)
+	}
+	return record, fieldSchemas, nil
+}
+
+// unionKeyOf returns the key of the member of the union of the field holding
+// the value encoded by encodeDatum, which is the string fallback of the field,
+// if any, for the values encoded as strings.
+func (f *avroSchemaField) unionKeyOf(encoded interface{}) string {
+	members := f.SchemaType.([]avroSchemaType)
+	if _, isString := encoded.(string); isString && len(members) > 2 {
+		return avroUnionKey(members[2])
+	}
+	return avroUnionKey(members[1])
+}
+
 // columnToAvroSchema converts a column descriptor into its corresponding
-// avro field schema.
-func columnToAvroSchema(col cdcevent.ResultColumn) (*avroSchemaField, error) {
-	schema, err := typeToAvroSchema(col.Typ)
+// avro field schema. recordName is the full name of the record of the row.
+func columnToAvroSchema(
+	col cdcevent.ResultColumn, recordName string, decimals avroDecimalConfig,
+) (*avroSchemaField, error) {
+	schema, err := typeToAvroSchema(col.Typ, recordName+`.`+SQLNameToAvroName(col.Name), decimals)
 	if err != nil {
 		return nil, errors.Wrapf(err, "column %s", col.Name)
 	}
@@ -699,9 +921,14 @@ func columnToAvroSchema(col cdcevent.ResultColumn) (*avroSchemaField, error) {
 
 // newSchemaForRow constructs avro schema for the Row.
 // Only columns returned by Iterator as used to popoulate schema fields.
-// sqlName can be any string but should uniquely identify a schema.
+// sqlName can be any string but should uniquely identify a schema. The fields
+// are named by namer, and the DECIMAL columns are encoded after decimals.
 func newSchemaForRow(
-	it cdcevent.Iterator, sqlName string, namespace string,
+	it cdcevent.Iterator,
+	sqlName string,
+	namespace string,
+	namer changefeedbase.FieldNamer,
+	decimals avroDecimalConfig,
 ) (*avroDataRecord, error) {
 	schema := &avroDataRecord{
 		avroRecord: avroRecord{
@@ -710,19 +937,28 @@ func newSchemaForRow(
 			Namespace:  namespace,
 		},
 		fieldIdxByName:   make(map[string]int),
+		fieldIdxByColumn: make(map[string]int),
 		colIdxByFieldIdx: make(map[int]int),
 	}
 
+	recordName := sqlName
+	if namespace != `` {
+		recordName = namespace + `.` + sqlName
+	}
 	if err := it.Col(func(col cdcevent.ResultColumn) error {
-		field, err := columnToAvroSchema(col)
+		field, err := columnToAvroSchema(col, recordName, decimals)
 		if err != nil {
 			return err
 		}
+		if !namer.IsIdentity() {
+			field.Name = SQLNameToAvroName(namer.Name(col.Name))
+		}
 		// Set column index -> ColumnID mapping.  We set PGAttributeNum to be the
 		// column ordinal position.  This information is only used to test that avro
 		// encoding is round trip-able.
 		schema.colIdxByFieldIdx[len(schema.Fields)] = col.Ordinal()
 		schema.fieldIdxByName[field.Name] = len(schema.Fields)
+		schema.fieldIdxByColumn[col.Name] = len(schema.Fields)
 		schema.Fields = append(schema.Fields, field)
 		return nil
 	}); err != nil {
@@ -742,9 +978,13 @@ func newSchemaForRow(
 
 // primaryIndexToAvroSchema constructs schema for primary index.
 func primaryIndexToAvroSchema(
-	row cdcevent.Row, sqlName string, namespace string,
+	row cdcevent.Row,
+	sqlName string,
+	namespace string,
+	namer changefeedbase.FieldNamer,
+	decimals avroDecimalConfig,
 ) (*avroDataRecord, error) {
-	return newSchemaForRow(row.ForEachKeyColumn(), SQLNameToAvroName(sqlName), namespace)
+	return newSchemaForRow(row.ForEachKeyColumn(), SQLNameToAvroName(sqlName), namespace, namer, decimals)
 }
 
 const (
@@ -757,7 +997,11 @@ const (
 // If a name suffix is provided (as opposed to avroSchemaNoSuffix), it will be
 // appended to the end of the avro record's name.
 func tableToAvroSchema(
-	row cdcevent.Row, nameSuffix string, namespace string,
+	row cdcevent.Row,
+	nameSuffix string,
+	namespace string,
+	namer changefeedbase.FieldNamer,
+	decimals avroDecimalConfig,
 ) (*avroDataRecord, error) {
 	var sqlName string
 	// Even though we now always specify a family,
@@ -771,7 +1015,7 @@ func tableToAvroSchema(
 	if nameSuffix != avroSchemaNoSuffix {
 		sqlName = sqlName + `_` + nameSuffix
 	}
-	return newSchemaForRow(row.ForEachColumn(), sqlName, namespace)
+	return newSchemaForRow(row.ForEachColumn(), sqlName, namespace, namer, decimals)
 }
 
 // BinaryFromRow encodes the given row data into avro's defined binary format.
@@ -824,11 +1068,11 @@ func (r *avroDataRecord) nativeFromRow(it cdcevent.Iterator) (interface{}, error
 	}
 
 	if err := it.Datum(func(d tree.Datum, col cdcevent.ResultColumn) (err error) {
-		fieldIdx, ok := r.fieldIdxByName[col.Name]
+		fieldIdx, ok := r.fieldIdxByColumn[col.Name]
 		if !ok {
 			return errors.AssertionFailedf("could not find avro field for column %s", col.Name)
 		}
-		r.native[col.Name], err = r.Fields[fieldIdx].encodeFn(d)
+		r.native[r.Fields[fieldIdx].Name], err = r.Fields[fieldIdx].encodeFn(d)
 		return err
 	}); err != nil {
 		return nil, err
@@ -908,6 +1152,40 @@ func envelopeToAvroSchema(
 		}
 		schema.Fields = append(schema.Fields, resolvedField)
 	}
+	for _, col := range opts.metadataColumns.Columns() {
+		schema.Fields = append(schema.Fields, &avroSchemaField{
+			SchemaType: []avroSchemaType{avroSchemaNull, metadataColumnAvroType(col)},
+			Name:       string(col),
+			Default:    nil,
+		})
+	}
+	if opts.debeziumFields {
+		// The envelopes of Debezium are named Envelope in the namespace of their
+		// table.
+		schema.Name = `Envelope`
+		schema.Namespace = SQLNameToAvroName(topic)
+		if namespace != `` {
+			schema.Namespace = namespace + `.` + schema.Namespace
+		}
+		schema.source = debeziumSourceAvroSchema()
+		schema.Fields = append(schema.Fields,
+			&avroSchemaField{
+				SchemaType: []avroSchemaType{avroSchemaNull, schema.source},
+				Name:       `source`,
+				Default:    nil,
+			},
+			&avroSchemaField{
+				SchemaType: []avroSchemaType{avroSchemaNull, avroSchemaString},
+				Name:       `op`,
+				Default:    nil,
+			},
+			&avroSchemaField{
+				SchemaType: []avroSchemaType{avroSchemaNull, avroSchemaLong},
+				Name:       `ts_ms`,
+				Default:    nil,
+			},
+		)
+	}
 
 	schemaJSON, err := json.Marshal(schema)
 	if err != nil {
@@ -920,6 +1198,59 @@ func envelopeToAvroSchema(
 	return schema, nil
 }
 
+// debeziumSourceAvroSchema returns the schema of the source block of the
+// debezium envelope (see debeziumSource).
+func debeziumSourceAvroSchema() *avroRecord {
+	source := &avroRecord{
+		Name:       `Source`,
+		SchemaType: `record`,
+		Namespace:  debeziumConnector,
+	}
+	for _, f := range []struct {
+		name string
+		typ  avroSchemaType
+	}{
+		{`version`, avroSchemaString},
+		{`connector`, avroSchemaString},
+		{`ts_ms`, avroSchemaLong},
+		{`ts_hlc`, avroSchemaString},
+		{`snapshot`, avroSchemaString},
+		{`table`, avroSchemaString},
+	} {
+		source.Fields = append(source.Fields, &avroSchemaField{
+			SchemaType: []avroSchemaType{avroSchemaNull, f.typ},
+			Name:       f.name,
+			Default:    nil,
+		})
+	}
+	return source
+}
+
+// metadataColumnAvroType returns the avro type of the metadata field (see
+// metadataColumnValue).
+func metadataColumnAvroType(col changefeedbase.MetadataColumn) avroSchemaType {
+	switch col {
+	case changefeedbase.OptMetadataColumnTableID, changefeedbase.OptMetadataColumnJobID,
+		changefeedbase.OptMetadataColumnNodeID:
+		return avroSchemaLong
+	default:
+		return avroSchemaString
+	}
+}
+
+// nullableNative returns the native representation of the value of a field
+// unioning its type with null.
+func nullableNative(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return goavro.Union(avroUnionKey(avroSchemaString), v), nil
+	case int64:
+		return goavro.Union(avroUnionKey(avroSchemaLong), v), nil
+	default:
+		return nil, errors.AssertionFailedf(`unknown metadata type: %T`, v)
+	}
+}
+
 // BinaryFromRow encodes the given metadata and row data into avro's defined
 // binary format.
 func (r *avroEnvelopeRecord) BinaryFromRow(
@@ -972,6 +1303,48 @@ func (r *avroEnvelopeRecord) BinaryFromRow(
 			native[`resolved`] = goavro.Union(avroUnionKey(avroSchemaString), ts.AsOfSystemTime())
 		}
 	}
+	if r.opts.debeziumFields {
+		for _, k := range []string{`source`, `op`, `ts_ms`} {
+			native[k] = nil
+		}
+		if s, ok := meta[`source`]; ok {
+			delete(meta, `source`)
+			source, ok := s.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf(`unknown metadata source type: %T`, s)
+			}
+			sourceNative := make(map[string]interface{}, len(r.source.Fields))
+			for _, f := range r.source.Fields {
+				sourceNative[f.Name] = nil
+				if v, ok := source[f.Name]; ok {
+					var err error
+					if sourceNative[f.Name], err = nullableNative(v); err != nil {
+						return nil, err
+					}
+				}
+			}
+			native[`source`] = goavro.Union(avroUnionKey(r.source), sourceNative)
+		}
+		for _, k := range []string{`op`, `ts_ms`} {
+			if v, ok := meta[k]; ok {
+				delete(meta, k)
+				var err error
+				if native[k], err = nullableNative(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, col := range r.opts.metadataColumns.Columns() {
+		native[string(col)] = nil
+		if v, ok := meta[string(col)]; ok {
+			delete(meta, string(col))
+			var err error
+			if native[string(col)], err = nullableNative(v); err != nil {
+				return nil, err
+			}
+		}
+	}
 	for k := range meta {
 		return nil, errors.AssertionFailedf(`unhandled meta key: %s`, k)
 	}
@@ -982,7 +1355,7 @@ func (r *avroEnvelopeRecord) BinaryFromRow(
 // The only user-defined type is enum, so this is usually a no-op.
 func (r *avroDataRecord) refreshTypeMetadata(row cdcevent.Row) error {
 	return row.ForEachUDTColumn().Col(func(col cdcevent.ResultColumn) error {
-		if fieldIdx, ok := r.fieldIdxByName[col.Name]; ok {
+		if fieldIdx, ok := r.fieldIdxByColumn[col.Name]; ok {
 			r.Fields[fieldIdx].typ = col.Typ
 		}
 		return nil
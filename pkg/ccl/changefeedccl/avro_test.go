@@ -20,6 +20,7 @@ import (
 
 	"github.com/cockroachdb/apd/v3"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
@@ -41,12 +42,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/duration"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil/pgdate"
 	"github.com/cockroachdb/errors"
+	"github.com/linkedin/goavro/v2"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/text/collate"
 )
@@ -165,7 +168,7 @@ func parseAvroSchema(t *testing.T, j string) (*avroDataRecord, error) {
 	return tableToAvroSchema(
 		cdcevent.TestingMakeEventRow(
 			tabledesc.NewBuilder(&tableDesc).BuildImmutableTable(), 0, nil, false,
-		), "", "")
+		), "", "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 }
 
 func avroFieldMetadataToColDesc(metadata string) (*descpb.ColumnDescriptor, error) {
@@ -390,7 +393,7 @@ func TestAvroSchema(t *testing.T) {
 			require.NoError(t, err)
 			origSchema, err := tableToAvroSchema(
 				cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false),
-				avroSchemaNoSuffix, "")
+				avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 			require.NoError(t, err)
 			jsonSchema := origSchema.codec.Schema()
 			roundtrippedSchema, err := parseAvroSchema(t, jsonSchema)
@@ -428,7 +431,7 @@ func TestAvroSchema(t *testing.T) {
 		tableDesc, err := parseTableDesc(`CREATE TABLE "☃" (🍦 INT PRIMARY KEY)`)
 		require.NoError(t, err)
 		tableSchema, err := tableToAvroSchema(
-			cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false), avroSchemaNoSuffix, "")
+			cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false), avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 		require.NoError(t, err)
 		require.Equal(t,
 			`{"type":"record","name":"_u2603_","fields":[`+
@@ -436,7 +439,7 @@ func TestAvroSchema(t *testing.T) {
 				`"__crdb__":"🍦 INT8 NOT NULL"}]}`,
 			tableSchema.codec.Schema())
 		indexSchema, err := primaryIndexToAvroSchema(
-			cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false), tableDesc.GetName(), "")
+			cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false), tableDesc.GetName(), "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 		require.NoError(t, err)
 		require.Equal(t,
 			`{"type":"record","name":"_u2603_","fields":[`+
@@ -459,8 +462,8 @@ func TestAvroSchema(t *testing.T) {
 			`GEOMETRY`:          `["null","bytes"]`,
 			`INET`:              `["null","string"]`,
 			`INT8`:              `["null","long"]`,
-			`INTERVAL`:          `["null","string"]`,
-			`JSONB`:             `["null","string"]`,
+			`INTERVAL`:          `["null",{"type":"fixed","name":"foo.a","size":12,"logicalType":"duration"},"string"]`,
+			`JSONB`:             `["null",{"type":"string","logicalType":"json"}]`,
 			`STRING`:            `["null","string"]`,
 			`STRING COLLATE fr`: `["null","string"]`,
 			`TIME`:              `["null",{"type":"long","logicalType":"time-micros"}]`,
@@ -486,7 +489,8 @@ func TestAvroSchema(t *testing.T) {
 			tableDesc, err := parseTableDesc(`CREATE TABLE foo (pk INT PRIMARY KEY, a ` + colType + `)`)
 			require.NoError(t, err)
 			field, err := columnToAvroSchema(
-				cdcevent.ResultColumn{ResultColumn: colinfo.ResultColumn{Typ: tableDesc.PublicColumns()[1].GetType()}},
+				cdcevent.ResultColumn{ResultColumn: colinfo.ResultColumn{Name: `a`, Typ: tableDesc.PublicColumns()[1].GetType()}},
+				`foo`, avroDecimalConfig{},
 			)
 			require.NoError(t, err)
 			schema, err := json.Marshal(field.SchemaType)
@@ -570,7 +574,10 @@ func TestAvroSchema(t *testing.T) {
 			{sqlType: `INTERVAL`, sql: `NULL`, avro: `null`},
 			{sqlType: `INTERVAL`,
 				sql:  `INTERVAL '1 yr 2 mons 3 d 4 hrs 5 mins 6 secs'`,
-				avro: `{"string":"P1Y2M3DT4H5M6S"}`},
+				avro: `{"foo.a":"\u000E\u0000\u0000\u0000\u0003\u0000\u0000\u0000Pe\u00E0\u0000"}`},
+			{sqlType: `INTERVAL`,
+				sql:  `INTERVAL '1 d 1 us'`,
+				avro: `{"string":"P1DT0.000001S"}`},
 			{sqlType: `INTERVAL`,
 				sql:  `INTERVAL '1 yr -6 ms'`,
 				avro: `{"string":"P1YT-0.006S"}`},
@@ -661,7 +668,7 @@ func TestAvroSchema(t *testing.T) {
 
 			row := cdcevent.TestingMakeEventRow(tableDesc, 0, encDatums[0], false)
 			schema, err := tableToAvroSchema(
-				row, avroSchemaNoSuffix, "")
+				row, avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 			require.NoError(t, err)
 			if test.numRawBytes > 0 {
 				overhead := 4
@@ -686,6 +693,49 @@ func TestAvroSchema(t *testing.T) {
 
 	// These are values stored with less precision than the column definition allows,
 	// which is still roundtrippable
+	// Tuples, which the queries of changefeeds can project, are encoded as
+	// records, which can hold arrays and be held by arrays.
+	t.Run("tuples", func(t *testing.T) {
+		tupleType := types.MakeLabeledTuple(
+			[]*types.T{types.Int, types.Interval, types.MakeArray(types.String)}, []string{`x`, `y`, `z`})
+		names := tree.NewDArray(types.String)
+		require.NoError(t, names.Append(tree.NewDString(`a`)))
+		require.NoError(t, names.Append(tree.DNull))
+		tuple := tree.NewDTuple(tupleType, tree.NewDInt(1),
+			&tree.DInterval{Duration: duration.MakeDuration(3*int64(time.Second), 2, 1)}, names)
+		negative := tree.NewDTuple(tupleType, tree.DNull,
+			&tree.DInterval{Duration: duration.MakeDuration(-1, 0, 0)}, tree.DNull)
+		tuples := tree.NewDArray(tupleType)
+		require.NoError(t, tuples.Append(tuple))
+		require.NoError(t, tuples.Append(tree.DNull))
+		require.NoError(t, tuples.Append(negative))
+
+		evalCtx := &eval.Context{
+			SessionDataStack: sessiondata.NewStack(&sessiondata.SessionData{}),
+		}
+		for _, d := range []tree.Datum{tuple, negative, tuples} {
+			field, err := typeToAvroSchema(d.ResolvedType(), `foo.t`, avroDecimalConfig{})
+			require.NoError(t, err)
+			field.Name = `t`
+			schemaJSON, err := json.Marshal(&avroRecord{
+				SchemaType: `record`, Name: `foo`, Fields: []*avroSchemaField{field},
+			})
+			require.NoError(t, err)
+			codec, err := goavro.NewCodec(string(schemaJSON))
+			require.NoError(t, err)
+
+			native, err := field.encodeFn(d)
+			require.NoError(t, err)
+			binary, err := codec.BinaryFromNative(nil, map[string]interface{}{`t`: native})
+			require.NoError(t, err)
+			decoded, _, err := codec.NativeFromBinary(binary)
+			require.NoError(t, err)
+			roundtripped, err := field.decodeFn(decoded.(map[string]interface{})[`t`])
+			require.NoError(t, err)
+			require.Equal(t, 0, d.Compare(evalCtx, roundtripped), `%s != %s`, d, roundtripped)
+		}
+	})
+
 	t.Run("lossless_truncations", func(t *testing.T) {
 		truncs := []struct {
 			sqlType string
@@ -718,7 +768,7 @@ func TestAvroSchema(t *testing.T) {
 			require.NoError(t, err)
 
 			row := cdcevent.TestingMakeEventRow(tableDesc, 0, encDatums[0], false)
-			schema, err := tableToAvroSchema(row, avroSchemaNoSuffix, "")
+			schema, err := tableToAvroSchema(row, avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 			require.NoError(t, err)
 			textual, err := schema.textualFromRow(row)
 			require.NoError(t, err)
@@ -822,13 +872,13 @@ func TestAvroMigration(t *testing.T) {
 				fmt.Sprintf(`CREATE TABLE "%s" %s`, test.name, test.writerSchema))
 			require.NoError(t, err)
 			writerSchema, err := tableToAvroSchema(
-				cdcevent.TestingMakeEventRow(writerDesc, 0, nil, false), avroSchemaNoSuffix, "")
+				cdcevent.TestingMakeEventRow(writerDesc, 0, nil, false), avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 			require.NoError(t, err)
 			readerDesc, err := parseTableDesc(
 				fmt.Sprintf(`CREATE TABLE "%s" %s`, test.name, test.readerSchema))
 			require.NoError(t, err)
 			readerSchema, err := tableToAvroSchema(
-				cdcevent.TestingMakeEventRow(readerDesc, 0, nil, false), avroSchemaNoSuffix, "")
+				cdcevent.TestingMakeEventRow(readerDesc, 0, nil, false), avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 			require.NoError(t, err)
 
 			writerRows, err := parseValues(writerDesc, `VALUES `+test.writerValues)
@@ -898,6 +948,87 @@ func TestDecimalRatRoundtrip(t *testing.T) {
 	})
 }
 
+func TestAvroDecimalModes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (pk INT PRIMARY KEY, a DECIMAL, b DECIMAL(4,1))`)
+	require.NoError(t, err)
+	makeRow := func(a, b *apd.Decimal) cdcevent.Row {
+		return cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+			rowenc.EncDatum{Datum: tree.NewDInt(1)},
+			rowenc.EncDatum{Datum: &tree.DDecimal{Decimal: *a}},
+			rowenc.EncDatum{Datum: &tree.DDecimal{Decimal: *b}},
+		}, false)
+	}
+	// roundtrip returns the values of a and b decoded from the encoded row.
+	roundtrip := func(t *testing.T, schema *avroDataRecord, row cdcevent.Row) (string, string) {
+		encoded, err := schema.BinaryFromRow(nil, row.ForEachColumn())
+		require.NoError(t, err)
+		decoded, err := schema.RowFromBinary(encoded)
+		require.NoError(t, err)
+		return decoded[1].Datum.String(), decoded[2].Datum.String()
+	}
+	fieldSchema := func(t *testing.T, schema *avroDataRecord, name string) string {
+		b, err := json.Marshal(schema.Fields[schema.fieldIdxByName[name]].SchemaType)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run(`error`, func(t *testing.T) {
+		_, err := tableToAvroSchema(makeRow(apd.New(1, 0), apd.New(1, 0)),
+			avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
+		require.EqualError(t, err, `column a: decimal with no precision not yet supported with avro`)
+
+		// The values which do not fit their column fail to be encoded.
+		boundedDesc, err := parseTableDesc(`CREATE TABLE bar (pk INT PRIMARY KEY, b DECIMAL(4,1))`)
+		require.NoError(t, err)
+		row := cdcevent.TestingMakeEventRow(boundedDesc, 0, rowenc.EncDatumRow{
+			rowenc.EncDatum{Datum: tree.NewDInt(1)},
+			rowenc.EncDatum{Datum: &tree.DDecimal{Decimal: *apd.New(1234, -2)}},
+		}, false)
+		schema, err := tableToAvroSchema(row, avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, avroDecimalConfig{})
+		require.NoError(t, err)
+		_, err = schema.BinaryFromRow(nil, row.ForEachColumn())
+		require.EqualError(t, err, `12.34 will not roundtrip at scale 1`)
+	})
+
+	t.Run(`string`, func(t *testing.T) {
+		decimals := avroDecimalConfig{mode: changefeedbase.OptAvroDecimalString}
+		row := makeRow(apd.New(12345, -3), apd.New(123, -1))
+		schema, err := tableToAvroSchema(row, avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, decimals)
+		require.NoError(t, err)
+		require.Equal(t, `["null","string"]`, fieldSchema(t, schema, `a`))
+		a, b := roundtrip(t, schema, row)
+		require.Equal(t, `12.345`, a)
+		require.Equal(t, `12.3`, b)
+
+		// The values which do not fit their column fall back to strings.
+		a, b = roundtrip(t, schema, makeRow(apd.New(1, 0), apd.New(1234, -2)))
+		require.Equal(t, `1`, a)
+		require.Equal(t, `12.34`, b)
+	})
+
+	t.Run(`fixed`, func(t *testing.T) {
+		decimals := avroDecimalConfig{mode: changefeedbase.OptAvroDecimalFixed, precision: 10, scale: 2}
+		row := makeRow(apd.New(1234, -2), apd.New(123, -1))
+		schema, err := tableToAvroSchema(row, avroSchemaNoSuffix, "", changefeedbase.FieldNamer{}, decimals)
+		require.NoError(t, err)
+		require.Equal(t, `["null",{"type":"bytes","logicalType":"decimal","precision":10,"scale":2},"string"]`,
+			fieldSchema(t, schema, `a`))
+		a, b := roundtrip(t, schema, row)
+		require.Equal(t, `12.34`, a)
+		require.Equal(t, `12.3`, b)
+
+		// The values with more fractional digits than the scale fall back to
+		// strings rather than being rounded.
+		a, _ = roundtrip(t, schema, makeRow(apd.New(12345, -3), apd.New(123, -1)))
+		require.Equal(t, `12.345`, a)
+		a, _ = roundtrip(t, schema, makeRow(apd.New(5, 0), apd.New(123, -1)))
+		require.Equal(t, `5.00`, a)
+	})
+}
+
 func benchmarkEncodeType(b *testing.B, typ *types.T, encRow rowenc.EncDatumRow) {
 	defer leaktest.AfterTest(b)()
 	defer log.Scope(b).Close(b)
@@ -906,7 +1037,7 @@ func benchmarkEncodeType(b *testing.B, typ *types.T, encRow rowenc.EncDatumRow)
 		fmt.Sprintf(`CREATE TABLE bench_table (bench_field %s)`, typ.SQLString()))
 	require.NoError(b, err)
 	row := cdcevent.TestingMakeEventRow(tableDesc, 0, encRow, false)
-	schema, err := tableToAvroSchema(row, "suffix", "namespace")
+	schema, err := tableToAvroSchema(row, "suffix", "namespace", changefeedbase.FieldNamer{}, avroDecimalConfig{})
 	require.NoError(b, err)
 
 	b.ReportAllocs()
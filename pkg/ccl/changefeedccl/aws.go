@@ -0,0 +1,105 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/cloud/amazon"
+	"github.com/cockroachdb/errors"
+)
+
+// awsClientConfig configures the clients of the sinks emitting to AWS
+// services. It is parsed from the query parameters of the sink URI, which are
+// named like the ones of the S3 URIs.
+type awsClientConfig struct {
+	region, endpoint             string
+	auth                         string
+	accessKey, secret, tempToken string
+	roleARN                      string
+	delegateRoleARNs             []string
+}
+
+func makeAWSClientConfig(u *sinkURL) (awsClientConfig, error) {
+	conf := awsClientConfig{
+		region:    u.consumeParam(amazon.S3RegionParam),
+		endpoint:  u.consumeParam(amazon.AWSEndpointParam),
+		auth:      u.consumeParam(cloud.AuthParam),
+		accessKey: u.consumeParam(amazon.AWSAccessKeyParam),
+		secret:    u.consumeParam(amazon.AWSSecretParam),
+		tempToken: u.consumeParam(amazon.AWSTempTokenParam),
+	}
+	conf.roleARN, conf.delegateRoleARNs = cloud.ParseRoleString(u.consumeParam(amazon.AssumeRoleParam))
+	// Same as for S3, the secrets may contain '+' characters which are decoded
+	// as spaces if they are not escaped.
+	conf.secret = strings.Replace(conf.secret, " ", "+", -1)
+
+	switch conf.auth {
+	case "", cloud.AuthParamSpecified:
+		if conf.accessKey == "" {
+			return conf, errors.Errorf("%s is set to '%s', but %s is not set",
+				cloud.AuthParam, cloud.AuthParamSpecified, amazon.AWSAccessKeyParam)
+		}
+		if conf.secret == "" {
+			return conf, errors.Errorf("%s is set to '%s', but %s is not set",
+				cloud.AuthParam, cloud.AuthParamSpecified, amazon.AWSSecretParam)
+		}
+		if conf.region == "" {
+			return conf, errors.Errorf("%s is not set", amazon.S3RegionParam)
+		}
+	case cloud.AuthParamImplicit:
+	default:
+		return conf, errors.Errorf("unsupported value %s for %s", conf.auth, cloud.AuthParam)
+	}
+	return conf, nil
+}
+
+// newAWSSession creates a session of the AWS APIs.
+func newAWSSession(conf awsClientConfig) (*session.Session, error) {
+	opts := session.Options{}
+	if conf.endpoint != "" {
+		opts.Config.Endpoint = aws.String(conf.endpoint)
+	}
+	if conf.region != "" {
+		opts.Config.Region = aws.String(conf.region)
+	}
+	opts.Config.CredentialsChainVerboseErrors = aws.Bool(true)
+
+	switch conf.auth {
+	case "", cloud.AuthParamSpecified:
+		opts.Config.Credentials = credentials.NewStaticCredentials(conf.accessKey, conf.secret, conf.tempToken)
+	case cloud.AuthParamImplicit:
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "new aws session")
+	}
+
+	if conf.roleARN != "" {
+		for _, role := range conf.delegateRoleARNs {
+			opts.Config.Credentials = stscreds.NewCredentials(sess, role)
+			sess, err = session.NewSessionWithOptions(opts)
+			if err != nil {
+				return nil, errors.Wrap(err, "session with intermediate credentials")
+			}
+		}
+		opts.Config.Credentials = stscreds.NewCredentials(sess, conf.roleARN)
+		sess, err = session.NewSessionWithOptions(opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "session with assume role credentials")
+		}
+	}
+	return sess, nil
+}
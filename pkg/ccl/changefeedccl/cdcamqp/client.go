@@ -0,0 +1,531 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+// Package cdcamqp implements the minimal client of AMQP 0.9.1 with which the
+// changefeeds publish to AMQP brokers, like RabbitMQ.
+package cdcamqp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/build"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// MaxShortStrLength is the maximum length of the short strings of AMQP
+	// 0.9.1, like the virtual hosts, exchanges and routing keys.
+	MaxShortStrLength = 255
+	// MinFrameMax is the minimum size of the frames of AMQP 0.9.1.
+	MinFrameMax = 4096
+	// DefaultHeartbeat is the default interval of the heartbeats proposed by
+	// the client.
+	DefaultHeartbeat = 10 * time.Second
+)
+
+const (
+	// handshakeTimeout bounds the time it takes to connect to an AMQP server.
+	handshakeTimeout = 10 * time.Second
+	// defaultFrameMax is the maximum size of the frames used by the client
+	// when the server does not limit it further.
+	defaultFrameMax = 128 << 10
+	// publishChannel is the channel on which the client publishes.
+	publishChannel = 1
+	// missedHeartbeats is the number of heartbeat intervals after which a
+	// server which sent nothing is considered unreachable.
+	missedHeartbeats = 3
+)
+
+// The flags of the content properties set by the client.
+const (
+	propertyContentType  = 0x8000
+	propertyDeliveryMode = 0x1000
+	// deliveryModePersistent makes the brokers store the messages on disk.
+	deliveryModePersistent = 2
+)
+
+// DialConfig configures the connection to an AMQP server.
+type DialConfig struct {
+	Addr           string
+	VHost          string
+	User, Password string
+	// TLSConfig is set when the connection uses TLS.
+	TLSConfig *tls.Config
+	// ContentType is the content type of the published messages.
+	ContentType string
+	// Heartbeat is the interval of the heartbeats proposed by the client. The
+	// connection uses the shortest of the intervals of the client and of the
+	// server which are set, rounded up to seconds; the heartbeats are disabled
+	// if neither is.
+	Heartbeat time.Duration
+}
+
+// ConfirmFunc is called with the result of a publication: nil once the server
+// confirmed the message, or the error which prevented it.
+type ConfirmFunc func(error)
+
+// Conn is a connection to an AMQP server, which publishes messages on a
+// channel in confirm mode. The messages are published without waiting for the
+// confirmations of the previous ones.
+//
+// The client and the server send heartbeats to each other, so that a server
+// which stops responding fails the connection even when nothing is published.
+type Conn struct {
+	conn      net.Conn
+	frameMax  uint32
+	heartbeat time.Duration
+	cfg       DialConfig
+	// done is closed when the connection is closed and all the pending
+	// confirmations were failed.
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu struct {
+		syncutil.Mutex
+		w *bufio.Writer
+		// nextTag is the delivery tag of the next published message.
+		nextTag uint64
+		pending map[uint64]ConfirmFunc
+		// returned is set when the server returned a message it could not
+		// route, and fails its confirmation.
+		returned error
+		// err is set once the connection failed, and fails the publications.
+		err error
+	}
+}
+
+// Dial connects to an AMQP server, and opens a channel in confirm mode.
+func Dial(ctx context.Context, cfg DialConfig) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to amqp server %s", cfg.Addr)
+	}
+	if cfg.TLSConfig != nil {
+		tlsConfig := cfg.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(cfg.Addr)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrapf(err, "connecting to amqp server %s", cfg.Addr)
+		}
+		conn = tlsConn
+	}
+
+	c := &Conn{conn: conn, cfg: cfg, done: make(chan struct{})}
+	r := bufio.NewReader(conn)
+	if err := c.handshake(ctx, r); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "connecting to amqp server %s", cfg.Addr)
+	}
+	c.wg.Add(1)
+	go c.readLoop(r)
+	if c.heartbeat > 0 {
+		c.wg.Add(1)
+		go c.heartbeatLoop()
+	}
+	return c, nil
+}
+
+// handshake opens the connection and the channel of the client.
+func (c *Conn) handshake(ctx context.Context, r *bufio.Reader) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(c.conn)
+	c.frameMax = defaultFrameMax
+
+	// expect reads the next method, which must be m.
+	expect := func(m method) (*decoder, error) {
+		f, err := readFrame(r, c.frameMax)
+		if err != nil {
+			return nil, err
+		}
+		got, d, err := parseMethod(f)
+		if err != nil {
+			return nil, err
+		}
+		switch got {
+		case m:
+			return d, nil
+		case connectionClose, channelClose:
+			return nil, closeError(got, d)
+		default:
+			return nil, errors.Errorf("expected amqp method %d.%d, got %d.%d",
+				m.class, m.method, got.class, got.method)
+		}
+	}
+	send := func(channel uint16, m method, args encoder) error {
+		if err := writeMethod(w, channel, m, args); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	if _, err := c.conn.Write(protocolHeader); err != nil {
+		return err
+	}
+	d, err := expect(connectionStart)
+	if err != nil {
+		return err
+	}
+	d.octet() // version-major
+	d.octet() // version-minor
+	d.longstr()
+	mechanisms := d.longstr()
+	if d.err != nil {
+		return d.err
+	}
+	if !hasMechanism(mechanisms, "PLAIN") {
+		return errors.Errorf("amqp server does not support the PLAIN mechanism, only %q", mechanisms)
+	}
+
+	var args encoder
+	args.table(map[string]string{
+		"product": "CockroachDB",
+		"version": build.BinaryVersion(),
+	})
+	args.shortstr("PLAIN")
+	args.longstr("\x00" + c.cfg.User + "\x00" + c.cfg.Password)
+	args.shortstr("en_US")
+	if err := send(0, connectionStartOk, args); err != nil {
+		return err
+	}
+
+	// The server closes the connection when the credentials are invalid.
+	if d, err = expect(connectionTune); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("amqp server closed the connection, check the credentials")
+		}
+		return err
+	}
+	channelMax, frameMax, heartbeat := d.short(), d.long(), d.short()
+	if d.err != nil {
+		return d.err
+	}
+	if frameMax != 0 && frameMax < c.frameMax {
+		if frameMax < MinFrameMax {
+			return errors.Errorf("invalid amqp frame-max %d", frameMax)
+		}
+		c.frameMax = frameMax
+	}
+	c.heartbeat = negotiateHeartbeat(c.cfg.Heartbeat, time.Duration(heartbeat)*time.Second)
+	args = nil
+	args.short(channelMax)
+	args.long(c.frameMax)
+	args.short(uint16(c.heartbeat / time.Second))
+	if err := send(0, connectionTuneOk, args); err != nil {
+		return err
+	}
+
+	args = nil
+	args.shortstr(c.cfg.VHost)
+	args.shortstr("")
+	args.octet(0)
+	if err := send(0, connectionOpen, args); err != nil {
+		return err
+	}
+	if _, err := expect(connectionOpenOk); err != nil {
+		return err
+	}
+
+	args = nil
+	args.shortstr("")
+	if err := send(publishChannel, channelOpen, args); err != nil {
+		return err
+	}
+	if _, err := expect(channelOpenOk); err != nil {
+		return err
+	}
+
+	args = nil
+	args.octet(0)
+	if err := send(publishChannel, confirmSelect, args); err != nil {
+		return err
+	}
+	if _, err := expect(confirmSelectOk); err != nil {
+		return err
+	}
+
+	if err := c.conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	c.mu.w = w
+	c.mu.nextTag = 1
+	c.mu.pending = make(map[uint64]ConfirmFunc)
+	return nil
+}
+
+// negotiateHeartbeat returns the heartbeat interval of a connection given the
+// intervals proposed by the client and by the server: the shortest of them
+// which is set, in whole seconds.
+func negotiateHeartbeat(client, server time.Duration) time.Duration {
+	h := client
+	if h <= 0 || (server > 0 && server < h) {
+		h = server
+	}
+	if h <= 0 {
+		return 0
+	}
+	if s := (h + time.Second - 1) / time.Second; s <= 1<<16-1 {
+		return s * time.Second
+	}
+	return (1<<16 - 1) * time.Second
+}
+
+// hasMechanism returns whether the space separated list of SASL mechanisms of
+// a server contains a mechanism.
+func hasMechanism(mechanisms, mechanism string) bool {
+	for _, m := range strings.Fields(mechanisms) {
+		if m == mechanism {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish publishes a persistent message to an exchange. The message is
+// buffered until the next call to Flush, and onConfirm is called once the
+// server confirmed it or the connection failed. The messages which cannot be
+// routed to a queue are returned by the server, and fail.
+func (c *Conn) Publish(exchange, routingKey string, body []byte, onConfirm ConfirmFunc) error {
+	if len(routingKey) > MaxShortStrLength {
+		return errors.Errorf("amqp routing key %q exceeds %d bytes", routingKey, MaxShortStrLength)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.err != nil {
+		return c.mu.err
+	}
+
+	var args encoder
+	args.short(0)
+	args.shortstr(exchange)
+	args.shortstr(routingKey)
+	// The mandatory bit makes the server return the unroutable messages.
+	args.octet(1)
+	if err := writeMethod(c.mu.w, publishChannel, basicPublish, args); err != nil {
+		return c.failLocked(err)
+	}
+
+	var header encoder
+	header.short(basicPublish.class)
+	header.short(0)
+	header.longlong(uint64(len(body)))
+	header.short(propertyContentType | propertyDeliveryMode)
+	header.shortstr(c.cfg.ContentType)
+	header.octet(deliveryModePersistent)
+	if err := writeFrame(c.mu.w, frameHeader, publishChannel, header); err != nil {
+		return c.failLocked(err)
+	}
+
+	// The frames have an overhead of 8 bytes.
+	maxBodyFrame := int(c.frameMax) - 8
+	for len(body) > 0 {
+		n := len(body)
+		if n > maxBodyFrame {
+			n = maxBodyFrame
+		}
+		if err := writeFrame(c.mu.w, frameBody, publishChannel, body[:n]); err != nil {
+			return c.failLocked(err)
+		}
+		body = body[n:]
+	}
+
+	c.mu.pending[c.mu.nextTag] = onConfirm
+	c.mu.nextTag++
+	return nil
+}
+
+// Flush sends the buffered messages to the server.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.err != nil {
+		return c.mu.err
+	}
+	if err := c.mu.w.Flush(); err != nil {
+		return c.failLocked(err)
+	}
+	return nil
+}
+
+// Failed returns the error of the connection if it failed.
+func (c *Conn) Failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.err
+}
+
+// failLocked records the failure of the connection and closes it, which makes
+// readLoop fail the pending confirmations.
+func (c *Conn) failLocked(err error) error {
+	if c.mu.err == nil {
+		c.mu.err = errors.Wrap(err, "amqp connection failed")
+		_ = c.conn.Close()
+	}
+	return c.mu.err
+}
+
+// heartbeatLoop sends heartbeats to the server twice per heartbeat interval,
+// until the connection fails.
+func (c *Conn) heartbeatLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.heartbeat / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.mu.err == nil {
+				if err := writeFrame(c.mu.w, frameHeartbeat, 0, nil); err != nil {
+					_ = c.failLocked(err)
+				} else if err := c.mu.w.Flush(); err != nil {
+					_ = c.failLocked(err)
+				}
+			}
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readLoop reads the frames of the server until the connection fails.
+func (c *Conn) readLoop(r *bufio.Reader) {
+	defer c.wg.Done()
+	defer close(c.done)
+	err := c.read(r)
+
+	c.mu.Lock()
+	err = c.failLocked(err)
+	pending := c.mu.pending
+	c.mu.pending = nil
+	c.mu.Unlock()
+	for _, onConfirm := range pending {
+		onConfirm(err)
+	}
+}
+
+func (c *Conn) read(r *bufio.Reader) error {
+	for {
+		// The server sends heartbeats when it has nothing else to send, so
+		// that the lack of frames means that the server is unreachable.
+		if c.heartbeat > 0 {
+			if err := c.conn.SetReadDeadline(timeutil.Now().Add(missedHeartbeats * c.heartbeat)); err != nil {
+				return err
+			}
+		}
+		f, err := readFrame(r, c.frameMax)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return errors.Errorf("amqp server sent no heartbeat for %s", missedHeartbeats*c.heartbeat)
+			}
+			return err
+		}
+		// The content of the returned messages follows their basic.return, and
+		// is ignored.
+		if f.typ == frameHeartbeat || f.typ == frameHeader || f.typ == frameBody {
+			continue
+		}
+		m, d, err := parseMethod(f)
+		if err != nil {
+			return err
+		}
+		switch m {
+		case basicAck, basicNack:
+			tag := d.longlong()
+			multiple := d.octet()&1 != 0
+			if d.err != nil {
+				return d.err
+			}
+			var err error
+			if m == basicNack {
+				err = errors.New("amqp server failed to store message")
+			}
+			c.confirm(tag, multiple, err)
+		case basicReturn:
+			code, text, exchange, routingKey := d.short(), d.shortstr(), d.shortstr(), d.shortstr()
+			if d.err != nil {
+				return d.err
+			}
+			c.mu.Lock()
+			c.mu.returned = errors.Errorf("amqp server returned message published to exchange %q with routing key %q: %d %s",
+				exchange, routingKey, code, text)
+			c.mu.Unlock()
+		case connectionClose, channelClose:
+			closeErr := closeError(m, d)
+			c.mu.Lock()
+			ok := connectionCloseOk
+			if m == channelClose {
+				ok = channelCloseOk
+			}
+			if writeMethod(c.mu.w, f.channel, ok, nil) == nil {
+				_ = c.mu.w.Flush()
+			}
+			c.mu.Unlock()
+			return closeErr
+		default:
+			return errors.Errorf("unexpected amqp method %d.%d", m.class, m.method)
+		}
+	}
+}
+
+// confirm handles the confirmation of the messages up to a delivery tag, or
+// of a single message.
+func (c *Conn) confirm(tag uint64, multiple bool, err error) {
+	var confirmed []uint64
+	c.mu.Lock()
+	for t := range c.mu.pending {
+		if t == tag || (multiple && t < tag) {
+			confirmed = append(confirmed, t)
+		}
+	}
+	sort.Slice(confirmed, func(i, j int) bool { return confirmed[i] < confirmed[j] })
+	callbacks := make([]ConfirmFunc, len(confirmed))
+	for i, t := range confirmed {
+		callbacks[i] = c.mu.pending[t]
+		delete(c.mu.pending, t)
+	}
+	// The server returns the unroutable messages before it confirms them.
+	if err == nil && c.mu.returned != nil {
+		err = c.mu.returned
+		c.mu.returned = nil
+	}
+	c.mu.Unlock()
+	for _, onConfirm := range callbacks {
+		onConfirm(err)
+	}
+}
+
+// Close closes the connection, and fails the pending confirmations.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	_ = c.failLocked(errors.New("connection closed"))
+	c.mu.Unlock()
+	c.wg.Wait()
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcamqp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, tc := range []struct {
+		client, server, expected time.Duration
+	}{
+		{client: 0, server: 0, expected: 0},
+		{client: 10 * time.Second, server: 0, expected: 10 * time.Second},
+		{client: 0, server: 60 * time.Second, expected: 60 * time.Second},
+		{client: 10 * time.Second, server: 60 * time.Second, expected: 10 * time.Second},
+		{client: 10 * time.Second, server: 5 * time.Second, expected: 5 * time.Second},
+		{client: 1500 * time.Millisecond, server: 0, expected: 2 * time.Second},
+		{client: 100 * time.Hour, server: 0, expected: (1<<16 - 1) * time.Second},
+	} {
+		t.Run(fmt.Sprintf("client=%s,server=%s", tc.client, tc.server), func(t *testing.T) {
+			require.Equal(t, tc.expected, negotiateHeartbeat(tc.client, tc.server))
+		})
+	}
+}
+
+func TestConn(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	broker, err := StartMockBroker("user", "pass")
+	require.NoError(t, err)
+	defer broker.Close()
+
+	dial := func(t *testing.T, cfg DialConfig) *Conn {
+		cfg.Addr = broker.Addr()
+		if cfg.User == "" {
+			cfg.User, cfg.Password = "user", "pass"
+		}
+		c, err := Dial(ctx, cfg)
+		require.NoError(t, err)
+		return c
+	}
+	// publish publishes the messages, and returns their confirmations.
+	publish := func(t *testing.T, c *Conn, routingKey string, bodies ...string) []chan error {
+		confirms := make([]chan error, len(bodies))
+		for i, body := range bodies {
+			confirm := make(chan error, 1)
+			confirms[i] = confirm
+			require.NoError(t, c.Publish("cdc", routingKey, []byte(body), func(err error) { confirm <- err }))
+		}
+		require.NoError(t, c.Flush())
+		return confirms
+	}
+	var seen int
+	newMessages := func() []string {
+		messages := broker.Messages()
+		defer func() { seen = len(messages) }()
+		return messages[seen:]
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		c := dial(t, DialConfig{VHost: "prod", ContentType: "application/json"})
+		defer c.Close()
+
+		// The large messages are split into several frames.
+		large := strings.Repeat("x", 3*MinFrameMax)
+		for _, confirm := range publish(t, c, "rows", "a", "b", large) {
+			require.NoError(t, <-confirm)
+		}
+		require.Equal(t, []string{"prod/cdc/rows:a", "prod/cdc/rows:b", "prod/cdc/rows:" + large}, newMessages())
+		require.NoError(t, c.Failed())
+	})
+
+	t.Run("routing key too long", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		err := c.Publish("cdc", strings.Repeat("k", MaxShortStrLength+1), nil, func(error) {})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `exceeds 255 bytes`)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		_, err := Dial(ctx, DialConfig{Addr: broker.Addr(), User: "user", Password: "wrong"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `amqp server closed the connection: 403 ACCESS_REFUSED`)
+	})
+
+	t.Run("nack", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		confirms := publish(t, c, "rows", "a", "nack-b", "c")
+		require.NoError(t, <-confirms[0])
+		require.EqualError(t, <-confirms[1], `amqp server failed to store message`)
+		require.NoError(t, <-confirms[2])
+		require.Equal(t, []string{"/cdc/rows:a", "/cdc/rows:c"}, newMessages())
+	})
+
+	t.Run("unroutable", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		confirms := publish(t, c, "unroutable", "a")
+		require.EqualError(t, <-confirms[0],
+			`amqp server returned message published to exchange "cdc" with routing key "unroutable": 312 NO_ROUTE`)
+	})
+
+	t.Run("close fails pending confirmations", func(t *testing.T) {
+		broker.SetHoldAcks(true)
+		defer broker.SetHoldAcks(false)
+		c := dial(t, DialConfig{})
+		confirms := publish(t, c, "rows", "a")
+		c.Close()
+		err := <-confirms[0]
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `connection closed`)
+		require.Error(t, c.Publish("cdc", "rows", nil, func(error) {}))
+	})
+
+	t.Run("heartbeats", func(t *testing.T) {
+		broker.SetHeartbeat(time.Second)
+		defer broker.SetHeartbeat(0)
+		c := dial(t, DialConfig{Heartbeat: time.Minute})
+		defer c.Close()
+
+		// The connection uses the shortest of the heartbeat intervals, and
+		// remains open while nothing is published.
+		require.Equal(t, time.Second, c.heartbeat)
+		testutils.SucceedsSoon(t, func() error {
+			if broker.Heartbeats() < 2 {
+				return errors.New("waiting for heartbeats")
+			}
+			return nil
+		})
+		require.NoError(t, c.Failed())
+
+		// The connection fails once the broker stops sending heartbeats.
+		broker.SetStalled(true)
+		defer broker.SetStalled(false)
+		testutils.SucceedsSoon(t, func() error {
+			if c.Failed() == nil {
+				return errors.New("waiting for the connection to fail")
+			}
+			return nil
+		})
+		require.Contains(t, c.Failed().Error(), `amqp server sent no heartbeat for 3s`)
+	})
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcamqp
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// protocolHeader starts the connections of AMQP 0.9.1 clients.
+var protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+// The types of the frames of AMQP 0.9.1, and the octet ending them.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xce
+)
+
+// method identifies a method of AMQP 0.9.1 by its class and method ids.
+type method struct {
+	class, method uint16
+}
+
+var (
+	connectionStart   = method{10, 10}
+	connectionStartOk = method{10, 11}
+	connectionTune    = method{10, 30}
+	connectionTuneOk  = method{10, 31}
+	connectionOpen    = method{10, 40}
+	connectionOpenOk  = method{10, 41}
+	connectionClose   = method{10, 50}
+	connectionCloseOk = method{10, 51}
+	channelOpen       = method{20, 10}
+	channelOpenOk     = method{20, 11}
+	channelClose      = method{20, 40}
+	channelCloseOk    = method{20, 41}
+	basicPublish      = method{60, 40}
+	basicReturn       = method{60, 50}
+	basicAck          = method{60, 80}
+	basicNack         = method{60, 120}
+	confirmSelect     = method{85, 10}
+	confirmSelectOk   = method{85, 11}
+)
+
+// encoder encodes the fields of AMQP 0.9.1 frames.
+type encoder []byte
+
+func (e *encoder) octet(v uint8) { *e = append(*e, v) }
+
+func (e *encoder) short(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	*e = append(*e, b[:]...)
+}
+
+func (e *encoder) long(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	*e = append(*e, b[:]...)
+}
+
+func (e *encoder) longlong(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	*e = append(*e, b[:]...)
+}
+
+// shortstr encodes a short string, which must not be longer than
+// MaxShortStrLength.
+func (e *encoder) shortstr(s string) {
+	e.octet(uint8(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *encoder) longstr(s string) {
+	e.long(uint32(len(s)))
+	*e = append(*e, s...)
+}
+
+// table encodes a field table whose values are long strings.
+func (e *encoder) table(fields map[string]string) {
+	var t encoder
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.shortstr(name)
+		t.octet('S')
+		t.longstr(fields[name])
+	}
+	e.longstr(string(t))
+}
+
+// decoder decodes the fields of AMQP 0.9.1 frames. Decoding past the end of
+// the frame sets err.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errors.New("truncated amqp frame")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) octet() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) short() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) long() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) longlong() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+// longstr decodes a long string, or skips a field table.
+func (d *decoder) longstr() string {
+	return string(d.next(int(d.long())))
+}
+
+// frame is a frame of AMQP 0.9.1.
+type frame struct {
+	typ     uint8
+	channel uint16
+	payload []byte
+}
+
+// readFrame reads a frame, which must not be larger than frameMax.
+func readFrame(r io.Reader, frameMax uint32) (frame, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		typ:     header[0],
+		channel: binary.BigEndian.Uint16(header[1:3]),
+	}
+	size := binary.BigEndian.Uint32(header[3:7])
+	if size > frameMax {
+		return frame{}, errors.Errorf("amqp frame of %d bytes exceeds the maximum of %d bytes", size, frameMax)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, err
+	}
+	if payload[size] != frameEnd {
+		return frame{}, errors.New("invalid amqp frame end")
+	}
+	f.payload = payload[:size]
+	return f, nil
+}
+
+// writeFrame writes a frame.
+func writeFrame(w io.Writer, typ uint8, channel uint16, payload []byte) error {
+	var e encoder
+	e.octet(typ)
+	e.short(channel)
+	e.long(uint32(len(payload)))
+	e = append(e, payload...)
+	e.octet(frameEnd)
+	_, err := w.Write(e)
+	return err
+}
+
+// writeMethod writes a method frame.
+func writeMethod(w io.Writer, channel uint16, m method, args encoder) error {
+	var e encoder
+	e.short(m.class)
+	e.short(m.method)
+	e = append(e, args...)
+	return writeFrame(w, frameMethod, channel, e)
+}
+
+// parseMethod parses the payload of a method frame into its method and the
+// decoder of its arguments.
+func parseMethod(f frame) (method, *decoder, error) {
+	if f.typ != frameMethod {
+		return method{}, nil, errors.Errorf("expected amqp method frame, got frame of type %d", f.typ)
+	}
+	d := &decoder{buf: f.payload}
+	m := method{class: d.short(), method: d.short()}
+	return m, d, d.err
+}
+
+// closeError returns the error reported by a connection.close or a
+// channel.close method.
+func closeError(m method, d *decoder) error {
+	code, text := d.short(), d.shortstr()
+	what := "connection"
+	if m == channelClose {
+		what = "channel"
+	}
+	return errors.Errorf("amqp server closed the %s: %d %s", what, code, text)
+}
@@ -0,0 +1,361 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcamqp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// MockBroker is the AMQP 0.9.1 broker used in tests. It confirms the
+// published messages, except the ones whose routing key starts with
+// "unroutable", which it returns, and the ones whose body starts with "nack",
+// which it rejects the first time they are published.
+type MockBroker struct {
+	ln       net.Listener
+	user     string
+	password string
+	wg       sync.WaitGroup
+
+	mu struct {
+		syncutil.Mutex
+		conns []net.Conn
+		// messages are the messages stored by the broker, as
+		// vhost/exchange/routingKey:body.
+		messages []string
+		// nacked are the bodies of the messages which were rejected.
+		nacked map[string]bool
+		// holdAcks makes the broker not confirm the messages.
+		holdAcks bool
+		// heartbeat is the heartbeat interval proposed to the new connections.
+		heartbeat time.Duration
+		// heartbeats is the number of heartbeats received from the clients.
+		heartbeats int
+		// stalled makes the broker send nothing to the clients.
+		stalled bool
+	}
+}
+
+// StartMockBroker starts a mock broker, which accepts the connections of the
+// clients authenticating with the specified credentials.
+func StartMockBroker(user, password string) (*MockBroker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &MockBroker{ln: ln, user: user, password: password}
+	b.mu.nacked = make(map[string]bool)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.mu.conns = append(b.mu.conns, conn)
+			b.mu.Unlock()
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				defer conn.Close()
+				if err := b.serve(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					log.Infof(context.Background(), "mock amqp broker: %v", err)
+				}
+			}()
+		}
+	}()
+	return b, nil
+}
+
+// Addr returns the address of the broker.
+func (b *MockBroker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+// brokerConn is a connection of a client to the mock broker.
+type brokerConn struct {
+	mu struct {
+		syncutil.Mutex
+		w *bufio.Writer
+	}
+}
+
+// send writes a method frame.
+func (c *brokerConn) send(m method, args encoder) error {
+	// The methods of the connection class use the channel 0.
+	channel := uint16(publishChannel)
+	if m.class == connectionStart.class {
+		channel = 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeMethod(c.mu.w, channel, m, args); err != nil {
+		return err
+	}
+	return c.mu.w.Flush()
+}
+
+func (b *MockBroker) serve(conn net.Conn) error {
+	const frameMax = MinFrameMax
+	r := bufio.NewReader(conn)
+	c := &brokerConn{}
+	c.mu.w = bufio.NewWriter(conn)
+	// next reads the next frame which is not a heartbeat.
+	next := func() (frame, error) {
+		for {
+			f, err := readFrame(r, frameMax)
+			if err != nil || f.typ != frameHeartbeat {
+				return f, err
+			}
+			b.mu.Lock()
+			b.mu.heartbeats++
+			b.mu.Unlock()
+		}
+	}
+	expect := func(m method) (*decoder, error) {
+		f, err := next()
+		if err != nil {
+			return nil, err
+		}
+		got, d, err := parseMethod(f)
+		if err != nil {
+			return nil, err
+		}
+		if got != m {
+			return nil, errors.Newf("expected method %v, got %v", m, got)
+		}
+		return d, nil
+	}
+
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	var args encoder
+	args.octet(0)
+	args.octet(9)
+	args.table(map[string]string{"product": "mock"})
+	args.longstr("AMQPLAIN PLAIN")
+	args.longstr("en_US")
+	if err := c.send(connectionStart, args); err != nil {
+		return err
+	}
+	d, err := expect(connectionStartOk)
+	if err != nil {
+		return err
+	}
+	d.longstr()
+	d.shortstr()
+	response := d.longstr()
+	if response != "\x00"+b.user+"\x00"+b.password {
+		args = nil
+		args.short(403)
+		args.shortstr("ACCESS_REFUSED - Login was refused")
+		args.short(0)
+		args.short(0)
+		return c.send(connectionClose, args)
+	}
+
+	b.mu.Lock()
+	heartbeat := b.mu.heartbeat
+	b.mu.Unlock()
+	args = nil
+	args.short(0)
+	args.long(frameMax)
+	args.short(uint16(heartbeat / time.Second))
+	if err := c.send(connectionTune, args); err != nil {
+		return err
+	}
+	if d, err = expect(connectionTuneOk); err != nil {
+		return err
+	}
+	d.short()
+	d.long()
+	if heartbeat = time.Duration(d.short()) * time.Second; heartbeat > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.sendHeartbeats(c, heartbeat, done)
+		}()
+	}
+
+	if d, err = expect(connectionOpen); err != nil {
+		return err
+	}
+	vhost := d.shortstr()
+	args = nil
+	args.shortstr("")
+	if err := c.send(connectionOpenOk, args); err != nil {
+		return err
+	}
+	if _, err := expect(channelOpen); err != nil {
+		return err
+	}
+	args = nil
+	args.longstr("")
+	if err := c.send(channelOpenOk, args); err != nil {
+		return err
+	}
+	if _, err := expect(confirmSelect); err != nil {
+		return err
+	}
+	if err := c.send(confirmSelectOk, nil); err != nil {
+		return err
+	}
+
+	for tag := uint64(1); ; tag++ {
+		d, err := expect(basicPublish)
+		if err != nil {
+			return err
+		}
+		d.short()
+		exchange, routingKey := d.shortstr(), d.shortstr()
+		f, err := next()
+		if err != nil {
+			return err
+		}
+		d = &decoder{buf: f.payload}
+		d.short()
+		d.short()
+		size := d.longlong()
+		var body []byte
+		for uint64(len(body)) < size {
+			f, err := next()
+			if err != nil {
+				return err
+			}
+			body = append(body, f.payload...)
+		}
+		if err := b.reply(c, tag, vhost, exchange, routingKey, string(body)); err != nil {
+			return err
+		}
+	}
+}
+
+// sendHeartbeats sends heartbeats to a client twice per heartbeat interval,
+// unless the broker is stalled, until done is closed.
+func (b *MockBroker) sendHeartbeats(c *brokerConn, heartbeat time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(heartbeat / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			stalled := b.mu.stalled
+			b.mu.Unlock()
+			if stalled {
+				continue
+			}
+			c.mu.Lock()
+			err := writeFrame(c.mu.w, frameHeartbeat, 0, nil)
+			if err == nil {
+				err = c.mu.w.Flush()
+			}
+			c.mu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// reply confirms, rejects or returns a published message like a broker.
+func (b *MockBroker) reply(c *brokerConn, tag uint64, vhost, exchange, routingKey, body string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mu.stalled {
+		return nil
+	}
+	var ack encoder
+	ack.longlong(tag)
+	ack.octet(0)
+	switch {
+	case strings.HasPrefix(routingKey, "unroutable"):
+		var args encoder
+		args.short(312)
+		args.shortstr("NO_ROUTE")
+		args.shortstr(exchange)
+		args.shortstr(routingKey)
+		if err := c.send(basicReturn, args); err != nil {
+			return err
+		}
+	case strings.HasPrefix(body, "nack") && !b.mu.nacked[body]:
+		b.mu.nacked[body] = true
+		return c.send(basicNack, ack)
+	case b.mu.holdAcks:
+		return nil
+	default:
+		b.mu.messages = append(b.mu.messages, vhost+"/"+exchange+"/"+routingKey+":"+body)
+	}
+	return c.send(basicAck, ack)
+}
+
+// Messages returns the messages stored by the broker, as
+// vhost/exchange/routingKey:body.
+func (b *MockBroker) Messages() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.mu.messages...)
+}
+
+// SetHoldAcks makes the broker not confirm the messages which it would store.
+func (b *MockBroker) SetHoldAcks(hold bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mu.holdAcks = hold
+}
+
+// SetHeartbeat sets the heartbeat interval proposed to the new connections,
+// which is rounded down to seconds.
+func (b *MockBroker) SetHeartbeat(heartbeat time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mu.heartbeat = heartbeat
+}
+
+// SetStalled makes the broker send nothing to the clients, neither heartbeats
+// nor confirmations, as if it were unreachable.
+func (b *MockBroker) SetStalled(stalled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mu.stalled = stalled
+}
+
+// Heartbeats returns the number of heartbeats received from the clients.
+func (b *MockBroker) Heartbeats() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.mu.heartbeats
+}
+
+// Close stops the broker, and closes the connections of the clients.
+func (b *MockBroker) Close() {
+	_ = b.ln.Close()
+	b.mu.Lock()
+	for _, conn := range b.mu.conns {
+		_ = conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
@@ -93,6 +93,24 @@ func constrainSpansBySelectClause(
 		return []roachpb.Span{ed.TableDescriptor().PrimaryIndexSpan(codec)}, nil, nil
 	}
 
+	if _, joins := splitLookupJoins(selectClause.From.Tables[0]); len(joins) > 0 {
+		// The filter may reference the columns of the joined reference tables,
+		// which are not known to the optimizer; don't constrain.
+		return []roachpb.Span{ed.TableDescriptor().PrimaryIndexSpan(codec)}, nil, nil
+	}
+
+	if invokesUserDefinedFunctions(selectClause.Where.Expr) {
+		// User defined functions are invoked via the internal executor, which
+		// the optimizer doesn't know about; don't constrain.
+		return []roachpb.Span{ed.TableDescriptor().PrimaryIndexSpan(codec)}, nil, nil
+	}
+
+	if referencesPrevColumns(selectClause.Where.Expr, sourceNames(selectClause)) {
+		// The columns of the previous row are not known to the optimizer; don't
+		// constrain.
+		return []roachpb.Span{ed.TableDescriptor().PrimaryIndexSpan(codec)}, nil, nil
+	}
+
 	tableName := tableNameOrAlias(ed.TableName, selectClause.From.Tables[0])
 	semaCtx := newSemaCtxWithTypeResolver(ed)
 	return sc.ConstrainPrimaryIndexSpanByExpr(
@@ -53,5 +53,22 @@ Certain stable functions (s.a. now(), current_timestamp(), etc) are allowed -- t
 return the MVCC timestamp of the event.
 We also provide custom, CDC specific functions, such as cdc_prev() which returns prevoius row as
 a JSONB record.  See functions.go for more details.
+event_op() returns the operation of the event ('insert', 'update' or 'delete').  When the filter
+compares event_op() with literal operations, s.a. "event_op() IN ('insert', 'update')", the events
+of the other operations are skipped before they are decoded.  See event_op.go.
+Immutable user defined functions can be used as well: their names are fully qualified when
+the changefeed is created, and they are invoked via the internal executor.  See udf.go.
+
+The target table can be joined with reference tables, s.a.
+"SELECT o.*, c.name FROM orders AS o JOIN customers AS c ON c.id = o.cid".
+The join condition must compare each primary key column of the reference table; the
+reference row joined with each event is looked up, as of the MVCC timestamp of the event,
+via the internal executor.  The columns of the reference tables are bound to the IndexedVars
+following the columns of the event.  See lookup.go for more details.
+
+The columns of the previous row can also be referenced individually, s.a. "cdc_prev.status",
+without materializing the whole previous row as cdc_prev() does.  They are bound to the IndexedVars
+following the columns of the reference tables, and only the referenced columns of the previous row
+are decoded.  See prev.go for more details.
 
 ***/
@@ -0,0 +1,219 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree/treecmp"
+)
+
+// EventOp is a set of the operations of changefeed events, s.a. inserts or
+// deletes.
+type EventOp int
+
+const (
+	// EventOpInsert is the operation of events inserting rows.
+	EventOpInsert EventOp = 1 << iota
+	// EventOpUpdate is the operation of events updating existing rows.
+	EventOpUpdate
+	// EventOpUpsert is the operation of events writing rows whose previous
+	// values are not known, and which may thus be inserts or updates.
+	EventOpUpsert
+	// EventOpDelete is the operation of events deleting rows.
+	EventOpDelete
+
+	allEventOps = EventOpInsert | EventOpUpdate | EventOpUpsert | EventOpDelete
+)
+
+// eventOpNames are the names of the operations returned by event_op().
+var eventOpNames = map[EventOp]string{
+	EventOpInsert: "insert",
+	EventOpUpdate: "update",
+	EventOpUpsert: "upsert",
+	EventOpDelete: "delete",
+}
+
+// String implements fmt.Stringer.
+func (op EventOp) String() string {
+	var names []string
+	for _, o := range []EventOp{EventOpInsert, EventOpUpdate, EventOpUpsert, EventOpDelete} {
+		if op&o != 0 {
+			names = append(names, eventOpNames[o])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// EventOpOfKV returns the operation of the event writing the value of the
+// key, whose previous value is prevValue if withDiff is set. Unlike
+// eventOpOfRow, this doesn't require decoding the values.
+func EventOpOfKV(value, prevValue roachpb.Value, withDiff bool) EventOp {
+	switch {
+	case !value.IsPresent():
+		return EventOpDelete
+	case !withDiff:
+		return EventOpUpsert
+	case prevValue.IsPresent():
+		return EventOpUpdate
+	default:
+		return EventOpInsert
+	}
+}
+
+// eventOpOfRow returns the operation of the event updating the row, whose
+// previous value is prevRow if it is initialized.
+func eventOpOfRow(updatedRow, prevRow cdcevent.Row) EventOp {
+	switch {
+	case updatedRow.IsDeleted():
+		return EventOpDelete
+	case !prevRow.IsInitialized():
+		return EventOpUpsert
+	case prevRow.HasValues() && !prevRow.IsDeleted():
+		return EventOpUpdate
+	default:
+		return EventOpInsert
+	}
+}
+
+// eventOpPredicate describes the operations of the events matching a
+// predicate: if exact is set, events match the predicate if and only if their
+// operation is one of ops; otherwise, events may only match the predicate if
+// their operation is one of ops.
+type eventOpPredicate struct {
+	ops   EventOp
+	exact bool
+}
+
+// eventOpsOf returns the operations of the events which may match the filter,
+// so that the events whose operation is not one of those can be skipped
+// without being decoded.
+func eventOpsOf(filter tree.Expr) (EventOp, error) {
+	if filter == nil {
+		return allEventOps, nil
+	}
+	p, err := eventOpPredicateOf(filter)
+	return p.ops, err
+}
+
+// eventOpPredicateOf returns the operations of the events matching the
+// predicate. Only comparisons of event_op() with string literals, and their
+// logical combinations, are analyzed.
+func eventOpPredicateOf(expr tree.Expr) (eventOpPredicate, error) {
+	switch t := expr.(type) {
+	case *tree.ParenExpr:
+		return eventOpPredicateOf(t.Expr)
+
+	case *tree.AndExpr:
+		left, right, err := eventOpPredicatesOf(t.Left, t.Right)
+		return eventOpPredicate{ops: left.ops & right.ops, exact: left.exact && right.exact}, err
+
+	case *tree.OrExpr:
+		left, right, err := eventOpPredicatesOf(t.Left, t.Right)
+		return eventOpPredicate{ops: left.ops | right.ops, exact: left.exact && right.exact}, err
+
+	case *tree.NotExpr:
+		p, err := eventOpPredicateOf(t.Expr)
+		if err != nil || !p.exact {
+			return eventOpPredicate{ops: allEventOps}, err
+		}
+		return eventOpPredicate{ops: allEventOps &^ p.ops, exact: true}, nil
+
+	case *tree.ComparisonExpr:
+		var operand tree.Expr
+		switch {
+		case isEventOpCall(t.Left):
+			operand = t.Right
+		case isEventOpCall(t.Right) && (t.Operator.Symbol == treecmp.EQ || t.Operator.Symbol == treecmp.NE):
+			operand = t.Left
+		default:
+			return eventOpPredicate{ops: allEventOps}, nil
+		}
+		var ops EventOp
+		var err error
+		switch t.Operator.Symbol {
+		case treecmp.EQ, treecmp.NE:
+			ops, err = eventOpOfLiteral(operand)
+		case treecmp.In, treecmp.NotIn:
+			tuple, ok := operand.(*tree.Tuple)
+			if !ok {
+				return eventOpPredicate{ops: allEventOps}, nil
+			}
+			for _, e := range tuple.Exprs {
+				op, err := eventOpOfLiteral(e)
+				if err != nil || op == 0 {
+					return eventOpPredicate{ops: allEventOps}, err
+				}
+				ops |= op
+			}
+		default:
+			return eventOpPredicate{ops: allEventOps}, nil
+		}
+		if err != nil || ops == 0 {
+			return eventOpPredicate{ops: allEventOps}, err
+		}
+		if t.Operator.Symbol == treecmp.NE || t.Operator.Symbol == treecmp.NotIn {
+			ops = allEventOps &^ ops
+		}
+		return eventOpPredicate{ops: ops, exact: true}, nil
+	}
+	return eventOpPredicate{ops: allEventOps}, nil
+}
+
+// eventOpPredicatesOf returns the predicates of both operands of a binary
+// logical expression.
+func eventOpPredicatesOf(left, right tree.Expr) (l, r eventOpPredicate, _ error) {
+	l, err := eventOpPredicateOf(left)
+	if err != nil {
+		return l, r, err
+	}
+	r, err = eventOpPredicateOf(right)
+	return l, r, err
+}
+
+// isEventOpCall returns true if the expression invokes event_op().
+func isEventOpCall(expr tree.Expr) bool {
+	fn, ok := expr.(*tree.FuncExpr)
+	if !ok || len(fn.Exprs) != 0 {
+		return false
+	}
+	switch t := fn.Func.FunctionReference.(type) {
+	case *tree.UnresolvedName:
+		return t.NumParts == 1 && strings.ToLower(t.Parts[0]) == eventOpFnName
+	case *tree.ResolvedFunctionDefinition:
+		return t.Name == eventOpFnName
+	}
+	return false
+}
+
+// eventOpOfLiteral returns the operation named by the string literal, or 0
+// if the expression is not a string literal.
+func eventOpOfLiteral(expr tree.Expr) (EventOp, error) {
+	var name string
+	switch t := expr.(type) {
+	case *tree.StrVal:
+		name = t.RawString()
+	case *tree.DString:
+		name = string(*t)
+	default:
+		return 0, nil
+	}
+	for op, opName := range eventOpNames {
+		if opName == name {
+			return op, nil
+		}
+	}
+	return 0, pgerror.Newf(pgcode.InvalidParameterValue,
+		"unknown event operation %q: expected one of %s", name, allEventOps)
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventOpsOf(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		filter    string
+		expect    EventOp
+		expectErr string
+	}{
+		{filter: "", expect: allEventOps},
+		{filter: "a > 1", expect: allEventOps},
+		{filter: "event_op() = 'insert'", expect: EventOpInsert},
+		{filter: "'delete' = EVENT_OP()", expect: EventOpDelete},
+		{filter: "event_op() != 'delete'", expect: EventOpInsert | EventOpUpdate | EventOpUpsert},
+		{filter: "event_op() IN ('insert', 'update')", expect: EventOpInsert | EventOpUpdate},
+		{filter: "event_op() NOT IN ('insert', 'update')", expect: EventOpUpsert | EventOpDelete},
+		{filter: "a > 1 AND (event_op() = 'update')", expect: EventOpUpdate},
+		{filter: "event_op() = 'insert' OR event_op() = 'update'", expect: EventOpInsert | EventOpUpdate},
+		{filter: "event_op() = 'insert' OR a > 1", expect: allEventOps},
+		{filter: "NOT (event_op() = 'delete')", expect: EventOpInsert | EventOpUpdate | EventOpUpsert},
+		{filter: "NOT (event_op() = 'delete' AND a > 1)", expect: allEventOps},
+		{filter: "event_op() = 'insert' AND event_op() = 'delete'", expect: 0},
+		{filter: "event_op() > 'delete'", expect: allEventOps},
+		{filter: "event_op() = b", expect: allEventOps},
+		{filter: "event_op() = 'updated'", expectErr: `unknown event operation "updated": expected one of insert, update, upsert, delete`},
+		{filter: "event_op() IN ('insert', 'deleted')", expectErr: `unknown event operation "deleted"`},
+	} {
+		t.Run(tc.filter, func(t *testing.T) {
+			var filter tree.Expr
+			if tc.filter != "" {
+				var err error
+				filter, err = parser.ParseExpr(tc.filter)
+				require.NoError(t, err)
+			}
+			ops, err := eventOpsOf(filter)
+			if tc.expectErr != "" {
+				require.Regexp(t, tc.expectErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, ops, "expected %s, found %s", tc.expect, ops)
+		})
+	}
+}
+
+func TestEventOpOfKV(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	value := roachpb.MakeValueFromString("value")
+	var tombstone roachpb.Value
+	for _, tc := range []struct {
+		value, prevValue roachpb.Value
+		withDiff         bool
+		expect           EventOp
+	}{
+		{value: value, prevValue: tombstone, withDiff: false, expect: EventOpUpsert},
+		{value: value, prevValue: tombstone, withDiff: true, expect: EventOpInsert},
+		{value: value, prevValue: value, withDiff: true, expect: EventOpUpdate},
+		{value: tombstone, prevValue: value, withDiff: true, expect: EventOpDelete},
+		{value: tombstone, prevValue: tombstone, withDiff: false, expect: EventOpDelete},
+	} {
+		require.Equal(t, tc.expect, EventOpOfKV(tc.value, tc.prevValue, tc.withDiff))
+	}
+}
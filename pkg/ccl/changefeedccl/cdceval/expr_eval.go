@@ -22,6 +22,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
@@ -34,17 +35,29 @@ type Evaluator struct {
 	selectors []tree.SelectExpr
 	from      tree.TableExpr
 	where     tree.Expr
+	joins     []lookupJoin
+	// ops are the operations of the events which may match the filter.
+	ops EventOp
 
 	evalCtx *eval.Context
+	// ie executes the queries looking up the rows of the reference tables of
+	// the joins.
+	ie sqlutil.InternalExecutor
+	// udfs resolves the user-defined functions, which are invoked via ie.
+	udfs *udfResolver
 	// Current evaluator.  Re-initialized whenever event descriptor
 	// version changes.
 	evaluator *exprEval
 }
 
 // NewEvaluator returns evaluator configured to process specified
-// select expression.
-func NewEvaluator(evalCtx *eval.Context, sc *tree.SelectClause) (*Evaluator, error) {
-	e := &Evaluator{evalCtx: evalCtx.Copy()}
+// select expression. The rows of the reference tables joined by the
+// select expression, if any, are looked up, and the user-defined functions it
+// invokes, if any, are invoked using ie.
+func NewEvaluator(
+	evalCtx *eval.Context, sc *tree.SelectClause, ie sqlutil.InternalExecutor,
+) (*Evaluator, error) {
+	e := &Evaluator{evalCtx: evalCtx.Copy(), ie: ie, udfs: newUDFResolver(ie)}
 
 	if len(sc.From.Tables) > 0 { // 0 tables used only in tests.
 		if len(sc.From.Tables) != 1 {
@@ -65,11 +78,17 @@ func (e *Evaluator) ComputeVirtualColumns(ctx context.Context, row *cdcevent.Row
 	return errors.AssertionFailedf("unimplemented yet")
 }
 
+// MatchesEventOp returns false if the events with the specified operation never
+// match the filter expression, in which case they need not be decoded.
+func (e *Evaluator) MatchesEventOp(op EventOp) bool {
+	return e.ops&op != 0
+}
+
 // MatchesFilter returns true if row matches evaluator filter expression.
 func (e *Evaluator) MatchesFilter(
 	ctx context.Context, updatedRow cdcevent.Row, mvccTS hlc.Timestamp, prevRow cdcevent.Row,
 ) (bool, error) {
-	if e.where == nil {
+	if e.where == nil && len(e.joins) == 0 {
 		return true, nil
 	}
 
@@ -106,6 +125,7 @@ func (e *Evaluator) initSelectClause(ctx context.Context, sc *tree.SelectClause)
 	}
 
 	semaCtx := newSemaCtx()
+	semaCtx.FunctionResolver = &CDCFunctionResolver{udfs: e.udfs}
 	e.selectors = sc.Exprs
 	for _, se := range e.selectors {
 		expr, err := validateExpressionForCDC(ctx, se.Expr, semaCtx)
@@ -123,6 +143,29 @@ func (e *Evaluator) initSelectClause(ctx context.Context, sc *tree.SelectClause)
 		e.where = expr
 	}
 
+	ops, err := eventOpsOf(e.where)
+	if err != nil {
+		return err
+	}
+	e.ops = ops
+
+	if len(sc.From.Tables) == 1 {
+		joins, err := lookupJoinsOf(sc.From.Tables[0])
+		if err != nil {
+			return err
+		}
+		for _, join := range joins {
+			for i, keyExpr := range join.keyExprs {
+				expr, err := validateExpressionForCDC(ctx, keyExpr, semaCtx)
+				if err != nil {
+					return err
+				}
+				join.keyExprs[i] = expr
+			}
+		}
+		e.joins = joins
+	}
+
 	return nil
 }
 
@@ -161,7 +204,15 @@ func (e *Evaluator) initEval(ctx context.Context, d *cdcevent.EventDescriptor) e
 		}
 	}
 
-	evaluator := newExprEval(e.evalCtx, d, tableNameOrAlias(d.TableName, e.from))
+	lookups, err := e.makeLookupTables(ctx, d.SchemaTS)
+	if err != nil {
+		return err
+	}
+
+	evaluator := newExprEval(e.evalCtx, d, tableNameOrAlias(d.TableName, e.from), lookups, e.ie, e.udfs)
+	if err := evaluator.addLookupKeys(ctx); err != nil {
+		return err
+	}
 	for _, selector := range e.selectors {
 		if err := evaluator.addSelector(ctx, selector, len(e.selectors)); err != nil {
 			return err
@@ -176,6 +227,24 @@ func (e *Evaluator) initEval(ctx context.Context, d *cdcevent.EventDescriptor) e
 	return nil
 }
 
+// makeLookupTables returns the lookup tables of the joins, whose columns are
+// resolved as of the schema timestamp.
+func (e *Evaluator) makeLookupTables(
+	ctx context.Context, schemaTS hlc.Timestamp,
+) ([]*lookupTable, error) {
+	var lookups []*lookupTable
+	offset := 0
+	for _, join := range e.joins {
+		l, err := makeLookupTable(ctx, e.ie, join, schemaTS, offset)
+		if err != nil {
+			return nil, err
+		}
+		lookups = append(lookups, l)
+		offset += len(l.cols)
+	}
+	return lookups, nil
+}
+
 type exprEval struct {
 	*cdcevent.EventDescriptor
 	semaCtx *tree.SemaContext
@@ -190,6 +259,9 @@ type exprEval struct {
 	projection     cdcevent.Projection // cdcevent.Projects helps construct projection results.
 	filter         tree.TypedExpr      // where clause filter
 
+	lookups []*lookupTable           // lookup joins of reference tables.
+	ie      sqlutil.InternalExecutor // ie executes the lookups.
+
 	// keep track of number of times particular column name was used
 	// in selectors.  Since the data produced by CDC gets converted
 	// to the formats (JSON, avro, etc.) that may not like having multiple
@@ -202,23 +274,42 @@ type exprEval struct {
 }
 
 func newExprEval(
-	evalCtx *eval.Context, ed *cdcevent.EventDescriptor, tableName *tree.TableName,
+	evalCtx *eval.Context,
+	ed *cdcevent.EventDescriptor,
+	tableName *tree.TableName,
+	lookups []*lookupTable,
+	ie sqlutil.InternalExecutor,
+	udfs *udfResolver,
 ) *exprEval {
 	cols := ed.ResultColumns()
+	// The columns of the reference tables follow the columns of the event,
+	// and are followed by the columns of the previous row.
+	evalCols := append([]cdcevent.ResultColumn(nil), cols...)
+	numLookupCols := 0
+	for _, l := range lookups {
+		for _, col := range l.cols {
+			evalCols = append(evalCols, cdcevent.ResultColumn{ResultColumn: col})
+		}
+		numLookupCols += len(l.cols)
+	}
+	evalCols = append(evalCols, cols...)
 	e := &exprEval{
 		EventDescriptor: ed,
 		semaCtx:         newSemaCtxWithTypeResolver(ed),
 		evalCtx:         evalCtx.Copy(),
-		evalHelper:      &rowContainer{cols: cols},
+		evalHelper:      &rowContainer{cols: evalCols},
 		projection:      cdcevent.MakeProjection(ed),
 		nameUseCount:    make(map[string]int),
+		lookups:         lookups,
+		ie:              ie,
 	}
+	e.rowEvalCtx.lookupRow = make(tree.Datums, numLookupCols)
 
 	evalCtx = nil // From this point, only e.evalCtx should be used.
 
 	// Configure semantic context.
 	e.semaCtx.SearchPath = &sessiondata.DefaultSearchPath
-	e.semaCtx.FunctionResolver = &CDCFunctionResolver{}
+	e.semaCtx.FunctionResolver = &CDCFunctionResolver{udfs: udfs}
 	e.semaCtx.Properties.Require("cdc",
 		tree.RejectAggregates|tree.RejectGenerators|tree.RejectWindowApplications|tree.RejectNestedGenerators,
 	)
@@ -239,13 +330,17 @@ func newExprEval(
 		return rc
 	}
 
-	e.iVarHelper = tree.MakeIndexedVarHelper(e.evalHelper, len(cols))
+	e.iVarHelper = tree.MakeIndexedVarHelper(e.evalHelper, len(evalCols))
 	e.resolver = cdcNameResolver{
 		EventDescriptor: ed,
 		NameResolutionVisitor: schemaexpr.MakeNameResolutionVisitor(
 			colinfo.NewSourceInfoForSingleTable(*tableName, nakedResultColumns()),
 			e.iVarHelper,
 		),
+		eval:    e,
+		lookups: lookups,
+		// The reference tables named cdc_prev are resolved first.
+		prevRow: tableName.ObjectName != prevRowSource,
 	}
 
 	return e
@@ -256,7 +351,10 @@ type rowEvalContext struct {
 	mvccTS     hlc.Timestamp
 	updatedRow cdcevent.Row
 	prevRow    cdcevent.Row
-	memo       struct {
+	// lookupRow holds the values of the columns of the reference rows joined
+	// with the updated row.
+	lookupRow tree.Datums
+	memo      struct {
 		prevJSON tree.Datum
 	}
 }
@@ -285,6 +383,9 @@ func (e *exprEval) evalProjection(
 	}
 
 	e.setupContext(updatedRow, mvccTS, prevRow)
+	if _, err := e.lookupRows(ctx); err != nil {
+		return cdcevent.Row{}, err
+	}
 
 	for i, expr := range e.selectors {
 		d, err := e.evalExpr(ctx, expr, types.Any)
@@ -303,11 +404,17 @@ func (e *exprEval) evalProjection(
 func (e *exprEval) matchesFilter(
 	ctx context.Context, updatedRow cdcevent.Row, mvccTS hlc.Timestamp, prevRow cdcevent.Row,
 ) (bool, error) {
-	if e.filter == nil {
+	if e.filter == nil && len(e.lookups) == 0 {
 		return true, nil
 	}
 
 	e.setupContext(updatedRow, mvccTS, prevRow)
+	if matched, err := e.lookupRows(ctx); err != nil || !matched {
+		return false, err
+	}
+	if e.filter == nil {
+		return true, nil
+	}
 	d, err := e.evalExpr(ctx, e.filter, types.Bool)
 	if err != nil {
 		return false, err
@@ -315,6 +422,75 @@ func (e *exprEval) matchesFilter(
 	return d == tree.DBoolTrue, nil
 }
 
+// lookupRows looks up the reference rows joined with the updated row. Returns
+// false if the row has no matching reference row for some inner join, in
+// which case the row is filtered out.
+func (e *exprEval) lookupRows(ctx context.Context) (bool, error) {
+	for _, l := range e.lookups {
+		key := make(tree.Datums, len(l.keys))
+		for i, expr := range l.keys {
+			d, err := e.evalExpr(ctx, expr, types.Any)
+			if err != nil {
+				return false, err
+			}
+			key[i] = d
+		}
+		row, err := l.lookup(ctx, e.ie, e.evalCtx, key, e.rowEvalCtx.mvccTS)
+		if err != nil {
+			return false, err
+		}
+		if row == nil {
+			if !l.outer {
+				return false, nil
+			}
+			row = l.nulls
+		}
+		copy(e.rowEvalCtx.lookupRow[l.offset:], row)
+	}
+	return true, nil
+}
+
+// datumAt returns the value of the indexed variable, which is either a column
+// of the updated row, a column of the reference rows joined with it, or a
+// column of the previous row.
+func (e *exprEval) datumAt(idx int) (tree.Datum, error) {
+	numCols := len(e.ResultColumns())
+	if idx < numCols {
+		return e.rowEvalCtx.updatedRow.DatumAt(idx)
+	}
+	if idx -= numCols; idx < len(e.rowEvalCtx.lookupRow) {
+		return e.rowEvalCtx.lookupRow[idx], nil
+	}
+	if idx -= len(e.rowEvalCtx.lookupRow); idx < numCols {
+		return e.prevDatumAt(idx)
+	}
+	return nil, errors.AssertionFailedf("column index %d out of bounds", idx)
+}
+
+// lookupColumn returns the indexed variable bound to the column of the
+// reference table with the specified ordinal.
+func (e *exprEval) lookupColumn(l *lookupTable, ord int) *tree.IndexedVar {
+	return e.iVarHelper.IndexedVar(len(e.ResultColumns()) + l.offset + ord)
+}
+
+// addLookupKeys type checks the key expressions of the lookup joins. The key
+// expressions of each join may only reference the reference tables joined
+// before it.
+func (e *exprEval) addLookupKeys(ctx context.Context) error {
+	defer func() { e.resolver.lookups = e.lookups }()
+	for i, l := range e.lookups {
+		e.resolver.lookups = e.lookups[:i]
+		for _, expr := range l.keyExprs {
+			typedExpr, err := e.typeCheck(ctx, expr, types.Any)
+			if err != nil {
+				return err
+			}
+			l.keys = append(l.keys, typedExpr)
+		}
+	}
+	return nil
+}
+
 // computeRenderColumnName returns render name for a selector, adjusted for CDC use case.
 func (e *exprEval) computeRenderColumnName(selector tree.SelectExpr) (string, error) {
 	as, err := func() (string, error) {
@@ -353,6 +529,15 @@ func (e *exprEval) makeUniqueName(as string) string {
 func (e *exprEval) addSelector(
 	ctx context.Context, selector tree.SelectExpr, numSelectors int,
 ) error {
+	// Expand "alias.*" of the reference tables, which the name resolver does
+	// not know about.
+	if l := e.lookupOfStar(selector.Expr); l != nil {
+		for ord, col := range l.cols {
+			e.addProjection(e.lookupColumn(l, ord), e.makeUniqueName(col.Name))
+		}
+		return nil
+	}
+
 	as, err := e.computeRenderColumnName(selector)
 	if err != nil {
 		return err
@@ -366,13 +551,22 @@ func (e *exprEval) addSelector(
 	// Expand "*".  We walked expression during type check above, so we only expect to
 	// see UnqualifiedStar.
 	if _, isStar := typedExpr.(tree.UnqualifiedStar); isStar {
-		if numSelectors == 1 {
+		// Unqualified "*" also expands to the columns of the reference tables.
+		allTables := isUnqualifiedStar(selector.Expr) && len(e.lookups) > 0
+		if numSelectors == 1 && !allTables {
 			// Single star gets special treatment.
 			e.starProjection = true
 		} else {
 			for ord, col := range e.ResultColumns() {
 				e.addProjection(e.iVarHelper.IndexedVar(ord), e.makeUniqueName(col.Name))
 			}
+			if allTables {
+				for _, l := range e.lookups {
+					for ord, col := range l.cols {
+						e.addProjection(e.lookupColumn(l, ord), e.makeUniqueName(col.Name))
+					}
+				}
+			}
 		}
 	} else {
 		e.addProjection(typedExpr, as)
@@ -381,6 +575,31 @@ func (e *exprEval) addSelector(
 	return nil
 }
 
+// lookupOfStar returns the lookup table whose columns are selected by the
+// expression if it is "alias.*" for some reference table alias, and nil
+// otherwise.
+func (e *exprEval) lookupOfStar(expr tree.Expr) *lookupTable {
+	if n, ok := expr.(*tree.UnresolvedName); ok && n.Star && n.NumParts == 2 {
+		for _, l := range e.lookups {
+			if tree.Name(n.Parts[1]) == l.alias {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// isUnqualifiedStar returns true if the expression is "*".
+func isUnqualifiedStar(expr tree.Expr) bool {
+	switch t := expr.(type) {
+	case tree.UnqualifiedStar:
+		return true
+	case *tree.UnresolvedName:
+		return t.Star && t.NumParts == 1
+	}
+	return false
+}
+
 // addFilter adds where clause filter.
 func (e *exprEval) addFilter(ctx context.Context, where tree.Expr) error {
 	if where == nil {
@@ -456,13 +675,13 @@ func (e *exprEval) evalExpr(
 	case tree.Datum:
 		return t, nil
 	case *tree.IndexedVar:
-		d, err := e.rowEvalCtx.updatedRow.DatumAt(t.Idx)
+		d, err := e.datumAt(t.Idx)
 		if err != nil {
 			return nil, err
 		}
 		return d, nil
 	default:
-		v := replaceIndexVarVisitor{row: e.rowEvalCtx.updatedRow}
+		v := replaceIndexVarVisitor{datumAt: e.datumAt}
 		newExpr, _ := tree.WalkExpr(&v, expr)
 		if v.err != nil {
 			return nil, v.err
@@ -529,11 +748,17 @@ func (v *cdcExprVisitor) VisitPost(expr tree.Expr) tree.Expr {
 }
 
 // cdcNameResolver is a visitor that resolves names in the expression
-// and associates them with the EventDescriptor columns.
+// and associates them with the EventDescriptor columns, with the columns
+// of the reference tables of the lookup joins, or with the columns of the
+// previous row.
 type cdcNameResolver struct {
 	schemaexpr.NameResolutionVisitor
 	*cdcevent.EventDescriptor
 	err error
+
+	eval    *exprEval
+	lookups []*lookupTable // reference tables whose columns may be referenced.
+	prevRow bool           // true if cdc_prev qualifies the columns of the previous row.
 }
 
 // tag errors generated by cdcNameResolver.
@@ -561,6 +786,30 @@ func (v *cdcNameResolver) wrapError() func() {
 
 // VisitPre implements tree.Visitor interface.
 func (v *cdcNameResolver) VisitPre(expr tree.Expr) (recurse bool, newExpr tree.Expr) {
+	// Columns qualified by the alias of a reference table are bound to the
+	// columns of the reference rows.
+	if n, ok := expr.(*tree.UnresolvedName); ok && !n.Star && n.NumParts == 2 {
+		for _, l := range v.lookups {
+			if tree.Name(n.Parts[1]) != l.alias {
+				continue
+			}
+			ord, err := l.columnOrdinal(tree.Name(n.Parts[0]))
+			if err != nil {
+				v.err = err
+				return false, expr
+			}
+			return false, v.eval.lookupColumn(l, ord)
+		}
+		if v.prevRow && tree.Name(n.Parts[1]) == prevRowSource {
+			iVar, err := v.eval.prevColumn(tree.Name(n.Parts[0]))
+			if err != nil {
+				v.err = err
+				return false, expr
+			}
+			return false, iVar
+		}
+	}
+
 	defer v.wrapError()()
 	recurse, newExpr = v.NameResolutionVisitor.VisitPre(expr)
 	return v.err == nil, newExpr
@@ -642,6 +891,13 @@ func checkFunctionSupported(
 	}
 
 	// We have a non-immutable function -- make sure it is supported.
+	if funcDef.Overloads[0].Category == udfFnProps.Category {
+		return nil, &cdcResolverError{
+			error: pgerror.Newf(pgcode.FeatureNotSupported,
+				"user-defined function %q unsupported by CDC: only immutable user-defined functions are supported",
+				fnName),
+		}
+	}
 	_, isSafe := supportedVolatileBuiltinFunctions[fnName]
 	if !isSafe {
 		return nil, unsupportedFunctionErr()
@@ -667,8 +923,8 @@ func (c *rowContainer) IndexedVarNodeFormatter(idx int) tree.NodeFormatter {
 }
 
 type replaceIndexVarVisitor struct {
-	row cdcevent.Row
-	err error
+	datumAt func(idx int) (tree.Datum, error)
+	err     error
 }
 
 var _ tree.Visitor = (*replaceIndexVarVisitor)(nil)
@@ -676,7 +932,7 @@ var _ tree.Visitor = (*replaceIndexVarVisitor)(nil)
 // VisitPre implements tree.Visitor interface.
 func (v *replaceIndexVarVisitor) VisitPre(expr tree.Expr) (recurse bool, newExpr tree.Expr) {
 	if iVar, ok := expr.(*tree.IndexedVar); ok {
-		datum, err := v.row.DatumAt(iVar.Idx)
+		datum, err := v.datumAt(iVar.Idx)
 		if err != nil {
 			v.err = pgerror.Wrapf(err, pgcode.NumericValueOutOfRange, "variable @%d out of bounds", iVar.Idx)
 			return false, expr
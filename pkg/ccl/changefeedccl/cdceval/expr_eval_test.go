@@ -265,6 +265,54 @@ CREATE TABLE foo (
 				},
 			},
 		},
+		{
+			testName:   "main/cdc_prev_column",
+			familyName: "only_c",
+			actions: []string{
+				"INSERT INTO foo (a, b, c) VALUES (42, 'prev_column', 'c value old')",
+				"UPSERT INTO foo (a, b, c) VALUES (42, 'prev_column', 'c value updated')",
+			},
+			predicate: "SELECT a, b, c, cdc_prev.c AS old_c FROM _",
+			expectMainFamily: []decodeExpectation{
+				{
+					expectUnwatchedErr: true,
+				},
+			},
+			expectOnlyCFamily: []decodeExpectation{
+				{
+					keyValues: []string{"prev_column", "42"},
+					allValues: map[string]string{"a": "42", "b": "prev_column", "c": "c value old", "old_c": "NULL"},
+				},
+				{
+					keyValues: []string{"prev_column", "42"},
+					allValues: map[string]string{"a": "42", "b": "prev_column", "c": "c value updated", "old_c": "c value old"},
+				},
+			},
+		},
+		{
+			testName:   "main/cdc_prev_column_filter",
+			familyName: "only_c",
+			actions: []string{
+				"INSERT INTO foo (a, b, c) VALUES (42, 'prev_column_filter', 'old')",
+				"UPSERT INTO foo (a, b, c) VALUES (42, 'prev_column_filter', 'new')",
+			},
+			predicate: "SELECT c FROM _ WHERE cdc_prev.c = 'old'",
+			expectMainFamily: []decodeExpectation{
+				{
+					expectUnwatchedErr: true,
+				},
+			},
+			expectOnlyCFamily: []decodeExpectation{
+				{
+					keyValues:      []string{"prev_column_filter", "42"},
+					expectFiltered: true,
+				},
+				{
+					keyValues: []string{"prev_column_filter", "42"},
+					allValues: map[string]string{"c": "new"},
+				},
+			},
+		},
 		{
 			testName:   "main/select_if",
 			familyName: "main",
@@ -582,6 +630,17 @@ func TestEvaluatesProjection(t *testing.T) {
 			input:       makeEncDatumRow(tree.NewDInt(4), tree.DNull, tree.DNull),
 			expectation: map[string]string{"result": "3.0", "a": "4", "b": "NULL", "c": "NULL"},
 		},
+		{
+			name:        "projection_with_prev_columns",
+			predicate:   "SELECT a, cdc_prev.b AS old_b, cdc_prev.c || 'x' AS old_c",
+			input:       makeEncDatumRow(tree.NewDInt(4), tree.NewDString("b"), tree.NewDString("c")),
+			expectation: map[string]string{"a": "4", "old_b": "b", "old_c": "cx"},
+		},
+		{
+			name:      "prev_column_of_other_family",
+			predicate: "SELECT cdc_prev.d",
+			expectErr: "column cdc_prev.d does not exist",
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			e, err := makeExprEval(t, s.ClusterSettings(), testRow.EventDescriptor, tc.predicate)
@@ -614,7 +673,7 @@ func makeEvaluator(t *testing.T, st *cluster.Settings, selectStr string) (*Evalu
 	require.NoError(t, err)
 	slct := s.AST.(*tree.Select).Select.(*tree.SelectClause)
 	evalCtx := eval.MakeTestingEvalContext(st)
-	return NewEvaluator(&evalCtx, slct)
+	return NewEvaluator(&evalCtx, slct, nil /* ie */)
 }
 
 func makeExprEval(
@@ -20,7 +20,11 @@ import (
 
 // CDCFunctionResolver is a function resolver specific used by CDC expression
 // evaluation.
-type CDCFunctionResolver struct{}
+type CDCFunctionResolver struct {
+	// udfs, if set, resolves the fully qualified names of user-defined
+	// functions.
+	udfs *udfResolver
+}
 
 // ResolveFunction implements FunctionReferenceResolver interface.
 func (rs *CDCFunctionResolver) ResolveFunction(
@@ -38,6 +42,9 @@ func (rs *CDCFunctionResolver) ResolveFunction(
 		if err != nil {
 			return nil, err
 		}
+		if funcDef == nil && fn.ExplicitCatalog && rs.udfs != nil {
+			return rs.udfs.resolve(ctx, fn)
+		}
 		if funcDef == nil {
 			return nil, errors.AssertionFailedf("function %s does not exist", fn.String())
 		}
@@ -73,7 +80,7 @@ func (rs *CDCFunctionResolver) WrapFunction(name string) (*tree.ResolvedFunction
 func (rs *CDCFunctionResolver) ResolveFunctionByOID(
 	ctx context.Context, oid oid.Oid,
 ) (string, *tree.Overload, error) {
-	// CDC doesn't support user defined function yet, so there's no need to
-	// resolve function by OID.
+	// CDC resolves user defined functions by name (see udfResolver), so
+	// there's no need to resolve function by OID.
 	return "", nil, errors.AssertionFailedf("unimplemented yet")
 }
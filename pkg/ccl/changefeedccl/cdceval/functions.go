@@ -85,6 +85,20 @@ var cdcFunctions = map[string]*tree.ResolvedFunctionDefinition{
 			return rowEvalCtx.updatedRow.SchemaTS
 		},
 	),
+	eventOpFnName: makeCDCBuiltIn(
+		eventOpFnName,
+		tree.Overload{
+			Types:      tree.ArgTypes{},
+			ReturnType: tree.FixedReturnType(types.String),
+			Fn: func(evalCtx *eval.Context, datums tree.Datums) (tree.Datum, error) {
+				rowEvalCtx := rowEvalContextFromEvalContext(evalCtx)
+				op := eventOpOfRow(rowEvalCtx.updatedRow, rowEvalCtx.prevRow)
+				return tree.NewDString(eventOpNames[op]), nil
+			},
+			Info: "Returns the operation of the event: 'insert', 'update', 'delete', " +
+				"or 'upsert' if the previous value of the row is not known",
+			Volatility: volatility.Stable,
+		}),
 	"cdc_prev": makeCDCBuiltIn(
 		"cdc_prev",
 		tree.Overload{
@@ -97,8 +111,6 @@ var cdcFunctions = map[string]*tree.ResolvedFunctionDefinition{
 }
 
 // TODO(yevgeniy): Implement additional functions (some ideas, not all should be implemented):
-//   * cdc_is_delete is easy; what about update? does update include new events?
-//   * cdc_is_new -- true if event is  a new row
 //   * tuple overload (or cdc_prev_tuple) to return previous value as a tuple
 //   * cdc_key -- effectively key_in_value where key columns returned as either a tuple or a json.
 //   * cdc_key_cols -- return key column names;
@@ -108,6 +120,11 @@ var cdcFunctions = map[string]*tree.ResolvedFunctionDefinition{
 //     family ID and family name.
 //     function can be used to write complex conditionals when dealing with multi-family table(s)
 
+// eventOpFnName is the name of the function returning the operation of the
+// event, which changefeeds also use to skip the events filtered out by their
+// operation (see eventOpsOf).
+const eventOpFnName = "event_op"
+
 var cdcFnProps = &tree.FunctionProperties{
 	Category: "CDC builtin",
 }
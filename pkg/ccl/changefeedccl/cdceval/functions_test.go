@@ -112,6 +112,30 @@ func TestEvaluatesCDCFunctionOverloads(t *testing.T) {
 		}
 	})
 
+	t.Run("event_op", func(t *testing.T) {
+		rowDatums := randEncDatumRow(t, desc, 0)
+		row := cdcevent.TestingMakeEventRow(desc, 0, rowDatums, false)
+		deletedRow := cdcevent.TestingMakeEventRow(desc, 0, rowDatums, true)
+		e, err := makeExprEval(t, s.ClusterSettings(), row.EventDescriptor, "SELECT event_op()")
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			updatedRow, prevRow cdcevent.Row
+			expect              string
+		}{
+			// When previous row is not set -- i.e. if running without diff,
+			// inserts can't be told from updates.
+			{updatedRow: row, prevRow: cdcevent.Row{}, expect: "upsert"},
+			{updatedRow: row, prevRow: deletedRow, expect: "insert"},
+			{updatedRow: row, prevRow: row, expect: "update"},
+			{updatedRow: deletedRow, prevRow: row, expect: "delete"},
+		} {
+			p, err := e.evalProjection(ctx, tc.updatedRow, s.Clock().Now(), tc.prevRow)
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"event_op": tc.expect}, slurpValues(t, p))
+		}
+	})
+
 	mustParseJSON := func(d tree.Datum) jsonb.JSON {
 		t.Helper()
 		j, err := tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
@@ -0,0 +1,323 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree/treecmp"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// lookupJoin is a join of the changefeed target table with a reference table,
+// such as "JOIN customers AS c ON c.id = cid". The row of the reference table
+// joined with each event is looked up by primary key, as of the MVCC timestamp
+// of the event.
+type lookupJoin struct {
+	tableID int64
+	alias   tree.Name
+	// outer is set for LEFT JOIN: the events without a matching reference row
+	// are joined with NULLs rather than filtered out.
+	outer bool
+	// keyCols are the columns of the reference table compared by the join
+	// condition, and keyExprs the expressions they are equal to. The
+	// expressions may reference the columns of the target table and of the
+	// reference tables joined before this one.
+	keyCols  []tree.Name
+	keyExprs []tree.Expr
+}
+
+// lookupJoinsOf returns the lookup joins of the normalized table expression of
+// a changefeed expression, in the order in which they are joined.
+func lookupJoinsOf(from tree.TableExpr) ([]lookupJoin, error) {
+	_, joins := splitLookupJoins(from)
+	if len(joins) == 0 {
+		return nil, nil
+	}
+	lookups := make([]lookupJoin, len(joins))
+	for i, join := range joins {
+		ref, ok := join.Right.(*tree.TableRef)
+		if !ok {
+			return nil, errors.AssertionFailedf("unexpected table expression type %T", join.Right)
+		}
+		if err := lookups[i].init(ref.TableID, ref.As.Alias, join); err != nil {
+			return nil, err
+		}
+	}
+	return lookups, nil
+}
+
+// init initializes the lookup join of the reference table with the specified
+// id and alias from the join expression.
+func (l *lookupJoin) init(tableID int64, alias tree.Name, join *tree.JoinTableExpr) error {
+	l.tableID, l.alias = tableID, alias
+	switch join.JoinType {
+	case "", tree.AstInner:
+	case tree.AstLeft:
+		l.outer = true
+	default:
+		return pgerror.Newf(pgcode.FeatureNotSupported,
+			"%s JOIN not supported by CDC: only inner and left joins are supported", join.JoinType)
+	}
+	on, ok := join.Cond.(*tree.OnJoinCond)
+	if !ok {
+		return pgerror.Newf(pgcode.FeatureNotSupported,
+			"join of %s not supported by CDC: only ON conditions are supported", l.alias)
+	}
+	return l.addKeyConditions(on.Expr)
+}
+
+// addKeyConditions adds the equalities of the join condition, which must be a
+// conjunction of equalities between the columns of the reference table and
+// expressions which do not reference it.
+func (l *lookupJoin) addKeyConditions(cond tree.Expr) error {
+	switch t := cond.(type) {
+	case *tree.ParenExpr:
+		return l.addKeyConditions(t.Expr)
+	case *tree.AndExpr:
+		if err := l.addKeyConditions(t.Left); err != nil {
+			return err
+		}
+		return l.addKeyConditions(t.Right)
+	case *tree.ComparisonExpr:
+		if t.Operator.Symbol != treecmp.EQ {
+			break
+		}
+		col, expr := l.columnOf(t.Left), t.Right
+		if col == "" {
+			col, expr = l.columnOf(t.Right), t.Left
+		}
+		if col == "" || l.referencedBy(expr) {
+			break
+		}
+		for _, keyCol := range l.keyCols {
+			if keyCol == col {
+				return pgerror.Newf(pgcode.FeatureNotSupported,
+					"join condition of %s compares column %s more than once", l.alias, col)
+			}
+		}
+		l.keyCols = append(l.keyCols, col)
+		l.keyExprs = append(l.keyExprs, expr)
+		return nil
+	}
+	return pgerror.Newf(pgcode.FeatureNotSupported,
+		"join condition %q not supported by CDC: expected equalities between columns of %s "+
+			"and expressions of the preceding tables", tree.AsString(cond), l.alias)
+}
+
+// columnOf returns the name of the column of the reference table if the
+// expression is a column qualified by the reference table alias, and an
+// empty name otherwise.
+func (l *lookupJoin) columnOf(expr tree.Expr) tree.Name {
+	if n, ok := expr.(*tree.UnresolvedName); ok && !n.Star && n.NumParts == 2 &&
+		tree.Name(n.Parts[1]) == l.alias {
+		return tree.Name(n.Parts[0])
+	}
+	return ""
+}
+
+// referencedBy returns true if the expression references the reference table.
+func (l *lookupJoin) referencedBy(expr tree.Expr) bool {
+	referenced := false
+	_, _ = tree.SimpleVisit(expr, func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+		if n, ok := expr.(*tree.UnresolvedName); ok && n.NumParts >= 2 &&
+			tree.Name(n.Parts[1]) == l.alias {
+			referenced = true
+		}
+		return !referenced, expr, nil
+	})
+	return referenced
+}
+
+// normalizeLookupJoins replaces the reference tables of the joins with table
+// references, and verifies that the join conditions compare each primary key
+// column of the reference tables, so that at most one reference row is joined
+// with each event.
+func normalizeLookupJoins(
+	joins []*tree.JoinTableExpr, descs []catalog.TableDescriptor, targetAlias tree.Name,
+) error {
+	if len(joins) != len(descs) {
+		return errors.AssertionFailedf("expected %d lookup table descriptors, found %d",
+			len(joins), len(descs))
+	}
+
+	aliases := map[tree.Name]struct{}{targetAlias: {}}
+	for i, join := range joins {
+		desc := descs[i]
+		var alias tree.AliasClause
+		switch t := join.Right.(type) {
+		case *tree.AliasedTableExpr:
+			alias = t.As
+		case tree.TablePattern:
+		default:
+			return errors.AssertionFailedf("unexpected table expression type %T", join.Right)
+		}
+		if alias.Alias == "" {
+			alias.Alias = tree.Name(desc.GetName())
+		}
+		if _, seen := aliases[alias.Alias]; seen {
+			return pgerror.Newf(pgcode.DuplicateAlias,
+				"source name %q specified more than once", alias.Alias)
+		}
+		aliases[alias.Alias] = struct{}{}
+		join.Right = &tree.TableRef{
+			TableID: int64(desc.GetID()),
+			As:      alias,
+		}
+
+		var l lookupJoin
+		if err := l.init(int64(desc.GetID()), alias.Alias, join); err != nil {
+			return err
+		}
+		primaryIndex := desc.GetPrimaryIndex()
+		keyCols := make([]string, primaryIndex.NumKeyColumns())
+		isKeyCol := make(map[tree.Name]struct{}, len(keyCols))
+		for i := range keyCols {
+			keyCols[i] = primaryIndex.GetKeyColumnName(i)
+			isKeyCol[tree.Name(keyCols[i])] = struct{}{}
+		}
+		covered := len(l.keyCols) == len(keyCols)
+		for _, col := range l.keyCols {
+			_, ok := isKeyCol[col]
+			covered = covered && ok
+		}
+		if !covered {
+			return pgerror.Newf(pgcode.FeatureNotSupported,
+				"join condition of %s must compare each of its primary key columns (%s), and only those",
+				l.alias, strings.Join(keyCols, ", "))
+		}
+	}
+	return nil
+}
+
+// lookupTable looks up the rows of the reference table of a lookup join.
+type lookupTable struct {
+	lookupJoin
+	// cols are the visible columns of the reference table. Their values are
+	// bound to the indexed variables following the columns of the event and
+	// of the reference tables joined before this one, starting at offset.
+	cols   []colinfo.ResultColumn
+	offset int
+	// keys are the type checked keyExprs.
+	keys []tree.TypedExpr
+	// selectList and where are the clauses of the lookup query.
+	selectList, where string
+	nulls             tree.Datums
+
+	// last memoizes the most recent lookup, which is repeated when both the
+	// filter and the projection of an event are evaluated, and for the
+	// events of a transaction referencing the same reference row.
+	last struct {
+		mvccTS hlc.Timestamp
+		key    tree.Datums
+		row    tree.Datums
+	}
+}
+
+// makeLookupTable returns the lookup table of the join, whose columns are
+// resolved as of the schema timestamp.
+func makeLookupTable(
+	ctx context.Context, ie sqlutil.InternalExecutor, l lookupJoin, schemaTS hlc.Timestamp, offset int,
+) (*lookupTable, error) {
+	if ie == nil {
+		return nil, errors.AssertionFailedf("lookup join of %s requires an executor", l.alias)
+	}
+	_, cols, err := ie.QueryBufferedExWithCols(ctx, "cdc-lookup-columns", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride,
+		fmt.Sprintf(`SELECT * FROM [%d AS t]%s LIMIT 0`, l.tableID, asOfSystemTime(schemaTS)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving columns of %s", l.alias)
+	}
+
+	t := &lookupTable{
+		lookupJoin: l,
+		cols:       cols,
+		offset:     offset,
+		nulls:      make(tree.Datums, len(cols)),
+	}
+	selectList := make([]string, len(cols))
+	for i, col := range cols {
+		selectList[i] = tree.NameString(col.Name)
+		t.nulls[i] = tree.DNull
+	}
+	where := make([]string, len(l.keyCols))
+	for i, keyCol := range l.keyCols {
+		if _, err := t.columnOrdinal(keyCol); err != nil {
+			return nil, err
+		}
+		where[i] = fmt.Sprintf("%s = $%d", tree.NameString(string(keyCol)), i+1)
+	}
+	t.selectList = strings.Join(selectList, ", ")
+	t.where = strings.Join(where, " AND ")
+	return t, nil
+}
+
+// columnOrdinal returns the ordinal of the column of the reference table.
+func (t *lookupTable) columnOrdinal(name tree.Name) (int, error) {
+	for i, col := range t.cols {
+		if col.Name == string(name) {
+			return i, nil
+		}
+	}
+	return 0, pgerror.Newf(pgcode.UndefinedColumn,
+		"column %s does not exist in %s", name.String(), t.alias.String())
+}
+
+// lookup returns the row of the reference table whose primary key is the key,
+// as of the MVCC timestamp, or nil if there is no such row.
+func (t *lookupTable) lookup(
+	ctx context.Context,
+	ie sqlutil.InternalExecutor,
+	evalCtx *eval.Context,
+	key tree.Datums,
+	mvccTS hlc.Timestamp,
+) (tree.Datums, error) {
+	args := make([]interface{}, len(key))
+	for i, d := range key {
+		if d == tree.DNull {
+			// NULL is not equal to any key.
+			return nil, nil
+		}
+		args[i] = d
+	}
+	if t.last.key != nil && t.last.mvccTS == mvccTS && t.last.key.Compare(evalCtx, key) == 0 {
+		return t.last.row, nil
+	}
+
+	row, err := ie.QueryRowEx(ctx, "cdc-lookup", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride,
+		fmt.Sprintf(`SELECT %s FROM [%d AS t]%s WHERE %s`,
+			t.selectList, t.tableID, asOfSystemTime(mvccTS), t.where),
+		args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up %s", t.alias)
+	}
+	t.last.mvccTS, t.last.key, t.last.row = mvccTS, key, row
+	return row, nil
+}
+
+// asOfSystemTime returns the AS OF SYSTEM TIME clause reading as of the
+// timestamp, or nothing if the timestamp is empty.
+func asOfSystemTime(ts hlc.Timestamp) string {
+	if ts.IsEmpty() {
+		return ""
+	}
+	return fmt.Sprintf(` AS OF SYSTEM TIME '%s'`, ts.AsOfSystemTime())
+}
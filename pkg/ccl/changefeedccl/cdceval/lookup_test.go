@@ -0,0 +1,202 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupJoin(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, kvDB := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.ExecMultiple(t,
+		`CREATE TABLE orders (id INT PRIMARY KEY, cid INT, rid INT)`,
+		`CREATE TABLE customers (id INT PRIMARY KEY, name STRING, region STRING)`,
+		`CREATE TABLE regions (name STRING PRIMARY KEY, zone STRING)`,
+		`CREATE TABLE accounts (a INT, b INT, PRIMARY KEY (a, b))`,
+		`INSERT INTO customers VALUES (1, 'alice', 'east'), (2, 'bob', 'west')`,
+		`INSERT INTO regions VALUES ('east', 'us-east1')`,
+	)
+
+	descs := make(map[string]catalog.TableDescriptor)
+	for _, name := range []string{`orders`, `customers`, `regions`, `accounts`} {
+		descs[name] = cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), tree.Name(name))
+	}
+	ordersDesc := descs[`orders`]
+
+	ctx := context.Background()
+	execCfg := s.ExecutorConfig().(sql.ExecutorConfig)
+	p, cleanup := sql.NewInternalPlanner("test",
+		kvDB.NewTxn(ctx, "test-planner"),
+		username.RootUserName(), &sql.MemoryMetrics{}, &execCfg,
+		sessiondatapb.SessionData{
+			Database:   "defaultdb",
+			SearchPath: sessiondata.DefaultSearchPath.GetPathArray(),
+		})
+	defer cleanup()
+	execCtx := p.(sql.JobExecContext)
+
+	// The events of the orders table, and the projections they are expected to
+	// produce; nil if the event is filtered out.
+	type event struct {
+		id, cid int
+		expect  map[string]string
+	}
+
+	for _, tc := range []struct {
+		name       string
+		stmt       string
+		lookups    []string
+		expectErr  string
+		expectStmt string
+		events     []event
+	}{
+		{
+			name:    "inner join",
+			stmt:    "SELECT o.id, c.name FROM orders AS o JOIN customers AS c ON c.id = o.cid",
+			lookups: []string{`customers`},
+			expectStmt: fmt.Sprintf("SELECT o.id, c.name FROM [%d AS o] JOIN [%d AS c] ON c.id = o.cid",
+				ordersDesc.GetID(), descs[`customers`].GetID()),
+			events: []event{
+				{id: 1, cid: 1, expect: map[string]string{"id": "1", "name": "alice"}},
+				{id: 2, cid: 3},
+			},
+		},
+		{
+			name:    "left join",
+			stmt:    "SELECT id, customers.name FROM orders LEFT JOIN customers ON customers.id = cid",
+			lookups: []string{`customers`},
+			expectStmt: fmt.Sprintf(
+				"SELECT id, customers.name FROM [%d AS orders] LEFT JOIN [%d AS customers] ON customers.id = cid",
+				ordersDesc.GetID(), descs[`customers`].GetID()),
+			events: []event{
+				{id: 1, cid: 2, expect: map[string]string{"id": "1", "name": "bob"}},
+				{id: 2, cid: 3, expect: map[string]string{"id": "2", "name": "NULL"}},
+			},
+		},
+		{
+			name:    "chained joins with filter",
+			stmt:    "SELECT id, r.zone FROM orders JOIN customers AS c ON c.id = cid JOIN regions AS r ON r.name = c.region WHERE c.name != 'bob'",
+			lookups: []string{`customers`, `regions`},
+			expectStmt: fmt.Sprintf(
+				"SELECT id, r.zone FROM [%d AS orders] JOIN [%d AS c] ON c.id = cid JOIN [%d AS r] ON r.name = c.region WHERE c.name != 'bob'",
+				ordersDesc.GetID(), descs[`customers`].GetID(), descs[`regions`].GetID()),
+			events: []event{
+				{id: 1, cid: 1, expect: map[string]string{"id": "1", "zone": "us-east1"}},
+				{id: 2, cid: 2},
+			},
+		},
+		{
+			name:      "full join",
+			stmt:      "SELECT * FROM orders FULL JOIN customers AS c ON c.id = cid",
+			lookups:   []string{`customers`},
+			expectErr: "FULL JOIN not supported by CDC",
+		},
+		{
+			name:      "using condition",
+			stmt:      "SELECT * FROM orders JOIN customers USING (id)",
+			lookups:   []string{`customers`},
+			expectErr: "only ON conditions are supported",
+		},
+		{
+			name:      "non equality condition",
+			stmt:      "SELECT * FROM orders JOIN customers AS c ON c.id > cid",
+			lookups:   []string{`customers`},
+			expectErr: `join condition "c.id > cid" not supported by CDC`,
+		},
+		{
+			name:      "not a primary key",
+			stmt:      "SELECT * FROM orders JOIN customers AS c ON c.name = 'alice'",
+			lookups:   []string{`customers`},
+			expectErr: `join condition of c must compare each of its primary key columns \(id\), and only those`,
+		},
+		{
+			name:      "partial primary key",
+			stmt:      "SELECT * FROM orders JOIN accounts AS a ON a.a = cid",
+			lookups:   []string{`accounts`},
+			expectErr: `join condition of a must compare each of its primary key columns \(a, b\), and only those`,
+		},
+		{
+			name:      "duplicate alias",
+			stmt:      "SELECT * FROM orders AS o JOIN customers AS o ON o.id = 1",
+			lookups:   []string{`customers`},
+			expectErr: `source name "o" specified more than once`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, err := ParseChangefeedExpression(tc.stmt)
+			require.NoError(t, err)
+			target := jobspb.ChangefeedTargetSpecification{
+				TableID:           ordersDesc.GetID(),
+				StatementTimeName: ordersDesc.GetName(),
+			}
+			var lookupDescs []catalog.TableDescriptor
+			for _, name := range tc.lookups {
+				lookupDescs = append(lookupDescs, descs[name])
+			}
+
+			_, _, err = NormalizeAndValidateSelectForTarget(
+				ctx, execCtx, ordersDesc, target, sc, lookupDescs, false, false)
+			if tc.expectErr != "" {
+				require.Regexp(t, tc.expectErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectStmt, AsStringUnredacted(sc))
+
+			// Evaluate the normalized expression, as changefeed aggregators do.
+			sc, err = ParseChangefeedExpression(AsStringUnredacted(sc))
+			require.NoError(t, err)
+			evalCtx := eval.MakeTestingEvalContext(s.ClusterSettings())
+			e, err := NewEvaluator(&evalCtx, sc, s.InternalExecutor().(sqlutil.InternalExecutor))
+			require.NoError(t, err)
+
+			mvccTS := s.Clock().Now()
+			for _, ev := range tc.events {
+				row := cdcevent.TestingMakeEventRow(ordersDesc, 0,
+					makeEncDatumRow(tree.NewDInt(tree.DInt(ev.id)), tree.NewDInt(tree.DInt(ev.cid)), tree.DNull), false)
+				matches, err := e.MatchesFilter(ctx, row, mvccTS, row)
+				require.NoError(t, err)
+				if ev.expect == nil {
+					require.False(t, matches, "event %d", ev.id)
+					continue
+				}
+				require.True(t, matches, "event %d", ev.id)
+				projection, err := e.Projection(ctx, row, mvccTS, row)
+				require.NoError(t, err)
+				require.Equal(t, ev.expect, slurpValues(t, projection))
+			}
+		})
+	}
+}
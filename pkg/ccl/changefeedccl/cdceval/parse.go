@@ -42,6 +42,35 @@ func tableNameOrAlias(name string, expr tree.TableExpr) *tree.TableName {
 		return tree.NewUnqualifiedTableName(t.As.Alias)
 	case *tree.TableRef:
 		return tree.NewUnqualifiedTableName(t.As.Alias)
+	case *tree.JoinTableExpr:
+		target, _ := splitLookupJoins(t)
+		return tableNameOrAlias(name, target)
 	}
 	return tree.NewUnqualifiedTableName(tree.Name(name))
 }
+
+// splitLookupJoins splits the table expression of a changefeed expression into
+// the changefeed target table and the joins of the reference tables, in the
+// order in which they are joined.
+func splitLookupJoins(expr tree.TableExpr) (tree.TableExpr, []*tree.JoinTableExpr) {
+	join, ok := expr.(*tree.JoinTableExpr)
+	if !ok {
+		return expr, nil
+	}
+	target, joins := splitLookupJoins(join.Left)
+	return target, append(joins, join)
+}
+
+// LookupTables returns the table expressions of the reference tables joined by
+// the changefeed expression, in the order in which they are joined.
+func LookupTables(sc *tree.SelectClause) []tree.TableExpr {
+	if len(sc.From.Tables) != 1 {
+		return nil
+	}
+	_, joins := splitLookupJoins(sc.From.Tables[0])
+	tables := make([]tree.TableExpr, len(joins))
+	for i, join := range joins {
+		tables[i] = join.Right
+	}
+	return tables
+}
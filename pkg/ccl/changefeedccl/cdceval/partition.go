@@ -0,0 +1,350 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// PartitionEvaluator evaluates a scalar expression over the columns of a row
+// in order to compute the partition the row should be emitted to.
+type PartitionEvaluator struct {
+	*Evaluator
+}
+
+// NewPartitionEvaluator returns PartitionEvaluator configured to evaluate
+// specified partition expression.
+func NewPartitionEvaluator(evalCtx *eval.Context, expr string) (*PartitionEvaluator, error) {
+	sc, err := parsePartitionExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewEvaluator(evalCtx, sc, nil /* ie */)
+	if err != nil {
+		return nil, err
+	}
+	return &PartitionEvaluator{Evaluator: e}, nil
+}
+
+// Partition evaluates partition expression against the updated row and
+// returns the resulting partition.
+func (e *PartitionEvaluator) Partition(
+	ctx context.Context, updatedRow cdcevent.Row, mvccTS hlc.Timestamp,
+) (int64, error) {
+	projection, err := e.Projection(ctx, updatedRow, mvccTS, cdcevent.Row{})
+	if err != nil {
+		return 0, err
+	}
+
+	var partition tree.Datum
+	if err := projection.ForEachColumn().Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+		partition = d
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if partition == tree.DNull {
+		return 0, pgerror.New(pgcode.NullValueNotAllowed, "partition expression evaluated to NULL")
+	}
+	d, ok := partition.(*tree.DInt)
+	if !ok {
+		return 0, errors.AssertionFailedf("expected partition expression to evaluate to INT, found %T", partition)
+	}
+	return int64(*d), nil
+}
+
+// ValidatePartitionExpr verifies that the partition expression is valid for
+// the table and target family: the expression must evaluate to an integer and
+// may only reference the columns of the row being emitted.
+func ValidatePartitionExpr(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	desc catalog.TableDescriptor,
+	target jobspb.ChangefeedTargetSpecification,
+	expr string,
+	includeVirtual bool,
+) error {
+	sc, err := parsePartitionExpr(expr)
+	if err != nil {
+		return err
+	}
+
+	selectors, err := typeCheckRowExprs(ctx, execCtx, desc, target, sc, includeVirtual)
+	if err != nil {
+		return err
+	}
+	if len(selectors) != 1 {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"partition expression %q must evaluate to a single value", expr)
+	}
+	if typ := selectors[0].ResolvedType(); typ.Family() != types.IntFamily {
+		return pgerror.Newf(pgcode.DatatypeMismatch,
+			"partition expression %q must evaluate to INT, found %s", expr, typ.SQLString())
+	}
+	return nil
+}
+
+// KeyEvaluator evaluates a scalar expression over the columns of a row in
+// order to compute the key of the messages of the row, in place of its primary
+// key.
+type KeyEvaluator struct {
+	*Evaluator
+	// desc is the descriptor of the last projection, and keyDesc its
+	// counterpart whose key column is the value of the expression.
+	desc, keyDesc *cdcevent.EventDescriptor
+}
+
+// NewKeyEvaluator returns KeyEvaluator configured to evaluate specified key
+// expression.
+func NewKeyEvaluator(evalCtx *eval.Context, expr string) (*KeyEvaluator, error) {
+	sc, err := parseKeyExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewEvaluator(evalCtx, sc, nil /* ie */)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyEvaluator{Evaluator: e}, nil
+}
+
+// Key evaluates key expression against the row and returns the row whose only
+// key column is the resulting value, which is valid until the next call.
+func (e *KeyEvaluator) Key(
+	ctx context.Context, row cdcevent.Row, mvccTS hlc.Timestamp,
+) (cdcevent.Row, error) {
+	projection, err := e.Projection(ctx, row, mvccTS, cdcevent.Row{})
+	if err != nil {
+		return cdcevent.Row{}, err
+	}
+	if projection.EventDescriptor != e.desc {
+		e.desc, e.keyDesc = projection.EventDescriptor, projection.EventDescriptor.WithValuesAsKey()
+	}
+	projection.EventDescriptor = e.keyDesc
+	return projection, nil
+}
+
+// ValidateKeyExpr verifies that the key expression is valid for the table and
+// target family: the expression must be immutable, so that the changes of a
+// row are keyed alike, and may only reference the columns of the row being
+// emitted.
+func ValidateKeyExpr(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	desc catalog.TableDescriptor,
+	target jobspb.ChangefeedTargetSpecification,
+	expr string,
+	includeVirtual bool,
+) error {
+	sc, err := parseKeyExpr(expr)
+	if err != nil {
+		return err
+	}
+
+	selectors, err := typeCheckRowExprs(ctx, execCtx, desc, target, sc, includeVirtual)
+	if err != nil {
+		return err
+	}
+	if len(selectors) != 1 {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"key expression %q must evaluate to a single value", expr)
+	}
+	if v := exprVolatility(selectors[0]); v > volatility.Immutable {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"key expression %q must be immutable, found %s", expr, v)
+	}
+	return nil
+}
+
+// PathEvaluator evaluates scalar expressions over the columns of a row in
+// order to compute the values used to template the output path of the row.
+type PathEvaluator struct {
+	*Evaluator
+}
+
+// NewPathEvaluator returns PathEvaluator configured to evaluate specified
+// expressions.
+func NewPathEvaluator(evalCtx *eval.Context, exprs []string) (*PathEvaluator, error) {
+	sc, err := parsePathExprs(exprs)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewEvaluator(evalCtx, sc, nil /* ie */)
+	if err != nil {
+		return nil, err
+	}
+	return &PathEvaluator{Evaluator: e}, nil
+}
+
+// NullPathValue is the value of path expressions which evaluate to NULL, which
+// is distinct from the string 'null'.
+const NullPathValue = "__null__"
+
+// Values evaluates path expressions against the updated row and returns the
+// resulting values, formatted as strings. NULL values are returned as
+// NullPathValue.
+func (e *PathEvaluator) Values(
+	ctx context.Context, updatedRow cdcevent.Row, mvccTS hlc.Timestamp,
+) ([]string, error) {
+	projection, err := e.Projection(ctx, updatedRow, mvccTS, cdcevent.Row{})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	if err := projection.ForEachColumn().Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+		if d == tree.DNull {
+			values = append(values, NullPathValue)
+		} else {
+			values = append(values, tree.AsStringWithFlags(d, tree.FmtBareStrings))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ValidatePathExpr verifies that the path expression is valid for the table
+// and target family: the expression must be immutable and may only reference
+// the columns of the row being emitted.
+func ValidatePathExpr(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	desc catalog.TableDescriptor,
+	target jobspb.ChangefeedTargetSpecification,
+	expr string,
+	includeVirtual bool,
+) error {
+	sc, err := parsePathExprs([]string{expr})
+	if err != nil {
+		return err
+	}
+
+	selectors, err := typeCheckRowExprs(ctx, execCtx, desc, target, sc, includeVirtual)
+	if err != nil {
+		return err
+	}
+	if len(selectors) != 1 {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"expression %q must evaluate to a single value", expr)
+	}
+	if v := exprVolatility(selectors[0]); v > volatility.Immutable {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"expression %q must be immutable, found %s", expr, v)
+	}
+	return nil
+}
+
+// typeCheckRowExprs type checks the expressions projected by the select clause
+// against the target, and returns the resulting typed expressions. The
+// expressions may only reference the columns of the row being emitted.
+func typeCheckRowExprs(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	desc catalog.TableDescriptor,
+	target jobspb.ChangefeedTargetSpecification,
+	sc *tree.SelectClause,
+	includeVirtual bool,
+) ([]tree.TypedExpr, error) {
+	requiresPrev, err := SelectClauseRequiresPrev(ctx, *newSemaCtx(), NormalizedSelectClause(*sc))
+	if err != nil {
+		return nil, err
+	}
+	if requiresPrev {
+		return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+			"expression %s may only reference columns of the row", tree.AsString(sc.Exprs))
+	}
+
+	ed, err := newEventDescriptorForTarget(desc, target, schemaTS(execCtx), includeVirtual)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluator, err := NewEvaluator(&execCtx.ExtendedEvalContext().Context, sc, nil /* ie */)
+	if err != nil {
+		return nil, err
+	}
+	if err := evaluator.initEval(ctx, ed); err != nil {
+		return nil, err
+	}
+	if evaluator.evaluator.starProjection {
+		return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+			"expression %s must evaluate to a single value", tree.AsString(sc.Exprs))
+	}
+	return evaluator.evaluator.selectors, nil
+}
+
+// exprVolatility returns the highest volatility of the functions referenced by
+// the expression.
+func exprVolatility(expr tree.TypedExpr) volatility.V {
+	v := volatility.Leakproof
+	_, _ = tree.SimpleVisit(expr, func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+		if fn, ok := expr.(*tree.FuncExpr); ok && fn.ResolvedOverload() != nil {
+			if fnVolatility := fn.ResolvedOverload().Volatility; fnVolatility > v {
+				v = fnVolatility
+			}
+		}
+		return true, expr, nil
+	})
+	return v
+}
+
+// parsePartitionExpr parses partition expression, and returns a select clause
+// projecting that expression.
+func parsePartitionExpr(expr string) (*tree.SelectClause, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, pgerror.Wrapf(err, pgcode.Syntax, "invalid partition expression %q", expr)
+	}
+	return &tree.SelectClause{
+		Exprs: tree.SelectExprs{{Expr: e}},
+	}, nil
+}
+
+// parseKeyExpr parses key expression, and returns a select clause projecting
+// that expression.
+func parseKeyExpr(expr string) (*tree.SelectClause, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, pgerror.Wrapf(err, pgcode.Syntax, "invalid key expression %q", expr)
+	}
+	return &tree.SelectClause{
+		Exprs: tree.SelectExprs{{Expr: e}},
+	}, nil
+}
+
+// parsePathExprs parses path expressions, and returns a select clause
+// projecting those expressions.
+func parsePathExprs(exprs []string) (*tree.SelectClause, error) {
+	sc := &tree.SelectClause{}
+	for _, expr := range exprs {
+		e, err := parser.ParseExpr(expr)
+		if err != nil {
+			return nil, pgerror.Wrapf(err, pgcode.Syntax, "invalid expression %q", expr)
+		}
+		sc.Exprs = append(sc.Exprs, tree.SelectExpr{Expr: e})
+	}
+	return sc, nil
+}
@@ -0,0 +1,321 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"context"
+	"hash/fnv"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionEvaluator(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY, region STRING)")
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+
+	fnv32 := func(s string) int64 {
+		h := fnv.New32()
+		_, _ = h.Write([]byte(s))
+		return int64(h.Sum32())
+	}
+
+	for _, tc := range []struct {
+		name      string
+		expr      string
+		input     []tree.Datum
+		expect    int64
+		expectErr string
+	}{
+		{
+			name:   "column",
+			expr:   "a",
+			input:  []tree.Datum{tree.NewDInt(7), tree.NewDString("us-east")},
+			expect: 7,
+		},
+		{
+			name:   "hash_modulo",
+			expr:   "fnv32(region) % 8",
+			input:  []tree.Datum{tree.NewDInt(1), tree.NewDString("us-east")},
+			expect: fnv32("us-east") % 8,
+		},
+		{
+			name:      "null",
+			expr:      "length(region)",
+			input:     []tree.Datum{tree.NewDInt(1), tree.DNull},
+			expectErr: "partition expression evaluated to NULL",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evalCtx := eval.MakeTestingEvalContext(s.ClusterSettings())
+			e, err := NewPartitionEvaluator(&evalCtx, tc.expr)
+			require.NoError(t, err)
+
+			row := cdcevent.TestingMakeEventRow(desc, 0, makeEncDatumRow(tc.input...), false)
+			partition, err := e.Partition(context.Background(), row, hlc.Timestamp{})
+			if tc.expectErr != "" {
+				require.Regexp(t, tc.expectErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, partition)
+		})
+	}
+}
+
+func TestValidatePartitionExpr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, kvDB := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY, region STRING)")
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+	target := jobspb.ChangefeedTargetSpecification{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           desc.GetID(),
+		StatementTimeName: desc.GetName(),
+	}
+
+	ctx := context.Background()
+	execCfg := s.ExecutorConfig().(sql.ExecutorConfig)
+	p, cleanup := sql.NewInternalPlanner("test",
+		kvDB.NewTxn(ctx, "test-planner"),
+		username.RootUserName(), &sql.MemoryMetrics{}, &execCfg,
+		sessiondatapb.SessionData{
+			Database:   "defaultdb",
+			SearchPath: sessiondata.DefaultSearchPath.GetPathArray(),
+		})
+	defer cleanup()
+	execCtx := p.(sql.JobExecContext)
+
+	for _, tc := range []struct {
+		expr      string
+		expectErr string
+	}{
+		{expr: "fnv32(region) % 8"},
+		{expr: "a % 4"},
+		{expr: "region", expectErr: "must evaluate to INT"},
+		{expr: "fnv32(nope) % 8", expectErr: `column "nope" does not exist`},
+		{expr: "random()::int", expectErr: `function "random" unsupported by CDC`},
+		{expr: "(cdc_prev()->>'a')::int", expectErr: "may only reference columns of the row"},
+		{expr: "a %", expectErr: "invalid partition expression"},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			err := ValidatePartitionExpr(ctx, execCtx, desc, target, tc.expr, false)
+			if tc.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Regexp(t, tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestKeyEvaluator(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY, region STRING)")
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+
+	evalCtx := eval.MakeTestingEvalContext(s.ClusterSettings())
+	e, err := NewKeyEvaluator(&evalCtx, "concat(region, '/', a::STRING)")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		input  []tree.Datum
+		expect tree.Datum
+	}{
+		{
+			input:  []tree.Datum{tree.NewDInt(7), tree.NewDString("us-east")},
+			expect: tree.NewDString("us-east/7"),
+		},
+		{
+			input:  []tree.Datum{tree.NewDInt(8), tree.DNull},
+			expect: tree.NewDString("/8"),
+		},
+	} {
+		row := cdcevent.TestingMakeEventRow(desc, 0, makeEncDatumRow(tc.input...), false)
+		key, err := e.Key(context.Background(), row, hlc.Timestamp{})
+		require.NoError(t, err)
+		var keys []tree.Datum
+		require.NoError(t, key.ForEachKeyColumn().Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+			keys = append(keys, d)
+			return nil
+		}))
+		require.Equal(t, []tree.Datum{tc.expect}, keys)
+	}
+}
+
+func TestValidateKeyExpr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, kvDB := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY, region STRING)")
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+	target := jobspb.ChangefeedTargetSpecification{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           desc.GetID(),
+		StatementTimeName: desc.GetName(),
+	}
+
+	ctx := context.Background()
+	execCfg := s.ExecutorConfig().(sql.ExecutorConfig)
+	p, cleanup := sql.NewInternalPlanner("test",
+		kvDB.NewTxn(ctx, "test-planner"),
+		username.RootUserName(), &sql.MemoryMetrics{}, &execCfg,
+		sessiondatapb.SessionData{
+			Database:   "defaultdb",
+			SearchPath: sessiondata.DefaultSearchPath.GetPathArray(),
+		})
+	defer cleanup()
+	execCtx := p.(sql.JobExecContext)
+
+	for _, tc := range []struct {
+		expr      string
+		expectErr string
+	}{
+		{expr: "region"},
+		{expr: "fnv64(region)"},
+		{expr: "concat(region, '/', a::STRING)"},
+		{expr: "nope", expectErr: `column "nope" does not exist`},
+		{expr: "cdc_prev()->>'region'", expectErr: "may only reference columns of the row"},
+		{expr: "cdc_mvcc_timestamp()", expectErr: "must be immutable, found stable"},
+		{expr: "region ||", expectErr: "invalid key expression"},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			err := ValidateKeyExpr(ctx, execCtx, desc, target, tc.expr, false)
+			if tc.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Regexp(t, tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestPathEvaluator(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY, region STRING)")
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+
+	evalCtx := eval.MakeTestingEvalContext(s.ClusterSettings())
+	e, err := NewPathEvaluator(&evalCtx, []string{"region", "a % 2", "upper(region)"})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		input  []tree.Datum
+		expect []string
+	}{
+		{
+			input:  []tree.Datum{tree.NewDInt(7), tree.NewDString("us-east")},
+			expect: []string{"us-east", "1", "US-EAST"},
+		},
+		{
+			input:  []tree.Datum{tree.NewDInt(8), tree.DNull},
+			expect: []string{NullPathValue, "0", NullPathValue},
+		},
+	} {
+		row := cdcevent.TestingMakeEventRow(desc, 0, makeEncDatumRow(tc.input...), false)
+		values, err := e.Values(context.Background(), row, hlc.Timestamp{})
+		require.NoError(t, err)
+		require.Equal(t, tc.expect, values)
+	}
+}
+
+func TestValidatePathExpr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, kvDB := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY, region STRING)")
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+	target := jobspb.ChangefeedTargetSpecification{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           desc.GetID(),
+		StatementTimeName: desc.GetName(),
+	}
+
+	ctx := context.Background()
+	execCfg := s.ExecutorConfig().(sql.ExecutorConfig)
+	p, cleanup := sql.NewInternalPlanner("test",
+		kvDB.NewTxn(ctx, "test-planner"),
+		username.RootUserName(), &sql.MemoryMetrics{}, &execCfg,
+		sessiondatapb.SessionData{
+			Database:   "defaultdb",
+			SearchPath: sessiondata.DefaultSearchPath.GetPathArray(),
+		})
+	defer cleanup()
+	execCtx := p.(sql.JobExecContext)
+
+	for _, tc := range []struct {
+		expr      string
+		expectErr string
+	}{
+		{expr: "region"},
+		{expr: "lower(region)"},
+		{expr: "a % 4"},
+		{expr: "nope", expectErr: `column "nope" does not exist`},
+		{expr: "cdc_prev()->>'region'", expectErr: "may only reference columns of the row"},
+		{expr: "cdc_mvcc_timestamp()", expectErr: "must be immutable, found stable"},
+		{expr: "statement_timestamp()", expectErr: "must be immutable, found stable"},
+		{expr: "region ||", expectErr: "invalid expression"},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			err := ValidatePathExpr(ctx, execCtx, desc, target, tc.expr, false)
+			if tc.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Regexp(t, tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/errors"
+)
+
+// The columns of the previous row may be referenced individually, by
+// qualifying them with cdc_prev, as in "cdc_prev.status". Unlike cdc_prev(),
+// which materializes the whole previous row as JSONB, such references are
+// bound to indexed variables which follow the columns of the event and of the
+// reference tables; only the previous values of the referenced columns are
+// decoded when the expression is evaluated.
+
+// prevRowSource is the name qualifying the columns of the previous row, unless
+// the target table, or a reference table, of the expression is named so.
+const prevRowSource tree.Name = "cdc_prev"
+
+// sourceNames returns the names, or the aliases, of the target table and of
+// the reference tables of the changefeed expression.
+func sourceNames(sc *tree.SelectClause) []tree.Name {
+	if len(sc.From.Tables) != 1 {
+		return nil
+	}
+	target, joins := splitLookupJoins(sc.From.Tables[0])
+	names := []tree.Name{sourceName(target)}
+	for _, join := range joins {
+		names = append(names, sourceName(join.Right))
+	}
+	return names
+}
+
+// sourceName returns the name, or the alias, of the table expression.
+func sourceName(expr tree.TableExpr) tree.Name {
+	switch t := expr.(type) {
+	case *tree.AliasedTableExpr:
+		if t.As.Alias != "" {
+			return t.As.Alias
+		}
+		return sourceName(t.Expr)
+	case *tree.TableRef:
+		return t.As.Alias
+	case *tree.TableName:
+		return t.ObjectName
+	case *tree.UnresolvedObjectName:
+		return tree.Name(t.Object())
+	}
+	return ""
+}
+
+// isPrevColumnRef returns true if the expression references a column of the
+// previous row, given the names of the tables of the changefeed expression.
+func isPrevColumnRef(expr tree.Expr, sources []tree.Name) bool {
+	n, ok := expr.(*tree.UnresolvedName)
+	if !ok || n.Star || n.NumParts != 2 || tree.Name(n.Parts[1]) != prevRowSource {
+		return false
+	}
+	for _, source := range sources {
+		if source == prevRowSource {
+			return false
+		}
+	}
+	return true
+}
+
+// referencesPrevColumns returns true if the expression references columns of
+// the previous row.
+func referencesPrevColumns(expr tree.Expr, sources []tree.Name) bool {
+	found := false
+	_, _ = tree.SimpleVisit(expr, func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+		found = found || isPrevColumnRef(expr, sources)
+		return !found, expr, nil
+	})
+	return found
+}
+
+// prevColumn returns the indexed variable bound to the previous value of the
+// event column with the specified name.
+func (e *exprEval) prevColumn(name tree.Name) (*tree.IndexedVar, error) {
+	ord, ok := columnOrdinal(e.ResultColumns(), name)
+	if !ok {
+		return nil, errors.WithHintf(
+			pgerror.Newf(pgcode.UndefinedColumn, "column %s.%s does not exist", prevRowSource, name.String()),
+			"object does not exist in table %q, family %q", e.TableName, e.FamilyName)
+	}
+	return e.iVarHelper.IndexedVar(len(e.ResultColumns()) + len(e.rowEvalCtx.lookupRow) + ord), nil
+}
+
+// prevDatumAt returns the previous value of the event column with the
+// specified ordinal; NULL if the row did not exist before the event, or if the
+// previous row is not known.
+func (e *exprEval) prevDatumAt(ord int) (tree.Datum, error) {
+	prevRow := e.rowEvalCtx.prevRow
+	if !prevRow.IsInitialized() || !prevRow.HasValues() || prevRow.IsDeleted() {
+		return tree.DNull, nil
+	}
+	if !prevRow.EqualsVersion(e.EventDescriptor) {
+		// The previous row was decoded with another version of the descriptor,
+		// whose columns may be different.
+		var ok bool
+		if ord, ok = columnOrdinal(prevRow.ResultColumns(), tree.Name(e.ResultColumns()[ord].Name)); !ok {
+			return tree.DNull, nil
+		}
+	}
+	return prevRow.DatumAt(ord)
+}
+
+// columnOrdinal returns the ordinal of the column with the specified name.
+func columnOrdinal(cols []cdcevent.ResultColumn, name tree.Name) (int, bool) {
+	for ord, col := range cols {
+		if col.Name == string(name) {
+			return ord, true
+		}
+	}
+	return 0, false
+}
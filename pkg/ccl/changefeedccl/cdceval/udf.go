@@ -0,0 +1,238 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// User-defined functions (UDFs) are evaluated by the SQL engine, not by CDC:
+// when the changefeed is created, the names of the UDFs invoked by the
+// changefeed expression are fully qualified (see qualifyUserDefinedFunction),
+// and the evaluator resolves such names to overloads whose implementation
+// invokes the function via the internal executor (see udfResolver).
+//
+// Only immutable UDFs are supported: their results do not depend on the time
+// at which they are invoked, nor on the session which invokes them.
+
+var udfFnProps = &tree.FunctionProperties{
+	Category: "User-defined function",
+}
+
+// qualifyUserDefinedFunction replaces the name of the function invoked by the
+// expression with its fully qualified name if it is a user-defined function,
+// so that it resolves to the same function regardless of the session which
+// evaluates the expression. The function is resolved by the resolver of the
+// session creating the changefeed, whose current database is database.
+func qualifyUserDefinedFunction(
+	ctx context.Context,
+	resolver tree.FunctionReferenceResolver,
+	searchPath tree.SearchPath,
+	database string,
+	fn *tree.FuncExpr,
+) error {
+	name, ok := fn.Func.FunctionReference.(*tree.UnresolvedName)
+	if !ok || resolver == nil {
+		return nil
+	}
+	if _, isCDCFn := cdcFunctions[strings.ToLower(name.Parts[0])]; isCDCFn && name.NumParts == 1 {
+		return nil
+	}
+	funcDef, err := resolver.ResolveFunction(ctx, name, searchPath)
+	if err != nil {
+		// Unknown functions are reported when the expression is validated.
+		return nil //nolint:returnerrcheck
+	}
+
+	var schema string
+	for _, o := range funcDef.Overloads {
+		if !o.IsUDF {
+			// Builtin functions take precedence over user-defined functions.
+			return nil
+		}
+		if schema != "" && o.Schema != schema {
+			return pgerror.Newf(pgcode.AmbiguousFunction,
+				"user-defined function %s is defined in schemas %s and %s; qualify its name",
+				funcDef.Name, schema, o.Schema)
+		}
+		schema = o.Schema
+	}
+	if schema == "" {
+		return nil
+	}
+	fn.Func.FunctionReference = &tree.UnresolvedName{
+		NumParts: 3,
+		Parts:    tree.NameParts{funcDef.Name, schema, database},
+	}
+	return nil
+}
+
+// invokesUserDefinedFunctions returns true if the normalized expression
+// invokes user-defined functions, whose names are fully qualified, or which
+// are already resolved.
+func invokesUserDefinedFunctions(expr tree.Expr) bool {
+	found := false
+	_, _ = tree.SimpleVisit(expr, func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+		if fn, ok := expr.(*tree.FuncExpr); ok {
+			switch ref := fn.Func.FunctionReference.(type) {
+			case *tree.UnresolvedName:
+				found = ref.NumParts == 3
+			case *tree.ResolvedFunctionDefinition:
+				found = len(ref.Overloads) > 0 && ref.Overloads[0].Category == udfFnProps.Category
+			}
+		}
+		return !found, expr, nil
+	})
+	return found
+}
+
+// udfResolver resolves the fully qualified names of user-defined functions to
+// overloads invoking them via the internal executor.
+type udfResolver struct {
+	ie sqlutil.InternalExecutor
+	// defs memoizes the resolved functions by name.
+	defs map[string]*tree.ResolvedFunctionDefinition
+}
+
+// newUDFResolver returns the resolver of the user-defined functions invoked
+// via the executor, or nil if there is no executor.
+func newUDFResolver(ie sqlutil.InternalExecutor) *udfResolver {
+	if ie == nil {
+		return nil
+	}
+	return &udfResolver{ie: ie, defs: make(map[string]*tree.ResolvedFunctionDefinition)}
+}
+
+// resolve returns the definition of the user-defined function, whose overloads
+// are those of the function.
+func (r *udfResolver) resolve(
+	ctx context.Context, fn *tree.FunctionName,
+) (*tree.ResolvedFunctionDefinition, error) {
+	key := fn.String()
+	if def, ok := r.defs[key]; ok {
+		return def, nil
+	}
+
+	rows, err := r.ie.QueryBufferedEx(ctx, "cdc-udf-signatures", nil, /* txn */
+		udfSessionDataOverride(fn.Catalog()), `
+SELECT p.proargtypes, p.prorettype, p.provolatile, p.proretset
+FROM pg_catalog.pg_proc AS p JOIN pg_catalog.pg_namespace AS n ON n.oid = p.pronamespace
+WHERE n.nspname = $1 AND p.proname = $2`,
+		fn.Schema(), fn.Object())
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving function %s", key)
+	}
+	if len(rows) == 0 {
+		return nil, errors.Wrapf(tree.ErrFunctionUndefined, "unknown function: %s()", key)
+	}
+
+	overloads := make([]tree.Overload, len(rows))
+	for i, row := range rows {
+		if err := r.makeOverload(fn, row, &overloads[i]); err != nil {
+			return nil, err
+		}
+	}
+	// The definition is named after the fully qualified name of the function,
+	// so that the expressions invoking it are still formatted with that name
+	// once the function is resolved.
+	def := tree.QualifyBuiltinFunctionDefinition(
+		tree.NewFunctionDefinition(key, udfFnProps, overloads), fn.Schema())
+	r.defs[key] = def
+	return def, nil
+}
+
+// makeOverload initializes the overload of the user-defined function from its
+// pg_proc row.
+func (r *udfResolver) makeOverload(fn *tree.FunctionName, row tree.Datums, o *tree.Overload) error {
+	argTypes := tree.MustBeDArray(tree.UnwrapDOidWrapper(row[0]))
+	retType, err := udfType(fn, tree.MustBeDOid(row[1]))
+	if err != nil {
+		return err
+	}
+	if tree.MustBeDBool(row[3]) {
+		return pgerror.Newf(pgcode.FeatureNotSupported,
+			"set-returning function %s not supported by CDC", fn)
+	}
+
+	params := make(tree.ArgTypes, argTypes.Len())
+	args := make([]string, argTypes.Len())
+	for i, d := range argTypes.Array {
+		typ, err := udfType(fn, tree.MustBeDOid(d))
+		if err != nil {
+			return err
+		}
+		params[i] = tree.ArgType{Name: fmt.Sprintf("arg%d", i+1), Typ: typ}
+		args[i] = fmt.Sprintf("$%d::%s", i+1, typ.SQLString())
+	}
+	o.Types = params
+	o.ReturnType = tree.FixedReturnType(retType)
+	switch tree.MustBeDString(row[2]) {
+	case "i":
+		o.Volatility = volatility.Immutable
+	case "s":
+		o.Volatility = volatility.Stable
+	default:
+		o.Volatility = volatility.Volatile
+	}
+	// Keep the SQL semantics of functions called on NULL input.
+	o.CalledOnNullInput = true
+
+	query := fmt.Sprintf(`SELECT %s(%s)`, tree.AsString(fn), strings.Join(args, ", "))
+	database := fn.Catalog()
+	o.Fn = func(evalCtx *eval.Context, datums tree.Datums) (tree.Datum, error) {
+		qargs := make([]interface{}, len(datums))
+		for i, d := range datums {
+			qargs[i] = d
+		}
+		row, err := r.ie.QueryRowEx(evalCtx.Ctx(), "cdc-udf", nil, /* txn */
+			udfSessionDataOverride(database), query, qargs...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invoking %s", fn)
+		}
+		if len(row) != 1 {
+			return nil, errors.AssertionFailedf("expected 1 column invoking %s, found %d", fn, len(row))
+		}
+		return row[0], nil
+	}
+	return nil
+}
+
+// udfType returns the type of the argument or of the result of the
+// user-defined function.
+func udfType(fn *tree.FunctionName, typOID *tree.DOid) (*types.T, error) {
+	typ, ok := types.OidToType[typOID.Oid]
+	if !ok {
+		return nil, pgerror.Newf(pgcode.FeatureNotSupported,
+			"function %s not supported by CDC: type %d of its arguments or result is not supported",
+			fn, typOID.Oid)
+	}
+	return typ, nil
+}
+
+// udfSessionDataOverride returns the session data of the queries resolving and
+// invoking the user-defined functions of the database.
+func udfSessionDataOverride(database string) sessiondata.InternalExecutorOverride {
+	return sessiondata.InternalExecutorOverride{
+		User:     username.NodeUserName(),
+		Database: database,
+	}
+}
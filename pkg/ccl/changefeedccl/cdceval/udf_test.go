@@ -0,0 +1,155 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdceval
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserDefinedFunctions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, kvDB := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.ExecMultiple(t,
+		`CREATE TABLE foo (a INT PRIMARY KEY, s STRING)`,
+		`CREATE FUNCTION twice(x INT) RETURNS INT IMMUTABLE LANGUAGE SQL AS 'SELECT x * 2'`,
+		`CREATE FUNCTION is_even(x INT) RETURNS BOOL IMMUTABLE LANGUAGE SQL AS 'SELECT x % 2 = 0'`,
+		`CREATE FUNCTION greet(s STRING) RETURNS STRING IMMUTABLE LANGUAGE SQL AS 'SELECT ''hello '' || s'`,
+		`CREATE FUNCTION vol(x INT) RETURNS INT LANGUAGE SQL AS 'SELECT x'`,
+		`CREATE FUNCTION stab(x INT) RETURNS INT STABLE LANGUAGE SQL AS 'SELECT x'`,
+		`CREATE SCHEMA other`,
+		`CREATE FUNCTION other.twice(x INT) RETURNS INT IMMUTABLE LANGUAGE SQL AS 'SELECT x * 3'`,
+		`CREATE FUNCTION other.thrice(x INT) RETURNS INT IMMUTABLE LANGUAGE SQL AS 'SELECT x * 3'`,
+	)
+
+	desc := cdctest.GetHydratedTableDescriptor(t, s.ExecutorConfig(), "foo")
+	ctx := context.Background()
+	execCfg := s.ExecutorConfig().(sql.ExecutorConfig)
+	p, cleanup := sql.NewInternalPlanner("test",
+		kvDB.NewTxn(ctx, "test-planner"),
+		username.RootUserName(), &sql.MemoryMetrics{}, &execCfg,
+		sessiondatapb.SessionData{
+			Database:   "defaultdb",
+			SearchPath: sessiondata.DefaultSearchPath.GetPathArray(),
+		})
+	defer cleanup()
+	execCtx := p.(sql.JobExecContext)
+
+	// The events of the foo table, and the projections they are expected to
+	// produce; nil if the event is filtered out.
+	type event struct {
+		a      int
+		s      string
+		expect map[string]string
+	}
+
+	for _, tc := range []struct {
+		name       string
+		stmt       string
+		expectErr  string
+		expectStmt string
+		events     []event
+	}{
+		{
+			name: "projection and filter",
+			stmt: "SELECT a, twice(a) AS d FROM foo WHERE is_even(a)",
+			expectStmt: fmt.Sprintf(
+				"SELECT a, defaultdb.public.twice(a) AS d FROM [%d AS foo] WHERE defaultdb.public.is_even(a)",
+				desc.GetID()),
+			events: []event{
+				{a: 2, s: "x", expect: map[string]string{"a": "2", "d": "4"}},
+				{a: 3, s: "y"},
+			},
+		},
+		{
+			name: "qualified function",
+			stmt: "SELECT a, other.thrice(a) AS t, greet(s) AS g FROM foo",
+			expectStmt: fmt.Sprintf(
+				"SELECT a, defaultdb.other.thrice(a) AS t, defaultdb.public.greet(s) AS g FROM [%d AS foo]",
+				desc.GetID()),
+			events: []event{
+				{a: 1, s: "bob", expect: map[string]string{"a": "1", "t": "3", "g": "hello bob"}},
+			},
+		},
+		{
+			name:      "volatile function",
+			stmt:      "SELECT vol(a) FROM foo",
+			expectErr: `user-defined function "defaultdb.public.vol" unsupported by CDC`,
+		},
+		{
+			name:      "stable function",
+			stmt:      "SELECT * FROM foo WHERE stab(a) > 1",
+			expectErr: `user-defined function "defaultdb.public.stab" unsupported by CDC`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, err := ParseChangefeedExpression(tc.stmt)
+			require.NoError(t, err)
+			target := jobspb.ChangefeedTargetSpecification{
+				TableID:           desc.GetID(),
+				StatementTimeName: desc.GetName(),
+			}
+
+			_, _, err = NormalizeAndValidateSelectForTarget(
+				ctx, execCtx, desc, target, sc, nil, false, false)
+			if tc.expectErr != "" {
+				require.Regexp(t, tc.expectErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectStmt, AsStringUnredacted(sc))
+
+			// Evaluate the normalized expression, as changefeed aggregators do.
+			sc, err = ParseChangefeedExpression(AsStringUnredacted(sc))
+			require.NoError(t, err)
+			evalCtx := eval.MakeTestingEvalContext(s.ClusterSettings())
+			e, err := NewEvaluator(&evalCtx, sc, s.InternalExecutor().(sqlutil.InternalExecutor))
+			require.NoError(t, err)
+
+			mvccTS := s.Clock().Now()
+			for _, ev := range tc.events {
+				row := cdcevent.TestingMakeEventRow(desc, 0,
+					makeEncDatumRow(tree.NewDInt(tree.DInt(ev.a)), tree.NewDString(ev.s)), false)
+				matches, err := e.MatchesFilter(ctx, row, mvccTS, row)
+				require.NoError(t, err)
+				if ev.expect == nil {
+					require.False(t, matches, "event %d", ev.a)
+					continue
+				}
+				require.True(t, matches, "event %d", ev.a)
+				projection, err := e.Projection(ctx, row, mvccTS, row)
+				require.NoError(t, err)
+				require.Equal(t, ev.expect, slurpValues(t, projection))
+			}
+		})
+	}
+}
@@ -28,10 +28,13 @@ import (
 // NormalizeAndValidateSelectForTarget normalizes select expression and verifies
 // expression is valid for a table and target family.  includeVirtual indicates
 // if virtual columns should be considered valid in the expressions.
+// lookupDescs are the descriptors of the reference tables joined by the select
+// expression, in the order in which they are joined (see LookupTables).
 // Normalization steps include:
 //   * Table name replaces with table reference
 //   * UDTs values replaced with their physical representation (to keep expression stable
 //     across data type changes).
+//   * User defined function names replaced with their fully qualified names.
 // The normalized (updated) select clause expression can be serialized into protocol
 // buffer using cdceval.AsStringUnredacted.
 func NormalizeAndValidateSelectForTarget(
@@ -40,6 +43,7 @@ func NormalizeAndValidateSelectForTarget(
 	desc catalog.TableDescriptor,
 	target jobspb.ChangefeedTargetSpecification,
 	sc *tree.SelectClause,
+	lookupDescs []catalog.TableDescriptor,
 	includeVirtual bool,
 	splitColFams bool,
 ) (n NormalizedSelectClause, _ jobspb.ChangefeedTargetSpecification, _ error) {
@@ -72,7 +76,8 @@ func NormalizeAndValidateSelectForTarget(
 
 	// Perform normalization.
 	var err error
-	normalized, err := normalizeSelectClause(ctx, *execCtx.SemaCtx(), sc, desc)
+	normalized, err := normalizeSelectClause(
+		ctx, *execCtx.SemaCtx(), execCtx.SessionData().Database, sc, desc, lookupDescs)
 	if err != nil {
 		return n, target, err
 	}
@@ -81,6 +86,9 @@ func NormalizeAndValidateSelectForTarget(
 		desc:         desc,
 		splitColFams: splitColFams,
 	}
+	for _, lookup := range LookupTables(sc) {
+		columnVisitor.lookupAliases = append(columnVisitor.lookupAliases, lookup.(*tree.TableRef).As.Alias)
+	}
 
 	err = columnVisitor.FindColumnFamilies(normalized)
 	if err != nil {
@@ -109,7 +117,7 @@ func NormalizeAndValidateSelectForTarget(
 
 	// Construct and initialize evaluator.  This performs some static checks,
 	// and (importantly) type checks expressions.
-	evaluator, err := NewEvaluator(evalCtx, sc)
+	evaluator, err := NewEvaluator(evalCtx, sc, execCfg.InternalExecutor)
 	if err != nil {
 		return n, target, err
 	}
@@ -236,35 +244,51 @@ func (n NormalizedSelectClause) Clause() *tree.SelectClause {
 }
 
 // normalizeSelectClause performs normalization step for select clause.
-// Returns normalized select clause.
+// Returns normalized select clause. The database is the current database of
+// the session whose sema context is specified.
 func normalizeSelectClause(
 	ctx context.Context,
 	semaCtx tree.SemaContext,
+	database string,
 	sc *tree.SelectClause,
 	desc catalog.TableDescriptor,
+	lookupDescs []catalog.TableDescriptor,
 ) (normalizedSelectClause NormalizedSelectClause, _ error) {
 	// Turn FROM clause to table reference.
 	// Note: must specify AliasClause for TableRef expression; otherwise we
 	// won't be able to deserialize string representation (grammar requires
 	// "select ... from [table_id as alias]")
+	from, joins := splitLookupJoins(sc.From.Tables[0])
 	var alias tree.AliasClause
-	switch t := sc.From.Tables[0].(type) {
+	switch t := from.(type) {
 	case *tree.AliasedTableExpr:
 		alias = t.As
 	case tree.TablePattern:
 	default:
 		// This is verified by sql.y -- but be safe.
-		return normalizedSelectClause, errors.AssertionFailedf("unexpected table expression type %T",
-			sc.From.Tables[0])
+		return normalizedSelectClause, errors.AssertionFailedf("unexpected table expression type %T", from)
 	}
 
 	if alias.Alias == "" {
 		alias.Alias = tree.Name(desc.GetName())
 	}
-	sc.From.Tables[0] = &tree.TableRef{
+	ref := &tree.TableRef{
 		TableID: int64(desc.GetID()),
 		As:      alias,
 	}
+	if len(joins) == 0 {
+		sc.From.Tables[0] = ref
+	} else {
+		joins[0].Left = ref
+	}
+	// Likewise, turn the joined reference tables to table references.
+	if err := normalizeLookupJoins(joins, lookupDescs, alias.Alias); err != nil {
+		return normalizedSelectClause, err
+	}
+
+	// User defined functions are resolved by the session creating the
+	// changefeed, and qualified so that they can be resolved without it.
+	sessionFunctionResolver := semaCtx.FunctionResolver
 
 	// Setup sema ctx to handle cdc expressions. We want to make sure we only
 	// override some properties, while keeping other properties (type resolver)
@@ -287,9 +311,17 @@ func normalizeSelectClause(
 		OIDs: make(map[oid.Oid]struct{}),
 	}
 
-	stmt, err := tree.SimpleStmtVisit(sc, func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
-		// Replace type references with resolved type.
+	normalizeExpr := func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+		// Replace type references with resolved type, and qualify the names of
+		// the user defined functions.
 		switch e := expr.(type) {
+		case *tree.FuncExpr:
+			if err := qualifyUserDefinedFunction(
+				ctx, sessionFunctionResolver, semaCtx.SearchPath, database, e,
+			); err != nil {
+				return false, expr, err
+			}
+
 		case *tree.AnnotateTypeExpr:
 			typ, udt, err := resolveType(e.Type)
 			if err != nil {
@@ -317,11 +349,16 @@ func normalizeSelectClause(
 		// Collect resolved type OIDs.
 		recurse, newExpr = v.VisitPre(expr)
 		return recurse, newExpr, nil
-	})
+	}
 
+	stmt, err := tree.SimpleStmtVisit(sc, normalizeExpr)
 	if err != nil {
 		return normalizedSelectClause, err
 	}
+	// The join conditions are not visited as part of the statement.
+	if err := visitJoinConditions(sc, normalizeExpr); err != nil {
+		return normalizedSelectClause, err
+	}
 	switch t := stmt.(type) {
 	case *tree.SelectClause:
 		normalizedSelectClause = NormalizedSelectClause(*t)
@@ -354,12 +391,13 @@ func normalizeSelectClause(
 type checkForPrevVisitor struct {
 	semaCtx   tree.SemaContext
 	ctx       context.Context
+	sources   []tree.Name // names of the tables of the select clause.
 	foundPrev bool
 }
 
 // VisitPre implements the Visitor interface.
 func (v *checkForPrevVisitor) VisitPre(expr tree.Expr) (bool, tree.Expr) {
-	if exprRequiresPreviousValue(v.ctx, v.semaCtx, expr) {
+	if exprRequiresPreviousValue(v.ctx, v.semaCtx, expr) || isPrevColumnRef(expr, v.sources) {
 		v.foundPrev = true
 		// no need to keep recursing
 		return false, expr
@@ -373,14 +411,15 @@ func (v *checkForPrevVisitor) VisitPost(e tree.Expr) tree.Expr {
 }
 
 // exprRequiresPreviousValue returns true if the top-level expression
-// is a function call that cdc implements using the diff from a rangefeed.
+// is a function call that cdc implements using the diff from a rangefeed:
+// cdc_prev(), or event_op() which tells inserts from updates.
 func exprRequiresPreviousValue(ctx context.Context, semaCtx tree.SemaContext, e tree.Expr) bool {
 	if f, ok := e.(*tree.FuncExpr); ok {
 		funcDef, err := f.Func.Resolve(ctx, semaCtx.SearchPath, semaCtx.FunctionResolver)
 		if err != nil {
 			return false
 		}
-		return funcDef.Name == "cdc_prev"
+		return funcDef.Name == "cdc_prev" || funcDef.Name == eventOpFnName
 	}
 	return false
 }
@@ -390,25 +429,61 @@ func exprRequiresPreviousValue(ctx context.Context, semaCtx tree.SemaContext, e
 func SelectClauseRequiresPrev(
 	ctx context.Context, semaCtx tree.SemaContext, sc NormalizedSelectClause,
 ) (bool, error) {
-	c := checkForPrevVisitor{semaCtx: semaCtx, ctx: ctx}
-	_, err := tree.SimpleStmtVisit(sc.Clause(), func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+	c := checkForPrevVisitor{semaCtx: semaCtx, ctx: ctx, sources: sourceNames(sc.Clause())}
+	visit := func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
 		recurse, newExpr = c.VisitPre(expr)
 		return recurse, newExpr, nil
-	})
+	}
+	if _, err := tree.SimpleStmtVisit(sc.Clause(), visit); err != nil {
+		return false, err
+	}
+	err := visitJoinConditions(sc.Clause(), visit)
 	return c.foundPrev, err
 }
 
+// visitJoinConditions visits the ON conditions of the lookup joins of the
+// select clause, which tree.SimpleStmtVisit does not visit, replacing them
+// with the visited expressions.
+func visitJoinConditions(sc *tree.SelectClause, fn tree.SimpleVisitFn) error {
+	if len(sc.From.Tables) != 1 {
+		return nil
+	}
+	_, joins := splitLookupJoins(sc.From.Tables[0])
+	for _, join := range joins {
+		on, ok := join.Cond.(*tree.OnJoinCond)
+		if !ok {
+			continue
+		}
+		expr, err := tree.SimpleVisit(on.Expr, fn)
+		if err != nil {
+			return err
+		}
+		on.Expr = expr
+	}
+	return nil
+}
+
 type checkColumnsVisitor struct {
 	err          error
 	desc         catalog.TableDescriptor
 	columns      []descpb.ColumnID
 	seenStar     bool
 	splitColFams bool
+	// lookupAliases are the aliases of the reference tables, whose columns
+	// are not columns of desc.
+	lookupAliases []tree.Name
 }
 
 func (c *checkColumnsVisitor) VisitCols(expr tree.Expr) (bool, tree.Expr) {
 	switch e := expr.(type) {
 	case *tree.UnresolvedName:
+		if e.NumParts == 2 {
+			for _, alias := range c.lookupAliases {
+				if tree.Name(e.Parts[1]) == alias {
+					return false, expr
+				}
+			}
+		}
 		vn, err := e.NormalizeVarName()
 		if err != nil {
 			c.err = err
@@ -432,9 +507,12 @@ func (c *checkColumnsVisitor) VisitCols(expr tree.Expr) (bool, tree.Expr) {
 }
 
 func (c *checkColumnsVisitor) FindColumnFamilies(sc NormalizedSelectClause) error {
-	_, err := tree.SimpleStmtVisit(sc.Clause(), func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+	visit := func(expr tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
 		recurse, newExpr = c.VisitCols(expr)
 		return recurse, newExpr, nil
-	})
-	return err
+	}
+	if _, err := tree.SimpleStmtVisit(sc.Clause(), visit); err != nil {
+		return err
+	}
+	return visitJoinConditions(sc.Clause(), visit)
 }
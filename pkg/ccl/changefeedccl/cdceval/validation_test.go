@@ -243,7 +243,7 @@ func TestNormalizeAndValidate(t *testing.T) {
 				StatementTimeName: tc.desc.GetName(),
 			}
 
-			_, _, err = NormalizeAndValidateSelectForTarget(ctx, execCtx, tc.desc, target, sc, false, tc.splitColFams)
+			_, _, err = NormalizeAndValidateSelectForTarget(ctx, execCtx, tc.desc, target, sc, nil, false, tc.splitColFams)
 			if tc.expectErr != "" {
 				require.Regexp(t, tc.expectErr, err)
 				return
@@ -321,6 +321,12 @@ func TestSelectClauseRequiresPrev(t *testing.T) {
 			stmt:   "SELECT CDC_PREV() from foo",
 			expect: true,
 		},
+		{
+			name:   "event operation",
+			desc:   descs[`foo`],
+			stmt:   "SELECT * FROM foo WHERE event_op() = 'update'",
+			expect: true,
+		},
 		{
 			name:   "contains misleading substring",
 			desc:   descs[`foo`],
@@ -333,6 +339,24 @@ func TestSelectClauseRequiresPrev(t *testing.T) {
 			stmt:   "SELECT * FROM cdc_prev",
 			expect: false,
 		},
+		{
+			name:   "column of the previous row",
+			desc:   descs[`foo`],
+			stmt:   "SELECT id, cdc_prev.s FROM foo",
+			expect: true,
+		},
+		{
+			name:   "column of the previous row in the predicate",
+			desc:   descs[`foo`],
+			stmt:   "SELECT * FROM foo WHERE cdc_prev.s != s",
+			expect: true,
+		},
+		{
+			name:   "column of table named cdc_prev",
+			desc:   descs[`cdc_prev`],
+			stmt:   "SELECT cdc_prev.s FROM cdc_prev",
+			expect: false,
+		},
 		{
 			name:   "misleading column name",
 			desc:   descs[`misleading_column_name`],
@@ -347,7 +371,7 @@ func TestSelectClauseRequiresPrev(t *testing.T) {
 				TableID:           tc.desc.GetID(),
 				StatementTimeName: tc.desc.GetName(),
 			}
-			normalized, _, err := NormalizeAndValidateSelectForTarget(ctx, execCtx, tc.desc, target, sc, false, false)
+			normalized, _, err := NormalizeAndValidateSelectForTarget(ctx, execCtx, tc.desc, target, sc, nil, false, false)
 			require.NoError(t, err)
 			actual, err := SelectClauseRequiresPrev(context.Background(), *execCtx.SemaCtx(), normalized)
 			require.NoError(t, err)
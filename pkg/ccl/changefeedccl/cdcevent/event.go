@@ -282,6 +282,33 @@ func (d *EventDescriptor) ResultColumns() []ResultColumn {
 	return d.cols
 }
 
+// KeyColumns returns the primary key columns in this descriptor.
+func (d *EventDescriptor) KeyColumns() []ResultColumn {
+	return d.columnsAt(d.keyCols)
+}
+
+// ValueColumns returns the column family columns in this descriptor.
+func (d *EventDescriptor) ValueColumns() []ResultColumn {
+	return d.columnsAt(d.valueCols)
+}
+
+// WithValuesAsKey returns a copy of the descriptor whose key columns are its
+// value columns, such as the descriptor of the projection of a key expression,
+// whose values key the messages of the rows in place of their primary keys.
+func (d *EventDescriptor) WithValuesAsKey() *EventDescriptor {
+	kd := *d
+	kd.keyCols = kd.valueCols
+	return &kd
+}
+
+func (d *EventDescriptor) columnsAt(colIndexes []int) []ResultColumn {
+	cols := make([]ResultColumn, len(colIndexes))
+	for i, colIdx := range colIndexes {
+		cols[i] = d.cols[colIdx]
+	}
+	return cols
+}
+
 // EqualsVersion returns true if this descriptor equals other.
 func (d *EventDescriptor) EqualsVersion(other *EventDescriptor) bool {
 	return d.TableID == other.TableID &&
@@ -0,0 +1,487 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+// Package cdcnats implements the minimal client of the NATS protocol with
+// which the changefeeds publish to NATS JetStream.
+package cdcnats
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/build"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// DefaultPingInterval is the default interval at which the client pings the
+	// server, like the official clients.
+	DefaultPingInterval = 2 * time.Minute
+	// DefaultMaxPingsOut is the default number of pings which the server may
+	// leave unanswered before the client fails the connection.
+	DefaultMaxPingsOut = 2
+)
+
+// handshakeTimeout bounds the time it takes to connect to a NATS server.
+const handshakeTimeout = 10 * time.Second
+
+// maxControlLineSize is the maximum size of the control lines of the NATS
+// protocol, which is also the limit of the servers.
+const maxControlLineSize = 4096
+
+// serverInfo is the part of the INFO message of NATS servers used by the
+// client.
+type serverInfo struct {
+	TLSRequired bool  `json:"tls_required"`
+	MaxPayload  int64 `json:"max_payload"`
+	Headers     bool  `json:"headers"`
+}
+
+// connectOptions is the CONNECT message of the NATS protocol.
+type connectOptions struct {
+	Verbose      bool   `json:"verbose"`
+	Pedantic     bool   `json:"pedantic"`
+	TLSRequired  bool   `json:"tls_required"`
+	Name         string `json:"name"`
+	Lang         string `json:"lang"`
+	Version      string `json:"version"`
+	Protocol     int    `json:"protocol"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	User         string `json:"user,omitempty"`
+	Pass         string `json:"pass,omitempty"`
+	AuthToken    string `json:"auth_token,omitempty"`
+}
+
+// pubAck is the reply of JetStream to a published message.
+type pubAck struct {
+	Stream    string `json:"stream"`
+	Seq       uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate"`
+	Error     *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// DialConfig configures the connection to a NATS server.
+type DialConfig struct {
+	Addr string
+	// User and Password authenticate the client, or Token when the password
+	// is not set.
+	User, Password, Token string
+	// TLSConfig is set when the connection uses TLS.
+	TLSConfig *tls.Config
+	// PingInterval is the interval at which the client pings the server, and
+	// MaxPingsOut the number of pings which the server may leave unanswered
+	// before the client fails the connection, DefaultMaxPingsOut if it is not
+	// set. The client does not ping the server if PingInterval is not set.
+	PingInterval time.Duration
+	MaxPingsOut  int
+}
+
+// AckFunc is called with the result of a publication: nil once JetStream
+// stored the message, or the error which prevented it.
+type AckFunc func(error)
+
+type pendingAck struct {
+	subject string
+	onAck   AckFunc
+}
+
+// Conn is a connection to a NATS server, which publishes messages to
+// JetStream and waits for their acknowledgements. The acknowledgements are
+// received on a wildcard subscription to an inbox, so that the messages can be
+// published without waiting for the acknowledgements of the previous ones.
+//
+// The messages published on a connection are stored by JetStream in the order
+// in which they were published. The client pings the server periodically, so
+// that a server which stops responding fails the connection even when nothing
+// is published.
+type Conn struct {
+	conn  net.Conn
+	cfg   DialConfig
+	info  serverInfo
+	inbox string
+	// done is closed when the connection is closed and all the pending
+	// acknowledgements were failed.
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu struct {
+		syncutil.Mutex
+		w       *bufio.Writer
+		nextID  uint64
+		pending map[string]pendingAck
+		// pingsOut is the number of pings which the server did not answer.
+		pingsOut int
+		// err is set once the connection failed, and fails the publications.
+		err error
+	}
+}
+
+// Dial connects to a NATS server.
+func Dial(ctx context.Context, cfg DialConfig) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to nats server %s", cfg.Addr)
+	}
+	c, r, err := handshake(ctx, conn, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "connecting to nats server %s", cfg.Addr)
+	}
+	c.wg.Add(1)
+	go c.readLoop(r)
+	if cfg.PingInterval > 0 {
+		c.wg.Add(1)
+		go c.pingLoop()
+	}
+	return c, nil
+}
+
+// handshake initializes a connection to a NATS server, and returns the reader
+// of the connection.
+func handshake(ctx context.Context, conn net.Conn, cfg DialConfig) (*Conn, *bufio.Reader, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	r := bufio.NewReaderSize(conn, maxControlLineSize)
+	line, err := readLine(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	op, args := splitOp(line)
+	if op != "INFO" {
+		return nil, nil, errors.Errorf("expected INFO from nats server, got %q", line)
+	}
+	c := &Conn{cfg: cfg, done: make(chan struct{})}
+	if c.cfg.MaxPingsOut <= 0 {
+		c.cfg.MaxPingsOut = DefaultMaxPingsOut
+	}
+	if err := json.Unmarshal([]byte(args), &c.info); err != nil {
+		return nil, nil, errors.Wrap(err, "parsing INFO of nats server")
+	}
+
+	if cfg.TLSConfig != nil {
+		tlsConfig := cfg.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(cfg.Addr)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, nil, errors.Wrap(err, "TLS handshake")
+		}
+		conn = tlsConn
+		r = bufio.NewReaderSize(conn, maxControlLineSize)
+	} else if c.info.TLSRequired {
+		return nil, nil, errors.New("nats server requires TLS")
+	}
+	c.conn = conn
+
+	var inbox [8]byte
+	if _, err := rand.Read(inbox[:]); err != nil {
+		return nil, nil, err
+	}
+	c.inbox = "_INBOX." + hex.EncodeToString(inbox[:]) + "."
+	c.mu.w = bufio.NewWriter(conn)
+	c.mu.pending = make(map[string]pendingAck)
+
+	connect := connectOptions{
+		TLSRequired: cfg.TLSConfig != nil,
+		Name:        "CockroachDB",
+		Lang:        "go",
+		Version:     build.BinaryVersion(),
+		Protocol:    1,
+		// The servers which support headers reply to the messages published
+		// to the subjects of no stream instead of letting them time out.
+		Headers:      c.info.Headers,
+		NoResponders: c.info.Headers,
+		User:         cfg.User,
+		Pass:         cfg.Password,
+		AuthToken:    cfg.Token,
+	}
+	connectJSON, err := json.Marshal(connect)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(c.mu.w, "CONNECT %s\r\nPING\r\n", connectJSON)
+	if err := c.mu.w.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	// The server replies to the PING once it processed the CONNECT, or fails
+	// the connection if the client was not authorized.
+	for waiting := true; waiting; {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch op, args := splitOp(line); op {
+		case "PONG":
+			waiting = false
+		case "+OK", "INFO":
+		case "PING":
+			if _, err := io.WriteString(conn, "PONG\r\n"); err != nil {
+				return nil, nil, err
+			}
+		case "-ERR":
+			return nil, nil, errors.Errorf("nats server error: %s", args)
+		default:
+			return nil, nil, errors.Errorf("unexpected message from nats server: %q", line)
+		}
+	}
+
+	fmt.Fprintf(c.mu.w, "SUB %s* 1\r\n", c.inbox)
+	if err := c.mu.w.Flush(); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, nil, err
+	}
+	return c, r, nil
+}
+
+// readLine reads a control line of the NATS protocol.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", errors.New("nats control line too long")
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// splitOp splits a control line of the NATS protocol into its operation and
+// its arguments.
+func splitOp(line string) (op string, args string) {
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return strings.ToUpper(line[:i]), strings.TrimSpace(line[i+1:])
+	}
+	return strings.ToUpper(line), ""
+}
+
+// Publish publishes a message to a subject. The message is buffered until the
+// next call to Flush, and onAck is called once JetStream acknowledged it or
+// the connection failed.
+func (c *Conn) Publish(subject string, data []byte, onAck AckFunc) error {
+	if c.info.MaxPayload > 0 && int64(len(data)) > c.info.MaxPayload {
+		return errors.Errorf("message of %d bytes exceeds the maximum payload of the nats server of %d bytes",
+			len(data), c.info.MaxPayload)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.err != nil {
+		return c.mu.err
+	}
+	c.mu.nextID++
+	reply := c.inbox + strconv.FormatUint(c.mu.nextID, 36)
+	fmt.Fprintf(c.mu.w, "PUB %s %s %d\r\n", subject, reply, len(data))
+	_, _ = c.mu.w.Write(data)
+	if _, err := c.mu.w.WriteString("\r\n"); err != nil {
+		return c.failLocked(err)
+	}
+	c.mu.pending[reply] = pendingAck{subject: subject, onAck: onAck}
+	return nil
+}
+
+// Flush sends the buffered messages to the server.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.err != nil {
+		return c.mu.err
+	}
+	if err := c.mu.w.Flush(); err != nil {
+		return c.failLocked(err)
+	}
+	return nil
+}
+
+// Failed returns the error of the connection if it failed.
+func (c *Conn) Failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.err
+}
+
+// failLocked records the failure of the connection and closes it, which makes
+// readLoop fail the pending acknowledgements.
+func (c *Conn) failLocked(err error) error {
+	if c.mu.err == nil {
+		c.mu.err = errors.Wrap(err, "nats connection failed")
+		_ = c.conn.Close()
+	}
+	return c.mu.err
+}
+
+// pingLoop pings the server at the ping interval, and fails the connection
+// once the server left too many pings unanswered.
+func (c *Conn) pingLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.mu.err == nil {
+				if c.mu.pingsOut >= c.cfg.MaxPingsOut {
+					_ = c.failLocked(errors.Errorf("nats server did not answer %d pings", c.mu.pingsOut))
+				} else if _, err := c.mu.w.WriteString("PING\r\n"); err != nil {
+					_ = c.failLocked(err)
+				} else if err := c.mu.w.Flush(); err != nil {
+					_ = c.failLocked(err)
+				} else {
+					c.mu.pingsOut++
+				}
+			}
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readLoop reads the messages of the server until the connection fails.
+func (c *Conn) readLoop(r *bufio.Reader) {
+	defer c.wg.Done()
+	defer close(c.done)
+	err := c.read(r)
+
+	c.mu.Lock()
+	err = c.failLocked(err)
+	pending := c.mu.pending
+	c.mu.pending = nil
+	c.mu.Unlock()
+	for _, p := range pending {
+		p.onAck(err)
+	}
+}
+
+func (c *Conn) read(r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		op, args := splitOp(line)
+		switch op {
+		case "PING":
+			c.mu.Lock()
+			_, _ = c.mu.w.WriteString("PONG\r\n")
+			err := c.mu.w.Flush()
+			c.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		case "PONG":
+			c.mu.Lock()
+			c.mu.pingsOut = 0
+			c.mu.Unlock()
+		case "+OK", "INFO":
+		case "-ERR":
+			return errors.Errorf("nats server error: %s", args)
+		case "MSG", "HMSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			// HMSG <subject> <sid> [reply-to] <#header bytes> <#total bytes>
+			fields := strings.Fields(args)
+			numSizes := 1
+			if op == "HMSG" {
+				numSizes = 2
+			}
+			if len(fields) < 2+numSizes {
+				return errors.Errorf("invalid nats message: %q", line)
+			}
+			sizes := fields[len(fields)-numSizes:]
+			total, err := strconv.Atoi(sizes[len(sizes)-1])
+			if err != nil || total < 0 {
+				return errors.Errorf("invalid nats message: %q", line)
+			}
+			headerSize := 0
+			if op == "HMSG" {
+				if headerSize, err = strconv.Atoi(sizes[0]); err != nil || headerSize < 0 || headerSize > total {
+					return errors.Errorf("invalid nats message: %q", line)
+				}
+			}
+			payload := make([]byte, total+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			c.ack(fields[0], payload[:headerSize], payload[headerSize:total])
+		default:
+			return errors.Errorf("unexpected message from nats server: %q", line)
+		}
+	}
+}
+
+// ack handles the reply of JetStream to a published message.
+func (c *Conn) ack(reply string, header, data []byte) {
+	c.mu.Lock()
+	p, ok := c.mu.pending[reply]
+	delete(c.mu.pending, reply)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// The status of the replies is in the first line of their headers, and
+	// the 503 status means that no stream listens to the subject.
+	if len(header) > 0 {
+		status := strings.Fields(strings.SplitN(string(header), "\r\n", 2)[0])
+		if len(status) >= 2 && status[1] == "503" {
+			p.onAck(errors.Errorf("no jetstream stream stores the messages of nats subject %s", p.subject))
+			return
+		}
+	}
+	var ack pubAck
+	if err := json.Unmarshal(data, &ack); err != nil {
+		p.onAck(errors.Wrapf(err, "parsing jetstream ack for nats subject %s", p.subject))
+		return
+	}
+	if ack.Error != nil {
+		p.onAck(errors.Errorf("jetstream failed to store message of nats subject %s: %s (code %d)",
+			p.subject, ack.Error.Description, ack.Error.Code))
+		return
+	}
+	if ack.Stream == "" {
+		p.onAck(errors.Errorf("invalid jetstream ack for nats subject %s: %q", p.subject, data))
+		return
+	}
+	p.onAck(nil)
+}
+
+// Close closes the connection, and fails the pending acknowledgements.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	_ = c.failLocked(errors.New("connection closed"))
+	c.mu.Unlock()
+	c.wg.Wait()
+}
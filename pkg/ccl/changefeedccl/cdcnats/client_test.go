@@ -0,0 +1,150 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcnats
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitOp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, tc := range []struct {
+		line, op, args string
+	}{
+		{line: "PING", op: "PING"},
+		{line: "pong", op: "PONG"},
+		{line: "MSG a.b 1 12", op: "MSG", args: "a.b 1 12"},
+		{line: "-ERR\t'Authorization Violation'", op: "-ERR", args: "'Authorization Violation'"},
+	} {
+		op, args := splitOp(tc.line)
+		require.Equal(t, tc.op, op)
+		require.Equal(t, tc.args, args)
+	}
+
+	r := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 2*maxControlLineSize)+"\r\n"), maxControlLineSize)
+	_, err := readLine(r)
+	require.EqualError(t, err, `nats control line too long`)
+}
+
+func TestConn(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	server, err := StartMockServer("user", "pass")
+	require.NoError(t, err)
+	defer server.Close()
+
+	dial := func(t *testing.T, cfg DialConfig) *Conn {
+		cfg.Addr = server.Addr()
+		cfg.User, cfg.Password = "user", "pass"
+		c, err := Dial(ctx, cfg)
+		require.NoError(t, err)
+		return c
+	}
+	// publish publishes the messages, and returns their acknowledgements.
+	publish := func(t *testing.T, c *Conn, subject string, messages ...string) []chan error {
+		acks := make([]chan error, len(messages))
+		for i, data := range messages {
+			ack := make(chan error, 1)
+			acks[i] = ack
+			require.NoError(t, c.Publish(subject, []byte(data), func(err error) { ack <- err }))
+		}
+		require.NoError(t, c.Flush())
+		return acks
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		for _, ack := range publish(t, c, "cdc.t", "a", "b", "c") {
+			require.NoError(t, <-ack)
+		}
+		require.Equal(t, []string{"cdc.t:a", "cdc.t:b", "cdc.t:c"}, server.Messages())
+		require.NoError(t, c.Failed())
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		_, err := Dial(ctx, DialConfig{Addr: server.Addr(), User: "user", Password: "wrong"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `nats server error: 'Authorization Violation'`)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		acks := publish(t, c, "cdc.t", "reject")
+		require.EqualError(t, <-acks[0],
+			`jetstream failed to store message of nats subject cdc.t: insufficient resources (code 503)`)
+	})
+
+	t.Run("no stream", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		acks := publish(t, c, "other.t", "a")
+		require.EqualError(t, <-acks[0], `no jetstream stream stores the messages of nats subject other.t`)
+	})
+
+	t.Run("payload too large", func(t *testing.T) {
+		c := dial(t, DialConfig{})
+		defer c.Close()
+		err := c.Publish("cdc.t", make([]byte, 2048), func(error) {})
+		require.EqualError(t, err, `message of 2048 bytes exceeds the maximum payload of the nats server of 1024 bytes`)
+	})
+
+	t.Run("close fails pending acknowledgements", func(t *testing.T) {
+		server.SetHoldAcks(true)
+		defer server.SetHoldAcks(false)
+		c := dial(t, DialConfig{})
+		acks := publish(t, c, "cdc.t", "a")
+		c.Close()
+		err := <-acks[0]
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `connection closed`)
+		require.Error(t, c.Publish("cdc.t", nil, func(error) {}))
+	})
+
+	t.Run("pings", func(t *testing.T) {
+		c := dial(t, DialConfig{PingInterval: 10 * time.Millisecond})
+		defer c.Close()
+		require.Equal(t, DefaultMaxPingsOut, c.cfg.MaxPingsOut)
+
+		// The connection remains open while the server answers the pings.
+		pings := server.Pings()
+		testutils.SucceedsSoon(t, func() error {
+			if server.Pings() < pings+5 {
+				return errors.New("waiting for pings")
+			}
+			return nil
+		})
+		require.NoError(t, c.Failed())
+
+		// The connection fails once the server stops answering them.
+		server.SetStalled(true)
+		defer server.SetStalled(false)
+		testutils.SucceedsSoon(t, func() error {
+			if c.Failed() == nil {
+				return errors.New("waiting for the connection to fail")
+			}
+			return nil
+		})
+		require.Contains(t, c.Failed().Error(), `nats server did not answer 2 pings`)
+	})
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcnats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// MockServer is the NATS server used in tests. It stores the messages
+// published to the subjects starting with "cdc." in a JetStream stream, and
+// rejects the messages whose data is "reject".
+type MockServer struct {
+	ln       net.Listener
+	user     string
+	password string
+	wg       sync.WaitGroup
+
+	mu struct {
+		syncutil.Mutex
+		conns []net.Conn
+		// messages are the messages stored in the stream, as subject:data.
+		messages []string
+		// holdAcks makes the server not acknowledge the messages.
+		holdAcks bool
+		// pings is the number of pings received from the clients.
+		pings int
+		// stalled makes the server answer nothing to the clients once they
+		// connected.
+		stalled bool
+	}
+}
+
+// StartMockServer starts a mock server, which accepts the connections of the
+// clients authenticating with the specified credentials.
+func StartMockServer(user, password string) (*MockServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &MockServer{ln: ln, user: user, password: password}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.mu.conns = append(s.mu.conns, conn)
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer conn.Close()
+				if err := s.serve(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					log.Infof(context.Background(), "mock nats server: %v", err)
+				}
+			}()
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address of the server.
+func (s *MockServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *MockServer) serve(conn net.Conn) error {
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(w, "INFO {\"server_id\":\"mock\",\"max_payload\":1024,\"headers\":true}\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	var sid string
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		op, args := splitOp(line)
+		switch op {
+		case "CONNECT":
+			var opts connectOptions
+			if err := json.Unmarshal([]byte(args), &opts); err != nil {
+				return err
+			}
+			if opts.User != s.user || opts.Pass != s.password {
+				fmt.Fprintf(w, "-ERR 'Authorization Violation'\r\n")
+				return w.Flush()
+			}
+		case "PING":
+			s.mu.Lock()
+			s.mu.pings++
+			stalled := s.mu.stalled && sid != ""
+			s.mu.Unlock()
+			if !stalled {
+				fmt.Fprintf(w, "PONG\r\n")
+			}
+		case "SUB":
+			fields := strings.Fields(args)
+			sid = fields[len(fields)-1]
+		case "PUB":
+			fields := strings.Fields(args)
+			size, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return err
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			s.reply(w, sid, fields[0], fields[1], string(data[:size]))
+		default:
+			return errors.Newf("unexpected message %q", line)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// reply replies to a published message like JetStream.
+func (s *MockServer) reply(w io.Writer, sid, subject, reply, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.stalled {
+		return
+	}
+	if !strings.HasPrefix(subject, "cdc.") {
+		// No stream stores the messages of the subject.
+		const header = "NATS/1.0 503\r\n\r\n"
+		fmt.Fprintf(w, "HMSG %s %s %d %d\r\n%s\r\n", reply, sid, len(header), len(header), header)
+		return
+	}
+	if data == "reject" {
+		const ack = `{"error":{"code":503,"description":"insufficient resources"}}`
+		fmt.Fprintf(w, "MSG %s %s %d\r\n%s\r\n", reply, sid, len(ack), ack)
+		return
+	}
+	if s.mu.holdAcks {
+		return
+	}
+	s.mu.messages = append(s.mu.messages, subject+":"+data)
+	ack := fmt.Sprintf(`{"stream":"CDC","seq":%d}`, len(s.mu.messages))
+	fmt.Fprintf(w, "MSG %s %s %d\r\n%s\r\n", reply, sid, len(ack), ack)
+}
+
+// Messages returns the messages stored in the stream, as subject:data.
+func (s *MockServer) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.mu.messages...)
+}
+
+// SetHoldAcks makes the server not acknowledge the messages which it would
+// store.
+func (s *MockServer) SetHoldAcks(hold bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.holdAcks = hold
+}
+
+// SetStalled makes the server answer neither the pings nor the messages of
+// the connected clients, as if it were unreachable.
+func (s *MockServer) SetStalled(stalled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.stalled = stalled
+}
+
+// Pings returns the number of pings received from the clients.
+func (s *MockServer) Pings() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.pings
+}
+
+// Close stops the server, and closes the connections of the clients.
+func (s *MockServer) Close() {
+	_ = s.ln.Close()
+	s.mu.Lock()
+	for _, conn := range s.mu.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
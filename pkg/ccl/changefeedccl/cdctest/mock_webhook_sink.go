@@ -9,6 +9,7 @@
 package cdctest
 
 import (
+	"compress/gzip"
 	"crypto/tls"
 	"io"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
+	"github.com/klauspost/compress/zstd"
 )
 
 // MockWebhookSink is the Webhook sink used in tests.
@@ -30,6 +32,8 @@ type MockWebhookSink struct {
 		statusCodesIndex int
 		rows             []string
 		notify           chan struct{}
+		// responseHeaders are the headers of the responses.
+		responseHeaders map[string]string
 	}
 }
 
@@ -116,6 +120,17 @@ func (s *MockWebhookSink) SetStatusCodes(statusCodes []int) {
 	s.mu.statusCodes = statusCodes
 }
 
+// SetResponseHeader sets a header of the responses to the subsequent
+// requests, e.g. the resolved timestamp acknowledged by the endpoint.
+func (s *MockWebhookSink) SetResponseHeader(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.responseHeaders == nil {
+		s.mu.responseHeaders = make(map[string]string)
+	}
+	s.mu.responseHeaders[key] = value
+}
+
 // Close closes the mock Webhook sink.
 func (s *MockWebhookSink) Close() {
 	s.server.Close()
@@ -183,7 +198,27 @@ func (s *MockWebhookSink) requestHandler(hw http.ResponseWriter, hr *http.Reques
 
 func (s *MockWebhookSink) publish(hw http.ResponseWriter, hr *http.Request) error {
 	defer hr.Body.Close()
-	row, err := io.ReadAll(hr.Body)
+	body := io.Reader(hr.Body)
+	switch encoding := hr.Header.Get("Content-Encoding"); encoding {
+	case "":
+	case "gzip":
+		r, err := gzip.NewReader(hr.Body)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		body = r
+	case "zstd":
+		r, err := zstd.NewReader(hr.Body)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		body = r
+	default:
+		return errors.Errorf("unsupported content encoding %q", encoding)
+	}
+	row, err := io.ReadAll(body)
 	if err != nil {
 		return err
 	}
@@ -197,6 +232,9 @@ func (s *MockWebhookSink) publish(hw http.ResponseWriter, hr *http.Request) erro
 		}
 	}
 
+	for key, value := range s.mu.responseHeaders {
+		hw.Header().Set(key, value)
+	}
 	hw.WriteHeader(s.mu.statusCodes[s.mu.statusCodesIndex])
 	s.mu.statusCodesIndex = (s.mu.statusCodesIndex + 1) % len(s.mu.statusCodes)
 	s.mu.Unlock()
@@ -28,9 +28,14 @@ type SchemaRegistry struct {
 	server *httptest.Server
 	mu     struct {
 		syncutil.Mutex
-		idAlloc  int32
-		schemas  map[int32]string
-		subjects map[string]int32
+		idAlloc     int32
+		schemas     map[int32]string
+		schemaTypes map[int32]string
+		subjects    map[string]int32
+		// compatibility are the compatibility levels set on the subjects.
+		// Under a level other than NONE, a subject rejects any schema other
+		// than the one registered for it.
+		compatibility map[string]string
 	}
 }
 
@@ -58,7 +63,9 @@ func StartTestSchemaRegistryWithTLS(certificate *tls.Certificate) (*SchemaRegist
 func makeTestSchemaRegistry() *SchemaRegistry {
 	r := &SchemaRegistry{}
 	r.mu.schemas = make(map[int32]string)
+	r.mu.schemaTypes = make(map[int32]string)
 	r.mu.subjects = make(map[string]int32)
+	r.mu.compatibility = make(map[string]string)
 	r.server = httptest.NewUnstartedServer(http.HandlerFunc(r.requestHandler))
 	return r
 }
@@ -90,21 +97,52 @@ func (r *SchemaRegistry) SchemaForSubject(subject string) string {
 	return r.mu.schemas[r.mu.subjects[subject]]
 }
 
-func (r *SchemaRegistry) registerSchema(subject string, schema string) int32 {
+// SchemaTypeForSubject returns the type of the schema registered for the
+// specified subject. As in the confluent schema registry, schemas registered
+// without a type are AVRO schemas.
+func (r *SchemaRegistry) SchemaTypeForSubject(subject string) string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.mu.schemaTypes[r.mu.subjects[subject]]
+}
+
+// CompatibilityForSubject returns the compatibility level set on the specified
+// subject, if any.
+func (r *SchemaRegistry) CompatibilityForSubject(subject string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.compatibility[subject]
+}
 
+// registerSchema registers the schema for the subject, and returns its ID. It
+// returns false if the compatibility level of the subject rejects the schema.
+func (r *SchemaRegistry) registerSchema(
+	subject string, schemaType string, schema string,
+) (int32, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if level := r.mu.compatibility[subject]; level != "" && level != "NONE" {
+		if prev, ok := r.mu.subjects[subject]; ok && r.mu.schemas[prev] != schema {
+			return 0, false
+		}
+	}
+	if schemaType == "" {
+		schemaType = "AVRO"
+	}
 	id := r.mu.idAlloc
 	r.mu.idAlloc++
 	r.mu.schemas[id] = schema
+	r.mu.schemaTypes[id] = schemaType
 	r.mu.subjects[subject] = id
-	return id
+	return id, true
 }
 
 var (
 	// We are slightly stricter than confluent here as they allow
 	// a trailing slash.
 	subjectVersionsRegexp = regexp.MustCompile("^/subjects/[^/]+/versions$")
+	subjectConfigRegexp   = regexp.MustCompile("^/config/[^/]+$")
 )
 
 // requestHandler routes requests based on the Method and Path of the request.
@@ -116,6 +154,8 @@ func (r *SchemaRegistry) requestHandler(hw http.ResponseWriter, hr *http.Request
 	switch {
 	case method == http.MethodPost && subjectVersionsRegexp.MatchString(path):
 		err = r.register(hw, hr)
+	case method == http.MethodPut && subjectConfigRegexp.MatchString(path):
+		err = r.config(hw, hr)
 	case method == http.MethodGet && path == "/mode":
 		err = r.mode(hw, hr)
 	default:
@@ -130,7 +170,8 @@ func (r *SchemaRegistry) requestHandler(hw http.ResponseWriter, hr *http.Request
 // register is an http handler for the underlying server which registers schemas.
 func (r *SchemaRegistry) register(hw http.ResponseWriter, hr *http.Request) (err error) {
 	type confluentSchemaVersionRequest struct {
-		Schema string `json:"schema"`
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
 	}
 	type confluentSchemaVersionResponse struct {
 		ID int32 `json:"id"`
@@ -146,7 +187,11 @@ func (r *SchemaRegistry) register(hw http.ResponseWriter, hr *http.Request) (err
 	}
 
 	subject := strings.Split(hr.URL.Path, "/")[2]
-	id := r.registerSchema(subject, req.Schema)
+	id, ok := r.registerSchema(subject, req.SchemaType, req.Schema)
+	if !ok {
+		http.Error(hw, "Schema being registered is incompatible with an earlier schema", http.StatusConflict)
+		return nil
+	}
 	res, err := json.Marshal(confluentSchemaVersionResponse{ID: id})
 	if err != nil {
 		return err
@@ -157,6 +202,35 @@ func (r *SchemaRegistry) register(hw http.ResponseWriter, hr *http.Request) (err
 	return err
 }
 
+// config is an http handler which sets the compatibility level of subjects.
+func (r *SchemaRegistry) config(hw http.ResponseWriter, hr *http.Request) (err error) {
+	type confluentCompatibilityRequest struct {
+		Compatibility string `json:"compatibility"`
+	}
+
+	defer func() {
+		err = hr.Body.Close()
+	}()
+
+	var req confluentCompatibilityRequest
+	if err := json.NewDecoder(hr.Body).Decode(&req); err != nil {
+		return err
+	}
+
+	subject := strings.Split(hr.URL.Path, "/")[2]
+	r.mu.Lock()
+	r.mu.compatibility[subject] = req.Compatibility
+	r.mu.Unlock()
+
+	res, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hw.Header().Set(`Content-type`, `application/json`)
+	_, err = hw.Write(res)
+	return err
+}
+
 // mode is an http handler for the /mode endpoint. Our implementation
 // returns an empty response as we currently don't care about the
 // response.
@@ -28,6 +28,9 @@ type TestFeedMessage struct {
 	Topic, Partition string
 	Key, Value       []byte
 	Resolved         []byte
+	// Headers are the headers of the message, for the sinks which support
+	// them.
+	Headers map[string]string
 }
 
 func (m TestFeedMessage) String() string {
@@ -21,6 +21,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/quotapool"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 )
@@ -111,17 +112,25 @@ var nodeSinkThrottle = struct {
 	*Throttler
 }{}
 
+// getThrottleConfig returns the throttling configuration of the specified
+// setting.
+func getThrottleConfig(
+	sv *settings.Values, setting *settings.StringSetting,
+) (config changefeedbase.SinkThrottleConfig) {
+	configStr := setting.Get(sv)
+	if configStr != "" {
+		if err := json.Unmarshal([]byte(configStr), &config); err != nil {
+			log.Errorf(context.Background(),
+				"failed to parse throttle config %s=%q: err=%v; throttling disabled", setting.Key(), configStr, err)
+		}
+	}
+	return
+}
+
 // NodeLevelThrottler returns node level Throttler for changefeeds.
 func NodeLevelThrottler(sv *settings.Values, metrics *Metrics) *Throttler {
-	getConfig := func() (config changefeedbase.SinkThrottleConfig) {
-		configStr := changefeedbase.NodeSinkThrottleConfig.Get(sv)
-		if configStr != "" {
-			if err := json.Unmarshal([]byte(configStr), &config); err != nil {
-				log.Errorf(context.Background(),
-					"failed to parse node throttle config %q: err=%v; throttling disabled", configStr, err)
-			}
-		}
-		return
+	getConfig := func() changefeedbase.SinkThrottleConfig {
+		return getThrottleConfig(sv, changefeedbase.NodeSinkThrottleConfig)
 	}
 
 	// Initialize node level throttler once.
@@ -139,6 +148,81 @@ func NodeLevelThrottler(sv *settings.Values, metrics *Metrics) *Throttler {
 	return nodeSinkThrottle.Throttler
 }
 
+// sharedThrottler is a Throttler shared by the aggregators of a changefeed
+// running on the node.
+type sharedThrottler struct {
+	*Throttler
+	refs int
+	// overridden is set if the changefeed specified the configuration of the
+	// throttler, which then ignores the cluster setting.
+	overridden bool
+}
+
+var sinkThrottlers = struct {
+	sync.Once
+	syncutil.Mutex
+	m map[int64]*sharedThrottler
+}{m: make(map[int64]*sharedThrottler)}
+
+// SinkThrottler returns the Throttler of the messages emitted to the sink by
+// the aggregators of the changefeed with the specified job ID, which is shared
+// by all of its aggregators running on the node. The throttler is configured
+// by the specified configuration, if any, and by the
+// changefeed.aggregator_sink_throttle_config setting otherwise. The returned
+// function must be called once the aggregator is done with the throttler.
+func SinkThrottler(
+	sv *settings.Values,
+	jobID int64,
+	config *changefeedbase.SinkThrottleConfig,
+	metrics *Metrics,
+) (*Throttler, func()) {
+	getConfig := func() changefeedbase.SinkThrottleConfig {
+		if config != nil {
+			return *config
+		}
+		return getThrottleConfig(sv, changefeedbase.AggregatorSinkThrottleConfig)
+	}
+	name := fmt.Sprintf("cf.%d.sink.throttle", jobID)
+
+	// Sinkless changefeeds have no job, so their throttlers cannot be shared.
+	if jobID == 0 {
+		return NewThrottler(name, getConfig(), metrics), func() {}
+	}
+
+	// Update the throttlers which use the cluster setting when it changes.
+	sinkThrottlers.Do(func() {
+		changefeedbase.AggregatorSinkThrottleConfig.SetOnChange(sv, func(ctx context.Context) {
+			config := getThrottleConfig(sv, changefeedbase.AggregatorSinkThrottleConfig)
+			sinkThrottlers.Lock()
+			defer sinkThrottlers.Unlock()
+			for _, t := range sinkThrottlers.m {
+				if !t.overridden {
+					t.updateConfig(config)
+				}
+			}
+		})
+	})
+
+	sinkThrottlers.Lock()
+	defer sinkThrottlers.Unlock()
+	t, ok := sinkThrottlers.m[jobID]
+	if !ok {
+		t = &sharedThrottler{
+			Throttler:  NewThrottler(name, getConfig(), metrics),
+			overridden: config != nil,
+		}
+		sinkThrottlers.m[jobID] = t
+	}
+	t.refs++
+	return t.Throttler, func() {
+		sinkThrottlers.Lock()
+		defer sinkThrottlers.Unlock()
+		if t.refs--; t.refs == 0 {
+			delete(sinkThrottlers.m, jobID)
+		}
+	}
+}
+
 // Metrics is a metric.Struct for kvfeed metrics.
 type Metrics struct {
 	BytesPushbackNanos    *metric.Counter
@@ -50,3 +50,44 @@ func TestNodeLevelThrottler(t *testing.T) {
 	require.True(t, throttler.flushLimiter.AdmitN(1))
 	require.False(t, throttler.flushLimiter.AdmitN(1))
 }
+
+func TestSinkThrottler(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sv := &cluster.MakeTestingClusterSettings().SV
+	m := MakeMetrics(time.Minute)
+
+	// The aggregators of a changefeed on the node share its throttler.
+	throttler, release := SinkThrottler(sv, 1, nil, &m)
+	shared, releaseShared := SinkThrottler(sv, 1, nil, &m)
+	require.Same(t, throttler, shared)
+	overridden, releaseOverridden := SinkThrottler(sv, 2,
+		&changefeedbase.SinkThrottleConfig{MessageRate: 2, ByteRate: 2}, &m)
+	defer releaseOverridden()
+	require.NotSame(t, throttler, overridden)
+
+	// Default: no throttling
+	require.True(t, throttler.messageLimiter.AdmitN(10000000))
+	require.True(t, throttler.byteLimiter.AdmitN(10000000))
+	require.True(t, overridden.messageLimiter.AdmitN(2))
+	require.False(t, overridden.messageLimiter.AdmitN(1))
+
+	// The cluster setting does not apply to the changefeeds which specify
+	// their configuration.
+	changefeedbase.AggregatorSinkThrottleConfig.Override(
+		ctx, sv, `{"MessageRate": 1, "ByteRate": 1}`,
+	)
+	require.True(t, throttler.messageLimiter.AdmitN(1))
+	require.False(t, throttler.messageLimiter.AdmitN(1))
+	require.True(t, throttler.byteLimiter.AdmitN(1))
+	require.False(t, throttler.byteLimiter.AdmitN(1))
+	require.False(t, overridden.messageLimiter.AdmitN(1))
+
+	// The throttler is released once all of the aggregators are done with it.
+	release()
+	require.Same(t, throttler, sinkThrottlers.m[1].Throttler)
+	releaseShared()
+	require.NotContains(t, sinkThrottlers.m, int64(1))
+}
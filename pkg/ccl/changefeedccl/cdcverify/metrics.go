@@ -0,0 +1,122 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcverify
+
+import "github.com/cockroachdb/cockroach/pkg/util/metric"
+
+var (
+	metaRowsWritten = metric.Metadata{
+		Name:        "cdc_verify.rows_written",
+		Help:        "Number of rows written by the workload",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaWriteErrors = metric.Metadata{
+		Name:        "cdc_verify.write_errors",
+		Help:        "Number of writes of the workload which failed",
+		Measurement: "Errors",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRowsReceived = metric.Metadata{
+		Name:        "cdc_verify.rows_received",
+		Help:        "Number of rows received from the sink",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaResolvedReceived = metric.Metadata{
+		Name:        "cdc_verify.resolved_received",
+		Help:        "Number of resolved timestamps received from the sink",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaDuplicateRows = metric.Metadata{
+		Name:        "cdc_verify.duplicate_rows",
+		Help:        "Number of rows received more than once from the sink",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRowsVerified = metric.Metadata{
+		Name:        "cdc_verify.rows_verified",
+		Help:        "Number of writes of the ledger verified to be received from the sink",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaCheckedTimestamp = metric.Metadata{
+		Name:        "cdc_verify.checked_timestamp",
+		Help:        "Resolved timestamp up to which the ledger was verified",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_TIMESTAMP_NS,
+	}
+	metaOrderingViolations = metric.Metadata{
+		Name:        "cdc_verify.violations.ordering",
+		Help:        "Number of new versions of keys received out of timestamp order",
+		Measurement: "Violations",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaResolvedViolations = metric.Metadata{
+		Name:        "cdc_verify.violations.resolved",
+		Help:        "Number of new rows received at or below a resolved timestamp",
+		Measurement: "Violations",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaMissingRows = metric.Metadata{
+		Name:        "cdc_verify.violations.missing",
+		Help:        "Number of writes of the ledger not received before they were resolved",
+		Measurement: "Violations",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaUnexpectedRows = metric.Metadata{
+		Name:        "cdc_verify.violations.unexpected",
+		Help:        "Number of rows received which do not match any write of the ledger",
+		Measurement: "Violations",
+		Unit:        metric.Unit_COUNT,
+	}
+)
+
+// Metrics are the metrics of a Verifier.
+type Metrics struct {
+	RowsWritten      *metric.Counter
+	WriteErrors      *metric.Counter
+	RowsReceived     *metric.Counter
+	ResolvedReceived *metric.Counter
+	DuplicateRows    *metric.Counter
+	RowsVerified     *metric.Counter
+	CheckedTimestamp *metric.Gauge
+
+	OrderingViolations *metric.Counter
+	ResolvedViolations *metric.Counter
+	MissingRows        *metric.Counter
+	UnexpectedRows     *metric.Counter
+}
+
+// MetricStruct implements the metric.Struct interface.
+func (*Metrics) MetricStruct() {}
+
+// MakeMetrics returns the metrics of a Verifier.
+func MakeMetrics() *Metrics {
+	return &Metrics{
+		RowsWritten:        metric.NewCounter(metaRowsWritten),
+		WriteErrors:        metric.NewCounter(metaWriteErrors),
+		RowsReceived:       metric.NewCounter(metaRowsReceived),
+		ResolvedReceived:   metric.NewCounter(metaResolvedReceived),
+		DuplicateRows:      metric.NewCounter(metaDuplicateRows),
+		RowsVerified:       metric.NewCounter(metaRowsVerified),
+		CheckedTimestamp:   metric.NewGauge(metaCheckedTimestamp),
+		OrderingViolations: metric.NewCounter(metaOrderingViolations),
+		ResolvedViolations: metric.NewCounter(metaResolvedViolations),
+		MissingRows:        metric.NewCounter(metaMissingRows),
+		UnexpectedRows:     metric.NewCounter(metaUnexpectedRows),
+	}
+}
+
+// Violations returns the total number of violations.
+func (m *Metrics) Violations() int64 {
+	return m.OrderingViolations.Count() + m.ResolvedViolations.Count() +
+		m.MissingRows.Count() + m.UnexpectedRows.Count()
+}
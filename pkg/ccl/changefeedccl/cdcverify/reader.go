@@ -0,0 +1,290 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcverify
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	gojson "encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// SinkReader reads the messages emitted by a changefeed to its sink.
+type SinkReader interface {
+	// Partitions returns the partitions of the sink. The resolved timestamps
+	// are emitted to every partition.
+	Partitions() []string
+	// Next blocks until the next message is available and returns it. Within a
+	// partition, the messages are returned in the order in which they were
+	// emitted. The row messages have a key and a value, and the resolved
+	// timestamp messages only have a resolved payload.
+	Next(ctx context.Context) (*cdctest.TestFeedMessage, error)
+	// Close releases the resources of the reader.
+	Close() error
+}
+
+// kafkaReader reads the messages of a Kafka topic.
+type kafkaReader struct {
+	consumer   sarama.Consumer
+	topic      string
+	partitions []string
+	pcs        []sarama.PartitionConsumer
+
+	messages chan *sarama.ConsumerMessage
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ SinkReader = (*kafkaReader)(nil)
+
+// NewKafkaReader returns a SinkReader consuming the given topic from the
+// oldest available offset of each of its partitions. The topic must exist.
+func NewKafkaReader(addrs []string, topic string) (SinkReader, error) {
+	config := sarama.NewConfig()
+	// The fetch size of the consumer must be at least the "max.message.bytes"
+	// of the brokers, otherwise the consumer fails to decode the messages.
+	config.Consumer.Fetch.Default = 1000012
+	consumer, err := sarama.NewConsumer(addrs, config)
+	if err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{
+		consumer: consumer,
+		topic:    topic,
+		messages: make(chan *sarama.ConsumerMessage),
+		done:     make(chan struct{}),
+	}
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		_ = consumer.Close()
+		return nil, err
+	}
+	for _, partition := range partitions {
+		pc, err := consumer.ConsumePartition(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r.partitions = append(r.partitions, strconv.Itoa(int(partition)))
+		r.pcs = append(r.pcs, pc)
+		r.wg.Add(1)
+		go r.forward(pc)
+	}
+	return r, nil
+}
+
+// forward forwards the messages of a partition to the messages channel, until
+// the reader is closed.
+func (r *kafkaReader) forward(pc sarama.PartitionConsumer) {
+	defer r.wg.Done()
+	// The messages must be drained until the partition consumer is closed.
+	for m := range pc.Messages() {
+		select {
+		case r.messages <- m:
+		case <-r.done:
+		}
+	}
+}
+
+// Partitions implements the SinkReader interface.
+func (r *kafkaReader) Partitions() []string {
+	return r.partitions
+}
+
+// Next implements the SinkReader interface.
+func (r *kafkaReader) Next(ctx context.Context) (*cdctest.TestFeedMessage, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m := <-r.messages:
+		msg := &cdctest.TestFeedMessage{
+			Topic:     m.Topic,
+			Partition: strconv.Itoa(int(m.Partition)),
+		}
+		if len(m.Key) > 0 {
+			msg.Key, msg.Value = m.Key, m.Value
+		} else {
+			msg.Resolved = m.Value
+		}
+		return msg, nil
+	}
+}
+
+// Close implements the SinkReader interface.
+func (r *kafkaReader) Close() error {
+	close(r.done)
+	for _, pc := range r.pcs {
+		pc.AsyncClose()
+		// The errors must be drained until the partition consumer is closed,
+		// the messages are drained by forward.
+		for err := range pc.Errors() {
+			log.Warningf(context.Background(), "error consuming topic %s: %v", r.topic, err)
+		}
+	}
+	r.wg.Wait()
+	return r.consumer.Close()
+}
+
+// cloudStorageReaderPartition is the only partition of cloud storage sinks,
+// whose files are totally ordered.
+const cloudStorageReaderPartition = ``
+
+// cloudStorageReader reads the files written by a cloud storage sink.
+//
+// The sink guarantees that, when its files are iterated in lexicographic order
+// of their names, every file preceding a resolved timestamp file is complete.
+// The reader thus only reads the files preceding the latest resolved timestamp
+// file, in lexicographic order.
+type cloudStorageReader struct {
+	es           cloud.ExternalStorage
+	pollInterval time.Duration
+
+	// lastResolved is the name of the latest resolved timestamp file read.
+	lastResolved string
+	pending      []*cdctest.TestFeedMessage
+}
+
+var _ SinkReader = (*cloudStorageReader)(nil)
+
+// NewCloudStorageReader returns a SinkReader reading the files written by a
+// cloud storage sink to es, which are listed every pollInterval. The sink must
+// emit JSON with the key in the value, which is the default.
+func NewCloudStorageReader(es cloud.ExternalStorage, pollInterval time.Duration) SinkReader {
+	return &cloudStorageReader{es: es, pollInterval: pollInterval}
+}
+
+// Partitions implements the SinkReader interface.
+func (r *cloudStorageReader) Partitions() []string {
+	return []string{cloudStorageReaderPartition}
+}
+
+// Next implements the SinkReader interface.
+func (r *cloudStorageReader) Next(ctx context.Context) (*cdctest.TestFeedMessage, error) {
+	for len(r.pending) == 0 {
+		found, err := r.poll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(r.pollInterval):
+		}
+	}
+	m := r.pending[0]
+	r.pending = r.pending[1:]
+	return m, nil
+}
+
+// poll reads the files preceding the latest resolved timestamp file which
+// were not read before. It returns whether a new resolved timestamp file was
+// found.
+func (r *cloudStorageReader) poll(ctx context.Context) (bool, error) {
+	// The files may be stored in subdirectories, but they are ordered by their
+	// base names.
+	var files []string
+	if err := r.es.List(ctx, "", "", func(f string) error {
+		if !strings.HasSuffix(f, ".tmp") {
+			files = append(files, f)
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	sort.Slice(files, func(i, j int) bool { return path.Base(files[i]) < path.Base(files[j]) })
+
+	end := -1
+	for i, f := range files {
+		if strings.HasSuffix(f, ".RESOLVED") && path.Base(f) > r.lastResolved {
+			end = i
+		}
+	}
+	if end < 0 {
+		return false, nil
+	}
+	for _, f := range files[:end+1] {
+		if path.Base(f) <= r.lastResolved {
+			continue
+		}
+		if err := r.readFile(ctx, f); err != nil {
+			return false, errors.Wrapf(err, "reading %s", f)
+		}
+	}
+	r.lastResolved = path.Base(files[end])
+	return true, nil
+}
+
+// readFile adds the messages of a file to the pending messages.
+func (r *cloudStorageReader) readFile(ctx context.Context, f string) error {
+	rc, err := r.es.ReadFile(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close(ctx)
+	var reader io.Reader = ioctx.ReaderCtxAdapter(ctx, rc)
+	if strings.HasSuffix(f, ".gz") {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(f, ".RESOLVED") {
+		r.pending = append(r.pending, &cdctest.TestFeedMessage{
+			Partition: cloudStorageReaderPartition,
+			Resolved:  contents,
+		})
+		return nil
+	}
+	for _, line := range bytes.Split(contents, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var value struct {
+			Key gojson.RawMessage `json:"key"`
+		}
+		if err := gojson.Unmarshal(line, &value); err != nil {
+			return errors.Wrapf(err, "parsing [%s] as json", line)
+		}
+		r.pending = append(r.pending, &cdctest.TestFeedMessage{
+			Partition: cloudStorageReaderPartition,
+			Key:       value.Key,
+			Value:     line,
+		})
+	}
+	return nil
+}
+
+// Close implements the SinkReader interface.
+func (r *cloudStorageReader) Close() error {
+	return r.es.Close()
+}
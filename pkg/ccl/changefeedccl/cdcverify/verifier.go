@@ -0,0 +1,480 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+// Package cdcverify continuously verifies the delivery guarantees of a
+// changefeed against a real sink.
+//
+// A Verifier runs a write workload against a test table, recording every write
+// in a side ledger table in the same transaction, and consumes the output of a
+// changefeed on the test table through a SinkReader. It checks that:
+//   - the new versions of each key are emitted in timestamp order;
+//   - no new version of a row is emitted at or below a resolved timestamp which
+//     was already emitted on the same partition;
+//   - every write in the ledger is emitted at least once before the resolved
+//     timestamps of the changefeed pass its commit timestamp, and every
+//     emitted row corresponds to a write in the ledger.
+//
+// The violations are counted in the verifier's metrics and recorded in a
+// results table, so that the verifier can run unattended for long periods of
+// time.
+package cdcverify
+
+import (
+	"context"
+	gosql "database/sql"
+	gojson "encoding/json"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/v2/crdb"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// Config configures a Verifier.
+type Config struct {
+	// Table is the name of the table written by the workload and watched by
+	// the changefeed. The ledger and results tables are named after it.
+	Table string
+	// SinkURI is the URI of the sink of the changefeed.
+	SinkURI string
+	// Keys is the number of distinct keys written by the workload.
+	Keys int
+	// Concurrency is the number of concurrent writers.
+	Concurrency int
+	// WriteInterval is the interval between two writes of each writer.
+	WriteInterval time.Duration
+	// PayloadBytes is the size of the payload of each write.
+	PayloadBytes int
+	// Resolved is the frequency of the resolved timestamps emitted by the
+	// changefeed, which bounds how often the ledger is checked.
+	Resolved time.Duration
+}
+
+// DefaultConfig returns the default configuration of a Verifier.
+func DefaultConfig() Config {
+	return Config{
+		Table:         "cdc_verify",
+		Keys:          1000,
+		Concurrency:   4,
+		WriteInterval: 10 * time.Millisecond,
+		PayloadBytes:  64,
+		Resolved:      time.Second,
+	}
+}
+
+// The kinds of violations recorded in the results table.
+const (
+	// violationOrdering is a new version of a key emitted with a lower
+	// timestamp than a version emitted before.
+	violationOrdering = "ordering"
+	// violationResolved is a new version of a row emitted at or below a
+	// resolved timestamp emitted before on the same partition.
+	violationResolved = "resolved"
+	// violationMissing is a write of the ledger which was not emitted before
+	// the resolved timestamps passed its commit timestamp.
+	violationMissing = "missing"
+	// violationUnexpected is an emitted row which does not match any write of
+	// the ledger.
+	violationUnexpected = "unexpected"
+)
+
+// rowKey identifies a version of a row.
+type rowKey struct {
+	id int64
+	ts hlc.Timestamp
+}
+
+// Verifier verifies the delivery guarantees of a changefeed. See the package
+// documentation for details.
+type Verifier struct {
+	db      *gosql.DB
+	cfg     Config
+	metrics *Metrics
+
+	table, ledger, results string
+
+	// jobID is the ID of the changefeed job created by Setup.
+	jobID jobspb.JobID
+	// seq is the sequence number of the last write, accessed atomically.
+	seq int64
+
+	// The following fields are only accessed by the goroutine consuming the
+	// sink.
+	//
+	// checked is the timestamp up to which the ledger was checked against the
+	// emitted rows.
+	checked hlc.Timestamp
+	// resolved is the latest resolved timestamp of each partition.
+	resolved map[string]hlc.Timestamp
+	// latest is the timestamp of the latest version emitted for each key.
+	latest map[int64]hlc.Timestamp
+	// pending are the sequence numbers of the rows emitted above checked, which
+	// are yet to be checked against the ledger.
+	pending map[rowKey]int64
+
+	mu struct {
+		syncutil.Mutex
+		violations []string
+	}
+}
+
+// New returns a Verifier with the given configuration, which runs its
+// workload and records its results through db.
+func New(db *gosql.DB, cfg Config) *Verifier {
+	return &Verifier{
+		db:       db,
+		cfg:      cfg,
+		metrics:  MakeMetrics(),
+		table:    tree.NameString(cfg.Table),
+		ledger:   tree.NameString(cfg.Table + "_ledger"),
+		results:  tree.NameString(cfg.Table + "_results"),
+		resolved: make(map[string]hlc.Timestamp),
+		latest:   make(map[int64]hlc.Timestamp),
+		pending:  make(map[rowKey]int64),
+	}
+}
+
+// Metrics returns the metrics of the verifier.
+func (v *Verifier) Metrics() *Metrics {
+	return v.metrics
+}
+
+// JobID returns the ID of the changefeed job created by Setup.
+func (v *Verifier) JobID() jobspb.JobID {
+	return v.jobID
+}
+
+// Violations returns the violations seen so far.
+func (v *Verifier) Violations() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.mu.violations...)
+}
+
+// Setup creates the test, ledger and results tables if they do not exist, and
+// creates the changefeed on the test table. The changefeed starts at the
+// current time, so the writes of previous runs are not checked.
+func (v *Verifier) Setup(ctx context.Context) error {
+	for _, stmt := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id INT PRIMARY KEY,
+			seq INT NOT NULL,
+			payload STRING NOT NULL
+		)`, v.table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			seq INT PRIMARY KEY,
+			id INT NOT NULL,
+			ts DECIMAL NOT NULL,
+			INDEX (ts)
+		)`, v.ledger),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			kind STRING NOT NULL,
+			details STRING NOT NULL
+		)`, v.results),
+	} {
+		if _, err := v.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	// Continue the sequence of the previous runs, so that the sequence numbers
+	// stay unique in the ledger.
+	if err := v.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT COALESCE(max(seq), 0) FROM %s`, v.ledger),
+	).Scan(&v.seq); err != nil {
+		return err
+	}
+
+	var start string
+	if err := v.db.QueryRowContext(ctx, `SELECT cluster_logical_timestamp()`).Scan(&start); err != nil {
+		return err
+	}
+	// The writes committed before the cursor of the changefeed are not
+	// expected to be emitted.
+	var err error
+	if v.checked, err = hlc.ParseHLC(start); err != nil {
+		return err
+	}
+	return v.db.QueryRowContext(ctx, fmt.Sprintf(
+		`CREATE CHANGEFEED FOR TABLE %s INTO $1
+		WITH updated, resolved = '%s', min_checkpoint_frequency = '%s', cursor = '%s'`,
+		v.table, v.cfg.Resolved, v.cfg.Resolved, start,
+	), v.cfg.SinkURI).Scan(&v.jobID)
+}
+
+// Run runs the workload and verifies the output of the changefeed read by r,
+// until the context is canceled or an error occurs. The violations of the
+// guarantees of the changefeed are not errors: they are reported through the
+// metrics, the results table and Violations.
+func (v *Verifier) Run(ctx context.Context, r SinkReader) error {
+	g := ctxgroup.WithContext(ctx)
+	for i := 0; i < v.cfg.Concurrency; i++ {
+		rng, _ := randutil.NewPseudoRand()
+		g.GoCtx(func(ctx context.Context) error {
+			return v.runWriter(ctx, rng)
+		})
+	}
+	g.GoCtx(func(ctx context.Context) error {
+		return v.runConsumer(ctx, r)
+	})
+	err := g.Wait()
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return nil
+	}
+	return err
+}
+
+// runWriter writes random keys, and records every write in the ledger in the
+// same transaction.
+func (v *Verifier) runWriter(ctx context.Context, rng *rand.Rand) error {
+	upsertStmt := fmt.Sprintf(`UPSERT INTO %s (id, seq, payload) VALUES ($1, $2, $3)`, v.table)
+	// The commit timestamp of the transaction is the timestamp at which the
+	// changefeed emits the row.
+	ledgerStmt := fmt.Sprintf(
+		`INSERT INTO %s (seq, id, ts) VALUES ($1, $2, cluster_logical_timestamp())`, v.ledger,
+	)
+	ticker := time.NewTicker(v.cfg.WriteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		id := rng.Int63n(int64(v.cfg.Keys))
+		seq := atomic.AddInt64(&v.seq, 1)
+		payload := randutil.RandString(rng, v.cfg.PayloadBytes, randutil.PrintableKeyAlphabet)
+		if err := crdb.ExecuteTx(ctx, v.db, nil /* txopts */, func(tx *gosql.Tx) error {
+			if _, err := tx.ExecContext(ctx, upsertStmt, id, seq, payload); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, ledgerStmt, seq, id)
+			return err
+		}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// The writes may fail while the cluster is disrupted. Since the
+			// ledger is written in the same transaction, it records the
+			// writes that committed regardless of the errors.
+			v.metrics.WriteErrors.Inc(1)
+			log.Warningf(ctx, "write of key %d failed: %v", id, err)
+			continue
+		}
+		v.metrics.RowsWritten.Inc(1)
+	}
+}
+
+// runConsumer consumes the output of the changefeed.
+func (v *Verifier) runConsumer(ctx context.Context, r SinkReader) error {
+	for {
+		m, err := r.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if m.Resolved != nil {
+			err = v.noteResolved(ctx, r, m)
+		} else {
+			err = v.noteRow(ctx, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rowValue is the part of the value of the emitted rows used by the verifier.
+type rowValue struct {
+	After *struct {
+		ID  int64 `json:"id"`
+		Seq int64 `json:"seq"`
+	} `json:"after"`
+}
+
+func (v *Verifier) noteRow(ctx context.Context, m *cdctest.TestFeedMessage) error {
+	updated, _, err := cdctest.ParseJSONValueTimestamps(m.Value)
+	if err != nil {
+		return err
+	}
+	var value rowValue
+	if err := gojson.Unmarshal(m.Value, &value); err != nil {
+		return errors.Wrapf(err, "parsing [%s] as json", m.Value)
+	}
+	if value.After == nil {
+		return errors.Newf("unexpected deletion: %s", m.Value)
+	}
+	v.metrics.RowsReceived.Inc(1)
+	id, seq := value.After.ID, value.After.Seq
+
+	// The rows at or below checked were already checked against the ledger,
+	// so they can only be duplicates. Had such a row not been emitted before,
+	// it would have been reported as missing by the check of the ledger.
+	if updated.LessEq(v.checked) {
+		v.metrics.DuplicateRows.Inc(1)
+		return nil
+	}
+	k := rowKey{id: id, ts: updated}
+	if prevSeq, ok := v.pending[k]; ok {
+		v.metrics.DuplicateRows.Inc(1)
+		if prevSeq != seq {
+			return v.recordViolation(ctx, violationUnexpected,
+				"key %d emitted twice at %s with seq %d and %d",
+				id, updated.AsOfSystemTime(), prevSeq, seq)
+		}
+		return nil
+	}
+	v.pending[k] = seq
+
+	if latest := v.latest[id]; updated.Less(latest) {
+		if err := v.recordViolation(ctx, violationOrdering,
+			"partition %q: saw new row timestamp %s for key %d after %s was seen",
+			m.Partition, updated.AsOfSystemTime(), id, latest.AsOfSystemTime(),
+		); err != nil {
+			return err
+		}
+	} else {
+		v.latest[id] = updated
+	}
+	if resolved := v.resolved[m.Partition]; updated.LessEq(resolved) {
+		return v.recordViolation(ctx, violationResolved,
+			"partition %q: saw new row timestamp %s for key %d after %s was resolved",
+			m.Partition, updated.AsOfSystemTime(), id, resolved.AsOfSystemTime())
+	}
+	return nil
+}
+
+func (v *Verifier) noteResolved(
+	ctx context.Context, r SinkReader, m *cdctest.TestFeedMessage,
+) error {
+	_, resolved, err := cdctest.ParseJSONValueTimestamps(m.Resolved)
+	if err != nil {
+		return err
+	}
+	v.metrics.ResolvedReceived.Inc(1)
+	// The resolved timestamps may regress when the changefeed restarts.
+	if v.resolved[m.Partition].Less(resolved) {
+		v.resolved[m.Partition] = resolved
+	}
+
+	// The changefeed is resolved up to the minimum of the resolved timestamps
+	// of the partitions.
+	var frontier hlc.Timestamp
+	for i, p := range r.Partitions() {
+		if ts := v.resolved[p]; i == 0 || ts.Less(frontier) {
+			frontier = ts
+		}
+	}
+	if v.checked.Less(frontier) {
+		return v.checkLedger(ctx, frontier)
+	}
+	return nil
+}
+
+// checkLedger checks that the writes of the ledger with a commit timestamp in
+// (checked, frontier] were all emitted, and that all the rows emitted in this
+// interval correspond to writes of the ledger.
+func (v *Verifier) checkLedger(ctx context.Context, frontier hlc.Timestamp) error {
+	rows, err := v.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT seq, id, ts::STRING FROM %s WHERE ts > $1::DECIMAL AND ts <= $2::DECIMAL`,
+		v.ledger,
+	), v.checked.AsOfSystemTime(), frontier.AsOfSystemTime())
+	if err != nil {
+		return err
+	}
+	// The violations are only recorded once the rows are closed.
+	type violation struct{ kind, details string }
+	var violations []violation
+	for rows.Next() {
+		var seq, id int64
+		var tsStr string
+		if err := rows.Scan(&seq, &id, &tsStr); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		ts, err := hlc.ParseHLC(tsStr)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		k := rowKey{id: id, ts: ts}
+		emittedSeq, ok := v.pending[k]
+		switch {
+		case !ok:
+			violations = append(violations, violation{violationMissing, fmt.Sprintf(
+				"key %d with seq %d committed at %s was not emitted before resolved timestamp %s",
+				id, seq, ts.AsOfSystemTime(), frontier.AsOfSystemTime())})
+			continue
+		case emittedSeq != seq:
+			violations = append(violations, violation{violationUnexpected, fmt.Sprintf(
+				"key %d committed at %s with seq %d was emitted with seq %d",
+				id, ts.AsOfSystemTime(), seq, emittedSeq)})
+		default:
+			v.metrics.RowsVerified.Inc(1)
+		}
+		delete(v.pending, k)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, vi := range violations {
+		if err := v.recordViolation(ctx, vi.kind, "%s", vi.details); err != nil {
+			return err
+		}
+	}
+	for k, seq := range v.pending {
+		if k.ts.LessEq(frontier) {
+			if err := v.recordViolation(ctx, violationUnexpected,
+				"key %d with seq %d emitted at %s is not in the ledger",
+				k.id, seq, k.ts.AsOfSystemTime(),
+			); err != nil {
+				return err
+			}
+			delete(v.pending, k)
+		}
+	}
+	v.checked = frontier
+	v.metrics.CheckedTimestamp.Update(frontier.WallTime)
+	return nil
+}
+
+// recordViolation records a violation in the metrics and the results table.
+func (v *Verifier) recordViolation(
+	ctx context.Context, kind string, format string, args ...interface{},
+) error {
+	details := fmt.Sprintf(format, args...)
+	switch kind {
+	case violationOrdering:
+		v.metrics.OrderingViolations.Inc(1)
+	case violationResolved:
+		v.metrics.ResolvedViolations.Inc(1)
+	case violationMissing:
+		v.metrics.MissingRows.Inc(1)
+	case violationUnexpected:
+		v.metrics.UnexpectedRows.Inc(1)
+	}
+	v.mu.Lock()
+	v.mu.violations = append(v.mu.violations, fmt.Sprintf("%s: %s", kind, details))
+	v.mu.Unlock()
+	log.Warningf(ctx, "cdc verification violation (%s): %s", kind, details)
+	_, err := v.db.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (kind, details) VALUES ($1, $2)`, v.results), kind, details,
+	)
+	return err
+}
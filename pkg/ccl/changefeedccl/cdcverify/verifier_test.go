@@ -0,0 +1,146 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcverify
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestVerifierWithChaos runs the verifier against a cloud storage changefeed
+// which is repeatedly paused and restarted, and checks that no violation is
+// found.
+func TestVerifierWithChaos(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The verification needs the changefeed to make steady progress.
+	skip.UnderRace(t)
+	skip.UnderStress(t)
+
+	ctx := context.Background()
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+
+	// restart makes the changefeed fail with a retryable error, which restarts
+	// its flow as when one of its nodes restarts.
+	var restart int32
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{
+		// The sink is read through the external storage of the system tenant.
+		DisableDefaultTestTenant: true,
+		ExternalIODir:            dir,
+		UseDatabase:              "d",
+		Knobs: base.TestingKnobs{
+			DistSQL: &execinfra.TestingKnobs{
+				Changefeed: &changefeedccl.TestingKnobs{
+					RaiseRetryableError: func() error {
+						if atomic.CompareAndSwapInt32(&restart, 1, 0) {
+							return errors.New("test restart")
+						}
+						return nil
+					},
+				},
+			},
+			JobsTestingKnobs: jobs.NewTestingKnobsWithShortIntervals(),
+		},
+	})
+	defer s.Stopper().Stop(ctx)
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, `CREATE DATABASE d`)
+	sqlDB.Exec(t, `SET CLUSTER SETTING kv.rangefeed.enabled = true`)
+	sqlDB.Exec(t, `SET CLUSTER SETTING kv.closed_timestamp.target_duration = '1s'`)
+	sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.experimental_poll_interval = '10ms'`)
+
+	const sinkURI = `nodelocal://0/cdc_verify`
+	cfg := Config{
+		Table:         "cdc_verify",
+		SinkURI:       sinkURI,
+		Keys:          50,
+		Concurrency:   2,
+		WriteInterval: 5 * time.Millisecond,
+		PayloadBytes:  16,
+		Resolved:      100 * time.Millisecond,
+	}
+	v := New(db, cfg)
+	require.NoError(t, v.Setup(ctx))
+
+	es, err := s.ExecutorConfig().(sql.ExecutorConfig).DistSQLSrv.ExternalStorageFromURI(
+		ctx, sinkURI, username.RootUserName(),
+	)
+	require.NoError(t, err)
+	r := NewCloudStorageReader(es, 50*time.Millisecond)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g := ctxgroup.WithContext(runCtx)
+	g.GoCtx(func(ctx context.Context) error {
+		return v.Run(ctx, r)
+	})
+
+	m := v.Metrics()
+	// waitForVerified waits until more rows were verified.
+	waitForVerified := func() {
+		verified := m.RowsVerified.Count()
+		testutils.SucceedsSoon(t, func() error {
+			if m.RowsVerified.Count() < verified+50 {
+				return errors.Newf("verified %d rows", m.RowsVerified.Count())
+			}
+			return nil
+		})
+	}
+	waitForJobStatus := func(status jobs.Status) {
+		testutils.SucceedsSoon(t, func() error {
+			var s string
+			sqlDB.QueryRow(t, `SELECT status FROM [SHOW JOB $1]`, v.JobID()).Scan(&s)
+			if jobs.Status(s) != status {
+				return errors.Newf("job %d is %s, expected %s", v.JobID(), s, status)
+			}
+			return nil
+		})
+	}
+
+	waitForVerified()
+	for i := 0; i < 3; i++ {
+		sqlDB.Exec(t, `PAUSE JOB $1`, v.JobID())
+		waitForJobStatus(jobs.StatusPaused)
+		sqlDB.Exec(t, `RESUME JOB $1`, v.JobID())
+		waitForJobStatus(jobs.StatusRunning)
+		waitForVerified()
+
+		atomic.StoreInt32(&restart, 1)
+		waitForVerified()
+	}
+	cancel()
+	require.NoError(t, g.Wait())
+
+	require.Empty(t, v.Violations())
+	require.Zero(t, m.Violations())
+	require.NotZero(t, m.RowsWritten.Count())
+	sqlDB.CheckQueryResults(t, `SELECT count(*) FROM cdc_verify_results`, [][]string{{"0"}})
+}
@@ -12,6 +12,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdceval"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
@@ -61,13 +62,15 @@ const (
 // minimum of the span-level resolved timestamps. This changefeed-level resolved
 // timestamp is emitted into the changefeed sink (or returned to the gateway if
 // there is no sink) whenever it advances. ChangeFrontier also updates the
-// progress of the changefeed's corresponding system job.
+// progress of the changefeed's corresponding system job, where it records the
+// specified retryable errors which caused the flow to restart.
 func distChangefeedFlow(
 	ctx context.Context,
 	execCtx sql.JobExecContext,
 	jobID jobspb.JobID,
 	details jobspb.ChangefeedDetails,
 	progress jobspb.Progress,
+	retryLog []jobspb.ChangefeedRetryLogEntry,
 	resultsCh chan<- tree.Datums,
 ) error {
 
@@ -120,9 +123,14 @@ func distChangefeedFlow(
 	if cf := progress.GetChangefeed(); cf != nil && cf.Checkpoint != nil {
 		checkpoint = *cf.Checkpoint
 	}
+	var transactions []jobspb.ChangefeedSinkTransaction
+	if cf := progress.GetChangefeed(); cf != nil {
+		transactions = cf.Transactions
+	}
 
 	return startDistChangefeed(
-		ctx, execCtx, jobID, schemaTS, details, initialHighWater, checkpoint, resultsCh)
+		ctx, execCtx, jobID, schemaTS, details, initialHighWater, checkpoint, transactions,
+		retryLog, resultsCh)
 }
 
 func fetchTableDescriptors(
@@ -223,6 +231,8 @@ func startDistChangefeed(
 	details jobspb.ChangefeedDetails,
 	initialHighWater hlc.Timestamp,
 	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+	transactions []jobspb.ChangefeedSinkTransaction,
+	retryLog []jobspb.ChangefeedRetryLogEntry,
 	resultsCh chan<- tree.Datums,
 ) error {
 	execCfg := execCtx.ExecCfg()
@@ -242,7 +252,8 @@ func startDistChangefeed(
 	dsp := execCtx.DistSQLPlanner()
 	evalCtx := execCtx.ExtendedEvalContext()
 
-	p, planCtx, err := makePlan(execCtx, jobID, details, initialHighWater, checkpoint, trackedSpans, selectClause)(ctx, dsp)
+	p, planCtx, err := makePlan(execCtx, jobID, details, initialHighWater, checkpoint,
+		transactions, retryLog, trackedSpans, selectClause)(ctx, dsp)
 	if err != nil {
 		return err
 	}
@@ -258,7 +269,8 @@ func startDistChangefeed(
 
 	replanner, stopReplanner := sql.PhysicalPlanChangeChecker(ctx,
 		p,
-		makePlan(execCtx, jobID, details, initialHighWater, checkpoint, trackedSpans, selectClause),
+		makePlan(execCtx, jobID, details, initialHighWater, checkpoint, transactions, retryLog,
+			trackedSpans, selectClause),
 		execCtx,
 		replanOracle,
 		func() time.Duration { return replanChangefeedFrequency.Get(execCtx.ExecCfg().SV()) },
@@ -313,6 +325,8 @@ func makePlan(
 	details jobspb.ChangefeedDetails,
 	initialHighWater hlc.Timestamp,
 	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+	transactions []jobspb.ChangefeedSinkTransaction,
+	retryLog []jobspb.ChangefeedRetryLogEntry,
 	trackedSpans []roachpb.Span,
 	selectClause string,
 ) func(context.Context, *sql.DistSQLPlanner) (*sql.PhysicalPlan, *sql.PlanningCtx, error) {
@@ -323,17 +337,10 @@ func makePlan(
 		planCtx := dsp.NewPlanningCtx(ctx, execCtx.ExtendedEvalContext(), nil /* planner */, blankTxn,
 			sql.DistributionTypeAlways)
 
-		var spanPartitions []sql.SpanPartition
-		if details.SinkURI == `` {
-			// Sinkless feeds get one ChangeAggregator on the gateway.
-			spanPartitions = []sql.SpanPartition{{SQLInstanceID: dsp.GatewayID(), Spans: trackedSpans}}
-		} else {
-			// All other feeds get a ChangeAggregator local on the leaseholder.
-			var err error
-			spanPartitions, err = dsp.PartitionSpans(ctx, planCtx, trackedSpans)
-			if err != nil {
-				return nil, nil, err
-			}
+		aggregators, frontier, err := distributeChangefeed(
+			ctx, dsp, planCtx, details, initialHighWater, checkpoint, trackedSpans)
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// Use the same checkpoint for all aggregators; each aggregator will only look at
@@ -344,30 +351,39 @@ func makePlan(
 			Timestamp: checkpoint.Timestamp,
 		}
 
-		var checkpointSpanGroup roachpb.SpanGroup
-		checkpointSpanGroup.Add(checkpoint.Spans...)
+		// The aggregators encrypt the values of the messages with a data key
+		// which is generated and wrapped by the KMS of the changefeed when the
+		// flow is planned, so that all of them share the key; they receive it
+		// in its wrapped form only, and unwrap it with the KMS.
+		var encryption *execinfrapb.ChangeAggregatorSpec_Encryption
+		if kmsURI, ok, err := changefeedbase.MakeStatementOptions(details.Opts).GetEncryptionKMS(); err != nil {
+			return nil, nil, err
+		} else if ok {
+			encryption, err = makeAggregatorEncryption(ctx, execCtx.ExecCfg(), kmsURI, execCtx.User())
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "invalid %s", changefeedbase.OptEncryptionKMS)
+			}
+		}
 
-		aggregatorSpecs := make([]*execinfrapb.ChangeAggregatorSpec, len(spanPartitions))
-		for i, sp := range spanPartitions {
-			watches := make([]execinfrapb.ChangeAggregatorSpec_Watch, len(sp.Spans))
-			for watchIdx, nodeSpan := range sp.Spans {
-				initialResolved := initialHighWater
-				if checkpointSpanGroup.Encloses(nodeSpan) {
-					initialResolved = checkpoint.Timestamp
-				}
+		aggregatorSpecs := make([]*execinfrapb.ChangeAggregatorSpec, len(aggregators))
+		for i, a := range aggregators {
+			watches := make([]execinfrapb.ChangeAggregatorSpec_Watch, len(a.Watches))
+			for watchIdx, w := range a.Watches {
 				watches[watchIdx] = execinfrapb.ChangeAggregatorSpec_Watch{
-					Span:            nodeSpan,
-					InitialResolved: initialResolved,
+					Span:            w.Span,
+					InitialResolved: w.InitialResolved,
 				}
 			}
 
 			aggregatorSpecs[i] = &execinfrapb.ChangeAggregatorSpec{
-				Watches:    watches,
-				Checkpoint: aggregatorCheckpoint,
-				Feed:       details,
-				UserProto:  execCtx.User().EncodeProto(),
-				JobID:      jobID,
-				Select:     execinfrapb.Expression{Expr: selectClause},
+				Watches:      watches,
+				Checkpoint:   aggregatorCheckpoint,
+				Feed:         details,
+				UserProto:    execCtx.User().EncodeProto(),
+				JobID:        jobID,
+				Select:       execinfrapb.Expression{Expr: selectClause},
+				Transactions: transactions,
+				Encryption:   encryption,
 			}
 		}
 
@@ -376,10 +392,11 @@ func makePlan(
 		// is created, even if it is paused and unpaused, but #28982 describes some
 		// ways that this might happen in the future.
 		changeFrontierSpec := execinfrapb.ChangeFrontierSpec{
-			TrackedSpans: trackedSpans,
+			TrackedSpans: frontier.TrackedSpans,
 			Feed:         details,
 			JobID:        jobID,
 			UserProto:    execCtx.User().EncodeProto(),
+			RetryLog:     retryLog,
 		}
 
 		cfKnobs := execCtx.ExecCfg().DistSQLSrv.TestingKnobs.Changefeed
@@ -387,16 +404,16 @@ func makePlan(
 			knobs.OnDistflowSpec(aggregatorSpecs, &changeFrontierSpec)
 		}
 
-		aggregatorCorePlacement := make([]physicalplan.ProcessorCorePlacement, len(spanPartitions))
-		for i, sp := range spanPartitions {
-			aggregatorCorePlacement[i].SQLInstanceID = sp.SQLInstanceID
+		aggregatorCorePlacement := make([]physicalplan.ProcessorCorePlacement, len(aggregators))
+		for i, a := range aggregators {
+			aggregatorCorePlacement[i].SQLInstanceID = a.SQLInstanceID
 			aggregatorCorePlacement[i].Core.ChangeAggregator = aggregatorSpecs[i]
 		}
 
 		p := planCtx.NewPhysicalPlan()
 		p.AddNoInputStage(aggregatorCorePlacement, execinfrapb.PostProcessSpec{}, changefeedResultTypes, execinfrapb.Ordering{})
 		p.AddSingleGroupStage(
-			dsp.GatewayID(),
+			frontier.SQLInstanceID,
 			execinfrapb.ProcessorCoreUnion{ChangeFrontier: &changeFrontierSpec},
 			execinfrapb.PostProcessSpec{},
 			changefeedResultTypes,
@@ -409,6 +426,115 @@ func makePlan(
 	}
 }
 
+// AggregatorAssignment describes a ChangeAggregator processor of a
+// changefeed: the SQL instance it runs on, and the spans it watches.
+type AggregatorAssignment struct {
+	SQLInstanceID base.SQLInstanceID `json:"sql_instance_id"`
+	Watches       []WatchAssignment  `json:"watches"`
+}
+
+// WatchAssignment describes a span watched by a ChangeAggregator processor,
+// along with the timestamp as of which the span is initially resolved.
+type WatchAssignment struct {
+	Span            roachpb.Span  `json:"span"`
+	InitialResolved hlc.Timestamp `json:"initial_resolved"`
+}
+
+// FrontierAssignment describes the ChangeFrontier processor of a changefeed:
+// the SQL instance it runs on, and the spans it tracks.
+type FrontierAssignment struct {
+	SQLInstanceID base.SQLInstanceID `json:"sql_instance_id"`
+	TrackedSpans  []roachpb.Span     `json:"tracked_spans"`
+}
+
+// PlanChangefeedDistribution returns how a changefeed with the specified
+// details would be distributed if it started from the specified high water
+// and checkpoint, without creating a job or running any processors. The
+// assignments are the ones the changefeed itself is planned with, at the time
+// of the call; the distribution of a running changefeed may change as ranges
+// move, or when its flow is replanned.
+//
+// The spans of the targets are resolved as of the timestamp following the
+// high water or, if it is empty, as of the statement time of the changefeed.
+func PlanChangefeedDistribution(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	details jobspb.ChangefeedDetails,
+	initialHighWater hlc.Timestamp,
+	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+) ([]AggregatorAssignment, FrontierAssignment, error) {
+	schemaTS := details.StatementTime
+	if !initialHighWater.IsEmpty() {
+		schemaTS = initialHighWater.Next()
+	}
+	tableDescs, err := fetchTableDescriptors(ctx, execCtx.ExecCfg(), AllTargets(details), schemaTS)
+	if err != nil {
+		return nil, FrontierAssignment{}, err
+	}
+	trackedSpans, _, err := fetchSpansForTables(ctx, execCtx, tableDescs, details)
+	if err != nil {
+		return nil, FrontierAssignment{}, err
+	}
+
+	var blankTxn *kv.Txn
+	dsp := execCtx.DistSQLPlanner()
+	planCtx := dsp.NewPlanningCtx(ctx, execCtx.ExtendedEvalContext(), nil /* planner */, blankTxn,
+		sql.DistributionTypeAlways)
+	return distributeChangefeed(ctx, dsp, planCtx, details, initialHighWater, checkpoint, trackedSpans)
+}
+
+// distributeChangefeed assigns the tracked spans of a changefeed to its
+// ChangeAggregator processors, and its ChangeFrontier processor to the
+// gateway. The aggregators of sinkless changefeeds all run on the gateway,
+// unless the changefeed is distributed (see changefeedbase.OptDistributed).
+func distributeChangefeed(
+	ctx context.Context,
+	dsp *sql.DistSQLPlanner,
+	planCtx *sql.PlanningCtx,
+	details jobspb.ChangefeedDetails,
+	initialHighWater hlc.Timestamp,
+	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+	trackedSpans []roachpb.Span,
+) ([]AggregatorAssignment, FrontierAssignment, error) {
+	var spanPartitions []sql.SpanPartition
+	opts := changefeedbase.MakeStatementOptions(details.Opts)
+	if details.SinkURI == `` && !opts.IsSet(changefeedbase.OptDistributed) {
+		// Sinkless feeds get one ChangeAggregator on the gateway.
+		spanPartitions = []sql.SpanPartition{{SQLInstanceID: dsp.GatewayID(), Spans: trackedSpans}}
+	} else {
+		// All other feeds get a ChangeAggregator local on the leaseholder. The
+		// rows of distributed sinkless feeds flow back to the ChangeFrontier on
+		// the gateway, which passes them through to the client.
+		var err error
+		spanPartitions, err = dsp.PartitionSpans(ctx, planCtx, trackedSpans)
+		if err != nil {
+			return nil, FrontierAssignment{}, err
+		}
+	}
+
+	var checkpointSpanGroup roachpb.SpanGroup
+	checkpointSpanGroup.Add(checkpoint.Spans...)
+
+	aggregators := make([]AggregatorAssignment, len(spanPartitions))
+	for i, sp := range spanPartitions {
+		watches := make([]WatchAssignment, len(sp.Spans))
+		for watchIdx, nodeSpan := range sp.Spans {
+			initialResolved := initialHighWater
+			if checkpointSpanGroup.Encloses(nodeSpan) {
+				initialResolved = checkpoint.Timestamp
+			}
+			watches[watchIdx] = WatchAssignment{
+				Span:            nodeSpan,
+				InitialResolved: initialResolved,
+			}
+		}
+		aggregators[i] = AggregatorAssignment{SQLInstanceID: sp.SQLInstanceID, Watches: watches}
+	}
+
+	frontier := FrontierAssignment{SQLInstanceID: dsp.GatewayID(), TrackedSpans: trackedSpans}
+	return aggregators, frontier, nil
+}
+
 // changefeedResultWriter implements the `sql.rowResultWriter` that sends
 // the received rows back over the given channel.
 type changefeedResultWriter struct {
@@ -26,6 +26,8 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
@@ -59,12 +61,22 @@ type changeAggregator struct {
 	// kvFeedDoneCh is closed when the kvfeed exits.
 	kvFeedDoneCh chan struct{}
 	kvFeedMemMon *mon.BytesMonitor
+	// oversizedEventMemMon is the monitor of the memory required by the
+	// oversized events in excess of the per changefeed limit.
+	oversizedEventMemMon *mon.BytesMonitor
 
 	// encoder is the Encoder to use for key and value serialization.
 	encoder Encoder
 	// sink is the Sink to write rows to. Resolved timestamps are never written
 	// by changeAggregator.
 	sink EventSink
+	// deadLetters, if set, receives the rows which cannot be encoded or
+	// delivered to the sink (see changefeedbase.OptDeadLetter).
+	deadLetters *deadLetterQueue
+	// releaseSinkThrottle, if set, releases the throttler of the messages
+	// emitted to the sink, which is shared by the aggregators of the
+	// changefeed on the node.
+	releaseSinkThrottle func()
 	// changedRowBuf, if non-nil, contains changed rows to be emitted. Anything
 	// queued in `resolvedSpanBuf` is dependent on these having been emitted, so
 	// this one must be empty before moving on to that one.
@@ -77,6 +89,10 @@ type changeAggregator struct {
 	// span was forwarded to the frontier
 	recentKVCount uint64
 
+	// resolvedOnly is set if the changefeed only emits resolved timestamps, in
+	// which case KV events are dropped (see changefeedbase.OptResolvedOnly).
+	resolvedOnly bool
+
 	// eventProducer produces the next event from the kv feed.
 	eventProducer kvevent.Reader
 	// eventConsumer consumes the event.
@@ -90,6 +106,16 @@ type changeAggregator struct {
 	// boundary information.
 	frontier *schemaChangeFrontier
 
+	// transactional is set if the sink emits the rows in transactions (see
+	// TransactionalEventSink).
+	transactional bool
+	// committedFrontier, if non-nil, tracks the timestamps at or below which
+	// the rows of the spans were committed in the sink transactions recorded in
+	// the job progress, which must not be emitted again. committedUpTo is the
+	// greatest of these timestamps.
+	committedFrontier *span.Frontier
+	committedUpTo     hlc.Timestamp
+
 	metrics    *Metrics
 	sliMetrics *sliMetrics
 	knobs      TestingKnobs
@@ -252,6 +278,10 @@ func (ca *changeAggregator) Start(ctx context.Context) {
 	kvFeedMemMon := mon.NewMonitorInheritWithLimit("kvFeed", limit, pool)
 	kvFeedMemMon.StartNoReserved(ctx, pool)
 	ca.kvFeedMemMon = kvFeedMemMon
+	maxEventSize := changefeedbase.MaxEventSize.Get(&ca.flowCtx.Cfg.Settings.SV)
+	oversizedEventMemMon := mon.NewMonitorInheritWithLimit("kvFeedOversizedEvents", maxEventSize, pool)
+	oversizedEventMemMon.StartNoReserved(ctx, pool)
+	ca.oversizedEventMemMon = oversizedEventMemMon
 
 	// The job registry has a set of metrics used to monitor the various jobs it
 	// runs. They're all stored as the `metric.Struct` interface because of
@@ -281,8 +311,27 @@ func (ca *changeAggregator) Start(ctx context.Context) {
 	if b, ok := ca.sink.(*bufferSink); ok {
 		ca.changedRowBuf = &b.buf
 	}
+	_, ca.transactional = ca.sink.(TransactionalEventSink)
+	setSchemaFileSink(ca.encoder, ca.sink)
+
+	if deadLetterOpts, err := opts.GetDeadLetterOptions(); err != nil {
+		ca.MoveToDraining(err)
+		ca.cancel()
+		return
+	} else if deadLetterOpts != nil {
+		ca.deadLetters, err = makeDeadLetterQueue(ctx, ca.flowCtx.Cfg, ca.spec.Feed, *deadLetterOpts,
+			timestampOracle, ca.spec.User(), ca.spec.JobID, ca.metrics)
+		if err != nil {
+			ca.MoveToDraining(changefeedbase.MarkRetryableError(err))
+			ca.cancel()
+			return
+		}
+		if s, ok := ca.sink.(DeadLetterEventSink); ok {
+			s.SetDeadLetterQueue(ca.deadLetters)
+		}
+	}
 
-	ca.sink = &errorWrapperSink{wrapped: ca.sink}
+	ca.sink = &errorWrapperSink{wrapped: ca.sink, metrics: ca.sliMetrics}
 
 	// If the initial scan was disabled the highwater would've already been forwarded
 	needsInitialScan := ca.frontier.Frontier().IsEmpty()
@@ -303,9 +352,49 @@ func (ca *changeAggregator) Start(ctx context.Context) {
 		return
 	}
 
+	ca.resolvedOnly = opts.IsResolvedOnly()
+
+	var suppressor *duplicateSuppressor
+	if window, err := opts.GetSuppressDuplicatesWindow(); err != nil {
+		ca.MoveToDraining(err)
+		ca.cancel()
+		return
+	} else if window != nil {
+		suppressor = newDuplicateSuppressor(*window,
+			changefeedbase.SuppressDuplicatesMemoryLimit.Get(&ca.flowCtx.Cfg.Settings.SV),
+			&ca.memAcc, ca.metrics)
+	}
+
+	sinkThrottleConfig, err := opts.GetSinkThrottleConfig()
+	if err != nil {
+		ca.MoveToDraining(err)
+		ca.cancel()
+		return
+	}
+	var sinkThrottle *cdcutils.Throttler
+	sinkThrottle, ca.releaseSinkThrottle = cdcutils.SinkThrottler(&ca.flowCtx.Cfg.Settings.SV,
+		int64(ca.spec.JobID), sinkThrottleConfig, &ca.metrics.ThrottleMetrics)
+
+	// The data key with which the values of the messages are encrypted is
+	// planned in its wrapped form, and unwrapped with the KMS of the changefeed.
+	var encryptor *messageEncryptor
+	if kmsURI, ok, err := opts.GetEncryptionKMS(); err != nil {
+		ca.MoveToDraining(err)
+		ca.cancel()
+		return
+	} else if ok && ca.spec.Encryption != nil {
+		encryptor, err = makeMessageEncryptor(ctx, ca.flowCtx.Cfg, kmsURI, ca.spec.User(), ca.spec.Encryption)
+		if err != nil {
+			ca.MoveToDraining(errors.Wrapf(err, "invalid %s", changefeedbase.OptEncryptionKMS))
+			ca.cancel()
+			return
+		}
+	}
+
 	ca.eventConsumer, err = newKVEventToRowConsumer(
 		ctx, ca.flowCtx.Cfg, ca.flowCtx.EvalCtx, ca.frontier.SpanFrontier(), kvFeedHighWater,
-		ca.sink, ca.encoder, feed, ca.spec.Select, ca.knobs, ca.topicNamer)
+		ca.sink, ca.encoder, feed, ca.spec.Select, ca.knobs, ca.topicNamer, suppressor,
+		ca.deadLetters, sinkThrottle, ca.spec.JobID, encryptor)
 
 	if err != nil {
 		// Early abort in the case that there is an error setting up the consumption.
@@ -324,7 +413,10 @@ func (ca *changeAggregator) startKVFeed(
 ) (kvevent.Reader, error) {
 	cfg := ca.flowCtx.Cfg
 	buf := kvevent.NewThrottlingBuffer(
-		kvevent.NewMemBuffer(ca.kvFeedMemMon.MakeBoundAccount(), &cfg.Settings.SV, &ca.metrics.KVFeedMetrics),
+		kvevent.NewMemBufferWithOversizedEvents(
+			ca.kvFeedMemMon.MakeBoundAccount(), ca.oversizedEventMemMon.MakeBoundAccount(),
+			&cfg.Settings.SV, &ca.metrics.KVFeedMetrics,
+		),
 		cdcutils.NodeLevelThrottler(&cfg.Settings.SV, &ca.metrics.ThrottleMetrics))
 
 	// KVFeed takes ownership of the kvevent.Writer portion of the buffer, while
@@ -398,6 +490,7 @@ func (ca *changeAggregator) makeKVFeedCfg(
 		OnBackfillCallback:      ca.sliMetrics.getBackfillCallback(),
 		OnBackfillRangeCallback: ca.sliMetrics.getBackfillRangeCallback(),
 		MM:                      ca.kvFeedMemMon,
+		OversizedEventMM:        ca.oversizedEventMemMon,
 		InitialHighWater:        initialHighWater,
 		EndTime:                 endTime,
 		WithDiff:                filters.WithDiff,
@@ -450,9 +543,43 @@ func (ca *changeAggregator) setupSpansAndFrontier() (spans []roachpb.Span, err e
 			return nil, err
 		}
 	}
+
+	// The rows of the spans of the recorded sink transactions were committed
+	// at or below the resolved timestamps of the transactions, which may be
+	// above the highwater mark the kv feed restarts from.
+	if len(ca.spec.Transactions) > 0 {
+		ca.committedFrontier, err = span.MakeFrontier(spans...)
+		if err != nil {
+			return nil, err
+		}
+		for _, txn := range ca.spec.Transactions {
+			for _, sp := range txn.Spans {
+				if _, err := ca.committedFrontier.Forward(sp, txn.Resolved); err != nil {
+					return nil, err
+				}
+			}
+			ca.committedUpTo.Forward(txn.Resolved)
+		}
+	}
 	return spans, nil
 }
 
+// committed returns true if the row of the key, at the specified timestamp,
+// was committed in a recorded sink transaction before the changefeed
+// restarted.
+func (ca *changeAggregator) committed(key roachpb.Key, ts hlc.Timestamp) bool {
+	if ca.committedFrontier == nil || ca.committedUpTo.Less(ts) {
+		return false
+	}
+	committed := false
+	ca.committedFrontier.SpanEntries(roachpb.Span{Key: key, EndKey: key.Next()},
+		func(_ roachpb.Span, committedTS hlc.Timestamp) span.OpResult {
+			committed = ts.LessEq(committedTS)
+			return span.StopMatch
+		})
+	return committed
+}
+
 // close has two purposes: to synchronize on the completion of the helper
 // goroutines created by the Start method, and to clean up any resources used by
 // the processor. Due to the fact that this method may be called even if the
@@ -472,11 +599,22 @@ func (ca *changeAggregator) close() {
 			log.Warningf(ca.Ctx, `error closing sink. goroutines may have leaked: %v`, err)
 		}
 	}
+	if ca.deadLetters != nil {
+		if err := ca.deadLetters.Close(); err != nil {
+			log.Warningf(ca.Ctx, `error closing dead letter sink. goroutines may have leaked: %v`, err)
+		}
+	}
+	if ca.releaseSinkThrottle != nil {
+		ca.releaseSinkThrottle()
+	}
 
 	ca.memAcc.Close(ca.Ctx)
 	if ca.kvFeedMemMon != nil {
 		ca.kvFeedMemMon.Stop(ca.Ctx)
 	}
+	if ca.oversizedEventMemMon != nil {
+		ca.oversizedEventMemMon.Stop(ca.Ctx)
+	}
 	ca.MemMonitor.Stop(ca.Ctx)
 	ca.InternalClose()
 }
@@ -537,6 +675,18 @@ func (ca *changeAggregator) tick() error {
 			ca.sliMetrics.AdmitLatency.RecordValue(timeutil.Since(event.Timestamp().GoTime()).Nanoseconds())
 		}
 		ca.recentKVCount++
+		if ca.resolvedOnly {
+			// The rows are not emitted, but the frontier still advances as the
+			// resolved events are received.
+			a := event.DetachAlloc()
+			a.Release(ca.Ctx)
+			return nil
+		}
+		if ca.committed(event.KV().Key, event.Timestamp()) {
+			a := event.DetachAlloc()
+			a.Release(ca.Ctx)
+			return nil
+		}
 		return ca.eventConsumer.ConsumeEvent(ca.Ctx, event)
 	case kvevent.TypeResolved:
 		a := event.DetachAlloc()
@@ -546,7 +696,10 @@ func (ca *changeAggregator) tick() error {
 			return ca.noteResolvedSpan(resolved)
 		}
 	case kvevent.TypeFlush:
-		return ca.sink.Flush(ca.Ctx)
+		if err := ca.sink.Flush(ca.Ctx); err != nil {
+			return err
+		}
+		return ca.flushDeadLetters()
 	}
 
 	return nil
@@ -588,13 +741,16 @@ func (ca *changeAggregator) flushFrontier() error {
 	// Make sure to flush the sink before forwarding resolved spans,
 	// otherwise, we could lose buffered messages and violate the
 	// at-least-once guarantee. This is also true for checkpointing the
-	// resolved spans in the job progress.
-	if err := ca.sink.Flush(ca.Ctx); err != nil {
+	// resolved spans in the job progress. For the same reason, rows retained
+	// by duplicate suppression must be emitted first.
+	if err := ca.eventConsumer.flushSuppressed(ca.Ctx); err != nil {
 		return err
 	}
+	start := timeutil.Now()
 
 	// Iterate frontier spans and build a list of spans to emit.
 	var batch jobspb.ResolvedSpans
+	var maxResolved hlc.Timestamp
 	ca.frontier.Entries(func(s roachpb.Span, ts hlc.Timestamp) span.OpResult {
 		boundaryType := jobspb.ResolvedSpan_NONE
 		if ca.frontier.boundaryTime.Equal(ts) {
@@ -606,18 +762,70 @@ func (ca *changeAggregator) flushFrontier() error {
 			Timestamp:    ts,
 			BoundaryType: boundaryType,
 		})
+		maxResolved.Forward(ts)
 		return span.ContinueMatch
 	})
 
+	if ca.transactional {
+		// A transactional sink only emits the rows at or below the local
+		// frontier, all of which were emitted by now, so that the restarted
+		// changefeed can resume the spans from the local frontier once the
+		// transaction is recorded (see sinkTransactions).
+		resolved := ca.frontier.Frontier()
+		txn, err := ca.sink.(TransactionalEventSink).PrepareUpTo(ca.Ctx, resolved)
+		if err != nil {
+			return err
+		}
+		if txn != nil {
+			txn.Resolved = resolved
+			for _, w := range ca.spec.Watches {
+				txn.Spans = append(txn.Spans, w.Span)
+			}
+			batch.Transaction = txn
+		}
+	} else if err := ca.flushUpTo(maxResolved); err != nil {
+		// The resolved spans only cover the rows emitted at or below their
+		// timestamps, so there is no need to wait for the rows emitted at later
+		// timestamps, which may be backed up behind a slow sink, to be
+		// delivered.
+		return err
+	}
+	ca.metrics.ResolvedFlushDelayNanos.RecordValue(timeutil.Since(start).Nanoseconds())
+
 	return ca.emitResolved(batch)
 }
 
+// flushUpTo flushes the rows emitted to the sink at or below the specified
+// timestamp, or all of the rows if the sink does not support partial flushes.
+func (ca *changeAggregator) flushUpTo(ts hlc.Timestamp) error {
+	if fs, ok := ca.sink.(ResolvedFlushingEventSink); ok && !ts.IsEmpty() {
+		if err := fs.FlushUpTo(ca.Ctx, ts); err != nil {
+			return err
+		}
+	} else if err := ca.sink.Flush(ca.Ctx); err != nil {
+		return err
+	}
+	return ca.flushDeadLetters()
+}
+
+// flushDeadLetters flushes the rows routed to the dead letter queue, which
+// includes the rows the sink failed to deliver while it was flushed.
+func (ca *changeAggregator) flushDeadLetters() error {
+	if ca.deadLetters == nil {
+		return nil
+	}
+	return ca.deadLetters.Flush(ca.Ctx)
+}
+
 func (ca *changeAggregator) emitResolved(batch jobspb.ResolvedSpans) error {
 	progressUpdate := jobspb.ResolvedSpans{
 		ResolvedSpans: batch.ResolvedSpans,
 		Stats: jobspb.ResolvedSpans_Stats{
 			RecentKvCount: ca.recentKVCount,
+			// The sink was flushed before emitting the resolved spans.
+			Emitted: ca.eventConsumer.takeEmitted(),
 		},
+		Transaction: batch.Transaction,
 	}
 	updateBytes, err := protoutil.Marshal(&progressUpdate)
 	if err != nil {
@@ -676,6 +884,20 @@ type changeFrontier struct {
 	// lastProtectedTimestampUpdate is the last time the protected timestamp
 	// record was updated to the frontier's highwater mark
 	lastProtectedTimestampUpdate time.Time
+	// gcTTL is the smallest GC TTL of the target tables, as of lastGCTTLRead.
+	gcTTL         time.Duration
+	lastGCTTLRead time.Time
+
+	// pendingEmitted accumulates the messages emitted per table by the
+	// aggregators which are yet to be added to the job progress.
+	pendingEmitted emittedStats
+	// pendingRetryLog are the retryable errors which caused the flow to
+	// restart, which are yet to be recorded in the job progress.
+	pendingRetryLog []jobspb.ChangefeedRetryLogEntry
+	// sinkTxns are the transactions prepared by the aggregators if the sink
+	// is transactional, which the frontier records in the job progress and
+	// then commits.
+	sinkTxns sinkTransactions
 
 	// js, if non-nil, is called to checkpoint the changefeed's
 	// progress in the corresponding system job entry.
@@ -846,6 +1068,8 @@ func newChangeFrontierProcessor(
 		input:         input,
 		frontier:      sf,
 		slowLogEveryN: log.Every(slowSpanMaxFrequency),
+
+		pendingRetryLog: spec.RetryLog,
 	}
 
 	if cfKnobs, ok := flowCtx.TestingKnobs().Changefeed.(*TestingKnobs); ok {
@@ -941,8 +1165,9 @@ func (cf *changeFrontier) Start(ctx context.Context) {
 	if b, ok := cf.sink.(*bufferSink); ok {
 		cf.resolvedBuf = &b.buf
 	}
+	setSchemaFileSink(cf.encoder, cf.sink)
 
-	cf.sink = &errorWrapperSink{wrapped: cf.sink}
+	cf.sink = &errorWrapperSink{wrapped: cf.sink, metrics: cf.sliMetrics}
 
 	cf.highWaterAtStart = cf.spec.Feed.StatementTime
 	if cf.spec.JobID != 0 {
@@ -976,6 +1201,17 @@ func (cf *changeFrontier) Start(ctx context.Context) {
 			}
 		}
 
+		// The sink transactions which were recorded in the job progress, but
+		// not committed, before the changefeed restarted are committed now,
+		// since the restarted aggregators do not emit their rows again.
+		if changefeedProgress := p.GetChangefeed(); changefeedProgress != nil {
+			cf.sinkTxns.txns = changefeedProgress.Transactions
+			if err := cf.sinkTxns.commit(ctx, cf.sink); err != nil {
+				cf.MoveToDraining(err)
+				return
+			}
+		}
+
 		if p.RunningStatus != "" {
 			// If we had running status set, that means we're probably retrying
 			// due to a transient error.  In that case, keep the previous
@@ -1111,6 +1347,15 @@ func (cf *changeFrontier) noteAggregatorProgress(d rowenc.EncDatum) error {
 	}
 
 	cf.maybeMarkJobIdle(resolvedSpans.Stats.RecentKvCount)
+	if cf.spec.JobID != 0 {
+		cf.pendingEmitted.merge(resolvedSpans.Stats.Emitted)
+	}
+
+	// The transaction holds rows at or below the resolved spans, so it must be
+	// recorded with any checkpoint of a high-water which they advance.
+	if resolvedSpans.Transaction != nil {
+		cf.sinkTxns.add(*resolvedSpans.Transaction)
+	}
 
 	for _, resolved := range resolvedSpans.ResolvedSpans {
 		// Inserting a timestamp less than the one the changefeed flow started at
@@ -1210,6 +1455,10 @@ func (cf *changeFrontier) maybeCheckpointJob(
 		(inBackfill || cf.frontier.hasLaggingSpans(cf.spec.Feed.StatementTime, &cf.js.settings.SV)) &&
 			cf.js.canCheckpointSpans()
 
+	// The sink transactions prepared by the aggregators are committed once
+	// they are recorded, which must not be delayed lest they time out.
+	updateTransactions := cf.sinkTxns.hasUncommitted()
+
 	// If the highwater has moved an empty checkpoint will be saved
 	var checkpoint jobspb.ChangefeedProgress_Checkpoint
 	if updateCheckpoint {
@@ -1217,9 +1466,16 @@ func (cf *changeFrontier) maybeCheckpointJob(
 		checkpoint.Spans, checkpoint.Timestamp = cf.frontier.getCheckpointSpans(maxBytes)
 	}
 
-	if updateCheckpoint || updateHighWater {
+	if updateCheckpoint || updateHighWater || updateTransactions {
+		// The changefeed must restart from the rows which the consumers of the
+		// sink did not acknowledge yet, so the spans checkpointed past them are
+		// not recorded either.
+		highWater, capped := cf.acknowledgedHighWater()
+		if capped {
+			checkpoint = jobspb.ChangefeedProgress_Checkpoint{}
+		}
 		checkpointStart := timeutil.Now()
-		updated, err := cf.checkpointJobProgress(cf.frontier.Frontier(), checkpoint)
+		updated, err := cf.checkpointJobProgress(highWater, checkpoint)
 		if err != nil {
 			return false, err
 		}
@@ -1230,6 +1486,28 @@ func (cf *changeFrontier) maybeCheckpointJob(
 	return false, nil
 }
 
+// acknowledgedHighWater returns the high-water of the changefeed, capped by
+// the resolved timestamp acknowledged by the consumers of the sink if it
+// requires acknowledgements (see AcknowledgingSink), along with whether it
+// was capped. The high-water of the job does not regress while nothing was
+// acknowledged since the changefeed started.
+func (cf *changeFrontier) acknowledgedHighWater() (hlc.Timestamp, bool) {
+	highWater := cf.frontier.Frontier()
+	as, ok := cf.sink.(AcknowledgingSink)
+	if !ok {
+		return highWater, false
+	}
+	acked, requiresAck := as.AcknowledgedResolved()
+	if !requiresAck {
+		return highWater, false
+	}
+	acked.Forward(cf.frontier.initialHighWater)
+	if acked.Less(highWater) {
+		return acked, true
+	}
+	return highWater, false
+}
+
 func (cf *changeFrontier) checkpointJobProgress(
 	frontier hlc.Timestamp, checkpoint jobspb.ChangefeedProgress_Checkpoint,
 ) (bool, error) {
@@ -1259,6 +1537,11 @@ func (cf *changeFrontier) checkpointJobProgress(
 
 			changefeedProgress := progress.Details.(*jobspb.Progress_Changefeed).Changefeed
 			changefeedProgress.Checkpoint = &checkpoint
+			changefeedProgress.EmittedStats = cf.pendingEmitted.addedTo(changefeedProgress.EmittedStats)
+			if len(cf.pendingRetryLog) > 0 {
+				changefeedProgress.RetryLog = mergeRetryLog(changefeedProgress.RetryLog, cf.pendingRetryLog...)
+			}
+			changefeedProgress.Transactions = cf.sinkTxns.recordedWith(frontier)
 
 			timestampManager := cf.manageProtectedTimestamps
 			// TODO(samiskin): Remove this conditional and the associated deprecated
@@ -1298,6 +1581,16 @@ func (cf *changeFrontier) checkpointJobProgress(
 		log.Warningf(cf.Ctx, "skipping changefeed checkpoint: %s", updateSkipped)
 		return false, nil
 	}
+	// The emitted messages and the retryable errors are now accounted for in
+	// the job progress.
+	cf.pendingEmitted.take()
+	cf.pendingRetryLog = nil
+
+	// The sink transactions are now recorded in the job progress, so they can
+	// be committed. This must happen before the resolved timestamp is emitted.
+	if err := cf.sinkTxns.commit(cf.Ctx, cf.sink); err != nil {
+		return false, err
+	}
 
 	if cf.knobs.RaiseRetryableError != nil {
 		if err := cf.knobs.RaiseRetryableError(); err != nil {
@@ -1323,7 +1616,7 @@ func (cf *changeFrontier) manageProtectedTimestamps(
 	pts := cf.flowCtx.Cfg.ProtectedTimestampProvider
 
 	// Create / advance the protected timestamp record to the highwater mark
-	highWater := cf.frontier.Frontier()
+	highWater, _ := cf.acknowledgedHighWater()
 	if highWater.Less(cf.highWaterAtStart) {
 		highWater = cf.highWaterAtStart
 	}
@@ -1348,22 +1641,78 @@ func (cf *changeFrontier) manageProtectedTimestamps(
 // changefeed is in a backfill or the highwater is lagging behind to a
 // sufficient degree after a backfill.  This was deprecated in favor of always
 // maintaining a timestamp record to avoid issues with a low gcttl setting.
+//
+// The targets are also protected while the highwater lags behind by more than
+// a fraction of the GC TTL of the target tables, whose data the changefeed
+// still needs would otherwise be garbage collected; the record is advanced to
+// the highwater as the frontier moves, and released once it catches up.
 func (cf *changeFrontier) deprecatedManageProtectedTimestamps(
 	ctx context.Context, txn *kv.Txn, progress *jobspb.ChangefeedProgress,
 ) error {
 	pts := cf.flowCtx.Cfg.ProtectedTimestampProvider
-	if err := cf.deprecatedMaybeReleaseProtectedTimestamp(ctx, progress, pts, txn); err != nil {
+	highWater := cf.frontier.Frontier()
+	lagging, err := cf.lagsBehindGCTTL(ctx, txn, highWater)
+	if err != nil {
 		return err
 	}
+	if !lagging {
+		if err := cf.deprecatedMaybeReleaseProtectedTimestamp(ctx, progress, pts, txn); err != nil {
+			return err
+		}
+	}
 
 	schemaChangePolicy := changefeedbase.SchemaChangePolicy(cf.spec.Feed.Opts[changefeedbase.OptSchemaChangePolicy])
 	shouldProtectBoundaries := schemaChangePolicy == changefeedbase.OptSchemaChangePolicyBackfill
-	if cf.frontier.schemaChangeBoundaryReached() && shouldProtectBoundaries {
-		highWater := cf.frontier.Frontier()
+	if cf.frontier.schemaChangeBoundaryReached() && shouldProtectBoundaries && !lagging {
 		ptr := createProtectedTimestampRecord(ctx, cf.flowCtx.Codec(), cf.spec.JobID, AllTargets(cf.spec.Feed), highWater, progress)
 		return pts.Protect(ctx, txn, ptr)
 	}
-	return nil
+	if !lagging {
+		return nil
+	}
+	if recordID := progress.ProtectedTimestampRecord; recordID != uuid.Nil {
+		log.VEventf(ctx, 2, "updating protected timestamp %v at %v", recordID, highWater)
+		return pts.UpdateTimestamp(ctx, txn, recordID, highWater)
+	}
+	log.VEventf(ctx, 2, "protecting targets at %v, which lags behind their GC TTL", highWater)
+	ptr := createProtectedTimestampRecord(ctx, cf.flowCtx.Codec(), cf.spec.JobID, AllTargets(cf.spec.Feed), highWater, progress)
+	return pts.Protect(ctx, txn, ptr)
+}
+
+// gcTTLProtectionFraction is the fraction of the GC TTL of the target tables
+// beyond which a lagging highwater is protected, so that the record is written
+// before the data the changefeed needs is garbage collected.
+const gcTTLProtectionFraction = 0.5
+
+// lagsBehindGCTTL returns true if the highwater lags behind the present by more
+// than gcTTLProtectionFraction of the smallest GC TTL of the target tables. The
+// GC TTLs are read at most every changefeed.protect_timestamp_interval.
+func (cf *changeFrontier) lagsBehindGCTTL(
+	ctx context.Context, txn *kv.Txn, highWater hlc.Timestamp,
+) (bool, error) {
+	if highWater.IsEmpty() {
+		return false, nil
+	}
+	ptsUpdateInterval := changefeedbase.ProtectTimestampInterval.Get(&cf.flowCtx.Cfg.Settings.SV)
+	if timeutil.Since(cf.lastGCTTLRead) >= ptsUpdateInterval {
+		var gcTTL time.Duration
+		targets := AllTargets(cf.spec.Feed)
+		if err := targets.EachTableID(func(id descpb.ID) error {
+			zone, err := sql.GetHydratedZoneConfigForTable(ctx, txn, cf.flowCtx.Codec(), id)
+			if err != nil {
+				return err
+			}
+			if ttl := time.Duration(zone.GC.TTLSeconds) * time.Second; gcTTL == 0 || ttl < gcTTL {
+				gcTTL = ttl
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		cf.gcTTL, cf.lastGCTTLRead = gcTTL, timeutil.Now()
+	}
+	lag := timeutil.Since(highWater.GoTime())
+	return cf.gcTTL > 0 && lag > time.Duration(float64(cf.gcTTL)*gcTTLProtectionFraction), nil
 }
 
 func (cf *changeFrontier) deprecatedMaybeReleaseProtectedTimestamp(
@@ -22,6 +22,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedvalidators"
 	"github.com/cockroachdb/cockroach/pkg/ccl/utilccl"
 	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn"
 	"github.com/cockroachdb/cockroach/pkg/docs"
 	"github.com/cockroachdb/cockroach/pkg/featureflag"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
@@ -210,7 +211,7 @@ func changefeedPlanHook(
 
 			var err error
 			for r := retry.StartWithCtx(ctx, changefeedRetryOptions); r.Next(); {
-				if err = distChangefeedFlow(ctx, p, 0 /* jobID */, details, progress, resultsCh); err == nil {
+				if err = distChangefeedFlow(ctx, p, 0 /* jobID */, details, progress, nil /* retryLog */, resultsCh); err == nil {
 					return nil
 				}
 
@@ -242,6 +243,7 @@ func changefeedPlanHook(
 		// of protected timestamps.
 		var sj *jobs.StartableJob
 		jobID := p.ExecCfg().JobRegistry.MakeJobID()
+		compactionJobID := jobspb.InvalidJobID
 		{
 			var ptr *ptpb.Record
 			codec := p.ExecCfg().Codec
@@ -258,10 +260,27 @@ func changefeedPlanHook(
 
 			jr.Progress = *progress.GetChangefeed()
 
+			compactionRecord, err := makeCompactionJobRecord(p.User(), jobID, opts)
+			if err != nil {
+				return err
+			}
+			if compactionRecord != nil {
+				compactionJobID = p.ExecCfg().JobRegistry.MakeJobID()
+			}
+
 			if err := p.ExecCfg().DB.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
 				if err := p.ExecCfg().JobRegistry.CreateStartableJobWithTxn(ctx, &sj, jobID, txn, *jr); err != nil {
 					return err
 				}
+				if compactionRecord != nil {
+					// The compaction job is adopted by the registry, and completes on its
+					// own once the changefeed is done.
+					if _, err := p.ExecCfg().JobRegistry.CreateAdoptableJobWithTxn(
+						ctx, *compactionRecord, compactionJobID, txn,
+					); err != nil {
+						return err
+					}
+				}
 				if ptr != nil {
 					return p.ExecCfg().ProtectedTimestampProvider.Protect(ctx, txn, ptr)
 				}
@@ -282,6 +301,9 @@ func changefeedPlanHook(
 		}
 
 		logChangefeedCreateTelemetry(ctx, jr)
+		if compactionJobID != jobspb.InvalidJobID {
+			p.BufferClientNotice(ctx, pgnotice.Newf(`the files of the changefeed are compacted by job %d`, compactionJobID))
+		}
 
 		select {
 		case <-ctx.Done():
@@ -402,9 +424,14 @@ func createChangefeedJobRecord(
 	}
 
 	if changefeedStmt.Select != nil {
+		lookupDescs, err := getLookupTableDescriptors(
+			ctx, p, changefeedStmt.Select, statementTime, initialHighWater)
+		if err != nil {
+			return nil, err
+		}
 		// Serialize changefeed expression.
 		normalized, _, err := validateAndNormalizeChangefeedExpression(
-			ctx, p, changefeedStmt.Select, targetDescs, targets, opts.IncludeVirtual(), opts.IsSet(changefeedbase.OptSplitColumnFamilies),
+			ctx, p, changefeedStmt.Select, targetDescs, lookupDescs, targets, opts.IncludeVirtual(), opts.IsSet(changefeedbase.OptSplitColumnFamilies),
 		)
 		if err != nil {
 			return nil, err
@@ -430,6 +457,41 @@ func createChangefeedJobRecord(
 		}
 	}
 
+	if partitionExpr, ok := opts.GetPartitionExpr(); ok {
+		if err := validatePartitionExpr(
+			ctx, p, partitionExpr, targetDescs, targets, opts.IncludeVirtual(),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateKafkaPartitioner(ctx, p, opts, targetDescs, targets); err != nil {
+		return nil, err
+	}
+
+	if column, ok := opts.GetSecurityLabelColumn(); ok {
+		if err := validateDecodedColumn(
+			changefeedbase.OptEmitSecurityLabel, column, targetDescs, targets,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	headersOpt, headers, err := opts.GetMessageHeaders()
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		if changefeedbase.IsMetadataHeader(header) {
+			continue
+		}
+		if err := validateDecodedColumn(
+			headersOpt, header, targetDescs, targets,
+		); err != nil {
+			return nil, err
+		}
+	}
+
 	// TODO(dan): In an attempt to present the most helpful error message to the
 	// user, the ordering requirements between all these usage validations have
 	// become extremely fragile and non-obvious.
@@ -474,6 +536,22 @@ func createChangefeedJobRecord(
 		return nil, err
 	}
 
+	if isCloudStorageSink(parsedSink) {
+		if err := validatePartitionTemplate(
+			ctx, p, parsedSink, targetDescs, targets, opts.IncludeVirtual(),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if isPubsubSink(parsedSink) {
+		if err := validateOrderingKeyExpr(
+			ctx, p, parsedSink, opts, targetDescs, targets,
+		); err != nil {
+			return nil, err
+		}
+	}
+
 	encodingOpts, err := opts.GetEncodingOptions()
 	if err != nil {
 		return nil, err
@@ -481,6 +559,25 @@ func createChangefeedJobRecord(
 	if _, err := getEncoder(encodingOpts, AllTargets(details)); err != nil {
 		return nil, err
 	}
+	for _, column := range encodingOpts.GetDiffColumns() {
+		if err := validateTableColumn(
+			changefeedbase.OptDiffColumns, column, targetDescs, targets,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if keyExpr, ok := opts.GetKeyExpr(); ok {
+		if encodingOpts.Format == changefeedbase.OptFormatCSV {
+			// The CSV records have no keys.
+			return nil, errors.Errorf(`%s is not supported with %s=%s`,
+				changefeedbase.OptKeyExpr, changefeedbase.OptFormat, changefeedbase.OptFormatCSV)
+		}
+		if err := validateKeyExpr(
+			ctx, p, keyExpr, targetDescs, targets, opts.IncludeVirtual(),
+		); err != nil {
+			return nil, err
+		}
+	}
 
 	//	 The changefeed is opted in to `OptKeyInValue` for any cloud
 	//   storage sink or webhook sink. Kafka etc have a key and value field in
@@ -497,12 +594,18 @@ func createChangefeedJobRecord(
 	//   the default error to avoid claiming the user set an option they didn't
 	//   explicitly set. Fortunately we know the only way to cause this is to
 	//   set envelope.
-	if isCloudStorageSink(parsedSink) || isWebhookSink(parsedSink) {
+	//   The sinks stored in external connections are resolved to tell which
+	//   kind of sink they are.
+	resolvedSink, err := resolveExternalConnectionSink(ctx, p, parsedSink)
+	if err != nil {
+		return nil, err
+	}
+	if isCloudStorageSink(resolvedSink) || isWebhookSink(resolvedSink) {
 		if err = opts.ForceKeyInValue(); err != nil {
 			return nil, errors.Errorf(`this sink is incompatible with envelope=%s`, encodingOpts.Envelope)
 		}
 	}
-	if isWebhookSink(parsedSink) {
+	if isWebhookSink(resolvedSink) {
 		if err = opts.ForceTopicInValue(); err != nil {
 			return nil, errors.Errorf(`this sink is incompatible with envelope=%s`, encodingOpts.Envelope)
 		}
@@ -545,6 +648,13 @@ func createChangefeedJobRecord(
 	details.Opts = opts.AsMap()
 
 	if details.SinkURI == `` {
+		for _, opt := range []string{
+			changefeedbase.OptDeadLetter, changefeedbase.OptAdditionalSinks, changefeedbase.OptProbeSink,
+		} {
+			if opts.IsSet(opt) {
+				return nil, errors.Errorf(`%s requires a sink`, opt)
+			}
+		}
 		// Jobs should not be created for sinkless changefeeds. However, note that
 		// we create and return a job record for sinkless changefeeds below. This is
 		// because we need the details field to create our sinkless changefeed.
@@ -576,7 +686,9 @@ func createChangefeedJobRecord(
 	// but are inappropriate for the provided sink.
 	// TODO: Ideally those option validations would happen in validateDetails()
 	// earlier, like the others.
-	err = validateSink(ctx, p, jobID, details, opts)
+	if err := validateSink(ctx, p, jobID, details, opts); err != nil {
+		return nil, err
+	}
 
 	jr := &jobs.Record{
 		Description: jobDescription,
@@ -590,7 +702,18 @@ func createChangefeedJobRecord(
 		Details: details,
 	}
 
-	return jr, err
+	retention, err := opts.GetJobRetention()
+	if err != nil {
+		return nil, err
+	}
+	if retention != nil {
+		jr.Retention = *retention
+	}
+	if _, err := opts.GetPTSExpiration(); err != nil {
+		return nil, err
+	}
+
+	return jr, nil
 }
 
 func validateSettings(ctx context.Context, p sql.PlanHookState) error {
@@ -663,6 +786,59 @@ func getTableDescriptors(
 	return targetDescs, err
 }
 
+// getLookupTableDescriptors returns the descriptors of the reference tables
+// joined by the changefeed expression, in the order in which they are joined.
+// The reference tables are read as of the timestamp of each event, which
+// requires SELECT privilege on them.
+func getLookupTableDescriptors(
+	ctx context.Context,
+	p sql.PlanHookState,
+	sc *tree.SelectClause,
+	statementTime hlc.Timestamp,
+	initialHighWater hlc.Timestamp,
+) ([]catalog.TableDescriptor, error) {
+	lookups := cdceval.LookupTables(sc)
+	if len(lookups) == 0 {
+		return nil, nil
+	}
+	descs := make([]catalog.TableDescriptor, len(lookups))
+	for i, lookup := range lookups {
+		var pattern tree.TablePattern
+		switch t := lookup.(type) {
+		case *tree.TableName:
+			pattern = t
+		case *tree.AliasedTableExpr:
+			if tn, ok := t.Expr.(*tree.TableName); ok {
+				pattern = tn
+			}
+		}
+		if pattern == nil {
+			return nil, errors.Errorf(`CHANGEFEED cannot join %s`, tree.AsString(lookup))
+		}
+
+		var targets tree.BackupTargetList
+		targets.Tables.TablePatterns = tree.TablePatterns{pattern}
+		resolved, err := getTableDescriptors(ctx, p, &targets, statementTime, initialHighWater)
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range resolved {
+			td, ok := desc.(catalog.TableDescriptor)
+			if !ok {
+				return nil, errors.Errorf(`CHANGEFEED cannot join %s`, tree.AsString(lookup))
+			}
+			if err := p.CheckPrivilege(ctx, desc, privilege.SELECT); err != nil {
+				return nil, err
+			}
+			descs[i] = td
+		}
+		if descs[i] == nil {
+			return nil, errors.Errorf(`CHANGEFEED cannot join %s`, tree.AsString(lookup))
+		}
+	}
+	return descs, nil
+}
+
 func getTargetsAndTables(
 	ctx context.Context,
 	p sql.PlanHookState,
@@ -753,23 +929,101 @@ func validateSink(
 		return err
 	}
 	var nilOracle timestampLowerBoundOracle
+	// The files the canary sink writes, if any, are named after the statement
+	// time, like the files of the aggregators before their first checkpoint.
 	canarySink, err := getSink(ctx, &p.ExecCfg().DistSQLSrv.ServerConfig, details,
-		nilOracle, p.User(), jobID, sli)
+		fixedLowerBoundOracle(details.StatementTime), p.User(), jobID, sli)
 	if err != nil {
 		return changefeedbase.MaybeStripRetryableErrorMarker(err)
 	}
+	if opts.IsSet(changefeedbase.OptProbeSink) {
+		if err := probeSink(ctx, canarySink, details); err != nil {
+			return errors.CombineErrors(err, canarySink.Close())
+		}
+	}
 	if err := canarySink.Close(); err != nil {
 		return err
 	}
-	if sink, ok := canarySink.(SinkWithTopics); ok {
-		if opts.IsSet(changefeedbase.OptResolvedTimestamps) &&
+	if _, ok := canarySink.(TransactionalEventSink); ok {
+		// A transactional sink retains the rows above the local frontier of the
+		// aggregators in memory, which scans emit in bulk below it.
+		scanType, err := opts.GetInitialScanType()
+		if err != nil {
+			return err
+		}
+		if scanType != changefeedbase.NoInitialScan {
+			return errors.Errorf("this sink requires %s='no'", changefeedbase.OptInitialScan)
+		}
+		schemaChangeOpts, err := opts.GetSchemaChangeHandlingOptions()
+		if err != nil {
+			return err
+		}
+		if schemaChangeOpts.Policy == changefeedbase.OptSchemaChangePolicyBackfill {
+			return errors.Errorf("this sink cannot be used with %s='%s'",
+				changefeedbase.OptSchemaChangePolicy, changefeedbase.OptSchemaChangePolicyBackfill)
+		}
+	}
+	if _, ok := canarySink.(*fanOutSink); ok {
+		// The sinks are flushed together, but not committed together.
+		for _, s := range fanOutSinks(canarySink) {
+			if _, ok := s.(TransactionalEventSink); ok {
+				return errors.Errorf(`this sink cannot be used with %s`, changefeedbase.OptAdditionalSinks)
+			}
+		}
+	}
+	if _, ok := opts.GetCompactFiles(); ok {
+		// The files of table formats are referenced by the logs of the tables.
+		for _, s := range fanOutSinks(canarySink) {
+			if s, ok := s.(*cloudStorageSink); ok && s.tableFormat != "" {
+				return errors.Errorf(`%s cannot be used with %s`,
+					changefeedbase.OptCompactFiles, changefeedbase.SinkParamTableFormat)
+			}
+		}
+	}
+	if deadLetterOpts, err := opts.GetDeadLetterOptions(); err != nil {
+		return err
+	} else if deadLetterOpts != nil {
+		// The rows of a transactional sink are only committed together with
+		// the resolved timestamps which cover them.
+		if _, ok := canarySink.(TransactionalEventSink); ok {
+			return errors.Errorf(`this sink cannot be used with %s`, changefeedbase.OptDeadLetter)
+		}
+		deadLetterSink, err := getSink(ctx, &p.ExecCfg().DistSQLSrv.ServerConfig,
+			deadLetterSinkDetails(details, *deadLetterOpts), nilOracle, p.User(), jobID, (*sliMetrics)(nil))
+		if err != nil {
+			return errors.Wrapf(changefeedbase.MaybeStripRetryableErrorMarker(err),
+				"invalid %s", changefeedbase.OptDeadLetter)
+		}
+		if err := deadLetterSink.Close(); err != nil {
+			return err
+		}
+	}
+	if kmsURI, ok, err := opts.GetEncryptionKMS(); err != nil {
+		return err
+	} else if ok {
+		// The data key is wrapped by the KMS when the flow is planned; a KMS
+		// which cannot wrap it fails the statement rather than the job.
+		if _, err := makeAggregatorEncryption(ctx, p.ExecCfg(), kmsURI, p.User()); err != nil {
+			return errors.Wrapf(err, "invalid %s", changefeedbase.OptEncryptionKMS)
+		}
+	}
+	var topics []string
+	var withTopics bool
+	for _, s := range fanOutSinks(canarySink) {
+		sink, ok := s.(SinkWithTopics)
+		if !ok {
+			continue
+		}
+		if (opts.IsSet(changefeedbase.OptResolvedTimestamps) || opts.IsResolvedOnly()) &&
 			opts.IsSet(changefeedbase.OptSplitColumnFamilies) {
 			return errors.Newf("Resolved timestamps are not currently supported with %s for this sink"+
 				" as the set of topics to fan them out to may change. Instead, use TABLE tablename FAMILY familyname"+
 				" to specify individual families to watch.", changefeedbase.OptSplitColumnFamilies)
 		}
-
-		topics := sink.Topics()
+		withTopics = true
+		topics = append(topics, sink.Topics()...)
+	}
+	if withTopics {
 		for _, topic := range topics {
 			p.BufferClientNotice(ctx, pgnotice.Newf(`changefeed will emit to topic %s`, topic))
 		}
@@ -778,13 +1032,46 @@ func validateSink(
 	return nil
 }
 
+// probeSink publishes a resolved timestamp message to each of the topics of
+// the changefeed and waits for the sink to deliver it. The message resolves
+// the timestamp before the statement time, which holds whichever rows the
+// changefeed goes on to emit.
+func probeSink(ctx context.Context, sink Sink, details jobspb.ChangefeedDetails) error {
+	if err := publishValidationMessage(ctx, sink, details, details.StatementTime.Prev()); err != nil {
+		return errors.Wrapf(changefeedbase.MaybeStripRetryableErrorMarker(err),
+			"%s failed", changefeedbase.OptProbeSink)
+	}
+	return nil
+}
+
+// resolveExternalConnectionSink returns the URI of the sink stored in the
+// external connection referred to by the specified sink URI, or the sink URI
+// itself if it does not refer to an external connection.
+func resolveExternalConnectionSink(
+	ctx context.Context, p sql.PlanHookState, sinkURI *url.URL,
+) (*url.URL, error) {
+	if sinkURI.Scheme != changefeedbase.SinkSchemeExternalConnection || sinkURI.Host == "" {
+		// A missing external connection name is reported when making the sink.
+		return sinkURI, nil
+	}
+	ec, err := externalconn.LoadExternalConnection(ctx, sinkURI.Host, p.ExecCfg().InternalExecutor, p.Txn())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load external connection object")
+	}
+	return url.Parse(ec.ConnectionProto().UnredactedURI())
+}
+
 func changefeedJobDescription(
 	changefeed *tree.CreateChangefeed, sinkURI string, opts changefeedbase.StatementOptions,
 ) (string, error) {
 	cleanedSinkURI, err := cloud.SanitizeExternalStorageURI(sinkURI, []string{
 		changefeedbase.SinkParamSASLPassword,
+		changefeedbase.SinkParamSASLClientSecret,
 		changefeedbase.SinkParamCACert,
 		changefeedbase.SinkParamClientCert,
+		changefeedbase.SinkParamClientKey,
+		changefeedbase.SinkParamIcebergCatalogToken,
+		changefeedbase.SinkParamAPIKey,
 	})
 
 	if err != nil {
@@ -824,6 +1111,63 @@ func validateDetailsAndOptions(
 		return err
 	}
 
+	if targetSize, ok := opts.GetCompactFiles(); ok {
+		if !opts.IsSet(changefeedbase.OptResolvedTimestamps) && !opts.IsResolvedOnly() {
+			return errors.Errorf(`%s requires the %s option`,
+				changefeedbase.OptCompactFiles, changefeedbase.OptResolvedTimestamps)
+		}
+		if _, err := parseCompactionTargetSize(targetSize); err != nil {
+			return err
+		}
+		// Parquet files end with their footer, so they cannot be concatenated.
+		encodingOpts, err := opts.GetEncodingOptions()
+		if err != nil {
+			return err
+		}
+		if encodingOpts.Format == changefeedbase.OptFormatParquet {
+			return errors.Errorf(`%s cannot be used with %s=%s`,
+				changefeedbase.OptCompactFiles, changefeedbase.OptFormat, changefeedbase.OptFormatParquet)
+		}
+		// Every file starts with the header, which concatenating the files
+		// would repeat within the merged file.
+		if encodingOpts.CSV.Header {
+			return errors.Errorf(`%s cannot be used with %s`,
+				changefeedbase.OptCompactFiles, changefeedbase.OptCSVHeader)
+		}
+	}
+
+	if _, err := opts.GetDeadLetterOptions(); err != nil {
+		return err
+	}
+
+	if _, _, err := opts.GetEncryptionKMS(); err != nil {
+		return err
+	}
+
+	if _, _, err := opts.GetMessageChunkSize(); err != nil {
+		return err
+	}
+
+	webhookOpts, err := opts.GetWebhookSinkOptions()
+	if err != nil {
+		return err
+	}
+	// The webhook endpoints acknowledge the resolved timestamps they receive,
+	// without which the changefeed would never checkpoint.
+	if webhookSinkAcknowledgesResolved(webhookOpts.JSONConfig) &&
+		!opts.IsSet(changefeedbase.OptResolvedTimestamps) && !opts.IsResolvedOnly() {
+		return errors.Errorf(`AcknowledgeResolved of %s requires the %s option`,
+			changefeedbase.OptWebhookSinkConfig, changefeedbase.OptResolvedTimestamps)
+	}
+
+	if _, err := opts.GetSinkThrottleConfig(); err != nil {
+		return err
+	}
+
+	if _, err := opts.GetAdditionalSinks(); err != nil {
+		return err
+	}
+
 	if opts.HasEndTime() {
 		scanType, err := opts.GetInitialScanType()
 		if err != nil {
@@ -862,6 +1206,7 @@ func validateAndNormalizeChangefeedExpression(
 	execCtx sql.JobExecContext,
 	sc *tree.SelectClause,
 	descriptors map[tree.TablePattern]catalog.Descriptor,
+	lookupDescs []catalog.TableDescriptor,
 	targets []jobspb.ChangefeedTargetSpecification,
 	includeVirtual bool,
 	splitColFams bool,
@@ -874,7 +1219,235 @@ func validateAndNormalizeChangefeedExpression(
 		tableDescr = d.(catalog.TableDescriptor)
 	}
 	return cdceval.NormalizeAndValidateSelectForTarget(
-		ctx, execCtx, tableDescr, targets[0], sc, includeVirtual, splitColFams)
+		ctx, execCtx, tableDescr, targets[0], sc, lookupDescs, includeVirtual, splitColFams)
+}
+
+// validatePartitionExpr verifies that the partition expression can be
+// evaluated against each of the changefeed targets.
+func validatePartitionExpr(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	expr string,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+	includeVirtual bool,
+) error {
+	return forEachTargetTable(descriptors, targets, func(
+		desc catalog.TableDescriptor, target jobspb.ChangefeedTargetSpecification,
+	) error {
+		return cdceval.ValidatePartitionExpr(ctx, execCtx, desc, target, expr, includeVirtual)
+	})
+}
+
+// validateKeyExpr verifies that the key expression can be evaluated against
+// each of the changefeed targets.
+func validateKeyExpr(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	expr string,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+	includeVirtual bool,
+) error {
+	return forEachTargetTable(descriptors, targets, func(
+		desc catalog.TableDescriptor, target jobspb.ChangefeedTargetSpecification,
+	) error {
+		return cdceval.ValidateKeyExpr(ctx, execCtx, desc, target, expr, includeVirtual)
+	})
+}
+
+// validateKafkaPartitioner verifies that the expression of the column
+// partitioner configured in the kafka_sink_config option, if any, can be
+// evaluated against each of the changefeed targets.
+func validateKafkaPartitioner(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	opts changefeedbase.StatementOptions,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+) error {
+	if !opts.IsSet(changefeedbase.OptKafkaSinkConfig) {
+		return nil
+	}
+	kafkaOpts, err := opts.GetKafkaSinkOptions()
+	if err != nil {
+		return err
+	}
+	saramaCfg, err := getSaramaConfig(kafkaOpts.JSONConfig)
+	if err != nil {
+		return errors.Wrapf(err,
+			"failed to parse sarama config; check %s option", changefeedbase.OptKafkaSinkConfig)
+	}
+	if saramaCfg.Partitioner.Strategy != changefeedbase.OptKafkaPartitionerColumn {
+		return nil
+	}
+	if _, ok := opts.GetPartitionExpr(); ok {
+		return errors.Errorf(`the %q partitioner cannot be used with %s`,
+			changefeedbase.OptKafkaPartitionerColumn, changefeedbase.OptPartitionExpr)
+	}
+	return forEachTargetTable(descriptors, targets, func(
+		desc catalog.TableDescriptor, target jobspb.ChangefeedTargetSpecification,
+	) error {
+		return cdceval.ValidatePathExpr(
+			ctx, execCtx, desc, target, saramaCfg.Partitioner.Expr, opts.IncludeVirtual())
+	})
+}
+
+// validateDecodedColumn verifies that the column referenced by the option,
+// such as the security label column, is a column of each of the changefeed
+// targets, which is decoded along with each of the column families watched by
+// the target.
+func validateDecodedColumn(
+	option string,
+	column string,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+) error {
+	return forEachTargetTable(descriptors, targets, func(
+		desc catalog.TableDescriptor, target jobspb.ChangefeedTargetSpecification,
+	) error {
+		col, err := desc.FindColumnWithName(tree.Name(column))
+		if err != nil || !col.Public() {
+			return pgerror.Newf(pgcode.UndefinedColumn,
+				"%s column %q does not exist in table %q",
+				option, column, desc.GetName())
+		}
+		if col.IsVirtual() {
+			return pgerror.Newf(pgcode.InvalidParameterValue,
+				"%s column %q cannot be a virtual column",
+				option, column)
+		}
+		if desc.GetPrimaryIndex().CollectKeyColumnIDs().Contains(col.GetID()) {
+			return nil
+		}
+		return desc.ForeachFamily(func(family *descpb.ColumnFamilyDescriptor) error {
+			if target.Type == jobspb.ChangefeedTargetSpecification_COLUMN_FAMILY &&
+				family.Name != target.FamilyName {
+				return nil
+			}
+			if catalog.MakeTableColSet(family.ColumnIDs...).Contains(col.GetID()) {
+				return nil
+			}
+			return pgerror.Newf(pgcode.InvalidParameterValue,
+				"%s column %q is not in column family %q of table %q",
+				option, column, family.Name, desc.GetName())
+		})
+	})
+}
+
+// validateTableColumn verifies that the column named by the option is a public
+// column of each of the changefeed targets. Unlike validateDecodedColumn, the
+// column may belong to any column family.
+func validateTableColumn(
+	option string,
+	column string,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+) error {
+	return forEachTargetTable(descriptors, targets, func(
+		desc catalog.TableDescriptor, _ jobspb.ChangefeedTargetSpecification,
+	) error {
+		col, err := desc.FindColumnWithName(tree.Name(column))
+		if err != nil || !col.Public() {
+			return pgerror.Newf(pgcode.UndefinedColumn,
+				"%s column %q does not exist in table %q",
+				option, column, desc.GetName())
+		}
+		return nil
+	})
+}
+
+// validatePartitionTemplate verifies that the expressions referenced by the
+// partition template of the cloud storage sink can be evaluated against each
+// of the changefeed targets.
+func validatePartitionTemplate(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	sinkURL *url.URL,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+	includeVirtual bool,
+) error {
+	query := sinkURL.Query()
+	columns := query.Get(changefeedbase.SinkParamPartitionColumns)
+	template, err := parsePartitionTemplate(query.Get(changefeedbase.SinkParamPartitionFormat), columns)
+	if err != nil {
+		return err
+	}
+	isColumn := make(map[string]bool)
+	if columns != "" {
+		for _, column := range splitPartitionColumns(columns) {
+			isColumn[column] = true
+		}
+	}
+	for _, expr := range template.exprs {
+		if err := forEachTargetTable(descriptors, targets, func(
+			desc catalog.TableDescriptor, target jobspb.ChangefeedTargetSpecification,
+		) error {
+			return cdceval.ValidatePathExpr(ctx, execCtx, desc, target, expr, includeVirtual)
+		}); err != nil {
+			if isColumn[expr] {
+				return pgerror.Wrapf(err, pgcode.InvalidParameterValue,
+					"invalid column %s in %s", expr, changefeedbase.SinkParamPartitionColumns)
+			}
+			return pgerror.Wrapf(err, pgcode.InvalidParameterValue,
+				"invalid token {%s} in %s", expr, changefeedbase.SinkParamPartitionFormat)
+		}
+	}
+	return nil
+}
+
+// validateOrderingKeyExpr verifies that the expression of the ordering keys of
+// the pubsub sink, if any, can be evaluated against each of the changefeed
+// targets.
+func validateOrderingKeyExpr(
+	ctx context.Context,
+	execCtx sql.JobExecContext,
+	sinkURL *url.URL,
+	opts changefeedbase.StatementOptions,
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+) error {
+	expr := sinkURL.Query().Get(changefeedbase.SinkParamOrderingKeyExpr)
+	if expr == "" {
+		return nil
+	}
+	// The messages with attributes are emitted without the values of the
+	// expression.
+	if opts.IsSet(changefeedbase.OptPubsubAttributes) {
+		return errors.Errorf(`%s cannot be used with %s`,
+			changefeedbase.SinkParamOrderingKeyExpr, changefeedbase.OptPubsubAttributes)
+	}
+	if err := forEachTargetTable(descriptors, targets, func(
+		desc catalog.TableDescriptor, target jobspb.ChangefeedTargetSpecification,
+	) error {
+		return cdceval.ValidatePathExpr(ctx, execCtx, desc, target, expr, opts.IncludeVirtual())
+	}); err != nil {
+		return pgerror.Wrapf(err, pgcode.InvalidParameterValue,
+			"invalid %s", changefeedbase.SinkParamOrderingKeyExpr)
+	}
+	return nil
+}
+
+// forEachTargetTable invokes fn for each changefeed target along with the
+// descriptor of its table.
+func forEachTargetTable(
+	descriptors map[tree.TablePattern]catalog.Descriptor,
+	targets []jobspb.ChangefeedTargetSpecification,
+	fn func(catalog.TableDescriptor, jobspb.ChangefeedTargetSpecification) error,
+) error {
+	for _, target := range targets {
+		for _, d := range descriptors {
+			tableDescr, ok := d.(catalog.TableDescriptor)
+			if !ok || tableDescr.GetID() != target.TableID {
+				continue
+			}
+			if err := fn(tableDescr, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 type changefeedResumer struct {
@@ -926,6 +1499,15 @@ func (b *changefeedResumer) handleChangefeedError(
 	if errErr != nil {
 		return errors.CombineErrors(changefeedErr, errErr)
 	}
+	// pauseOpt and pauseValue are the option pausing the changefeed, if it is
+	// paused.
+	pauseOpt, pauseValue := changefeedbase.OptOnError, string(changefeedbase.OptOnErrorPause)
+	if errors.Is(changefeedErr, errPauseOnIncompatibleSchema) {
+		// The incompatible schemas pause the changefeed whatever its on_error.
+		onError = changefeedbase.OptOnErrorPause
+		pauseOpt = changefeedbase.OptOnSchemaIncompatibility
+		pauseValue = string(changefeedbase.OptSchemaIncompatibilityPause)
+	}
 	switch onError {
 	// default behavior
 	case changefeedbase.OptOnErrorFail:
@@ -936,8 +1518,7 @@ func (b *changefeedResumer) handleChangefeedError(
 		// user-initiated cancellation. if the job has been canceled, the ctx
 		// will handle it and the pause will return an error.
 		const errorFmt = "job failed (%v) but is being paused because of %s=%s"
-		errorMessage := fmt.Sprintf(errorFmt, changefeedErr,
-			changefeedbase.OptOnError, changefeedbase.OptOnErrorPause)
+		errorMessage := fmt.Sprintf(errorFmt, changefeedErr, pauseOpt, pauseValue)
 		return b.job.PauseRequested(ctx, jobExec.Txn(), func(ctx context.Context,
 			planHookState interface{}, txn *kv.Txn, progress *jobspb.Progress) error {
 			err := b.OnPauseRequest(ctx, jobExec, txn, progress)
@@ -946,7 +1527,7 @@ func (b *changefeedResumer) handleChangefeedError(
 			}
 			// directly update running status to avoid the running/reverted job status check
 			progress.RunningStatus = errorMessage
-			log.Warningf(ctx, errorFmt, changefeedErr, changefeedbase.OptOnError, changefeedbase.OptOnErrorPause)
+			log.Warningf(ctx, errorFmt, changefeedErr, pauseOpt, pauseValue)
 			return nil
 		}, errorMessage)
 	default:
@@ -969,6 +1550,9 @@ func (b *changefeedResumer) resumeWithRetries(
 	// or for many other reasons.
 	var err error
 	var lastRunStatusUpdate time.Time
+	// retryLog are the retryable errors encountered by this resumer, which the
+	// restarted flows record in the job progress.
+	var retryLog []jobspb.ChangefeedRetryLogEntry
 
 	for r := retry.StartWithCtx(ctx, changefeedRetryOptions); r.Next(); {
 		// startedCh is normally used to signal back to the creator of the job that
@@ -977,7 +1561,7 @@ func (b *changefeedResumer) resumeWithRetries(
 		// a dummy channel.
 		startedCh := make(chan tree.Datums, 1)
 
-		if err = distChangefeedFlow(ctx, jobExec, jobID, details, progress, startedCh); err == nil {
+		if err = distChangefeedFlow(ctx, jobExec, jobID, details, progress, retryLog, startedCh); err == nil {
 			return nil
 		}
 
@@ -1013,6 +1597,7 @@ func (b *changefeedResumer) resumeWithRetries(
 
 		log.Warningf(ctx, `WARNING: CHANGEFEED job %d encountered retryable error: %v`, jobID, err)
 		lastRunStatusUpdate = b.setJobRunningStatus(ctx, lastRunStatusUpdate, "retryable error: %s", err)
+		retryLog = mergeRetryLog(retryLog, makeRetryLogEntry(err, execCfg.NodeInfo.NodeID.SQLInstanceID()))
 		if metrics, ok := execCfg.JobRegistry.MetricsStruct().Changefeed.(*Metrics); ok {
 			sli, err := metrics.getSLIMetrics(details.Opts[changefeedbase.OptMetricsScope])
 			if err != nil {
@@ -1077,6 +1662,25 @@ func (b *changefeedResumer) maybeCleanUpProtectedTimestamp(
 	}
 }
 
+var _ jobs.RecordRemovalCleaner = (*changefeedResumer)(nil)
+
+// OnRecordRemoval implements jobs.RecordRemovalCleaner. It releases the
+// protected timestamp record of the changefeed, if it still has one, and
+// unregisters the metrics of its metrics label.
+func (b *changefeedResumer) OnRecordRemoval(ctx context.Context, jobExec interface{}) error {
+	execCfg := jobExec.(sql.JobExecContext).ExecCfg()
+	progress := b.job.Progress()
+	if cp := progress.GetChangefeed(); cp != nil {
+		b.maybeCleanUpProtectedTimestamp(ctx, execCfg.DB, execCfg.ProtectedTimestampProvider, cp.ProtectedTimestampRecord)
+	}
+
+	details := b.job.Details().(jobspb.ChangefeedDetails)
+	if scope, ok := details.Opts[changefeedbase.OptMetricsScope]; ok {
+		execCfg.JobRegistry.MetricsStruct().Changefeed.(*Metrics).AggMetrics.releaseScope(scope)
+	}
+	return nil
+}
+
 var _ jobs.PauseRequester = (*changefeedResumer)(nil)
 
 // OnPauseRequest implements jobs.PauseRequester. If this changefeed is being
@@ -1089,7 +1693,14 @@ func (b *changefeedResumer) OnPauseRequest(
 	cp := progress.GetChangefeed()
 	execCfg := jobExec.(sql.JobExecContext).ExecCfg()
 
-	if _, shouldProtect := details.Opts[changefeedbase.OptProtectDataFromGCOnPause]; !shouldProtect {
+	_, shouldProtect := details.Opts[changefeedbase.OptProtectDataFromGCOnPause]
+	if _, expires := details.Opts[changefeedbase.OptExpirePTSAfter]; expires {
+		// The record is kept while paused, and released by the protected
+		// timestamp reconciler once it expires, which is measured from now.
+		shouldProtect = true
+		cp.PausedAt = execCfg.Clock.Now()
+	}
+	if !shouldProtect {
 		// Release existing pts record to avoid a single changefeed left on pause
 		// resulting in storage issues
 		if cp.ProtectedTimestampRecord != uuid.Nil {
@@ -1115,6 +1726,62 @@ func (b *changefeedResumer) OnPauseRequest(
 	return nil
 }
 
+var _ jobs.ProtectedTimestampExpirer = (*changefeedResumer)(nil)
+
+// CheckProtectedTimestampExpiration implements jobs.ProtectedTimestampExpirer.
+// The protected timestamp record of a paused changefeed expires once the
+// changefeed has been paused for longer than gc_protect_expires_after, at
+// which point the changefeed is failed since the data it needs to resume may
+// be garbage collected.
+func (b *changefeedResumer) CheckProtectedTimestampExpiration(
+	ctx context.Context, now hlc.Timestamp,
+) error {
+	details := b.job.Details().(jobspb.ChangefeedDetails)
+	expiration, err := changefeedbase.MakeStatementOptions(details.Opts).GetPTSExpiration()
+	if err != nil || expiration == nil {
+		return err
+	}
+
+	progress := b.job.Progress()
+	cp := progress.GetChangefeed()
+	if cp == nil || cp.ProtectedTimestampRecord == uuid.Nil {
+		return nil
+	}
+	// The changefeeds paused before their pause time was recorded have been
+	// paused since at least their high-water.
+	pausedAt := cp.PausedAt
+	if pausedAt.IsEmpty() {
+		pausedAt = details.StatementTime
+		if hw := progress.GetHighWater(); hw != nil && !hw.IsEmpty() {
+			pausedAt = *hw
+		}
+	}
+	if now.GoTime().Sub(pausedAt.GoTime()) <= *expiration {
+		return nil
+	}
+	return errors.Newf(
+		"protected timestamp record of paused changefeed expired: changefeed was paused at %s, "+
+			"more than %s (%s) ago; the changefeed must be recreated",
+		pausedAt.GoTime().UTC(), *expiration, changefeedbase.OptExpirePTSAfter)
+}
+
+// ReleaseExpiredProtectedTimestamps implements jobs.ProtectedTimestampExpirer.
+func (b *changefeedResumer) ReleaseExpiredProtectedTimestamps(
+	ctx context.Context, jobExec interface{}, txn *kv.Txn,
+) error {
+	progress := b.job.Progress()
+	cp := progress.GetChangefeed()
+	if cp == nil || cp.ProtectedTimestampRecord == uuid.Nil {
+		return nil
+	}
+	pts := jobExec.(sql.JobExecContext).ExecCfg().ProtectedTimestampProvider
+	if err := pts.Release(ctx, txn, cp.ProtectedTimestampRecord); err != nil &&
+		!errors.Is(err, protectedts.ErrNotExists) {
+		return err
+	}
+	return nil
+}
+
 // getQualifiedTableName returns the database-qualified name of the table
 // or view represented by the provided descriptor.
 func getQualifiedTableName(
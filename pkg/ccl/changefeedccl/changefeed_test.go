@@ -771,6 +771,381 @@ func TestChangefeedMVCCTimestamps(t *testing.T) {
 	cdcTest(t, testFn)
 }
 
+func TestChangefeedEmitTxnID(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH emit_txn_id, no_initial_scan`)
+		defer closeFeed(t, foo)
+
+		// All the rows written by a transaction share its commit timestamp, and
+		// so its txn_id.
+		var txnID string
+		tx, err := s.DB.Begin()
+		require.NoError(t, err)
+		_, err = tx.Exec(`INSERT INTO foo VALUES (1, 'a'), (2, 'b')`)
+		require.NoError(t, err)
+		_, err = tx.Exec(`INSERT INTO foo VALUES (3, 'c')`)
+		require.NoError(t, err)
+		require.NoError(t, tx.QueryRow(`SELECT cluster_logical_timestamp()`).Scan(&txnID))
+		require.NoError(t, tx.Commit())
+		var otherTxnID string
+		sqlDB.QueryRow(t,
+			`INSERT INTO foo VALUES (4, 'd') RETURNING cluster_logical_timestamp()`,
+		).Scan(&otherTxnID)
+		require.NotEqual(t, txnID, otherTxnID)
+
+		assertPayloads(t, foo, []string{
+			fmt.Sprintf(`foo: [1]->{"after": {"a": 1, "b": "a"}, "txn_id": "%s"}`, txnID),
+			fmt.Sprintf(`foo: [2]->{"after": {"a": 2, "b": "b"}, "txn_id": "%s"}`, txnID),
+			fmt.Sprintf(`foo: [3]->{"after": {"a": 3, "b": "c"}, "txn_id": "%s"}`, txnID),
+			fmt.Sprintf(`foo: [4]->{"after": {"a": 4, "b": "d"}, "txn_id": "%s"}`, otherTxnID),
+		})
+	}
+
+	cdcTest(t, testFn)
+}
+
+func TestChangefeedKeyExpr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'x', 'y')`)
+
+		t.Run(`envelope=wrapped`, func(t *testing.T) {
+			foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH key_expr=$1`, `concat(b, '-', c)`)
+			defer closeFeed(t, foo)
+			assertPayloads(t, foo, []string{
+				`foo: ["x-y"]->{"after": {"a": 1, "b": "x", "c": "y"}}`,
+			})
+
+			// The deleted rows are keyed by their previous values.
+			sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+			assertPayloads(t, foo, []string{
+				`foo: ["x-y"]->{"after": null}`,
+			})
+			sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'x', 'y')`)
+			assertPayloads(t, foo, []string{
+				`foo: ["x-y"]->{"after": {"a": 1, "b": "x", "c": "y"}}`,
+			})
+		})
+		t.Run(`envelope=key_only`, func(t *testing.T) {
+			foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH key_expr='upper(b)', envelope='key_only'`)
+			defer closeFeed(t, foo)
+			assertPayloads(t, foo, []string{`foo: ["X"]->`})
+		})
+	}
+
+	cdcTest(t, testFn, feedTestRestrictSinks("sinkless", "enterprise", "kafka"))
+}
+
+func TestChangefeedEmittedStats(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `CREATE TABLE bar (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (2, 'b'), (3, 'c')`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1), (2)`)
+		var fooID, barID int64
+		sqlDB.QueryRow(t, `SELECT 'foo'::regclass::oid::INT8, 'bar'::regclass::oid::INT8`).Scan(&fooID, &barID)
+
+		foobar := feed(t, f, `CREATE CHANGEFEED FOR foo, bar WITH resolved`)
+		defer closeFeed(t, foobar)
+		assertPayloads(t, foobar, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+			`foo: [2]->{"after": {"a": 2, "b": "b"}}`,
+			`foo: [3]->{"after": {"a": 3, "b": "c"}}`,
+			`bar: [1]->{"after": {"a": 1}}`,
+			`bar: [2]->{"after": {"a": 2}}`,
+		})
+		feedJob := foobar.(cdctest.EnterpriseTestFeed)
+
+		type stats struct{ messages, bytes int64 }
+		emittedStats := func() map[int64]stats {
+			res := make(map[int64]stats)
+			rows := sqlDB.Query(t,
+				`SELECT * FROM crdb_internal.changefeed_emitted_stats($1)`, feedJob.JobID())
+			defer rows.Close()
+			for rows.Next() {
+				var tableID int64
+				var st stats
+				require.NoError(t, rows.Scan(&tableID, &st.messages, &st.bytes))
+				res[tableID] = st
+			}
+			require.NoError(t, rows.Err())
+			return res
+		}
+		// The stats are accounted for with at-least-once semantics, so they may
+		// exceed the number of distinct rows.
+		waitForStats := func(minFoo, minBar int64) map[int64]stats {
+			var res map[int64]stats
+			testutils.SucceedsSoon(t, func() error {
+				res = emittedStats()
+				if res[fooID].messages < minFoo || res[barID].messages < minBar {
+					return errors.Newf("waiting for emitted stats, got %v", res)
+				}
+				return nil
+			})
+			return res
+		}
+
+		beforeRestart := waitForStats(3, 2)
+		require.Less(t, int64(0), beforeRestart[fooID].bytes)
+		require.Less(t, int64(0), beforeRestart[barID].bytes)
+
+		var showStats string
+		sqlDB.QueryRow(t, `SELECT emitted_stats FROM [SHOW CHANGEFEED JOB $1]`, feedJob.JobID()).Scan(&showStats)
+		require.Contains(t, showStats, fmt.Sprintf(`"tableId": %d`, fooID))
+
+		// The stats are only shown to the users who may see the job.
+		sqlDB.Exec(t, `CREATE USER guest WITH PASSWORD 'password'`)
+		pgURL := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(`guest`, `password`),
+			Host:   s.Server.SQLAddr(),
+		}
+		db2, err := gosql.Open("postgres", pgURL.String())
+		require.NoError(t, err)
+		defer db2.Close()
+		guestDB := sqlutils.MakeSQLRunner(db2)
+		guestDB.ExpectErr(t, `user guest does not have privileges for job`,
+			`SELECT * FROM crdb_internal.changefeed_emitted_stats($1)`, feedJob.JobID())
+
+		// The stats are persisted in the job progress, so they survive restarts
+		// and keep increasing afterwards.
+		require.NoError(t, feedJob.Pause())
+		require.Equal(t, beforeRestart, emittedStats())
+		require.NoError(t, feedJob.Resume())
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (4, 'd')`)
+		assertPayloads(t, foobar, []string{
+			`foo: [4]->{"after": {"a": 4, "b": "d"}}`,
+		})
+		afterRestart := waitForStats(beforeRestart[fooID].messages+1, beforeRestart[barID].messages)
+		require.Less(t, beforeRestart[fooID].bytes, afterRestart[fooID].bytes)
+		require.LessOrEqual(t, beforeRestart[barID].bytes, afterRestart[barID].bytes)
+	}
+
+	cdcTest(t, testFn, feedTestEnterpriseSinks)
+}
+
+func TestChangefeedEmitSecurityLabel(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, classification STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 'secret'), (2, 'b', 'public'), (3, 'c', NULL)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH emit_security_label='classification', diff`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a", "classification": "secret"}, "before": null, "security_label": "secret"}`,
+			`foo: [2]->{"after": {"a": 2, "b": "b", "classification": "public"}, "before": null, "security_label": "public"}`,
+			`foo: [3]->{"after": {"a": 3, "b": "c", "classification": null}, "before": null, "security_label": null}`,
+		})
+
+		// The label of a deleted row is the label of its previous value.
+		sqlDB.Exec(t, `UPDATE foo SET classification = 'public' WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a", "classification": "public"}, "before": {"a": 1, "b": "a", "classification": "secret"}, "security_label": "public"}`,
+			`foo: [2]->{"after": null, "before": {"a": 2, "b": "b", "classification": "public"}, "security_label": "public"}`,
+		})
+
+		// The label column does not need to be projected.
+		projected := feed(t, f, `CREATE CHANGEFEED WITH emit_security_label='classification', schema_change_policy='stop' AS SELECT a, b FROM foo`)
+		defer closeFeed(t, projected)
+		assertPayloads(t, projected, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}, "security_label": "public"}`,
+			`foo: [3]->{"after": {"a": 3, "b": "c"}, "security_label": null}`,
+		})
+
+		// The label of a deleted row is known without the diff option.
+		noDiff := feed(t, f, `CREATE CHANGEFEED FOR foo WITH emit_security_label='classification', initial_scan='no'`)
+		defer closeFeed(t, noDiff)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, noDiff, []string{
+			`foo: [1]->{"after": null, "security_label": "public"}`,
+		})
+
+		// The changefeed fails rather than emit rows without their label if the
+		// label column is dropped.
+		sqlDB.Exec(t, `CREATE TABLE bar (a INT PRIMARY KEY, classification STRING)`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1, 'secret')`)
+		bar := feed(t, f, `CREATE CHANGEFEED FOR bar WITH emit_security_label='classification'`)
+		defer closeFeed(t, bar)
+		assertPayloads(t, bar, []string{
+			`bar: [1]->{"after": {"a": 1, "classification": "secret"}, "security_label": "secret"}`,
+		})
+		sqlDB.Exec(t, `ALTER TABLE bar DROP COLUMN classification`)
+		requireErrorSoon(context.Background(), t, bar,
+			regexp.MustCompile(`emit_security_label column "classification" does not exist in table "bar"`))
+	}
+
+	cdcTest(t, testFn)
+}
+
+func TestChangefeedEmitSecurityLabelEnvelopeRow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, classification STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'secret')`)
+
+		// The label is a top-level field rather than row metadata.
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH emit_security_label='classification', envelope='row'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"a": 1, "classification": "secret", "security_label": "secret"}`,
+		})
+	}
+
+	// some sinks are incompatible with envelope
+	cdcTest(t, testFn, feedTestRestrictSinks("sinkless", "enterprise", "kafka"))
+}
+
+func TestChangefeedKafkaHeaders(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, region STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 'us-east'), (2, 'b', 'eu-west')`)
+
+		// nextHeaders returns the headers of the next messages of the rows,
+		// keyed by the keys of the rows.
+		nextHeaders := func(feed cdctest.TestFeed, n int) map[string]map[string]string {
+			headers := make(map[string]map[string]string)
+			for len(headers) < n {
+				m, err := feed.Next()
+				require.NoError(t, err)
+				if m.Key != nil {
+					headers[string(m.Key)] = m.Headers
+				}
+			}
+			return headers
+		}
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH kafka_headers='region, cdc_op', diff`)
+		defer closeFeed(t, foo)
+		require.Equal(t, map[string]map[string]string{
+			`[1]`: {`region`: `us-east`, `cdc_op`: `insert`},
+			`[2]`: {`region`: `eu-west`, `cdc_op`: `insert`},
+		}, nextHeaders(foo, 2))
+
+		// The region of a deleted row is the region of its previous value.
+		sqlDB.Exec(t, `UPDATE foo SET region = 'us-west' WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		require.Equal(t, map[string]map[string]string{
+			`[1]`: {`region`: `us-west`, `cdc_op`: `update`},
+			`[2]`: {`region`: `eu-west`, `cdc_op`: `delete`},
+		}, nextHeaders(foo, 2))
+
+		// Inserts cannot be told apart from updates without the previous rows,
+		// and the headers do not need to be projected.
+		var ts string
+		sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&ts)
+		projected := feed(t, f, `CREATE CHANGEFEED WITH kafka_headers='region, cdc_op, cdc_mvcc_timestamp', `+
+			`schema_change_policy='stop', initial_scan='no', cursor=$1 AS SELECT a FROM foo`, ts)
+		defer closeFeed(t, projected)
+		var mvcc string
+		sqlDB.QueryRow(t, `UPDATE foo SET b = 'c' WHERE a = 1 RETURNING cluster_logical_timestamp()`).Scan(&mvcc)
+		require.Equal(t, map[string]map[string]string{
+			`[1]`: {`region`: `us-west`, `cdc_op`: `upsert`, `cdc_mvcc_timestamp`: mvcc},
+		}, nextHeaders(projected, 1))
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
+func TestChangefeedPubsubAttributes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, region STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 'us-east'), (2, 'b', NULL)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH pubsub_attributes='cdc_table, cdc_op, region'`)
+		defer closeFeed(t, foo)
+
+		// The attributes holding NULL are left out.
+		attributes := make(map[string]map[string]string)
+		for len(attributes) < 2 {
+			m, err := foo.Next()
+			require.NoError(t, err)
+			if m.Key != nil {
+				attributes[string(m.Key)] = m.Headers
+			}
+		}
+		require.Equal(t, map[string]map[string]string{
+			`[1]`: {`cdc_table`: `foo`, `cdc_op`: `upsert`, `region`: `us-east`},
+			`[2]`: {`cdc_table`: `foo`, `cdc_op`: `upsert`},
+		}, attributes)
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("pubsub"))
+}
+
+func TestChangefeedOversizedEvents(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.memory.per_changefeed_limit = '1MiB'`)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+
+		// The events for the rows with a 512KiB value exceed the per changefeed
+		// limit once the event memory multiplier is applied.
+		const bigSize = 512 << 10
+		big := strings.Repeat("x", bigSize)
+		insert := func(keys ...int) []string {
+			var expected []string
+			for _, k := range keys {
+				v := fmt.Sprintf("v%d", k)
+				if k%2 == 0 {
+					v = big
+				}
+				sqlDB.Exec(t, `INSERT INTO foo VALUES ($1, $2)`, k, v)
+				expected = append(expected, fmt.Sprintf(`foo: [%d]->{"after": {"a": %d, "b": "%s"}}`, k, k, v))
+			}
+			return expected
+		}
+
+		initial := insert(1, 2, 3)
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, initial)
+		assertPayloads(t, foo, insert(4, 5, 6))
+
+		// The oversized events are still buffered after a restart.
+		feedJob := foo.(cdctest.EnterpriseTestFeed)
+		require.NoError(t, feedJob.Pause())
+		require.NoError(t, feedJob.Resume())
+		assertPayloads(t, foo, insert(7, 8))
+
+		require.Less(t, int64(0), s.Server.MustGetSQLCounter(`changefeed.buffer_entries.oversized`))
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
 func TestChangefeedResolvedFrequency(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -801,6 +1176,40 @@ func TestChangefeedResolvedFrequency(t *testing.T) {
 	cdcTest(t, testFn)
 }
 
+func TestChangefeedResolvedOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		// Rows written before the changefeed is created are not scanned.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (0, 'initial')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved_only, resolved='10ms'`)
+		defer closeFeed(t, foo)
+
+		var ts string
+		sqlDB.QueryRow(t,
+			`INSERT INTO foo VALUES (1, 'a'), (2, 'b') RETURNING cluster_logical_timestamp()`,
+		).Scan(&ts)
+		sqlDB.Exec(t, `UPDATE foo SET b = 'c' WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+
+		// The rows would have been emitted before a resolved timestamp greater
+		// than the timestamp of the insert; expectResolvedTimestamp fails if it
+		// gets a row instead of a resolved timestamp.
+		parsed := parseTimeToHLC(t, ts)
+		for {
+			if resolved, _ := expectResolvedTimestamp(t, foo); parsed.Less(resolved) {
+				break
+			}
+		}
+	}
+
+	cdcTest(t, testFn)
+}
+
 // Test how Changefeeds react to schema changes that do not require a backfill
 // operation.
 func TestChangefeedInitialScan(t *testing.T) {
@@ -3423,6 +3832,84 @@ func TestChangefeedRetryableError(t *testing.T) {
 	cdcTest(t, testFn, feedTestEnterpriseSinks)
 }
 
+func TestChangefeedRetryLog(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// Retry quickly, since the changefeed is restarted many times.
+	defer func(opts retry.Options) { changefeedRetryOptions = opts }(changefeedRetryOptions)
+	changefeedRetryOptions.MaxBackoff = 10 * time.Millisecond
+
+	// More failures are injected than the retry log retains.
+	const numFailures = maxRetryLogEntries + 5
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		knobs := s.TestingKnobs.
+			DistSQL.(*execinfra.TestingKnobs).
+			Changefeed.(*TestingKnobs)
+		var emits int64
+		knobs.BeforeEmitRow = func(_ context.Context) error {
+			if n := atomic.AddInt64(&emits, 1); n <= numFailures {
+				return changefeedbase.MarkRetryableError(errors.Newf("injected failure %d", n))
+			}
+			return nil
+		}
+
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+		})
+		jobID := foo.(cdctest.EnterpriseTestFeed).JobID()
+
+		// The retry log is recorded along with the first checkpoint after the
+		// failures, and retains only the most recent ones.
+		var retryLog [][]string
+		testutils.SucceedsSoon(t, func() error {
+			retryLog = sqlDB.QueryStr(t,
+				`SELECT error_class, error, instance_id FROM crdb_internal.changefeed_retry_log($1)`, jobID)
+			if len(retryLog) == 0 ||
+				!strings.Contains(retryLog[len(retryLog)-1][1], fmt.Sprintf("injected failure %d", numFailures)) {
+				return errors.Newf("waiting for the retry log, got %v", retryLog)
+			}
+			return nil
+		})
+		require.Len(t, retryLog, maxRetryLogEntries)
+		for i, entry := range retryLog {
+			require.Equal(t, "retryable", entry[0])
+			require.Contains(t, entry[1],
+				fmt.Sprintf("injected failure %d", numFailures-maxRetryLogEntries+i+1))
+			require.Equal(t, fmt.Sprint(s.Server.SQLInstanceID()), entry[2])
+		}
+		rows := sqlDB.Query(t, `SELECT time FROM crdb_internal.changefeed_retry_log($1)`, jobID)
+		var prev time.Time
+		for rows.Next() {
+			var ts time.Time
+			require.NoError(t, rows.Scan(&ts))
+			require.True(t, ts.After(prev), "%s is not after %s", ts, prev)
+			prev = ts
+		}
+		require.NoError(t, rows.Err())
+		require.NoError(t, rows.Close())
+
+		var showRetryLog string
+		sqlDB.QueryRow(t, `SELECT retry_log FROM [SHOW CHANGEFEED JOB $1]`, jobID).Scan(&showRetryLog)
+		require.Contains(t, showRetryLog, fmt.Sprintf("injected failure %d", numFailures))
+		require.NotContains(t, showRetryLog, "injected failure 1\"")
+
+		// The changefeed keeps running after the failures.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2}}`,
+		})
+	}
+
+	cdcTest(t, testFn, feedTestEnterpriseSinks)
+}
+
 func TestChangefeedJobRetryOnNoInboundStream(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -4012,7 +4499,7 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO $1`, `kafka://nope/?sasl_mechanism=SCRAM-SHA-256`,
 	)
 	sqlDB.ExpectErr(
-		t, `param sasl_mechanism must be one of SCRAM-SHA-256, SCRAM-SHA-512, or PLAIN`,
+		t, `param sasl_mechanism must be one of SCRAM-SHA-256, SCRAM-SHA-512, OAUTHBEARER, or PLAIN`,
 		`CREATE CHANGEFEED FOR foo INTO $1`, `kafka://nope/?sasl_enabled=true&sasl_mechanism=unsuppported`,
 	)
 	sqlDB.ExpectErr(
@@ -4020,34 +4507,112 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_sink_config='{"Flush": {"Messages": 100, "Frequency": "1s"}}'`,
 	)
 	sqlDB.ExpectErr(
-		t, `this sink is incompatible with option webhook_client_timeout`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_client_timeout='1s'`,
-		`kafka://nope/`,
+		t, `kafka_max_in_flight=5 may reorder messages for the same key on retry and cannot be used with kafka_strict_ordering`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_max_in_flight='5', kafka_strict_ordering`,
 	)
-	// The avro format doesn't support key_in_value or topic_in_value yet.
 	sqlDB.ExpectErr(
-		t, `key_in_value is not supported with format=avro`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH key_in_value, format='experimental_avro'`,
-		`kafka://nope`,
+		t, `kafka_partitioner='sticky' does not preserve the order of the messages for a key and cannot be used with kafka_strict_ordering`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_partitioner='sticky', kafka_strict_ordering`,
 	)
 	sqlDB.ExpectErr(
-		t, `topic_in_value is not supported with format=avro`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH topic_in_value, format='experimental_avro'`,
-		`kafka://nope`,
+		t, `kafka_partitioner='roundrobin' does not preserve the order of the messages for a key and requires kafka_unordered`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_partitioner='roundrobin'`,
 	)
-
-	// The topics option should not be exposed to users since it is used
-	// internally to display topics in the show changefeed jobs query
 	sqlDB.ExpectErr(
-		t, `invalid option "topics"`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH topics='foo,bar'`,
-		`kafka://nope`,
+		t, `kafka_unordered cannot be used with kafka_strict_ordering`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_unordered, kafka_strict_ordering`,
 	)
-
-	// The cloudStorageSink is particular about the options it will work with.
 	sqlDB.ExpectErr(
-		t, `this sink is incompatible with option confluent_schema_registry`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', confluent_schema_registry=$2`,
+		t, `unknown kafka_partitioner: random`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_partitioner='random'`,
+	)
+	sqlDB.ExpectErr(
+		t, `unknown Partitioner.Strategy "random"`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_sink_config='{"Partitioner": {"Strategy": "random"}}'`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid sarama configuration: TopicCreation.ReplicationFactor must be > 0`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_sink_config='{"TopicCreation": {"Partitions": 3}}'`,
+	)
+	sqlDB.ExpectErr(
+		t, `kafka_partitioner cannot be used with the Partitioner of kafka_sink_config`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_partitioner='sticky', `+
+			`kafka_sink_config='{"Partitioner": {"Strategy": "roundrobin"}}'`,
+	)
+	sqlDB.ExpectErr(
+		t, `column "nope" does not exist`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH `+
+			`kafka_sink_config='{"Partitioner": {"Strategy": "column", "Expr": "nope"}}'`,
+	)
+	sqlDB.ExpectErr(
+		t, `the "column" partitioner cannot be used with partition_expr`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH partition_expr='a % 8', `+
+			`kafka_sink_config='{"Partitioner": {"Strategy": "column", "Expr": "b"}}'`,
+	)
+	sqlDB.ExpectErr(
+		t, `Partitioner.Strategy='column' does not preserve the order of the messages for a key and cannot be used with kafka_strict_ordering`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_strict_ordering, `+
+			`kafka_sink_config='{"Partitioner": {"Strategy": "column", "Expr": "b"}}'`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option kafka_max_in_flight`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH kafka_max_in_flight='5'`,
+		`webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option webhook_client_timeout`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_client_timeout='1s'`,
+		`kafka://nope/`,
+	)
+	sqlDB.ExpectErr(
+		t, `partition expression "b" must evaluate to INT`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH partition_expr='b'`,
+		`kafka://nope/`,
+	)
+	sqlDB.ExpectErr(
+		t, `may only reference columns of the row`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH partition_expr=$2`,
+		`kafka://nope/`, `(cdc_prev()->>'a')::int`,
+	)
+	sqlDB.ExpectErr(
+		t, `key expression .* must be immutable, found stable`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH key_expr='statement_timestamp()::STRING'`,
+		`kafka://nope/`,
+	)
+	sqlDB.ExpectErr(
+		t, `key_expr is not supported with format=csv`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH key_expr='b', format='csv'`,
+		`kafka://nope/`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option partition_expr`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH partition_expr='a % 8'`,
+		`webhook-https://fake-host`,
+	)
+	// The avro format doesn't support key_in_value or topic_in_value yet.
+	sqlDB.ExpectErr(
+		t, `key_in_value is not supported with format=avro`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH key_in_value, format='experimental_avro'`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `topic_in_value is not supported with format=avro`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH topic_in_value, format='experimental_avro'`,
+		`kafka://nope`,
+	)
+
+	// The topics option should not be exposed to users since it is used
+	// internally to display topics in the show changefeed jobs query
+	sqlDB.ExpectErr(
+		t, `invalid option "topics"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH topics='foo,bar'`,
+		`kafka://nope`,
+	)
+
+	// The cloudStorageSink is particular about the options it will work with.
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option confluent_schema_registry`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', confluent_schema_registry=$2`,
 		`experimental-nodelocal://0/bar`, schemaReg.URL(),
 	)
 	sqlDB.ExpectErr(
@@ -4055,6 +4620,31 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope='key_only'`,
 		`experimental-nodelocal://0/bar`,
 	)
+	sqlDB.ExpectErr(
+		t, `invalid token \{nope\} in partition_format`,
+		`CREATE CHANGEFEED FOR foo INTO $1`,
+		`experimental-nodelocal://0/bar?partition_format=%7Btable%7D%2F%7Bnope%7D%2F`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid token \{cdc_mvcc_timestamp\(\)\} in partition_format.*must be immutable`,
+		`CREATE CHANGEFEED FOR foo INTO $1`,
+		`experimental-nodelocal://0/bar?partition_format=%7Bcdc_mvcc_timestamp()%7D`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid partition_format "\{table\}//": // empty path components are not allowed`,
+		`CREATE CHANGEFEED FOR foo INTO $1`,
+		`experimental-nodelocal://0/bar?partition_format=%7Btable%7D%2F%2F`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid column nope in partition_columns: .*column "nope" does not exist`,
+		`CREATE CHANGEFEED FOR foo INTO $1`,
+		`experimental-nodelocal://0/bar?partition_columns=b,nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid ordering_key_expr: .*column "nope" does not exist`,
+		`CREATE CHANGEFEED FOR foo INTO $1`,
+		`gcpubsub://nope?region=us-east1&ordering_key_expr=nope`,
+	)
 
 	// WITH key_in_value requires envelope=wrapped
 	sqlDB.ExpectErr(
@@ -4135,6 +4725,118 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH diff, initial_scan = 'only'`, `kafka://nope`,
 	)
 
+	sqlDB.ExpectErr(
+		t, `emit_txn_id is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH emit_txn_id, format=avro, confluent_schema_registry='http://localhost'`,
+		`kafka://nope`,
+	)
+
+	sqlDB.ExpectErr(
+		t, `emit_security_label column "nope" does not exist in table "foo"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH emit_security_label='nope'`, `kafka://nope`,
+	)
+
+	sqlDB.ExpectErr(
+		t, `kafka_headers column "nope" does not exist in table "foo"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH kafka_headers='cdc_op,nope'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `option kafka_headers lists header "b" more than once`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH kafka_headers='b, b'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option kafka_headers`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH kafka_headers='cdc_op'`, `webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option encryption_kms`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH encryption_kms='aws:///nope'`, `webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `pubsub_attributes column "nope" does not exist in table "foo"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH pubsub_attributes='cdc_table,nope'`,
+		`gcpubsub://nope?region=us-east1`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option pubsub_attributes`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH pubsub_attributes='cdc_op'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `ordering_key_expr cannot be used with pubsub_attributes`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH pubsub_attributes='cdc_op'`,
+		`gcpubsub://nope?region=us-east1&ordering_key_expr=b`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option compact_files`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compact_files, resolved`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `compact_files requires the resolved option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compact_files`, `experimental-nodelocal://0/bar`,
+	)
+	sqlDB.ExpectErr(
+		t, `compact_files must be a positive size, found "nope"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compact_files='nope', resolved`, `experimental-nodelocal://0/bar`,
+	)
+	sqlDB.ExpectErr(
+		t, `compact_files cannot be used with table_format`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compact_files, resolved`,
+		`experimental-nodelocal://0/bar?table_format=delta`,
+	)
+	sqlDB.ExpectErr(
+		t, `compact_files cannot be used with format=parquet`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compact_files, resolved, format=parquet`,
+		`experimental-nodelocal://0/bar`,
+	)
+	sqlDB.ExpectErr(
+		t, `compact_files cannot be used with csv_header`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compact_files, resolved, format=csv, csv_header`,
+		`experimental-nodelocal://0/bar`,
+	)
+	sqlDB.ExpectErr(
+		t, `option dead_letter_max_messages requires option dead_letter`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH dead_letter_max_messages='10'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `option dead_letter_max_messages must be a positive integer`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH dead_letter='null://', dead_letter_max_messages='0'`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid dead_letter: unsupported sink: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH dead_letter='nope://'`, `null://`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid sink_throttle_config: rates and bursts must not be negative`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH sink_throttle_config='{"MessageRate": -1}'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `sink 1 \(nope\): unsupported sink: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH additional_sinks='nope://'`, `null://`,
+	)
+	sqlDB.ExpectErr(
+		t, `option additional_sinks requires a comma separated list of sink URIs`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH additional_sinks='null://,'`, `null://`,
+	)
+	sqlDB.ExpectErr(
+		t, `probe_sink requires a sink`,
+		`EXPERIMENTAL CHANGEFEED FOR foo WITH probe_sink`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option distributed`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH distributed`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `cannot specify both initial_scan_only and probe_sink`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH probe_sink, initial_scan = 'only'`, `kafka://nope`,
+	)
+
+	sqlDB.ExpectErr(
+		t, `emit_security_label is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH emit_security_label='b', format=avro, confluent_schema_registry='http://localhost'`,
+		`kafka://nope`,
+	)
+
 	sqlDB.ExpectErr(
 		t, `cannot specify both initial_scan_only and mvcc_timestamp`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH mvcc_timestamp, initial_scan = 'only'`, `kafka://nope`,
@@ -4145,6 +4847,19 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH updated, initial_scan = 'only'`, `kafka://nope`,
 	)
 
+	sqlDB.ExpectErr(
+		t, `cannot specify both resolved_only and initial_scan`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_only, initial_scan`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `cannot specify both resolved_only and initial_scan_only`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_only, initial_scan_only`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `cannot specify both resolved_only and diff`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_only, diff`, `kafka://nope`,
+	)
+
 	sqlDB.ExpectErr(
 		t, `unknown initial_scan: foo`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH initial_scan = 'foo'`, `kafka://nope`,
@@ -4159,8 +4874,8 @@ func TestChangefeedErrors(t *testing.T) {
 	)
 
 	sqlDB.ExpectErr(
-		t, `format=csv is only usable with initial_scan_only`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH format = csv`, `kafka://nope`,
+		t, `csv_delimiter is only usable with format=csv`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH csv_delimiter = ';'`, `kafka://nope`,
 	)
 
 	var tsCurrent string
@@ -4242,6 +4957,11 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_sink_config='{"Flush": {"Messages": 100, "Frequency": "-1s"}}'`,
 		`webhook-https://fake-host`,
 	)
+	sqlDB.ExpectErr(
+		t, `invalid option value webhook_sink_config, all config values must be non-negative`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_sink_config='{"Parallelism": -1}'`,
+		`webhook-https://fake-host`,
+	)
 	sqlDB.ExpectErr(
 		t, `invalid option value webhook_sink_config, flush frequency is not set, messages may never be sent`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_sink_config='{"Flush": {"Messages": 100}}'`,
@@ -4258,10 +4978,30 @@ func TestChangefeedErrors(t *testing.T) {
 		`webhook-https://fake-host`,
 	)
 	sqlDB.ExpectErr(
-		t, `this sink is incompatible with option compression`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH compression='gzip'`,
+		t, `AcknowledgeResolved of webhook_sink_config requires the resolved option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_sink_config='{"AcknowledgeResolved": true}'`,
+		`webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with compression=lz4`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compression='lz4'`,
+		`webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `option sink_retry_max_attempts must be a positive integer: sink_retry_max_attempts='0'`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH sink_retry_max_attempts='0'`,
+		`webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `option sink_retry_max_backoff must not be less than sink_retry_backoff`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH sink_retry_backoff='10s', sink_retry_max_backoff='1s'`,
 		`webhook-https://fake-host`,
 	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option sink_retry_on`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH sink_retry_on='transient'`,
+		`kafka://nope/`,
+	)
 	sqlDB.ExpectErr(
 		t, `max retries must be either a positive int or 'inf' for infinite retries.`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_sink_config='{"Retry": {"Max": "not valid"}}'`,
@@ -4304,6 +5044,40 @@ func TestChangefeedErrors(t *testing.T) {
 		`kafka://nope`)
 }
 
+func TestChangefeedProbeSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, stopServer := makeServer(t)
+	defer stopServer()
+	sqlDB := sqlutils.MakeSQLRunner(s.DB)
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+	cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamCACert, certEncoded)
+	sinkDestHost.RawQuery = params.Encode()
+
+	// The probe is delivered before the statement returns.
+	var jobID int
+	sqlDB.QueryRow(t, `CREATE CHANGEFEED FOR foo INTO $1 WITH probe_sink`,
+		fmt.Sprintf("webhook-%s", sinkDestHost.String())).Scan(&jobID)
+	defer sqlDB.Exec(t, `CANCEL JOB $1`, jobID)
+	require.Contains(t, sinkDest.Pop(), `"resolved"`)
+
+	// A sink which cannot be delivered to fails the statement.
+	sinkDest.Close()
+	sqlDB.ExpectErr(t, `probe_sink failed`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH probe_sink, sink_retry_max_attempts='1'`,
+		fmt.Sprintf("webhook-%s", sinkDestHost.String()))
+}
+
 func TestChangefeedDescription(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -4609,6 +5383,130 @@ func TestCDCPrev(t *testing.T) {
 	cdcTest(t, testFn, feedTestForceSink("kafka"))
 }
 
+func TestCDCPrevColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, status STRING, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (0, 'open', 'initial')`)
+		// TODO(#85143): remove schema_change_policy='stop' from this test.
+		foo := feed(t, f, `CREATE CHANGEFEED WITH envelope='row', schema_change_policy='stop' `+
+			`AS SELECT a, cdc_prev.status AS old_status, status FROM foo `+
+			`WHERE cdc_prev.status IS DISTINCT FROM status`)
+		defer closeFeed(t, foo)
+
+		// Previous values are null during initial scan, and for insert events.
+		assertPayloads(t, foo, []string{
+			`foo: [0]->{"a": 0, "old_status": null, "status": "open"}`,
+		})
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'open', 'original')`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"a": 1, "old_status": null, "status": "open"}`,
+		})
+
+		// Updates which do not change the status are filtered out.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'updated' WHERE a = 1`)
+		sqlDB.Exec(t, `UPDATE foo SET status = 'closed' WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"a": 1, "old_status": "open", "status": "closed"}`,
+		})
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
+func TestChangefeedEventOp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (0, 'initial')`)
+		// TODO(#85143): remove schema_change_policy='stop' from this test.
+		foo := feed(t, f, `CREATE CHANGEFEED WITH envelope='row', schema_change_policy='stop' `+
+			`AS SELECT a, b, event_op() AS op FROM foo WHERE event_op() IN ('insert', 'update')`)
+		defer closeFeed(t, foo)
+
+		// Rows are inserted during initial scan.
+		assertPayloads(t, foo, []string{
+			`foo: [0]->{"a": 0, "b": "initial", "op": "insert"}`,
+		})
+
+		// Deletes are filtered out.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'original')`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 0`)
+		sqlDB.Exec(t, `UPSERT INTO foo VALUES (1, 'updated')`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"a": 1, "b": "original", "op": "insert"}`,
+			`foo: [1]->{"a": 1, "b": "updated", "op": "update"}`,
+		})
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
+func TestChangefeedLookupJoin(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE customers (id INT PRIMARY KEY, name STRING)`)
+		sqlDB.Exec(t, `CREATE TABLE orders (id INT PRIMARY KEY, cid INT)`)
+		sqlDB.Exec(t, `INSERT INTO customers VALUES (1, 'alice')`)
+		sqlDB.Exec(t, `INSERT INTO orders VALUES (1, 1)`)
+		// TODO(#85143): remove schema_change_policy='stop' from this test.
+		orders := feed(t, f, `CREATE CHANGEFEED WITH envelope='row', schema_change_policy='stop' `+
+			`AS SELECT o.id, c.name FROM orders AS o JOIN customers AS c ON c.id = o.cid`)
+		defer closeFeed(t, orders)
+
+		assertPayloads(t, orders, []string{
+			`orders: [1]->{"id": 1, "name": "alice"}`,
+		})
+
+		// Orders without a customer are filtered out, and the customers are
+		// looked up as of the time of the order.
+		sqlDB.Exec(t, `INSERT INTO orders VALUES (2, 3)`)
+		sqlDB.Exec(t, `UPDATE customers SET name = 'alicia' WHERE id = 1`)
+		sqlDB.Exec(t, `INSERT INTO orders VALUES (3, 1)`)
+		assertPayloads(t, orders, []string{
+			`orders: [3]->{"id": 3, "name": "alicia"}`,
+		})
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
+func TestChangefeedUserDefinedFunction(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b INT)`)
+		sqlDB.Exec(t, `CREATE FUNCTION twice(x INT) RETURNS INT IMMUTABLE LANGUAGE SQL AS 'SELECT x * 2'`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 1), (2, 2)`)
+		// TODO(#85143): remove schema_change_policy='stop' from this test.
+		foo := feed(t, f, `CREATE CHANGEFEED WITH envelope='row', schema_change_policy='stop' `+
+			`AS SELECT a, twice(b) AS c FROM foo WHERE twice(a) > 2`)
+		defer closeFeed(t, foo)
+
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"a": 2, "c": 4}`,
+		})
+
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (3, 5)`)
+		assertPayloads(t, foo, []string{
+			`foo: [3]->{"a": 3, "c": 10}`,
+		})
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
 func TestChangefeedProtectedTimestamps(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -4891,6 +5789,103 @@ func TestChangefeedProtectedTimestampOnPause(t *testing.T) {
 
 }
 
+func TestChangefeedProtectedTimestampExpiresOnPause(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `SET CLUSTER SETTING kv.protectedts.reconciliation.interval = '10ms'`)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved, gc_protect_expires_after = '1s'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1}}`})
+		expectResolvedTimestamp(t, foo)
+
+		feedJob := foo.(cdctest.EnterpriseTestFeed)
+		require.NoError(t, feedJob.Pause())
+
+		// The paused changefeed keeps protecting its data until the record
+		// expires, after which it fails and the record is released.
+		testutils.SucceedsSoon(t, func() error {
+			var status string
+			var active bool
+			sqlDB.QueryRow(t, `SELECT status, protected_timestamp_active FROM [SHOW CHANGEFEED JOB $1]`,
+				feedJob.JobID()).Scan(&status, &active)
+			if status != string(jobs.StatusFailed) {
+				return errors.Newf("expected changefeed to fail, found status %s", status)
+			}
+			if active {
+				return errors.New("expected protected timestamp record to be released")
+			}
+			return nil
+		})
+		var jobErr string
+		sqlDB.QueryRow(t, `SELECT error FROM [SHOW CHANGEFEED JOB $1]`, feedJob.JobID()).Scan(&jobErr)
+		require.Contains(t, jobErr, changefeedbase.OptExpirePTSAfter)
+		// The expiration is measured from the time the changefeed was paused
+		// rather than from its high-water.
+		require.Contains(t, jobErr, "changefeed was paused at")
+	}
+
+	cdcTest(t, testFn, feedTestNoTenants, feedTestEnterpriseSinks)
+}
+
+func TestChangefeedProtectedTimestampWhenLaggingBehindGCTTL(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		ctx := context.Background()
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		// Only the targets of changefeeds which lag behind are protected.
+		changefeedbase.ActiveProtectedTimestampsEnabled.Override(ctx, &s.Server.ClusterSettings().SV, false)
+		changefeedbase.ProtectTimestampInterval.Override(ctx, &s.Server.ClusterSettings().SV, 10*time.Millisecond)
+		// The frontier lags behind the present by at least the closed timestamp
+		// target duration.
+		sqlDB.Exec(t, `SET CLUSTER SETTING kv.closed_timestamp.target_duration = '2s'`)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved = '10ms', min_checkpoint_frequency = '10ms'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1}}`})
+
+		jr := s.Server.DistSQLServer().(*distsql.ServerImpl).ServerConfig.JobRegistry
+		jobID := foo.(cdctest.EnterpriseTestFeed).JobID()
+		protected := func() bool {
+			j, err := jr.LoadJob(ctx, jobID)
+			require.NoError(t, err)
+			return j.Progress().GetChangefeed().ProtectedTimestampRecord != uuid.Nil
+		}
+
+		// The frontier lags far less than the default GC TTL, so the record of
+		// the initial scan is released.
+		testutils.SucceedsSoon(t, func() error {
+			expectResolvedTimestamp(t, foo)
+			if protected() {
+				return errors.New("expected protected timestamp record to be released")
+			}
+			return nil
+		})
+
+		// Once the GC TTL of the table is shorter than the lag of the frontier,
+		// the table is protected at the high-water.
+		sqlDB.Exec(t, `ALTER TABLE foo CONFIGURE ZONE USING gc.ttlseconds = 1`)
+		testutils.SucceedsSoon(t, func() error {
+			expectResolvedTimestamp(t, foo)
+			if !protected() {
+				return errors.New("expected protected timestamp record to be written")
+			}
+			return nil
+		})
+	}
+
+	cdcTest(t, testFn, feedTestEnterpriseSinks)
+}
+
 func TestManyChangefeedsOneTable(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -6226,6 +7221,77 @@ func TestChangefeedOnlyInitialScan(t *testing.T) {
 	cdcTest(t, testFn, feedTestEnterpriseSinks)
 }
 
+func TestChangefeedJobRetention(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	defer func(prev bool) { enableSLIMetrics = prev }(enableSLIMetrics)
+	enableSLIMetrics = true
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, "CREATE TABLE foo (a INT PRIMARY KEY)")
+		sqlDB.Exec(t, "INSERT INTO foo VALUES (1), (2), (3)")
+
+		registry := s.Server.JobRegistry().(*jobs.Registry)
+		jobExists := func(id jobspb.JobID) bool {
+			var count int
+			sqlDB.QueryRow(t, `SELECT count(*) FROM system.jobs WHERE id = $1`, id).Scan(&count)
+			return count > 0
+		}
+
+		// Changefeed which completes after the initial scan.
+		completed := feed(t, f, `CREATE CHANGEFEED FOR foo WITH initial_scan_only, job_retention='1ms', metrics_label='short_lived'`)
+		assertPayloads(t, completed, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+			`foo: [2]->{"after": {"a": 2}}`,
+			`foo: [3]->{"after": {"a": 3}}`,
+		})
+		completedJob := completed.(cdctest.EnterpriseTestFeed)
+		require.NoError(t, completedJob.WaitForStatus(func(s jobs.Status) bool {
+			return s == jobs.StatusSucceeded
+		}))
+		closeFeed(t, completed)
+
+		job, err := registry.LoadJob(context.Background(), completedJob.JobID())
+		require.NoError(t, err)
+		require.Equal(t, time.Millisecond.Microseconds(), job.Payload().RetentionMicros)
+
+		// Running and paused changefeeds must never be cleaned up.
+		running := feed(t, f, `CREATE CHANGEFEED FOR foo WITH job_retention='1ms'`)
+		defer closeFeed(t, running)
+		paused := feed(t, f, `CREATE CHANGEFEED FOR foo WITH job_retention='1ms'`)
+		defer closeFeed(t, paused)
+		assertPayloads(t, paused, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+			`foo: [2]->{"after": {"a": 2}}`,
+			`foo: [3]->{"after": {"a": 3}}`,
+		})
+		pausedJob := paused.(cdctest.EnterpriseTestFeed)
+		require.NoError(t, pausedJob.Pause())
+
+		sqlDB.Exec(t, `SET CLUSTER SETTING jobs.registry.interval.gc = '10ms'`)
+		testutils.SucceedsSoon(t, func() error {
+			if jobExists(completedJob.JobID()) {
+				return errors.Newf("job %d has not been cleaned up yet", completedJob.JobID())
+			}
+			return nil
+		})
+
+		require.True(t, jobExists(running.(cdctest.EnterpriseTestFeed).JobID()))
+		require.True(t, jobExists(pausedJob.JobID()))
+
+		// The metrics registered for the label of the removed job are gone.
+		m := registry.MetricsStruct().Changefeed.(*Metrics).AggMetrics
+		m.mu.Lock()
+		_, found := m.mu.sliMetrics["short_lived"]
+		m.mu.Unlock()
+		require.False(t, found)
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
 func TestChangefeedOnlyInitialScanCSV(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -6519,7 +7585,7 @@ func normalizeCDCExpression(t *testing.T, execCfgI interface{}, exprStr string)
 
 	execCtx := p.(sql.JobExecContext)
 	_, _, err = cdceval.NormalizeAndValidateSelectForTarget(
-		context.Background(), execCtx, desc, target, sc, false, false,
+		context.Background(), execCtx, desc, target, sc, nil, false, false,
 	)
 	require.NoError(t, err)
 	log.Infof(context.Background(), "PostNorm: %s", tree.StmtDebugString(sc))
@@ -6549,6 +7615,7 @@ CREATE TABLE foo (
   e status DEFAULT 'inactive',
   PRIMARY KEY (a, b)
 )`)
+	sqlDB.Exec(t, `CREATE FUNCTION vol(x INT) RETURNS INT VOLATILE LANGUAGE SQL AS 'SELECT x'`)
 
 	for _, tc := range []struct {
 		name   string
@@ -6585,6 +7652,26 @@ CREATE TABLE foo (
 			create: `CREATE CHANGEFEED INTO 'null://' AS SELECT * FROM foo AS bar WHERE foo.a > 0`,
 			err:    `no data source matches prefix: foo in this context`,
 		},
+		{
+			name:   "join of missing table",
+			create: `CREATE CHANGEFEED INTO 'null://' AS SELECT * FROM foo JOIN bar ON bar.a = a`,
+			err:    `table "bar" does not exist`,
+		},
+		{
+			name:   "join without primary key condition",
+			create: `CREATE CHANGEFEED INTO 'null://' AS SELECT * FROM foo AS f JOIN foo AS g ON g.a = f.a`,
+			err:    `join condition of g must compare each of its primary key columns \(a, b\), and only those`,
+		},
+		{
+			name:   "unknown event operation",
+			create: `CREATE CHANGEFEED INTO 'null://' AS SELECT * FROM foo WHERE event_op() IN ('insert', 'updated')`,
+			err:    `unknown event operation "updated"`,
+		},
+		{
+			name:   "volatile user-defined function",
+			create: `CREATE CHANGEFEED INTO 'null://' AS SELECT * FROM foo WHERE vol(a) > 0`,
+			err:    `user-defined function ".*\.public\.vol" unsupported by CDC`,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			sqlDB.ExpectErr(t, tc.err, tc.create)
@@ -6921,19 +8008,12 @@ func TestChangefeedMultiPodTenantPlanning(t *testing.T) {
 	defer log.Scope(t).Close(t)
 	skip.UnderRace(t, "may time out due to multiple servers")
 
-	// Record the number of aggregators in planning
-	aggregatorCount := 0
-
 	// Create 2 connections of the same tenant on a cluster to have 2 pods
 	tc, _, cleanupDB := startTestCluster(t)
 	defer cleanupDB()
 
 	tenantKnobs := base.TestingKnobs{
-		DistSQL: &execinfra.TestingKnobs{Changefeed: &TestingKnobs{
-			OnDistflowSpec: func(aggregatorSpecs []*execinfrapb.ChangeAggregatorSpec, _ *execinfrapb.ChangeFrontierSpec) {
-				aggregatorCount = len(aggregatorSpecs)
-			},
-		}},
+		DistSQL:          &execinfra.TestingKnobs{Changefeed: &TestingKnobs{}},
 		JobsTestingKnobs: jobs.NewTestingKnobsWithShortIntervals(),
 		Server:           &server.TestingKnobs{},
 	}
@@ -6974,7 +8054,177 @@ func TestChangefeedMultiPodTenantPlanning(t *testing.T) {
 		`bar: [2]->{"after": {"b": 2}}`,
 	})
 
-	require.Equal(t, 2, aggregatorCount)
+	aggregators, _ := planChangefeedJob(
+		t, tenant1Server, foo.(cdctest.EnterpriseTestFeed).JobID(), jobspb.ChangefeedProgress_Checkpoint{})
+	require.Equal(t, 2, len(aggregators))
+}
+
+func TestChangefeedDistributedSinkless(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `CREATE TABLE bar (b INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1)`)
+
+		feed := feed(t, f, `CREATE CHANGEFEED FOR foo, bar WITH distributed`)
+		defer closeFeed(t, feed)
+		assertPayloads(t, feed, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+			`bar: [1]->{"after": {"b": 1}}`,
+		})
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		sqlDB.Exec(t, `DELETE FROM bar WHERE b = 1`)
+		assertPayloads(t, feed, []string{
+			`foo: [2]->{"after": {"a": 2}}`,
+			`bar: [1]->{"after": null}`,
+		})
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("sinkless"))
+}
+
+func TestPlanChangefeedDistribution(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `CREATE TABLE bar (b INT PRIMARY KEY)`)
+
+		type flowSpecs struct {
+			aggregators []*execinfrapb.ChangeAggregatorSpec
+			frontier    *execinfrapb.ChangeFrontierSpec
+		}
+		specs := make(chan flowSpecs, 1)
+		knobs := s.TestingKnobs.
+			DistSQL.(*execinfra.TestingKnobs).
+			Changefeed.(*TestingKnobs)
+		knobs.OnDistflowSpec = func(
+			aggregatorSpecs []*execinfrapb.ChangeAggregatorSpec, frontierSpec *execinfrapb.ChangeFrontierSpec,
+		) {
+			select {
+			case specs <- flowSpecs{aggregators: aggregatorSpecs, frontier: frontierSpec}:
+			default:
+			}
+		}
+
+		cf := feed(t, f, `CREATE CHANGEFEED FOR foo, bar`)
+		defer closeFeed(t, cf)
+		jobID := cf.(cdctest.EnterpriseTestFeed).JobID()
+
+		// The changefeed is planned like the job was, since neither of them
+		// have a high water or a checkpoint.
+		aggregators, frontier := planChangefeedJob(
+			t, s.Server, jobID, jobspb.ChangefeedProgress_Checkpoint{})
+		planned := <-specs
+		require.Equal(t, planned.frontier.TrackedSpans, frontier.TrackedSpans)
+		require.Equal(t, len(planned.aggregators), len(aggregators))
+		for i, spec := range planned.aggregators {
+			require.Equal(t, len(spec.Watches), len(aggregators[i].Watches))
+			for j, w := range spec.Watches {
+				require.Equal(t, w.Span, aggregators[i].Watches[j].Span)
+				require.Equal(t, w.InitialResolved, aggregators[i].Watches[j].InitialResolved)
+			}
+		}
+
+		// The assignments round-trip through JSON.
+		encoded, err := json.Marshal(aggregators)
+		require.NoError(t, err)
+		var decoded []AggregatorAssignment
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		require.Equal(t, aggregators, decoded)
+		encoded, err = json.Marshal(frontier)
+		require.NoError(t, err)
+		var decodedFrontier FrontierAssignment
+		require.NoError(t, json.Unmarshal(encoded, &decodedFrontier))
+		require.Equal(t, frontier, decodedFrontier)
+
+		// A sinkless changefeed gets a single aggregator on the gateway, unless
+		// it is distributed like the changefeeds with a sink.
+		details := loadChangefeedDetails(t, s.Server, jobID)
+		details.SinkURI = ``
+		sinkless, sinklessFrontier := planChangefeed(t, s.Server, details, jobspb.ChangefeedProgress_Checkpoint{})
+		require.Equal(t, frontier, sinklessFrontier)
+		require.Len(t, sinkless, 1)
+		require.Equal(t, frontier.SQLInstanceID, sinkless[0].SQLInstanceID)
+		require.Len(t, sinkless[0].Watches, len(frontier.TrackedSpans))
+		if details.Opts == nil {
+			details.Opts = make(map[string]string)
+		}
+		details.Opts[changefeedbase.OptDistributed] = ``
+		distributed, _ := planChangefeed(t, s.Server, details, jobspb.ChangefeedProgress_Checkpoint{})
+		require.Equal(t, aggregators, distributed)
+
+		// The spans enclosed by the checkpoint are initially resolved as of the
+		// timestamp of the checkpoint.
+		fooSpan := frontier.TrackedSpans[0]
+		checkpointTS := hlc.Timestamp{WallTime: 42}
+		aggregators, _ = planChangefeedJob(t, s.Server, jobID, jobspb.ChangefeedProgress_Checkpoint{
+			Spans:     []roachpb.Span{fooSpan},
+			Timestamp: checkpointTS,
+		})
+		var watched int
+		for _, a := range aggregators {
+			for _, w := range a.Watches {
+				watched++
+				if fooSpan.Contains(w.Span) {
+					require.Equal(t, checkpointTS, w.InitialResolved)
+				} else {
+					require.True(t, w.InitialResolved.IsEmpty())
+				}
+			}
+		}
+		require.Equal(t, 2, watched)
+	}
+
+	cdcTest(t, testFn, feedTestEnterpriseSinks)
+}
+
+// planChangefeedJob plans the distribution of the changefeed job from its
+// statement time, with the specified checkpoint.
+func planChangefeedJob(
+	t *testing.T,
+	s serverutils.TestTenantInterface,
+	jobID jobspb.JobID,
+	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+) ([]AggregatorAssignment, FrontierAssignment) {
+	t.Helper()
+	return planChangefeed(t, s, loadChangefeedDetails(t, s, jobID), checkpoint)
+}
+
+// loadChangefeedDetails returns the details of the changefeed job.
+func loadChangefeedDetails(
+	t *testing.T, s serverutils.TestTenantInterface, jobID jobspb.JobID,
+) jobspb.ChangefeedDetails {
+	t.Helper()
+	job, err := s.JobRegistry().(*jobs.Registry).LoadJob(context.Background(), jobID)
+	require.NoError(t, err)
+	return job.Details().(jobspb.ChangefeedDetails)
+}
+
+// planChangefeed plans the distribution of the changefeed with the specified
+// details from its statement time, with the specified checkpoint.
+func planChangefeed(
+	t *testing.T,
+	s serverutils.TestTenantInterface,
+	details jobspb.ChangefeedDetails,
+	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+) ([]AggregatorAssignment, FrontierAssignment) {
+	t.Helper()
+	ctx := context.Background()
+	execCfg := s.ExecutorConfig().(sql.ExecutorConfig)
+	execCtx, cleanup := sql.MakeJobExecContext(
+		"plan-changefeed", username.RootUserName(), &sql.MemoryMetrics{}, &execCfg)
+	defer cleanup()
+	aggregators, frontier, err := PlanChangefeedDistribution(
+		ctx, execCtx, details, hlc.Timestamp{} /* initialHighWater */, checkpoint)
+	require.NoError(t, err)
+	return aggregators, frontier
 }
 
 func TestChangefeedCreateTelemetryLogs(t *testing.T) {
@@ -0,0 +1,107 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedbase
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/errors"
+)
+
+// FieldNamer names the fields holding the columns of the rows in the values
+// and keys of the messages (see OptFieldNameMapping and OptFieldNameCase).
+// The zero value names the fields after their columns.
+type FieldNamer struct {
+	mapping  map[string]string
+	nameCase FieldNameCase
+}
+
+// Name returns the name of the field holding the column. The columns named by
+// the mapping are not re-cased.
+func (n FieldNamer) Name(column string) string {
+	if name, ok := n.mapping[column]; ok {
+		return name
+	}
+	switch n.nameCase {
+	case OptFieldNameCaseCamel:
+		return joinWords(column, false /* capitalizeFirst */)
+	case OptFieldNameCasePascal:
+		return joinWords(column, true /* capitalizeFirst */)
+	default:
+		return column
+	}
+}
+
+// IsIdentity returns true if the fields are named after their columns.
+func (n FieldNamer) IsIdentity() bool {
+	return len(n.mapping) == 0 && n.nameCase == ``
+}
+
+// joinWords joins the words of the snake_case name, capitalizing all of them
+// but the first, unless capitalizeFirst is set. The other letters of the
+// words are kept as is.
+func joinWords(name string, capitalizeFirst bool) string {
+	var b strings.Builder
+	first := true
+	for _, word := range strings.Split(name, `_`) {
+		if word == `` {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(word)
+		if first && !capitalizeFirst {
+			r = unicode.ToLower(r)
+		} else {
+			r = unicode.ToUpper(r)
+		}
+		b.WriteRune(r)
+		b.WriteString(word[size:])
+		first = false
+	}
+	if b.Len() == 0 {
+		// Names made of underscores have no words.
+		return name
+	}
+	return b.String()
+}
+
+// parseFieldNameMapping parses the value of OptFieldNameMapping, a
+// comma-separated list of column:field pairs.
+func parseFieldNameMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(value, `,`) {
+		parts := strings.SplitN(pair, `:`, 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf(`%s must be a comma-separated list of column:field pairs, found %q`,
+				OptFieldNameMapping, pair)
+		}
+		column, field := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if column == `` || field == `` {
+			return nil, errors.Errorf(`%s must be a comma-separated list of column:field pairs, found %q`,
+				OptFieldNameMapping, pair)
+		}
+		if _, ok := mapping[column]; ok {
+			return nil, errors.Errorf(`%s maps column %q more than once`, OptFieldNameMapping, column)
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// GetFieldNamer returns the FieldNamer of the options, which were validated by
+// GetEncodingOptions.
+func (e EncodingOptions) GetFieldNamer() FieldNamer {
+	n := FieldNamer{nameCase: e.FieldNameCase}
+	if e.FieldNameMapping != `` {
+		// The mapping was validated along with the other options.
+		n.mapping, _ = parseFieldNameMapping(e.FieldNameMapping)
+	}
+	return n
+}
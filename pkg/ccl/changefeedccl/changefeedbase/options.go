@@ -9,10 +9,14 @@
 package changefeedbase
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -41,6 +45,51 @@ type FormatType string
 // OnErrorType configures the job behavior when an error occurs.
 type OnErrorType string
 
+// KafkaPartitionerType configures how the kafka sink assigns the messages to
+// partitions.
+type KafkaPartitionerType string
+
+// SinkRetryOnType configures which errors of the requests of the sink are
+// retried.
+type SinkRetryOnType string
+
+// CloudEventsMode configures how the CloudEvents of format=cloudevents are
+// carried by the messages of the sink.
+type CloudEventsMode string
+
+// CSVQuoteMode configures which fields of the rows of format=csv are quoted.
+type CSVQuoteMode string
+
+// MetadataColumn is a field of metadata which OptMetadataColumns adds to the
+// values of the rows.
+type MetadataColumn string
+
+// FieldNameCase configures how the fields holding the columns of the rows are
+// re-cased (see FieldNamer).
+type FieldNameCase string
+
+// SchemaIncompatibilityPolicy configures the behavior of a changefeed when the
+// schema registry rejects the schema of its rows as incompatible (see
+// OptOnSchemaIncompatibility).
+type SchemaIncompatibilityPolicy string
+
+// SubjectNameStrategy configures how the subjects under which the schemas of
+// the changefeed are registered are named (see
+// OptSchemaRegistrySubjectNameStrategy).
+type SubjectNameStrategy string
+
+// AvroDecimalMode configures how the DECIMAL columns of format=avro are
+// encoded (see OptAvroDecimalMode).
+type AvroDecimalMode string
+
+// DeletePayload configures the value of the messages of deleted rows (see
+// OptDeletePayload).
+type DeletePayload string
+
+// RowMetadataLocation is where the metadata of the rows of envelope=row is
+// placed (see OptRowMetadataConfig).
+type RowMetadataLocation string
+
 // SchemaChangeEventClass defines a set of schema change event types which
 // trigger the action defined by the SchemaChangeEventPolicy.
 type SchemaChangeEventClass string
@@ -94,6 +143,245 @@ const (
 	OptOnError                  = `on_error`
 	OptMetricsScope             = `metrics_label`
 	OptVirtualColumns           = `virtual_columns`
+	OptPartitionExpr            = `partition_expr`
+	OptJobRetention             = `job_retention`
+	OptExpirePTSAfter           = `gc_protect_expires_after`
+
+	// OptSuppressDuplicatesWindow suppresses the rows for a key emitted within
+	// the specified window of the previous row emitted for that key. The final
+	// state of every key is still emitted before a resolved timestamp covering
+	// it, so every key is emitted at least once per window in which it changes,
+	// and at least once per resolved timestamp. Deletes are never suppressed.
+	OptSuppressDuplicatesWindow = `suppress_duplicates_window`
+
+	// OptResolvedOnly suppresses all of the row events of the changefeed, which
+	// then only emits resolved timestamps. It implies `resolved`, which then
+	// emits the resolved timestamps as often as possible unless an interval is
+	// specified, and `no_initial_scan`. It is meant for consumers which only
+	// track the freshness of the data.
+	OptResolvedOnly = `resolved_only`
+
+	// OptEmitTxnID adds a `txn_id` field to every row event, which consumers
+	// can use as a deduplication key when writing the changes to a
+	// transactional outbox. Note that the field is derived from the MVCC commit
+	// timestamp of the row, so it groups the changes by commit timestamp rather
+	// than by logical transaction: all the rows written by a transaction share
+	// the same txn_id, but so may the rows of unrelated transactions which
+	// happen to commit at the same timestamp.
+	OptEmitTxnID = `emit_txn_id`
+
+	// OptEmitSecurityLabel adds a `security_label` field to every row event,
+	// whose value is the value of the specified column of the row, so that the
+	// consumers can enforce row-level access control. The column does not need
+	// to be part of the projection of the changefeed.
+	OptEmitSecurityLabel = `emit_security_label`
+
+	// OptCloudEventsMode chooses how the rows of format=cloudevents are
+	// carried by the messages of the sink: as structured events, whose values
+	// hold the attributes of the events along with their data (the default),
+	// or as binary events, whose values hold the data while the attributes are
+	// attached as headers. Binary events require a sink which supports message
+	// headers.
+	OptCloudEventsMode = `cloudevents_mode`
+
+	// OptCSVDelimiter, OptCSVQuote and OptCSVNull configure the encoding of
+	// the rows of format=csv: the character separating their fields (a comma
+	// by default), which of their fields are quoted (see CSVQuoteMode) and the
+	// representation of the NULL values (NULL by default).
+	OptCSVDelimiter = `csv_delimiter`
+	OptCSVQuote     = `csv_quote`
+	OptCSVNull      = `csv_null`
+	// OptCSVHeader starts the files of the cloud storage sink and the batches
+	// of the webhook sink with a header row of format=csv, naming the columns
+	// of the rows which follow it.
+	OptCSVHeader = `csv_header`
+
+	// OptMetadataColumns adds the specified comma-separated metadata fields
+	// (see MetadataColumn) to the values of the rows, in the same way for every
+	// envelope: alongside the other metadata fields of the rows in json, as
+	// fields of the envelope in avro, and as columns following the columns of
+	// the rows in csv.
+	OptMetadataColumns = `metadata_columns`
+
+	// OptFieldNameMapping renames the fields holding the specified columns of
+	// the rows in json and avro, given as comma-separated column:field pairs.
+	// OptFieldNameCase re-cases the fields holding the other columns (see
+	// FieldNameCase). Neither applies to the fields of metadata.
+	OptFieldNameMapping = `field_name_mapping`
+	OptFieldNameCase    = `field_name_case`
+
+	// OptRowMetadataConfig is a JSON configuration (RowMetadataConfig) of the
+	// metadata of the rows of envelope=row in json: which fields it holds, and
+	// whether they are nested under the __crdb__ key or placed at the top level
+	// of the values. It replaces the updated and mvcc_timestamp options.
+	OptRowMetadataConfig = `row_metadata_config`
+
+	// OptCompactFiles creates a companion job for a cloud storage changefeed,
+	// which merges the files emitted between consecutive resolved timestamps
+	// into files of up to the specified size. It requires `resolved`, since the
+	// resolved timestamp files delimit the windows of files that are final.
+	OptCompactFiles = `compact_files`
+
+	// OptSinkRetryMaxAttempts, OptSinkRetryBackoff and OptSinkRetryMaxBackoff
+	// override the retry policy of the requests of the sink: the maximum number
+	// of attempts of a request, and the initial and maximum backoff between
+	// them. They take precedence over the Retry of the JSON configuration of
+	// the sink, if any.
+	OptSinkRetryMaxAttempts = `sink_retry_max_attempts`
+	OptSinkRetryBackoff     = `sink_retry_backoff`
+	OptSinkRetryMaxBackoff  = `sink_retry_max_backoff`
+	// OptSinkRetryOn chooses which errors of the requests of the sink are
+	// retried: all of them, or only the transient ones, such as timeouts,
+	// connection failures and the responses of overloaded endpoints. The
+	// requests which fail otherwise, e.g. because they are rejected, fail the
+	// sink right away.
+	OptSinkRetryOn = `sink_retry_on`
+
+	// OptDeadLetter routes the rows which the changefeed permanently fails to
+	// encode or deliver, such as the rows whose schema the schema registry
+	// rejects or whose messages are too large for the sink, to the sink with
+	// the specified URI, rather than failing the changefeed. The dead letter
+	// sink receives a JSON message per row, holding the row and the error.
+	OptDeadLetter = `dead_letter`
+	// OptDeadLetterMaxMessages is the number of rows which each aggregator of
+	// the changefeed routes to the dead letter sink before it fails the
+	// changefeed, so that a changefeed which cannot deliver any of its rows
+	// does not silently move all of them aside.
+	OptDeadLetterMaxMessages = `dead_letter_max_messages`
+
+	// OptSinkThrottleConfig is a JSON configuration (SinkThrottleConfig) of the
+	// rate of messages and bytes which the aggregators of the changefeed on
+	// each node emit to the sink. It overrides the
+	// changefeed.aggregator_sink_throttle_config cluster setting.
+	OptSinkThrottleConfig = `sink_throttle_config`
+
+	// OptAdditionalSinks is a comma separated list of the URIs of the sinks to
+	// which the changefeed emits in addition to the sink it is created INTO,
+	// so that a single changefeed feeds several sinks from one scan of its
+	// tables. The options of the changefeed must be valid for all of them.
+	OptAdditionalSinks = `additional_sinks`
+
+	// OptProbeSink makes CREATE CHANGEFEED publish a resolved timestamp message
+	// to each of the topics of the changefeed, and wait for the sink to deliver
+	// it, before it returns. A changefeed whose sink cannot be reached,
+	// authenticated against or published to then fails at the SQL prompt,
+	// rather than once its job is running.
+	OptProbeSink = `probe_sink`
+
+	// OptDistributed makes a sinkless changefeed run a ChangeAggregator on the
+	// leaseholder of each of its spans, like the changefeeds with a sink do,
+	// rather than a single one on the gateway. The rows are streamed back to
+	// the ChangeFrontier on the gateway, which returns them to the client, so
+	// that the initial scan of wide tables is spread across the cluster.
+	OptDistributed = `distributed`
+
+	// OptEncryptionKMS is the URI of a KMS, such as the KMS of AWS or GCP, with
+	// which the values of the messages of the rows are encrypted: each
+	// aggregator of the changefeed encrypts them with a data key of its own,
+	// which it wraps with the master key of the KMS. The wrapped key and the ID
+	// of the master key are attached to the messages as headers, so the sink
+	// must support headers. Resolved timestamps are not encrypted.
+	OptEncryptionKMS = `encryption_kms`
+
+	// OptMessageCompression compresses the value of each message of the rows
+	// of format=json with the specified codec, gzip (the default) or zstd,
+	// whether or not the sink compresses its batches, so that large rows fit
+	// within the limits of sinks such as pubsub. The compressed values remain
+	// JSON, so that the sinks frame them like the other values: each is a JSON
+	// string holding MessageCompressionPrefix, the codec and a colon, followed
+	// by the standard base64 encoding of the compressed JSON value, e.g.
+	// "crdb-gzip:H4sIAAAAAAAA...". Consumers decode the values holding the
+	// prefix by decoding the base64 following the colon and decompressing it
+	// with the codec. Resolved timestamps are not compressed.
+	OptMessageCompression = `message_compression`
+
+	// OptMessageChunkSize splits the values of the messages of the rows which
+	// exceed the specified size, such as 900KiB, into chunks of up to that
+	// size, rather than failing the changefeed on rows which exceed the maximum
+	// message size of the sink. Each chunk is emitted as a message with the key
+	// of the row and the headers of the message, along with the
+	// MessageChunkIDHeader, MessageChunkIndexHeader and MessageChunkCountHeader
+	// headers, from which consumers reassemble the value: they buffer the
+	// chunks of each key and chunk ID until all of them have been received, and
+	// concatenate their values in the order of their indexes. Since the headers
+	// add to the size of the messages, the size should leave some room below
+	// the limit of the sink. Smaller values and resolved timestamps are emitted
+	// as is. The sink must support headers.
+	OptMessageChunkSize = `message_chunk_size`
+
+	// OptSchemaRegistryCompatibility sets the compatibility level of the
+	// subjects of the changefeed in the schema registry, such as backward or
+	// full_transitive, before their first schema is registered. Without it,
+	// the subjects follow the level configured in the registry.
+	OptSchemaRegistryCompatibility = `schema_registry_compatibility`
+
+	// OptOnSchemaIncompatibility configures what the changefeed does when the
+	// schema registry rejects a schema, such as the schema of a table after an
+	// ALTER TABLE, as incompatible with the schemas registered before it.
+	OptOnSchemaIncompatibility = `on_schema_incompatibility`
+
+	// OptSchemaRegistrySubjectNameStrategy names the subjects of the schemas
+	// registered by the changefeed after their topic (the default), their
+	// record or both, like the subject name strategies of the confluent
+	// serializers.
+	OptSchemaRegistrySubjectNameStrategy = `schema_registry_subject_name_strategy`
+
+	// OptSchemaRegistrySubjectTemplate names the subjects of the schemas
+	// registered by the changefeed after a template, in which {topic} is
+	// replaced with the topic of the schema, {record} with the full name of
+	// its record and {type} with key or value, e.g. "cdc.{topic}-{type}".
+	OptSchemaRegistrySubjectTemplate = `schema_registry_subject_template`
+
+	// OptDiffColumns is a comma-separated list of columns onto which the
+	// previous values of the rows emitted by the diff option, or by the
+	// debezium and enriched envelopes, are projected, so that wide tables only
+	// emit the previous values of the columns which their consumers track.
+	OptDiffColumns = `diff_columns`
+
+	// OptDeletePayload overrides the value of the messages of deleted rows,
+	// which depends on the envelope otherwise: a null value, as the tombstones
+	// of compacted Kafka topics, with any envelope, or, with envelope=row, the
+	// previous values of the rows or their key columns, which consumers such
+	// as Elasticsearch need to locate the documents to delete.
+	OptDeletePayload = `delete_payload`
+
+	// OptAvroDecimalMode configures how format=avro encodes the DECIMAL
+	// columns without a precision, which the avro decimal logical type cannot
+	// represent, and the values which do not fit the precision and scale of
+	// their column, such as the previous values of a column whose type was
+	// altered.
+	OptAvroDecimalMode = `avro_decimal_mode`
+	// OptAvroDecimalPrecision is the precision and scale, such as '38,9', of
+	// the avro decimals of the DECIMAL columns without a precision, with
+	// avro_decimal_mode=fixed.
+	OptAvroDecimalPrecision = `avro_decimal_precision`
+
+	// OptCanonicalJSON makes format=json write the keys and the values of the
+	// rows in a canonical form, so that the messages of a row are identical
+	// whichever node or version emits them, as consumers which deduplicate
+	// messages by hashing them, and test fixtures, expect: the keys of the
+	// objects are sorted by their UTF-8 bytes, no whitespace separates the
+	// tokens, and the numbers are written in plain notation, without exponent,
+	// trailing zeros in their fraction or negative zero.
+	OptCanonicalJSON = `canonical_json`
+
+	// OptValueTemplate is the Go text/template which renders the values of the
+	// rows of format=template. See the templateRow of the changefeedccl
+	// package for the fields available to the template.
+	OptValueTemplate = `value_template`
+
+	// OptKeyExpr keys the messages of the rows by the value of a scalar
+	// expression over their columns, such as a business key or a hash of
+	// columns, in place of their primary keys, so that the partitioning and
+	// compaction of the topics follow it. The deleted rows are keyed by their
+	// previous values, which the changefeed fetches for the purpose. Note that
+	// the changes of a row are only ordered with respect to the other changes
+	// keyed alike, so a row whose key changes may be emitted out of order
+	// across its keys.
+	OptKeyExpr = `key_expr`
+
+	OptSinkRetryOnAll       SinkRetryOnType = `all`
+	OptSinkRetryOnTransient SinkRetryOnType = `transient`
 
 	OptVirtualColumnsOmitted VirtualColumnVisibility = `omitted`
 	OptVirtualColumnsNull    VirtualColumnVisibility = `null`
@@ -138,14 +426,153 @@ const (
 	OptEnvelopeRow           EnvelopeType = `row`
 	OptEnvelopeDeprecatedRow EnvelopeType = `deprecated_row`
 	OptEnvelopeWrapped       EnvelopeType = `wrapped`
-
-	OptFormatJSON FormatType = `json`
-	OptFormatAvro FormatType = `avro`
-	OptFormatCSV  FormatType = `csv`
+	// OptEnvelopeDebezium wraps the rows in the envelope of Debezium change
+	// events, holding the previous and new values of the row, its source, the
+	// operation which produced it and its timestamp.
+	OptEnvelopeDebezium EnvelopeType = `debezium`
+	// OptEnvelopeEnriched wraps the rows in an envelope holding their previous
+	// and new values, the operation which produced them, their commit
+	// timestamp, and the cluster, node and job of the changefeed.
+	OptEnvelopeEnriched EnvelopeType = `enriched`
+
+	OptFormatJSON     FormatType = `json`
+	OptFormatAvro     FormatType = `avro`
+	OptFormatCSV      FormatType = `csv`
+	OptFormatProtobuf FormatType = `protobuf`
+	// OptFormatCloudEvents wraps the JSON encoding of the rows in CloudEvents
+	// (https://cloudevents.io), whose attributes are derived from the table
+	// and the MVCC timestamp of the rows (see OptCloudEventsMode).
+	OptFormatCloudEvents FormatType = `cloudevents`
+	// OptFormatParquet emits the rows in parquet files. The cloud storage sink
+	// writes a file per topic between flushes, while the kafka and webhook
+	// sinks emit a message per topic between flushes, holding the file (see
+	// parquetBatchingSink).
+	OptFormatParquet FormatType = `parquet`
+	// OptFormatMsgpack emits the JSON encoding of the rows as MessagePack
+	// (https://msgpack.org), whose integral numbers are encoded as integers
+	// and other numbers as 64-bit floats.
+	OptFormatMsgpack FormatType = `msgpack`
+	// OptFormatTemplate renders the values of the rows with the template of
+	// OptValueTemplate, for consumers which expect a line format that none of
+	// the other formats produce. The keys are those of format=json.
+	OptFormatTemplate FormatType = `template`
+	// OptFormatXML emits the JSON encoding of the rows as XML documents,
+	// whose elements are named after the fields of the JSON objects.
+	OptFormatXML FormatType = `xml`
+
+	OptCloudEventsModeStructured CloudEventsMode = `structured`
+	OptCloudEventsModeBinary     CloudEventsMode = `binary`
+
+	// OptCSVQuoteMinimal quotes the fields which could not be told apart
+	// otherwise: the fields holding a delimiter, a quote or a newline, and
+	// the values which match the representation of NULL values.
+	OptCSVQuoteMinimal CSVQuoteMode = `minimal`
+	// OptCSVQuoteAll quotes every field but the NULL values.
+	OptCSVQuoteAll CSVQuoteMode = `all`
+	// OptCSVQuoteNone quotes no field, which leaves it to the consumers to
+	// handle the fields holding a delimiter or a newline.
+	OptCSVQuoteNone CSVQuoteMode = `none`
+
+	// OptMetadataColumnMVCCTimestamp is the MVCC timestamp of the row.
+	OptMetadataColumnMVCCTimestamp MetadataColumn = `mvcc_timestamp`
+	// OptMetadataColumnStatementTime is the statement time of the changefeed.
+	OptMetadataColumnStatementTime MetadataColumn = `statement_time`
+	// OptMetadataColumnTableID is the ID of the table of the row.
+	OptMetadataColumnTableID MetadataColumn = `table_id`
+	// OptMetadataColumnJobID is the ID of the job of the changefeed.
+	OptMetadataColumnJobID MetadataColumn = `job_id`
+	// OptMetadataColumnClusterID is the logical ID of the cluster of the
+	// changefeed.
+	OptMetadataColumnClusterID MetadataColumn = `cluster_id`
+	// OptMetadataColumnNodeID is the ID of the node which emitted the row.
+	OptMetadataColumnNodeID MetadataColumn = `node_id`
+
+	// OptFieldNameCaseCamel turns snake_case column names into camelCase.
+	OptFieldNameCaseCamel FieldNameCase = `camel`
+	// OptFieldNameCasePascal turns snake_case column names into PascalCase.
+	OptFieldNameCasePascal FieldNameCase = `pascal`
+
+	// OptRowMetadataNested nests the metadata under the __crdb__ key, as
+	// without OptRowMetadataConfig.
+	OptRowMetadataNested RowMetadataLocation = `nested`
+	// OptRowMetadataTopLevel places the metadata at the top level of the
+	// values, alongside the columns of the rows, which it shadows.
+	OptRowMetadataTopLevel RowMetadataLocation = `top_level`
+
+	// The fields which OptRowMetadataConfig can add to the metadata of the
+	// rows: their updated and MVCC timestamps, and the operation which
+	// produced them (see operationOfRow), which needs the diff option to tell
+	// inserts from updates.
+	RowMetadataFieldUpdated       = `updated`
+	RowMetadataFieldMVCCTimestamp = `mvcc_timestamp`
+	RowMetadataFieldOperation     = `operation`
 
 	OptOnErrorFail  OnErrorType = `fail`
 	OptOnErrorPause OnErrorType = `pause`
 
+	// OptSchemaIncompatibilityFail fails the changefeed, with an error naming
+	// the subject, unless a dead letter sink receives the rows instead.
+	OptSchemaIncompatibilityFail SchemaIncompatibilityPolicy = `fail`
+	// OptSchemaIncompatibilityPause pauses the changefeed, whatever the value
+	// of OptOnError, so that it can be resumed once the subject is fixed.
+	OptSchemaIncompatibilityPause SchemaIncompatibilityPolicy = `pause`
+	// OptSchemaIncompatibilityCoerce sets the compatibility level of the
+	// subject to none and registers the schema again.
+	OptSchemaIncompatibilityCoerce SchemaIncompatibilityPolicy = `coerce`
+
+	// OptSubjectNameTopic names the subjects <topic>-key and <topic>-value,
+	// as the TopicNameStrategy does.
+	OptSubjectNameTopic SubjectNameStrategy = `topic_name`
+	// OptSubjectNameRecord names the subjects after the full name of their
+	// record, as the RecordNameStrategy does.
+	OptSubjectNameRecord SubjectNameStrategy = `record_name`
+	// OptSubjectNameTopicRecord names the subjects <topic>-<record>, as the
+	// TopicRecordNameStrategy does.
+	OptSubjectNameTopicRecord SubjectNameStrategy = `topic_record_name`
+
+	// OptDeletePayloadTombstone emits deleted rows with a null value.
+	OptDeletePayloadTombstone DeletePayload = `tombstone`
+	// OptDeletePayloadPriorRow emits the previous values of deleted rows as
+	// their value, which requires the diff option. Rows whose previous values
+	// are unknown are emitted with a null value.
+	OptDeletePayloadPriorRow DeletePayload = `prior_row`
+	// OptDeletePayloadKeyColumns emits the key columns of deleted rows as
+	// their value.
+	OptDeletePayloadKeyColumns DeletePayload = `key_columns`
+
+	// OptAvroDecimalError fails to encode the DECIMAL columns without a
+	// precision, and the values which do not fit their column.
+	OptAvroDecimalError AvroDecimalMode = `error`
+	// OptAvroDecimalString encodes the DECIMAL columns without a precision as
+	// strings, and the values which do not fit their column as the string
+	// member of the union of their field.
+	OptAvroDecimalString AvroDecimalMode = `string`
+	// OptAvroDecimalFixed encodes the DECIMAL columns without a precision as
+	// decimals of the precision and scale of OptAvroDecimalPrecision, and the
+	// values which do not fit their column, such as the values with more
+	// fractional digits than the scale, as the string member of the union of
+	// their field.
+	OptAvroDecimalFixed AvroDecimalMode = `fixed`
+
+	OptKafkaPartitionerHash       KafkaPartitionerType = `hash`
+	OptKafkaPartitionerRoundRobin KafkaPartitionerType = `roundrobin`
+	OptKafkaPartitionerSticky     KafkaPartitionerType = `sticky`
+	// OptKafkaPartitionerColumn hashes the value of an expression over the
+	// columns of the row rather than the key. Since it requires the expression,
+	// it can only be configured in the Partitioner of OptKafkaSinkConfig.
+	OptKafkaPartitionerColumn KafkaPartitionerType = `column`
+
+	// The codecs of OptCompression. Kafka sinks support all of them, while
+	// webhook and cloud storage sinks only support gzip and zstd.
+	OptCompressionGzip   = `gzip`
+	OptCompressionZstd   = `zstd`
+	OptCompressionLZ4    = `lz4`
+	OptCompressionSnappy = `snappy`
+
+	// MessageCompressionPrefix starts the values compressed by
+	// OptMessageCompression.
+	MessageCompressionPrefix = `crdb-`
+
 	DeprecatedOptFormatAvro                   = `experimental_avro`
 	DeprecatedSinkSchemeCloudStorageAzure     = `experimental-azure`
 	DeprecatedSinkSchemeCloudStorageGCS       = `experimental-gs`
@@ -157,6 +584,76 @@ const (
 	// OptKafkaSinkConfig is a JSON configuration for kafka sink (kafkaSinkConfig).
 	OptKafkaSinkConfig   = `kafka_sink_config`
 	OptWebhookSinkConfig = `webhook_sink_config`
+	// OptKinesisSinkConfig is a JSON configuration for the kinesis sink
+	// (kinesisSinkConfig), which configures its partition keys, aggregation,
+	// batching and retries.
+	OptKinesisSinkConfig = `kinesis_sink_config`
+	// OptNATSSinkConfig is a JSON configuration for the NATS JetStream sink
+	// (natsSinkConfig), which configures how long it waits for the
+	// acknowledgements of JetStream and how many messages may await them.
+	OptNATSSinkConfig = `nats_sink_config`
+	// OptAMQPSinkConfig is a JSON configuration for the AMQP sink
+	// (amqpSinkConfig), which configures the confirmations of the messages and
+	// their retries.
+	OptAMQPSinkConfig = `amqp_sink_config`
+	// OptGRPCSinkConfig is a JSON configuration for the gRPC sink
+	// (grpcSinkConfig), which configures how long it waits for the
+	// acknowledgements of the consumer and how many requests may await them.
+	OptGRPCSinkConfig = `grpc_sink_config`
+	// OptBigQuerySinkConfig is a JSON configuration for the BigQuery sink
+	// (bigQuerySinkConfig), which configures the batching of the rows and
+	// their retries.
+	OptBigQuerySinkConfig = `bigquery_sink_config`
+	// OptSnowflakeSinkConfig is a JSON configuration for the Snowflake sink
+	// (snowflakeSinkConfig), which configures the batching of the rows, how
+	// long the sink waits for Snowflake to commit them and the retries of the
+	// requests.
+	OptSnowflakeSinkConfig = `snowflake_sink_config`
+	// OptElasticsearchSinkConfig is a JSON configuration for the Elasticsearch
+	// sink (elasticsearchSinkConfig), which configures the batching of the
+	// bulk requests and their retries.
+	OptElasticsearchSinkConfig = `elasticsearch_sink_config`
+	// OptClickHouseSinkConfig is a JSON configuration for the ClickHouse sink
+	// (clickHouseSinkConfig), which configures the batching of the inserts and
+	// their retries.
+	OptClickHouseSinkConfig = `clickhouse_sink_config`
+	// OptSQSSinkConfig is a JSON configuration for the SQS sink
+	// (sqsSinkConfig), which configures the batching of the messages and their
+	// retries.
+	OptSQSSinkConfig = `sqs_sink_config`
+
+	// OptKafkaMaxInFlight is the maximum number of unacknowledged requests the
+	// kafka producer may have in flight per broker connection. More requests in
+	// flight improve throughput, but a retried request may then land after a
+	// request sent after it, reordering the messages for a key.
+	OptKafkaMaxInFlight = `kafka_max_in_flight`
+	// OptKafkaStrictOrdering requests that the messages for a key are never
+	// reordered, even when the kafka producer retries requests. Since the
+	// producer is not idempotent, this limits it to a single request in flight
+	// per broker connection.
+	OptKafkaStrictOrdering = `kafka_strict_ordering`
+	// OptKafkaUnordered waives the ordering of the messages for a key, which
+	// the round robin and sticky partitioners do not preserve since they
+	// spread the messages for a key across partitions.
+	OptKafkaUnordered = `kafka_unordered`
+	// OptKafkaPartitioner is the strategy the kafka sink uses to assign the
+	// messages to partitions: the hash of their key (the default), or, when
+	// the ordering of the messages for a key is waived with OptKafkaUnordered,
+	// round robin or sticky partitions, which spread the messages evenly. The strategy may also be
+	// configured in the Partitioner of OptKafkaSinkConfig, which additionally
+	// supports hashing the value of an expression over the columns of the row.
+	OptKafkaPartitioner = `kafka_partitioner`
+	// OptKafkaHeaders is a comma-separated list of the headers the kafka sink
+	// attaches to the messages of the rows, so that consumers can route the
+	// messages without decoding them. Each header is named after a column of
+	// the targets, whose value it holds, or is one of the metadata headers
+	// (see IsMetadataHeader).
+	OptKafkaHeaders = `kafka_headers`
+	// OptPubsubAttributes is a comma-separated list of the attributes the
+	// pubsub sink attaches to the messages of the rows, so that subscriptions
+	// can filter the messages on the server. Attributes are named like the
+	// headers of OptKafkaHeaders; attributes holding NULL are omitted.
+	OptPubsubAttributes = `pubsub_attributes`
 
 	// OptSink allows users to alter the Sink URI of an existing changefeed.
 	// Note that this option is only allowed for alter changefeed statements.
@@ -165,24 +662,56 @@ const (
 	SinkParamCACert                 = `ca_cert`
 	SinkParamClientCert             = `client_cert`
 	SinkParamClientKey              = `client_key`
+	SinkParamCACertFile             = `ca_cert_file`
+	SinkParamClientCertFile         = `client_cert_file`
+	SinkParamClientKeyFile          = `client_key_file`
 	SinkParamFileSize               = `file_size`
+	SinkParamFileMaxRows            = `file_max_rows`
+	SinkParamFileMaxDuration        = `file_max_duration`
 	SinkParamPartitionFormat        = `partition_format`
+	SinkParamPartitionColumns       = `partition_columns`
+	SinkParamTableFormat            = `table_format`
+	SinkParamIcebergCatalog         = `iceberg_catalog`
+	SinkParamIcebergCatalogToken    = `iceberg_catalog_token`
+	SinkParamIcebergNamespace       = `iceberg_namespace`
 	SinkParamSchemaTopic            = `schema_topic`
 	SinkParamTLSEnabled             = `tls_enabled`
 	SinkParamSkipTLSVerify          = `insecure_tls_skip_verify`
 	SinkParamTopicPrefix            = `topic_prefix`
 	SinkParamTopicName              = `topic_name`
+	SinkParamSubjectTemplate        = `subject_template`
+	SinkParamExchange               = `exchange`
+	SinkParamRoutingKey             = `routing_key`
+	SinkParamRoutingKeyColumn       = `routing_key_column`
+	SinkParamUser                   = `user`
+	SinkParamPrivateKey             = `private_key`
+	SinkParamAPIKey                 = `api_key`
+	SinkParamFIFO                   = `fifo`
+	SinkParamOrderingKey            = `ordering_key`
+	SinkParamOrderingKeyExpr        = `ordering_key_expr`
+	SinkSchemeAMQP                  = `amqp`
+	SinkSchemeAMQPS                 = `amqps`
+	SinkSchemeBigQuery              = `bigquery`
+	SinkSchemeClickHouse            = `clickhouse`
 	SinkSchemeCloudStorageAzure     = `azure`
 	SinkSchemeCloudStorageGCS       = `gs`
 	SinkSchemeCloudStorageHTTP      = `http`
 	SinkSchemeCloudStorageHTTPS     = `https`
 	SinkSchemeCloudStorageNodelocal = `nodelocal`
 	SinkSchemeCloudStorageS3        = `s3`
+	SinkSchemeElasticsearch         = `elasticsearch`
 	SinkSchemeExperimentalSQL       = `experimental-sql`
+	SinkSchemeGRPC                  = `grpc`
 	SinkSchemeHTTP                  = `http`
 	SinkSchemeHTTPS                 = `https`
 	SinkSchemeKafka                 = `kafka`
+	SinkSchemeKafkaV2               = `kafka-v2`
+	SinkSchemeKinesis               = `kinesis`
+	SinkSchemeNATS                  = `nats`
 	SinkSchemeNull                  = `null`
+	SinkSchemeOpenSearch            = `opensearch`
+	SinkSchemeSnowflake             = `snowflake`
+	SinkSchemeSQS                   = `sqs`
 	SinkSchemeWebhookHTTP           = `webhook-http`
 	SinkSchemeWebhookHTTPS          = `webhook-https`
 	SinkSchemeExternalConnection    = `external`
@@ -191,6 +720,11 @@ const (
 	SinkParamSASLUser               = `sasl_user`
 	SinkParamSASLPassword           = `sasl_password`
 	SinkParamSASLMechanism          = `sasl_mechanism`
+	SinkParamSASLClientID           = `sasl_client_id`
+	SinkParamSASLClientSecret       = `sasl_client_secret`
+	SinkParamSASLTokenURL           = `sasl_token_url`
+	SinkParamSASLScopes             = `sasl_scopes`
+	SinkParamSASLGrantType          = `sasl_grant_type`
 
 	RegistryParamCACert = `ca_cert`
 
@@ -282,35 +816,91 @@ var jsonOption = OptionPermittedValues{Type: OptionTypeJSON}
 // ChangefeedOptionExpectValues is used to parse changefeed options using
 // PlanHookState.TypeAsStringOpts().
 var ChangefeedOptionExpectValues = map[string]OptionPermittedValues{
-	OptAvroSchemaPrefix:         stringOption,
-	OptConfluentSchemaRegistry:  stringOption,
-	OptCursor:                   timestampOption,
-	OptEndTime:                  timestampOption,
-	OptEnvelope:                 enum("row", "key_only", "wrapped", "deprecated_row"),
-	OptFormat:                   enum("json", "avro", "csv", "experimental_avro"),
-	OptFullTableName:            flagOption,
-	OptKeyInValue:               flagOption,
-	OptTopicInValue:             flagOption,
-	OptResolvedTimestamps:       durationOption.thatCanBeZero().orEmptyMeans("0"),
-	OptMinCheckpointFrequency:   durationOption.thatCanBeZero(),
-	OptUpdatedTimestamps:        flagOption,
-	OptMVCCTimestamps:           flagOption,
-	OptDiff:                     flagOption,
-	OptCompression:              enum("gzip"),
-	OptSchemaChangeEvents:       enum("column_changes", "default"),
-	OptSchemaChangePolicy:       enum("backfill", "nobackfill", "stop", "ignore"),
-	OptSplitColumnFamilies:      flagOption,
-	OptInitialScan:              enum("yes", "no", "only").orEmptyMeans("yes"),
-	OptNoInitialScan:            flagOption,
-	OptInitialScanOnly:          flagOption,
-	OptProtectDataFromGCOnPause: flagOption,
-	OptKafkaSinkConfig:          jsonOption,
-	OptWebhookSinkConfig:        jsonOption,
-	OptWebhookAuthHeader:        stringOption,
-	OptWebhookClientTimeout:     durationOption,
-	OptOnError:                  enum("pause", "fail"),
-	OptMetricsScope:             stringOption,
-	OptVirtualColumns:           enum("omitted", "null"),
+	OptAvroSchemaPrefix:                  stringOption,
+	OptConfluentSchemaRegistry:           stringOption,
+	OptCursor:                            timestampOption,
+	OptEndTime:                           timestampOption,
+	OptEnvelope:                          enum("row", "key_only", "wrapped", "deprecated_row", "debezium", "enriched"),
+	OptFormat:                            enum("json", "avro", "csv", "protobuf", "cloudevents", "parquet", "msgpack", "template", "xml", "experimental_avro"),
+	OptFullTableName:                     flagOption,
+	OptCloudEventsMode:                   enum("structured", "binary"),
+	OptCSVDelimiter:                      stringOption,
+	OptCSVQuote:                          enum("minimal", "all", "none"),
+	OptCSVNull:                           stringOption,
+	OptCSVHeader:                         flagOption,
+	OptMetadataColumns:                   stringOption,
+	OptFieldNameMapping:                  stringOption,
+	OptFieldNameCase:                     enum("camel", "pascal"),
+	OptRowMetadataConfig:                 jsonOption,
+	OptKeyInValue:                        flagOption,
+	OptTopicInValue:                      flagOption,
+	OptResolvedTimestamps:                durationOption.thatCanBeZero().orEmptyMeans("0"),
+	OptMinCheckpointFrequency:            durationOption.thatCanBeZero(),
+	OptUpdatedTimestamps:                 flagOption,
+	OptMVCCTimestamps:                    flagOption,
+	OptDiff:                              flagOption,
+	OptCompression:                       enum(OptCompressionGzip, OptCompressionZstd, OptCompressionLZ4, OptCompressionSnappy),
+	OptSchemaChangeEvents:                enum("column_changes", "default"),
+	OptSchemaChangePolicy:                enum("backfill", "nobackfill", "stop", "ignore"),
+	OptSplitColumnFamilies:               flagOption,
+	OptInitialScan:                       enum("yes", "no", "only").orEmptyMeans("yes"),
+	OptNoInitialScan:                     flagOption,
+	OptInitialScanOnly:                   flagOption,
+	OptProtectDataFromGCOnPause:          flagOption,
+	OptKafkaSinkConfig:                   jsonOption,
+	OptKafkaMaxInFlight:                  stringOption,
+	OptKafkaStrictOrdering:               flagOption,
+	OptKafkaUnordered:                    flagOption,
+	OptKafkaPartitioner:                  enum("hash", "roundrobin", "sticky"),
+	OptKafkaHeaders:                      stringOption,
+	OptPubsubAttributes:                  stringOption,
+	OptWebhookSinkConfig:                 jsonOption,
+	OptWebhookAuthHeader:                 stringOption,
+	OptWebhookClientTimeout:              durationOption,
+	OptKinesisSinkConfig:                 jsonOption,
+	OptNATSSinkConfig:                    jsonOption,
+	OptAMQPSinkConfig:                    jsonOption,
+	OptGRPCSinkConfig:                    jsonOption,
+	OptBigQuerySinkConfig:                jsonOption,
+	OptSnowflakeSinkConfig:               jsonOption,
+	OptElasticsearchSinkConfig:           jsonOption,
+	OptClickHouseSinkConfig:              jsonOption,
+	OptSQSSinkConfig:                     jsonOption,
+	OptOnError:                           enum("pause", "fail"),
+	OptMetricsScope:                      stringOption,
+	OptVirtualColumns:                    enum("omitted", "null"),
+	OptPartitionExpr:                     stringOption,
+	OptJobRetention:                      durationOption,
+	OptExpirePTSAfter:                    durationOption,
+	OptSuppressDuplicatesWindow:          durationOption,
+	OptResolvedOnly:                      flagOption,
+	OptEmitTxnID:                         flagOption,
+	OptEmitSecurityLabel:                 stringOption,
+	OptCompactFiles:                      stringOption.orEmptyMeans("64MiB"),
+	OptSinkRetryMaxAttempts:              stringOption,
+	OptSinkRetryBackoff:                  durationOption,
+	OptSinkRetryMaxBackoff:               durationOption,
+	OptSinkRetryOn:                       enum("all", "transient"),
+	OptDeadLetter:                        stringOption,
+	OptDeadLetterMaxMessages:             stringOption,
+	OptSinkThrottleConfig:                jsonOption,
+	OptAdditionalSinks:                   stringOption,
+	OptProbeSink:                         flagOption,
+	OptDistributed:                       flagOption,
+	OptEncryptionKMS:                     stringOption,
+	OptMessageChunkSize:                  stringOption,
+	OptMessageCompression:                enum(OptCompressionGzip, OptCompressionZstd).orEmptyMeans(OptCompressionGzip),
+	OptSchemaRegistryCompatibility:       enum("none", "backward", "backward_transitive", "forward", "forward_transitive", "full", "full_transitive"),
+	OptOnSchemaIncompatibility:           enum("fail", "pause", "coerce"),
+	OptSchemaRegistrySubjectNameStrategy: enum("topic_name", "record_name", "topic_record_name"),
+	OptSchemaRegistrySubjectTemplate:     stringOption,
+	OptDiffColumns:                       stringOption,
+	OptDeletePayload:                     enum("tombstone", "prior_row", "key_columns"),
+	OptAvroDecimalMode:                   enum("error", "string", "fixed"),
+	OptAvroDecimalPrecision:              stringOption,
+	OptCanonicalJSON:                     flagOption,
+	OptValueTemplate:                     stringOption,
+	OptKeyExpr:                           stringOption,
 }
 
 // CommonOptions is options common to all sinks
@@ -322,22 +912,71 @@ var CommonOptions = makeStringSet(OptCursor, OptEndTime, OptEnvelope,
 	OptSchemaChangeEvents, OptSchemaChangePolicy,
 	OptProtectDataFromGCOnPause, OptOnError,
 	OptInitialScan, OptNoInitialScan, OptInitialScanOnly,
-	OptMinCheckpointFrequency, OptMetricsScope, OptVirtualColumns, Topics)
+	OptMinCheckpointFrequency, OptMetricsScope, OptVirtualColumns, Topics,
+	OptJobRetention, OptExpirePTSAfter, OptSuppressDuplicatesWindow, OptResolvedOnly,
+	OptEmitTxnID, OptEmitSecurityLabel, OptDeadLetter, OptDeadLetterMaxMessages,
+	OptSinkThrottleConfig, OptAdditionalSinks, OptProbeSink, OptCloudEventsMode,
+	OptCSVDelimiter, OptCSVQuote, OptCSVNull, OptMetadataColumns,
+	OptFieldNameMapping, OptFieldNameCase, OptRowMetadataConfig,
+	OptMessageCompression, OptDiffColumns, OptDeletePayload, OptAvroDecimalMode, OptAvroDecimalPrecision,
+	OptCanonicalJSON, OptValueTemplate, OptKeyExpr)
 
 // SQLValidOptions is options exclusive to SQL sink
 var SQLValidOptions map[string]struct{} = nil
 
 // KafkaValidOptions is options exclusive to Kafka sink
-var KafkaValidOptions = makeStringSet(OptAvroSchemaPrefix, OptConfluentSchemaRegistry, OptKafkaSinkConfig)
+var KafkaValidOptions = makeStringSet(OptAvroSchemaPrefix, OptConfluentSchemaRegistry, OptKafkaSinkConfig,
+	OptPartitionExpr, OptKafkaMaxInFlight, OptKafkaStrictOrdering, OptKafkaUnordered, OptKafkaPartitioner,
+	OptKafkaHeaders,
+	OptCompression, OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff,
+	OptSchemaRegistryCompatibility, OptOnSchemaIncompatibility,
+	OptSchemaRegistrySubjectNameStrategy, OptSchemaRegistrySubjectTemplate, OptMessageChunkSize,
+	OptEncryptionKMS)
 
 // CloudStorageValidOptions is options exclusive to cloud storage sink
-var CloudStorageValidOptions = makeStringSet(OptCompression)
+var CloudStorageValidOptions = makeStringSet(OptCompression, OptCompactFiles, OptCSVHeader)
 
 // WebhookValidOptions is options exclusive to webhook sink
-var WebhookValidOptions = makeStringSet(OptWebhookAuthHeader, OptWebhookClientTimeout, OptWebhookSinkConfig)
+var WebhookValidOptions = makeStringSet(OptWebhookAuthHeader, OptWebhookClientTimeout, OptWebhookSinkConfig,
+	OptCompression, OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn,
+	OptCSVHeader)
+
+// KinesisValidOptions is options exclusive to kinesis sink
+var KinesisValidOptions = makeStringSet(OptKinesisSinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
+
+// NATSValidOptions is options exclusive to the NATS JetStream sink
+var NATSValidOptions = makeStringSet(OptNATSSinkConfig)
+
+// AMQPValidOptions is options exclusive to the AMQP sink
+var AMQPValidOptions = makeStringSet(OptAMQPSinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
+
+// GRPCValidOptions is options exclusive to the gRPC sink
+var GRPCValidOptions = makeStringSet(OptGRPCSinkConfig)
+
+// BigQueryValidOptions is options exclusive to the BigQuery sink
+var BigQueryValidOptions = makeStringSet(OptBigQuerySinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
+
+// SnowflakeValidOptions is options exclusive to the Snowflake sink
+var SnowflakeValidOptions = makeStringSet(OptSnowflakeSinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
+
+// ElasticsearchValidOptions is options exclusive to the Elasticsearch sink
+var ElasticsearchValidOptions = makeStringSet(OptElasticsearchSinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
+
+// ClickHouseValidOptions is options exclusive to the ClickHouse sink
+var ClickHouseValidOptions = makeStringSet(OptClickHouseSinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
+
+// SQSValidOptions is options exclusive to the SQS sink
+var SQSValidOptions = makeStringSet(OptSQSSinkConfig,
+	OptSinkRetryMaxAttempts, OptSinkRetryBackoff, OptSinkRetryMaxBackoff, OptSinkRetryOn)
 
 // PubsubValidOptions is options exclusive to pubsub sink
-var PubsubValidOptions = makeStringSet()
+var PubsubValidOptions = makeStringSet(OptPubsubAttributes, OptMessageChunkSize, OptEncryptionKMS)
 
 // ExternalConnectionValidOptions is options exclusive to the external
 // connection sink.
@@ -349,15 +988,42 @@ var ExternalConnectionValidOptions = makeStringSet(
 	// Options valid for a kafka sink.
 	OptAvroSchemaPrefix,
 	OptConfluentSchemaRegistry,
+	OptSchemaRegistryCompatibility,
+	OptOnSchemaIncompatibility,
+	OptSchemaRegistrySubjectNameStrategy,
+	OptSchemaRegistrySubjectTemplate,
 	OptKafkaSinkConfig,
+	OptPartitionExpr,
+	OptKafkaMaxInFlight,
+	OptKafkaStrictOrdering,
+	OptKafkaUnordered,
+	OptKafkaPartitioner,
+	OptKafkaHeaders,
+	OptMessageChunkSize,
+	OptEncryptionKMS,
+	// Options valid for a webhook sink.
+	OptWebhookAuthHeader,
+	OptWebhookClientTimeout,
+	OptWebhookSinkConfig,
+	OptSinkRetryOn,
+	OptCSVHeader,
+	// Options valid for both.
+	OptCompression,
+	OptSinkRetryMaxAttempts,
+	OptSinkRetryBackoff,
+	OptSinkRetryMaxBackoff,
 )
 
 // CaseInsensitiveOpts options which supports case Insensitive value
 var CaseInsensitiveOpts = makeStringSet(OptFormat, OptEnvelope, OptCompression, OptSchemaChangeEvents,
-	OptSchemaChangePolicy, OptOnError, OptInitialScan)
+	OptSchemaChangePolicy, OptOnError, OptInitialScan, OptKafkaPartitioner, OptSinkRetryOn,
+	OptCloudEventsMode, OptCSVQuote, OptFieldNameCase, OptMessageCompression,
+	OptSchemaRegistryCompatibility, OptOnSchemaIncompatibility, OptSchemaRegistrySubjectNameStrategy,
+	OptDeletePayload, OptAvroDecimalMode)
 
 // RedactedOptions are options whose values should be replaced with "redacted" in job descriptions and errors.
-var RedactedOptions = makeStringSet(OptWebhookAuthHeader, SinkParamClientKey)
+var RedactedOptions = makeStringSet(OptWebhookAuthHeader, SinkParamClientKey, OptDeadLetter,
+	OptAdditionalSinks, OptEncryptionKMS)
 
 // NoLongerExperimental aliases options prefixed with experimental that no longer need to be
 var NoLongerExperimental = map[string]string{
@@ -373,7 +1039,12 @@ var NoLongerExperimental = map[string]string{
 // InitialScanOnlyUnsupportedOptions is options that are not supported with the
 // initial scan only option
 var InitialScanOnlyUnsupportedOptions = makeStringSet(OptEndTime, OptResolvedTimestamps, OptDiff,
-	OptMVCCTimestamps, OptUpdatedTimestamps)
+	OptMVCCTimestamps, OptUpdatedTimestamps, OptProbeSink)
+
+// ResolvedOnlyUnsupportedOptions is options that are not supported with the
+// resolved only option, as they only affect the row events.
+var ResolvedOnlyUnsupportedOptions = makeStringSet(OptInitialScan, OptInitialScanOnly, OptDiff,
+	OptSuppressDuplicatesWindow)
 
 // AlterChangefeedUnsupportedOptions are changefeed options that we do not allow
 // users to alter.
@@ -382,7 +1053,7 @@ var InitialScanOnlyUnsupportedOptions = makeStringSet(OptEndTime, OptResolvedTim
 // allowed to alter either of these options. We need to support the alteration
 // of these fields.
 var AlterChangefeedUnsupportedOptions = makeStringSet(OptCursor, OptInitialScan,
-	OptNoInitialScan, OptInitialScanOnly, OptEndTime)
+	OptNoInitialScan, OptInitialScanOnly, OptEndTime, OptCompactFiles)
 
 // AlterChangefeedOptionExpectValues is used to parse alter changefeed options
 // using PlanHookState.TypeAsStringOpts().
@@ -575,8 +1246,8 @@ func (s StatementOptions) GetInitialScanType() (InitialScanType, error) {
 
 	// If we reach this point, this implies that the user did not specify any initial scan
 	// options. In this case the default behaviour is to perform an initial scan if the
-	// cursor is not specified.
-	if !s.HasStartCursor() {
+	// cursor is not specified, unless the changefeed does not emit rows.
+	if !s.HasStartCursor() && !s.IsResolvedOnly() {
 		return InitialScan, nil
 	}
 
@@ -617,10 +1288,213 @@ type EncodingOptions struct {
 	TopicInValue      bool
 	UpdatedTimestamps bool
 	MVCCTimestamps    bool
+	EmitTxnID         bool
 	Diff              bool
-	AvroSchemaPrefix  string
-	SchemaRegistryURI string
-	Compression       string
+	// SecurityLabelColumn is the name of the column whose value is emitted as
+	// the security label of the rows, if any.
+	SecurityLabelColumn string
+	AvroSchemaPrefix    string
+	SchemaRegistryURI   string
+	// SchemaRegistryCompatibility is the compatibility level of the subjects
+	// of the changefeed, if OptSchemaRegistryCompatibility was specified.
+	SchemaRegistryCompatibility string
+	// OnSchemaIncompatibility is the behavior of the changefeed when the
+	// schema registry rejects a schema as incompatible.
+	OnSchemaIncompatibility SchemaIncompatibilityPolicy
+	// SubjectNameStrategy and SubjectTemplate name the subjects of the
+	// schemas of the changefeed in the schema registry.
+	SubjectNameStrategy SubjectNameStrategy
+	SubjectTemplate     string
+	Compression         string
+	// CloudEventsMode is how the events of format=cloudevents are carried by
+	// the messages of the sink, if OptCloudEventsMode was specified.
+	CloudEventsMode CloudEventsMode
+	// CSV configures the encoding of the rows of format=csv.
+	CSV CSVOptions
+	// MetadataColumns are the metadata fields added to the values of the rows.
+	MetadataColumns MetadataColumns
+	// FieldNameMapping and FieldNameCase name the fields holding the columns
+	// of the rows (see GetFieldNamer).
+	FieldNameMapping string
+	FieldNameCase    FieldNameCase
+	// RowMetadata configures the metadata of the rows of envelope=row, if
+	// OptRowMetadataConfig was specified.
+	RowMetadata RowMetadataOptions
+	// MessageCompression is the codec compressing the values of the messages
+	// of the rows, if OptMessageCompression was specified.
+	MessageCompression string
+	// DiffColumns is the comma-separated list of the columns of the previous
+	// values of the rows, if OptDiffColumns was specified (see
+	// GetDiffColumns).
+	DiffColumns string
+	// DeletePayload is the value of the messages of deleted rows, if
+	// OptDeletePayload was specified.
+	DeletePayload DeletePayload
+	// AvroDecimalMode is how the DECIMAL columns of format=avro are encoded,
+	// if OptAvroDecimalMode was specified, with AvroDecimalPrecision and
+	// AvroDecimalScale for OptAvroDecimalFixed.
+	AvroDecimalMode      AvroDecimalMode
+	AvroDecimalPrecision int
+	AvroDecimalScale     int
+	// CanonicalJSON is set to write the JSON documents in a canonical form
+	// (see OptCanonicalJSON).
+	CanonicalJSON bool
+	// ValueTemplate is the template rendering the values of the rows of
+	// format=template.
+	ValueTemplate string
+}
+
+// GetDiffColumns returns the columns onto which the previous values of the rows
+// are projected, or nil if they hold all of their columns.
+func (e EncodingOptions) GetDiffColumns() []string {
+	if e.DiffColumns == `` {
+		return nil
+	}
+	return strings.Split(e.DiffColumns, `,`)
+}
+
+// RowMetadataConfig is the JSON configuration of OptRowMetadataConfig.
+type RowMetadataConfig struct {
+	// Fields are the fields of the metadata, among RowMetadataFieldUpdated,
+	// RowMetadataFieldMVCCTimestamp and RowMetadataFieldOperation. Without
+	// fields, the values only hold the columns of the rows.
+	Fields []string
+	// Location is where the fields are placed, nested by default.
+	Location RowMetadataLocation `json:",omitempty"`
+}
+
+// RowMetadataOptions is the parsed RowMetadataConfig, which, unlike it, can
+// be compared.
+type RowMetadataOptions struct {
+	// Configured is set if OptRowMetadataConfig was specified.
+	Configured                        bool
+	Updated, MVCCTimestamp, Operation bool
+	Location                          RowMetadataLocation
+}
+
+// parseRowMetadataConfig parses the value of OptRowMetadataConfig.
+func parseRowMetadataConfig(value string) (RowMetadataOptions, error) {
+	var config RowMetadataConfig
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return RowMetadataOptions{}, errors.Wrapf(err, "invalid %s", OptRowMetadataConfig)
+	}
+	o := RowMetadataOptions{Configured: true, Location: config.Location}
+	switch o.Location {
+	case ``:
+		o.Location = OptRowMetadataNested
+	case OptRowMetadataNested, OptRowMetadataTopLevel:
+	default:
+		return o, errors.Errorf("invalid %s: unknown location %q, %s", OptRowMetadataConfig,
+			config.Location, describeEnum(string(OptRowMetadataNested), string(OptRowMetadataTopLevel)))
+	}
+	for _, f := range config.Fields {
+		switch f {
+		case RowMetadataFieldUpdated:
+			o.Updated = true
+		case RowMetadataFieldMVCCTimestamp:
+			o.MVCCTimestamp = true
+		case RowMetadataFieldOperation:
+			o.Operation = true
+		default:
+			return o, errors.Errorf("invalid %s: unknown field %q, %s", OptRowMetadataConfig, f,
+				describeEnum(RowMetadataFieldUpdated, RowMetadataFieldMVCCTimestamp, RowMetadataFieldOperation))
+		}
+	}
+	return o, nil
+}
+
+// MetadataColumns is a set of metadata fields, held as a bit set so that
+// EncodingOptions can be compared.
+type MetadataColumns uint8
+
+// metadataColumns are the metadata fields, in the order in which they are
+// added to the values of the rows.
+var metadataColumns = []MetadataColumn{
+	OptMetadataColumnMVCCTimestamp,
+	OptMetadataColumnStatementTime,
+	OptMetadataColumnTableID,
+	OptMetadataColumnJobID,
+	OptMetadataColumnClusterID,
+	OptMetadataColumnNodeID,
+}
+
+// Empty returns true if the set holds no field.
+func (m MetadataColumns) Empty() bool {
+	return m == 0
+}
+
+// Columns returns the fields of the set, in the order in which they are added
+// to the values of the rows.
+func (m MetadataColumns) Columns() []MetadataColumn {
+	var cols []MetadataColumn
+	for i, c := range metadataColumns {
+		if m&(1<<i) != 0 {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// parseMetadataColumns parses the value of OptMetadataColumns.
+func parseMetadataColumns(value string) (MetadataColumns, error) {
+	var m MetadataColumns
+	for _, name := range strings.Split(value, `,`) {
+		name = strings.TrimSpace(name)
+		found := false
+		for i, c := range metadataColumns {
+			if MetadataColumn(name) == c {
+				m |= 1 << i
+				found = true
+				break
+			}
+		}
+		if !found {
+			names := make([]string, len(metadataColumns))
+			for i, c := range metadataColumns {
+				names[i] = string(c)
+			}
+			return 0, errors.Errorf(`unknown %s value %q: %s`,
+				OptMetadataColumns, name, describeEnum(names...))
+		}
+	}
+	return m, nil
+}
+
+// parseDiffColumns parses the value of OptDiffColumns, whose columns are
+// returned comma-separated without the surrounding spaces.
+func parseDiffColumns(value string) (string, error) {
+	names := strings.Split(value, `,`)
+	seen := make(map[string]struct{}, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == `` {
+			return ``, errors.Errorf(`%s requires a comma separated list of columns: %s='%s'`,
+				OptDiffColumns, OptDiffColumns, value)
+		}
+		if _, ok := seen[name]; ok {
+			return ``, errors.Errorf(`%s lists column %q more than once`, OptDiffColumns, name)
+		}
+		seen[name] = struct{}{}
+		names[i] = name
+	}
+	return strings.Join(names, `,`), nil
+}
+
+// CSVOptions configures the encoding of the rows of format=csv.
+type CSVOptions struct {
+	// Delimiter separates the fields of the rows.
+	Delimiter rune
+	Quote     CSVQuoteMode
+	// Null represents the NULL values.
+	Null string
+	// Header is set when the sinks start their files or batches with a header
+	// row.
+	Header bool
+	// DeletedColumn is set when the rows end with a _crdb_deleted column,
+	// telling the deleted rows, which only hold their primary key, from the
+	// others. The changefeeds which only scan their tables do not emit deleted
+	// rows.
+	DeletedColumn bool
 }
 
 // GetEncodingOptions populates and validates an EncodingOptions.
@@ -661,24 +1535,335 @@ func (s StatementOptions) GetEncodingOptions() (EncodingOptions, error) {
 	_, o.TopicInValue = s.m[OptTopicInValue]
 	_, o.UpdatedTimestamps = s.m[OptUpdatedTimestamps]
 	_, o.MVCCTimestamps = s.m[OptMVCCTimestamps]
+	_, o.EmitTxnID = s.m[OptEmitTxnID]
 	_, o.Diff = s.m[OptDiff]
 
 	o.SchemaRegistryURI = s.m[OptConfluentSchemaRegistry]
 	o.AvroSchemaPrefix = s.m[OptAvroSchemaPrefix]
 	o.Compression = s.m[OptCompression]
+	o.SecurityLabelColumn = s.m[OptEmitSecurityLabel]
+	if o.SchemaRegistryCompatibility, err = s.getEnumValue(OptSchemaRegistryCompatibility); err != nil {
+		return o, err
+	}
+	if o.OnSchemaIncompatibility, err = s.GetOnSchemaIncompatibility(); err != nil {
+		return o, err
+	}
+	strategy, err := s.getEnumValue(OptSchemaRegistrySubjectNameStrategy)
+	if err != nil {
+		return o, err
+	}
+	o.SubjectNameStrategy = SubjectNameStrategy(strategy)
+	if template, ok := s.m[OptSchemaRegistrySubjectTemplate]; ok {
+		if err := validateSubjectTemplate(template); err != nil {
+			return o, err
+		}
+		o.SubjectTemplate = template
+	}
+	mode, err := s.getEnumValue(OptCloudEventsMode)
+	if err != nil {
+		return o, err
+	}
+	o.CloudEventsMode = CloudEventsMode(mode)
+	if o.CSV, err = s.getCSVOptions(o.Format); err != nil {
+		return o, err
+	}
+	if cols, ok := s.m[OptMetadataColumns]; ok {
+		if o.MetadataColumns, err = parseMetadataColumns(cols); err != nil {
+			return o, err
+		}
+	}
+	if mapping, ok := s.m[OptFieldNameMapping]; ok {
+		if _, err := parseFieldNameMapping(mapping); err != nil {
+			return o, err
+		}
+		o.FieldNameMapping = mapping
+	}
+	nameCase, err := s.getEnumValue(OptFieldNameCase)
+	if err != nil {
+		return o, err
+	}
+	o.FieldNameCase = FieldNameCase(nameCase)
+	if o.MessageCompression, err = s.getEnumValue(OptMessageCompression); err != nil {
+		return o, err
+	}
+	if config, ok := s.m[OptRowMetadataConfig]; ok {
+		if o.RowMetadata, err = parseRowMetadataConfig(config); err != nil {
+			return o, err
+		}
+	}
+	if cols, ok := s.m[OptDiffColumns]; ok {
+		if o.DiffColumns, err = parseDiffColumns(cols); err != nil {
+			return o, err
+		}
+	}
+	deletePayload, err := s.getEnumValue(OptDeletePayload)
+	if err != nil {
+		return o, err
+	}
+	o.DeletePayload = DeletePayload(deletePayload)
+	_, o.CanonicalJSON = s.m[OptCanonicalJSON]
+	o.ValueTemplate = s.m[OptValueTemplate]
+	decimalMode, err := s.getEnumValue(OptAvroDecimalMode)
+	if err != nil {
+		return o, err
+	}
+	o.AvroDecimalMode = AvroDecimalMode(decimalMode)
+	if v, ok := s.m[OptAvroDecimalPrecision]; ok {
+		if o.AvroDecimalPrecision, o.AvroDecimalScale, err = parseAvroDecimalPrecision(v); err != nil {
+			return o, err
+		}
+	}
 
 	s.cache.EncodingOptions = o
 	return o, o.Validate()
 }
 
+// getCSVOptions returns the CSVOptions of the format, which are only
+// specified for format=csv.
+func (s StatementOptions) getCSVOptions(format FormatType) (CSVOptions, error) {
+	if format != OptFormatCSV {
+		for _, opt := range []string{OptCSVDelimiter, OptCSVQuote, OptCSVNull, OptCSVHeader} {
+			if _, ok := s.m[opt]; ok {
+				return CSVOptions{}, errors.Errorf(`%s is only usable with %s=%s`,
+					opt, OptFormat, OptFormatCSV)
+			}
+		}
+		return CSVOptions{}, nil
+	}
+	o := CSVOptions{Delimiter: ',', Quote: OptCSVQuoteMinimal, Null: `NULL`}
+	if delimiter, ok := s.m[OptCSVDelimiter]; ok {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size == 0 || size != len(delimiter) || r == utf8.RuneError ||
+			r == '"' || r == '\r' || r == '\n' {
+			return o, errors.Errorf(`%s must be a single character other than a quote or a newline, found %q`,
+				OptCSVDelimiter, delimiter)
+		}
+		o.Delimiter = r
+	}
+	quote, err := s.getEnumValue(OptCSVQuote)
+	if err != nil {
+		return o, err
+	}
+	if quote != `` {
+		o.Quote = CSVQuoteMode(quote)
+	}
+	if null, ok := s.m[OptCSVNull]; ok {
+		o.Null = null
+	}
+	_, o.Header = s.m[OptCSVHeader]
+	scanType, err := s.GetInitialScanType()
+	if err != nil {
+		return o, err
+	}
+	o.DeletedColumn = scanType != OnlyInitialScan
+	return o, nil
+}
+
 // Validate checks for incompatible encoding options.
 func (e EncodingOptions) Validate() error {
-	if e.Envelope == OptEnvelopeRow && e.Format == OptFormatAvro {
+	if e.Envelope == OptEnvelopeRow && (e.Format == OptFormatAvro || e.Format == OptFormatProtobuf) {
 		return errors.Errorf(`%s=%s is not supported with %s=%s`,
-			OptEnvelope, OptEnvelopeRow, OptFormat, OptFormatAvro,
+			OptEnvelope, OptEnvelopeRow, OptFormat, e.Format,
 		)
 	}
-	if e.Envelope != OptEnvelopeWrapped && e.Format != OptFormatJSON {
+	if e.Format == OptFormatParquet && e.Envelope != OptEnvelopeWrapped {
+		// The rows are converted to parquet from their wrapped JSON encoding.
+		return errors.Errorf(`%s=%s is not supported with %s=%s`,
+			OptEnvelope, e.Envelope, OptFormat, OptFormatParquet)
+	}
+	if e.Format == OptFormatCloudEvents {
+		// The data of the events is the JSON encoding of the rows, which must
+		// have a value for every row.
+		if e.Envelope != OptEnvelopeWrapped && e.Envelope != OptEnvelopeRow {
+			return errors.Errorf(`%s=%s is not supported with %s=%s`,
+				OptEnvelope, e.Envelope, OptFormat, OptFormatCloudEvents)
+		}
+	} else if e.CloudEventsMode != `` {
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptCloudEventsMode, OptFormat, OptFormatCloudEvents)
+	}
+	if e.EmitTxnID && e.Format != OptFormatJSON {
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptEmitTxnID, OptFormat, OptFormatJSON)
+	}
+	if e.SecurityLabelColumn != `` {
+		if e.Format != OptFormatJSON {
+			return errors.Errorf(`%s is only usable with %s=%s`,
+				OptEmitSecurityLabel, OptFormat, OptFormatJSON)
+		}
+		if e.Envelope == OptEnvelopeKeyOnly {
+			return errors.Errorf(`%s is not supported with %s=%s`,
+				OptEmitSecurityLabel, OptEnvelope, OptEnvelopeKeyOnly)
+		}
+	}
+	if !e.MetadataColumns.Empty() {
+		switch e.Format {
+		case OptFormatJSON, OptFormatAvro, OptFormatCSV, OptFormatCloudEvents, OptFormatMsgpack, OptFormatXML:
+		default:
+			return errors.Errorf(`%s is not supported with %s=%s`,
+				OptMetadataColumns, OptFormat, e.Format)
+		}
+		if e.Envelope == OptEnvelopeKeyOnly {
+			return errors.Errorf(`%s is not supported with %s=%s`,
+				OptMetadataColumns, OptEnvelope, OptEnvelopeKeyOnly)
+		}
+	}
+	if e.Format == OptFormatTemplate {
+		if e.ValueTemplate == `` {
+			return errors.Errorf(`%s=%s requires %s`, OptFormat, OptFormatTemplate, OptValueTemplate)
+		}
+		// The template has access to the previous values and the timestamps
+		// of the rows regardless of the envelope, which only adds its fields
+		// to the JSON encodings of the rows.
+		if e.Envelope != OptEnvelopeWrapped {
+			return errors.Errorf(`%s=%s is not supported with %s=%s`,
+				OptEnvelope, e.Envelope, OptFormat, OptFormatTemplate)
+		}
+	} else if e.ValueTemplate != `` {
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptValueTemplate, OptFormat, OptFormatTemplate)
+	}
+	if e.CanonicalJSON && e.Format != OptFormatJSON {
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptCanonicalJSON, OptFormat, OptFormatJSON)
+	}
+	if e.AvroDecimalMode != `` && e.Format != OptFormatAvro {
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptAvroDecimalMode, OptFormat, OptFormatAvro)
+	}
+	if (e.AvroDecimalMode == OptAvroDecimalFixed) != (e.AvroDecimalPrecision != 0) {
+		if e.AvroDecimalMode == OptAvroDecimalFixed {
+			return errors.Errorf(`%s=%s requires %s`,
+				OptAvroDecimalMode, OptAvroDecimalFixed, OptAvroDecimalPrecision)
+		}
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptAvroDecimalPrecision, OptAvroDecimalMode, OptAvroDecimalFixed)
+	}
+	if e.SchemaRegistryURI == `` {
+		if e.SchemaRegistryCompatibility != `` {
+			return errors.Errorf(`%s is only usable with %s`,
+				OptSchemaRegistryCompatibility, OptConfluentSchemaRegistry)
+		}
+		if e.OnSchemaIncompatibility != `` && e.OnSchemaIncompatibility != OptSchemaIncompatibilityFail {
+			return errors.Errorf(`%s is only usable with %s`,
+				OptOnSchemaIncompatibility, OptConfluentSchemaRegistry)
+		}
+		if e.SubjectNameStrategy != `` || e.SubjectTemplate != `` {
+			opt := OptSchemaRegistrySubjectNameStrategy
+			if e.SubjectTemplate != `` {
+				opt = OptSchemaRegistrySubjectTemplate
+			}
+			return errors.Errorf(`%s is only usable with %s`, opt, OptConfluentSchemaRegistry)
+		}
+	}
+	if e.SubjectNameStrategy != `` && e.SubjectTemplate != `` {
+		return errors.Errorf(`%s cannot be used with %s`,
+			OptSchemaRegistrySubjectNameStrategy, OptSchemaRegistrySubjectTemplate)
+	}
+	if e.Format == OptFormatProtobuf &&
+		(e.SubjectNameStrategy == OptSubjectNameRecord || e.SubjectNameStrategy == OptSubjectNameTopicRecord) {
+		// The messages of the keys and of the values are both named after the
+		// table, so their schemas would be registered under the same subject.
+		return errors.Errorf(`%s=%s is not supported with %s=%s`,
+			OptSchemaRegistrySubjectNameStrategy, e.SubjectNameStrategy, OptFormat, OptFormatProtobuf)
+	}
+	if e.DiffColumns != `` {
+		if !e.Diff && e.Envelope != OptEnvelopeDebezium && e.Envelope != OptEnvelopeEnriched {
+			return errors.Errorf(`%s is only usable with %s`, OptDiffColumns, OptDiff)
+		}
+		switch e.Format {
+		case OptFormatJSON, OptFormatCloudEvents, OptFormatMsgpack, OptFormatXML:
+		default:
+			return errors.Errorf(`%s is not supported with %s=%s`,
+				OptDiffColumns, OptFormat, e.Format)
+		}
+	}
+	if e.DeletePayload != `` {
+		switch e.Format {
+		case OptFormatJSON, OptFormatMsgpack, OptFormatXML:
+		default:
+			return errors.Errorf(`%s is not supported with %s=%s`,
+				OptDeletePayload, OptFormat, e.Format)
+		}
+		switch e.DeletePayload {
+		case OptDeletePayloadTombstone:
+			if e.Envelope == OptEnvelopeKeyOnly {
+				return errors.Errorf(`%s is not supported with %s=%s`,
+					OptDeletePayload, OptEnvelope, OptEnvelopeKeyOnly)
+			}
+		default:
+			if e.Envelope != OptEnvelopeRow {
+				return errors.Errorf(`%s=%s is only usable with %s=%s`,
+					OptDeletePayload, e.DeletePayload, OptEnvelope, OptEnvelopeRow)
+			}
+			if e.DeletePayload == OptDeletePayloadPriorRow && !e.Diff {
+				return errors.Errorf(`%s=%s is only usable with %s`,
+					OptDeletePayload, e.DeletePayload, OptDiff)
+			}
+		}
+	}
+	if e.MessageCompression != `` && e.Format != OptFormatJSON {
+		return errors.Errorf(`%s is only usable with %s=%s`,
+			OptMessageCompression, OptFormat, OptFormatJSON)
+	}
+	if e.RowMetadata.Configured {
+		if e.Format != OptFormatJSON || e.Envelope != OptEnvelopeRow {
+			return errors.Errorf(`%s is only usable with %s=%s and %s=%s`,
+				OptRowMetadataConfig, OptFormat, OptFormatJSON, OptEnvelope, OptEnvelopeRow)
+		}
+		// The fields of the metadata are those of the configuration.
+		for _, opt := range []struct {
+			k string
+			b bool
+		}{
+			{OptUpdatedTimestamps, e.UpdatedTimestamps},
+			{OptMVCCTimestamps, e.MVCCTimestamps},
+		} {
+			if opt.b {
+				return errors.Errorf(`%s is not supported with %s, whose fields may include it`,
+					opt.k, OptRowMetadataConfig)
+			}
+		}
+	}
+	if !e.GetFieldNamer().IsIdentity() {
+		switch e.Format {
+		case OptFormatJSON, OptFormatAvro, OptFormatCloudEvents, OptFormatMsgpack, OptFormatXML:
+		default:
+			opt := OptFieldNameCase
+			if e.FieldNameMapping != `` {
+				opt = OptFieldNameMapping
+			}
+			return errors.Errorf(`%s is not supported with %s=%s`, opt, OptFormat, e.Format)
+		}
+	}
+	if e.Envelope == OptEnvelopeDebezium || e.Envelope == OptEnvelopeEnriched {
+		if e.Format != OptFormatJSON && (e.Format != OptFormatAvro || e.Envelope == OptEnvelopeEnriched) {
+			return errors.Errorf(`%s=%s is not supported with %s=%s`,
+				OptEnvelope, e.Envelope, OptFormat, e.Format)
+		}
+		// The debezium and enriched envelopes have fixed fields, which include
+		// the previous values of the rows and their timestamps.
+		unsupported := []struct {
+			k string
+			b bool
+		}{
+			{OptKeyInValue, e.KeyInValue},
+			{OptTopicInValue, e.TopicInValue},
+			{OptUpdatedTimestamps, e.UpdatedTimestamps},
+			{OptMVCCTimestamps, e.MVCCTimestamps},
+			{OptEmitTxnID, e.EmitTxnID},
+			{OptEmitSecurityLabel, e.SecurityLabelColumn != ``},
+		}
+		for _, v := range unsupported {
+			if v.b {
+				return errors.Errorf(`%s is not supported with %s=%s`,
+					v.k, OptEnvelope, e.Envelope)
+			}
+		}
+		return nil
+	}
+	if e.Envelope != OptEnvelopeWrapped && e.Format != OptFormatJSON &&
+		e.Format != OptFormatCloudEvents && e.Format != OptFormatMsgpack && e.Format != OptFormatXML {
 		requiresWrap := []struct {
 			k string
 			b bool
@@ -687,6 +1872,7 @@ func (e EncodingOptions) Validate() error {
 			{OptTopicInValue, e.TopicInValue},
 			{OptUpdatedTimestamps, e.UpdatedTimestamps},
 			{OptMVCCTimestamps, e.MVCCTimestamps},
+			{OptEmitTxnID, e.EmitTxnID},
 			{OptDiff, e.Diff},
 		}
 		for _, v := range requiresWrap {
@@ -742,6 +1928,24 @@ type Filters struct {
 // GetFilters returns a populated Filters.
 func (s StatementOptions) GetFilters() Filters {
 	_, withDiff := s.m[OptDiff]
+	// The deleted rows are keyed by the key expression over their previous
+	// values, since only their primary key columns are known otherwise.
+	if _, ok := s.m[OptKeyExpr]; ok {
+		withDiff = true
+	}
+	// Likewise, the security label of the deleted rows is the value of the
+	// label column in their previous values.
+	if _, ok := s.m[OptEmitSecurityLabel]; ok {
+		withDiff = true
+	}
+	// The debezium and enriched envelopes hold the previous values of the rows,
+	// which also tell inserts apart from updates.
+	if envelope, err := s.getEnumValue(OptEnvelope); err == nil {
+		switch EnvelopeType(envelope) {
+		case OptEnvelopeDebezium, OptEnvelopeEnriched:
+			withDiff = true
+		}
+	}
 	return Filters{
 		WithDiff: withDiff,
 	}
@@ -755,6 +1959,7 @@ type WebhookSinkOptions struct {
 	JSONConfig    SinkSpecificJSONConfig
 	AuthHeader    string
 	ClientTimeout *time.Duration
+	Retry         SinkRetryOptions
 }
 
 // GetWebhookSinkOptions includes arbitrary json to be interpreted
@@ -766,27 +1971,360 @@ func (s StatementOptions) GetWebhookSinkOptions() (WebhookSinkOptions, error) {
 		return o, err
 	}
 	o.ClientTimeout = timeout
+	o.Retry, err = s.GetSinkRetryOptions()
+	return o, err
+}
+
+// SinkRetryOptions override the retry policy of the requests of the sinks
+// (see OptSinkRetryMaxAttempts and OptSinkRetryOn). The zero value leaves the
+// retry policy of the sink as configured.
+type SinkRetryOptions struct {
+	// MaxAttempts is the maximum number of attempts of a request, or 0 if not
+	// set.
+	MaxAttempts int
+	// Backoff and MaxBackoff are the initial and maximum backoff between the
+	// attempts of a request, or nil if not set.
+	Backoff, MaxBackoff *time.Duration
+	// TransientOnly only retries the transient errors of the requests.
+	TransientOnly bool
+}
+
+// GetSinkRetryOptions returns the options which override the retry policy of
+// the sink.
+func (s StatementOptions) GetSinkRetryOptions() (SinkRetryOptions, error) {
+	var o SinkRetryOptions
+	if v, ok := s.m[OptSinkRetryMaxAttempts]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return o, errors.Errorf("option %s must be a positive integer: %s='%s'",
+				OptSinkRetryMaxAttempts, OptSinkRetryMaxAttempts, v)
+		}
+		o.MaxAttempts = n
+	}
+	var err error
+	if o.Backoff, err = s.getDurationValue(OptSinkRetryBackoff); err != nil {
+		return o, err
+	}
+	if o.MaxBackoff, err = s.getDurationValue(OptSinkRetryMaxBackoff); err != nil {
+		return o, err
+	}
+	if o.Backoff != nil && o.MaxBackoff != nil && *o.MaxBackoff < *o.Backoff {
+		return o, errors.Errorf("option %s must not be less than %s", OptSinkRetryMaxBackoff, OptSinkRetryBackoff)
+	}
+	retryOn, err := s.getEnumValue(OptSinkRetryOn)
+	if err != nil {
+		return o, err
+	}
+	o.TransientOnly = SinkRetryOnType(retryOn) == OptSinkRetryOnTransient
 	return o, nil
 }
 
-// GetKafkaConfigJSON returns arbitrary json to be interpreted
+// KinesisSinkOptions are passed in WITH args but
+// are specific to the kinesis sink.
+type KinesisSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetKinesisSinkOptions includes arbitrary json to be interpreted
+// by the kinesis sink.
+func (s StatementOptions) GetKinesisSinkOptions() (KinesisSinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return KinesisSinkOptions{JSONConfig: s.getJSONValue(OptKinesisSinkConfig), Retry: retry}, err
+}
+
+// NATSSinkOptions are passed in WITH args but
+// are specific to the NATS JetStream sink.
+type NATSSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+}
+
+// GetNATSSinkOptions includes arbitrary json to be interpreted
+// by the NATS JetStream sink.
+func (s StatementOptions) GetNATSSinkOptions() NATSSinkOptions {
+	return NATSSinkOptions{JSONConfig: s.getJSONValue(OptNATSSinkConfig)}
+}
+
+// AMQPSinkOptions are passed in WITH args but
+// are specific to the AMQP sink.
+type AMQPSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetAMQPSinkOptions includes arbitrary json to be interpreted
+// by the AMQP sink.
+func (s StatementOptions) GetAMQPSinkOptions() (AMQPSinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return AMQPSinkOptions{JSONConfig: s.getJSONValue(OptAMQPSinkConfig), Retry: retry}, err
+}
+
+// GRPCSinkOptions are passed in WITH args but
+// are specific to the gRPC sink.
+type GRPCSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+}
+
+// GetGRPCSinkOptions includes arbitrary json to be interpreted
+// by the gRPC sink.
+func (s StatementOptions) GetGRPCSinkOptions() GRPCSinkOptions {
+	return GRPCSinkOptions{JSONConfig: s.getJSONValue(OptGRPCSinkConfig)}
+}
+
+// BigQuerySinkOptions are passed in WITH args but
+// are specific to the BigQuery sink.
+type BigQuerySinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetBigQuerySinkOptions includes arbitrary json to be interpreted
+// by the BigQuery sink.
+func (s StatementOptions) GetBigQuerySinkOptions() (BigQuerySinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return BigQuerySinkOptions{JSONConfig: s.getJSONValue(OptBigQuerySinkConfig), Retry: retry}, err
+}
+
+// SnowflakeSinkOptions are passed in WITH args but
+// are specific to the Snowflake sink.
+type SnowflakeSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetSnowflakeSinkOptions includes arbitrary json to be interpreted
+// by the Snowflake sink.
+func (s StatementOptions) GetSnowflakeSinkOptions() (SnowflakeSinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return SnowflakeSinkOptions{JSONConfig: s.getJSONValue(OptSnowflakeSinkConfig), Retry: retry}, err
+}
+
+// ElasticsearchSinkOptions are passed in WITH args but
+// are specific to the Elasticsearch sink.
+type ElasticsearchSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetElasticsearchSinkOptions includes arbitrary json to be interpreted
+// by the Elasticsearch sink.
+func (s StatementOptions) GetElasticsearchSinkOptions() (ElasticsearchSinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return ElasticsearchSinkOptions{JSONConfig: s.getJSONValue(OptElasticsearchSinkConfig), Retry: retry}, err
+}
+
+// ClickHouseSinkOptions are passed in WITH args but
+// are specific to the ClickHouse sink.
+type ClickHouseSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetClickHouseSinkOptions includes arbitrary json to be interpreted
+// by the ClickHouse sink.
+func (s StatementOptions) GetClickHouseSinkOptions() (ClickHouseSinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return ClickHouseSinkOptions{JSONConfig: s.getJSONValue(OptClickHouseSinkConfig), Retry: retry}, err
+}
+
+// SQSSinkOptions are passed in WITH args but
+// are specific to the SQS sink.
+type SQSSinkOptions struct {
+	JSONConfig SinkSpecificJSONConfig
+	Retry      SinkRetryOptions
+}
+
+// GetSQSSinkOptions includes arbitrary json to be interpreted
+// by the SQS sink.
+func (s StatementOptions) GetSQSSinkOptions() (SQSSinkOptions, error) {
+	retry, err := s.GetSinkRetryOptions()
+	return SQSSinkOptions{JSONConfig: s.getJSONValue(OptSQSSinkConfig), Retry: retry}, err
+}
+
+// KafkaSinkOptions are passed in WITH args but
+// are specific to the kafka sink.
+// MaxInFlight is 0 if not set, in which case the
+// producer's default is used. Partitioner is empty if
+// not set, in which case the partitioner configured in
+// JSONConfig, if any, or the hash partitioner is used.
+type KafkaSinkOptions struct {
+	JSONConfig     SinkSpecificJSONConfig
+	MaxInFlight    int
+	StrictOrdering bool
+	// Unordered waives the ordering of the messages for a key (see
+	// OptKafkaUnordered).
+	Unordered   bool
+	Partitioner KafkaPartitionerType
+	// Headers are the headers attached to the messages of the rows (see
+	// OptKafkaHeaders).
+	Headers []string
+	// Compression is the codec of the batches of messages (see
+	// OptCompression), if any.
+	Compression string
+	// Retry overrides the retries of the producer. The kafka sink does not
+	// support OptSinkRetryOn, since the producer only retries the errors which
+	// kafka reports as retriable.
+	Retry SinkRetryOptions
+}
+
+// GetKafkaSinkOptions includes arbitrary json to be interpreted
 // by the kafka sink.
-func (s StatementOptions) GetKafkaConfigJSON() SinkSpecificJSONConfig {
-	return s.getJSONValue(OptKafkaSinkConfig)
+func (s StatementOptions) GetKafkaSinkOptions() (KafkaSinkOptions, error) {
+	o := KafkaSinkOptions{JSONConfig: s.getJSONValue(OptKafkaSinkConfig)}
+	_, o.StrictOrdering = s.m[OptKafkaStrictOrdering]
+	_, o.Unordered = s.m[OptKafkaUnordered]
+	partitioner, err := s.getEnumValue(OptKafkaPartitioner)
+	if err != nil {
+		return o, err
+	}
+	o.Partitioner = KafkaPartitionerType(partitioner)
+	o.Compression = s.m[OptCompression]
+	if v, ok := s.m[OptKafkaMaxInFlight]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return o, errors.Errorf("option %s must be a positive integer: %s='%s'",
+				OptKafkaMaxInFlight, OptKafkaMaxInFlight, v)
+		}
+		o.MaxInFlight = n
+	}
+	if o.Headers, err = s.GetKafkaHeaders(); err != nil {
+		return o, err
+	}
+	o.Retry, err = s.GetSinkRetryOptions()
+	return o, err
+}
+
+// The metadata headers which may be requested with OptKafkaHeaders or
+// OptPubsubAttributes, rather than the columns of the targets.
+const (
+	// MessageHeaderOperation holds the operation which produced the row:
+	// insert, update or delete. Since inserts cannot be told apart from updates
+	// unless the previous values of the rows are known (see OptDiff), it holds
+	// upsert rather than insert or update otherwise.
+	MessageHeaderOperation = `cdc_op`
+	// MessageHeaderMVCCTimestamp holds the MVCC timestamp of the row, which is
+	// the commit timestamp of the transaction that wrote it.
+	MessageHeaderMVCCTimestamp = `cdc_mvcc_timestamp`
+	// MessageHeaderTable holds the name of the table of the row.
+	MessageHeaderTable = `cdc_table`
+)
+
+// The headers attached to the chunks of the messages split by
+// OptMessageChunkSize.
+const (
+	// MessageChunkIDHeader identifies the message of the chunk among the
+	// messages of its key. It holds the MVCC timestamp of the row, so that the
+	// chunks of a row emitted again, such as after a retry, are identical to
+	// the chunks emitted before.
+	MessageChunkIDHeader = `cdc_chunk_id`
+	// MessageChunkIndexHeader holds the position of the chunk in the value of
+	// the message, starting at 0.
+	MessageChunkIndexHeader = `cdc_chunk_index`
+	// MessageChunkCountHeader holds the number of chunks of the message.
+	MessageChunkCountHeader = `cdc_chunk_count`
+)
+
+// IsMetadataHeader returns whether the header is one of the metadata headers,
+// rather than a column of the targets.
+func IsMetadataHeader(header string) bool {
+	switch header {
+	case MessageHeaderOperation, MessageHeaderMVCCTimestamp, MessageHeaderTable:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetKafkaHeaders returns the names of the headers attached to the kafka
+// messages of the rows, or nil if none have been requested.
+func (s StatementOptions) GetKafkaHeaders() ([]string, error) {
+	return s.getHeaders(OptKafkaHeaders)
+}
+
+// GetPubsubAttributes returns the names of the attributes attached to the
+// pubsub messages of the rows, or nil if none have been requested.
+func (s StatementOptions) GetPubsubAttributes() ([]string, error) {
+	return s.getHeaders(OptPubsubAttributes)
+}
+
+// GetMessageHeaders returns the option requesting the headers attached to the
+// messages of the rows, either OptKafkaHeaders or OptPubsubAttributes, along
+// with the names of the headers, or nil if none have been requested.
+func (s StatementOptions) GetMessageHeaders() (string, []string, error) {
+	for _, opt := range []string{OptKafkaHeaders, OptPubsubAttributes} {
+		if _, ok := s.m[opt]; ok {
+			headers, err := s.getHeaders(opt)
+			return opt, headers, err
+		}
+	}
+	return ``, nil, nil
+}
+
+func (s StatementOptions) getHeaders(opt string) ([]string, error) {
+	v, ok := s.m[opt]
+	if !ok {
+		return nil, nil
+	}
+	var headers []string
+	seen := make(map[string]struct{})
+	for _, h := range strings.Split(v, `,`) {
+		h = strings.TrimSpace(h)
+		if h == `` {
+			return nil, errors.Errorf("option %s must be a comma-separated list of headers: %s='%s'",
+				opt, opt, v)
+		}
+		if _, ok := seen[h]; ok {
+			return nil, errors.Errorf("option %s lists header %q more than once", opt, h)
+		}
+		seen[h] = struct{}{}
+		headers = append(headers, h)
+	}
+	return headers, nil
+}
+
+// GetPartitionExpr returns the expression used to compute the partition each
+// row is emitted to, or false if none has been provided.
+func (s StatementOptions) GetPartitionExpr() (string, bool) {
+	v, ok := s.m[OptPartitionExpr]
+	return v, ok
+}
+
+// GetKeyExpr returns the key expression, or false if none has been provided.
+func (s StatementOptions) GetKeyExpr() (string, bool) {
+	v, ok := s.m[OptKeyExpr]
+	return v, ok
+}
+
+// GetSecurityLabelColumn returns the name of the column whose value is emitted
+// as the security label of the rows, or false if none has been provided.
+func (s StatementOptions) GetSecurityLabelColumn() (string, bool) {
+	v, ok := s.m[OptEmitSecurityLabel]
+	return v, ok
 }
 
 // GetResolvedTimestampInterval gets the best-effort interval at which resolved timestamps
 // should be emitted. Nil or 0 means emit as often as possible. False means do not emit at all.
 // Returns an error for negative or invalid duration value.
+// If resolved_only is specified, resolved timestamps are always emitted.
 func (s StatementOptions) GetResolvedTimestampInterval() (*time.Duration, bool, error) {
 	str, ok := s.m[OptResolvedTimestamps]
 	if ok && str == OptEmitAllResolvedTimestamps {
 		return nil, true, nil
 	}
+	if !ok && s.IsResolvedOnly() {
+		return nil, true, nil
+	}
 	d, err := s.getDurationValue(OptResolvedTimestamps)
 	return d, d != nil, err
 }
 
+// IsResolvedOnly returns true if the changefeed should only emit resolved
+// timestamps, and no rows.
+func (s StatementOptions) IsResolvedOnly() bool {
+	_, ok := s.m[OptResolvedOnly]
+	return ok
+}
+
 // GetMetricScope returns a namespace for metrics affected by this changefeed, or
 // false if none has been provided.
 func (s StatementOptions) GetMetricScope() (string, bool) {
@@ -805,6 +2343,142 @@ func (s StatementOptions) GetMinCheckpointFrequency() (*time.Duration, error) {
 	return s.getDurationValue(OptMinCheckpointFrequency)
 }
 
+// GetJobRetention returns the duration for which the record of the changefeed
+// job is retained once it succeeds or fails. Returns nil if not set, in which
+// case the jobs.retention_time cluster setting applies.
+func (s StatementOptions) GetJobRetention() (*time.Duration, error) {
+	return s.getDurationValue(OptJobRetention)
+}
+
+// GetSuppressDuplicatesWindow returns the window within which duplicate rows
+// for a key are suppressed, or nil if duplicates should not be suppressed.
+func (s StatementOptions) GetSuppressDuplicatesWindow() (*time.Duration, error) {
+	return s.getDurationValue(OptSuppressDuplicatesWindow)
+}
+
+// GetPTSExpiration returns the duration after which the protected timestamp
+// record of a paused changefeed expires, at which point the changefeed fails.
+// Returns nil if not set, in which case the record never expires.
+func (s StatementOptions) GetPTSExpiration() (*time.Duration, error) {
+	return s.getDurationValue(OptExpirePTSAfter)
+}
+
+// GetCompactFiles returns the target size of the files merged by the
+// compaction job of the changefeed, and whether the changefeed has one.
+func (s StatementOptions) GetCompactFiles() (string, bool) {
+	v, ok := s.m[OptCompactFiles]
+	if ok && v == `` {
+		v = ChangefeedOptionExpectValues[OptCompactFiles].IfEmpty
+	}
+	return v, ok
+}
+
+// DefaultDeadLetterMaxMessages is the number of rows which each aggregator
+// routes to the dead letter sink if OptDeadLetterMaxMessages is not set.
+const DefaultDeadLetterMaxMessages = 1000
+
+// DeadLetterOptions configure the dead letter sink of a changefeed (see
+// OptDeadLetter).
+type DeadLetterOptions struct {
+	URI         string
+	MaxMessages int64
+}
+
+// GetDeadLetterOptions returns the dead letter sink of the changefeed, or nil
+// if it has none.
+func (s StatementOptions) GetDeadLetterOptions() (*DeadLetterOptions, error) {
+	uri, ok := s.m[OptDeadLetter]
+	if !ok {
+		if _, ok := s.m[OptDeadLetterMaxMessages]; ok {
+			return nil, errors.Errorf("option %s requires option %s", OptDeadLetterMaxMessages, OptDeadLetter)
+		}
+		return nil, nil
+	}
+	if uri == `` {
+		return nil, errors.Errorf("option %s requires the URI of a sink", OptDeadLetter)
+	}
+	o := &DeadLetterOptions{URI: uri, MaxMessages: DefaultDeadLetterMaxMessages}
+	if v, ok := s.m[OptDeadLetterMaxMessages]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errors.Errorf("option %s must be a positive integer: %s='%s'",
+				OptDeadLetterMaxMessages, OptDeadLetterMaxMessages, v)
+		}
+		o.MaxMessages = n
+	}
+	return o, nil
+}
+
+// GetEncryptionKMS returns the URI of the KMS wrapping the data keys which
+// encrypt the values of the messages, or false if they are not encrypted.
+func (s StatementOptions) GetEncryptionKMS() (string, bool, error) {
+	uri, ok := s.m[OptEncryptionKMS]
+	if !ok {
+		return ``, false, nil
+	}
+	if uri == `` {
+		return ``, false, errors.Errorf("option %s requires the URI of a KMS", OptEncryptionKMS)
+	}
+	// The dead letter sink receives the rows in the clear.
+	if _, ok := s.m[OptDeadLetter]; ok {
+		return ``, false, errors.Errorf("%s cannot be used with %s", OptEncryptionKMS, OptDeadLetter)
+	}
+	return uri, true, nil
+}
+
+// GetMessageChunkSize returns the size beyond which the values of the messages
+// are split into chunks, or false if they are not split.
+func (s StatementOptions) GetMessageChunkSize() (int64, bool, error) {
+	v, ok := s.m[OptMessageChunkSize]
+	if !ok {
+		return 0, false, nil
+	}
+	size, err := humanizeutil.ParseBytes(v)
+	if err != nil || size <= 0 {
+		return 0, false, errors.Errorf("option %s must be a positive size: %s='%s'",
+			OptMessageChunkSize, OptMessageChunkSize, v)
+	}
+	return size, true, nil
+}
+
+// GetSinkThrottleConfig returns the throttling configuration of the messages
+// emitted to the sink specified by the changefeed, or nil if the changefeed
+// uses the configuration of the cluster setting.
+func (s StatementOptions) GetSinkThrottleConfig() (*SinkThrottleConfig, error) {
+	v, ok := s.m[OptSinkThrottleConfig]
+	if !ok {
+		return nil, nil
+	}
+	config := &SinkThrottleConfig{}
+	if err := json.Unmarshal([]byte(v), config); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", OptSinkThrottleConfig)
+	}
+	if config.MessageRate < 0 || config.MessageBurst < 0 || config.ByteRate < 0 ||
+		config.ByteBurst < 0 || config.FlushRate < 0 || config.FlushBurst < 0 {
+		return nil, errors.Errorf("invalid %s: rates and bursts must not be negative", OptSinkThrottleConfig)
+	}
+	return config, nil
+}
+
+// GetAdditionalSinks returns the URIs of the sinks to which the changefeed
+// emits in addition to its sink, if any.
+func (s StatementOptions) GetAdditionalSinks() ([]string, error) {
+	v, ok := s.m[OptAdditionalSinks]
+	if !ok {
+		return nil, nil
+	}
+	var uris []string
+	for _, uri := range strings.Split(v, ",") {
+		uri = strings.TrimSpace(uri)
+		if uri == `` {
+			return nil, errors.Errorf("option %s requires a comma separated list of sink URIs: %s='%s'",
+				OptAdditionalSinks, OptAdditionalSinks, v)
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
 // ForceKeyInValue sets the encoding option KeyInValue to true and then validates the
 // resoluting encoding options.
 func (s StatementOptions) ForceKeyInValue() error {
@@ -837,6 +2511,58 @@ func (s StatementOptions) SetDefaultEnvelope(t EnvelopeType) {
 	}
 }
 
+// subjectTemplatePlaceholders are the placeholders of
+// OptSchemaRegistrySubjectTemplate.
+var subjectTemplatePlaceholders = []string{`{topic}`, `{record}`, `{type}`}
+
+// parseAvroDecimalPrecision parses the precision and scale of
+// OptAvroDecimalPrecision, as in DECIMAL(precision, scale).
+func parseAvroDecimalPrecision(value string) (precision int, scale int, _ error) {
+	parts := strings.Split(value, `,`)
+	if len(parts) == 2 {
+		var err error
+		precision, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err == nil {
+			scale, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+		if err == nil && precision > 0 && scale >= 0 && scale <= precision {
+			return precision, scale, nil
+		}
+	}
+	return 0, 0, errors.Errorf(
+		`%s must be a positive precision and a scale between 0 and the precision, `+
+			`such as '38,9', found %q`, OptAvroDecimalPrecision, value)
+}
+
+// validateSubjectTemplate checks that the template of the subjects only holds
+// known placeholders, and at least one of them, so that the changefeed does not
+// register the schemas of all of its tables under a single subject.
+func validateSubjectTemplate(template string) error {
+	rest := template
+	for _, p := range subjectTemplatePlaceholders {
+		rest = strings.ReplaceAll(rest, p, ``)
+	}
+	if rest == template {
+		return errors.Errorf(`%s must hold at least one of %s, found %q`,
+			OptSchemaRegistrySubjectTemplate, strings.Join(subjectTemplatePlaceholders, `, `), template)
+	}
+	if strings.ContainsAny(rest, `{}/`) {
+		return errors.Errorf(`%s may only hold the placeholders %s and no slash, found %q`,
+			OptSchemaRegistrySubjectTemplate, strings.Join(subjectTemplatePlaceholders, `, `), template)
+	}
+	return nil
+}
+
+// GetOnSchemaIncompatibility validates and returns the desired behavior when
+// the schema registry rejects a schema as incompatible.
+func (s StatementOptions) GetOnSchemaIncompatibility() (SchemaIncompatibilityPolicy, error) {
+	v, err := s.getEnumValue(OptOnSchemaIncompatibility)
+	if err != nil || v == `` {
+		return OptSchemaIncompatibilityFail, err
+	}
+	return SchemaIncompatibilityPolicy(v), nil
+}
+
 // GetOnError validates and returns the desired behavior when a non-retriable error is encountered.
 func (s StatementOptions) GetOnError() (OnErrorType, error) {
 	v, err := s.getEnumValue(OptOnError)
@@ -875,6 +2601,13 @@ func (s StatementOptions) ValidateForCreateChangefeed() error {
 	if err != nil {
 		return err
 	}
+	if s.IsResolvedOnly() {
+		for o := range ResolvedOnlyUnsupportedOptions {
+			if _, ok := s.m[o]; ok {
+				return errors.Newf(`cannot specify both %s and %s`, OptResolvedOnly, o)
+			}
+		}
+	}
 	scanType, err := s.GetInitialScanType()
 	if err != nil {
 		return err
@@ -885,10 +2618,6 @@ func (s StatementOptions) ValidateForCreateChangefeed() error {
 				return errors.Newf(`cannot specify both %s and %s`, OptInitialScanOnly, o)
 			}
 		}
-	} else {
-		if s.m[OptFormat] == string(OptFormatCSV) {
-			return errors.Newf(`%s=%s is only usable with %s`, OptFormat, OptFormatCSV, OptInitialScanOnly)
-		}
 	}
 	return nil
 }
@@ -40,3 +40,511 @@ func TestOptionsValidations(t *testing.T) {
 	}
 
 }
+
+func TestDebeziumEnvelopeOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The debezium envelope holds the previous values of the rows.
+	o := MakeStatementOptions(map[string]string{"envelope": "Debezium"})
+	require.True(t, o.GetFilters().WithDiff)
+	e, err := o.GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptEnvelopeDebezium, e.Envelope)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"envelope": "debezium", "format": "protobuf"}, "envelope=debezium is not supported with format=protobuf"},
+		{map[string]string{"envelope": "debezium", "updated": ""}, "updated is not supported with envelope=debezium"},
+		{map[string]string{"envelope": "debezium", "format": "avro", "key_in_value": ""}, "key_in_value is not supported with envelope=debezium"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestCloudEventsOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	o := MakeStatementOptions(map[string]string{"format": "CloudEvents", "cloudevents_mode": "Binary"})
+	e, err := o.GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptFormatCloudEvents, e.Format)
+	require.Equal(t, OptCloudEventsModeBinary, e.CloudEventsMode)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"format": "cloudevents", "envelope": "key_only"}, "envelope=key_only is not supported with format=cloudevents"},
+		{map[string]string{"format": "json", "cloudevents_mode": "structured"}, "cloudevents_mode is only usable with format=cloudevents"},
+		{map[string]string{"format": "cloudevents", "cloudevents_mode": "batch"}, "unknown cloudevents_mode: batch, valid values are 'structured' and 'binary'"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestCSVOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{"format": "csv", "initial_scan_only": ""}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, CSVOptions{Delimiter: ',', Quote: OptCSVQuoteMinimal, Null: `NULL`}, e.CSV)
+
+	e, err = MakeStatementOptions(map[string]string{
+		"format": "csv", "csv_delimiter": "|", "csv_quote": "All", "csv_null": "", "csv_header": "",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, CSVOptions{
+		Delimiter: '|', Quote: OptCSVQuoteAll, Null: ``, Header: true, DeletedColumn: true,
+	}, e.CSV)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"format": "json", "csv_header": ""}, "csv_header is only usable with format=csv"},
+		{map[string]string{"format": "csv", "csv_delimiter": ";;"}, `csv_delimiter must be a single character other than a quote or a newline, found ";;"`},
+		{map[string]string{"format": "csv", "csv_delimiter": `"`}, `csv_delimiter must be a single character other than a quote or a newline, found "\""`},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestEnrichedEnvelopeOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The enriched envelope holds the previous values of the rows.
+	o := MakeStatementOptions(map[string]string{"envelope": "enriched"})
+	require.True(t, o.GetFilters().WithDiff)
+	e, err := o.GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptEnvelopeEnriched, e.Envelope)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"envelope": "enriched", "format": "avro"}, "envelope=enriched is not supported with format=avro"},
+		{map[string]string{"envelope": "enriched", "mvcc_timestamp": ""}, "mvcc_timestamp is not supported with envelope=enriched"},
+		{map[string]string{"envelope": "enriched", "key_in_value": ""}, "key_in_value is not supported with envelope=enriched"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestMetadataColumnsOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The columns are ordered regardless of the order in which they are
+	// specified.
+	e, err := MakeStatementOptions(map[string]string{
+		"metadata_columns": "job_id, mvcc_timestamp",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, []MetadataColumn{OptMetadataColumnMVCCTimestamp, OptMetadataColumnJobID},
+		e.MetadataColumns.Columns())
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"metadata_columns": "job_id,txn_id"}, `unknown metadata_columns value "txn_id": ` +
+			`valid values are 'mvcc_timestamp', 'statement_time', 'table_id', 'job_id', 'cluster_id',  and 'node_id'`},
+		{map[string]string{"metadata_columns": "job_id", "format": "protobuf"}, "metadata_columns is not supported with format=protobuf"},
+		{map[string]string{"metadata_columns": "job_id", "envelope": "key_only"}, "metadata_columns is not supported with envelope=key_only"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestFieldNamer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{
+		"field_name_case":    "Camel",
+		"field_name_mapping": "id:ID, created_at:createdOn",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	namer := e.GetFieldNamer()
+	for column, expected := range map[string]string{
+		"id":            "ID",
+		"created_at":    "createdOn",
+		"updated_at":    "updatedAt",
+		"_last__userID": "lastUserID",
+		"Name":          "name",
+		"__":            "__",
+	} {
+		require.Equal(t, expected, namer.Name(column), column)
+	}
+
+	e, err = MakeStatementOptions(map[string]string{"field_name_case": "pascal"}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, "UpdatedAt", e.GetFieldNamer().Name("updated_at"))
+	require.True(t, EncodingOptions{}.GetFieldNamer().IsIdentity())
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"field_name_mapping": "id:ID,name"}, `field_name_mapping must be a comma-separated list of column:field pairs, found "name"`},
+		{map[string]string{"field_name_mapping": "id:ID,id:key"}, `field_name_mapping maps column "id" more than once`},
+		{map[string]string{"field_name_case": "camel", "format": "csv"}, "field_name_case is not supported with format=csv"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestRowMetadataConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{
+		"envelope":            "row",
+		"row_metadata_config": `{"Fields": ["operation", "updated"], "Location": "top_level"}`,
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, RowMetadataOptions{
+		Configured: true, Updated: true, Operation: true, Location: OptRowMetadataTopLevel,
+	}, e.RowMetadata)
+
+	// The metadata is nested by default.
+	e, err = MakeStatementOptions(map[string]string{
+		"envelope": "row", "row_metadata_config": `{"Fields": []}`,
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, RowMetadataOptions{Configured: true, Location: OptRowMetadataNested}, e.RowMetadata)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"envelope": "row", "row_metadata_config": `{"Fields": ["resolved"]}`},
+			`invalid row_metadata_config: unknown field "resolved", valid values are 'updated', 'mvcc_timestamp',  and 'operation'`},
+		{map[string]string{"envelope": "row", "row_metadata_config": `{"Location": "bottom"}`},
+			`invalid row_metadata_config: unknown location "bottom", valid values are 'nested' and 'top_level'`},
+		{map[string]string{"row_metadata_config": `{}`},
+			"row_metadata_config is only usable with format=json and envelope=row"},
+		{map[string]string{"envelope": "row", "updated": "", "row_metadata_config": `{}`},
+			"updated is not supported with row_metadata_config, whose fields may include it"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestEncryptionKMS(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	uri, ok, err := MakeStatementOptions(map[string]string{}).GetEncryptionKMS()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, "", uri)
+
+	uri, ok, err = MakeStatementOptions(map[string]string{
+		"encryption_kms": "aws:///key?REGION=us-east-1",
+	}).GetEncryptionKMS()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "aws:///key?REGION=us-east-1", uri)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"encryption_kms": ""}, "option encryption_kms requires the URI of a KMS"},
+		{map[string]string{"encryption_kms": "aws:///key", "dead_letter": "kafka://dlq"},
+			"encryption_kms cannot be used with dead_letter"},
+	} {
+		_, _, err := MakeStatementOptions(test.input).GetEncryptionKMS()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestMessageCompressionOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The codec defaults to gzip.
+	e, err := MakeStatementOptions(map[string]string{"message_compression": ""}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptCompressionGzip, e.MessageCompression)
+	e, err = MakeStatementOptions(map[string]string{"message_compression": "ZSTD"}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptCompressionZstd, e.MessageCompression)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"message_compression": "snappy"}, "unknown message_compression: snappy, valid values are 'gzip' and 'zstd'"},
+		{map[string]string{"message_compression": "gzip", "format": "avro"}, "message_compression is only usable with format=json"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestSchemaRegistryCompatibilityOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// Without on_schema_incompatibility, incompatible schemas fail the
+	// changefeed.
+	e, err := MakeStatementOptions(map[string]string{
+		"format": "avro", "confluent_schema_registry": "http://localhost",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, ``, e.SchemaRegistryCompatibility)
+	require.Equal(t, OptSchemaIncompatibilityFail, e.OnSchemaIncompatibility)
+
+	e, err = MakeStatementOptions(map[string]string{
+		"format": "avro", "confluent_schema_registry": "http://localhost",
+		"schema_registry_compatibility": "FULL_TRANSITIVE", "on_schema_incompatibility": "Coerce",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, `full_transitive`, e.SchemaRegistryCompatibility)
+	require.Equal(t, OptSchemaIncompatibilityCoerce, e.OnSchemaIncompatibility)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"schema_registry_compatibility": "backward"},
+			"schema_registry_compatibility is only usable with confluent_schema_registry"},
+		{map[string]string{"on_schema_incompatibility": "pause"},
+			"on_schema_incompatibility is only usable with confluent_schema_registry"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestSchemaRegistrySubjectOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{
+		"format": "avro", "confluent_schema_registry": "http://localhost",
+		"schema_registry_subject_name_strategy": "Record_Name",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptSubjectNameRecord, e.SubjectNameStrategy)
+
+	e, err = MakeStatementOptions(map[string]string{
+		"format": "protobuf", "confluent_schema_registry": "http://localhost",
+		"schema_registry_subject_template": "cdc.{topic}-{type}",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, `cdc.{topic}-{type}`, e.SubjectTemplate)
+
+	registry := map[string]string{"format": "avro", "confluent_schema_registry": "http://localhost"}
+	with := func(kvs ...string) map[string]string {
+		m := make(map[string]string)
+		for k, v := range registry {
+			m[k] = v
+		}
+		for i := 0; i < len(kvs); i += 2 {
+			m[kvs[i]] = kvs[i+1]
+		}
+		return m
+	}
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"schema_registry_subject_name_strategy": "record_name"},
+			"schema_registry_subject_name_strategy is only usable with confluent_schema_registry"},
+		{with("schema_registry_subject_name_strategy", "record_name", "schema_registry_subject_template", "{topic}"),
+			"schema_registry_subject_name_strategy cannot be used with schema_registry_subject_template"},
+		{with("format", "protobuf", "schema_registry_subject_name_strategy", "topic_record_name"),
+			"schema_registry_subject_name_strategy=topic_record_name is not supported with format=protobuf"},
+		{with("schema_registry_subject_template", "cdc"),
+			`schema_registry_subject_template must hold at least one of {topic}, {record}, {type}, found "cdc"`},
+		{with("schema_registry_subject_template", "cdc/{topic}"),
+			`schema_registry_subject_template may only hold the placeholders {topic}, {record}, {type} and no slash, found "cdc/{topic}"`},
+		{with("schema_registry_subject_template", "{table}-{type}"),
+			`schema_registry_subject_template may only hold the placeholders {topic}, {record}, {type} and no slash, found "{table}-{type}"`},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestDiffColumnsOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{
+		"diff": "", "diff_columns": " b, c ",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c"}, e.GetDiffColumns())
+
+	e, err = MakeStatementOptions(map[string]string{
+		"envelope": "debezium", "diff_columns": "b",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, e.GetDiffColumns())
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"diff_columns": "b"},
+			"diff_columns is only usable with diff"},
+		{map[string]string{"diff": "", "diff_columns": "b,,c"},
+			"diff_columns requires a comma separated list of columns: diff_columns='b,,c'"},
+		{map[string]string{"diff": "", "diff_columns": "b, b"},
+			`diff_columns lists column "b" more than once`},
+		{map[string]string{"diff": "", "diff_columns": "b", "format": "avro", "confluent_schema_registry": "http://localhost"},
+			"diff_columns is not supported with format=avro"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestMessageChunkSizeOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	_, ok, err := MakeStatementOptions(map[string]string{}).GetMessageChunkSize()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	size, ok, err := MakeStatementOptions(map[string]string{"message_chunk_size": "900KiB"}).GetMessageChunkSize()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(900<<10), size)
+
+	for _, v := range []string{"", "0", "-1", "big"} {
+		_, _, err := MakeStatementOptions(map[string]string{"message_chunk_size": v}).GetMessageChunkSize()
+		require.EqualError(t, err, "option message_chunk_size must be a positive size: message_chunk_size='"+v+"'")
+	}
+}
+
+func TestDeletePayloadOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{
+		"envelope": "row", "diff": "", "delete_payload": "prior_row",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptDeletePayloadPriorRow, e.DeletePayload)
+
+	e, err = MakeStatementOptions(map[string]string{"delete_payload": "Tombstone"}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptDeletePayloadTombstone, e.DeletePayload)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"delete_payload": "key_columns"},
+			"delete_payload=key_columns is only usable with envelope=row"},
+		{map[string]string{"envelope": "row", "delete_payload": "prior_row"},
+			"delete_payload=prior_row is only usable with diff"},
+		{map[string]string{"envelope": "key_only", "delete_payload": "tombstone"},
+			"delete_payload is not supported with envelope=key_only"},
+		{map[string]string{"format": "avro", "confluent_schema_registry": "http://localhost", "delete_payload": "tombstone"},
+			"delete_payload is not supported with format=avro"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestAvroDecimalOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	avro := func(kvs ...string) map[string]string {
+		m := map[string]string{"format": "avro", "confluent_schema_registry": "http://localhost"}
+		for i := 0; i < len(kvs); i += 2 {
+			m[kvs[i]] = kvs[i+1]
+		}
+		return m
+	}
+	e, err := MakeStatementOptions(avro(
+		"avro_decimal_mode", "Fixed", "avro_decimal_precision", "38, 9",
+	)).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptAvroDecimalFixed, e.AvroDecimalMode)
+	require.Equal(t, 38, e.AvroDecimalPrecision)
+	require.Equal(t, 9, e.AvroDecimalScale)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"avro_decimal_mode": "string"},
+			"avro_decimal_mode is only usable with format=avro"},
+		{avro("avro_decimal_mode", "fixed"),
+			"avro_decimal_mode=fixed requires avro_decimal_precision"},
+		{avro("avro_decimal_mode", "string", "avro_decimal_precision", "38,9"),
+			"avro_decimal_precision is only usable with avro_decimal_mode=fixed"},
+		{avro("avro_decimal_mode", "fixed", "avro_decimal_precision", "9,38"),
+			`avro_decimal_precision must be a positive precision and a scale between 0 and the precision, such as '38,9', found "9,38"`},
+		{avro("avro_decimal_mode", "fixed", "avro_decimal_precision", "38"),
+			`avro_decimal_precision must be a positive precision and a scale between 0 and the precision, such as '38,9', found "38"`},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
+
+func TestCanonicalJSONOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{"canonical_json": ""}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.True(t, e.CanonicalJSON)
+
+	_, err = MakeStatementOptions(map[string]string{
+		"format": "msgpack", "canonical_json": "",
+	}).GetEncodingOptions()
+	require.EqualError(t, err, "canonical_json is only usable with format=json")
+}
+
+func TestValueTemplateOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	e, err := MakeStatementOptions(map[string]string{
+		"format": "template", "value_template": "{{.Op}}",
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	require.Equal(t, OptFormatTemplate, e.Format)
+	require.Equal(t, "{{.Op}}", e.ValueTemplate)
+
+	for _, test := range []struct {
+		input map[string]string
+		err   string
+	}{
+		{map[string]string{"format": "template"},
+			"format=template requires value_template"},
+		{map[string]string{"value_template": "{{.Op}}"},
+			"value_template is only usable with format=template"},
+		{map[string]string{"format": "template", "value_template": "{{.Op}}", "envelope": "row"},
+			"envelope=row is not supported with format=template"},
+	} {
+		_, err := MakeStatementOptions(test.input).GetEncodingOptions()
+		require.EqualError(t, err, test.err)
+	}
+}
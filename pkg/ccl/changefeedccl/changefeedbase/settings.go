@@ -50,6 +50,67 @@ var PerChangefeedMemLimit = settings.RegisterByteSizeSetting(
 	1<<27, // 128MiB
 )
 
+// MaxEventSize is the maximum amount of memory which can be required to
+// process a single event. The events which require more memory than the per
+// changefeed limit, but not more than this maximum, are buffered alone, with
+// the memory in excess of the limit reserved separately.
+var MaxEventSize = settings.RegisterByteSizeSetting(
+	settings.TenantWritable,
+	"changefeed.memory.max_event_size",
+	"the maximum amount of memory required to process a single event; the events which "+
+		"require more memory than changefeed.memory.per_changefeed_limit are processed alone, "+
+		"and the changefeed fails if an event requires more memory than this maximum",
+	1<<29, // 512MiB
+)
+
+// KafkaMaxInflightBytes is the default limit of the size of the messages which
+// a kafka sink emitted but the brokers did not acknowledge yet, past which the
+// sink waits for acknowledgements before accepting more messages.
+var KafkaMaxInflightBytes = settings.RegisterByteSizeSetting(
+	settings.TenantWritable,
+	"changefeed.kafka.max_inflight_bytes",
+	"the size of the messages a kafka sink may have emitted without them being acknowledged "+
+		"by the brokers, past which the changefeed waits for acknowledgements, e.g. while the "+
+		"brokers throttle it; overridden by the MaxInflight.Bytes of kafka_sink_config; 0 disables the limit",
+	1<<26, // 64MiB
+	settings.NonNegativeInt,
+)
+
+// KafkaV2SinkEnabled makes the changefeeds emitting to kafka:// sinks use the
+// kafka-v2 sink, which batches the messages of each partition itself rather
+// than through the sarama producers, unless their configuration is only
+// supported by the kafka sink.
+var KafkaV2SinkEnabled = settings.RegisterBoolSetting(
+	settings.TenantWritable,
+	"changefeed.kafka_v2_sink.enabled",
+	"if true, changefeeds emitting to kafka:// sinks use the kafka-v2 sink, "+
+		"unless their kafka_sink_config or options are not supported by it",
+	false,
+)
+
+// SinkConnectionPoolMaxSinks is the number of sinks which may share a
+// connection to the same endpoint with the same configuration.
+var SinkConnectionPoolMaxSinks = settings.RegisterIntSetting(
+	settings.TenantWritable,
+	"changefeed.sink_connection_pool.max_sinks_per_connection",
+	"the number of sinks of the changefeeds running on a node which may share a kafka client, "+
+		"or a webhook HTTP client, connecting to the same endpoint with the same configuration; "+
+		"0 disables the sharing",
+	32,
+	settings.NonNegativeInt,
+)
+
+// SinkConnectionPoolMaxIdleConnsPerHost is the number of idle connections
+// which a shared webhook HTTP client keeps open to its endpoint.
+var SinkConnectionPoolMaxIdleConnsPerHost = settings.RegisterIntSetting(
+	settings.TenantWritable,
+	"changefeed.sink_connection_pool.max_idle_conns_per_host",
+	"the number of idle connections which a webhook HTTP client shared by sinks keeps open "+
+		"to its endpoint for their next requests",
+	16,
+	settings.NonNegativeInt,
+)
+
 // SlowSpanLogThreshold controls when we will log slow spans.
 var SlowSpanLogThreshold = settings.RegisterDurationSetting(
 	settings.TenantWritable,
@@ -158,6 +219,22 @@ var NodeSinkThrottleConfig = func() *settings.StringSetting {
 	return s
 }()
 
+// AggregatorSinkThrottleConfig is the throttling configuration of the messages
+// emitted to the sink by the aggregators of each changefeed on each node.
+var AggregatorSinkThrottleConfig = func() *settings.StringSetting {
+	s := settings.RegisterValidatedStringSetting(
+		settings.TenantWritable,
+		"changefeed.aggregator_sink_throttle_config",
+		"specifies the throttling configuration of the messages emitted to the sink "+
+			"by the aggregators of each changefeed on each node, unless the changefeed "+
+			"specifies the "+OptSinkThrottleConfig+" option",
+		"",
+		validateSinkThrottleConfig,
+	)
+	s.SetVisibility(settings.Public)
+	return s
+}()
+
 func validateSinkThrottleConfig(values *settings.Values, configStr string) error {
 	if configStr == "" {
 		return nil
@@ -197,6 +274,28 @@ var EventMemoryMultiplier = settings.RegisterFloatSetting(
 	},
 )
 
+// SuppressDuplicatesMemoryLimit bounds the memory used by each change
+// aggregator to track the keys whose duplicate rows are suppressed.
+var SuppressDuplicatesMemoryLimit = settings.RegisterByteSizeSetting(
+	settings.TenantWritable,
+	"changefeed.suppress_duplicates.memory_limit",
+	"the amount of memory each change aggregator may use to track keys for suppress_duplicates_window",
+	16<<20, // 16MiB
+)
+
+// ParquetMaxBatchSize is the size of the rows buffered in a parquet file by
+// the streaming sinks emitting format=parquet, past which the file is emitted
+// before the next flush of the sink.
+var ParquetMaxBatchSize = settings.RegisterByteSizeSetting(
+	settings.TenantWritable,
+	"changefeed.parquet.max_batch_size",
+	"the size of the rows buffered in a parquet file by the kafka and webhook sinks with "+
+		"format=parquet, past which the file is emitted early; the files must fit in the "+
+		"messages or requests accepted by the endpoint",
+	8<<20, // 8MiB
+	settings.PositiveInt,
+)
+
 // ProtectTimestampInterval controls the frequency of protected timestamp record updates
 var ProtectTimestampInterval = settings.RegisterDurationSetting(
 	settings.TenantWritable,
@@ -230,3 +329,34 @@ var UseMuxRangeFeed = settings.RegisterBoolSetting(
 	"if true, changefeed uses multiplexing rangefeed RPC",
 	false,
 )
+
+// DefaultSinkCompression is the compression codec of the payloads of the sinks
+// of the changefeeds which do not specify the compression option. It is only
+// used by the sinks which support the codec (see OptCompression).
+var DefaultSinkCompression = settings.RegisterEnumSetting(
+	settings.TenantWritable,
+	"changefeed.sink_compression.default",
+	"the compression codec of the payloads of changefeeds which do not specify the compression option; "+
+		"sinks which do not support the codec leave their payloads uncompressed",
+	"none",
+	map[int64]string{
+		0: "none",
+		1: OptCompressionGzip,
+		2: OptCompressionZstd,
+		3: OptCompressionLZ4,
+		4: OptCompressionSnappy,
+	},
+)
+
+// SinkTLSReloadInterval is the minimum interval between the checks for new
+// certificates of the sinks whose certificates are read from files or from
+// an external connection.
+var SinkTLSReloadInterval = settings.RegisterDurationSetting(
+	settings.TenantWritable,
+	"changefeed.sink_tls_reload_interval",
+	"the minimum interval between the checks for new CA and client certificates of the sinks "+
+		"whose certificates are read from files or from an external connection; "+
+		"the new certificates are used by the connections the sinks open afterwards",
+	time.Minute,
+	settings.NonNegativeDuration,
+).WithPublic()
@@ -0,0 +1,113 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/errors"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/klauspost/compress/zstd"
+)
+
+// payloadCompressionCodecs are the codecs of changefeedbase.OptCompression
+// with which the webhook and cloud storage sinks compress their payloads (see
+// newCompressionWriter).
+var payloadCompressionCodecs = []string{
+	changefeedbase.OptCompressionGzip,
+	changefeedbase.OptCompressionZstd,
+}
+
+// sinkCompression returns the compression codec of a sink which supports the
+// specified codecs: the codec of the compression option of the changefeed if
+// set, or else the default codec of the cluster if the sink supports it. The
+// sinks reject the codecs of the option which they do not support.
+func sinkCompression(sv *settings.Values, codec string, supported []string) string {
+	if codec != `` {
+		return codec
+	}
+	defaultCodec := changefeedbase.DefaultSinkCompression.String(sv)
+	for _, c := range supported {
+		if c == defaultCodec {
+			return c
+		}
+	}
+	return ``
+}
+
+// newCompressionWriter returns a writer which compresses what is written to it
+// into w with the specified codec, one of payloadCompressionCodecs. The
+// compressed stream is complete once the writer is closed.
+func newCompressionWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case changefeedbase.OptCompressionGzip:
+		return gzip.NewWriter(w), nil
+	case changefeedbase.OptCompressionZstd:
+		// The payloads are compressed by the goroutines emitting them, so the
+		// encoder does not need its own.
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, errors.Errorf(`unsupported compression codec %q`, codec)
+	}
+}
+
+// compressMessage compresses the JSON value of a message with the codec of
+// changefeedbase.OptMessageCompression into a JSON string, which holds the
+// codec and the base64 encoding of the compressed value.
+func compressMessage(codec string, value []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	w, err := newCompressionWriter(codec, &compressed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	prefix := changefeedbase.MessageCompressionPrefix + codec + `:`
+	// Neither the prefix nor base64 need to be escaped in a JSON string.
+	out := make([]byte, 0, len(prefix)+base64.StdEncoding.EncodedLen(compressed.Len())+2)
+	out = append(out, '"')
+	out = append(out, prefix...)
+	out = out[:len(out)+base64.StdEncoding.EncodedLen(compressed.Len())]
+	base64.StdEncoding.Encode(out[len(prefix)+1:], compressed.Bytes())
+	out = append(out, '"')
+	return out, nil
+}
+
+func init() {
+	// The parquet library only compresses with gzip and snappy out of the box.
+	goparquet.RegisterBlockCompressor(parquet.CompressionCodec_ZSTD, zstdBlockCompressor{})
+}
+
+// zstdBlockCompressor compresses the pages of parquet files with zstd.
+type zstdBlockCompressor struct{}
+
+var (
+	zstdBlockEncoder, _ = zstd.NewWriter(nil)
+	zstdBlockDecoder, _ = zstd.NewReader(nil)
+)
+
+// CompressBlock is part of the goparquet.BlockCompressor interface.
+func (zstdBlockCompressor) CompressBlock(block []byte) ([]byte, error) {
+	return zstdBlockEncoder.EncodeAll(block, nil), nil
+}
+
+// DecompressBlock is part of the goparquet.BlockCompressor interface.
+func (zstdBlockCompressor) DecompressBlock(block []byte) ([]byte, error) {
+	return zstdBlockDecoder.DecodeAll(block, nil)
+}
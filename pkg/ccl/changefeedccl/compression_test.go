@@ -0,0 +1,73 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	gojson "encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+// decompressMessage decodes a value compressed by compressMessage, as the
+// consumers of the messages do (see changefeedbase.OptMessageCompression).
+func decompressMessage(t *testing.T, value []byte) []byte {
+	var s string
+	require.NoError(t, gojson.Unmarshal(value, &s))
+	require.True(t, strings.HasPrefix(s, changefeedbase.MessageCompressionPrefix), s)
+	s = strings.TrimPrefix(s, changefeedbase.MessageCompressionPrefix)
+	colon := strings.IndexByte(s, ':')
+	require.True(t, colon > 0, s)
+	compressed, err := base64.StdEncoding.DecodeString(s[colon+1:])
+	require.NoError(t, err)
+	var r io.Reader
+	switch codec := s[:colon]; codec {
+	case changefeedbase.OptCompressionGzip:
+		gr, err := gzip.NewReader(strings.NewReader(string(compressed)))
+		require.NoError(t, err)
+		r = gr
+	case changefeedbase.OptCompressionZstd:
+		zr, err := zstd.NewReader(strings.NewReader(string(compressed)))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	default:
+		t.Fatalf("unexpected codec %q", codec)
+	}
+	decompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return decompressed
+}
+
+func TestCompressMessage(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	value := []byte(`{"after": {"a": 1, "b": "` + strings.Repeat("x", 1000) + `"}}`)
+	for _, codec := range payloadCompressionCodecs {
+		t.Run(codec, func(t *testing.T) {
+			compressed, err := compressMessage(codec, value)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(string(compressed), `"crdb-`+codec+`:`), string(compressed))
+			require.Less(t, len(compressed), len(value))
+			require.Equal(t, string(value), string(decompressMessage(t, compressed)))
+		})
+	}
+
+	_, err := compressMessage(changefeedbase.OptCompressionSnappy, value)
+	require.EqualError(t, err, `unsupported compression codec "snappy"`)
+}
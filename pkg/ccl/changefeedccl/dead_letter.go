@@ -0,0 +1,224 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// errUndeliverableRow marks the errors of encoding or delivering a row which
+// persist however many times the row is retried, such as the rejection of its
+// schema by the schema registry or of its message by the sink because of its
+// size. The rows which fail with such errors are routed to the dead letter
+// sink of the changefeed, if it has one (see changefeedbase.OptDeadLetter).
+var errUndeliverableRow = errors.New("undeliverable row")
+
+// markUndeliverableRow marks the error of a row as persistent.
+func markUndeliverableRow(err error) error {
+	return errors.Mark(err, errUndeliverableRow)
+}
+
+// isUndeliverableRowError returns whether the error of encoding a row is
+// persistent. The errors which are not marked as retryable fail the
+// changefeed, so they are persistent as well, except for those which pause it.
+func isUndeliverableRowError(err error) bool {
+	if errors.Is(err, errPauseOnIncompatibleSchema) {
+		return false
+	}
+	return errors.Is(err, errUndeliverableRow) || !changefeedbase.IsRetryableError(err)
+}
+
+// DeadLetterEventSink is implemented by event sinks which route the rows which
+// they permanently fail to deliver to the dead letter queue of the changefeed
+// rather than failing.
+type DeadLetterEventSink interface {
+	EventSink
+
+	// SetDeadLetterQueue sets the queue to which the rows which the sink fails
+	// to deliver are routed.
+	SetDeadLetterQueue(q *deadLetterQueue)
+}
+
+// deadLetterMessage is the message emitted to the dead letter sink for a row.
+// The key and the value of the row are embedded as is if they are JSON, and
+// as base64 strings otherwise.
+type deadLetterMessage struct {
+	Error   string          `json:"error"`
+	Table   string          `json:"table"`
+	Key     json.RawMessage `json:"key,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Updated string          `json:"updated"`
+	MVCC    string          `json:"mvcc_timestamp"`
+}
+
+// deadLetterPayload returns the representation of the key or the value of a
+// row in its dead letter message.
+func deadLetterPayload(b []byte) json.RawMessage {
+	if len(b) == 0 || json.Valid(b) {
+		return b
+	}
+	// []byte values are marshaled as base64 strings.
+	encoded, _ := json.Marshal(b)
+	return encoded
+}
+
+// deadLetterQueue emits the rows which a changefeed aggregator fails to encode
+// or deliver to the dead letter sink of the changefeed, up to a maximum number
+// of rows, after which it fails the changefeed. The rows may be routed to the
+// queue concurrently by the aggregator and by the workers of its sink.
+type deadLetterQueue struct {
+	maxMessages int64
+	metrics     *Metrics
+	logEvery    log.EveryN
+
+	mu struct {
+		syncutil.Mutex
+		sink     EventSink
+		messages int64
+		// err is set once the queue is full or fails to emit a row, and is
+		// returned by the next flush.
+		err error
+	}
+}
+
+// deadLetterSinkDetails returns the details of the dead letter sink of a
+// changefeed. The dead letter messages are JSON regardless of the format of
+// the changefeed, and the options of the changefeed only apply to its sink.
+func deadLetterSinkDetails(
+	details jobspb.ChangefeedDetails, opts changefeedbase.DeadLetterOptions,
+) jobspb.ChangefeedDetails {
+	sinkOpts := map[string]string{
+		changefeedbase.OptFormat:       string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope:     string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptKeyInValue:   ``,
+		changefeedbase.OptTopicInValue: ``,
+	}
+	if v, ok := details.Opts[changefeedbase.OptFullTableName]; ok {
+		sinkOpts[changefeedbase.OptFullTableName] = v
+	}
+	details.SinkURI = opts.URI
+	details.Opts = sinkOpts
+	return details
+}
+
+// makeDeadLetterQueue makes the dead letter queue of a changefeed aggregator.
+func makeDeadLetterQueue(
+	ctx context.Context,
+	serverCfg *execinfra.ServerConfig,
+	details jobspb.ChangefeedDetails,
+	opts changefeedbase.DeadLetterOptions,
+	timestampOracle timestampLowerBoundOracle,
+	user username.SQLUsername,
+	jobID jobspb.JobID,
+	metrics *Metrics,
+) (*deadLetterQueue, error) {
+	sink, err := getEventSink(ctx, serverCfg, deadLetterSinkDetails(details, opts),
+		timestampOracle, user, jobID, (*sliMetrics)(nil))
+	if err != nil {
+		return nil, errors.Wrapf(err, "making %s sink", changefeedbase.OptDeadLetter)
+	}
+	q := &deadLetterQueue{
+		maxMessages: opts.MaxMessages,
+		metrics:     metrics,
+		logEvery:    log.Every(10 * time.Second),
+	}
+	q.mu.sink = &errorWrapperSink{wrapped: sink}
+	return q, nil
+}
+
+// enqueue emits the row, which failed with the specified error, to the dead
+// letter sink. An error is returned if the queue is full or failed, in which
+// case the row is not emitted.
+func (q *deadLetterQueue) enqueue(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	cause error,
+) error {
+	name, components := topic.GetNameComponents()
+	msg, err := json.Marshal(deadLetterMessage{
+		Error:   cause.Error(),
+		Table:   strings.Join(append([]string{string(name)}, components...), "."),
+		Key:     deadLetterPayload(key),
+		Value:   deadLetterPayload(value),
+		Updated: updated.AsOfSystemTime(),
+		MVCC:    mvcc.AsOfSystemTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.mu.err != nil {
+		return q.mu.err
+	}
+	if q.mu.messages >= q.maxMessages {
+		// The error is not retryable: the rows would fail again once the
+		// changefeed restarts.
+		q.mu.err = errors.Newf("the %s sink received %d rows which could not be encoded or delivered, "+
+			"the maximum allowed by %s", changefeedbase.OptDeadLetter, q.mu.messages,
+			changefeedbase.OptDeadLetterMaxMessages)
+		return q.mu.err
+	}
+	if q.logEvery.ShouldLog() {
+		log.Warningf(ctx, "routing row of %s to the %s sink: %v",
+			name, changefeedbase.OptDeadLetter, cause)
+	}
+	if err := q.mu.sink.EmitRow(ctx, topic, key, msg, updated, mvcc, kvevent.Alloc{}); err != nil {
+		q.mu.err = err
+		return err
+	}
+	q.mu.messages++
+	q.metrics.DeadLetterMessages.Inc(1)
+	return nil
+}
+
+// setErr records an error of the queue which could not be returned to the
+// caller of enqueue, such as a sink worker, so that the next flush fails.
+func (q *deadLetterQueue) setErr(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.mu.err == nil {
+		q.mu.err = err
+	}
+}
+
+// Flush flushes the rows routed to the dead letter sink. It must be called
+// after the sink of the changefeed is flushed, which may route its failed rows
+// to the queue, and before the resolved timestamps are forwarded.
+func (q *deadLetterQueue) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.mu.err != nil {
+		return q.mu.err
+	}
+	return q.mu.sink.Flush(ctx)
+}
+
+// Close closes the dead letter sink.
+func (q *deadLetterQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.mu.sink.Close()
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// deadLetterTestSink records the messages emitted to the dead letter sink.
+type deadLetterTestSink struct {
+	messages []string
+	flushed  int
+}
+
+func (s *deadLetterTestSink) Dial() error  { return nil }
+func (s *deadLetterTestSink) Close() error { return nil }
+
+func (s *deadLetterTestSink) EmitRow(
+	_ context.Context, _ TopicDescriptor, _, value []byte, _, _ hlc.Timestamp, _ kvevent.Alloc,
+) error {
+	s.messages = append(s.messages, string(value))
+	return nil
+}
+
+func (s *deadLetterTestSink) Flush(context.Context) error {
+	s.flushed++
+	return nil
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := &deadLetterTestSink{}
+	metrics := MakeMetrics(time.Minute).(*Metrics)
+	q := &deadLetterQueue{maxMessages: 2, metrics: metrics, logEvery: log.Every(time.Minute)}
+	q.mu.sink = sink
+
+	ts := hlc.Timestamp{WallTime: 1, Logical: 2}
+	cause := markUndeliverableRow(errors.New("schema rejected"))
+	require.True(t, isUndeliverableRowError(cause))
+	require.True(t, isUndeliverableRowError(changefeedbase.MarkRetryableError(cause)))
+	require.False(t, isUndeliverableRowError(changefeedbase.MarkRetryableError(errors.New("unavailable"))))
+
+	// JSON payloads are embedded as is, others as base64 strings.
+	require.NoError(t, q.enqueue(ctx, topic("t"), []byte(`[1]`), []byte(`{"a": 1}`), ts, ts, cause))
+	require.NoError(t, q.enqueue(ctx, topic("t"), []byte{0x0, 0x1}, nil, ts, ts, cause))
+	require.Equal(t, []string{
+		`{"error":"schema rejected","table":"t","key":[1],"value":{"a":1},` +
+			`"updated":"1.0000000002","mvcc_timestamp":"1.0000000002"}`,
+		`{"error":"schema rejected","table":"t","key":"AAE=",` +
+			`"updated":"1.0000000002","mvcc_timestamp":"1.0000000002"}`,
+	}, sink.messages)
+	require.Equal(t, int64(2), metrics.DeadLetterMessages.Count())
+	require.NoError(t, q.Flush(ctx))
+	require.Equal(t, 1, sink.flushed)
+
+	// Once the queue is full, the changefeed fails on the next flush.
+	err := q.enqueue(ctx, topic("t"), []byte(`[2]`), nil, ts, ts, cause)
+	require.Regexp(t, `received 2 rows which could not be encoded or delivered`, err)
+	require.False(t, changefeedbase.IsRetryableError(err))
+	require.Len(t, sink.messages, 2)
+	require.Equal(t, err, q.Flush(ctx))
+	require.Equal(t, 1, sink.flushed)
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"container/list"
+	"context"
+	"time"
+	"unsafe"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+)
+
+// encodedRow is a row which has been encoded, and is ready to be emitted to
+// the sink.
+type encodedRow struct {
+	topic           TopicDescriptor
+	key, value      []byte
+	updated, mvcc   hlc.Timestamp
+	alloc           kvevent.Alloc
+	partition       int32
+	partitionValues []string
+	headers         []messageHeader
+}
+
+// suppressionKey identifies the key of a row in a topic.
+type suppressionKey struct {
+	topic TopicIdentifier
+	key   string
+}
+
+// suppressedKey is the state tracked for each key by the duplicateSuppressor.
+type suppressedKey struct {
+	key         suppressionKey
+	lastEmitted hlc.Timestamp
+	// pending is the most recent suppressed row for the key, if any.
+	pending *encodedRow
+	// size is the amount of memory accounted for this key.
+	size int64
+}
+
+const suppressedKeyOverhead = int64(unsafe.Sizeof(suppressedKey{}) + unsafe.Sizeof(encodedRow{}))
+
+// duplicateSuppressor drops the rows for a key whose previous emission was
+// within the suppression window (see changefeedbase.OptSuppressDuplicatesWindow).
+//
+// The most recent suppressed row for each key is retained and emitted when the
+// changefeed flushes before forwarding resolved timestamps, so that the final
+// state of every key as of a resolved timestamp is always emitted. Deletes are
+// never suppressed.
+//
+// The keys are tracked in a memory accounted cache. Once the cache exceeds its
+// memory limit, the least recently updated keys are evicted and their retained
+// rows, if any, are emitted. Evicting keys can only cause more rows to be
+// emitted, never fewer.
+type duplicateSuppressor struct {
+	window  time.Duration
+	limit   int64
+	acc     *mon.BoundAccount
+	metrics *Metrics
+
+	// lru orders the tracked keys from the most to the least recently updated.
+	lru  list.List
+	keys map[suppressionKey]*list.Element
+	// used is the amount of memory accounted for the tracked keys.
+	used int64
+	// evicted are the retained rows of the keys which were evicted, and which
+	// must be emitted.
+	evicted []*encodedRow
+}
+
+func newDuplicateSuppressor(
+	window time.Duration, limit int64, acc *mon.BoundAccount, metrics *Metrics,
+) *duplicateSuppressor {
+	return &duplicateSuppressor{
+		window:  window,
+		limit:   limit,
+		acc:     acc,
+		metrics: metrics,
+		keys:    make(map[suppressionKey]*list.Element),
+	}
+}
+
+// admit records the row and returns true if it should be emitted. Otherwise,
+// the row is retained until it is superseded by a newer row for the same key,
+// or until the suppressor is flushed. The rows of the keys evicted in the
+// process are returned by takeEvicted.
+func (s *duplicateSuppressor) admit(ctx context.Context, row *encodedRow, deleted bool) bool {
+	k := suppressionKey{topic: row.topic.GetTopicIdentifier(), key: string(row.key)}
+
+	elem, ok := s.keys[k]
+	if !ok {
+		sk := &suppressedKey{key: k, lastEmitted: row.mvcc}
+		if !s.reserve(ctx, sk, suppressedKeyOverhead+int64(len(k.key))) {
+			// The key cannot be tracked; the row is emitted as is.
+			return true
+		}
+		s.keys[k] = s.lru.PushFront(sk)
+		return true
+	}
+
+	s.lru.MoveToFront(elem)
+	sk := elem.Value.(*suppressedKey)
+	s.releasePending(ctx, sk)
+
+	if deleted || row.mvcc.GoTime().Sub(sk.lastEmitted.GoTime()) >= s.window {
+		sk.lastEmitted = row.mvcc
+		return true
+	}
+
+	// Retain a copy of the row. The row's allocation is released right away,
+	// so that the retained rows do not hold on to the memory of the kv feed
+	// buffer; they are accounted for by the suppressor instead.
+	pending := *row
+	pending.key = append([]byte(nil), row.key...)
+	pending.value = append([]byte(nil), row.value...)
+	pending.alloc = kvevent.Alloc{}
+	row.alloc.Release(ctx)
+	if !s.reserve(ctx, sk, int64(len(pending.key)+len(pending.value))) {
+		// The key was evicted to make room; emit the row instead.
+		return true
+	}
+	sk.pending = &pending
+	s.metrics.SuppressedDuplicates.Inc(1)
+	return false
+}
+
+// reserve accounts for the specified number of bytes on behalf of the key,
+// evicting the least recently updated keys as needed. Returns false if the
+// memory could not be reserved, in which case the key is no longer tracked.
+func (s *duplicateSuppressor) reserve(ctx context.Context, sk *suppressedKey, bytes int64) bool {
+	for {
+		if s.used+bytes <= s.limit {
+			if err := s.acc.Grow(ctx, bytes); err == nil {
+				s.used += bytes
+				sk.size += bytes
+				return true
+			}
+		}
+		oldest := s.lru.Back()
+		if oldest == nil {
+			return false
+		}
+		evicted := oldest.Value.(*suppressedKey)
+		s.evict(ctx, oldest)
+		if evicted == sk {
+			return false
+		}
+	}
+}
+
+// evict stops tracking the key, and queues its retained row for emission.
+func (s *duplicateSuppressor) evict(ctx context.Context, elem *list.Element) {
+	sk := s.lru.Remove(elem).(*suppressedKey)
+	delete(s.keys, sk.key)
+	if sk.pending != nil {
+		s.evicted = append(s.evicted, sk.pending)
+		sk.pending = nil
+	}
+	s.shrink(ctx, sk.size)
+	s.metrics.SuppressionEvictions.Inc(1)
+}
+
+// releasePending discards the row retained for the key, if any.
+func (s *duplicateSuppressor) releasePending(ctx context.Context, sk *suppressedKey) {
+	if sk.pending == nil {
+		return
+	}
+	bytes := int64(len(sk.pending.key) + len(sk.pending.value))
+	sk.pending = nil
+	sk.size -= bytes
+	s.shrink(ctx, bytes)
+}
+
+func (s *duplicateSuppressor) shrink(ctx context.Context, bytes int64) {
+	s.used -= bytes
+	s.acc.Shrink(ctx, bytes)
+}
+
+// takeEvicted returns the retained rows of the keys evicted since the last
+// call.
+func (s *duplicateSuppressor) takeEvicted() []*encodedRow {
+	evicted := s.evicted
+	s.evicted = nil
+	return evicted
+}
+
+// flush returns all of the retained rows, which must be emitted before
+// resolved timestamps are forwarded. The keys remain tracked, with their
+// retained rows considered emitted.
+func (s *duplicateSuppressor) flush(ctx context.Context) []*encodedRow {
+	rows := s.takeEvicted()
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		sk := elem.Value.(*suppressedKey)
+		if sk.pending == nil {
+			continue
+		}
+		rows = append(rows, sk.pending)
+		sk.lastEmitted = sk.pending.mvcc
+		s.releasePending(ctx, sk)
+	}
+	return rows
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicateSuppressor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	row := func(key, value string, seconds int64) *encodedRow {
+		ts := hlc.Timestamp{WallTime: seconds * int64(time.Second)}
+		return &encodedRow{
+			topic:   noTopic{},
+			key:     []byte(key),
+			value:   []byte(value),
+			updated: ts,
+			mvcc:    ts,
+		}
+	}
+	values := func(rows []*encodedRow) (res []string) {
+		for _, r := range rows {
+			res = append(res, string(r.key)+"="+string(r.value))
+		}
+		return res
+	}
+
+	t.Run("suppresses within window", func(t *testing.T) {
+		mm := startMonitorWithBudget(math.MaxInt64)
+		defer mm.Stop(ctx)
+		acc := mm.MakeBoundAccount()
+		defer acc.Close(ctx)
+		metrics := MakeMetrics(time.Minute).(*Metrics)
+		s := newDuplicateSuppressor(5*time.Second, math.MaxInt64, &acc, metrics)
+
+		require.True(t, s.admit(ctx, row("a", "1", 0), false))
+		require.False(t, s.admit(ctx, row("a", "2", 1), false))
+		require.False(t, s.admit(ctx, row("a", "3", 2), false))
+		require.True(t, s.admit(ctx, row("b", "1", 2), false))
+		// Deletes are never suppressed, and supersede the suppressed rows.
+		require.True(t, s.admit(ctx, row("b", "", 3), true))
+		require.Equal(t, int64(2), metrics.SuppressedDuplicates.Count())
+
+		// Flushing emits the final state of the keys with suppressed rows.
+		require.Equal(t, []string{"a=3"}, values(s.flush(ctx)))
+		require.Empty(t, s.flush(ctx))
+
+		// The window is measured from the last emitted row, which includes the
+		// rows emitted by flush.
+		require.False(t, s.admit(ctx, row("a", "4", 6), false))
+		require.True(t, s.admit(ctx, row("a", "5", 7), false))
+		require.Empty(t, s.flush(ctx))
+		require.Empty(t, s.takeEvicted())
+	})
+
+	t.Run("evicts least recently updated keys", func(t *testing.T) {
+		mm := startMonitorWithBudget(math.MaxInt64)
+		defer mm.Stop(ctx)
+		acc := mm.MakeBoundAccount()
+		defer acc.Close(ctx)
+		metrics := MakeMetrics(time.Minute).(*Metrics)
+
+		// Allow for two keys, one of which retains a row.
+		limit := 2*(suppressedKeyOverhead+1) + 2
+		s := newDuplicateSuppressor(5*time.Second, limit, &acc, metrics)
+
+		require.True(t, s.admit(ctx, row("a", "1", 0), false))
+		require.False(t, s.admit(ctx, row("a", "2", 1), false))
+		require.True(t, s.admit(ctx, row("b", "1", 1), false))
+		require.Empty(t, s.takeEvicted())
+		require.Equal(t, limit, acc.Used())
+
+		// Tracking c evicts a, whose suppressed row must then be emitted.
+		require.True(t, s.admit(ctx, row("c", "1", 1), false))
+		require.Equal(t, []string{"a=2"}, values(s.takeEvicted()))
+		require.Equal(t, int64(1), metrics.SuppressionEvictions.Count())
+
+		// a is no longer tracked, so its next row is emitted, evicting b.
+		require.True(t, s.admit(ctx, row("a", "3", 2), false))
+		require.Empty(t, s.takeEvicted())
+		require.Equal(t, int64(2), metrics.SuppressionEvictions.Count())
+		require.Equal(t, 2*(suppressedKeyOverhead+1), acc.Used())
+
+		// Rows which cannot be retained within the limit are emitted.
+		require.True(t, s.admit(ctx, row("a", strings.Repeat("x", int(limit)), 3), false))
+		require.Empty(t, s.takeEvicted())
+		require.Empty(t, s.flush(ctx))
+		require.Equal(t, int64(0), acc.Used())
+	})
+}
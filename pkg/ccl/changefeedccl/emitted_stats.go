@@ -0,0 +1,82 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+)
+
+// emittedStats accumulates the number of messages, and their size in bytes,
+// emitted for each of the target tables of a changefeed.
+//
+// The aggregators count the messages they emit to the sink, and forward the
+// counts to the frontier along with their resolved spans, after the messages
+// have been flushed. The frontier adds the counts to the job progress whenever
+// it checkpoints, so that the cumulative counts survive restarts. Messages are
+// counted once they are handed to the sink, regardless of the retries the sink
+// performs internally, but the messages emitted again after a restart are
+// counted again: like the messages themselves, the counts are at-least-once.
+type emittedStats struct {
+	m map[descpb.ID]jobspb.ChangefeedEmittedStats
+}
+
+// add records the emission of the specified number of messages and bytes for
+// the table.
+func (s *emittedStats) add(tableID descpb.ID, messages, bytes int64) {
+	if s.m == nil {
+		s.m = make(map[descpb.ID]jobspb.ChangefeedEmittedStats)
+	}
+	stats := s.m[tableID]
+	stats.TableID = tableID
+	stats.Messages += messages
+	stats.Bytes += bytes
+	s.m[tableID] = stats
+}
+
+// merge adds the specified stats to the accumulated ones.
+func (s *emittedStats) merge(stats []jobspb.ChangefeedEmittedStats) {
+	for _, st := range stats {
+		s.add(st.TableID, st.Messages, st.Bytes)
+	}
+}
+
+// empty returns true if no messages were accumulated.
+func (s *emittedStats) empty() bool {
+	return len(s.m) == 0
+}
+
+// addedTo returns the sum of the specified stats and the accumulated ones,
+// sorted by table ID. The specified stats are not modified.
+func (s *emittedStats) addedTo(
+	stats []jobspb.ChangefeedEmittedStats,
+) []jobspb.ChangefeedEmittedStats {
+	var sum emittedStats
+	sum.merge(stats)
+	for _, st := range s.m {
+		sum.add(st.TableID, st.Messages, st.Bytes)
+	}
+	return sum.take()
+}
+
+// take returns the accumulated stats, sorted by table ID, and resets them.
+func (s *emittedStats) take() []jobspb.ChangefeedEmittedStats {
+	if s.empty() {
+		return nil
+	}
+	stats := make([]jobspb.ChangefeedEmittedStats, 0, len(s.m))
+	for _, st := range s.m {
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].TableID < stats[j].TableID })
+	s.m = nil
+	return stats
+}
@@ -10,7 +10,10 @@ package changefeedccl
 
 import (
 	"context"
+	"strconv"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/build"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
@@ -41,6 +44,26 @@ type Encoder interface {
 	EncodeResolvedTimestamp(context.Context, string, hlc.Timestamp) ([]byte, error)
 }
 
+// schemaFileEncoder is implemented by the encoders which write the definitions
+// of their messages which no schema registry holds to schema files, when the
+// sink writes files (see SchemaFileSink).
+type schemaFileEncoder interface {
+	// setSchemaFileSink sets the sink to which the schema files are written.
+	setSchemaFileSink(s SchemaFileSink)
+}
+
+// setSchemaFileSink hooks up the encoder to the sink if the encoder writes
+// schema files and the sink supports them.
+func setSchemaFileSink(encoder Encoder, sink externalResource) {
+	e, ok := encoder.(schemaFileEncoder)
+	if !ok {
+		return
+	}
+	if s, ok := sink.(SchemaFileSink); ok {
+		e.setSchemaFileSink(s)
+	}
+}
+
 func getEncoder(
 	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
 ) (Encoder, error) {
@@ -49,9 +72,107 @@ func getEncoder(
 		return makeJSONEncoder(opts, targets)
 	case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
 		return newConfluentAvroEncoder(opts, targets)
+	case changefeedbase.OptFormatProtobuf:
+		return newConfluentProtobufEncoder(opts, targets)
 	case changefeedbase.OptFormatCSV:
 		return newCSVEncoder(opts), nil
+	case changefeedbase.OptFormatCloudEvents:
+		return newCloudEventsEncoder(opts, targets)
+	case changefeedbase.OptFormatMsgpack:
+		return newMsgpackEncoder(opts, targets)
+	case changefeedbase.OptFormatTemplate:
+		return newTemplateEncoder(opts, targets)
+	case changefeedbase.OptFormatXML:
+		return newXMLEncoder(opts, targets)
+	case changefeedbase.OptFormatParquet:
+		// The sinks convert the JSON encoding of the rows to parquet.
+		return makeJSONEncoder(opts, targets)
 	default:
 		return nil, errors.AssertionFailedf(`unknown format: %s`, opts.Format)
 	}
 }
+
+// metadataColumnValue returns the value of the metadata field of the row (see
+// changefeedbase.OptMetadataColumns): a string for the timestamps and the
+// cluster ID, and an int64 for the other IDs.
+func metadataColumnValue(
+	col changefeedbase.MetadataColumn, evCtx eventContext, row cdcevent.Row,
+) (interface{}, error) {
+	switch col {
+	case changefeedbase.OptMetadataColumnMVCCTimestamp:
+		return evCtx.mvcc.AsOfSystemTime(), nil
+	case changefeedbase.OptMetadataColumnStatementTime:
+		return evCtx.statementTime.AsOfSystemTime(), nil
+	case changefeedbase.OptMetadataColumnTableID:
+		return int64(row.TableID), nil
+	case changefeedbase.OptMetadataColumnJobID:
+		return int64(evCtx.origin.jobID), nil
+	case changefeedbase.OptMetadataColumnClusterID:
+		return evCtx.origin.clusterID.String(), nil
+	case changefeedbase.OptMetadataColumnNodeID:
+		return int64(evCtx.origin.nodeID), nil
+	default:
+		return nil, errors.AssertionFailedf(`unknown metadata column: %s`, col)
+	}
+}
+
+// The operations of the debezium envelope (see
+// changefeedbase.OptEnvelopeDebezium).
+const (
+	debeziumOpCreate = `c`
+	debeziumOpUpdate = `u`
+	debeziumOpDelete = `d`
+	debeziumOpRead   = `r`
+
+	debeziumConnector = `cockroachdb`
+)
+
+// debeziumOp returns the operation of the debezium envelope of the row: the
+// read of the row by a scan of its table, or its create, update or delete.
+// Creates are told apart from updates by the previous row, which is not known
+// when the changefeed projects its rows.
+func debeziumOp(evCtx eventContext, updatedRow, prevRow cdcevent.Row) string {
+	switch {
+	case evCtx.snapshot:
+		return debeziumOpRead
+	case updatedRow.IsDeleted():
+		return debeziumOpDelete
+	case prevRow.IsInitialized() && prevRow.HasValues() && !prevRow.IsDeleted():
+		return debeziumOpUpdate
+	default:
+		return debeziumOpCreate
+	}
+}
+
+// debeziumSource returns the source block of the debezium envelope of the row.
+// Its ts_ms holds the commit time of the row, and ts_hlc its exact timestamp.
+func debeziumSource(evCtx eventContext, row cdcevent.Row) map[string]interface{} {
+	return map[string]interface{}{
+		`version`:   build.BinaryVersion(),
+		`connector`: debeziumConnector,
+		`ts_ms`:     evCtx.mvcc.WallTime / int64(time.Millisecond),
+		`ts_hlc`:    evCtx.mvcc.AsOfSystemTime(),
+		`snapshot`:  strconv.FormatBool(evCtx.snapshot),
+		`table`:     row.TableName,
+	}
+}
+
+// debeziumTimestamp returns the ts_ms of the debezium envelope of the row, the
+// time at which the changefeed observed it.
+func debeziumTimestamp(evCtx eventContext) int64 {
+	return evCtx.updated.WallTime / int64(time.Millisecond)
+}
+
+// enrichedSource returns the source block of the enriched envelope of the row
+// (see changefeedbase.OptEnvelopeEnriched), identifying the changefeed which
+// emitted it and the table of the row. Its snapshot field tells the rows read
+// by scans of the table apart.
+func enrichedSource(evCtx eventContext, row cdcevent.Row) map[string]interface{} {
+	return map[string]interface{}{
+		`cluster_id`: evCtx.origin.clusterID.String(),
+		`node_id`:    int64(evCtx.origin.nodeID),
+		`job_id`:     int64(evCtx.origin.jobID),
+		`table`:      row.TableName,
+		`snapshot`:   evCtx.snapshot,
+	}
+}
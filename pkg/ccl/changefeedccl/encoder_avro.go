@@ -12,6 +12,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
@@ -36,6 +37,16 @@ type confluentAvroEncoder struct {
 	updatedField, beforeField, keyOnly bool
 	virtualColumnVisibility            changefeedbase.VirtualColumnVisibility
 	targets                            changefeedbase.Targets
+	// debezium is set to wrap the values in the debezium envelope.
+	debezium bool
+	// metadataColumns are the metadata fields added to the envelopes.
+	metadataColumns changefeedbase.MetadataColumns
+	// fieldNamer names the fields of the columns of the keys and rows.
+	fieldNamer changefeedbase.FieldNamer
+	// decimals configures the encoding of the DECIMAL columns.
+	decimals avroDecimalConfig
+	// subjectNamer names the subjects of the registered schemas.
+	subjectNamer confluentSubjectNamer
 
 	keyCache   *cache.UnorderedCache // [tableIDAndVersion]confluentRegisteredKeySchema
 	valueCache *cache.UnorderedCache // [tableIDAndVersionPair]confluentRegisteredEnvelopeSchema
@@ -79,12 +90,20 @@ func newConfluentAvroEncoder(
 		schemaPrefix:            opts.AvroSchemaPrefix,
 		targets:                 targets,
 		virtualColumnVisibility: opts.VirtualColumns,
+		metadataColumns:         opts.MetadataColumns,
+		fieldNamer:              opts.GetFieldNamer(),
+		decimals:                makeAvroDecimalConfig(opts),
+		subjectNamer:            makeConfluentSubjectNamer(opts),
 	}
 
 	switch opts.Envelope {
 	case changefeedbase.OptEnvelopeKeyOnly:
 		e.keyOnly = true
 	case changefeedbase.OptEnvelopeWrapped:
+	case changefeedbase.OptEnvelopeDebezium:
+		// The debezium envelope always holds the previous values of the rows.
+		e.debezium = true
+		e.beforeField = true
 	default:
 		return nil, errors.Errorf(`%s=%s is not supported with %s=%s`,
 			changefeedbase.OptEnvelope, opts.Envelope, changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
@@ -94,7 +113,7 @@ func newConfluentAvroEncoder(
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
 			changefeedbase.OptUpdatedTimestamps, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
 	}
-	e.beforeField = opts.Diff
+	e.beforeField = e.beforeField || opts.Diff
 	if e.beforeField && e.keyOnly {
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
 			changefeedbase.OptDiff, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
@@ -118,7 +137,7 @@ func newConfluentAvroEncoder(
 		return nil, err
 	}
 
-	e.schemaRegistry = reg
+	e.schemaRegistry = newPolicySchemaRegistry(reg, opts)
 	e.keyCache = cache.NewUnorderedCache(encoderCacheConfig)
 	e.valueCache = cache.NewUnorderedCache(encoderCacheConfig)
 	e.resolvedCache = make(map[string]confluentRegisteredEnvelopeSchema)
@@ -128,22 +147,64 @@ func newConfluentAvroEncoder(
 // Get the raw SQL-formatted string for a table name
 // and apply full_table_name and avro_schema_prefix options
 func (e *confluentAvroEncoder) rawTableName(eventMeta cdcevent.Metadata) (string, error) {
-	target, found := e.targets.FindByTableIDAndFamilyName(eventMeta.TableID, eventMeta.FamilyName)
+	return confluentRawTableName(e.targets, e.schemaPrefix, eventMeta)
+}
+
+// confluentRawTableName returns the raw SQL-formatted table name of the event,
+// prefixed with the specified schema prefix, after which the subjects of the
+// schema registry are named.
+func confluentRawTableName(
+	targets changefeedbase.Targets, schemaPrefix string, eventMeta cdcevent.Metadata,
+) (string, error) {
+	target, found := targets.FindByTableIDAndFamilyName(eventMeta.TableID, eventMeta.FamilyName)
 	if !found {
 		return eventMeta.TableName, errors.Newf("Could not find Target for %s", eventMeta)
 	}
 	switch target.Type {
 	case jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY:
-		return e.schemaPrefix + string(target.StatementTimeName), nil
+		return schemaPrefix + string(target.StatementTimeName), nil
 	case jobspb.ChangefeedTargetSpecification_EACH_FAMILY:
-		return fmt.Sprintf("%s%s.%s", e.schemaPrefix, target.StatementTimeName, eventMeta.FamilyName), nil
+		return fmt.Sprintf("%s%s.%s", schemaPrefix, target.StatementTimeName, eventMeta.FamilyName), nil
 	case jobspb.ChangefeedTargetSpecification_COLUMN_FAMILY:
-		return fmt.Sprintf("%s%s.%s", e.schemaPrefix, target.StatementTimeName, target.FamilyName), nil
+		return fmt.Sprintf("%s%s.%s", schemaPrefix, target.StatementTimeName, target.FamilyName), nil
 	default:
 		return "", errors.AssertionFailedf("Found a matching target with unimplemented type %s", target.Type)
 	}
 }
 
+// confluentSubjectNamer names the subjects under which the schemas of the keys
+// and values are registered (see
+// changefeedbase.OptSchemaRegistrySubjectNameStrategy).
+type confluentSubjectNamer struct {
+	strategy changefeedbase.SubjectNameStrategy
+	template string
+}
+
+func makeConfluentSubjectNamer(opts changefeedbase.EncodingOptions) confluentSubjectNamer {
+	return confluentSubjectNamer{strategy: opts.SubjectNameStrategy, template: opts.SubjectTemplate}
+}
+
+// subject returns the subject of the schema of the keys or the values of the
+// topic, depending on the suffix, whose record has the specified full name.
+func (n confluentSubjectNamer) subject(topic string, suffix string, record string) string {
+	// NB: This uses the kafka name escaper because it has to match the name
+	// of the kafka topic.
+	topic = SQLNameToKafkaName(topic)
+	if n.template != `` {
+		return strings.NewReplacer(
+			`{topic}`, topic, `{record}`, record, `{type}`, strings.TrimPrefix(suffix, `-`),
+		).Replace(n.template)
+	}
+	switch n.strategy {
+	case changefeedbase.OptSubjectNameRecord:
+		return record
+	case changefeedbase.OptSubjectNameTopicRecord:
+		return topic + `-` + record
+	default:
+		return topic + suffix
+	}
+}
+
 // EncodeKey implements the Encoder interface.
 func (e *confluentAvroEncoder) EncodeKey(ctx context.Context, row cdcevent.Row) ([]byte, error) {
 	// No familyID in the cache key for keys because it's the same schema for all families
@@ -162,15 +223,14 @@ func (e *confluentAvroEncoder) EncodeKey(ctx context.Context, row cdcevent.Row)
 		if err != nil {
 			return nil, err
 		}
-		registered.schema, err = primaryIndexToAvroSchema(row, tableName, e.schemaPrefix)
+		registered.schema, err = primaryIndexToAvroSchema(
+			row, tableName, e.schemaPrefix, e.fieldNamer, e.decimals)
 		if err != nil {
 			return nil, err
 		}
 
-		// NB: This uses the kafka name escaper because it has to match the name
-		// of the kafka topic.
-		subject := SQLNameToKafkaName(tableName) + confluentSubjectSuffixKey
-		registered.registryID, err = e.register(ctx, &registered.schema.avroRecord, subject)
+		registered.registryID, err = e.register(
+			ctx, &registered.schema.avroRecord, tableName, confluentSubjectSuffixKey)
 		if err != nil {
 			return nil, err
 		}
@@ -220,18 +280,32 @@ func (e *confluentAvroEncoder) EncodeValue(
 		var beforeDataSchema *avroDataRecord
 		if e.beforeField && prevRow.IsInitialized() {
 			var err error
-			beforeDataSchema, err = tableToAvroSchema(prevRow, `before`, e.schemaPrefix)
+			beforeDataSchema, err = tableToAvroSchema(
+				prevRow, `before`, e.schemaPrefix, e.fieldNamer, e.decimals)
+			if err != nil {
+				return nil, err
+			}
+		} else if e.debezium {
+			// The previous rows are not known when the changefeed projects its
+			// rows, but the debezium envelope has the before field regardless.
+			var err error
+			beforeDataSchema, err = tableToAvroSchema(
+				updatedRow, `before`, e.schemaPrefix, e.fieldNamer, e.decimals)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		afterDataSchema, err := tableToAvroSchema(updatedRow, avroSchemaNoSuffix, e.schemaPrefix)
+		afterDataSchema, err := tableToAvroSchema(
+			updatedRow, avroSchemaNoSuffix, e.schemaPrefix, e.fieldNamer, e.decimals)
 		if err != nil {
 			return nil, err
 		}
 
-		opts := avroEnvelopeOpts{afterField: true, beforeField: e.beforeField, updatedField: e.updatedField}
+		opts := avroEnvelopeOpts{
+			afterField: true, beforeField: e.beforeField, updatedField: e.updatedField, debeziumFields: e.debezium,
+			metadataColumns: e.metadataColumns,
+		}
 		name, err := e.rawTableName(updatedRow.Metadata)
 		if err != nil {
 			return nil, err
@@ -242,10 +316,8 @@ func (e *confluentAvroEncoder) EncodeValue(
 			return nil, err
 		}
 
-		// NB: This uses the kafka name escaper because it has to match the name
-		// of the kafka topic.
-		subject := SQLNameToKafkaName(name) + confluentSubjectSuffixValue
-		registered.registryID, err = e.register(ctx, &registered.schema.avroRecord, subject)
+		registered.registryID, err = e.register(
+			ctx, &registered.schema.avroRecord, name, confluentSubjectSuffixValue)
 		if err != nil {
 			return nil, err
 		}
@@ -258,6 +330,24 @@ func (e *confluentAvroEncoder) EncodeValue(
 			`updated`: evCtx.updated,
 		}
 	}
+	if registered.schema.opts.debeziumFields {
+		meta = map[string]interface{}{
+			`source`: debeziumSource(evCtx, updatedRow),
+			`op`:     debeziumOp(evCtx, updatedRow, prevRow),
+			`ts_ms`:  debeziumTimestamp(evCtx),
+		}
+	}
+	if cols := registered.schema.opts.metadataColumns.Columns(); len(cols) > 0 {
+		if meta == nil {
+			meta = make(map[string]interface{}, len(cols))
+		}
+		for _, col := range cols {
+			var err error
+			if meta[string(col)], err = metadataColumnValue(col, evCtx, updatedRow); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	// https://docs.confluent.io/current/schema-registry/docs/serializer-formatter.html#wire-format
 	header := []byte{
@@ -281,10 +371,8 @@ func (e *confluentAvroEncoder) EncodeResolvedTimestamp(
 			return nil, err
 		}
 
-		// NB: This uses the kafka name escaper because it has to match the name
-		// of the kafka topic.
-		subject := SQLNameToKafkaName(topic) + confluentSubjectSuffixValue
-		registered.registryID, err = e.register(ctx, &registered.schema.avroRecord, subject)
+		registered.registryID, err = e.register(
+			ctx, &registered.schema.avroRecord, topic, confluentSubjectSuffixValue)
 		if err != nil {
 			return nil, err
 		}
@@ -307,8 +395,12 @@ func (e *confluentAvroEncoder) EncodeResolvedTimestamp(
 	return registered.schema.BinaryFromRow(header, meta, nilRow, nilRow)
 }
 
+// register registers the schema of the keys or values of the topic, depending
+// on the suffix, under its subject.
 func (e *confluentAvroEncoder) register(
-	ctx context.Context, schema *avroRecord, subject string,
+	ctx context.Context, schema *avroRecord, topic string, suffix string,
 ) (int32, error) {
-	return e.schemaRegistry.RegisterSchemaForSubject(ctx, subject, schema.codec.Schema())
+	subject := e.subjectNamer.subject(topic, suffix, avroUnionKey(schema))
+	return e.schemaRegistry.RegisterSchemaForSubject(
+		ctx, subject, confluentSchemaTypeAvro, schema.codec.Schema())
 }
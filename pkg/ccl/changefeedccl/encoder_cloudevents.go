@@ -0,0 +1,161 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// The attributes of the CloudEvents emitted by format=cloudevents. See
+// https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/spec.md.
+const (
+	cloudEventsSpecVersion     = `1.0`
+	cloudEventsContentType     = `application/json`
+	cloudEventsSourcePrefix    = `/cockroachdb/`
+	cloudEventsResolvedSource  = cloudEventsSourcePrefix + `changefeed`
+	cloudEventsRowTypePrefix   = `com.cockroachlabs.changefeed.row.`
+	cloudEventsResolvedType    = `com.cockroachlabs.changefeed.resolved`
+	cloudEventsHeaderPrefix    = `ce_`
+	cloudEventsContentTypeName = `content-type`
+)
+
+// cloudEvent is a CloudEvent in the structured content mode, whose data is
+// JSON.
+type cloudEvent struct {
+	SpecVersion     string            `json:"specversion"`
+	ID              string            `json:"id"`
+	Source          string            `json:"source"`
+	Type            string            `json:"type"`
+	Time            string            `json:"time"`
+	DataContentType string            `json:"datacontenttype"`
+	Data            gojson.RawMessage `json:"data,omitempty"`
+}
+
+// makeCloudEvent returns the CloudEvent of the row, without its data. The id
+// of the event is made of the MVCC timestamp and the key of the row, which
+// identify the change within the table, the source of the event.
+func makeCloudEvent(
+	evCtx eventContext, updatedRow, prevRow cdcevent.Row, key []byte, withDiff bool,
+) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              evCtx.mvcc.AsOfSystemTime() + `:` + string(key),
+		Source:          cloudEventsSourcePrefix + url.PathEscape(updatedRow.TableName),
+		Type:            cloudEventsRowTypePrefix + operationOfRow(updatedRow, prevRow, withDiff),
+		Time:            cloudEventTime(evCtx.mvcc),
+		DataContentType: cloudEventsContentType,
+	}
+}
+
+// cloudEventTime formats the timestamp as the time attribute of a CloudEvent,
+// which is a RFC 3339 timestamp.
+func cloudEventTime(ts hlc.Timestamp) string {
+	return timeutil.Unix(0, ts.WallTime).UTC().Format(time.RFC3339Nano)
+}
+
+// headers returns the attributes of the CloudEvent as the headers of a message
+// in the binary content mode, named as in the kafka protocol binding.
+func (ce cloudEvent) headers() []messageHeader {
+	return []messageHeader{
+		{key: cloudEventsHeaderPrefix + `specversion`, value: []byte(ce.SpecVersion)},
+		{key: cloudEventsHeaderPrefix + `id`, value: []byte(ce.ID)},
+		{key: cloudEventsHeaderPrefix + `source`, value: []byte(ce.Source)},
+		{key: cloudEventsHeaderPrefix + `type`, value: []byte(ce.Type)},
+		{key: cloudEventsHeaderPrefix + `time`, value: []byte(ce.Time)},
+		{key: cloudEventsContentTypeName, value: []byte(ce.DataContentType)},
+	}
+}
+
+// cloudEventsEncoder encodes changefeed entries as CloudEvents, whose data is
+// the JSON encoding of the rows. In the binary content mode, the values only
+// hold the data of the events, whose attributes are attached as headers by
+// the kvEventToRowConsumer.
+type cloudEventsEncoder struct {
+	json     *jsonEncoder
+	binary   bool
+	withDiff bool
+}
+
+var _ Encoder = &cloudEventsEncoder{}
+
+func newCloudEventsEncoder(
+	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
+) (*cloudEventsEncoder, error) {
+	j, err := makeJSONEncoder(opts, targets)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudEventsEncoder{
+		json:     j,
+		binary:   opts.CloudEventsMode == changefeedbase.OptCloudEventsModeBinary,
+		withDiff: opts.Diff,
+	}, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *cloudEventsEncoder) EncodeKey(ctx context.Context, row cdcevent.Row) ([]byte, error) {
+	return e.json.EncodeKey(ctx, row)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *cloudEventsEncoder) EncodeValue(
+	ctx context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+) ([]byte, error) {
+	if e.binary {
+		return e.json.EncodeValue(ctx, evCtx, updatedRow, prevRow)
+	}
+	// The key and the value of the row share the buffer of the JSON encoder.
+	key, err := e.json.EncodeKey(ctx, updatedRow)
+	if err != nil {
+		return nil, err
+	}
+	ce := makeCloudEvent(evCtx, updatedRow, prevRow, key, e.withDiff)
+	data, err := e.json.EncodeValue(ctx, evCtx, updatedRow, prevRow)
+	if err != nil {
+		return nil, err
+	}
+	ce.Data = data
+	return gojson.Marshal(ce)
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface. Resolved
+// timestamps are always encoded as structured events, since the sinks do not
+// attach headers to them. Their source is the topic, if any.
+func (e *cloudEventsEncoder) EncodeResolvedTimestamp(
+	_ context.Context, topic string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	source := cloudEventsResolvedSource
+	if topic != `` {
+		source = cloudEventsSourcePrefix + url.PathEscape(topic)
+	}
+	data, err := gojson.Marshal(map[string]interface{}{
+		`resolved`: eval.TimestampToDecimalDatum(resolved).Decimal.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gojson.Marshal(cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              resolved.AsOfSystemTime(),
+		Source:          source,
+		Type:            cloudEventsResolvedType,
+		Time:            cloudEventTime(resolved),
+		DataContentType: cloudEventsContentType,
+		Data:            data,
+	})
+}
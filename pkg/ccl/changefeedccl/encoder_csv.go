@@ -11,31 +11,52 @@ package changefeedccl
 import (
 	"bytes"
 	"context"
+	gojson "encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
-	"github.com/cockroachdb/cockroach/pkg/util/encoding/csv"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/errors"
 )
 
+// csvDeletedColumn is the last column of the rows of the changefeeds which
+// emit deleted rows (see changefeedbase.CSVOptions).
+const csvDeletedColumn = `_crdb_deleted`
+
+// csvMetadataColumnPrefix prefixes the names of the metadata columns, which
+// follow the columns of the rows (see changefeedbase.OptMetadataColumns).
+const csvMetadataColumnPrefix = `_crdb_`
+
+// csvEncoder encodes the rows as CSV records, which are not terminated by a
+// newline: the sinks delimit them. The rows have no keys. Resolved timestamps
+// are encoded in JSON, since they cannot be told apart from the rows
+// otherwise.
 type csvEncoder struct {
-	csvRow []string
-	buf    *bytes.Buffer
-	writer *csv.Writer
+	opts            changefeedbase.CSVOptions
+	metadataColumns []changefeedbase.MetadataColumn
+	csvRow          []csvField
+	keys            map[int]string
+	buf             bytes.Buffer
+}
+
+// csvField is a field of a CSV record, which is either a value or NULL.
+type csvField struct {
+	value string
+	null  bool
 }
 
 var _ Encoder = &csvEncoder{}
 
 func newCSVEncoder(opts changefeedbase.EncodingOptions) *csvEncoder {
-	newBuf := bytes.NewBuffer([]byte{})
-	newEncoder := &csvEncoder{
-		buf:    newBuf,
-		writer: csv.NewWriter(newBuf),
-	}
-	newEncoder.writer.SkipNewline = true
-	return newEncoder
+	return &csvEncoder{opts: opts.CSV, metadataColumns: opts.MetadataColumns.Columns()}
 }
 
 // EncodeKey implements the Encoder interface.
@@ -43,26 +64,60 @@ func (e *csvEncoder) EncodeKey(_ context.Context, row cdcevent.Row) ([]byte, err
 	return nil, nil
 }
 
-// EncodeValue implements the Encoder interface.
+// EncodeValue implements the Encoder interface. Deleted rows only hold their
+// primary key columns, the other columns being NULL.
 func (e *csvEncoder) EncodeValue(
 	ctx context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
 ) ([]byte, error) {
-	if updatedRow.IsDeleted() {
+	deleted := updatedRow.IsDeleted()
+	if deleted && !e.opts.DeletedColumn {
 		return nil, errors.Errorf(`cannot encode deleted rows into CSV format`)
 	}
 	e.csvRow = e.csvRow[:0]
-	if err := updatedRow.ForEachColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
-		e.csvRow = append(e.csvRow, tree.AsString(d))
+	if deleted {
+		// Only the primary key columns of the deleted rows are decoded.
+		if e.keys == nil {
+			e.keys = make(map[int]string)
+		}
+		for ord := range e.keys {
+			delete(e.keys, ord)
+		}
+		if err := updatedRow.ForEachKeyColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+			e.keys[col.Ordinal()] = tree.AsString(d)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if err := updatedRow.ForEachColumn().Col(func(col cdcevent.ResultColumn) error {
+			key, ok := e.keys[col.Ordinal()]
+			e.csvRow = append(e.csvRow, csvField{value: key, null: !ok})
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	} else if err := updatedRow.ForEachColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if d == tree.DNull {
+			e.csvRow = append(e.csvRow, csvField{null: true})
+		} else {
+			e.csvRow = append(e.csvRow, csvField{value: tree.AsString(d)})
+		}
 		return nil
 	}); err != nil {
 		return nil, err
 	}
+	for _, col := range e.metadataColumns {
+		v, err := metadataColumnValue(col, evCtx, updatedRow)
+		if err != nil {
+			return nil, err
+		}
+		e.csvRow = append(e.csvRow, csvField{value: fmt.Sprint(v)})
+	}
+	if e.opts.DeletedColumn {
+		e.csvRow = append(e.csvRow, csvField{value: strconv.FormatBool(deleted)})
+	}
 
 	e.buf.Reset()
-	if err := e.writer.Write(e.csvRow); err != nil {
-		return nil, err
-	}
-	e.writer.Flush()
+	writeCSVRecord(&e.buf, e.opts, e.csvRow)
 	return e.buf.Bytes(), nil
 }
 
@@ -70,5 +125,100 @@ func (e *csvEncoder) EncodeValue(
 func (e *csvEncoder) EncodeResolvedTimestamp(
 	_ context.Context, _ string, resolved hlc.Timestamp,
 ) ([]byte, error) {
-	return nil, errors.New("EncodeResolvedTimestamp is not supported with the CSV encoder")
+	return gojson.Marshal(map[string]interface{}{
+		`resolved`: eval.TimestampToDecimalDatum(resolved).Decimal.String(),
+	})
+}
+
+// writeCSVRecord writes the fields as a CSV record, without a trailing newline.
+// The quotes of the quoted fields are escaped by doubling them.
+func writeCSVRecord(buf *bytes.Buffer, opts changefeedbase.CSVOptions, fields []csvField) {
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteRune(opts.Delimiter)
+		}
+		if f.null {
+			buf.WriteString(opts.Null)
+			continue
+		}
+		if !csvFieldNeedsQuotes(opts, f.value) {
+			buf.WriteString(f.value)
+			continue
+		}
+		buf.WriteByte('"')
+		buf.WriteString(strings.ReplaceAll(f.value, `"`, `""`))
+		buf.WriteByte('"')
+	}
+}
+
+// csvFieldNeedsQuotes reports whether the value must be quoted. Under the
+// minimal quoting, these are the values holding a delimiter, a quote or a
+// newline, the values starting with a space, which some readers trim, the
+// values matching the representation of NULL, and the Postgres end-of-data
+// marker.
+func csvFieldNeedsQuotes(opts changefeedbase.CSVOptions, value string) bool {
+	switch opts.Quote {
+	case changefeedbase.OptCSVQuoteAll:
+		return true
+	case changefeedbase.OptCSVQuoteNone:
+		return false
+	}
+	if value == opts.Null {
+		return true
+	}
+	if value == `` {
+		return false
+	}
+	if value == `\.` || strings.ContainsRune(value, opts.Delimiter) || strings.ContainsAny(value, "\"\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return unicode.IsSpace(r)
+}
+
+// csvHeaders builds the header rows of the topics, naming the columns of the
+// rows of their current version (see changefeedbase.OptCSVHeader).
+type csvHeaders struct {
+	opts            changefeedbase.CSVOptions
+	metadataColumns []changefeedbase.MetadataColumn
+	headers         map[TopicIdentifier]csvHeader
+}
+
+type csvHeader struct {
+	version descpb.DescriptorVersion
+	row     []byte
+}
+
+func makeCSVHeaders(opts changefeedbase.EncodingOptions) *csvHeaders {
+	return &csvHeaders{
+		opts:            opts.CSV,
+		metadataColumns: opts.MetadataColumns.Columns(),
+		headers:         make(map[TopicIdentifier]csvHeader),
+	}
+}
+
+// headerOf returns the header row of the topic, without a trailing newline.
+func (h *csvHeaders) headerOf(topic TopicDescriptor) ([]byte, error) {
+	id := topic.GetTopicIdentifier()
+	if header, ok := h.headers[id]; ok && header.version == topic.GetVersion() {
+		return header.row, nil
+	}
+	descTopic, ok := topic.(eventDescriptorTopic)
+	if !ok || descTopic.getEventDescriptor() == nil {
+		return nil, errors.AssertionFailedf("topic %v does not describe its columns", id)
+	}
+	var fields []csvField
+	for _, col := range descTopic.getEventDescriptor().ValueColumns() {
+		fields = append(fields, csvField{value: col.Name})
+	}
+	for _, col := range h.metadataColumns {
+		fields = append(fields, csvField{value: csvMetadataColumnPrefix + string(col)})
+	}
+	if h.opts.DeletedColumn {
+		fields = append(fields, csvField{value: csvDeletedColumn})
+	}
+	var buf bytes.Buffer
+	writeCSVRecord(&buf, h.opts, fields)
+	h.headers[id] = csvHeader{version: topic.GetVersion(), row: buf.Bytes()}
+	return buf.Bytes(), nil
 }
@@ -14,6 +14,7 @@ import (
 	gojson "encoding/json"
 	"time"
 
+	"github.com/cockroachdb/apd/v3"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
@@ -29,7 +30,30 @@ import (
 // to its value. Updated timestamps in rows and resolved timestamp payloads are
 // stored in a sub-object under the `__crdb__` key in the top-level JSON object.
 type jsonEncoder struct {
-	updatedField, mvccTimestampField, beforeField, wrapped, keyOnly, keyInValue, topicInValue bool
+	updatedField, mvccTimestampField, txnIDField, securityLabelField, beforeField, wrapped, keyOnly, keyInValue, topicInValue bool
+	// debezium is set to wrap the values in the debezium envelope.
+	debezium bool
+	// enriched is set to wrap the values in the enriched envelope.
+	enriched bool
+	// metadataColumns are the metadata fields added to the values.
+	metadataColumns []changefeedbase.MetadataColumn
+	// fieldNamer, unless it is the identity, names the fields of the columns,
+	// whose names are cached in fieldNames.
+	fieldNamer changefeedbase.FieldNamer
+	fieldNames map[string]string
+	// diffColumns, if set, are the columns onto which the previous values of
+	// the rows are projected (see changefeedbase.OptDiffColumns).
+	diffColumns map[string]struct{}
+	// deletePayload, if set, overrides the value of deleted rows (see
+	// changefeedbase.OptDeletePayload).
+	deletePayload changefeedbase.DeletePayload
+	// canonical is set to write the documents in their canonical form (see
+	// changefeedbase.OptCanonicalJSON).
+	canonical bool
+	// operationField adds the operation which produced the rows to their
+	// metadata, which is placed at the top level of the values if
+	// topLevelMeta is set (see changefeedbase.OptRowMetadataConfig).
+	operationField, topLevelMeta bool
 
 	targets changefeedbase.Targets
 	buf     bytes.Buffer
@@ -41,13 +65,35 @@ func makeJSONEncoder(
 	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
 ) (*jsonEncoder, error) {
 	e := &jsonEncoder{
-		targets: targets,
-		keyOnly: opts.Envelope == changefeedbase.OptEnvelopeKeyOnly,
-		wrapped: opts.Envelope == changefeedbase.OptEnvelopeWrapped,
+		targets:  targets,
+		keyOnly:  opts.Envelope == changefeedbase.OptEnvelopeKeyOnly,
+		wrapped:  opts.Envelope == changefeedbase.OptEnvelopeWrapped,
+		debezium: opts.Envelope == changefeedbase.OptEnvelopeDebezium,
+		enriched: opts.Envelope == changefeedbase.OptEnvelopeEnriched,
+	}
+	e.metadataColumns = opts.MetadataColumns.Columns()
+	if e.fieldNamer = opts.GetFieldNamer(); !e.fieldNamer.IsIdentity() {
+		e.fieldNames = make(map[string]string)
 	}
 	e.updatedField = opts.UpdatedTimestamps
 	e.mvccTimestampField = opts.MVCCTimestamps
+	if opts.RowMetadata.Configured {
+		e.updatedField = opts.RowMetadata.Updated
+		e.mvccTimestampField = opts.RowMetadata.MVCCTimestamp
+		e.operationField = opts.RowMetadata.Operation
+		e.topLevelMeta = opts.RowMetadata.Location == changefeedbase.OptRowMetadataTopLevel
+	}
+	e.txnIDField = opts.EmitTxnID
+	e.securityLabelField = opts.SecurityLabelColumn != ""
 	e.beforeField = opts.Diff
+	if cols := opts.GetDiffColumns(); cols != nil {
+		e.diffColumns = make(map[string]struct{}, len(cols))
+		for _, col := range cols {
+			e.diffColumns[col] = struct{}{}
+		}
+	}
+	e.deletePayload = opts.DeletePayload
+	e.canonical = opts.CanonicalJSON
 	e.keyInValue = opts.KeyInValue
 	if e.keyInValue && !e.wrapped {
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
@@ -63,17 +109,20 @@ func makeJSONEncoder(
 
 // EncodeKey implements the Encoder interface.
 func (e *jsonEncoder) EncodeKey(_ context.Context, row cdcevent.Row) ([]byte, error) {
-	jsonEntries, err := e.encodeKeyRaw(row)
+	j, err := e.keyJSON(row)
 	if err != nil {
 		return nil, err
 	}
-	j, err := json.MakeJSON(jsonEntries)
+	return e.format(j)
+}
+
+// keyJSON returns the JSON document of the key of the row.
+func (e *jsonEncoder) keyJSON(row cdcevent.Row) (json.JSON, error) {
+	jsonEntries, err := e.encodeKeyRaw(row)
 	if err != nil {
 		return nil, err
 	}
-	e.buf.Reset()
-	j.Format(&e.buf)
-	return e.buf.Bytes(), nil
+	return json.MakeJSON(jsonEntries)
 }
 
 func (e *jsonEncoder) encodeKeyRaw(row cdcevent.Row) ([]interface{}, error) {
@@ -92,14 +141,33 @@ func (e *jsonEncoder) encodeKeyRaw(row cdcevent.Row) ([]interface{}, error) {
 	return jsonEntries, nil
 }
 
-func rowAsGoNative(row cdcevent.Row) (map[string]interface{}, error) {
+func (e *jsonEncoder) rowAsGoNative(row cdcevent.Row) (map[string]interface{}, error) {
+	return e.projectedRowAsGoNative(row, nil /* columns */)
+}
+
+// prevRowAsGoNative returns the previous values of the row, projected onto the
+// diff columns, if any.
+func (e *jsonEncoder) prevRowAsGoNative(row cdcevent.Row) (map[string]interface{}, error) {
+	return e.projectedRowAsGoNative(row, e.diffColumns)
+}
+
+// projectedRowAsGoNative returns the values of the columns of the row, or of
+// the specified columns only, if any.
+func (e *jsonEncoder) projectedRowAsGoNative(
+	row cdcevent.Row, columns map[string]struct{},
+) (map[string]interface{}, error) {
 	if !row.HasValues() || row.IsDeleted() {
 		return nil, nil
 	}
 
 	result := make(map[string]interface{})
 	if err := row.ForEachColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) (err error) {
-		result[col.Name], err = tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
+		if columns != nil {
+			if _, ok := columns[col.Name]; !ok {
+				return nil
+			}
+		}
+		result[e.fieldName(col.Name)], err = tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
 		return err
 	}); err != nil {
 		return nil, err
@@ -107,26 +175,157 @@ func rowAsGoNative(row cdcevent.Row) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// fieldName returns the name of the field of the column.
+func (e *jsonEncoder) fieldName(column string) string {
+	if e.fieldNames == nil {
+		return column
+	}
+	name, ok := e.fieldNames[column]
+	if !ok {
+		name = e.fieldNamer.Name(column)
+		e.fieldNames[column] = name
+	}
+	return name
+}
+
 // EncodeValue implements the Encoder interface.
 func (e *jsonEncoder) EncodeValue(
-	ctx context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+	_ context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
 ) ([]byte, error) {
-	if e.keyOnly || (!e.wrapped && updatedRow.IsDeleted()) {
+	j, err := e.valueJSON(evCtx, updatedRow, prevRow)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	return e.format(j)
+}
+
+// format writes the JSON document to the buffer of the encoder, which is only
+// valid until the next call.
+func (e *jsonEncoder) format(j json.JSON) ([]byte, error) {
+	e.buf.Reset()
+	if !e.canonical {
+		j.Format(&e.buf)
+		return e.buf.Bytes(), nil
+	}
+	if err := formatCanonicalJSON(&e.buf, j); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// formatCanonicalJSON writes the canonical form of the JSON document to buf
+// (see changefeedbase.OptCanonicalJSON). The keys of the objects of the
+// documents are already sorted by their bytes.
+func formatCanonicalJSON(buf *bytes.Buffer, j json.JSON) error {
+	switch j.Type() {
+	case json.NumberJSONType:
+		d, _ := j.AsDecimal()
+		if d.Form != apd.Finite {
+			// The non-finite numbers are written as strings.
+			j.Format(buf)
+			return nil
+		}
+		var reduced apd.Decimal
+		reduced.Reduce(d)
+		if reduced.IsZero() {
+			reduced.Negative = false
+		}
+		buf.WriteString(reduced.Text('f'))
+	case json.ArrayJSONType:
+		buf.WriteByte('[')
+		for i, n := 0, j.Len(); i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			elem, err := j.FetchValIdx(i)
+			if err != nil {
+				return err
+			}
+			if err := formatCanonicalJSON(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case json.ObjectJSONType:
+		it, err := j.ObjectIter()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('{')
+		for first := true; it.Next(); first = false {
+			if !first {
+				buf.WriteByte(',')
+			}
+			json.FromString(it.Key()).Format(buf)
+			buf.WriteByte(':')
+			if err := formatCanonicalJSON(buf, it.Value()); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		j.Format(buf)
+	}
+	return nil
+}
+
+// valueJSON returns the JSON document of the value of the row, which is nil if
+// the row has no value, such as the deleted rows of envelope=row.
+func (e *jsonEncoder) valueJSON(
+	evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+) (json.JSON, error) {
+	if e.keyOnly {
 		return nil, nil
 	}
+	if updatedRow.IsDeleted() {
+		switch {
+		case e.deletePayload == changefeedbase.OptDeletePayloadTombstone:
+			return nil, nil
+		case e.deletePayload == `` && !e.wrapped && !e.debezium && !e.enriched:
+			return nil, nil
+		}
+	}
 
-	after, err := rowAsGoNative(updatedRow)
+	after, err := e.rowAsGoNative(updatedRow)
 	if err != nil {
 		return nil, err
 	}
 
-	before, err := rowAsGoNative(prevRow)
+	before, err := e.prevRowAsGoNative(prevRow)
 	if err != nil {
 		return nil, err
 	}
 
 	var jsonEntries map[string]interface{}
-	if e.wrapped {
+	if e.debezium {
+		jsonEntries = map[string]interface{}{
+			`before`: nil,
+			`after`:  nil,
+			`source`: debeziumSource(evCtx, updatedRow),
+			`op`:     debeziumOp(evCtx, updatedRow, prevRow),
+			`ts_ms`:  debeziumTimestamp(evCtx),
+		}
+		if before != nil {
+			jsonEntries[`before`] = before
+		}
+		if after != nil {
+			jsonEntries[`after`] = after
+		}
+	} else if e.enriched {
+		jsonEntries = map[string]interface{}{
+			`before`:           nil,
+			`after`:            nil,
+			`op`:               operationOfRow(updatedRow, prevRow, true /* withDiff */),
+			`commit_timestamp`: evCtx.mvcc.AsOfSystemTime(),
+			`source`:           enrichedSource(evCtx, updatedRow),
+		}
+		if before != nil {
+			jsonEntries[`before`] = before
+		}
+		if after != nil {
+			jsonEntries[`after`] = after
+		}
+	} else if e.wrapped {
 		if after != nil {
 			jsonEntries = map[string]interface{}{`after`: after}
 		} else {
@@ -149,13 +348,21 @@ func (e *jsonEncoder) EncodeValue(
 		if e.topicInValue {
 			jsonEntries[`topic`] = evCtx.topic
 		}
+	} else if updatedRow.IsDeleted() {
+		// The deleted rows of envelope=row only have a value with
+		// OptDeletePayload.
+		jsonEntries, err = e.deletedRowAsGoNative(updatedRow, prevRow)
+		if err != nil || jsonEntries == nil {
+			return nil, err
+		}
 	} else {
 		jsonEntries = after
 	}
 
-	if e.updatedField || e.mvccTimestampField {
+	if e.updatedField || e.mvccTimestampField || e.txnIDField ||
+		len(e.metadataColumns) > 0 || e.operationField {
 		var meta map[string]interface{}
-		if e.wrapped {
+		if e.wrapped || e.debezium || e.enriched || e.topLevelMeta {
 			meta = jsonEntries
 		} else {
 			meta = make(map[string]interface{}, 1)
@@ -167,31 +374,74 @@ func (e *jsonEncoder) EncodeValue(
 		if e.mvccTimestampField {
 			meta[`mvcc_timestamp`] = evCtx.mvcc.AsOfSystemTime()
 		}
+		if e.operationField {
+			meta[`operation`] = operationOfRow(updatedRow, prevRow, e.beforeField)
+		}
+		if e.txnIDField {
+			// All the rows written by a transaction are committed at the same
+			// MVCC timestamp, which groups them together. Unrelated transactions
+			// may commit at the same timestamp too, so the value does not
+			// uniquely identify a transaction.
+			meta[`txn_id`] = evCtx.mvcc.AsOfSystemTime()
+		}
+		for _, col := range e.metadataColumns {
+			if meta[string(col)], err = metadataColumnValue(col, evCtx, updatedRow); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Unlike the other metadata, the security label is a top-level field
+	// regardless of the envelope.
+	if e.securityLabelField {
+		label := evCtx.securityLabel
+		if label == nil {
+			label = tree.DNull
+		}
+		jsonEntries[`security_label`], err = tree.AsJSON(label, sessiondatapb.DataConversionConfig{}, time.UTC)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	j, err := json.MakeJSON(jsonEntries)
-	if err != nil {
+	return json.MakeJSON(jsonEntries)
+}
+
+// deletedRowAsGoNative returns the value of the deleted row configured by
+// changefeedbase.OptDeletePayload: its previous values, or nil if they are
+// unknown, or its key columns.
+func (e *jsonEncoder) deletedRowAsGoNative(
+	updatedRow, prevRow cdcevent.Row,
+) (map[string]interface{}, error) {
+	if e.deletePayload == changefeedbase.OptDeletePayloadPriorRow {
+		return e.rowAsGoNative(prevRow)
+	}
+	result := make(map[string]interface{})
+	if err := updatedRow.ForEachKeyColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) (err error) {
+		result[e.fieldName(col.Name)], err = tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
+		return err
+	}); err != nil {
 		return nil, err
 	}
-	e.buf.Reset()
-	j.Format(&e.buf)
-	return e.buf.Bytes(), nil
+	return result, nil
 }
 
 // EncodeResolvedTimestamp implements the Encoder interface.
 func (e *jsonEncoder) EncodeResolvedTimestamp(
 	_ context.Context, _ string, resolved hlc.Timestamp,
 ) ([]byte, error) {
+	return gojson.Marshal(e.resolvedEntries(resolved))
+}
+
+// resolvedEntries returns the entries of the payload of the resolved
+// timestamp.
+func (e *jsonEncoder) resolvedEntries(resolved hlc.Timestamp) map[string]interface{} {
 	meta := map[string]interface{}{
 		`resolved`: eval.TimestampToDecimalDatum(resolved).Decimal.String(),
 	}
-	var jsonEntries interface{}
-	if e.wrapped {
-		jsonEntries = meta
-	} else {
-		jsonEntries = map[string]interface{}{
-			jsonMetaSentinel: meta,
-		}
+	if e.wrapped || e.debezium || e.enriched || e.topLevelMeta {
+		return meta
+	}
+	return map[string]interface{}{
+		jsonMetaSentinel: meta,
 	}
-	return gojson.Marshal(jsonEntries)
 }
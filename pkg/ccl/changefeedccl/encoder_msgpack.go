@@ -0,0 +1,224 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/errors"
+)
+
+// msgpackEncoder encodes changefeed entries as MessagePack
+// (https://msgpack.org). The keys and the values are those of the JSON
+// encoding of the rows, whose documents are transcoded to their MessagePack
+// counterparts: the integral numbers are encoded as integers and the other
+// numbers as 64-bit floats.
+type msgpackEncoder struct {
+	json *jsonEncoder
+	buf  []byte
+}
+
+var _ Encoder = &msgpackEncoder{}
+
+func newMsgpackEncoder(
+	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
+) (*msgpackEncoder, error) {
+	j, err := makeJSONEncoder(opts, targets)
+	if err != nil {
+		return nil, err
+	}
+	return &msgpackEncoder{json: j}, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *msgpackEncoder) EncodeKey(_ context.Context, row cdcevent.Row) ([]byte, error) {
+	j, err := e.json.keyJSON(row)
+	if err != nil {
+		return nil, err
+	}
+	return e.encode(j)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *msgpackEncoder) EncodeValue(
+	_ context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+) ([]byte, error) {
+	j, err := e.json.valueJSON(evCtx, updatedRow, prevRow)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	return e.encode(j)
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *msgpackEncoder) EncodeResolvedTimestamp(
+	_ context.Context, _ string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	j, err := json.MakeJSON(e.json.resolvedEntries(resolved))
+	if err != nil {
+		return nil, err
+	}
+	return e.encode(j)
+}
+
+// encode returns the MessagePack encoding of the JSON document, which is only
+// valid until the next call.
+func (e *msgpackEncoder) encode(j json.JSON) ([]byte, error) {
+	var err error
+	e.buf, err = appendMsgpack(e.buf[:0], j)
+	return e.buf, err
+}
+
+// The MessagePack type markers. See
+// https://github.com/msgpack/msgpack/blob/master/spec.md.
+const (
+	msgpackNil      = 0xc0
+	msgpackFalse    = 0xc2
+	msgpackTrue     = 0xc3
+	msgpackFloat64  = 0xcb
+	msgpackUint8    = 0xcc
+	msgpackUint16   = 0xcd
+	msgpackUint32   = 0xce
+	msgpackUint64   = 0xcf
+	msgpackInt8     = 0xd0
+	msgpackInt16    = 0xd1
+	msgpackInt32    = 0xd2
+	msgpackInt64    = 0xd3
+	msgpackFixStr   = 0xa0
+	msgpackStr8     = 0xd9
+	msgpackStr16    = 0xda
+	msgpackStr32    = 0xdb
+	msgpackFixArray = 0x90
+	msgpackArray16  = 0xdc
+	msgpackArray32  = 0xdd
+	msgpackFixMap   = 0x80
+	msgpackMap16    = 0xde
+	msgpackMap32    = 0xdf
+)
+
+// appendMsgpack appends the MessagePack encoding of the JSON document to buf.
+func appendMsgpack(buf []byte, j json.JSON) ([]byte, error) {
+	switch j.Type() {
+	case json.NullJSONType:
+		return append(buf, msgpackNil), nil
+	case json.FalseJSONType:
+		return append(buf, msgpackFalse), nil
+	case json.TrueJSONType:
+		return append(buf, msgpackTrue), nil
+	case json.NumberJSONType:
+		d, _ := j.AsDecimal()
+		if i, err := d.Int64(); err == nil {
+			return appendMsgpackInt(buf, i), nil
+		}
+		f, err := d.Float64()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, msgpackFloat64)
+		return encoding.EncodeUint64Ascending(buf, math.Float64bits(f)), nil
+	case json.StringJSONType:
+		s, err := j.AsText()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpackString(buf, *s), nil
+	case json.ArrayJSONType:
+		n := j.Len()
+		buf = appendMsgpackHeader(buf, n, msgpackFixArray, msgpackArray16, msgpackArray32)
+		for i := 0; i < n; i++ {
+			elem, err := j.FetchValIdx(i)
+			if err != nil {
+				return nil, err
+			}
+			if buf, err = appendMsgpack(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case json.ObjectJSONType:
+		it, err := j.ObjectIter()
+		if err != nil {
+			return nil, err
+		}
+		buf = appendMsgpackHeader(buf, j.Len(), msgpackFixMap, msgpackMap16, msgpackMap32)
+		for it.Next() {
+			buf = appendMsgpackString(buf, it.Key())
+			if buf, err = appendMsgpack(buf, it.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, errors.AssertionFailedf(`unknown JSON type: %v`, j.Type())
+	}
+}
+
+// appendMsgpackInt appends the most compact MessagePack encoding of the
+// integer to buf.
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		// Positive fixint.
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		// Negative fixint.
+		return append(buf, byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, msgpackUint8, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return append(buf, msgpackUint16, byte(i>>8), byte(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return encoding.EncodeUint32Ascending(append(buf, msgpackUint32), uint32(i))
+	case i >= 0:
+		return encoding.EncodeUint64Ascending(append(buf, msgpackUint64), uint64(i))
+	case i >= math.MinInt8:
+		return append(buf, msgpackInt8, byte(int8(i)))
+	case i >= math.MinInt16:
+		return append(buf, msgpackInt16, byte(i>>8), byte(i))
+	case i >= math.MinInt32:
+		return encoding.EncodeUint32Ascending(append(buf, msgpackInt32), uint32(int32(i)))
+	default:
+		return encoding.EncodeUint64Ascending(append(buf, msgpackInt64), uint64(i))
+	}
+}
+
+// appendMsgpackString appends the MessagePack encoding of the UTF-8 string to
+// buf.
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, msgpackFixStr|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, msgpackStr8, byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, msgpackStr16, byte(n>>8), byte(n))
+	default:
+		buf = encoding.EncodeUint32Ascending(append(buf, msgpackStr32), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+// appendMsgpackHeader appends the header of an array or a map of n elements to
+// buf. The fixed formats of both hold up to 15 elements.
+func appendMsgpackHeader(buf []byte, n int, fix, marker16, marker32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return append(buf, marker16, byte(n>>8), byte(n))
+	default:
+		return encoding.EncodeUint32Ascending(append(buf, marker32), uint32(n))
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/util/cache"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// confluentProtobufEncoder encodes changefeed entries as Protobuf messages,
+// whose definitions are derived from the table descriptors. Keys are the
+// primary key columns in a record. Values are all columns in a record, wrapped
+// in an envelope.
+//
+// If a schema registry is configured, the definitions are registered in it and
+// the messages are framed in the confluent wire format. Otherwise, the
+// messages are bare, and their definitions, which are deterministic for a
+// version of a table, are written to schema files alongside the output of the
+// sinks which write files, and logged otherwise.
+type confluentProtobufEncoder struct {
+	// schemaRegistry is nil if the definitions are not registered.
+	schemaRegistry schemaRegistry
+	// schemaFiles, if set, writes the definitions which are not registered.
+	schemaFiles SchemaFileSink
+
+	updatedField, beforeField, keyOnly bool
+	targets                            changefeedbase.Targets
+	// subjectNamer names the subjects of the registered definitions.
+	subjectNamer confluentSubjectNamer
+
+	keyCache   *cache.UnorderedCache // [tableIDAndVersion]confluentRegisteredProtobufKey
+	valueCache *cache.UnorderedCache // [tableIDAndVersionPair]confluentRegisteredProtobufEnvelope
+
+	// resolvedCache doesn't need to be bounded like the other caches because the number of topics
+	// is fixed per changefeed.
+	resolvedCache map[string]confluentRegisteredProtobufEnvelope
+}
+
+type confluentRegisteredProtobufKey struct {
+	message    *protobufRowMessage
+	registryID int32
+}
+
+type confluentRegisteredProtobufEnvelope struct {
+	message    *protobufEnvelopeMessage
+	registryID int32
+}
+
+var _ Encoder = &confluentProtobufEncoder{}
+var _ schemaFileEncoder = &confluentProtobufEncoder{}
+
+func newConfluentProtobufEncoder(
+	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
+) (*confluentProtobufEncoder, error) {
+	e := &confluentProtobufEncoder{
+		targets:      targets,
+		subjectNamer: makeConfluentSubjectNamer(opts),
+	}
+
+	switch opts.Envelope {
+	case changefeedbase.OptEnvelopeKeyOnly:
+		e.keyOnly = true
+	case changefeedbase.OptEnvelopeWrapped:
+	default:
+		return nil, errors.Errorf(`%s=%s is not supported with %s=%s`,
+			changefeedbase.OptEnvelope, opts.Envelope, changefeedbase.OptFormat, changefeedbase.OptFormatProtobuf)
+	}
+	e.updatedField = opts.UpdatedTimestamps
+	if e.updatedField && e.keyOnly {
+		return nil, errors.Errorf(`%s is only usable with %s=%s`,
+			changefeedbase.OptUpdatedTimestamps, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+	}
+	e.beforeField = opts.Diff
+	if e.beforeField && e.keyOnly {
+		return nil, errors.Errorf(`%s is only usable with %s=%s`,
+			changefeedbase.OptDiff, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+	}
+
+	if opts.KeyInValue {
+		return nil, errors.Errorf(`%s is not supported with %s=%s`,
+			changefeedbase.OptKeyInValue, changefeedbase.OptFormat, changefeedbase.OptFormatProtobuf)
+	}
+	if opts.TopicInValue {
+		return nil, errors.Errorf(`%s is not supported with %s=%s`,
+			changefeedbase.OptTopicInValue, changefeedbase.OptFormat, changefeedbase.OptFormatProtobuf)
+	}
+	if opts.AvroSchemaPrefix != `` {
+		return nil, errors.Errorf(`%s is not supported with %s=%s`,
+			changefeedbase.OptAvroSchemaPrefix, changefeedbase.OptFormat, changefeedbase.OptFormatProtobuf)
+	}
+	if len(opts.SchemaRegistryURI) != 0 {
+		reg, err := newConfluentSchemaRegistry(opts.SchemaRegistryURI)
+		if err != nil {
+			return nil, err
+		}
+		e.schemaRegistry = newPolicySchemaRegistry(reg, opts)
+	}
+
+	e.keyCache = cache.NewUnorderedCache(encoderCacheConfig)
+	e.valueCache = cache.NewUnorderedCache(encoderCacheConfig)
+	e.resolvedCache = make(map[string]confluentRegisteredProtobufEnvelope)
+	return e, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *confluentProtobufEncoder) EncodeKey(ctx context.Context, row cdcevent.Row) ([]byte, error) {
+	// No familyID in the cache key for keys because it's the same schema for all families
+	cacheKey := tableIDAndVersion{tableID: row.TableID, version: row.Version}
+
+	var registered confluentRegisteredProtobufKey
+	if v, ok := e.keyCache.Get(cacheKey); ok {
+		registered = v.(confluentRegisteredProtobufKey)
+	} else {
+		tableName, err := confluentRawTableName(e.targets, `` /* schemaPrefix */, row.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		registered.message, err = rowToProtobufMessage(SQLNameToAvroName(tableName), row.ForEachKeyColumn())
+		if err != nil {
+			return nil, err
+		}
+
+		registered.registryID, err = e.register(ctx, tableName, confluentSubjectSuffixKey,
+			registered.message.name, registered.message.Schema(), row.Version)
+		if err != nil {
+			return nil, err
+		}
+		e.keyCache.Add(cacheKey, registered)
+	}
+
+	return registered.message.appendRow(e.wireHeader(registered.registryID), row.ForEachKeyColumn())
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *confluentProtobufEncoder) EncodeValue(
+	ctx context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+) ([]byte, error) {
+	if e.keyOnly {
+		return nil, nil
+	}
+
+	var cacheKey tableIDAndVersionPair
+	if e.beforeField && prevRow.IsInitialized() {
+		cacheKey[0] = tableIDAndVersion{
+			tableID: prevRow.TableID, version: prevRow.Version, familyID: prevRow.FamilyID,
+		}
+	}
+	cacheKey[1] = tableIDAndVersion{
+		tableID: updatedRow.TableID, version: updatedRow.Version, familyID: updatedRow.FamilyID,
+	}
+
+	var registered confluentRegisteredProtobufEnvelope
+	if v, ok := e.valueCache.Get(cacheKey); ok {
+		registered = v.(confluentRegisteredProtobufEnvelope)
+	} else {
+		name, err := confluentRawTableName(e.targets, `` /* schemaPrefix */, updatedRow.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		after, err := rowToProtobufMessage(`Row`, updatedRow.ForEachColumn())
+		if err != nil {
+			return nil, err
+		}
+		envelope := &protobufEnvelopeMessage{
+			name:         SQLNameToAvroName(name),
+			after:        after,
+			updatedField: e.updatedField,
+		}
+		if e.beforeField {
+			// The previous row shares the message of the updated row unless it
+			// has another version of the table with other columns.
+			envelope.before = after
+			if prevRow.IsInitialized() {
+				before, err := rowToProtobufMessage(`BeforeRow`, prevRow.ForEachColumn())
+				if err != nil {
+					return nil, err
+				}
+				if !before.equal(after) {
+					envelope.before = before
+				}
+			}
+		}
+		registered.message = envelope
+
+		registered.registryID, err = e.register(ctx, name, confluentSubjectSuffixValue,
+			envelope.name, envelope.Schema(), updatedRow.Version)
+		if err != nil {
+			return nil, err
+		}
+		e.valueCache.Add(cacheKey, registered)
+	}
+
+	return registered.message.appendEnvelope(
+		e.wireHeader(registered.registryID), evCtx.updated, hlc.Timestamp{}, prevRow, updatedRow)
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *confluentProtobufEncoder) EncodeResolvedTimestamp(
+	ctx context.Context, topic string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	registered, ok := e.resolvedCache[topic]
+	if !ok {
+		registered.message = &protobufEnvelopeMessage{
+			name:          SQLNameToAvroName(topic),
+			resolvedField: true,
+		}
+
+		var err error
+		registered.registryID, err = e.register(ctx, topic, confluentSubjectSuffixValue,
+			registered.message.name, registered.message.Schema(), 0 /* version */)
+		if err != nil {
+			return nil, err
+		}
+		e.resolvedCache[topic] = registered
+	}
+	var nilRow cdcevent.Row
+	return registered.message.appendEnvelope(
+		e.wireHeader(registered.registryID), hlc.Timestamp{}, resolved, nilRow, nilRow)
+}
+
+// register registers the definition of the keys or values of the topic,
+// depending on the suffix, under its subject, if the encoder has a schema
+// registry, and returns the ID of the registered schema. Otherwise, the
+// definition is written to the schema file of the subject and of the version
+// of the table, or logged if the sink writes no files. The definitions have no
+// package, so the full name of their record is the name of their message.
+func (e *confluentProtobufEncoder) register(
+	ctx context.Context,
+	topic string,
+	suffix string,
+	message string,
+	schema string,
+	version descpb.DescriptorVersion,
+) (int32, error) {
+	subject := e.subjectNamer.subject(topic, suffix, message)
+	if e.schemaRegistry != nil {
+		return e.schemaRegistry.RegisterSchemaForSubject(ctx, subject, confluentSchemaTypeProtobuf, schema)
+	}
+	if e.schemaFiles == nil {
+		log.Infof(ctx, "protobuf definition of subject %s:\n%s", subject, schema)
+		return 0, nil
+	}
+	// The schema file is written by the encoder rather than by the changefeed,
+	// so the errors of the sink are marked as retryable here.
+	if err := e.schemaFiles.WriteSchemaFile(
+		ctx, protobufSchemaFileName(subject, version), []byte(schema),
+	); err != nil {
+		return 0, changefeedbase.MarkRetryableError(err)
+	}
+	return 0, nil
+}
+
+// protobufSchemaFileName returns the name of the schema file holding the
+// definition of a subject for a version of a table, which is
+// `<subject>-<version>.proto`, or `<subject>.proto` for the definitions of the
+// resolved timestamps, which do not depend on the tables.
+func protobufSchemaFileName(subject string, version descpb.DescriptorVersion) string {
+	if version == 0 {
+		return subject + `.proto`
+	}
+	return fmt.Sprintf(`%s-%d.proto`, subject, version)
+}
+
+// setSchemaFileSink implements the schemaFileEncoder interface.
+func (e *confluentProtobufEncoder) setSchemaFileSink(s SchemaFileSink) {
+	e.schemaFiles = s
+}
+
+// wireHeader returns the header of the messages encoded with the schema of
+// the specified ID, which is empty if the encoder has no schema registry.
+func (e *confluentProtobufEncoder) wireHeader(registryID int32) []byte {
+	if e.schemaRegistry == nil {
+		return nil
+	}
+	return protobufWireHeader(registryID)
+}
+
+// protobufWireHeader returns the header of the messages encoded with the
+// schema of the specified ID. Unlike avro, the protobuf wire format also
+// holds the indexes of the message in the registered definition; the
+// messages are always the first message of their definition, whose indexes
+// are encoded as a single 0.
+//
+//	https://docs.confluent.io/platform/current/schema-registry/serdes-develop/index.html#wire-format
+func protobufWireHeader(registryID int32) []byte {
+	header := []byte{
+		changefeedbase.ConfluentAvroWireFormatMagic,
+		0, 0, 0, 0, // Placeholder for the ID.
+		0, // The message indexes.
+	}
+	binary.BigEndian.PutUint32(header[1:5], uint32(registryID))
+	return header
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	gojson "encoding/json"
+	"text/template"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// templateRow is the data to which the templates of format=template are
+// applied. The values of the columns are nil for NULL, and otherwise formatted
+// as by EXPORT, such as `x,y` for the string 'x,y'.
+type templateRow struct {
+	// Key holds the values of the primary key columns of the row.
+	Key []interface{}
+	// After maps the columns of the row to their values, and is nil for the
+	// deleted rows. Before maps them to their previous values, and is nil
+	// unless changefeedbase.OptDiff is set and the row had previous values.
+	After, Before map[string]interface{}
+	// Op is the operation which produced the row: insert, update or delete,
+	// or upsert for the inserts and updates without changefeedbase.OptDiff.
+	Op string
+	// Table is the name of the table of the row, and Topic the topic to which
+	// it is emitted.
+	Table, Topic string
+	// Updated and MVCCTimestamp are the timestamps of the row, formatted as
+	// the updated and mvcc_timestamp fields of format=json.
+	Updated, MVCCTimestamp string
+}
+
+// templateFuncs are the functions available to the templates, besides the
+// predefined functions of text/template.
+var templateFuncs = template.FuncMap{
+	// json formats the value as JSON, such as "x,y" for the string x,y.
+	"json": func(v interface{}) (string, error) {
+		b, err := gojson.Marshal(v)
+		return string(b), err
+	},
+}
+
+// templateEncoder encodes the values of the rows with the template of
+// changefeedbase.OptValueTemplate, without a trailing newline: the sinks
+// delimit them. The keys and the resolved timestamps are encoded in JSON.
+type templateEncoder struct {
+	json     *jsonEncoder
+	tmpl     *template.Template
+	withDiff bool
+	buf      bytes.Buffer
+}
+
+var _ Encoder = &templateEncoder{}
+
+func newTemplateEncoder(
+	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
+) (*templateEncoder, error) {
+	j, err := makeJSONEncoder(opts, targets)
+	if err != nil {
+		return nil, err
+	}
+	// A field missing from a row is reported rather than rendered as "<no
+	// value>", since it usually is a misspelled column.
+	tmpl, err := template.New(changefeedbase.OptValueTemplate).
+		Funcs(templateFuncs).Option(`missingkey=error`).Parse(opts.ValueTemplate)
+	if err != nil {
+		return nil, errors.Wrapf(err, `invalid %s`, changefeedbase.OptValueTemplate)
+	}
+	return &templateEncoder{json: j, tmpl: tmpl, withDiff: opts.Diff}, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *templateEncoder) EncodeKey(ctx context.Context, row cdcevent.Row) ([]byte, error) {
+	return e.json.EncodeKey(ctx, row)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *templateEncoder) EncodeValue(
+	_ context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+) ([]byte, error) {
+	data := templateRow{
+		Op:            operationOfRow(updatedRow, prevRow, e.withDiff),
+		Table:         updatedRow.TableName,
+		Topic:         evCtx.topic,
+		Updated:       evCtx.updated.AsOfSystemTime(),
+		MVCCTimestamp: evCtx.mvcc.AsOfSystemTime(),
+	}
+	if err := updatedRow.ForEachKeyColumn().Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+		data.Key = append(data.Key, templateValue(d))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var err error
+	if data.After, err = templateColumns(updatedRow); err != nil {
+		return nil, err
+	}
+	if e.withDiff {
+		if data.Before, err = templateColumns(prevRow); err != nil {
+			return nil, err
+		}
+	}
+
+	e.buf.Reset()
+	if err := e.tmpl.Execute(&e.buf, data); err != nil {
+		return nil, errors.Wrapf(err, `rendering %s`, changefeedbase.OptValueTemplate)
+	}
+	return e.buf.Bytes(), nil
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *templateEncoder) EncodeResolvedTimestamp(
+	ctx context.Context, topic string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return e.json.EncodeResolvedTimestamp(ctx, topic, resolved)
+}
+
+// templateColumns maps the columns of the row to their values, and returns nil
+// if the row has no values.
+func templateColumns(row cdcevent.Row) (map[string]interface{}, error) {
+	if !row.HasValues() || row.IsDeleted() {
+		return nil, nil
+	}
+	columns := make(map[string]interface{})
+	if err := row.ForEachColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		columns[col.Name] = templateValue(d)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// templateValue returns the value of the datum available to the templates.
+func templateValue(d tree.Datum) interface{} {
+	if d == tree.DNull {
+		return nil
+	}
+	return tree.AsStringWithFlags(d, tree.FmtExport)
+}
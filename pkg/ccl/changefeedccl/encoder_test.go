@@ -12,10 +12,13 @@ import (
 	"context"
 	gosql "database/sql"
 	"fmt"
+	"math"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach-go/v2/crdb"
+	"github.com/cockroachdb/cockroach/pkg/build"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
@@ -27,9 +30,11 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/cockroach/pkg/workload/ledger"
 	"github.com/cockroachdb/cockroach/pkg/workload/workloadsql"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func TestEncoders(t *testing.T) {
@@ -261,6 +266,953 @@ func TestEncoders(t *testing.T) {
 	}
 }
 
+func TestDebeziumEncoders(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}
+	evCtx := eventContext{
+		updated: hlc.Timestamp{WallTime: 2 * int64(time.Millisecond)},
+		mvcc:    hlc.Timestamp{WallTime: int64(time.Millisecond), Logical: 2},
+	}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row, true)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	snapshotCtx := evCtx
+	snapshotCtx.snapshot = true
+	events := []struct {
+		name              string
+		evCtx             eventContext
+		updated, prevRow  cdcevent.Row
+		op, before, after string
+	}{
+		{name: `create`, evCtx: evCtx, updated: rowInsert, prevRow: noPrevRow, op: `c`},
+		{name: `update`, evCtx: evCtx, updated: rowInsert, prevRow: rowInsert, op: `u`},
+		{name: `delete`, evCtx: evCtx, updated: rowDelete, prevRow: rowInsert, op: `d`},
+		{name: `read`, evCtx: snapshotCtx, updated: rowInsert, prevRow: noPrevRow, op: `r`},
+	}
+
+	t.Run(`json`, func(t *testing.T) {
+		e, err := getEncoder(changefeedbase.EncodingOptions{
+			Format: changefeedbase.OptFormatJSON, Envelope: changefeedbase.OptEnvelopeDebezium,
+		}, targets)
+		require.NoError(t, err)
+		values := map[string]string{
+			`create`: `"after": {"a": 1, "b": "bar"}, "before": null`,
+			`update`: `"after": {"a": 1, "b": "bar"}, "before": {"a": 1, "b": "bar"}`,
+			`delete`: `"after": null, "before": {"a": 1, "b": "bar"}`,
+			`read`:   `"after": {"a": 1, "b": "bar"}, "before": null`,
+		}
+		for _, ev := range events {
+			value, err := e.EncodeValue(context.Background(), ev.evCtx, ev.updated, ev.prevRow)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf(`{%s, "op": "%s", "source": {"connector": "cockroachdb", `+
+				`"snapshot": "%t", "table": "foo", "ts_hlc": "1000000.0000000002", "ts_ms": 1, "version": "%s"}, "ts_ms": 2}`,
+				values[ev.name], ev.op, ev.evCtx.snapshot, build.BinaryVersion()), string(value), ev.name)
+		}
+	})
+
+	t.Run(`avro`, func(t *testing.T) {
+		reg := cdctest.StartTestSchemaRegistry()
+		defer reg.Close()
+		e, err := getEncoder(changefeedbase.EncodingOptions{
+			Format:            changefeedbase.OptFormatAvro,
+			Envelope:          changefeedbase.OptEnvelopeDebezium,
+			SchemaRegistryURI: reg.URL(),
+		}, targets)
+		require.NoError(t, err)
+		values := map[string]string{
+			`create`: `"after":{"foo":{"a":{"long":1},"b":{"string":"bar"}}},"before":null`,
+			`update`: `"after":{"foo":{"a":{"long":1},"b":{"string":"bar"}}},"before":{"foo_before":{"a":{"long":1},"b":{"string":"bar"}}}`,
+			`delete`: `"after":null,"before":{"foo_before":{"a":{"long":1},"b":{"string":"bar"}}}`,
+			`read`:   `"after":{"foo":{"a":{"long":1},"b":{"string":"bar"}}},"before":null`,
+		}
+		for _, ev := range events {
+			value, err := e.EncodeValue(context.Background(), ev.evCtx, ev.updated, ev.prevRow)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf(`{%s,"op":{"string":"%s"},"source":{"cockroachdb.Source":{`+
+				`"connector":{"string":"cockroachdb"},"snapshot":{"string":"%t"},"table":{"string":"foo"},`+
+				`"ts_hlc":{"string":"1000000.0000000002"},"ts_ms":{"long":1},"version":{"string":"%s"}}},"ts_ms":{"long":2}}`,
+				values[ev.name], ev.op, ev.evCtx.snapshot, build.BinaryVersion()), string(avroToJSON(t, reg, value)), ev.name)
+		}
+
+		// The envelopes are named like those of Debezium, under the subjects
+		// named after the topics.
+		schema := reg.SchemaForSubject(`foo` + confluentSubjectSuffixValue)
+		require.Contains(t, schema, `"name":"Envelope"`)
+		require.Contains(t, schema, `"namespace":"foo"`)
+	})
+}
+
+func TestCloudEventsEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}
+	evCtx := eventContext{
+		updated: hlc.Timestamp{WallTime: 2 * int64(time.Millisecond)},
+		mvcc:    hlc.Timestamp{WallTime: int64(time.Millisecond), Logical: 2},
+	}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row, true)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	const attributes = `"specversion":"1.0","id":"1000000.0000000002:[1]","source":"/cockroachdb/foo",` +
+		`"type":"com.cockroachlabs.changefeed.row.%s","time":"1970-01-01T00:00:00.001Z",` +
+		`"datacontenttype":"application/json"`
+
+	t.Run(`structured`, func(t *testing.T) {
+		e, err := getEncoder(changefeedbase.EncodingOptions{
+			Format: changefeedbase.OptFormatCloudEvents, Envelope: changefeedbase.OptEnvelopeWrapped, Diff: true,
+		}, targets)
+		require.NoError(t, err)
+		for _, ev := range []struct {
+			updated, prevRow cdcevent.Row
+			op, data         string
+		}{
+			{rowInsert, noPrevRow, `insert`, `{"after":{"a":1,"b":"bar"},"before":null}`},
+			{rowInsert, rowInsert, `update`, `{"after":{"a":1,"b":"bar"},"before":{"a":1,"b":"bar"}}`},
+			{rowDelete, rowInsert, `delete`, `{"after":null,"before":{"a":1,"b":"bar"}}`},
+		} {
+			value, err := e.EncodeValue(context.Background(), evCtx, ev.updated, ev.prevRow)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf(`{`+attributes+`,"data":%s}`, ev.op, ev.data), string(value), ev.op)
+		}
+
+		resolved, err := e.EncodeResolvedTimestamp(context.Background(), ``, evCtx.mvcc)
+		require.NoError(t, err)
+		require.Equal(t, `{"specversion":"1.0","id":"1000000.0000000002","source":"/cockroachdb/changefeed",`+
+			`"type":"com.cockroachlabs.changefeed.resolved","time":"1970-01-01T00:00:00.001Z",`+
+			`"datacontenttype":"application/json","data":{"resolved":"1000000.0000000002"}}`, string(resolved))
+	})
+
+	t.Run(`binary`, func(t *testing.T) {
+		e, err := getEncoder(changefeedbase.EncodingOptions{
+			Format:          changefeedbase.OptFormatCloudEvents,
+			Envelope:        changefeedbase.OptEnvelopeRow,
+			CloudEventsMode: changefeedbase.OptCloudEventsModeBinary,
+		}, targets)
+		require.NoError(t, err)
+		// The values only hold the data of the events, whose attributes are
+		// attached as headers.
+		value, err := e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+		require.NoError(t, err)
+		require.Equal(t, `{"a": 1, "b": "bar"}`, string(value))
+
+		key, err := e.EncodeKey(context.Background(), rowInsert)
+		require.NoError(t, err)
+		var headers []string
+		for _, h := range makeCloudEvent(evCtx, rowInsert, noPrevRow, key, false /* withDiff */).headers() {
+			headers = append(headers, h.key+`=`+string(h.value))
+		}
+		require.Equal(t, []string{
+			`ce_specversion=1.0`,
+			`ce_id=1000000.0000000002:[1]`,
+			`ce_source=/cockroachdb/foo`,
+			`ce_type=com.cockroachlabs.changefeed.row.upsert`,
+			`ce_time=1970-01-01T00:00:00.001Z`,
+			`content-type=application/json`,
+		}, headers)
+	})
+}
+
+func TestCSVEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c INT)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`x,y`)},
+		rowenc.EncDatum{Datum: tree.DNull},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row[:1], true)
+	target := changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	}
+	topic, err := makeTopicDescriptorFromSpec(target, rowInsert.EventDescriptor)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name                    string
+		opts                    changefeedbase.CSVOptions
+		header, insert, deleted string
+	}{
+		{
+			name:   `initial scan only`,
+			opts:   changefeedbase.CSVOptions{Delimiter: ',', Quote: changefeedbase.OptCSVQuoteMinimal, Null: `NULL`},
+			header: `a,b,c`,
+			insert: `1,"'x,y'",NULL`,
+		},
+		{
+			name: `minimal`,
+			opts: changefeedbase.CSVOptions{
+				Delimiter: ',', Quote: changefeedbase.OptCSVQuoteMinimal, Null: `1`, DeletedColumn: true,
+			},
+			header:  `a,b,c,_crdb_deleted`,
+			insert:  `"1","'x,y'",1,false`,
+			deleted: `"1",1,1,true`,
+		},
+		{
+			name: `all`,
+			opts: changefeedbase.CSVOptions{
+				Delimiter: '|', Quote: changefeedbase.OptCSVQuoteAll, Null: ``, DeletedColumn: true,
+			},
+			header:  `"a"|"b"|"c"|"_crdb_deleted"`,
+			insert:  `"1"|"'x,y'"||"false"`,
+			deleted: `"1"|||"true"`,
+		},
+		{
+			name: `none`,
+			opts: changefeedbase.CSVOptions{
+				Delimiter: ',', Quote: changefeedbase.OptCSVQuoteNone, Null: `\N`, DeletedColumn: true,
+			},
+			header:  `a,b,c,_crdb_deleted`,
+			insert:  `1,'x,y',\N,false`,
+			deleted: `1,\N,\N,true`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := getEncoder(changefeedbase.EncodingOptions{
+				Format: changefeedbase.OptFormatCSV, CSV: test.opts,
+			}, changefeedbase.Targets{})
+			require.NoError(t, err)
+			value, err := e.EncodeValue(context.Background(), eventContext{}, rowInsert, cdcevent.Row{})
+			require.NoError(t, err)
+			require.Equal(t, test.insert, string(value))
+			value, err = e.EncodeValue(context.Background(), eventContext{}, rowDelete, cdcevent.Row{})
+			if test.deleted == `` {
+				require.EqualError(t, err, `cannot encode deleted rows into CSV format`)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.deleted, string(value))
+			}
+
+			header, err := makeCSVHeaders(changefeedbase.EncodingOptions{CSV: test.opts}).headerOf(topic)
+			require.NoError(t, err)
+			require.Equal(t, test.header, string(header))
+		})
+	}
+}
+
+func TestMsgpackEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c FLOAT)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`x`)},
+		rowenc.EncDatum{Datum: tree.NewDFloat(1.5)},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+
+	e, err := getEncoder(changefeedbase.EncodingOptions{
+		Format: changefeedbase.OptFormatMsgpack, Envelope: changefeedbase.OptEnvelopeWrapped,
+	}, changefeedbase.Targets{})
+	require.NoError(t, err)
+	key, err := e.EncodeKey(context.Background(), rowInsert)
+	require.NoError(t, err)
+	// [1]
+	require.Equal(t, []byte{0x91, 0x01}, key)
+	value, err := e.EncodeValue(context.Background(), eventContext{}, rowInsert, cdcevent.Row{})
+	require.NoError(t, err)
+	// {"after": {"a": 1, "b": "x", "c": 1.5}}
+	require.Equal(t, []byte{
+		0x81, 0xa5, 'a', 'f', 't', 'e', 'r',
+		0x83, 0xa1, 'a', 0x01, 0xa1, 'b', 0xa1, 'x',
+		0xa1, 'c', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+	}, value)
+	resolved, err := e.EncodeResolvedTimestamp(context.Background(), `foo`, hlc.Timestamp{WallTime: 1})
+	require.NoError(t, err)
+	// {"resolved": "1.0000000000"}
+	require.Equal(t, append([]byte{0x81, 0xa8}, "resolved\xac1.0000000000"...), resolved)
+
+	for _, test := range []struct {
+		i        int64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0xcc, 0x80}},
+		{300, []byte{0xcd, 0x01, 0x2c}},
+		{1 << 32, []byte{0xcf, 0, 0, 0, 0x01, 0, 0, 0, 0}},
+		{-1, []byte{0xff}},
+		{-32, []byte{0xe0}},
+		{-33, []byte{0xd0, 0xdf}},
+		{-129, []byte{0xd1, 0xff, 0x7f}},
+		{-1 << 31, []byte{0xd2, 0x80, 0, 0, 0}},
+	} {
+		require.Equal(t, test.expected, appendMsgpackInt(nil, test.i), "%d", test.i)
+	}
+}
+
+func TestXMLEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(
+		`CREATE TABLE foo (a INT PRIMARY KEY, "b c" STRING, d FLOAT, e JSONB, f BOOL)`)
+	require.NoError(t, err)
+	e, err := tree.ParseDJSON(`{"xmlns": [1, "<&>"], "": {"_x": null}}`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString("x\ny")},
+		rowenc.EncDatum{Datum: tree.DNull},
+		rowenc.EncDatum{Datum: e},
+		rowenc.EncDatum{Datum: tree.DBoolTrue},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row[:1], true)
+
+	enc, err := getEncoder(changefeedbase.EncodingOptions{
+		Format: changefeedbase.OptFormatXML, Envelope: changefeedbase.OptEnvelopeWrapped,
+	}, changefeedbase.Targets{})
+	require.NoError(t, err)
+	key, err := enc.EncodeKey(context.Background(), rowInsert)
+	require.NoError(t, err)
+	require.Equal(t, `<key><item>1</item></key>`, string(key))
+	value, err := enc.EncodeValue(context.Background(), eventContext{}, rowInsert, cdcevent.Row{})
+	require.NoError(t, err)
+	require.Equal(t, `<row><after><a>1</a><b_x0020_c>x&#xA;y</b_x0020_c><d null="true"/>`+
+		`<e><_><_x005F_x null="true"/></_><_x0078_mlns><item>1</item><item>&lt;&amp;&gt;</item></_x0078_mlns></e>`+
+		`<f>true</f></after></row>`, string(value))
+	value, err = enc.EncodeValue(context.Background(), eventContext{}, rowDelete, cdcevent.Row{})
+	require.NoError(t, err)
+	require.Equal(t, `<row><after null="true"/></row>`, string(value))
+	resolved, err := enc.EncodeResolvedTimestamp(context.Background(), `foo`, hlc.Timestamp{WallTime: 1})
+	require.NoError(t, err)
+	require.Equal(t, `<resolved>1.0000000000</resolved>`, string(resolved))
+
+	for field, name := range map[string]string{
+		`a`:       `a`,
+		`_a_b`:    `_a_b`,
+		`a.b-1`:   `a.b-1`,
+		`1a`:      `_x0031_a`,
+		`a:b`:     `a_x003A_b`,
+		`_x0031_`: `_x005F_x0031_`,
+		`XmlA`:    `_x0058_mlA`,
+		`été`:     `été`,
+		`a😀`:      `a_x0001F600_`,
+	} {
+		require.Equal(t, name, xmlName(field), field)
+	}
+}
+
+func TestTemplateEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c INT)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`x,y`)},
+		rowenc.EncDatum{Datum: tree.DNull},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row[:1], true)
+	prevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`z`)},
+		rowenc.EncDatum{Datum: tree.NewDInt(2)},
+	}, false)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{
+		updated: hlc.Timestamp{WallTime: 1}, mvcc: hlc.Timestamp{WallTime: 2}, topic: `foo`,
+	}
+
+	const tmpl = `{{.Op}} {{.Table}}/{{.Topic}}@{{.Updated}} {{index .Key 0}}` +
+		`{{with .After}} b={{json .b}}{{if .c}} c={{.c}}{{end}}{{end}}` +
+		`{{with .Before}} was b={{.b}} c={{.c}}{{end}}`
+	for _, test := range []struct {
+		name             string
+		diff             bool
+		updated, prevRow cdcevent.Row
+		value            string
+	}{
+		{
+			name:    `upsert`,
+			updated: rowInsert,
+			prevRow: noPrevRow,
+			value:   `upsert foo/foo@1.0000000000 1 b="x,y"`,
+		},
+		{
+			name:    `insert`,
+			diff:    true,
+			updated: rowInsert,
+			prevRow: noPrevRow,
+			value:   `insert foo/foo@1.0000000000 1 b="x,y"`,
+		},
+		{
+			name:    `update`,
+			diff:    true,
+			updated: rowInsert,
+			prevRow: prevRow,
+			value:   `update foo/foo@1.0000000000 1 b="x,y" was b=z c=2`,
+		},
+		{
+			name:    `delete`,
+			diff:    true,
+			updated: rowDelete,
+			prevRow: prevRow,
+			value:   `delete foo/foo@1.0000000000 1 was b=z c=2`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := getEncoder(changefeedbase.EncodingOptions{
+				Format:        changefeedbase.OptFormatTemplate,
+				Envelope:      changefeedbase.OptEnvelopeWrapped,
+				Diff:          test.diff,
+				ValueTemplate: tmpl,
+			}, changefeedbase.Targets{})
+			require.NoError(t, err)
+			key, err := e.EncodeKey(context.Background(), test.updated)
+			require.NoError(t, err)
+			require.Equal(t, `[1]`, string(key))
+			value, err := e.EncodeValue(context.Background(), evCtx, test.updated, test.prevRow)
+			require.NoError(t, err)
+			require.Equal(t, test.value, string(value))
+		})
+	}
+
+	_, err = getEncoder(changefeedbase.EncodingOptions{
+		Format:        changefeedbase.OptFormatTemplate,
+		Envelope:      changefeedbase.OptEnvelopeWrapped,
+		ValueTemplate: `{{.After.a`,
+	}, changefeedbase.Targets{})
+	require.True(t, testutils.IsError(err, `invalid value_template`), err)
+
+	e, err := getEncoder(changefeedbase.EncodingOptions{
+		Format:        changefeedbase.OptFormatTemplate,
+		Envelope:      changefeedbase.OptEnvelopeWrapped,
+		ValueTemplate: `{{.After.d}}`,
+	}, changefeedbase.Targets{})
+	require.NoError(t, err)
+	_, err = e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+	require.True(t, testutils.IsError(err, `map has no entry for key "d"`), err)
+}
+
+func TestEnrichedEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}
+	clusterID := uuid.MakeV4()
+	evCtx := eventContext{
+		updated: hlc.Timestamp{WallTime: 2},
+		mvcc:    hlc.Timestamp{WallTime: 1, Logical: 2},
+		origin:  eventOrigin{clusterID: clusterID, nodeID: 3, jobID: 4},
+	}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+	e, err := getEncoder(changefeedbase.EncodingOptions{
+		Format: changefeedbase.OptFormatJSON, Envelope: changefeedbase.OptEnvelopeEnriched,
+	}, targets)
+	require.NoError(t, err)
+
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row, true)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	snapshotCtx := evCtx
+	snapshotCtx.snapshot = true
+	for _, ev := range []struct {
+		name             string
+		evCtx            eventContext
+		updated, prevRow cdcevent.Row
+		expected         string
+	}{
+		{
+			name: `insert`, evCtx: evCtx, updated: rowInsert, prevRow: noPrevRow,
+			expected: `"after": {"a": 1, "b": "bar"}, "before": null, "commit_timestamp": "1.0000000002", "op": "insert"`,
+		},
+		{
+			name: `update`, evCtx: evCtx, updated: rowInsert, prevRow: rowInsert,
+			expected: `"after": {"a": 1, "b": "bar"}, "before": {"a": 1, "b": "bar"}, "commit_timestamp": "1.0000000002", "op": "update"`,
+		},
+		{
+			name: `delete`, evCtx: evCtx, updated: rowDelete, prevRow: rowInsert,
+			expected: `"after": null, "before": {"a": 1, "b": "bar"}, "commit_timestamp": "1.0000000002", "op": "delete"`,
+		},
+		{
+			name: `scan`, evCtx: snapshotCtx, updated: rowInsert, prevRow: noPrevRow,
+			expected: `"after": {"a": 1, "b": "bar"}, "before": null, "commit_timestamp": "1.0000000002", "op": "insert"`,
+		},
+	} {
+		value, err := e.EncodeValue(context.Background(), ev.evCtx, ev.updated, ev.prevRow)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(`{%s, "source": {"cluster_id": "%s", "job_id": 4, "node_id": 3, `+
+			`"snapshot": %t, "table": "foo"}}`, ev.expected, clusterID, ev.evCtx.snapshot), string(value), ev.name)
+	}
+
+	resolved, err := e.EncodeResolvedTimestamp(context.Background(), `foo`, hlc.Timestamp{WallTime: 5})
+	require.NoError(t, err)
+	require.Equal(t, `{"resolved":"5.0000000000"}`, string(resolved))
+}
+
+func TestMetadataColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{
+		updated:       hlc.Timestamp{WallTime: 2},
+		mvcc:          hlc.Timestamp{WallTime: 1, Logical: 2},
+		statementTime: hlc.Timestamp{WallTime: 3},
+		origin:        eventOrigin{nodeID: 4, jobID: 5},
+	}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+	opts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptMetadataColumns: `mvcc_timestamp,statement_time,table_id,job_id,node_id`,
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	metadata := func(format string) string {
+		return fmt.Sprintf(format, tableDesc.GetID())
+	}
+
+	for _, test := range []struct {
+		format   changefeedbase.FormatType
+		envelope changefeedbase.EnvelopeType
+		expected string
+	}{
+		{
+			format: changefeedbase.OptFormatJSON, envelope: changefeedbase.OptEnvelopeWrapped,
+			expected: metadata(`{"after": {"a": 1, "b": "bar"}, "job_id": 5, "mvcc_timestamp": "1.0000000002", ` +
+				`"node_id": 4, "statement_time": "3.0000000000", "table_id": %d}`),
+		},
+		{
+			format: changefeedbase.OptFormatJSON, envelope: changefeedbase.OptEnvelopeRow,
+			expected: metadata(`{"__crdb__": {"job_id": 5, "mvcc_timestamp": "1.0000000002", ` +
+				`"node_id": 4, "statement_time": "3.0000000000", "table_id": %d}, "a": 1, "b": "bar"}`),
+		},
+		{
+			format: changefeedbase.OptFormatCSV, envelope: changefeedbase.OptEnvelopeWrapped,
+			expected: metadata(`1,bar,1.0000000002,3.0000000000,%d,5,4`),
+		},
+	} {
+		t.Run(fmt.Sprintf(`%s/%s`, test.format, test.envelope), func(t *testing.T) {
+			o := opts
+			o.Format, o.Envelope = test.format, test.envelope
+			o.CSV = changefeedbase.CSVOptions{Delimiter: ',', Quote: changefeedbase.OptCSVQuoteMinimal, Null: `NULL`}
+			e, err := getEncoder(o, targets)
+			require.NoError(t, err)
+			value, err := e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, string(value))
+		})
+	}
+
+	t.Run(`avro`, func(t *testing.T) {
+		reg := cdctest.StartTestSchemaRegistry()
+		defer reg.Close()
+		o := opts
+		o.Format, o.SchemaRegistryURI = changefeedbase.OptFormatAvro, reg.URL()
+		e, err := getEncoder(o, targets)
+		require.NoError(t, err)
+		value, err := e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+		require.NoError(t, err)
+		require.Equal(t, metadata(`{"after":{"foo":{"a":{"long":1},"b":{"string":"bar"}}},`+
+			`"job_id":{"long":5},"mvcc_timestamp":{"string":"1.0000000002"},"node_id":{"long":4},`+
+			`"statement_time":{"string":"3.0000000000"},"table_id":{"long":%d}}`), string(avroToJSON(t, reg, value)))
+	})
+}
+
+func TestFieldNames(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (user_id INT PRIMARY KEY, first_name STRING, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+		rowenc.EncDatum{Datum: tree.NewDString(`baz`)},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{updated: hlc.Timestamp{WallTime: 1}}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+	// The metadata fields are not renamed.
+	opts := changefeedbase.EncodingOptions{
+		Envelope:          changefeedbase.OptEnvelopeWrapped,
+		UpdatedTimestamps: true,
+		FieldNameMapping:  `b:Other`,
+		FieldNameCase:     changefeedbase.OptFieldNameCaseCamel,
+	}
+
+	t.Run(`json`, func(t *testing.T) {
+		o := opts
+		o.Format = changefeedbase.OptFormatJSON
+		e, err := getEncoder(o, targets)
+		require.NoError(t, err)
+		value, err := e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+		require.NoError(t, err)
+		require.Equal(t, `{"after": {"Other": "baz", "firstName": "bar", "userId": 1}, `+
+			`"updated": "1.0000000000"}`, string(value))
+	})
+
+	t.Run(`avro`, func(t *testing.T) {
+		reg := cdctest.StartTestSchemaRegistry()
+		defer reg.Close()
+		o := opts
+		o.Format, o.SchemaRegistryURI = changefeedbase.OptFormatAvro, reg.URL()
+		e, err := getEncoder(o, targets)
+		require.NoError(t, err)
+		key, err := e.EncodeKey(context.Background(), rowInsert)
+		require.NoError(t, err)
+		require.Equal(t, `{"userId":{"long":1}}`, string(avroToJSON(t, reg, key)))
+		value, err := e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+		require.NoError(t, err)
+		require.Equal(t, `{"after":{"foo":{"Other":{"string":"baz"},"firstName":{"string":"bar"},`+
+			`"userId":{"long":1}}},"updated":{"string":"1.0000000000"}}`, string(avroToJSON(t, reg, value)))
+	})
+}
+
+func TestRowMetadataConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{updated: hlc.Timestamp{WallTime: 1}, mvcc: hlc.Timestamp{WallTime: 2}}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+
+	for _, test := range []struct {
+		config, value, resolved string
+	}{
+		{
+			config:   `{"Fields": []}`,
+			value:    `{"a": 1, "b": "bar"}`,
+			resolved: `{"__crdb__":{"resolved":"3.0000000000"}}`,
+		},
+		{
+			config:   `{"Fields": ["updated", "mvcc_timestamp", "operation"]}`,
+			value:    `{"__crdb__": {"mvcc_timestamp": "2.0000000000", "operation": "insert", "updated": "1.0000000000"}, "a": 1, "b": "bar"}`,
+			resolved: `{"__crdb__":{"resolved":"3.0000000000"}}`,
+		},
+		{
+			config:   `{"Fields": ["operation"], "Location": "top_level"}`,
+			value:    `{"a": 1, "b": "bar", "operation": "insert"}`,
+			resolved: `{"resolved":"3.0000000000"}`,
+		},
+	} {
+		t.Run(test.config, func(t *testing.T) {
+			opts, err := changefeedbase.MakeStatementOptions(map[string]string{
+				changefeedbase.OptEnvelope:          string(changefeedbase.OptEnvelopeRow),
+				changefeedbase.OptDiff:              ``,
+				changefeedbase.OptRowMetadataConfig: test.config,
+			}).GetEncodingOptions()
+			require.NoError(t, err)
+			e, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+			value, err := e.EncodeValue(context.Background(), evCtx, rowInsert, noPrevRow)
+			require.NoError(t, err)
+			require.Equal(t, test.value, string(value))
+			resolved, err := e.EncodeResolvedTimestamp(context.Background(), `foo`, hlc.Timestamp{WallTime: 3})
+			require.NoError(t, err)
+			require.Equal(t, test.resolved, string(resolved))
+		})
+	}
+}
+
+func TestDiffColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c STRING)`)
+	require.NoError(t, err)
+	rowUpdate := cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`new`)},
+		rowenc.EncDatum{Datum: tree.NewDString(`wide`)},
+	}, false)
+	prevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`old`)},
+		rowenc.EncDatum{Datum: tree.NewDString(`wide`)},
+	}, false)
+	evCtx := eventContext{updated: hlc.Timestamp{WallTime: 1}}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+
+	for _, test := range []struct {
+		name  string
+		opts  map[string]string
+		value string
+	}{
+		{
+			name: `all`,
+			opts: map[string]string{changefeedbase.OptDiff: ``},
+			value: `{"after": {"a": 1, "b": "new", "c": "wide"}, ` +
+				`"before": {"a": 1, "b": "old", "c": "wide"}}`,
+		},
+		{
+			name: `projected`,
+			opts: map[string]string{changefeedbase.OptDiff: ``, changefeedbase.OptDiffColumns: `b`},
+			value: `{"after": {"a": 1, "b": "new", "c": "wide"}, ` +
+				`"before": {"b": "old"}}`,
+		},
+		{
+			name: `renamed`,
+			opts: map[string]string{
+				changefeedbase.OptDiff: ``, changefeedbase.OptDiffColumns: `b`,
+				changefeedbase.OptFieldNameCase: string(changefeedbase.OptFieldNameCasePascal),
+			},
+			value: `{"after": {"A": 1, "B": "new", "C": "wide"}, ` +
+				`"before": {"B": "old"}}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := changefeedbase.MakeStatementOptions(test.opts).GetEncodingOptions()
+			require.NoError(t, err)
+			e, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+			value, err := e.EncodeValue(context.Background(), evCtx, rowUpdate, prevRow)
+			require.NoError(t, err)
+			require.Equal(t, test.value, string(value))
+		})
+	}
+}
+
+func TestDeletePayload(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.DNull},
+	}, true)
+	prevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}, false)
+	noPrevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{updated: hlc.Timestamp{WallTime: 1}}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+
+	for _, test := range []struct {
+		name    string
+		opts    map[string]string
+		prevRow cdcevent.Row
+		value   string
+	}{
+		{
+			name:    `wrapped`,
+			opts:    map[string]string{},
+			prevRow: noPrevRow,
+			value:   `{"after": null}`,
+		},
+		{
+			name:    `wrapped tombstone`,
+			opts:    map[string]string{changefeedbase.OptDeletePayload: `tombstone`},
+			prevRow: noPrevRow,
+		},
+		{
+			name: `debezium tombstone`,
+			opts: map[string]string{
+				changefeedbase.OptEnvelope:      string(changefeedbase.OptEnvelopeDebezium),
+				changefeedbase.OptDeletePayload: `tombstone`,
+			},
+			prevRow: prevRow,
+		},
+		{
+			name:    `row`,
+			opts:    map[string]string{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeRow)},
+			prevRow: noPrevRow,
+		},
+		{
+			name: `row prior_row`,
+			opts: map[string]string{
+				changefeedbase.OptEnvelope:      string(changefeedbase.OptEnvelopeRow),
+				changefeedbase.OptDiff:          ``,
+				changefeedbase.OptDeletePayload: `prior_row`,
+			},
+			prevRow: prevRow,
+			value:   `{"a": 1, "b": "bar"}`,
+		},
+		{
+			name: `row prior_row unknown`,
+			opts: map[string]string{
+				changefeedbase.OptEnvelope:      string(changefeedbase.OptEnvelopeRow),
+				changefeedbase.OptDiff:          ``,
+				changefeedbase.OptDeletePayload: `prior_row`,
+			},
+			prevRow: noPrevRow,
+		},
+		{
+			name: `row key_columns`,
+			opts: map[string]string{
+				changefeedbase.OptEnvelope:          string(changefeedbase.OptEnvelopeRow),
+				changefeedbase.OptDeletePayload:     `key_columns`,
+				changefeedbase.OptRowMetadataConfig: `{"Fields": ["operation"]}`,
+			},
+			prevRow: noPrevRow,
+			value:   `{"__crdb__": {"operation": "delete"}, "a": 1}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := changefeedbase.MakeStatementOptions(test.opts).GetEncodingOptions()
+			require.NoError(t, err)
+			e, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+			value, err := e.EncodeValue(context.Background(), evCtx, rowDelete, test.prevRow)
+			require.NoError(t, err)
+			require.Equal(t, test.value, string(value))
+		})
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(
+		`CREATE TABLE foo (a INT PRIMARY KEY, b DECIMAL, c FLOAT, d FLOAT, e JSONB)`)
+	require.NoError(t, err)
+	b, err := tree.ParseDDecimal(`1.50`)
+	require.NoError(t, err)
+	e, err := tree.ParseDJSON(`{"z": [1.0, -0, 2.5e1], "a": {"y": null, "x": "x y"}}`)
+	require.NoError(t, err)
+	row := cdcevent.TestingMakeEventRow(tableDesc, 0, rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: b},
+		rowenc.EncDatum{Datum: tree.NewDFloat(1e21)},
+		rowenc.EncDatum{Datum: tree.NewDFloat(tree.DFloat(math.NaN()))},
+		rowenc.EncDatum{Datum: e},
+	}, false)
+	prevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{updated: hlc.Timestamp{WallTime: 1}}
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+
+	for _, test := range []struct {
+		name  string
+		opts  map[string]string
+		key   string
+		value string
+	}{
+		{
+			name: `default`,
+			opts: map[string]string{},
+			key:  `[1]`,
+			value: `{"after": {"a": 1, "b": 1.50, "c": 1E+21, "d": "NaN", ` +
+				`"e": {"a": {"x": "x y", "y": null}, "z": [1.0, -0, 2.5E+1]}}}`,
+		},
+		{
+			name: `canonical`,
+			opts: map[string]string{changefeedbase.OptCanonicalJSON: ``},
+			key:  `[1]`,
+			value: `{"after":{"a":1,"b":1.5,"c":1000000000000000000000,"d":"NaN",` +
+				`"e":{"a":{"x":"x y","y":null},"z":[1,0,25]}}}`,
+		},
+		{
+			name: `canonical updated`,
+			opts: map[string]string{
+				changefeedbase.OptCanonicalJSON:     ``,
+				changefeedbase.OptUpdatedTimestamps: ``,
+				changefeedbase.OptKeyInValue:        ``,
+			},
+			key: `[1]`,
+			value: `{"after":{"a":1,"b":1.5,"c":1000000000000000000000,"d":"NaN",` +
+				`"e":{"a":{"x":"x y","y":null},"z":[1,0,25]}},"key":[1],"updated":"1.0000000000"}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := changefeedbase.MakeStatementOptions(test.opts).GetEncodingOptions()
+			require.NoError(t, err)
+			enc, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+			key, err := enc.EncodeKey(context.Background(), row)
+			require.NoError(t, err)
+			require.Equal(t, test.key, string(key))
+			value, err := enc.EncodeValue(context.Background(), evCtx, row, prevRow)
+			require.NoError(t, err)
+			require.Equal(t, test.value, string(value))
+		})
+	}
+}
+
 func TestAvroEncoder(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -312,6 +1264,39 @@ func TestAvroEncoder(t *testing.T) {
 	cdcTest(t, testFn, feedTestForceSink("kafka"))
 }
 
+func TestAvroEncoderIntervalsAndJSON(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, s TestServer, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(s.DB)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, i INTERVAL, j JSONB)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, '1 mon 2 d 3 s', '{"x": 1}')`)
+		// Intervals which the duration logical type cannot represent are emitted
+		// as strings.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, '1 d 1 us', NULL)`)
+
+		foo := feed(t, f, fmt.Sprintf(`CREATE CHANGEFEED FOR foo `+
+			`WITH format=%s, diff`, changefeedbase.OptFormatAvro))
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: {"a":{"long":1}}->{"after":{"foo":{"a":{"long":1},` +
+				`"i":{"foo.i":"\u0001\u0000\u0000\u0000\u0002\u0000\u0000\u0000\u00B8\u000B\u0000\u0000"},` +
+				`"j":{"string":"{\"x\": 1}"}}},"before":null}`,
+			`foo: {"a":{"long":2}}->{"after":{"foo":{"a":{"long":2},` +
+				`"i":{"string":"P1DT0.000001S"},"j":null}},"before":null}`,
+		})
+
+		// The durations of the before and after records are distinct named types.
+		schema := foo.(*kafkaFeed).registry.SchemaForSubject(`foo-value`)
+		require.Contains(t, schema, `{"type":"fixed","name":"foo.i","size":12,"logicalType":"duration"}`)
+		require.Contains(t, schema, `"name":"foo_before.i"`)
+		require.Contains(t, schema, `{"type":"string","logicalType":"json"}`)
+	}
+
+	cdcTest(t, testFn, feedTestForceSink("kafka"))
+}
+
 func TestAvroEncoderWithTLS(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -426,6 +1411,239 @@ func TestAvroEncoderWithTLS(t *testing.T) {
 	})
 }
 
+func TestProtobufEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+	}
+	ts := hlc.Timestamp{WallTime: 1, Logical: 2}
+
+	reg := cdctest.StartTestSchemaRegistry()
+	defer reg.Close()
+	opts := changefeedbase.EncodingOptions{
+		Format:            changefeedbase.OptFormatProtobuf,
+		Envelope:          changefeedbase.OptEnvelopeWrapped,
+		UpdatedTimestamps: true,
+		Diff:              true,
+		SchemaRegistryURI: reg.URL(),
+	}
+	require.NoError(t, opts.Validate())
+
+	targets := changefeedbase.Targets{}
+	targets.Add(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           tableDesc.GetID(),
+		StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+	})
+	e, err := getEncoder(opts, targets)
+	require.NoError(t, err)
+
+	// The expected encodings of the row and of the timestamps.
+	var fooRow []byte
+	fooRow = protowire.AppendTag(fooRow, 1, protowire.VarintType)
+	fooRow = protowire.AppendVarint(fooRow, 1)
+	fooRow = protowire.AppendTag(fooRow, 2, protowire.BytesType)
+	fooRow = protowire.AppendString(fooRow, `bar`)
+	appendTimestamp := func(buf []byte, num protowire.Number) []byte {
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendString(buf, `1.0000000002`)
+	}
+
+	rowInsert := cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	prevRow := cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	evCtx := eventContext{updated: ts}
+
+	keyInsert, err := e.EncodeKey(context.Background(), rowInsert)
+	require.NoError(t, err)
+	require.Equal(t, `PROTOBUF`, reg.SchemaTypeForSubject(`foo-key`))
+	require.Equal(t, "syntax = \"proto3\";\n\n"+
+		"message foo {\n"+
+		"  optional int64 a = 1;\n"+
+		"}\n", reg.SchemaForSubject(`foo-key`))
+	require.Equal(t, append(protobufWireHeader(0), 0x08, 0x01), keyInsert)
+
+	valueInsert, err := e.EncodeValue(context.Background(), evCtx, rowInsert, prevRow)
+	require.NoError(t, err)
+	require.Equal(t, `PROTOBUF`, reg.SchemaTypeForSubject(`foo-value`))
+	require.Equal(t, "syntax = \"proto3\";\n\n"+
+		"message foo {\n"+
+		"  Row after = 1;\n"+
+		"  Row before = 2;\n"+
+		"  optional string updated = 3;\n"+
+		"\n"+
+		"  message Row {\n"+
+		"    optional int64 a = 1;\n"+
+		"    optional string b = 2;\n"+
+		"  }\n"+
+		"}\n", reg.SchemaForSubject(`foo-value`))
+	expected := protowire.AppendTag(protobufWireHeader(1), 1, protowire.BytesType)
+	expected = protowire.AppendBytes(expected, fooRow)
+	require.Equal(t, appendTimestamp(expected, 3), valueInsert)
+
+	// The previous row has the same version of the table, so the envelope
+	// registered for the insert is reused.
+	rowDelete := cdcevent.TestingMakeEventRow(tableDesc, 0, row, true)
+	prevRow = cdcevent.TestingMakeEventRow(tableDesc, 0, row, false)
+	valueDelete, err := e.EncodeValue(context.Background(), evCtx, rowDelete, prevRow)
+	require.NoError(t, err)
+	expected = protowire.AppendTag(protobufWireHeader(1), 2, protowire.BytesType)
+	expected = protowire.AppendBytes(expected, fooRow)
+	require.Equal(t, appendTimestamp(expected, 3), valueDelete)
+
+	resolved, err := e.EncodeResolvedTimestamp(context.Background(), `foo`, ts)
+	require.NoError(t, err)
+	require.Equal(t, "syntax = \"proto3\";\n\n"+
+		"message foo {\n"+
+		"  optional string resolved = 4;\n"+
+		"}\n", reg.SchemaForSubject(`foo-value`))
+	require.Equal(t, appendTimestamp(protobufWireHeader(2), 4), resolved)
+
+	opts.Envelope = changefeedbase.OptEnvelopeRow
+	require.EqualError(t, opts.Validate(), `envelope=row is not supported with format=protobuf`)
+	opts.Envelope = changefeedbase.OptEnvelopeWrapped
+
+	// Without a schema registry, the messages are not framed in the wire
+	// format.
+	opts.SchemaRegistryURI = ``
+	e, err = getEncoder(opts, targets)
+	require.NoError(t, err)
+	keyInsert, err = e.EncodeKey(context.Background(), rowInsert)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x08, 0x01}, keyInsert)
+	prevRow = cdcevent.TestingMakeEventRow(tableDesc, 0, nil, false)
+	valueInsert, err = e.EncodeValue(context.Background(), evCtx, rowInsert, prevRow)
+	require.NoError(t, err)
+	expected = protowire.AppendTag(nil, 1, protowire.BytesType)
+	expected = protowire.AppendBytes(expected, fooRow)
+	require.Equal(t, appendTimestamp(expected, 3), valueInsert)
+	resolved, err = e.EncodeResolvedTimestamp(context.Background(), `foo`, ts)
+	require.NoError(t, err)
+	require.Equal(t, appendTimestamp(nil, 4), resolved)
+
+	// The definitions are written to the schema files of the sinks which
+	// write files.
+	e, err = getEncoder(opts, targets)
+	require.NoError(t, err)
+	files := make(testSchemaFileSink)
+	setSchemaFileSink(e, files)
+	_, err = e.EncodeKey(context.Background(), rowInsert)
+	require.NoError(t, err)
+	_, err = e.EncodeValue(context.Background(), evCtx, rowInsert, prevRow)
+	require.NoError(t, err)
+	_, err = e.EncodeResolvedTimestamp(context.Background(), `foo`, ts)
+	require.NoError(t, err)
+	version := rowInsert.Version
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	require.ElementsMatch(t, []string{
+		fmt.Sprintf(`foo-key-%d.proto`, version),
+		fmt.Sprintf(`foo-value-%d.proto`, version),
+		`foo-value.proto`,
+	}, names)
+	require.Equal(t, "syntax = \"proto3\";\n\n"+
+		"message foo {\n"+
+		"  optional int64 a = 1;\n"+
+		"}\n", files[protobufSchemaFileName(`foo-key`, version)])
+}
+
+// testSchemaFileSink is a SchemaFileSink recording the schema files.
+type testSchemaFileSink map[string]string
+
+func (s testSchemaFileSink) WriteSchemaFile(_ context.Context, name string, schema []byte) error {
+	s[name] = string(schema)
+	return nil
+}
+
+func (s testSchemaFileSink) Close() error {
+	return nil
+}
+
+func (s testSchemaFileSink) Dial() error {
+	return nil
+}
+
+// TestProtobufSchemaEvolution tests that the definitions registered for the
+// successive versions of a table stay backward compatible as columns are
+// added: the existing columns keep their field numbers.
+func TestProtobufSchemaEvolution(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	reg := cdctest.StartTestSchemaRegistry()
+	defer reg.Close()
+	opts := changefeedbase.EncodingOptions{
+		Format:            changefeedbase.OptFormatProtobuf,
+		Envelope:          changefeedbase.OptEnvelopeWrapped,
+		SchemaRegistryURI: reg.URL(),
+	}
+
+	for _, tc := range []struct {
+		createStmt string
+		row        rowenc.EncDatumRow
+		expected   string
+	}{
+		{
+			createStmt: `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`,
+			row: rowenc.EncDatumRow{
+				rowenc.EncDatum{Datum: tree.NewDInt(1)},
+				rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+			},
+			expected: "    optional int64 a = 1;\n" +
+				"    optional string b = 2;\n",
+		},
+		{
+			createStmt: `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c FLOAT[])`,
+			row: rowenc.EncDatumRow{
+				rowenc.EncDatum{Datum: tree.NewDInt(1)},
+				rowenc.EncDatum{Datum: tree.NewDString(`bar`)},
+				rowenc.EncDatum{Datum: tree.DNull},
+			},
+			expected: "    optional int64 a = 1;\n" +
+				"    optional string b = 2;\n" +
+				"    repeated double c = 3;\n",
+		},
+	} {
+		tableDesc, err := parseTableDesc(tc.createStmt)
+		require.NoError(t, err)
+		targets := changefeedbase.Targets{}
+		targets.Add(changefeedbase.Target{
+			Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+			TableID:           tableDesc.GetID(),
+			StatementTimeName: changefeedbase.StatementTimeName(tableDesc.GetName()),
+		})
+		e, err := getEncoder(opts, targets)
+		require.NoError(t, err)
+
+		updatedRow := cdcevent.TestingMakeEventRow(tableDesc, 0, tc.row, false)
+		var prevRow cdcevent.Row
+		value, err := e.EncodeValue(context.Background(), eventContext{}, updatedRow, prevRow)
+		require.NoError(t, err)
+		require.Contains(t, reg.SchemaForSubject(`foo-value`), "  message Row {\n"+tc.expected+"  }\n")
+
+		// NULLs are omitted, so the rows of both versions have the same
+		// encoding, which readers of either definition can decode.
+		var fooRow []byte
+		fooRow = protowire.AppendTag(fooRow, 1, protowire.VarintType)
+		fooRow = protowire.AppendVarint(fooRow, 1)
+		fooRow = protowire.AppendTag(fooRow, 2, protowire.BytesType)
+		fooRow = protowire.AppendString(fooRow, `bar`)
+		envelope := value[len(protobufWireHeader(0)):]
+		num, typ, n := protowire.ConsumeTag(envelope)
+		require.Equal(t, protobufEnvelopeAfter, num)
+		require.Equal(t, protowire.BytesType, typ)
+		after, m := protowire.ConsumeBytes(envelope[n:])
+		require.Equal(t, len(envelope), n+m)
+		require.Equal(t, fooRow, after)
+	}
+}
+
 func TestAvroArray(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -732,6 +1950,32 @@ func TestAvroSchemaNamespace(t *testing.T) {
 	cdcTest(t, testFn, feedTestForceSink("kafka"))
 }
 
+func TestConfluentSubjectNamer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, test := range []struct {
+		opts       changefeedbase.EncodingOptions
+		key, value string
+	}{
+		{changefeedbase.EncodingOptions{}, `movr.drivers-key`, `movr.drivers-value`},
+		{changefeedbase.EncodingOptions{SubjectNameStrategy: changefeedbase.OptSubjectNameTopic},
+			`movr.drivers-key`, `movr.drivers-value`},
+		{changefeedbase.EncodingOptions{SubjectNameStrategy: changefeedbase.OptSubjectNameRecord},
+			`super.drivers`, `super.drivers_envelope`},
+		{changefeedbase.EncodingOptions{SubjectNameStrategy: changefeedbase.OptSubjectNameTopicRecord},
+			`movr.drivers-super.drivers`, `movr.drivers-super.drivers_envelope`},
+		{changefeedbase.EncodingOptions{SubjectTemplate: `cdc.{topic}.{type}`},
+			`cdc.movr.drivers.key`, `cdc.movr.drivers.value`},
+		{changefeedbase.EncodingOptions{SubjectTemplate: `{record}-{type}`},
+			`super.drivers-key`, `super.drivers_envelope-value`},
+	} {
+		n := makeConfluentSubjectNamer(test.opts)
+		require.Equal(t, test.key, n.subject(`movr.drivers`, confluentSubjectSuffixKey, `super.drivers`))
+		require.Equal(t, test.value, n.subject(`movr.drivers`, confluentSubjectSuffixValue, `super.drivers_envelope`))
+	}
+}
+
 func TestTableNameCollision(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
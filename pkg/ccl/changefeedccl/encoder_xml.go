@@ -0,0 +1,203 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/errors"
+)
+
+// The elements of the XML documents which are not named after the fields of
+// the JSON documents.
+const (
+	xmlKeyElement      = `key`
+	xmlRowElement      = `row`
+	xmlResolvedElement = `resolved`
+	xmlItemElement     = `item`
+)
+
+// xmlEncoder encodes changefeed entries as XML documents, without XML
+// declarations, whose encoding is UTF-8. The keys and the values are those of
+// the JSON encoding of the rows, whose documents are transcoded to XML under a
+// key and a row element respectively:
+//   - the fields of the objects are elements named after the fields (see
+//     xmlName), in the order of their names;
+//   - the elements of the arrays are item elements;
+//   - null is an empty element with a null="true" attribute;
+//   - the strings, numbers and booleans are the text of their elements.
+//
+// For example, the value {"after": {"a": 1, "b": null}} is encoded as
+// <row><after><a>1</a><b null="true"/></after></row>. The resolved timestamps
+// are encoded as resolved elements, such as <resolved>1.0000000000</resolved>.
+// The newlines of the strings are escaped, so that the documents are lines.
+type xmlEncoder struct {
+	json *jsonEncoder
+	buf  bytes.Buffer
+}
+
+var _ Encoder = &xmlEncoder{}
+
+func newXMLEncoder(
+	opts changefeedbase.EncodingOptions, targets changefeedbase.Targets,
+) (*xmlEncoder, error) {
+	j, err := makeJSONEncoder(opts, targets)
+	if err != nil {
+		return nil, err
+	}
+	return &xmlEncoder{json: j}, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *xmlEncoder) EncodeKey(_ context.Context, row cdcevent.Row) ([]byte, error) {
+	j, err := e.json.keyJSON(row)
+	if err != nil {
+		return nil, err
+	}
+	return e.encode(xmlKeyElement, j)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *xmlEncoder) EncodeValue(
+	_ context.Context, evCtx eventContext, updatedRow cdcevent.Row, prevRow cdcevent.Row,
+) ([]byte, error) {
+	j, err := e.json.valueJSON(evCtx, updatedRow, prevRow)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	return e.encode(xmlRowElement, j)
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *xmlEncoder) EncodeResolvedTimestamp(
+	_ context.Context, _ string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return e.encode(xmlResolvedElement,
+		json.FromString(eval.TimestampToDecimalDatum(resolved).Decimal.String()))
+}
+
+// encode returns the XML encoding of the JSON document under the element,
+// which is only valid until the next call.
+func (e *xmlEncoder) encode(element string, j json.JSON) ([]byte, error) {
+	e.buf.Reset()
+	if err := writeXMLElement(&e.buf, element, j); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// writeXMLElement writes the JSON document as the content of the element.
+func writeXMLElement(buf *bytes.Buffer, element string, j json.JSON) error {
+	if j.Type() == json.NullJSONType {
+		fmt.Fprintf(buf, `<%s null="true"/>`, element)
+		return nil
+	}
+	fmt.Fprintf(buf, `<%s>`, element)
+	switch j.Type() {
+	case json.FalseJSONType:
+		buf.WriteString(`false`)
+	case json.TrueJSONType:
+		buf.WriteString(`true`)
+	case json.NumberJSONType:
+		d, _ := j.AsDecimal()
+		buf.WriteString(d.String())
+	case json.StringJSONType:
+		s, err := j.AsText()
+		if err != nil {
+			return err
+		}
+		if err := xml.EscapeText(buf, []byte(*s)); err != nil {
+			return err
+		}
+	case json.ArrayJSONType:
+		for i, n := 0, j.Len(); i < n; i++ {
+			elem, err := j.FetchValIdx(i)
+			if err != nil {
+				return err
+			}
+			if err := writeXMLElement(buf, xmlItemElement, elem); err != nil {
+				return err
+			}
+		}
+	case json.ObjectJSONType:
+		it, err := j.ObjectIter()
+		if err != nil {
+			return err
+		}
+		for it.Next() {
+			if err := writeXMLElement(buf, xmlName(it.Key()), it.Value()); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.AssertionFailedf(`unknown JSON type: %v`, j.Type())
+	}
+	fmt.Fprintf(buf, `</%s>`, element)
+	return nil
+}
+
+// xmlName returns the name of the element of the field, escaping the
+// characters of the field which are not allowed in XML names as _xHHHH_, the
+// hexadecimal code point of the character, as ISO/IEC 9075-14 maps SQL
+// identifiers to XML names. The colons, which separate the namespaces of the
+// names, the underscores starting an escape sequence, and the reserved xml
+// prefix are escaped as well, so that the mapping of the non-empty fields is
+// reversible. The empty field, which has no XML name, is named _.
+func xmlName(field string) string {
+	if field == `` {
+		return `_`
+	}
+	escaped := false
+	for i, r := range field {
+		if !xmlNameRuneValid(field, i, r) {
+			escaped = true
+			break
+		}
+	}
+	if !escaped {
+		return field
+	}
+	var b strings.Builder
+	for i, r := range field {
+		switch {
+		case xmlNameRuneValid(field, i, r):
+			b.WriteRune(r)
+		case r <= 0xFFFF:
+			fmt.Fprintf(&b, `_x%04X_`, r)
+		default:
+			fmt.Fprintf(&b, `_x%08X_`, r)
+		}
+	}
+	return b.String()
+}
+
+// xmlNameRuneValid reports whether the character at the offset i of the field
+// is kept as is in the name of its element (see xmlName).
+func xmlNameRuneValid(field string, i int, r rune) bool {
+	switch {
+	case i == 0 && len(field) >= 3 && strings.EqualFold(field[:3], `xml`):
+		return false
+	case r == '_':
+		return !strings.HasPrefix(field[i+1:], `x`)
+	case unicode.IsLetter(r):
+		return true
+	default:
+		return i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.')
+	}
+}
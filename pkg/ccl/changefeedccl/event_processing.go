@@ -10,10 +10,15 @@ package changefeedccl
 
 import (
 	"context"
+	"math"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdceval"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcutils"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
@@ -23,6 +28,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
 )
 
@@ -31,6 +37,23 @@ type eventContext struct {
 	updated, mvcc hlc.Timestamp
 	// topic is set to the string to be included if TopicInValue is true
 	topic string
+	// securityLabel is set to the value of the security label column of the
+	// row if the changefeed emits security labels.
+	securityLabel tree.Datum
+	// snapshot is set if the row was read by a scan of the table, such as the
+	// initial scan, rather than written at its timestamp.
+	snapshot bool
+	// origin identifies the changefeed which emitted the row.
+	origin eventOrigin
+	// statementTime is the statement time of the changefeed.
+	statementTime hlc.Timestamp
+}
+
+// eventOrigin identifies the cluster, the node and the job of a changefeed.
+type eventOrigin struct {
+	clusterID uuid.UUID
+	nodeID    base.SQLInstanceID
+	jobID     jobspb.JobID
 }
 
 type kvEventToRowConsumer struct {
@@ -45,6 +68,51 @@ type kvEventToRowConsumer struct {
 	evaluator *cdceval.Evaluator
 	safeExpr  string
 
+	// partitioner, if set, computes the sink partition for each row
+	// (see changefeedbase.OptPartitionExpr).
+	partitioner *cdceval.PartitionEvaluator
+	// keyEvaluator, if set, computes the key of the messages of each row in
+	// place of its primary key (see changefeedbase.OptKeyExpr).
+	keyEvaluator *cdceval.KeyEvaluator
+	// pathEvaluator, if set, computes the values partitioning the output paths
+	// of the sink for each row (see PathPartitionedEventSink).
+	pathEvaluator *cdceval.PathEvaluator
+	// suppressor, if set, suppresses duplicate rows for the same key (see
+	// changefeedbase.OptSuppressDuplicatesWindow).
+	suppressor *duplicateSuppressor
+	// securityLabelColumn, if set, is the name of the column whose value is
+	// emitted as the security label of the rows (see
+	// changefeedbase.OptEmitSecurityLabel).
+	securityLabelColumn string
+	// headers, if set, are the names of the headers attached to the messages
+	// of the rows (see changefeedbase.OptKafkaHeaders and
+	// changefeedbase.OptPubsubAttributes).
+	headers []string
+	// cloudEvents is set to attach the attributes of the CloudEvents of the
+	// rows as headers (see changefeedbase.OptCloudEventsMode).
+	cloudEvents bool
+	// emitted accumulates the messages emitted per table since they were last
+	// forwarded to the frontier.
+	emitted emittedStats
+	// deadLetters, if set, receives the rows which cannot be encoded, encoded
+	// by deadLetterEncoder (see changefeedbase.OptDeadLetter).
+	deadLetters       *deadLetterQueue
+	deadLetterEncoder Encoder
+	// sinkThrottle, if set, throttles the messages emitted to the sink (see
+	// changefeedbase.OptSinkThrottleConfig).
+	sinkThrottle *cdcutils.Throttler
+	// origin identifies the changefeed emitting the rows.
+	origin eventOrigin
+	// messageCompression, if set, is the codec compressing the values of the
+	// messages (see changefeedbase.OptMessageCompression).
+	messageCompression string
+	// encryptor, if set, encrypts the values of the messages (see
+	// changefeedbase.OptEncryptionKMS).
+	encryptor *messageEncryptor
+	// chunkSize, if set, is the size beyond which the values of the messages
+	// are split into chunks (see changefeedbase.OptMessageChunkSize).
+	chunkSize int64
+
 	topicDescriptorCache map[TopicIdentifier]TopicDescriptor
 	topicNamer           *TopicNamer
 }
@@ -61,6 +129,11 @@ func newKVEventToRowConsumer(
 	expr execinfrapb.Expression,
 	knobs TestingKnobs,
 	topicNamer *TopicNamer,
+	suppressor *duplicateSuppressor,
+	deadLetters *deadLetterQueue,
+	sinkThrottle *cdcutils.Throttler,
+	jobID jobspb.JobID,
+	encryptor *messageEncryptor,
 ) (*kvEventToRowConsumer, error) {
 	includeVirtual := details.Opts.IncludeVirtual()
 	decoder, err := cdcevent.NewEventDecoder(ctx, cfg, details.Targets, includeVirtual)
@@ -77,12 +150,104 @@ func newKVEventToRowConsumer(
 			return nil, err
 		}
 		safeExpr = tree.AsString(expr)
-		evaluator, err = cdceval.NewEvaluator(evalCtx, expr)
+		evaluator, err = cdceval.NewEvaluator(evalCtx, expr, cfg.Executor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var partitioner *cdceval.PartitionEvaluator
+	if partitionExpr, ok := details.Opts.GetPartitionExpr(); ok {
+		if _, ok := unwrapSink(sink).(PartitionedEventSink); !ok {
+			return nil, errors.Newf("sink does not support %s option", changefeedbase.OptPartitionExpr)
+		}
+		partitioner, err = cdceval.NewPartitionEvaluator(evalCtx, partitionExpr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var keyEvaluator *cdceval.KeyEvaluator
+	if keyExpr, ok := details.Opts.GetKeyExpr(); ok {
+		keyEvaluator, err = cdceval.NewKeyEvaluator(evalCtx, keyExpr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var pathEvaluator *cdceval.PathEvaluator
+	if ps, ok := sink.(PathPartitionedEventSink); ok && len(ps.PartitionExprs()) > 0 {
+		if partitioner != nil {
+			return nil, errors.AssertionFailedf("sink cannot be partitioned by both partition and path expressions")
+		}
+		pathEvaluator, err = cdceval.NewPathEvaluator(evalCtx, ps.PartitionExprs())
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	encodingOpts, err := details.Opts.GetEncodingOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	headersOpt, headers, err := details.Opts.GetMessageHeaders()
+	if err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		if _, ok := unwrapSink(sink).(HeaderedEventSink); !ok {
+			return nil, errors.Newf("sink does not support %s option", headersOpt)
+		}
+	}
+	cloudEvents := encodingOpts.Format == changefeedbase.OptFormatCloudEvents &&
+		encodingOpts.CloudEventsMode == changefeedbase.OptCloudEventsModeBinary
+	if cloudEvents {
+		if _, ok := unwrapSink(sink).(HeaderedEventSink); !ok {
+			return nil, errors.Newf("sink does not support %s=%s",
+				changefeedbase.OptCloudEventsMode, changefeedbase.OptCloudEventsModeBinary)
+		}
+	}
+
+	if _, ok, err := details.Opts.GetEncryptionKMS(); err != nil {
+		return nil, err
+	} else if ok {
+		if encryptor == nil {
+			return nil, errors.AssertionFailedf("no data key was planned for %s", changefeedbase.OptEncryptionKMS)
+		}
+		if _, ok := unwrapSink(sink).(HeaderedEventSink); !ok {
+			return nil, errors.Newf("sink does not support %s option", changefeedbase.OptEncryptionKMS)
+		}
+	}
+
+	chunkSize, ok, err := details.Opts.GetMessageChunkSize()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if _, ok := unwrapSink(sink).(HeaderedEventSink); !ok {
+			return nil, errors.Newf("sink does not support %s option", changefeedbase.OptMessageChunkSize)
+		}
+	}
+
+	// The rows which cannot be encoded in the format of the changefeed are
+	// routed to the dead letter queue as JSON.
+	var deadLetterEncoder Encoder
+	if deadLetters != nil {
+		deadLetterEncoder, err = makeJSONEncoder(changefeedbase.EncodingOptions{
+			Format:   changefeedbase.OptFormatJSON,
+			Envelope: changefeedbase.OptEnvelopeWrapped,
+		}, details.Targets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	origin := eventOrigin{nodeID: cfg.NodeID.SQLInstanceID(), jobID: jobID}
+	if cfg.LogicalClusterID != nil {
+		origin.clusterID = cfg.LogicalClusterID.Get()
+	}
+
 	return &kvEventToRowConsumer{
 		frontier:             frontier,
 		encoder:              encoder,
@@ -95,10 +260,27 @@ func newKVEventToRowConsumer(
 		topicNamer:           topicNamer,
 		evaluator:            evaluator,
 		safeExpr:             safeExpr,
+		partitioner:          partitioner,
+		keyEvaluator:         keyEvaluator,
+		pathEvaluator:        pathEvaluator,
+		suppressor:           suppressor,
+		securityLabelColumn:  encodingOpts.SecurityLabelColumn,
+		headers:              headers,
+		cloudEvents:          cloudEvents,
+		deadLetters:          deadLetters,
+		deadLetterEncoder:    deadLetterEncoder,
+		sinkThrottle:         sinkThrottle,
+		origin:               origin,
+		messageCompression:   encodingOpts.MessageCompression,
+		encryptor:            encryptor,
+		chunkSize:            chunkSize,
 	}, nil
 }
 
-func (c *kvEventToRowConsumer) topicForEvent(eventMeta cdcevent.Metadata) (TopicDescriptor, error) {
+func (c *kvEventToRowConsumer) topicForEvent(
+	eventDesc *cdcevent.EventDescriptor,
+) (TopicDescriptor, error) {
+	eventMeta := eventDesc.Metadata
 	if topic, ok := c.topicDescriptorCache[TopicIdentifier{TableID: eventMeta.TableID, FamilyID: eventMeta.FamilyID}]; ok {
 		if topic.GetVersion() == eventMeta.Version {
 			return topic, nil
@@ -106,7 +288,7 @@ func (c *kvEventToRowConsumer) topicForEvent(eventMeta cdcevent.Metadata) (Topic
 	}
 	t, found := c.details.Targets.FindByTableIDAndFamilyName(eventMeta.TableID, eventMeta.FamilyName)
 	if found {
-		topic, err := makeTopicDescriptorFromSpec(t, eventMeta)
+		topic, err := makeTopicDescriptorFromSpec(t, eventDesc)
 		if err != nil {
 			return noTopic{}, err
 		}
@@ -131,6 +313,16 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 		prevSchemaTimestamp = schemaTimestamp.Prev()
 	}
 
+	if c.evaluator != nil {
+		op := cdceval.EventOpOfKV(ev.KV().Value, ev.PrevValue(), c.details.Opts.GetFilters().WithDiff)
+		if !c.evaluator.MatchesEventOp(op) {
+			// The event is filtered out by its operation; don't bother decoding it.
+			a := ev.DetachAlloc()
+			a.Release(ctx)
+			return nil
+		}
+	}
+
 	updatedRow, err := c.decoder.DecodeKV(ctx, ev.KV(), schemaTimestamp)
 	if err != nil {
 		// Column families are stored contiguously, so we'll get
@@ -170,7 +362,74 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 			a.Release(ctx)
 			return nil
 		}
+	}
+
+	// Partitions are computed against the row prior to projection since the
+	// partition expressions reference the columns of the table.
+	var partition int64
+	if c.partitioner != nil {
+		partition, err = c.partitioner.Partition(ctx, updatedRow, mvccTimestamp)
+		if err != nil {
+			return errors.Wrapf(err, "while evaluating partition expression")
+		}
+		if partition < 0 || partition > math.MaxInt32 {
+			return errors.Newf("partition expression evaluated to invalid partition %d", partition)
+		}
+	}
+	var partitionValues []string
+	if c.pathEvaluator != nil {
+		partitionValues, err = c.pathEvaluator.Values(ctx, updatedRow, mvccTimestamp)
+		if err != nil {
+			return errors.Wrapf(err, "while evaluating partition values")
+		}
+	}
+
+	// Deletes are never suppressed; note whether the row is deleted prior to
+	// projection.
+	deleted := updatedRow.IsDeleted()
+
+	// The security label is extracted prior to projection, since the label
+	// column does not need to be projected.
+	var securityLabel tree.Datum
+	if c.securityLabelColumn != "" {
+		var ok bool
+		securityLabel, ok, err = columnOfRow(updatedRow, prevRow, c.securityLabelColumn)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// The label column was dropped since the changefeed was created.
+			// The rows are not emitted without their label.
+			return errors.Newf("%s column %q does not exist in table %q",
+				changefeedbase.OptEmitSecurityLabel, c.securityLabelColumn, updatedRow.TableName)
+		}
+	}
+
+	// Likewise, the headers may reference columns which are not projected.
+	var headers []messageHeader
+	if c.headers != nil {
+		headers, err = c.headersOfRow(updatedRow, prevRow, mvccTimestamp)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Likewise, the key expression references the columns of the table. The
+	// deleted rows are keyed by their previous values, since only their
+	// primary key columns are known otherwise.
+	var keyRow cdcevent.Row
+	if c.keyEvaluator != nil {
+		keyedRow := updatedRow
+		if deleted && prevRow.IsInitialized() && prevRow.HasValues() && !prevRow.IsDeleted() {
+			keyedRow = prevRow
+		}
+		keyRow, err = c.keyEvaluator.Key(ctx, keyedRow, mvccTimestamp)
+		if err != nil {
+			return errors.Wrapf(err, "while evaluating key expression")
+		}
+	}
 
+	if c.evaluator != nil {
 		projection, err := c.evaluator.Projection(ctx, updatedRow, mvccTimestamp, prevRow)
 		if err != nil {
 			return errors.Wrapf(err, "while evaluating projection: %s", c.safeExpr)
@@ -182,7 +441,7 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 		prevRow = cdcevent.Row{}
 	}
 
-	topic, err := c.topicForEvent(updatedRow.Metadata)
+	topic, err := c.topicForEvent(updatedRow.EventDescriptor)
 	if err != nil {
 		return err
 	}
@@ -200,8 +459,12 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 	}
 
 	evCtx := eventContext{
-		updated: schemaTimestamp,
-		mvcc:    mvccTimestamp,
+		updated:       schemaTimestamp,
+		mvcc:          mvccTimestamp,
+		securityLabel: securityLabel,
+		snapshot:      !ev.BackfillTimestamp().IsEmpty(),
+		origin:        c.origin,
+		statementTime: c.details.ScanTime,
 	}
 
 	if c.topicNamer != nil {
@@ -212,29 +475,71 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 		evCtx.topic = topic
 	}
 
+	if !keyRow.IsInitialized() {
+		keyRow = updatedRow
+	}
 	var keyCopy, valueCopy []byte
-	encodedKey, err := c.encoder.EncodeKey(ctx, updatedRow)
+	encodedKey, err := c.encoder.EncodeKey(ctx, keyRow)
 	if err != nil {
-		return err
+		return c.maybeRouteToDeadLetters(ctx, &ev, topic, evCtx, updatedRow, prevRow, err)
 	}
 	c.scratch, keyCopy = c.scratch.Copy(encodedKey, 0 /* extraCap */)
 	// TODO(yevgeniy): Some refactoring is needed in the encoder: namely, prevRow
 	// might not be available at all when working with changefeed expressions.
 	encodedValue, err := c.encoder.EncodeValue(ctx, evCtx, updatedRow, prevRow)
 	if err != nil {
-		return err
+		return c.maybeRouteToDeadLetters(ctx, &ev, topic, evCtx, updatedRow, prevRow, err)
 	}
 	c.scratch, valueCopy = c.scratch.Copy(encodedValue, 0 /* extraCap */)
+	if c.cloudEvents {
+		ce := makeCloudEvent(evCtx, updatedRow, prevRow, keyCopy, c.details.Opts.GetFilters().WithDiff)
+		headers = append(headers, ce.headers()...)
+	}
+	// The values are compressed before they are encrypted, which leaves them
+	// incompressible. Empty values, such as the tombstones of deleted rows,
+	// are left as is.
+	if c.messageCompression != `` && len(valueCopy) > 0 {
+		valueCopy, err = compressMessage(c.messageCompression, valueCopy)
+		if err != nil {
+			return err
+		}
+	}
+	if c.encryptor != nil {
+		valueCopy, err = c.encryptor.encrypt(valueCopy)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, c.encryptor.headers...)
+	}
 
 	if c.knobs.BeforeEmitRow != nil {
 		if err := c.knobs.BeforeEmitRow(ctx); err != nil {
 			return err
 		}
 	}
-	if err := c.sink.EmitRow(
-		ctx, topic,
-		keyCopy, valueCopy, schemaTimestamp, mvccTimestamp, ev.DetachAlloc(),
-	); err != nil {
+	row := &encodedRow{
+		topic:           topic,
+		key:             keyCopy,
+		value:           valueCopy,
+		updated:         schemaTimestamp,
+		mvcc:            mvccTimestamp,
+		alloc:           ev.DetachAlloc(),
+		partition:       int32(partition),
+		partitionValues: partitionValues,
+		headers:         headers,
+	}
+	if c.suppressor != nil {
+		emit := c.suppressor.admit(ctx, row, deleted)
+		// Rows retained for keys which were evicted in the process must be
+		// emitted.
+		if err := c.emitRows(ctx, c.suppressor.takeEvicted()); err != nil {
+			return err
+		}
+		if !emit {
+			return nil
+		}
+	}
+	if err := c.emitRow(ctx, row); err != nil {
 		return err
 	}
 	if log.V(3) {
@@ -242,3 +547,178 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 	}
 	return nil
 }
+
+// maybeRouteToDeadLetters routes the row which could not be encoded to the
+// dead letter queue, if the changefeed has one and the row would fail to be
+// encoded again. Otherwise, the error of encoding the row is returned.
+func (c *kvEventToRowConsumer) maybeRouteToDeadLetters(
+	ctx context.Context,
+	ev *kvevent.Event,
+	topic TopicDescriptor,
+	evCtx eventContext,
+	updatedRow, prevRow cdcevent.Row,
+	encodeErr error,
+) error {
+	if c.deadLetters == nil || !isUndeliverableRowError(encodeErr) {
+		return encodeErr
+	}
+	key, err := c.deadLetterEncoder.EncodeKey(ctx, updatedRow)
+	if err != nil {
+		return errors.WithSecondaryError(encodeErr, err)
+	}
+	// The encoder reuses its buffer across calls.
+	c.scratch, key = c.scratch.Copy(key, 0 /* extraCap */)
+	value, err := c.deadLetterEncoder.EncodeValue(ctx, evCtx, updatedRow, prevRow)
+	if err != nil {
+		return errors.WithSecondaryError(encodeErr, err)
+	}
+	if err := c.deadLetters.enqueue(
+		ctx, topic, key, value, evCtx.updated, evCtx.mvcc, encodeErr,
+	); err != nil {
+		return err
+	}
+	a := ev.DetachAlloc()
+	a.Release(ctx)
+	return nil
+}
+
+// columnOfRow returns the value of the column of the row, such as the security
+// label column, and whether the row has the column. The value of the column of
+// a deleted row is the value of the column of the previous row, if it is known.
+func columnOfRow(updatedRow, prevRow cdcevent.Row, column string) (tree.Datum, bool, error) {
+	row := updatedRow
+	if updatedRow.IsDeleted() && prevRow.IsInitialized() && prevRow.HasValues() && !prevRow.IsDeleted() {
+		row = prevRow
+	}
+	for i, col := range row.ResultColumns() {
+		if col.Name == column {
+			d, err := row.DatumAt(i)
+			return d, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// headersOfRow returns the headers attached to the message of the row.
+func (c *kvEventToRowConsumer) headersOfRow(
+	updatedRow, prevRow cdcevent.Row, mvcc hlc.Timestamp,
+) ([]messageHeader, error) {
+	headers := make([]messageHeader, len(c.headers))
+	for i, name := range c.headers {
+		headers[i].key = name
+		switch name {
+		case changefeedbase.MessageHeaderOperation:
+			headers[i].value = []byte(operationOfRow(updatedRow, prevRow, c.details.Opts.GetFilters().WithDiff))
+		case changefeedbase.MessageHeaderMVCCTimestamp:
+			headers[i].value = []byte(mvcc.AsOfSystemTime())
+		case changefeedbase.MessageHeaderTable:
+			headers[i].value = []byte(updatedRow.TableName)
+		default:
+			d, ok, err := columnOfRow(updatedRow, prevRow, name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, errors.Newf("message header column %q does not exist in table %q",
+					name, updatedRow.TableName)
+			}
+			if d != tree.DNull {
+				headers[i].value = []byte(tree.AsStringWithFlags(d, tree.FmtBareStrings))
+			}
+		}
+	}
+	return headers, nil
+}
+
+// operationOfRow returns the operation which produced the row, as held by the
+// changefeedbase.MessageHeaderOperation header. Inserts can only be told apart
+// from updates if the previous row was decoded.
+func operationOfRow(updatedRow, prevRow cdcevent.Row, withDiff bool) string {
+	switch {
+	case updatedRow.IsDeleted():
+		return "delete"
+	case !withDiff:
+		return "upsert"
+	case prevRow.IsInitialized() && prevRow.HasValues() && !prevRow.IsDeleted():
+		return "update"
+	default:
+		return "insert"
+	}
+}
+
+// emitRow emits the encoded row to the sink, and records its emission.
+func (c *kvEventToRowConsumer) emitRow(ctx context.Context, row *encodedRow) error {
+	if c.chunkSize > 0 && int64(len(row.value)) > c.chunkSize {
+		return c.emitRows(ctx, chunkRow(row, c.chunkSize))
+	}
+	if c.sinkThrottle != nil {
+		if err := c.sinkThrottle.AcquireMessageQuota(ctx, len(row.key)+len(row.value)); err != nil {
+			return err
+		}
+	}
+	if err := c.emitRowToSink(ctx, row); err != nil {
+		return err
+	}
+	c.emitted.add(row.topic.GetTopicIdentifier().TableID, 1, int64(len(row.key)+len(row.value)))
+	return nil
+}
+
+func (c *kvEventToRowConsumer) emitRowToSink(ctx context.Context, row *encodedRow) error {
+	if c.headers != nil || c.cloudEvents || c.encryptor != nil || c.chunkSize > 0 {
+		partition := int32(-1)
+		if c.partitioner != nil {
+			partition = row.partition
+		}
+		// The sink type was verified when the consumer was constructed.
+		return c.sink.(HeaderedEventSink).EmitRowWithHeaders(
+			ctx, row.topic,
+			row.key, row.value, row.updated, row.mvcc, row.alloc,
+			partition, row.headers,
+		)
+	}
+	if c.pathEvaluator != nil {
+		// The sink type was verified when the consumer was constructed.
+		return c.sink.(PathPartitionedEventSink).EmitRowWithPartitionValues(
+			ctx, row.topic,
+			row.key, row.value, row.updated, row.mvcc, row.alloc,
+			row.partitionValues,
+		)
+	}
+	if c.partitioner != nil {
+		// The sink type was verified when the consumer was constructed.
+		return c.sink.(PartitionedEventSink).EmitRowToPartition(
+			ctx, row.topic,
+			row.key, row.value, row.updated, row.mvcc, row.alloc,
+			row.partition,
+		)
+	}
+	return c.sink.EmitRow(
+		ctx, row.topic,
+		row.key, row.value, row.updated, row.mvcc, row.alloc,
+	)
+}
+
+func (c *kvEventToRowConsumer) emitRows(ctx context.Context, rows []*encodedRow) error {
+	for _, row := range rows {
+		if err := c.emitRow(ctx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushSuppressed emits the rows retained by duplicate suppression. It must be
+// called before the sink is flushed prior to forwarding resolved timestamps,
+// so that the final state of every key is emitted.
+func (c *kvEventToRowConsumer) flushSuppressed(ctx context.Context) error {
+	if c.suppressor == nil {
+		return nil
+	}
+	return c.emitRows(ctx, c.suppressor.flush(ctx))
+}
+
+// takeEmitted returns the messages emitted per table since the last call, and
+// resets them. The emitted messages must have been flushed to the sink.
+func (c *kvEventToRowConsumer) takeEmitted() []jobspb.ChangefeedEmittedStats {
+	return c.emitted.take()
+}
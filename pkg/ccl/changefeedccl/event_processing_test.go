@@ -153,7 +153,7 @@ func TestTopicForEvent(t *testing.T) {
 			tn, err := MakeTopicNamer(AllTargets(tc.details))
 			require.NoError(t, err)
 
-			td, err := c.topicForEvent(tc.event)
+			td, err := c.topicForEvent(&cdcevent.EventDescriptor{Metadata: tc.event})
 			if tc.expectErr == "" {
 				require.NoError(t, err)
 				topicName, err := tn.Name(td)
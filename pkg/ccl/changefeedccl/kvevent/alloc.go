@@ -92,6 +92,27 @@ func (a *Alloc) Merge(other *Alloc) {
 	}
 }
 
+// Split splits the resources of this allocation into n allocations, which may
+// be released independently of each other. The first of them retains the
+// entries of this allocation, and its allocations from other pools; the bytes
+// are split evenly between them.
+func (a *Alloc) Split(n int) []Alloc {
+	allocs := make([]Alloc, n)
+	if a.isZero() || n == 0 {
+		return allocs
+	}
+	defer a.clear()
+	share := a.bytes / int64(n)
+	if share > 0 {
+		for i := 1; i < n; i++ {
+			allocs[i] = Alloc{bytes: share, ap: a.ap}
+		}
+	}
+	allocs[0] = *a
+	allocs[0].bytes -= share * int64(n-1)
+	return allocs
+}
+
 func (a *Alloc) clear()       { *a = Alloc{} }
 func (a *Alloc) isZero() bool { return a.ap == nil }
 func (a *Alloc) init(bytes int64, p pool) {
@@ -82,6 +82,49 @@ func TestAllocMergeRandomized(t *testing.T) {
 	}
 }
 
+func TestAllocSplit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		bytes int64
+		n     int
+	}{
+		{bytes: 10, n: 1},
+		{bytes: 10, n: 3},
+		{bytes: 2, n: 3},
+	} {
+		t.Run(fmt.Sprintf("bytes=%d,n=%d", tc.bytes, tc.n), func(t *testing.T) {
+			p := &testAllocPool{n: tc.bytes}
+			a := TestingMakeAlloc(tc.bytes, p)
+			allocs := a.Split(tc.n)
+			require.True(t, a.isZero())
+			require.Len(t, allocs, tc.n)
+
+			var bytes, events int64
+			for i := range allocs {
+				bytes += allocs[i].Bytes()
+				events += allocs[i].Events()
+			}
+			require.Equal(t, tc.bytes, bytes)
+			require.Equal(t, int64(1), events)
+
+			// The allocations are released independently of each other.
+			for i := range allocs {
+				released := allocs[i].Bytes()
+				before := p.getN()
+				allocs[i].Release(ctx)
+				require.Equal(t, before-int(released), p.getN())
+			}
+			require.Equal(t, 0, p.getN())
+		})
+	}
+
+	var zero Alloc
+	require.Equal(t, []Alloc{{}, {}}, zero.Split(2))
+}
+
 type testAllocPool struct {
 	syncutil.Mutex
 	n int64
@@ -31,6 +31,10 @@ type blockingBuffer struct {
 	qp       allocPool     // Pool for memory allocations.
 	signalCh chan struct{} // Signal when new events are available.
 
+	// oversized, if set, is the pool reserving the memory which the oversized
+	// events require in excess of the per changefeed limit.
+	oversized *oversizedPool
+
 	req struct {
 		syncutil.Mutex
 		memRequest
@@ -53,6 +57,30 @@ type blockingBuffer struct {
 func NewMemBuffer(
 	acc mon.BoundAccount, sv *settings.Values, metrics *Metrics, opts ...quotapool.Option,
 ) Buffer {
+	return newMemBuffer(acc, sv, metrics, opts...)
+}
+
+// NewMemBufferWithOversizedEvents is like NewMemBuffer, except that the entries
+// which exceed the per changefeed limit (but not changefeedbase.MaxEventSize)
+// are buffered alone: such an entry waits until all the previously buffered
+// entries are released, and blocks the subsequent ones until it is released
+// itself. The memory it requires in excess of the limit is reserved from the
+// oversized account.
+func NewMemBufferWithOversizedEvents(
+	acc mon.BoundAccount,
+	oversizedAcc mon.BoundAccount,
+	sv *settings.Values,
+	metrics *Metrics,
+	opts ...quotapool.Option,
+) Buffer {
+	b := newMemBuffer(acc, sv, metrics, opts...)
+	b.oversized = &oversizedPool{acc: oversizedAcc}
+	return b
+}
+
+func newMemBuffer(
+	acc mon.BoundAccount, sv *settings.Values, metrics *Metrics, opts ...quotapool.Option,
+) *blockingBuffer {
 	const slowAcquisitionThreshold = 5 * time.Second
 
 	opts = append(opts,
@@ -179,8 +207,19 @@ func (b *blockingBuffer) Add(ctx context.Context, e Event) error {
 
 	// Acquire the quota first.
 	alloc := int64(changefeedbase.EventMemoryMultiplier.Get(b.sv) * float64(e.approxSize))
+	var excess int64
 	if l := changefeedbase.PerChangefeedMemLimit.Get(b.sv); alloc > l {
-		return errors.Newf("event size %d exceeds per changefeed limit %d", alloc, l)
+		if b.oversized == nil {
+			return errors.Newf("event size %d exceeds per changefeed limit %d", alloc, l)
+		}
+		if m := changefeedbase.MaxEventSize.Get(b.sv); alloc > m {
+			return errors.Newf("event size %d exceeds maximum event size %d (%s)",
+				alloc, m, changefeedbase.MaxEventSize.Key())
+		}
+		// The oversized event acquires the whole limit from the quota pool, so
+		// that it is buffered alone, and reserves the rest of its memory from
+		// the oversized pool.
+		alloc, excess = l, alloc-l
 	}
 	e.alloc.init(alloc, &b.qp)
 	e.bufferAddTimestamp = timeutil.Now()
@@ -195,6 +234,15 @@ func (b *blockingBuffer) Add(ctx context.Context, e Event) error {
 	}(); err != nil {
 		return err
 	}
+	if excess > 0 {
+		if err := b.oversized.reserve(ctx, excess); err != nil {
+			e.alloc.Release(ctx)
+			return err
+		}
+		oversizedAlloc := Alloc{bytes: excess, ap: b.oversized}
+		e.alloc.Merge(&oversizedAlloc)
+		b.metrics.BufferEntriesOversized.Inc(1)
+	}
 	b.metrics.BufferEntriesMemAcquired.Inc(alloc)
 	return b.enqueue(ctx, e)
 }
@@ -252,6 +300,9 @@ func (b *blockingBuffer) CloseWithReason(ctx context.Context, reason error) erro
 		quota.acc.Close(ctx)
 		return false
 	})
+	if b.oversized != nil {
+		b.oversized.close(ctx)
+	}
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -367,6 +418,46 @@ func (ap allocPool) Release(ctx context.Context, bytes, entries int64) {
 	})
 }
 
+// oversizedPool reserves the memory required by the oversized events in excess
+// of the per changefeed limit.
+type oversizedPool struct {
+	mu struct {
+		syncutil.Mutex
+		// closed indicates that the pool is closed, and that the attempts to
+		// release memory should be ignored.
+		closed bool
+	}
+	acc mon.BoundAccount
+}
+
+var _ pool = (*oversizedPool)(nil)
+
+func (p *oversizedPool) reserve(ctx context.Context, bytes int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.closed {
+		return errors.AssertionFailedf("oversized pool unexpectedly closed")
+	}
+	return p.acc.Grow(ctx, bytes)
+}
+
+// Release implements the pool interface.
+func (p *oversizedPool) Release(ctx context.Context, bytes, _ int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.closed {
+		return
+	}
+	p.acc.Shrink(ctx, bytes)
+}
+
+func (p *oversizedPool) close(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.closed = true
+	p.acc.Close(ctx)
+}
+
 // logSlowAcquisition is a function returning a quotapool.SlowAcquisitionFunction.
 // It differs from the quotapool.LogSlowAcquisition in that only some of slow acquisition
 // events are logged to reduce log spam.
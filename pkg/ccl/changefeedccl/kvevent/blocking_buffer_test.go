@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
@@ -161,3 +162,72 @@ func TestBlockingBufferNotifiesConsumerWhenOutOfMemory(t *testing.T) {
 
 	stopProducer()
 }
+
+func TestBlockingBufferOversizedEvents(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	const limit = 1024
+	st := cluster.MakeTestingClusterSettings()
+	changefeedbase.PerChangefeedMemLimit.Override(ctx, &st.SV, limit)
+	changefeedbase.MaxEventSize.Override(ctx, &st.SV, 16<<10)
+	rnd, _ := randutil.NewTestRand()
+	makeEvent := func(valSize int) kvevent.Event {
+		return kvevent.MakeKVEvent(makeKV(rnd, valSize), roachpb.Value{}, hlc.Timestamp{})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		metrics := kvevent.MakeMetrics(time.Minute)
+		ba, release := getBoundAccountWithBudget(limit)
+		defer release()
+		buf := kvevent.NewMemBuffer(ba, &st.SV, &metrics)
+		defer func() {
+			require.NoError(t, buf.CloseWithReason(ctx, nil))
+		}()
+		require.Regexp(t, "exceeds per changefeed limit", buf.Add(ctx, makeEvent(512)))
+	})
+
+	metrics := kvevent.MakeMetrics(time.Minute)
+	ba, release := getBoundAccountWithBudget(limit)
+	defer release()
+	oversizedAcc, releaseOversized := getBoundAccountWithBudget(1 << 20)
+	defer releaseOversized()
+	oversizedMon := oversizedAcc.Monitor()
+	buf := kvevent.NewMemBufferWithOversizedEvents(ba, oversizedAcc, &st.SV, &metrics)
+	defer func() {
+		require.NoError(t, buf.CloseWithReason(ctx, nil))
+	}()
+
+	// The oversized event waits until the previously buffered event is
+	// released.
+	require.NoError(t, buf.Add(ctx, makeEvent(8)))
+	added := make(chan error, 1)
+	go func() {
+		added <- buf.Add(ctx, makeEvent(512))
+	}()
+	select {
+	case err := <-added:
+		t.Fatalf("expected the oversized event to wait, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	e, err := buf.Get(ctx)
+	require.NoError(t, err)
+	a := e.DetachAlloc()
+	a.Release(ctx)
+	require.NoError(t, <-added)
+
+	// The oversized event acquires the whole limit from the buffer, and the
+	// rest of its memory from the oversized account, until it is released.
+	e, err = buf.Get(ctx)
+	require.NoError(t, err)
+	a = e.DetachAlloc()
+	require.Equal(t, int64(limit), a.Bytes())
+	require.Equal(t, int64(1), metrics.BufferEntriesOversized.Count())
+	require.Less(t, int64(0), oversizedMon.AllocBytes())
+	a.Release(ctx)
+	require.Equal(t, int64(0), oversizedMon.AllocBytes())
+
+	// The events larger than the maximum event size are rejected.
+	require.Regexp(t, "exceeds maximum event size", buf.Add(ctx, makeEvent(8<<10)))
+}
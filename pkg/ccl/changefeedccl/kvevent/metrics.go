@@ -45,6 +45,12 @@ var (
 		Measurement: "Entries",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaChangefeedBufferEntriesOversized = metric.Metadata{
+		Name:        "changefeed.buffer_entries.oversized",
+		Help:        "Total entries which required more memory than the per changefeed limit, and were buffered alone",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
 	metaChangefeedBufferPushbackNanos = metric.Metadata{
 		Name:        "changefeed.buffer_pushback_nanos",
 		Help:        "Total time spent waiting while the buffer was full",
@@ -58,6 +64,7 @@ type Metrics struct {
 	BufferEntriesIn          *metric.Counter
 	BufferEntriesOut         *metric.Counter
 	BufferEntriesReleased    *metric.Counter
+	BufferEntriesOversized   *metric.Counter
 	BufferPushbackNanos      *metric.Counter
 	BufferEntriesMemAcquired *metric.Counter
 	BufferEntriesMemReleased *metric.Counter
@@ -69,6 +76,7 @@ func MakeMetrics(histogramWindow time.Duration) Metrics {
 		BufferEntriesIn:          metric.NewCounter(metaChangefeedBufferEntriesIn),
 		BufferEntriesOut:         metric.NewCounter(metaChangefeedBufferEntriesOut),
 		BufferEntriesReleased:    metric.NewCounter(metaChangefeedBufferEntriesReleased),
+		BufferEntriesOversized:   metric.NewCounter(metaChangefeedBufferEntriesOversized),
 		BufferEntriesMemAcquired: metric.NewCounter(metaChangefeedBufferMemAcquired),
 		BufferEntriesMemReleased: metric.NewCounter(metaChangefeedBufferMemReleased),
 		BufferPushbackNanos:      metric.NewCounter(metaChangefeedBufferPushbackNanos),
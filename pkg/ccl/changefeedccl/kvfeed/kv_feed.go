@@ -50,6 +50,7 @@ type Config struct {
 	OnBackfillCallback      func() func()
 	OnBackfillRangeCallback func(int64) (func(), func())
 	MM                      *mon.BytesMonitor
+	OversizedEventMM        *mon.BytesMonitor // see kvevent.NewMemBufferWithOversizedEvents
 	WithDiff                bool
 	SchemaChangeEvents      changefeedbase.SchemaChangeEventClass
 	SchemaChangePolicy      changefeedbase.SchemaChangePolicy
@@ -98,6 +99,11 @@ func Run(ctx context.Context, cfg Config) error {
 	}
 
 	bf := func() kvevent.Buffer {
+		if cfg.OversizedEventMM != nil {
+			return kvevent.NewErrorWrapperEventBuffer(kvevent.NewMemBufferWithOversizedEvents(
+				cfg.MM.MakeBoundAccount(), cfg.OversizedEventMM.MakeBoundAccount(),
+				&cfg.Settings.SV, cfg.Metrics))
+		}
 		return kvevent.NewErrorWrapperEventBuffer(
 			kvevent.NewMemBuffer(cfg.MM.MakeBoundAccount(), &cfg.Settings.SV, cfg.Metrics))
 	}
@@ -0,0 +1,49 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strconv"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+)
+
+// chunkRow splits the value of the row into chunks of up to size bytes, each
+// of which is emitted as a row with the key and the headers of the row, along
+// with the headers from which the consumers reassemble the value (see
+// changefeedbase.OptMessageChunkSize). The chunks share the value of the row,
+// whose memory is released once the last chunk has been emitted.
+func chunkRow(row *encodedRow, size int64) []*encodedRow {
+	n := int64(len(row.value))
+	count := (n + size - 1) / size
+	id := []byte(row.mvcc.AsOfSystemTime())
+	countValue := []byte(strconv.FormatInt(count, 10))
+	chunks := make([]*encodedRow, 0, count)
+	for i := int64(0); i < count; i++ {
+		chunk := *row
+		end := (i + 1) * size
+		if end > n {
+			end = n
+		}
+		chunk.value = row.value[i*size : end]
+		if i < count-1 {
+			chunk.alloc = kvevent.Alloc{}
+		}
+		chunk.headers = make([]messageHeader, 0, len(row.headers)+3)
+		chunk.headers = append(chunk.headers, row.headers...)
+		chunk.headers = append(chunk.headers,
+			messageHeader{key: changefeedbase.MessageChunkIDHeader, value: id},
+			messageHeader{key: changefeedbase.MessageChunkIndexHeader, value: []byte(strconv.FormatInt(i, 10))},
+			messageHeader{key: changefeedbase.MessageChunkCountHeader, value: countValue},
+		)
+		chunks = append(chunks, &chunk)
+	}
+	return chunks
+}
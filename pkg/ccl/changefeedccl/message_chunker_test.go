@@ -0,0 +1,81 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// reassembleChunks reassembles the value of the chunks, as the consumers of the
+// messages do (see changefeedbase.OptMessageChunkSize).
+func reassembleChunks(t *testing.T, chunks []*encodedRow) string {
+	var id string
+	values := make(map[int64]string)
+	for _, chunk := range chunks {
+		headers := make(map[string]string)
+		for _, h := range chunk.headers {
+			headers[h.key] = string(h.value)
+		}
+		if id == `` {
+			id = headers[changefeedbase.MessageChunkIDHeader]
+		}
+		require.Equal(t, id, headers[changefeedbase.MessageChunkIDHeader])
+		count, err := strconv.ParseInt(headers[changefeedbase.MessageChunkCountHeader], 10, 64)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(chunks)), count)
+		index, err := strconv.ParseInt(headers[changefeedbase.MessageChunkIndexHeader], 10, 64)
+		require.NoError(t, err)
+		values[index] = string(chunk.value)
+	}
+	var b strings.Builder
+	for i := int64(0); i < int64(len(chunks)); i++ {
+		value, ok := values[i]
+		require.True(t, ok, "missing chunk %d", i)
+		b.WriteString(value)
+	}
+	return b.String()
+}
+
+func TestChunkRow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	value := `{"after": {"a": 1, "b": "` + strings.Repeat("x", 100) + `"}}`
+	row := &encodedRow{
+		key:     []byte(`[1]`),
+		value:   []byte(value),
+		mvcc:    hlc.Timestamp{WallTime: 1, Logical: 2},
+		headers: []messageHeader{{key: `cdc_op`, value: []byte(`insert`)}},
+	}
+	for _, size := range []int64{1, 10, 64, int64(len(value)) - 1} {
+		t.Run(strconv.FormatInt(size, 10), func(t *testing.T) {
+			chunks := chunkRow(row, size)
+			require.Len(t, chunks, int((int64(len(value))+size-1)/size))
+			for _, chunk := range chunks {
+				require.Equal(t, row.key, chunk.key)
+				require.LessOrEqual(t, int64(len(chunk.value)), size)
+				// The headers of the row precede the headers of the chunk.
+				require.Equal(t, row.headers[0], chunk.headers[0])
+				require.Len(t, chunk.headers, 4)
+			}
+			require.Equal(t, `1.0000000002`, string(chunks[0].headers[1].value))
+			require.Equal(t, value, reassembleChunks(t, chunks))
+		})
+	}
+	// The headers of the row are not modified.
+	require.Len(t, row.headers, 1)
+}
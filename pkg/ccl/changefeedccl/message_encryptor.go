@@ -0,0 +1,139 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/backupccl/backupencryption"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// The headers attached to the messages whose values are encrypted (see
+// changefeedbase.OptEncryptionKMS).
+const (
+	// encryptionKeyIDHeader holds the ID of the master key of the KMS which
+	// wrapped the data key.
+	encryptionKeyIDHeader = `cdc_encryption_key_id`
+	// encryptionKeyHeader holds the data key wrapped by the KMS, in base64.
+	encryptionKeyHeader = `cdc_encryption_key`
+)
+
+// makeAggregatorEncryption opens the KMS with the specified URI on behalf of
+// the user, as backups do, and generates a data key wrapped by it.
+func makeAggregatorEncryption(
+	ctx context.Context, execCfg *sql.ExecutorConfig, kmsURI string, user username.SQLUsername,
+) (*execinfrapb.ChangeAggregatorSpec_Encryption, error) {
+	kmsEnv := backupencryption.MakeBackupKMSEnv(execCfg.Settings, &execCfg.ExternalIODirConfig,
+		execCfg.DB, user, execCfg.InternalExecutor)
+	var enc *execinfrapb.ChangeAggregatorSpec_Encryption
+	err := withKMS(ctx, kmsURI, &kmsEnv, func(kms cloud.KMS) (err error) {
+		enc, err = newAggregatorEncryption(ctx, kms)
+		return err
+	})
+	return enc, err
+}
+
+// makeMessageEncryptor opens the KMS with the specified URI on behalf of the
+// user, and unwraps the data key of the aggregator with it.
+func makeMessageEncryptor(
+	ctx context.Context,
+	cfg *execinfra.ServerConfig,
+	kmsURI string,
+	user username.SQLUsername,
+	enc *execinfrapb.ChangeAggregatorSpec_Encryption,
+) (*messageEncryptor, error) {
+	kmsEnv := backupencryption.MakeBackupKMSEnv(cfg.Settings, &cfg.ExternalIODirConfig,
+		cfg.DB, user, cfg.Executor)
+	var e *messageEncryptor
+	err := withKMS(ctx, kmsURI, &kmsEnv, func(kms cloud.KMS) (err error) {
+		e, err = newMessageEncryptor(ctx, kms, enc)
+		return err
+	})
+	return e, err
+}
+
+// withKMS opens the KMS with the specified URI, and closes it once fn returns.
+func withKMS(ctx context.Context, kmsURI string, env cloud.KMSEnv, fn func(cloud.KMS) error) error {
+	kms, err := cloud.KMSFromURI(ctx, kmsURI, env)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := kms.Close(); err != nil {
+			log.Infof(ctx, "failed to close KMS: %+v", err)
+		}
+	}()
+	return fn(kms)
+}
+
+// newAggregatorEncryption generates a data key and wraps it with the KMS. Only
+// the wrapped key is retained, so that the specs of the aggregators never hold
+// the data key in the clear.
+func newAggregatorEncryption(
+	ctx context.Context, kms cloud.KMS,
+) (*execinfrapb.ChangeAggregatorSpec_Encryption, error) {
+	key := make([]byte, 32)
+	if _, err := cryptorand.Read(key); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := kms.Encrypt(ctx, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wrap the data key")
+	}
+	keyID, err := kms.MasterKeyID()
+	if err != nil {
+		return nil, err
+	}
+	return &execinfrapb.ChangeAggregatorSpec_Encryption{
+		WrappedKey: wrappedKey,
+		KeyID:      keyID,
+	}, nil
+}
+
+// messageEncryptor encrypts the values of the messages with the data key of
+// the aggregator, in the format of the encrypted files of backups (see
+// storageccl.EncryptFile). The data key, wrapped by the master key of the KMS,
+// is attached to the messages along with the ID of the master key, so that
+// their consumers can unwrap it with the KMS.
+type messageEncryptor struct {
+	key     []byte
+	headers []messageHeader
+}
+
+// newMessageEncryptor unwraps the data key of the aggregator with the KMS.
+func newMessageEncryptor(
+	ctx context.Context, kms cloud.KMS, enc *execinfrapb.ChangeAggregatorSpec_Encryption,
+) (*messageEncryptor, error) {
+	key, err := kms.Decrypt(ctx, enc.WrappedKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unwrap the data key")
+	}
+	return &messageEncryptor{
+		key: key,
+		headers: []messageHeader{
+			{key: encryptionKeyIDHeader, value: []byte(enc.KeyID)},
+			{key: encryptionKeyHeader, value: []byte(base64.StdEncoding.EncodeToString(enc.WrappedKey))},
+		},
+	}, nil
+}
+
+// encrypt returns the encrypted value.
+func (e *messageEncryptor) encrypt(value []byte) ([]byte, error) {
+	return storageccl.EncryptFile(value, e.key)
+}
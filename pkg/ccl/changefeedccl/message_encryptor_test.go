@@ -0,0 +1,75 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// testKMS wraps the data keys by reversing them.
+type testKMS struct{}
+
+var _ cloud.KMS = testKMS{}
+
+func (testKMS) MasterKeyID() (string, error) { return "test-key", nil }
+
+func (testKMS) Encrypt(_ context.Context, data []byte) ([]byte, error) {
+	wrapped := make([]byte, len(data))
+	for i := range data {
+		wrapped[len(data)-1-i] = data[i]
+	}
+	return wrapped, nil
+}
+
+func (k testKMS) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	return k.Encrypt(ctx, data)
+}
+
+func (testKMS) Close() error { return nil }
+
+func TestMessageEncryptor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	enc, err := newAggregatorEncryption(ctx, testKMS{})
+	require.NoError(t, err)
+	require.Len(t, enc.WrappedKey, 32)
+	require.Equal(t, "test-key", enc.KeyID)
+
+	// The aggregators unwrap the data key with the KMS.
+	e, err := newMessageEncryptor(ctx, testKMS{}, enc)
+	require.NoError(t, err)
+	key, err := testKMS{}.Decrypt(ctx, enc.WrappedKey)
+	require.NoError(t, err)
+	require.Equal(t, key, e.key)
+	encrypted, err := e.encrypt([]byte(`{"after": {"a": 1}}`))
+	require.NoError(t, err)
+	require.True(t, storageccl.AppearsEncrypted(encrypted))
+
+	// The consumers of the messages unwrap the data key of the headers with
+	// the KMS, and decrypt the values with it.
+	require.Len(t, e.headers, 2)
+	require.Equal(t, messageHeader{key: encryptionKeyIDHeader, value: []byte("test-key")}, e.headers[0])
+	require.Equal(t, encryptionKeyHeader, e.headers[1].key)
+	wrappedKey, err := base64.StdEncoding.DecodeString(string(e.headers[1].value))
+	require.NoError(t, err)
+	require.Equal(t, enc.WrappedKey, wrappedKey)
+	decrypted, err := storageccl.DecryptFile(ctx, encrypted, key, nil /* mm */)
+	require.NoError(t, err)
+	require.Equal(t, `{"after": {"a": 1}}`, string(decrypted))
+}
@@ -59,6 +59,10 @@ type AggMetrics struct {
 	RunningCount              *aggmetric.AggGauge
 	BatchReductionCount       *aggmetric.AggGauge
 	InternalRetryMessageCount *aggmetric.AggGauge
+	InFlightBatches           *aggmetric.AggGauge
+	SinkRetries               *aggmetric.AggCounter
+	SinkErrors                *aggmetric.AggCounter
+	SinkBackpressureNanos     *aggmetric.AggCounter
 
 	// There is always at least 1 sliMetrics created for defaultSLI scope.
 	mu struct {
@@ -79,6 +83,10 @@ var nilMetricsRecorderBuilder metricsRecorderBuilder = func(_ bool) metricsRecor
 type metricsRecorder interface {
 	recordMessageSize(int64)
 	recordInternalRetry(int64, bool)
+	recordInFlightBatchChange(int64)
+	recordSinkRetry()
+	recordSinkError()
+	recordSinkBackpressure(time.Duration)
 	recordOneMessage() recordOneMessageCallback
 	recordEmittedBatch(startTime time.Time, numMessages int, mvcc hlc.Timestamp, bytes int, compressedBytes int)
 	recordResolvedCallback() func()
@@ -110,6 +118,10 @@ type sliMetrics struct {
 	RunningCount              *aggmetric.Gauge
 	BatchReductionCount       *aggmetric.Gauge
 	InternalRetryMessageCount *aggmetric.Gauge
+	InFlightBatches           *aggmetric.Gauge
+	SinkRetries               *aggmetric.Counter
+	SinkErrors                *aggmetric.Counter
+	SinkBackpressureNanos     *aggmetric.Counter
 }
 
 // sinkDoesNotCompress is a sentinel value indicating the sink
@@ -148,6 +160,30 @@ func (m *sliMetrics) recordInternalRetry(numMessages int64, reducedBatchSize boo
 	m.InternalRetryMessageCount.Inc(numMessages)
 }
 
+func (m *sliMetrics) recordInFlightBatchChange(delta int64) {
+	if m != nil {
+		m.InFlightBatches.Inc(delta)
+	}
+}
+
+func (m *sliMetrics) recordSinkRetry() {
+	if m != nil {
+		m.SinkRetries.Inc(1)
+	}
+}
+
+func (m *sliMetrics) recordSinkError() {
+	if m != nil {
+		m.SinkErrors.Inc(1)
+	}
+}
+
+func (m *sliMetrics) recordSinkBackpressure(d time.Duration) {
+	if m != nil {
+		m.SinkBackpressureNanos.Inc(d.Nanoseconds())
+	}
+}
+
 func (m *sliMetrics) recordEmittedBatch(
 	startTime time.Time, numMessages int, mvcc hlc.Timestamp, bytes int, compressedBytes int,
 ) {
@@ -266,6 +302,22 @@ func (w *wrappingCostController) recordInternalRetry(numMessages int64, reducedB
 	w.inner.recordInternalRetry(numMessages, reducedBatchSize)
 }
 
+func (w *wrappingCostController) recordInFlightBatchChange(delta int64) {
+	w.inner.recordInFlightBatchChange(delta)
+}
+
+func (w *wrappingCostController) recordSinkRetry() {
+	w.inner.recordSinkRetry()
+}
+
+func (w *wrappingCostController) recordSinkError() {
+	w.inner.recordSinkError()
+}
+
+func (w *wrappingCostController) recordSinkBackpressure(d time.Duration) {
+	w.inner.recordSinkBackpressure(d)
+}
+
 func (w *wrappingCostController) recordResolvedCallback() func() {
 	// TODO(ssd): We don't count resolved messages currently. These messages should be relatively
 	// small and the error here is further in the favor of the user.
@@ -325,6 +377,13 @@ var (
 		Measurement: "Changefeeds",
 		Unit:        metric.Unit_NANOSECONDS,
 	}
+	metaChangefeedResolvedFlushDelayNanos = metric.Metadata{
+		Name: "changefeed.resolved_flush_delay_nanos",
+		Help: "Time between the frontier of a changefeed aggregator advancing and its " +
+			"resolved spans being forwarded, spent waiting for the sink to deliver the rows they cover",
+		Measurement: "Changefeeds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
 
 	// TODO(dan): This was intended to be a measure of the minimum distance of
 	// any changefeed ahead of its gc ttl threshold, but keeping that correct in
@@ -350,6 +409,36 @@ var (
 		Measurement: "Replans",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaChangefeedSuppressedDuplicates = metric.Metadata{
+		Name:        "changefeed.suppress_duplicates.suppressed",
+		Help:        "Rows suppressed because a row for the same key was emitted within suppress_duplicates_window",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedSuppressionEvictions = metric.Metadata{
+		Name:        "changefeed.suppress_duplicates.evictions",
+		Help:        "Keys evicted from the cache tracking the keys for suppress_duplicates_window",
+		Measurement: "Keys",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedDeadLetterMessages = metric.Metadata{
+		Name:        "changefeed.dead_letter_messages",
+		Help:        "Rows routed to the dead letter sinks of changefeeds because they could not be encoded or delivered",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedSinkConnections = metric.Metadata{
+		Name:        "changefeed.sink_connection_pool.connections",
+		Help:        "Number of kafka and webhook clients shared by the sinks of changefeeds",
+		Measurement: "Connections",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedSinkConnectionSinks = metric.Metadata{
+		Name:        "changefeed.sink_connection_pool.sinks",
+		Help:        "Number of sinks of changefeeds using shared kafka and webhook clients",
+		Measurement: "Sinks",
+		Unit:        metric.Unit_COUNT,
+	}
 )
 
 func newAggregateMetrics(histogramWindow time.Duration) *AggMetrics {
@@ -444,6 +533,30 @@ func newAggregateMetrics(histogramWindow time.Duration) *AggMetrics {
 		Measurement: "Messages",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaInFlightBatches := metric.Metadata{
+		Name:        "changefeed.sink_batches_in_flight",
+		Help:        "Number of message batches currently being sent to the sink",
+		Measurement: "Batches",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSinkRetries := metric.Metadata{
+		Name:        "changefeed.sink_retries",
+		Help:        "Number of requests to the sink retried after an error",
+		Measurement: "Retries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSinkErrors := metric.Metadata{
+		Name:        "changefeed.sink_errors",
+		Help:        "Number of errors returned by the sink",
+		Measurement: "Errors",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSinkBackpressureNanos := metric.Metadata{
+		Name:        "changefeed.sink_backpressure_nanos",
+		Help:        "Total time spent waiting for the sink to acknowledge or accept more messages, e.g. while kafka brokers throttle it",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
 	// NB: When adding new histograms, use sigFigs = 1.  Older histograms
 	// retain significant figures of 2.
 	b := aggmetric.MakeBuilder("scope")
@@ -469,6 +582,10 @@ func newAggregateMetrics(histogramWindow time.Duration) *AggMetrics {
 		RunningCount:              b.Gauge(metaChangefeedRunning),
 		BatchReductionCount:       b.Gauge(metaBatchReductionCount),
 		InternalRetryMessageCount: b.Gauge(metaInternalRetryMessageCount),
+		InFlightBatches:           b.Gauge(metaInFlightBatches),
+		SinkRetries:               b.Counter(metaSinkRetries),
+		SinkErrors:                b.Counter(metaSinkErrors),
+		SinkBackpressureNanos:     b.Counter(metaSinkBackpressureNanos),
 	}
 	a.mu.sliMetrics = make(map[string]*sliMetrics)
 	_, err := a.getOrCreateScope(defaultSLIScope)
@@ -528,12 +645,54 @@ func (a *AggMetrics) getOrCreateScope(scope string) (*sliMetrics, error) {
 		RunningCount:              a.RunningCount.AddChild(scope),
 		BatchReductionCount:       a.BatchReductionCount.AddChild(scope),
 		InternalRetryMessageCount: a.InternalRetryMessageCount.AddChild(scope),
+		InFlightBatches:           a.InFlightBatches.AddChild(scope),
+		SinkRetries:               a.SinkRetries.AddChild(scope),
+		SinkErrors:                a.SinkErrors.AddChild(scope),
+		SinkBackpressureNanos:     a.SinkBackpressureNanos.AddChild(scope),
 	}
 
 	a.mu.sliMetrics[scope] = sm
 	return sm, nil
 }
 
+// releaseScope unregisters the metrics of the specified scope unless it is the
+// default scope or there are changefeeds using that scope still running on
+// this node.
+func (a *AggMetrics) releaseScope(scope string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	scope = strings.TrimSpace(strings.ToLower(scope))
+	if scope == "" || scope == defaultSLIScope {
+		return
+	}
+	sm, ok := a.mu.sliMetrics[scope]
+	if !ok || sm.RunningCount.Value() > 0 {
+		return
+	}
+
+	sm.EmittedMessages.Destroy()
+	sm.MessageSize.Destroy()
+	sm.EmittedBytes.Destroy()
+	sm.FlushedBytes.Destroy()
+	sm.BatchHistNanos.Destroy()
+	sm.Flushes.Destroy()
+	sm.FlushHistNanos.Destroy()
+	sm.CommitLatency.Destroy()
+	sm.ErrorRetries.Destroy()
+	sm.AdmitLatency.Destroy()
+	sm.BackfillCount.Destroy()
+	sm.BackfillPendingRanges.Destroy()
+	sm.RunningCount.Destroy()
+	sm.BatchReductionCount.Destroy()
+	sm.InternalRetryMessageCount.Destroy()
+	sm.InFlightBatches.Destroy()
+	sm.SinkRetries.Destroy()
+	sm.SinkErrors.Destroy()
+	sm.SinkBackpressureNanos.Destroy()
+	delete(a.mu.sliMetrics, scope)
+}
+
 // Metrics are for production monitoring of changefeeds.
 type Metrics struct {
 	AggMetrics          *AggMetrics
@@ -543,9 +702,23 @@ type Metrics struct {
 	ResolvedMessages    *metric.Counter
 	QueueTimeNanos      *metric.Counter
 	CheckpointHistNanos *metric.Histogram
-	FrontierUpdates     *metric.Counter
-	ThrottleMetrics     cdcutils.Metrics
-	ReplanCount         *metric.Counter
+	// ResolvedFlushDelayNanos tracks the time spent by the aggregators
+	// flushing the rows covered by their resolved spans before forwarding them.
+	ResolvedFlushDelayNanos *metric.Histogram
+	FrontierUpdates         *metric.Counter
+	ThrottleMetrics         cdcutils.Metrics
+	ReplanCount             *metric.Counter
+	// SuppressedDuplicates and SuppressionEvictions track duplicate
+	// suppression (see changefeedbase.OptSuppressDuplicatesWindow).
+	SuppressedDuplicates *metric.Counter
+	SuppressionEvictions *metric.Counter
+	// DeadLetterMessages counts the rows routed to the dead letter sinks of
+	// changefeeds (see changefeedbase.OptDeadLetter).
+	DeadLetterMessages *metric.Counter
+	// SinkConnections and SinkConnectionSinks track the clients shared by the
+	// sinks of the changefeeds running on the node (see sinkConnectionPool).
+	SinkConnections     *metric.Gauge
+	SinkConnectionSinks *metric.Gauge
 
 	mu struct {
 		syncutil.Mutex
@@ -574,9 +747,23 @@ func MakeMetrics(histogramWindow time.Duration) metric.Struct {
 		QueueTimeNanos:    metric.NewCounter(metaEventQueueTime),
 		CheckpointHistNanos: metric.NewHistogram(metaChangefeedCheckpointHistNanos, histogramWindow,
 			changefeedCheckpointHistMaxLatency.Nanoseconds(), 2),
+		ResolvedFlushDelayNanos: metric.NewHistogram(metaChangefeedResolvedFlushDelayNanos, histogramWindow,
+			changefeedFlushHistMaxLatency.Nanoseconds(), 2),
 		FrontierUpdates: metric.NewCounter(metaChangefeedFrontierUpdates),
 		ThrottleMetrics: cdcutils.MakeMetrics(histogramWindow),
 		ReplanCount:     metric.NewCounter(metaChangefeedReplanCount),
+
+		SuppressedDuplicates: metric.NewCounter(metaChangefeedSuppressedDuplicates),
+		SuppressionEvictions: metric.NewCounter(metaChangefeedSuppressionEvictions),
+		DeadLetterMessages:   metric.NewCounter(metaChangefeedDeadLetterMessages),
+		SinkConnections: metric.NewFunctionalGauge(metaChangefeedSinkConnections, func() int64 {
+			conns, _ := sinkConnections.stats()
+			return conns
+		}),
+		SinkConnectionSinks: metric.NewFunctionalGauge(metaChangefeedSinkConnectionSinks, func() int64 {
+			_, sinks := sinkConnections.stats()
+			return sinks
+		}),
 	}
 
 	m.mu.resolved = make(map[int]hlc.Timestamp)
@@ -0,0 +1,320 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+)
+
+// The columns added to the parquet files after the columns of the rows.
+const (
+	parquetUpdatedColumn = "_crdb_updated"
+	parquetDeletedColumn = "_crdb_deleted"
+)
+
+// parquetKind is the type of a column of the parquet files written by
+// changefeeds.
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetInt64
+	parquetDouble
+	parquetBool
+	parquetBinary
+	parquetTimestamp
+	parquetDate
+)
+
+// parquetKindOf returns the type of the parquet columns of a type. The types
+// without a parquet counterpart, as well as the arrays and the JSON values,
+// are stored as strings, which hold their JSON encoding for the arrays and the
+// JSON values.
+func parquetKindOf(typ *types.T) parquetKind {
+	switch typ.Family() {
+	case types.IntFamily:
+		return parquetInt64
+	case types.FloatFamily:
+		return parquetDouble
+	case types.BoolFamily:
+		return parquetBool
+	case types.BytesFamily:
+		return parquetBinary
+	case types.TimestampTZFamily:
+		return parquetTimestamp
+	case types.DateFamily:
+		return parquetDate
+	default:
+		return parquetString
+	}
+}
+
+// schemaElement returns the definition of an optional column of the type.
+func (k parquetKind) schemaElement(name string) *parquet.SchemaElement {
+	el := parquet.NewSchemaElement()
+	el.Name = name
+	el.RepetitionType = parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_OPTIONAL)
+	switch k {
+	case parquetString:
+		el.Type = parquet.TypePtr(parquet.Type_BYTE_ARRAY)
+		el.LogicalType = parquet.NewLogicalType()
+		el.LogicalType.STRING = parquet.NewStringType()
+		el.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)
+	case parquetInt64:
+		el.Type = parquet.TypePtr(parquet.Type_INT64)
+		el.LogicalType = parquet.NewLogicalType()
+		el.LogicalType.INTEGER = parquet.NewIntType()
+		el.LogicalType.INTEGER.BitWidth = 64
+		el.LogicalType.INTEGER.IsSigned = true
+		el.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_INT_64)
+	case parquetDouble:
+		el.Type = parquet.TypePtr(parquet.Type_DOUBLE)
+	case parquetBool:
+		el.Type = parquet.TypePtr(parquet.Type_BOOLEAN)
+	case parquetBinary:
+		el.Type = parquet.TypePtr(parquet.Type_BYTE_ARRAY)
+	case parquetTimestamp:
+		el.Type = parquet.TypePtr(parquet.Type_INT64)
+		el.LogicalType = parquet.NewLogicalType()
+		el.LogicalType.TIMESTAMP = parquet.NewTimestampType()
+		el.LogicalType.TIMESTAMP.IsAdjustedToUTC = true
+		el.LogicalType.TIMESTAMP.Unit = parquet.NewTimeUnit()
+		el.LogicalType.TIMESTAMP.Unit.MICROS = parquet.NewMicroSeconds()
+		el.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MICROS)
+	case parquetDate:
+		el.Type = parquet.TypePtr(parquet.Type_INT32)
+		el.LogicalType = parquet.NewLogicalType()
+		el.LogicalType.DATE = parquet.NewDateType()
+		el.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_DATE)
+	}
+	return el
+}
+
+// parquetKindOfElement returns the type of a column of a parquet file written
+// by a changefeed.
+func parquetKindOfElement(el *parquet.SchemaElement) (parquetKind, error) {
+	if el.Type == nil {
+		return 0, errors.Errorf("parquet column %s is not a primitive column", el.Name)
+	}
+	switch *el.Type {
+	case parquet.Type_BOOLEAN:
+		return parquetBool, nil
+	case parquet.Type_INT32:
+		return parquetDate, nil
+	case parquet.Type_INT64:
+		if el.LogicalType != nil && el.LogicalType.TIMESTAMP != nil {
+			return parquetTimestamp, nil
+		}
+		return parquetInt64, nil
+	case parquet.Type_DOUBLE:
+		return parquetDouble, nil
+	case parquet.Type_BYTE_ARRAY:
+		if (el.LogicalType != nil && el.LogicalType.STRING != nil) ||
+			(el.ConvertedType != nil && *el.ConvertedType == parquet.ConvertedType_UTF8) {
+			return parquetString, nil
+		}
+		return parquetBinary, nil
+	default:
+		return 0, errors.Errorf("parquet column %s has unexpected type %s", el.Name, el.Type)
+	}
+}
+
+// parquetColumnName returns the name of the parquet column of a column, in
+// which the characters which table formats such as Delta Lake do not allow in
+// column names are replaced by underscores.
+func parquetColumnName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" ,;{}()\n\t=", r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// parquetColumn is a column of the parquet files of the rows of a topic, which
+// is encoded from a column of the changefeed rows.
+type parquetColumn struct {
+	name string
+	kind parquetKind
+	// source is the name of the column in the changefeed rows.
+	source string
+	// keyIdx is the index of the column in the primary key, or -1.
+	keyIdx int
+}
+
+// parquetRowSchema is the schema of the parquet files of the rows of a topic:
+// its columns are the columns of the rows, followed by the _crdb_updated and
+// _crdb_deleted columns.
+type parquetRowSchema struct {
+	columns    []parquetColumn
+	definition *parquetschema.SchemaDefinition
+}
+
+// makeParquetRowSchema returns the schema of the rows described by an event
+// descriptor.
+func makeParquetRowSchema(desc *cdcevent.EventDescriptor) (*parquetRowSchema, error) {
+	sc := &parquetRowSchema{
+		definition: &parquetschema.SchemaDefinition{
+			RootColumn: &parquetschema.ColumnDefinition{SchemaElement: parquet.NewSchemaElement()},
+		},
+	}
+	sc.definition.RootColumn.SchemaElement.Name = "root"
+	// The table formats reading the files treat the column names as case
+	// insensitive.
+	names := make(map[string]string)
+	addColumn := func(col parquetColumn) error {
+		lowerName := strings.ToLower(col.name)
+		if other, ok := names[lowerName]; ok {
+			return errors.Errorf("columns %q and %q both map to parquet column %s",
+				other, col.source, col.name)
+		}
+		names[lowerName] = col.source
+		sc.columns = append(sc.columns, col)
+		sc.definition.RootColumn.Children = append(sc.definition.RootColumn.Children,
+			&parquetschema.ColumnDefinition{SchemaElement: col.kind.schemaElement(col.name)})
+		return nil
+	}
+
+	// The primary key columns come first, and are always set from the keys of
+	// the rows, which also have them when the rows are deleted.
+	keyCols := desc.KeyColumns()
+	isKey := make(map[string]struct{}, len(keyCols))
+	for i, col := range keyCols {
+		if err := addColumn(parquetColumn{
+			name: parquetColumnName(col.Name), kind: parquetKindOf(col.Typ), source: col.Name, keyIdx: i,
+		}); err != nil {
+			return nil, err
+		}
+		isKey[col.Name] = struct{}{}
+	}
+	for _, col := range desc.ValueColumns() {
+		if _, ok := isKey[col.Name]; ok {
+			continue
+		}
+		if err := addColumn(parquetColumn{
+			name: parquetColumnName(col.Name), kind: parquetKindOf(col.Typ), source: col.Name, keyIdx: -1,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, meta := range []parquetColumn{
+		{name: parquetUpdatedColumn, kind: parquetString, source: parquetUpdatedColumn, keyIdx: -1},
+		{name: parquetDeletedColumn, kind: parquetBool, source: parquetDeletedColumn, keyIdx: -1},
+	} {
+		if err := addColumn(meta); err != nil {
+			return nil, err
+		}
+	}
+	return sc, nil
+}
+
+// newFileWriter returns a writer of parquet files with the schema.
+func (sc *parquetRowSchema) newFileWriter(
+	w io.Writer, codec parquet.CompressionCodec,
+) *goparquet.FileWriter {
+	return goparquet.NewFileWriter(w,
+		goparquet.WithCompressionCodec(codec),
+		goparquet.WithSchemaDefinition(sc.definition),
+	)
+}
+
+// encodeRow returns the values of the columns of a row, from the key and the
+// value of the row encoded in JSON with the wrapped envelope, in the form
+// accepted by the parquet file writers. The NULL values are omitted.
+func (sc *parquetRowSchema) encodeRow(
+	key, value []byte, updated hlc.Timestamp,
+) (map[string]interface{}, error) {
+	var keyDatums []json.RawMessage
+	if err := json.Unmarshal(key, &keyDatums); err != nil {
+		return nil, errors.Wrap(err, "decoding key")
+	}
+	var envelope struct {
+		After map[string]json.RawMessage `json:"after"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return nil, errors.Wrap(err, "decoding value")
+	}
+
+	row := make(map[string]interface{}, len(sc.columns))
+	for _, col := range sc.columns[:len(sc.columns)-2] {
+		v := envelope.After[col.source]
+		if col.keyIdx >= 0 {
+			if col.keyIdx >= len(keyDatums) {
+				return nil, errors.AssertionFailedf("key %s is missing column %s", key, col.source)
+			}
+			v = keyDatums[col.keyIdx]
+		}
+		if isJSONNull(v) {
+			continue
+		}
+		encoded, err := encodeParquetValue(col.kind, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding column %s", col.source)
+		}
+		row[col.name] = encoded
+	}
+	row[parquetUpdatedColumn] = []byte(updated.AsOfSystemTime())
+	row[parquetDeletedColumn] = envelope.After == nil
+	return row, nil
+}
+
+// encodeParquetValue converts a JSON value, as encoded by tree.AsJSON, to the
+// value of a parquet column of a type.
+func encodeParquetValue(kind parquetKind, v json.RawMessage) (interface{}, error) {
+	// text is the content of the JSON strings, and the JSON text of the other
+	// values.
+	text := string(v)
+	if len(v) > 0 && v[0] == '"' {
+		if err := json.Unmarshal(v, &text); err != nil {
+			return nil, err
+		}
+	}
+
+	switch kind {
+	case parquetInt64:
+		return strconv.ParseInt(text, 10, 64)
+	case parquetDouble:
+		// The infinite and NaN floats are encoded as strings.
+		return strconv.ParseFloat(text, 64)
+	case parquetBool:
+		return strconv.ParseBool(text)
+	case parquetBinary:
+		if !strings.HasPrefix(text, `\x`) {
+			return nil, errors.Errorf("expected hex encoded bytes, got %q", text)
+		}
+		return hex.DecodeString(text[2:])
+	case parquetTimestamp:
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, err
+		}
+		return t.UnixMicro(), nil
+	case parquetDate:
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, err
+		}
+		return int32(t.Unix() / int64(24*time.Hour/time.Second)), nil
+	default:
+		return []byte(text), nil
+	}
+}
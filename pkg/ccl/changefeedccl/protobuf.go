@@ -0,0 +1,378 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufScalar is the protobuf scalar type a column is encoded as.
+type protobufScalar string
+
+// The protobuf scalar types the columns are encoded as. Integers of all sizes
+// are encoded as int64, so that widening an integer column stays compatible,
+// and the types without a natural protobuf counterpart are encoded as their
+// textual representation.
+const (
+	protobufBool   protobufScalar = `bool`
+	protobufInt64  protobufScalar = `int64`
+	protobufDouble protobufScalar = `double`
+	protobufBytes  protobufScalar = `bytes`
+	protobufString protobufScalar = `string`
+)
+
+// The numbers of the fields of the envelope message. They are fixed, so that
+// the envelope of the resolved timestamps, which is registered under the same
+// subject as the envelope of the rows, is compatible with it.
+const (
+	protobufEnvelopeAfter    protowire.Number = 1
+	protobufEnvelopeBefore   protowire.Number = 2
+	protobufEnvelopeUpdated  protowire.Number = 3
+	protobufEnvelopeResolved protowire.Number = 4
+)
+
+// protobufField is a field of a protobuf message, which holds a column of a
+// row.
+type protobufField struct {
+	name     string
+	num      protowire.Number
+	scalar   protobufScalar
+	repeated bool
+}
+
+// protobufRowMessage is a protobuf message whose fields hold the columns of a
+// row.
+type protobufRowMessage struct {
+	name   string
+	fields []protobufField
+}
+
+// protobufEnvelopeMessage is the protobuf message of the values of a
+// changefeed, which wraps the rows along with their metadata.
+type protobufEnvelopeMessage struct {
+	name          string
+	after, before *protobufRowMessage
+	updatedField  bool
+	resolvedField bool
+}
+
+// columnToProtobufScalar returns the protobuf scalar type of a column of the
+// specified type, and whether the column is a repeated field.
+func columnToProtobufScalar(typ *types.T) (protobufScalar, bool, error) {
+	switch typ.Family() {
+	case types.BoolFamily:
+		return protobufBool, false, nil
+	case types.IntFamily:
+		return protobufInt64, false, nil
+	case types.FloatFamily:
+		return protobufDouble, false, nil
+	case types.BytesFamily:
+		return protobufBytes, false, nil
+	case types.ArrayFamily:
+		elem, repeated, err := columnToProtobufScalar(typ.ArrayContents())
+		if err != nil {
+			return ``, false, err
+		}
+		if repeated {
+			return ``, false, errors.Errorf(`type %s is not supported by the protobuf format`, typ.SQLString())
+		}
+		return elem, true, nil
+	default:
+		return protobufString, false, nil
+	}
+}
+
+// protobufFieldNumbers returns the numbers of the fields holding the columns.
+// Columns are numbered after their column IDs, which are never reused, so
+// that the messages of the successive versions of a table are compatible as
+// columns are added and dropped. Columns computed by CDC expressions have no
+// column ID, in which case the columns are numbered after their position;
+// since CDC expressions require schema_change_policy='stop', the schema of
+// such changefeeds does not evolve.
+func protobufFieldNumbers(cols []cdcevent.ResultColumn) []protowire.Number {
+	nums := make([]protowire.Number, len(cols))
+	seen := make(map[uint32]struct{}, len(cols))
+	for i, col := range cols {
+		if _, ok := seen[col.PGAttributeNum]; ok || col.PGAttributeNum == 0 {
+			for j := range cols {
+				nums[j] = protowire.Number(j + 1)
+			}
+			return nums
+		}
+		seen[col.PGAttributeNum] = struct{}{}
+		nums[i] = protowire.Number(col.PGAttributeNum)
+	}
+	return nums
+}
+
+// rowToProtobufMessage returns the protobuf message holding the columns
+// iterated by it.
+func rowToProtobufMessage(name string, it cdcevent.Iterator) (*protobufRowMessage, error) {
+	var cols []cdcevent.ResultColumn
+	if err := it.Col(func(col cdcevent.ResultColumn) error {
+		cols = append(cols, col)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	msg := &protobufRowMessage{name: name}
+	names := make(map[string]struct{}, len(cols))
+	for i, num := range protobufFieldNumbers(cols) {
+		if !num.IsValid() {
+			return nil, errors.Errorf(
+				`column %s cannot be numbered %d by the protobuf format`, cols[i].Name, num)
+		}
+		scalar, repeated, err := columnToProtobufScalar(cols[i].Typ)
+		if err != nil {
+			return nil, errors.Wrapf(err, `column %s`, cols[i].Name)
+		}
+		f := protobufField{
+			name:     SQLNameToAvroName(cols[i].Name),
+			num:      num,
+			scalar:   scalar,
+			repeated: repeated,
+		}
+		if _, ok := names[f.name]; ok {
+			return nil, errors.Errorf(
+				`column %s collides with another column in the protobuf format`, cols[i].Name)
+		}
+		names[f.name] = struct{}{}
+		msg.fields = append(msg.fields, f)
+	}
+	return msg, nil
+}
+
+// equal returns whether the messages have the same fields.
+func (m *protobufRowMessage) equal(o *protobufRowMessage) bool {
+	if len(m.fields) != len(o.fields) {
+		return false
+	}
+	for i := range m.fields {
+		if m.fields[i] != o.fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFields writes the definitions of the fields of the message.
+func (m *protobufRowMessage) writeFields(buf *strings.Builder, indent string) {
+	for _, f := range m.fields {
+		// Fields are optional, so that NULLs can be told apart from zero
+		// values. Repeated fields cannot be optional; NULL arrays are encoded
+		// as empty ones.
+		label := `optional`
+		if f.repeated {
+			label = `repeated`
+		}
+		fmt.Fprintf(buf, "%s%s %s %s = %d;\n", indent, label, f.scalar, f.name, f.num)
+	}
+}
+
+// Schema returns the proto3 definition of the message, as registered in the
+// schema registry.
+func (m *protobufRowMessage) Schema() string {
+	var buf strings.Builder
+	buf.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&buf, "message %s {\n", m.name)
+	m.writeFields(&buf, `  `)
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// Schema returns the proto3 definition of the envelope message, as
+// registered in the schema registry. The messages of the rows are nested in
+// the envelope, which is the first (and only) message of the definition.
+func (m *protobufEnvelopeMessage) Schema() string {
+	var buf strings.Builder
+	buf.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&buf, "message %s {\n", m.name)
+	if m.after != nil {
+		fmt.Fprintf(&buf, "  %s after = %d;\n", m.after.name, protobufEnvelopeAfter)
+	}
+	if m.before != nil {
+		fmt.Fprintf(&buf, "  %s before = %d;\n", m.before.name, protobufEnvelopeBefore)
+	}
+	if m.updatedField {
+		fmt.Fprintf(&buf, "  optional string updated = %d;\n", protobufEnvelopeUpdated)
+	}
+	if m.resolvedField {
+		fmt.Fprintf(&buf, "  optional string resolved = %d;\n", protobufEnvelopeResolved)
+	}
+	// The before field shares the message of the after field, unless the
+	// previous row has another version of the table.
+	rows := []*protobufRowMessage{m.after}
+	if m.before != m.after {
+		rows = append(rows, m.before)
+	}
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n  message %s {\n", row.name)
+		row.writeFields(&buf, `    `)
+		buf.WriteString("  }\n")
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// appendRow appends the encoding of the columns iterated by it to buf.
+func (m *protobufRowMessage) appendRow(buf []byte, it cdcevent.Iterator) ([]byte, error) {
+	i := 0
+	err := it.Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if i >= len(m.fields) {
+			return errors.AssertionFailedf(`unexpected column %s`, col.Name)
+		}
+		var err error
+		buf, err = m.fields[i].appendDatum(buf, d)
+		i++
+		return errors.Wrapf(err, `column %s`, col.Name)
+	})
+	return buf, err
+}
+
+// appendDatum appends the encoding of the datum to buf. NULLs are omitted.
+func (f protobufField) appendDatum(buf []byte, d tree.Datum) ([]byte, error) {
+	d = tree.UnwrapDOidWrapper(d)
+	if d == tree.DNull {
+		return buf, nil
+	}
+	if !f.repeated {
+		return appendProtobufScalar(buf, f.num, f.scalar, d)
+	}
+
+	arr, ok := d.(*tree.DArray)
+	if !ok {
+		return nil, errors.AssertionFailedf(`expected array, found %T`, d)
+	}
+	for _, elem := range arr.Array {
+		if elem == tree.DNull {
+			return nil, errors.New(`the protobuf format cannot encode NULL array elements`)
+		}
+	}
+	switch f.scalar {
+	case protobufString, protobufBytes:
+		for _, elem := range arr.Array {
+			var err error
+			if buf, err = appendProtobufScalar(buf, f.num, f.scalar, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		// Repeated numeric fields are packed, as is the default in proto3.
+		var packed []byte
+		for _, elem := range arr.Array {
+			var err error
+			if packed, err = appendProtobufPackedScalar(packed, f.scalar, elem); err != nil {
+				return nil, err
+			}
+		}
+		buf = protowire.AppendTag(buf, f.num, protowire.BytesType)
+		return protowire.AppendBytes(buf, packed), nil
+	}
+}
+
+// appendProtobufScalar appends the field holding the datum to buf.
+func appendProtobufScalar(
+	buf []byte, num protowire.Number, scalar protobufScalar, d tree.Datum,
+) ([]byte, error) {
+	switch scalar {
+	case protobufBool, protobufInt64:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	case protobufDouble:
+		buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+	default:
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	}
+	return appendProtobufPackedScalar(buf, scalar, d)
+}
+
+// appendProtobufPackedScalar appends the value of the datum, without a tag, to
+// buf.
+func appendProtobufPackedScalar(buf []byte, scalar protobufScalar, d tree.Datum) ([]byte, error) {
+	d = tree.UnwrapDOidWrapper(d)
+	switch scalar {
+	case protobufBool:
+		b, ok := d.(*tree.DBool)
+		if !ok {
+			return nil, errors.AssertionFailedf(`expected bool, found %T`, d)
+		}
+		return protowire.AppendVarint(buf, protowire.EncodeBool(bool(*b))), nil
+	case protobufInt64:
+		i, ok := d.(*tree.DInt)
+		if !ok {
+			return nil, errors.AssertionFailedf(`expected int, found %T`, d)
+		}
+		return protowire.AppendVarint(buf, uint64(*i)), nil
+	case protobufDouble:
+		f, ok := d.(*tree.DFloat)
+		if !ok {
+			return nil, errors.AssertionFailedf(`expected float, found %T`, d)
+		}
+		return protowire.AppendFixed64(buf, math.Float64bits(float64(*f))), nil
+	case protobufBytes:
+		b, ok := d.(*tree.DBytes)
+		if !ok {
+			return nil, errors.AssertionFailedf(`expected bytes, found %T`, d)
+		}
+		return protowire.AppendString(buf, string(*b)), nil
+	default:
+		if s, ok := d.(*tree.DString); ok {
+			return protowire.AppendString(buf, string(*s)), nil
+		}
+		return protowire.AppendString(buf, tree.AsStringWithFlags(d, tree.FmtBareStrings)), nil
+	}
+}
+
+// appendEnvelope appends the encoding of the envelope of the rows to buf.
+func (m *protobufEnvelopeMessage) appendEnvelope(
+	buf []byte, updated, resolved hlc.Timestamp, beforeRow, afterRow cdcevent.Row,
+) ([]byte, error) {
+	appendRowField := func(
+		buf []byte, num protowire.Number, msg *protobufRowMessage, row cdcevent.Row,
+	) ([]byte, error) {
+		if msg == nil || !row.HasValues() || row.IsDeleted() {
+			return buf, nil
+		}
+		encoded, err := msg.appendRow(nil, row.ForEachColumn())
+		if err != nil {
+			return nil, err
+		}
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendBytes(buf, encoded), nil
+	}
+	buf, err := appendRowField(buf, protobufEnvelopeAfter, m.after, afterRow)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = appendRowField(buf, protobufEnvelopeBefore, m.before, beforeRow); err != nil {
+		return nil, err
+	}
+	if m.updatedField {
+		buf = protowire.AppendTag(buf, protobufEnvelopeUpdated, protowire.BytesType)
+		buf = protowire.AppendString(buf, updated.AsOfSystemTime())
+	}
+	if m.resolvedField {
+		buf = protowire.AppendTag(buf, protobufEnvelopeResolved, protowire.BytesType)
+		buf = protowire.AppendString(buf, resolved.AsOfSystemTime())
+	}
+	return buf, nil
+}
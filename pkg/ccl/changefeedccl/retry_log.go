@@ -0,0 +1,83 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/flowinfra"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// The retry log of a changefeed records the most recent retryable errors which
+// caused its flow to restart, so that they can be inspected with SHOW
+// CHANGEFEED JOBS and crdb_internal.changefeed_retry_log rather than in the
+// logs of the nodes.
+//
+// The resumer accumulates the errors it retries, and hands them to the
+// frontier of the restarted flow, which records them in the job progress along
+// with its next checkpoint: the retry log does not cause any additional writes
+// to the jobs table. The entries are identified by their time, so that the
+// errors handed again to the next flows are recorded only once. The errors
+// which are not recorded before the resumer stops, e.g. because the changefeed
+// fails or is paused, are lost.
+
+// maxRetryLogEntries is the number of entries retained in the retry log of a
+// changefeed.
+const maxRetryLogEntries = 20
+
+// makeRetryLogEntry returns the retry log entry of the retryable error
+// encountered by the changefeed coordinated by the specified instance.
+func makeRetryLogEntry(err error, instanceID base.SQLInstanceID) jobspb.ChangefeedRetryLogEntry {
+	return jobspb.ChangefeedRetryLogEntry{
+		Time:       timeutil.Now(),
+		ErrorClass: retryErrorClass(err),
+		Error:      err.Error(),
+		InstanceID: instanceID,
+	}
+}
+
+// retryErrorClass returns a coarse classification of a retryable error.
+func retryErrorClass(err error) string {
+	switch {
+	case errors.Is(err, sql.ErrPlanChanged):
+		return "plan_changed"
+	case errors.HasType(err, (*roachpb.NodeUnavailableError)(nil)),
+		flowinfra.IsNoInboundStreamConnectionError(err):
+		return "node_unavailable"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "retryable"
+	}
+}
+
+// mergeRetryLog returns the entries of the retry log followed by the entries
+// which are more recent than its last one, trimmed to the last
+// maxRetryLogEntries entries. The retry log is not modified.
+func mergeRetryLog(
+	retryLog []jobspb.ChangefeedRetryLogEntry, entries ...jobspb.ChangefeedRetryLogEntry,
+) []jobspb.ChangefeedRetryLogEntry {
+	merged := append([]jobspb.ChangefeedRetryLogEntry(nil), retryLog...)
+	for _, e := range entries {
+		if n := len(merged); n > 0 && !e.Time.After(merged[n-1].Time) {
+			continue
+		}
+		merged = append(merged, e)
+	}
+	if len(merged) > maxRetryLogEntries {
+		merged = merged[len(merged)-maxRetryLogEntries:]
+	}
+	return merged
+}
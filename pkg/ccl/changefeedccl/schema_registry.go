@@ -15,8 +15,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
@@ -28,27 +30,62 @@ import (
 
 const confluentSchemaContentType = `application/vnd.schemaregistry.v1+json`
 
+// confluentSchemaType is the type of the schemas registered in the schema
+// registry.
+type confluentSchemaType string
+
+const (
+	// confluentSchemaTypeAvro is the type of Avro schemas. It is left out of
+	// the registration requests, since it is the default type, which older
+	// schema registries do not know about.
+	confluentSchemaTypeAvro confluentSchemaType = ``
+	// confluentSchemaTypeProtobuf is the type of Protobuf schemas.
+	confluentSchemaTypeProtobuf confluentSchemaType = `PROTOBUF`
+)
+
 type schemaRegistry interface {
 	// Ping tests the connectivity to the schema registry. A nil
 	// error is returned if the schema registry appears to be
 	// available.
 	Ping(ctx context.Context) error
 
-	// RegisterSchemaForSubject registers the given schema for the
-	// given subject. The returned int32 is a schema ID that can
-	// be used in Avro wire messages or in other calls to the
-	// schema registry.
-	RegisterSchemaForSubject(ctx context.Context, subject string, schema string) (int32, error)
+	// RegisterSchemaForSubject registers the given schema of the
+	// given type for the given subject. The returned int32 is a
+	// schema ID that can be used in Avro or Protobuf wire messages
+	// or in other calls to the schema registry.
+	RegisterSchemaForSubject(
+		ctx context.Context, subject string, schemaType confluentSchemaType, schema string,
+	) (int32, error)
+
+	// SetCompatibilityForSubject sets the compatibility level, such as
+	// BACKWARD or NONE, against which the schemas registered for the given
+	// subject are checked.
+	SetCompatibilityForSubject(ctx context.Context, subject string, level string) error
 }
 
+// errIncompatibleSchema marks the rejections of schemas by the schema registry
+// as incompatible with the schemas previously registered for their subject.
+var errIncompatibleSchema = errors.New("incompatible schema")
+
+// errPauseOnIncompatibleSchema marks the rejections of schemas which pause the
+// changefeed, whatever its on_error option, rather than failing it or routing
+// its rows to the dead letter sink (see
+// changefeedbase.OptSchemaIncompatibilityPause).
+var errPauseOnIncompatibleSchema = errors.New("pause on incompatible schema")
+
 type confluentSchemaVersionRequest struct {
-	Schema string `json:"schema"`
+	Schema     string              `json:"schema"`
+	SchemaType confluentSchemaType `json:"schemaType,omitempty"`
 }
 
 type confluentSchemaVersionResponse struct {
 	ID int32 `json:"id"`
 }
 
+type confluentCompatibilityRequest struct {
+	Compatibility string `json:"compatibility"`
+}
+
 type confluentSchemaRegistry struct {
 	baseURL *url.URL
 	// The current defaults for httputil.Client sets
@@ -139,19 +176,19 @@ func (r *confluentSchemaRegistry) Ping(ctx context.Context) error {
 }
 
 // RegisterSchemaForSubject registers the given schema for the given
-// subject. The schema type is assumed to be AVRO.
+// subject.
 //
 //   https://docs.confluent.io/platform/current/schema-registry/develop/api.html#post--subjects-(string-%20subject)-versions
 //
 func (r *confluentSchemaRegistry) RegisterSchemaForSubject(
-	ctx context.Context, subject string, schema string,
+	ctx context.Context, subject string, schemaType confluentSchemaType, schema string,
 ) (int32, error) {
 	u := r.urlForPath(fmt.Sprintf("subjects/%s/versions", subject))
 	if log.V(1) {
-		log.Infof(ctx, "registering avro schema %s %s", u, schema)
+		log.Infof(ctx, "registering %s schema %s %s", schemaTypeName(schemaType), u, schema)
 	}
 
-	req := confluentSchemaVersionRequest{Schema: schema}
+	req := confluentSchemaVersionRequest{Schema: schema, SchemaType: schemaType}
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(req); err != nil {
 		return 0, err
@@ -166,7 +203,16 @@ func (r *confluentSchemaRegistry) RegisterSchemaForSubject(
 		defer gracefulClose(ctx, resp.Body)
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 			body, _ := io.ReadAll(resp.Body)
-			return errors.Errorf("registering schema to %s %s: %s", u, resp.Status, body)
+			err := errors.Errorf("registering schema to %s %s: %s", u, resp.Status, body)
+			if resp.StatusCode == http.StatusConflict {
+				err = errors.Mark(err, errIncompatibleSchema)
+			}
+			if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusUnprocessableEntity {
+				// The registry rejected the schema as incompatible or invalid,
+				// which it will do however many times it is registered.
+				err = markUndeliverableRow(err)
+			}
+			return err
 		}
 		var res confluentSchemaVersionResponse
 		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
@@ -181,6 +227,52 @@ func (r *confluentSchemaRegistry) RegisterSchemaForSubject(
 	return id, nil
 }
 
+// SetCompatibilityForSubject sets the compatibility level of the given
+// subject.
+//
+//   https://docs.confluent.io/platform/current/schema-registry/develop/api.html#put--config-(string-%20subject)
+//
+func (r *confluentSchemaRegistry) SetCompatibilityForSubject(
+	ctx context.Context, subject string, level string,
+) error {
+	u := r.urlForPath(fmt.Sprintf("config/%s", subject))
+	if log.V(1) {
+		log.Infof(ctx, "setting compatibility %s %s", u, level)
+	}
+
+	req := confluentCompatibilityRequest{Compatibility: level}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+
+	return r.doWithRetry(ctx, func() error {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", confluentSchemaContentType)
+		resp, err := r.client.Do(httpReq)
+		if err != nil {
+			return errors.Wrap(err, "contacting confluent schema registry")
+		}
+		defer gracefulClose(ctx, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(resp.Body)
+			return errors.Errorf("setting compatibility of %s %s: %s", u, resp.Status, body)
+		}
+		return nil
+	})
+}
+
+// schemaTypeName returns the name of the schema type, for logging.
+func schemaTypeName(schemaType confluentSchemaType) string {
+	if schemaType == confluentSchemaTypeAvro {
+		return `avro`
+	}
+	return strings.ToLower(string(schemaType))
+}
+
 func (r *confluentSchemaRegistry) doWithRetry(ctx context.Context, fn func() error) error {
 	// Since network services are often a source of flakes, add a few retries here
 	// before we give up and return an error that will bubble up and tear down the
@@ -199,11 +291,81 @@ func (r *confluentSchemaRegistry) doWithRetry(ctx context.Context, fn func() err
 		if err == nil {
 			return nil
 		}
+		if errors.Is(err, errUndeliverableRow) {
+			break
+		}
 		log.VInfof(ctx, 2, "retrying schema registry operation: %s", err.Error())
 	}
 	return changefeedbase.MarkRetryableError(err)
 }
 
+// policySchemaRegistry applies the schema registry options of a changefeed,
+// schema_registry_compatibility and on_schema_incompatibility, to the schemas
+// registered by its encoder.
+type policySchemaRegistry struct {
+	schemaRegistry
+	// compatibility is the compatibility level set on the subjects before
+	// their first registration, if any.
+	compatibility  string
+	onIncompatible changefeedbase.SchemaIncompatibilityPolicy
+	// configuredSubjects are the subjects whose compatibility level was set.
+	// Like the caches of the encoders, it is not synchronized.
+	configuredSubjects map[string]struct{}
+}
+
+var _ schemaRegistry = (*policySchemaRegistry)(nil)
+
+func newPolicySchemaRegistry(
+	reg schemaRegistry, opts changefeedbase.EncodingOptions,
+) *policySchemaRegistry {
+	return &policySchemaRegistry{
+		schemaRegistry:     reg,
+		compatibility:      strings.ToUpper(opts.SchemaRegistryCompatibility),
+		onIncompatible:     opts.OnSchemaIncompatibility,
+		configuredSubjects: make(map[string]struct{}),
+	}
+}
+
+// RegisterSchemaForSubject implements the schemaRegistry interface.
+func (r *policySchemaRegistry) RegisterSchemaForSubject(
+	ctx context.Context, subject string, schemaType confluentSchemaType, schema string,
+) (int32, error) {
+	if _, ok := r.configuredSubjects[subject]; !ok && r.compatibility != `` {
+		if err := r.SetCompatibilityForSubject(ctx, subject, r.compatibility); err != nil {
+			return 0, err
+		}
+		r.configuredSubjects[subject] = struct{}{}
+	}
+	id, err := r.schemaRegistry.RegisterSchemaForSubject(ctx, subject, schemaType, schema)
+	if err == nil || !errors.Is(err, errIncompatibleSchema) {
+		return id, err
+	}
+
+	if r.onIncompatible == changefeedbase.OptSchemaIncompatibilityCoerce {
+		log.Warningf(ctx, "schema registry rejected the schema of subject %s as incompatible, "+
+			"setting its compatibility to NONE because of %s=%s: %v", subject,
+			changefeedbase.OptOnSchemaIncompatibility, changefeedbase.OptSchemaIncompatibilityCoerce, err)
+		if err := r.SetCompatibilityForSubject(ctx, subject, `NONE`); err != nil {
+			return 0, err
+		}
+		return r.schemaRegistry.RegisterSchemaForSubject(ctx, subject, schemaType, schema)
+	}
+
+	// The rejection is not marked as retryable, since registering the schema
+	// again would be rejected again, so that the changefeed fails or pauses
+	// with an error naming the subject rather than retrying forever. The
+	// response of the registry is the cause of the error, which, unlike the
+	// error itself, does not claim to be retryable.
+	incompatibleErr := errors.Mark(errors.Newf(
+		"schema registry rejected the schema of subject %s as incompatible with its previous schemas "+
+			"(see %s): %s", subject, changefeedbase.OptOnSchemaIncompatibility, errors.Cause(err).Error(),
+	), errIncompatibleSchema)
+	if r.onIncompatible == changefeedbase.OptSchemaIncompatibilityPause {
+		return 0, errors.Mark(incompatibleErr, errPauseOnIncompatibleSchema)
+	}
+	return 0, markUndeliverableRow(incompatibleErr)
+}
+
 func gracefulClose(ctx context.Context, toClose io.ReadCloser) {
 	// NOTE(ssd): To reuse the connection we have to be sure to
 	// read to EOF and close the response body.
@@ -13,8 +13,10 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -56,3 +58,48 @@ func TestConfluentSchemaRegistryPing(t *testing.T) {
 		require.Error(t, reg.Ping(context.Background()))
 	})
 }
+
+func TestSchemaRegistryIncompatibilityPolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	regServer := cdctest.StartTestSchemaRegistry()
+	defer regServer.Close()
+
+	ctx := context.Background()
+	for _, policy := range []changefeedbase.SchemaIncompatibilityPolicy{
+		changefeedbase.OptSchemaIncompatibilityFail,
+		changefeedbase.OptSchemaIncompatibilityPause,
+		changefeedbase.OptSchemaIncompatibilityCoerce,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			confluentReg, err := newConfluentSchemaRegistry(regServer.URL())
+			require.NoError(t, err)
+			reg := newPolicySchemaRegistry(confluentReg, changefeedbase.EncodingOptions{
+				SchemaRegistryCompatibility: `backward`,
+				OnSchemaIncompatibility:     policy,
+			})
+
+			subject := `foo-` + string(policy) + `-value`
+			_, err = reg.RegisterSchemaForSubject(ctx, subject, confluentSchemaTypeAvro, `"int"`)
+			require.NoError(t, err)
+			require.Equal(t, `BACKWARD`, regServer.CompatibilityForSubject(subject))
+
+			_, err = reg.RegisterSchemaForSubject(ctx, subject, confluentSchemaTypeAvro, `"string"`)
+			switch policy {
+			case changefeedbase.OptSchemaIncompatibilityCoerce:
+				require.NoError(t, err)
+				require.Equal(t, `NONE`, regServer.CompatibilityForSubject(subject))
+				require.Equal(t, `"string"`, regServer.SchemaForSubject(subject))
+			default:
+				require.Error(t, err)
+				require.Regexp(t, `rejected the schema of subject `+subject+` as incompatible`, err)
+				require.False(t, changefeedbase.IsRetryableError(err))
+				require.True(t, errors.Is(err, errIncompatibleSchema))
+				paused := policy == changefeedbase.OptSchemaIncompatibilityPause
+				require.Equal(t, paused, errors.Is(err, errPauseOnIncompatibleSchema))
+				require.Equal(t, !paused, isUndeliverableRowError(err))
+			}
+		})
+	}
+}
@@ -70,6 +70,152 @@ type EventSink interface {
 	Flush(ctx context.Context) error
 }
 
+// PartitionedEventSink is implemented by event sinks which can emit a row into
+// an explicitly chosen partition rather than deriving the partition from the
+// message key (see changefeedbase.OptPartitionExpr).
+type PartitionedEventSink interface {
+	EventSink
+
+	// EmitRowToPartition is like EmitRow, but the row is delivered into the
+	// specified partition.
+	EmitRowToPartition(
+		ctx context.Context,
+		topic TopicDescriptor,
+		key, value []byte,
+		updated, mvcc hlc.Timestamp,
+		alloc kvevent.Alloc,
+		partition int32,
+	) error
+}
+
+// messageHeader is a header attached to the message of a row (see
+// HeaderedEventSink). A nil value means the header has no value.
+type messageHeader struct {
+	key   string
+	value []byte
+}
+
+// HeaderedEventSink is implemented by event sinks which can attach headers to
+// the messages of the rows (see changefeedbase.OptKafkaHeaders and
+// changefeedbase.OptPubsubAttributes).
+type HeaderedEventSink interface {
+	EventSink
+
+	// EmitRowWithHeaders is like EmitRow, but the specified headers are
+	// attached to the message of the row. The row is delivered into the
+	// specified partition, unless it is negative, in which case the partition
+	// is derived from the message key as usual.
+	EmitRowWithHeaders(
+		ctx context.Context,
+		topic TopicDescriptor,
+		key, value []byte,
+		updated, mvcc hlc.Timestamp,
+		alloc kvevent.Alloc,
+		partition int32,
+		headers []messageHeader,
+	) error
+}
+
+// PathPartitionedEventSink is implemented by event sinks which partition
+// their output by values computed from the emitted rows, such as the paths of
+// the cloud storage sink (see changefeedbase.SinkParamPartitionFormat and
+// changefeedbase.SinkParamPartitionColumns) or the partitions of the kafka
+// sink (see kafkaPartitionerConfig).
+type PathPartitionedEventSink interface {
+	EventSink
+
+	// PartitionExprs returns the CDC expressions whose values are used to
+	// partition the output of the sink. The sink does not need the values of
+	// the expressions if empty.
+	PartitionExprs() []string
+
+	// EmitRowWithPartitionValues is like EmitRow, but additionally takes the
+	// values of the expressions returned by PartitionExprs for the row.
+	EmitRowWithPartitionValues(
+		ctx context.Context,
+		topic TopicDescriptor,
+		key, value []byte,
+		updated, mvcc hlc.Timestamp,
+		alloc kvevent.Alloc,
+		partitionValues []string,
+	) error
+}
+
+// ResolvedFlushingEventSink is implemented by event sinks which can flush the
+// rows covered by a resolved timestamp without waiting for the rows emitted at
+// later timestamps to be delivered. This keeps resolved timestamps flowing
+// while the sink is backpressured by a backlog of newer rows.
+type ResolvedFlushingEventSink interface {
+	EventSink
+
+	// FlushUpTo is like Flush, but only blocks until the rows whose updated
+	// timestamp is not greater than the specified timestamp have been
+	// acknowledged.
+	FlushUpTo(ctx context.Context, ts hlc.Timestamp) error
+}
+
+// TransactionalEventSink is implemented by event sinks which emit rows in
+// transactions. The rows of a transaction are not visible until it is
+// committed, and the changefeed only commits a transaction once it is recorded
+// in the job progress, along with the resolved timestamp of the spans whose
+// rows it holds. The rows are thus emitted exactly once, even if the changefeed
+// restarts.
+type TransactionalEventSink interface {
+	EventSink
+
+	// PrepareUpTo emits the rows whose updated timestamp is not greater than
+	// the specified timestamp, and which were not emitted yet, in a transaction
+	// which it does not commit. The rows emitted at later timestamps are
+	// retained for the next transactions. No transaction is returned if there
+	// are no rows to emit.
+	PrepareUpTo(ctx context.Context, ts hlc.Timestamp) (*jobspb.ChangefeedSinkTransaction, error)
+}
+
+// TransactionCommittingSink is implemented by resolved timestamp sinks which
+// commit the transactions prepared by a TransactionalEventSink.
+type TransactionCommittingSink interface {
+	ResolvedTimestampSink
+
+	// CommitTransactions commits the specified transactions, in order.
+	// Committing a transaction which is already committed is not an error. An
+	// error marked with errSinkTransactionAborted is returned if a transaction
+	// was aborted instead.
+	CommitTransactions(ctx context.Context, txns []jobspb.ChangefeedSinkTransaction) error
+}
+
+// AcknowledgingSink is implemented by resolved timestamp sinks whose consumers
+// acknowledge the resolved timestamps up to which they durably applied the
+// rows. The changefeed does not checkpoint past the acknowledged resolved
+// timestamp, so that it restarts from the rows which the consumers did not
+// apply yet.
+type AcknowledgingSink interface {
+	ResolvedTimestampSink
+
+	// AcknowledgedResolved returns the highest resolved timestamp acknowledged
+	// by the consumers, and whether the sink requires acknowledgements.
+	AcknowledgedResolved() (hlc.Timestamp, bool)
+}
+
+// SchemaFileSink is implemented by the sinks which write files, alongside
+// which they write the schema files holding the definitions of the messages
+// that are not registered in a schema registry.
+type SchemaFileSink interface {
+	// WriteSchemaFile writes the schema file of the specified name, replacing
+	// any previous file of that name.
+	WriteSchemaFile(ctx context.Context, name string, schema []byte) error
+}
+
+// errSinkTransactionAborted marks the errors of the sink transactions which
+// were aborted, rather than committed, after they were recorded in the job
+// progress. The rows of such a transaction are lost, so the error is not
+// retryable.
+var errSinkTransactionAborted = errors.New("sink transaction aborted")
+
+// errPartitionOutOfRange marks the errors of the rows which partition_expr
+// routes to a partition the topic does not have. Retrying such a row routes it
+// to the same partition, so the error is not retryable.
+var errPartitionOutOfRange = errors.New("partition out of range")
+
 // ResolvedTimestampSink is the interface used when emitting resolved
 // timestamps.
 type ResolvedTimestampSink interface {
@@ -119,6 +265,22 @@ func getSink(
 	user username.SQLUsername,
 	jobID jobspb.JobID,
 	m metricsRecorder,
+) (Sink, error) {
+	return getSinkWithURISource(ctx, serverCfg, feedCfg, timestampOracle, user, jobID, m, nil /* uriSource */)
+}
+
+// getSinkWithURISource is like getSink, but the sink reloads its certificates
+// from the URI returned by uriSource, rather than from the sink URI of
+// feedCfg, when they may have changed (see sinkTLSReloader).
+func getSinkWithURISource(
+	ctx context.Context,
+	serverCfg *execinfra.ServerConfig,
+	feedCfg jobspb.ChangefeedDetails,
+	timestampOracle timestampLowerBoundOracle,
+	user username.SQLUsername,
+	jobID jobspb.JobID,
+	m metricsRecorder,
+	uriSource sinkURISource,
 ) (Sink, error) {
 	u, err := url.Parse(feedCfg.SinkURI)
 	if err != nil {
@@ -130,6 +292,16 @@ func getSink(
 
 	opts := changefeedbase.MakeStatementOptions(feedCfg.Opts)
 
+	if feedCfg.SinkURI != "" {
+		additionalSinks, err := opts.GetAdditionalSinks()
+		if err != nil {
+			return nil, err
+		}
+		if len(additionalSinks) > 0 {
+			return makeFanOutSink(ctx, serverCfg, feedCfg, additionalSinks, timestampOracle, user, jobID, m)
+		}
+	}
+
 	// check that options are compatible with the given sink
 	validateOptionsAndMakeSink := func(sinkSpecificOpts map[string]struct{}, makeSink func() (Sink, error)) (Sink, error) {
 		err := validateSinkOptions(feedCfg.Opts, sinkSpecificOpts)
@@ -155,6 +327,14 @@ func getSink(
 		if err != nil {
 			return nil, err
 		}
+		// The streaming sinks emit the parquet files of format=parquet as
+		// messages, whose pages are compressed with the specified codec.
+		batchParquet := func(sink Sink, compression string) Sink {
+			if encodingOpts.Format != changefeedbase.OptFormatParquet {
+				return sink
+			}
+			return makeParquetBatchingSink(sink, parquetCompressionCodec(compression), &serverCfg.Settings.SV)
+		}
 
 		switch {
 		case u.Scheme == changefeedbase.SinkSchemeNull:
@@ -163,23 +343,131 @@ func getSink(
 				nullIsAccounted = knobs.NullSinkIsExternalIOAccounted
 			}
 			return makeNullSink(sinkURL{URL: u}, metricsBuilder(nullIsAccounted))
-		case u.Scheme == changefeedbase.SinkSchemeKafka:
+		case u.Scheme == changefeedbase.SinkSchemeKafka || u.Scheme == changefeedbase.SinkSchemeKafkaV2:
+			kafkaOpts, err := opts.GetKafkaSinkOptions()
+			if err != nil {
+				return nil, err
+			}
+			kafkaOpts.Compression = sinkCompression(&serverCfg.Settings.SV, kafkaOpts.Compression,
+				kafkaCompressionCodecNames)
 			return validateOptionsAndMakeSink(changefeedbase.KafkaValidOptions, func() (Sink, error) {
-				return makeKafkaSink(ctx, sinkURL{URL: u}, AllTargets(feedCfg), opts.GetKafkaConfigJSON(), serverCfg.Settings, metricsBuilder)
+				tlsReloader, err := makeSinkTLSReloader(serverCfg.Settings, u, uriSource)
+				if err != nil {
+					return nil, err
+				}
+				var sink Sink
+				if useKafkaV2Sink(serverCfg.Settings, u.Scheme, kafkaOpts) {
+					sink, err = makeKafkaV2Sink(ctx, sinkURL{URL: u}, AllTargets(feedCfg), kafkaOpts,
+						metricsBuilder, tlsReloader)
+				} else {
+					sink, err = makeKafkaSink(ctx, sinkURL{URL: u}, AllTargets(feedCfg), kafkaOpts, serverCfg.Settings,
+						jobID, metricsBuilder, tlsReloader)
+				}
+				if err != nil {
+					return nil, err
+				}
+				// The messages are compressed by the producer, if at all.
+				return batchParquet(sink, `` /* compression */), nil
 			})
 		case isWebhookSink(u):
 			webhookOpts, err := opts.GetWebhookSinkOptions()
 			if err != nil {
 				return nil, err
 			}
+			encodingOpts.Compression = sinkCompression(&serverCfg.Settings.SV, encodingOpts.Compression,
+				payloadCompressionCodecs)
 			return validateOptionsAndMakeSink(changefeedbase.WebhookValidOptions, func() (Sink, error) {
-				return makeWebhookSink(ctx, sinkURL{URL: u}, encodingOpts, webhookOpts,
-					defaultWorkerCount(), timeutil.DefaultTimeSource{}, metricsBuilder)
+				tlsReloader, err := makeSinkTLSReloader(serverCfg.Settings, u, uriSource)
+				if err != nil {
+					return nil, err
+				}
+				sink, err := makeWebhookSink(ctx, sinkURL{URL: u}, AllTargets(feedCfg), encodingOpts,
+					webhookOpts, serverCfg.Settings, defaultWorkerCount(), timeutil.DefaultTimeSource{}, metricsBuilder,
+					tlsReloader)
+				if err != nil {
+					return nil, err
+				}
+				return batchParquet(sink, encodingOpts.Compression), nil
+			})
+		case u.Scheme == changefeedbase.SinkSchemeKinesis:
+			return validateOptionsAndMakeSink(changefeedbase.KinesisValidOptions, func() (Sink, error) {
+				kinesisOpts, err := opts.GetKinesisSinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeKinesisSink(sinkURL{URL: u}, encodingOpts, kinesisOpts,
+					AllTargets(feedCfg), metricsBuilder)
+			})
+		case u.Scheme == changefeedbase.SinkSchemeSQS:
+			return validateOptionsAndMakeSink(changefeedbase.SQSValidOptions, func() (Sink, error) {
+				sqsOpts, err := opts.GetSQSSinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeSQSSink(sinkURL{URL: u}, encodingOpts, sqsOpts,
+					AllTargets(feedCfg), metricsBuilder)
+			})
+		case u.Scheme == changefeedbase.SinkSchemeNATS:
+			return validateOptionsAndMakeSink(changefeedbase.NATSValidOptions, func() (Sink, error) {
+				return makeNATSSink(sinkURL{URL: u}, encodingOpts, opts.GetNATSSinkOptions(),
+					AllTargets(feedCfg), metricsBuilder)
+			})
+		case isAMQPSink(u):
+			return validateOptionsAndMakeSink(changefeedbase.AMQPValidOptions, func() (Sink, error) {
+				amqpOpts, err := opts.GetAMQPSinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeAMQPSink(sinkURL{URL: u}, encodingOpts, amqpOpts,
+					AllTargets(feedCfg), metricsBuilder)
+			})
+		case u.Scheme == changefeedbase.SinkSchemeGRPC:
+			return validateOptionsAndMakeSink(changefeedbase.GRPCValidOptions, func() (Sink, error) {
+				return makeGRPCSink(sinkURL{URL: u}, opts.GetGRPCSinkOptions(), AllTargets(feedCfg), metricsBuilder)
+			})
+		case u.Scheme == changefeedbase.SinkSchemeBigQuery:
+			return validateOptionsAndMakeSink(changefeedbase.BigQueryValidOptions, func() (Sink, error) {
+				bigQueryOpts, err := opts.GetBigQuerySinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeBigQuerySink(ctx, sinkURL{URL: u}, encodingOpts, bigQueryOpts,
+					AllTargets(feedCfg), metricsBuilder)
+			})
+		case u.Scheme == changefeedbase.SinkSchemeSnowflake:
+			return validateOptionsAndMakeSink(changefeedbase.SnowflakeValidOptions, func() (Sink, error) {
+				snowflakeOpts, err := opts.GetSnowflakeSinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeSnowflakeSink(ctx, sinkURL{URL: u}, encodingOpts, snowflakeOpts,
+					AllTargets(feedCfg), jobID, serverCfg.NodeID.SQLInstanceID(), metricsBuilder)
+			})
+		case u.Scheme == changefeedbase.SinkSchemeClickHouse:
+			return validateOptionsAndMakeSink(changefeedbase.ClickHouseValidOptions, func() (Sink, error) {
+				clickHouseOpts, err := opts.GetClickHouseSinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeClickHouseSink(sinkURL{URL: u}, encodingOpts, clickHouseOpts,
+					AllTargets(feedCfg), metricsBuilder)
+			})
+		case isElasticsearchSink(u):
+			return validateOptionsAndMakeSink(changefeedbase.ElasticsearchValidOptions, func() (Sink, error) {
+				elasticsearchOpts, err := opts.GetElasticsearchSinkOptions()
+				if err != nil {
+					return nil, err
+				}
+				return makeElasticsearchSink(sinkURL{URL: u}, encodingOpts, elasticsearchOpts,
+					AllTargets(feedCfg), metricsBuilder)
 			})
 		case isPubsubSink(u):
-			// TODO: add metrics to pubsubsink
-			return MakePubsubSink(ctx, u, encodingOpts, AllTargets(feedCfg))
+			return validateOptionsAndMakeSink(changefeedbase.PubsubValidOptions, func() (Sink, error) {
+				return MakePubsubSink(ctx, u, encodingOpts, AllTargets(feedCfg), metricsBuilder)
+			})
 		case isCloudStorageSink(u):
+			encodingOpts.Compression = sinkCompression(&serverCfg.Settings.SV, encodingOpts.Compression,
+				payloadCompressionCodecs)
 			return validateOptionsAndMakeSink(changefeedbase.CloudStorageValidOptions, func() (Sink, error) {
 				return makeCloudStorageSink(
 					ctx, sinkURL{URL: u}, serverCfg.NodeID.SQLInstanceID(), serverCfg.Settings, encodingOpts,
@@ -300,9 +588,27 @@ func (u *sinkURL) String() string {
 // errorWrapperSink delegates to another sink and marks all returned errors as
 // retryable. During changefeed setup, we use the sink once without this to
 // verify configuration, but in the steady state, no sink error should be
-// terminal.
+// terminal. The errors are counted in the metrics of the changefeed, whatever
+// the type of the sink.
 type errorWrapperSink struct {
 	wrapped externalResource
+	metrics metricsRecorder
+}
+
+// retryable marks an error returned by the wrapped sink as retryable, unless
+// retrying cannot succeed.
+func (s errorWrapperSink) retryable(err error) error {
+	s.recordError()
+	if errors.Is(err, errPartitionOutOfRange) {
+		return err
+	}
+	return changefeedbase.MarkRetryableError(err)
+}
+
+func (s errorWrapperSink) recordError() {
+	if s.metrics != nil {
+		s.metrics.recordSinkError()
+	}
 }
 
 // EmitRow implements Sink interface.
@@ -314,7 +620,73 @@ func (s errorWrapperSink) EmitRow(
 	alloc kvevent.Alloc,
 ) error {
 	if err := s.wrapped.(EventSink).EmitRow(ctx, topic, key, value, updated, mvcc, alloc); err != nil {
-		return changefeedbase.MarkRetryableError(err)
+		return s.retryable(err)
+	}
+	return nil
+}
+
+// EmitRowToPartition implements PartitionedEventSink interface.
+func (s errorWrapperSink) EmitRowToPartition(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+) error {
+	ps, ok := s.wrapped.(PartitionedEventSink)
+	if !ok {
+		return errors.AssertionFailedf("sink %T does not support emitting into explicit partitions", s.wrapped)
+	}
+	if err := ps.EmitRowToPartition(ctx, topic, key, value, updated, mvcc, alloc, partition); err != nil {
+		return s.retryable(err)
+	}
+	return nil
+}
+
+// EmitRowWithHeaders implements HeaderedEventSink interface.
+func (s errorWrapperSink) EmitRowWithHeaders(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+	headers []messageHeader,
+) error {
+	hs, ok := s.wrapped.(HeaderedEventSink)
+	if !ok {
+		return errors.AssertionFailedf("sink %T does not support message headers", s.wrapped)
+	}
+	if err := hs.EmitRowWithHeaders(ctx, topic, key, value, updated, mvcc, alloc, partition, headers); err != nil {
+		return s.retryable(err)
+	}
+	return nil
+}
+
+// PartitionExprs implements PathPartitionedEventSink interface.
+func (s errorWrapperSink) PartitionExprs() []string {
+	if ps, ok := s.wrapped.(PathPartitionedEventSink); ok {
+		return ps.PartitionExprs()
+	}
+	return nil
+}
+
+// EmitRowWithPartitionValues implements PathPartitionedEventSink interface.
+func (s errorWrapperSink) EmitRowWithPartitionValues(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partitionValues []string,
+) error {
+	ps, ok := s.wrapped.(PathPartitionedEventSink)
+	if !ok {
+		return errors.AssertionFailedf("sink %T does not support partitioning by row values", s.wrapped)
+	}
+	if err := ps.EmitRowWithPartitionValues(ctx, topic, key, value, updated, mvcc, alloc, partitionValues); err != nil {
+		return s.retryable(err)
 	}
 	return nil
 }
@@ -324,7 +696,7 @@ func (s errorWrapperSink) EmitResolvedTimestamp(
 	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
 ) error {
 	if err := s.wrapped.(ResolvedTimestampSink).EmitResolvedTimestamp(ctx, encoder, resolved); err != nil {
-		return changefeedbase.MarkRetryableError(err)
+		return s.retryable(err)
 	}
 	return nil
 }
@@ -332,15 +704,74 @@ func (s errorWrapperSink) EmitResolvedTimestamp(
 // Flush implements Sink interface.
 func (s errorWrapperSink) Flush(ctx context.Context) error {
 	if err := s.wrapped.(EventSink).Flush(ctx); err != nil {
-		return changefeedbase.MarkRetryableError(err)
+		return s.retryable(err)
+	}
+	return nil
+}
+
+// FlushUpTo implements ResolvedFlushingEventSink interface. Sinks which do not
+// support it flush all of their rows instead.
+func (s errorWrapperSink) FlushUpTo(ctx context.Context, ts hlc.Timestamp) error {
+	var err error
+	if fs, ok := s.wrapped.(ResolvedFlushingEventSink); ok {
+		err = fs.FlushUpTo(ctx, ts)
+	} else {
+		err = s.wrapped.(EventSink).Flush(ctx)
+	}
+	if err != nil {
+		return s.retryable(err)
 	}
 	return nil
 }
 
+// PrepareUpTo implements TransactionalEventSink interface. Sinks which do not
+// support transactions flush the rows instead.
+func (s errorWrapperSink) PrepareUpTo(
+	ctx context.Context, ts hlc.Timestamp,
+) (*jobspb.ChangefeedSinkTransaction, error) {
+	tx, ok := s.wrapped.(TransactionalEventSink)
+	if !ok {
+		return nil, s.FlushUpTo(ctx, ts)
+	}
+	txn, err := tx.PrepareUpTo(ctx, ts)
+	if err != nil {
+		return nil, s.retryable(err)
+	}
+	return txn, nil
+}
+
+// CommitTransactions implements TransactionCommittingSink interface. The
+// errors of the transactions which were aborted are not retryable.
+func (s errorWrapperSink) CommitTransactions(
+	ctx context.Context, txns []jobspb.ChangefeedSinkTransaction,
+) error {
+	cs, ok := s.wrapped.(TransactionCommittingSink)
+	if !ok {
+		return errors.AssertionFailedf("sink does not support transactions")
+	}
+	if err := cs.CommitTransactions(ctx, txns); err != nil {
+		if errors.Is(err, errSinkTransactionAborted) {
+			s.recordError()
+			return err
+		}
+		return s.retryable(err)
+	}
+	return nil
+}
+
+// AcknowledgedResolved implements AcknowledgingSink interface. Sinks which do
+// not support acknowledgements do not require them.
+func (s errorWrapperSink) AcknowledgedResolved() (hlc.Timestamp, bool) {
+	if as, ok := s.wrapped.(AcknowledgingSink); ok {
+		return as.AcknowledgedResolved()
+	}
+	return hlc.Timestamp{}, false
+}
+
 // Close implements Sink interface.
 func (s errorWrapperSink) Close() error {
 	if err := s.wrapped.Close(); err != nil {
-		return changefeedbase.MarkRetryableError(err)
+		return s.retryable(err)
 	}
 	return nil
 }
@@ -350,6 +781,19 @@ func (s errorWrapperSink) Dial() error {
 	return s.wrapped.Dial()
 }
 
+// unwrapSink returns the sink wrapped by an errorWrapperSink, or the sink
+// itself. Since errorWrapperSink implements all the optional sink interfaces,
+// the capabilities of a sink must be checked on the unwrapped sink.
+func unwrapSink(sink externalResource) externalResource {
+	switch s := sink.(type) {
+	case *errorWrapperSink:
+		return s.wrapped
+	case errorWrapperSink:
+		return s.wrapped
+	}
+	return sink
+}
+
 // encDatumRowBuffer is a FIFO of `EncDatumRow`s.
 //
 // TODO(dan): There's some potential allocation savings here by reusing the same
@@ -0,0 +1,549 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcamqp"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	amqpDefaultPort  = "5672"
+	amqpsDefaultPort = "5671"
+)
+
+// amqpRoutingKeyTopicPlaceholder is replaced by the name of the topic of the
+// rows in the routing key template of the AMQP sink.
+const amqpRoutingKeyTopicPlaceholder = `{topic}`
+
+func isAMQPSink(u *url.URL) bool {
+	switch u.Scheme {
+	case changefeedbase.SinkSchemeAMQP, changefeedbase.SinkSchemeAMQPS:
+		return true
+	default:
+		return false
+	}
+}
+
+// proper JSON schema for amqp sink config:
+//
+//	{
+//	  "ConfirmTimeout": ...,
+//	  "MaxPending": ...,
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// ConfirmTimeout is how long the sink waits for the server to confirm a
+// message before retrying it, and MaxPending is the number of messages which
+// may await their confirmations before the sink waits for them.
+type amqpSinkConfig struct {
+	ConfirmTimeout jsonDuration `json:",omitempty"`
+	MaxPending     int          `json:",omitempty"`
+	Retry          retryConfig  `json:",omitempty"`
+}
+
+func getAMQPSinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg amqpSinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.ConfirmTimeout = jsonDuration(30 * time.Second)
+	cfg.MaxPending = 1000
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	if cfg.ConfirmTimeout <= 0 || cfg.MaxPending <= 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, ConfirmTimeout and MaxPending must be positive",
+			changefeedbase.OptAMQPSinkConfig)
+	}
+	if cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, retry values must be non-negative",
+			changefeedbase.OptAMQPSinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+func makeAMQPDialConfig(u *sinkURL, contentType string) (cdcamqp.DialConfig, error) {
+	cfg := cdcamqp.DialConfig{
+		Addr:        u.Host,
+		User:        "guest",
+		Password:    "guest",
+		VHost:       "/",
+		ContentType: contentType,
+		Heartbeat:   cdcamqp.DefaultHeartbeat,
+	}
+	tlsEnabled := u.Scheme == changefeedbase.SinkSchemeAMQPS
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return cfg, err
+	}
+	if u.Port() == "" {
+		port := amqpDefaultPort
+		if tlsEnabled {
+			port = amqpsDefaultPort
+		}
+		cfg.Addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	// Like in the AMQP URIs of the client libraries, the path is the virtual
+	// host, where "/" must be escaped as %2f.
+	if u.Path != "" {
+		cfg.VHost = u.Path[1:]
+	}
+	if len(cfg.VHost) > cdcamqp.MaxShortStrLength {
+		return cfg, errors.Errorf("amqp virtual host exceeds %d bytes", cdcamqp.MaxShortStrLength)
+	}
+
+	var err error
+	cfg.TLSConfig, err = consumeTLSParams(u, tlsEnabled)
+	return cfg, err
+}
+
+// amqpMessage is a message published by the AMQP sink which was not confirmed
+// yet.
+type amqpMessage struct {
+	routingKey string
+	body       []byte
+	// alloc, mvcc and updateMetrics are set for the messages of rows.
+	alloc         kvevent.Alloc
+	mvcc          hlc.Timestamp
+	updateMetrics recordOneMessageCallback
+
+	// attempt counts the publications of the message, and confirmed and err
+	// are the result of the latest one. They are guarded by the mutex of the
+	// sink.
+	attempt   int
+	confirmed bool
+	err       error
+}
+
+// amqpSink publishes to an exchange of an AMQP 0.9.1 server, like RabbitMQ.
+// The routing key of each row is generated from the routing key template of
+// the sink URI, whose {topic} placeholder is replaced by the topic name of the
+// table, or is the value of a column of the row.
+//
+// The messages are published in confirm mode without waiting for the
+// confirmations of the previous ones, and the sink waits for all of them to be
+// confirmed when it is flushed. When a message fails, it is published again
+// along with all the messages published after it, so that the messages for a
+// key are not reordered, after reconnecting if the connection failed.
+type amqpSink struct {
+	dialCfg            cdcamqp.DialConfig
+	exchange           string
+	routingKeyTemplate string
+	routingKeyColumn   string
+	envelope           changefeedbase.EnvelopeType
+	topicNamer         *TopicNamer
+	cfg                amqpSinkConfig
+	retryCfg           sinkRetryPolicy
+	metrics            metricsRecorder
+
+	conn *cdcamqp.Conn
+	// pending are the messages which were not confirmed yet, in the order in
+	// which they were emitted.
+	pending []*amqpMessage
+	// confirmCh is notified when messages are confirmed.
+	confirmCh chan struct{}
+	mu        syncutil.Mutex
+}
+
+var _ Sink = (*amqpSink)(nil)
+
+func makeAMQPSink(
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.AMQPSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	var contentType string
+	switch encodingOpts.Format {
+	case changefeedbase.OptFormatJSON:
+		contentType = "application/json"
+	case changefeedbase.OptFormatCSV:
+		contentType = "text/csv"
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+
+	switch encodingOpts.Envelope {
+	case changefeedbase.OptEnvelopeWrapped, changefeedbase.OptEnvelopeRow:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.Errorf(`amqp sink URI must specify a server`)
+	}
+
+	s := &amqpSink{
+		exchange:           u.consumeParam(changefeedbase.SinkParamExchange),
+		routingKeyTemplate: u.consumeParam(changefeedbase.SinkParamRoutingKey),
+		routingKeyColumn:   u.consumeParam(changefeedbase.SinkParamRoutingKeyColumn),
+		envelope:           encodingOpts.Envelope,
+		metrics:            mb(requiresResourceAccounting),
+		confirmCh:          make(chan struct{}, 1),
+	}
+	if len(s.exchange) > cdcamqp.MaxShortStrLength {
+		return nil, errors.Errorf(`param %s exceeds %d bytes`, changefeedbase.SinkParamExchange, cdcamqp.MaxShortStrLength)
+	}
+	if s.routingKeyTemplate == "" {
+		s.routingKeyTemplate = amqpRoutingKeyTopicPlaceholder
+	}
+	if s.routingKeyColumn != "" && encodingOpts.Format != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`param %s requires %s=%s`,
+			changefeedbase.SinkParamRoutingKeyColumn, changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicName := u.consumeParam(changefeedbase.SinkParamTopicName)
+	var err error
+	s.topicNamer, err = MakeTopicNamer(targets, WithPrefix(topicPrefix), WithSingleName(topicName))
+	if err != nil {
+		return nil, err
+	}
+
+	s.dialCfg, err = makeAMQPDialConfig(&u, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown amqp sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getAMQPSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptAMQPSinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface.
+func (s *amqpSink) Dial() error {
+	conn, err := cdcamqp.Dial(context.Background(), s.dialCfg)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// topicRoutingKey returns the routing key of a topic.
+func (s *amqpSink) topicRoutingKey(topicName string) string {
+	return strings.Replace(s.routingKeyTemplate, amqpRoutingKeyTopicPlaceholder, topicName, -1)
+}
+
+// columnRoutingKey returns the value of the routing key column in a row, if
+// the row has a non-NULL value for it. The deleted rows only have a value for
+// it with the diff option.
+func (s *amqpSink) columnRoutingKey(value []byte) (string, bool, error) {
+	var rows []map[string]json.RawMessage
+	if s.envelope == changefeedbase.OptEnvelopeRow {
+		var row map[string]json.RawMessage
+		if err := json.Unmarshal(value, &row); err != nil {
+			return "", false, err
+		}
+		rows = append(rows, row)
+	} else {
+		var wrapped struct {
+			After  map[string]json.RawMessage `json:"after"`
+			Before map[string]json.RawMessage `json:"before"`
+		}
+		if err := json.Unmarshal(value, &wrapped); err != nil {
+			return "", false, err
+		}
+		rows = append(rows, wrapped.After, wrapped.Before)
+	}
+
+	for _, row := range rows {
+		v, ok := row[s.routingKeyColumn]
+		if !ok || string(v) == "null" {
+			continue
+		}
+		// The strings are used without their quotes, and the other values as
+		// they are encoded.
+		var str string
+		if err := json.Unmarshal(v, &str); err == nil {
+			return str, true, nil
+		}
+		return string(v), true, nil
+	}
+	return "", false, nil
+}
+
+// publish publishes a message on the current connection. The publication is
+// retried when the sink is flushed if it fails.
+func (s *amqpSink) publish(m *amqpMessage) {
+	s.mu.Lock()
+	m.attempt++
+	attempt := m.attempt
+	m.confirmed, m.err = false, nil
+	s.mu.Unlock()
+
+	// The confirmations of the previous publications of the message are
+	// ignored.
+	onConfirm := func(err error) {
+		s.mu.Lock()
+		if m.attempt == attempt {
+			m.confirmed, m.err = true, err
+		}
+		s.mu.Unlock()
+		select {
+		case s.confirmCh <- struct{}{}:
+		default:
+		}
+	}
+	if s.conn == nil {
+		onConfirm(errors.New("amqp sink is not connected"))
+		return
+	}
+	if err := s.conn.Publish(s.exchange, m.routingKey, m.body, onConfirm); err != nil {
+		onConfirm(err)
+	}
+}
+
+// EmitRow implements the Sink interface.
+func (s *amqpSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	topicName, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	routingKey := s.topicRoutingKey(topicName)
+	if s.routingKeyColumn != "" {
+		columnKey, ok, err := s.columnRoutingKey(value)
+		if err != nil {
+			return errors.Wrapf(err, "reading routing key column %s", s.routingKeyColumn)
+		}
+		if ok {
+			routingKey = columnKey
+		}
+	}
+	if len(routingKey) > cdcamqp.MaxShortStrLength {
+		return errors.Errorf("amqp routing key %q exceeds %d bytes", routingKey, cdcamqp.MaxShortStrLength)
+	}
+
+	if len(s.pending) >= s.cfg.MaxPending {
+		if err := s.flushPending(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+	m := &amqpMessage{
+		routingKey:    routingKey,
+		body:          value,
+		alloc:         alloc,
+		mvcc:          mvcc,
+		updateMetrics: s.metrics.recordOneMessage(),
+	}
+	s.pending = append(s.pending, m)
+	s.publish(m)
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved timestamps
+// are published with the routing keys of all the topics.
+func (s *amqpSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return errors.Wrap(err, "encoding resolved timestamp")
+	}
+	if err := s.topicNamer.Each(func(topicName string) error {
+		m := &amqpMessage{routingKey: s.topicRoutingKey(topicName), body: payload}
+		s.pending = append(s.pending, m)
+		s.publish(m)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return s.flushPending(ctx)
+}
+
+// Flush implements the Sink interface.
+func (s *amqpSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.flushPending(ctx)
+}
+
+// flushPending waits for the pending messages to be confirmed, and retries
+// the messages which failed.
+func (s *amqpSink) flushPending(ctx context.Context) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := s.retryCfg.do(ctx, func() error {
+		if err := s.republishFailed(ctx); err != nil {
+			return err
+		}
+		if err := s.waitForConfirms(ctx); err != nil {
+			return err
+		}
+		if i := s.firstFailed(); i >= 0 {
+			return s.pending[i].err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, m := range s.pending {
+		if m.updateMetrics != nil {
+			m.updateMetrics(m.mvcc, len(m.body), sinkDoesNotCompress)
+		}
+		m.alloc.Release(ctx)
+	}
+	s.pending = nil
+	return nil
+}
+
+// firstFailed returns the index of the first pending message which failed, or
+// -1.
+func (s *amqpSink) firstFailed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.pending {
+		if m.confirmed && m.err != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// republishFailed publishes again the first pending message which failed and
+// all the messages after it, after reconnecting if the connection failed.
+func (s *amqpSink) republishFailed(ctx context.Context) error {
+	i := s.firstFailed()
+	if i < 0 {
+		return nil
+	}
+	if s.conn == nil || s.conn.Failed() != nil {
+		if s.conn != nil {
+			// Closing the connection fails the messages which were not
+			// confirmed yet.
+			s.conn.Close()
+			s.conn = nil
+			i = s.firstFailed()
+		}
+		conn, err := cdcamqp.Dial(ctx, s.dialCfg)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.metrics.recordInternalRetry(int64(len(s.pending)-i), false /* reducedBatchSize */)
+	for _, m := range s.pending[i:] {
+		s.publish(m)
+	}
+	return nil
+}
+
+// waitForConfirms waits until all the pending messages were confirmed or
+// failed. The connection is closed if they are not confirmed in time, which
+// fails them.
+func (s *amqpSink) waitForConfirms(ctx context.Context) error {
+	if s.conn != nil {
+		if err := s.conn.Flush(); err != nil {
+			return err
+		}
+	}
+
+	timeout := time.Duration(s.cfg.ConfirmTimeout)
+	var timer timeutil.Timer
+	defer timer.Stop()
+	timer.Reset(timeout)
+	for {
+		s.mu.Lock()
+		unconfirmed := 0
+		for _, m := range s.pending {
+			if !m.confirmed {
+				unconfirmed++
+			}
+		}
+		s.mu.Unlock()
+		if unconfirmed == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.confirmCh:
+			timer.Reset(timeout)
+		case <-timer.C:
+			timer.Read = true
+			s.conn.Close()
+			return errors.Errorf("timed out after %s waiting for amqp server to confirm %d messages",
+				timeout, unconfirmed)
+		}
+	}
+}
+
+// Close implements the Sink interface.
+func (s *amqpSink) Close() error {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	for _, m := range s.pending {
+		m.alloc.Release(context.Background())
+	}
+	s.pending = nil
+	return nil
+}
+
+// Topics gives the routing keys of all topics that have been initialized
+// and will receive resolved timestamps.
+func (s *amqpSink) Topics() []string {
+	var routingKeys []string
+	for _, topicName := range s.topicNamer.DisplayNamesSlice() {
+		routingKeys = append(routingKeys, s.topicRoutingKey(topicName))
+	}
+	return routingKeys
+}
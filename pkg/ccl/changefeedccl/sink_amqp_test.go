@@ -0,0 +1,290 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcamqp"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestAMQPSink(
+	t *testing.T, uri string, opts map[string]string, targetNames ...string,
+) (*amqpSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	stmtOpts := map[string]string{
+		changefeedbase.OptFormat:         string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope:       string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptAMQPSinkConfig: `{"Retry":{"Backoff":"5ms"}}`,
+	}
+	for k, v := range opts {
+		stmtOpts[k] = v
+	}
+	statementOpts := changefeedbase.MakeStatementOptions(stmtOpts)
+	encodingOpts, err := statementOpts.GetEncodingOptions()
+	require.NoError(t, err)
+	amqpOpts, err := statementOpts.GetAMQPSinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeAMQPSink(sinkURL{URL: u}, encodingOpts, amqpOpts,
+		makeChangefeedTargets(targetNames...), nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*amqpSink), nil
+}
+
+func TestAMQPSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name        string
+		uri         string
+		opts        map[string]string
+		routingKeys []string
+		addr        string
+		vhost       string
+		err         string
+	}{
+		{
+			name:        "defaults",
+			uri:         `amqp://localhost`,
+			routingKeys: []string{"t"},
+			addr:        "localhost:5672",
+			vhost:       "/",
+		},
+		{
+			name:        "amqps",
+			uri:         `amqps://localhost/prod?routing_key=cdc.{topic}&topic_prefix=p_`,
+			routingKeys: []string{"cdc.p_t"},
+			addr:        "localhost:5671",
+			vhost:       "prod",
+		},
+		{
+			name:        "single routing key",
+			uri:         `amqp://localhost:1234/%2f?exchange=cdc&routing_key=rows.{topic}&topic_name=all`,
+			routingKeys: []string{"rows.all"},
+			addr:        "localhost:1234",
+			vhost:       "/",
+		},
+		{
+			name:        "row envelope",
+			uri:         `amqp://localhost?routing_key_column=region`,
+			opts:        map[string]string{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeRow)},
+			routingKeys: []string{"t"},
+			addr:        "localhost:5672",
+			vhost:       "/",
+		},
+		{
+			name: "missing server",
+			uri:  `amqp:///vhost`,
+			err:  `amqp sink URI must specify a server`,
+		},
+		{
+			name: "routing key column with csv",
+			uri:  `amqp://localhost?routing_key_column=region`,
+			opts: map[string]string{
+				changefeedbase.OptFormat:          string(changefeedbase.OptFormatCSV),
+				changefeedbase.OptInitialScanOnly: ``,
+			},
+			err: `param routing_key_column requires format=json`,
+		},
+		{
+			name: "unknown param",
+			uri:  `amqp://localhost?foo=bar`,
+			err:  `unknown amqp sink query parameters: foo`,
+		},
+		{
+			name: "client cert without tls",
+			uri:  `amqp://localhost?client_cert=Zm9v`,
+			err:  `client_cert requires tls_enabled=true`,
+		},
+		{
+			name: "invalid config",
+			uri:  `amqp://localhost`,
+			opts: map[string]string{changefeedbase.OptAMQPSinkConfig: `{"ConfirmTimeout":"-1s"}`},
+			err:  `ConfirmTimeout and MaxPending must be positive`,
+		},
+		{
+			name: "avro",
+			uri:  `amqp://localhost`,
+			opts: map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatAvro)},
+			err:  `this sink is incompatible with format=avro`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestAMQPSink(t, tc.uri, tc.opts, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.routingKeys, s.Topics())
+			require.Equal(t, tc.addr, s.dialCfg.Addr)
+			require.Equal(t, tc.vhost, s.dialCfg.VHost)
+			require.Equal(t, cdcamqp.DefaultHeartbeat, s.dialCfg.Heartbeat)
+		})
+	}
+}
+
+func TestAMQPSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	broker, err := cdcamqp.StartMockBroker("user", "pass")
+	require.NoError(t, err)
+	defer broker.Close()
+	addr := broker.Addr()
+
+	var pool testAllocPool
+	emit := func(s *amqpSink, topicName, value string) error {
+		return s.EmitRow(ctx, topic(topicName), []byte(`[1]`), []byte(value), zeroTS, zeroTS, pool.alloc())
+	}
+	// newMessages returns the messages stored by the broker since the
+	// previous call.
+	var seen int
+	newMessages := func() []string {
+		messages := broker.Messages()
+		defer func() { seen = len(messages) }()
+		return messages[seen:]
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		s, err := makeTestAMQPSink(t, `amqp://user:pass@`+addr+`/prod?exchange=cdc&routing_key=rows.{topic}`,
+			nil, "t1", "t2")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		// The large messages are split into several frames.
+		large := strings.Repeat("x", 3*cdcamqp.MinFrameMax)
+		require.NoError(t, emit(s, "t1", "a"))
+		require.NoError(t, emit(s, "t2", "b"))
+		require.NoError(t, emit(s, "t1", large))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{
+			"prod/cdc/rows.t1:a",
+			"prod/cdc/rows.t2:b",
+			"prod/cdc/rows.t1:" + large,
+		}, newMessages())
+		require.EqualValues(t, 0, pool.used())
+
+		opts, err := changefeedbase.MakeStatementOptions(map[string]string{
+			changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+		}).GetEncodingOptions()
+		require.NoError(t, err)
+		enc, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+		require.NoError(t, err)
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 2}))
+		require.ElementsMatch(t, []string{
+			`prod/cdc/rows.t1:{"resolved":"2.0000000000"}`,
+			`prod/cdc/rows.t2:{"resolved":"2.0000000000"}`,
+		}, newMessages())
+	})
+
+	t.Run("routing key column", func(t *testing.T) {
+		s, err := makeTestAMQPSink(t, `amqp://user:pass@`+addr+`?routing_key_column=region`, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		rows := []string{
+			`{"after":{"id":1,"region":"us"}}`,
+			`{"after":null,"before":{"id":2,"region":"eu"}}`,
+			`{"after":{"id":3,"region":7}}`,
+			`{"after":{"id":4,"region":null}}`,
+		}
+		for _, row := range rows {
+			require.NoError(t, emit(s, "t1", row))
+		}
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{
+			"///us:" + rows[0],
+			"///eu:" + rows[1],
+			"///7:" + rows[2],
+			"///t1:" + rows[3],
+		}, newMessages())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		s, err := makeTestAMQPSink(t, `amqp://user:wrong@`+addr, nil, "t1")
+		require.NoError(t, err)
+		err = s.Dial()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `403 ACCESS_REFUSED`)
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("nack", func(t *testing.T) {
+		s, err := makeTestAMQPSink(t, `amqp://user:pass@`+addr, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, "t1", "a"))
+		require.NoError(t, emit(s, "t1", "nack-b"))
+		require.NoError(t, emit(s, "t1", "c"))
+		require.NoError(t, s.Flush(ctx))
+		// The messages after the rejected one are published again, so that
+		// they follow it.
+		require.Equal(t, []string{"///t1:a", "///t1:c", "///t1:nack-b", "///t1:c"}, newMessages())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("unroutable", func(t *testing.T) {
+		s, err := makeTestAMQPSink(t, `amqp://user:pass@`+addr+`?routing_key=unroutable.{topic}`,
+			map[string]string{changefeedbase.OptAMQPSinkConfig: `{"Retry":{"Max":1,"Backoff":"1ms"}}`}, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "a"))
+		err = s.Flush(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(),
+			`amqp server returned message published to exchange "" with routing key "unroutable.t1": 312 NO_ROUTE`)
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("confirm timeout", func(t *testing.T) {
+		broker.SetHoldAcks(true)
+		s, err := makeTestAMQPSink(t, `amqp://user:pass@`+addr, map[string]string{
+			changefeedbase.OptAMQPSinkConfig: `{"ConfirmTimeout":"10ms","MaxPending":1,"Retry":{"Max":1,"Backoff":"1ms"}}`,
+		}, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "a"))
+		// The second message waits for the confirmation of the first one.
+		err = s.EmitRow(ctx, topic("t1"), []byte(`[1]`), []byte("b"), zeroTS, zeroTS, zeroAlloc)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `timed out after 10ms waiting for amqp server to confirm 1 messages`)
+
+		// The message is published again on a new connection once the broker
+		// confirms the messages.
+		broker.SetHoldAcks(false)
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{"///t1:a"}, newMessages())
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+}
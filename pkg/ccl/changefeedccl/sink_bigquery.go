@@ -0,0 +1,1077 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	gtransport "google.golang.org/api/transport/grpc"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	bigQueryScope           = "https://www.googleapis.com/auth/bigquery"
+	bigQueryStorageEndpoint = "bigquerystorage.googleapis.com:443"
+	// bigQueryMaxBytesPerRequest is the maximum size of the rows of an
+	// AppendRows request, which leaves room for the writer schema under the
+	// 10MiB limit of the requests.
+	bigQueryMaxBytesPerRequest = 9 << 20
+)
+
+// The columns added to the BigQuery tables after the columns of the rows.
+const (
+	// bigQueryUpdatedColumn is the timestamp at which the row was updated.
+	bigQueryUpdatedColumn = `_crdb_updated`
+	// bigQueryMVCCTimestampColumn is the MVCC timestamp of the row, as the
+	// decimal of the mvcc_timestamp option, which orders the updates of a row
+	// when several of them have the same updated timestamp.
+	bigQueryMVCCTimestampColumn = `_crdb_mvcc_timestamp`
+	// bigQueryDeletedColumn is true when the row was deleted, in which case
+	// only the primary key columns are set.
+	bigQueryDeletedColumn = `_crdb_deleted`
+)
+
+// bigQueryKind is the BigQuery type of a column.
+type bigQueryKind int
+
+const (
+	bigQueryString bigQueryKind = iota
+	bigQueryInt64
+	bigQueryFloat64
+	bigQueryBool
+	bigQueryBigNumeric
+	bigQueryBytes
+	bigQueryTimestamp
+	bigQueryDatetime
+	bigQueryDate
+	bigQueryJSON
+)
+
+// bigQueryTypeNames are the names of the BigQuery types in the table schemas
+// of the BigQuery API, which are the names of the legacy SQL dialect.
+var bigQueryTypeNames = [...]string{
+	bigQueryString:     "STRING",
+	bigQueryInt64:      "INTEGER",
+	bigQueryFloat64:    "FLOAT",
+	bigQueryBool:       "BOOLEAN",
+	bigQueryBigNumeric: "BIGNUMERIC",
+	bigQueryBytes:      "BYTES",
+	bigQueryTimestamp:  "TIMESTAMP",
+	bigQueryDatetime:   "DATETIME",
+	bigQueryDate:       "DATE",
+	bigQueryJSON:       "JSON",
+}
+
+// bigQueryTypeAliases maps the names of the standard SQL dialect to the ones of
+// the table schemas.
+var bigQueryTypeAliases = map[string]string{
+	"INT64":      "INTEGER",
+	"FLOAT64":    "FLOAT",
+	"BOOL":       "BOOLEAN",
+	"BIGDECIMAL": "BIGNUMERIC",
+}
+
+// bigQueryProtoTypes are the protocol buffer types of the fields of the rows
+// appended to the columns of each BigQuery type, as expected by the Storage
+// Write API: the timestamps are microseconds since the epoch, the dates are
+// days since the epoch, and the numerics and datetimes are strings.
+var bigQueryProtoTypes = [...]descriptorpb.FieldDescriptorProto_Type{
+	bigQueryString:     descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	bigQueryInt64:      descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	bigQueryFloat64:    descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	bigQueryBool:       descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	bigQueryBigNumeric: descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	bigQueryBytes:      descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	bigQueryTimestamp:  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	bigQueryDatetime:   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	bigQueryDate:       descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	bigQueryJSON:       descriptorpb.FieldDescriptorProto_TYPE_STRING,
+}
+
+// bigQueryScalarKind returns the BigQuery type of the columns of a type. The
+// types without a BigQuery counterpart are stored as strings.
+func bigQueryScalarKind(typ *types.T) bigQueryKind {
+	switch typ.Family() {
+	case types.IntFamily:
+		return bigQueryInt64
+	case types.FloatFamily:
+		return bigQueryFloat64
+	case types.BoolFamily:
+		return bigQueryBool
+	case types.DecimalFamily:
+		return bigQueryBigNumeric
+	case types.BytesFamily:
+		return bigQueryBytes
+	case types.TimestampTZFamily:
+		return bigQueryTimestamp
+	case types.TimestampFamily:
+		return bigQueryDatetime
+	case types.DateFamily:
+		return bigQueryDate
+	case types.JsonFamily:
+		return bigQueryJSON
+	default:
+		return bigQueryString
+	}
+}
+
+// bigQueryColumnKind returns the BigQuery type of the columns of a type, and
+// whether they are repeated. The arrays are stored as repeated columns, except
+// the nested arrays which BigQuery does not support.
+func bigQueryColumnKind(typ *types.T) (kind bigQueryKind, repeated bool) {
+	if typ.Family() == types.ArrayFamily {
+		if typ.ArrayContents().Family() == types.ArrayFamily {
+			return bigQueryString, false
+		}
+		return bigQueryScalarKind(typ.ArrayContents()), true
+	}
+	return bigQueryScalarKind(typ), false
+}
+
+// bigQueryName returns a valid BigQuery table or column name for a name, in
+// which the characters other than letters, digits and underscores are replaced
+// by underscores.
+func bigQueryName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// bigQueryColumnName returns a valid BigQuery column name for a column, which
+// must not start with a digit.
+func bigQueryColumnName(name string) string {
+	name = bigQueryName(name)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return "_" + name
+	}
+	return name
+}
+
+// bigQueryColumn is a column of a BigQuery table.
+type bigQueryColumn struct {
+	Name string
+	// Type is the name of the type of the column in the table schemas.
+	Type     string
+	Repeated bool
+}
+
+// bigQueryField is a field of the rows appended to a BigQuery table, which is
+// encoded from a column of the changefeed rows.
+type bigQueryField struct {
+	column bigQueryColumn
+	kind   bigQueryKind
+	// source is the name of the column in the changefeed rows.
+	source string
+	// keyIdx is the index of the column in the primary key, or -1.
+	keyIdx int
+}
+
+// bigQuerySchema is the schema of the rows of a topic appended to a BigQuery
+// table: its fields are the columns of the rows, followed by the timestamp
+// and deletion columns.
+type bigQuerySchema struct {
+	fields     []bigQueryField
+	descriptor *descriptorpb.DescriptorProto
+}
+
+// makeBigQuerySchema returns the schema of the rows described by an event
+// descriptor.
+func makeBigQuerySchema(desc *cdcevent.EventDescriptor) (*bigQuerySchema, error) {
+	sc := &bigQuerySchema{
+		descriptor: &descriptorpb.DescriptorProto{Name: proto.String("ChangefeedRow")},
+	}
+	// BigQuery column names are case insensitive.
+	names := make(map[string]string)
+	addField := func(f bigQueryField) error {
+		lowerName := strings.ToLower(f.column.Name)
+		if other, ok := names[lowerName]; ok {
+			return errors.Errorf("columns %q and %q both map to BigQuery column %s",
+				other, f.source, f.column.Name)
+		}
+		names[lowerName] = f.source
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if f.column.Repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		sc.fields = append(sc.fields, f)
+		sc.descriptor.Field = append(sc.descriptor.Field, &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(f.column.Name),
+			Number: proto.Int32(int32(len(sc.fields))),
+			Label:  label.Enum(),
+			Type:   bigQueryProtoTypes[f.kind].Enum(),
+		})
+		return nil
+	}
+	addColumn := func(col cdcevent.ResultColumn, keyIdx int) error {
+		kind, repeated := bigQueryColumnKind(col.Typ)
+		return addField(bigQueryField{
+			column: bigQueryColumn{
+				Name:     bigQueryColumnName(col.Name),
+				Type:     bigQueryTypeNames[kind],
+				Repeated: repeated,
+			},
+			kind:   kind,
+			source: col.Name,
+			keyIdx: keyIdx,
+		})
+	}
+
+	// The primary key columns come first, and are always set from the keys of
+	// the rows, which also have them when the rows are deleted or when their
+	// column family does not contain them.
+	keyCols := desc.KeyColumns()
+	isKey := make(map[string]struct{}, len(keyCols))
+	for i, col := range keyCols {
+		if err := addColumn(col, i); err != nil {
+			return nil, err
+		}
+		isKey[col.Name] = struct{}{}
+	}
+	for _, col := range desc.ValueColumns() {
+		if _, ok := isKey[col.Name]; ok {
+			continue
+		}
+		if err := addColumn(col, -1); err != nil {
+			return nil, err
+		}
+	}
+	for _, meta := range []struct {
+		name string
+		kind bigQueryKind
+	}{
+		{bigQueryUpdatedColumn, bigQueryTimestamp},
+		{bigQueryMVCCTimestampColumn, bigQueryBigNumeric},
+		{bigQueryDeletedColumn, bigQueryBool},
+	} {
+		if err := addField(bigQueryField{
+			column: bigQueryColumn{Name: meta.name, Type: bigQueryTypeNames[meta.kind]},
+			kind:   meta.kind,
+			source: meta.name,
+			keyIdx: -1,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return sc, nil
+}
+
+// columns returns the columns of the BigQuery table to which the rows are
+// appended.
+func (sc *bigQuerySchema) columns() []bigQueryColumn {
+	cols := make([]bigQueryColumn, len(sc.fields))
+	for i, f := range sc.fields {
+		cols[i] = f.column
+	}
+	return cols
+}
+
+// equal returns true if the rows of both schemas are encoded in the same way.
+func (sc *bigQuerySchema) equal(other *bigQuerySchema) bool {
+	if len(sc.fields) != len(other.fields) {
+		return false
+	}
+	for i := range sc.fields {
+		if sc.fields[i] != other.fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeRow encodes a row as a protocol buffer with the schema, from the key
+// and the value of the row encoded in JSON with the wrapped envelope.
+func (sc *bigQuerySchema) encodeRow(
+	key, value []byte, updated, mvcc hlc.Timestamp,
+) ([]byte, error) {
+	var keyDatums []json.RawMessage
+	if err := json.Unmarshal(key, &keyDatums); err != nil {
+		return nil, errors.Wrap(err, "decoding key")
+	}
+	var envelope struct {
+		After map[string]json.RawMessage `json:"after"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return nil, errors.Wrap(err, "decoding value")
+	}
+
+	numCols := len(sc.fields) - 3
+	var row []byte
+	for i, f := range sc.fields[:numCols] {
+		v := envelope.After[f.source]
+		if f.keyIdx >= 0 {
+			if f.keyIdx >= len(keyDatums) {
+				return nil, errors.AssertionFailedf("key %s is missing column %s", key, f.source)
+			}
+			v = keyDatums[f.keyIdx]
+		}
+		var err error
+		if row, err = appendBigQueryField(row, protowire.Number(i+1), f, v); err != nil {
+			return nil, errors.Wrapf(err, "encoding column %s", f.source)
+		}
+	}
+	row = protowire.AppendTag(row, protowire.Number(numCols+1), protowire.VarintType)
+	row = protowire.AppendVarint(row, uint64(updated.GoTime().UnixMicro()))
+	row = protowire.AppendTag(row, protowire.Number(numCols+2), protowire.BytesType)
+	row = protowire.AppendString(row, mvcc.AsOfSystemTime())
+	row = protowire.AppendTag(row, protowire.Number(numCols+3), protowire.VarintType)
+	row = protowire.AppendVarint(row, protowire.EncodeBool(envelope.After == nil))
+	return row, nil
+}
+
+func isJSONNull(v json.RawMessage) bool {
+	v = bytes.TrimSpace(v)
+	return len(v) == 0 || string(v) == "null"
+}
+
+// appendBigQueryField appends the encoding of a JSON value of a column to a
+// row. The NULL values are omitted.
+func appendBigQueryField(
+	row []byte, num protowire.Number, f bigQueryField, v json.RawMessage,
+) ([]byte, error) {
+	if isJSONNull(v) {
+		return row, nil
+	}
+	if !f.column.Repeated {
+		return appendBigQueryValue(row, num, f.kind, v)
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(v, &elems); err != nil {
+		return nil, err
+	}
+	for _, elem := range elems {
+		if isJSONNull(elem) {
+			return nil, errors.New("BigQuery arrays cannot contain NULL elements")
+		}
+		var err error
+		if row, err = appendBigQueryValue(row, num, f.kind, elem); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// appendBigQueryValue appends the encoding of a JSON value, as encoded by
+// tree.AsJSON, as a field of a BigQuery type.
+func appendBigQueryValue(
+	row []byte, num protowire.Number, kind bigQueryKind, v json.RawMessage,
+) ([]byte, error) {
+	// text is the content of the JSON strings, and the JSON text of the other
+	// values.
+	text := string(v)
+	if len(v) > 0 && v[0] == '"' {
+		if err := json.Unmarshal(v, &text); err != nil {
+			return nil, err
+		}
+	}
+
+	switch kind {
+	case bigQueryInt64:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		row = protowire.AppendTag(row, num, protowire.VarintType)
+		return protowire.AppendVarint(row, uint64(i)), nil
+	case bigQueryFloat64:
+		// The infinite and NaN floats are encoded as strings.
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, err
+		}
+		row = protowire.AppendTag(row, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(row, math.Float64bits(f)), nil
+	case bigQueryBool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, err
+		}
+		row = protowire.AppendTag(row, num, protowire.VarintType)
+		return protowire.AppendVarint(row, protowire.EncodeBool(b)), nil
+	case bigQueryBytes:
+		if !strings.HasPrefix(text, `\x`) {
+			return nil, errors.Errorf("expected hex encoded bytes, got %q", text)
+		}
+		b, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return nil, err
+		}
+		row = protowire.AppendTag(row, num, protowire.BytesType)
+		return protowire.AppendBytes(row, b), nil
+	case bigQueryTimestamp:
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, err
+		}
+		row = protowire.AppendTag(row, num, protowire.VarintType)
+		return protowire.AppendVarint(row, uint64(t.UnixMicro())), nil
+	case bigQueryDatetime:
+		t, err := time.Parse("2006-01-02T15:04:05.999999999", text)
+		if err != nil {
+			return nil, err
+		}
+		row = protowire.AppendTag(row, num, protowire.BytesType)
+		return protowire.AppendString(row, t.Format("2006-01-02 15:04:05.999999")), nil
+	case bigQueryDate:
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, err
+		}
+		days := t.Unix() / int64(24*time.Hour/time.Second)
+		row = protowire.AppendTag(row, num, protowire.VarintType)
+		return protowire.AppendVarint(row, uint64(days)), nil
+	case bigQueryJSON:
+		row = protowire.AppendTag(row, num, protowire.BytesType)
+		return protowire.AppendBytes(row, v), nil
+	default:
+		row = protowire.AppendTag(row, num, protowire.BytesType)
+		return protowire.AppendString(row, text), nil
+	}
+}
+
+type bigQueryFlushConfig struct {
+	Messages, Bytes int `json:",omitempty"`
+}
+
+// proper JSON schema for bigquery sink config:
+//
+//	{
+//	  "Flush": {
+//	    "Messages": ...,
+//	    "Bytes":    ...,
+//	  },
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// The rows of each table are buffered until an AppendRows request is full, the
+// Flush thresholds are reached or the changefeed flushes the sink.
+type bigQuerySinkConfig struct {
+	Flush bigQueryFlushConfig `json:",omitempty"`
+	Retry retryConfig         `json:",omitempty"`
+}
+
+func getBigQuerySinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg bigQuerySinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative",
+			changefeedbase.OptBigQuerySinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+// bigQueryClient is the part of the BigQuery API used by the BigQuery sink,
+// for the tables of a dataset.
+type bigQueryClient interface {
+	// getTableColumns returns the columns of a table, and false if the table
+	// does not exist.
+	getTableColumns(ctx context.Context, table string) ([]bigQueryColumn, bool, error)
+	createTable(ctx context.Context, table string, cols []bigQueryColumn) error
+	// addTableColumns adds nullable columns to a table.
+	addTableColumns(ctx context.Context, table string, cols []bigQueryColumn) error
+	// createWriteStream creates a committed write stream for a table, and
+	// returns its name.
+	createWriteStream(ctx context.Context, table string) (string, error)
+	// appendRows sends an AppendRows request, and returns its response.
+	appendRows(ctx context.Context, req *storagepb.AppendRowsRequest) (*storagepb.AppendRowsResponse, error)
+	// finalizeWriteStream finalizes a write stream, to which no more rows are
+	// appended.
+	finalizeWriteStream(ctx context.Context, stream string) error
+	close() error
+}
+
+// bigQueryAPIClient is the bigQueryClient of the BigQuery APIs: the REST API
+// for the tables, and the gRPC Storage Write API for the rows.
+type bigQueryAPIClient struct {
+	project, dataset string
+	tables           *bigquery.Service
+	conn             *grpc.ClientConn
+	writer           storagepb.BigQueryWriteClient
+
+	// ctx is the context of the AppendRows streams, which is canceled when the
+	// client is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// appendStreams are the open AppendRows streams, by write stream.
+	appendStreams map[string]storagepb.BigQueryWrite_AppendRowsClient
+}
+
+var _ bigQueryClient = (*bigQueryAPIClient)(nil)
+
+func newBigQueryAPIClient(
+	ctx context.Context, project, dataset string, creds option.ClientOption,
+) (*bigQueryAPIClient, error) {
+	tables, err := bigquery.NewService(ctx, creds)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating BigQuery client")
+	}
+	conn, err := gtransport.Dial(ctx, creds, option.WithEndpoint(bigQueryStorageEndpoint))
+	if err != nil {
+		return nil, errors.Wrap(err, "dialing BigQuery Storage Write API")
+	}
+	c := &bigQueryAPIClient{
+		project:       project,
+		dataset:       dataset,
+		tables:        tables,
+		conn:          conn,
+		writer:        storagepb.NewBigQueryWriteClient(conn),
+		appendStreams: make(map[string]storagepb.BigQueryWrite_AppendRowsClient),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+func isGoogleAPIErrorCode(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func toBigQueryColumns(schema *bigquery.TableSchema) []bigQueryColumn {
+	if schema == nil {
+		return nil
+	}
+	cols := make([]bigQueryColumn, len(schema.Fields))
+	for i, f := range schema.Fields {
+		typ := f.Type
+		if alias, ok := bigQueryTypeAliases[typ]; ok {
+			typ = alias
+		}
+		cols[i] = bigQueryColumn{Name: f.Name, Type: typ, Repeated: f.Mode == "REPEATED"}
+	}
+	return cols
+}
+
+func toBigQueryFields(cols []bigQueryColumn) []*bigquery.TableFieldSchema {
+	fields := make([]*bigquery.TableFieldSchema, len(cols))
+	for i, col := range cols {
+		mode := "NULLABLE"
+		if col.Repeated {
+			mode = "REPEATED"
+		}
+		fields[i] = &bigquery.TableFieldSchema{Name: col.Name, Type: col.Type, Mode: mode}
+	}
+	return fields
+}
+
+func (c *bigQueryAPIClient) getTableColumns(
+	ctx context.Context, table string,
+) ([]bigQueryColumn, bool, error) {
+	t, err := c.tables.Tables.Get(c.project, c.dataset, table).Context(ctx).Do()
+	if err != nil {
+		if isGoogleAPIErrorCode(err, http.StatusNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return toBigQueryColumns(t.Schema), true, nil
+}
+
+func (c *bigQueryAPIClient) createTable(
+	ctx context.Context, table string, cols []bigQueryColumn,
+) error {
+	_, err := c.tables.Tables.Insert(c.project, c.dataset, &bigquery.Table{
+		TableReference: &bigquery.TableReference{
+			ProjectId: c.project,
+			DatasetId: c.dataset,
+			TableId:   table,
+		},
+		Schema: &bigquery.TableSchema{Fields: toBigQueryFields(cols)},
+	}).Context(ctx).Do()
+	if isGoogleAPIErrorCode(err, http.StatusConflict) {
+		// The table was created concurrently by another node.
+		return nil
+	}
+	return err
+}
+
+func (c *bigQueryAPIClient) addTableColumns(
+	ctx context.Context, table string, cols []bigQueryColumn,
+) error {
+	t, err := c.tables.Tables.Get(c.project, c.dataset, table).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	// The columns may have been added concurrently by another node.
+	existing := make(map[string]struct{})
+	for _, f := range t.Schema.Fields {
+		existing[strings.ToLower(f.Name)] = struct{}{}
+	}
+	schema := &bigquery.TableSchema{Fields: t.Schema.Fields}
+	for _, f := range toBigQueryFields(cols) {
+		if _, ok := existing[strings.ToLower(f.Name)]; !ok {
+			schema.Fields = append(schema.Fields, f)
+		}
+	}
+	_, err = c.tables.Tables.Patch(c.project, c.dataset, table, &bigquery.Table{Schema: schema}).
+		Context(ctx).Do()
+	return err
+}
+
+func (c *bigQueryAPIClient) createWriteStream(ctx context.Context, table string) (string, error) {
+	ws, err := c.writer.CreateWriteStream(ctx, &storagepb.CreateWriteStreamRequest{
+		Parent:      fmt.Sprintf("projects/%s/datasets/%s/tables/%s", c.project, c.dataset, table),
+		WriteStream: &storagepb.WriteStream{Type: storagepb.WriteStream_COMMITTED},
+	})
+	if err != nil {
+		return "", err
+	}
+	return ws.Name, nil
+}
+
+func (c *bigQueryAPIClient) appendRows(
+	_ context.Context, req *storagepb.AppendRowsRequest,
+) (*storagepb.AppendRowsResponse, error) {
+	stream, ok := c.appendStreams[req.WriteStream]
+	if !ok {
+		// The requests are routed to the backend of the write stream.
+		streamCtx := metadata.AppendToOutgoingContext(c.ctx,
+			"x-goog-request-params", "write_stream="+req.WriteStream)
+		var err error
+		if stream, err = c.writer.AppendRows(streamCtx); err != nil {
+			return nil, err
+		}
+		c.appendStreams[req.WriteStream] = stream
+	}
+	err := stream.Send(req)
+	var resp *storagepb.AppendRowsResponse
+	if err == nil {
+		resp, err = stream.Recv()
+	}
+	if err != nil {
+		// The stream is broken, and is opened again by the next request, which
+		// is why the requests always contain the writer schema.
+		_ = stream.CloseSend()
+		delete(c.appendStreams, req.WriteStream)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *bigQueryAPIClient) finalizeWriteStream(ctx context.Context, stream string) error {
+	if s, ok := c.appendStreams[stream]; ok {
+		_ = s.CloseSend()
+		delete(c.appendStreams, stream)
+	}
+	_, err := c.writer.FinalizeWriteStream(ctx, &storagepb.FinalizeWriteStreamRequest{Name: stream})
+	return err
+}
+
+func (c *bigQueryAPIClient) close() error {
+	c.cancel()
+	return c.conn.Close()
+}
+
+// bigQueryTable is a BigQuery table to which the rows of a topic are
+// appended, through a committed write stream.
+type bigQueryTable struct {
+	name string
+	// version is the version of the topic whose schema is used to append the
+	// rows.
+	version descpb.DescriptorVersion
+	schema  *bigQuerySchema
+	// stream is the write stream to which the rows are appended, and offset is
+	// the offset in the stream of the next rows.
+	stream string
+	offset int64
+
+	// The rows buffered for the table, which are yet to be appended.
+	rows      [][]byte
+	bytes     int
+	alloc     kvevent.Alloc
+	emitTime  time.Time
+	mvcc      hlc.Timestamp
+	emitBytes int
+}
+
+type bigQuerySinkKnobs struct {
+	OverrideClient func() (bigQueryClient, error)
+}
+
+// bigQuerySink appends the rows of a changefeed to BigQuery tables with the
+// Storage Write API. The rows of each table are appended to the BigQuery table
+// named after it in the dataset of the sink URI, whose columns are the columns
+// of the rows followed by the _crdb_updated, _crdb_mvcc_timestamp and
+// _crdb_deleted columns. Deleted rows are appended with their primary key
+// columns, so that the tables record the history of the rows.
+//
+// The tables are created when they do not exist, and the columns added to the
+// tables of the changefeed are added to them as nullable columns. The columns
+// dropped from the tables of the changefeed are kept, and are NULL in the
+// newer rows. The changefeed fails if a column exists with a different type.
+//
+// The rows of a table are appended to a committed write stream at explicit
+// offsets, so that a retried request whose response was lost does not append
+// its rows again: BigQuery rejects the offsets which were already appended
+// with ALREADY_EXISTS. The write streams are created when the sink is dialed,
+// so the rows emitted again when the changefeed restarts from its last
+// resolved timestamp are appended again, and are delivered at least once.
+type bigQuerySink struct {
+	ctx              context.Context
+	project, dataset string
+	creds            option.ClientOption
+	client           bigQueryClient
+	topicNamer       *TopicNamer
+	cfg              bigQuerySinkConfig
+	retryCfg         sinkRetryPolicy
+	metrics          metricsRecorder
+	knobs            bigQuerySinkKnobs
+
+	tables map[string]*bigQueryTable
+}
+
+var _ Sink = (*bigQuerySink)(nil)
+
+func makeBigQuerySink(
+	ctx context.Context,
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.BigQuerySinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	if encodingOpts.Format != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+	if encodingOpts.Envelope != changefeedbase.OptEnvelopeWrapped {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+
+	dataset := strings.Trim(u.Path, "/")
+	if u.Host == "" || dataset == "" || strings.Contains(dataset, "/") {
+		return nil, errors.Errorf(`bigquery sink URI must be bigquery://<project>/<dataset>`)
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicNamer, err := MakeTopicNamer(targets,
+		WithPrefix(topicPrefix), WithJoinByte('_'), WithSanitizeFn(bigQueryName))
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := getGCPCredentials(ctx, u, bigQueryScope)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown bigquery sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	s := &bigQuerySink{
+		ctx:        ctx,
+		project:    u.Host,
+		dataset:    dataset,
+		creds:      creds,
+		topicNamer: topicNamer,
+		metrics:    mb(requiresResourceAccounting),
+		tables:     make(map[string]*bigQueryTable),
+	}
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getBigQuerySinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptBigQuerySinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface.
+func (s *bigQuerySink) Dial() error {
+	if s.knobs.OverrideClient != nil {
+		client, err := s.knobs.OverrideClient()
+		s.client = client
+		return err
+	}
+	client, err := newBigQueryAPIClient(s.ctx, s.project, s.dataset, s.creds)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	return nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *bigQuerySink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	name, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	t, ok := s.tables[name]
+	if !ok {
+		t = &bigQueryTable{name: name}
+		s.tables[name] = t
+	}
+	if t.schema == nil || t.version != topic.GetVersion() {
+		if err := s.updateSchema(ctx, t, topic); err != nil {
+			return err
+		}
+	}
+
+	row, err := t.schema.encodeRow(key, value, updated, mvcc)
+	if err != nil {
+		return errors.Wrapf(err, "encoding row for BigQuery table %s", t.name)
+	}
+	if len(row) > bigQueryMaxBytesPerRequest {
+		return errors.Errorf("row of %d bytes exceeds the maximum size of BigQuery requests of %d bytes",
+			len(row), bigQueryMaxBytesPerRequest)
+	}
+	if t.bytes+len(row) > bigQueryMaxBytesPerRequest {
+		if err := s.send(ctx, t); err != nil {
+			return err
+		}
+	}
+	if len(t.rows) == 0 {
+		t.emitTime = timeutil.Now()
+	}
+	t.rows = append(t.rows, row)
+	t.bytes += len(row)
+	t.emitBytes += len(key) + len(value)
+	t.alloc.Merge(&alloc)
+	if t.mvcc.IsEmpty() || mvcc.Less(t.mvcc) {
+		t.mvcc = mvcc
+	}
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+
+	if (s.cfg.Flush.Messages > 0 && len(t.rows) >= s.cfg.Flush.Messages) ||
+		(s.cfg.Flush.Bytes > 0 && t.bytes >= s.cfg.Flush.Bytes) {
+		return s.send(ctx, t)
+	}
+	return nil
+}
+
+// updateSchema updates the schema of the rows appended to a table to the
+// columns of a topic. When the columns changed, the rows buffered with the
+// previous schema are appended, the columns are added to the table, and a new
+// write stream is created, since the write streams only accept the columns
+// which existed when they were created.
+func (s *bigQuerySink) updateSchema(
+	ctx context.Context, t *bigQueryTable, topic TopicDescriptor,
+) error {
+	descTopic, ok := topic.(eventDescriptorTopic)
+	if !ok || descTopic.getEventDescriptor() == nil {
+		return errors.AssertionFailedf("topic %s does not describe its columns", t.name)
+	}
+	schema, err := makeBigQuerySchema(descTopic.getEventDescriptor())
+	if err != nil {
+		return errors.Wrapf(err, "mapping the columns of BigQuery table %s", t.name)
+	}
+	if t.schema != nil && t.schema.equal(schema) {
+		t.version = topic.GetVersion()
+		return nil
+	}
+
+	if err := s.send(ctx, t); err != nil {
+		return err
+	}
+	if err := s.ensureColumns(ctx, t.name, schema.columns()); err != nil {
+		return err
+	}
+	stream, err := s.client.createWriteStream(ctx, t.name)
+	if err != nil {
+		return errors.Wrapf(err, "creating write stream for BigQuery table %s", t.name)
+	}
+	if t.stream != "" {
+		if err := s.client.finalizeWriteStream(ctx, t.stream); err != nil {
+			return errors.Wrapf(err, "finalizing write stream for BigQuery table %s", t.name)
+		}
+	}
+	t.version, t.schema, t.stream, t.offset = topic.GetVersion(), schema, stream, 0
+	return nil
+}
+
+// ensureColumns creates a table with the given columns, or adds the missing
+// columns to it.
+func (s *bigQuerySink) ensureColumns(
+	ctx context.Context, table string, cols []bigQueryColumn,
+) error {
+	existing, found, err := s.client.getTableColumns(ctx, table)
+	if err != nil {
+		return errors.Wrapf(err, "getting BigQuery table %s", table)
+	}
+	if !found {
+		if err := s.client.createTable(ctx, table, cols); err != nil {
+			return errors.Wrapf(err, "creating BigQuery table %s", table)
+		}
+		return nil
+	}
+
+	byName := make(map[string]bigQueryColumn, len(existing))
+	for _, col := range existing {
+		byName[strings.ToLower(col.Name)] = col
+	}
+	var missing []bigQueryColumn
+	for _, col := range cols {
+		e, ok := byName[strings.ToLower(col.Name)]
+		if !ok {
+			missing = append(missing, col)
+			continue
+		}
+		if e.Type != col.Type || e.Repeated != col.Repeated {
+			return errors.Errorf("column %s of BigQuery table %s is %s, but the changefeed emits %s",
+				e.Name, table, bigQueryColumnTypeString(e), bigQueryColumnTypeString(col))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := s.client.addTableColumns(ctx, table, missing); err != nil {
+		return errors.Wrapf(err, "adding columns to BigQuery table %s", table)
+	}
+	return nil
+}
+
+func bigQueryColumnTypeString(col bigQueryColumn) string {
+	if col.Repeated {
+		return "REPEATED " + col.Type
+	}
+	return col.Type
+}
+
+// send appends the rows buffered for a table, and resets its buffer.
+func (s *bigQuerySink) send(ctx context.Context, t *bigQueryTable) error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	req := &storagepb.AppendRowsRequest{
+		WriteStream: t.stream,
+		Offset:      wrapperspb.Int64(t.offset),
+		Rows: &storagepb.AppendRowsRequest_ProtoRows{
+			ProtoRows: &storagepb.AppendRowsRequest_ProtoData{
+				WriterSchema: &storagepb.ProtoSchema{ProtoDescriptor: t.schema.descriptor},
+				Rows:         &storagepb.ProtoRows{SerializedRows: t.rows},
+			},
+		},
+	}
+	attempt := 0
+	if err := s.retryCfg.do(ctx, func() error {
+		if attempt++; attempt > 1 {
+			s.metrics.recordInternalRetry(int64(len(t.rows)), false)
+		}
+		resp, err := s.client.appendRows(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "appending rows to BigQuery table %s", t.name)
+		}
+		if resp.GetError() != nil {
+			st := status.FromProto(resp.GetError())
+			if st.Code() == codes.AlreadyExists {
+				// The rows were appended by a previous attempt, whose response
+				// was lost.
+				return nil
+			}
+			return errors.Wrapf(st.Err(), "appending rows to BigQuery table %s", t.name)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	t.offset += int64(len(t.rows))
+	s.metrics.recordEmittedBatch(t.emitTime, len(t.rows), t.mvcc, t.emitBytes, sinkDoesNotCompress)
+	t.alloc.Release(ctx)
+	t.rows, t.bytes, t.emitBytes, t.mvcc = nil, 0, 0, hlc.Timestamp{}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The BigQuery tables
+// only store rows, so the resolved timestamps are not emitted: the rows are
+// committed as soon as they are appended, which happens when the changefeed
+// flushes the sink before it resolves a timestamp.
+func (s *bigQuerySink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+	return nil
+}
+
+// Flush implements the Sink interface.
+func (s *bigQuerySink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	for _, t := range s.tables {
+		if err := s.send(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *bigQuerySink) Close() error {
+	for _, t := range s.tables {
+		t.alloc.Release(context.Background())
+	}
+	if s.client == nil {
+		return nil
+	}
+	return s.client.close()
+}
+
+// Topics gives the names of all tables that have been initialized
+// and will receive rows.
+func (s *bigQuerySink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
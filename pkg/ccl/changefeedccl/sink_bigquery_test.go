@@ -0,0 +1,439 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type fakeBigQueryTable struct {
+	cols []bigQueryColumn
+	// rows are the rows appended to the table, as space separated
+	// column=value pairs.
+	rows []string
+}
+
+type fakeBigQueryStream struct {
+	table string
+	// cols are the lower case names of the columns of the table when the
+	// stream was created, which are the only ones it accepts.
+	cols map[string]struct{}
+	// rows is the number of rows appended to the stream.
+	rows int64
+}
+
+// fakeBigQueryClient is a bigQueryClient storing the tables in memory, which
+// validates the offsets and the writer schemas of the AppendRows requests like
+// BigQuery.
+type fakeBigQueryClient struct {
+	tables    map[string]*fakeBigQueryTable
+	streams   map[string]*fakeBigQueryStream
+	finalized []string
+	// loseResponses is the number of the next AppendRows requests whose rows
+	// are appended, but whose responses are lost.
+	loseResponses int
+}
+
+var _ bigQueryClient = (*fakeBigQueryClient)(nil)
+
+func newFakeBigQueryClient() *fakeBigQueryClient {
+	return &fakeBigQueryClient{
+		tables:  make(map[string]*fakeBigQueryTable),
+		streams: make(map[string]*fakeBigQueryStream),
+	}
+}
+
+func (c *fakeBigQueryClient) getTableColumns(
+	_ context.Context, table string,
+) ([]bigQueryColumn, bool, error) {
+	t, ok := c.tables[table]
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]bigQueryColumn(nil), t.cols...), true, nil
+}
+
+func (c *fakeBigQueryClient) createTable(
+	_ context.Context, table string, cols []bigQueryColumn,
+) error {
+	if _, ok := c.tables[table]; ok {
+		return errors.Newf("table %s already exists", table)
+	}
+	c.tables[table] = &fakeBigQueryTable{cols: cols}
+	return nil
+}
+
+func (c *fakeBigQueryClient) addTableColumns(
+	_ context.Context, table string, cols []bigQueryColumn,
+) error {
+	t, ok := c.tables[table]
+	if !ok {
+		return errors.Newf("table %s does not exist", table)
+	}
+	t.cols = append(t.cols, cols...)
+	return nil
+}
+
+func (c *fakeBigQueryClient) createWriteStream(_ context.Context, table string) (string, error) {
+	t, ok := c.tables[table]
+	if !ok {
+		return "", errors.Newf("table %s does not exist", table)
+	}
+	name := fmt.Sprintf("%s/streams/%d", table, len(c.streams))
+	ws := &fakeBigQueryStream{table: table, cols: make(map[string]struct{})}
+	for _, col := range t.cols {
+		ws.cols[strings.ToLower(col.Name)] = struct{}{}
+	}
+	c.streams[name] = ws
+	return name, nil
+}
+
+func fakeBigQueryError(code codes.Code, format string, args ...interface{}) *storagepb.AppendRowsResponse {
+	return &storagepb.AppendRowsResponse{
+		Response: &storagepb.AppendRowsResponse_Error{Error: status.Newf(code, format, args...).Proto()},
+	}
+}
+
+func (c *fakeBigQueryClient) appendRows(
+	_ context.Context, req *storagepb.AppendRowsRequest,
+) (*storagepb.AppendRowsResponse, error) {
+	ws, ok := c.streams[req.WriteStream]
+	if !ok {
+		return fakeBigQueryError(codes.NotFound, "write stream %s not found", req.WriteStream), nil
+	}
+	switch offset := req.Offset.GetValue(); {
+	case offset < ws.rows:
+		return fakeBigQueryError(codes.AlreadyExists, "offset %d already exists", offset), nil
+	case offset > ws.rows:
+		return fakeBigQueryError(codes.OutOfRange, "offset %d is beyond the end of the stream", offset), nil
+	}
+	data := req.GetProtoRows()
+	desc := data.WriterSchema.ProtoDescriptor
+	for _, f := range desc.Field {
+		if _, ok := ws.cols[strings.ToLower(f.GetName())]; !ok {
+			return fakeBigQueryError(codes.InvalidArgument, "field %s is not in the table", f.GetName()), nil
+		}
+	}
+	var rows []string
+	for _, r := range data.Rows.SerializedRows {
+		row, err := decodeFakeBigQueryRow(desc, r)
+		if err != nil {
+			return fakeBigQueryError(codes.InvalidArgument, "%v", err), nil
+		}
+		rows = append(rows, row)
+	}
+
+	t := c.tables[ws.table]
+	t.rows = append(t.rows, rows...)
+	resp := &storagepb.AppendRowsResponse{
+		Response: &storagepb.AppendRowsResponse_AppendResult_{
+			AppendResult: &storagepb.AppendRowsResponse_AppendResult{Offset: wrapperspb.Int64(ws.rows)},
+		},
+	}
+	ws.rows += int64(len(rows))
+	if c.loseResponses > 0 {
+		c.loseResponses--
+		return nil, errors.New("connection reset")
+	}
+	return resp, nil
+}
+
+// decodeFakeBigQueryRow decodes a row with its writer schema.
+func decodeFakeBigQueryRow(desc *descriptorpb.DescriptorProto, b []byte) (string, error) {
+	var pairs []string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || num < 1 || int(num) > len(desc.Field) {
+			return "", errors.Newf("invalid field %d", num)
+		}
+		b = b[n:]
+		f := desc.Field[num-1]
+		var value string
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return "", protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch f.GetType() {
+			case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+				value = strconv.FormatBool(protowire.DecodeBool(v))
+			case descriptorpb.FieldDescriptorProto_TYPE_INT32:
+				value = strconv.Itoa(int(int32(v)))
+			default:
+				value = strconv.FormatInt(int64(v), 10)
+			}
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return "", protowire.ParseError(n)
+			}
+			b = b[n:]
+			value = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return "", protowire.ParseError(n)
+			}
+			b = b[n:]
+			value = string(v)
+		default:
+			return "", errors.Newf("unexpected wire type %d", typ)
+		}
+		pairs = append(pairs, f.GetName()+"="+value)
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+func (c *fakeBigQueryClient) finalizeWriteStream(_ context.Context, stream string) error {
+	c.finalized = append(c.finalized, stream)
+	return nil
+}
+
+func (c *fakeBigQueryClient) close() error {
+	return nil
+}
+
+// fakeBigQueryCredentials are the credentials of an authorized user, which do
+// not need to be valid since the sink does not use them before it is dialed.
+var fakeBigQueryCredentials = url.QueryEscape(base64.StdEncoding.EncodeToString(
+	[]byte(`{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"token"}`)))
+
+func makeTestBigQuerySink(
+	t *testing.T, uri string, opts map[string]string, targetNames ...string,
+) (*bigQuerySink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	statementOpts := changefeedbase.MakeStatementOptions(opts)
+	encodingOpts, err := statementOpts.GetEncodingOptions()
+	require.NoError(t, err)
+	bigQueryOpts, err := statementOpts.GetBigQuerySinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeBigQuerySink(context.Background(), sinkURL{URL: u}, encodingOpts,
+		bigQueryOpts, makeChangefeedTargets(targetNames...), nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*bigQuerySink), nil
+}
+
+func TestBigQuerySinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		opts   map[string]string
+		topics []string
+		err    string
+	}{
+		{
+			name:   "defaults",
+			uri:    `bigquery://project/dataset?CREDENTIALS=` + fakeBigQueryCredentials,
+			topics: []string{"t"},
+		},
+		{
+			name:   "topic prefix",
+			uri:    `bigquery://project/dataset?topic_prefix=cdc-&CREDENTIALS=` + fakeBigQueryCredentials,
+			topics: []string{"cdc_t"},
+		},
+		{
+			name: "missing dataset",
+			uri:  `bigquery://project?CREDENTIALS=` + fakeBigQueryCredentials,
+			err:  `bigquery sink URI must be bigquery://<project>/<dataset>`,
+		},
+		{
+			name: "unknown param",
+			uri:  `bigquery://project/dataset?foo=bar&CREDENTIALS=` + fakeBigQueryCredentials,
+			err:  `unknown bigquery sink query parameters: foo`,
+		},
+		{
+			name: "csv",
+			uri:  `bigquery://project/dataset?CREDENTIALS=` + fakeBigQueryCredentials,
+			opts: map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatCSV)},
+			err:  `this sink is incompatible with format=csv`,
+		},
+		{
+			name: "row envelope",
+			uri:  `bigquery://project/dataset?CREDENTIALS=` + fakeBigQueryCredentials,
+			opts: map[string]string{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeRow)},
+			err:  `this sink is incompatible with envelope=row`,
+		},
+		{
+			name: "invalid config",
+			uri:  `bigquery://project/dataset?CREDENTIALS=` + fakeBigQueryCredentials,
+			opts: map[string]string{changefeedbase.OptBigQuerySinkConfig: `{"Flush":{"Messages":-1}}`},
+			err:  `all config values must be non-negative`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestBigQuerySink(t, tc.uri, tc.opts, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+		})
+	}
+}
+
+func TestBigQuerySink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := newFakeBigQueryClient()
+	var pool testAllocPool
+	updated, mvcc := hlc.Timestamp{WallTime: 2000}, hlc.Timestamp{WallTime: 1000, Logical: 1}
+	const meta = `_crdb_updated=2 _crdb_mvcc_timestamp=1000.0000000001`
+
+	makeSink := func(t *testing.T, targetNames ...string) *bigQuerySink {
+		s, err := makeTestBigQuerySink(t,
+			`bigquery://project/dataset?CREDENTIALS=`+fakeBigQueryCredentials,
+			map[string]string{changefeedbase.OptBigQuerySinkConfig: `{"Retry":{"Backoff":"1ms"}}`},
+			targetNames...)
+		require.NoError(t, err)
+		s.knobs.OverrideClient = func() (bigQueryClient, error) { return client, nil }
+		require.NoError(t, s.Dial())
+		return s
+	}
+	emit := func(s *bigQuerySink, topic TopicDescriptor, key, value string) error {
+		return s.EmitRow(ctx, topic, []byte(key), []byte(value), updated, mvcc, pool.alloc())
+	}
+
+	tCols := []descpb.ColumnDescriptor{
+		{Name: "a", Type: types.Int},
+		{Name: "b", Type: types.String, Nullable: true},
+		{Name: "c", Type: types.TimestampTZ, Nullable: true},
+		{Name: "d", Type: types.Bytes, Nullable: true},
+		{Name: "e-e", Type: types.StringArray, Nullable: true},
+	}
+	tV1 := makeTestTopicWithColumns(t, 100, "t", 1, tCols...)
+
+	t.Run("create table", func(t *testing.T) {
+		s := makeSink(t, "t")
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, tV1, `[1]`,
+			`{"after": {"a": 1, "b": "x", "c": "1970-01-01T00:00:01.5Z", "d": "\\x6869", "e-e": ["y", "z"]}}`))
+		require.NoError(t, emit(s, tV1, `[2]`, `{"after": {"a": 2, "b": null, "c": null, "d": null, "e-e": null}}`))
+		require.NoError(t, emit(s, tV1, `[1]`, `{"after": null}`))
+		require.NoError(t, s.Flush(ctx))
+
+		require.Equal(t, []bigQueryColumn{
+			{Name: "a", Type: "INTEGER"},
+			{Name: "b", Type: "STRING"},
+			{Name: "c", Type: "TIMESTAMP"},
+			{Name: "d", Type: "BYTES"},
+			{Name: "e_e", Type: "STRING", Repeated: true},
+			{Name: "_crdb_updated", Type: "TIMESTAMP"},
+			{Name: "_crdb_mvcc_timestamp", Type: "BIGNUMERIC"},
+			{Name: "_crdb_deleted", Type: "BOOLEAN"},
+		}, client.tables["t"].cols)
+		require.Equal(t, []string{
+			`a=1 b=x c=1500000 d=hi e_e=y e_e=z ` + meta + ` _crdb_deleted=false`,
+			`a=2 ` + meta + ` _crdb_deleted=false`,
+			`a=1 ` + meta + ` _crdb_deleted=true`,
+		}, client.tables["t"].rows)
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("schema evolution", func(t *testing.T) {
+		s := makeSink(t, "t")
+		defer func() { require.NoError(t, s.Close()) }()
+		client.tables["t"].rows = nil
+
+		require.NoError(t, emit(s, tV1, `[3]`, `{"after": {"a": 3}}`))
+		// Adding a column appends the rows of the previous version, adds the
+		// column to the table and appends the newer rows to a new write stream.
+		tV2 := makeTestTopicWithColumns(t, 100, "t", 2,
+			append(tCols, descpb.ColumnDescriptor{Name: "f", Type: types.Decimal, Nullable: true})...)
+		require.NoError(t, emit(s, tV2, `[4]`, `{"after": {"a": 4, "f": 1.50}}`))
+		require.Len(t, client.tables["t"].rows, 1)
+		require.NoError(t, s.Flush(ctx))
+
+		require.Equal(t, bigQueryColumn{Name: "f", Type: "BIGNUMERIC"}, client.tables["t"].cols[8])
+		require.Equal(t, []string{
+			`a=3 ` + meta + ` _crdb_deleted=false`,
+			`a=4 f=1.50 ` + meta + ` _crdb_deleted=false`,
+		}, client.tables["t"].rows)
+		require.Len(t, client.finalized, 1)
+
+		// A version which does not change the columns uses the same stream.
+		tV3 := makeTestTopicWithColumns(t, 100, "t", 3,
+			append(tCols, descpb.ColumnDescriptor{Name: "f", Type: types.Decimal, Nullable: true})...)
+		require.NoError(t, emit(s, tV3, `[5]`, `{"after": {"a": 5}}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Len(t, client.tables["t"].rows, 3)
+		require.Len(t, client.finalized, 1)
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("lost response", func(t *testing.T) {
+		s := makeSink(t, "t")
+		defer func() { require.NoError(t, s.Close()) }()
+		client.tables["t"].rows = nil
+
+		require.NoError(t, emit(s, tV1, `[6]`, `{"after": {"a": 6}}`))
+		require.NoError(t, s.Flush(ctx))
+		// The retried request is rejected since its offset was appended, so
+		// the row is not appended twice.
+		client.loseResponses = 1
+		require.NoError(t, emit(s, tV1, `[7]`, `{"after": {"a": 7}}`))
+		require.NoError(t, s.Flush(ctx))
+		require.NoError(t, emit(s, tV1, `[8]`, `{"after": {"a": 8}}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{
+			`a=6 ` + meta + ` _crdb_deleted=false`,
+			`a=7 ` + meta + ` _crdb_deleted=false`,
+			`a=8 ` + meta + ` _crdb_deleted=false`,
+		}, client.tables["t"].rows)
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("column type mismatch", func(t *testing.T) {
+		s := makeSink(t, "u")
+		defer func() { require.NoError(t, s.Close()) }()
+		client.tables["u"] = &fakeBigQueryTable{cols: []bigQueryColumn{{Name: "A", Type: "STRING"}}}
+
+		topic := makeTestTopicWithColumns(t, 101, "u", 1, descpb.ColumnDescriptor{Name: "a", Type: types.Int})
+		err := s.EmitRow(ctx, topic, []byte(`[1]`), []byte(`{"after": {"a": 1}}`), updated, mvcc, zeroAlloc)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `column A of BigQuery table u is STRING, but the changefeed emits INTEGER`)
+		require.Empty(t, client.tables["u"].rows)
+	})
+}
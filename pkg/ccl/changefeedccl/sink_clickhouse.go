@@ -0,0 +1,466 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// clickHouseMaxBytesPerInsert is the maximum size of the rows inserted
+	// into a table by a request.
+	clickHouseMaxBytesPerInsert = 64 << 20
+	// The default ports of the HTTP interface of ClickHouse, without and with
+	// TLS.
+	clickHouseDefaultPort    = "8123"
+	clickHouseDefaultTLSPort = "8443"
+)
+
+// The columns added to the rows inserted into ClickHouse tables.
+const (
+	clickHouseUpdatedColumn = "_crdb_updated"
+	clickHouseDeletedColumn = "_crdb_deleted"
+)
+
+// clickHouseQuoteIdentifier quotes a ClickHouse identifier.
+func clickHouseQuoteIdentifier(name string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
+
+type clickHouseFlushConfig struct {
+	Messages, Bytes int `json:",omitempty"`
+}
+
+// proper JSON schema for clickhouse sink config:
+//
+//	{
+//	  "Flush": {
+//	    "Messages": ...,
+//	    "Bytes":    ...,
+//	  },
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// The rows of each table are buffered until the changefeed flushes the sink,
+// which it does before it resolves a timestamp, so that each table receives
+// one large insert per resolved timestamp, as ClickHouse prefers. The Flush
+// thresholds insert the rows sooner.
+type clickHouseSinkConfig struct {
+	Flush clickHouseFlushConfig `json:",omitempty"`
+	Retry retryConfig           `json:",omitempty"`
+}
+
+func getClickHouseSinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg clickHouseSinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative",
+			changefeedbase.OptClickHouseSinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+// clickHouseError is an error returned by the HTTP interface of ClickHouse.
+type clickHouseError struct {
+	statusCode int
+	message    string
+}
+
+func (e *clickHouseError) Error() string {
+	return fmt.Sprintf("clickhouse returned %d %s: %s",
+		e.statusCode, http.StatusText(e.statusCode), e.message)
+}
+
+func (e *clickHouseError) httpStatusCode() int {
+	return e.statusCode
+}
+
+// clickHouseClient is a client of the HTTP interface of ClickHouse, for the
+// tables of a database.
+type clickHouseClient struct {
+	client   *httputil.Client
+	baseURL  string
+	database string
+	// user and password authenticate the requests, if user is set.
+	user, password string
+}
+
+func (c *clickHouseClient) do(
+	ctx context.Context, method string, query url.Values, body []byte,
+) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.user != "" {
+		req.Header.Set("X-ClickHouse-User", c.user)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &clickHouseError{statusCode: resp.StatusCode, message: strings.TrimSpace(string(respBody))}
+	}
+	return respBody, nil
+}
+
+// ping checks that the server is reachable and that the credentials and the
+// database are valid.
+func (c *clickHouseClient) ping(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, url.Values{
+		"query":    {"SELECT 1"},
+		"database": {c.database},
+	}, nil)
+	return err
+}
+
+// insert inserts newline delimited JSON rows into a table. The rows are
+// inserted with a deduplication token which identifies them, so that the
+// replicated tables ignore the inserts which are sent again after their
+// response was lost. The columns of the rows which the table does not have are
+// ignored.
+func (c *clickHouseClient) insert(ctx context.Context, table string, rows []byte) error {
+	token := sha256.Sum256(append([]byte(table+"\n"), rows...))
+	_, err := c.do(ctx, http.MethodPost, url.Values{
+		"query":                            {"INSERT INTO " + clickHouseQuoteIdentifier(table) + " FORMAT JSONEachRow"},
+		"database":                         {c.database},
+		"insert_deduplication_token":       {hex.EncodeToString(token[:])},
+		"input_format_skip_unknown_fields": {"1"},
+		"date_time_input_format":           {"best_effort"},
+	}, rows)
+	return err
+}
+
+// clickHouseTable is a ClickHouse table into which the rows of a topic are
+// inserted.
+type clickHouseTable struct {
+	name string
+	// rows are the rows buffered for the table.
+	rows     []byte
+	messages int
+
+	emitBytes int
+	alloc     kvevent.Alloc
+	emitTime  time.Time
+	mvcc      hlc.Timestamp
+}
+
+// clickHouseSink inserts the rows of a changefeed into ClickHouse tables with
+// the HTTP interface, in the JSONEachRow format. The rows of each table are
+// inserted into the ClickHouse table named after its topic, in the database of
+// the sink URI, with the columns of the rows followed by the _crdb_updated
+// column, which holds the update timestamp of the row, and the _crdb_deleted
+// column, which is true for the deleted rows, whose other columns are the
+// primary key columns. The tables must exist, and are typically
+// ReplacingMergeTree tables ordered by the primary key columns. The columns
+// which the tables do not have are ignored.
+//
+// The rows of each table are buffered until the changefeed flushes the sink,
+// and then inserted with a single request, so that ClickHouse receives large
+// inserts which advance with the resolved timestamps. The rows emitted again
+// after the changefeed restarts are inserted again.
+type clickHouseSink struct {
+	client     *clickHouseClient
+	topicNamer *TopicNamer
+	cfg        clickHouseSinkConfig
+	retryCfg   sinkRetryPolicy
+	metrics    metricsRecorder
+
+	tables map[string]*clickHouseTable
+}
+
+var _ Sink = (*clickHouseSink)(nil)
+
+func makeClickHouseSink(
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.ClickHouseSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	if encodingOpts.Format != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+	if encodingOpts.Envelope != changefeedbase.OptEnvelopeWrapped {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf(`clickhouse sink URI must specify a server`)
+	}
+	database := strings.Trim(u.Path, "/")
+	if strings.Contains(database, "/") {
+		return nil, errors.Errorf(`clickhouse sink URI must be clickhouse://<host>:<port>/<database>`)
+	}
+	if database == "" {
+		database = "default"
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicName := u.consumeParam(changefeedbase.SinkParamTopicName)
+	topicNamer, err := MakeTopicNamer(targets, WithPrefix(topicPrefix), WithSingleName(topicName))
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsEnabled bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := consumeTLSParams(&u, tlsEnabled)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown clickhouse sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	scheme, host := "http", u.Host
+	if tlsEnabled {
+		scheme = "https"
+	}
+	if u.Port() == "" {
+		if tlsEnabled {
+			host = net.JoinHostPort(u.Hostname(), clickHouseDefaultTLSPort)
+		} else {
+			host = net.JoinHostPort(u.Hostname(), clickHouseDefaultPort)
+		}
+	}
+	c := &clickHouseClient{
+		client:   httputil.NewClientWithTimeout(httputil.StandardHTTPTimeout),
+		baseURL:  scheme + "://" + host,
+		database: database,
+	}
+	c.client.Client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	s := &clickHouseSink{
+		client:     c,
+		topicNamer: topicNamer,
+		metrics:    mb(requiresResourceAccounting),
+		tables:     make(map[string]*clickHouseTable),
+	}
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getClickHouseSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptClickHouseSinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface.
+func (s *clickHouseSink) Dial() error {
+	return errors.Wrap(s.client.ping(context.Background()), "connecting to clickhouse")
+}
+
+// encodeClickHouseRow encodes a row as a JSON object with the columns of the
+// row and the _crdb_updated and _crdb_deleted columns, from the key and the
+// value of the row encoded in JSON with the wrapped envelope. The deleted rows
+// only have their primary key columns, whose names are given by the event
+// descriptor of the topic.
+func encodeClickHouseRow(
+	topic TopicDescriptor, key, value []byte, updated hlc.Timestamp,
+) ([]byte, error) {
+	var envelope struct {
+		After map[string]json.RawMessage `json:"after"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return nil, errors.Wrap(err, "decoding value")
+	}
+	row := envelope.After
+	deleted := row == nil
+	if deleted {
+		descTopic, ok := topic.(eventDescriptorTopic)
+		if !ok || descTopic.getEventDescriptor() == nil {
+			return nil, errors.AssertionFailedf("topic %s does not describe its columns", topic.GetName())
+		}
+		var keyDatums []json.RawMessage
+		if err := json.Unmarshal(key, &keyDatums); err != nil {
+			return nil, errors.Wrap(err, "decoding key")
+		}
+		keyCols := descTopic.getEventDescriptor().KeyColumns()
+		if len(keyDatums) != len(keyCols) {
+			return nil, errors.AssertionFailedf("key %s does not match the %d primary key columns",
+				key, len(keyCols))
+		}
+		row = make(map[string]json.RawMessage, len(keyCols)+2)
+		for i, col := range keyCols {
+			row[col.Name] = keyDatums[i]
+		}
+	}
+	updatedJSON, err := json.Marshal(updated.AsOfSystemTime())
+	if err != nil {
+		return nil, err
+	}
+	row[clickHouseUpdatedColumn] = updatedJSON
+	row[clickHouseDeletedColumn] = json.RawMessage("false")
+	if deleted {
+		row[clickHouseDeletedColumn] = json.RawMessage("true")
+	}
+	return json.Marshal(row)
+}
+
+// EmitRow implements the Sink interface.
+func (s *clickHouseSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	name, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	row, err := encodeClickHouseRow(topic, key, value, updated)
+	if err != nil {
+		return errors.Wrapf(err, "encoding row for clickhouse table %s", name)
+	}
+	row = append(row, '\n')
+	if len(row) > clickHouseMaxBytesPerInsert {
+		return errors.Errorf("row of %d bytes exceeds the maximum size of clickhouse inserts of %d bytes",
+			len(row), clickHouseMaxBytesPerInsert)
+	}
+
+	t, ok := s.tables[name]
+	if !ok {
+		t = &clickHouseTable{name: name}
+		s.tables[name] = t
+	}
+	if len(t.rows)+len(row) > clickHouseMaxBytesPerInsert {
+		if err := s.send(ctx, t); err != nil {
+			return err
+		}
+	}
+	if t.messages == 0 {
+		t.emitTime = timeutil.Now()
+	}
+	t.rows = append(t.rows, row...)
+	t.messages++
+	t.emitBytes += len(key) + len(value)
+	t.alloc.Merge(&alloc)
+	if t.mvcc.IsEmpty() || mvcc.Less(t.mvcc) {
+		t.mvcc = mvcc
+	}
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+
+	if (s.cfg.Flush.Messages > 0 && t.messages >= s.cfg.Flush.Messages) ||
+		(s.cfg.Flush.Bytes > 0 && len(t.rows) >= s.cfg.Flush.Bytes) {
+		return s.send(ctx, t)
+	}
+	return nil
+}
+
+// send inserts the rows buffered for a table.
+func (s *clickHouseSink) send(ctx context.Context, t *clickHouseTable) error {
+	if t.messages == 0 {
+		return nil
+	}
+	attempt := 0
+	if err := s.retryCfg.do(ctx, func() error {
+		if attempt++; attempt > 1 {
+			s.metrics.recordInternalRetry(int64(t.messages), false)
+		}
+		return errors.Wrapf(s.client.insert(ctx, t.name, t.rows), "inserting rows into clickhouse table %s", t.name)
+	}); err != nil {
+		return err
+	}
+
+	s.metrics.recordEmittedBatch(t.emitTime, t.messages, t.mvcc, t.emitBytes, sinkDoesNotCompress)
+	t.alloc.Release(ctx)
+	*t = clickHouseTable{name: t.name}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The ClickHouse tables
+// only store rows, so the resolved timestamps are not emitted: the rows are
+// inserted when the changefeed flushes the sink before it resolves a
+// timestamp.
+func (s *clickHouseSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+	return nil
+}
+
+// Flush implements the Sink interface.
+func (s *clickHouseSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	for _, t := range s.tables {
+		if err := s.send(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *clickHouseSink) Close() error {
+	for _, t := range s.tables {
+		t.alloc.Release(context.Background())
+		*t = clickHouseTable{name: t.name}
+	}
+	s.client.client.CloseIdleConnections()
+	return nil
+}
+
+// Topics gives the names of all tables that have been initialized
+// and will receive rows.
+func (s *clickHouseSink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
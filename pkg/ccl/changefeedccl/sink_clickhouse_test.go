@@ -0,0 +1,308 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClickHouse implements the parts of the HTTP interface of ClickHouse used
+// by the clickhouse sink, for the tables of a database. Like the replicated
+// tables, it ignores the inserts whose deduplication token was already
+// inserted.
+type fakeClickHouse struct {
+	t        *testing.T
+	database string
+	mu       struct {
+		syncutil.Mutex
+		rows   map[string][]string
+		tokens map[string]bool
+		user   string
+		// failInserts is the number of the next inserts which fail without
+		// inserting their rows, and loseInserts the number of the next inserts
+		// which insert their rows but fail.
+		failInserts, loseInserts int
+	}
+}
+
+func newFakeClickHouse(t *testing.T, database string) *fakeClickHouse {
+	f := &fakeClickHouse{t: t, database: database}
+	f.mu.rows = make(map[string][]string)
+	f.mu.tokens = make(map[string]bool)
+	return f
+}
+
+func (f *fakeClickHouse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.mu.user = r.Header.Get("X-ClickHouse-User") + ":" + r.Header.Get("X-ClickHouse-Key")
+	q := r.URL.Query()
+	if q.Get("database") != f.database {
+		http.Error(w, "Code: 81. DB::Exception: Database does not exist.", http.StatusNotFound)
+		return
+	}
+	query := q.Get("query")
+	switch {
+	case r.Method == http.MethodGet && query == "SELECT 1":
+		fmt.Fprintln(w, "1")
+	case r.Method == http.MethodPost && strings.HasPrefix(query, "INSERT INTO "):
+		table := strings.TrimSuffix(strings.TrimPrefix(query, "INSERT INTO "), " FORMAT JSONEachRow")
+		require.Equal(f.t, "1", q.Get("input_format_skip_unknown_fields"))
+		if f.mu.failInserts > 0 {
+			f.mu.failInserts--
+			http.Error(w, "Code: 252. DB::Exception: Too many parts.", http.StatusInternalServerError)
+			return
+		}
+		token := table + "/" + q.Get("insert_deduplication_token")
+		if !f.mu.tokens[token] {
+			f.mu.tokens[token] = true
+			f.mu.rows[table] = append(f.mu.rows[table], strings.Split(strings.TrimSpace(string(body)), "\n")...)
+		}
+		if f.mu.loseInserts > 0 {
+			f.mu.loseInserts--
+			http.Error(w, "connection reset", http.StatusBadGateway)
+		}
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// rows returns the rows inserted into a table.
+func (f *fakeClickHouse) rows(table string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.mu.rows[table]...)
+}
+
+func (f *fakeClickHouse) user() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mu.user
+}
+
+func (f *fakeClickHouse) setFailures(failInserts, loseInserts int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.failInserts, f.mu.loseInserts = failInserts, loseInserts
+}
+
+func makeTestClickHouseSink(
+	t *testing.T, uri string, opts map[string]string, targetNames ...string,
+) (*clickHouseSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	statementOpts := changefeedbase.MakeStatementOptions(opts)
+	encodingOpts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	clickHouseOpts, err := statementOpts.GetClickHouseSinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeClickHouseSink(sinkURL{URL: u}, encodingOpts,
+		clickHouseOpts, makeChangefeedTargets(targetNames...),
+		nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*clickHouseSink), nil
+}
+
+func TestClickHouseSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name     string
+		uri      string
+		opts     map[string]string
+		topics   []string
+		database string
+		baseURL  string
+		err      string
+	}{
+		{
+			name:     "defaults",
+			uri:      `clickhouse://localhost`,
+			topics:   []string{"t"},
+			database: "default",
+			baseURL:  "http://localhost:8123",
+		},
+		{
+			name:     "tls",
+			uri:      `clickhouse://localhost/analytics?tls_enabled=true&topic_prefix=crdb_`,
+			topics:   []string{"crdb_t"},
+			database: "analytics",
+			baseURL:  "https://localhost:8443",
+		},
+		{
+			name:     "port",
+			uri:      `clickhouse://localhost:9000/analytics?topic_name=all`,
+			topics:   []string{"all"},
+			database: "analytics",
+			baseURL:  "http://localhost:9000",
+		},
+		{
+			name: "missing server",
+			uri:  `clickhouse:///db`,
+			err:  `clickhouse sink URI must specify a server`,
+		},
+		{
+			name: "invalid path",
+			uri:  `clickhouse://localhost/db/t`,
+			err:  `clickhouse sink URI must be clickhouse://<host>:<port>/<database>`,
+		},
+		{
+			name: "unknown param",
+			uri:  `clickhouse://localhost?foo=bar`,
+			err:  `unknown clickhouse sink query parameters: foo`,
+		},
+		{
+			name: "invalid config",
+			uri:  `clickhouse://localhost`,
+			opts: map[string]string{changefeedbase.OptClickHouseSinkConfig: `{"Flush":{"Bytes":-1}}`},
+			err:  `all config values must be non-negative`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestClickHouseSink(t, tc.uri, tc.opts, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+			require.Equal(t, tc.database, s.client.database)
+			require.Equal(t, tc.baseURL, s.client.baseURL)
+			require.NoError(t, s.Close())
+		})
+	}
+}
+
+func TestClickHouseSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	fake := newFakeClickHouse(t, "db")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	uri := fmt.Sprintf(`clickhouse://crdb:secret@%s/db`, server.Listener.Addr())
+	fastRetries := map[string]string{
+		changefeedbase.OptClickHouseSinkConfig: `{"Retry":{"Backoff":"1ms"}}`,
+	}
+
+	var pool testAllocPool
+	emit := func(s *clickHouseSink, topic TopicDescriptor, key string, updated int64, after string) error {
+		return s.EmitRow(ctx, topic, []byte(key),
+			[]byte(fmt.Sprintf(`{"after": %s, "key": %s}`, after, key)),
+			hlc.Timestamp{WallTime: updated}, zeroTS, pool.alloc())
+	}
+	cols := []descpb.ColumnDescriptor{
+		{Name: "id", Type: types.Int},
+		{Name: "v", Type: types.String, Nullable: true},
+	}
+	t1 := makeTestTopicWithColumns(t, 100, "t1", 1, cols...)
+	t2 := makeTestTopicWithColumns(t, 101, "t2", 1, cols...)
+
+	t.Run("dial", func(t *testing.T) {
+		s, err := makeTestClickHouseSink(t, uri, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.Equal(t, "crdb:secret", fake.user())
+		require.NoError(t, s.Close())
+
+		s, err = makeTestClickHouseSink(t, fmt.Sprintf(`clickhouse://%s/nodb`, server.Listener.Addr()), nil, "t1")
+		require.NoError(t, err)
+		err = s.Dial()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `Database does not exist`)
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("emit", func(t *testing.T) {
+		s, err := makeTestClickHouseSink(t, uri, nil, "t1", "t2")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, t1, `[1]`, 1, `{"id": 1, "v": "a"}`))
+		require.NoError(t, emit(s, t2, `[1]`, 1, `{"id": 1, "v": null}`))
+		require.NoError(t, emit(s, t1, `[2]`, 2, `{"id": 2, "v": "b"}`))
+		// The rows are only inserted when the sink is flushed.
+		require.Empty(t, fake.rows("`t1`"))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{
+			`{"_crdb_deleted":false,"_crdb_updated":"1.0000000000","id":1,"v":"a"}`,
+			`{"_crdb_deleted":false,"_crdb_updated":"2.0000000000","id":2,"v":"b"}`,
+		}, fake.rows("`t1`"))
+		require.Equal(t, []string{
+			`{"_crdb_deleted":false,"_crdb_updated":"1.0000000000","id":1,"v":null}`,
+		}, fake.rows("`t2`"))
+		require.EqualValues(t, 0, pool.used())
+
+		// The deleted rows have their primary key columns.
+		require.NoError(t, emit(s, t1, `[1]`, 3, `null`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, `{"_crdb_deleted":true,"_crdb_updated":"3.0000000000","id":1}`, fake.rows("`t1`")[2])
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("flush thresholds", func(t *testing.T) {
+		s, err := makeTestClickHouseSink(t, uri+`?topic_prefix=thresholds_`,
+			map[string]string{changefeedbase.OptClickHouseSinkConfig: `{"Flush":{"Messages":2}}`}, "t1")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, t1, `[1]`, 1, `{"id": 1}`))
+		require.Empty(t, fake.rows("`thresholds_t1`"))
+		require.NoError(t, emit(s, t1, `[2]`, 1, `{"id": 2}`))
+		require.Len(t, fake.rows("`thresholds_t1`"), 2)
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("failed inserts are retried", func(t *testing.T) {
+		s, err := makeTestClickHouseSink(t, uri+`?topic_prefix=retries_`, fastRetries, "t1")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		fake.setFailures(1, 0)
+		require.NoError(t, emit(s, t1, `[1]`, 1, `{"id": 1}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Len(t, fake.rows("`retries_t1`"), 1)
+
+		// The inserts whose response was lost are deduplicated.
+		fake.setFailures(0, 1)
+		require.NoError(t, emit(s, t1, `[2]`, 2, `{"id": 2}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Len(t, fake.rows("`retries_t1`"), 2)
+		require.EqualValues(t, 0, pool.used())
+	})
+}
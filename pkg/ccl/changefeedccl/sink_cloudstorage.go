@@ -10,7 +10,6 @@ package changefeedccl
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
@@ -18,6 +17,7 @@ import (
 	"io"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -35,6 +35,8 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
 	"github.com/google/btree"
 )
 
@@ -68,13 +70,19 @@ type cloudStorageSinkFile struct {
 	buf         bytes.Buffer
 	alloc       kvevent.Alloc
 	oldestMVCC  hlc.Timestamp
+	// parquetWriter buffers the rows of the file when the sink writes parquet
+	// files; the rows are written to buf when it is closed.
+	parquetWriter *goparquet.FileWriter
+	parquetSchema *parquetRowSchema
+	// partitionValues are the values of the expressions of the partition
+	// template for the rows in this file.
+	partitionValues []string
 }
 
 var _ io.Writer = &cloudStorageSinkFile{}
 
 func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 	f.rawSize += len(p)
-	f.numMessages++
 	if f.codec != nil {
 		return f.codec.Write(p)
 	}
@@ -107,7 +115,8 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 // sink adds some quality of life guarantees of its own.
 // 3. All rows in a file are from the same table. Further, all rows in a file are
 // from the same schema version of that table, and so all have the same schema.
-// 4. All files are partitioned into folders by the date part of the filename.
+// 4. All files are partitioned into folders by the date part of the filename
+// (by default; see partitionTemplate for the other supported layouts).
 //
 // Two methods of the cloudStorageSink on each data emitting processor are
 // called. EmitRow is called with each row change and Flush is called before
@@ -198,7 +207,11 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 // deleted, included in hive queries, etc). A typical user of cloudStorageSink
 // would periodically do exactly this.
 //
-// Still TODO is writing out data schemas, Avro support, bounding memory usage.
+// The definitions of the messages of format=protobuf which are not registered
+// in a schema registry are written to schema files in the root directory,
+// named `<subject>-<schema_id>.proto` (see protobufSchemaFileName).
+//
+// Still TODO is Avro support, bounding memory usage.
 //
 // Now what follows is a proof of why the above is correct even in the presence
 // of multiple job restarts. We begin by establishing some terminology and by
@@ -282,14 +295,37 @@ type cloudStorageSink struct {
 	sinkID            int64
 	targetMaxFileSize int64
 	settings          *cluster.Settings
-	partitionFormat   string
-	topicNamer        *TopicNamer
+	partitionTemplate *partitionTemplate
+	// partitionDirs are the partitions written to since the last resolved
+	// timestamp, into whose directories the next resolved timestamp file is
+	// written.
+	partitionDirs partitionDirectories
+	topicNamer    *TopicNamer
+
+	// targetMaxFileRows and targetMaxFileDuration, when set, also bound the
+	// number of rows in the files and how long the files are open before they
+	// are flushed.
+	targetMaxFileRows     int
+	targetMaxFileDuration time.Duration
 
 	ext          string
 	rowDelimiter []byte
 
 	compression string
 
+	// tableFormat is the table_format of the sink, if any. When the sink writes
+	// tables, the rows are written to parquet files, and the resolved
+	// timestamps commit the files to the tables.
+	tableFormat string
+	tables      tableCommitter
+	// parquet is set when the rows are written to parquet files, compressed
+	// with parquetCodec, either for format=parquet or for the tables.
+	parquet      bool
+	parquetCodec parquet.CompressionCodec
+	// csvHeaders, if set, builds the header rows starting the files of
+	// format=csv (see changefeedbase.OptCSVHeader).
+	csvHeaders *csvHeaders
+
 	es cloud.ExternalStorage
 
 	// These are fields to track information needed to output files based on the naming
@@ -302,27 +338,14 @@ type cloudStorageSink struct {
 	// We keep track of the successor of the least resolved timestamp in the local
 	// frontier as of the time of the last `Flush()` call. If `Flush()` hasn't been
 	// called, these fields are based on the statement time of the changefeed.
-	dataFileTs        string
-	dataFilePartition string
-	prevFilename      string
-	metrics           metricsRecorder
+	dataFileTs   string
+	dataFileHLC  hlc.Timestamp
+	prevFilename string
+	metrics      metricsRecorder
 }
 
-const sinkCompressionGzip = "gzip"
-
 var cloudStorageSinkIDAtomic int64
 
-// Files that are emitted can be partitioned by their earliest event time,
-// for example being emitted to topic/date/file.ndjson, or further split by hour.
-// Note that a file may contain events with timestamps that would normally
-// fall under a different partition had they been flushed later.
-var partitionDateFormats = map[string]string{
-	"flat":   "/",
-	"daily":  "2006-01-02/",
-	"hourly": "2006-01-02/15/",
-}
-var defaultPartitionFormat = partitionDateFormats["daily"]
-
 func makeCloudStorageSink(
 	ctx context.Context,
 	u sinkURL,
@@ -341,6 +364,22 @@ func makeCloudStorageSink(
 			return nil, pgerror.Wrapf(err, pgcode.Syntax, `parsing %s`, fileSizeParam)
 		}
 	}
+	var targetMaxFileRows int
+	if maxRowsParam := u.consumeParam(changefeedbase.SinkParamFileMaxRows); maxRowsParam != `` {
+		var err error
+		if targetMaxFileRows, err = strconv.Atoi(maxRowsParam); err != nil || targetMaxFileRows <= 0 {
+			return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+				`%s must be a positive integer, found %q`, changefeedbase.SinkParamFileMaxRows, maxRowsParam)
+		}
+	}
+	var targetMaxFileDuration time.Duration
+	if maxDurationParam := u.consumeParam(changefeedbase.SinkParamFileMaxDuration); maxDurationParam != `` {
+		var err error
+		if targetMaxFileDuration, err = time.ParseDuration(maxDurationParam); err != nil || targetMaxFileDuration <= 0 {
+			return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+				`%s must be a positive duration, found %q`, changefeedbase.SinkParamFileMaxDuration, maxDurationParam)
+		}
+	}
 	u.Scheme = strings.TrimPrefix(u.Scheme, `experimental-`)
 
 	sinkID := atomic.AddInt64(&cloudStorageSinkIDAtomic, 1)
@@ -363,25 +402,42 @@ func makeCloudStorageSink(
 		settings:          settings,
 		targetMaxFileSize: targetMaxFileSize,
 		files:             btree.New(8),
-		partitionFormat:   defaultPartitionFormat,
 		timestampOracle:   timestampOracle,
 		// TODO(dan,ajwerner): Use the jobs framework's session ID once that's available.
 		jobSessionID: sessID,
 		topicNamer:   tn,
+
+		targetMaxFileRows:     targetMaxFileRows,
+		targetMaxFileDuration: targetMaxFileDuration,
 	}
 
-	if partitionFormat := u.consumeParam(changefeedbase.SinkParamPartitionFormat); partitionFormat != "" {
-		dateFormat, ok := partitionDateFormats[partitionFormat]
-		if !ok {
-			return nil, errors.Errorf("invalid partition_format of %s", partitionFormat)
-		}
+	// Files that are emitted can be partitioned by their earliest event time,
+	// for example being emitted to date/file.ndjson, or further split by hour,
+	// table, or values computed from the rows, such as the values of the
+	// partition columns. Note that a file may contain
+	// events with timestamps that would normally fall under a different
+	// partition had they been flushed later.
+	if s.partitionTemplate, err = parsePartitionTemplate(
+		u.consumeParam(changefeedbase.SinkParamPartitionFormat),
+		u.consumeParam(changefeedbase.SinkParamPartitionColumns),
+	); err != nil {
+		return nil, err
+	}
 
-		s.partitionFormat = dateFormat
+	var iceberg *icebergCommitter
+	switch s.tableFormat = u.consumeParam(changefeedbase.SinkParamTableFormat); s.tableFormat {
+	case "", cloudStorageTableFormatDelta:
+	case cloudStorageTableFormatIceberg:
+		if iceberg, err = makeIcebergCommitter(&u); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf(`unknown %s %q`, changefeedbase.SinkParamTableFormat, s.tableFormat)
 	}
 
 	if s.timestampOracle != nil {
-		s.dataFileTs = cloudStorageFormatTime(s.timestampOracle.inclusiveLowerBoundTS())
-		s.dataFilePartition = s.timestampOracle.inclusiveLowerBoundTS().GoTime().Format(s.partitionFormat)
+		s.dataFileHLC = s.timestampOracle.inclusiveLowerBoundTS()
+		s.dataFileTs = cloudStorageFormatTime(s.dataFileHLC)
 	}
 
 	switch encodingOpts.Format {
@@ -395,6 +451,23 @@ func makeCloudStorageSink(
 		// would require a bit of refactoring.
 		s.ext = `.csv`
 		s.rowDelimiter = []byte{'\n'}
+		if encodingOpts.CSV.Header {
+			s.csvHeaders = makeCSVHeaders(encodingOpts)
+		}
+	case changefeedbase.OptFormatMsgpack:
+		// MessagePack values are self-delimiting, so the files are streams of
+		// values.
+		s.ext = `.msgpack`
+	case changefeedbase.OptFormatTemplate:
+		s.ext = `.txt`
+		s.rowDelimiter = []byte{'\n'}
+	case changefeedbase.OptFormatXML:
+		// The documents have no newlines, so the files hold a document per
+		// line.
+		s.ext = `.xml`
+		s.rowDelimiter = []byte{'\n'}
+	case changefeedbase.OptFormatParquet:
+		s.parquet = true
 	default:
 		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
 			changefeedbase.OptFormat, encodingOpts.Format)
@@ -411,13 +484,32 @@ func makeCloudStorageSink(
 		return nil, errors.Errorf(`this sink requires the WITH %s option`, changefeedbase.OptKeyInValue)
 	}
 
-	if codec := encodingOpts.Compression; codec != "" {
-		if strings.EqualFold(codec, "gzip") {
-			s.compression = sinkCompressionGzip
-			s.ext = s.ext + ".gz"
-		} else {
-			return nil, errors.Errorf(`unsupported compression codec %q`, codec)
+	switch codec := encodingOpts.Compression; codec {
+	case "":
+	case changefeedbase.OptCompressionGzip:
+		s.compression = codec
+		s.ext = s.ext + ".gz"
+	case changefeedbase.OptCompressionZstd:
+		s.compression = codec
+		s.ext = s.ext + ".zst"
+	default:
+		return nil, errors.Errorf(`unsupported compression codec %q`, codec)
+	}
+
+	if s.tableFormat != "" {
+		if encodingOpts.Format != changefeedbase.OptFormatJSON && !s.parquet {
+			return nil, errors.Errorf(`%s=%s requires %s=%s`, changefeedbase.SinkParamTableFormat,
+				s.tableFormat, changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
 		}
+		s.parquet = true
+	}
+	if s.parquet {
+		// The rows are encoded in JSON by the encoder, and then converted to the
+		// columns of the parquet files, which are compressed by the parquet
+		// writers rather than as a whole.
+		s.ext = `.parquet`
+		s.parquetCodec = parquetCompressionCodec(s.compression)
+		s.compression = ""
 	}
 
 	// We make the external storage with a nil IOAccountingInterceptor since we
@@ -430,32 +522,71 @@ func makeCloudStorageSink(
 	} else {
 		s.metrics = (*sliMetrics)(nil)
 	}
+	switch s.tableFormat {
+	case cloudStorageTableFormatDelta:
+		s.tables = makeDeltaLogCommitter(s.es)
+	case cloudStorageTableFormatIceberg:
+		iceberg.init(s.es, u.URL)
+		s.tables = iceberg
+	}
 	return s, nil
 }
 
 func (s *cloudStorageSink) getOrCreateFile(
-	topic TopicDescriptor, eventMVCC hlc.Timestamp,
-) *cloudStorageSinkFile {
+	topic TopicDescriptor, eventMVCC hlc.Timestamp, partitionValues []string,
+) (*cloudStorageSinkFile, error) {
 	name, _ := s.topicNamer.Name(topic)
-	key := cloudStorageSinkKey{name, int64(topic.GetVersion())}
+	key := cloudStorageSinkKey{
+		topic:           name,
+		schemaID:        int64(topic.GetVersion()),
+		partitionValues: strings.Join(partitionValues, "/"),
+	}
 	if item := s.files.Get(key); item != nil {
 		f := item.(*cloudStorageSinkFile)
 		if eventMVCC.Less(f.oldestMVCC) {
 			f.oldestMVCC = eventMVCC
 		}
-		return f
+		return f, nil
 	}
 	f := &cloudStorageSinkFile{
 		created:             timeutil.Now(),
 		cloudStorageSinkKey: key,
 		oldestMVCC:          eventMVCC,
+		partitionValues:     partitionValues,
 	}
-	switch s.compression {
-	case sinkCompressionGzip:
-		f.codec = gzip.NewWriter(&f.buf)
+	if s.compression != "" {
+		codec, err := newCompressionWriter(s.compression, &f.buf)
+		if err != nil {
+			return nil, err
+		}
+		f.codec = codec
+	}
+	if s.parquet {
+		descTopic, ok := topic.(eventDescriptorTopic)
+		if !ok || descTopic.getEventDescriptor() == nil {
+			return nil, errors.AssertionFailedf("topic %s does not describe its columns", name)
+		}
+		schema, err := makeParquetRowSchema(descTopic.getEventDescriptor())
+		if err != nil {
+			return nil, errors.Wrapf(err, "mapping the columns of topic %s", name)
+		}
+		f.parquetSchema = schema
+		f.parquetWriter = schema.newFileWriter(&f.buf, s.parquetCodec)
+	}
+	if s.csvHeaders != nil {
+		header, err := s.csvHeaders.headerOf(topic)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(header); err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(s.rowDelimiter); err != nil {
+			return nil, err
+		}
 	}
 	s.files.ReplaceOrInsert(f)
-	return f
+	return f, nil
 }
 
 // EmitRow implements the Sink interface.
@@ -465,23 +596,78 @@ func (s *cloudStorageSink) EmitRow(
 	key, value []byte,
 	updated, mvcc hlc.Timestamp,
 	alloc kvevent.Alloc,
+) error {
+	if len(s.partitionTemplate.exprs) > 0 {
+		return errors.AssertionFailedf("%s references row values, but none were provided",
+			changefeedbase.SinkParamPartitionFormat)
+	}
+	return s.emitRow(ctx, topic, key, value, updated, mvcc, alloc, nil /* partitionValues */)
+}
+
+var _ PathPartitionedEventSink = (*cloudStorageSink)(nil)
+
+// PartitionExprs implements the PathPartitionedEventSink interface.
+func (s *cloudStorageSink) PartitionExprs() []string {
+	return s.partitionTemplate.exprs
+}
+
+// EmitRowWithPartitionValues implements the PathPartitionedEventSink interface.
+func (s *cloudStorageSink) EmitRowWithPartitionValues(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partitionValues []string,
+) error {
+	if len(partitionValues) != len(s.partitionTemplate.exprs) {
+		return errors.AssertionFailedf("expected %d partition values, found %d",
+			len(s.partitionTemplate.exprs), len(partitionValues))
+	}
+	return s.emitRow(ctx, topic, key, value, updated, mvcc, alloc, partitionValues)
+}
+
+func (s *cloudStorageSink) emitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partitionValues []string,
 ) error {
 	if s.files == nil {
 		return errors.New(`cannot EmitRow on a closed sink`)
 	}
 
 	s.metrics.recordMessageSize(int64(len(key) + len(value)))
-	file := s.getOrCreateFile(topic, mvcc)
-	file.alloc.Merge(&alloc)
-
-	if _, err := file.Write(value); err != nil {
+	file, err := s.getOrCreateFile(topic, mvcc, partitionValues)
+	if err != nil {
 		return err
 	}
-	if _, err := file.Write(s.rowDelimiter); err != nil {
-		return err
+	file.alloc.Merge(&alloc)
+
+	if file.parquetWriter != nil {
+		// The parquet writers buffer the rows until they are closed, so the size
+		// of the file is estimated from the size of the encoded rows.
+		row, err := file.parquetSchema.encodeRow(key, value, updated)
+		if err != nil {
+			return err
+		}
+		if err := file.parquetWriter.AddData(row); err != nil {
+			return err
+		}
+		file.rawSize += len(value)
+	} else {
+		if _, err := file.Write(value); err != nil {
+			return err
+		}
+		if _, err := file.Write(s.rowDelimiter); err != nil {
+			return err
+		}
 	}
+	file.numMessages++
 
-	if int64(file.buf.Len()) > s.targetMaxFileSize {
+	if s.shouldRotate(file) {
 		if err := s.flushTopicVersions(ctx, file.topic, file.schemaID); err != nil {
 			return err
 		}
@@ -489,6 +675,24 @@ func (s *cloudStorageSink) EmitRow(
 	return nil
 }
 
+// shouldRotate returns whether the file reached any of the limits of the
+// sink, in which case it is flushed and the next rows go to a new file. The
+// limits are only checked as rows are added to the file; files that stop
+// receiving rows are written by the next Flush.
+func (s *cloudStorageSink) shouldRotate(file *cloudStorageSinkFile) bool {
+	size := int64(file.buf.Len())
+	if file.parquetWriter != nil {
+		size = int64(file.rawSize)
+	}
+	if size > s.targetMaxFileSize {
+		return true
+	}
+	if s.targetMaxFileRows > 0 && file.numMessages >= s.targetMaxFileRows {
+		return true
+	}
+	return s.targetMaxFileDuration > 0 && timeutil.Since(file.created) >= s.targetMaxFileDuration
+}
+
 // EmitResolvedTimestamp implements the Sink interface.
 func (s *cloudStorageSink) EmitResolvedTimestamp(
 	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
@@ -499,6 +703,11 @@ func (s *cloudStorageSink) EmitResolvedTimestamp(
 
 	defer s.metrics.recordResolvedCallback()()
 
+	if s.tables != nil {
+		// The resolved timestamps of the tables are their commits.
+		return s.tables.commit(ctx, resolved)
+	}
+
 	var noTopic string
 	payload, err := encoder.EncodeResolvedTimestamp(ctx, noTopic, resolved)
 	if err != nil {
@@ -506,12 +715,26 @@ func (s *cloudStorageSink) EmitResolvedTimestamp(
 	}
 	// Don't need to copy payload because we never buffer it anywhere.
 
-	part := resolved.GoTime().Format(s.partitionFormat)
+	// The resolved timestamp file is written into the directory of every
+	// partition written to since the previous resolved timestamp, so that the
+	// ordering guarantees hold for the files in each partition.
+	dirs := s.partitionTemplate.resolvedDirectories(s.partitionDirs, resolved)
 	filename := fmt.Sprintf(`%s.RESOLVED`, cloudStorageFormatTime(resolved))
-	if log.V(1) {
-		log.Infof(ctx, "writing file %s %s", filename, resolved.AsOfSystemTime())
+	for _, dir := range dirs {
+		if log.V(1) {
+			log.Infof(ctx, "writing file %s %s", filepath.Join(dir, filename), resolved.AsOfSystemTime())
+		}
+		if err := cloud.WriteFile(ctx, s.es, filepath.Join(dir, filename), bytes.NewReader(payload)); err != nil {
+			// Immutable storages refuse to overwrite the resolved file of a
+			// timestamp emitted again after a restart, which it already records.
+			if errors.Is(err, cloud.ErrFileAlreadyExists) {
+				continue
+			}
+			return err
+		}
 	}
-	return cloud.WriteFile(ctx, s.es, filepath.Join(part, filename), bytes.NewReader(payload))
+	s.partitionDirs = nil
+	return nil
 }
 
 // flushTopicVersions flushes all open files for the provided topic up to and
@@ -533,19 +756,19 @@ func (s *cloudStorageSink) EmitResolvedTimestamp(
 func (s *cloudStorageSink) flushTopicVersions(
 	ctx context.Context, topic string, maxVersionToFlush int64,
 ) (err error) {
-	var toRemoveAlloc [2]int64    // generally avoid allocating
-	toRemove := toRemoveAlloc[:0] // schemaIDs of flushed files
+	var toRemoveAlloc [2]cloudStorageSinkKey // generally avoid allocating
+	toRemove := toRemoveAlloc[:0]            // keys of flushed files
 	gte := cloudStorageSinkKey{topic: topic}
 	lt := cloudStorageSinkKey{topic: topic, schemaID: maxVersionToFlush + 1}
 	s.files.AscendRange(gte, lt, func(i btree.Item) (wantMore bool) {
 		f := i.(*cloudStorageSinkFile)
 		if err = s.flushFile(ctx, f); err == nil {
-			toRemove = append(toRemove, f.schemaID)
+			toRemove = append(toRemove, f.cloudStorageSinkKey)
 		}
 		return err == nil
 	})
-	for _, v := range toRemove {
-		s.files.Delete(cloudStorageSinkKey{topic: topic, schemaID: v})
+	for _, k := range toRemove {
+		s.files.Delete(k)
 	}
 	return err
 }
@@ -571,8 +794,8 @@ func (s *cloudStorageSink) Flush(ctx context.Context) error {
 	// Record the least resolved timestamp being tracked in the frontier as of this point,
 	// to use for naming files until the next `Flush()`. See comment on cloudStorageSink
 	// for an overview of the naming convention and proof of correctness.
-	s.dataFileTs = cloudStorageFormatTime(s.timestampOracle.inclusiveLowerBoundTS())
-	s.dataFilePartition = s.timestampOracle.inclusiveLowerBoundTS().GoTime().Format(s.partitionFormat)
+	s.dataFileHLC = s.timestampOracle.inclusiveLowerBoundTS()
+	s.dataFileTs = cloudStorageFormatTime(s.dataFileHLC)
 	return nil
 }
 
@@ -591,6 +814,11 @@ func (s *cloudStorageSink) flushFile(ctx context.Context, file *cloudStorageSink
 			return err
 		}
 	}
+	if file.parquetWriter != nil {
+		if err := file.parquetWriter.Close(); err != nil {
+			return err
+		}
+	}
 
 	// We use this monotonically increasing fileID to ensure correct ordering
 	// among files emitted at the same timestamp during the same job session.
@@ -608,14 +836,42 @@ func (s *cloudStorageSink) flushFile(ctx context.Context, file *cloudStorageSink
 	}
 	s.prevFilename = filename
 	compressedBytes := file.buf.Len()
-	if err := cloud.WriteFile(ctx, s.es, filepath.Join(s.dataFilePartition, filename), bytes.NewReader(file.buf.Bytes())); err != nil {
+	dir := s.partitionTemplate.render(file.topic, s.dataFileHLC, file.partitionValues)
+	if s.tableFormat != "" {
+		// The files of each topic are in the directory of its table.
+		dir = file.topic + "/" + dir
+	}
+	if err := cloud.WriteFile(ctx, s.es, filepath.Join(dir, filename), bytes.NewReader(file.buf.Bytes())); err != nil {
 		return err
 	}
+	if s.tableFormat == "" {
+		s.partitionTemplate.written(&s.partitionDirs, file.topic, s.dataFileHLC, file.partitionValues)
+	}
 	s.metrics.recordEmittedBatch(file.created, file.numMessages, file.oldestMVCC, file.rawSize, compressedBytes)
 
 	return nil
 }
 
+var _ SchemaFileSink = (*cloudStorageSink)(nil)
+
+// WriteSchemaFile implements the SchemaFileSink interface. The schema files
+// are written to the root directory of the sink, so that they are not mistaken
+// for the data files of a partition.
+func (s *cloudStorageSink) WriteSchemaFile(ctx context.Context, name string, schema []byte) error {
+	if log.V(1) {
+		log.Infof(ctx, "writing schema file %s", name)
+	}
+	if err := cloud.WriteFile(ctx, s.es, name, bytes.NewReader(schema)); err != nil {
+		// Immutable storages refuse to overwrite the schema file written by
+		// another aggregator, whose definition is the same.
+		if errors.Is(err, cloud.ErrFileAlreadyExists) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 // Close implements the Sink interface.
 func (s *cloudStorageSink) Close() error {
 	s.files = nil
@@ -630,6 +886,9 @@ func (s *cloudStorageSink) Dial() error {
 type cloudStorageSinkKey struct {
 	topic    string
 	schemaID int64
+	// partitionValues are the '/' separated values of the expressions of the
+	// partition template (if any).
+	partitionValues string
 }
 
 func (k cloudStorageSinkKey) Less(other btree.Item) bool {
@@ -645,6 +904,9 @@ func (k cloudStorageSinkKey) Less(other btree.Item) bool {
 
 func keyLess(a, b cloudStorageSinkKey) bool {
 	if a.topic == b.topic {
+		if a.schemaID == b.schemaID {
+			return a.partitionValues < b.partitionValues
+		}
 		return a.schemaID < b.schemaID
 	}
 	return a.topic < b.topic
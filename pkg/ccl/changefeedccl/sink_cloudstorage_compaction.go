@@ -0,0 +1,443 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// compactionInterval is the interval between the passes of the compaction
+// jobs of cloud storage changefeeds.
+var compactionInterval = settings.RegisterDurationSetting(
+	settings.TenantWritable,
+	"changefeed.cloudstorage.compaction_interval",
+	"the interval between the passes of the compaction jobs of cloud storage changefeeds over their files",
+	time.Minute,
+	settings.PositiveDuration,
+)
+
+func init() {
+	jobs.RegisterConstructor(
+		jobspb.TypeChangefeedCompaction,
+		func(job *jobs.Job, settings *cluster.Settings) jobs.Resumer {
+			return &compactionResumer{job: job, settings: settings}
+		},
+		jobs.UsesTenantCostControl,
+	)
+}
+
+// parseCompactionTargetSize parses the value of the compact_files option.
+func parseCompactionTargetSize(value string) (int64, error) {
+	size, err := humanizeutil.ParseBytes(value)
+	if err != nil || size <= 0 {
+		return 0, errors.Errorf(`%s must be a positive size, found %q`, changefeedbase.OptCompactFiles, value)
+	}
+	return size, nil
+}
+
+// makeCompactionJobRecord returns the record of the compaction job of the
+// changefeed with the specified ID, or nil if the changefeed does not compact
+// its files.
+func makeCompactionJobRecord(
+	user username.SQLUsername, changefeedJobID jobspb.JobID, opts changefeedbase.StatementOptions,
+) (*jobs.Record, error) {
+	value, ok := opts.GetCompactFiles()
+	if !ok {
+		return nil, nil
+	}
+	size, err := parseCompactionTargetSize(value)
+	if err != nil {
+		return nil, err
+	}
+	return &jobs.Record{
+		Description: fmt.Sprintf("compaction of the files of changefeed %d", changefeedJobID),
+		Username:    user,
+		Details: jobspb.ChangefeedCompactionDetails{
+			ChangefeedJobID: changefeedJobID,
+			TargetFileSize:  size,
+		},
+		Progress: jobspb.ChangefeedCompactionProgress{},
+	}, nil
+}
+
+// compactionResumer is the resumer of the companion job of a cloud storage
+// changefeed created with the compact_files option. It periodically merges
+// the files emitted by the changefeed into larger files, and completes once
+// the changefeed is done.
+type compactionResumer struct {
+	job      *jobs.Job
+	settings *cluster.Settings
+}
+
+var _ jobs.Resumer = (*compactionResumer)(nil)
+
+// Resume is part of the jobs.Resumer interface.
+func (r *compactionResumer) Resume(ctx context.Context, execCtx interface{}) error {
+	p := execCtx.(sql.JobExecContext)
+	details := r.job.Details().(jobspb.ChangefeedCompactionDetails)
+	var through hlc.Timestamp
+	if progress := r.job.Progress().GetChangefeedCompaction(); progress != nil {
+		through = progress.CompactedThrough
+	}
+
+	for {
+		feed, err := p.ExecCfg().JobRegistry.LoadJob(ctx, details.ChangefeedJobID)
+		if err != nil {
+			if jobs.HasJobNotFoundError(err) {
+				// The record of the changefeed has been removed.
+				return nil
+			}
+			return err
+		}
+		// The status of the changefeed is checked before the pass, so that the
+		// last pass sees all the files of a changefeed which is done.
+		done := feed.Status().Terminal()
+
+		compacted, err := r.compact(ctx, p, feed, details.TargetFileSize, through)
+		if err != nil {
+			return err
+		}
+		if through.Less(compacted) {
+			through = compacted
+			if err := r.job.SetProgress(ctx, nil /* txn */, jobspb.ChangefeedCompactionProgress{
+				CompactedThrough: through,
+			}); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+
+		r.job.MarkIdle(true)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(compactionInterval.Get(&r.settings.SV)):
+		}
+		r.job.MarkIdle(false)
+	}
+}
+
+// compact compacts the files of the current sink of the changefeed.
+func (r *compactionResumer) compact(
+	ctx context.Context,
+	p sql.JobExecContext,
+	feed *jobs.Job,
+	targetFileSize int64,
+	through hlc.Timestamp,
+) (hlc.Timestamp, error) {
+	payload := feed.Payload()
+	details := feed.Details().(jobspb.ChangefeedDetails)
+	uri, template, err := compactionStorage(details.SinkURI)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	es, err := p.ExecCfg().DistSQLSrv.ExternalStorageFromURI(ctx, uri, payload.UsernameProto.Decode())
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	defer es.Close()
+	return compactCloudStorageFiles(ctx, es, template, targetFileSize, through)
+}
+
+// OnFailOrCancel is part of the jobs.Resumer interface.
+func (r *compactionResumer) OnFailOrCancel(context.Context, interface{}, error) error {
+	return nil
+}
+
+// compactionStorage returns the URI of the external storage of a cloud storage
+// sink, without the parameters which only configure the sink, and the
+// partition template of the sink.
+func compactionStorage(sinkURI string) (string, *partitionTemplate, error) {
+	parsed, err := url.Parse(sinkURI)
+	if err != nil {
+		return "", nil, err
+	}
+	u := sinkURL{URL: parsed}
+	u.Scheme = strings.TrimPrefix(u.Scheme, `experimental-`)
+	template, err := parsePartitionTemplate(
+		u.consumeParam(changefeedbase.SinkParamPartitionFormat),
+		u.consumeParam(changefeedbase.SinkParamPartitionColumns),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, param := range []string{
+		changefeedbase.SinkParamFileSize,
+		changefeedbase.SinkParamFileMaxRows,
+		changefeedbase.SinkParamFileMaxDuration,
+	} {
+		u.consumeParam(param)
+	}
+	return u.String(), template, nil
+}
+
+// compactCloudStorageFiles merges the data files emitted by a cloud storage
+// sink into files of up to targetFileSize bytes, and returns the timestamp of
+// the latest resolved timestamp file which closed a compacted window.
+//
+// The files of each directory are compacted one window at a time, a window
+// being the data files which lexically precede a resolved timestamp file and
+// follow the previous one. The files of a window are final once the resolved
+// timestamp file is written, and the windows closed at or before through
+// have been compacted by previous passes.
+//
+// Within a window, consecutive files of the same topic and schema version
+// are concatenated, in lexical order, into the first of them, and the others
+// are deleted. The merged file has the position of the first file in the
+// ordering of the sink, and holds the rows of the merged files in their
+// order, so the ordering guarantees of the sink are preserved. Compressed
+// files are merged into multi-member gzip files or multi-frame zstd files,
+// which the readers of either decompress as a whole. A pass interrupted
+// between the write of a merged file and the deletion of the others can leave
+// duplicate rows, as the at-least-once guarantee of changefeeds allows.
+func compactCloudStorageFiles(
+	ctx context.Context,
+	es cloud.ExternalStorage,
+	template *partitionTemplate,
+	targetFileSize int64,
+	through hlc.Timestamp,
+) (hlc.Timestamp, error) {
+	dirs, err := listCompactionFiles(ctx, es, template, through)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+
+	compacted := through
+	for dir, files := range dirs {
+		sort.Strings(files)
+		var window []string
+		for _, file := range files {
+			if !strings.HasSuffix(file, `.RESOLVED`) {
+				window = append(window, file)
+				continue
+			}
+			resolved, err := parseCloudStorageFormatTime(strings.TrimSuffix(file, `.RESOLVED`))
+			if err != nil {
+				return hlc.Timestamp{}, errors.Wrapf(err, "parsing resolved timestamp file %s", path.Join(dir, file))
+			}
+			if through.Less(resolved) {
+				if err := compactWindow(ctx, es, dir, window, targetFileSize); err != nil {
+					return hlc.Timestamp{}, err
+				}
+				compacted.Forward(resolved)
+			}
+			window = window[:0]
+		}
+	}
+	return compacted, nil
+}
+
+// listCompactionFiles returns the names of the files of the directories of a
+// cloud storage sink which may hold windows closed after through, by
+// directory.
+//
+// The directories are walked along the partition template of the sink, so
+// that the directories of the days, or of the hours of the day, which precede
+// those of through are not listed: their resolved timestamp files, which are
+// written to the directories of their own day and hour, precede through, so
+// their windows were compacted by previous passes. The directories which
+// follow those of through are listed entirely.
+func listCompactionFiles(
+	ctx context.Context, es cloud.ExternalStorage, template *partitionTemplate, through hlc.Timestamp,
+) (map[string][]string, error) {
+	dirs := make(map[string][]string)
+	listAll := func(prefix string) error {
+		return es.List(ctx, prefix, "", func(name string) error {
+			name = path.Join(prefix, strings.TrimPrefix(name, "/"))
+			dir, file := path.Split(name)
+			dirs[dir] = append(dirs[dir], file)
+			return nil
+		})
+	}
+
+	// walk lists the files under the directory of the template component i.
+	// The directory is bounded if its time based components are those of
+	// through, and dated if one of them is the day of through.
+	var walk func(dir string, i int, bounded, dated bool) error
+	walk = func(dir string, i int, bounded, dated bool) error {
+		if !bounded || i == len(template.components) {
+			return listAll(dir)
+		}
+		segments := template.components[i]
+		// The subdirectories which sort before floor are skipped, which is
+		// only possible if they are ordered by time.
+		var floor string
+		if timeOnly(segments) {
+			switch token := firstToken(segments); {
+			case token == partitionTokenDate:
+				dated = true
+			case token == partitionTokenHour && dated, token == "":
+			default:
+				// The subdirectories are not ordered by time.
+				return listAll(dir)
+			}
+			floor = renderSegments(segments, "" /* topic */, through, nil /* values */)
+		}
+
+		var subdirs []string
+		if err := es.List(ctx, dir, "/", func(name string) error {
+			name = strings.TrimPrefix(name, "/")
+			if strings.HasSuffix(name, "/") && name != "/" {
+				subdirs = append(subdirs, strings.TrimSuffix(name, "/"))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, subdir := range subdirs {
+			if subdir < floor {
+				continue
+			}
+			bounded := floor == "" || subdir == floor
+			if err := walk(path.Join(dir, subdir)+"/", i+1, bounded, dated); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", 0, true /* bounded */, false /* dated */); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// firstToken returns the first token of the template component, if any.
+func firstToken(segments []partitionTemplateSegment) string {
+	for _, s := range segments {
+		if s.token != "" {
+			return s.token
+		}
+	}
+	return ""
+}
+
+// compactWindow merges the data files of a window of a directory.
+func compactWindow(
+	ctx context.Context, es cloud.ExternalStorage, dir string, window []string, targetFileSize int64,
+) error {
+	// The data files are named
+	// <timestamp>-<session>-<node>-<sink>-<file>-<topic>-<schema><ext>, so the
+	// files of the same topic and schema version share the part of their name
+	// after the fifth dash. Other files, such as those of table formats, are
+	// left alone, as are parquet files, which cannot be concatenated.
+	groups := make(map[string][]string)
+	var keys []string
+	for _, file := range window {
+		parts := strings.SplitN(file, "-", 6)
+		if len(parts) != 6 || strings.HasSuffix(file, `.parquet`) {
+			continue
+		}
+		if _, ok := groups[parts[5]]; !ok {
+			keys = append(keys, parts[5])
+		}
+		groups[parts[5]] = append(groups[parts[5]], file)
+	}
+
+	for _, key := range keys {
+		var run []string
+		var runSize int64
+		for _, file := range groups[key] {
+			size, err := es.Size(ctx, path.Join(dir, file))
+			if err != nil {
+				return err
+			}
+			if len(run) > 0 && runSize+size > targetFileSize {
+				if err := mergeFiles(ctx, es, dir, run); err != nil {
+					return err
+				}
+				run, runSize = run[:0], 0
+			}
+			run = append(run, file)
+			runSize += size
+		}
+		if err := mergeFiles(ctx, es, dir, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeFiles concatenates the files into the first of them, and deletes the
+// others.
+func mergeFiles(ctx context.Context, es cloud.ExternalStorage, dir string, files []string) error {
+	if len(files) < 2 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, file := range files {
+		r, err := es.ReadFile(ctx, path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		content, err := ioctx.ReadAll(ctx, r)
+		_ = r.Close(ctx)
+		if err != nil {
+			return err
+		}
+		buf.Write(content)
+	}
+	if log.V(1) {
+		log.Infof(ctx, "merging %d files into %s", len(files), path.Join(dir, files[0]))
+	}
+	if err := cloud.WriteFile(ctx, es, path.Join(dir, files[0]), &buf); err != nil {
+		return err
+	}
+	for _, file := range files[1:] {
+		if err := es.Delete(ctx, path.Join(dir, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCloudStorageFormatTime parses the timestamps formatted by
+// cloudStorageFormatTime.
+func parseCloudStorageFormatTime(s string) (hlc.Timestamp, error) {
+	const f = `20060102150405`
+	if len(s) != len(f)+9+10 {
+		return hlc.Timestamp{}, errors.Errorf("malformed timestamp %q", s)
+	}
+	t, err := time.Parse(f, s[:len(f)])
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	nanos, err := strconv.ParseInt(s[len(f):len(f)+9], 10, 64)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	logical, err := strconv.ParseInt(s[len(f)+9:], 10, 32)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	return hlc.Timestamp{WallTime: t.UnixNano() + nanos, Logical: int32(logical)}, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudStorageCompaction(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	ctx := context.Background()
+
+	dir, dirCleanupFn := testutils.TempDir(t)
+	defer dirCleanupFn()
+
+	settings := cluster.MakeTestingClusterSettings()
+	settings.ExternalIODir = dir
+	opts := changefeedbase.EncodingOptions{
+		Format:     changefeedbase.OptFormatJSON,
+		Envelope:   changefeedbase.OptEnvelopeWrapped,
+		KeyInValue: true,
+	}
+	e, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+	require.NoError(t, err)
+	ts := func(i int64) hlc.Timestamp { return hlc.Timestamp{WallTime: i} }
+
+	clientFactory := blobs.TestBlobServiceClient(settings.ExternalIODir)
+	externalStorageFromURI := func(ctx context.Context, uri string, user username.SQLUsername, opts ...cloud.ExternalStorageOption) (cloud.ExternalStorage,
+		error) {
+		return cloud.ExternalStorageFromURI(ctx, uri, base.ExternalIODirConfig{}, settings,
+			clientFactory, user, nil, nil, nil, opts...)
+	}
+
+	// makeSink makes a sink which writes every row to its own file, and a
+	// function which resolves the specified timestamp like changefeeds do: the
+	// frontier is forwarded and the sink flushed, so that the files written
+	// after the resolved timestamp file follow it.
+	makeSink := func(t *testing.T, dir string) (*cloudStorageSink, func(int64)) {
+		u, err := url.Parse(fmt.Sprintf(`nodelocal://0/%s?%s=1`, dir, changefeedbase.SinkParamFileMaxRows))
+		require.NoError(t, err)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		s, err := makeCloudStorageSink(
+			ctx, sinkURL{URL: u}, 1, settings, opts, &changeAggregatorLowerBoundOracle{sf: sf},
+			externalStorageFromURI, username.RootUserName(), nil,
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, s.Close()) })
+		resolve := func(i int64) {
+			_, err := sf.Forward(testSpan, ts(i))
+			require.NoError(t, err)
+			require.NoError(t, s.Flush(ctx))
+			require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(i)))
+		}
+		return s.(*cloudStorageSink), resolve
+	}
+
+	// readFiles returns the contents of the files of the sink, in lexical order
+	// of their names.
+	readFiles := func(t *testing.T, es cloud.ExternalStorage) []string {
+		var names []string
+		require.NoError(t, es.List(ctx, "", "", func(name string) error {
+			names = append(names, strings.TrimPrefix(name, "/"))
+			return nil
+		}))
+		sort.Strings(names)
+		var files []string
+		for _, name := range names {
+			r, err := es.ReadFile(ctx, name)
+			require.NoError(t, err)
+			content, err := ioctx.ReadAll(ctx, r)
+			require.NoError(t, r.Close(ctx))
+			require.NoError(t, err)
+			files = append(files, string(content))
+		}
+		return files
+	}
+
+	emit := func(t *testing.T, s *cloudStorageSink, topic TopicDescriptor, values ...string) {
+		for _, v := range values {
+			require.NoError(t, s.EmitRow(ctx, topic, nil /* key */, []byte(v), ts(1), ts(1), zeroAlloc))
+		}
+	}
+
+	t.Run(`windows`, func(t *testing.T) {
+		t1, t2 := makeTopic(`t1`), makeTopic(`t2`)
+		s, resolve := makeSink(t, `windows`)
+		emit(t, s, t1, `v1`)
+		emit(t, s, t2, `w1`)
+		emit(t, s, t1, `v2`, `v3`)
+		resolve(3)
+		emit(t, s, t1, `v4`, `v5`)
+		require.Equal(t, []string{
+			"v1\n", "w1\n", "v2\n", "v3\n", `{"resolved":"3.0000000000"}`, "v4\n", "v5\n",
+		}, readFiles(t, s.es))
+
+		// The files of each topic in the closed window are merged, and the files
+		// after the last resolved timestamp are left alone.
+		compacted, err := compactCloudStorageFiles(ctx, s.es, s.partitionTemplate, 1<<20, hlc.Timestamp{})
+		require.NoError(t, err)
+		require.Equal(t, ts(3), compacted)
+		require.Equal(t, []string{
+			"v1\nv2\nv3\n", "w1\n", `{"resolved":"3.0000000000"}`, "v4\n", "v5\n",
+		}, readFiles(t, s.es))
+
+		// The windows which were compacted are skipped.
+		resolve(5)
+		compacted, err = compactCloudStorageFiles(ctx, s.es, s.partitionTemplate, 1<<20, compacted)
+		require.NoError(t, err)
+		require.Equal(t, ts(5), compacted)
+		require.Equal(t, []string{
+			"v1\nv2\nv3\n", "w1\n", `{"resolved":"3.0000000000"}`,
+			"v4\nv5\n", `{"resolved":"5.0000000000"}`,
+		}, readFiles(t, s.es))
+	})
+
+	t.Run(`target-size`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		s, resolve := makeSink(t, `target-size`)
+		emit(t, s, t1, `v1`, `v2`, `v3`, `v4`, `v5`)
+		resolve(5)
+
+		// Files are merged up to the target size; every file holds 3 bytes.
+		_, err := compactCloudStorageFiles(ctx, s.es, s.partitionTemplate, 6, hlc.Timestamp{})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"v1\nv2\n", "v3\nv4\n", "v5\n", `{"resolved":"5.0000000000"}`,
+		}, readFiles(t, s.es))
+	})
+
+	t.Run(`parquet`, func(t *testing.T) {
+		s, resolve := makeSink(t, `parquet`)
+		// Parquet files end with their footer, so concatenating them would
+		// produce an invalid file.
+		dir := s.partitionTemplate.render(`t1`, ts(1), nil /* values */)
+		for i, content := range []string{`PAR1 v1 PAR1`, `PAR1 v2 PAR1`} {
+			name := fmt.Sprintf(`%s%s-session-1-2-%08d-t1-1.parquet`, dir, cloudStorageFormatTime(ts(1)), i)
+			require.NoError(t, cloud.WriteFile(ctx, s.es, name, strings.NewReader(content)))
+		}
+		resolve(3)
+
+		compacted, err := compactCloudStorageFiles(ctx, s.es, s.partitionTemplate, 1<<20, hlc.Timestamp{})
+		require.NoError(t, err)
+		require.Equal(t, ts(3), compacted)
+		require.Equal(t, []string{
+			`PAR1 v1 PAR1`, `PAR1 v2 PAR1`, `{"resolved":"3.0000000000"}`,
+		}, readFiles(t, s.es))
+	})
+
+	t.Run(`listing`, func(t *testing.T) {
+		s, _ := makeSink(t, `listing`)
+		template, err := parsePartitionTemplate(`hourly`, ``)
+		require.NoError(t, err)
+		hour := func(day, hour int64) hlc.Timestamp {
+			return hlc.Timestamp{WallTime: (day*24 + hour) * int64(time.Hour)}
+		}
+		for _, day := range []int64{1, 2} {
+			for _, h := range []int64{0, 1} {
+				name := template.render(`t1`, hour(day, h), nil /* values */) +
+					cloudStorageFormatTime(hour(day, h)) + `.RESOLVED`
+				require.NoError(t, cloud.WriteFile(ctx, s.es, name, strings.NewReader(`{}`)))
+			}
+		}
+		listedDirs := func(through hlc.Timestamp) []string {
+			files, err := listCompactionFiles(ctx, s.es, template, through)
+			require.NoError(t, err)
+			var dirs []string
+			for dir := range files {
+				dirs = append(dirs, dir)
+			}
+			sort.Strings(dirs)
+			return dirs
+		}
+
+		// The directories of the days and hours before through are not
+		// listed.
+		require.Equal(t, []string{
+			`1970-01-02/01/`, `1970-01-03/00/`, `1970-01-03/01/`,
+		}, listedDirs(hour(1, 1)))
+		require.Equal(t, []string{`1970-01-03/01/`}, listedDirs(hour(2, 1)))
+		require.Equal(t, []string{
+			`1970-01-02/00/`, `1970-01-02/01/`, `1970-01-03/00/`, `1970-01-03/01/`,
+		}, listedDirs(hlc.Timestamp{}))
+	})
+
+	t.Run(`storage-uri`, func(t *testing.T) {
+		uri, template, err := compactionStorage(
+			`experimental-nodelocal://0/foo?file_size=1KB&file_max_rows=2&partition_format=hourly&AUTH=implicit`)
+		require.NoError(t, err)
+		require.Equal(t, `nodelocal://0/foo?AUTH=implicit`, uri)
+		require.Equal(t, `1970-01-01/00/`, template.render(`t1`, hlc.Timestamp{}, nil /* values */))
+	})
+}
@@ -0,0 +1,402 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+)
+
+// deltaLogDir is the directory of the transaction log of a Delta table,
+// relative to the directory of the table.
+const deltaLogDir = "_delta_log/"
+
+// deltaTypeNames are the names of the Delta Lake types of the parquet columns.
+var deltaTypeNames = map[parquetKind]string{
+	parquetString:    "string",
+	parquetInt64:     "long",
+	parquetDouble:    "double",
+	parquetBool:      "boolean",
+	parquetBinary:    "binary",
+	parquetTimestamp: "timestamp",
+	parquetDate:      "date",
+}
+
+// The actions of the commits of Delta Lake transaction logs. Each line of a
+// commit file is an action, which has exactly one of its fields set. See
+// https://github.com/delta-io/delta/blob/master/PROTOCOL.md.
+type deltaAction struct {
+	CommitInfo *deltaCommitInfo `json:"commitInfo,omitempty"`
+	Protocol   *deltaProtocol   `json:"protocol,omitempty"`
+	MetaData   *deltaMetaData   `json:"metaData,omitempty"`
+	Add        *deltaAdd        `json:"add,omitempty"`
+}
+
+type deltaCommitInfo struct {
+	Timestamp           int64             `json:"timestamp"`
+	Operation           string            `json:"operation"`
+	OperationParameters map[string]string `json:"operationParameters"`
+}
+
+type deltaProtocol struct {
+	MinReaderVersion int `json:"minReaderVersion"`
+	MinWriterVersion int `json:"minWriterVersion"`
+}
+
+type deltaMetaData struct {
+	ID               string            `json:"id"`
+	Format           deltaFormat       `json:"format"`
+	SchemaString     string            `json:"schemaString"`
+	PartitionColumns []string          `json:"partitionColumns"`
+	Configuration    map[string]string `json:"configuration"`
+	CreatedTime      int64             `json:"createdTime"`
+}
+
+type deltaFormat struct {
+	Provider string            `json:"provider"`
+	Options  map[string]string `json:"options"`
+}
+
+type deltaAdd struct {
+	Path             string            `json:"path"`
+	PartitionValues  map[string]string `json:"partitionValues"`
+	Size             int64             `json:"size"`
+	ModificationTime int64             `json:"modificationTime"`
+	DataChange       bool              `json:"dataChange"`
+}
+
+// deltaSchema is the schema of a Delta table, which is serialized in the
+// schemaString of its metadata.
+type deltaSchema struct {
+	Type   string       `json:"type"`
+	Fields []deltaField `json:"fields"`
+}
+
+type deltaField struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Nullable bool              `json:"nullable"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// deltaTable is the state of the transaction log of a Delta table, as of its
+// last commit.
+type deltaTable struct {
+	// dir is the directory of the table, ending with a '/'.
+	dir string
+	// version is the version of the last commit of the table, or -1 if the
+	// table has no commits.
+	version int64
+	// metaData is the metadata of the table, or nil if the table has no
+	// commits.
+	metaData *deltaMetaData
+	fields   []deltaField
+	// committed are the paths, relative to the table directory, of the data
+	// files added to the table.
+	committed map[string]struct{}
+	// schemaFields caches the fields of the data files by their schema ID.
+	schemaFields map[string][]deltaField
+}
+
+// deltaLogCommitter commits the data files written by the cloud storage sinks
+// of a changefeed to the transaction logs of the Delta tables of its topics,
+// which are in the directories of the topics. See tableCommitter for the data
+// files added by each commit.
+//
+// The transaction logs are only written by the sink of the change frontier,
+// which is the only one to emit resolved timestamps, so the commits do not
+// need to be coordinated with other writers. The tables should thus not be
+// written to by anything else than the changefeed.
+type deltaLogCommitter struct {
+	es     cloud.ExternalStorage
+	tables map[string]*deltaTable
+}
+
+var _ tableCommitter = (*deltaLogCommitter)(nil)
+
+func makeDeltaLogCommitter(es cloud.ExternalStorage) *deltaLogCommitter {
+	return &deltaLogCommitter{es: es, tables: make(map[string]*deltaTable)}
+}
+
+// commit implements the tableCommitter interface.
+func (c *deltaLogCommitter) commit(ctx context.Context, resolved hlc.Timestamp) error {
+	files, err := listResolvedDataFiles(ctx, c.es, resolved)
+	if err != nil {
+		return err
+	}
+
+	dirs := make([]string, 0, len(files))
+	for dir := range files {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		t, err := c.table(ctx, dir)
+		if err != nil {
+			return err
+		}
+		if err := c.commitFiles(ctx, t, files[dir], resolved); err != nil {
+			return errors.Wrapf(err, "committing to Delta table %s", dir)
+		}
+	}
+	return nil
+}
+
+// table returns the state of the transaction log of the table in a
+// directory, which is loaded from the log on first use.
+func (c *deltaLogCommitter) table(ctx context.Context, dir string) (*deltaTable, error) {
+	if t, ok := c.tables[dir]; ok {
+		return t, nil
+	}
+	t := &deltaTable{
+		dir:          dir,
+		version:      -1,
+		committed:    make(map[string]struct{}),
+		schemaFields: make(map[string][]deltaField),
+	}
+	for {
+		actions, err := c.readCommit(ctx, t.dir, t.version+1)
+		if errors.Is(err, cloud.ErrFileDoesNotExist) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		t.version++
+		for _, a := range actions {
+			switch {
+			case a.MetaData != nil:
+				var schema deltaSchema
+				if err := json.Unmarshal([]byte(a.MetaData.SchemaString), &schema); err != nil {
+					return nil, errors.Wrapf(err, "decoding schema of Delta table %s", dir)
+				}
+				t.metaData, t.fields = a.MetaData, schema.Fields
+			case a.Add != nil:
+				path, err := url.PathUnescape(a.Add.Path)
+				if err != nil {
+					return nil, err
+				}
+				t.committed[path] = struct{}{}
+			}
+		}
+	}
+	c.tables[dir] = t
+	return t, nil
+}
+
+// deltaCommitName returns the name of the file of a commit of a transaction
+// log.
+func deltaCommitName(dir string, version int64) string {
+	return fmt.Sprintf("%s%s%020d.json", dir, deltaLogDir, version)
+}
+
+func (c *deltaLogCommitter) readCommit(
+	ctx context.Context, dir string, version int64,
+) ([]deltaAction, error) {
+	r, err := c.es.ReadFile(ctx, deltaCommitName(dir, version))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close(ctx)
+	data, err := ioctx.ReadAll(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	var actions []deltaAction
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var a deltaAction
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", deltaCommitName(dir, version))
+		}
+		actions = append(actions, a)
+	}
+	return actions, scanner.Err()
+}
+
+// commitFiles writes the next commit of a table, which adds the data files
+// which were not committed yet, and updates the schema of the table if the
+// files have new columns.
+func (c *deltaLogCommitter) commitFiles(
+	ctx context.Context, t *deltaTable, files []string, resolved hlc.Timestamp,
+) error {
+	sort.Strings(files)
+	fields := t.fields
+	var adds []deltaAction
+	for _, rel := range files {
+		if _, ok := t.committed[rel]; ok {
+			continue
+		}
+		fileFields, err := c.fileFields(ctx, t, rel)
+		if err != nil {
+			return err
+		}
+		if fields, err = mergeDeltaFields(fields, fileFields); err != nil {
+			return errors.Wrapf(err, "adding %s", rel)
+		}
+		size, err := c.es.Size(ctx, t.dir+rel)
+		if err != nil {
+			return err
+		}
+		adds = append(adds, deltaAction{Add: &deltaAdd{
+			Path:             escapeDeltaPath(rel),
+			PartitionValues:  map[string]string{},
+			Size:             size,
+			ModificationTime: timeutil.Now().UnixMilli(),
+			DataChange:       true,
+		}})
+	}
+	if len(adds) == 0 {
+		return nil
+	}
+
+	now := timeutil.Now()
+	actions := []deltaAction{{CommitInfo: &deltaCommitInfo{
+		Timestamp: now.UnixMilli(),
+		Operation: "WRITE",
+		OperationParameters: map[string]string{
+			"mode":     "Append",
+			"resolved": resolved.AsOfSystemTime(),
+		},
+	}}}
+	if t.version < 0 {
+		actions = append(actions, deltaAction{Protocol: &deltaProtocol{
+			MinReaderVersion: 1,
+			MinWriterVersion: 2,
+		}})
+	}
+	metaData := t.metaData
+	if metaData == nil || len(fields) != len(t.fields) {
+		schemaString, err := json.Marshal(deltaSchema{Type: "struct", Fields: fields})
+		if err != nil {
+			return err
+		}
+		metaData = &deltaMetaData{
+			Format:           deltaFormat{Provider: "parquet", Options: map[string]string{}},
+			SchemaString:     string(schemaString),
+			PartitionColumns: []string{},
+			Configuration:    map[string]string{},
+		}
+		if t.metaData != nil {
+			metaData.ID, metaData.CreatedTime = t.metaData.ID, t.metaData.CreatedTime
+		} else {
+			metaData.ID, metaData.CreatedTime = uuid.MakeV4().String(), now.UnixMilli()
+		}
+		actions = append(actions, deltaAction{MetaData: metaData})
+	}
+	actions = append(actions, adds...)
+
+	var buf bytes.Buffer
+	for _, a := range actions {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	name := deltaCommitName(t.dir, t.version+1)
+	if log.V(1) {
+		log.Infof(ctx, "writing Delta commit %s adding %d files at %s",
+			name, len(adds), resolved.AsOfSystemTime())
+	}
+	if err := cloud.WriteFile(ctx, c.es, name, bytes.NewReader(buf.Bytes())); err != nil {
+		return err
+	}
+
+	t.version++
+	t.metaData, t.fields = metaData, fields
+	for _, rel := range files {
+		t.committed[rel] = struct{}{}
+	}
+	return nil
+}
+
+// fileFields returns the fields of the columns of a data file of a table,
+// which are read from the footer of one of the files of its schema ID, that
+// is, the last '-' separated component of its name.
+func (c *deltaLogCommitter) fileFields(
+	ctx context.Context, t *deltaTable, rel string,
+) ([]deltaField, error) {
+	schemaID := strings.TrimSuffix(rel[strings.LastIndexByte(rel, '-')+1:], ".parquet")
+	if fields, ok := t.schemaFields[schemaID]; ok {
+		return fields, nil
+	}
+
+	file, err := readParquetDataFile(ctx, c.es, t.dir+rel)
+	if err != nil {
+		return nil, err
+	}
+	var fields []deltaField
+	for _, col := range file.columns {
+		fields = append(fields, deltaField{
+			Name:     col.name,
+			Type:     deltaTypeNames[col.kind],
+			Nullable: true,
+			Metadata: map[string]string{},
+		})
+	}
+	t.schemaFields[schemaID] = fields
+	return fields, nil
+}
+
+// mergeDeltaFields returns the fields of a table with the columns of a data
+// file, which are appended to the fields of the table if they are new. The
+// columns which were dropped from the tables remain in the Delta tables, and
+// are NULL in the later data files.
+func mergeDeltaFields(fields, fileFields []deltaField) ([]deltaField, error) {
+	// The fields are copied, since the fields of the table must not change if
+	// the commit fails.
+	merged := append([]deltaField(nil), fields...)
+	for _, f := range fileFields {
+		found := false
+		for _, existing := range merged {
+			if !strings.EqualFold(existing.Name, f.Name) {
+				continue
+			}
+			if existing.Type != f.Type {
+				return nil, errors.Errorf("column %s changed type from %s to %s",
+					f.Name, existing.Type, f.Type)
+			}
+			found = true
+			break
+		}
+		if !found {
+			merged = append(merged, f)
+		}
+	}
+	return merged, nil
+}
+
+// escapeDeltaPath escapes the components of the path of a data file, since
+// the paths of the transaction logs are URIs.
+func escapeDeltaPath(rel string) string {
+	components := strings.Split(rel, "/")
+	for i := range components {
+		components[i] = url.PathEscape(components[i])
+	}
+	return strings.Join(components, "/")
+}
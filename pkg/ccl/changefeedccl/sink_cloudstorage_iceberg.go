@@ -0,0 +1,757 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+	"github.com/linkedin/goavro/v2"
+)
+
+// icebergTypeNames are the names of the Iceberg types of the parquet columns.
+var icebergTypeNames = map[parquetKind]string{
+	parquetString:    "string",
+	parquetInt64:     "long",
+	parquetDouble:    "double",
+	parquetBool:      "boolean",
+	parquetBinary:    "binary",
+	parquetTimestamp: "timestamptz",
+	parquetDate:      "date",
+}
+
+const (
+	// icebergResolvedProperty is the property of the summaries of the snapshots
+	// committed by changefeeds which holds their resolved timestamp.
+	icebergResolvedProperty = "cockroachdb.resolved"
+	// icebergNameMappingProperty is the property of the tables which maps the
+	// columns of the data files, which do not have field IDs, to the fields of
+	// the tables.
+	icebergNameMappingProperty = "schema.name-mapping.default"
+	icebergMainBranch          = "main"
+)
+
+// The Avro schemas of the manifests and manifest lists of the snapshots of
+// Iceberg v2 tables, with the field IDs of the Iceberg specification. See
+// https://iceberg.apache.org/spec/#manifests.
+const (
+	icebergManifestSchema = `{
+  "type": "record", "name": "manifest_entry", "fields": [
+    {"name": "status", "type": "int", "field-id": 0},
+    {"name": "snapshot_id", "type": ["null", "long"], "default": null, "field-id": 1},
+    {"name": "sequence_number", "type": ["null", "long"], "default": null, "field-id": 3},
+    {"name": "file_sequence_number", "type": ["null", "long"], "default": null, "field-id": 4},
+    {"name": "data_file", "field-id": 2, "type": {
+      "type": "record", "name": "r2", "fields": [
+        {"name": "content", "type": "int", "field-id": 134},
+        {"name": "file_path", "type": "string", "field-id": 100},
+        {"name": "file_format", "type": "string", "field-id": 101},
+        {"name": "partition", "field-id": 102, "type": {"type": "record", "name": "r102", "fields": []}},
+        {"name": "record_count", "type": "long", "field-id": 103},
+        {"name": "file_size_in_bytes", "type": "long", "field-id": 104}
+      ]
+    }}
+  ]
+}`
+	icebergManifestListSchema = `{
+  "type": "record", "name": "manifest_file", "fields": [
+    {"name": "manifest_path", "type": "string", "field-id": 500},
+    {"name": "manifest_length", "type": "long", "field-id": 501},
+    {"name": "partition_spec_id", "type": "int", "field-id": 502},
+    {"name": "content", "type": "int", "field-id": 517},
+    {"name": "sequence_number", "type": "long", "field-id": 515},
+    {"name": "min_sequence_number", "type": "long", "field-id": 516},
+    {"name": "added_snapshot_id", "type": "long", "field-id": 503},
+    {"name": "added_files_count", "type": "int", "field-id": 504},
+    {"name": "existing_files_count", "type": "int", "field-id": 505},
+    {"name": "deleted_files_count", "type": "int", "field-id": 506},
+    {"name": "added_rows_count", "type": "long", "field-id": 512},
+    {"name": "existing_rows_count", "type": "long", "field-id": 513},
+    {"name": "deleted_rows_count", "type": "long", "field-id": 514}
+  ]
+}`
+)
+
+// icebergManifestListFields are the fields of the manifest lists which are
+// copied from the manifest list of the parent snapshot.
+var icebergManifestListFields = []string{
+	"manifest_path", "manifest_length", "partition_spec_id", "content",
+	"sequence_number", "min_sequence_number", "added_snapshot_id",
+	"added_files_count", "existing_files_count", "deleted_files_count",
+	"added_rows_count", "existing_rows_count", "deleted_rows_count",
+}
+
+type icebergField struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Type     string `json:"type"`
+}
+
+type icebergSchema struct {
+	SchemaID int            `json:"schema-id"`
+	Type     string         `json:"type"`
+	Fields   []icebergField `json:"fields"`
+}
+
+type icebergSnapshot struct {
+	SnapshotID       int64             `json:"snapshot-id"`
+	ParentSnapshotID *int64            `json:"parent-snapshot-id,omitempty"`
+	SequenceNumber   int64             `json:"sequence-number"`
+	TimestampMs      int64             `json:"timestamp-ms"`
+	ManifestList     string            `json:"manifest-list"`
+	Summary          map[string]string `json:"summary"`
+}
+
+// icebergTableMetadata is the part of the metadata of Iceberg tables used by
+// the changefeeds.
+type icebergTableMetadata struct {
+	TableUUID          string            `json:"table-uuid"`
+	Location           string            `json:"location"`
+	LastSequenceNumber int64             `json:"last-sequence-number"`
+	LastColumnID       int               `json:"last-column-id"`
+	CurrentSchemaID    int               `json:"current-schema-id"`
+	Schemas            []icebergSchema   `json:"schemas"`
+	CurrentSnapshotID  *int64            `json:"current-snapshot-id"`
+	Snapshots          []icebergSnapshot `json:"snapshots"`
+	Properties         map[string]string `json:"properties"`
+}
+
+// currentSnapshotID returns the ID of the current snapshot of the table, or
+// nil if it has none.
+func (m *icebergTableMetadata) currentSnapshotID() *int64 {
+	// Some catalogs use -1 rather than null for the tables without snapshots.
+	if m.CurrentSnapshotID == nil || *m.CurrentSnapshotID == -1 {
+		return nil
+	}
+	return m.CurrentSnapshotID
+}
+
+// currentSnapshot returns the current snapshot of the table, or nil if it has
+// none.
+func (m *icebergTableMetadata) currentSnapshot() *icebergSnapshot {
+	id := m.currentSnapshotID()
+	if id == nil {
+		return nil
+	}
+	for i := range m.Snapshots {
+		if m.Snapshots[i].SnapshotID == *id {
+			return &m.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// currentSchema returns the current schema of the table.
+func (m *icebergTableMetadata) currentSchema() (icebergSchema, error) {
+	for _, sc := range m.Schemas {
+		if sc.SchemaID == m.CurrentSchemaID {
+			return sc, nil
+		}
+	}
+	return icebergSchema{}, errors.Errorf("table has no schema %d", m.CurrentSchemaID)
+}
+
+// committedResolved returns the last resolved timestamp committed to the
+// table by a changefeed.
+func (m *icebergTableMetadata) committedResolved() (hlc.Timestamp, error) {
+	var resolved hlc.Timestamp
+	for _, snapshot := range m.Snapshots {
+		v, ok := snapshot.Summary[icebergResolvedProperty]
+		if !ok {
+			continue
+		}
+		ts, err := hlc.ParseHLC(v)
+		if err != nil {
+			return hlc.Timestamp{}, errors.Wrapf(err, "parsing %s of snapshot %d",
+				icebergResolvedProperty, snapshot.SnapshotID)
+		}
+		resolved.Forward(ts)
+	}
+	return resolved, nil
+}
+
+// icebergLoadTableResult is the response of the catalog to the requests
+// loading, creating or committing to tables.
+type icebergLoadTableResult struct {
+	MetadataLocation string               `json:"metadata-location"`
+	Metadata         icebergTableMetadata `json:"metadata"`
+}
+
+// icebergCatalogError is an error response of an Iceberg REST catalog.
+type icebergCatalogError struct {
+	statusCode int
+	message    string
+}
+
+func (e *icebergCatalogError) Error() string {
+	return fmt.Sprintf("iceberg catalog error %d: %s", e.statusCode, e.message)
+}
+
+// icebergCatalog is a client of an Iceberg REST catalog. See
+// https://github.com/apache/iceberg/blob/master/open-api/rest-catalog-open-api.yaml.
+type icebergCatalog struct {
+	client *httputil.Client
+	// uri is the base URI of the catalog, without a trailing '/'.
+	uri       string
+	token     string
+	namespace []string
+}
+
+func (c *icebergCatalog) do(ctx context.Context, method, target string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &icebergCatalogError{statusCode: resp.StatusCode, message: strings.TrimSpace(string(respBody))}
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(respBody, out), "decoding iceberg catalog response")
+}
+
+func (c *icebergCatalog) tablesPath() string {
+	// The levels of the namespaces are separated by the unit separator.
+	return fmt.Sprintf("%s/v1/namespaces/%s/tables", c.uri,
+		url.PathEscape(strings.Join(c.namespace, "\x1f")))
+}
+
+// loadTable returns the metadata of a table, or nil if it does not exist.
+func (c *icebergCatalog) loadTable(ctx context.Context, name string) (*icebergTableMetadata, error) {
+	var res icebergLoadTableResult
+	err := c.do(ctx, http.MethodGet, c.tablesPath()+"/"+url.PathEscape(name), nil, &res)
+	var catalogErr *icebergCatalogError
+	if errors.As(err, &catalogErr) && catalogErr.statusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &res.Metadata, nil
+}
+
+// createTable creates a v2 table, and returns its metadata.
+func (c *icebergCatalog) createTable(
+	ctx context.Context, name, location string, schema icebergSchema,
+) (*icebergTableMetadata, error) {
+	var res icebergLoadTableResult
+	if err := c.do(ctx, http.MethodPost, c.tablesPath(), map[string]interface{}{
+		"name":     name,
+		"location": location,
+		"schema":   schema,
+		"properties": map[string]string{
+			"format-version":       "2",
+			"write.format.default": "parquet",
+		},
+	}, &res); err != nil {
+		return nil, err
+	}
+	return &res.Metadata, nil
+}
+
+// commitTable commits updates to a table if it satisfies the requirements,
+// and returns its new metadata.
+func (c *icebergCatalog) commitTable(
+	ctx context.Context, name string, requirements, updates []map[string]interface{},
+) (*icebergTableMetadata, error) {
+	var res icebergLoadTableResult
+	if err := c.do(ctx, http.MethodPost, c.tablesPath()+"/"+url.PathEscape(name), map[string]interface{}{
+		"identifier":   map[string]interface{}{"namespace": c.namespace, "name": name},
+		"requirements": requirements,
+		"updates":      updates,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return &res.Metadata, nil
+}
+
+// icebergTable is the state of an Iceberg table, as of the last commit of
+// the changefeed.
+type icebergTable struct {
+	// dir is the directory of the data files of the table, ending with a '/'.
+	dir  string
+	name string
+	// metadata is the metadata of the table, or nil if it does not exist yet.
+	metadata *icebergTableMetadata
+	// resolved is the last resolved timestamp committed to the table: the data
+	// files up to it have been added to the table.
+	resolved hlc.Timestamp
+}
+
+// icebergCommitter commits the data files written by the cloud storage sinks
+// of a changefeed to the Iceberg tables of its topics, which are named after
+// the topics, and located in the directories of the topics. The tables are
+// created by the first commit if they do not exist. See tableCommitter for the
+// data files added by each commit.
+//
+// Each commit appends a snapshot to each table which has new data files, whose
+// manifest list and manifest are written to the metadata directory of the
+// table. The resolved timestamp of the commit is stored in the summary of the
+// snapshot, so that the data files up to it are not committed again after a
+// restart. The snapshots are committed to the catalog only if the current
+// snapshot of the table did not change since the last commit: other writers
+// may thus commit to the tables (e.g. to compact them), which only fails the
+// concurrent commits of the changefeed, which are retried with the next
+// resolved timestamp.
+type icebergCommitter struct {
+	es      cloud.ExternalStorage
+	catalog *icebergCatalog
+	// location is the URI of the root of the external storage, without a
+	// trailing '/'.
+	location string
+	tables   map[string]*icebergTable
+}
+
+var _ tableCommitter = (*icebergCommitter)(nil)
+
+// makeIcebergCommitter returns a committer of the data files of the sink,
+// which consumes the parameters of the Iceberg catalog from the sink URI.
+func makeIcebergCommitter(u *sinkURL) (*icebergCommitter, error) {
+	catalogURI := strings.TrimSuffix(u.consumeParam(changefeedbase.SinkParamIcebergCatalog), "/")
+	if catalogURI == "" {
+		return nil, errors.Errorf(`%s=%s requires the %s parameter`, changefeedbase.SinkParamTableFormat,
+			cloudStorageTableFormatIceberg, changefeedbase.SinkParamIcebergCatalog)
+	}
+	namespace := u.consumeParam(changefeedbase.SinkParamIcebergNamespace)
+	if namespace == "" {
+		return nil, errors.Errorf(`%s=%s requires the %s parameter`, changefeedbase.SinkParamTableFormat,
+			cloudStorageTableFormatIceberg, changefeedbase.SinkParamIcebergNamespace)
+	}
+	return &icebergCommitter{
+		catalog: &icebergCatalog{
+			client:    httputil.NewClientWithTimeout(httputil.StandardHTTPTimeout),
+			uri:       catalogURI,
+			token:     u.consumeParam(changefeedbase.SinkParamIcebergCatalogToken),
+			namespace: strings.Split(namespace, "."),
+		},
+		tables: make(map[string]*icebergTable),
+	}, nil
+}
+
+// init sets the external storage to which the sink writes, whose URI is the
+// location of the tables.
+func (c *icebergCommitter) init(es cloud.ExternalStorage, u *url.URL) {
+	location := *u
+	location.RawQuery = ""
+	c.es = es
+	c.location = strings.TrimSuffix(location.String(), "/")
+}
+
+// commit implements the tableCommitter interface.
+func (c *icebergCommitter) commit(ctx context.Context, resolved hlc.Timestamp) error {
+	files, err := listResolvedDataFiles(ctx, c.es, resolved)
+	if err != nil {
+		return err
+	}
+
+	dirs := make([]string, 0, len(files))
+	for dir := range files {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		t, err := c.table(ctx, dir)
+		if err != nil {
+			return err
+		}
+		if err := c.commitFiles(ctx, t, files[dir], resolved); err != nil {
+			// The table may have been changed by another writer, so it is loaded
+			// again by the next commit.
+			delete(c.tables, dir)
+			return errors.Wrapf(err, "committing to Iceberg table %s", t.name)
+		}
+	}
+	return nil
+}
+
+// table returns the state of the table of the data files in a directory,
+// which is loaded from the catalog on first use.
+func (c *icebergCommitter) table(ctx context.Context, dir string) (*icebergTable, error) {
+	if t, ok := c.tables[dir]; ok {
+		return t, nil
+	}
+	t := &icebergTable{dir: dir, name: strings.TrimSuffix(dir, "/")}
+	metadata, err := c.catalog.loadTable(ctx, t.name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading Iceberg table %s", t.name)
+	}
+	if metadata != nil {
+		if t.resolved, err = metadata.committedResolved(); err != nil {
+			return nil, err
+		}
+	}
+	t.metadata = metadata
+	c.tables[dir] = t
+	return t, nil
+}
+
+// commitFiles commits a snapshot to a table, which adds the data files which
+// were not committed yet, and updates the schema of the table if the files
+// have new columns.
+func (c *icebergCommitter) commitFiles(
+	ctx context.Context, t *icebergTable, files []string, resolved hlc.Timestamp,
+) error {
+	sort.Strings(files)
+	var paths []string
+	var dataFiles []*parquetDataFile
+	for _, rel := range files {
+		if !dataFileAfter(rel, t.resolved) {
+			continue
+		}
+		file, err := readParquetDataFile(ctx, c.es, t.dir+rel)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, c.location+"/"+t.dir+rel)
+		dataFiles = append(dataFiles, file)
+	}
+	if len(dataFiles) == 0 {
+		return nil
+	}
+
+	if t.metadata == nil {
+		schema, _, err := mergeIcebergFields(icebergSchema{Type: "struct"}, 0, dataFiles)
+		if err != nil {
+			return err
+		}
+		if t.metadata, err = c.catalog.createTable(
+			ctx, t.name, c.location+"/"+t.name, schema,
+		); err != nil {
+			return errors.Wrapf(err, "creating Iceberg table %s", t.name)
+		}
+	}
+
+	m := t.metadata
+	requirements := []map[string]interface{}{
+		{"type": "assert-table-uuid", "uuid": m.TableUUID},
+		{"type": "assert-ref-snapshot-id", "ref": icebergMainBranch, "snapshot-id": m.currentSnapshotID()},
+	}
+	var updates []map[string]interface{}
+
+	current, err := m.currentSchema()
+	if err != nil {
+		return err
+	}
+	schema, lastColumnID, err := mergeIcebergFields(current, m.LastColumnID, dataFiles)
+	if err != nil {
+		return err
+	}
+	if lastColumnID != m.LastColumnID {
+		for _, sc := range m.Schemas {
+			if sc.SchemaID >= schema.SchemaID {
+				schema.SchemaID = sc.SchemaID + 1
+			}
+		}
+		requirements = append(requirements,
+			map[string]interface{}{"type": "assert-current-schema-id", "current-schema-id": m.CurrentSchemaID},
+			map[string]interface{}{"type": "assert-last-assigned-field-id", "last-assigned-field-id": m.LastColumnID},
+		)
+		updates = append(updates,
+			map[string]interface{}{"action": "add-schema", "schema": schema, "last-column-id": lastColumnID},
+			// -1 is the schema added by the update above.
+			map[string]interface{}{"action": "set-current-schema", "schema-id": -1},
+		)
+	}
+	nameMapping, err := icebergNameMapping(schema)
+	if err != nil {
+		return err
+	}
+	if m.Properties[icebergNameMappingProperty] != nameMapping {
+		updates = append(updates, map[string]interface{}{
+			"action": "set-properties", "updates": map[string]string{icebergNameMappingProperty: nameMapping},
+		})
+	}
+
+	snapshot, err := c.writeSnapshot(ctx, t, schema, paths, dataFiles)
+	if err != nil {
+		return err
+	}
+	snapshot.Summary[icebergResolvedProperty] = resolved.AsOfSystemTime()
+	updates = append(updates,
+		map[string]interface{}{"action": "add-snapshot", "snapshot": snapshot},
+		map[string]interface{}{
+			"action": "set-snapshot-ref", "ref-name": icebergMainBranch, "type": "branch",
+			"snapshot-id": snapshot.SnapshotID,
+		},
+	)
+
+	if log.V(1) {
+		log.Infof(ctx, "committing Iceberg snapshot %d to table %s adding %d files at %s",
+			snapshot.SnapshotID, t.name, len(dataFiles), resolved.AsOfSystemTime())
+	}
+	metadata, err := c.catalog.commitTable(ctx, t.name, requirements, updates)
+	if err != nil {
+		return err
+	}
+	t.metadata, t.resolved = metadata, resolved
+	return nil
+}
+
+// writeSnapshot writes the manifest of the data files of a new snapshot of a
+// table, and its manifest list, which also lists the manifests of the current
+// snapshot of the table.
+func (c *icebergCommitter) writeSnapshot(
+	ctx context.Context, t *icebergTable, schema icebergSchema, paths []string, files []*parquetDataFile,
+) (*icebergSnapshot, error) {
+	m := t.metadata
+	snapshot := &icebergSnapshot{
+		SnapshotID:       newIcebergSnapshotID(),
+		ParentSnapshotID: m.currentSnapshotID(),
+		SequenceNumber:   m.LastSequenceNumber + 1,
+		TimestampMs:      timeutil.Now().UnixMilli(),
+		Summary:          map[string]string{"operation": "append"},
+	}
+
+	var numRows, size int64
+	entries := make([]map[string]interface{}, len(files))
+	for i, file := range files {
+		numRows += file.numRows
+		size += file.size
+		entries[i] = map[string]interface{}{
+			"status":      int32(1), // ADDED
+			"snapshot_id": goavro.Union("long", snapshot.SnapshotID),
+			// The sequence numbers of the added files are inherited from the
+			// manifest list.
+			"sequence_number":      nil,
+			"file_sequence_number": nil,
+			"data_file": map[string]interface{}{
+				"content":            int32(0), // DATA
+				"file_path":          paths[i],
+				"file_format":        "PARQUET",
+				"partition":          map[string]interface{}{},
+				"record_count":       file.numRows,
+				"file_size_in_bytes": file.size,
+			},
+		}
+	}
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	manifestName := fmt.Sprintf("%smetadata/%s-m0.avro", t.dir, uuid.MakeV4())
+	manifestLength, err := c.writeAvro(ctx, manifestName, icebergManifestSchema, map[string][]byte{
+		"schema":            schemaJSON,
+		"schema-id":         []byte(strconv.Itoa(schema.SchemaID)),
+		"partition-spec":    []byte("[]"),
+		"partition-spec-id": []byte("0"),
+		"format-version":    []byte("2"),
+		"content":           []byte("data"),
+	}, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []map[string]interface{}
+	if parent := m.currentSnapshot(); parent != nil {
+		if manifests, err = c.readManifestList(ctx, parent.ManifestList); err != nil {
+			return nil, err
+		}
+	}
+	manifests = append(manifests, map[string]interface{}{
+		"manifest_path":        c.location + "/" + manifestName,
+		"manifest_length":      manifestLength,
+		"partition_spec_id":    int32(0),
+		"content":              int32(0), // DATA
+		"sequence_number":      snapshot.SequenceNumber,
+		"min_sequence_number":  snapshot.SequenceNumber,
+		"added_snapshot_id":    snapshot.SnapshotID,
+		"added_files_count":    int32(len(files)),
+		"existing_files_count": int32(0),
+		"deleted_files_count":  int32(0),
+		"added_rows_count":     numRows,
+		"existing_rows_count":  int64(0),
+		"deleted_rows_count":   int64(0),
+	})
+	parentID := "null"
+	if id := m.currentSnapshotID(); id != nil {
+		parentID = strconv.FormatInt(*id, 10)
+	}
+	listName := fmt.Sprintf("%smetadata/snap-%d-1-%s.avro", t.dir, snapshot.SnapshotID, uuid.MakeV4())
+	if _, err := c.writeAvro(ctx, listName, icebergManifestListSchema, map[string][]byte{
+		"snapshot-id":        []byte(strconv.FormatInt(snapshot.SnapshotID, 10)),
+		"parent-snapshot-id": []byte(parentID),
+		"sequence-number":    []byte(strconv.FormatInt(snapshot.SequenceNumber, 10)),
+		"format-version":     []byte("2"),
+	}, manifests); err != nil {
+		return nil, err
+	}
+
+	snapshot.ManifestList = c.location + "/" + listName
+	snapshot.Summary["added-data-files"] = strconv.Itoa(len(files))
+	snapshot.Summary["added-records"] = strconv.FormatInt(numRows, 10)
+	snapshot.Summary["added-files-size"] = strconv.FormatInt(size, 10)
+	return snapshot, nil
+}
+
+// writeAvro writes records to an Avro object container file, and returns
+// its size.
+func (c *icebergCommitter) writeAvro(
+	ctx context.Context,
+	name, schema string,
+	metadata map[string][]byte,
+	records []map[string]interface{},
+) (int64, error) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: schema, MetaData: metadata})
+	if err != nil {
+		return 0, err
+	}
+	native := make([]interface{}, len(records))
+	for i, r := range records {
+		native[i] = r
+	}
+	if err := w.Append(native); err != nil {
+		return 0, errors.Wrapf(err, "encoding %s", name)
+	}
+	if err := cloud.WriteFile(ctx, c.es, name, bytes.NewReader(buf.Bytes())); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), nil
+}
+
+// readManifestList returns the manifests of a manifest list written to the
+// external storage of the sink.
+func (c *icebergCommitter) readManifestList(
+	ctx context.Context, location string,
+) ([]map[string]interface{}, error) {
+	name := strings.TrimPrefix(location, c.location+"/")
+	if name == location {
+		return nil, errors.Errorf("manifest list %s is not in %s", location, c.location)
+	}
+	r, err := c.es.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close(ctx)
+	data, err := ioctx.ReadAll(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	ocf, err := goavro.NewOCFReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading manifest list %s", location)
+	}
+	var manifests []map[string]interface{}
+	for ocf.Scan() {
+		record, err := ocf.Read()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading manifest list %s", location)
+		}
+		fields, ok := record.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("unexpected record %T in manifest list %s", record, location)
+		}
+		manifest := make(map[string]interface{}, len(icebergManifestListFields))
+		for _, f := range icebergManifestListFields {
+			v, ok := fields[f]
+			if !ok {
+				return nil, errors.Errorf("manifest list %s has no %s field", location, f)
+			}
+			manifest[f] = v
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, ocf.Err()
+}
+
+// mergeIcebergFields returns the schema of a table with the columns of data
+// files, which are appended to the fields of the table, with new field IDs,
+// if they are new, and the last field ID of the table. The columns which were
+// dropped from the tables remain in the Iceberg tables, and are NULL in the
+// later data files.
+func mergeIcebergFields(
+	schema icebergSchema, lastColumnID int, files []*parquetDataFile,
+) (icebergSchema, int, error) {
+	// The fields are copied, since the schema of the table must not change if
+	// the commit fails.
+	schema.Fields = append([]icebergField(nil), schema.Fields...)
+	for _, file := range files {
+		for _, col := range file.columns {
+			typ := icebergTypeNames[col.kind]
+			found := false
+			for _, f := range schema.Fields {
+				if f.Name != col.name {
+					continue
+				}
+				if f.Type != typ {
+					return icebergSchema{}, 0, errors.Errorf("column %s changed type from %s to %s",
+						col.name, f.Type, typ)
+				}
+				found = true
+				break
+			}
+			if !found {
+				lastColumnID++
+				schema.Fields = append(schema.Fields, icebergField{ID: lastColumnID, Name: col.name, Type: typ})
+			}
+		}
+	}
+	return schema, lastColumnID, nil
+}
+
+// icebergNameMapping returns the name mapping of the columns of the data
+// files to the fields of a schema.
+func icebergNameMapping(schema icebergSchema) (string, error) {
+	type mappedField struct {
+		FieldID int      `json:"field-id"`
+		Names   []string `json:"names"`
+	}
+	mapping := make([]mappedField, len(schema.Fields))
+	for i, f := range schema.Fields {
+		mapping[i] = mappedField{FieldID: f.ID, Names: []string{f.Name}}
+	}
+	b, err := json.Marshal(mapping)
+	return string(b), err
+}
+
+// newIcebergSnapshotID returns a random positive snapshot ID.
+func newIcebergSnapshotID() int64 {
+	id := uuid.MakeV4()
+	return int64(binary.BigEndian.Uint64(id.GetBytes()[:8]) &^ (1 << 63))
+}
@@ -0,0 +1,398 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIcebergCatalog is an Iceberg REST catalog with a single namespace,
+// which implements the requests used by the changefeeds.
+type fakeIcebergCatalog struct {
+	namespace string
+	mu        struct {
+		syncutil.Mutex
+		tables map[string]*icebergTableMetadata
+		// conflicts is the number of the next commits which fail as if the
+		// table was concurrently changed.
+		conflicts int
+	}
+}
+
+func newFakeIcebergCatalog(namespace string) *fakeIcebergCatalog {
+	c := &fakeIcebergCatalog{namespace: namespace}
+	c.mu.tables = make(map[string]*icebergTableMetadata)
+	return c
+}
+
+func (c *fakeIcebergCatalog) table(name string) *icebergTableMetadata {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.tables[name]
+}
+
+// remarshal converts a decoded JSON value to another type.
+func remarshal(in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (c *fakeIcebergCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := fmt.Sprintf("/v1/namespaces/%s/tables", c.namespace)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "unknown namespace", http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	reply := func(m *icebergTableMetadata) {
+		_ = json.NewEncoder(w).Encode(icebergLoadTableResult{MetadataLocation: "unused", Metadata: *m})
+	}
+
+	switch {
+	case r.Method == http.MethodGet:
+		if m, ok := c.mu.tables[name]; ok {
+			reply(m)
+		} else {
+			http.Error(w, "no such table", http.StatusNotFound)
+		}
+
+	case r.Method == http.MethodPost && name == "":
+		var req struct {
+			Name       string            `json:"name"`
+			Location   string            `json:"location"`
+			Schema     icebergSchema     `json:"schema"`
+			Properties map[string]string `json:"properties"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := c.mu.tables[req.Name]; ok {
+			http.Error(w, "table exists", http.StatusConflict)
+			return
+		}
+		m := &icebergTableMetadata{
+			TableUUID:  uuid.MakeV4().String(),
+			Location:   req.Location,
+			Schemas:    []icebergSchema{req.Schema},
+			Properties: req.Properties,
+		}
+		for _, f := range req.Schema.Fields {
+			if f.ID > m.LastColumnID {
+				m.LastColumnID = f.ID
+			}
+		}
+		c.mu.tables[req.Name] = m
+		reply(m)
+
+	case r.Method == http.MethodPost:
+		m, ok := c.mu.tables[name]
+		if !ok {
+			http.Error(w, "no such table", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Requirements []map[string]interface{} `json:"requirements"`
+			Updates      []map[string]interface{} `json:"updates"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if c.mu.conflicts > 0 {
+			c.mu.conflicts--
+			http.Error(w, "concurrent commit", http.StatusConflict)
+			return
+		}
+		for _, requirement := range req.Requirements {
+			var ok bool
+			switch requirement["type"] {
+			case "assert-table-uuid":
+				ok = requirement["uuid"] == m.TableUUID
+			case "assert-ref-snapshot-id":
+				id, _ := requirement["snapshot-id"].(float64)
+				ok = (requirement["snapshot-id"] == nil && m.CurrentSnapshotID == nil) ||
+					(m.CurrentSnapshotID != nil && int64(id) == *m.CurrentSnapshotID)
+			case "assert-current-schema-id":
+				ok = int(requirement["current-schema-id"].(float64)) == m.CurrentSchemaID
+			case "assert-last-assigned-field-id":
+				ok = int(requirement["last-assigned-field-id"].(float64)) == m.LastColumnID
+			}
+			if !ok {
+				http.Error(w, fmt.Sprintf("requirement failed: %v", requirement), http.StatusConflict)
+				return
+			}
+		}
+		updated := *m
+		lastSchemaID := -1
+		for _, u := range req.Updates {
+			var err error
+			switch u["action"] {
+			case "add-schema":
+				var sc icebergSchema
+				err = remarshal(u["schema"], &sc)
+				updated.Schemas = append(updated.Schemas[:len(updated.Schemas):len(updated.Schemas)], sc)
+				updated.LastColumnID = int(u["last-column-id"].(float64))
+				lastSchemaID = sc.SchemaID
+			case "set-current-schema":
+				updated.CurrentSchemaID = int(u["schema-id"].(float64))
+				if updated.CurrentSchemaID == -1 {
+					updated.CurrentSchemaID = lastSchemaID
+				}
+			case "set-properties":
+				var props map[string]string
+				err = remarshal(u["updates"], &props)
+				updated.Properties = make(map[string]string)
+				for k, v := range m.Properties {
+					updated.Properties[k] = v
+				}
+				for k, v := range props {
+					updated.Properties[k] = v
+				}
+			case "add-snapshot":
+				var snapshot icebergSnapshot
+				err = remarshal(u["snapshot"], &snapshot)
+				updated.Snapshots = append(updated.Snapshots[:len(updated.Snapshots):len(updated.Snapshots)], snapshot)
+				updated.LastSequenceNumber = snapshot.SequenceNumber
+			case "set-snapshot-ref":
+				id := int64(u["snapshot-id"].(float64))
+				updated.CurrentSnapshotID = &id
+			default:
+				err = fmt.Errorf("unexpected update %v", u)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		c.mu.tables[name] = &updated
+		reply(&updated)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestCloudStorageSinkIceberg(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	ctx := context.Background()
+
+	dir, dirCleanupFn := testutils.TempDir(t)
+	defer dirCleanupFn()
+	settings := cluster.MakeTestingClusterSettings()
+	settings.ExternalIODir = dir
+	clientFactory := blobs.TestBlobServiceClient(settings.ExternalIODir)
+	externalStorageFromURI := func(ctx context.Context, uri string, user username.SQLUsername, opts ...cloud.ExternalStorageOption) (cloud.ExternalStorage,
+		error) {
+		return cloud.ExternalStorageFromURI(ctx, uri, base.ExternalIODirConfig{}, settings,
+			clientFactory, user, nil, nil, nil, opts...)
+	}
+	opts := changefeedbase.EncodingOptions{
+		Format:     changefeedbase.OptFormatJSON,
+		Envelope:   changefeedbase.OptEnvelopeWrapped,
+		KeyInValue: true,
+	}
+	e, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+	require.NoError(t, err)
+	ts := func(i int64) hlc.Timestamp { return hlc.Timestamp{WallTime: i} }
+
+	testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+	sf, err := span.MakeFrontier(testSpan)
+	require.NoError(t, err)
+	timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+	forwardFrontier := func(wall int64) {
+		_, err := sf.Forward(testSpan, ts(wall))
+		require.NoError(t, err)
+	}
+
+	catalog := newFakeIcebergCatalog("db")
+	server := httptest.NewServer(catalog)
+	defer server.Close()
+
+	const sinkDir = `iceberg`
+	makeSink := func(params url.Values) (Sink, error) {
+		u, err := url.Parse(`nodelocal://0/` + sinkDir + `?` + params.Encode())
+		require.NoError(t, err)
+		return makeCloudStorageSink(ctx, sinkURL{URL: u}, 1, settings, opts, timestampOracle,
+			externalStorageFromURI, username.RootUserName(), nil)
+	}
+	params := url.Values{
+		changefeedbase.SinkParamTableFormat:      {cloudStorageTableFormatIceberg},
+		changefeedbase.SinkParamIcebergCatalog:   {server.URL},
+		changefeedbase.SinkParamIcebergNamespace: {"db"},
+	}
+
+	t.Run("options", func(t *testing.T) {
+		for _, tc := range []struct {
+			drop string
+			err  string
+		}{
+			{changefeedbase.SinkParamIcebergCatalog, `table_format=iceberg requires the iceberg_catalog parameter`},
+			{changefeedbase.SinkParamIcebergNamespace, `table_format=iceberg requires the iceberg_namespace parameter`},
+		} {
+			p := url.Values{}
+			for k, v := range params {
+				if k != tc.drop {
+					p[k] = v
+				}
+			}
+			_, err := makeSink(p)
+			require.EqualError(t, err, tc.err)
+		}
+	})
+
+	s, err := makeSink(params)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s.Close()) }()
+
+	tCols := []descpb.ColumnDescriptor{
+		{Name: "a", Type: types.Int},
+		{Name: "b", Type: types.String, Nullable: true},
+	}
+	tV1 := makeTestTopicWithColumns(t, 100, "t", 1, tCols...)
+	tV2 := makeTestTopicWithColumns(t, 100, "t", 2,
+		append(tCols, descpb.ColumnDescriptor{Name: "c", Type: types.TimestampTZ, Nullable: true})...)
+
+	require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[1]`),
+		[]byte(`{"after": {"a": 1, "b": "x"}, "key": [1]}`), ts(1), ts(1), zeroAlloc))
+	require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[2]`),
+		[]byte(`{"after": {"a": 2, "b": null}, "key": [2]}`), ts(2), ts(2), zeroAlloc))
+	forwardFrontier(2)
+	require.NoError(t, s.Flush(ctx))
+	require.NoError(t, s.EmitRow(ctx, tV2, []byte(`[3]`), []byte(
+		`{"after": {"a": 3, "b": "y", "c": "2022-01-01T00:00:00Z"}, "key": [3]}`), ts(3), ts(3), zeroAlloc))
+	forwardFrontier(3)
+	require.NoError(t, s.Flush(ctx))
+
+	// readAvro returns the records of an Avro file written by the sink.
+	readAvro := func(location string) []map[string]interface{} {
+		rel := strings.TrimPrefix(location, `nodelocal://0/`+sinkDir+`/`)
+		require.NotEqual(t, location, rel)
+		f, err := os.Open(filepath.Join(dir, sinkDir, rel))
+		require.NoError(t, err)
+		defer f.Close()
+		ocf, err := goavro.NewOCFReader(f)
+		require.NoError(t, err)
+		var records []map[string]interface{}
+		for ocf.Scan() {
+			record, err := ocf.Read()
+			require.NoError(t, err)
+			records = append(records, record.(map[string]interface{}))
+		}
+		require.NoError(t, ocf.Err())
+		return records
+	}
+	fields := func(m *icebergTableMetadata) []string {
+		sc, err := m.currentSchema()
+		require.NoError(t, err)
+		var res []string
+		for _, f := range sc.Fields {
+			res = append(res, fmt.Sprintf("%d %s %s", f.ID, f.Name, f.Type))
+		}
+		return res
+	}
+	// checkSnapshot checks the manifests of the current snapshot of the table,
+	// and returns the paths of the data files added by its last manifest.
+	checkSnapshot := func(m *icebergTableMetadata, resolved hlc.Timestamp, manifests int) []string {
+		snapshot := m.currentSnapshot()
+		require.NotNil(t, snapshot)
+		require.Equal(t, resolved.AsOfSystemTime(), snapshot.Summary[icebergResolvedProperty])
+		list := readAvro(snapshot.ManifestList)
+		require.Len(t, list, manifests)
+		last := list[len(list)-1]
+		require.Equal(t, snapshot.SnapshotID, last["added_snapshot_id"])
+		require.Equal(t, snapshot.SequenceNumber, last["sequence_number"])
+		var paths []string
+		for _, entry := range readAvro(last["manifest_path"].(string)) {
+			dataFile := entry["data_file"].(map[string]interface{})
+			require.Equal(t, "PARQUET", dataFile["file_format"])
+			paths = append(paths, dataFile["file_path"].(string))
+		}
+		return paths
+	}
+
+	// The table is created by the first commit, which does not add the files
+	// with rows after the resolved timestamp.
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(2)))
+	m := catalog.table("t")
+	require.NotNil(t, m)
+	require.Equal(t, `nodelocal://0/iceberg/t`, m.Location)
+	require.Equal(t, []string{
+		`1 a long`, `2 b string`, `3 _crdb_updated string`, `4 _crdb_deleted boolean`,
+	}, fields(m))
+	require.Equal(t,
+		`[{"field-id":1,"names":["a"]},{"field-id":2,"names":["b"]},`+
+			`{"field-id":3,"names":["_crdb_updated"]},{"field-id":4,"names":["_crdb_deleted"]}]`,
+		m.Properties[icebergNameMappingProperty])
+	paths := checkSnapshot(m, ts(2), 1)
+	require.Len(t, paths, 1)
+	require.True(t, strings.HasPrefix(paths[0], `nodelocal://0/iceberg/t/`), paths[0])
+	require.Equal(t, "2", m.currentSnapshot().Summary["added-records"])
+
+	// Commits which conflict with other writers are retried with the next
+	// resolved timestamp.
+	catalog.mu.Lock()
+	catalog.mu.conflicts = 1
+	catalog.mu.Unlock()
+	require.Error(t, s.EmitResolvedTimestamp(ctx, e, ts(3)))
+	require.Len(t, catalog.table("t").Snapshots, 1)
+
+	// The columns added to the rows are added to the schema of the table.
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(3)))
+	m = catalog.table("t")
+	require.Equal(t, []string{
+		`1 a long`, `2 b string`, `3 _crdb_updated string`, `4 _crdb_deleted boolean`, `5 c timestamptz`,
+	}, fields(m))
+	require.Contains(t, m.Properties[icebergNameMappingProperty], `{"field-id":5,"names":["c"]}`)
+	require.Len(t, m.Snapshots, 2)
+	require.Equal(t, m.Snapshots[0].SnapshotID, *m.Snapshots[1].ParentSnapshotID)
+	require.Len(t, checkSnapshot(m, ts(3), 2), 1)
+
+	// A restarted sink does not commit the files again.
+	require.NoError(t, s.Close())
+	s, err = makeSink(params)
+	require.NoError(t, err)
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(4)))
+	require.Len(t, catalog.table("t").Snapshots, 2)
+}
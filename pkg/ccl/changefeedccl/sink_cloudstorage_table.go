@@ -0,0 +1,126 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/errors"
+	goparquet "github.com/fraugster/parquet-go"
+)
+
+// The table formats which may be specified as the table_format of the cloud
+// storage sink. With a table format, the rows of each topic are written to
+// parquet files in the directory of the topic, which are added to the table of
+// the topic when the changefeed emits resolved timestamps.
+const (
+	// cloudStorageTableFormatDelta writes Delta Lake tables, whose transaction
+	// logs are in the directories of the topics.
+	cloudStorageTableFormatDelta = "delta"
+	// cloudStorageTableFormatIceberg writes Apache Iceberg tables, whose
+	// snapshots are committed to an Iceberg REST catalog.
+	cloudStorageTableFormatIceberg = "iceberg"
+)
+
+// tableCommitter commits the data files written by the cloud storage sinks of
+// a changefeed to the tables of its topics.
+//
+// The data files are committed when the changefeed emits resolved
+// timestamps: the commit for a resolved timestamp adds the data files whose
+// names sort before the name of the resolved timestamp file which the sink
+// would have written otherwise, that is, the data files which hold all the
+// rows up to the resolved timestamp (and possibly some later rows). Since the
+// data files are only added once their rows are resolved, the readers of the
+// tables never see rows out of order, and see all the rows up to the last
+// committed resolved timestamp. After a restart, the rows since the last
+// resolved timestamp are emitted again, so the tables may contain
+// duplicates, which are distinguished by their _crdb_updated column.
+type tableCommitter interface {
+	// commit commits the data files holding the rows up to a resolved
+	// timestamp to their tables.
+	commit(ctx context.Context, resolved hlc.Timestamp) error
+}
+
+// dataFileAfter returns whether a data file only holds rows after a
+// resolved timestamp. Data files are named after the inclusive lower bound of
+// the timestamps of their rows, so the files whose names sort after the
+// resolved timestamp hold later rows only, and are committed with a later
+// resolved timestamp.
+func dataFileAfter(path string, resolved hlc.Timestamp) bool {
+	resolvedName := cloudStorageFormatTime(resolved)
+	base := path[strings.LastIndexByte(path, '/')+1:]
+	return len(base) < len(resolvedName) || base[:len(resolvedName)] > resolvedName
+}
+
+// listResolvedDataFiles returns the data files holding the rows up to a
+// resolved timestamp, keyed by the directory of their table, with their paths
+// relative to it.
+func listResolvedDataFiles(
+	ctx context.Context, es cloud.ExternalStorage, resolved hlc.Timestamp,
+) (map[string][]string, error) {
+	files := make(map[string][]string)
+	if err := es.List(ctx, "", "", func(name string) error {
+		name = strings.TrimPrefix(name, "/")
+		i := strings.IndexByte(name, '/')
+		if i < 0 || !strings.HasSuffix(name, ".parquet") {
+			return nil
+		}
+		dir, rel := name[:i+1], name[i+1:]
+		if strings.HasPrefix(rel, deltaLogDir) || dataFileAfter(rel, resolved) {
+			return nil
+		}
+		files[dir] = append(files[dir], rel)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// parquetDataFile is the metadata of a data file, read from its footer.
+type parquetDataFile struct {
+	columns []parquetColumn
+	numRows int64
+	size    int64
+}
+
+// readParquetDataFile reads the metadata of a data file.
+func readParquetDataFile(
+	ctx context.Context, es cloud.ExternalStorage, name string,
+) (*parquetDataFile, error) {
+	r, err := es.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close(ctx)
+	data, err := ioctx.ReadAll(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	fr, err := goparquet.NewFileReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+	file := &parquetDataFile{numRows: fr.NumRows(), size: int64(len(data))}
+	for _, col := range fr.GetSchemaDefinition().RootColumn.Children {
+		kind, err := parquetKindOfElement(col.SchemaElement)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", name)
+		}
+		file.columns = append(file.columns, parquetColumn{
+			name: col.SchemaElement.Name, kind: kind, source: col.SchemaElement.Name, keyIdx: -1,
+		})
+	}
+	return file, nil
+}
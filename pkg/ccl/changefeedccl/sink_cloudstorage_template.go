@@ -0,0 +1,305 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdceval"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// Named partition layouts which may be specified as the partition_format of
+// the cloud storage sink. Any other value of partition_format is interpreted
+// as a partition template.
+var partitionLayouts = map[string]string{
+	"flat":   "",
+	"daily":  "{date}/",
+	"hourly": "{date}/{hour}/",
+}
+
+const defaultPartitionLayout = "daily"
+
+// Tokens which may be used in a partition template. Any other token is
+// interpreted as a CDC expression over the columns of the row (e.g. a column
+// name) whose value is used to partition the files.
+const (
+	partitionTokenTable = "table"
+	partitionTokenDate  = "date"
+	partitionTokenHour  = "hour"
+)
+
+// partitionTemplateSegment is either a literal string, a token, or a reference
+// to an expression of the partition template.
+type partitionTemplateSegment struct {
+	literal string
+	token   string
+	// exprIdx is the index of the expression in partitionTemplate.exprs; only
+	// set if the segment is an expression.
+	exprIdx int
+}
+
+func (s partitionTemplateSegment) isExpr() bool {
+	return s.exprIdx >= 0
+}
+
+// partitionTemplate describes the directory layout of the files written by the
+// cloud storage sink. A template such as `{table}/{region}/{date}/` partitions
+// files by the topic name, the value of the region column of the rows, and the
+// date of the timestamp of the file. Template components are separated by '/';
+// the template always ends with a '/' unless it is empty (flat layout).
+type partitionTemplate struct {
+	// components are the '/' separated components of the template.
+	components [][]partitionTemplateSegment
+	// exprs are the CDC expressions referenced by the template, in the order
+	// of their first occurrence.
+	exprs []string
+}
+
+// parsePartitionTemplate parses the partition_format of the cloud storage sink
+// which is either one of the named layouts, or a partition template. The
+// partition_columns of the sink, if any, partition the files by the values of
+// the listed columns before the partition_format does.
+func parsePartitionTemplate(format, columns string) (*partitionTemplate, error) {
+	if format == "" {
+		format = defaultPartitionLayout
+	}
+	if layout, ok := partitionLayouts[format]; ok {
+		format = layout
+	}
+	if columns != "" {
+		columnComponents, err := hivePartitionComponents(columns)
+		if err != nil {
+			return nil, err
+		}
+		format = columnComponents + format
+	}
+
+	invalidTemplate := func(token, reason string) error {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"invalid %s %q: %s %s", changefeedbase.SinkParamPartitionFormat, format, token, reason)
+	}
+
+	if strings.HasPrefix(format, "/") {
+		return nil, invalidTemplate("/", "cannot be the first character")
+	}
+
+	t := &partitionTemplate{}
+	exprIdx := make(map[string]int)
+	for _, component := range strings.Split(strings.TrimSuffix(format, "/"), "/") {
+		if component == "" {
+			if format == "" {
+				break
+			}
+			return nil, invalidTemplate("//", "empty path components are not allowed")
+		}
+		if component == "." || component == ".." {
+			return nil, invalidTemplate(component, "is not a valid path component")
+		}
+
+		var segments []partitionTemplateSegment
+		for rest := component; rest != ""; {
+			open := strings.IndexByte(rest, '{')
+			if close := strings.IndexByte(rest, '}'); close >= 0 && (open < 0 || close < open) {
+				return nil, invalidTemplate("}", "has no matching {")
+			}
+			if open < 0 {
+				segments = append(segments, partitionTemplateSegment{literal: rest, exprIdx: -1})
+				break
+			}
+			if open > 0 {
+				segments = append(segments, partitionTemplateSegment{literal: rest[:open], exprIdx: -1})
+			}
+			rest = rest[open+1:]
+			close := strings.IndexByte(rest, '}')
+			if close < 0 {
+				return nil, invalidTemplate("{", "has no matching }")
+			}
+			token := strings.TrimSpace(rest[:close])
+			rest = rest[close+1:]
+			if token == "" || strings.ContainsRune(token, '{') {
+				return nil, invalidTemplate("{"+token+"}", "is not a valid token")
+			}
+
+			switch token {
+			case partitionTokenTable, partitionTokenDate, partitionTokenHour:
+				segments = append(segments, partitionTemplateSegment{token: token, exprIdx: -1})
+			default:
+				idx, ok := exprIdx[token]
+				if !ok {
+					idx = len(t.exprs)
+					exprIdx[token] = idx
+					t.exprs = append(t.exprs, token)
+				}
+				segments = append(segments, partitionTemplateSegment{token: token, exprIdx: idx})
+			}
+		}
+		t.components = append(t.components, segments)
+	}
+	return t, nil
+}
+
+// hivePartitionComponents returns the template components which partition the
+// files by the values of the specified comma separated columns, in the
+// column=value layout of Hive partitions. For example, the columns `region,
+// zone` are the template `region={region}/zone={zone}/`.
+func hivePartitionComponents(columns string) (string, error) {
+	var b strings.Builder
+	for _, column := range splitPartitionColumns(columns) {
+		if column == "" || strings.ContainsAny(column, "{}/=") {
+			return "", pgerror.Newf(pgcode.InvalidParameterValue,
+				"invalid %s %q: %q is not a valid column", changefeedbase.SinkParamPartitionColumns, columns, column)
+		}
+		fmt.Fprintf(&b, "%[1]s={%[1]s}/", column)
+	}
+	return b.String(), nil
+}
+
+// splitPartitionColumns splits the comma separated partition_columns of the
+// cloud storage sink.
+func splitPartitionColumns(columns string) []string {
+	split := strings.Split(columns, ",")
+	for i := range split {
+		split[i] = strings.TrimSpace(split[i])
+	}
+	return split
+}
+
+// escapePartitionValue path escapes the value of a template expression. Empty
+// values, which would render empty path components, are rendered like NULL
+// values as cdceval.NullPathValue, and the dots of "." and "..", which would
+// name the current or parent directory, are escaped.
+func escapePartitionValue(value string) string {
+	switch value {
+	case "":
+		return cdceval.NullPathValue
+	case ".", "..":
+		return strings.ReplaceAll(value, ".", "%2E")
+	}
+	return url.PathEscape(value)
+}
+
+// renderSegments renders the segments of a template component. Expression
+// values are escaped by escapePartitionValue.
+func renderSegments(
+	segments []partitionTemplateSegment, topic string, ts hlc.Timestamp, values []string,
+) string {
+	var b strings.Builder
+	for _, s := range segments {
+		switch {
+		case s.isExpr():
+			b.WriteString(escapePartitionValue(values[s.exprIdx]))
+		case s.token == partitionTokenTable:
+			b.WriteString(topic)
+		case s.token == partitionTokenDate:
+			b.WriteString(ts.GoTime().Format("2006-01-02"))
+		case s.token == partitionTokenHour:
+			b.WriteString(ts.GoTime().Format("15"))
+		default:
+			b.WriteString(s.literal)
+		}
+	}
+	return b.String()
+}
+
+// render returns the directory of a file for the specified topic, file
+// timestamp and values of the template expressions. The returned directory is
+// either empty or ends with a '/'.
+func (t *partitionTemplate) render(topic string, ts hlc.Timestamp, values []string) string {
+	var b strings.Builder
+	for _, segments := range t.components {
+		b.WriteString(renderSegments(segments, topic, ts, values))
+		b.WriteByte('/')
+	}
+	return b.String()
+}
+
+// timeOnly returns true if the template component only consists of literals
+// and time based tokens, that is, it can be rendered knowing only the
+// timestamp.
+func timeOnly(segments []partitionTemplateSegment) bool {
+	for _, s := range segments {
+		if s.isExpr() || s.token == partitionTokenTable {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionDirectories are the partitions the sink has written files to since
+// it last emitted a resolved timestamp. Each of them is identified by the
+// rendering of the template components which depend on the emitted rows (i.e.
+// the table or expression values), the other components being empty.
+type partitionDirectories map[string][]string
+
+// written records the partition of a file the sink has written for the
+// specified topic, file timestamp and values of the template expressions.
+func (t *partitionTemplate) written(
+	c *partitionDirectories, topic string, ts hlc.Timestamp, values []string,
+) {
+	components := make([]string, len(t.components))
+	for i, segments := range t.components {
+		if !timeOnly(segments) {
+			components[i] = renderSegments(segments, topic, ts, values)
+		}
+	}
+	key := strings.Join(components, "/")
+	if _, ok := (*c)[key]; ok {
+		return
+	}
+	if *c == nil {
+		*c = make(partitionDirectories)
+	}
+	(*c)[key] = components
+}
+
+// resolvedDirectories returns the directories into which the resolved
+// timestamp file for the specified timestamp should be written, which are the
+// directories of the partitions the sink has written files to since it last
+// emitted a resolved timestamp. If the template does not depend on the emitted
+// rows, the resolved timestamp file is always written.
+func (t *partitionTemplate) resolvedDirectories(
+	c partitionDirectories, resolved hlc.Timestamp,
+) []string {
+	render := func(components []string) string {
+		var b strings.Builder
+		for i, segments := range t.components {
+			if timeOnly(segments) {
+				b.WriteString(renderSegments(segments, "" /* topic */, resolved, nil /* values */))
+			} else {
+				b.WriteString(components[i])
+			}
+			b.WriteByte('/')
+		}
+		return b.String()
+	}
+
+	rowDependent := false
+	for _, segments := range t.components {
+		if !timeOnly(segments) {
+			rowDependent = true
+			break
+		}
+	}
+	if !rowDependent {
+		return []string{render(nil)}
+	}
+	dirs := make([]string, 0, len(c))
+	for _, components := range c {
+		dirs = append(dirs, render(components))
+	}
+	sort.Strings(dirs)
+	return dirs
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdceval"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionTemplate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ts := hlc.Timestamp{WallTime: time.Date(2000, time.January, 2, 3, 4, 5, 0, time.UTC).UnixNano()}
+
+	for _, tc := range []struct {
+		format        string
+		columns       string
+		values        []string
+		expectedExprs []string
+		expectedDir   string
+		expectedErr   string
+	}{
+		{format: "", expectedDir: "2000-01-02/"},
+		{format: "flat", expectedDir: ""},
+		{format: "daily", expectedDir: "2000-01-02/"},
+		{format: "hourly", expectedDir: "2000-01-02/03/"},
+		{format: "{table}", expectedDir: "t1/"},
+		{
+			format:        "{table}/{region}/{date}/",
+			values:        []string{"us-east"},
+			expectedExprs: []string{"region"},
+			expectedDir:   "t1/us-east/2000-01-02/",
+		},
+		{
+			format:        "region={ lower(region) }/{date}T{hour}/{lower(region)}",
+			values:        []string{"a b/c"},
+			expectedExprs: []string{"lower(region)"},
+			expectedDir:   "region=a%20b%2Fc/2000-01-02T03/a%20b%2Fc/",
+		},
+		{
+			format:        "{a}-{b}/",
+			values:        []string{"1", "null"},
+			expectedExprs: []string{"a", "b"},
+			expectedDir:   "1-null/",
+		},
+		{
+			format:        "{a}/{b}/{c}/{d}/",
+			values:        []string{"", cdceval.NullPathValue, ".", ".."},
+			expectedExprs: []string{"a", "b", "c", "d"},
+			expectedDir:   "__null__/__null__/%2E/%2E%2E/",
+		},
+		{
+			format:        "{date}/",
+			columns:       "region, zone",
+			values:        []string{"us-east", "a"},
+			expectedExprs: []string{"region", "zone"},
+			expectedDir:   "region=us-east/zone=a/2000-01-02/",
+		},
+		{
+			format:        "date={date}/",
+			columns:       "region",
+			values:        []string{"us-east"},
+			expectedExprs: []string{"region"},
+			expectedDir:   "region=us-east/date=2000-01-02/",
+		},
+		{
+			format:        "flat",
+			columns:       "region",
+			values:        []string{"us-east"},
+			expectedExprs: []string{"region"},
+			expectedDir:   "region=us-east/",
+		},
+		{
+			format:        "{table}/{region}",
+			columns:       "region",
+			values:        []string{"us-east"},
+			expectedExprs: []string{"region"},
+			expectedDir:   "region=us-east/t1/us-east/",
+		},
+		{format: "daily", columns: "region,", expectedErr: `invalid partition_columns "region,": "" is not a valid column`},
+		{format: "daily", columns: "a=b", expectedErr: `"a=b" is not a valid column`},
+		{format: "/{date}", expectedErr: `: / cannot be the first character`},
+		{format: "{table}//{date}", expectedErr: `: // empty path components are not allowed`},
+		{format: "{table}/../{date}", expectedErr: `\.\. is not a valid path component`},
+		{format: "{table", expectedErr: `\{ has no matching \}`},
+		{format: "table}", expectedErr: `\} has no matching \{`},
+		{format: "{}/{date}", expectedErr: `\{\} is not a valid token`},
+		{format: "{{region}}", expectedErr: `\{\{region\} is not a valid token`},
+	} {
+		t.Run(tc.format+tc.columns, func(t *testing.T) {
+			template, err := parsePartitionTemplate(tc.format, tc.columns)
+			if tc.expectedErr != "" {
+				require.Regexp(t, tc.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedExprs, template.exprs)
+			require.Equal(t, tc.expectedDir, template.render("t1", ts, tc.values))
+		})
+	}
+}
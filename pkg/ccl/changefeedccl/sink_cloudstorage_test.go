@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -38,11 +39,17 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors/oserror"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/google/btree"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
 )
 
@@ -89,6 +96,16 @@ func TestCloudStorageSink(t *testing.T) {
 		return decompressed
 	}
 
+	zstdDecompress := func(t *testing.T, compressed []byte) []byte {
+		r, err := zstd.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		defer r.Close()
+
+		decompressed, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return decompressed
+	}
+
 	listLeafDirectories := func(root string) []string {
 		absRoot := filepath.Join(dir, root)
 
@@ -143,6 +160,9 @@ func TestCloudStorageSink(t *testing.T) {
 			if strings.HasSuffix(path, ".gz") {
 				file = gzipDecompress(t, file)
 			}
+			if strings.HasSuffix(path, ".zst") {
+				file = zstdDecompress(t, file)
+			}
 			files = append(files, string(file))
 			return nil
 		}
@@ -212,6 +232,12 @@ func TestCloudStorageSink(t *testing.T) {
 			dir, sinkDir, `1970-01-01`, `197001010000000000000050000000000.RESOLVED`))
 		require.NoError(t, err)
 		require.Equal(t, `{"resolved":"5.0000000000"}`, string(resolvedFile))
+
+		// Schema files are written to the root directory.
+		require.NoError(t, s.(SchemaFileSink).WriteSchemaFile(ctx, `t1-value-1.proto`, []byte(`schema`)))
+		schemaFile, err := os.ReadFile(filepath.Join(dir, sinkDir, `t1-value-1.proto`))
+		require.NoError(t, err)
+		require.Equal(t, `schema`, string(schemaFile))
 	})
 
 	forwardFrontier := func(f *span.Frontier, s roachpb.Span, wall int64) bool {
@@ -227,7 +253,7 @@ func TestCloudStorageSink(t *testing.T) {
 		defer func() {
 			opts.Compression = before
 		}()
-		for _, compression := range []string{"", "gzip"} {
+		for _, compression := range []string{"", "gzip", "zstd"} {
 			opts.Compression = compression
 			t.Run("compress="+compression, func(t *testing.T) {
 				t1 := makeTopic(`t1`)
@@ -530,6 +556,63 @@ func TestCloudStorageSink(t *testing.T) {
 		}, slurpDir(t, dir))
 	})
 
+	t.Run(`file-rotation`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+
+		dir := `file-rotation`
+		sinkURIWithParam := sinkURI(dir, unlimitedFileSize)
+		sinkURIWithParam.addParam(changefeedbase.SinkParamFileMaxRows, `3`)
+		sinkURIWithParam.addParam(changefeedbase.SinkParamFileMaxDuration, `1h`)
+		s, err := makeCloudStorageSink(
+			ctx, sinkURIWithParam, 1,
+			settings, opts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		s.(*cloudStorageSink).sinkID = 7 // Force a deterministic sinkID.
+
+		// Files are flushed once they hold the max number of rows.
+		for i := int64(1); i <= 4; i++ {
+			require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(fmt.Sprintf(`v%d`, i)), ts(i), ts(i), zeroAlloc))
+		}
+		require.Equal(t, []string{
+			"v1\nv2\nv3\n",
+		}, slurpDir(t, dir))
+
+		// Files are flushed by the next row once they have been open for the
+		// max duration.
+		s.(*cloudStorageSink).files.Ascend(func(i btree.Item) bool {
+			i.(*cloudStorageSinkFile).created = timeutil.Now().Add(-time.Hour)
+			return true
+		})
+		require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v5`), ts(5), ts(5), zeroAlloc))
+		require.Equal(t, []string{
+			"v1\nv2\nv3\n",
+			"v4\nv5\n",
+		}, slurpDir(t, dir))
+		require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v6`), ts(6), ts(6), zeroAlloc))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{
+			"v1\nv2\nv3\n",
+			"v4\nv5\n",
+			"v6\n",
+		}, slurpDir(t, dir))
+
+		for _, param := range []string{changefeedbase.SinkParamFileMaxRows, changefeedbase.SinkParamFileMaxDuration} {
+			invalidURI := sinkURI(dir, unlimitedFileSize)
+			invalidURI.addParam(param, `-1`)
+			_, err = makeCloudStorageSink(
+				ctx, invalidURI, 1,
+				settings, opts, timestampOracle, externalStorageFromURI, user, nil,
+			)
+			require.Regexp(t, param+` must be a positive`, err)
+		}
+	})
+
 	t.Run(`partition-formatting`, func(t *testing.T) {
 		t1 := makeTopic(`t1`)
 		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
@@ -613,6 +696,120 @@ func TestCloudStorageSink(t *testing.T) {
 		}
 	})
 
+	t.Run(`partition-template`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+
+		dir := `partition-template`
+		sinkURIWithParam := sinkURI(dir, unlimitedFileSize)
+		sinkURIWithParam.addParam(changefeedbase.SinkParamPartitionFormat, `{table}/{region}/{date}/`)
+		s, err := makeCloudStorageSink(
+			ctx, sinkURIWithParam, 1,
+			settings, opts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		s.(*cloudStorageSink).sinkID = 7 // Force a deterministic sinkID.
+
+		ps, ok := s.(PathPartitionedEventSink)
+		require.True(t, ok)
+		require.Equal(t, []string{`region`}, ps.PartitionExprs())
+
+		// Rows must be emitted along with the values of the template expressions.
+		require.Regexp(t, `references row values`,
+			s.EmitRow(ctx, t1, noKey, []byte(`v0`), ts(1), ts(1), zeroAlloc))
+
+		hlcTime := ts(time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC).UnixNano())
+		_, err = sf.Forward(testSpan, hlcTime)
+		require.NoError(t, err)
+		require.NoError(t, s.Flush(ctx))
+
+		for i, region := range []string{`us`, `eu`, `us`, `ap/south`} {
+			require.NoError(t, ps.EmitRowWithPartitionValues(ctx, t1, noKey,
+				[]byte(fmt.Sprintf(`v%d`, i)), hlcTime, hlcTime, zeroAlloc, []string{region}))
+		}
+		require.NoError(t, s.Flush(ctx))
+		require.ElementsMatch(t, []string{
+			"t1/us/2000-01-01",
+			"t1/eu/2000-01-01",
+			"t1/ap%2Fsouth/2000-01-01",
+		}, listLeafDirectories(dir))
+		require.Equal(t, []string{"v3\n", "v1\n", "v0\nv2\n"}, slurpDir(t, dir))
+
+		// The resolved timestamp file is written into every partition directory
+		// written to since the previous resolved timestamp.
+		countResolved := func(leaf string) int {
+			files, err := os.ReadDir(filepath.Join(settings.ExternalIODir, dir, leaf))
+			require.NoError(t, err)
+			var resolved int
+			for _, f := range files {
+				if strings.HasSuffix(f.Name(), `.RESOLVED`) {
+					resolved++
+				}
+			}
+			return resolved
+		}
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, e, hlcTime))
+		for _, leaf := range listLeafDirectories(dir) {
+			require.Equal(t, 1, countResolved(leaf), leaf)
+		}
+
+		// The partitions which were not written to since, including those
+		// written to by other sinks, do not get the next resolved timestamp.
+		other := filepath.Join(settings.ExternalIODir, dir, `t1`, `other`, `2000-01-01`)
+		require.NoError(t, os.MkdirAll(other, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(other, `data.ndjson`), []byte("v4\n"), 0644))
+		require.NoError(t, ps.EmitRowWithPartitionValues(ctx, t1, noKey,
+			[]byte(`v5`), hlcTime.Next(), hlcTime.Next(), zeroAlloc, []string{`us`}))
+		require.NoError(t, s.Flush(ctx))
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, e, hlcTime.Next()))
+		require.Equal(t, 2, countResolved(`t1/us/2000-01-01`))
+		require.Equal(t, 1, countResolved(`t1/eu/2000-01-01`))
+		require.Equal(t, 0, countResolved(`t1/other/2000-01-01`))
+	})
+
+	t.Run(`partition-columns`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+
+		dir := `partition-columns`
+		sinkURIWithParam := sinkURI(dir, unlimitedFileSize)
+		sinkURIWithParam.addParam(changefeedbase.SinkParamPartitionColumns, `region, zone`)
+		sinkURIWithParam.addParam(changefeedbase.SinkParamPartitionFormat, `date={date}/`)
+		s, err := makeCloudStorageSink(
+			ctx, sinkURIWithParam, 1,
+			settings, opts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		s.(*cloudStorageSink).sinkID = 7 // Force a deterministic sinkID.
+
+		ps, ok := s.(PathPartitionedEventSink)
+		require.True(t, ok)
+		require.Equal(t, []string{`region`, `zone`}, ps.PartitionExprs())
+
+		hlcTime := ts(time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC).UnixNano())
+		_, err = sf.Forward(testSpan, hlcTime)
+		require.NoError(t, err)
+		require.NoError(t, s.Flush(ctx))
+
+		for i, values := range [][]string{{`us`, `a`}, {`eu`, `b`}, {`us`, `a`}} {
+			require.NoError(t, ps.EmitRowWithPartitionValues(ctx, t1, noKey,
+				[]byte(fmt.Sprintf(`v%d`, i)), hlcTime, hlcTime, zeroAlloc, values))
+		}
+		require.NoError(t, s.Flush(ctx))
+		require.ElementsMatch(t, []string{
+			"region=us/zone=a/date=2000-01-01",
+			"region=eu/zone=b/date=2000-01-01",
+		}, listLeafDirectories(dir))
+	})
+
 	t.Run(`file-ordering`, func(t *testing.T) {
 		t1 := makeTopic(`t1`)
 		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
@@ -722,4 +919,122 @@ func TestCloudStorageSink(t *testing.T) {
 			"w1\n",
 		}, slurpDir(t, dir))
 	})
+
+	t.Run(`delta-lake`, func(t *testing.T) {
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+		sinkDir := `delta-lake`
+		makeSink := func() Sink {
+			u := sinkURI(sinkDir, unlimitedFileSize)
+			u.RawQuery = changefeedbase.SinkParamTableFormat + `=` + cloudStorageTableFormatDelta
+			s, err := makeCloudStorageSink(
+				ctx, u, 1, settings, opts, timestampOracle, externalStorageFromURI, user, nil,
+			)
+			require.NoError(t, err)
+			return s
+		}
+		s := makeSink()
+		defer func() { require.NoError(t, s.Close()) }()
+
+		tCols := []descpb.ColumnDescriptor{
+			{Name: "a", Type: types.Int},
+			{Name: "b b", Type: types.String, Nullable: true},
+		}
+		tV1 := makeTestTopicWithColumns(t, 100, "t", 1, tCols...)
+		tV2 := makeTestTopicWithColumns(t, 100, "t", 2,
+			append(tCols, descpb.ColumnDescriptor{Name: "c", Type: types.Float, Nullable: true})...)
+
+		require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[1]`),
+			[]byte(`{"after": {"a": 1, "b b": "x"}, "key": [1]}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[2]`),
+			[]byte(`{"after": {"a": 2, "b b": null}, "key": [2]}`), ts(2), ts(2), zeroAlloc))
+		require.True(t, forwardFrontier(sf, testSpan, 2))
+		require.NoError(t, s.Flush(ctx))
+		require.NoError(t, s.EmitRow(ctx, tV2, []byte(`[1]`),
+			[]byte(`{"after": null, "key": [1]}`), ts(3), ts(3), zeroAlloc))
+		require.NoError(t, s.EmitRow(ctx, tV2, []byte(`[3]`),
+			[]byte(`{"after": {"a": 3, "b b": "y", "c": 1.5}, "key": [3]}`), ts(3), ts(3), zeroAlloc))
+		require.True(t, forwardFrontier(sf, testSpan, 3))
+		require.NoError(t, s.Flush(ctx))
+
+		tableDir := filepath.Join(dir, sinkDir, `t`)
+		readCommit := func(version int) (schema []string, paths []string) {
+			data, err := os.ReadFile(filepath.Join(tableDir, deltaLogDir, fmt.Sprintf(`%020d.json`, version)))
+			require.NoError(t, err)
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				var a deltaAction
+				require.NoError(t, json.Unmarshal([]byte(line), &a))
+				switch {
+				case a.MetaData != nil:
+					var sc deltaSchema
+					require.NoError(t, json.Unmarshal([]byte(a.MetaData.SchemaString), &sc))
+					for _, f := range sc.Fields {
+						schema = append(schema, f.Name+` `+f.Type)
+					}
+				case a.Add != nil:
+					paths = append(paths, a.Add.Path)
+				}
+			}
+			return schema, paths
+		}
+		readRows := func(path string) []string {
+			data, err := os.ReadFile(filepath.Join(tableDir, path))
+			require.NoError(t, err)
+			fr, err := goparquet.NewFileReader(bytes.NewReader(data))
+			require.NoError(t, err)
+			var rows []string
+			for {
+				row, err := fr.NextRow()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				var cols []string
+				for _, col := range fr.GetSchemaDefinition().RootColumn.Children {
+					v, ok := row[col.SchemaElement.Name]
+					if b, isBytes := v.([]byte); isBytes {
+						v = string(b)
+					}
+					if ok {
+						cols = append(cols, fmt.Sprintf(`%s=%v`, col.SchemaElement.Name, v))
+					}
+				}
+				rows = append(rows, strings.Join(cols, ` `))
+			}
+			return rows
+		}
+
+		// The files with rows after the resolved timestamp are not committed.
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(2)))
+		schema, paths := readCommit(0)
+		require.Equal(t, []string{
+			`a long`, `b_b string`, `_crdb_updated string`, `_crdb_deleted boolean`,
+		}, schema)
+		require.Len(t, paths, 1)
+		require.Equal(t, []string{
+			`a=1 b_b=x _crdb_updated=1.0000000000 _crdb_deleted=false`,
+			`a=2 _crdb_updated=2.0000000000 _crdb_deleted=false`,
+		}, readRows(paths[0]))
+
+		// The columns added to the rows are added to the schema of the table.
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(3)))
+		schema, paths = readCommit(1)
+		require.Equal(t, []string{
+			`a long`, `b_b string`, `_crdb_updated string`, `_crdb_deleted boolean`, `c double`,
+		}, schema)
+		require.Len(t, paths, 1)
+		require.Equal(t, []string{
+			`a=1 _crdb_updated=3.0000000000 _crdb_deleted=true`,
+			`a=3 b_b=y c=1.5 _crdb_updated=3.0000000000 _crdb_deleted=false`,
+		}, readRows(paths[0]))
+
+		// A restarted sink does not commit the files again.
+		require.NoError(t, s.Close())
+		s = makeSink()
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(4)))
+		_, err = os.Stat(filepath.Join(tableDir, deltaLogDir, fmt.Sprintf(`%020d.json`, 2)))
+		require.True(t, oserror.IsNotExist(err))
+	})
 }
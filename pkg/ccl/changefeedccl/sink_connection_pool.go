@@ -0,0 +1,173 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// sinkConnectionKey identifies the connections which sinks may share, i.e. the
+// connections of the same kind to the same endpoint with the same
+// configuration.
+type sinkConnectionKey struct {
+	kind     string
+	endpoint string
+	// config is the fingerprint of the configuration of the connection, which
+	// may contain secrets.
+	config string
+}
+
+// makeSinkConnectionKey returns the key of the connections of the specified
+// kind to the endpoint, whose configuration is made of the specified parts.
+func makeSinkConnectionKey(kind, endpoint string, config ...string) sinkConnectionKey {
+	h := sha256.New()
+	for _, c := range config {
+		_, _ = fmt.Fprintf(h, "%d:%s", len(c), c)
+	}
+	return sinkConnectionKey{kind: kind, endpoint: endpoint, config: hex.EncodeToString(h.Sum(nil))}
+}
+
+// pooledSinkConnection is a connection shared by sinks.
+type pooledSinkConnection struct {
+	conn  interface{}
+	close func() error
+	// sinks is the number of sinks using the connection.
+	sinks int
+}
+
+// sinkConnectionPool shares the connections of the sinks of the changefeeds
+// running on the node which connect to the same endpoint with the same
+// configuration, e.g. the kafka clients of the sinks of hundreds of
+// changefeeds emitting to the same kafka cluster, rather than each sink
+// opening connections of its own. A connection is shared by at most
+// changefeedbase.SinkConnectionPoolMaxSinks sinks, and is closed once it is
+// released by all of them.
+type sinkConnectionPool struct {
+	mu struct {
+		syncutil.Mutex
+		conns map[sinkConnectionKey][]*pooledSinkConnection
+		// numConns and numSinks are the number of pooled connections, and the
+		// number of sinks using them.
+		numConns, numSinks int64
+	}
+}
+
+// sinkConnections is the pool of the connections of the sinks of the node.
+var sinkConnections = newSinkConnectionPool()
+
+func newSinkConnectionPool() *sinkConnectionPool {
+	p := &sinkConnectionPool{}
+	p.mu.conns = make(map[sinkConnectionKey][]*pooledSinkConnection)
+	return p
+}
+
+// acquire returns a connection of the key, which is shared with other sinks
+// unless all the connections of the key are shared by the maximum number of
+// sinks, in which case a connection is opened with open. The returned function
+// releases the connection, and must be called once the sink is done with it.
+func (p *sinkConnectionPool) acquire(
+	st *cluster.Settings,
+	key sinkConnectionKey,
+	open func() (conn interface{}, close func() error, err error),
+) (conn interface{}, release func() error, err error) {
+	maxSinks := int(changefeedbase.SinkConnectionPoolMaxSinks.Get(&st.SV))
+	if maxSinks == 0 {
+		return open()
+	}
+
+	if c := p.share(key, maxSinks); c != nil {
+		return c.conn, p.releaseFunc(key, c), nil
+	}
+	// The connection is opened without holding the lock, since it may dial the
+	// endpoint; the sinks opening connections of the same key concurrently
+	// each pool their own.
+	conn, closeConn, err := open()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &pooledSinkConnection{conn: conn, close: closeConn, sinks: 1}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.conns[key] = append(p.mu.conns[key], c)
+	p.mu.numConns++
+	p.mu.numSinks++
+	return conn, p.releaseFunc(key, c), nil
+}
+
+// share returns a connection of the key which is shared by fewer than
+// maxSinks sinks, if any, for one more sink.
+func (p *sinkConnectionPool) share(key sinkConnectionKey, maxSinks int) *pooledSinkConnection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.mu.conns[key] {
+		if c.sinks < maxSinks {
+			c.sinks++
+			p.mu.numSinks++
+			return c
+		}
+	}
+	return nil
+}
+
+// releaseFunc returns the function with which a sink releases the connection,
+// which is closed once no sink uses it anymore.
+func (p *sinkConnectionPool) releaseFunc(
+	key sinkConnectionKey, c *pooledSinkConnection,
+) func() error {
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			if p.release(key, c) {
+				err = c.close()
+			}
+		})
+		return err
+	}
+}
+
+// release releases the connection of a sink, and returns whether the
+// connection must be closed.
+func (p *sinkConnectionPool) release(key sinkConnectionKey, c *pooledSinkConnection) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.sinks--
+	p.mu.numSinks--
+	if c.sinks > 0 {
+		return false
+	}
+	conns := p.mu.conns[key]
+	for i := range conns {
+		if conns[i] == c {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(p.mu.conns, key)
+	} else {
+		p.mu.conns[key] = conns
+	}
+	p.mu.numConns--
+	return true
+}
+
+// stats returns the number of pooled connections, and the number of sinks
+// using them.
+func (p *sinkConnectionPool) stats() (conns, sinks int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mu.numConns, p.mu.numSinks
+}
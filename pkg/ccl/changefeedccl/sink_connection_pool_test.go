@@ -0,0 +1,88 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkConnectionPool(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	changefeedbase.SinkConnectionPoolMaxSinks.Override(ctx, &st.SV, 2)
+	p := newSinkConnectionPool()
+
+	var opened, closed int
+	open := func() (interface{}, func() error, error) {
+		opened++
+		return opened, func() error {
+			closed++
+			return nil
+		}, nil
+	}
+	key := makeSinkConnectionKey(`kafka`, `broker:9092`, `config`)
+	acquire := func(key sinkConnectionKey) (interface{}, func() error) {
+		conn, release, err := p.acquire(st, key, open)
+		require.NoError(t, err)
+		return conn, release
+	}
+	requireStats := func(expectedConns, expectedSinks int64) {
+		conns, sinks := p.stats()
+		require.Equal(t, expectedConns, conns)
+		require.Equal(t, expectedSinks, sinks)
+	}
+
+	// The sinks of the same key share a connection, up to the maximum number of
+	// sinks per connection.
+	c1, r1 := acquire(key)
+	c2, r2 := acquire(key)
+	c3, r3 := acquire(key)
+	require.Equal(t, []interface{}{1, 1, 2}, []interface{}{c1, c2, c3})
+	requireStats(2, 3)
+
+	// The connections of different configurations are not shared.
+	c4, r4 := acquire(makeSinkConnectionKey(`kafka`, `broker:9092`, `other config`))
+	require.Equal(t, 3, c4)
+	requireStats(3, 4)
+
+	// A connection is only closed once all its sinks released it, and a sink
+	// releasing it twice does not release it for the other sinks.
+	require.NoError(t, r1())
+	require.NoError(t, r1())
+	require.Zero(t, closed)
+	c5, r5 := acquire(key)
+	require.Equal(t, 1, c5)
+	require.NoError(t, r2())
+	require.NoError(t, r5())
+	require.Equal(t, 1, closed)
+	require.NoError(t, r3())
+	require.NoError(t, r4())
+	require.Equal(t, 3, closed)
+	requireStats(0, 0)
+
+	// The connections are not shared if the sharing is disabled.
+	changefeedbase.SinkConnectionPoolMaxSinks.Override(ctx, &st.SV, 0)
+	c6, r6 := acquire(key)
+	c7, r7 := acquire(key)
+	require.Equal(t, []interface{}{4, 5}, []interface{}{c6, c7})
+	requireStats(0, 0)
+	require.NoError(t, r6())
+	require.NoError(t, r7())
+	require.Equal(t, 5, closed)
+}
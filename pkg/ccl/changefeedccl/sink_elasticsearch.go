@@ -0,0 +1,500 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// elasticsearchMaxBytesPerRequest is the maximum size of the bulk
+	// requests, which is the upper end of the sizes recommended by
+	// Elasticsearch.
+	elasticsearchMaxBytesPerRequest = 10 << 20
+	// elasticsearchMaxIDBytes is the maximum size of the IDs of the documents.
+	elasticsearchMaxIDBytes = 512
+)
+
+func isElasticsearchSink(u *url.URL) bool {
+	switch u.Scheme {
+	case changefeedbase.SinkSchemeElasticsearch, changefeedbase.SinkSchemeOpenSearch:
+		return true
+	default:
+		return false
+	}
+}
+
+// elasticsearchIndexName converts a topic name to a valid index name: the
+// index names are lowercase, must not contain some characters, which are
+// replaced by underscores, and must not start with '-', '_' or '+', which are
+// removed.
+func elasticsearchIndexName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/*?"<>| ,#:`, r) {
+			return '_'
+		}
+		return unicode.ToLower(r)
+	}, name)
+	return strings.TrimLeft(name, "-_+")
+}
+
+type elasticsearchFlushConfig struct {
+	Messages, Bytes int `json:",omitempty"`
+}
+
+// proper JSON schema for elasticsearch sink config:
+//
+//	{
+//	  "Flush": {
+//	    "Messages": ...,
+//	    "Bytes":    ...,
+//	  },
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// The rows are buffered until a bulk request is full, the Flush thresholds are
+// reached or the changefeed flushes the sink.
+type elasticsearchSinkConfig struct {
+	Flush elasticsearchFlushConfig `json:",omitempty"`
+	Retry retryConfig              `json:",omitempty"`
+}
+
+func getElasticsearchSinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg elasticsearchSinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative",
+			changefeedbase.OptElasticsearchSinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+// elasticsearchError is an error returned by Elasticsearch, for a bulk
+// request or one of its actions.
+type elasticsearchError struct {
+	statusCode int
+	message    string
+}
+
+func (e *elasticsearchError) Error() string {
+	return fmt.Sprintf("elasticsearch returned %d %s: %s",
+		e.statusCode, http.StatusText(e.statusCode), e.message)
+}
+
+// retryable returns whether the request may succeed when it is sent again:
+// Elasticsearch rejects the requests with 429 when it is overloaded.
+func (e *elasticsearchError) retryable() bool {
+	return e.statusCode == http.StatusTooManyRequests || e.statusCode >= 500
+}
+
+func (e *elasticsearchError) httpStatusCode() int {
+	return e.statusCode
+}
+
+// elasticsearchAction is an action of a bulk request: the indexing or the
+// deletion of a document, as the lines of the request body.
+type elasticsearchAction struct {
+	lines []byte
+}
+
+// elasticsearchClient is a client of the bulk API of Elasticsearch or
+// OpenSearch, whose requests are authenticated with a user and a password or
+// an API key.
+type elasticsearchClient struct {
+	client  *httputil.Client
+	baseURL string
+	// authHeader is the Authorization header of the requests, if any.
+	authHeader string
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	// Items are the results of the actions, in the order of the actions, keyed
+	// by the type of the action.
+	Items []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends a bulk request, and returns the actions which failed and may
+// succeed if they are sent again, along with the error of the first of them.
+// The actions whose documents were updated at a later version succeed, since
+// the versions of the documents are their update timestamps.
+func (c *elasticsearchClient) bulk(
+	ctx context.Context, actions []*elasticsearchAction,
+) ([]*elasticsearchAction, error) {
+	var body bytes.Buffer
+	for _, a := range actions {
+		body.Write(a.lines)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/_bulk", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if c.authHeader != "" {
+		req.Header.Set(authorizationHeader, c.authHeader)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The connection errors are retried.
+		return actions, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return actions, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return actions, &elasticsearchError{statusCode: resp.StatusCode, message: strings.TrimSpace(string(respBody))}
+	}
+	var res elasticsearchBulkResponse
+	if err := json.Unmarshal(respBody, &res); err != nil {
+		return nil, errors.Wrap(err, "decoding elasticsearch bulk response")
+	}
+	if !res.Errors {
+		return nil, nil
+	}
+	if len(res.Items) != len(actions) {
+		return nil, errors.Errorf("elasticsearch returned %d results for %d actions",
+			len(res.Items), len(actions))
+	}
+
+	var failed []*elasticsearchAction
+	var firstErr *elasticsearchError
+	for i, item := range res.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300,
+				// The document was updated at a later version.
+				result.Status == http.StatusConflict,
+				// The deleted document does not exist.
+				result.Status == http.StatusNotFound && result.Error == nil:
+				continue
+			}
+			err := &elasticsearchError{statusCode: result.Status, message: string(result.Error)}
+			if !err.retryable() {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed = append(failed, actions[i])
+		}
+	}
+	if firstErr == nil {
+		return nil, nil
+	}
+	return failed, firstErr
+}
+
+// elasticsearchBatch is the bulk request of the buffered rows.
+type elasticsearchBatch struct {
+	actions []*elasticsearchAction
+	bytes   int
+
+	emitBytes int
+	alloc     kvevent.Alloc
+	emitTime  time.Time
+	mvcc      hlc.Timestamp
+}
+
+// elasticsearchSink indexes the rows of a changefeed into Elasticsearch or
+// OpenSearch with the bulk API. The rows of each table are indexed into the
+// index named after its topic, as documents whose ID is the primary key of
+// the row and whose source is the row, and the deleted rows delete their
+// documents. The indices are created by Elasticsearch when they do not exist,
+// unless it is configured otherwise.
+//
+// The versions of the documents are the update timestamps of the rows, with
+// the external_gte version type, so that Elasticsearch ignores the updates
+// older than the indexed documents: the rows emitted again after the
+// changefeed restarts do not overwrite the later updates of their documents.
+// The resolved timestamps are not emitted, since the indices only store
+// documents.
+type elasticsearchSink struct {
+	client     *elasticsearchClient
+	topicNamer *TopicNamer
+	cfg        elasticsearchSinkConfig
+	retryCfg   sinkRetryPolicy
+	metrics    metricsRecorder
+
+	batch elasticsearchBatch
+}
+
+var _ Sink = (*elasticsearchSink)(nil)
+
+func makeElasticsearchSink(
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.ElasticsearchSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	if encodingOpts.Format != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+	// The documents are the rows after their updates, which are in the wrapped
+	// envelopes.
+	if encodingOpts.Envelope != changefeedbase.OptEnvelopeWrapped {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf(`elasticsearch sink URI must specify a server`)
+	}
+
+	c := &elasticsearchClient{}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		c.authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	if apiKey := u.consumeParam(changefeedbase.SinkParamAPIKey); apiKey != "" {
+		if c.authHeader != "" {
+			return nil, errors.Errorf(`elasticsearch sink URI must not specify both a user and the %s parameter`,
+				changefeedbase.SinkParamAPIKey)
+		}
+		c.authHeader = "ApiKey " + apiKey
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicName := u.consumeParam(changefeedbase.SinkParamTopicName)
+	topicNamer, err := MakeTopicNamer(targets,
+		WithPrefix(topicPrefix), WithSingleName(topicName), WithSanitizeFn(elasticsearchIndexName))
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsEnabled bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := consumeTLSParams(&u, tlsEnabled)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown elasticsearch sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	scheme := "http"
+	if tlsEnabled {
+		scheme = "https"
+	}
+	c.baseURL = scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/")
+	c.client = httputil.NewClientWithTimeout(httputil.StandardHTTPTimeout)
+	c.client.Client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+
+	s := &elasticsearchSink{
+		client:     c,
+		topicNamer: topicNamer,
+		metrics:    mb(requiresResourceAccounting),
+	}
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getElasticsearchSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptElasticsearchSinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface.
+func (s *elasticsearchSink) Dial() error {
+	return nil
+}
+
+// elasticsearchActionMetadata is the first line of the actions of the bulk
+// requests.
+type elasticsearchActionMetadata struct {
+	Index       string `json:"_index"`
+	ID          string `json:"_id"`
+	Version     int64  `json:"version"`
+	VersionType string `json:"version_type"`
+}
+
+// EmitRow implements the Sink interface.
+func (s *elasticsearchSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	index, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	if len(key) > elasticsearchMaxIDBytes {
+		return errors.Errorf("key of %d bytes exceeds the maximum size of elasticsearch document IDs of %d bytes",
+			len(key), elasticsearchMaxIDBytes)
+	}
+	var envelope struct {
+		After json.RawMessage `json:"after"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return errors.Wrap(err, "decoding row")
+	}
+
+	actionType := "index"
+	if isJSONNull(envelope.After) {
+		actionType = "delete"
+	}
+	metadata, err := json.Marshal(map[string]elasticsearchActionMetadata{
+		actionType: {
+			Index:       index,
+			ID:          string(key),
+			Version:     updated.WallTime,
+			VersionType: "external_gte",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	lines := append(metadata, '\n')
+	if actionType == "index" {
+		// The source of the document must fit on a line.
+		var source bytes.Buffer
+		if err := json.Compact(&source, envelope.After); err != nil {
+			return errors.Wrap(err, "encoding row")
+		}
+		lines = append(append(lines, source.Bytes()...), '\n')
+	}
+	if len(lines) > elasticsearchMaxBytesPerRequest {
+		return errors.Errorf("row of %d bytes exceeds the maximum size of elasticsearch requests of %d bytes",
+			len(lines), elasticsearchMaxBytesPerRequest)
+	}
+
+	if s.batch.bytes+len(lines) > elasticsearchMaxBytesPerRequest {
+		if err := s.send(ctx); err != nil {
+			return err
+		}
+	}
+	b := &s.batch
+	if len(b.actions) == 0 {
+		b.emitTime = timeutil.Now()
+	}
+	b.actions = append(b.actions, &elasticsearchAction{lines: lines})
+	b.bytes += len(lines)
+	b.emitBytes += len(key) + len(value)
+	b.alloc.Merge(&alloc)
+	if b.mvcc.IsEmpty() || mvcc.Less(b.mvcc) {
+		b.mvcc = mvcc
+	}
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+
+	if (s.cfg.Flush.Messages > 0 && len(b.actions) >= s.cfg.Flush.Messages) ||
+		(s.cfg.Flush.Bytes > 0 && b.bytes >= s.cfg.Flush.Bytes) {
+		return s.send(ctx)
+	}
+	return nil
+}
+
+// send sends the bulk request of the buffered rows. The actions which fail
+// because Elasticsearch is overloaded are sent again with backoff, and the
+// other failed actions fail the request.
+func (s *elasticsearchSink) send(ctx context.Context) error {
+	b := &s.batch
+	if len(b.actions) == 0 {
+		return nil
+	}
+
+	pending := b.actions
+	var err error
+	for r := retry.StartWithCtx(ctx, s.retryCfg.Options); r.Next(); {
+		if err != nil {
+			s.metrics.recordInternalRetry(int64(len(pending)), false)
+		}
+		pending, err = s.client.bulk(ctx, pending)
+		if err == nil {
+			break
+		}
+		var esErr *elasticsearchError
+		if (errors.As(err, &esErr) && !esErr.retryable()) || !s.retryCfg.retryable(err) {
+			break
+		}
+	}
+	if err == nil && len(pending) > 0 {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return errors.Wrap(err, "sending elasticsearch bulk request")
+	}
+
+	s.metrics.recordEmittedBatch(b.emitTime, len(b.actions), b.mvcc, b.emitBytes, sinkDoesNotCompress)
+	b.alloc.Release(ctx)
+	*b = elasticsearchBatch{}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The indices only store
+// documents, so the resolved timestamps are not emitted: the rows are indexed
+// when the changefeed flushes the sink before it resolves a timestamp.
+func (s *elasticsearchSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+	return nil
+}
+
+// Flush implements the Sink interface.
+func (s *elasticsearchSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.send(ctx)
+}
+
+// Close implements the Sink interface.
+func (s *elasticsearchSink) Close() error {
+	s.batch.alloc.Release(context.Background())
+	s.batch = elasticsearchBatch{}
+	s.client.client.CloseIdleConnections()
+	return nil
+}
+
+// Topics gives the names of all indices that have been initialized
+// and will receive rows.
+func (s *elasticsearchSink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
@@ -0,0 +1,349 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeElasticsearchDoc struct {
+	version int64
+	source  string
+}
+
+// fakeElasticsearch implements the bulk API of Elasticsearch, with the
+// external_gte versions used by the elasticsearch sink.
+type fakeElasticsearch struct {
+	t  *testing.T
+	mu struct {
+		syncutil.Mutex
+		indices map[string]map[string]fakeElasticsearchDoc
+		auth    string
+		// rejectRequests is the number of the next bulk requests rejected with
+		// a 429, and rejectActions the number of the next actions rejected with
+		// a 429 or failErr.
+		rejectRequests, rejectActions int
+		failErr                       string
+	}
+}
+
+func newFakeElasticsearch(t *testing.T) *fakeElasticsearch {
+	f := &fakeElasticsearch{t: t}
+	f.mu.indices = make(map[string]map[string]fakeElasticsearchDoc)
+	return f
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != http.MethodPost || r.URL.Path != "/_bulk" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	f.mu.auth = r.Header.Get("Authorization")
+	if f.mu.rejectRequests > 0 {
+		f.mu.rejectRequests--
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	type result struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	}
+	var res struct {
+		Errors bool                `json:"errors"`
+		Items  []map[string]result `json:"items"`
+	}
+	lines := bufio.NewScanner(r.Body)
+	for lines.Scan() {
+		var action map[string]struct {
+			Index       string `json:"_index"`
+			ID          string `json:"_id"`
+			Version     int64  `json:"version"`
+			VersionType string `json:"version_type"`
+		}
+		require.NoError(f.t, json.Unmarshal(lines.Bytes(), &action))
+		for actionType, meta := range action {
+			require.Equal(f.t, "external_gte", meta.VersionType)
+			var source string
+			if actionType == "index" {
+				require.True(f.t, lines.Scan())
+				source = lines.Text()
+			}
+			if f.mu.rejectActions > 0 {
+				f.mu.rejectActions--
+				res.Errors = true
+				if f.mu.failErr != "" {
+					res.Items = append(res.Items, map[string]result{actionType: {
+						Status: http.StatusBadRequest, Error: json.RawMessage(f.mu.failErr)}})
+				} else {
+					res.Items = append(res.Items, map[string]result{actionType: {
+						Status: http.StatusTooManyRequests, Error: json.RawMessage(`{"type":"es_rejected_execution_exception"}`)}})
+				}
+				continue
+			}
+
+			docs, ok := f.mu.indices[meta.Index]
+			if !ok {
+				docs = make(map[string]fakeElasticsearchDoc)
+				f.mu.indices[meta.Index] = docs
+			}
+			doc, exists := docs[meta.ID]
+			switch {
+			case exists && meta.Version < doc.version:
+				res.Errors = true
+				res.Items = append(res.Items, map[string]result{actionType: {
+					Status: http.StatusConflict, Error: json.RawMessage(`{"type":"version_conflict_engine_exception"}`)}})
+			case actionType == "delete":
+				// The deletions are not versioned by the fake.
+				delete(docs, meta.ID)
+				status := http.StatusOK
+				if !exists {
+					res.Errors = true
+					status = http.StatusNotFound
+				}
+				res.Items = append(res.Items, map[string]result{actionType: {Status: status}})
+			default:
+				docs[meta.ID] = fakeElasticsearchDoc{version: meta.Version, source: source}
+				res.Items = append(res.Items, map[string]result{actionType: {Status: http.StatusCreated}})
+			}
+		}
+	}
+	require.NoError(f.t, json.NewEncoder(w).Encode(res))
+}
+
+// docs returns the documents of an index, as their ID followed by their
+// source.
+func (f *fakeElasticsearch) docs(index string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	docs := make(map[string]string)
+	for id, doc := range f.mu.indices[index] {
+		docs[id] = doc.source
+	}
+	return docs
+}
+
+func (f *fakeElasticsearch) setRejections(requests, actions int, failErr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.rejectRequests, f.mu.rejectActions, f.mu.failErr = requests, actions, failErr
+}
+
+func (f *fakeElasticsearch) auth() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mu.auth
+}
+
+func makeTestElasticsearchSink(
+	t *testing.T, uri string, opts map[string]string, targetNames ...string,
+) (*elasticsearchSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	statementOpts := changefeedbase.MakeStatementOptions(opts)
+	encodingOpts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	elasticsearchOpts, err := statementOpts.GetElasticsearchSinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeElasticsearchSink(sinkURL{URL: u}, encodingOpts,
+		elasticsearchOpts, makeChangefeedTargets(targetNames...),
+		nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*elasticsearchSink), nil
+}
+
+func TestElasticsearchSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		opts   map[string]string
+		topics []string
+		err    string
+	}{
+		{
+			name:   "defaults",
+			uri:    `elasticsearch://localhost:9200`,
+			topics: []string{"t"},
+		},
+		{
+			name:   "opensearch",
+			uri:    `opensearch://localhost:9200`,
+			topics: []string{"t"},
+		},
+		{
+			name:   "topic prefix",
+			uri:    `elasticsearch://localhost:9200?topic_prefix=_CRDB:`,
+			topics: []string{"crdb_t"},
+		},
+		{
+			name:   "topic name",
+			uri:    `elasticsearch://localhost:9200?topic_name=all`,
+			topics: []string{"all"},
+		},
+		{
+			name: "missing server",
+			uri:  `elasticsearch:///`,
+			err:  `elasticsearch sink URI must specify a server`,
+		},
+		{
+			name: "user and api key",
+			uri:  `elasticsearch://u:p@localhost:9200?api_key=abc`,
+			err:  `elasticsearch sink URI must not specify both a user and the api_key parameter`,
+		},
+		{
+			name: "ca cert without tls",
+			uri:  `elasticsearch://localhost:9200?ca_cert=Zm9v`,
+			err:  `ca_cert requires tls_enabled=true`,
+		},
+		{
+			name: "unknown param",
+			uri:  `elasticsearch://localhost:9200?foo=bar`,
+			err:  `unknown elasticsearch sink query parameters: foo`,
+		},
+		{
+			name: "invalid config",
+			uri:  `elasticsearch://localhost:9200`,
+			opts: map[string]string{changefeedbase.OptElasticsearchSinkConfig: `{"Flush":{"Messages":-1}}`},
+			err:  `all config values must be non-negative`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestElasticsearchSink(t, tc.uri, tc.opts, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+			require.NoError(t, s.Close())
+		})
+	}
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	fake := newFakeElasticsearch(t)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	uri := fmt.Sprintf(`elasticsearch://%s`, server.Listener.Addr())
+	fastRetries := map[string]string{
+		changefeedbase.OptElasticsearchSinkConfig: `{"Retry":{"Backoff":"1ms"}}`,
+	}
+
+	var pool testAllocPool
+	emit := func(s *elasticsearchSink, topicName, key string, updated int64, after string) error {
+		return s.EmitRow(ctx, topic(topicName), []byte(key),
+			[]byte(fmt.Sprintf(`{"after": %s, "key": %s}`, after, key)),
+			hlc.Timestamp{WallTime: updated}, zeroTS, pool.alloc())
+	}
+
+	t.Run("emit", func(t *testing.T) {
+		s, err := makeTestElasticsearchSink(t, `elasticsearch://elastic:secret@`+server.Listener.Addr().String(),
+			nil, "t1", "T2")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, "t1", `[1]`, 1, `{"a": 1, "b": "x"}`))
+		require.NoError(t, emit(s, "t1", `[2]`, 1, `{"a": 2}`))
+		require.NoError(t, emit(s, "T2", `[1, "k"]`, 1, `{"c": 1}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, map[string]string{`[1]`: `{"a":1,"b":"x"}`, `[2]`: `{"a":2}`}, fake.docs("t1"))
+		require.Equal(t, map[string]string{`[1, "k"]`: `{"c":1}`}, fake.docs("t2"))
+		require.Equal(t, "Basic ZWxhc3RpYzpzZWNyZXQ=", fake.auth())
+		require.EqualValues(t, 0, pool.used())
+
+		// The deleted rows delete their documents, and the updates older than
+		// the documents are ignored.
+		require.NoError(t, emit(s, "t1", `[1]`, 3, `null`))
+		require.NoError(t, emit(s, "t1", `[2]`, 3, `{"a": 3}`))
+		require.NoError(t, emit(s, "t1", `[2]`, 2, `{"a": 2}`))
+		require.NoError(t, emit(s, "t1", `[3]`, 2, `null`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, map[string]string{`[2]`: `{"a":3}`}, fake.docs("t1"))
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("flush thresholds", func(t *testing.T) {
+		s, err := makeTestElasticsearchSink(t, uri+`?api_key=a2V5`,
+			map[string]string{changefeedbase.OptElasticsearchSinkConfig: `{"Flush":{"Messages":2}}`}, "t3")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, "t3", `[1]`, 1, `{"a": 1}`))
+		require.Empty(t, fake.docs("t3"))
+		require.NoError(t, emit(s, "t3", `[2]`, 1, `{"a": 2}`))
+		require.Len(t, fake.docs("t3"), 2)
+		require.Equal(t, "ApiKey a2V5", fake.auth())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("rejected requests are retried", func(t *testing.T) {
+		s, err := makeTestElasticsearchSink(t, uri, fastRetries, "t4")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		fake.setRejections(2, 0, "")
+		require.NoError(t, emit(s, "t4", `[1]`, 1, `{"a": 1}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, map[string]string{`[1]`: `{"a":1}`}, fake.docs("t4"))
+
+		// Only the rejected actions are sent again.
+		fake.setRejections(0, 1, "")
+		require.NoError(t, emit(s, "t4", `[2]`, 2, `{"a": 2}`))
+		require.NoError(t, emit(s, "t4", `[3]`, 2, `{"a": 3}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, map[string]string{`[1]`: `{"a":1}`, `[2]`: `{"a":2}`, `[3]`: `{"a":3}`},
+			fake.docs("t4"))
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("failed actions fail the flush", func(t *testing.T) {
+		s, err := makeTestElasticsearchSink(t, uri, fastRetries, "t5")
+		require.NoError(t, err)
+
+		fake.setRejections(0, 1, `{"type":"mapper_parsing_exception"}`)
+		require.NoError(t, emit(s, "t5", `[1]`, 1, `{"a": 1}`))
+		err = s.Flush(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `elasticsearch returned 400 Bad Request: {"type":"mapper_parsing_exception"}`)
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+}
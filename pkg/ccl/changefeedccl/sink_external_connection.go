@@ -40,7 +40,27 @@ func makeExternalConnectionSink(
 	}
 
 	externalConnectionName := u.Host
+	loadURI := func(ctx context.Context) (string, error) {
+		return loadExternalConnectionSinkURI(ctx, db, ie, externalConnectionName)
+	}
+	uri, err := loadURI(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the external connection URI in the `feedCfg` with the URI of the
+	// underlying resource. The sink reloads its certificates from the external
+	// connection, so that they can be rotated without restarting the
+	// changefeed.
+	feedCfg.SinkURI = uri
+	return getSinkWithURISource(ctx, serverCfg, feedCfg, timestampOracle, user, jobID, m, loadURI)
+}
 
+// loadExternalConnectionSinkURI returns the URI of the sink represented by the
+// external connection.
+func loadExternalConnectionSinkURI(
+	ctx context.Context, db *kv.DB, ie sqlutil.InternalExecutor, externalConnectionName string,
+) (string, error) {
 	// Retrieve the external connection object from the system table.
 	var ec externalconn.ExternalConnection
 	if err := db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
@@ -48,18 +68,14 @@ func makeExternalConnectionSink(
 		ec, err = externalconn.LoadExternalConnection(ctx, externalConnectionName, ie, txn)
 		return err
 	}); err != nil {
-		return nil, errors.Wrap(err, "failed to load external connection object")
+		return "", errors.Wrap(err, "failed to load external connection object")
 	}
 
-	// Construct a Sink handle for the underlying resource represented by the
-	// external connection object.
+	// Only the resources with a URI can be the sink of a changefeed.
 	switch d := ec.ConnectionProto().Details.(type) {
 	case *connectionpb.ConnectionDetails_SimpleURI:
-		// Replace the external connection URI in the `feedCfg` with the URI of the
-		// underlying resource.
-		feedCfg.SinkURI = d.SimpleURI.URI
-		return getSink(ctx, serverCfg, feedCfg, timestampOracle, user, jobID, m)
+		return d.SimpleURI.URI, nil
 	default:
-		return nil, errors.Newf("cannot connect to %T; unsupported resource for a Sink connection", d)
+		return "", errors.Newf("cannot connect to %T; unsupported resource for a Sink connection", d)
 	}
 }
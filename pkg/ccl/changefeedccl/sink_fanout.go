@@ -0,0 +1,165 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// fanOutSink emits the rows and resolved timestamps of a changefeed to each
+// of its sinks: the sink the changefeed was created INTO, followed by its
+// additional sinks (see changefeedbase.OptAdditionalSinks). A flush waits
+// for all of the sinks, so that the resolved timestamps only advance once the
+// rows were delivered to every sink. The errors of each sink name the sink
+// which failed.
+type fanOutSink struct {
+	sinks []Sink
+	names []string
+}
+
+var _ Sink = (*fanOutSink)(nil)
+var _ DeadLetterEventSink = (*fanOutSink)(nil)
+
+// makeFanOutSink makes the sinks of a changefeed with additional sinks.
+func makeFanOutSink(
+	ctx context.Context,
+	serverCfg *execinfra.ServerConfig,
+	feedCfg jobspb.ChangefeedDetails,
+	additionalSinks []string,
+	timestampOracle timestampLowerBoundOracle,
+	user username.SQLUsername,
+	jobID jobspb.JobID,
+	m metricsRecorder,
+) (_ Sink, err error) {
+	opts := make(map[string]string, len(feedCfg.Opts))
+	for k, v := range feedCfg.Opts {
+		if k != changefeedbase.OptAdditionalSinks {
+			opts[k] = v
+		}
+	}
+
+	s := &fanOutSink{}
+	defer func() {
+		if err != nil {
+			_ = s.Close()
+		}
+	}()
+	for i, uri := range append([]string{feedCfg.SinkURI}, additionalSinks...) {
+		name := fmt.Sprintf("sink %d", i)
+		if u, err := url.Parse(uri); err == nil {
+			name = fmt.Sprintf("%s (%s)", name, u.Scheme)
+		}
+		sinkCfg := feedCfg
+		sinkCfg.SinkURI = uri
+		sinkCfg.Opts = opts
+		sink, err := getSink(ctx, serverCfg, sinkCfg, timestampOracle, user, jobID, m)
+		if err != nil {
+			return nil, errors.Wrapf(changefeedbase.MaybeStripRetryableErrorMarker(err), "%s", name)
+		}
+		s.sinks = append(s.sinks, sink)
+		s.names = append(s.names, name)
+	}
+	return s, nil
+}
+
+// fanOutSinks returns the sinks to which the specified sink emits.
+func fanOutSinks(sink Sink) []Sink {
+	if s, ok := sink.(*fanOutSink); ok {
+		return s.sinks
+	}
+	return []Sink{sink}
+}
+
+func (s *fanOutSink) wrapErr(i int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return errors.Wrapf(err, "%s", s.names[i])
+}
+
+// Dial implements the Sink interface. The sinks are dialed as they are made.
+func (s *fanOutSink) Dial() error {
+	return nil
+}
+
+// EmitRow implements the Sink interface. The memory of the row is split
+// between the sinks, so that each of them releases its share once it delivers
+// the row, and the rows buffered by any of the sinks remain accounted for.
+func (s *fanOutSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	allocs := alloc.Split(len(s.sinks))
+	for i := len(s.sinks) - 1; i >= 0; i-- {
+		if err := s.sinks[i].EmitRow(ctx, topic, key, value, updated, mvcc, allocs[i]); err != nil {
+			for j := range allocs[:i] {
+				allocs[j].Release(ctx)
+			}
+			return s.wrapErr(i, err)
+		}
+	}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *fanOutSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	for i, sink := range s.sinks {
+		if err := sink.EmitResolvedTimestamp(ctx, encoder, resolved); err != nil {
+			return s.wrapErr(i, err)
+		}
+	}
+	return nil
+}
+
+// Flush implements the Sink interface. The sinks are flushed concurrently, so
+// that a flush takes as long as the slowest of them.
+func (s *fanOutSink) Flush(ctx context.Context) error {
+	g := ctxgroup.WithContext(ctx)
+	for i := range s.sinks {
+		i := i
+		g.GoCtx(func(ctx context.Context) error {
+			return s.wrapErr(i, s.sinks[i].Flush(ctx))
+		})
+	}
+	return g.Wait()
+}
+
+// Close implements the Sink interface.
+func (s *fanOutSink) Close() error {
+	var err error
+	for i, sink := range s.sinks {
+		err = errors.CombineErrors(err, s.wrapErr(i, sink.Close()))
+	}
+	return err
+}
+
+// SetDeadLetterQueue implements the DeadLetterEventSink interface.
+func (s *fanOutSink) SetDeadLetterQueue(q *deadLetterQueue) {
+	for _, sink := range s.sinks {
+		if dl, ok := sink.(DeadLetterEventSink); ok {
+			dl.SetDeadLetterQueue(q)
+		}
+	}
+}
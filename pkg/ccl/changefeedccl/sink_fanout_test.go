@@ -0,0 +1,106 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fanOutTestSink records the rows and resolved timestamps emitted to it.
+type fanOutTestSink struct {
+	rows     []string
+	bytes    int64
+	resolved []hlc.Timestamp
+	flushes  int
+	closed   bool
+	err      error
+}
+
+var _ Sink = (*fanOutTestSink)(nil)
+
+func (s *fanOutTestSink) Dial() error { return nil }
+
+func (s *fanOutTestSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fanOutTestSink) EmitRow(
+	ctx context.Context, _ TopicDescriptor, key, value []byte, _, _ hlc.Timestamp, alloc kvevent.Alloc,
+) error {
+	s.rows = append(s.rows, string(key)+"="+string(value))
+	s.bytes += alloc.Bytes()
+	alloc.Release(ctx)
+	return s.err
+}
+
+func (s *fanOutTestSink) EmitResolvedTimestamp(
+	_ context.Context, _ Encoder, resolved hlc.Timestamp,
+) error {
+	s.resolved = append(s.resolved, resolved)
+	return s.err
+}
+
+func (s *fanOutTestSink) Flush(context.Context) error {
+	s.flushes++
+	return s.err
+}
+
+func TestFanOutSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	primary, archive := &fanOutTestSink{}, &fanOutTestSink{}
+	s := &fanOutSink{
+		sinks: []Sink{primary, archive},
+		names: []string{"sink 0 (kafka)", "sink 1 (s3)"},
+	}
+	require.Equal(t, []Sink{primary, archive}, fanOutSinks(s))
+	require.Equal(t, []Sink{primary}, fanOutSinks(primary))
+
+	// The memory of the rows is split between the sinks.
+	pool := &testAllocPool{n: 10}
+	ts := hlc.Timestamp{WallTime: 1}
+	require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(`k`), []byte(`v`), ts, ts,
+		kvevent.TestingMakeAlloc(10, pool)))
+	require.Equal(t, int64(5), primary.bytes)
+	require.Equal(t, int64(5), archive.bytes)
+	require.Equal(t, int64(0), pool.used())
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, nil, ts))
+	require.NoError(t, s.Flush(ctx))
+	for _, sink := range []*fanOutTestSink{primary, archive} {
+		require.Equal(t, []string{`k=v`}, sink.rows)
+		require.Equal(t, []hlc.Timestamp{ts}, sink.resolved)
+		require.Equal(t, 1, sink.flushes)
+	}
+
+	// The errors name the sink which failed, and the memory of the rows which
+	// were not emitted to the other sinks is released.
+	archive.err = errors.New("boom")
+	pool.n = 10
+	require.EqualError(t, s.EmitRow(ctx, topic("t"), []byte(`k`), []byte(`v`), ts, ts,
+		kvevent.TestingMakeAlloc(10, pool)), `sink 1 (s3): boom`)
+	require.Equal(t, int64(5), primary.bytes)
+	require.Equal(t, int64(0), pool.used())
+	require.EqualError(t, s.Flush(ctx), `sink 1 (s3): boom`)
+	require.Equal(t, 2, primary.flushes)
+
+	require.NoError(t, s.Close())
+	require.True(t, primary.closed)
+	require.True(t, archive.closed)
+}
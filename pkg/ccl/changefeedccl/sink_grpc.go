@@ -0,0 +1,385 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcsinkpb"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// proper JSON schema for grpc sink config:
+//
+//	{
+//	  "AckTimeout": ...,
+//	  "MaxInflight": ...,
+//	}
+//
+// AckTimeout is how long the sink waits for the consumer to acknowledge a
+// request before failing, and MaxInflight is the number of requests which may
+// await their acknowledgements before the sink waits for them.
+type grpcSinkConfig struct {
+	AckTimeout  jsonDuration `json:",omitempty"`
+	MaxInflight int          `json:",omitempty"`
+}
+
+func getGRPCSinkConfig(jsonStr changefeedbase.SinkSpecificJSONConfig) (grpcSinkConfig, error) {
+	cfg := grpcSinkConfig{
+		AckTimeout:  jsonDuration(30 * time.Second),
+		MaxInflight: 1000,
+	}
+	if jsonStr != `` {
+		if err := json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+	if cfg.AckTimeout <= 0 || cfg.MaxInflight <= 0 {
+		return cfg, errors.Errorf("invalid option value %s, all config values must be positive",
+			changefeedbase.OptGRPCSinkConfig)
+	}
+	return cfg, nil
+}
+
+// grpcInflight is a request sent by the gRPC sink which was not acknowledged
+// yet.
+type grpcInflight struct {
+	seq uint64
+	// alloc, mvcc, size and updateMetrics are set for the requests of rows.
+	alloc         kvevent.Alloc
+	mvcc          hlc.Timestamp
+	size          int
+	updateMetrics recordOneMessageCallback
+}
+
+// grpcSink streams the rows and the resolved timestamps to a consumer
+// implementing the ChangefeedSink service of the cdcsinkpb package, which
+// documents the protocol.
+//
+// The requests are sent without waiting for the acknowledgements of the
+// previous ones, and the sink waits for all of them to be acknowledged when it
+// is flushed and before it sends a resolved timestamp, so that the resolved
+// timestamps of the changefeed only advance once the consumer has processed
+// the rows below them.
+type grpcSink struct {
+	addr       string
+	creds      credentials.TransportCredentials
+	topicNamer *TopicNamer
+	cfg        grpcSinkConfig
+	metrics    metricsRecorder
+
+	conn   *grpc.ClientConn
+	stream cdcsinkpb.ChangefeedSink_EmitClient
+	// cancel cancels the stream, and recvDone is closed once the goroutine
+	// receiving the acknowledgements exited.
+	cancel   context.CancelFunc
+	recvDone chan struct{}
+	// ackCh is notified when requests are acknowledged.
+	ackCh chan struct{}
+	mu    struct {
+		syncutil.Mutex
+		// lastSeq is the sequence number of the last request sent on the
+		// stream.
+		lastSeq uint64
+		// inflight are the requests which await their acknowledgements, in
+		// the order in which they were sent.
+		inflight []grpcInflight
+		// err is set once the stream failed or the consumer reported an
+		// error.
+		err error
+	}
+}
+
+var _ Sink = (*grpcSink)(nil)
+
+func makeGRPCSink(
+	u sinkURL,
+	opts changefeedbase.GRPCSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, errors.Errorf(`grpc sink URI must specify a host and a port`)
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicName := u.consumeParam(changefeedbase.SinkParamTopicName)
+	topicNamer, err := MakeTopicNamer(targets, WithPrefix(topicPrefix), WithSingleName(topicName))
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsEnabled bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := consumeTLSParams(&u, tlsEnabled)
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown grpc sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	cfg, err := getGRPCSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptGRPCSinkConfig)
+	}
+
+	return &grpcSink{
+		addr:       u.Host,
+		creds:      creds,
+		topicNamer: topicNamer,
+		cfg:        cfg,
+		metrics:    mb(requiresResourceAccounting),
+		ackCh:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Dial implements the Sink interface.
+func (s *grpcSink) Dial() error {
+	conn, err := grpc.Dial(s.addr, grpc.WithTransportCredentials(s.creds))
+	if err != nil {
+		return errors.Wrapf(err, "connecting to grpc sink %s", s.addr)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := cdcsinkpb.NewChangefeedSinkClient(conn).Emit(ctx)
+	if err != nil {
+		cancel()
+		_ = conn.Close()
+		return errors.Wrapf(err, "opening stream to grpc sink %s", s.addr)
+	}
+	s.conn, s.stream, s.cancel = conn, stream, cancel
+	s.recvDone = make(chan struct{})
+	go s.recvAcks()
+	return nil
+}
+
+// recvAcks receives the acknowledgements of the consumer until the stream
+// fails.
+func (s *grpcSink) recvAcks() {
+	defer close(s.recvDone)
+	for {
+		resp, err := s.stream.Recv()
+		if err == nil {
+			err = s.ack(resp)
+		}
+		if err != nil {
+			s.mu.Lock()
+			if s.mu.err == nil {
+				s.mu.err = errors.Wrap(err, "grpc sink stream failed")
+			}
+			s.mu.Unlock()
+			s.notifyAck()
+			return
+		}
+	}
+}
+
+// ack handles the acknowledgement of the requests up to a sequence number.
+func (s *grpcSink) ack(resp *cdcsinkpb.EmitResponse) error {
+	if resp.Error != "" {
+		return errors.Newf("consumer failed to process request %d: %s", resp.Seq, resp.Error)
+	}
+
+	s.mu.Lock()
+	if resp.Seq > s.mu.lastSeq {
+		s.mu.Unlock()
+		return errors.Newf("consumer acknowledged request %d which was not sent", resp.Seq)
+	}
+	n := 0
+	for n < len(s.mu.inflight) && s.mu.inflight[n].seq <= resp.Seq {
+		n++
+	}
+	acked := s.mu.inflight[:n]
+	s.mu.inflight = s.mu.inflight[n:]
+	s.mu.Unlock()
+
+	for _, r := range acked {
+		if r.updateMetrics != nil {
+			r.updateMetrics(r.mvcc, r.size, sinkDoesNotCompress)
+		}
+		r.alloc.Release(context.Background())
+	}
+	s.notifyAck()
+	return nil
+}
+
+func (s *grpcSink) notifyAck() {
+	select {
+	case s.ackCh <- struct{}{}:
+	default:
+	}
+}
+
+// send sends a request, which awaits its acknowledgement until the consumer
+// acknowledges it or a later request.
+func (s *grpcSink) send(req *cdcsinkpb.EmitRequest, inflight grpcInflight) error {
+	s.mu.Lock()
+	if err := s.mu.err; err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.lastSeq++
+	req.Seq = s.mu.lastSeq
+	inflight.seq = s.mu.lastSeq
+	s.mu.inflight = append(s.mu.inflight, inflight)
+	s.mu.Unlock()
+
+	if err := s.stream.Send(req); err != nil {
+		return errors.Wrap(err, "grpc sink stream failed")
+	}
+	return nil
+}
+
+// waitForAcks waits until at most maxInflight requests await their
+// acknowledgements, or returns the error of the stream.
+func (s *grpcSink) waitForAcks(ctx context.Context, maxInflight int) error {
+	timeout := time.Duration(s.cfg.AckTimeout)
+	var timer timeutil.Timer
+	defer timer.Stop()
+	timer.Reset(timeout)
+	for {
+		s.mu.Lock()
+		inflight, err := len(s.mu.inflight), s.mu.err
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if inflight <= maxInflight {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ackCh:
+			timer.Reset(timeout)
+		case <-timer.C:
+			timer.Read = true
+			return errors.Errorf("timed out after %s waiting for grpc sink consumer to acknowledge %d requests",
+				timeout, inflight)
+		}
+	}
+}
+
+// EmitRow implements the Sink interface.
+func (s *grpcSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	topicName, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	inflight, err := len(s.mu.inflight), s.mu.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if inflight >= s.cfg.MaxInflight {
+		if err := s.waitForAcks(ctx, s.cfg.MaxInflight-1); err != nil {
+			return err
+		}
+	}
+
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+	return s.send(&cdcsinkpb.EmitRequest{
+		Row: &cdcsinkpb.Row{
+			Topic:         topicName,
+			Key:           key,
+			Value:         value,
+			Updated:       updated.AsOfSystemTime(),
+			MVCCTimestamp: mvcc.AsOfSystemTime(),
+		},
+	}, grpcInflight{
+		alloc:         alloc,
+		mvcc:          mvcc,
+		size:          len(key) + len(value),
+		updateMetrics: s.metrics.recordOneMessage(),
+	})
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved timestamp
+// is only sent once all the rows sent before were acknowledged, and the sink
+// waits for its acknowledgement.
+func (s *grpcSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return errors.Wrap(err, "encoding resolved timestamp")
+	}
+	if err := s.waitForAcks(ctx, 0); err != nil {
+		return err
+	}
+	if err := s.send(&cdcsinkpb.EmitRequest{
+		Resolved: &cdcsinkpb.Resolved{
+			Timestamp: resolved.AsOfSystemTime(),
+			Payload:   payload,
+		},
+	}, grpcInflight{}); err != nil {
+		return err
+	}
+	return s.waitForAcks(ctx, 0)
+}
+
+// Flush implements the Sink interface.
+func (s *grpcSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.waitForAcks(ctx, 0)
+}
+
+// Close implements the Sink interface.
+func (s *grpcSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.cancel()
+	<-s.recvDone
+	err := s.conn.Close()
+
+	s.mu.Lock()
+	inflight := s.mu.inflight
+	s.mu.inflight = nil
+	s.mu.Unlock()
+	for _, r := range inflight {
+		r.alloc.Release(context.Background())
+	}
+	return err
+}
+
+// Topics gives the names of all topics that have been initialized
+// and will receive resolved timestamps.
+func (s *grpcSink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
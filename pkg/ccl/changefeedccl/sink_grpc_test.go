@@ -0,0 +1,246 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcsinkpb"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeGRPCConsumer implements the ChangefeedSink service. It acknowledges each
+// request once it received it, except the rows whose value is "fail", for
+// which it reports an error.
+type fakeGRPCConsumer struct {
+	mu struct {
+		syncutil.Mutex
+		// received are the rows, as topic:value, and the resolved timestamps,
+		// as resolved:timestamp:payload, in the order in which they were
+		// received.
+		received []string
+		// holdAcks makes the consumer not acknowledge the requests.
+		holdAcks bool
+	}
+}
+
+var _ cdcsinkpb.ChangefeedSinkServer = (*fakeGRPCConsumer)(nil)
+
+// Emit implements the ChangefeedSinkServer interface.
+func (c *fakeGRPCConsumer) Emit(stream cdcsinkpb.ChangefeedSink_EmitServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		resp := &cdcsinkpb.EmitResponse{Seq: req.Seq}
+		c.mu.Lock()
+		switch {
+		case req.Row != nil && string(req.Row.Value) == "fail":
+			resp.Error = "boom"
+		case req.Row != nil:
+			c.mu.received = append(c.mu.received, req.Row.Topic+":"+string(req.Row.Value))
+		case req.Resolved != nil:
+			c.mu.received = append(c.mu.received,
+				"resolved:"+req.Resolved.Timestamp+":"+string(req.Resolved.Payload))
+		}
+		holdAcks := c.mu.holdAcks
+		c.mu.Unlock()
+		if holdAcks {
+			continue
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *fakeGRPCConsumer) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.mu.received...)
+}
+
+func (c *fakeGRPCConsumer) setHoldAcks(hold bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.holdAcks = hold
+}
+
+func makeTestGRPCSink(
+	t *testing.T, uri string, opts map[string]string, targetNames ...string,
+) (*grpcSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	statementOpts := changefeedbase.MakeStatementOptions(opts)
+	s, err := makeGRPCSink(sinkURL{URL: u}, statementOpts.GetGRPCSinkOptions(),
+		makeChangefeedTargets(targetNames...), nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*grpcSink), nil
+}
+
+func TestGRPCSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		opts   map[string]string
+		topics []string
+		err    string
+	}{
+		{
+			name:   "defaults",
+			uri:    `grpc://localhost:26300`,
+			topics: []string{"t"},
+		},
+		{
+			name:   "topic prefix",
+			uri:    `grpc://localhost:26300?topic_prefix=p_&tls_enabled=true`,
+			topics: []string{"p_t"},
+		},
+		{
+			name: "missing port",
+			uri:  `grpc://localhost`,
+			err:  `grpc sink URI must specify a host and a port`,
+		},
+		{
+			name: "unknown param",
+			uri:  `grpc://localhost:26300?foo=bar`,
+			err:  `unknown grpc sink query parameters: foo`,
+		},
+		{
+			name: "ca cert without tls",
+			uri:  `grpc://localhost:26300?ca_cert=Zm9v`,
+			err:  `ca_cert requires tls_enabled=true`,
+		},
+		{
+			name: "invalid config",
+			uri:  `grpc://localhost:26300`,
+			opts: map[string]string{changefeedbase.OptGRPCSinkConfig: `{"MaxInflight":-1}`},
+			err:  `all config values must be positive`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestGRPCSink(t, tc.uri, tc.opts, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+		})
+	}
+}
+
+func TestGRPCSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	consumer := &fakeGRPCConsumer{}
+	server := grpc.NewServer()
+	cdcsinkpb.RegisterChangefeedSinkServer(server, consumer)
+	go func() { _ = server.Serve(ln) }()
+	defer server.Stop()
+	uri := `grpc://` + ln.Addr().String()
+
+	var pool testAllocPool
+	emit := func(s *grpcSink, topicName, value string) error {
+		return s.EmitRow(ctx, topic(topicName), []byte(`[1]`), []byte(value), zeroTS, zeroTS, pool.alloc())
+	}
+	opts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	enc, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+	require.NoError(t, err)
+	// newReceived returns the requests received by the consumer since the
+	// previous call.
+	var seen int
+	newReceived := func() []string {
+		received := consumer.received()
+		defer func() { seen = len(received) }()
+		return received[seen:]
+	}
+
+	t.Run("emit", func(t *testing.T) {
+		s, err := makeTestGRPCSink(t, uri, nil, "t1", "t2")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, "t1", "a"))
+		require.NoError(t, emit(s, "t2", "b"))
+		require.NoError(t, emit(s, "t1", "c"))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{"t1:a", "t2:b", "t1:c"}, newReceived())
+		require.EqualValues(t, 0, pool.used())
+
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 2}))
+		require.Equal(t, []string{`resolved:2.0000000000:{"resolved":"2.0000000000"}`}, newReceived())
+	})
+
+	t.Run("consumer error", func(t *testing.T) {
+		s, err := makeTestGRPCSink(t, uri, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "fail"))
+		err = s.Flush(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `consumer failed to process request 1: boom`)
+		// The sink fails once the consumer reported an error.
+		require.Error(t, s.EmitRow(ctx, topic("t1"), []byte(`[1]`), []byte("a"), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+		require.Empty(t, newReceived())
+	})
+
+	t.Run("acks gate resolved timestamps", func(t *testing.T) {
+		consumer.setHoldAcks(true)
+		defer consumer.setHoldAcks(false)
+		s, err := makeTestGRPCSink(t, uri,
+			map[string]string{changefeedbase.OptGRPCSinkConfig: `{"AckTimeout":"10ms"}`}, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "a"))
+		err = s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 3})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `timed out after 10ms waiting for grpc sink consumer to acknowledge 1 requests`)
+		// The resolved timestamp was not sent, since the row before it was not
+		// acknowledged.
+		testutils.SucceedsSoon(t, func() error {
+			if len(consumer.received()) == seen {
+				return errors.New("row not received yet")
+			}
+			return nil
+		})
+		require.Equal(t, []string{"t1:a"}, newReceived())
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+}
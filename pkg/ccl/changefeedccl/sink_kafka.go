@@ -15,6 +15,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,6 +26,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
@@ -33,6 +37,8 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/logtags"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
 // maybeLocker is a wrapper around a Locker that allows for successive Unlocks
@@ -60,6 +66,14 @@ type kafkaSinkKnobs struct {
 	OverrideClientInit              func(config *sarama.Config) (kafkaClient, error)
 	OverrideAsyncProducerFromClient func(kafkaClient) (sarama.AsyncProducer, error)
 	OverrideSyncProducerFromClient  func(kafkaClient) (sarama.SyncProducer, error)
+	// OverrideTransactionalClientInit overrides the client of the exactly-once
+	// kafka sink.
+	OverrideTransactionalClientInit func(config *sarama.Config) (kafkaTransactionalClient, error)
+	// OverrideClusterAdminInit overrides the admin client with which the kafka
+	// sinks create their topics.
+	OverrideClusterAdminInit func(config *sarama.Config) (kafkaClusterAdmin, error)
+	// OverrideProduceClientInit overrides the client of the kafka-v2 sink.
+	OverrideProduceClientInit func(config *sarama.Config) (kafkaProduceClient, error)
 }
 
 var _ sarama.StdLogger = (*kafkaLogAdapter)(nil)
@@ -112,6 +126,33 @@ type kafkaSink struct {
 	producer       sarama.AsyncProducer
 	topics         *TopicNamer
 
+	// topicCfgs are the configurations of the topics whose configuration is
+	// overridden in the kafka_sink_config option, keyed by topic name. The
+	// topics whose overrides apply the same settings to the producer share a
+	// configuration, and their messages are emitted with the topicProducers of
+	// their configuration.
+	topicCfgs      map[string]*sarama.Config
+	topicProducers map[*sarama.Config]kafkaTopicProducer
+
+	// topicCreator, if set, creates the topics of the sink which do not exist
+	// yet.
+	topicCreator *kafkaTopicCreator
+
+	// settings, if set, lets the sink share its clients with the sinks of other
+	// changefeeds whose clients have the same connectionKey (see
+	// sinkConnectionPool).
+	settings      *cluster.Settings
+	connectionKey sinkConnectionKey
+
+	// partitionExpr is the expression of the column partitioner, if the sink
+	// is configured with it (see kafkaPartitionerConfig).
+	partitionExpr string
+
+	// successes and producerErrors receive the acknowledgements of all the
+	// producers of the sink.
+	successes      <-chan *sarama.ProducerMessage
+	producerErrors <-chan *sarama.ProducerError
+
 	lastMetadataRefresh time.Time
 
 	stopWorkerCh chan struct{}
@@ -129,9 +170,30 @@ type kafkaSink struct {
 		inflight int64
 		flushErr error
 		flushCh  chan struct{}
+		// inflightBytes is the size of the inflight messages, and capacityCh,
+		// if set, is closed once a message is acknowledged to wake up the
+		// emitter waiting for the inflight messages to fit within the limits.
+		inflightBytes int64
+		capacityCh    chan struct{}
+		// inflightRows counts the inflight rows by their updated timestamp, so
+		// that FlushUpTo only waits for the rows it covers.
+		inflightRows map[hlc.Timestamp]int64
+		// flushUpTo is set if the pending flush only waits for the rows whose
+		// updated timestamp is not greater than it, in which case flushCovered
+		// is the number of such rows which are still inflight.
+		flushUpTo    hlc.Timestamp
+		flushCovered int64
+		// deadLetters, if set, receives the messages which cannot be delivered
+		// (see DeadLetterEventSink).
+		deadLetters *deadLetterQueue
 	}
 
 	disableInternalRetry bool
+
+	// maxInflightMessages and maxInflightBytes, if set, bound the messages
+	// inflight (see saramaConfig.MaxInflight).
+	maxInflightMessages int64
+	maxInflightBytes    int64
 }
 
 type saramaConfig struct {
@@ -149,6 +211,72 @@ type saramaConfig struct {
 	RequiredAcks string `json:",omitempty"`
 
 	Version string `json:",omitempty"`
+
+	// Topics overrides the configuration of specific topics, keyed by topic
+	// name. The configuration of a topic is merged over the configuration of
+	// the sink, e.g. {"Topics": {"foo": {"Flush": {"Messages": 100}}}} only
+	// changes the number of messages per batch of topic foo.
+	Topics map[string]json.RawMessage `json:",omitempty"`
+
+	// ExactlyOnce emits the rows in kafka transactions which are committed
+	// once the changefeed records them in its progress, so that consumers
+	// reading with the read_committed isolation level see each row exactly
+	// once.
+	ExactlyOnce bool `json:",omitempty"`
+
+	// TransactionTimeout is the timeout of the transactions of the
+	// ExactlyOnce mode, after which the brokers abort the transactions which
+	// were not committed. It must not exceed the transaction.max.timeout.ms
+	// setting of the brokers.
+	TransactionTimeout jsonDuration `json:",omitempty"`
+
+	// Partitioner configures how the messages of the rows are assigned to
+	// partitions, e.g. {"Partitioner": {"Strategy": "column", "Expr": "region"}}.
+	Partitioner kafkaPartitionerConfig `json:",omitempty"`
+
+	// TopicCreation configures the topics which the sink creates if they do
+	// not exist yet (see kafkaTopicCreationConfig).
+	TopicCreation kafkaTopicCreationConfig `json:",omitempty"`
+
+	// MaxInflight bounds the messages which the sink emitted but the brokers
+	// did not acknowledge yet, past which the changefeed waits for their
+	// acknowledgements before emitting more messages. Brokers which throttle
+	// the producer to enforce their quotas delay their acknowledgements, so
+	// the changefeed slows down rather than buffering its messages without
+	// bound. Bytes defaults to changefeed.kafka.max_inflight_bytes, and 0
+	// Messages means no limit.
+	MaxInflight struct {
+		Messages int64 `json:",omitempty"`
+		Bytes    int64 `json:",omitempty"`
+	} `json:",omitempty"`
+
+	// PartitionInflight bounds the full batches of each partition which the
+	// kafka-v2 sink queued but the brokers did not acknowledge yet, past which
+	// the changefeed waits for the batches of the partition to be sent before
+	// emitting more messages to it. It defaults to
+	// kafkaV2DefaultPartitionInflight, and is only supported by the kafka-v2
+	// sink.
+	PartitionInflight int `json:",omitempty"`
+}
+
+// kafkaPartitionerConfig is the configuration of the partitioner of the kafka
+// sink.
+type kafkaPartitionerConfig struct {
+	// Strategy is hash (the hash of the message key, which is the default),
+	// roundrobin, sticky or column (the hash of the value of Expr). See
+	// changefeedbase.OptKafkaPartitioner.
+	Strategy changefeedbase.KafkaPartitionerType `json:",omitempty"`
+	// Expr is the expression over the columns of the row whose value is hashed
+	// by the column strategy. It is validated against the changefeed targets
+	// when the changefeed is created.
+	Expr string `json:",omitempty"`
+}
+
+// kafkaTopicProducer is the producer of the topics whose configuration is
+// overridden alike, along with its client.
+type kafkaTopicProducer struct {
+	client   kafkaClient
+	producer sarama.AsyncProducer
 }
 
 func (c saramaConfig) Validate() error {
@@ -160,7 +288,87 @@ func (c saramaConfig) Validate() error {
 	if (c.Flush.Bytes > 0 || c.Flush.Messages > 1) && c.Flush.Frequency == 0 {
 		return errors.New("Flush.Frequency must be > 0 when Flush.Bytes > 0 or Flush.Messages > 1")
 	}
-	return nil
+	if c.ExactlyOnce {
+		// The transactions span all the topics, which are emitted with a single
+		// producer, whose writes must be acknowledged by all the replicas lest a
+		// committed transaction loses some of its rows.
+		if len(c.Topics) > 0 {
+			return errors.New("Topics cannot be used with ExactlyOnce")
+		}
+		// The rows are retained by the sink until they are emitted in a
+		// transaction, which waits for the brokers to acknowledge them.
+		if c.MaxInflight.Messages != 0 || c.MaxInflight.Bytes != 0 {
+			return errors.New("MaxInflight cannot be used with ExactlyOnce")
+		}
+		if c.RequiredAcks != "" && c.RequiredAcks != "ALL" && c.RequiredAcks != "-1" {
+			return errors.New(`RequiredAcks must be "ALL" when ExactlyOnce is set`)
+		}
+	} else if c.TransactionTimeout != 0 {
+		return errors.New("TransactionTimeout requires ExactlyOnce")
+	}
+	if c.TransactionTimeout < 0 {
+		return errors.New("TransactionTimeout must be positive")
+	}
+	if c.MaxInflight.Messages < 0 || c.MaxInflight.Bytes < 0 {
+		return errors.New("MaxInflight.Messages and MaxInflight.Bytes must not be negative")
+	}
+	if c.PartitionInflight < 0 {
+		return errors.New("PartitionInflight must not be negative")
+	}
+	switch c.Partitioner.Strategy {
+	case "", changefeedbase.OptKafkaPartitionerHash, changefeedbase.OptKafkaPartitionerRoundRobin,
+		changefeedbase.OptKafkaPartitionerSticky:
+		if c.Partitioner.Expr != "" {
+			return errors.Newf(`Partitioner.Expr requires the %q strategy`, changefeedbase.OptKafkaPartitionerColumn)
+		}
+	case changefeedbase.OptKafkaPartitionerColumn:
+		if c.Partitioner.Expr == "" {
+			return errors.Newf(`the %q strategy requires Partitioner.Expr`, changefeedbase.OptKafkaPartitionerColumn)
+		}
+	default:
+		return errors.Newf(`unknown Partitioner.Strategy %q, must be one of %q, %q, %q or %q`,
+			c.Partitioner.Strategy, changefeedbase.OptKafkaPartitionerHash,
+			changefeedbase.OptKafkaPartitionerRoundRobin, changefeedbase.OptKafkaPartitionerSticky,
+			changefeedbase.OptKafkaPartitionerColumn)
+	}
+	return c.TopicCreation.Validate()
+}
+
+// producerSettings returns the settings of the configuration which Apply
+// applies to the producer, which the topics whose settings are equal share.
+func (c saramaConfig) producerSettings() string {
+	return fmt.Sprintf("%+v/%s/%s", c.Flush, c.RequiredAcks, c.Version)
+}
+
+// topicConfig returns the configuration of the topic, which is the
+// configuration of the sink merged with the overrides of the topic.
+func (c saramaConfig) topicConfig(topic string) (*saramaConfig, error) {
+	config := c
+	config.Topics = nil
+	// The topic configs are merged over a copy of the configs of the sink.
+	config.TopicCreation.Configs = nil
+	if len(c.TopicCreation.Configs) > 0 {
+		config.TopicCreation.Configs = make(map[string]string, len(c.TopicCreation.Configs))
+		for k, v := range c.TopicCreation.Configs {
+			config.TopicCreation.Configs[k] = v
+		}
+	}
+	if err := json.Unmarshal(c.Topics[topic], &config); err != nil {
+		return nil, err
+	}
+	if config.Topics != nil {
+		return nil, errors.New("the configuration of a topic cannot override the configuration of other topics")
+	}
+	// The partition expression is evaluated by the changefeed for the rows of
+	// all the topics alike.
+	if config.Partitioner != c.Partitioner {
+		return nil, errors.New("the configuration of a topic cannot override the Partitioner")
+	}
+	// The messages inflight are bounded across all the topics of the sink.
+	if config.MaxInflight != c.MaxInflight {
+		return nil, errors.New("the configuration of a topic cannot override MaxInflight")
+	}
+	return &config, nil
 }
 
 func defaultSaramaConfig() *saramaConfig {
@@ -199,7 +407,7 @@ func defaultSaramaConfig() *saramaConfig {
 
 // Dial implements the Sink interface.
 func (s *kafkaSink) Dial() error {
-	client, err := s.newClient(s.kafkaCfg)
+	client, err := s.newSharedClient("" /* topic */, s.kafkaCfg)
 	if err != nil {
 		return err
 	}
@@ -211,14 +419,99 @@ func (s *kafkaSink) Dial() error {
 
 	s.client = client
 	s.producer = producer
+	s.successes, s.producerErrors = producer.Successes(), producer.Errors()
+
+	if err := s.topicCreator.ensureTopics(s.topics.DisplayNamesSlice()...); err != nil {
+		return err
+	}
+
+	// The client of the topics sharing a configuration is keyed by the first
+	// of them, which is the same for all the sinks with the same
+	// connectionKey.
+	topics := make([]string, 0, len(s.topicCfgs))
+	for topic := range s.topicCfgs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		config := s.topicCfgs[topic]
+		if _, ok := s.topicProducers[config]; ok {
+			continue
+		}
+		client, err := s.newSharedClient(topic, config)
+		if err != nil {
+			return err
+		}
+		producer, err := s.newAsyncProducer(client)
+		if err != nil {
+			return err
+		}
+		if s.topicProducers == nil {
+			s.topicProducers = make(map[*sarama.Config]kafkaTopicProducer)
+		}
+		s.topicProducers[config] = kafkaTopicProducer{client: client, producer: producer}
+	}
 
 	// Start the worker
 	s.stopWorkerCh = make(chan struct{})
+	if len(s.topicProducers) > 0 {
+		s.mergeAcknowledgements()
+	}
 	s.worker.Add(1)
 	go s.workerLoop()
 	return nil
 }
 
+// mergeAcknowledgements forwards the acknowledgements of all the producers of
+// the sink to the channels read by the worker.
+func (s *kafkaSink) mergeAcknowledgements() {
+	successes := make(chan *sarama.ProducerMessage)
+	producerErrors := make(chan *sarama.ProducerError)
+	forward := func(producer sarama.AsyncProducer) {
+		defer s.worker.Done()
+		for {
+			select {
+			case <-s.stopWorkerCh:
+				return
+			case m := <-producer.Successes():
+				select {
+				case successes <- m:
+				case <-s.stopWorkerCh:
+					return
+				}
+			case err := <-producer.Errors():
+				select {
+				case producerErrors <- err:
+				case <-s.stopWorkerCh:
+					return
+				}
+			}
+		}
+	}
+	s.worker.Add(1 + len(s.topicProducers))
+	go forward(s.producer)
+	for _, p := range s.topicProducers {
+		go forward(p.producer)
+	}
+	s.successes, s.producerErrors = successes, producerErrors
+}
+
+// producerFor returns the producer of the messages of the topic.
+func (s *kafkaSink) producerFor(topic string) sarama.AsyncProducer {
+	if p, ok := s.topicProducers[s.topicCfgs[topic]]; ok {
+		return p.producer
+	}
+	return s.producer
+}
+
+// configFor returns the configuration of the producer of the topic.
+func (s *kafkaSink) configFor(topic string) *sarama.Config {
+	if config, ok := s.topicCfgs[topic]; ok {
+		return config
+	}
+	return s.kafkaCfg
+}
+
 func (s *kafkaSink) newClient(config *sarama.Config) (kafkaClient, error) {
 	// Initialize client and producer
 	if s.knobs.OverrideClientInit != nil {
@@ -234,6 +527,40 @@ func (s *kafkaSink) newClient(config *sarama.Config) (kafkaClient, error) {
 	return client, err
 }
 
+// sharedKafkaClient is a kafka client shared by sinks, which closing releases.
+type sharedKafkaClient struct {
+	sarama.Client
+	release func() error
+}
+
+// Close implements the kafkaClient interface.
+func (c *sharedKafkaClient) Close() error {
+	return c.release()
+}
+
+// newSharedClient returns a client with the configuration of the topic, or of
+// the sink if the topic is empty, which is shared with the sinks of other
+// changefeeds with the same configuration unless the sink does not share its
+// clients.
+func (s *kafkaSink) newSharedClient(topic string, config *sarama.Config) (kafkaClient, error) {
+	if s.settings == nil || s.knobs.OverrideClientInit != nil {
+		return s.newClient(config)
+	}
+	key := makeSinkConnectionKey(s.connectionKey.kind, s.connectionKey.endpoint, s.connectionKey.config, topic)
+	conn, release, err := sinkConnections.acquire(s.settings, key,
+		func() (interface{}, func() error, error) {
+			client, err := s.newClient(config)
+			if err != nil {
+				return nil, nil, err
+			}
+			return client, client.Close, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &sharedKafkaClient{Client: conn.(sarama.Client), release: release}, nil
+}
+
 func (s *kafkaSink) newAsyncProducer(client kafkaClient) (sarama.AsyncProducer, error) {
 	var producer sarama.AsyncProducer
 	var err error
@@ -274,17 +601,50 @@ func (s *kafkaSink) Close() error {
 		// down or beginning to retry regardless
 		_ = s.producer.Close()
 	}
+	var err error
+	for _, p := range s.topicProducers {
+		_ = p.producer.Close()
+		if p.client != nil {
+			err = errors.CombineErrors(err, p.client.Close())
+		}
+	}
 	// s.client is only nil in tests.
 	if s.client != nil {
-		return s.client.Close()
+		err = errors.CombineErrors(err, s.client.Close())
 	}
-	return nil
+	return err
 }
 
 type messageMetadata struct {
 	alloc         kvevent.Alloc
 	updateMetrics recordOneMessageCallback
-	mvcc          hlc.Timestamp
+	updated, mvcc hlc.Timestamp
+	// topic is the descriptor of the table of the row, with which the row is
+	// routed to the dead letter queue if it cannot be delivered.
+	topic TopicDescriptor
+	// explicitPartition is set if the message must be delivered into the
+	// partition specified in the message rather than the one derived from
+	// the message key.
+	explicitPartition bool
+	// partitionKey, if set, is the value of the expression of the column
+	// partitioner for the row, which is hashed instead of the message key.
+	partitionKey []byte
+}
+
+var _ PartitionedEventSink = (*kafkaSink)(nil)
+var _ HeaderedEventSink = (*kafkaSink)(nil)
+var _ PathPartitionedEventSink = (*kafkaSink)(nil)
+var _ ResolvedFlushingEventSink = (*kafkaSink)(nil)
+var _ DeadLetterEventSink = (*kafkaSink)(nil)
+
+// topicName returns the name of the topic of the descriptor, which the sink
+// creates if needed.
+func (s *kafkaSink) topicName(topicDescr TopicDescriptor) (string, error) {
+	topic, err := s.topics.Name(topicDescr)
+	if err != nil {
+		return "", err
+	}
+	return topic, s.topicCreator.ensureTopics(topic)
 }
 
 // EmitRow implements the Sink interface.
@@ -295,21 +655,162 @@ func (s *kafkaSink) EmitRow(
 	updated, mvcc hlc.Timestamp,
 	alloc kvevent.Alloc,
 ) error {
-	topic, err := s.topics.Name(topicDescr)
+	topic, err := s.topicName(topicDescr)
 	if err != nil {
 		return err
 	}
 
 	msg := &sarama.ProducerMessage{
-		Topic:    topic,
-		Key:      sarama.ByteEncoder(key),
-		Value:    sarama.ByteEncoder(value),
-		Metadata: messageMetadata{alloc: alloc, mvcc: mvcc, updateMetrics: s.metrics.recordOneMessage()},
+		Topic: topic,
+		Key:   sarama.ByteEncoder(key),
+		Value: sarama.ByteEncoder(value),
+		Metadata: messageMetadata{
+			alloc:         alloc,
+			updated:       updated,
+			mvcc:          mvcc,
+			topic:         topicDescr,
+			updateMetrics: s.metrics.recordOneMessage(),
+		},
 	}
 	s.stats.startMessage(int64(msg.Key.Length() + msg.Value.Length()))
 	return s.emitMessage(ctx, msg)
 }
 
+// EmitRowToPartition implements the PartitionedEventSink interface.
+func (s *kafkaSink) EmitRowToPartition(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+) error {
+	topic, err := s.topicName(topicDescr)
+	if err != nil {
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:     topic,
+		Key:       sarama.ByteEncoder(key),
+		Value:     sarama.ByteEncoder(value),
+		Partition: partition,
+		Metadata: messageMetadata{
+			alloc:             alloc,
+			updated:           updated,
+			mvcc:              mvcc,
+			topic:             topicDescr,
+			updateMetrics:     s.metrics.recordOneMessage(),
+			explicitPartition: true,
+		},
+	}
+	s.stats.startMessage(int64(msg.Key.Length() + msg.Value.Length()))
+	return s.emitMessage(ctx, msg)
+}
+
+// EmitRowWithHeaders implements the HeaderedEventSink interface.
+func (s *kafkaSink) EmitRowWithHeaders(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+	headers []messageHeader,
+) error {
+	topic, err := s.topicName(topicDescr)
+	if err != nil {
+		return err
+	}
+
+	meta := messageMetadata{
+		alloc:         alloc,
+		updated:       updated,
+		mvcc:          mvcc,
+		topic:         topicDescr,
+		updateMetrics: s.metrics.recordOneMessage(),
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(key),
+		Value:   sarama.ByteEncoder(value),
+		Headers: makeKafkaRecordHeaders(headers),
+	}
+	if partition >= 0 {
+		msg.Partition = partition
+		meta.explicitPartition = true
+	}
+	msg.Metadata = meta
+	s.stats.startMessage(int64(msg.Key.Length() + msg.Value.Length()))
+	return s.emitMessage(ctx, msg)
+}
+
+// PartitionExprs implements the PathPartitionedEventSink interface. The
+// expression of the column partitioner is evaluated for each row, whose value
+// is then hashed to assign the row to a partition.
+func (s *kafkaSink) PartitionExprs() []string {
+	if s.partitionExpr == "" {
+		return nil
+	}
+	return []string{s.partitionExpr}
+}
+
+// EmitRowWithPartitionValues implements the PathPartitionedEventSink
+// interface.
+func (s *kafkaSink) EmitRowWithPartitionValues(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partitionValues []string,
+) error {
+	partitionKey, err := columnPartitionKey(partitionValues)
+	if err != nil {
+		return err
+	}
+	topic, err := s.topicName(topicDescr)
+	if err != nil {
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(key),
+		Value: sarama.ByteEncoder(value),
+		Metadata: messageMetadata{
+			alloc:         alloc,
+			updated:       updated,
+			mvcc:          mvcc,
+			topic:         topicDescr,
+			updateMetrics: s.metrics.recordOneMessage(),
+			partitionKey:  partitionKey,
+		},
+	}
+	s.stats.startMessage(int64(msg.Key.Length() + msg.Value.Length()))
+	return s.emitMessage(ctx, msg)
+}
+
+// columnPartitionKey returns the key hashed by the column partitioner from the
+// value of its expression.
+func columnPartitionKey(partitionValues []string) ([]byte, error) {
+	if len(partitionValues) != 1 {
+		return nil, errors.AssertionFailedf(
+			"expected the value of the partition expression, found %d values", len(partitionValues))
+	}
+	return []byte(partitionValues[0]), nil
+}
+
+// makeKafkaRecordHeaders converts the headers of a row into the headers of its
+// kafka message.
+func makeKafkaRecordHeaders(headers []messageHeader) []sarama.RecordHeader {
+	recordHeaders := make([]sarama.RecordHeader, len(headers))
+	for i, h := range headers {
+		recordHeaders[i] = sarama.RecordHeader{Key: []byte(h.key), Value: h.value}
+	}
+	return recordHeaders
+}
+
 // EmitResolvedTimestamp implements the Sink interface.
 func (s *kafkaSink) EmitResolvedTimestamp(
 	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
@@ -363,17 +864,39 @@ func (s *kafkaSink) EmitResolvedTimestamp(
 
 // Flush implements the Sink interface.
 func (s *kafkaSink) Flush(ctx context.Context) error {
+	return s.flush(ctx, hlc.Timestamp{})
+}
+
+// FlushUpTo implements the ResolvedFlushingEventSink interface.
+func (s *kafkaSink) FlushUpTo(ctx context.Context, ts hlc.Timestamp) error {
+	return s.flush(ctx, ts)
+}
+
+// flush waits for the inflight messages to be acknowledged. If upTo is set,
+// only the rows whose updated timestamp is not greater than upTo are waited
+// for; the rows emitted at later timestamps may remain inflight.
+func (s *kafkaSink) flush(ctx context.Context, upTo hlc.Timestamp) error {
 	defer s.metrics.recordFlushRequestCallback()()
 
 	flushCh := make(chan struct{}, 1)
 
 	s.mu.Lock()
 	inflight := s.mu.inflight
+	if !upTo.IsEmpty() {
+		inflight = 0
+		for ts, n := range s.mu.inflightRows {
+			if ts.LessEq(upTo) {
+				inflight += n
+			}
+		}
+	}
 	flushErr := s.mu.flushErr
 	s.mu.flushErr = nil
 	immediateFlush := inflight == 0 || flushErr != nil
 	if !immediateFlush {
 		s.mu.flushCh = flushCh
+		s.mu.flushUpTo = upTo
+		s.mu.flushCovered = inflight
 	}
 	s.mu.Unlock()
 
@@ -396,26 +919,90 @@ func (s *kafkaSink) Flush(ctx context.Context) error {
 	}
 }
 
-func (s *kafkaSink) startInflightMessage(ctx context.Context) error {
+func (s *kafkaSink) startInflightMessage(ctx context.Context, msg *sarama.ProducerMessage) error {
+	sz := kafkaMessageSize(msg)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.waitForInflightCapacityLocked(ctx, sz); err != nil {
+		return err
+	}
 	s.mu.inflight++
+	s.mu.inflightBytes += sz
+	if m, ok := msg.Metadata.(messageMetadata); ok {
+		if s.mu.inflightRows == nil {
+			s.mu.inflightRows = make(map[hlc.Timestamp]int64)
+		}
+		s.mu.inflightRows[m.updated]++
+	}
 	if log.V(2) {
 		log.Infof(ctx, "emitting %d inflight records to kafka", s.mu.inflight)
 	}
 	return nil
 }
 
+// waitForInflightCapacityLocked waits until a message of the specified size
+// fits within the limits of the inflight messages, which sarama does not
+// bound on its own. The message is always admitted once nothing is inflight,
+// so that messages larger than the limit are still emitted.
+func (s *kafkaSink) waitForInflightCapacityLocked(ctx context.Context, sz int64) error {
+	s.mu.AssertHeld()
+	exceeded := func() bool {
+		return s.mu.inflight > 0 &&
+			((s.maxInflightMessages > 0 && s.mu.inflight >= s.maxInflightMessages) ||
+				(s.maxInflightBytes > 0 && s.mu.inflightBytes+sz > s.maxInflightBytes))
+	}
+	if !exceeded() {
+		return nil
+	}
+
+	start := timeutil.Now()
+	defer func() { s.metrics.recordSinkBackpressure(timeutil.Since(start)) }()
+	if log.V(1) {
+		log.Infof(ctx, "waiting for the acknowledgements of %d inflight messages (%d bytes)",
+			s.mu.inflight, s.mu.inflightBytes)
+	}
+	for exceeded() {
+		if s.mu.capacityCh == nil {
+			s.mu.capacityCh = make(chan struct{})
+		}
+		capacityCh := s.mu.capacityCh
+		s.mu.Unlock()
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-capacityCh:
+		}
+		s.mu.Lock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kafkaMessageSize returns the size of the key and value of the message.
+func kafkaMessageSize(msg *sarama.ProducerMessage) int64 {
+	var sz int
+	if msg != nil && msg.Key != nil {
+		sz += msg.Key.Length()
+	}
+	if msg != nil && msg.Value != nil {
+		sz += msg.Value.Length()
+	}
+	return int64(sz)
+}
+
 func (s *kafkaSink) emitMessage(ctx context.Context, msg *sarama.ProducerMessage) error {
-	if err := s.startInflightMessage(ctx); err != nil {
+	if err := s.startInflightMessage(ctx, msg); err != nil {
 		return err
 	}
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case s.producer.Input() <- msg:
+	case s.producerFor(msg.Topic).Input() <- msg:
 	}
 
 	return nil
@@ -477,9 +1064,9 @@ func (s *kafkaSink) workerLoop() {
 		select {
 		case <-s.stopWorkerCh:
 			return
-		case m := <-s.producer.Successes():
+		case m := <-s.successes:
 			ackMsg = m
-		case err := <-s.producer.Errors():
+		case err := <-s.producerErrors:
 			ackMsg, ackError = err.Msg, err.Err
 			if ackError != nil {
 				// Msg should never be nil but we're being defensive around a vendor library.
@@ -498,6 +1085,11 @@ func (s *kafkaSink) workerLoop() {
 			muLocker.Lock()
 		}
 		s.mu.inflight--
+		s.mu.inflightBytes -= kafkaMessageSize(ackMsg)
+		if s.mu.capacityCh != nil {
+			close(s.mu.capacityCh)
+			s.mu.capacityCh = nil
+		}
 
 		if !isRetrying() && s.isInternalRetryable(ackError) {
 			startInternalRetry(ackError)
@@ -514,7 +1106,7 @@ func (s *kafkaSink) workerLoop() {
 		// Once inflight messages to retry are done buffering, find a new client
 		// that successfully resends and continue on with it.
 		if isRetrying() && s.mu.inflight == 0 {
-			if err := s.handleBufferedRetries(retryBuf, retryErr); err != nil {
+			if err := s.handleBufferedRetries(retryBuf, retryErr); err != nil && !s.isDeadLettered(err) {
 				s.mu.flushErr = err
 			}
 			endInternalRetry()
@@ -522,9 +1114,11 @@ func (s *kafkaSink) workerLoop() {
 
 		// If we're in a retry inflight can be 0 but messages in retryBuf are yet to
 		// be resent.
-		if !isRetrying() && s.mu.inflight == 0 && s.mu.flushCh != nil {
+		if !isRetrying() && s.mu.flushCh != nil &&
+			(s.mu.inflight == 0 || (!s.mu.flushUpTo.IsEmpty() && s.mu.flushCovered == 0)) {
 			s.mu.flushCh <- struct{}{}
 			s.mu.flushCh = nil
+			s.mu.flushUpTo = hlc.Timestamp{}
 		}
 
 		// If we're in a retry we keep hold of the lock to stop all other operations
@@ -538,26 +1132,127 @@ func (s *kafkaSink) workerLoop() {
 func (s *kafkaSink) finishProducerMessage(ackMsg *sarama.ProducerMessage, ackError error) {
 	s.mu.AssertHeld()
 	if m, ok := ackMsg.Metadata.(messageMetadata); ok {
+		if ackError != nil && s.isDeadLetter(ackError) {
+			// The failure to route the message to the dead letter queue fails
+			// its next flush.
+			key, _ := ackMsg.Key.Encode()
+			value, _ := ackMsg.Value.Encode()
+			_ = s.mu.deadLetters.enqueue(s.ctx, m.topic, key, value, m.updated, m.mvcc, ackError)
+			ackError = nil
+		}
 		if ackError == nil {
 			sz := ackMsg.Key.Length() + ackMsg.Value.Length()
 			s.stats.finishMessage(int64(sz))
 			m.updateMetrics(m.mvcc, sz, sinkDoesNotCompress)
 		}
 		m.alloc.Release(s.ctx)
+		s.finishInflightRow(m.updated)
 	}
 	if s.mu.flushErr == nil && ackError != nil {
 		s.mu.flushErr = ackError
 	}
 }
 
+// SetDeadLetterQueue implements the DeadLetterEventSink interface.
+func (s *kafkaSink) SetDeadLetterQueue(q *deadLetterQueue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.deadLetters = q
+}
+
+// isDeadLetter returns whether the message which failed with the specified
+// error is routed to the dead letter queue: the messages which the brokers
+// reject because of their size are rejected however many times they are
+// retried.
+func (s *kafkaSink) isDeadLetter(err error) bool {
+	s.mu.AssertHeld()
+	if s.mu.deadLetters == nil {
+		return false
+	}
+	var kError sarama.KError
+	return errors.As(err, &kError) &&
+		(kError == sarama.ErrMessageSizeTooLarge || kError == sarama.ErrInvalidMessageSize)
+}
+
+// isDeadLettered returns whether all of the messages which failed to be
+// retried with the specified error were routed to the dead letter queue.
+func (s *kafkaSink) isDeadLettered(err error) bool {
+	var producerErrs sarama.ProducerErrors
+	if !errors.As(err, &producerErrs) {
+		return false
+	}
+	for _, pe := range producerErrs {
+		if !s.isDeadLetter(pe.Err) {
+			return false
+		}
+	}
+	return true
+}
+
+// finishInflightRow stops tracking an inflight row emitted at the specified
+// updated timestamp.
+func (s *kafkaSink) finishInflightRow(updated hlc.Timestamp) {
+	s.mu.AssertHeld()
+	if n := s.mu.inflightRows[updated] - 1; n > 0 {
+		s.mu.inflightRows[updated] = n
+	} else {
+		delete(s.mu.inflightRows, updated)
+	}
+	if !s.mu.flushUpTo.IsEmpty() && updated.LessEq(s.mu.flushUpTo) {
+		s.mu.flushCovered--
+	}
+}
+
+// handleBufferedRetries retries the messages with reduced batching, starting
+// from the configuration of their topics.
 func (s *kafkaSink) handleBufferedRetries(msgs []*sarama.ProducerMessage, retryErr error) error {
+	if len(s.topicCfgs) == 0 {
+		return s.retryMessages(s.kafkaCfg, msgs, retryErr)
+	}
+	var configs []*sarama.Config
+	msgsByConfig := make(map[*sarama.Config][]*sarama.ProducerMessage)
+	for _, msg := range msgs {
+		config := s.configFor(msg.Topic)
+		if _, ok := msgsByConfig[config]; !ok {
+			configs = append(configs, config)
+		}
+		msgsByConfig[config] = append(msgsByConfig[config], msg)
+	}
+	var err error
+	for _, config := range configs {
+		if sendErr := s.retryMessages(config, msgsByConfig[config], retryErr); err == nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+// retryMessages retries the messages with a batching configuration reduced
+// from the specified configuration until they are sent.
+func (s *kafkaSink) retryMessages(
+	config *sarama.Config, msgs []*sarama.ProducerMessage, retryErr error,
+) error {
 	lastSendErr := retryErr
-	activeConfig := s.kafkaCfg
+	activeConfig := config
 
 	// Ensure memory for messages are always cleaned up
 	defer func() {
+		// If the last attempt failed to send some of the messages, the others
+		// were delivered, and the failed ones are finished with their own
+		// errors, which determine whether they are dead letters.
+		var producerErrs sarama.ProducerErrors
+		if !errors.As(lastSendErr, &producerErrs) {
+			for _, msg := range msgs {
+				s.finishProducerMessage(msg, lastSendErr)
+			}
+			return
+		}
+		msgErrs := make(map[*sarama.ProducerMessage]error, len(producerErrs))
+		for _, pe := range producerErrs {
+			msgErrs[pe.Msg] = pe.Err
+		}
 		for _, msg := range msgs {
-			s.finishProducerMessage(msg, lastSendErr)
+			s.finishProducerMessage(msg, msgErrs[msg])
 		}
 	}()
 
@@ -643,26 +1338,130 @@ func (s *kafkaSink) Topics() []string {
 }
 
 type changefeedPartitioner struct {
-	hash sarama.Partitioner
+	// keyed assigns the partitions of the messages which have a key and are
+	// not explicitly partitioned (see changefeedbase.OptPartitionExpr),
+	// according to the changefeedbase.OptKafkaPartitioner option or the
+	// Partitioner of the kafka_sink_config option.
+	keyed sarama.Partitioner
 }
 
-var _ sarama.Partitioner = &changefeedPartitioner{}
-var _ sarama.PartitionerConstructor = newChangefeedPartitioner
-
-func newChangefeedPartitioner(topic string) sarama.Partitioner {
-	return &changefeedPartitioner{
-		hash: sarama.NewHashPartitioner(topic),
+var _ sarama.DynamicConsistencyPartitioner = &changefeedPartitioner{}
+
+func newChangefeedPartitioner(
+	partitioner changefeedbase.KafkaPartitionerType,
+) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		p := &changefeedPartitioner{}
+		switch partitioner {
+		case changefeedbase.OptKafkaPartitionerRoundRobin:
+			p.keyed = sarama.NewRoundRobinPartitioner(topic)
+		case changefeedbase.OptKafkaPartitionerSticky:
+			p.keyed = &stickyPartitioner{partition: -1}
+		case changefeedbase.OptKafkaPartitionerColumn:
+			p.keyed = &columnPartitioner{hash: sarama.NewHashPartitioner(topic)}
+		default:
+			p.keyed = sarama.NewHashPartitioner(topic)
+		}
+		return p
 	}
 }
 
 func (p *changefeedPartitioner) RequiresConsistency() bool { return true }
+
+// MessageRequiresConsistency implements the
+// sarama.DynamicConsistencyPartitioner interface. The resolved messages
+// (which have no key) and the explicitly partitioned messages are emitted to
+// the specified partition, even if it is unavailable, and so are the keyed
+// messages when they are partitioned by the hash of their key, so that the
+// messages for a key stay in order.
+func (p *changefeedPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if message.Key == nil {
+		return true
+	}
+	if m, ok := message.Metadata.(messageMetadata); ok && m.explicitPartition {
+		return true
+	}
+	return p.keyed.RequiresConsistency()
+}
+
 func (p *changefeedPartitioner) Partition(
 	message *sarama.ProducerMessage, numPartitions int32,
 ) (int32, error) {
 	if message.Key == nil {
 		return message.Partition, nil
 	}
-	return p.hash.Partition(message, numPartitions)
+	if m, ok := message.Metadata.(messageMetadata); ok && m.explicitPartition {
+		if message.Partition < 0 || message.Partition >= numPartitions {
+			return -1, errors.Mark(errors.Newf(
+				"%s evaluated to partition %d, but topic %s has %d partitions",
+				changefeedbase.OptPartitionExpr, message.Partition, message.Topic, numPartitions),
+				errPartitionOutOfRange)
+		}
+		return message.Partition, nil
+	}
+	return p.keyed.Partition(message, numPartitions)
+}
+
+// stickyPartitionBatchBytes is the size of the messages the sticky partitioner
+// assigns to a partition before moving on to another one. It is the default
+// batch size of the Java producer, whose default partitioner is sticky for
+// messages without keys.
+const stickyPartitionBatchBytes = 16 << 10
+
+// stickyPartitioner assigns the messages to a random partition until it has
+// been assigned stickyPartitionBatchBytes worth of messages, so that the
+// producer sends larger batches than when spreading the messages across all
+// the partitions. The partitioner of a topic is only called by the goroutine
+// of the topic in the producer.
+type stickyPartitioner struct {
+	partition int32
+	bytes     int
+}
+
+var _ sarama.Partitioner = &stickyPartitioner{}
+
+func (p *stickyPartitioner) RequiresConsistency() bool { return false }
+func (p *stickyPartitioner) Partition(
+	message *sarama.ProducerMessage, numPartitions int32,
+) (int32, error) {
+	if p.partition < 0 || p.partition >= numPartitions || p.bytes >= stickyPartitionBatchBytes {
+		partition := rand.Int31n(numPartitions)
+		// Move on to another partition, if there is one.
+		if partition == p.partition && numPartitions > 1 {
+			partition = (partition + 1) % numPartitions
+		}
+		p.partition, p.bytes = partition, 0
+	}
+	p.bytes += message.Key.Length()
+	if message.Value != nil {
+		p.bytes += message.Value.Length()
+	}
+	return p.partition, nil
+}
+
+// columnPartitioner assigns the messages to partitions by the hash of the
+// value of the expression of the column strategy (see kafkaPartitionerConfig)
+// rather than the hash of their key, so that e.g. all the rows of a region
+// are emitted to the same partition. The value is passed to the sink
+// alongside the row (see PathPartitionedEventSink).
+type columnPartitioner struct {
+	hash sarama.Partitioner
+}
+
+var _ sarama.Partitioner = &columnPartitioner{}
+
+func (p *columnPartitioner) RequiresConsistency() bool { return true }
+func (p *columnPartitioner) Partition(
+	message *sarama.ProducerMessage, numPartitions int32,
+) (int32, error) {
+	m, ok := message.Metadata.(messageMetadata)
+	if !ok || m.partitionKey == nil {
+		return p.hash.Partition(message, numPartitions)
+	}
+	return p.hash.Partition(&sarama.ProducerMessage{
+		Topic: message.Topic,
+		Key:   sarama.ByteEncoder(m.partitionKey),
+	}, numPartitions)
 }
 
 type jsonDuration time.Duration
@@ -733,8 +1532,26 @@ func getSaramaConfig(
 	return
 }
 
+// kafkaCompressionCodecs maps the codecs of changefeedbase.OptCompression to
+// the codecs with which the producer compresses the batches of messages.
+var kafkaCompressionCodecs = map[string]sarama.CompressionCodec{
+	changefeedbase.OptCompressionGzip:   sarama.CompressionGZIP,
+	changefeedbase.OptCompressionZstd:   sarama.CompressionZSTD,
+	changefeedbase.OptCompressionLZ4:    sarama.CompressionLZ4,
+	changefeedbase.OptCompressionSnappy: sarama.CompressionSnappy,
+}
+
+// kafkaCompressionCodecNames are the codecs of changefeedbase.OptCompression
+// supported by the kafka sink.
+var kafkaCompressionCodecNames = []string{
+	changefeedbase.OptCompressionGzip,
+	changefeedbase.OptCompressionZstd,
+	changefeedbase.OptCompressionLZ4,
+	changefeedbase.OptCompressionSnappy,
+}
+
 func buildKafkaConfig(
-	u sinkURL, jsonStr changefeedbase.SinkSpecificJSONConfig,
+	ctx context.Context, u sinkURL, kafkaOpts changefeedbase.KafkaSinkOptions,
 ) (*sarama.Config, error) {
 	dialConfig := struct {
 		tlsEnabled    bool
@@ -747,6 +1564,13 @@ func buildKafkaConfig(
 		saslUser      string
 		saslPassword  string
 		saslMechanism string
+		// The parameters of the client credentials flow used to retrieve the
+		// tokens of the OAUTHBEARER mechanism.
+		saslClientID     string
+		saslClientSecret []byte
+		saslTokenURL     string
+		saslScopes       []string
+		saslGrantType    string
 	}{}
 
 	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &dialConfig.tlsEnabled); err != nil {
@@ -788,16 +1612,57 @@ func buildKafkaConfig(
 		dialConfig.saslMechanism = sarama.SASLTypePlaintext
 	}
 	switch dialConfig.saslMechanism {
-	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, sarama.SASLTypePlaintext:
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, sarama.SASLTypeOAuth, sarama.SASLTypePlaintext:
 	default:
-		return nil, errors.Errorf(`param %s must be one of %s, %s, or %s`,
+		return nil, errors.Errorf(`param %s must be one of %s, %s, %s, or %s`,
 			changefeedbase.SinkParamSASLMechanism,
-			sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, sarama.SASLTypePlaintext)
+			sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, sarama.SASLTypeOAuth, sarama.SASLTypePlaintext)
 	}
 
 	dialConfig.saslUser = u.consumeParam(changefeedbase.SinkParamSASLUser)
 	dialConfig.saslPassword = u.consumeParam(changefeedbase.SinkParamSASLPassword)
-	if dialConfig.saslEnabled {
+	dialConfig.saslClientID = u.consumeParam(changefeedbase.SinkParamSASLClientID)
+	if err := u.decodeBase64(changefeedbase.SinkParamSASLClientSecret, &dialConfig.saslClientSecret); err != nil {
+		return nil, err
+	}
+	dialConfig.saslTokenURL = u.consumeParam(changefeedbase.SinkParamSASLTokenURL)
+	if scopes := u.consumeParam(changefeedbase.SinkParamSASLScopes); scopes != `` {
+		for _, scope := range strings.Split(scopes, ",") {
+			dialConfig.saslScopes = append(dialConfig.saslScopes, strings.TrimSpace(scope))
+		}
+	}
+	dialConfig.saslGrantType = u.consumeParam(changefeedbase.SinkParamSASLGrantType)
+	isOAuth := dialConfig.saslMechanism == sarama.SASLTypeOAuth
+	oauthParams := []struct {
+		param         string
+		set, required bool
+	}{
+		{changefeedbase.SinkParamSASLClientID, dialConfig.saslClientID != ``, true},
+		{changefeedbase.SinkParamSASLClientSecret, dialConfig.saslClientSecret != nil, true},
+		{changefeedbase.SinkParamSASLTokenURL, dialConfig.saslTokenURL != ``, true},
+		{changefeedbase.SinkParamSASLScopes, dialConfig.saslScopes != nil, false},
+		{changefeedbase.SinkParamSASLGrantType, dialConfig.saslGrantType != ``, false},
+	}
+	for _, p := range oauthParams {
+		if isOAuth && p.required && !p.set {
+			return nil, errors.Errorf(`%s must be provided when %s=%s`,
+				p.param, changefeedbase.SinkParamSASLMechanism, sarama.SASLTypeOAuth)
+		}
+		if !isOAuth && p.set {
+			return nil, errors.Errorf(`%s requires %s=%s`,
+				p.param, changefeedbase.SinkParamSASLMechanism, sarama.SASLTypeOAuth)
+		}
+	}
+
+	// The OAUTHBEARER mechanism authenticates with tokens rather than a user
+	// and password.
+	if dialConfig.saslEnabled && isOAuth {
+		if dialConfig.saslUser != `` || dialConfig.saslPassword != `` {
+			return nil, errors.Errorf(`%s and %s cannot be used with %s=%s`,
+				changefeedbase.SinkParamSASLUser, changefeedbase.SinkParamSASLPassword,
+				changefeedbase.SinkParamSASLMechanism, sarama.SASLTypeOAuth)
+		}
+	} else if dialConfig.saslEnabled {
 		if dialConfig.saslUser == `` {
 			return nil, errors.Errorf(`%s must be provided when SASL is enabled`, changefeedbase.SinkParamSASLUser)
 		}
@@ -816,7 +1681,6 @@ func buildKafkaConfig(
 	config := sarama.NewConfig()
 	config.ClientID = `CockroachDB`
 	config.Producer.Return.Successes = true
-	config.Producer.Partitioner = newChangefeedPartitioner
 
 	if dialConfig.tlsEnabled {
 		config.Net.TLS.Enable = true
@@ -863,11 +1727,19 @@ func buildKafkaConfig(
 			config.Net.SASL.SCRAMClientGeneratorFunc = sha512ClientGenerator
 		case sarama.SASLTypeSCRAMSHA256:
 			config.Net.SASL.SCRAMClientGeneratorFunc = sha256ClientGenerator
+		case sarama.SASLTypeOAuth:
+			tokenProvider, err := newOAuthTokenProvider(ctx, dialConfig.saslClientID,
+				string(dialConfig.saslClientSecret), dialConfig.saslTokenURL,
+				dialConfig.saslScopes, dialConfig.saslGrantType)
+			if err != nil {
+				return nil, err
+			}
+			config.Net.SASL.TokenProvider = tokenProvider
 		}
 	}
 
 	// Apply statement level overrides.
-	saramaCfg, err := getSaramaConfig(jsonStr)
+	saramaCfg, err := getSaramaConfig(kafkaOpts.JSONConfig)
 	if err != nil {
 		return nil, errors.Wrapf(err,
 			"failed to parse sarama config; check %s option", changefeedbase.OptKafkaSinkConfig)
@@ -880,27 +1752,261 @@ func buildKafkaConfig(
 	if err := saramaCfg.Apply(config); err != nil {
 		return nil, errors.Wrap(err, "failed to apply kafka client configuration")
 	}
+
+	if kafkaOpts.Compression != "" {
+		codec, ok := kafkaCompressionCodecs[kafkaOpts.Compression]
+		if !ok {
+			return nil, errors.Errorf(`unsupported compression codec %q`, kafkaOpts.Compression)
+		}
+		config.Producer.Compression = codec
+		// Batches compressed with zstd require the record batches of kafka
+		// 2.1.0, which the producer only uses when told the brokers support it.
+		if codec == sarama.CompressionZSTD && !config.Version.IsAtLeast(sarama.V2_1_0_0) {
+			if saramaCfg.Version != "" {
+				return nil, errors.Errorf(`%s=%s requires kafka version 2.1.0 or later, but Version is %s`,
+					changefeedbase.OptCompression, changefeedbase.OptCompressionZstd, config.Version)
+			}
+			config.Version = sarama.V2_1_0_0
+		}
+	}
+
+	// The producer only retries the errors which kafka reports as retriable,
+	// so only the number of attempts and the backoff can be overridden.
+	if kafkaOpts.Retry.MaxAttempts > 0 {
+		config.Producer.Retry.Max = kafkaOpts.Retry.MaxAttempts - 1
+	}
+	if kafkaOpts.Retry.Backoff != nil {
+		config.Producer.Retry.Backoff = *kafkaOpts.Retry.Backoff
+	}
+	if kafkaOpts.Retry.MaxBackoff != nil {
+		initial, maxBackoff := config.Producer.Retry.Backoff, *kafkaOpts.Retry.MaxBackoff
+		config.Producer.Retry.BackoffFunc = func(retries, _ int) time.Duration {
+			return kafkaRetryBackoff(initial, maxBackoff, retries)
+		}
+	}
+
+	// The partitioner may be chosen with either the kafka_partitioner option or
+	// the Partitioner of the kafka_sink_config option, but not both.
+	partitioner, partitionerOpt := kafkaOpts.Partitioner, changefeedbase.OptKafkaPartitioner
+	if saramaCfg.Partitioner.Strategy != "" {
+		if partitioner != "" {
+			return nil, errors.Errorf(`%s cannot be used with the Partitioner of %s`,
+				changefeedbase.OptKafkaPartitioner, changefeedbase.OptKafkaSinkConfig)
+		}
+		partitioner, partitionerOpt = saramaCfg.Partitioner.Strategy, "Partitioner.Strategy"
+	}
+	config.Producer.Partitioner = newChangefeedPartitioner(partitioner)
+
+	// With more than one request in flight, a failed request may be retried
+	// after the requests sent after it succeeded, reordering the messages for
+	// a key. The producer is not idempotent, so strict ordering requires a
+	// single request in flight per broker connection.
+	if kafkaOpts.StrictOrdering {
+		if kafkaOpts.MaxInFlight > 1 {
+			return nil, errors.Errorf(
+				`%s=%d may reorder messages for the same key on retry and cannot be used with %s`,
+				changefeedbase.OptKafkaMaxInFlight, kafkaOpts.MaxInFlight, changefeedbase.OptKafkaStrictOrdering)
+		}
+		config.Net.MaxOpenRequests = 1
+	} else if kafkaOpts.MaxInFlight > 0 {
+		config.Net.MaxOpenRequests = kafkaOpts.MaxInFlight
+	}
+
+	// The round robin and sticky partitioners spread the messages for a key
+	// across partitions, and so does the column partitioner whenever the value
+	// of its expression changes for a key, so they cannot be used when the
+	// messages for a key must be ordered.
+	spreadsKeys := partitioner == changefeedbase.OptKafkaPartitionerRoundRobin ||
+		partitioner == changefeedbase.OptKafkaPartitionerSticky ||
+		partitioner == changefeedbase.OptKafkaPartitionerColumn
+	if kafkaOpts.StrictOrdering && kafkaOpts.Unordered {
+		return nil, errors.Errorf(`%s cannot be used with %s`,
+			changefeedbase.OptKafkaUnordered, changefeedbase.OptKafkaStrictOrdering)
+	}
+	if kafkaOpts.StrictOrdering && spreadsKeys {
+		return nil, errors.Errorf(
+			`%s='%s' does not preserve the order of the messages for a key and cannot be used with %s`,
+			partitionerOpt, partitioner, changefeedbase.OptKafkaStrictOrdering)
+	}
+	// The messages of a row are always keyed by its primary key, so the round
+	// robin and sticky partitioners, which ignore the key entirely, require the
+	// ordering of the messages for a key to be explicitly waived. The column
+	// partitioner keeps the messages for a key together as long as the value
+	// of its expression does not change.
+	if (partitioner == changefeedbase.OptKafkaPartitionerRoundRobin ||
+		partitioner == changefeedbase.OptKafkaPartitionerSticky) && !kafkaOpts.Unordered {
+		return nil, errors.Errorf(
+			`%s='%s' does not preserve the order of the messages for a key and requires %s`,
+			partitionerOpt, partitioner, changefeedbase.OptKafkaUnordered)
+	}
+	// The values of the partition expression are passed to the sink alongside
+	// the rows, which the headers are not.
+	if partitioner == changefeedbase.OptKafkaPartitionerColumn && len(kafkaOpts.Headers) > 0 {
+		return nil, errors.Errorf(`the %q partitioner cannot be used with %s`,
+			changefeedbase.OptKafkaPartitionerColumn, changefeedbase.OptKafkaHeaders)
+	}
 	return config, nil
 }
 
+// kafkaRetryBackoff returns the backoff of the producer before the specified
+// retry of a message: the initial backoff, doubled with every retry up to
+// maxBackoff.
+func kafkaRetryBackoff(initial, maxBackoff time.Duration, retries int) time.Duration {
+	backoff := initial
+	for i := 1; i < retries && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// oauthTokenProvider retrieves the tokens of the OAUTHBEARER SASL mechanism
+// with the client credentials flow.
+type oauthTokenProvider struct {
+	tokenSource oauth2.TokenSource
+}
+
+var _ sarama.AccessTokenProvider = (*oauthTokenProvider)(nil)
+
+// Token implements the sarama.AccessTokenProvider interface. It is called by
+// sarama whenever it authenticates a connection to a broker.
+func (p *oauthTokenProvider) Token() (*sarama.AccessToken, error) {
+	token, err := p.tokenSource.Token()
+	if err != nil {
+		// Sarama retries the connection to the broker, so a token endpoint which
+		// is temporarily unavailable only surfaces once the retries are
+		// exhausted.
+		return nil, errors.Wrap(err, "retrieving OAUTHBEARER token")
+	}
+	return &sarama.AccessToken{Token: token.AccessToken}, nil
+}
+
+func newOAuthTokenProvider(
+	ctx context.Context,
+	clientID, clientSecret, tokenURL string,
+	scopes []string,
+	grantType string,
+) (*oauthTokenProvider, error) {
+	if _, err := url.ParseRequestURI(tokenURL); err != nil {
+		return nil, errors.Wrapf(err, `param %s must be a valid URL`, changefeedbase.SinkParamSASLTokenURL)
+	}
+	// The client credentials flow uses the client_credentials grant type,
+	// which some non-compliant authorization servers want overridden.
+	var endpointParams url.Values
+	if grantType != `` {
+		endpointParams = url.Values{"grant_type": {grantType}}
+	}
+	cfg := clientcredentials.Config{
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		TokenURL:       tokenURL,
+		Scopes:         scopes,
+		EndpointParams: endpointParams,
+	}
+	// The token source caches the token until it expires, and then retrieves
+	// a new one from the token endpoint.
+	return &oauthTokenProvider{tokenSource: cfg.TokenSource(ctx)}, nil
+}
+
+// buildKafkaTopicConfigs returns the configurations of the topics whose
+// configuration is overridden in the kafka_sink_config option, which are
+// merged over the specified configuration of the sink. The topics whose
+// overrides leave the settings of the producer unchanged are emitted with the
+// producer of the sink and are omitted, and the topics whose overrides apply
+// the same settings share a configuration, and so a producer.
+func buildKafkaTopicConfigs(
+	config *sarama.Config, kafkaOpts changefeedbase.KafkaSinkOptions, topics *TopicNamer,
+) (map[string]*sarama.Config, error) {
+	saramaCfg, err := getSaramaConfig(kafkaOpts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"failed to parse sarama config; check %s option", changefeedbase.OptKafkaSinkConfig)
+	}
+	if len(saramaCfg.Topics) == 0 {
+		return nil, nil
+	}
+
+	targetTopics := make(map[string]struct{})
+	if err := topics.Each(func(topic string) error {
+		targetTopics[topic] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	topicCfgs := make(map[string]*sarama.Config, len(saramaCfg.Topics))
+	sharedCfgs := make(map[string]*sarama.Config)
+	for topic := range saramaCfg.Topics {
+		if _, ok := targetTopics[topic]; !ok {
+			return nil, errors.Errorf(
+				"%s overrides the configuration of topic %q, which is not emitted to by the changefeed",
+				changefeedbase.OptKafkaSinkConfig, topic)
+		}
+		topicCfg, err := saramaCfg.topicConfig(topic)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to parse sarama config of topic %q; check %s option",
+				topic, changefeedbase.OptKafkaSinkConfig)
+		}
+		if err := topicCfg.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid sarama configuration of topic %q", topic)
+		}
+		settings := topicCfg.producerSettings()
+		if settings == saramaCfg.producerSettings() {
+			continue
+		}
+		if shared, ok := sharedCfgs[settings]; ok {
+			topicCfgs[topic] = shared
+			continue
+		}
+		// The configuration of the sink is copied, so that the topic inherits
+		// its connection settings.
+		topicConfig := *config
+		if err := topicCfg.Apply(&topicConfig); err != nil {
+			return nil, errors.Wrapf(err, "failed to apply kafka client configuration of topic %q", topic)
+		}
+		topicCfgs[topic] = &topicConfig
+		sharedCfgs[settings] = &topicConfig
+	}
+	return topicCfgs, nil
+}
+
 func makeKafkaSink(
 	ctx context.Context,
 	u sinkURL,
 	targets changefeedbase.Targets,
-	jsonStr changefeedbase.SinkSpecificJSONConfig,
+	kafkaOpts changefeedbase.KafkaSinkOptions,
 	settings *cluster.Settings,
+	jobID jobspb.JobID,
 	mb metricsRecorderBuilder,
+	tlsReloader *sinkTLSReloader,
 ) (Sink, error) {
 	kafkaTopicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
 	kafkaTopicName := u.consumeParam(changefeedbase.SinkParamTopicName)
 	if schemaTopic := u.consumeParam(changefeedbase.SinkParamSchemaTopic); schemaTopic != `` {
 		return nil, errors.Errorf(`%s is not yet supported`, changefeedbase.SinkParamSchemaTopic)
 	}
+	// The clients of the sink are shared with the sinks of the other
+	// changefeeds which emit to the same brokers with the same configuration,
+	// whatever their topics.
+	connectionKey := makeSinkConnectionKey(changefeedbase.SinkSchemeKafka, u.Host,
+		u.Scheme, u.q.Encode(), fmt.Sprintf("%+v", kafkaOpts))
 
-	config, err := buildKafkaConfig(u, jsonStr)
+	config, err := buildKafkaConfig(ctx, u, kafkaOpts)
 	if err != nil {
 		return nil, err
 	}
+	if err := tlsReloader.apply(ctx, config.Net.TLS.Config, false /* systemRoots */); err != nil {
+		return nil, err
+	}
+	// Message headers were introduced along with the record batches of kafka
+	// 0.11; the producer silently drops them when talking to older brokers.
+	if len(kafkaOpts.Headers) > 0 && !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.Errorf(`%s requires kafka version 0.11.0 or later, but Version is %s`,
+			changefeedbase.OptKafkaHeaders, config.Version)
+	}
 
 	topics, err := MakeTopicNamer(
 		targets,
@@ -910,15 +2016,57 @@ func makeKafkaSink(
 		return nil, err
 	}
 
+	saramaCfg, err := getSaramaConfig(kafkaOpts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"failed to parse sarama config; check %s option", changefeedbase.OptKafkaSinkConfig)
+	}
+	if saramaCfg.ExactlyOnce {
+		if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+			return nil, errors.Errorf(
+				`unknown kafka sink query parameters: %s`, strings.Join(unknownParams, ", "))
+		}
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		return makeKafkaTransactionalSink(ctx, u.Host, config, saramaCfg, topics, jobID, mb)
+	}
+	if saramaCfg.PartitionInflight != 0 {
+		return nil, errors.Errorf(`PartitionInflight requires the %s sink`, changefeedbase.SinkSchemeKafkaV2)
+	}
+
+	topicCfgs, err := buildKafkaTopicConfigs(config, kafkaOpts, topics)
+	if err != nil {
+		return nil, err
+	}
+
 	internalRetryEnabled := settings != nil && changefeedbase.BatchReductionRetryEnabled.Get(&settings.SV)
+	maxInflightBytes := saramaCfg.MaxInflight.Bytes
+	if maxInflightBytes == 0 && settings != nil {
+		maxInflightBytes = changefeedbase.KafkaMaxInflightBytes.Get(&settings.SV)
+	}
 
 	sink := &kafkaSink{
 		ctx:                  ctx,
 		kafkaCfg:             config,
+		topicCfgs:            topicCfgs,
 		bootstrapAddrs:       u.Host,
 		metrics:              mb(requiresResourceAccounting),
 		topics:               topics,
+		partitionExpr:        saramaCfg.Partitioner.Expr,
 		disableInternalRetry: !internalRetryEnabled,
+		maxInflightMessages:  saramaCfg.MaxInflight.Messages,
+		maxInflightBytes:     maxInflightBytes,
+		connectionKey:        connectionKey,
+	}
+	// The sinks whose certificates are reloaded do not share their clients,
+	// since the TLS configuration of their clients is their own.
+	if tlsReloader == nil {
+		sink.settings = settings
+	}
+	sink.topicCreator, err = makeKafkaTopicCreator(saramaCfg, func() (kafkaClusterAdmin, error) {
+		return newKafkaClusterAdmin(sink.knobs, sink.bootstrapAddrs, sink.kafkaCfg)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
@@ -20,14 +20,24 @@ import (
 )
 
 func parseAndValidateKafkaSinkURI(
-	ctx context.Context, _ interface{}, _ username.SQLUsername, uri *url.URL,
+	ctx context.Context, execCfg interface{}, _ username.SQLUsername, uri *url.URL,
 ) (externalconn.ExternalConnection, error) {
 	// Validate the kafka URI by creating a kafka sink and throwing it away.
 	//
 	// TODO(adityamaru): When we add `CREATE EXTERNAL CONNECTION ... WITH` support
 	// to accept JSONConfig we should validate that here too.
-	_, err := makeKafkaSink(ctx, sinkURL{URL: uri}, changefeedbase.Targets{}, "",
-		nil, nilMetricsRecorderBuilder)
+	sinkURI := *uri
+	tlsReloader, err := makeConnectionTLSReloader(execCfg, &sinkURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Kafka URI")
+	}
+	if uri.Scheme == changefeedbase.SinkSchemeKafkaV2 {
+		_, err = makeKafkaV2Sink(ctx, sinkURL{URL: &sinkURI}, changefeedbase.Targets{},
+			changefeedbase.KafkaSinkOptions{}, nilMetricsRecorderBuilder, tlsReloader)
+	} else {
+		_, err = makeKafkaSink(ctx, sinkURL{URL: &sinkURI}, changefeedbase.Targets{}, changefeedbase.KafkaSinkOptions{},
+			nil, 0 /* jobID */, nilMetricsRecorderBuilder, tlsReloader)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid Kafka URI")
 	}
@@ -46,4 +56,6 @@ func parseAndValidateKafkaSinkURI(
 func init() {
 	externalconn.RegisterConnectionDetailsFromURIFactory(changefeedbase.SinkSchemeKafka,
 		parseAndValidateKafkaSinkURI)
+	externalconn.RegisterConnectionDetailsFromURIFactory(changefeedbase.SinkSchemeKafkaV2,
+		parseAndValidateKafkaSinkURI)
 }
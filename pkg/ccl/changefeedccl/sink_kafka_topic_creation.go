@@ -0,0 +1,173 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/errors"
+)
+
+// kafkaTopicCreationConfig is the configuration of the topics which the kafka
+// sink creates, e.g. {"TopicCreation": {"Partitions": 12,
+// "ReplicationFactor": 3, "Configs": {"cleanup.policy": "compact"}}}. When it
+// is set, the sink creates the topics it emits to which do not exist yet,
+// rather than leaving them to the automatic topic creation of the brokers,
+// whose defaults rarely suit the topics of a changefeed.
+type kafkaTopicCreationConfig struct {
+	Partitions        int32 `json:",omitempty"`
+	ReplicationFactor int16 `json:",omitempty"`
+	// Configs overrides the configuration of the created topics, e.g.
+	// retention.ms or cleanup.policy.
+	Configs map[string]string `json:",omitempty"`
+}
+
+func (c kafkaTopicCreationConfig) enabled() bool {
+	return c.Partitions != 0 || c.ReplicationFactor != 0 || len(c.Configs) > 0
+}
+
+// Validate checks that the topics can be created with the configuration. The
+// version of the CreateTopics request sent by sarama cannot defer the number
+// of partitions or the replication factor to the defaults of the brokers.
+func (c kafkaTopicCreationConfig) Validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.Partitions <= 0 {
+		return errors.New("TopicCreation.Partitions must be > 0")
+	}
+	if c.ReplicationFactor <= 0 {
+		return errors.New("TopicCreation.ReplicationFactor must be > 0")
+	}
+	return nil
+}
+
+func (c kafkaTopicCreationConfig) topicDetail() *sarama.TopicDetail {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+	}
+	if len(c.Configs) > 0 {
+		detail.ConfigEntries = make(map[string]*string, len(c.Configs))
+		for k, v := range c.Configs {
+			v := v
+			detail.ConfigEntries[k] = &v
+		}
+	}
+	return detail
+}
+
+// kafkaClusterAdmin is the subset of sarama.ClusterAdmin with which the kafka
+// sinks create their topics.
+type kafkaClusterAdmin interface {
+	DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error)
+	CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	Close() error
+}
+
+func newKafkaClusterAdmin(
+	knobs kafkaSinkKnobs, bootstrapAddrs string, config *sarama.Config,
+) (kafkaClusterAdmin, error) {
+	if knobs.OverrideClusterAdminInit != nil {
+		return knobs.OverrideClusterAdminInit(config)
+	}
+	admin, err := sarama.NewClusterAdmin(strings.Split(bootstrapAddrs, `,`), config)
+	if err != nil {
+		return nil, pgerror.Wrapf(err, pgcode.CannotConnectNow,
+			`connecting to kafka: %s`, bootstrapAddrs)
+	}
+	return admin, nil
+}
+
+// kafkaTopicCreator creates the topics of a kafka sink which do not exist yet,
+// before the sink first emits to them.
+type kafkaTopicCreator struct {
+	config kafkaTopicCreationConfig
+	// overrides are the configurations of the topics whose configuration is
+	// overridden in the kafka_sink_config option, keyed by topic name.
+	overrides map[string]kafkaTopicCreationConfig
+	newAdmin  func() (kafkaClusterAdmin, error)
+	// existing are the topics which are known to exist.
+	existing map[string]struct{}
+}
+
+// makeKafkaTopicCreator returns the topic creator of the sink, or nil if the
+// sink does not create any of its topics.
+func makeKafkaTopicCreator(
+	saramaCfg *saramaConfig, newAdmin func() (kafkaClusterAdmin, error),
+) (*kafkaTopicCreator, error) {
+	c := &kafkaTopicCreator{
+		config:   saramaCfg.TopicCreation,
+		newAdmin: newAdmin,
+		existing: make(map[string]struct{}),
+	}
+	enabled := c.config.enabled()
+	for topic := range saramaCfg.Topics {
+		topicCfg, err := saramaCfg.topicConfig(topic)
+		if err != nil {
+			return nil, err
+		}
+		if c.overrides == nil {
+			c.overrides = make(map[string]kafkaTopicCreationConfig, len(saramaCfg.Topics))
+		}
+		c.overrides[topic] = topicCfg.TopicCreation
+		enabled = enabled || topicCfg.TopicCreation.enabled()
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// ensureTopics creates the specified topics which do not exist yet. The names
+// of the topics of split column families which are not known before their
+// rows are emitted are skipped.
+func (c *kafkaTopicCreator) ensureTopics(topics ...string) error {
+	if c == nil {
+		return nil
+	}
+	var unknown []string
+	for _, topic := range topics {
+		if _, ok := c.existing[topic]; !ok && !strings.Contains(topic, familyPlaceholder) {
+			unknown = append(unknown, topic)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	admin, err := c.newAdmin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = admin.Close() }()
+	metadata, err := admin.DescribeTopics(unknown)
+	if err != nil {
+		return errors.Wrap(err, "describing kafka topics")
+	}
+	for _, m := range metadata {
+		config, ok := c.overrides[m.Name]
+		if !ok {
+			config = c.config
+		}
+		if m.Err == sarama.ErrUnknownTopicOrPartition && config.enabled() {
+			err := admin.CreateTopic(m.Name, config.topicDetail(), false /* validateOnly */)
+			// The topic may have been created by another sink of the changefeed.
+			var topicErr *sarama.TopicError
+			if err != nil && !(errors.As(err, &topicErr) && topicErr.Err == sarama.ErrTopicAlreadyExists) {
+				return errors.Wrapf(err, "creating kafka topic %s", m.Name)
+			}
+		}
+		c.existing[m.Name] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKafkaClusterAdmin records the topics created through it.
+type fakeKafkaClusterAdmin struct {
+	topics   map[string]*sarama.TopicDetail
+	describe int
+	// racing is a topic which is described as missing but already exists when
+	// it is created, as if another sink created it in between.
+	racing string
+}
+
+var _ kafkaClusterAdmin = (*fakeKafkaClusterAdmin)(nil)
+
+func (a *fakeKafkaClusterAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	a.describe++
+	var metadata []*sarama.TopicMetadata
+	for _, topic := range topics {
+		m := &sarama.TopicMetadata{Name: topic}
+		if _, ok := a.topics[topic]; !ok || topic == a.racing {
+			m.Err = sarama.ErrUnknownTopicOrPartition
+		}
+		metadata = append(metadata, m)
+	}
+	return metadata, nil
+}
+
+func (a *fakeKafkaClusterAdmin) CreateTopic(
+	topic string, detail *sarama.TopicDetail, _ bool,
+) error {
+	if _, ok := a.topics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	a.topics[topic] = detail
+	return nil
+}
+
+func (a *fakeKafkaClusterAdmin) Close() error { return nil }
+
+func TestKafkaTopicCreator(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// Topics are only created if the sink is configured to.
+	cfg, err := getSaramaConfig(`{"Flush": {"Messages": 1, "Frequency": "1s"}}`)
+	require.NoError(t, err)
+	c, err := makeKafkaTopicCreator(cfg, nil)
+	require.NoError(t, err)
+	require.Nil(t, c)
+	require.NoError(t, c.ensureTopics("foo"))
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{`{"TopicCreation": {"Partitions": 3}}`, `TopicCreation.ReplicationFactor must be > 0`},
+		{`{"TopicCreation": {"ReplicationFactor": 3}}`, `TopicCreation.Partitions must be > 0`},
+		{`{"TopicCreation": {"Configs": {"cleanup.policy": "compact"}}}`, `TopicCreation.Partitions must be > 0`},
+	} {
+		cfg, err := getSaramaConfig(changefeedbase.SinkSpecificJSONConfig(tc.config))
+		require.NoError(t, err)
+		require.EqualError(t, cfg.Validate(), tc.err)
+	}
+
+	cfg, err = getSaramaConfig(`{
+		"TopicCreation": {"Partitions": 6, "ReplicationFactor": 3, "Configs": {"retention.ms": "3600000"}},
+		"Topics": {"bar": {"TopicCreation": {"Partitions": 1, "Configs": {"cleanup.policy": "compact"}}}}
+	}`)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	admin := &fakeKafkaClusterAdmin{topics: map[string]*sarama.TopicDetail{"existing": nil}}
+	c, err = makeKafkaTopicCreator(cfg, func() (kafkaClusterAdmin, error) { return admin, nil })
+	require.NoError(t, err)
+
+	// The topics of split column families are created once their names are
+	// known, and the existing topics are left alone.
+	require.NoError(t, c.ensureTopics("foo", "bar", "existing", "baz.{family}"))
+	retention, compact := "3600000", "compact"
+	require.Equal(t, map[string]*sarama.TopicDetail{
+		"existing": nil,
+		"foo": {NumPartitions: 6, ReplicationFactor: 3, ConfigEntries: map[string]*string{
+			"retention.ms": &retention,
+		}},
+		"bar": {NumPartitions: 1, ReplicationFactor: 3, ConfigEntries: map[string]*string{
+			"retention.ms": &retention, "cleanup.policy": &compact,
+		}},
+	}, admin.topics)
+	// The configs of the sink are not changed by those of the topics.
+	require.Equal(t, map[string]string{"retention.ms": "3600000"}, cfg.TopicCreation.Configs)
+
+	// The topics are only looked up once.
+	require.Equal(t, 1, admin.describe)
+	require.NoError(t, c.ensureTopics("foo", "bar"))
+	require.Equal(t, 1, admin.describe)
+	require.NoError(t, c.ensureTopics("baz.primary"))
+	require.Equal(t, 2, admin.describe)
+	require.Contains(t, admin.topics, "baz.primary")
+
+	// A topic created concurrently by another sink is not an error.
+	admin.racing = "qux"
+	admin.topics["qux"] = nil
+	require.NoError(t, c.ensureTopics("qux"))
+	require.Nil(t, admin.topics["qux"])
+}
@@ -0,0 +1,793 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+)
+
+// kafkaDefaultTransactionTimeout is the default timeout of the transactions
+// of the exactly-once kafka sink, after which the brokers abort the
+// transactions which were not committed.
+const kafkaDefaultTransactionTimeout = time.Minute
+
+// kafkaTransactionBatchBytes is the size of the messages after which the
+// messages of a partition are sent in another produce request.
+const kafkaTransactionBatchBytes = 512 << 10
+
+// kafkaProducerFencedErrorCode is the code of the PRODUCER_FENCED error,
+// which newer brokers return instead of INVALID_PRODUCER_EPOCH when a
+// transaction was aborted, and which sarama does not define.
+const kafkaProducerFencedErrorCode = sarama.KError(90)
+
+// kafkaProduceClient is the interface of the kafka requests issued by the
+// kafka sinks which produce their batches of messages themselves rather than
+// through the sarama producers.
+type kafkaProduceClient interface {
+	// Partitions returns the sorted list of all partition IDs for the given topic.
+	Partitions(topic string) ([]int32, error)
+	// RefreshMetadata refreshes the metadata of the specified topics.
+	RefreshMetadata(topics ...string) error
+	// Produce sends the batch to the partition, as part of the transaction if
+	// txn is set. It returns the time for which the broker throttles the
+	// producer to enforce its quotas, during which the producer is expected
+	// not to send it more requests.
+	Produce(
+		ctx context.Context,
+		txn *jobspb.ChangefeedSinkTransaction,
+		topic string,
+		partition int32,
+		batch *sarama.RecordBatch,
+	) (throttle time.Duration, err error)
+	// Close closes kafka connection.
+	Close() error
+}
+
+// kafkaTransactionalClient is the interface of the kafka requests issued by
+// the exactly-once kafka sink, which are not exposed by the sarama producers.
+type kafkaTransactionalClient interface {
+	kafkaProduceClient
+	// InitProducerID returns the producer id and epoch of the transactional id,
+	// aborting its transaction in progress, if any.
+	InitProducerID(
+		ctx context.Context, txnID string, timeout time.Duration,
+	) (producerID int64, epoch int16, err error)
+	// AddPartitionsToTxn adds the partitions, keyed by topic, to the
+	// transaction.
+	AddPartitionsToTxn(
+		ctx context.Context, txn jobspb.ChangefeedSinkTransaction, partitions map[string][]int32,
+	) error
+	// EndTxn commits, or aborts, the transaction.
+	EndTxn(ctx context.Context, txn jobspb.ChangefeedSinkTransaction, commit bool) error
+}
+
+// kafkaTransactionalSink is the kafka sink used with the ExactlyOnce kafka
+// sink config. The rows are retained until they are emitted in a transaction
+// by PrepareUpTo, which is committed by CommitTransactions once it is
+// recorded in the job progress (see sinkTransactions), so that consumers
+// reading with the read_committed isolation level see each row exactly once.
+// It is not concurrency-safe.
+type kafkaTransactionalSink struct {
+	ctx            context.Context
+	bootstrapAddrs string
+	kafkaCfg       *sarama.Config
+	client         kafkaTransactionalClient
+	topics         *TopicNamer
+	metrics        metricsRecorder
+	knobs          kafkaSinkKnobs
+
+	// txnIDPrefix prefixes the transactional ids of the transactions of the
+	// sink. Every transaction has its own transactional id, since initializing
+	// the producer of a transactional id aborts the transaction in progress,
+	// which may be recorded but not yet committed by the changefeed.
+	txnIDPrefix string
+	nextTxnID   int
+	txnTimeout  time.Duration
+
+	// rows are the rows emitted since they were last prepared, in the order
+	// they were emitted.
+	rows []*sarama.ProducerMessage
+	// partitioners are the partitioners of the topics.
+	partitioners map[string]sarama.Partitioner
+	// partitionExpr is the expression of the column partitioner, if the sink
+	// is configured with it (see kafkaPartitionerConfig).
+	partitionExpr string
+	// topicCreator, if set, creates the topics of the sink which do not exist
+	// yet.
+	topicCreator *kafkaTopicCreator
+
+	lastMetadataRefresh time.Time
+	scratch             bufalloc.ByteAllocator
+}
+
+var _ TransactionalEventSink = (*kafkaTransactionalSink)(nil)
+var _ TransactionCommittingSink = (*kafkaTransactionalSink)(nil)
+var _ PartitionedEventSink = (*kafkaTransactionalSink)(nil)
+var _ HeaderedEventSink = (*kafkaTransactionalSink)(nil)
+var _ PathPartitionedEventSink = (*kafkaTransactionalSink)(nil)
+
+func makeKafkaTransactionalSink(
+	ctx context.Context,
+	bootstrapAddrs string,
+	config *sarama.Config,
+	saramaCfg *saramaConfig,
+	topics *TopicNamer,
+	jobID jobspb.JobID,
+	mb metricsRecorderBuilder,
+) (*kafkaTransactionalSink, error) {
+	if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.Errorf(
+			"ExactlyOnce requires kafka version 0.11.0.0 or later, found %s", config.Version)
+	}
+	txnTimeout := time.Duration(saramaCfg.TransactionTimeout)
+	if txnTimeout == 0 {
+		txnTimeout = kafkaDefaultTransactionTimeout
+	}
+	// The transactional ids must not be reused by another sink of the job, nor
+	// by the sink of the restarted job.
+	sinkID := uuid.MakeV4().Short()
+	sink := &kafkaTransactionalSink{
+		ctx:            ctx,
+		bootstrapAddrs: bootstrapAddrs,
+		kafkaCfg:       config,
+		topics:         topics,
+		metrics:        mb(requiresResourceAccounting),
+		txnIDPrefix:    fmt.Sprintf("crdb-changefeed-%d-%s", jobID, sinkID),
+		txnTimeout:     txnTimeout,
+		partitioners:   make(map[string]sarama.Partitioner),
+		partitionExpr:  saramaCfg.Partitioner.Expr,
+	}
+	var err error
+	sink.topicCreator, err = makeKafkaTopicCreator(saramaCfg, func() (kafkaClusterAdmin, error) {
+		return newKafkaClusterAdmin(sink.knobs, sink.bootstrapAddrs, sink.kafkaCfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Dial implements the Sink interface.
+func (s *kafkaTransactionalSink) Dial() error {
+	if s.knobs.OverrideTransactionalClientInit != nil {
+		client, err := s.knobs.OverrideTransactionalClientInit(s.kafkaCfg)
+		if err != nil {
+			return err
+		}
+		s.client = client
+		return s.topicCreator.ensureTopics(s.topics.DisplayNamesSlice()...)
+	}
+	client, err := sarama.NewClient(strings.Split(s.bootstrapAddrs, `,`), s.kafkaCfg)
+	if err != nil {
+		return pgerror.Wrapf(err, pgcode.CannotConnectNow,
+			`connecting to kafka: %s`, s.bootstrapAddrs)
+	}
+	s.client = &saramaTransactionalClient{Client: client}
+	return s.topicCreator.ensureTopics(s.topics.DisplayNamesSlice()...)
+}
+
+// EmitRow implements the Sink interface.
+func (s *kafkaTransactionalSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	return s.EmitRowToPartition(ctx, topicDescr, key, value, updated, mvcc, alloc, -1)
+}
+
+// EmitRowToPartition implements the PartitionedEventSink interface. A
+// negative partition derives the partition from the key of the row.
+func (s *kafkaTransactionalSink) EmitRowToPartition(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+) error {
+	return s.EmitRowWithHeaders(ctx, topicDescr, key, value, updated, mvcc, alloc, partition, nil)
+}
+
+// EmitRowWithHeaders implements the HeaderedEventSink interface.
+func (s *kafkaTransactionalSink) EmitRowWithHeaders(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+	headers []messageHeader,
+) error {
+	topic, err := s.topics.Name(topicDescr)
+	if err != nil {
+		return err
+	}
+	if err := s.topicCreator.ensureTopics(topic); err != nil {
+		return err
+	}
+	s.rows = append(s.rows, &sarama.ProducerMessage{
+		Topic:     topic,
+		Key:       sarama.ByteEncoder(key),
+		Value:     sarama.ByteEncoder(value),
+		Headers:   makeKafkaRecordHeaders(headers),
+		Partition: partition,
+		Timestamp: timeutil.Now(),
+		Metadata: messageMetadata{
+			alloc:             alloc,
+			updated:           updated,
+			mvcc:              mvcc,
+			updateMetrics:     s.metrics.recordOneMessage(),
+			explicitPartition: partition >= 0,
+		},
+	})
+	return nil
+}
+
+// PartitionExprs implements the PathPartitionedEventSink interface.
+func (s *kafkaTransactionalSink) PartitionExprs() []string {
+	if s.partitionExpr == "" {
+		return nil
+	}
+	return []string{s.partitionExpr}
+}
+
+// EmitRowWithPartitionValues implements the PathPartitionedEventSink
+// interface.
+func (s *kafkaTransactionalSink) EmitRowWithPartitionValues(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partitionValues []string,
+) error {
+	partitionKey, err := columnPartitionKey(partitionValues)
+	if err != nil {
+		return err
+	}
+	if err := s.EmitRow(ctx, topicDescr, key, value, updated, mvcc, alloc); err != nil {
+		return err
+	}
+	m := s.rows[len(s.rows)-1].Metadata.(messageMetadata)
+	m.partitionKey = partitionKey
+	s.rows[len(s.rows)-1].Metadata = m
+	return nil
+}
+
+// Flush implements the Sink interface. The rows are only emitted, in a
+// transaction, by PrepareUpTo, so there is nothing to flush.
+func (s *kafkaTransactionalSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// PrepareUpTo implements the TransactionalEventSink interface.
+func (s *kafkaTransactionalSink) PrepareUpTo(
+	ctx context.Context, ts hlc.Timestamp,
+) (*jobspb.ChangefeedSinkTransaction, error) {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	var prepared, retained []*sarama.ProducerMessage
+	for _, m := range s.rows {
+		if m.Metadata.(messageMetadata).updated.LessEq(ts) {
+			prepared = append(prepared, m)
+		} else {
+			retained = append(retained, m)
+		}
+	}
+	if len(prepared) == 0 {
+		return nil, nil
+	}
+
+	batches, err := s.partition(prepared)
+	if err != nil {
+		return nil, err
+	}
+
+	txnID := fmt.Sprintf("%s-%d", s.txnIDPrefix, s.nextTxnID)
+	s.nextTxnID++
+	producerID, epoch, err := s.client.InitProducerID(ctx, txnID, s.txnTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "initializing kafka transaction %s", txnID)
+	}
+	txn := &jobspb.ChangefeedSinkTransaction{
+		TransactionalID: txnID,
+		ProducerID:      producerID,
+		ProducerEpoch:   int32(epoch),
+	}
+	if err := s.produce(ctx, txn, batches); err != nil {
+		// The rows remain retained and are emitted in the next transaction, or by
+		// the restarted changefeed.
+		if abortErr := s.client.EndTxn(ctx, *txn, false /* commit */); abortErr != nil {
+			log.Warningf(ctx, "failed to abort kafka transaction %s: %v", txnID, abortErr)
+		}
+		return nil, err
+	}
+
+	for _, m := range prepared {
+		meta := m.Metadata.(messageMetadata)
+		meta.updateMetrics(meta.mvcc, m.Key.Length()+m.Value.Length(), sinkDoesNotCompress)
+		meta.alloc.Release(ctx)
+	}
+	s.rows = retained
+	return txn, nil
+}
+
+// kafkaPartitionKey identifies a partition of a topic.
+type kafkaPartitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partition groups the messages by the partition they are emitted to.
+func (s *kafkaTransactionalSink) partition(
+	msgs []*sarama.ProducerMessage,
+) (map[kafkaPartitionKey][]*sarama.ProducerMessage, error) {
+	batches := make(map[kafkaPartitionKey][]*sarama.ProducerMessage)
+	numPartitions := make(map[string]int32)
+	for _, m := range msgs {
+		n, ok := numPartitions[m.Topic]
+		if !ok {
+			partitions, err := s.client.Partitions(m.Topic)
+			if err != nil {
+				return nil, err
+			}
+			n = int32(len(partitions))
+			numPartitions[m.Topic] = n
+		}
+		p, ok := s.partitioners[m.Topic]
+		if !ok {
+			p = s.kafkaCfg.Producer.Partitioner(m.Topic)
+			s.partitioners[m.Topic] = p
+		}
+		partition, err := p.Partition(m, n)
+		if err != nil {
+			return nil, err
+		}
+		key := kafkaPartitionKey{topic: m.Topic, partition: partition}
+		batches[key] = append(batches[key], m)
+	}
+	return batches, nil
+}
+
+// produce adds the partitions to the transaction and emits the messages.
+func (s *kafkaTransactionalSink) produce(
+	ctx context.Context,
+	txn *jobspb.ChangefeedSinkTransaction,
+	batches map[kafkaPartitionKey][]*sarama.ProducerMessage,
+) error {
+	keys := make([]kafkaPartitionKey, 0, len(batches))
+	partitions := make(map[string][]int32)
+	for k := range batches {
+		keys = append(keys, k)
+		partitions[k.topic] = append(partitions[k.topic], k.partition)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+	if err := s.client.AddPartitionsToTxn(ctx, *txn, partitions); err != nil {
+		return errors.Wrapf(err, "adding partitions to kafka transaction %s", txn.TransactionalID)
+	}
+
+	for _, k := range keys {
+		// The sequence numbers of a producer start at 0 in every partition.
+		var sequence int32
+		msgs := batches[k]
+		for len(msgs) > 0 {
+			n, size := 0, 0
+			for n < len(msgs) && size < kafkaTransactionBatchBytes &&
+				(s.kafkaCfg.Producer.Flush.MaxMessages == 0 || n < s.kafkaCfg.Producer.Flush.MaxMessages) {
+				size += msgs[n].Key.Length() + msgs[n].Value.Length()
+				n++
+			}
+			batch := s.recordBatch(msgs[:n], txn.ProducerID, int16(txn.ProducerEpoch), sequence)
+			throttle, err := s.client.Produce(ctx, txn, k.topic, k.partition, batch)
+			if err != nil {
+				return errors.Wrapf(err, "emitting to partition %d of topic %s", k.partition, k.topic)
+			}
+			if err := s.waitThrottled(ctx, throttle); err != nil {
+				return err
+			}
+			sequence += int32(n)
+			msgs = msgs[n:]
+		}
+	}
+	return nil
+}
+
+// waitThrottled waits for the time for which a broker throttles the sink, so
+// that the changefeed slows down to the quota of the sink rather than have its
+// next requests delayed by the broker.
+func (s *kafkaTransactionalSink) waitThrottled(ctx context.Context, throttle time.Duration) error {
+	if throttle <= 0 {
+		return nil
+	}
+	if log.V(1) {
+		log.Infof(ctx, "kafka broker throttled the sink for %s", throttle)
+	}
+	start := timeutil.Now()
+	defer func() { s.metrics.recordSinkBackpressure(timeutil.Since(start)) }()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(throttle):
+		return nil
+	}
+}
+
+// recordBatch returns the batch of the messages. The batch is transactional
+// unless the producer id is negative.
+func (s *kafkaTransactionalSink) recordBatch(
+	msgs []*sarama.ProducerMessage, producerID int64, epoch int16, sequence int32,
+) *sarama.RecordBatch {
+	first, last := msgs[0].Timestamp, msgs[0].Timestamp
+	records := make([]*sarama.Record, len(msgs))
+	for i, m := range msgs {
+		if m.Timestamp.Before(first) {
+			first = m.Timestamp
+		}
+		if m.Timestamp.After(last) {
+			last = m.Timestamp
+		}
+		records[i] = &sarama.Record{OffsetDelta: int64(i)}
+		if m.Key != nil {
+			records[i].Key, _ = m.Key.Encode()
+		}
+		if m.Value != nil {
+			records[i].Value, _ = m.Value.Encode()
+		}
+		for j := range m.Headers {
+			records[i].Headers = append(records[i].Headers, &m.Headers[j])
+		}
+	}
+	for i, m := range msgs {
+		records[i].TimestampDelta = m.Timestamp.Sub(first)
+	}
+	return &sarama.RecordBatch{
+		Version:          2,
+		Codec:            s.kafkaCfg.Producer.Compression,
+		CompressionLevel: s.kafkaCfg.Producer.CompressionLevel,
+		FirstTimestamp:   first,
+		MaxTimestamp:     last,
+		ProducerID:       producerID,
+		ProducerEpoch:    epoch,
+		FirstSequence:    sequence,
+		IsTransactional:  producerID >= 0,
+		LastOffsetDelta:  int32(len(msgs) - 1),
+		Records:          records,
+	}
+}
+
+// CommitTransactions implements the TransactionCommittingSink interface.
+// Committing a transaction which was already committed succeeds.
+func (s *kafkaTransactionalSink) CommitTransactions(
+	ctx context.Context, txns []jobspb.ChangefeedSinkTransaction,
+) error {
+	for _, txn := range txns {
+		err := s.client.EndTxn(ctx, txn, true /* commit */)
+		if err == nil {
+			continue
+		}
+		var kErr sarama.KError
+		if errors.As(err, &kErr) {
+			switch kErr {
+			case sarama.ErrInvalidProducerEpoch, sarama.ErrInvalidTxnState,
+				sarama.ErrInvalidProducerIDMapping, kafkaProducerFencedErrorCode:
+				// The transaction was aborted by the brokers, most likely because it
+				// was not committed within the transaction timeout, and its rows are
+				// lost.
+				return errors.Mark(errors.Wrapf(err,
+					"kafka transaction %s was aborted before it was committed; "+
+						"consider increasing the TransactionTimeout of the kafka sink config",
+					txn.TransactionalID), errSinkTransactionAborted)
+			}
+		}
+		return errors.Wrapf(err, "committing kafka transaction %s", txn.TransactionalID)
+	}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved
+// timestamps are emitted outside of transactions.
+func (s *kafkaTransactionalSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	// See kafkaSink.EmitResolvedTimestamp.
+	const metadataRefreshMinDuration = time.Minute
+	if timeutil.Since(s.lastMetadataRefresh) > metadataRefreshMinDuration {
+		if err := s.client.RefreshMetadata(s.topics.DisplayNamesSlice()...); err != nil {
+			return err
+		}
+		s.lastMetadataRefresh = timeutil.Now()
+	}
+
+	return s.topics.Each(func(topic string) error {
+		payload, err := encoder.EncodeResolvedTimestamp(ctx, topic, resolved)
+		if err != nil {
+			return err
+		}
+		s.scratch, payload = s.scratch.Copy(payload, 0 /* extraCap */)
+
+		partitions, err := s.client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		msg := &sarama.ProducerMessage{
+			Topic:     topic,
+			Value:     sarama.ByteEncoder(payload),
+			Timestamp: timeutil.Now(),
+		}
+		for _, partition := range partitions {
+			batch := s.recordBatch([]*sarama.ProducerMessage{msg}, -1, -1, -1)
+			throttle, err := s.client.Produce(ctx, nil /* txn */, topic, partition, batch)
+			if err != nil {
+				return err
+			}
+			if err := s.waitThrottled(ctx, throttle); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Topics implements the Sink interface.
+func (s *kafkaTransactionalSink) Topics() []string {
+	return s.topics.DisplayNamesSlice()
+}
+
+// Close implements the Sink interface.
+func (s *kafkaTransactionalSink) Close() error {
+	for _, m := range s.rows {
+		meta := m.Metadata.(messageMetadata)
+		meta.alloc.Release(s.ctx)
+	}
+	s.rows = nil
+	// s.client is only nil if the sink was not dialed.
+	if s.client != nil {
+		return s.client.Close()
+	}
+	return nil
+}
+
+// kafkaTransactionalRetryOptions are the options of the retries of the
+// requests of the exactly-once kafka sink which fail with retriable errors.
+var kafkaTransactionalRetryOptions = retry.Options{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	MaxRetries:     10,
+}
+
+// saramaTransactionalClient implements the kafkaTransactionalClient interface,
+// and so the kafkaProduceClient interface of the kafka-v2 sink, with the
+// requests of the sarama brokers.
+type saramaTransactionalClient struct {
+	sarama.Client
+}
+
+var _ kafkaTransactionalClient = (*saramaTransactionalClient)(nil)
+
+// isRetriableKafkaError returns true if the error is returned by the brokers
+// while the coordinators or the leaders move, or are loading their state.
+func isRetriableKafkaError(err error) bool {
+	var kErr sarama.KError
+	if !errors.As(err, &kErr) {
+		return false
+	}
+	switch kErr {
+	case sarama.ErrConsumerCoordinatorNotAvailable, sarama.ErrNotCoordinatorForConsumer,
+		sarama.ErrOffsetsLoadInProgress, sarama.ErrConcurrentTransactions,
+		sarama.ErrNotLeaderForPartition, sarama.ErrLeaderNotAvailable,
+		sarama.ErrRequestTimedOut, sarama.ErrNotEnoughReplicas,
+		sarama.ErrNotEnoughReplicasAfterAppend, sarama.ErrUnknownTopicOrPartition:
+		return true
+	default:
+		return false
+	}
+}
+
+// withRetries calls fn until it does not fail with a retriable error,
+// refreshing the metadata of the topics between the attempts.
+func (c *saramaTransactionalClient) withRetries(
+	ctx context.Context, topics []string, fn func() error,
+) error {
+	err := ctx.Err()
+	for r := retry.StartWithCtx(ctx, kafkaTransactionalRetryOptions); r.Next(); {
+		if err = fn(); err == nil || !isRetriableKafkaError(err) {
+			return err
+		}
+		if refreshErr := c.RefreshMetadata(topics...); refreshErr != nil {
+			log.Warningf(ctx, "failed to refresh kafka metadata: %v", refreshErr)
+		}
+	}
+	return err
+}
+
+// coordinator returns the transaction coordinator of the transactional id.
+func (c *saramaTransactionalClient) coordinator(txnID string) (*sarama.Broker, error) {
+	controller, err := c.Controller()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := controller.FindCoordinator(&sarama.FindCoordinatorRequest{
+		Version:         1,
+		CoordinatorKey:  txnID,
+		CoordinatorType: sarama.CoordinatorTransaction,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, resp.Err
+	}
+	broker, err := c.Broker(resp.Coordinator.ID())
+	if errors.Is(err, sarama.ErrBrokerNotFound) {
+		// The coordinator may have joined the cluster since the metadata was
+		// last refreshed.
+		if err := c.RefreshMetadata(); err != nil {
+			return nil, err
+		}
+		broker, err = c.Broker(resp.Coordinator.ID())
+	}
+	return broker, err
+}
+
+// InitProducerID implements the kafkaTransactionalClient interface.
+func (c *saramaTransactionalClient) InitProducerID(
+	ctx context.Context, txnID string, timeout time.Duration,
+) (producerID int64, epoch int16, err error) {
+	err = c.withRetries(ctx, nil /* topics */, func() error {
+		coordinator, err := c.coordinator(txnID)
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.InitProducerID(&sarama.InitProducerIDRequest{
+			TransactionalID:    &txnID,
+			TransactionTimeout: timeout,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Err != sarama.ErrNoError {
+			return resp.Err
+		}
+		producerID, epoch = resp.ProducerID, resp.ProducerEpoch
+		return nil
+	})
+	return producerID, epoch, err
+}
+
+// AddPartitionsToTxn implements the kafkaTransactionalClient interface.
+func (c *saramaTransactionalClient) AddPartitionsToTxn(
+	ctx context.Context, txn jobspb.ChangefeedSinkTransaction, partitions map[string][]int32,
+) error {
+	return c.withRetries(ctx, nil /* topics */, func() error {
+		coordinator, err := c.coordinator(txn.TransactionalID)
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.AddPartitionsToTxn(&sarama.AddPartitionsToTxnRequest{
+			TransactionalID: txn.TransactionalID,
+			ProducerID:      txn.ProducerID,
+			ProducerEpoch:   int16(txn.ProducerEpoch),
+			TopicPartitions: partitions,
+		})
+		if err != nil {
+			return err
+		}
+		for _, partitionErrs := range resp.Errors {
+			for _, partitionErr := range partitionErrs {
+				if partitionErr.Err != sarama.ErrNoError {
+					return partitionErr.Err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Produce implements the kafkaTransactionalClient interface.
+func (c *saramaTransactionalClient) Produce(
+	ctx context.Context,
+	txn *jobspb.ChangefeedSinkTransaction,
+	topic string,
+	partition int32,
+	batch *sarama.RecordBatch,
+) (time.Duration, error) {
+	var throttle time.Duration
+	err := c.withRetries(ctx, []string{topic}, func() error {
+		leader, err := c.Leader(topic, partition)
+		if err != nil {
+			return err
+		}
+		req := &sarama.ProduceRequest{
+			RequiredAcks: c.Config().Producer.RequiredAcks,
+			Timeout:      int32(c.Config().Producer.Timeout / time.Millisecond),
+			Version:      3,
+		}
+		// The brokers only accept the batches compressed with zstd in the
+		// requests of version 7 or later.
+		if batch.Codec == sarama.CompressionZSTD {
+			req.Version = 7
+		}
+		if txn != nil {
+			req.TransactionalID = &txn.TransactionalID
+		}
+		req.AddBatch(topic, partition, batch)
+		resp, err := leader.Produce(req)
+		if err != nil {
+			return err
+		}
+		// The brokers do not respond to the producers which require no
+		// acknowledgements.
+		if resp == nil {
+			return nil
+		}
+		throttle = resp.ThrottleTime
+		block := resp.GetBlock(topic, partition)
+		if block == nil {
+			return errors.Errorf("no response for partition %d of topic %s", partition, topic)
+		}
+		// A duplicate sequence number means that the batch was already written by
+		// a previous attempt.
+		if block.Err != sarama.ErrNoError && block.Err != sarama.ErrDuplicateSequenceNumber {
+			return block.Err
+		}
+		return nil
+	})
+	return throttle, err
+}
+
+// EndTxn implements the kafkaTransactionalClient interface.
+func (c *saramaTransactionalClient) EndTxn(
+	ctx context.Context, txn jobspb.ChangefeedSinkTransaction, commit bool,
+) error {
+	return c.withRetries(ctx, nil /* topics */, func() error {
+		coordinator, err := c.coordinator(txn.TransactionalID)
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.EndTxn(&sarama.EndTxnRequest{
+			TransactionalID:   txn.TransactionalID,
+			ProducerID:        txn.ProducerID,
+			ProducerEpoch:     int16(txn.ProducerEpoch),
+			TransactionResult: commit,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Err != sarama.ErrNoError {
+			return resp.Err
+		}
+		return nil
+	})
+}
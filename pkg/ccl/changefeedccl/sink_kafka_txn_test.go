@@ -0,0 +1,335 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKafkaTransactionalClient emulates the transactions of the kafka
+// brokers.
+type fakeKafkaTransactionalClient struct {
+	partitions     int32
+	nextProducerID int64
+	// open are the values produced by the open transactions, keyed by
+	// transactional id.
+	open map[string][]string
+	// ended are the transactions which were committed, or aborted, keyed by
+	// transactional id.
+	ended map[string]bool
+	// committed are the values of the committed transactions, along with the
+	// values produced outside of transactions.
+	committed []string
+	// sequences are the sequence numbers of the produced batches.
+	sequences  []int32
+	produceErr error
+	// throttle is the time for which the produce requests are throttled.
+	throttle time.Duration
+}
+
+var _ kafkaTransactionalClient = (*fakeKafkaTransactionalClient)(nil)
+
+func (c *fakeKafkaTransactionalClient) Partitions(topic string) ([]int32, error) {
+	partitions := make([]int32, c.partitions)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (c *fakeKafkaTransactionalClient) RefreshMetadata(topics ...string) error {
+	return nil
+}
+
+func (c *fakeKafkaTransactionalClient) InitProducerID(
+	ctx context.Context, txnID string, timeout time.Duration,
+) (int64, int16, error) {
+	c.nextProducerID++
+	if c.open == nil {
+		c.open = make(map[string][]string)
+		c.ended = make(map[string]bool)
+	}
+	c.open[txnID] = nil
+	return c.nextProducerID, 0, nil
+}
+
+func (c *fakeKafkaTransactionalClient) AddPartitionsToTxn(
+	ctx context.Context, txn jobspb.ChangefeedSinkTransaction, partitions map[string][]int32,
+) error {
+	if _, ok := c.open[txn.TransactionalID]; !ok {
+		return sarama.ErrInvalidProducerIDMapping
+	}
+	return nil
+}
+
+func (c *fakeKafkaTransactionalClient) Produce(
+	ctx context.Context,
+	txn *jobspb.ChangefeedSinkTransaction,
+	topic string,
+	partition int32,
+	batch *sarama.RecordBatch,
+) (time.Duration, error) {
+	if c.produceErr != nil {
+		return 0, c.produceErr
+	}
+	c.sequences = append(c.sequences, batch.FirstSequence)
+	for _, r := range batch.Records {
+		if txn == nil {
+			c.committed = append(c.committed, string(r.Value))
+		} else {
+			c.open[txn.TransactionalID] = append(c.open[txn.TransactionalID], string(r.Value))
+		}
+	}
+	return c.throttle, nil
+}
+
+func (c *fakeKafkaTransactionalClient) EndTxn(
+	ctx context.Context, txn jobspb.ChangefeedSinkTransaction, commit bool,
+) error {
+	values, ok := c.open[txn.TransactionalID]
+	if !ok {
+		if ended, ok := c.ended[txn.TransactionalID]; ok && ended == commit {
+			return nil
+		}
+		return sarama.ErrInvalidTxnState
+	}
+	if commit {
+		c.committed = append(c.committed, values...)
+	}
+	delete(c.open, txn.TransactionalID)
+	c.ended[txn.TransactionalID] = commit
+	return nil
+}
+
+// abort aborts the open transactions, as if they timed out.
+func (c *fakeKafkaTransactionalClient) abort() {
+	for txnID := range c.open {
+		delete(c.open, txnID)
+		c.ended[txnID] = false
+	}
+}
+
+func (c *fakeKafkaTransactionalClient) Close() error {
+	return nil
+}
+
+func makeTestKafkaTransactionalSink(
+	t *testing.T, client *fakeKafkaTransactionalClient, targetNames ...string,
+) *kafkaTransactionalSink {
+	topics, err := MakeTopicNamer(makeChangefeedTargets(targetNames...),
+		WithSanitizeFn(SQLNameToKafkaName))
+	require.NoError(t, err)
+	config := sarama.NewConfig()
+	config.Producer.Partitioner = newChangefeedPartitioner("")
+	config.Producer.Flush.MaxMessages = 2
+	s, err := makeKafkaTransactionalSink(context.Background(), "", config,
+		&saramaConfig{ExactlyOnce: true}, topics, 42, nilMetricsRecorderBuilder)
+	require.NoError(t, err)
+	s.knobs.OverrideTransactionalClientInit = func(*sarama.Config) (kafkaTransactionalClient, error) {
+		return client, nil
+	}
+	require.NoError(t, s.Dial())
+	return s
+}
+
+func TestKafkaTransactionalSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeKafkaTransactionalClient{partitions: 1}
+	sink := makeTestKafkaTransactionalSink(t, client, "t")
+	defer func() { require.NoError(t, sink.Close()) }()
+
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+	var pool testAllocPool
+	for i := int64(1); i <= 5; i++ {
+		v := []byte(strconv.FormatInt(i, 10))
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), v, v, ts(i), ts(i), pool.alloc()))
+	}
+
+	// Only the rows at or below the timestamp are prepared, in batches of
+	// Flush.MaxMessages messages.
+	txn, err := sink.PrepareUpTo(ctx, ts(3))
+	require.NoError(t, err)
+	require.NotNil(t, txn)
+	require.Equal(t, []int32{0, 2}, client.sequences)
+	require.EqualValues(t, 2, pool.used())
+	require.Empty(t, client.committed)
+
+	// Nothing is prepared if no rows are at or below the timestamp.
+	noTxn, err := sink.PrepareUpTo(ctx, ts(3))
+	require.NoError(t, err)
+	require.Nil(t, noTxn)
+
+	// Committing a transaction is idempotent.
+	require.NoError(t, sink.CommitTransactions(ctx, []jobspb.ChangefeedSinkTransaction{*txn}))
+	require.Equal(t, []string{`1`, `2`, `3`}, client.committed)
+	require.NoError(t, sink.CommitTransactions(ctx, []jobspb.ChangefeedSinkTransaction{*txn}))
+	require.Equal(t, []string{`1`, `2`, `3`}, client.committed)
+
+	// The rows remain retained if the transaction cannot be prepared, and the
+	// transaction is aborted.
+	client.produceErr = sarama.ErrNotEnoughReplicas
+	_, err = sink.PrepareUpTo(ctx, ts(5))
+	require.Error(t, err)
+	require.EqualValues(t, 2, pool.used())
+	require.Empty(t, client.open)
+
+	client.produceErr = nil
+	nextTxn, err := sink.PrepareUpTo(ctx, ts(5))
+	require.NoError(t, err)
+	require.NotEqual(t, txn.TransactionalID, nextTxn.TransactionalID)
+	require.EqualValues(t, 0, pool.used())
+	require.NoError(t, sink.CommitTransactions(ctx, []jobspb.ChangefeedSinkTransaction{*nextTxn}))
+	require.Equal(t, []string{`1`, `2`, `3`, `4`, `5`}, client.committed)
+}
+
+func TestKafkaTransactionalSinkAborted(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeKafkaTransactionalClient{partitions: 2}
+	sink := makeTestKafkaTransactionalSink(t, client, "t")
+	defer func() { require.NoError(t, sink.Close()) }()
+
+	var pool testAllocPool
+	ts := hlc.Timestamp{WallTime: 1}
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), []byte(`v`), ts, ts, pool.alloc()))
+	txn, err := sink.PrepareUpTo(ctx, ts)
+	require.NoError(t, err)
+
+	// The rows of a transaction aborted by the brokers are lost, so the
+	// changefeed must not retry.
+	client.abort()
+	err = (&errorWrapperSink{wrapped: sink}).CommitTransactions(
+		ctx, []jobspb.ChangefeedSinkTransaction{*txn})
+	require.True(t, errors.Is(err, errSinkTransactionAborted), "%v", err)
+	require.False(t, changefeedbase.IsRetryableError(err))
+	require.Empty(t, client.committed)
+}
+
+func TestKafkaTransactionalSinkThrottled(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeKafkaTransactionalClient{partitions: 1, throttle: time.Millisecond}
+	sink := makeTestKafkaTransactionalSink(t, client, "t")
+	defer func() { require.NoError(t, sink.Close()) }()
+	metrics, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	sink.metrics = metrics
+
+	// The sink waits for the time the brokers throttle it for after producing.
+	var pool testAllocPool
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), []byte(`v`), ts(1), ts(1), pool.alloc()))
+	_, err = sink.PrepareUpTo(ctx, ts(1))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, metrics.SinkBackpressureNanos.Value(), time.Millisecond.Nanoseconds())
+
+	// The wait ends with the context of the changefeed.
+	client.throttle = time.Hour
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), []byte(`v`), ts(2), ts(2), pool.alloc()))
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = sink.PrepareUpTo(timeoutCtx, ts(2))
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestSinkTransactions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeKafkaTransactionalClient{partitions: 1}
+	sink := makeTestKafkaTransactionalSink(t, client, "t")
+	defer func() { require.NoError(t, sink.Close()) }()
+
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	prepare := func(resolved hlc.Timestamp, spans ...roachpb.Span) jobspb.ChangefeedSinkTransaction {
+		var pool testAllocPool
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), []byte(`v`),
+			resolved, resolved, pool.alloc()))
+		txn, err := sink.PrepareUpTo(ctx, resolved)
+		require.NoError(t, err)
+		txn.Resolved, txn.Spans = resolved, spans
+		return *txn
+	}
+
+	var txns sinkTransactions
+	ab := prepare(ts(2), sp("a", "b"))
+	bc := prepare(ts(3), sp("b", "c"))
+	txns.add(ab)
+	txns.add(bc)
+	require.True(t, txns.hasUncommitted())
+
+	// The recorded transactions are committed.
+	require.Len(t, txns.recordedWith(ts(1)), 2)
+	require.NoError(t, txns.commit(ctx, sink))
+	require.False(t, txns.hasUncommitted())
+	require.Len(t, client.committed, 2)
+	// Committing again is a no-op.
+	require.NoError(t, txns.commit(ctx, sink))
+
+	// A later transaction of the spans supersedes the committed transactions.
+	txns.add(prepare(ts(4), sp("a", "c")))
+	require.Len(t, txns.txns, 1)
+	require.True(t, txns.hasUncommitted())
+
+	// The committed transactions are no longer recorded once the high-water
+	// reaches them, but the uncommitted transactions are.
+	require.Len(t, txns.recordedWith(ts(5)), 1)
+	require.NoError(t, txns.commit(ctx, sink))
+	require.Empty(t, txns.recordedWith(ts(5)))
+}
+
+func TestSaramaConfigExactlyOnce(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{config: `{"ExactlyOnce": true}`},
+		{config: `{"ExactlyOnce": true, "TransactionTimeout": "30s", "RequiredAcks": "ALL"}`},
+		{config: `{"ExactlyOnce": true, "RequiredAcks": "ONE"}`, err: `RequiredAcks must be "ALL"`},
+		{config: `{"ExactlyOnce": true, "Topics": {"foo": {}}}`, err: `Topics cannot be used with ExactlyOnce`},
+		{config: `{"TransactionTimeout": "30s"}`, err: `TransactionTimeout requires ExactlyOnce`},
+	} {
+		t.Run(tc.config, func(t *testing.T) {
+			cfg, err := getSaramaConfig(changefeedbase.SinkSpecificJSONConfig(tc.config))
+			require.NoError(t, err)
+			err = cfg.Validate()
+			if tc.err == `` {
+				require.NoError(t, err)
+			} else {
+				require.Regexp(t, tc.err, err)
+			}
+		})
+	}
+}
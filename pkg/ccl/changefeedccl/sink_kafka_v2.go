@@ -0,0 +1,695 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// kafkaV2DefaultBatchBytes is the size of the messages of a partition past
+// which the kafka-v2 sink sends them in another batch, unless the Flush.Bytes
+// of the kafka_sink_config option is set. It stays under the default maximum
+// size of the batches accepted by the brokers.
+const kafkaV2DefaultBatchBytes = 512 << 10
+
+// kafkaV2DefaultPartitionInflight is the default number of full batches of a
+// partition which the kafka-v2 sink queues before the changefeed waits for
+// them to be sent (see saramaConfig.PartitionInflight).
+const kafkaV2DefaultPartitionInflight = 5
+
+// kafkaV2Batch is a batch of messages of a partition, which is sent in a
+// single produce request.
+type kafkaV2Batch struct {
+	// records are the records of the messages, whose pointers are only taken
+	// once the batch is sent, since the slice may grow until then.
+	records  []sarama.Record
+	pointers []*sarama.Record
+	bytes    int
+
+	first    time.Time
+	last     time.Time
+	alloc    kvevent.Alloc
+	emitTime time.Time
+	mvcc     hlc.Timestamp
+}
+
+// kafkaV2BatchPool recycles the batches of the kafka-v2 sinks, along with
+// their slices of records.
+var kafkaV2BatchPool = sync.Pool{
+	New: func() interface{} { return new(kafkaV2Batch) },
+}
+
+func newKafkaV2Batch() *kafkaV2Batch {
+	return kafkaV2BatchPool.Get().(*kafkaV2Batch)
+}
+
+// release releases the memory of the messages of the batch, and returns the
+// batch to the pool.
+func (b *kafkaV2Batch) release(ctx context.Context) {
+	b.alloc.Release(ctx)
+	// The records are cleared so that the pool does not retain the messages.
+	for i := range b.records {
+		b.records[i] = sarama.Record{}
+	}
+	for i := range b.pointers {
+		b.pointers[i] = nil
+	}
+	*b = kafkaV2Batch{records: b.records[:0], pointers: b.pointers[:0]}
+	kafkaV2BatchPool.Put(b)
+}
+
+// add adds a message to the batch.
+func (b *kafkaV2Batch) add(key, value []byte, mvcc hlc.Timestamp, alloc kvevent.Alloc) {
+	now := timeutil.Now()
+	if len(b.records) == 0 {
+		b.first, b.emitTime = now, now
+	}
+	b.last = now
+	b.records = append(b.records, sarama.Record{
+		OffsetDelta:    int64(len(b.records)),
+		TimestampDelta: now.Sub(b.first),
+		Key:            key,
+		Value:          value,
+	})
+	b.bytes += len(key) + len(value)
+	// The resolved timestamps have no allocation, which cannot be merged into
+	// the allocations of the rows.
+	if alloc.Events() > 0 || alloc.Bytes() > 0 {
+		b.alloc.Merge(&alloc)
+	}
+	if b.mvcc.IsEmpty() || (!mvcc.IsEmpty() && mvcc.Less(b.mvcc)) {
+		b.mvcc = mvcc
+	}
+}
+
+// full returns true if a message of the specified size cannot be added to the
+// batch without exceeding the limits of a batch.
+func (b *kafkaV2Batch) full(size, maxMessages, maxBytes int) bool {
+	if len(b.records) == 0 {
+		return false
+	}
+	return (maxMessages > 0 && len(b.records) >= maxMessages) || b.bytes+size > maxBytes
+}
+
+// recordBatch returns the record batch of the produce request sending the
+// batch.
+func (b *kafkaV2Batch) recordBatch(config *sarama.Config) *sarama.RecordBatch {
+	for i := range b.records {
+		b.pointers = append(b.pointers, &b.records[i])
+	}
+	return &sarama.RecordBatch{
+		Version:          2,
+		Codec:            config.Producer.Compression,
+		CompressionLevel: config.Producer.CompressionLevel,
+		FirstTimestamp:   b.first,
+		MaxTimestamp:     b.last,
+		ProducerID:       -1,
+		ProducerEpoch:    -1,
+		FirstSequence:    -1,
+		LastOffsetDelta:  int32(len(b.records) - 1),
+		Records:          b.pointers,
+	}
+}
+
+// kafkaPartitionSender sends the batches of a partition, one at a time and in
+// order, so that the messages for a key stay in order.
+type kafkaPartitionSender struct {
+	key kafkaPartitionKey
+	// wake is signaled when there are messages to send.
+	wake chan struct{}
+	// dequeued is signaled when a queued batch is taken to be sent, which
+	// makes room for another one.
+	dequeued chan struct{}
+
+	mu struct {
+		syncutil.Mutex
+		// pending is the batch the messages are added to. It is sent as soon as
+		// the partition has no other batch to send, so that the messages are
+		// batched while the previous batches are in flight, or queued once it is
+		// full.
+		pending *kafkaV2Batch
+		// queued are the full batches which are yet to be sent, in order.
+		queued []*kafkaV2Batch
+	}
+}
+
+// next returns the next batch to send, if any.
+func (p *kafkaPartitionSender) next() *kafkaV2Batch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.mu.queued) > 0 {
+		b := p.mu.queued[0]
+		p.mu.queued[0] = nil
+		p.mu.queued = p.mu.queued[1:]
+		select {
+		case p.dequeued <- struct{}{}:
+		default:
+		}
+		return b
+	}
+	b := p.mu.pending
+	p.mu.pending = nil
+	return b
+}
+
+// kafkaV2Sink emits to Kafka like the kafka sink, but batches the messages of
+// each partition itself, and sends the batches with the produce requests of
+// the brokers rather than through the sarama producers, which allocate and
+// dispatch every message through several goroutines. The messages are
+// partitioned as they are emitted, and each partition has a goroutine sending
+// its batches, so that the messages of a partition are batched while its
+// previous batch is in flight. At most PartitionInflight full batches are
+// queued per partition, past which the changefeed waits for the partition.
+//
+// The kafka-v2 sink does not support the ExactlyOnce, Topics and MaxInflight
+// settings of the kafka_sink_config option, the column partitioner and the
+// kafka_headers option (see kafkaV2Unsupported). It is not concurrency-safe;
+// all calls to Emit and Flush should be from the same goroutine.
+type kafkaV2Sink struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	bootstrapAddrs string
+	kafkaCfg       *sarama.Config
+	client         kafkaProduceClient
+	topics         *TopicNamer
+	metrics        metricsRecorder
+	knobs          kafkaSinkKnobs
+
+	// topicCreator, if set, creates the topics of the sink which do not exist
+	// yet.
+	topicCreator *kafkaTopicCreator
+
+	// maxBatchMessages and maxBatchBytes bound the batches of the partitions,
+	// and partitionInflight the full batches queued for a partition.
+	maxBatchMessages  int
+	maxBatchBytes     int
+	partitionInflight int
+
+	// partitioners are the partitioners of the topics, and numPartitions their
+	// number of partitions, which is refreshed along with the metadata.
+	partitioners  map[string]sarama.Partitioner
+	numPartitions map[string]int32
+	// partitionMsg is the message with which the partitioners are called.
+	partitionMsg sarama.ProducerMessage
+
+	senders   map[kafkaPartitionKey]*kafkaPartitionSender
+	stopCh    chan struct{}
+	senderWG  sync.WaitGroup
+	closeOnce sync.Once
+
+	lastMetadataRefresh time.Time
+	scratch             bufalloc.ByteAllocator
+
+	mu struct {
+		syncutil.Mutex
+		// inflight is the number of messages which were emitted but not yet
+		// acknowledged by the brokers.
+		inflight int64
+		// err is the error of the first batch which could not be sent, after
+		// which the sink fails.
+		err error
+		// flushCh, if set, is closed once there are no more inflight messages,
+		// or once a batch could not be sent.
+		flushCh chan struct{}
+	}
+}
+
+var _ Sink = (*kafkaV2Sink)(nil)
+var _ PartitionedEventSink = (*kafkaV2Sink)(nil)
+
+// useKafkaV2Sink returns true if the sink with the specified scheme and
+// options is a kafka-v2 sink, i.e. if it has the kafka-v2 scheme, or if the
+// kafka sinks are switched to the kafka-v2 sink by the
+// changefeed.kafka_v2_sink.enabled setting and it supports the options.
+func useKafkaV2Sink(
+	st *cluster.Settings, scheme string, kafkaOpts changefeedbase.KafkaSinkOptions,
+) bool {
+	if scheme == changefeedbase.SinkSchemeKafkaV2 {
+		return true
+	}
+	if st == nil || !changefeedbase.KafkaV2SinkEnabled.Get(&st.SV) {
+		return false
+	}
+	saramaCfg, err := getSaramaConfig(kafkaOpts.JSONConfig)
+	if err != nil {
+		// The kafka sink reports the error.
+		return false
+	}
+	return kafkaV2Unsupported(saramaCfg, kafkaOpts) == nil
+}
+
+// kafkaV2Unsupported returns an error if the kafka-v2 sink does not support
+// the specified configuration.
+func kafkaV2Unsupported(
+	saramaCfg *saramaConfig, kafkaOpts changefeedbase.KafkaSinkOptions,
+) error {
+	switch {
+	case saramaCfg.ExactlyOnce:
+		return errors.Errorf(`ExactlyOnce is not supported by the %s sink`, changefeedbase.SinkSchemeKafkaV2)
+	case len(saramaCfg.Topics) > 0:
+		return errors.Errorf(`Topics is not supported by the %s sink`, changefeedbase.SinkSchemeKafkaV2)
+	case saramaCfg.MaxInflight.Messages != 0 || saramaCfg.MaxInflight.Bytes != 0:
+		return errors.Errorf(`MaxInflight is not supported by the %s sink, use PartitionInflight instead`,
+			changefeedbase.SinkSchemeKafkaV2)
+	case saramaCfg.Partitioner.Strategy == changefeedbase.OptKafkaPartitionerColumn ||
+		kafkaOpts.Partitioner == changefeedbase.OptKafkaPartitionerColumn:
+		return errors.Errorf(`the %q partitioner is not supported by the %s sink`,
+			changefeedbase.OptKafkaPartitionerColumn, changefeedbase.SinkSchemeKafkaV2)
+	case len(kafkaOpts.Headers) > 0:
+		return errors.Errorf(`%s is not supported by the %s sink`,
+			changefeedbase.OptKafkaHeaders, changefeedbase.SinkSchemeKafkaV2)
+	}
+	return nil
+}
+
+func makeKafkaV2Sink(
+	ctx context.Context,
+	u sinkURL,
+	targets changefeedbase.Targets,
+	kafkaOpts changefeedbase.KafkaSinkOptions,
+	mb metricsRecorderBuilder,
+	tlsReloader *sinkTLSReloader,
+) (Sink, error) {
+	kafkaTopicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	kafkaTopicName := u.consumeParam(changefeedbase.SinkParamTopicName)
+	if schemaTopic := u.consumeParam(changefeedbase.SinkParamSchemaTopic); schemaTopic != `` {
+		return nil, errors.Errorf(`%s is not yet supported`, changefeedbase.SinkParamSchemaTopic)
+	}
+
+	config, err := buildKafkaConfig(ctx, u, kafkaOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := tlsReloader.apply(ctx, config.Net.TLS.Config, false /* systemRoots */); err != nil {
+		return nil, err
+	}
+	// The batches are sent as the record batches introduced by kafka 0.11.
+	if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.Errorf(`the %s sink requires kafka version 0.11.0 or later, but Version is %s`,
+			changefeedbase.SinkSchemeKafkaV2, config.Version)
+	}
+	saramaCfg, err := getSaramaConfig(kafkaOpts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"failed to parse sarama config; check %s option", changefeedbase.OptKafkaSinkConfig)
+	}
+	if err := kafkaV2Unsupported(saramaCfg, kafkaOpts); err != nil {
+		return nil, err
+	}
+
+	topics, err := MakeTopicNamer(
+		targets,
+		WithPrefix(kafkaTopicPrefix), WithSingleName(kafkaTopicName), WithSanitizeFn(SQLNameToKafkaName))
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &kafkaV2Sink{
+		bootstrapAddrs:    u.Host,
+		kafkaCfg:          config,
+		topics:            topics,
+		metrics:           mb(requiresResourceAccounting),
+		maxBatchMessages:  config.Producer.Flush.MaxMessages,
+		maxBatchBytes:     config.Producer.Flush.Bytes,
+		partitionInflight: saramaCfg.PartitionInflight,
+		partitioners:      make(map[string]sarama.Partitioner),
+		numPartitions:     make(map[string]int32),
+		senders:           make(map[kafkaPartitionKey]*kafkaPartitionSender),
+		stopCh:            make(chan struct{}),
+	}
+	if sink.maxBatchBytes <= 0 {
+		sink.maxBatchBytes = kafkaV2DefaultBatchBytes
+	}
+	if sink.partitionInflight == 0 {
+		sink.partitionInflight = kafkaV2DefaultPartitionInflight
+	}
+	sink.topicCreator, err = makeKafkaTopicCreator(saramaCfg, func() (kafkaClusterAdmin, error) {
+		return newKafkaClusterAdmin(sink.knobs, sink.bootstrapAddrs, sink.kafkaCfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown kafka sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	sink.ctx, sink.cancel = context.WithCancel(ctx)
+	return sink, nil
+}
+
+// Dial implements the Sink interface.
+func (s *kafkaV2Sink) Dial() error {
+	if s.knobs.OverrideProduceClientInit != nil {
+		client, err := s.knobs.OverrideProduceClientInit(s.kafkaCfg)
+		if err != nil {
+			return err
+		}
+		s.client = client
+		return s.topicCreator.ensureTopics(s.topics.DisplayNamesSlice()...)
+	}
+	client, err := sarama.NewClient(strings.Split(s.bootstrapAddrs, `,`), s.kafkaCfg)
+	if err != nil {
+		return pgerror.Wrapf(err, pgcode.CannotConnectNow,
+			`connecting to kafka: %s`, s.bootstrapAddrs)
+	}
+	s.client = &saramaTransactionalClient{Client: client}
+	return s.topicCreator.ensureTopics(s.topics.DisplayNamesSlice()...)
+}
+
+// EmitRow implements the Sink interface.
+func (s *kafkaV2Sink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	return s.EmitRowToPartition(ctx, topicDescr, key, value, updated, mvcc, alloc, -1)
+}
+
+// EmitRowToPartition implements the PartitionedEventSink interface. A
+// negative partition derives the partition from the key of the row.
+func (s *kafkaV2Sink) EmitRowToPartition(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+) error {
+	topic, err := s.topics.Name(topicDescr)
+	if err != nil {
+		return err
+	}
+	if err := s.topicCreator.ensureTopics(topic); err != nil {
+		return err
+	}
+	partition, err = s.partition(topic, key, value, partition)
+	if err != nil {
+		return err
+	}
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+	return s.emit(ctx, kafkaPartitionKey{topic: topic, partition: partition}, key, value, mvcc, alloc)
+}
+
+// partition returns the partition of a message, which is the specified
+// partition if it is not negative.
+func (s *kafkaV2Sink) partition(
+	topic string, key, value []byte, partition int32,
+) (int32, error) {
+	n, ok := s.numPartitions[topic]
+	if !ok {
+		partitions, err := s.client.Partitions(topic)
+		if err != nil {
+			return 0, err
+		}
+		n = int32(len(partitions))
+		s.numPartitions[topic] = n
+	}
+	if partition >= 0 {
+		if partition >= n {
+			return 0, errors.Mark(errors.Newf("%s evaluated to partition %d, but topic %s has %d partitions",
+				changefeedbase.OptPartitionExpr, partition, topic, n), errPartitionOutOfRange)
+		}
+		return partition, nil
+	}
+	p, ok := s.partitioners[topic]
+	if !ok {
+		p = s.kafkaCfg.Producer.Partitioner(topic)
+		s.partitioners[topic] = p
+	}
+	s.partitionMsg = sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	}
+	return p.Partition(&s.partitionMsg, n)
+}
+
+// emit adds a message to the pending batch of its partition, waiting for the
+// full batches of the partition to be sent if too many of them are queued.
+func (s *kafkaV2Sink) emit(
+	ctx context.Context,
+	key kafkaPartitionKey,
+	msgKey, value []byte,
+	mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	p := s.senderFor(key)
+	size := len(msgKey) + len(value)
+	p.mu.Lock()
+	for p.mu.pending != nil && p.mu.pending.full(size, s.maxBatchMessages, s.maxBatchBytes) {
+		if len(p.mu.queued) < s.partitionInflight {
+			p.mu.queued = append(p.mu.queued, p.mu.pending)
+			p.mu.pending = nil
+			break
+		}
+		p.mu.Unlock()
+		if err := s.waitForPartition(ctx, p); err != nil {
+			return err
+		}
+		p.mu.Lock()
+	}
+	s.mu.Lock()
+	err := s.mu.err
+	if err == nil {
+		s.mu.inflight++
+	}
+	s.mu.Unlock()
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	if p.mu.pending == nil {
+		p.mu.pending = newKafkaV2Batch()
+	}
+	p.mu.pending.add(msgKey, value, mvcc, alloc)
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// waitForPartition waits for a queued batch of the partition to be taken to
+// be sent. The time spent waiting is recorded as backpressure of the sink.
+func (s *kafkaV2Sink) waitForPartition(ctx context.Context, p *kafkaPartitionSender) error {
+	start := timeutil.Now()
+	defer func() { s.metrics.recordSinkBackpressure(timeutil.Since(start)) }()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopCh:
+		return errors.New("kafka sink closed")
+	case <-p.dequeued:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.err
+	}
+}
+
+// senderFor returns the sender of a partition, which it starts if needed.
+func (s *kafkaV2Sink) senderFor(key kafkaPartitionKey) *kafkaPartitionSender {
+	if p, ok := s.senders[key]; ok {
+		return p
+	}
+	p := &kafkaPartitionSender{
+		key:      key,
+		wake:     make(chan struct{}, 1),
+		dequeued: make(chan struct{}, 1),
+	}
+	s.senders[key] = p
+	s.senderWG.Add(1)
+	go s.senderLoop(p)
+	return p
+}
+
+// senderLoop sends the batches of a partition until the sink is closed.
+func (s *kafkaV2Sink) senderLoop(p *kafkaPartitionSender) {
+	defer s.senderWG.Done()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-p.wake:
+		}
+		for b := p.next(); b != nil; b = p.next() {
+			s.mu.Lock()
+			err := s.mu.err
+			s.mu.Unlock()
+			// The batches are dropped once the sink failed, since the changefeed
+			// restarts from its last checkpoint.
+			if err == nil {
+				err = s.send(p.key, b)
+			}
+			s.finishBatch(b, err)
+		}
+	}
+}
+
+// send sends a batch to its partition.
+func (s *kafkaV2Sink) send(key kafkaPartitionKey, b *kafkaV2Batch) error {
+	throttle, err := s.client.Produce(s.ctx, nil /* txn */, key.topic, key.partition, b.recordBatch(s.kafkaCfg))
+	if err != nil {
+		return errors.Wrapf(err, "emitting to partition %d of topic %s", key.partition, key.topic)
+	}
+	s.metrics.recordEmittedBatch(b.emitTime, len(b.records), b.mvcc, b.bytes, sinkDoesNotCompress)
+	if throttle <= 0 {
+		return nil
+	}
+	// The sender of the partition waits for the time for which the broker
+	// throttles the sink, so that the changefeed slows down to the quota of
+	// the sink once the batches of the partition queue up.
+	if log.V(1) {
+		log.Infof(s.ctx, "kafka broker throttled the sink for %s", throttle)
+	}
+	start := timeutil.Now()
+	defer func() { s.metrics.recordSinkBackpressure(timeutil.Since(start)) }()
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	case <-time.After(throttle):
+		return nil
+	}
+}
+
+// finishBatch accounts for a batch which was sent, or which could not be.
+func (s *kafkaV2Sink) finishBatch(b *kafkaV2Batch, err error) {
+	n := int64(len(b.records))
+	b.release(s.ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.inflight -= n
+	if err != nil && s.mu.err == nil {
+		s.mu.err = err
+	}
+	if s.mu.flushCh != nil && (s.mu.inflight == 0 || s.mu.err != nil) {
+		close(s.mu.flushCh)
+		s.mu.flushCh = nil
+	}
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved
+// timestamps are emitted to every partition of every topic, after the rows
+// emitted before them.
+func (s *kafkaV2Sink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	// See kafkaSink.EmitResolvedTimestamp.
+	const metadataRefreshMinDuration = time.Minute
+	if timeutil.Since(s.lastMetadataRefresh) > metadataRefreshMinDuration {
+		if err := s.client.RefreshMetadata(s.topics.DisplayNamesSlice()...); err != nil {
+			return err
+		}
+		s.lastMetadataRefresh = timeutil.Now()
+		for topic := range s.numPartitions {
+			delete(s.numPartitions, topic)
+		}
+	}
+
+	if err := s.topics.Each(func(topic string) error {
+		payload, err := encoder.EncodeResolvedTimestamp(ctx, topic, resolved)
+		if err != nil {
+			return err
+		}
+		s.scratch, payload = s.scratch.Copy(payload, 0 /* extraCap */)
+
+		partitions, err := s.client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		for _, partition := range partitions {
+			key := kafkaPartitionKey{topic: topic, partition: partition}
+			if err := s.emit(ctx, key, nil /* msgKey */, payload, hlc.Timestamp{}, kvevent.Alloc{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return s.flush(ctx)
+}
+
+// Flush implements the Sink interface.
+func (s *kafkaV2Sink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.flush(ctx)
+}
+
+// flush waits for the messages emitted so far to be acknowledged.
+func (s *kafkaV2Sink) flush(ctx context.Context) error {
+	s.mu.Lock()
+	if s.mu.inflight == 0 || s.mu.err != nil {
+		defer s.mu.Unlock()
+		return s.mu.err
+	}
+	flushCh := make(chan struct{})
+	s.mu.flushCh = flushCh
+	s.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-flushCh:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.err
+}
+
+// Topics implements the Sink interface.
+func (s *kafkaV2Sink) Topics() []string {
+	return s.topics.DisplayNamesSlice()
+}
+
+// Close implements the Sink interface.
+func (s *kafkaV2Sink) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		close(s.stopCh)
+		s.senderWG.Wait()
+		for _, p := range s.senders {
+			for _, b := range p.mu.queued {
+				b.release(s.ctx)
+			}
+			if p.mu.pending != nil {
+				p.mu.pending.release(s.ctx)
+			}
+		}
+		// s.client is only nil if the sink was not dialed.
+		if s.client != nil {
+			err = s.client.Close()
+		}
+	})
+	return err
+}
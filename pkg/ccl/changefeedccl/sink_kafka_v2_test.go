@@ -0,0 +1,315 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKafkaProduceClient records the batches produced by the kafka-v2 sink,
+// whose partitions produce concurrently.
+type fakeKafkaProduceClient struct {
+	partitions int32
+	// unblock, if set, is received from before every batch is produced.
+	unblock chan struct{}
+
+	mu struct {
+		syncutil.Mutex
+		// values are the values produced to every partition, keyed by topic and
+		// partition.
+		values     map[kafkaPartitionKey][]string
+		batches    []int
+		produceErr error
+	}
+}
+
+var _ kafkaProduceClient = (*fakeKafkaProduceClient)(nil)
+
+func (c *fakeKafkaProduceClient) Partitions(topic string) ([]int32, error) {
+	partitions := make([]int32, c.partitions)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (c *fakeKafkaProduceClient) RefreshMetadata(topics ...string) error {
+	return nil
+}
+
+func (c *fakeKafkaProduceClient) Produce(
+	ctx context.Context,
+	txn *jobspb.ChangefeedSinkTransaction,
+	topic string,
+	partition int32,
+	batch *sarama.RecordBatch,
+) (time.Duration, error) {
+	if c.unblock != nil {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-c.unblock:
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.produceErr != nil {
+		return 0, c.mu.produceErr
+	}
+	if c.mu.values == nil {
+		c.mu.values = make(map[kafkaPartitionKey][]string)
+	}
+	key := kafkaPartitionKey{topic: topic, partition: partition}
+	for _, r := range batch.Records {
+		c.mu.values[key] = append(c.mu.values[key], string(r.Value))
+	}
+	c.mu.batches = append(c.mu.batches, len(batch.Records))
+	return 0, nil
+}
+
+func (c *fakeKafkaProduceClient) Close() error {
+	return nil
+}
+
+func (c *fakeKafkaProduceClient) produced() (map[kafkaPartitionKey][]string, []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.values, c.mu.batches
+}
+
+func (c *fakeKafkaProduceClient) setProduceErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.produceErr = err
+}
+
+func makeTestKafkaV2Sink(
+	t testing.TB, client kafkaProduceClient, jsonConfig string, targetNames ...string,
+) *kafkaV2Sink {
+	u, err := url.Parse(`kafka-v2://broker:9092`)
+	require.NoError(t, err)
+	s, err := makeKafkaV2Sink(context.Background(), sinkURL{URL: u}, makeChangefeedTargets(targetNames...),
+		changefeedbase.KafkaSinkOptions{JSONConfig: changefeedbase.SinkSpecificJSONConfig(jsonConfig)},
+		nilMetricsRecorderBuilder, nil /* tlsReloader */)
+	require.NoError(t, err)
+	sink := s.(*kafkaV2Sink)
+	sink.knobs.OverrideProduceClientInit = func(*sarama.Config) (kafkaProduceClient, error) {
+		return client, nil
+	}
+	require.NoError(t, sink.Dial())
+	return sink
+}
+
+func TestKafkaV2Sink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeKafkaProduceClient{partitions: 2}
+	sink := makeTestKafkaV2Sink(t, client, `{"Flush": {"MaxMessages": 2}}`, "t")
+	defer func() { require.NoError(t, sink.Close()) }()
+
+	// No inflight.
+	require.NoError(t, sink.Flush(ctx))
+
+	// The messages of every partition are produced in order, in batches of at
+	// most Flush.MaxMessages messages.
+	var pool testAllocPool
+	for i := 0; i < 10; i++ {
+		v := []byte(strconv.Itoa(i))
+		require.NoError(t, sink.EmitRowToPartition(
+			ctx, topic(`t`), v, v, zeroTS, zeroTS, pool.alloc(), int32(i%2)))
+	}
+	require.NoError(t, sink.Flush(ctx))
+	require.EqualValues(t, 0, pool.used())
+	values, batches := client.produced()
+	require.Equal(t, map[kafkaPartitionKey][]string{
+		{topic: `t`, partition: 0}: {`0`, `2`, `4`, `6`, `8`},
+		{topic: `t`, partition: 1}: {`1`, `3`, `5`, `7`, `9`},
+	}, values)
+	for _, n := range batches {
+		require.LessOrEqual(t, n, 2)
+	}
+
+	// The explicit partitions must exist, and retrying a row routed to a
+	// partition which does not exist cannot succeed.
+	err := errorWrapperSink{wrapped: sink}.EmitRowToPartition(
+		ctx, topic(`t`), nil, nil, zeroTS, zeroTS, zeroAlloc, 2)
+	require.Regexp(t, `evaluated to partition 2, but topic t has 2 partitions`, err)
+	require.False(t, changefeedbase.IsRetryableError(err))
+
+	// The resolved timestamps are emitted to every partition.
+	var e testEncoder
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, e, hlc.Timestamp{WallTime: 1}))
+	values, _ = client.produced()
+	for partition := int32(0); partition < 2; partition++ {
+		require.Len(t, values[kafkaPartitionKey{topic: `t`, partition: partition}], 6)
+	}
+
+	// The sink fails once a batch could not be produced.
+	client.setProduceErr(sarama.ErrNotEnoughReplicas)
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), []byte(`v`), zeroTS, zeroTS, pool.alloc()))
+	require.True(t, errors.Is(sink.Flush(ctx), sarama.ErrNotEnoughReplicas))
+	require.EqualValues(t, 0, pool.used())
+	require.True(t, errors.Is(
+		sink.EmitRow(ctx, topic(`t`), []byte(`k`), []byte(`v`), zeroTS, zeroTS, zeroAlloc),
+		sarama.ErrNotEnoughReplicas))
+}
+
+func TestKafkaV2SinkPartitionInflight(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeKafkaProduceClient{partitions: 1, unblock: make(chan struct{})}
+	sink := makeTestKafkaV2Sink(t, client, `{"Flush": {"MaxMessages": 1}, "PartitionInflight": 1}`, "t")
+	defer func() { require.NoError(t, sink.Close()) }()
+	metrics, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	sink.metrics = metrics
+
+	// While the first batch is in flight, a second one is queued and a third
+	// one is filled, after which the changefeed waits for the partition.
+	emit := func(ctx context.Context, v string) error {
+		return sink.EmitRow(ctx, topic(`t`), []byte(v), []byte(v), zeroTS, zeroTS, zeroAlloc)
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, emit(ctx, strconv.Itoa(i)))
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.True(t, errors.Is(emit(timeoutCtx, `3`), context.DeadlineExceeded))
+	require.Greater(t, metrics.SinkBackpressureNanos.Value(), int64(0))
+
+	close(client.unblock)
+	require.NoError(t, emit(ctx, `3`))
+	require.NoError(t, sink.Flush(ctx))
+	values, _ := client.produced()
+	require.Equal(t, []string{`0`, `1`, `2`, `3`}, values[kafkaPartitionKey{topic: `t`}])
+}
+
+func TestKafkaV2SinkConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		uri     string
+		opts    changefeedbase.KafkaSinkOptions
+		err     string
+		enabled bool
+	}{
+		{uri: `kafka-v2://broker`},
+		{uri: `kafka-v2://broker?topic_prefix=foo&tls_enabled=true`},
+		{uri: `kafka-v2://broker`, opts: changefeedbase.KafkaSinkOptions{JSONConfig: `{"PartitionInflight": 1}`}},
+		{uri: `kafka-v2://broker`, opts: changefeedbase.KafkaSinkOptions{JSONConfig: `{"Version": "0.10.2.0"}`},
+			err: `requires kafka version 0.11.0 or later`},
+		{uri: `kafka-v2://broker`, opts: changefeedbase.KafkaSinkOptions{JSONConfig: `{"ExactlyOnce": true}`},
+			err: `ExactlyOnce is not supported by the kafka-v2 sink`},
+		{uri: `kafka-v2://broker`, opts: changefeedbase.KafkaSinkOptions{JSONConfig: `{"MaxInflight": {"Messages": 1}}`},
+			err: `MaxInflight is not supported by the kafka-v2 sink`},
+		{uri: `kafka-v2://broker`, opts: changefeedbase.KafkaSinkOptions{Headers: []string{`a`}},
+			err: `kafka_headers is not supported by the kafka-v2 sink`},
+		{uri: `kafka-v2://broker?foo=bar`, err: `unknown kafka sink query parameters: foo`},
+	} {
+		t.Run(tc.uri+string(tc.opts.JSONConfig), func(t *testing.T) {
+			u, err := url.Parse(tc.uri)
+			require.NoError(t, err)
+			_, err = makeKafkaV2Sink(ctx, sinkURL{URL: u}, makeChangefeedTargets("t"), tc.opts,
+				nilMetricsRecorderBuilder, nil /* tlsReloader */)
+			if tc.err == `` {
+				require.NoError(t, err)
+			} else {
+				require.Regexp(t, tc.err, err)
+			}
+		})
+	}
+
+	// The kafka sink does not support the settings of the kafka-v2 sink.
+	u, err := url.Parse(`kafka://broker`)
+	require.NoError(t, err)
+	_, err = makeKafkaSink(ctx, sinkURL{URL: u}, makeChangefeedTargets("t"),
+		changefeedbase.KafkaSinkOptions{JSONConfig: `{"PartitionInflight": 1}`},
+		nil /* settings */, 0 /* jobID */, nilMetricsRecorderBuilder, nil /* tlsReloader */)
+	require.Regexp(t, `PartitionInflight requires the kafka-v2 sink`, err)
+}
+
+func TestUseKafkaV2Sink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	var opts changefeedbase.KafkaSinkOptions
+	require.True(t, useKafkaV2Sink(st, changefeedbase.SinkSchemeKafkaV2, opts))
+	require.False(t, useKafkaV2Sink(st, changefeedbase.SinkSchemeKafka, opts))
+
+	// The setting switches the kafka sinks to the kafka-v2 sink, unless they
+	// use options it does not support.
+	changefeedbase.KafkaV2SinkEnabled.Override(ctx, &st.SV, true)
+	require.True(t, useKafkaV2Sink(st, changefeedbase.SinkSchemeKafka, opts))
+	require.False(t, useKafkaV2Sink(st, changefeedbase.SinkSchemeKafka,
+		changefeedbase.KafkaSinkOptions{JSONConfig: `{"ExactlyOnce": true}`}))
+	require.False(t, useKafkaV2Sink(st, changefeedbase.SinkSchemeKafka,
+		changefeedbase.KafkaSinkOptions{Headers: []string{`a`}}))
+}
+
+// BenchmarkKafkaSinks compares the kafka and kafka-v2 sinks emitting the same
+// rows to brokers acknowledging them immediately.
+func BenchmarkKafkaSinks(b *testing.B) {
+	defer log.Scope(b).Close(b)
+
+	ctx := context.Background()
+	const numKeys = 1000
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf(`["key-%d"]`, i))
+	}
+	value := []byte(`{"after": {"a": 1, "b": "some value"}}`)
+	emitRows := func(b *testing.B, sink Sink) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, sink.EmitRow(ctx, topic(`t`), keys[i%numKeys], value, zeroTS, zeroTS, zeroAlloc))
+		}
+		require.NoError(b, sink.Flush(ctx))
+	}
+
+	b.Run("kafka", func(b *testing.B) {
+		p := newAsyncProducerMock(unbuffered)
+		sink, cleanup := makeTestKafkaSink(b, noTopicPrefix, defaultTopicName, p, "t")
+		stopConsuming := p.consumeAndSucceed()
+		defer func() {
+			stopConsuming()
+			cleanup()
+		}()
+		emitRows(b, sink)
+	})
+	b.Run("kafka-v2", func(b *testing.B) {
+		sink := makeTestKafkaV2Sink(b, &fakeKafkaProduceClient{partitions: 16}, ``, "t")
+		defer func() { require.NoError(b, sink.Close()) }()
+		emitRows(b, sink)
+	})
+}
@@ -0,0 +1,643 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The limits of the PutRecords API of Kinesis Data Streams.
+const (
+	kinesisMaxRecordsPerRequest  = 500
+	kinesisMaxBytesPerRequest    = 5 << 20
+	kinesisMaxBytesPerRecord     = 1 << 20
+	kinesisMaxPartitionKeyLength = 256
+)
+
+// kinesisResolvedPartitionKey is the partition key of the resolved timestamp
+// records, which are routed to every shard by explicit hash keys instead.
+const kinesisResolvedPartitionKey = `resolved`
+
+// kinesisAggregationMagic is the prefix of the records aggregated in the
+// format of the Kinesis Producer Library (KPL), which the Kinesis Client
+// Library (KCL) and the kinesis-aggregation libraries deaggregate.
+var kinesisAggregationMagic = []byte{0xf3, 0x89, 0x9a, 0xc2}
+
+// kinesisPartitionKeyType configures the partition keys of the records emitted
+// by the kinesis sink.
+type kinesisPartitionKeyType string
+
+const (
+	// kinesisPartitionKeyKey uses the keys of the rows as partition keys, so
+	// that the rows with the same key are stored in order in the same shard.
+	kinesisPartitionKeyKey kinesisPartitionKeyType = `key`
+	// kinesisPartitionKeyRandom uses random partition keys, which spreads the
+	// rows evenly across the shards but does not order the rows for a key.
+	kinesisPartitionKeyRandom kinesisPartitionKeyType = `random`
+)
+
+type kinesisFlushConfig struct {
+	Messages, Bytes int `json:",omitempty"`
+}
+
+// proper JSON schema for kinesis sink config:
+//
+//	{
+//	  "PartitionKey": "key" or "random",
+//	  "Aggregate": true or false,
+//	  "Flush": {
+//	    "Messages": ...,
+//	    "Bytes":    ...,
+//	  },
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// The rows are buffered until a PutRecords request is full, the Flush
+// thresholds are reached or the changefeed flushes the sink.
+type kinesisSinkConfig struct {
+	PartitionKey kinesisPartitionKeyType `json:",omitempty"`
+	// Aggregate aggregates the rows into records in the KPL format, which
+	// reduces the number of records stored in the stream.
+	Aggregate bool               `json:",omitempty"`
+	Flush     kinesisFlushConfig `json:",omitempty"`
+	Retry     retryConfig        `json:",omitempty"`
+}
+
+func getKinesisSinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg kinesisSinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.PartitionKey = kinesisPartitionKeyKey
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	switch cfg.PartitionKey {
+	case kinesisPartitionKeyKey, kinesisPartitionKeyRandom:
+	default:
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, unknown partition key %q",
+			changefeedbase.OptKinesisSinkConfig, cfg.PartitionKey)
+	}
+	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative",
+			changefeedbase.OptKinesisSinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+// kinesisClient is the part of the Kinesis API used by the kinesis sink.
+type kinesisClient interface {
+	PutRecordsWithContext(
+		ctx aws.Context, input *kinesis.PutRecordsInput, opts ...request.Option,
+	) (*kinesis.PutRecordsOutput, error)
+	ListShardsWithContext(
+		ctx aws.Context, input *kinesis.ListShardsInput, opts ...request.Option,
+	) (*kinesis.ListShardsOutput, error)
+}
+
+var _ kinesisClient = (*kinesis.Kinesis)(nil)
+
+// newKinesisClient creates a client of the Kinesis API.
+func newKinesisClient(conf awsClientConfig) (kinesisClient, error) {
+	sess, err := newAWSSession(conf)
+	if err != nil {
+		return nil, err
+	}
+	return kinesis.New(sess), nil
+}
+
+// kinesisAggregate is a record aggregating several rows in the KPL format:
+// kinesisAggregationMagic, followed by an AggregatedRecord protocol buffer and
+// by its MD5 checksum.
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records = 3;
+//	}
+//
+//	message Record {
+//	  required uint64 partition_key_index = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes data = 3;
+//	}
+//
+// All the rows of an aggregate have the explicit hash key of the aggregate,
+// so that the consumers do not filter them out of the shard in which the
+// aggregate is stored.
+type kinesisAggregate struct {
+	explicitHashKey string
+	partitionKeys   []string
+	keyIndexes      map[string]uint64
+	// records is the encoding of the records field of the aggregate.
+	records []byte
+	// size is the size of the encoded aggregate.
+	size int
+}
+
+func newKinesisAggregate(explicitHashKey string) *kinesisAggregate {
+	return &kinesisAggregate{
+		explicitHashKey: explicitHashKey,
+		keyIndexes:      make(map[string]uint64),
+		size: len(kinesisAggregationMagic) + md5.Size +
+			protowire.SizeTag(2) + protowire.SizeBytes(len(explicitHashKey)),
+	}
+}
+
+// kinesisAggregatedRecordSize returns the size of the encoding of a Record.
+func kinesisAggregatedRecordSize(keyIndex uint64, data []byte) int {
+	return protowire.SizeTag(1) + protowire.SizeVarint(keyIndex) +
+		protowire.SizeTag(2) + protowire.SizeVarint(0) +
+		protowire.SizeTag(3) + protowire.SizeBytes(len(data))
+}
+
+// recordSizeWith returns the size of the aggregate in a PutRecords request, if
+// the given row was added to it.
+func (a *kinesisAggregate) recordSizeWith(partitionKey string, data []byte) int {
+	size := a.size
+	keyIndex, ok := a.keyIndexes[partitionKey]
+	if !ok {
+		keyIndex = uint64(len(a.partitionKeys))
+		size += protowire.SizeTag(1) + protowire.SizeBytes(len(partitionKey))
+	}
+	size += protowire.SizeTag(3) + protowire.SizeBytes(kinesisAggregatedRecordSize(keyIndex, data))
+	if len(a.partitionKeys) == 0 {
+		return size + len(partitionKey)
+	}
+	return size + len(a.partitionKeys[0])
+}
+
+// recordSize returns the size of the aggregate in a PutRecords request.
+func (a *kinesisAggregate) recordSize() int {
+	if len(a.partitionKeys) == 0 {
+		return 0
+	}
+	return a.size + len(a.partitionKeys[0])
+}
+
+func (a *kinesisAggregate) add(partitionKey string, data []byte) {
+	keyIndex, ok := a.keyIndexes[partitionKey]
+	if !ok {
+		keyIndex = uint64(len(a.partitionKeys))
+		a.partitionKeys = append(a.partitionKeys, partitionKey)
+		a.keyIndexes[partitionKey] = keyIndex
+		a.size += protowire.SizeTag(1) + protowire.SizeBytes(len(partitionKey))
+	}
+	n := kinesisAggregatedRecordSize(keyIndex, data)
+	a.records = protowire.AppendTag(a.records, 3, protowire.BytesType)
+	a.records = protowire.AppendVarint(a.records, uint64(n))
+	a.records = protowire.AppendTag(a.records, 1, protowire.VarintType)
+	a.records = protowire.AppendVarint(a.records, keyIndex)
+	a.records = protowire.AppendTag(a.records, 2, protowire.VarintType)
+	a.records = protowire.AppendVarint(a.records, 0)
+	a.records = protowire.AppendTag(a.records, 3, protowire.BytesType)
+	a.records = protowire.AppendBytes(a.records, data)
+	a.size += protowire.SizeTag(3) + protowire.SizeBytes(n)
+}
+
+// entry returns the record of the aggregate in a PutRecords request.
+func (a *kinesisAggregate) entry() *kinesis.PutRecordsRequestEntry {
+	buf := make([]byte, 0, a.size)
+	buf = append(buf, kinesisAggregationMagic...)
+	for _, partitionKey := range a.partitionKeys {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendString(buf, partitionKey)
+	}
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	buf = protowire.AppendString(buf, a.explicitHashKey)
+	buf = append(buf, a.records...)
+	sum := md5.Sum(buf[len(kinesisAggregationMagic):])
+	buf = append(buf, sum[:]...)
+	return &kinesis.PutRecordsRequestEntry{
+		Data:            buf,
+		PartitionKey:    aws.String(a.partitionKeys[0]),
+		ExplicitHashKey: aws.String(a.explicitHashKey),
+	}
+}
+
+// kinesisAggregationBucket returns the aggregation bucket of a partition key,
+// and the explicit hash key of the bucket. Kinesis maps the partition keys to
+// 128-bit hash keys with MD5, and the bucket of a partition key is the top
+// byte of its hash key. The aggregates of a bucket are stored in the shard of
+// the first hash key of the bucket, so the rows with the same partition key
+// are always stored in the same shard.
+func kinesisAggregationBucket(partitionKey string) (byte, string) {
+	sum := md5.Sum([]byte(partitionKey))
+	return sum[0], new(big.Int).Lsh(big.NewInt(int64(sum[0])), 120).String()
+}
+
+// kinesisBatch is a batch of rows buffered for a stream, which are sent in a
+// single PutRecords request.
+//
+// PutRecords does not order the records of a request, so a batch holds at most
+// one record per partition key, or per aggregation bucket when aggregating.
+// Since the requests are sent one after the other, the rows with the same
+// partition key are stored in order.
+type kinesisBatch struct {
+	// entries are the records of the batch when the rows are not aggregated,
+	// and partitionKeys are their partition keys.
+	entries       []*kinesis.PutRecordsRequestEntry
+	partitionKeys map[string]struct{}
+	// aggregates are the records of the batch by aggregation bucket when the
+	// rows are aggregated.
+	aggregates map[byte]*kinesisAggregate
+	// bytes is the size of the records of the batch.
+	bytes int
+
+	numMessages int
+	alloc       kvevent.Alloc
+	emitTime    time.Time
+	mvcc        hlc.Timestamp
+}
+
+func newKinesisBatch() *kinesisBatch {
+	return &kinesisBatch{
+		partitionKeys: make(map[string]struct{}),
+		aggregates:    make(map[byte]*kinesisAggregate),
+	}
+}
+
+// add adds a row to the batch. It returns false if the row cannot be added
+// without exceeding the limits of a request or reordering the rows of its
+// partition key, in which case the batch needs to be sent first.
+func (b *kinesisBatch) add(partitionKey string, data []byte, aggregate bool) bool {
+	if aggregate {
+		bucket, hashKey := kinesisAggregationBucket(partitionKey)
+		a, ok := b.aggregates[bucket]
+		if !ok {
+			a = newKinesisAggregate(hashKey)
+		}
+		prev, next := a.recordSize(), a.recordSizeWith(partitionKey, data)
+		if next > kinesisMaxBytesPerRecord || b.bytes-prev+next > kinesisMaxBytesPerRequest {
+			return false
+		}
+		a.add(partitionKey, data)
+		b.aggregates[bucket] = a
+		b.bytes += next - prev
+		return true
+	}
+
+	size := len(partitionKey) + len(data)
+	if _, ok := b.partitionKeys[partitionKey]; ok ||
+		len(b.entries) >= kinesisMaxRecordsPerRequest ||
+		size > kinesisMaxBytesPerRecord || b.bytes+size > kinesisMaxBytesPerRequest {
+		return false
+	}
+	b.entries = append(b.entries, &kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(partitionKey),
+	})
+	b.partitionKeys[partitionKey] = struct{}{}
+	b.bytes += size
+	return true
+}
+
+// noteMessage accounts for a row added to the batch.
+func (b *kinesisBatch) noteMessage(alloc kvevent.Alloc, mvcc hlc.Timestamp) {
+	if b.numMessages == 0 {
+		b.emitTime = timeutil.Now()
+	}
+	b.numMessages++
+	b.alloc.Merge(&alloc)
+	if b.mvcc.IsEmpty() || mvcc.Less(b.mvcc) {
+		b.mvcc = mvcc
+	}
+}
+
+// requestEntries returns the records of the PutRecords request sending the
+// batch.
+func (b *kinesisBatch) requestEntries() []*kinesis.PutRecordsRequestEntry {
+	if len(b.aggregates) == 0 {
+		return b.entries
+	}
+	buckets := make([]byte, 0, len(b.aggregates))
+	for bucket := range b.aggregates {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	entries := make([]*kinesis.PutRecordsRequestEntry, len(buckets))
+	for i, bucket := range buckets {
+		entries[i] = b.aggregates[bucket].entry()
+	}
+	return entries
+}
+
+func (b *kinesisBatch) reset() {
+	*b = kinesisBatch{
+		partitionKeys: make(map[string]struct{}),
+		aggregates:    make(map[byte]*kinesisAggregate),
+	}
+}
+
+type kinesisSinkKnobs struct {
+	OverrideClient func() (kinesisClient, error)
+}
+
+// kinesisSink emits to Amazon Kinesis Data Streams. The rows of each table
+// are emitted to the stream named after the table, unless the sink URI names
+// a single stream for all the tables.
+type kinesisSink struct {
+	clientCfg  awsClientConfig
+	client     kinesisClient
+	topicNamer *TopicNamer
+	cfg        kinesisSinkConfig
+	retryCfg   sinkRetryPolicy
+	metrics    metricsRecorder
+	knobs      kinesisSinkKnobs
+
+	// batches are the rows buffered for each stream, which are yet to be sent.
+	batches map[string]*kinesisBatch
+}
+
+var _ Sink = (*kinesisSink)(nil)
+
+func makeKinesisSink(
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.KinesisSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	switch encodingOpts.Format {
+	case changefeedbase.OptFormatJSON, changefeedbase.OptFormatCSV:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+
+	switch encodingOpts.Envelope {
+	case changefeedbase.OptEnvelopeWrapped:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+
+	// The stream names have the same restrictions as the kafka topic names.
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicNamer, err := MakeTopicNamer(targets,
+		WithPrefix(topicPrefix), WithSingleName(u.Host), WithSanitizeFn(SQLNameToKafkaName))
+	if err != nil {
+		return nil, err
+	}
+
+	clientCfg, err := makeAWSClientConfig(&u)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown kinesis sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	s := &kinesisSink{
+		clientCfg:  clientCfg,
+		topicNamer: topicNamer,
+		metrics:    mb(requiresResourceAccounting),
+		batches:    make(map[string]*kinesisBatch),
+	}
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getKinesisSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptKinesisSinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface.
+func (s *kinesisSink) Dial() error {
+	if s.knobs.OverrideClient != nil {
+		client, err := s.knobs.OverrideClient()
+		s.client = client
+		return err
+	}
+	client, err := newKinesisClient(s.clientCfg)
+	s.client = client
+	return err
+}
+
+// partitionKey returns the partition key of a row.
+func (s *kinesisSink) partitionKey(key []byte) string {
+	// The rows do not have keys with some formats, like CSV.
+	if s.cfg.PartitionKey == kinesisPartitionKeyRandom || len(key) == 0 {
+		return strconv.FormatUint(rand.Uint64(), 36)
+	}
+	if len(key) > kinesisMaxPartitionKeyLength {
+		// Kinesis only uses the hash of the partition keys, so hashing the long
+		// keys keeps the rows with the same key in the same shard.
+		sum := sha256.Sum256(key)
+		return hex.EncodeToString(sum[:])
+	}
+	return string(key)
+}
+
+// EmitRow implements the Sink interface.
+func (s *kinesisSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	stream, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	b, ok := s.batches[stream]
+	if !ok {
+		b = newKinesisBatch()
+		s.batches[stream] = b
+	}
+
+	partitionKey := s.partitionKey(key)
+	if !b.add(partitionKey, value, s.cfg.Aggregate) {
+		if err := s.send(ctx, stream, b); err != nil {
+			return err
+		}
+		if !b.add(partitionKey, value, s.cfg.Aggregate) {
+			return errors.Errorf("message of %d bytes exceeds the maximum size of kinesis records of %d bytes",
+				len(value), kinesisMaxBytesPerRecord)
+		}
+	}
+	b.noteMessage(alloc, mvcc)
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+
+	if (s.cfg.Flush.Messages > 0 && b.numMessages >= s.cfg.Flush.Messages) ||
+		(s.cfg.Flush.Bytes > 0 && b.bytes >= s.cfg.Flush.Bytes) {
+		return s.send(ctx, stream, b)
+	}
+	return nil
+}
+
+// send sends the rows of a batch, and resets the batch.
+func (s *kinesisSink) send(ctx context.Context, stream string, b *kinesisBatch) error {
+	if b.numMessages == 0 {
+		return nil
+	}
+	if err := s.putRecords(ctx, stream, b.requestEntries()); err != nil {
+		return err
+	}
+	s.metrics.recordEmittedBatch(b.emitTime, b.numMessages, b.mvcc, b.bytes, sinkDoesNotCompress)
+	b.alloc.Release(ctx)
+	b.reset()
+	return nil
+}
+
+// putRecords puts records into a stream, retrying the records which failed.
+// The records which failed can be retried on their own since the records of a
+// request are not ordered.
+func (s *kinesisSink) putRecords(
+	ctx context.Context, stream string, entries []*kinesis.PutRecordsRequestEntry,
+) error {
+	return s.retryCfg.do(ctx, func() error {
+		out, err := s.client.PutRecordsWithContext(ctx, &kinesis.PutRecordsInput{
+			StreamName: aws.String(stream),
+			Records:    entries,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "putting records into kinesis stream %s", stream)
+		}
+		if aws.Int64Value(out.FailedRecordCount) == 0 {
+			return nil
+		}
+		var failed []*kinesis.PutRecordsRequestEntry
+		var firstErr error
+		for i, r := range out.Records {
+			if r.ErrorCode == nil {
+				continue
+			}
+			failed = append(failed, entries[i])
+			if firstErr == nil {
+				firstErr = errors.Newf("%s: %s", aws.StringValue(r.ErrorCode), aws.StringValue(r.ErrorMessage))
+			}
+		}
+		entries = failed
+		return errors.Wrapf(firstErr, "putting %d records into kinesis stream %s", len(failed), stream)
+	})
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved timestamps
+// are emitted to every open shard of the streams, so that the consumers of
+// each shard see them.
+func (s *kinesisSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return errors.Wrap(err, "encoding resolved timestamp")
+	}
+	return s.topicNamer.Each(func(stream string) error {
+		shards, err := s.listOpenShards(ctx, stream)
+		if err != nil {
+			return err
+		}
+		entries := make([]*kinesis.PutRecordsRequestEntry, len(shards))
+		for i, shard := range shards {
+			entries[i] = &kinesis.PutRecordsRequestEntry{
+				Data:            payload,
+				PartitionKey:    aws.String(kinesisResolvedPartitionKey),
+				ExplicitHashKey: shard.HashKeyRange.StartingHashKey,
+			}
+		}
+		for len(entries) > 0 {
+			n := len(entries)
+			if n > kinesisMaxRecordsPerRequest {
+				n = kinesisMaxRecordsPerRequest
+			}
+			if err := s.putRecords(ctx, stream, entries[:n]); err != nil {
+				return errors.Wrap(err, "emitting resolved timestamp")
+			}
+			entries = entries[n:]
+		}
+		return nil
+	})
+}
+
+// listOpenShards returns the shards of a stream which are open for writes.
+func (s *kinesisSink) listOpenShards(ctx context.Context, stream string) ([]*kinesis.Shard, error) {
+	var shards []*kinesis.Shard
+	input := &kinesis.ListShardsInput{StreamName: aws.String(stream)}
+	for {
+		out, err := s.client.ListShardsWithContext(ctx, input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing shards of kinesis stream %s", stream)
+		}
+		for _, shard := range out.Shards {
+			// The shards which were split or merged are closed.
+			if shard.SequenceNumberRange == nil || shard.SequenceNumberRange.EndingSequenceNumber == nil {
+				shards = append(shards, shard)
+			}
+		}
+		if out.NextToken == nil {
+			return shards, nil
+		}
+		// The stream name must not be set along with the token.
+		input = &kinesis.ListShardsInput{NextToken: out.NextToken}
+	}
+}
+
+// Flush implements the Sink interface.
+func (s *kinesisSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	for stream, b := range s.batches {
+		if err := s.send(ctx, stream, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *kinesisSink) Close() error {
+	for _, b := range s.batches {
+		b.alloc.Release(context.Background())
+	}
+	return nil
+}
+
+// Topics gives the names of all streams that have been initialized
+// and will receive resolved timestamps.
+func (s *kinesisSink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
@@ -0,0 +1,511 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeKinesisClient records the PutRecords requests it receives.
+type fakeKinesisClient struct {
+	syncutil.Mutex
+	requests []*kinesis.PutRecordsInput
+	// failures is the number of times the records with a partition key fail
+	// before they succeed.
+	failures map[string]int
+	// shards are the shards of every stream, which are listed shardsPerPage at
+	// a time.
+	shards        []*kinesis.Shard
+	shardsPerPage int
+}
+
+var _ kinesisClient = (*fakeKinesisClient)(nil)
+
+func (c *fakeKinesisClient) PutRecordsWithContext(
+	_ aws.Context, input *kinesis.PutRecordsInput, _ ...request.Option,
+) (*kinesis.PutRecordsOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.requests = append(c.requests, &kinesis.PutRecordsInput{
+		StreamName: input.StreamName,
+		Records:    append([]*kinesis.PutRecordsRequestEntry(nil), input.Records...),
+	})
+	out := &kinesis.PutRecordsOutput{}
+	var failed int64
+	for i, r := range input.Records {
+		if c.failures[aws.StringValue(r.PartitionKey)] > 0 {
+			c.failures[aws.StringValue(r.PartitionKey)]--
+			failed++
+			out.Records = append(out.Records, &kinesis.PutRecordsResultEntry{
+				ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+				ErrorMessage: aws.String("rate exceeded"),
+			})
+			continue
+		}
+		out.Records = append(out.Records, &kinesis.PutRecordsResultEntry{
+			SequenceNumber: aws.String(strconv.Itoa(i)),
+			ShardId:        aws.String("shardId-000000000000"),
+		})
+	}
+	out.FailedRecordCount = aws.Int64(failed)
+	return out, nil
+}
+
+func (c *fakeKinesisClient) ListShardsWithContext(
+	_ aws.Context, input *kinesis.ListShardsInput, _ ...request.Option,
+) (*kinesis.ListShardsOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	start := 0
+	if input.NextToken != nil {
+		if input.StreamName != nil {
+			return nil, errors.New("NextToken and StreamName cannot be provided together")
+		}
+		var err error
+		if start, err = strconv.Atoi(*input.NextToken); err != nil {
+			return nil, err
+		}
+	}
+	end := len(c.shards)
+	if c.shardsPerPage > 0 && start+c.shardsPerPage < end {
+		end = start + c.shardsPerPage
+	}
+	out := &kinesis.ListShardsOutput{Shards: c.shards[start:end]}
+	if end < len(c.shards) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+// sentRecords returns the partition keys and the data of the records of each
+// request sent so far.
+func (c *fakeKinesisClient) sentRecords() [][]string {
+	c.Lock()
+	defer c.Unlock()
+	var sent [][]string
+	for _, req := range c.requests {
+		var records []string
+		for _, r := range req.Records {
+			records = append(records, fmt.Sprintf("%s:%s", aws.StringValue(r.PartitionKey), r.Data))
+		}
+		sent = append(sent, records)
+	}
+	return sent
+}
+
+const testKinesisSinkURI = `kinesis://?AWS_REGION=us-east-1&AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`
+
+func getGenericKinesisSinkOptions(config string) changefeedbase.StatementOptions {
+	return changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:            string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope:          string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptKinesisSinkConfig: config,
+	})
+}
+
+func makeTestKinesisSink(
+	t *testing.T, uri string, opts changefeedbase.StatementOptions, targetNames ...string,
+) (*kinesisSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	encodingOpts, err := opts.GetEncodingOptions()
+	require.NoError(t, err)
+	kinesisOpts, err := opts.GetKinesisSinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeKinesisSink(sinkURL{URL: u}, encodingOpts, kinesisOpts,
+		makeChangefeedTargets(targetNames...), nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*kinesisSink), nil
+}
+
+func dialTestKinesisSink(
+	t *testing.T, config string, client *fakeKinesisClient, targetNames ...string,
+) *kinesisSink {
+	// Speed up the tests by using faster backoff times.
+	if config == `` {
+		config = `{"Retry":{"Backoff":"5ms"}}`
+	}
+	s, err := makeTestKinesisSink(t, testKinesisSinkURI, getGenericKinesisSinkOptions(config), targetNames...)
+	require.NoError(t, err)
+	s.knobs.OverrideClient = func() (kinesisClient, error) { return client, nil }
+	require.NoError(t, s.Dial())
+	return s
+}
+
+func TestKinesisSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		opts   map[string]string
+		topics []string
+		err    string
+	}{
+		{
+			name:   "stream per table",
+			uri:    testKinesisSinkURI + `&topic_prefix=prefix_`,
+			topics: []string{"prefix_t"},
+		},
+		{
+			name:   "single stream",
+			uri:    `kinesis://stream?AWS_REGION=us-east-1&AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`,
+			topics: []string{"stream"},
+		},
+		{
+			name:   "implicit auth",
+			uri:    `kinesis://?AUTH=implicit`,
+			topics: []string{"t"},
+		},
+		{
+			name: "unknown param",
+			uri:  testKinesisSinkURI + `&foo=bar`,
+			err:  `unknown kinesis sink query parameters: foo`,
+		},
+		{
+			name: "missing access key",
+			uri:  `kinesis://?AWS_REGION=us-east-1&AWS_SECRET_ACCESS_KEY=secret`,
+			err:  `AWS_ACCESS_KEY_ID is not set`,
+		},
+		{
+			name: "missing region",
+			uri:  `kinesis://?AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`,
+			err:  `AWS_REGION is not set`,
+		},
+		{
+			name: "unsupported auth",
+			uri:  `kinesis://?AUTH=foo`,
+			err:  `unsupported value foo for AUTH`,
+		},
+		{
+			name: "unknown partition key",
+			uri:  testKinesisSinkURI,
+			opts: map[string]string{changefeedbase.OptKinesisSinkConfig: `{"PartitionKey":"column"}`},
+			err:  `unknown partition key "column"`,
+		},
+		{
+			name: "negative flush",
+			uri:  testKinesisSinkURI,
+			opts: map[string]string{changefeedbase.OptKinesisSinkConfig: `{"Flush":{"Messages":-1}}`},
+			err:  `all config values must be non-negative`,
+		},
+		{
+			name: "avro",
+			uri:  testKinesisSinkURI,
+			opts: map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatAvro)},
+			err:  `this sink is incompatible with format=avro`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := map[string]string{
+				changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+				changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+			}
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+			s, err := makeTestKinesisSink(t, tc.uri, changefeedbase.MakeStatementOptions(opts), "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+		})
+	}
+}
+
+func TestKinesisSinkBatching(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var pool testAllocPool
+	emit := func(s *kinesisSink, key, value string) {
+		require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(key), []byte(value), zeroTS, zeroTS, pool.alloc()))
+	}
+
+	t.Run("one record per key", func(t *testing.T) {
+		client := &fakeKinesisClient{}
+		s := dialTestKinesisSink(t, ``, client, "t")
+		emit(s, `[1]`, `a`)
+		emit(s, `[2]`, `b`)
+		require.Empty(t, client.sentRecords())
+		// The second row of [1] cannot be sent along with the first one, since
+		// the records of a request are not ordered.
+		emit(s, `[1]`, `c`)
+		require.Equal(t, [][]string{{`[1]:a`, `[2]:b`}}, client.sentRecords())
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, [][]string{{`[1]:a`, `[2]:b`}, {`[1]:c`}}, client.sentRecords())
+		require.Equal(t, "t", aws.StringValue(client.requests[0].StreamName))
+		require.EqualValues(t, 0, pool.used())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("flush messages", func(t *testing.T) {
+		client := &fakeKinesisClient{}
+		s := dialTestKinesisSink(t, `{"Flush":{"Messages":2}}`, client, "t")
+		emit(s, `[1]`, `a`)
+		emit(s, `[2]`, `b`)
+		require.Equal(t, [][]string{{`[1]:a`, `[2]:b`}}, client.sentRecords())
+		emit(s, `[3]`, `c`)
+		require.Len(t, client.sentRecords(), 1)
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, [][]string{{`[1]:a`, `[2]:b`}, {`[3]:c`}}, client.sentRecords())
+		require.EqualValues(t, 0, pool.used())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("random partition keys", func(t *testing.T) {
+		client := &fakeKinesisClient{}
+		s := dialTestKinesisSink(t, `{"PartitionKey":"random"}`, client, "t")
+		emit(s, `[1]`, `a`)
+		emit(s, `[1]`, `b`)
+		require.NoError(t, s.Flush(ctx))
+		require.Len(t, client.requests, 1)
+		for _, r := range client.requests[0].Records {
+			require.NotEqual(t, `[1]`, aws.StringValue(r.PartitionKey))
+		}
+		require.EqualValues(t, 0, pool.used())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("oversized record", func(t *testing.T) {
+		client := &fakeKinesisClient{}
+		s := dialTestKinesisSink(t, ``, client, "t")
+		err := s.EmitRow(ctx, topic("t"), []byte(`[1]`), make([]byte, kinesisMaxBytesPerRecord),
+			zeroTS, zeroTS, zeroAlloc)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `exceeds the maximum size of kinesis records`)
+		require.NoError(t, s.Close())
+	})
+}
+
+func TestKinesisSinkRetriesFailedRecords(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var pool testAllocPool
+
+	client := &fakeKinesisClient{failures: map[string]int{`[2]`: 2}}
+	s := dialTestKinesisSink(t, ``, client, "t")
+	for i := 1; i <= 3; i++ {
+		key := fmt.Sprintf(`[%d]`, i)
+		require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(key), []byte(key), zeroTS, zeroTS, pool.alloc()))
+	}
+	require.NoError(t, s.Flush(ctx))
+	// Only the records which failed are retried.
+	require.Equal(t, [][]string{
+		{`[1]:[1]`, `[2]:[2]`, `[3]:[3]`},
+		{`[2]:[2]`},
+		{`[2]:[2]`},
+	}, client.sentRecords())
+	require.EqualValues(t, 0, pool.used())
+
+	client.failures[`[1]`] = 100
+	require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(`[1]`), []byte(`[1]`), zeroTS, zeroTS, pool.alloc()))
+	err := s.Flush(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), kinesis.ErrCodeProvisionedThroughputExceededException)
+	require.NoError(t, s.Close())
+	require.EqualValues(t, 0, pool.used())
+}
+
+// kinesisAggregatedRow is a row decoded from an aggregated record.
+type kinesisAggregatedRow struct {
+	partitionKey, explicitHashKey, data string
+}
+
+// decodeKinesisAggregate decodes the rows of a record in the KPL format.
+func decodeKinesisAggregate(t *testing.T, data []byte) []kinesisAggregatedRow {
+	require.True(t, bytes.HasPrefix(data, kinesisAggregationMagic))
+	msg := data[len(kinesisAggregationMagic) : len(data)-md5.Size]
+	sum := md5.Sum(msg)
+	require.Equal(t, sum[:], data[len(data)-md5.Size:])
+
+	consumeBytes := func(b []byte) ([]byte, []byte) {
+		v, n := protowire.ConsumeBytes(b)
+		require.GreaterOrEqual(t, n, 0)
+		return v, b[n:]
+	}
+	var partitionKeys, explicitHashKeys []string
+	type record struct {
+		keyIndex, hashKeyIndex uint64
+		data                   []byte
+	}
+	var records []record
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		require.GreaterOrEqual(t, n, 0)
+		require.Equal(t, protowire.BytesType, typ)
+		var v []byte
+		v, msg = consumeBytes(msg[n:])
+		switch num {
+		case 1:
+			partitionKeys = append(partitionKeys, string(v))
+		case 2:
+			explicitHashKeys = append(explicitHashKeys, string(v))
+		case 3:
+			var r record
+			for len(v) > 0 {
+				num, typ, n := protowire.ConsumeTag(v)
+				require.GreaterOrEqual(t, n, 0)
+				v = v[n:]
+				switch num {
+				case 1, 2:
+					require.Equal(t, protowire.VarintType, typ)
+					x, n := protowire.ConsumeVarint(v)
+					require.GreaterOrEqual(t, n, 0)
+					if num == 1 {
+						r.keyIndex = x
+					} else {
+						r.hashKeyIndex = x
+					}
+					v = v[n:]
+				case 3:
+					require.Equal(t, protowire.BytesType, typ)
+					r.data, v = consumeBytes(v)
+				default:
+					t.Fatalf("unexpected field %d in record", num)
+				}
+			}
+			records = append(records, r)
+		default:
+			t.Fatalf("unexpected field %d in aggregated record", num)
+		}
+	}
+
+	var rows []kinesisAggregatedRow
+	for _, r := range records {
+		require.Less(t, r.keyIndex, uint64(len(partitionKeys)))
+		require.Less(t, r.hashKeyIndex, uint64(len(explicitHashKeys)))
+		rows = append(rows, kinesisAggregatedRow{
+			partitionKey:    partitionKeys[r.keyIndex],
+			explicitHashKey: explicitHashKeys[r.hashKeyIndex],
+			data:            string(r.data),
+		})
+	}
+	return rows
+}
+
+func TestKinesisSinkAggregation(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var pool testAllocPool
+
+	client := &fakeKinesisClient{}
+	s := dialTestKinesisSink(t, `{"Aggregate":true}`, client, "t")
+	const numKeys = 1000
+	expected := make(map[string][]string)
+	for i := 0; i < 2*numKeys; i++ {
+		key, value := fmt.Sprintf(`[%d]`, i%numKeys), fmt.Sprintf(`v%d`, i)
+		expected[key] = append(expected[key], value)
+		require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(key), []byte(value), zeroTS, zeroTS, pool.alloc()))
+	}
+	require.NoError(t, s.Flush(ctx))
+	require.EqualValues(t, 0, pool.used())
+
+	// The rows of a key are aggregated into the same bucket, so they all fit
+	// in a single request.
+	require.Len(t, client.requests, 1)
+	actual := make(map[string][]string)
+	hashKeys := make(map[string]struct{})
+	for _, r := range client.requests[0].Records {
+		hashKey := aws.StringValue(r.ExplicitHashKey)
+		_, dup := hashKeys[hashKey]
+		require.False(t, dup, "more than one record for explicit hash key %s", hashKey)
+		hashKeys[hashKey] = struct{}{}
+
+		for _, row := range decodeKinesisAggregate(t, r.Data) {
+			_, bucketHashKey := kinesisAggregationBucket(row.partitionKey)
+			require.Equal(t, bucketHashKey, hashKey)
+			require.Equal(t, hashKey, row.explicitHashKey)
+			actual[row.partitionKey] = append(actual[row.partitionKey], row.data)
+		}
+	}
+	require.Equal(t, expected, actual)
+	require.NoError(t, s.Close())
+}
+
+func TestKinesisSinkEmitResolvedTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	shard := func(startingHashKey string, closed bool) *kinesis.Shard {
+		s := &kinesis.Shard{
+			HashKeyRange:        &kinesis.HashKeyRange{StartingHashKey: aws.String(startingHashKey)},
+			SequenceNumberRange: &kinesis.SequenceNumberRange{StartingSequenceNumber: aws.String("1")},
+		}
+		if closed {
+			s.SequenceNumberRange.EndingSequenceNumber = aws.String("2")
+		}
+		return s
+	}
+	client := &fakeKinesisClient{
+		shards: []*kinesis.Shard{
+			shard("0", true),
+			shard("1", false),
+			shard("100", false),
+			shard("200", true),
+			shard("300", false),
+		},
+		shardsPerPage: 2,
+	}
+	s := dialTestKinesisSink(t, ``, client, "t1", "t2")
+
+	opts, err := getGenericKinesisSinkOptions(``).GetEncodingOptions()
+	require.NoError(t, err)
+	enc, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+	require.NoError(t, err)
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 2}))
+
+	// The resolved timestamp is emitted to every open shard of every stream.
+	require.Len(t, client.requests, 2)
+	var streams []string
+	for _, req := range client.requests {
+		streams = append(streams, aws.StringValue(req.StreamName))
+		var hashKeys []string
+		for _, r := range req.Records {
+			require.Equal(t, kinesisResolvedPartitionKey, aws.StringValue(r.PartitionKey))
+			require.Equal(t, `{"resolved":"2.0000000000"}`, string(r.Data))
+			hashKeys = append(hashKeys, aws.StringValue(r.ExplicitHashKey))
+		}
+		require.Equal(t, []string{"1", "100", "300"}, hashKeys)
+	}
+	require.ElementsMatch(t, []string{"t1", "t2"}, streams)
+	require.NoError(t, s.Close())
+}
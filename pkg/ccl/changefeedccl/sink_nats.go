@@ -0,0 +1,354 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcnats"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// natsDefaultPort is the default port of the NATS servers.
+const natsDefaultPort = "4222"
+
+// natsSubjectTopicPlaceholder is replaced by the name of the topic of the
+// rows in the subject template of the NATS sink.
+const natsSubjectTopicPlaceholder = `{topic}`
+
+// proper JSON schema for nats sink config:
+//
+//	{
+//	  "AckTimeout": ...,
+//	  "MaxPending": ...,
+//	}
+//
+// AckTimeout is how long the sink waits for JetStream to acknowledge a message
+// before failing, and MaxPending is the number of messages which may await
+// their acknowledgements before the sink waits for them.
+type natsSinkConfig struct {
+	AckTimeout jsonDuration `json:",omitempty"`
+	MaxPending int          `json:",omitempty"`
+}
+
+func getNATSSinkConfig(jsonStr changefeedbase.SinkSpecificJSONConfig) (natsSinkConfig, error) {
+	cfg := natsSinkConfig{
+		AckTimeout: jsonDuration(30 * time.Second),
+		MaxPending: 1000,
+	}
+	if jsonStr != `` {
+		if err := json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+	if cfg.AckTimeout <= 0 || cfg.MaxPending <= 0 {
+		return cfg, errors.Errorf("invalid option value %s, all config values must be positive",
+			changefeedbase.OptNATSSinkConfig)
+	}
+	return cfg, nil
+}
+
+// validateNATSSubjectTemplate checks that the subjects generated from a
+// subject template are valid NATS subjects to publish to: dot separated
+// non-empty tokens, without whitespace or wildcards.
+func validateNATSSubjectTemplate(template string) error {
+	subject := strings.Replace(template, natsSubjectTopicPlaceholder, "topic", -1)
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || strings.ContainsAny(token, " \t\r\n*>") {
+			return errors.Errorf(`param %s must be a valid nats subject, got %q`,
+				changefeedbase.SinkParamSubjectTemplate, template)
+		}
+	}
+	return nil
+}
+
+func makeNATSDialConfig(u *sinkURL) (cdcnats.DialConfig, error) {
+	cfg := cdcnats.DialConfig{Addr: u.Host, PingInterval: cdcnats.DefaultPingInterval}
+	if u.Port() == "" {
+		cfg.Addr = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	if u.User != nil {
+		// The user info is either a user and a password, or a token.
+		if password, ok := u.User.Password(); ok {
+			cfg.User, cfg.Password = u.User.Username(), password
+		} else {
+			cfg.Token = u.User.Username()
+		}
+	}
+
+	var tlsEnabled bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return cfg, err
+	}
+	var err error
+	cfg.TLSConfig, err = consumeTLSParams(u, tlsEnabled)
+	return cfg, err
+}
+
+// natsSink publishes to the subjects of NATS JetStream streams. The subject of
+// the rows of each table is generated from the subject template of the sink
+// URI, whose {topic} placeholder is replaced by the topic name of the table.
+//
+// The messages are published without waiting for the acknowledgements of the
+// previous ones, and the sink waits for all of them to be acknowledged when it
+// is flushed, which guarantees that they were stored in the streams.
+type natsSink struct {
+	dialCfg         cdcnats.DialConfig
+	subjectTemplate string
+	topicNamer      *TopicNamer
+	cfg             natsSinkConfig
+	metrics         metricsRecorder
+
+	conn *cdcnats.Conn
+	// ackCh is notified when messages are acknowledged.
+	ackCh chan struct{}
+	mu    struct {
+		syncutil.Mutex
+		// inflight is the number of messages which await their
+		// acknowledgements.
+		inflight int
+		// err is the first error returned by the acknowledgements.
+		err error
+	}
+}
+
+var _ Sink = (*natsSink)(nil)
+
+func makeNATSSink(
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.NATSSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	switch encodingOpts.Format {
+	case changefeedbase.OptFormatJSON, changefeedbase.OptFormatCSV:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+
+	switch encodingOpts.Envelope {
+	case changefeedbase.OptEnvelopeWrapped:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.Errorf(`nats sink URI must specify a server`)
+	}
+
+	subjectTemplate := u.consumeParam(changefeedbase.SinkParamSubjectTemplate)
+	if subjectTemplate == "" {
+		subjectTemplate = natsSubjectTopicPlaceholder
+	}
+	if err := validateNATSSubjectTemplate(subjectTemplate); err != nil {
+		return nil, err
+	}
+	// The topic names have the same restrictions as the kafka topic names,
+	// which are valid tokens of NATS subjects.
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicName := u.consumeParam(changefeedbase.SinkParamTopicName)
+	topicNamer, err := MakeTopicNamer(targets,
+		WithPrefix(topicPrefix), WithSingleName(topicName), WithSanitizeFn(SQLNameToKafkaName))
+	if err != nil {
+		return nil, err
+	}
+
+	dialCfg, err := makeNATSDialConfig(&u)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown nats sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	cfg, err := getNATSSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptNATSSinkConfig)
+	}
+
+	return &natsSink{
+		dialCfg:         dialCfg,
+		subjectTemplate: subjectTemplate,
+		topicNamer:      topicNamer,
+		cfg:             cfg,
+		metrics:         mb(requiresResourceAccounting),
+		ackCh:           make(chan struct{}, 1),
+	}, nil
+}
+
+// Dial implements the Sink interface.
+func (s *natsSink) Dial() error {
+	conn, err := cdcnats.Dial(context.Background(), s.dialCfg)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// subject returns the subject of a topic.
+func (s *natsSink) subject(topicName string) string {
+	return strings.Replace(s.subjectTemplate, natsSubjectTopicPlaceholder, topicName, -1)
+}
+
+// publish publishes a message, and calls onAck once it was acknowledged or
+// failed.
+func (s *natsSink) publish(subject string, data []byte, onAck func(error)) error {
+	s.mu.Lock()
+	s.mu.inflight++
+	s.mu.Unlock()
+
+	err := s.conn.Publish(subject, data, func(err error) {
+		onAck(err)
+		s.mu.Lock()
+		s.mu.inflight--
+		if err != nil && s.mu.err == nil {
+			s.mu.err = err
+		}
+		s.mu.Unlock()
+		select {
+		case s.ackCh <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.mu.inflight--
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// waitForAcks waits until at most maxInflight messages await their
+// acknowledgements, or returns the error of the acknowledgements.
+func (s *natsSink) waitForAcks(ctx context.Context, maxInflight int) error {
+	if err := s.conn.Flush(); err != nil {
+		return err
+	}
+
+	timeout := time.Duration(s.cfg.AckTimeout)
+	var timer timeutil.Timer
+	defer timer.Stop()
+	timer.Reset(timeout)
+	for {
+		s.mu.Lock()
+		inflight, err := s.mu.inflight, s.mu.err
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if inflight <= maxInflight {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ackCh:
+			timer.Reset(timeout)
+		case <-timer.C:
+			timer.Read = true
+			return errors.Errorf("timed out after %s waiting for jetstream to acknowledge %d messages",
+				timeout, inflight)
+		}
+	}
+}
+
+// EmitRow implements the Sink interface.
+func (s *natsSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	topicName, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	inflight, err := s.mu.inflight, s.mu.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if inflight >= s.cfg.MaxPending {
+		if err := s.waitForAcks(ctx, s.cfg.MaxPending-1); err != nil {
+			return err
+		}
+	}
+
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+	updateMetrics := s.metrics.recordOneMessage()
+	return s.publish(s.subject(topicName), value, func(err error) {
+		if err == nil {
+			updateMetrics(mvcc, len(value), sinkDoesNotCompress)
+		}
+		alloc.Release(ctx)
+	})
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved timestamps
+// are published to the subjects of all the topics.
+func (s *natsSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return errors.Wrap(err, "encoding resolved timestamp")
+	}
+	if err := s.topicNamer.Each(func(topicName string) error {
+		return s.publish(s.subject(topicName), payload, func(error) {})
+	}); err != nil {
+		return err
+	}
+	return s.waitForAcks(ctx, 0)
+}
+
+// Flush implements the Sink interface.
+func (s *natsSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.waitForAcks(ctx, 0)
+}
+
+// Close implements the Sink interface.
+func (s *natsSink) Close() error {
+	if s.conn != nil {
+		// The messages which await their acknowledgements are failed, which
+		// releases their resources.
+		s.conn.Close()
+	}
+	return nil
+}
+
+// Topics gives the names of all subjects that have been initialized
+// and will receive resolved timestamps.
+func (s *natsSink) Topics() []string {
+	var subjects []string
+	for _, topicName := range s.topicNamer.DisplayNamesSlice() {
+		subjects = append(subjects, s.subject(topicName))
+	}
+	return subjects
+}
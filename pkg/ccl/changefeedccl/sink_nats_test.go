@@ -0,0 +1,227 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcnats"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestNATSSink(
+	t *testing.T, uri string, opts map[string]string, targetNames ...string,
+) (*natsSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	stmtOpts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+	for k, v := range opts {
+		stmtOpts[k] = v
+	}
+	statementOpts := changefeedbase.MakeStatementOptions(stmtOpts)
+	encodingOpts, err := statementOpts.GetEncodingOptions()
+	require.NoError(t, err)
+	s, err := makeNATSSink(sinkURL{URL: u}, encodingOpts, statementOpts.GetNATSSinkOptions(),
+		makeChangefeedTargets(targetNames...), nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*natsSink), nil
+}
+
+func TestNATSSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name     string
+		uri      string
+		opts     map[string]string
+		subjects []string
+		err      string
+	}{
+		{
+			name:     "default subject",
+			uri:      `nats://localhost`,
+			subjects: []string{"t"},
+		},
+		{
+			name:     "subject template",
+			uri:      `nats://localhost:4222?subject_template=cdc.{topic}.rows&topic_prefix=p_`,
+			subjects: []string{"cdc.p_t.rows"},
+		},
+		{
+			name:     "single subject",
+			uri:      `nats://localhost?subject_template=cdc.{topic}&topic_name=all`,
+			subjects: []string{"cdc.all"},
+		},
+		{
+			name: "missing server",
+			uri:  `nats://?subject_template=cdc`,
+			err:  `nats sink URI must specify a server`,
+		},
+		{
+			name: "empty token",
+			uri:  `nats://localhost?subject_template=cdc..{topic}`,
+			err:  `param subject_template must be a valid nats subject`,
+		},
+		{
+			name: "wildcard",
+			uri:  `nats://localhost?subject_template=cdc.*`,
+			err:  `param subject_template must be a valid nats subject`,
+		},
+		{
+			name: "unknown param",
+			uri:  `nats://localhost?foo=bar`,
+			err:  `unknown nats sink query parameters: foo`,
+		},
+		{
+			name: "ca cert without tls",
+			uri:  `nats://localhost?ca_cert=Zm9v`,
+			err:  `ca_cert requires tls_enabled=true`,
+		},
+		{
+			name: "invalid config",
+			uri:  `nats://localhost`,
+			opts: map[string]string{changefeedbase.OptNATSSinkConfig: `{"MaxPending":-1}`},
+			err:  `all config values must be positive`,
+		},
+		{
+			name: "avro",
+			uri:  `nats://localhost`,
+			opts: map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatAvro)},
+			err:  `this sink is incompatible with format=avro`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestNATSSink(t, tc.uri, tc.opts, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.subjects, s.Topics())
+			require.Equal(t, cdcnats.DefaultPingInterval, s.dialCfg.PingInterval)
+		})
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	server, err := cdcnats.StartMockServer("user", "pass")
+	require.NoError(t, err)
+	defer server.Close()
+	addr := server.Addr()
+
+	var pool testAllocPool
+	emit := func(s *natsSink, topicName, value string) error {
+		return s.EmitRow(ctx, topic(topicName), []byte(`[1]`), []byte(value), zeroTS, zeroTS, pool.alloc())
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		s, err := makeTestNATSSink(t, `nats://user:pass@`+addr+`?subject_template=cdc.{topic}`, nil, "t1", "t2")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, "t1", "a"))
+		require.NoError(t, emit(s, "t2", "b"))
+		require.NoError(t, emit(s, "t1", "c"))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{"cdc.t1:a", "cdc.t2:b", "cdc.t1:c"}, server.Messages())
+		require.EqualValues(t, 0, pool.used())
+
+		opts, err := changefeedbase.MakeStatementOptions(map[string]string{
+			changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+		}).GetEncodingOptions()
+		require.NoError(t, err)
+		enc, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+		require.NoError(t, err)
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 2}))
+		require.ElementsMatch(t, []string{
+			`cdc.t1:{"resolved":"2.0000000000"}`,
+			`cdc.t2:{"resolved":"2.0000000000"}`,
+		}, server.Messages()[3:])
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		s, err := makeTestNATSSink(t, `nats://user:wrong@`+addr, nil, "t1")
+		require.NoError(t, err)
+		err = s.Dial()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `Authorization Violation`)
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		s, err := makeTestNATSSink(t, `nats://user:pass@`+addr+`?subject_template=cdc.{topic}`, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "reject"))
+		err = s.Flush(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `insufficient resources`)
+		// The sink fails once a message failed.
+		require.Error(t, s.EmitRow(ctx, topic("t1"), []byte(`[1]`), []byte("a"), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("no stream", func(t *testing.T) {
+		s, err := makeTestNATSSink(t, `nats://user:pass@`+addr+`?subject_template=other.{topic}`, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "a"))
+		err = s.Flush(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `no jetstream stream stores the messages of nats subject other.t1`)
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("payload too large", func(t *testing.T) {
+		s, err := makeTestNATSSink(t, `nats://user:pass@`+addr+`?subject_template=cdc.{topic}`, nil, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		err = s.EmitRow(ctx, topic("t1"), []byte(`[1]`), make([]byte, 2048), zeroTS, zeroTS, zeroAlloc)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `exceeds the maximum payload of the nats server of 1024 bytes`)
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("ack timeout", func(t *testing.T) {
+		server.SetHoldAcks(true)
+		defer server.SetHoldAcks(false)
+		s, err := makeTestNATSSink(t, `nats://user:pass@`+addr+`?subject_template=cdc.{topic}`,
+			map[string]string{changefeedbase.OptNATSSinkConfig: `{"AckTimeout":"10ms","MaxPending":1}`}, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t1", "a"))
+		// The second message waits for the acknowledgement of the first one.
+		err = s.EmitRow(ctx, topic("t1"), []byte(`[1]`), []byte("b"), zeroTS, zeroTS, zeroAlloc)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `timed out after 10ms waiting for jetstream to acknowledge 1 messages`)
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+}
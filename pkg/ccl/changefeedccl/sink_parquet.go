@@ -0,0 +1,194 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+)
+
+// parquetBatchingSink wraps a streaming sink, such as kafka or webhook, to
+// emit the rows of format=parquet as parquet files: the rows of each topic are
+// buffered in a file until the sink is flushed, which the changefeed does
+// before it emits a resolved timestamp or checkpoints its progress, and each
+// file is then emitted as a single message of the topic. Files are cut early
+// when they exceed changefeed.parquet.max_batch_size, or when the version of
+// the table of the topic changes, since the columns of the files are those of
+// a version of the table.
+//
+// The rows are encoded in JSON by the encoder, and converted to the columns of
+// the files by the sink (see parquetRowSchema). Resolved timestamps are emitted
+// by the wrapped sink, in JSON.
+type parquetBatchingSink struct {
+	wrapped Sink
+	codec   parquet.CompressionCodec
+	sv      *settings.Values
+	// batches are the open files of the topics.
+	batches map[TopicIdentifier]*parquetBatch
+}
+
+// parquetBatch is a parquet file holding the rows of a version of the table of
+// a topic.
+type parquetBatch struct {
+	topic   TopicDescriptor
+	version descpb.DescriptorVersion
+	schema  *parquetRowSchema
+	writer  *goparquet.FileWriter
+	buf     bytes.Buffer
+	rawSize int
+	alloc   kvevent.Alloc
+	// updated and mvcc are the latest updated and the oldest MVCC timestamps of
+	// the rows of the batch.
+	updated, mvcc hlc.Timestamp
+}
+
+var _ Sink = (*parquetBatchingSink)(nil)
+
+func makeParquetBatchingSink(
+	wrapped Sink, codec parquet.CompressionCodec, sv *settings.Values,
+) *parquetBatchingSink {
+	return &parquetBatchingSink{
+		wrapped: wrapped,
+		codec:   codec,
+		sv:      sv,
+		batches: make(map[TopicIdentifier]*parquetBatch),
+	}
+}
+
+// parquetCompressionCodec returns the codec compressing the pages of the
+// parquet files for the compression option of a sink, which defaults to
+// snappy.
+func parquetCompressionCodec(compression string) parquet.CompressionCodec {
+	switch compression {
+	case changefeedbase.OptCompressionGzip:
+		return parquet.CompressionCodec_GZIP
+	case changefeedbase.OptCompressionZstd:
+		return parquet.CompressionCodec_ZSTD
+	default:
+		return parquet.CompressionCodec_SNAPPY
+	}
+}
+
+// EmitRow implements the Sink interface.
+func (s *parquetBatchingSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	id := topic.GetTopicIdentifier()
+	b, ok := s.batches[id]
+	if ok && b.version != topic.GetVersion() {
+		// The rows of the previous version are emitted first, so that the rows
+		// of each key stay ordered.
+		if err := s.emitBatch(ctx, id, b); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		descTopic, isDescTopic := topic.(eventDescriptorTopic)
+		if !isDescTopic || descTopic.getEventDescriptor() == nil {
+			return errors.AssertionFailedf("topic %v does not describe its columns", id)
+		}
+		schema, err := makeParquetRowSchema(descTopic.getEventDescriptor())
+		if err != nil {
+			return errors.Wrapf(err, "mapping the columns of table %d", id.TableID)
+		}
+		b = &parquetBatch{topic: topic, version: topic.GetVersion(), schema: schema}
+		b.writer = schema.newFileWriter(&b.buf, s.codec)
+		s.batches[id] = b
+	}
+
+	row, err := b.schema.encodeRow(key, value, updated)
+	if err != nil {
+		return err
+	}
+	if err := b.writer.AddData(row); err != nil {
+		return err
+	}
+	b.alloc.Merge(&alloc)
+	// The writers buffer the rows until they are closed, so the size of the
+	// file is estimated from the size of the encoded rows.
+	b.rawSize += len(value)
+	b.updated.Forward(updated)
+	if b.mvcc.IsEmpty() || mvcc.Less(b.mvcc) {
+		b.mvcc = mvcc
+	}
+
+	if int64(b.rawSize) >= changefeedbase.ParquetMaxBatchSize.Get(s.sv) {
+		return s.emitBatch(ctx, id, b)
+	}
+	return nil
+}
+
+// emitBatch closes the file of the batch and emits it to the wrapped sink.
+func (s *parquetBatchingSink) emitBatch(
+	ctx context.Context, id TopicIdentifier, b *parquetBatch,
+) error {
+	delete(s.batches, id)
+	if err := b.writer.Close(); err != nil {
+		b.alloc.Release(ctx)
+		return err
+	}
+	// The files are not keyed, which spreads them across the partitions of the
+	// topic, if any.
+	return s.wrapped.EmitRow(ctx, b.topic, nil /* key */, b.buf.Bytes(), b.updated, b.mvcc, b.alloc)
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *parquetBatchingSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	// The changefeed flushes the sink before it emits resolved timestamps, so
+	// that the rows preceding them have already been emitted.
+	return s.wrapped.EmitResolvedTimestamp(ctx, encoder, resolved)
+}
+
+// Flush implements the Sink interface.
+func (s *parquetBatchingSink) Flush(ctx context.Context) error {
+	for id, b := range s.batches {
+		if err := s.emitBatch(ctx, id, b); err != nil {
+			return err
+		}
+	}
+	return s.wrapped.Flush(ctx)
+}
+
+// AcknowledgedResolved implements the AcknowledgingSink interface.
+func (s *parquetBatchingSink) AcknowledgedResolved() (hlc.Timestamp, bool) {
+	if as, ok := s.wrapped.(AcknowledgingSink); ok {
+		return as.AcknowledgedResolved()
+	}
+	return hlc.Timestamp{}, false
+}
+
+// Close implements the Sink interface.
+func (s *parquetBatchingSink) Close() error {
+	for id, b := range s.batches {
+		b.alloc.Release(context.Background())
+		delete(s.batches, id)
+	}
+	return s.wrapped.Close()
+}
+
+// Dial implements the Sink interface.
+func (s *parquetBatchingSink) Dial() error {
+	return s.wrapped.Dial()
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParquetBatchingSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	wrapped := &fanOutTestSink{}
+	s := makeParquetBatchingSink(wrapped, parquet.CompressionCodec_SNAPPY, &st.SV)
+
+	cols := []descpb.ColumnDescriptor{
+		{Name: "a", Type: types.Int},
+		{Name: "b", Type: types.String, Nullable: true},
+	}
+	tV1 := makeTestTopicWithColumns(t, 100, "t", 1, cols...)
+	tV2 := makeTestTopicWithColumns(t, 100, "t", 2,
+		append(cols, descpb.ColumnDescriptor{Name: "c", Type: types.Float, Nullable: true})...)
+	ts := func(i int64) hlc.Timestamp { return hlc.Timestamp{WallTime: i} }
+
+	// readFile decodes the rows of the i-th parquet file emitted to the wrapped
+	// sink, whose messages are not keyed.
+	readFile := func(i int) []string {
+		require.True(t, strings.HasPrefix(wrapped.rows[i], `=`))
+		fr, err := goparquet.NewFileReader(bytes.NewReader([]byte(wrapped.rows[i][1:])))
+		require.NoError(t, err)
+		var rows []string
+		for {
+			row, err := fr.NextRow()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			var cols []string
+			for name, v := range row {
+				if b, ok := v.([]byte); ok {
+					v = string(b)
+				}
+				cols = append(cols, fmt.Sprintf(`%s=%v`, name, v))
+			}
+			sort.Strings(cols)
+			rows = append(rows, strings.Join(cols, ` `))
+		}
+		return rows
+	}
+
+	// The rows are buffered until the sink is flushed.
+	require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[1]`),
+		[]byte(`{"after": {"a": 1, "b": "x"}}`), ts(1), ts(1), kvevent.Alloc{}))
+	require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[2]`),
+		[]byte(`{"after": null}`), ts(2), ts(2), kvevent.Alloc{}))
+	require.Empty(t, wrapped.rows)
+	require.NoError(t, s.Flush(ctx))
+	require.Equal(t, 1, wrapped.flushes)
+	require.Len(t, wrapped.rows, 1)
+	require.Equal(t, []string{
+		`_crdb_deleted=false _crdb_updated=1.0000000000 a=1 b=x`,
+		`_crdb_deleted=true _crdb_updated=2.0000000000 a=2`,
+	}, readFile(0))
+
+	// The rows of a version of the table are emitted before the rows of the
+	// next version.
+	require.NoError(t, s.EmitRow(ctx, tV1, []byte(`[3]`),
+		[]byte(`{"after": {"a": 3, "b": "y"}}`), ts(3), ts(3), kvevent.Alloc{}))
+	require.NoError(t, s.EmitRow(ctx, tV2, []byte(`[4]`),
+		[]byte(`{"after": {"a": 4, "b": null, "c": 1.5}}`), ts(4), ts(4), kvevent.Alloc{}))
+	require.Len(t, wrapped.rows, 2)
+	require.Equal(t, []string{`_crdb_deleted=false _crdb_updated=3.0000000000 a=3 b=y`}, readFile(1))
+	require.NoError(t, s.Flush(ctx))
+	require.Len(t, wrapped.rows, 3)
+	require.Equal(t, []string{`_crdb_deleted=false _crdb_updated=4.0000000000 a=4 c=1.5`}, readFile(2))
+
+	// The files are emitted early when they exceed the maximum batch size.
+	changefeedbase.ParquetMaxBatchSize.Override(ctx, &st.SV, 1)
+	require.NoError(t, s.EmitRow(ctx, tV2, []byte(`[5]`),
+		[]byte(`{"after": {"a": 5}}`), ts(5), ts(5), kvevent.Alloc{}))
+	require.Len(t, wrapped.rows, 4)
+	require.Equal(t, []string{`_crdb_deleted=false _crdb_updated=5.0000000000 a=5`}, readFile(3))
+
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, nil /* encoder */, ts(5)))
+	require.Equal(t, []hlc.Timestamp{ts(5)}, wrapped.resolved)
+	require.NoError(t, s.Close())
+	require.True(t, wrapped.closed)
+}
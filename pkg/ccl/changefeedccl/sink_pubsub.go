@@ -10,10 +10,13 @@ package changefeedccl
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"hash/crc32"
 	"net/url"
+	"unicode/utf8"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
@@ -40,6 +43,16 @@ const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
 // TODO: make numOfWorkers configurable
 const numOfWorkers = 128
 
+// The values of changefeedbase.SinkParamOrderingKey.
+const (
+	pubsubOrderingKeyRowKey = `key`
+	pubsubOrderingKeyNone   = `none`
+)
+
+// pubsubMaxOrderingKeyBytes is the maximum size of the ordering keys of the
+// messages.
+const pubsubMaxOrderingKeyBytes = 1024
+
 // isPubsubSInk returns true if url contains scheme with valid pubsub sink
 func isPubsubSink(u *url.URL) bool {
 	return u.Scheme == GcpScheme
@@ -49,7 +62,7 @@ type pubsubClient interface {
 	init() error
 	closeTopics()
 	flushTopics()
-	sendMessage(content []byte, topic string, key string) error
+	sendMessage(content []byte, topic string, orderingKey string, attributes map[string]string) error
 	sendMessageToAllTopics(content []byte) error
 	connectivityError() error
 }
@@ -72,6 +85,16 @@ type pubsubMessage struct {
 	alloc   kvevent.Alloc
 	message payload
 	isFlush bool
+	// orderingKey is the ordering key of the message, or empty if the
+	// messages are not ordered.
+	orderingKey string
+	// attributes are the attributes attached to the message, if any (see
+	// changefeedbase.OptPubsubAttributes).
+	attributes map[string]string
+	// mvcc is the MVCC timestamp of the row, and updateMetrics records the
+	// message in the metrics once it is published.
+	mvcc          hlc.Timestamp
+	updateMetrics recordOneMessageCallback
 }
 
 type gcpPubsubClient struct {
@@ -82,6 +105,8 @@ type gcpPubsubClient struct {
 	region     string
 	topicNamer *TopicNamer
 	url        sinkURL
+	// enableOrdering is set if the messages are published with ordering keys.
+	enableOrdering bool
 
 	mu struct {
 		syncutil.Mutex
@@ -109,11 +134,20 @@ type pubsubSink struct {
 	topicNamer *TopicNamer
 
 	format changefeedbase.FormatType
+
+	// orderingKey is the source of the ordering keys of the messages, one of
+	// pubsubOrderingKeyRowKey and pubsubOrderingKeyNone, unless the ordering
+	// keys are the values of orderingKeyExpr.
+	orderingKey     string
+	orderingKeyExpr string
+
+	metrics metricsRecorder
 }
 
 // TODO: unify gcp credentials code with gcp cloud storage credentials code
-// getGCPCredentials returns gcp credentials parsed out from url
-func getGCPCredentials(ctx context.Context, u sinkURL) (option.ClientOption, error) {
+// getGCPCredentials returns gcp credentials for the given scope parsed out
+// from url
+func getGCPCredentials(ctx context.Context, u sinkURL, scope string) (option.ClientOption, error) {
 	const authParam = "AUTH"
 	const assumeRoleParam = "ASSUME_ROLE"
 	const authSpecified = "specified"
@@ -125,7 +159,7 @@ func getGCPCredentials(ctx context.Context, u sinkURL) (option.ClientOption, err
 	var err error
 	authOption := u.consumeParam(authParam)
 	assumeRoleOption := u.consumeParam(assumeRoleParam)
-	authScope := gcpScope
+	authScope := scope
 	if assumeRoleOption != "" {
 		// If we need to assume a role, the credentials need to have the scope to
 		// impersonate instead.
@@ -159,7 +193,7 @@ func getGCPCredentials(ctx context.Context, u sinkURL) (option.ClientOption, err
 		assumeRole, delegateRoles := cloud.ParseRoleString(assumeRoleOption)
 		cfg := impersonate.CredentialsConfig{
 			TargetPrincipal: assumeRole,
-			Scopes:          []string{gcpScope},
+			Scopes:          []string{scope},
 			Delegates:       delegateRoles,
 		}
 
@@ -179,6 +213,7 @@ func MakePubsubSink(
 	u *url.URL,
 	encodingOpts changefeedbase.EncodingOptions,
 	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
 ) (Sink, error) {
 
 	pubsubURL := sinkURL{URL: u, q: u.Query()}
@@ -202,12 +237,31 @@ func MakePubsubSink(
 			changefeedbase.OptEnvelope, encodingOpts.Envelope)
 	}
 
+	orderingKey := pubsubURL.consumeParam(changefeedbase.SinkParamOrderingKey)
+	orderingKeyExpr := pubsubURL.consumeParam(changefeedbase.SinkParamOrderingKeyExpr)
+	switch orderingKey {
+	case ``:
+		orderingKey = pubsubOrderingKeyRowKey
+	case pubsubOrderingKeyRowKey:
+	case pubsubOrderingKeyNone:
+		if orderingKeyExpr != `` {
+			return nil, errors.Errorf(`%s=%s cannot be used with %s`,
+				changefeedbase.SinkParamOrderingKey, pubsubOrderingKeyNone, changefeedbase.SinkParamOrderingKeyExpr)
+		}
+	default:
+		return nil, errors.Errorf(`unknown %s: %q, expected %q or %q`,
+			changefeedbase.SinkParamOrderingKey, orderingKey, pubsubOrderingKeyRowKey, pubsubOrderingKeyNone)
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	p := &pubsubSink{
-		workerCtx:   ctx,
-		numWorkers:  numOfWorkers,
-		exitWorkers: cancel,
-		format:      formatType,
+		workerCtx:       ctx,
+		numWorkers:      numOfWorkers,
+		exitWorkers:     cancel,
+		format:          formatType,
+		orderingKey:     orderingKey,
+		orderingKeyExpr: orderingKeyExpr,
+		metrics:         mb(requiresResourceAccounting),
 	}
 
 	// creates custom pubsub object based on scheme
@@ -224,11 +278,12 @@ func MakePubsubSink(
 			return nil, err
 		}
 		g := &gcpPubsubClient{
-			topicNamer: tn,
-			ctx:        ctx,
-			projectID:  projectID,
-			region:     gcpEndpointForRegion(region),
-			url:        pubsubURL,
+			topicNamer:     tn,
+			ctx:            ctx,
+			projectID:      projectID,
+			region:         gcpEndpointForRegion(region),
+			url:            pubsubURL,
+			enableOrdering: orderingKey != pubsubOrderingKeyNone,
 		}
 		p.client = g
 		p.topicNamer = tn
@@ -243,6 +298,9 @@ func (p *pubsubSink) Dial() error {
 	return p.client.init()
 }
 
+var _ HeaderedEventSink = (*pubsubSink)(nil)
+var _ PathPartitionedEventSink = (*pubsubSink)(nil)
+
 // EmitRow pushes a message to event channel where it is consumed by workers
 func (p *pubsubSink) EmitRow(
 	ctx context.Context,
@@ -251,20 +309,104 @@ func (p *pubsubSink) EmitRow(
 	updated hlc.Timestamp,
 	mvcc hlc.Timestamp,
 	alloc kvevent.Alloc,
+) error {
+	return p.emitMessage(ctx, topic, key, value, mvcc, alloc, p.rowOrderingKey(key), nil /* attributes */)
+}
+
+// EmitRowWithHeaders implements the HeaderedEventSink interface. The headers
+// are attached to the message as its attributes; since pubsub topics are not
+// partitioned, the partition is ignored.
+func (p *pubsubSink) EmitRowWithHeaders(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+	headers []messageHeader,
+) error {
+	attributes := make(map[string]string, len(headers))
+	for _, h := range headers {
+		// The attributes holding NULL are omitted, so that subscriptions can
+		// filter on their presence.
+		if h.value != nil {
+			attributes[h.key] = string(h.value)
+		}
+	}
+	return p.emitMessage(ctx, topic, key, value, mvcc, alloc, p.rowOrderingKey(key), attributes)
+}
+
+// rowOrderingKey returns the ordering key of the message of the row with the
+// specified key, unless the ordering keys are the values of an expression.
+func (p *pubsubSink) rowOrderingKey(key []byte) string {
+	if p.orderingKey != pubsubOrderingKeyRowKey {
+		return ``
+	}
+	return pubsubOrderingKey(key)
+}
+
+// PartitionExprs implements the PathPartitionedEventSink interface. The
+// expression of the ordering keys, if any, is evaluated for each row, whose
+// value is then the ordering key of its message.
+func (p *pubsubSink) PartitionExprs() []string {
+	if p.orderingKeyExpr == `` {
+		return nil
+	}
+	return []string{p.orderingKeyExpr}
+}
+
+// EmitRowWithPartitionValues implements the PathPartitionedEventSink
+// interface.
+func (p *pubsubSink) EmitRowWithPartitionValues(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partitionValues []string,
+) error {
+	if len(partitionValues) != 1 {
+		return errors.AssertionFailedf(
+			"expected the value of the ordering key expression, found %d values", len(partitionValues))
+	}
+	return p.emitMessage(
+		ctx, topic, key, value, mvcc, alloc, pubsubOrderingKey([]byte(partitionValues[0])), nil /* attributes */)
+}
+
+// emitMessage pushes the message of a row to the event channel of the worker
+// publishing its ordering key, or its key if the message is not ordered.
+func (p *pubsubSink) emitMessage(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	orderingKey string,
+	attributes map[string]string,
 ) error {
 	topicName, err := p.topicNamer.Name(topic)
 	if err != nil {
 		return err
 	}
+	p.metrics.recordMessageSize(int64(len(key) + len(value)))
 	m := pubsubMessage{
 		alloc: alloc, isFlush: false, message: payload{
 			Key:   key,
 			Value: value,
 			Topic: topicName,
-		}}
+		},
+		orderingKey:   orderingKey,
+		attributes:    attributes,
+		mvcc:          mvcc,
+		updateMetrics: p.metrics.recordOneMessage(),
+	}
 
-	// calculate index by hashing key
+	// The messages with the same ordering key must be published by the same
+	// worker to be published in order.
 	i := p.workerIndex(key)
+	if orderingKey != `` {
+		i = p.workerIndex([]byte(orderingKey))
+	}
 	select {
 	// check the sink context in case workers have been terminated
 	case <-p.workerCtx.Done():
@@ -284,6 +426,8 @@ func (p *pubsubSink) EmitRow(
 func (p *pubsubSink) EmitResolvedTimestamp(
 	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
 ) error {
+	defer p.metrics.recordResolvedCallback()()
+
 	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
 	if err != nil {
 		return errors.Wrap(err, "encoding resolved timestamp")
@@ -294,6 +438,7 @@ func (p *pubsubSink) EmitResolvedTimestamp(
 
 // Flush blocks until all messages in the event channels are sent
 func (p *pubsubSink) Flush(ctx context.Context) error {
+	defer p.metrics.recordFlushRequestCallback()()
 	if err := p.flush(ctx); err != nil {
 		return errors.CombineErrors(p.client.connectivityError(), err)
 	}
@@ -412,15 +557,28 @@ func (p *pubsubSink) workerLoop(workerIndex int) {
 				content = msg.message.Value
 			}
 
-			err = p.client.sendMessage(content, msg.message.Topic, string(msg.message.Key))
+			err = p.client.sendMessage(content, msg.message.Topic, msg.orderingKey, msg.attributes)
 			if err != nil {
 				p.exitWorkersWithError(err)
+			} else {
+				msg.updateMetrics(msg.mvcc, len(content), sinkDoesNotCompress)
 			}
 			msg.alloc.Release(p.workerCtx)
 		}
 	}
 }
 
+// pubsubOrderingKey returns the ordering key of the messages with the
+// specified key or value of the ordering key expression. The ordering keys are
+// at most 1024 bytes of UTF-8, so the other keys are hashed.
+func pubsubOrderingKey(key []byte) string {
+	if len(key) <= pubsubMaxOrderingKeyBytes && utf8.Valid(key) {
+		return string(key)
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
 // exitWorkersWithError sends an error to the sink error channel
 func (p *pubsubSink) exitWorkersWithError(err error) {
 	// errChan has buffer size 1, first error will be saved to the buffer and
@@ -475,7 +633,7 @@ func (p *gcpPubsubClient) init() error {
 	var client *pubsub.Client
 	var err error
 
-	creds, err := getGCPCredentials(p.ctx, p.url)
+	creds, err := getGCPCredentials(p.ctx, p.url, gcpScope)
 	if err != nil {
 		return err
 	}
@@ -517,7 +675,7 @@ func (p *gcpPubsubClient) openTopic(topicName string) (*pubsub.Topic, error) {
 			return nil, err
 		}
 	}
-	t.EnableMessageOrdering = true
+	t.EnableMessageOrdering = p.enableOrdering
 	return t, nil
 }
 
@@ -529,14 +687,17 @@ func (p *gcpPubsubClient) closeTopics() {
 }
 
 // sendMessage sends a message to the topic
-func (p *gcpPubsubClient) sendMessage(m []byte, topic string, key string) error {
+func (p *gcpPubsubClient) sendMessage(
+	m []byte, topic string, orderingKey string, attributes map[string]string,
+) error {
 	t, err := p.getTopicClient(topic)
 	if err != nil {
 		return err
 	}
 	res := t.Publish(p.ctx, &pubsub.Message{
 		Data:        m,
-		OrderingKey: key,
+		OrderingKey: orderingKey,
+		Attributes:  attributes,
 	})
 
 	// The Get method blocks until a server-generated ID or
@@ -544,6 +705,14 @@ func (p *gcpPubsubClient) sendMessage(m []byte, topic string, key string) error
 	_, err = res.Get(p.ctx)
 	if err != nil {
 		p.recordPublishError(err)
+		// Publishing the messages with the ordering key is paused after an
+		// error, so that no message is published out of order. The error
+		// fails the sink, and the changefeed retries from its last
+		// checkpoint, publishing again the messages of the key in order, so
+		// publishing can be resumed.
+		if orderingKey != `` {
+			t.ResumePublish(orderingKey)
+		}
 		return err
 	}
 
@@ -0,0 +1,152 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPubsubSinkOrderingKey(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	encodingOpts := changefeedbase.EncodingOptions{
+		Format:   changefeedbase.OptFormatJSON,
+		Envelope: changefeedbase.OptEnvelopeWrapped,
+	}
+	tableTopic := topic(`t`)
+	targets := changefeedbase.Targets{}
+	targets.Add(tableTopic.GetTargetSpecification())
+
+	makeSink := func(t *testing.T, params string) (*pubsubSink, *fakePubsubClient, error) {
+		u, err := url.Parse(`gcpubsub://project?region=us-east1` + params)
+		require.NoError(t, err)
+		s, err := MakePubsubSink(ctx, u, encodingOpts, targets, nilMetricsRecorderBuilder)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := s.(*pubsubSink)
+		client := &fakePubsubClient{buffer: &mockPubsubMessageBuffer{}}
+		p.client = client
+		p.setupWorkers()
+		t.Cleanup(func() { require.NoError(t, p.Close()) })
+		return p, client, nil
+	}
+
+	t.Run("key", func(t *testing.T) {
+		p, client, err := makeSink(t, ``)
+		require.NoError(t, err)
+		require.Empty(t, p.PartitionExprs())
+		require.NoError(t, p.EmitRow(ctx, tableTopic, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, p.Flush(ctx))
+		require.Equal(t, `[1]`, client.buffer.pop().orderingKey)
+
+		// Keys too long to be ordering keys are hashed.
+		longKey := []byte(`["` + strings.Repeat(`a`, pubsubMaxOrderingKeyBytes) + `"]`)
+		require.NoError(t, p.EmitRow(ctx, tableTopic, longKey, []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, p.Flush(ctx))
+		orderingKey := client.buffer.pop().orderingKey
+		require.Len(t, orderingKey, 64)
+		require.Equal(t, pubsubOrderingKey(longKey), orderingKey)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		p, client, err := makeSink(t, `&ordering_key=none`)
+		require.NoError(t, err)
+		require.NoError(t, p.EmitRow(ctx, tableTopic, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, p.Flush(ctx))
+		require.Equal(t, ``, client.buffer.pop().orderingKey)
+	})
+
+	t.Run("expr", func(t *testing.T) {
+		p, client, err := makeSink(t, `&ordering_key_expr=b`)
+		require.NoError(t, err)
+		require.Equal(t, []string{`b`}, p.PartitionExprs())
+		for _, key := range []string{`[1]`, `[2]`} {
+			require.NoError(t, p.EmitRowWithPartitionValues(
+				ctx, tableTopic, []byte(key), []byte(`{}`), zeroTS, zeroTS, zeroAlloc, []string{`bar`}))
+		}
+		require.NoError(t, p.Flush(ctx))
+		require.Equal(t, `bar`, client.buffer.pop().orderingKey)
+		require.Equal(t, `bar`, client.buffer.pop().orderingKey)
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		p, client, err := makeSink(t, ``)
+		require.NoError(t, err)
+		headers := []messageHeader{
+			{key: changefeedbase.MessageHeaderTable, value: []byte(`t`)},
+			{key: `region`, value: nil},
+		}
+		require.NoError(t, p.EmitRowWithHeaders(
+			ctx, tableTopic, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc, 0, headers))
+		require.NoError(t, p.Flush(ctx))
+		m := client.buffer.pop()
+		require.Equal(t, `[1]`, m.orderingKey)
+		require.Equal(t, map[string]string{changefeedbase.MessageHeaderTable: `t`}, m.attributes)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, _, err := makeSink(t, `&ordering_key=nope`)
+		require.EqualError(t, err, `unknown ordering_key: "nope", expected "key" or "none"`)
+		_, _, err = makeSink(t, `&ordering_key=none&ordering_key_expr=b`)
+		require.EqualError(t, err, `ordering_key=none cannot be used with ordering_key_expr`)
+	})
+}
+
+func TestPubsubSinkMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	encodingOpts := changefeedbase.EncodingOptions{
+		Format:   changefeedbase.OptFormatJSON,
+		Envelope: changefeedbase.OptEnvelopeWrapped,
+	}
+	tableTopic := topic(`t`)
+	targets := changefeedbase.Targets{}
+	targets.Add(tableTopic.GetTargetSpecification())
+
+	metrics, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	u, err := url.Parse(`gcpubsub://project?region=us-east1`)
+	require.NoError(t, err)
+	s, err := MakePubsubSink(ctx, u, encodingOpts, targets,
+		func(bool) metricsRecorder { return metrics })
+	require.NoError(t, err)
+	p := s.(*pubsubSink)
+	client := &fakePubsubClient{buffer: &mockPubsubMessageBuffer{}}
+	p.client = client
+	p.setupWorkers()
+	defer func() { require.NoError(t, p.Close()) }()
+
+	// The published messages and the flushes are recorded in the metrics of
+	// the scope of the sink.
+	for _, key := range []string{`[1]`, `[2]`} {
+		require.NoError(t, p.EmitRow(ctx, tableTopic, []byte(key), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+	}
+	require.NoError(t, p.Flush(ctx))
+	var bytes int
+	for m := client.buffer.pop(); m != nil; m = client.buffer.pop() {
+		bytes += len(m.data)
+	}
+	require.EqualValues(t, 2, metrics.EmittedMessages.Value())
+	require.EqualValues(t, bytes, metrics.EmittedBytes.Value())
+	require.EqualValues(t, 1, metrics.Flushes.Value())
+}
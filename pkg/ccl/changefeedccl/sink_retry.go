@@ -0,0 +1,114 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// sinkRetryPolicy is the policy with which a sink retries its requests which
+// fail. The sink configures the backoff and the number of retries, which the
+// sink_retry options of the changefeed override (see makeSinkRetryPolicy).
+type sinkRetryPolicy struct {
+	retry.Options
+	// transientOnly only retries the errors which isTransientSinkError
+	// classifies as transient.
+	transientOnly bool
+}
+
+// makeSinkRetryPolicy returns the retry policy of a sink whose configuration
+// sets retryCfg, overridden by the sink_retry options of the changefeed.
+func makeSinkRetryPolicy(
+	retryCfg retry.Options, opts changefeedbase.SinkRetryOptions,
+) sinkRetryPolicy {
+	if opts.MaxAttempts > 0 {
+		retryCfg.MaxRetries = opts.MaxAttempts - 1
+	}
+	if opts.Backoff != nil {
+		retryCfg.InitialBackoff = *opts.Backoff
+		if retryCfg.MaxBackoff < retryCfg.InitialBackoff {
+			retryCfg.MaxBackoff = retryCfg.InitialBackoff
+		}
+	}
+	if opts.MaxBackoff != nil {
+		retryCfg.MaxBackoff = *opts.MaxBackoff
+	}
+	return sinkRetryPolicy{Options: retryCfg, transientOnly: opts.TransientOnly}
+}
+
+// retryable returns whether the policy retries the error of a request.
+func (p sinkRetryPolicy) retryable(err error) bool {
+	return !p.transientOnly || isTransientSinkError(err)
+}
+
+// do calls fn until it succeeds, up to MaxRetries+1 times, and returns the
+// error of its last attempt. The errors which the policy does not retry are
+// returned right away.
+func (p sinkRetryPolicy) do(ctx context.Context, fn func() error) error {
+	attempts := p.MaxRetries + 1
+	var err error
+	for r := retry.StartWithCtx(ctx, p.Options); r.Next(); {
+		attempts--
+		if err = fn(); err == nil || attempts == 0 || !p.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// sinkStatusCodeError is implemented by the errors of the sinks which carry
+// the HTTP status code of a failed request.
+type sinkStatusCodeError interface {
+	error
+	httpStatusCode() int
+}
+
+// isTransientSinkError returns whether a failed request of a sink may succeed
+// when it is sent again, because it timed out, its connection failed, or the
+// endpoint was overloaded or failed internally, i.e. responded with the HTTP
+// status 408, 429 or 5xx. Other errors, such as rejected credentials or
+// malformed requests, persist across attempts.
+func isTransientSinkError(err error) bool {
+	var statusErr sinkStatusCodeError
+	if errors.As(err, &statusErr) {
+		return isTransientStatusCode(statusErr.httpStatusCode())
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return isTransientStatusCode(apiErr.Code)
+	}
+	var awsErr awserr.RequestFailure
+	if errors.As(err, &awsErr) {
+		return isTransientStatusCode(awsErr.StatusCode())
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func isTransientStatusCode(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+func TestSinkRetryPolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	backoff := time.Millisecond
+	policy := makeSinkRetryPolicy(retry.Options{MaxRetries: 10, InitialBackoff: time.Second},
+		changefeedbase.SinkRetryOptions{MaxAttempts: 3, Backoff: &backoff, TransientOnly: true})
+	require.Equal(t, 2, policy.MaxRetries)
+	require.Equal(t, time.Millisecond, policy.InitialBackoff)
+
+	attempts := 0
+	err := policy.do(context.Background(), func() error {
+		attempts++
+		return &webhookError{status: "503 Service Unavailable", statusCode: http.StatusServiceUnavailable}
+	})
+	require.Regexp(t, `503 Service Unavailable`, err)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = policy.do(context.Background(), func() error {
+		attempts++
+		return &webhookError{status: "403 Forbidden", statusCode: http.StatusForbidden}
+	})
+	require.Regexp(t, `403 Forbidden`, err)
+	require.Equal(t, 1, attempts)
+
+	require.True(t, isTransientSinkError(errors.Wrap(context.DeadlineExceeded, "sending")))
+	require.True(t, isTransientSinkError(&googleapi.Error{Code: http.StatusTooManyRequests}))
+	require.False(t, isTransientSinkError(&googleapi.Error{Code: http.StatusBadRequest}))
+	require.False(t, isTransientSinkError(errors.New("invalid credentials")))
+}
@@ -0,0 +1,875 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// snowflakeMaxBytesPerRequest is the maximum size of the rows appended to
+	// a channel by a request.
+	snowflakeMaxBytesPerRequest = 16 << 20
+	// snowflakePipeSuffix is the suffix of the name of the default pipe of a
+	// table, through which the rows are streamed to it.
+	snowflakePipeSuffix = "-STREAMING"
+	// snowflakeJWTLifetime is how long the JSON web tokens authenticating the
+	// sink are valid.
+	snowflakeJWTLifetime = time.Hour
+)
+
+// snowflakeName converts a topic name to an unquoted Snowflake identifier,
+// which names the table receiving its rows.
+func snowflakeName(name string) string {
+	var b strings.Builder
+	for i, r := range strings.ToUpper(name) {
+		if i == 0 && r >= '0' && r <= '9' {
+			b.WriteByte('_')
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+type snowflakeFlushConfig struct {
+	Messages, Bytes int `json:",omitempty"`
+}
+
+// proper JSON schema for snowflake sink config:
+//
+//	{
+//	  "Flush": {
+//	    "Messages": ...,
+//	    "Bytes":    ...,
+//	  },
+//	  "CommitTimeout": ...,
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// The rows of each table are buffered until a request is full, the Flush
+// thresholds are reached or the changefeed flushes the sink. CommitTimeout is
+// how long the sink waits for Snowflake to commit the appended rows when it is
+// flushed.
+type snowflakeSinkConfig struct {
+	Flush         snowflakeFlushConfig `json:",omitempty"`
+	CommitTimeout jsonDuration         `json:",omitempty"`
+	Retry         retryConfig          `json:",omitempty"`
+}
+
+func getSnowflakeSinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg snowflakeSinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.CommitTimeout = jsonDuration(time.Minute)
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative",
+			changefeedbase.OptSnowflakeSinkConfig)
+	}
+	if cfg.CommitTimeout <= 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, CommitTimeout must be positive",
+			changefeedbase.OptSnowflakeSinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+// parseSnowflakePrivateKey parses the PEM encoded RSA key with which the sink
+// signs its JSON web tokens.
+func parseSnowflakePrivateKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.Errorf(`%s must be a PEM encoded RSA key`, changefeedbase.SinkParamPrivateKey)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return key, errors.Wrapf(err, `parsing %s`, changefeedbase.SinkParamPrivateKey)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, `parsing %s`, changefeedbase.SinkParamPrivateKey)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.Errorf(`%s must be an RSA key`, changefeedbase.SinkParamPrivateKey)
+		}
+		return rsaKey, nil
+	default:
+		return nil, errors.Errorf(`%s must be an unencrypted RSA key, found a PEM block of type %s`,
+			changefeedbase.SinkParamPrivateKey, block.Type)
+	}
+}
+
+// makeSnowflakeJWT makes the JSON web token authenticating a user of an
+// account with key pair authentication. The token is signed with RS256, and
+// its issuer names the fingerprint of the public key registered for the user.
+func makeSnowflakeJWT(account, user string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := sha256.Sum256(pub)
+	qualifiedUser := strings.ToUpper(account) + "." + strings.ToUpper(user)
+	claims, err := json.Marshal(struct {
+		Issuer   string `json:"iss"`
+		Subject  string `json:"sub"`
+		IssuedAt int64  `json:"iat"`
+		Expiry   int64  `json:"exp"`
+	}{
+		Issuer:   qualifiedUser + ".SHA256:" + base64.StdEncoding.EncodeToString(fingerprint[:]),
+		Subject:  qualifiedUser,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(snowflakeJWTLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) +
+		"." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// snowflakeClient is a client of the Snowpipe Streaming REST API, for the
+// pipes of a schema.
+//
+// The client authenticates with a JSON web token signed with the key of the
+// user, which it exchanges for a token scoped to the ingest host of the
+// account. The scoped token is exchanged again when it expires.
+type snowflakeClient struct {
+	client           *httputil.Client
+	accountURL       string
+	account, user    string
+	key              *rsa.PrivateKey
+	database, schema string
+
+	// ingestURL is the URL of the ingest host of the account, and token is
+	// the scoped token authenticating the requests sent to it.
+	ingestURL string
+	token     string
+}
+
+// snowflakeAPIError is an error returned by the Snowflake API.
+type snowflakeAPIError struct {
+	statusCode int
+	message    string
+}
+
+func (e *snowflakeAPIError) Error() string {
+	return fmt.Sprintf("snowflake returned %d %s: %s",
+		e.statusCode, http.StatusText(e.statusCode), e.message)
+}
+
+func (e *snowflakeAPIError) httpStatusCode() int {
+	return e.statusCode
+}
+
+// do sends a request and decodes its JSON response into out, if not nil.
+func (c *snowflakeClient) do(
+	ctx context.Context, method, target string, header http.Header, body []byte, out interface{},
+) error {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &snowflakeAPIError{statusCode: resp.StatusCode, message: strings.TrimSpace(string(respBody))}
+	}
+	if out == nil {
+		return nil
+	}
+	if s, ok := out.(*string); ok {
+		*s = strings.TrimSpace(string(respBody))
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(respBody, out), "decoding snowflake response")
+}
+
+// authenticate discovers the ingest host of the account, and exchanges a
+// JSON web token for a token scoped to it.
+func (c *snowflakeClient) authenticate(ctx context.Context) error {
+	jwt, err := makeSnowflakeJWT(c.account, c.user, c.key, timeutil.Now())
+	if err != nil {
+		return errors.Wrap(err, "signing snowflake JSON web token")
+	}
+
+	if c.ingestURL == "" {
+		var host string
+		if err := c.do(ctx, http.MethodGet, c.accountURL+"/v2/streaming/hostname", http.Header{
+			"Authorization":                        {"Bearer " + jwt},
+			"X-Snowflake-Authorization-Token-Type": {"KEYPAIR_JWT"},
+		}, nil, &host); err != nil {
+			return errors.Wrap(err, "discovering snowflake ingest host")
+		}
+		c.ingestURL = "https://" + host
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"scope":      {strings.TrimPrefix(c.ingestURL, "https://")},
+		"assertion":  {jwt},
+	}
+	if err := c.do(ctx, http.MethodPost, c.accountURL+"/oauth/token", http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	}, []byte(form.Encode()), &c.token); err != nil {
+		return errors.Wrap(err, "getting snowflake scoped token")
+	}
+	return nil
+}
+
+// doIngest sends a request to the ingest host, authenticating again when the
+// scoped token expired.
+func (c *snowflakeClient) doIngest(
+	ctx context.Context, method, path, contentType string, body []byte, out interface{},
+) error {
+	for attempt := 0; ; attempt++ {
+		if c.token == "" {
+			if err := c.authenticate(ctx); err != nil {
+				return err
+			}
+		}
+		err := c.do(ctx, method, c.ingestURL+path, http.Header{
+			"Authorization": {"Bearer " + c.token},
+			"Content-Type":  {contentType},
+		}, body, out)
+		var apiErr *snowflakeAPIError
+		if attempt == 0 && errors.As(err, &apiErr) && apiErr.statusCode == http.StatusUnauthorized {
+			c.token = ""
+			continue
+		}
+		return err
+	}
+}
+
+func (c *snowflakeClient) pipePath(prefix, pipe string) string {
+	return fmt.Sprintf("/v2/streaming%s/databases/%s/schemas/%s/pipes/%s", prefix,
+		url.PathEscape(c.database), url.PathEscape(c.schema), url.PathEscape(pipe))
+}
+
+type snowflakeChannelStatus struct {
+	LastCommittedOffsetToken string `json:"last_committed_offset_token"`
+}
+
+// openChannel opens a channel of a pipe, creating it if it does not exist.
+// Opening a channel invalidates its previous continuation token, and discards
+// the rows which were appended to it but not committed. It returns the
+// continuation token of the next request appending rows to the channel, and
+// the offset token of the last committed rows.
+func (c *snowflakeClient) openChannel(
+	ctx context.Context, pipe, channel string,
+) (continuation, committed string, err error) {
+	var resp struct {
+		NextContinuationToken string                 `json:"next_continuation_token"`
+		ChannelStatus         snowflakeChannelStatus `json:"channel_status"`
+	}
+	if err := c.doIngest(ctx, http.MethodPut,
+		c.pipePath("", pipe)+"/channels/"+url.PathEscape(channel),
+		"application/json", []byte(`{}`), &resp); err != nil {
+		return "", "", err
+	}
+	return resp.NextContinuationToken, resp.ChannelStatus.LastCommittedOffsetToken, nil
+}
+
+// appendRows appends newline delimited JSON rows to a channel. The rows are
+// committed asynchronously, and the offset token identifies them in the
+// status of the channel once they are. It returns the continuation token of
+// the next request.
+func (c *snowflakeClient) appendRows(
+	ctx context.Context, pipe, channel, continuation, offsetToken string, rows []byte,
+) (string, error) {
+	query := url.Values{"continuationToken": {continuation}, "offsetToken": {offsetToken}}
+	var resp struct {
+		NextContinuationToken string `json:"next_continuation_token"`
+	}
+	if err := c.doIngest(ctx, http.MethodPost,
+		c.pipePath("/data", pipe)+"/channels/"+url.PathEscape(channel)+"/rows?"+query.Encode(),
+		"application/x-ndjson", rows, &resp); err != nil {
+		return "", err
+	}
+	return resp.NextContinuationToken, nil
+}
+
+// committedOffsetToken returns the offset token of the last committed rows of
+// a channel, which is empty if the channel does not exist or has no committed
+// rows.
+func (c *snowflakeClient) committedOffsetToken(
+	ctx context.Context, pipe, channel string,
+) (string, error) {
+	req, err := json.Marshal(struct {
+		ChannelNames []string `json:"channel_names"`
+	}{ChannelNames: []string{channel}})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		ChannelStatuses map[string]snowflakeChannelStatus `json:"channel_statuses"`
+	}
+	if err := c.doIngest(ctx, http.MethodPost, c.pipePath("", pipe)+":bulk-channel-status",
+		"application/json", req, &resp); err != nil {
+		return "", err
+	}
+	return resp.ChannelStatuses[channel].LastCommittedOffsetToken, nil
+}
+
+// snowflakeRecord is a row streamed to Snowflake, in the format of the tables
+// of the Snowflake connector for Kafka.
+type snowflakeRecord struct {
+	Metadata snowflakeRecordMetadata `json:"RECORD_METADATA"`
+	Content  json.RawMessage         `json:"RECORD_CONTENT"`
+}
+
+type snowflakeRecordMetadata struct {
+	Topic         string          `json:"topic"`
+	Key           json.RawMessage `json:"key,omitempty"`
+	Updated       string          `json:"updated,omitempty"`
+	MVCCTimestamp string          `json:"mvcc_timestamp,omitempty"`
+	Resolved      string          `json:"resolved,omitempty"`
+}
+
+// snowflakeBatch is a request appending rows to a channel.
+type snowflakeBatch struct {
+	// token is the offset token of the request, which is set when it is first
+	// sent.
+	token int64
+	rows  []byte
+
+	messages  int
+	emitBytes int
+	alloc     kvevent.Alloc
+	emitTime  time.Time
+	mvcc      hlc.Timestamp
+}
+
+// snowflakeChannel is a channel of a pipe, to which a sink appends rows.
+type snowflakeChannel struct {
+	pipe, name string
+	// opened is set once the channel was opened by the sink.
+	opened bool
+	// continuation is the continuation token of the next request appending
+	// rows to the channel, which is empty when the channel must be opened.
+	continuation string
+	// batch are the rows buffered for the channel.
+	batch snowflakeBatch
+	// uncommitted are the requests which Snowflake did not commit yet, in the
+	// order of their offset tokens, of which the first sent were appended to
+	// the current instance of the channel.
+	uncommitted []*snowflakeBatch
+	sent        int
+}
+
+// snowflakeTable is a Snowflake table to which the rows of a topic are
+// streamed.
+type snowflakeTable struct {
+	name string
+	// rows is the channel to which the sink appends rows, and resolved the
+	// one to which it appends resolved timestamps.
+	rows, resolved *snowflakeChannel
+	// skipUpTo is the last resolved timestamp committed to the table when the
+	// sink started emitting rows to it. The rows updated at or before it were
+	// committed before the changefeed restarted, and are not appended again.
+	skipUpTo hlc.Timestamp
+}
+
+// snowflakeSink streams the rows of a changefeed to Snowflake tables with
+// Snowpipe Streaming, without staging files in cloud storage. The rows of each
+// table are appended to the default pipe of the Snowflake table named after it
+// in the database and schema of the sink URI, in the format of the tables of
+// the Snowflake connector for Kafka: a RECORD_METADATA column with the topic,
+// key and timestamps of the row, and a RECORD_CONTENT column with its value.
+// The tables must exist.
+//
+// Each node appends the rows of a table to its own channel of the pipe, and
+// the requests appending them are identified by increasing offset tokens.
+// When the sink is flushed, it waits for Snowflake to commit the offset token
+// of the last request, so that the resolved timestamps of the changefeed only
+// advance once the rows below them are committed. A request whose response
+// was lost reopens the channel, which tells which requests were committed, and
+// the requests which were not are appended again.
+//
+// The resolved timestamps are appended to a channel of each table owned by the
+// frontier of the changefeed, with the resolved timestamp as their offset
+// token. When the changefeed restarts, the sink reads the offset token of the
+// last resolved timestamp committed to a table, and skips the rows updated at
+// or before it, so that the rows below the resolved timestamps are committed
+// exactly once. The rows updated after the last committed resolved timestamp
+// may be emitted again when the changefeed restarts, and be committed twice.
+type snowflakeSink struct {
+	ctx        context.Context
+	client     *snowflakeClient
+	topicNamer *TopicNamer
+	cfg        snowflakeSinkConfig
+	retryCfg   sinkRetryPolicy
+	metrics    metricsRecorder
+
+	rowsChannel, resolvedChannel string
+	// lastToken is the offset token of the last request appending rows.
+	lastToken int64
+	tables    map[string]*snowflakeTable
+}
+
+var _ Sink = (*snowflakeSink)(nil)
+
+func makeSnowflakeSink(
+	ctx context.Context,
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.SnowflakeSinkOptions,
+	targets changefeedbase.Targets,
+	jobID jobspb.JobID,
+	srcID base.SQLInstanceID,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	if encodingOpts.Format != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(path) != 2 || path[0] == "" || path[1] == "" {
+		return nil, errors.Errorf(
+			`snowflake sink URI must be snowflake://<account>.snowflakecomputing.com/<database>/<schema>`)
+	}
+	user := u.consumeParam(changefeedbase.SinkParamUser)
+	if user == "" {
+		return nil, errors.Errorf(`snowflake sink requires the %s parameter`, changefeedbase.SinkParamUser)
+	}
+	var keyPEM []byte
+	if err := u.decodeBase64(changefeedbase.SinkParamPrivateKey, &keyPEM); err != nil {
+		return nil, err
+	}
+	if keyPEM == nil {
+		return nil, errors.Errorf(`snowflake sink requires the %s parameter`, changefeedbase.SinkParamPrivateKey)
+	}
+	key, err := parseSnowflakePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicNamer, err := MakeTopicNamer(targets,
+		WithPrefix(topicPrefix), WithJoinByte('_'), WithSanitizeFn(snowflakeName))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := consumeTLSParams(&u, true /* tlsEnabled */)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown snowflake sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	httpClient := httputil.NewClientWithTimeout(httputil.StandardHTTPTimeout)
+	httpClient.Client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+
+	s := &snowflakeSink{
+		ctx: ctx,
+		client: &snowflakeClient{
+			client:     httpClient,
+			accountURL: "https://" + u.Host,
+			account:    strings.SplitN(u.Hostname(), ".", 2)[0],
+			user:       user,
+			key:        key,
+			database:   path[0],
+			schema:     path[1],
+		},
+		topicNamer:      topicNamer,
+		metrics:         mb(requiresResourceAccounting),
+		rowsChannel:     fmt.Sprintf("CRDB_%d_%d", jobID, srcID),
+		resolvedChannel: fmt.Sprintf("CRDB_%d_RESOLVED", jobID),
+		lastToken:       timeutil.Now().UnixNano(),
+		tables:          make(map[string]*snowflakeTable),
+	}
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getSnowflakeSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptSnowflakeSinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface.
+func (s *snowflakeSink) Dial() error {
+	return s.client.authenticate(s.ctx)
+}
+
+// table returns the table to which the rows of a topic are streamed.
+func (s *snowflakeSink) table(name string) *snowflakeTable {
+	t, ok := s.tables[name]
+	if !ok {
+		pipe := name + snowflakePipeSuffix
+		t = &snowflakeTable{
+			name:     name,
+			rows:     &snowflakeChannel{pipe: pipe, name: s.rowsChannel},
+			resolved: &snowflakeChannel{pipe: pipe, name: s.resolvedChannel},
+		}
+		s.tables[name] = t
+	}
+	return t
+}
+
+// openRowsChannel opens the channel to which the rows of a table are
+// appended. The requests which were committed before it was opened are
+// released, and the others are appended again.
+func (s *snowflakeSink) openRowsChannel(ctx context.Context, t *snowflakeTable) error {
+	ch := t.rows
+	continuation, committed, err := s.client.openChannel(ctx, ch.pipe, ch.name)
+	if err != nil {
+		return errors.Wrapf(err, "opening channel %s of snowflake pipe %s", ch.name, ch.pipe)
+	}
+	if token, err := strconv.ParseInt(committed, 10, 64); err == nil {
+		if !ch.opened && token > s.lastToken {
+			// The channel was used by a previous run of the changefeed with
+			// greater offset tokens.
+			s.lastToken = token
+		}
+		if ch.opened {
+			s.releaseCommitted(ctx, ch, token)
+		}
+	}
+	ch.opened, ch.continuation, ch.sent = true, continuation, 0
+	return nil
+}
+
+// releaseCommitted releases the requests of a channel up to a committed offset
+// token.
+func (s *snowflakeSink) releaseCommitted(ctx context.Context, ch *snowflakeChannel, committed int64) {
+	n := 0
+	for n < len(ch.uncommitted) && ch.uncommitted[n].token != 0 && ch.uncommitted[n].token <= committed {
+		b := ch.uncommitted[n]
+		s.metrics.recordEmittedBatch(b.emitTime, b.messages, b.mvcc, b.emitBytes, sinkDoesNotCompress)
+		b.alloc.Release(ctx)
+		n++
+	}
+	ch.uncommitted = ch.uncommitted[n:]
+	if ch.sent -= n; ch.sent < 0 {
+		ch.sent = 0
+	}
+}
+
+// loadSkipUpTo reads the last resolved timestamp committed to a table.
+func (s *snowflakeSink) loadSkipUpTo(ctx context.Context, t *snowflakeTable) error {
+	return s.retryCfg.do(ctx, func() error {
+		committed, err := s.client.committedOffsetToken(ctx, t.resolved.pipe, t.resolved.name)
+		if err != nil {
+			return errors.Wrapf(err, "getting status of channel %s of snowflake pipe %s",
+				t.resolved.name, t.resolved.pipe)
+		}
+		if committed == "" {
+			return nil
+		}
+		if t.skipUpTo, err = hlc.ParseHLC(committed); err != nil {
+			return errors.Wrapf(err, "parsing offset token of channel %s of snowflake pipe %s",
+				t.resolved.name, t.resolved.pipe)
+		}
+		return nil
+	})
+}
+
+// EmitRow implements the Sink interface.
+func (s *snowflakeSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	name, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	t, ok := s.tables[name]
+	if !ok {
+		t = s.table(name)
+		if err := s.loadSkipUpTo(ctx, t); err != nil {
+			delete(s.tables, name)
+			return err
+		}
+	}
+	if !t.skipUpTo.IsEmpty() && updated.LessEq(t.skipUpTo) {
+		alloc.Release(ctx)
+		return nil
+	}
+
+	row, err := json.Marshal(snowflakeRecord{
+		Metadata: snowflakeRecordMetadata{
+			Topic:         name,
+			Key:           key,
+			Updated:       updated.AsOfSystemTime(),
+			MVCCTimestamp: mvcc.AsOfSystemTime(),
+		},
+		Content: value,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "encoding row for snowflake table %s", name)
+	}
+	row = append(row, '\n')
+	if len(row) > snowflakeMaxBytesPerRequest {
+		return errors.Errorf("row of %d bytes exceeds the maximum size of snowflake requests of %d bytes",
+			len(row), snowflakeMaxBytesPerRequest)
+	}
+
+	ch := t.rows
+	if len(ch.batch.rows)+len(row) > snowflakeMaxBytesPerRequest {
+		if err := s.send(ctx, t); err != nil {
+			return err
+		}
+	}
+	b := &ch.batch
+	if b.messages == 0 {
+		b.emitTime = timeutil.Now()
+	}
+	b.rows = append(b.rows, row...)
+	b.messages++
+	b.emitBytes += len(key) + len(value)
+	b.alloc.Merge(&alloc)
+	if b.mvcc.IsEmpty() || mvcc.Less(b.mvcc) {
+		b.mvcc = mvcc
+	}
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+
+	if (s.cfg.Flush.Messages > 0 && b.messages >= s.cfg.Flush.Messages) ||
+		(s.cfg.Flush.Bytes > 0 && len(b.rows) >= s.cfg.Flush.Bytes) {
+		return s.send(ctx, t)
+	}
+	return nil
+}
+
+// send appends the rows buffered for a table to its channel, along with the
+// requests which must be appended again since the channel was reopened.
+func (s *snowflakeSink) send(ctx context.Context, t *snowflakeTable) error {
+	ch := t.rows
+	if ch.batch.messages > 0 {
+		b := ch.batch
+		ch.batch = snowflakeBatch{}
+		ch.uncommitted = append(ch.uncommitted, &b)
+	}
+	if ch.sent == len(ch.uncommitted) {
+		return nil
+	}
+
+	attempt := 0
+	return s.retryCfg.do(ctx, func() error {
+		if attempt++; attempt > 1 {
+			s.metrics.recordInternalRetry(int64(len(ch.uncommitted)-ch.sent), false)
+		}
+		if ch.continuation == "" {
+			if err := s.openRowsChannel(ctx, t); err != nil {
+				return err
+			}
+		}
+		for ch.sent < len(ch.uncommitted) {
+			b := ch.uncommitted[ch.sent]
+			if b.token == 0 {
+				s.lastToken++
+				b.token = s.lastToken
+			}
+			next, err := s.client.appendRows(ctx, ch.pipe, ch.name, ch.continuation,
+				strconv.FormatInt(b.token, 10), b.rows)
+			if err != nil {
+				// The rows may have been appended, so the channel is reopened to
+				// find out which requests were committed.
+				ch.continuation = ""
+				return errors.Wrapf(err, "appending rows to channel %s of snowflake pipe %s", ch.name, ch.pipe)
+			}
+			ch.continuation = next
+			ch.sent++
+		}
+		return nil
+	})
+}
+
+// waitForCommit polls the status of a channel until Snowflake committed the
+// offset token accepted by the committed function.
+func (s *snowflakeSink) waitForCommit(
+	ctx context.Context, ch *snowflakeChannel, committed func(token string) bool,
+) error {
+	timeout := time.Duration(s.cfg.CommitTimeout)
+	start := timeutil.Now()
+	opts := retry.Options{InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	for r := retry.StartWithCtx(ctx, opts); r.Next(); {
+		token, err := s.client.committedOffsetToken(ctx, ch.pipe, ch.name)
+		if err != nil {
+			return errors.Wrapf(err, "getting status of channel %s of snowflake pipe %s", ch.name, ch.pipe)
+		}
+		if committed(token) {
+			return nil
+		}
+		if timeutil.Since(start) > timeout {
+			return errors.Errorf("timed out after %s waiting for snowflake to commit the rows of channel %s of pipe %s",
+				timeout, ch.name, ch.pipe)
+		}
+	}
+	return ctx.Err()
+}
+
+// EmitResolvedTimestamp implements the Sink interface. The resolved timestamp
+// is appended to the resolved channel of each table with the resolved
+// timestamp as its offset token, and the sink waits for Snowflake to commit
+// it.
+func (s *snowflakeSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	return s.topicNamer.Each(func(name string) error {
+		payload, err := encoder.EncodeResolvedTimestamp(ctx, name, resolved)
+		if err != nil {
+			return errors.Wrap(err, "encoding resolved timestamp")
+		}
+		row, err := json.Marshal(snowflakeRecord{
+			Metadata: snowflakeRecordMetadata{Topic: name, Resolved: resolved.AsOfSystemTime()},
+			Content:  payload,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "encoding resolved timestamp for snowflake table %s", name)
+		}
+		row = append(row, '\n')
+
+		ch := s.table(name).resolved
+		isCommitted := func(token string) bool {
+			ts, err := hlc.ParseHLC(token)
+			return err == nil && resolved.LessEq(ts)
+		}
+		done := false
+		if err := s.retryCfg.do(ctx, func() error {
+			if ch.continuation == "" {
+				continuation, committed, err := s.client.openChannel(ctx, ch.pipe, ch.name)
+				if err != nil {
+					return errors.Wrapf(err, "opening channel %s of snowflake pipe %s", ch.name, ch.pipe)
+				}
+				if done = isCommitted(committed); done {
+					return nil
+				}
+				ch.continuation = continuation
+			}
+			next, err := s.client.appendRows(ctx, ch.pipe, ch.name, ch.continuation,
+				resolved.AsOfSystemTime(), row)
+			if err != nil {
+				ch.continuation = ""
+				return errors.Wrapf(err, "appending resolved timestamp to channel %s of snowflake pipe %s",
+					ch.name, ch.pipe)
+			}
+			ch.continuation = next
+			return nil
+		}); err != nil || done {
+			return err
+		}
+		return s.waitForCommit(ctx, ch, isCommitted)
+	})
+}
+
+// Flush implements the Sink interface. The sink appends the buffered rows,
+// and waits for Snowflake to commit all the rows it appended.
+func (s *snowflakeSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	for _, t := range s.tables {
+		if err := s.send(ctx, t); err != nil {
+			return err
+		}
+	}
+	for _, t := range s.tables {
+		ch := t.rows
+		if len(ch.uncommitted) == 0 {
+			continue
+		}
+		if err := s.waitForCommit(ctx, ch, func(token string) bool {
+			if committed, err := strconv.ParseInt(token, 10, 64); err == nil {
+				s.releaseCommitted(ctx, ch, committed)
+			}
+			return len(ch.uncommitted) == 0
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *snowflakeSink) Close() error {
+	for _, t := range s.tables {
+		t.rows.batch.alloc.Release(context.Background())
+		for _, b := range t.rows.uncommitted {
+			b.alloc.Release(context.Background())
+		}
+		t.rows.uncommitted = nil
+	}
+	s.client.client.CloseIdleConnections()
+	return nil
+}
+
+// Topics gives the names of all tables that have been initialized
+// and will receive rows.
+func (s *snowflakeSink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
@@ -0,0 +1,506 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSnowflakeChannel is a channel of a fakeSnowflake pipe.
+type fakeSnowflakeChannel struct {
+	continuation int
+	committed    string
+	// pending are the rows appended to the channel which are not committed
+	// yet, and pendingToken their offset token.
+	pending      []string
+	pendingToken string
+}
+
+// fakeSnowflake implements the parts of the Snowpipe Streaming REST API used
+// by the snowflake sink, for the pipes of a schema. It commits the appended
+// rows right away, unless holdCommits is set.
+type fakeSnowflake struct {
+	t   *testing.T
+	key *rsa.PublicKey
+	mu  struct {
+		syncutil.Mutex
+		// rows are the rows committed to the pipes.
+		rows     map[string][]string
+		channels map[string]*fakeSnowflakeChannel
+		tokens   map[string]bool
+		auths    int
+		// failAppends is the number of requests appending rows which fail
+		// without appending them, and loseAppends the number of requests
+		// which append their rows but fail.
+		failAppends, loseAppends int
+		holdCommits              bool
+	}
+}
+
+func newFakeSnowflake(t *testing.T, key *rsa.PublicKey) *fakeSnowflake {
+	f := &fakeSnowflake{t: t, key: key}
+	f.mu.rows = make(map[string][]string)
+	f.mu.channels = make(map[string]*fakeSnowflakeChannel)
+	f.mu.tokens = make(map[string]bool)
+	return f
+}
+
+// verifyJWT checks that a JSON web token was signed by the key of the
+// TESTUSER user.
+func (f *fakeSnowflake) verifyJWT(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], sig); err != nil {
+		return err
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var c struct {
+		Issuer  string `json:"iss"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(claims, &c); err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(f.key)
+	if err != nil {
+		return err
+	}
+	fingerprint := sha256.Sum256(pub)
+	if c.Subject != "127.TESTUSER" ||
+		c.Issuer != "127.TESTUSER.SHA256:"+base64.StdEncoding.EncodeToString(fingerprint[:]) {
+		return fmt.Errorf("unexpected claims %s", claims)
+	}
+	return nil
+}
+
+func (f *fakeSnowflake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && path == "/v2/streaming/hostname":
+		if err := f.verifyJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(r.Host))
+		return
+	case r.Method == http.MethodPost && path == "/oauth/token":
+		form, err := url.ParseQuery(string(body))
+		require.NoError(f.t, err)
+		if err := f.verifyJWT(form.Get("assertion")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		f.mu.auths++
+		token := fmt.Sprintf("scoped-%d", f.mu.auths)
+		f.mu.tokens[token] = true
+		_, _ = w.Write([]byte(token))
+		return
+	}
+
+	if !f.mu.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	const pipesPrefix = "/v2/streaming/databases/DB/schemas/PUBLIC/pipes/"
+	const dataPrefix = "/v2/streaming/data/databases/DB/schemas/PUBLIC/pipes/"
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(path, pipesPrefix):
+		parts := strings.Split(strings.TrimPrefix(path, pipesPrefix), "/")
+		require.Len(f.t, parts, 3)
+		ch := f.channel(parts[0], parts[2])
+		ch.continuation++
+		ch.pending, ch.pendingToken = nil, ""
+		f.writeJSON(w, map[string]interface{}{
+			"next_continuation_token": fmt.Sprint(ch.continuation),
+			"channel_status":          map[string]string{"last_committed_offset_token": ch.committed},
+		})
+	case r.Method == http.MethodPost && strings.HasPrefix(path, dataPrefix):
+		parts := strings.Split(strings.TrimPrefix(path, dataPrefix), "/")
+		require.Len(f.t, parts, 4)
+		require.Equal(f.t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		ch := f.channel(parts[0], parts[2])
+		if r.URL.Query().Get("continuationToken") != fmt.Sprint(ch.continuation) {
+			http.Error(w, "invalid continuation token", http.StatusBadRequest)
+			return
+		}
+		if f.mu.failAppends > 0 {
+			f.mu.failAppends--
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		ch.pending = append(ch.pending, strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")...)
+		ch.pendingToken = r.URL.Query().Get("offsetToken")
+		if !f.mu.holdCommits {
+			f.commitLocked(parts[0], ch)
+		}
+		if f.mu.loseAppends > 0 {
+			f.mu.loseAppends--
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		ch.continuation++
+		f.writeJSON(w, map[string]string{"next_continuation_token": fmt.Sprint(ch.continuation)})
+	case r.Method == http.MethodPost && strings.HasSuffix(path, ":bulk-channel-status"):
+		pipe := strings.TrimSuffix(strings.TrimPrefix(path, pipesPrefix), ":bulk-channel-status")
+		var req struct {
+			ChannelNames []string `json:"channel_names"`
+		}
+		require.NoError(f.t, json.Unmarshal(body, &req))
+		statuses := make(map[string]interface{})
+		for _, name := range req.ChannelNames {
+			if ch, ok := f.mu.channels[pipe+"/"+name]; ok {
+				statuses[name] = map[string]string{"last_committed_offset_token": ch.committed}
+			}
+		}
+		f.writeJSON(w, map[string]interface{}{"channel_statuses": statuses})
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (f *fakeSnowflake) writeJSON(w http.ResponseWriter, v interface{}) {
+	resp, err := json.Marshal(v)
+	require.NoError(f.t, err)
+	_, _ = w.Write(resp)
+}
+
+func (f *fakeSnowflake) channel(pipe, name string) *fakeSnowflakeChannel {
+	ch, ok := f.mu.channels[pipe+"/"+name]
+	if !ok {
+		ch = &fakeSnowflakeChannel{}
+		f.mu.channels[pipe+"/"+name] = ch
+	}
+	return ch
+}
+
+func (f *fakeSnowflake) commitLocked(pipe string, ch *fakeSnowflakeChannel) {
+	f.mu.rows[pipe] = append(f.mu.rows[pipe], ch.pending...)
+	if ch.pendingToken != "" {
+		ch.committed = ch.pendingToken
+	}
+	ch.pending, ch.pendingToken = nil, ""
+}
+
+func (f *fakeSnowflake) setHoldCommits(hold bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.holdCommits = hold
+	if !hold {
+		for key, ch := range f.mu.channels {
+			f.commitLocked(strings.Split(key, "/")[0], ch)
+		}
+	}
+}
+
+func (f *fakeSnowflake) setFailures(failAppends, loseAppends int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.failAppends, f.mu.loseAppends = failAppends, loseAppends
+}
+
+// expireTokens expires the scoped tokens, and returns the number of times
+// the sink authenticated.
+func (f *fakeSnowflake) expireTokens() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.tokens = make(map[string]bool)
+	return f.mu.auths
+}
+
+func (f *fakeSnowflake) committedToken(pipe, channel string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.channel(pipe, channel).committed
+}
+
+// rows returns the rows committed to a pipe, as the content of the rows, or
+// "resolved:" followed by the resolved timestamp for the resolved timestamps.
+func (f *fakeSnowflake) rows(pipe string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rows []string
+	for _, line := range f.mu.rows[pipe] {
+		var record struct {
+			Metadata map[string]json.RawMessage `json:"RECORD_METADATA"`
+			Content  json.RawMessage            `json:"RECORD_CONTENT"`
+		}
+		require.NoError(f.t, json.Unmarshal([]byte(line), &record))
+		if resolved, ok := record.Metadata["resolved"]; ok {
+			rows = append(rows, "resolved:"+strings.Trim(string(resolved), `"`))
+		} else {
+			rows = append(rows, string(record.Content))
+		}
+	}
+	return rows
+}
+
+func makeTestSnowflakeSink(
+	t *testing.T, uri string, opts map[string]string, srcID base.SQLInstanceID, targetNames ...string,
+) (*snowflakeSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	statementOpts := changefeedbase.MakeStatementOptions(opts)
+	encodingOpts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	snowflakeOpts, err := statementOpts.GetSnowflakeSinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeSnowflakeSink(context.Background(), sinkURL{URL: u}, encodingOpts,
+		snowflakeOpts, makeChangefeedTargets(targetNames...),
+		jobspb.JobID(123), srcID, nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*snowflakeSink), nil
+}
+
+func makeSnowflakeTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return key, url.QueryEscape(base64.StdEncoding.EncodeToString(keyPEM))
+}
+
+func TestSnowflakeSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	_, key := makeSnowflakeTestKey(t)
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		opts   map[string]string
+		topics []string
+		err    string
+	}{
+		{
+			name:   "defaults",
+			uri:    `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?user=u&private_key=` + key,
+			topics: []string{"T"},
+		},
+		{
+			name:   "topic prefix",
+			uri:    `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?user=u&topic_prefix=crdb.&private_key=` + key,
+			topics: []string{"CRDB_T"},
+		},
+		{
+			name: "missing schema",
+			uri:  `snowflake://acct.snowflakecomputing.com/DB?user=u&private_key=` + key,
+			err:  `snowflake sink URI must be snowflake://<account>.snowflakecomputing.com/<database>/<schema>`,
+		},
+		{
+			name: "missing user",
+			uri:  `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?private_key=` + key,
+			err:  `snowflake sink requires the user parameter`,
+		},
+		{
+			name: "missing key",
+			uri:  `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?user=u`,
+			err:  `snowflake sink requires the private_key parameter`,
+		},
+		{
+			name: "invalid key",
+			uri:  `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?user=u&private_key=Zm9v`,
+			err:  `private_key must be a PEM encoded RSA key`,
+		},
+		{
+			name: "unknown param",
+			uri:  `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?user=u&foo=bar&private_key=` + key,
+			err:  `unknown snowflake sink query parameters: foo`,
+		},
+		{
+			name: "invalid config",
+			uri:  `snowflake://acct.snowflakecomputing.com/DB/PUBLIC?user=u&private_key=` + key,
+			opts: map[string]string{changefeedbase.OptSnowflakeSinkConfig: `{"CommitTimeout":"0s"}`},
+			err:  `CommitTimeout must be positive`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := makeTestSnowflakeSink(t, tc.uri, tc.opts, 1, "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+		})
+	}
+}
+
+func TestSnowflakeSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	key, keyParam := makeSnowflakeTestKey(t)
+	fake := newFakeSnowflake(t, &key.PublicKey)
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	uri := fmt.Sprintf(`snowflake://%s/DB/PUBLIC?user=testuser&private_key=%s&ca_cert=%s`,
+		server.Listener.Addr(), keyParam, url.QueryEscape(base64.StdEncoding.EncodeToString(caCert)))
+	fastRetries := map[string]string{
+		changefeedbase.OptSnowflakeSinkConfig: `{"Retry":{"Backoff":"1ms"}}`,
+	}
+
+	var pool testAllocPool
+	emit := func(s *snowflakeSink, topicName string, updated int64, value string) error {
+		return s.EmitRow(ctx, topic(topicName), []byte(`[1]`), []byte(value),
+			hlc.Timestamp{WallTime: updated}, zeroTS, pool.alloc())
+	}
+	opts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}).GetEncodingOptions()
+	require.NoError(t, err)
+	enc, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+	require.NoError(t, err)
+
+	t.Run("emit", func(t *testing.T) {
+		s, err := makeTestSnowflakeSink(t, uri, nil, 1, "t1", "t2")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, emit(s, "t1", 1, `{"a":1}`))
+		require.NoError(t, emit(s, "t2", 1, `{"b":1}`))
+		require.NoError(t, emit(s, "t1", 2, `{"a":2}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{`{"a":1}`, `{"a":2}`}, fake.rows("T1-STREAMING"))
+		require.Equal(t, []string{`{"b":1}`}, fake.rows("T2-STREAMING"))
+		require.NotEmpty(t, fake.committedToken("T1-STREAMING", "CRDB_123_1"))
+		require.EqualValues(t, 0, pool.used())
+
+		// The sink authenticates again when its scoped token expires.
+		auths := fake.expireTokens()
+		require.NoError(t, emit(s, "t1", 3, `{"a":3}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, auths+1, fake.expireTokens())
+		require.Equal(t, []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}, fake.rows("T1-STREAMING"))
+	})
+
+	t.Run("resolved timestamps", func(t *testing.T) {
+		s, err := makeTestSnowflakeSink(t, uri, nil, 0, "t1", "t2")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 3}))
+		require.Equal(t, []string{`{"b":1}`, "resolved:3.0000000000"}, fake.rows("T2-STREAMING"))
+		require.Equal(t, "3.0000000000", fake.committedToken("T1-STREAMING", "CRDB_123_RESOLVED"))
+		// A resolved timestamp which was already committed is not appended
+		// again.
+		s.tables["T1"].resolved.continuation = ""
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 3}))
+		require.Equal(t, []string{`{"a":1}`, `{"a":2}`, `{"a":3}`, "resolved:3.0000000000"},
+			fake.rows("T1-STREAMING"))
+	})
+
+	t.Run("skips committed rows after restart", func(t *testing.T) {
+		s, err := makeTestSnowflakeSink(t, uri, nil, 2, "t1")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		// The rows updated at or before the last committed resolved timestamp
+		// were committed before the changefeed restarted.
+		require.NoError(t, emit(s, "t1", 2, `{"a":2}`))
+		require.NoError(t, emit(s, "t1", 3, `{"a":3}`))
+		require.NoError(t, emit(s, "t1", 4, `{"a":4}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{`{"a":1}`, `{"a":2}`, `{"a":3}`, "resolved:3.0000000000", `{"a":4}`},
+			fake.rows("T1-STREAMING"))
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("failed appends are retried", func(t *testing.T) {
+		s, err := makeTestSnowflakeSink(t, uri, fastRetries, 3, "t3")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+
+		fake.setFailures(1, 0)
+		require.NoError(t, emit(s, "t3", 5, `{"c":1}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{`{"c":1}`}, fake.rows("T3-STREAMING"))
+
+		// The rows whose response was lost were committed, and are not
+		// appended again when the channel is reopened.
+		fake.setFailures(0, 1)
+		require.NoError(t, emit(s, "t3", 6, `{"c":2}`))
+		require.NoError(t, s.Flush(ctx))
+		require.NoError(t, emit(s, "t3", 7, `{"c":3}`))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{`{"c":1}`, `{"c":2}`, `{"c":3}`}, fake.rows("T3-STREAMING"))
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run("commit timeout", func(t *testing.T) {
+		fake.setHoldCommits(true)
+		defer fake.setHoldCommits(false)
+		s, err := makeTestSnowflakeSink(t, uri,
+			map[string]string{changefeedbase.OptSnowflakeSinkConfig: `{"CommitTimeout":"10ms"}`}, 4, "t4")
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		require.NoError(t, emit(s, "t4", 8, `{"d":1}`))
+		err = s.Flush(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(),
+			`timed out after 10ms waiting for snowflake to commit the rows of channel CRDB_123_4 of pipe T4-STREAMING`)
+		require.NoError(t, s.Close())
+		require.EqualValues(t, 0, pool.used())
+		require.Empty(t, fake.rows("T4-STREAMING"))
+	})
+}
@@ -0,0 +1,484 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// The limits of the SendMessageBatch API of SQS.
+const (
+	sqsMaxMessagesPerBatch    = 10
+	sqsMaxBytesPerBatch       = 256 << 10
+	sqsMaxMessageGroupIDBytes = 128
+)
+
+// sqsFIFOSuffix is the suffix of the names of the FIFO queues.
+const sqsFIFOSuffix = `.fifo`
+
+// The message groups of the FIFO queues for the messages which do not have a
+// key, like the rows encoded in CSV, and for the resolved timestamps.
+const (
+	sqsDefaultMessageGroupID  = `default`
+	sqsResolvedMessageGroupID = `resolved`
+)
+
+type sqsFlushConfig struct {
+	Messages, Bytes int `json:",omitempty"`
+}
+
+// proper JSON schema for sqs sink config:
+//
+//	{
+//	  "Flush": {
+//	    "Messages": ...,
+//	    "Bytes":    ...,
+//	  },
+//	  "Retry": {
+//	    "Max":     ...,
+//	    "Backoff": ...,
+//	  }
+//	}
+//
+// The rows are buffered until a SendMessageBatch request is full, the Flush
+// thresholds are reached or the changefeed flushes the sink.
+type sqsSinkConfig struct {
+	Flush sqsFlushConfig `json:",omitempty"`
+	Retry retryConfig    `json:",omitempty"`
+}
+
+func getSQSSinkConfig(
+	jsonStr changefeedbase.SinkSpecificJSONConfig,
+) (cfg sqsSinkConfig, retryCfg retry.Options, err error) {
+	retryCfg = defaultRetryConfig()
+
+	cfg.Retry.Max = jsonMaxRetries(retryCfg.MaxRetries)
+	cfg.Retry.Backoff = jsonDuration(retryCfg.InitialBackoff)
+	if jsonStr != `` {
+		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+			return cfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+		}
+	}
+
+	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
+		return cfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative",
+			changefeedbase.OptSQSSinkConfig)
+	}
+
+	retryCfg.MaxRetries = int(cfg.Retry.Max)
+	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
+	return cfg, retryCfg, nil
+}
+
+// sqsClient is the part of the SQS API used by the sqs sink.
+type sqsClient interface {
+	GetQueueUrlWithContext(
+		ctx aws.Context, input *sqs.GetQueueUrlInput, opts ...request.Option,
+	) (*sqs.GetQueueUrlOutput, error)
+	SendMessageBatchWithContext(
+		ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option,
+	) (*sqs.SendMessageBatchOutput, error)
+}
+
+var _ sqsClient = (*sqs.SQS)(nil)
+
+// newSQSClient creates a client of the SQS API.
+func newSQSClient(conf awsClientConfig) (sqsClient, error) {
+	sess, err := newAWSSession(conf)
+	if err != nil {
+		return nil, err
+	}
+	return sqs.New(sess), nil
+}
+
+// sqsQueueName returns a valid SQS queue name for a topic: the queue names
+// only have alphanumeric characters, hyphens and underscores, followed by the
+// .fifo suffix for the FIFO queues.
+func sqsQueueName(name string, fifo bool) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSuffix(name, sqsFIFOSuffix))
+	if fifo {
+		return name + sqsFIFOSuffix
+	}
+	return name
+}
+
+// sqsMessageGroupID returns the message group of a row in a FIFO queue, so
+// that the rows with the same key are delivered in order. The message groups
+// are at most 128 printable ASCII characters, so the other keys are hashed.
+func sqsMessageGroupID(key []byte) string {
+	if len(key) == 0 {
+		return sqsDefaultMessageGroupID
+	}
+	valid := len(key) <= sqsMaxMessageGroupIDBytes
+	for i := 0; valid && i < len(key); i++ {
+		valid = key[i] > ' ' && key[i] <= '~'
+	}
+	if valid {
+		return string(key)
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// sqsMessageDeduplicationID returns the deduplication id of a message in a
+// FIFO queue, which identifies the version of a row, so that the messages
+// sent again by a retry are delivered once.
+func sqsMessageDeduplicationID(queue string, key, value []byte, updated hlc.Timestamp) string {
+	h := sha256.New()
+	h.Write([]byte(queue + "\n" + updated.AsOfSystemTime() + "\n"))
+	h.Write(key)
+	h.Write([]byte{'\n'})
+	h.Write(value)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sqsBatch is a batch of rows buffered for a queue, which are sent in a
+// single SendMessageBatch request.
+//
+// The failed messages of a request are retried on their own, so a batch for a
+// FIFO queue holds at most one message per message group. Since the requests
+// are sent one after the other, the rows with the same key are delivered in
+// order.
+type sqsBatch struct {
+	entries []*sqs.SendMessageBatchRequestEntry
+	groups  map[string]struct{}
+	// bytes is the size of the messages of the batch.
+	bytes int
+
+	numMessages int
+	alloc       kvevent.Alloc
+	emitTime    time.Time
+	mvcc        hlc.Timestamp
+}
+
+func newSQSBatch() *sqsBatch {
+	return &sqsBatch{groups: make(map[string]struct{})}
+}
+
+// add adds a message to the batch. It returns false if the message cannot be
+// added without exceeding the limits of a request or reordering the messages
+// of its group, in which case the batch needs to be sent first.
+func (b *sqsBatch) add(entry *sqs.SendMessageBatchRequestEntry) bool {
+	size := len(aws.StringValue(entry.MessageBody))
+	group := aws.StringValue(entry.MessageGroupId)
+	if _, ok := b.groups[group]; (ok && group != "") ||
+		len(b.entries) >= sqsMaxMessagesPerBatch || b.bytes+size > sqsMaxBytesPerBatch {
+		return false
+	}
+	// The ids identify the messages of a request in its response.
+	entry.Id = aws.String(strconv.Itoa(len(b.entries)))
+	b.entries = append(b.entries, entry)
+	b.groups[group] = struct{}{}
+	b.bytes += size
+	return true
+}
+
+// noteMessage accounts for a row added to the batch.
+func (b *sqsBatch) noteMessage(alloc kvevent.Alloc, mvcc hlc.Timestamp) {
+	if b.numMessages == 0 {
+		b.emitTime = timeutil.Now()
+	}
+	b.numMessages++
+	b.alloc.Merge(&alloc)
+	if b.mvcc.IsEmpty() || mvcc.Less(b.mvcc) {
+		b.mvcc = mvcc
+	}
+}
+
+func (b *sqsBatch) reset() {
+	*b = sqsBatch{groups: make(map[string]struct{})}
+}
+
+type sqsSinkKnobs struct {
+	OverrideClient func() (sqsClient, error)
+}
+
+// sqsSink emits to Amazon SQS queues. The rows of each table are emitted to
+// the queue named after the table, unless the sink URI names a single queue
+// for all the tables. The rows emitted to FIFO queues are grouped by key, so
+// that the rows with the same key are delivered in order.
+type sqsSink struct {
+	clientCfg  awsClientConfig
+	client     sqsClient
+	topicNamer *TopicNamer
+	fifo       bool
+	cfg        sqsSinkConfig
+	retryCfg   sinkRetryPolicy
+	metrics    metricsRecorder
+	knobs      sqsSinkKnobs
+
+	// queueURLs are the URLs of the queues, by name.
+	queueURLs map[string]string
+	// batches are the rows buffered for each queue, which are yet to be sent.
+	batches map[string]*sqsBatch
+}
+
+var _ Sink = (*sqsSink)(nil)
+
+func makeSQSSink(
+	u sinkURL,
+	encodingOpts changefeedbase.EncodingOptions,
+	opts changefeedbase.SQSSinkOptions,
+	targets changefeedbase.Targets,
+	mb metricsRecorderBuilder,
+) (Sink, error) {
+	// The messages of SQS are text.
+	switch encodingOpts.Format {
+	case changefeedbase.OptFormatJSON, changefeedbase.OptFormatCSV:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptFormat, encodingOpts.Format)
+	}
+
+	switch encodingOpts.Envelope {
+	case changefeedbase.OptEnvelopeWrapped:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptEnvelope, encodingOpts.Envelope)
+	}
+
+	// The queue named in the sink URI is a FIFO queue if it has the .fifo
+	// suffix, and the queues named after the tables are FIFO queues if the fifo
+	// parameter is set.
+	fifo := strings.HasSuffix(u.Host, sqsFIFOSuffix)
+	var fifoParam bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamFIFO, &fifoParam); err != nil {
+		return nil, err
+	}
+	fifo = fifo || fifoParam
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	topicNamer, err := MakeTopicNamer(targets,
+		WithPrefix(topicPrefix), WithSingleName(u.Host),
+		WithSanitizeFn(func(name string) string { return sqsQueueName(name, fifo) }))
+	if err != nil {
+		return nil, err
+	}
+
+	clientCfg, err := makeAWSClientConfig(&u)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown sqs sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	s := &sqsSink{
+		clientCfg:  clientCfg,
+		topicNamer: topicNamer,
+		fifo:       fifo,
+		metrics:    mb(requiresResourceAccounting),
+		queueURLs:  make(map[string]string),
+		batches:    make(map[string]*sqsBatch),
+	}
+	var retryCfg retry.Options
+	s.cfg, retryCfg, err = getSQSSinkConfig(opts.JSONConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptSQSSinkConfig)
+	}
+	s.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	return s, nil
+}
+
+// Dial implements the Sink interface. It looks up the URLs of the queues, so
+// that the changefeed fails to start if they do not exist.
+func (s *sqsSink) Dial() error {
+	var err error
+	if s.knobs.OverrideClient != nil {
+		s.client, err = s.knobs.OverrideClient()
+	} else {
+		s.client, err = newSQSClient(s.clientCfg)
+	}
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return s.topicNamer.Each(func(queue string) error {
+		out, err := s.client.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+			QueueName: aws.String(queue),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "getting url of sqs queue %s", queue)
+		}
+		s.queueURLs[queue] = aws.StringValue(out.QueueUrl)
+		return nil
+	})
+}
+
+// entry returns the message of a payload sent to a queue.
+func (s *sqsSink) entry(groupID string, payload []byte, dedupID string) *sqs.SendMessageBatchRequestEntry {
+	entry := &sqs.SendMessageBatchRequestEntry{MessageBody: aws.String(string(payload))}
+	if s.fifo {
+		entry.MessageGroupId = aws.String(groupID)
+		entry.MessageDeduplicationId = aws.String(dedupID)
+	}
+	return entry
+}
+
+// EmitRow implements the Sink interface.
+func (s *sqsSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	queue, err := s.topicNamer.Name(topic)
+	if err != nil {
+		return err
+	}
+	b, ok := s.batches[queue]
+	if !ok {
+		b = newSQSBatch()
+		s.batches[queue] = b
+	}
+
+	entry := s.entry(sqsMessageGroupID(key), value,
+		sqsMessageDeduplicationID(queue, key, value, updated))
+	if !b.add(entry) {
+		if err := s.send(ctx, queue, b); err != nil {
+			return err
+		}
+		if !b.add(entry) {
+			return errors.Errorf("message of %d bytes exceeds the maximum size of sqs messages of %d bytes",
+				len(value), sqsMaxBytesPerBatch)
+		}
+	}
+	b.noteMessage(alloc, mvcc)
+	s.metrics.recordMessageSize(int64(len(key) + len(value)))
+
+	if (s.cfg.Flush.Messages > 0 && b.numMessages >= s.cfg.Flush.Messages) ||
+		(s.cfg.Flush.Bytes > 0 && b.bytes >= s.cfg.Flush.Bytes) {
+		return s.send(ctx, queue, b)
+	}
+	return nil
+}
+
+// send sends the rows of a batch, and resets the batch.
+func (s *sqsSink) send(ctx context.Context, queue string, b *sqsBatch) error {
+	if b.numMessages == 0 {
+		return nil
+	}
+	if err := s.sendMessages(ctx, queue, b.entries); err != nil {
+		return err
+	}
+	s.metrics.recordEmittedBatch(b.emitTime, b.numMessages, b.mvcc, b.bytes, sinkDoesNotCompress)
+	b.alloc.Release(ctx)
+	b.reset()
+	return nil
+}
+
+// sendMessages sends messages to a queue, retrying the messages which failed.
+// The messages which failed can be retried on their own since a request has
+// at most one message per message group.
+func (s *sqsSink) sendMessages(
+	ctx context.Context, queue string, entries []*sqs.SendMessageBatchRequestEntry,
+) error {
+	queueURL, ok := s.queueURLs[queue]
+	if !ok {
+		return errors.AssertionFailedf("unknown sqs queue %s", queue)
+	}
+	return s.retryCfg.do(ctx, func() error {
+		out, err := s.client.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "sending messages to sqs queue %s", queue)
+		}
+		if len(out.Failed) == 0 {
+			return nil
+		}
+		failedIDs := make(map[string]struct{}, len(out.Failed))
+		for _, f := range out.Failed {
+			failedIDs[aws.StringValue(f.Id)] = struct{}{}
+		}
+		var failed []*sqs.SendMessageBatchRequestEntry
+		for _, e := range entries {
+			if _, ok := failedIDs[aws.StringValue(e.Id)]; ok {
+				failed = append(failed, e)
+			}
+		}
+		entries = failed
+		return errors.Wrapf(
+			errors.Newf("%s: %s", aws.StringValue(out.Failed[0].Code), aws.StringValue(out.Failed[0].Message)),
+			"sending %d messages to sqs queue %s", len(failed), queue)
+	})
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *sqsSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return errors.Wrap(err, "encoding resolved timestamp")
+	}
+	return s.topicNamer.Each(func(queue string) error {
+		entry := s.entry(sqsResolvedMessageGroupID, payload,
+			sqsMessageDeduplicationID(queue, nil, payload, resolved))
+		entry.Id = aws.String("0")
+		if err := s.sendMessages(ctx, queue, []*sqs.SendMessageBatchRequestEntry{entry}); err != nil {
+			return errors.Wrap(err, "emitting resolved timestamp")
+		}
+		return nil
+	})
+}
+
+// Flush implements the Sink interface.
+func (s *sqsSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	for queue, b := range s.batches {
+		if err := s.send(ctx, queue, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *sqsSink) Close() error {
+	for _, b := range s.batches {
+		b.alloc.Release(context.Background())
+	}
+	return nil
+}
+
+// Topics gives the names of all queues that have been initialized
+// and will receive resolved timestamps.
+func (s *sqsSink) Topics() []string {
+	return s.topicNamer.DisplayNamesSlice()
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn/connectionpb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/errors"
+)
+
+func parseAndValidateSQSSinkURI(
+	_ context.Context, _ interface{}, _ username.SQLUsername, uri *url.URL,
+) (externalconn.ExternalConnection, error) {
+	// Validate the sqs URI, including its credentials, by creating a sqs sink
+	// and throwing it away. The queues are only looked up when a changefeed
+	// dials the sink.
+	encodingOpts := changefeedbase.EncodingOptions{
+		Format:   changefeedbase.OptFormatJSON,
+		Envelope: changefeedbase.OptEnvelopeWrapped,
+	}
+	_, err := makeSQSSink(sinkURL{URL: uri}, encodingOpts, changefeedbase.SQSSinkOptions{},
+		changefeedbase.Targets{}, nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid SQS URI")
+	}
+
+	connDetails := connectionpb.ConnectionDetails{
+		Provider: connectionpb.ConnectionProvider_sqs,
+		Details: &connectionpb.ConnectionDetails_SimpleURI{
+			SimpleURI: &connectionpb.SimpleURI{
+				URI: uri.String(),
+			},
+		},
+	}
+	return externalconn.NewExternalConnection(connDetails), nil
+}
+
+func init() {
+	externalconn.RegisterConnectionDetailsFromURIFactory(changefeedbase.SinkSchemeSQS,
+		parseAndValidateSQSSinkURI)
+}
@@ -0,0 +1,413 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn/connectionpb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testSQSQueueURLPrefix = `https://sqs.us-east-1.amazonaws.com/123456789012/`
+
+// fakeSQSClient records the SendMessageBatch requests it receives.
+type fakeSQSClient struct {
+	syncutil.Mutex
+	// queues are the names of the existing queues.
+	queues   []string
+	requests []*sqs.SendMessageBatchInput
+	// failures is the number of times the messages with a body fail before
+	// they succeed.
+	failures map[string]int
+}
+
+var _ sqsClient = (*fakeSQSClient)(nil)
+
+func (c *fakeSQSClient) GetQueueUrlWithContext(
+	_ aws.Context, input *sqs.GetQueueUrlInput, _ ...request.Option,
+) (*sqs.GetQueueUrlOutput, error) {
+	for _, q := range c.queues {
+		if q == aws.StringValue(input.QueueName) {
+			return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(testSQSQueueURLPrefix + q)}, nil
+		}
+	}
+	return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist.", nil)
+}
+
+func (c *fakeSQSClient) SendMessageBatchWithContext(
+	_ aws.Context, input *sqs.SendMessageBatchInput, _ ...request.Option,
+) (*sqs.SendMessageBatchOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.requests = append(c.requests, &sqs.SendMessageBatchInput{
+		QueueUrl: input.QueueUrl,
+		Entries:  append([]*sqs.SendMessageBatchRequestEntry(nil), input.Entries...),
+	})
+	out := &sqs.SendMessageBatchOutput{}
+	for _, e := range input.Entries {
+		if body := aws.StringValue(e.MessageBody); c.failures[body] > 0 {
+			c.failures[body]--
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{
+				Id:          e.Id,
+				Code:        aws.String("InternalError"),
+				Message:     aws.String("internal error"),
+				SenderFault: aws.Bool(false),
+			})
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{
+			Id:        e.Id,
+			MessageId: aws.String(aws.StringValue(e.Id)),
+		})
+	}
+	return out, nil
+}
+
+// sentMessages returns the message groups and the bodies of the messages of
+// each request sent so far.
+func (c *fakeSQSClient) sentMessages() [][]string {
+	c.Lock()
+	defer c.Unlock()
+	var sent [][]string
+	for _, req := range c.requests {
+		var messages []string
+		for _, e := range req.Entries {
+			messages = append(messages, fmt.Sprintf("%s:%s",
+				aws.StringValue(e.MessageGroupId), aws.StringValue(e.MessageBody)))
+		}
+		sent = append(sent, messages)
+	}
+	return sent
+}
+
+const testSQSSinkURI = `sqs://?AWS_REGION=us-east-1&AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`
+
+func getGenericSQSSinkOptions(config string) changefeedbase.StatementOptions {
+	return changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptFormat:        string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope:      string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptSQSSinkConfig: config,
+	})
+}
+
+func makeTestSQSSink(
+	t *testing.T, uri string, opts changefeedbase.StatementOptions, targetNames ...string,
+) (*sqsSink, error) {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	encodingOpts, err := opts.GetEncodingOptions()
+	require.NoError(t, err)
+	sqsOpts, err := opts.GetSQSSinkOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := makeSQSSink(sinkURL{URL: u}, encodingOpts, sqsOpts,
+		makeChangefeedTargets(targetNames...), nilMetricsRecorderBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*sqsSink), nil
+}
+
+func dialTestSQSSink(
+	t *testing.T, uri, config string, client *fakeSQSClient, targetNames ...string,
+) *sqsSink {
+	// Speed up the tests by using faster backoff times.
+	if config == `` {
+		config = `{"Retry":{"Backoff":"5ms"}}`
+	}
+	s, err := makeTestSQSSink(t, uri, getGenericSQSSinkOptions(config), targetNames...)
+	require.NoError(t, err)
+	s.knobs.OverrideClient = func() (sqsClient, error) { return client, nil }
+	require.NoError(t, s.Dial())
+	return s
+}
+
+func TestSQSSinkOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		opts   map[string]string
+		topics []string
+		fifo   bool
+		err    string
+	}{
+		{
+			name:   "queue per table",
+			uri:    testSQSSinkURI + `&topic_prefix=prefix.`,
+			topics: []string{"prefix_t"},
+		},
+		{
+			name:   "fifo queue per table",
+			uri:    testSQSSinkURI + `&fifo=true`,
+			topics: []string{"t.fifo"},
+			fifo:   true,
+		},
+		{
+			name:   "single queue",
+			uri:    `sqs://queue?AWS_REGION=us-east-1&AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`,
+			topics: []string{"queue"},
+		},
+		{
+			name:   "single fifo queue",
+			uri:    `sqs://queue.fifo?AUTH=implicit`,
+			topics: []string{"queue.fifo"},
+			fifo:   true,
+		},
+		{
+			name: "invalid fifo",
+			uri:  testSQSSinkURI + `&fifo=maybe`,
+			err:  `param fifo must be a bool`,
+		},
+		{
+			name: "unknown param",
+			uri:  testSQSSinkURI + `&foo=bar`,
+			err:  `unknown sqs sink query parameters: foo`,
+		},
+		{
+			name: "missing region",
+			uri:  `sqs://?AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`,
+			err:  `AWS_REGION is not set`,
+		},
+		{
+			name: "negative flush",
+			uri:  testSQSSinkURI,
+			opts: map[string]string{changefeedbase.OptSQSSinkConfig: `{"Flush":{"Bytes":-1}}`},
+			err:  `all config values must be non-negative`,
+		},
+		{
+			name: "avro",
+			uri:  testSQSSinkURI,
+			opts: map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatAvro)},
+			err:  `this sink is incompatible with format=avro`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := map[string]string{
+				changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+				changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+			}
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+			s, err := makeTestSQSSink(t, tc.uri, changefeedbase.MakeStatementOptions(opts), "t")
+			if tc.err != `` {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.topics, s.Topics())
+			require.Equal(t, tc.fifo, s.fifo)
+		})
+	}
+}
+
+func TestSQSSinkDial(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	client := &fakeSQSClient{queues: []string{"t1"}}
+	s, err := makeTestSQSSink(t, testSQSSinkURI, getGenericSQSSinkOptions(``), "t1", "t2")
+	require.NoError(t, err)
+	s.knobs.OverrideClient = func() (sqsClient, error) { return client, nil }
+	err = s.Dial()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `getting url of sqs queue t2`)
+	require.Contains(t, err.Error(), sqs.ErrCodeQueueDoesNotExist)
+
+	client.queues = append(client.queues, "t2")
+	require.NoError(t, s.Dial())
+	require.Equal(t, map[string]string{
+		"t1": testSQSQueueURLPrefix + "t1",
+		"t2": testSQSQueueURLPrefix + "t2",
+	}, s.queueURLs)
+	require.NoError(t, s.Close())
+}
+
+func TestSQSSinkBatching(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var pool testAllocPool
+	emit := func(s *sqsSink, key, value string) {
+		require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(key), []byte(value),
+			hlc.Timestamp{WallTime: 1}, zeroTS, pool.alloc()))
+	}
+
+	t.Run("standard queue", func(t *testing.T) {
+		client := &fakeSQSClient{queues: []string{"t"}}
+		s := dialTestSQSSink(t, testSQSSinkURI, ``, client, "t")
+		for i := 0; i < sqsMaxMessagesPerBatch; i++ {
+			emit(s, `[1]`, fmt.Sprint(i))
+		}
+		require.Empty(t, client.sentMessages())
+		// A request holds at most 10 messages.
+		emit(s, `[1]`, `10`)
+		require.Len(t, client.sentMessages(), 1)
+		require.Len(t, client.sentMessages()[0], sqsMaxMessagesPerBatch)
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, []string{`:10`}, client.sentMessages()[1])
+		require.Equal(t, testSQSQueueURLPrefix+"t", aws.StringValue(client.requests[0].QueueUrl))
+		require.Nil(t, client.requests[0].Entries[0].MessageDeduplicationId)
+		require.EqualValues(t, 0, pool.used())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("fifo queue", func(t *testing.T) {
+		client := &fakeSQSClient{queues: []string{"t.fifo"}}
+		s := dialTestSQSSink(t, testSQSSinkURI+`&fifo=true`, ``, client, "t")
+		emit(s, `[1]`, `a`)
+		emit(s, `[2]`, `b`)
+		require.Empty(t, client.sentMessages())
+		// The second row of [1] cannot be sent along with the first one, since
+		// the failed messages of a request are retried on their own.
+		emit(s, `[1]`, `c`)
+		require.Equal(t, [][]string{{`[1]:a`, `[2]:b`}}, client.sentMessages())
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, [][]string{{`[1]:a`, `[2]:b`}, {`[1]:c`}}, client.sentMessages())
+
+		// The deduplication ids identify the versions of the rows.
+		a := aws.StringValue(client.requests[0].Entries[0].MessageDeduplicationId)
+		require.Equal(t, sqsMessageDeduplicationID("t.fifo", []byte(`[1]`), []byte(`a`), hlc.Timestamp{WallTime: 1}), a)
+		require.NotEqual(t, a, sqsMessageDeduplicationID("t.fifo", []byte(`[1]`), []byte(`a`), hlc.Timestamp{WallTime: 2}))
+		require.EqualValues(t, 0, pool.used())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("flush messages", func(t *testing.T) {
+		client := &fakeSQSClient{queues: []string{"t"}}
+		s := dialTestSQSSink(t, testSQSSinkURI, `{"Flush":{"Messages":2}}`, client, "t")
+		emit(s, `[1]`, `a`)
+		emit(s, `[2]`, `b`)
+		require.Equal(t, [][]string{{`:a`, `:b`}}, client.sentMessages())
+		emit(s, `[3]`, `c`)
+		require.Len(t, client.sentMessages(), 1)
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, [][]string{{`:a`, `:b`}, {`:c`}}, client.sentMessages())
+		require.EqualValues(t, 0, pool.used())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("oversized message", func(t *testing.T) {
+		client := &fakeSQSClient{queues: []string{"t"}}
+		s := dialTestSQSSink(t, testSQSSinkURI, ``, client, "t")
+		err := s.EmitRow(ctx, topic("t"), []byte(`[1]`), make([]byte, sqsMaxBytesPerBatch+1),
+			zeroTS, zeroTS, zeroAlloc)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `exceeds the maximum size of sqs messages`)
+		require.NoError(t, s.Close())
+	})
+}
+
+func TestSQSMessageGroupID(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	require.Equal(t, sqsDefaultMessageGroupID, sqsMessageGroupID(nil))
+	require.Equal(t, `[1,"a"]`, sqsMessageGroupID([]byte(`[1,"a"]`)))
+	// The keys with spaces, non-ASCII characters or more than 128 characters
+	// are hashed.
+	for _, key := range []string{`[1, "a"]`, `["é"]`, `["` + strings.Repeat("a", 127) + `"]`} {
+		id := sqsMessageGroupID([]byte(key))
+		require.Len(t, id, 64)
+		require.Equal(t, id, sqsMessageGroupID([]byte(key)))
+	}
+}
+
+func TestSQSSinkRetriesFailedMessages(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var pool testAllocPool
+
+	client := &fakeSQSClient{queues: []string{"t"}, failures: map[string]int{`b`: 2}}
+	s := dialTestSQSSink(t, testSQSSinkURI, ``, client, "t")
+	for _, value := range []string{`a`, `b`, `c`} {
+		require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(`[1]`), []byte(value), zeroTS, zeroTS, pool.alloc()))
+	}
+	require.NoError(t, s.Flush(ctx))
+	// Only the messages which failed are retried.
+	require.Equal(t, [][]string{{`:a`, `:b`, `:c`}, {`:b`}, {`:b`}}, client.sentMessages())
+	require.EqualValues(t, 0, pool.used())
+
+	client.failures[`d`] = 100
+	require.NoError(t, s.EmitRow(ctx, topic("t"), []byte(`[1]`), []byte(`d`), zeroTS, zeroTS, pool.alloc()))
+	err := s.Flush(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `InternalError: internal error`)
+	require.NoError(t, s.Close())
+	require.EqualValues(t, 0, pool.used())
+}
+
+func TestSQSSinkEmitResolvedTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	client := &fakeSQSClient{queues: []string{"t1.fifo", "t2.fifo"}}
+	s := dialTestSQSSink(t, testSQSSinkURI+`&fifo=true`, ``, client, "t1", "t2")
+
+	opts, err := getGenericSQSSinkOptions(``).GetEncodingOptions()
+	require.NoError(t, err)
+	enc, err := makeJSONEncoder(opts, changefeedbase.Targets{})
+	require.NoError(t, err)
+	require.NoError(t, s.EmitResolvedTimestamp(ctx, enc, hlc.Timestamp{WallTime: 2}))
+
+	// The resolved timestamp is emitted to every queue.
+	require.Len(t, client.requests, 2)
+	var queueURLs []string
+	for i, req := range client.requests {
+		queueURLs = append(queueURLs, aws.StringValue(req.QueueUrl))
+		require.Equal(t, []string{`resolved:{"resolved":"2.0000000000"}`}, client.sentMessages()[i])
+		require.NotEmpty(t, aws.StringValue(req.Entries[0].MessageDeduplicationId))
+	}
+	require.ElementsMatch(t, []string{testSQSQueueURLPrefix + "t1.fifo", testSQSQueueURLPrefix + "t2.fifo"}, queueURLs)
+	require.NoError(t, s.Close())
+}
+
+func TestSQSSinkExternalConnection(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	uri := `sqs://queue.fifo?AWS_REGION=us-east-1&AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=secret`
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	ec, err := parseAndValidateSQSSinkURI(ctx, nil, username.RootUserName(), u)
+	require.NoError(t, err)
+	require.Equal(t, connectionpb.ConnectionProvider_sqs, ec.ConnectionProto().Provider)
+	require.Equal(t, connectionpb.TypeStorage, ec.ConnectionType())
+	require.Equal(t, uri, ec.ConnectionProto().UnredactedURI())
+
+	u, err = url.Parse(`sqs://queue?AWS_REGION=us-east-1`)
+	require.NoError(t, err)
+	_, err = parseAndValidateSQSSinkURI(ctx, nil, username.RootUserName(), u)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `invalid SQS URI`)
+}
@@ -10,6 +10,10 @@ package changefeedccl
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"sync"
@@ -23,8 +27,10 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
@@ -177,6 +183,41 @@ func topic(name string) *tableDescriptorTopic {
 	return &tableDescriptorTopic{Metadata: makeMetadata(tableDesc), spec: spec}
 }
 
+// makeTestTopicWithColumns returns the topic of a table with a single column
+// family, whose primary key is its first column.
+func makeTestTopicWithColumns(
+	t *testing.T, id descpb.ID, name string, version descpb.DescriptorVersion, cols ...descpb.ColumnDescriptor,
+) TopicDescriptor {
+	family := descpb.ColumnFamilyDescriptor{Name: "primary"}
+	for i := range cols {
+		cols[i].ID = descpb.ColumnID(i + 1)
+		family.ColumnIDs = append(family.ColumnIDs, cols[i].ID)
+		family.ColumnNames = append(family.ColumnNames, cols[i].Name)
+	}
+	desc := tabledesc.NewBuilder(&descpb.TableDescriptor{
+		Name:     name,
+		ID:       id,
+		Version:  version,
+		Columns:  cols,
+		Families: []descpb.ColumnFamilyDescriptor{family},
+		PrimaryIndex: descpb.IndexDescriptor{
+			Name:           name + "_pkey",
+			ID:             1,
+			KeyColumnIDs:   []descpb.ColumnID{cols[0].ID},
+			KeyColumnNames: []string{cols[0].Name},
+		},
+	}).BuildImmutableTable()
+	eventDesc, err := cdcevent.NewEventDescriptor(desc, &family, false, hlc.Timestamp{})
+	require.NoError(t, err)
+	topic, err := makeTopicDescriptorFromSpec(changefeedbase.Target{
+		Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+		TableID:           id,
+		StatementTimeName: changefeedbase.StatementTimeName(name),
+	}, eventDesc)
+	require.NoError(t, err)
+	return topic
+}
+
 const noTopicPrefix = ""
 const defaultTopicName = ""
 
@@ -318,6 +359,117 @@ func TestKafkaSink(t *testing.T) {
 	require.EqualValues(t, 0, pool.used())
 }
 
+func TestKafkaSinkFlushUpTo(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(unbuffered)
+	sink, cleanup := makeTestKafkaSink(
+		t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+	stopConsume := p.consume()
+	defer stopConsume()
+
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+	var pool testAllocPool
+	for i := int64(1); i <= 4; i++ {
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(strconv.FormatInt(i, 10)), nil,
+			ts(i), ts(i), pool.alloc()))
+	}
+	testutils.SucceedsSoon(t, func() error {
+		if n := p.outstanding(); n != 4 {
+			return errors.Newf("expected 4 outstanding messages, found %d", n)
+		}
+		return nil
+	})
+
+	// Simulate a slow sink by only acknowledging the rows at or below ts 2.
+	p.mu.Lock()
+	outstanding := append([]*sarama.ProducerMessage(nil), p.mu.outstanding...)
+	p.mu.outstanding = p.mu.outstanding[:0]
+	p.mu.Unlock()
+	flushDone := make(chan error, 1)
+	go func() { flushDone <- sink.FlushUpTo(ctx, ts(2)) }()
+	p.successesCh <- outstanding[0]
+	p.successesCh <- outstanding[1]
+
+	// Flushing up to ts 2 does not wait for the later rows, which are still
+	// inflight, whereas a full flush does.
+	require.NoError(t, <-flushDone)
+	require.EqualValues(t, 2, pool.used())
+	require.NoError(t, sink.FlushUpTo(ctx, ts(2)))
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	require.True(t, errors.Is(sink.FlushUpTo(timeoutCtx, ts(3)), context.DeadlineExceeded))
+	timeoutCtx, cancel = context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	require.True(t, errors.Is(sink.Flush(timeoutCtx), context.DeadlineExceeded))
+
+	go func() {
+		p.successesCh <- outstanding[2]
+		p.successesCh <- outstanding[3]
+	}()
+	require.NoError(t, sink.Flush(ctx))
+	require.EqualValues(t, 0, pool.used())
+}
+
+func TestKafkaSinkMaxInflight(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(unbuffered)
+	sink, cleanup := makeTestKafkaSink(
+		t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+	stopConsume := p.consume()
+	defer stopConsume()
+	metrics, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	sink.metrics = metrics
+
+	emit := func(ctx context.Context, key string) chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- sink.EmitRow(ctx, topic(`t`), []byte(key), []byte(`abcd`), zeroTS, zeroTS, zeroAlloc)
+		}()
+		return errCh
+	}
+	requireWaiting := func(errCh chan error) {
+		select {
+		case err := <-errCh:
+			t.Fatalf("expected the message to wait for the inflight messages, got %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The messages in excess of the limits wait for the acknowledgements of
+	// the inflight messages, as they do while the brokers throttle the sink.
+	sink.maxInflightMessages = 2
+	require.NoError(t, <-emit(ctx, `1`))
+	require.NoError(t, <-emit(ctx, `2`))
+	waiting := emit(ctx, `3`)
+	requireWaiting(waiting)
+	p.acknowledge(2, p.successesCh)
+	require.NoError(t, <-waiting)
+	require.Greater(t, metrics.SinkBackpressureNanos.Value(), int64(0))
+
+	// A message larger than the limit is emitted once nothing is inflight.
+	sink.maxInflightMessages, sink.maxInflightBytes = 0, 8
+	waiting = emit(ctx, `4`)
+	requireWaiting(waiting)
+	p.acknowledge(1, p.successesCh)
+	require.NoError(t, <-waiting)
+
+	// The wait ends with the context of the emitter.
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	require.True(t, errors.Is(<-emit(timeoutCtx, `5`), context.DeadlineExceeded))
+	p.acknowledge(1, p.successesCh)
+	require.NoError(t, sink.Flush(ctx))
+}
+
 func TestKafkaSinkEscaping(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -336,6 +488,92 @@ func TestKafkaSinkEscaping(t *testing.T) {
 	require.Equal(t, sarama.ByteEncoder(`v☃`), m.Value)
 }
 
+func TestKafkaSinkPartitionExpr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(1)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+
+	partitioner := newChangefeedPartitioner(changefeedbase.OptKafkaPartitionerHash)("t")
+	for _, partition := range []int32{0, 3, 7} {
+		require.NoError(t, sink.EmitRowToPartition(
+			ctx, topic(`t`), []byte(`k`), []byte(`v`), zeroTS, zeroTS, zeroAlloc, partition))
+		m := <-p.inputCh
+		require.Equal(t, partition, m.Partition)
+
+		// Explicit partition takes precedence over the hash of the key.
+		got, err := partitioner.Partition(m, 8)
+		require.NoError(t, err)
+		require.Equal(t, partition, got)
+
+		// Partitions outside of the topic's partition range are rejected.
+		_, err = partitioner.Partition(m, partition)
+		require.Regexp(t, `evaluated to partition \d+, but topic t has \d+ partitions`, err)
+		go func() { p.successesCh <- m }()
+	}
+	require.NoError(t, sink.Flush(ctx))
+}
+
+func TestKafkaSinkColumnPartitioner(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(1)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+
+	// The sink only needs the values of the partition expression when it is
+	// configured with the column partitioner.
+	require.Empty(t, sink.PartitionExprs())
+	sink.partitionExpr = `region`
+	require.Equal(t, []string{`region`}, sink.PartitionExprs())
+
+	require.NoError(t, sink.EmitRowWithPartitionValues(
+		ctx, topic(`t`), []byte(`k`), []byte(`v`), zeroTS, zeroTS, zeroAlloc, []string{`us-east`}))
+	m := <-p.inputCh
+	require.Equal(t, []byte(`us-east`), m.Metadata.(messageMetadata).partitionKey)
+	require.False(t, m.Metadata.(messageMetadata).explicitPartition)
+	go func() { p.successesCh <- m }()
+	require.NoError(t, sink.Flush(ctx))
+}
+
+func TestKafkaSinkHeaders(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(1)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+
+	headers := []messageHeader{
+		{key: changefeedbase.MessageHeaderOperation, value: []byte(`delete`)},
+		{key: `region`},
+	}
+	require.NoError(t, sink.EmitRowWithHeaders(
+		ctx, topic(`t`), []byte(`k`), []byte(`v`), zeroTS, zeroTS, zeroAlloc, -1, headers))
+	m := <-p.inputCh
+	require.Equal(t, []sarama.RecordHeader{
+		{Key: []byte(`cdc_op`), Value: []byte(`delete`)},
+		{Key: []byte(`region`)},
+	}, m.Headers)
+	// A negative partition derives the partition from the key.
+	require.False(t, m.Metadata.(messageMetadata).explicitPartition)
+	go func() { p.successesCh <- m }()
+
+	require.NoError(t, sink.EmitRowWithHeaders(
+		ctx, topic(`t`), []byte(`k`), []byte(`v`), zeroTS, zeroTS, zeroAlloc, 3, headers))
+	m = <-p.inputCh
+	require.Equal(t, int32(3), m.Partition)
+	require.True(t, m.Metadata.(messageMetadata).explicitPartition)
+	go func() { p.successesCh <- m }()
+	require.NoError(t, sink.Flush(ctx))
+}
+
 func TestKafkaTopicNameProvided(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -589,6 +827,22 @@ func TestSaramaConfigOptionParsing(t *testing.T) {
 		require.NoError(t, err)
 		require.Error(t, cfg.Validate())
 	})
+	t.Run("validate returns error for bad max inflight configuration", func(t *testing.T) {
+		for _, opts := range []changefeedbase.SinkSpecificJSONConfig{
+			`{"MaxInflight": {"Messages": -1}}`,
+			`{"MaxInflight": {"Bytes": 100}, "ExactlyOnce": true}`,
+		} {
+			cfg, err := getSaramaConfig(opts)
+			require.NoError(t, err)
+			require.Error(t, cfg.Validate())
+		}
+
+		cfg, err := getSaramaConfig(`{"MaxInflight": {"Bytes": 100}, "Topics": {"t": {"MaxInflight": {"Bytes": 10}}}}`)
+		require.NoError(t, err)
+		require.NoError(t, cfg.Validate())
+		_, err = cfg.topicConfig("t")
+		require.EqualError(t, err, "the configuration of a topic cannot override MaxInflight")
+	})
 	t.Run("apply parses valid version", func(t *testing.T) {
 		opts := changefeedbase.SinkSpecificJSONConfig(`{"version": "0.8.2.0"}`)
 
@@ -667,6 +921,550 @@ func TestSaramaConfigOptionParsing(t *testing.T) {
 	})
 }
 
+func TestKafkaPartitioner(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	buildPartitioner := func(opts map[string]string) (sarama.Partitioner, error) {
+		kafkaOpts, err := changefeedbase.MakeStatementOptions(opts).GetKafkaSinkOptions()
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(`kafka://localhost:9092`)
+		require.NoError(t, err)
+		cfg, err := buildKafkaConfig(context.Background(), sinkURL{URL: u}, kafkaOpts)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.Producer.Partitioner(`t`), nil
+	}
+	withPartitioner := func(partitioner string) map[string]string {
+		return map[string]string{changefeedbase.OptKafkaPartitioner: partitioner}
+	}
+	withConfig := func(partitioner string) map[string]string {
+		return map[string]string{
+			changefeedbase.OptKafkaSinkConfig: fmt.Sprintf(`{"Partitioner": %s}`, partitioner),
+		}
+	}
+	unordered := func(opts map[string]string) map[string]string {
+		opts[changefeedbase.OptKafkaUnordered] = ``
+		return opts
+	}
+
+	const numPartitions = 4
+	keyed := func(valueSize int) *sarama.ProducerMessage {
+		return &sarama.ProducerMessage{
+			Topic: `t`,
+			Key:   sarama.ByteEncoder(`k`),
+			Value: sarama.ByteEncoder(make([]byte, valueSize)),
+		}
+	}
+	partition := func(t *testing.T, p sarama.Partitioner, m *sarama.ProducerMessage) int32 {
+		got, err := p.Partition(m, numPartitions)
+		require.NoError(t, err)
+		require.True(t, got >= 0 && got < numPartitions, "partition %d out of range", got)
+		return got
+	}
+	requiresConsistency := func(p sarama.Partitioner, m *sarama.ProducerMessage) bool {
+		return p.(sarama.DynamicConsistencyPartitioner).MessageRequiresConsistency(m)
+	}
+
+	t.Run("defaults to hash", func(t *testing.T) {
+		for _, opts := range []map[string]string{{}, withPartitioner(`hash`)} {
+			p, err := buildPartitioner(opts)
+			require.NoError(t, err)
+			require.True(t, requiresConsistency(p, keyed(10)))
+			// The messages for a key are all emitted to the same partition.
+			first := partition(t, p, keyed(10))
+			for i := 0; i < 10; i++ {
+				require.Equal(t, first, partition(t, p, keyed(10)))
+			}
+		}
+	})
+	t.Run("round robin", func(t *testing.T) {
+		p, err := buildPartitioner(unordered(withPartitioner(`roundrobin`)))
+		require.NoError(t, err)
+		require.False(t, requiresConsistency(p, keyed(10)))
+		for i := 0; i < 2*numPartitions; i++ {
+			require.Equal(t, int32(i%numPartitions), partition(t, p, keyed(10)))
+		}
+	})
+	t.Run("sticky", func(t *testing.T) {
+		p, err := buildPartitioner(unordered(withPartitioner(`sticky`)))
+		require.NoError(t, err)
+		require.False(t, requiresConsistency(p, keyed(10)))
+		// The messages of 1 KiB (plus a key of 1 byte) stick to a partition until
+		// it has been assigned 16 KiB worth of them.
+		const messagesPerPartition = 16
+		prev := int32(-1)
+		for i := 0; i < 4; i++ {
+			cur := partition(t, p, keyed(1<<10))
+			require.NotEqual(t, prev, cur)
+			for j := 1; j < messagesPerPartition; j++ {
+				require.Equal(t, cur, partition(t, p, keyed(1<<10)))
+			}
+			prev = cur
+		}
+	})
+	t.Run("resolved and explicitly partitioned messages keep their partition", func(t *testing.T) {
+		for _, partitioner := range []string{`hash`, `roundrobin`, `sticky`} {
+			p, err := buildPartitioner(unordered(withPartitioner(partitioner)))
+			require.NoError(t, err)
+
+			resolved := &sarama.ProducerMessage{Topic: `t`, Partition: 2, Value: sarama.ByteEncoder(`v`)}
+			require.True(t, requiresConsistency(p, resolved))
+			require.Equal(t, int32(2), partition(t, p, resolved))
+
+			explicit := keyed(10)
+			explicit.Partition = 3
+			explicit.Metadata = messageMetadata{explicitPartition: true}
+			require.True(t, requiresConsistency(p, explicit))
+			require.Equal(t, int32(3), partition(t, p, explicit))
+		}
+	})
+	t.Run("round robin and sticky require unordered", func(t *testing.T) {
+		for _, partitioner := range []string{`roundrobin`, `sticky`} {
+			_, err := buildPartitioner(withPartitioner(partitioner))
+			require.Regexp(t, `kafka_partitioner='`+partitioner+`' does not preserve the order `+
+				`of the messages for a key and requires kafka_unordered`, err)
+		}
+		_, err := buildPartitioner(withConfig(`{"Strategy": "sticky"}`))
+		require.Regexp(t, `Partitioner.Strategy='sticky' does not preserve the order`, err)
+
+		opts := unordered(withPartitioner(`hash`))
+		opts[changefeedbase.OptKafkaStrictOrdering] = ``
+		_, err = buildPartitioner(opts)
+		require.Regexp(t, `kafka_unordered cannot be used with kafka_strict_ordering`, err)
+	})
+	t.Run("strict ordering requires hash", func(t *testing.T) {
+		for _, partitioner := range []string{`roundrobin`, `sticky`} {
+			opts := withPartitioner(partitioner)
+			opts[changefeedbase.OptKafkaStrictOrdering] = ``
+			_, err := buildPartitioner(opts)
+			require.Regexp(t, `kafka_partitioner='`+partitioner+`' does not preserve the order`, err)
+		}
+		opts := withPartitioner(`hash`)
+		opts[changefeedbase.OptKafkaStrictOrdering] = ``
+		_, err := buildPartitioner(opts)
+		require.NoError(t, err)
+	})
+	t.Run("configured in kafka_sink_config", func(t *testing.T) {
+		p, err := buildPartitioner(unordered(withConfig(`{"Strategy": "roundrobin"}`)))
+		require.NoError(t, err)
+		for i := 0; i < 2*numPartitions; i++ {
+			require.Equal(t, int32(i%numPartitions), partition(t, p, keyed(10)))
+		}
+
+		opts := withConfig(`{"Strategy": "roundrobin"}`)
+		opts[changefeedbase.OptKafkaPartitioner] = `hash`
+		_, err = buildPartitioner(opts)
+		require.Regexp(t, `kafka_partitioner cannot be used with the Partitioner of kafka_sink_config`, err)
+	})
+	t.Run("column", func(t *testing.T) {
+		p, err := buildPartitioner(withConfig(`{"Strategy": "column", "Expr": "region"}`))
+		require.NoError(t, err)
+		withPartitionKey := func(key, partitionKey string) *sarama.ProducerMessage {
+			m := keyed(10)
+			m.Key = sarama.ByteEncoder(key)
+			m.Metadata = messageMetadata{partitionKey: []byte(partitionKey)}
+			return m
+		}
+		require.True(t, requiresConsistency(p, withPartitionKey(`k`, `us-east`)))
+		// The messages are partitioned by the hash of the value of the
+		// expression, whatever their key.
+		hashed, err := sarama.NewHashPartitioner(`t`).Partition(
+			&sarama.ProducerMessage{Key: sarama.ByteEncoder(`us-east`)}, numPartitions)
+		require.NoError(t, err)
+		for _, key := range []string{`a`, `b`, `c`, `d`} {
+			require.Equal(t, hashed, partition(t, p, withPartitionKey(key, `us-east`)))
+		}
+
+		opts := withConfig(`{"Strategy": "column", "Expr": "region"}`)
+		opts[changefeedbase.OptKafkaStrictOrdering] = ``
+		_, err = buildPartitioner(opts)
+		require.Regexp(t, `Partitioner.Strategy='column' does not preserve the order`, err)
+
+		opts = withConfig(`{"Strategy": "column", "Expr": "region"}`)
+		opts[changefeedbase.OptKafkaHeaders] = `cdc_op`
+		_, err = buildPartitioner(opts)
+		require.Regexp(t, `the "column" partitioner cannot be used with kafka_headers`, err)
+	})
+	t.Run("rejects unknown partitioners", func(t *testing.T) {
+		_, err := buildPartitioner(withPartitioner(`random`))
+		require.Regexp(t, `unknown kafka_partitioner: random`, err)
+		_, err = buildPartitioner(withConfig(`{"Strategy": "random"}`))
+		require.Regexp(t, `unknown Partitioner.Strategy "random"`, err)
+	})
+	t.Run("validates the expression", func(t *testing.T) {
+		_, err := buildPartitioner(withConfig(`{"Strategy": "column"}`))
+		require.Regexp(t, `the "column" strategy requires Partitioner.Expr`, err)
+		_, err = buildPartitioner(withConfig(`{"Strategy": "hash", "Expr": "region"}`))
+		require.Regexp(t, `Partitioner.Expr requires the "column" strategy`, err)
+	})
+}
+
+func TestKafkaSinkTopicConfigOverrides(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	buildConfigs := func(
+		jsonConfig string, targetNames ...string,
+	) (*sarama.Config, map[string]*sarama.Config, error) {
+		kafkaOpts, err := changefeedbase.MakeStatementOptions(map[string]string{
+			changefeedbase.OptKafkaSinkConfig: jsonConfig,
+		}).GetKafkaSinkOptions()
+		require.NoError(t, err)
+		u, err := url.Parse(`kafka://localhost:9092`)
+		require.NoError(t, err)
+		config, err := buildKafkaConfig(context.Background(), sinkURL{URL: u}, kafkaOpts)
+		require.NoError(t, err)
+		topics, err := MakeTopicNamer(
+			makeChangefeedTargets(targetNames...), WithSanitizeFn(SQLNameToKafkaName))
+		require.NoError(t, err)
+		topicCfgs, err := buildKafkaTopicConfigs(config, kafkaOpts, topics)
+		return config, topicCfgs, err
+	}
+
+	t.Run("overrides are merged over the sink configuration", func(t *testing.T) {
+		config, topicCfgs, err := buildConfigs(`{
+			"Flush": {"Messages": 10, "Frequency": "1s"},
+			"RequiredAcks": "ALL",
+			"Topics": {"t2": {"Flush": {"Messages": 500}}}
+		}`, `t1`, `t2`)
+		require.NoError(t, err)
+		require.Equal(t, 10, config.Producer.Flush.Messages)
+		require.Len(t, topicCfgs, 1)
+		t2 := topicCfgs[`t2`]
+		require.Equal(t, 500, t2.Producer.Flush.Messages)
+		require.Equal(t, time.Second, t2.Producer.Flush.Frequency)
+		require.Equal(t, defaultSaramaConfig().Flush.MaxMessages, t2.Producer.Flush.MaxMessages)
+		require.Equal(t, sarama.WaitForAll, t2.Producer.RequiredAcks)
+	})
+	t.Run("no overrides", func(t *testing.T) {
+		_, topicCfgs, err := buildConfigs(`{"Flush": {"Messages": 10, "Frequency": "1s"}}`, `t1`)
+		require.NoError(t, err)
+		require.Nil(t, topicCfgs)
+	})
+	t.Run("topics with the same producer settings share a configuration", func(t *testing.T) {
+		config, topicCfgs, err := buildConfigs(`{
+			"Flush": {"Messages": 10, "Frequency": "1s"},
+			"Topics": {
+				"t1": {"Flush": {"Messages": 10}, "TopicCreation": {"Partitions": 3, "ReplicationFactor": 1}},
+				"t2": {"Flush": {"Messages": 500}},
+				"t3": {"Flush": {"Messages": 500}, "RequiredAcks": ""},
+				"t4": {"Flush": {"Messages": 500}, "RequiredAcks": "ALL"}
+			}
+		}`, `t1`, `t2`, `t3`, `t4`)
+		require.NoError(t, err)
+		// The overrides of t1 leave the settings of the producer unchanged, so
+		// its messages are emitted with the producer of the sink.
+		require.NotContains(t, topicCfgs, `t1`)
+		require.Len(t, topicCfgs, 3)
+		require.Same(t, topicCfgs[`t2`], topicCfgs[`t3`])
+		require.NotSame(t, topicCfgs[`t2`], topicCfgs[`t4`])
+		require.NotSame(t, config, topicCfgs[`t2`])
+		require.Equal(t, sarama.WaitForAll, topicCfgs[`t4`].Producer.RequiredAcks)
+	})
+	t.Run("rejects topics which are not emitted to", func(t *testing.T) {
+		_, _, err := buildConfigs(`{"Topics": {"t3": {"Flush": {"Messages": 1}}}}`, `t1`, `t2`)
+		require.Regexp(t, `overrides the configuration of topic "t3", which is not emitted to`, err)
+	})
+	t.Run("validates topic configurations", func(t *testing.T) {
+		_, _, err := buildConfigs(`{"Topics": {"t1": {"Flush": {"Bytes": 10}}}}`, `t1`)
+		require.Regexp(t, `invalid sarama configuration of topic "t1"`, err)
+
+		_, _, err = buildConfigs(`{"Topics": {"t1": {"RequiredAcks": "MANY"}}}`, `t1`)
+		require.Regexp(t, `failed to apply kafka client configuration of topic "t1"`, err)
+
+		_, _, err = buildConfigs(`{"Topics": {"t1": {"Topics": {"t1": {}}}}}`, `t1`)
+		require.Regexp(t, `cannot override the configuration of other topics`, err)
+	})
+
+	t.Run("each topic is emitted with its own configuration", func(t *testing.T) {
+		config, topicCfgs, err := buildConfigs(`{
+			"Flush": {"Messages": 10, "Frequency": "1s"},
+			"Topics": {
+				"t2": {"Flush": {"Messages": 500, "Frequency": "1h"}},
+				"t3": {"Flush": {"Messages": 500, "Frequency": "1h"}}
+			}
+		}`, `t1`, `t2`, `t3`)
+		require.NoError(t, err)
+		topics, err := MakeTopicNamer(
+			makeChangefeedTargets(`t1`, `t2`, `t3`), WithSanitizeFn(SQLNameToKafkaName))
+		require.NoError(t, err)
+
+		producers := make(map[*sarama.Config]*asyncProducerMock)
+		s := &kafkaSink{
+			ctx:       ctx,
+			topics:    topics,
+			kafkaCfg:  config,
+			topicCfgs: topicCfgs,
+			metrics:   (*sliMetrics)(nil),
+			knobs: kafkaSinkKnobs{
+				OverrideClientInit: func(config *sarama.Config) (kafkaClient, error) {
+					return &fakeKafkaClient{config}, nil
+				},
+				OverrideAsyncProducerFromClient: func(client kafkaClient) (sarama.AsyncProducer, error) {
+					p := newAsyncProducerMock(1)
+					producers[client.Config()] = p
+					return p, nil
+				},
+			},
+		}
+		require.NoError(t, s.Dial())
+		defer func() { require.NoError(t, s.Close()) }()
+		// t2 and t3 share a producer.
+		require.Len(t, producers, 2)
+
+		for _, tc := range []struct {
+			topic     string
+			config    *sarama.Config
+			messages  int
+			frequency time.Duration
+		}{
+			{topic: `t1`, config: config, messages: 10, frequency: time.Second},
+			{topic: `t2`, config: topicCfgs[`t2`], messages: 500, frequency: time.Hour},
+			{topic: `t3`, config: topicCfgs[`t2`], messages: 500, frequency: time.Hour},
+		} {
+			require.Equal(t, tc.messages, tc.config.Producer.Flush.Messages)
+			require.Equal(t, tc.frequency, tc.config.Producer.Flush.Frequency)
+
+			require.NoError(t, s.EmitRow(
+				ctx, topic(tc.topic), []byte(tc.topic), nil, zeroTS, zeroTS, zeroAlloc))
+			p := producers[tc.config]
+			m := <-p.inputCh
+			require.Equal(t, tc.topic, m.Topic)
+			// The acknowledgements of the producers of all the topics are
+			// handled by the sink.
+			p.successesCh <- m
+			require.NoError(t, s.Flush(ctx))
+		}
+	})
+}
+
+func TestKafkaSASLOAuthBearer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var requests int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if id, secret, ok := r.BasicAuth(); !ok || id != `id` || secret != `s3cr3t` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, `client_credentials`, r.PostForm.Get(`grant_type`))
+		require.Equal(t, `a b`, r.PostForm.Get(`scope`))
+		w.Header().Set(`Content-Type`, `application/json`)
+		// The token expires right away, so that it is retrieved again each time
+		// it is requested.
+		_, _ = fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 1}`, requests)
+	}))
+	defer tokenServer.Close()
+
+	buildConfig := func(params url.Values) (*sarama.Config, error) {
+		u, err := url.Parse(`kafka://localhost:9092?` + params.Encode())
+		require.NoError(t, err)
+		return buildKafkaConfig(context.Background(), sinkURL{URL: u}, changefeedbase.KafkaSinkOptions{})
+	}
+	oauthParams := func() url.Values {
+		return url.Values{
+			changefeedbase.SinkParamSASLEnabled:      {`true`},
+			changefeedbase.SinkParamSASLMechanism:    {sarama.SASLTypeOAuth},
+			changefeedbase.SinkParamSASLClientID:     {`id`},
+			changefeedbase.SinkParamSASLClientSecret: {base64.StdEncoding.EncodeToString([]byte(`s3cr3t`))},
+			changefeedbase.SinkParamSASLTokenURL:     {tokenServer.URL},
+			changefeedbase.SinkParamSASLScopes:       {`a,b`},
+		}
+	}
+
+	t.Run("retrieves and refreshes tokens", func(t *testing.T) {
+		cfg, err := buildConfig(oauthParams())
+		require.NoError(t, err)
+		require.Equal(t, sarama.SASLMechanism(sarama.SASLTypeOAuth), cfg.Net.SASL.Mechanism)
+		for i := 1; i <= 2; i++ {
+			token, err := cfg.Net.SASL.TokenProvider.Token()
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf(`token-%d`, requests), token.Token)
+		}
+		require.Equal(t, 2, requests)
+	})
+	t.Run("surfaces token endpoint errors", func(t *testing.T) {
+		params := oauthParams()
+		params.Set(changefeedbase.SinkParamSASLClientID, `nope`)
+		cfg, err := buildConfig(params)
+		require.NoError(t, err)
+		_, err = cfg.Net.SASL.TokenProvider.Token()
+		require.Regexp(t, `retrieving OAUTHBEARER token`, err)
+	})
+	t.Run("requires the client credentials", func(t *testing.T) {
+		for _, param := range []string{
+			changefeedbase.SinkParamSASLClientID,
+			changefeedbase.SinkParamSASLClientSecret,
+			changefeedbase.SinkParamSASLTokenURL,
+		} {
+			params := oauthParams()
+			params.Del(param)
+			_, err := buildConfig(params)
+			require.Regexp(t, param+` must be provided when sasl_mechanism=OAUTHBEARER`, err)
+		}
+	})
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		params := oauthParams()
+		params.Set(changefeedbase.SinkParamSASLUser, `user`)
+		_, err := buildConfig(params)
+		require.Regexp(t, `sasl_user and sasl_password cannot be used with sasl_mechanism=OAUTHBEARER`, err)
+
+		params = oauthParams()
+		params.Set(changefeedbase.SinkParamSASLTokenURL, `not a url`)
+		_, err = buildConfig(params)
+		require.Regexp(t, `param sasl_token_url must be a valid URL`, err)
+
+		params = oauthParams()
+		params.Set(changefeedbase.SinkParamSASLClientSecret, `!!`)
+		_, err = buildConfig(params)
+		require.Regexp(t, `param sasl_client_secret must be base 64 encoded`, err)
+
+		params = url.Values{
+			changefeedbase.SinkParamSASLEnabled:  {`true`},
+			changefeedbase.SinkParamSASLUser:     {`user`},
+			changefeedbase.SinkParamSASLPassword: {`password`},
+			changefeedbase.SinkParamSASLClientID: {`id`},
+		}
+		_, err = buildConfig(params)
+		require.Regexp(t, `sasl_client_id requires sasl_mechanism=OAUTHBEARER`, err)
+	})
+}
+
+func TestKafkaMaxInFlight(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	buildConfig := func(opts map[string]string) (*sarama.Config, error) {
+		kafkaOpts, err := changefeedbase.MakeStatementOptions(opts).GetKafkaSinkOptions()
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(`kafka://localhost:9092`)
+		require.NoError(t, err)
+		return buildKafkaConfig(context.Background(), sinkURL{URL: u}, kafkaOpts)
+	}
+
+	t.Run("defaults to producer default", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{})
+		require.NoError(t, err)
+		require.Equal(t, sarama.NewConfig().Net.MaxOpenRequests, cfg.Net.MaxOpenRequests)
+	})
+	t.Run("applies max in flight", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{changefeedbase.OptKafkaMaxInFlight: `3`})
+		require.NoError(t, err)
+		require.Equal(t, 3, cfg.Net.MaxOpenRequests)
+	})
+	t.Run("strict ordering limits requests in flight", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{changefeedbase.OptKafkaStrictOrdering: ``})
+		require.NoError(t, err)
+		require.Equal(t, 1, cfg.Net.MaxOpenRequests)
+
+		cfg, err = buildConfig(map[string]string{
+			changefeedbase.OptKafkaStrictOrdering: ``,
+			changefeedbase.OptKafkaMaxInFlight:    `1`,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, cfg.Net.MaxOpenRequests)
+	})
+	t.Run("strict ordering rejects more than one request in flight", func(t *testing.T) {
+		_, err := buildConfig(map[string]string{
+			changefeedbase.OptKafkaStrictOrdering: ``,
+			changefeedbase.OptKafkaMaxInFlight:    `5`,
+		})
+		require.Regexp(t, `kafka_max_in_flight=5 may reorder messages for the same key`, err)
+	})
+	t.Run("rejects invalid max in flight", func(t *testing.T) {
+		for _, v := range []string{`0`, `-1`, `many`} {
+			_, err := buildConfig(map[string]string{changefeedbase.OptKafkaMaxInFlight: v})
+			require.Regexp(t, `option kafka_max_in_flight must be a positive integer`, err)
+		}
+	})
+}
+
+func TestKafkaCompression(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	buildConfig := func(opts map[string]string) (*sarama.Config, error) {
+		kafkaOpts, err := changefeedbase.MakeStatementOptions(opts).GetKafkaSinkOptions()
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(`kafka://localhost:9092`)
+		require.NoError(t, err)
+		return buildKafkaConfig(context.Background(), sinkURL{URL: u}, kafkaOpts)
+	}
+
+	t.Run("defaults to no compression", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{})
+		require.NoError(t, err)
+		require.Equal(t, sarama.CompressionNone, cfg.Producer.Compression)
+	})
+	t.Run("applies compression codec", func(t *testing.T) {
+		for codec, expected := range map[string]sarama.CompressionCodec{
+			`gzip`:   sarama.CompressionGZIP,
+			`LZ4`:    sarama.CompressionLZ4,
+			`snappy`: sarama.CompressionSnappy,
+		} {
+			cfg, err := buildConfig(map[string]string{changefeedbase.OptCompression: codec})
+			require.NoError(t, err)
+			require.Equal(t, expected, cfg.Producer.Compression)
+		}
+	})
+	t.Run("zstd requires kafka 2.1.0", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{changefeedbase.OptCompression: `zstd`})
+		require.NoError(t, err)
+		require.Equal(t, sarama.CompressionZSTD, cfg.Producer.Compression)
+		require.Equal(t, sarama.V2_1_0_0, cfg.Version)
+
+		_, err = buildConfig(map[string]string{
+			changefeedbase.OptCompression:     `zstd`,
+			changefeedbase.OptKafkaSinkConfig: `{"Version": "2.0.0"}`,
+		})
+		require.Regexp(t, `compression=zstd requires kafka version 2.1.0 or later`, err)
+	})
+	t.Run("cluster default", func(t *testing.T) {
+		st := cluster.MakeTestingClusterSettings()
+		changefeedbase.DefaultSinkCompression.Override(context.Background(), &st.SV, 3 /* lz4 */)
+		require.Equal(t, `lz4`, sinkCompression(&st.SV, ``, kafkaCompressionCodecNames))
+		require.Equal(t, `gzip`, sinkCompression(&st.SV, `gzip`, kafkaCompressionCodecNames))
+		// The webhook and cloud storage sinks do not support lz4.
+		require.Equal(t, ``, sinkCompression(&st.SV, ``, payloadCompressionCodecs))
+	})
+}
+
+func TestKafkaRetryOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	u, err := url.Parse(`kafka://localhost:9092`)
+	require.NoError(t, err)
+	kafkaOpts, err := changefeedbase.MakeStatementOptions(map[string]string{
+		changefeedbase.OptSinkRetryMaxAttempts: `5`,
+		changefeedbase.OptSinkRetryBackoff:     `100ms`,
+		changefeedbase.OptSinkRetryMaxBackoff:  `300ms`,
+	}).GetKafkaSinkOptions()
+	require.NoError(t, err)
+	cfg, err := buildKafkaConfig(context.Background(), sinkURL{URL: u}, kafkaOpts)
+	require.NoError(t, err)
+
+	require.Equal(t, 4, cfg.Producer.Retry.Max)
+	require.Equal(t, 100*time.Millisecond, cfg.Producer.Retry.Backoff)
+	for retries, expected := range []time.Duration{
+		100 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond,
+	} {
+		require.Equal(t, expected, cfg.Producer.Retry.BackoffFunc(retries, cfg.Producer.Retry.Max))
+	}
+}
+
 func TestKafkaSinkTracksMemory(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -706,3 +1504,26 @@ func TestKafkaSinkTracksMemory(t *testing.T) {
 	require.EqualValues(t, 0, p.outstanding())
 	require.EqualValues(t, 0, pool.used())
 }
+
+func TestErrorWrapperSinkRecordsErrors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	metrics, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	wrapped := &fanOutTestSink{}
+	s := &errorWrapperSink{wrapped: wrapped, metrics: metrics}
+
+	require.NoError(t, s.EmitRow(ctx, nil, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+	require.NoError(t, s.Flush(ctx))
+	require.EqualValues(t, 0, metrics.SinkErrors.Value())
+
+	// The errors of the sink are counted in the metrics of its scope, whatever
+	// the kind of the sink.
+	wrapped.err = errors.New("boom")
+	err = s.EmitRow(ctx, nil, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc)
+	require.True(t, changefeedbase.IsRetryableError(err))
+	require.Error(t, s.Flush(ctx))
+	require.EqualValues(t, 2, metrics.SinkErrors.Value())
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// sinkTransactions are the transactions of a TransactionalEventSink which the
+// frontier records in the job progress.
+//
+// Whenever an aggregator forwards its resolved spans to the frontier, it
+// prepares a transaction holding the rows of its spans at or below its local
+// frontier, which is the resolved timestamp of the transaction. The frontier
+// records the transaction with its next checkpoint, before any high-water
+// covering rows of the transaction, and commits it once the checkpoint is
+// persisted. A restarted changefeed commits the transactions which were
+// recorded but not committed yet, and resumes their spans from their resolved
+// timestamps rather than from the high-water, so their rows are not emitted
+// again. The transactions which were prepared but not recorded are never
+// committed, and their rows are emitted again.
+//
+// A committed transaction remains recorded until the high-water reaches its
+// resolved timestamp, or a later transaction of the same spans supersedes it.
+type sinkTransactions struct {
+	txns []jobspb.ChangefeedSinkTransaction
+}
+
+// add records a transaction prepared by an aggregator. The committed
+// transactions whose spans it covers, up to a later resolved timestamp, are
+// superseded by it.
+func (t *sinkTransactions) add(txn jobspb.ChangefeedSinkTransaction) {
+	var covered roachpb.SpanGroup
+	covered.Add(txn.Spans...)
+	kept := t.txns[:0]
+	for _, prev := range t.txns {
+		if prev.Committed && prev.Resolved.LessEq(txn.Resolved) && covered.Encloses(prev.Spans...) {
+			continue
+		}
+		kept = append(kept, prev)
+	}
+	t.txns = append(kept, txn)
+}
+
+// hasUncommitted returns true if some of the transactions are not committed
+// yet.
+func (t *sinkTransactions) hasUncommitted() bool {
+	for _, txn := range t.txns {
+		if !txn.Committed {
+			return true
+		}
+	}
+	return false
+}
+
+// recordedWith returns the transactions to record in the job progress along
+// with the specified high-water. The committed transactions whose resolved
+// timestamps are not above the high-water are dropped, since the changefeed
+// resumes their spans from the high-water anyway.
+func (t *sinkTransactions) recordedWith(highWater hlc.Timestamp) []jobspb.ChangefeedSinkTransaction {
+	kept := t.txns[:0]
+	for _, txn := range t.txns {
+		if txn.Committed && txn.Resolved.LessEq(highWater) {
+			continue
+		}
+		kept = append(kept, txn)
+	}
+	t.txns = kept
+	if len(kept) == 0 {
+		return nil
+	}
+	// The recorded transactions are copied, since they are marked as committed
+	// later on.
+	return append([]jobspb.ChangefeedSinkTransaction(nil), kept...)
+}
+
+// commit commits the transactions which are not committed yet with the sink.
+// It must only be called once they are recorded in the job progress.
+func (t *sinkTransactions) commit(ctx context.Context, sink ResolvedTimestampSink) error {
+	var uncommitted []jobspb.ChangefeedSinkTransaction
+	for _, txn := range t.txns {
+		if !txn.Committed {
+			uncommitted = append(uncommitted, txn)
+		}
+	}
+	if len(uncommitted) == 0 {
+		return nil
+	}
+	cs, ok := sink.(TransactionCommittingSink)
+	if !ok {
+		return errors.AssertionFailedf("sink does not support transactions")
+	}
+	if err := cs.CommitTransactions(ctx, uncommitted); err != nil {
+		return err
+	}
+	for i := range t.txns {
+		t.txns[i].Committed = true
+	}
+	return nil
+}
@@ -27,21 +27,40 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/system"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
 
 const (
-	applicationTypeJSON = `application/json`
-	applicationTypeCSV  = `text/csv`
-	authorizationHeader = `Authorization`
+	applicationTypeJSON   = `application/json`
+	applicationTypeCSV    = `text/csv`
+	authorizationHeader   = `Authorization`
+	contentEncodingHeader = `Content-Encoding`
+	// acknowledgedResolvedHeader is the header of the responses of an endpoint
+	// acknowledging the resolved timestamps (see webhookSinkConfig), whose
+	// value is the highest resolved timestamp up to which the endpoint durably
+	// applied the rows, in the format of the resolved timestamps it received.
+	acknowledgedResolvedHeader = `Changefeed-Acknowledged-Resolved`
+	// applicationTypeCloudEventsBatch is the content type of the batches of
+	// structured CloudEvents of the HTTP protocol binding.
+	applicationTypeCloudEventsBatch = `application/cloudevents-batch+json`
+	// applicationTypeParquet is the content type of the parquet files of
+	// format=parquet, which are sent as is.
+	applicationTypeParquet = `application/vnd.apache.parquet`
 )
 
+// webhookWorkerBufferSize is the number of messages buffered for each worker,
+// so that the worker keeps batching messages while its previous batch is
+// being sent.
+const webhookWorkerBufferSize = 1024
+
 func isWebhookSink(u *url.URL) bool {
 	switch u.Scheme {
 	// allow HTTP here but throw an error later to make it clear HTTPS is required
@@ -55,35 +74,66 @@ func isWebhookSink(u *url.URL) bool {
 type webhookSink struct {
 	// Webhook configuration.
 	parallelism int
-	retryCfg    retry.Options
+	retryCfg    sinkRetryPolicy
 	batchCfg    batchConfig
 	ts          timeutil.TimeSource
 	format      changefeedbase.FormatType
+	// compression is the codec with which the bodies of the requests are
+	// compressed, if any. It is also the content coding of the requests.
+	compression string
+	// csvHeaders, if set, builds the header rows starting the batches of
+	// format=csv (see changefeedbase.OptCSVHeader).
+	csvHeaders *csvHeaders
+
+	// topicBatchCfgs are the batch configs of the topics whose Flush is
+	// overridden in the webhook_sink_config option, keyed by topic name (see
+	// webhookSinkConfig). The messages of these topics are batched apart from
+	// the messages of the other topics.
+	topicBatchCfgs map[string]batchConfig
+	topics         *TopicNamer
 
 	// Webhook destination.
 	url        sinkURL
 	authHeader string
 	client     *httputil.Client
 
-	// messages are written onto batch channel
-	// which batches matches based on batching configuration.
-	batchChan chan webhookMessage
+	// settings, if set, lets the sink share its client with the sinks of other
+	// changefeeds whose clients have the same connectionKey (see
+	// sinkConnectionPool), and releaseClient releases the shared client.
+	settings      *cluster.Settings
+	connectionKey sinkConnectionKey
+	releaseClient func() error
 
-	// flushDone channel signaled when flushing completes.
+	// flushDone channel signaled by each worker once it has sent the messages
+	// written before a flush request.
 	flushDone chan struct{}
 
 	// errChan is written to indicate an error while sending message.
 	errChan chan error
 
 	// parallelism workers are created and controlled by the workerGroup, running with workerCtx.
-	// each worker gets its own events channel.
+	// each worker gets its own events channel, onto which the messages are
+	// written, and batches them based on batching configuration.
 	workerCtx   context.Context
 	workerGroup ctxgroup.Group
 	exitWorkers func() // Signaled to shut down all workers.
-	eventsChans []chan []messagePayload
+	eventsChans []chan webhookMessage
 	metrics     metricsRecorder
+
+	// acknowledgeResolved is set if the endpoint acknowledges the resolved
+	// timestamps (see webhookSinkConfig).
+	acknowledgeResolved bool
+	acknowledged        struct {
+		syncutil.Mutex
+		// resolved is the highest resolved timestamp acknowledged by the
+		// endpoint, which is bounded by emitted, the highest resolved timestamp
+		// emitted to it.
+		resolved, emitted hlc.Timestamp
+	}
 }
 
+var _ AcknowledgingSink = (*webhookSink)(nil)
+
 type webhookSinkPayload struct {
 	Payload []json.RawMessage `json:"payload"`
 	Length  int               `json:"length"`
@@ -125,14 +175,65 @@ func encodePayloadJSONWebhook(messages []messagePayload) (encodedPayload, error)
 	return result, err
 }
 
+// encodePayloadCloudEventsWebhook encodes the messages, which are structured
+// CloudEvents, as a batch of CloudEvents: a JSON array of the events.
+func encodePayloadCloudEventsWebhook(messages []messagePayload) (encodedPayload, error) {
+	result := encodedPayload{
+		emitTime: timeutil.Now(),
+	}
+
+	payload := make([]json.RawMessage, len(messages))
+	for i, m := range messages {
+		result.alloc.Merge(&m.alloc)
+		payload[i] = m.val
+		if m.emitTime.Before(result.emitTime) {
+			result.emitTime = m.emitTime
+		}
+		if result.mvcc.IsEmpty() || m.mvcc.Less(result.mvcc) {
+			result.mvcc = m.mvcc
+		}
+	}
+
+	j, err := json.Marshal(payload)
+	if err != nil {
+		return encodedPayload{}, err
+	}
+	result.data = j
+	return result, nil
+}
+
+// encodePayloadParquetWebhook encodes the message, which is a parquet file
+// (see parquetBatchingSink), as is. The files cannot be merged, so they are
+// sent one per request.
+func encodePayloadParquetWebhook(messages []messagePayload) (encodedPayload, error) {
+	if len(messages) != 1 {
+		return encodedPayload{}, errors.AssertionFailedf(
+			"expected a single parquet file per request, found %d", len(messages))
+	}
+	m := messages[0]
+	return encodedPayload{data: m.val, alloc: m.alloc, emitTime: m.emitTime, mvcc: m.mvcc}, nil
+}
+
+// encodePayloadCSVWebhook encodes the messages as CSV records separated by
+// newlines. The header rows of the messages, if any, precede the first
+// message of the batch and the messages whose header differs from the header
+// of the previous message, such as the messages of another table.
 func encodePayloadCSVWebhook(messages []messagePayload) (encodedPayload, error) {
 	result := encodedPayload{
 		emitTime: timeutil.Now(),
 	}
 
 	var mergedMsgs []byte
-	for _, m := range messages {
+	var header []byte
+	for i, m := range messages {
 		result.alloc.Merge(&m.alloc)
+		if i > 0 {
+			mergedMsgs = append(mergedMsgs, '\n')
+		}
+		if m.csvHeader != nil && (i == 0 || !bytes.Equal(m.csvHeader, header)) {
+			mergedMsgs = append(append(mergedMsgs, m.csvHeader...), '\n')
+			header = m.csvHeader
+		}
 		mergedMsgs = append(mergedMsgs, m.val...)
 		if m.emitTime.Before(result.emitTime) {
 			result.emitTime = m.emitTime
@@ -153,12 +254,18 @@ type messagePayload struct {
 	alloc    kvevent.Alloc
 	emitTime time.Time
 	mvcc     hlc.Timestamp
+	// csvHeader is the header row of the message for format=csv, if any.
+	csvHeader []byte
 }
 
 // webhookMessage contains either messagePayload or a flush request.
 type webhookMessage struct {
-	flushDone *chan struct{}
-	payload   messagePayload
+	flush   bool
+	payload messagePayload
+	// topic is the name of the topic of the message if its Flush is
+	// overridden, in which case the message is batched with the config of the
+	// topic.
+	topic string
 }
 
 type batch struct {
@@ -181,6 +288,18 @@ type batchConfig struct {
 	Frequency       jsonDuration `json:",omitempty"`
 }
 
+func (c batchConfig) validate() error {
+	// don't support negative values
+	if c.Messages < 0 || c.Bytes < 0 || c.Frequency < 0 {
+		return errors.Errorf("invalid option value %s, all config values must be non-negative", changefeedbase.OptWebhookSinkConfig)
+	}
+	// errors if other batch values are set, but frequency is not
+	if (c.Messages > 0 || c.Bytes > 0) && c.Frequency == 0 {
+		return errors.Errorf("invalid option value %s, flush frequency is not set, messages may never be sent", changefeedbase.OptWebhookSinkConfig)
+	}
+	return nil
+}
+
 type jsonMaxRetries int
 
 func (j *jsonMaxRetries) UnmarshalJSON(b []byte) error {
@@ -227,16 +346,48 @@ type retryConfig struct {
 //	 "Retry": {
 //	   "Max":     ...,
 //	   "Backoff": ...,
-//   }
+//   },
+//   "Parallelism": ...,
+//   "AcknowledgeResolved": ...,
+//   "Topics": {
+//     "<topic>": {"Flush": {...}},
+//   },
 // }
+//
+// Topics overrides the Flush of specific topics, keyed by topic name. The
+// Flush of a topic is merged over the Flush of the sink, e.g.
+// {"Topics": {"foo": {"Flush": {"Messages": 100}}}} only changes the number of
+// messages per batch of topic foo, whose messages are then batched apart from
+// the messages of the other topics.
+//
+// Parallelism is the number of workers sending requests from each node; it
+// defaults to the number of CPUs.
+//
+// AcknowledgeResolved, if set, makes the changefeed only checkpoint up to the
+// resolved timestamp which the endpoint acknowledged with the
+// acknowledgedResolvedHeader of its responses, so that the changefeed restarts
+// from the rows the endpoint did not durably apply yet. The endpoint then
+// applies the rows effectively once by discarding the rows at or below the
+// resolved timestamp it acknowledged, without a deduplication store of its own.
 type webhookSinkConfig struct {
-	Flush batchConfig `json:",omitempty"`
-	Retry retryConfig `json:",omitempty"`
+	Flush               batchConfig                `json:",omitempty"`
+	Retry               retryConfig                `json:",omitempty"`
+	Parallelism         int                        `json:",omitempty"`
+	AcknowledgeResolved bool                       `json:",omitempty"`
+	Topics              map[string]json.RawMessage `json:",omitempty"`
+}
+
+// webhookSinkAcknowledgesResolved returns true if the specified
+// webhook_sink_config sets AcknowledgeResolved. Invalid configurations are
+// reported by the sink.
+func webhookSinkAcknowledgesResolved(jsonStr changefeedbase.SinkSpecificJSONConfig) bool {
+	var cfg struct{ AcknowledgeResolved bool }
+	return jsonStr != `` && json.Unmarshal([]byte(jsonStr), &cfg) == nil && cfg.AcknowledgeResolved
 }
 
 func (s *webhookSink) getWebhookSinkConfig(
 	jsonStr changefeedbase.SinkSpecificJSONConfig,
-) (batchCfg batchConfig, retryCfg retry.Options, err error) {
+) (batchCfg batchConfig, retryCfg retry.Options, parallelism int, err error) {
 	retryCfg = defaultRetryConfig()
 
 	var cfg webhookSinkConfig
@@ -245,34 +396,74 @@ func (s *webhookSink) getWebhookSinkConfig(
 	if jsonStr != `` {
 		// set retry defaults to be overridden if included in JSON
 		if err = json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
-			return batchCfg, retryCfg, errors.Wrapf(err, "error unmarshalling json")
+			return batchCfg, retryCfg, parallelism, errors.Wrapf(err, "error unmarshalling json")
 		}
 	}
 
 	// don't support negative values
-	if cfg.Flush.Messages < 0 || cfg.Flush.Bytes < 0 || cfg.Flush.Frequency < 0 ||
-		cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 {
-		return batchCfg, retryCfg, errors.Errorf("invalid option value %s, all config values must be non-negative", changefeedbase.OptWebhookSinkConfig)
+	if cfg.Retry.Max < 0 || cfg.Retry.Backoff < 0 || cfg.Parallelism < 0 {
+		return batchCfg, retryCfg, parallelism, errors.Errorf("invalid option value %s, all config values must be non-negative", changefeedbase.OptWebhookSinkConfig)
 	}
-
-	// errors if other batch values are set, but frequency is not
-	if (cfg.Flush.Messages > 0 || cfg.Flush.Bytes > 0) && cfg.Flush.Frequency == 0 {
-		return batchCfg, retryCfg, errors.Errorf("invalid option value %s, flush frequency is not set, messages may never be sent", changefeedbase.OptWebhookSinkConfig)
+	if err = cfg.Flush.validate(); err != nil {
+		return batchCfg, retryCfg, parallelism, err
+	}
+	if s.topicBatchCfgs, err = s.getTopicBatchConfigs(cfg); err != nil {
+		return batchCfg, retryCfg, parallelism, err
 	}
 
 	retryCfg.MaxRetries = int(cfg.Retry.Max)
 	retryCfg.InitialBackoff = time.Duration(cfg.Retry.Backoff)
-	return cfg.Flush, retryCfg, nil
+	s.acknowledgeResolved = cfg.AcknowledgeResolved
+	return cfg.Flush, retryCfg, cfg.Parallelism, nil
+}
+
+// getTopicBatchConfigs returns the batch configs of the topics whose Flush is
+// overridden in the config, which are merged over the Flush of the sink.
+func (s *webhookSink) getTopicBatchConfigs(cfg webhookSinkConfig) (map[string]batchConfig, error) {
+	if len(cfg.Topics) == 0 {
+		return nil, nil
+	}
+	targetTopics := make(map[string]struct{})
+	if err := s.topics.Each(func(topic string) error {
+		targetTopics[topic] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	topicBatchCfgs := make(map[string]batchConfig, len(cfg.Topics))
+	for topic, override := range cfg.Topics {
+		if _, ok := targetTopics[topic]; !ok {
+			return nil, errors.Errorf(
+				"%s overrides the configuration of topic %q, which is not emitted to by the changefeed",
+				changefeedbase.OptWebhookSinkConfig, topic)
+		}
+		// The retries and the workers are shared by all the topics, so only the
+		// batching of a topic can be overridden.
+		topicCfg := struct{ Flush batchConfig }{Flush: cfg.Flush}
+		dec := json.NewDecoder(bytes.NewReader(override))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&topicCfg); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshalling the configuration of topic %q", topic)
+		}
+		if err := topicCfg.Flush.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid configuration of topic %q", topic)
+		}
+		topicBatchCfgs[topic] = topicCfg.Flush
+	}
+	return topicBatchCfgs, nil
 }
 
 func makeWebhookSink(
 	ctx context.Context,
 	u sinkURL,
+	targets changefeedbase.Targets,
 	encodingOpts changefeedbase.EncodingOptions,
 	opts changefeedbase.WebhookSinkOptions,
+	settings *cluster.Settings,
 	parallelism int,
 	source timeutil.TimeSource,
 	mb metricsRecorderBuilder,
+	tlsReloader *sinkTLSReloader,
 ) (Sink, error) {
 	if u.Scheme != changefeedbase.SinkSchemeWebhookHTTPS {
 		return nil, errors.Errorf(`this sink requires %s`, changefeedbase.SinkSchemeHTTPS)
@@ -282,6 +473,16 @@ func makeWebhookSink(
 	switch encodingOpts.Format {
 	case changefeedbase.OptFormatJSON:
 	case changefeedbase.OptFormatCSV:
+	case changefeedbase.OptFormatCloudEvents:
+		// The batches of CloudEvents can only hold structured events.
+		if encodingOpts.CloudEventsMode == changefeedbase.OptCloudEventsModeBinary {
+			return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+				changefeedbase.OptCloudEventsMode, encodingOpts.CloudEventsMode)
+		}
+	case changefeedbase.OptFormatParquet:
+		// The parquet files are compressed by the parquet writers (see
+		// parquetBatchingSink).
+		encodingOpts.Compression = ""
 	default:
 		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
 			changefeedbase.OptFormat, encodingOpts.Format)
@@ -302,6 +503,13 @@ func makeWebhookSink(
 		return nil, errors.Errorf(`this sink requires the WITH %s option`, changefeedbase.OptTopicInValue)
 	}
 
+	switch encodingOpts.Compression {
+	case "", changefeedbase.OptCompressionGzip, changefeedbase.OptCompressionZstd:
+	default:
+		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
+			changefeedbase.OptCompression, encodingOpts.Compression)
+	}
+
 	var connTimeout time.Duration
 	if opts.ClientTimeout != nil {
 		connTimeout = *opts.ClientTimeout
@@ -317,19 +525,55 @@ func makeWebhookSink(
 		ts:          source,
 		metrics:     mb(requiresResourceAccounting),
 		format:      encodingOpts.Format,
+		compression: encodingOpts.Compression,
+	}
+	if encodingOpts.Format == changefeedbase.OptFormatCSV && encodingOpts.CSV.Header {
+		sink.csvHeaders = makeCSVHeaders(encodingOpts)
 	}
 
 	var err error
-	sink.batchCfg, sink.retryCfg, err = sink.getWebhookSinkConfig(opts.JSONConfig)
+	// The topics are named like the topics of the messages (see
+	// changeAggregator).
+	if sink.topics, err = MakeTopicNamer(targets); err != nil {
+		return nil, err
+	}
+	var cfgParallelism int
+	var retryCfg retry.Options
+	sink.batchCfg, retryCfg, cfgParallelism, err = sink.getWebhookSinkConfig(opts.JSONConfig)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error processing option %s", changefeedbase.OptWebhookSinkConfig)
 	}
+	sink.retryCfg = makeSinkRetryPolicy(retryCfg, opts.Retry)
+	if sink.format == changefeedbase.OptFormatParquet {
+		// The messages are parquet files, which already batch the rows and are
+		// sent one per request.
+		sink.batchCfg, sink.topicBatchCfgs = batchConfig{}, nil
+	}
+	if cfgParallelism > 0 {
+		sink.parallelism = cfgParallelism
+	}
+
+	// The client of the sink is shared with the sinks of the other changefeeds
+	// which emit to the same endpoint with the same TLS configuration, unless
+	// their certificates are reloaded.
+	if tlsReloader == nil {
+		q := u.Query()
+		sink.settings = settings
+		sink.connectionKey = makeSinkConnectionKey(changefeedbase.SinkSchemeWebhookHTTPS, u.Host,
+			q.Get(changefeedbase.SinkParamSkipTLSVerify), q.Get(changefeedbase.SinkParamCACert),
+			q.Get(changefeedbase.SinkParamClientCert), q.Get(changefeedbase.SinkParamClientKey),
+			connTimeout.String())
+	}
 
 	// TODO(yevgeniy): Establish HTTP connection in Dial().
 	sink.client, err = makeWebhookClient(u, connTimeout)
 	if err != nil {
 		return nil, err
 	}
+	tlsConfig := sink.client.Transport.(*http.Transport).TLSClientConfig
+	if err := tlsReloader.apply(ctx, tlsConfig, true /* systemRoots */); err != nil {
+		return nil, err
+	}
 
 	// remove known query params from sink URL before setting in sink config
 	sinkURLParsed, err := url.Parse(u.String())
@@ -431,28 +675,52 @@ func defaultWorkerCount() int {
 }
 
 func (s *webhookSink) Dial() error {
+	if err := s.shareClient(); err != nil {
+		return err
+	}
 	s.setupWorkers()
 	return nil
 }
 
+// shareClient replaces the client of the sink with the client shared by the
+// sinks of other changefeeds with the same configuration, or shares the client
+// of the sink with them if they have none to share.
+func (s *webhookSink) shareClient() error {
+	if s.settings == nil {
+		return nil
+	}
+	client := s.client
+	conn, release, err := sinkConnections.acquire(s.settings, s.connectionKey,
+		func() (interface{}, func() error, error) {
+			// The connections of the client are reused by the requests of all
+			// the sinks sharing it.
+			client.Transport.(*http.Transport).MaxIdleConnsPerHost =
+				int(changefeedbase.SinkConnectionPoolMaxIdleConnsPerHost.Get(&s.settings.SV))
+			return client, func() error {
+				client.CloseIdleConnections()
+				return nil
+			}, nil
+		})
+	if err != nil {
+		return err
+	}
+	s.client, s.releaseClient = conn.(*httputil.Client), release
+	return nil
+}
+
 func (s *webhookSink) setupWorkers() {
 	// setup events channels to send to workers and the worker group
-	s.eventsChans = make([]chan []messagePayload, s.parallelism)
+	s.eventsChans = make([]chan webhookMessage, s.parallelism)
 	s.workerGroup = ctxgroup.WithContext(s.workerCtx)
-	s.batchChan = make(chan webhookMessage)
 
 	// an error channel with buffer for the first error.
 	s.errChan = make(chan error, 1)
 
-	// flushDone notified when flush completes.
-	s.flushDone = make(chan struct{})
+	// flushDone notified by each worker when flush completes.
+	s.flushDone = make(chan struct{}, s.parallelism)
 
-	s.workerGroup.GoCtx(func(ctx context.Context) error {
-		s.batchWorker()
-		return nil
-	})
 	for i := 0; i < s.parallelism; i++ {
-		s.eventsChans[i] = make(chan []messagePayload)
+		s.eventsChans[i] = make(chan webhookMessage, webhookWorkerBufferSize)
 		j := i
 		s.workerGroup.GoCtx(func(ctx context.Context) error {
 			s.workerLoop(j)
@@ -461,172 +729,219 @@ func (s *webhookSink) setupWorkers() {
 	}
 }
 
-func (s *webhookSink) shouldSendBatch(b batch) bool {
+func shouldSendBatch(cfg batchConfig, b batch) bool {
 	// similar to sarama, send batch if:
 	// everything is zero (default)
 	// any one of the conditions are met UNLESS the condition is zero which means never batch
 	switch {
 	// all zero values should batch every time, otherwise batch will wait forever
-	case s.batchCfg.Messages == 0 && s.batchCfg.Bytes == 0 && s.batchCfg.Frequency == 0:
+	case cfg.Messages == 0 && cfg.Bytes == 0 && cfg.Frequency == 0:
 		return true
 	// messages threshold has been reached
-	case s.batchCfg.Messages > 0 && len(b.buffer) >= s.batchCfg.Messages:
+	case cfg.Messages > 0 && len(b.buffer) >= cfg.Messages:
 		return true
 	// bytes threshold has been reached
-	case s.batchCfg.Bytes > 0 && b.bufferBytes >= s.batchCfg.Bytes:
+	case cfg.Bytes > 0 && b.bufferBytes >= cfg.Bytes:
 		return true
 	default:
 		return false
 	}
 }
 
-func (s *webhookSink) splitAndSendBatch(batch []messagePayload) error {
-	workerBatches := make([][]messagePayload, s.parallelism)
-	for _, msg := range batch {
-		// split batch into per-worker batches
-		i := s.workerIndex(msg.key)
-		workerBatches[i] = append(workerBatches[i], msg)
-	}
-	for i, workerBatch := range workerBatches {
-		// don't send empty batches
-		if len(workerBatch) > 0 {
-			select {
-			case <-s.workerCtx.Done():
-				return s.workerCtx.Err()
-			case s.eventsChans[i] <- workerBatch:
-			}
-		}
-	}
-	return nil
+// webhookBatch is a batch of a worker, which is sent once it is full according
+// to its config or once its deadline passes.
+type webhookBatch struct {
+	batch
+	cfg batchConfig
+	// deadline is the time by which the batch is sent, which is set when its
+	// first message is added if its config has a flush frequency.
+	deadline time.Time
 }
 
-// flushWorkers sends flush request to each worker and waits for each one to acknowledge.
-func (s *webhookSink) flushWorkers(done chan struct{}) error {
-	for i := 0; i < len(s.eventsChans); i++ {
-		// Ability to write a nil message to events channel indicates that
-		// the worker has processed all other messages.
-		select {
-		case <-s.workerCtx.Done():
-			return s.workerCtx.Err()
-		case s.eventsChans[i] <- nil:
-		}
-	}
-
-	select {
-	case <-s.workerCtx.Done():
-		return s.workerCtx.Err()
-	case done <- struct{}{}:
-		return nil
-	}
-}
-
-// batchWorker ingests messages from EmitRow into a batch and splits them into
-// per-worker batches to be sent separately
-func (s *webhookSink) batchWorker() {
-	var batchTracker batch
+// workerLoop ingests the messages assigned to the worker into a batch, and
+// sends the batch once it is full, its flush frequency elapses or a flush is
+// requested. Since the messages of a key are always assigned to the same
+// worker, which sends one batch at a time, the messages of each key are
+// delivered in order. The messages of the topics whose Flush is overridden
+// are ingested into a batch of their topic, with the config of the topic.
+func (s *webhookSink) workerLoop(workerIndex int) {
+	batches := map[string]*webhookBatch{"": {cfg: s.batchCfg}}
 	batchTimer := s.ts.NewTimer()
 	defer batchTimer.Stop()
+	// timerDeadline is the deadline the timer is set for, if any.
+	var timerDeadline time.Time
+
+	send := func(b *webhookBatch) bool {
+		if err := s.sendBatch(b.buffer); err != nil {
+			s.exitWorkersWithError(err)
+			return false
+		}
+		b.reset()
+		b.deadline = time.Time{}
+		return true
+	}
 
 	for {
 		select {
 		case <-s.workerCtx.Done():
 			return
-		case msg := <-s.batchChan:
-			flushRequested := msg.flushDone != nil
-
-			if !flushRequested {
-				batchTracker.addToBuffer(msg.payload)
-			}
-
-			if s.shouldSendBatch(batchTracker) || flushRequested {
-				if err := s.splitAndSendBatch(batchTracker.buffer); err != nil {
-					s.exitWorkersWithError(err)
+		case msg := <-s.eventsChans[workerIndex]:
+			if msg.flush {
+				for _, b := range batches {
+					if !send(b) {
+						return
+					}
+				}
+				// It's a flush request: if we read it, all the messages written
+				// before it have been sent.
+				select {
+				case <-s.workerCtx.Done():
 					return
+				case s.flushDone <- struct{}{}:
 				}
-				batchTracker.reset()
+				continue
+			}
 
-				if flushRequested {
-					if err := s.flushWorkers(*msg.flushDone); err != nil {
-						s.exitWorkersWithError(err)
-						return
-					}
+			b, ok := batches[msg.topic]
+			if !ok {
+				b = &webhookBatch{cfg: s.topicBatchCfgs[msg.topic]}
+				batches[msg.topic] = b
+			}
+			b.addToBuffer(msg.payload)
+			if shouldSendBatch(b.cfg, b.batch) {
+				if !send(b) {
+					return
 				}
-			} else {
-				if len(batchTracker.buffer) == 1 && time.Duration(s.batchCfg.Frequency) > 0 {
-					// only start timer when first message appears
-					batchTimer.Reset(time.Duration(s.batchCfg.Frequency))
+			} else if len(b.buffer) == 1 && time.Duration(b.cfg.Frequency) > 0 {
+				// only start timer when first message appears, unless the timer
+				// is already set to expire before the deadline of the batch.
+				b.deadline = s.ts.Now().Add(time.Duration(b.cfg.Frequency))
+				if timerDeadline.IsZero() || b.deadline.Before(timerDeadline) {
+					batchTimer.Reset(time.Duration(b.cfg.Frequency))
+					timerDeadline = b.deadline
 				}
 			}
-		// check the channel for time expiry. the batch should have at least one
-		// message in it. If it doesn't, the timer has been carried over from a
-		// previous batch, and the new batch will be empty so it won't send. If
-		// the new batch has at least one element, the timer will be reset so it'll
-		// be updated.
+		// check the channel for time expiry. the batches whose deadline passed
+		// are sent, and the timer is set for the earliest deadline of the other
+		// batches. If the timer has been carried over from a batch which was
+		// sent since, no batch is sent.
 		case <-batchTimer.Ch():
 			batchTimer.MarkRead()
-			if len(batchTracker.buffer) > 0 {
-				if err := s.splitAndSendBatch(batchTracker.buffer); err != nil {
-					s.exitWorkersWithError(err)
-					return
+			now := s.ts.Now()
+			timerDeadline = time.Time{}
+			for _, b := range batches {
+				if b.deadline.IsZero() {
+					continue
 				}
-				batchTracker.reset()
+				if !now.Before(b.deadline) {
+					if !send(b) {
+						return
+					}
+				} else if timerDeadline.IsZero() || b.deadline.Before(timerDeadline) {
+					timerDeadline = b.deadline
+				}
+			}
+			if !timerDeadline.IsZero() {
+				batchTimer.Reset(timerDeadline.Sub(now))
 			}
 		}
 	}
 }
 
-func (s *webhookSink) workerLoop(workerIndex int) {
-	for {
-		select {
-		case <-s.workerCtx.Done():
-			return
-		case msgs := <-s.eventsChans[workerIndex]:
-			if msgs == nil {
-				// It's a flush request: if we read it, it means all outstanding
-				// requests for this worker have been completed.
-				continue
-			}
+// sendBatch encodes the messages of the batch into a single request and sends
+// it. Empty batches are not sent.
+func (s *webhookSink) sendBatch(msgs []messagePayload) error {
+	if len(msgs) == 0 {
+		return nil
+	}
 
-			var encoded encodedPayload
-			var err error
-			switch s.format {
-			case changefeedbase.OptFormatJSON:
-				encoded, err = encodePayloadJSONWebhook(msgs)
-			case changefeedbase.OptFormatCSV:
-				encoded, err = encodePayloadCSVWebhook(msgs)
-			}
-			if err != nil {
-				s.exitWorkersWithError(err)
-				return
-			}
-			if err := s.sendMessageWithRetries(s.workerCtx, encoded.data); err != nil {
-				s.exitWorkersWithError(err)
-				return
-			}
-			encoded.alloc.Release(s.workerCtx)
-			s.metrics.recordEmittedBatch(
-				encoded.emitTime, len(msgs), encoded.mvcc, len(encoded.data), sinkDoesNotCompress)
+	var encoded encodedPayload
+	var err error
+	switch s.format {
+	case changefeedbase.OptFormatJSON:
+		encoded, err = encodePayloadJSONWebhook(msgs)
+	case changefeedbase.OptFormatCSV:
+		encoded, err = encodePayloadCSVWebhook(msgs)
+	case changefeedbase.OptFormatCloudEvents:
+		encoded, err = encodePayloadCloudEventsWebhook(msgs)
+	case changefeedbase.OptFormatParquet:
+		encoded, err = encodePayloadParquetWebhook(msgs)
+	}
+	if err != nil {
+		return err
+	}
+	reqBody, compressedBytes := encoded.data, sinkDoesNotCompress
+	if s.compression != "" {
+		if reqBody, err = compressPayload(s.compression, encoded.data); err != nil {
+			return err
 		}
+		compressedBytes = len(reqBody)
+	}
+	if err := s.sendMessageWithRetries(s.workerCtx, s.contentType(), reqBody); err != nil {
+		return err
+	}
+	encoded.alloc.Release(s.workerCtx)
+	s.metrics.recordEmittedBatch(
+		encoded.emitTime, len(msgs), encoded.mvcc, len(encoded.data), compressedBytes)
+	return nil
+}
+
+// compressPayload compresses the body of a request with the specified codec.
+func compressPayload(codec string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newCompressionWriter(codec, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
 	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-func (s *webhookSink) sendMessageWithRetries(ctx context.Context, reqBody []byte) error {
+func (s *webhookSink) sendMessageWithRetries(
+	ctx context.Context, contentType string, reqBody []byte,
+) error {
+	s.metrics.recordInFlightBatchChange(1)
+	defer s.metrics.recordInFlightBatchChange(-1)
+
+	var attempts int
 	requestFunc := func() error {
-		return s.sendMessage(ctx, reqBody)
+		if attempts > 0 {
+			s.metrics.recordSinkRetry()
+		}
+		attempts++
+		return s.sendMessage(ctx, contentType, reqBody)
+	}
+	return s.retryCfg.do(ctx, requestFunc)
+}
+
+// contentType returns the content type of the requests holding the rows.
+func (s *webhookSink) contentType() string {
+	switch s.format {
+	case changefeedbase.OptFormatCSV:
+		return applicationTypeCSV
+	case changefeedbase.OptFormatCloudEvents:
+		return applicationTypeCloudEventsBatch
+	case changefeedbase.OptFormatParquet:
+		return applicationTypeParquet
+	default:
+		return applicationTypeJSON
 	}
-	return retry.WithMaxAttempts(ctx, s.retryCfg, s.retryCfg.MaxRetries+1, requestFunc)
 }
 
-func (s *webhookSink) sendMessage(ctx context.Context, reqBody []byte) error {
+func (s *webhookSink) sendMessage(ctx context.Context, contentType string, reqBody []byte) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url.String(), bytes.NewReader(reqBody))
 	if err != nil {
 		return err
 	}
-	switch s.format {
-	case changefeedbase.OptFormatJSON:
-		req.Header.Set("Content-Type", applicationTypeJSON)
-	case changefeedbase.OptFormatCSV:
-		req.Header.Set("Content-Type", applicationTypeCSV)
+	req.Header.Set("Content-Type", contentType)
+
+	if s.compression != "" {
+		req.Header.Set(contentEncodingHeader, s.compression)
 	}
 
 	if s.authHeader != "" {
@@ -645,11 +960,56 @@ func (s *webhookSink) sendMessage(ctx context.Context, reqBody []byte) error {
 		if err != nil {
 			return errors.Wrapf(err, "failed to read body for HTTP response with status: %d", res.StatusCode)
 		}
-		return fmt.Errorf("%s: %s", res.Status, string(resBody))
+		return &webhookError{status: res.Status, statusCode: res.StatusCode, body: string(resBody)}
+	}
+	if s.acknowledgeResolved {
+		return s.recordAcknowledged(res.Header.Get(acknowledgedResolvedHeader))
+	}
+	return nil
+}
+
+// recordAcknowledged records the resolved timestamp acknowledged by a response
+// of the endpoint, if any.
+func (s *webhookSink) recordAcknowledged(header string) error {
+	if header == `` {
+		return nil
 	}
+	acked, err := hlc.ParseHLC(header)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s header %q", acknowledgedResolvedHeader, header)
+	}
+	s.acknowledged.Lock()
+	defer s.acknowledged.Unlock()
+	// The endpoint cannot have applied the rows of resolved timestamps which
+	// were not emitted to it yet.
+	acked.Backward(s.acknowledged.emitted)
+	s.acknowledged.resolved.Forward(acked)
 	return nil
 }
 
+// AcknowledgedResolved implements the AcknowledgingSink interface.
+func (s *webhookSink) AcknowledgedResolved() (hlc.Timestamp, bool) {
+	s.acknowledged.Lock()
+	defer s.acknowledged.Unlock()
+	return s.acknowledged.resolved, s.acknowledgeResolved
+}
+
+// webhookError is the error of a request which the webhook endpoint did not
+// accept.
+type webhookError struct {
+	status     string
+	statusCode int
+	body       string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("%s: %s", e.status, e.body)
+}
+
+func (e *webhookError) httpStatusCode() int {
+	return e.statusCode
+}
+
 // workerIndex assigns rows each to a worker goroutine based on the hash of its
 // primary key. This is to ensure that each message with the same key gets
 // deterministically assigned to the same worker. Since we have a channel per
@@ -688,6 +1048,23 @@ func (s *webhookSink) EmitRow(
 	updated, mvcc hlc.Timestamp,
 	alloc kvevent.Alloc,
 ) error {
+	var csvHeader []byte
+	if s.csvHeaders != nil {
+		var err error
+		if csvHeader, err = s.csvHeaders.headerOf(topic); err != nil {
+			return err
+		}
+	}
+	var batchTopic string
+	if len(s.topicBatchCfgs) > 0 {
+		name, err := s.topics.Name(topic)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.topicBatchCfgs[name]; ok {
+			batchTopic = name
+		}
+	}
 	select {
 	// check the webhook sink context in case workers have been terminated
 	case <-s.workerCtx.Done():
@@ -698,14 +1075,17 @@ func (s *webhookSink) EmitRow(
 		return ctx.Err()
 	case err := <-s.errChan:
 		return err
-	case s.batchChan <- webhookMessage{
+	case s.eventsChans[s.workerIndex(key)] <- webhookMessage{
 		payload: messagePayload{
-			key:      key,
-			val:      value,
-			alloc:    alloc,
-			emitTime: timeutil.Now(),
-			mvcc:     mvcc,
-		}}:
+			key:       key,
+			val:       value,
+			alloc:     alloc,
+			emitTime:  timeutil.Now(),
+			mvcc:      mvcc,
+			csvHeader: csvHeader,
+		},
+		topic: batchTopic,
+	}:
 		s.metrics.recordMessageSize(int64(len(key) + len(value)))
 	}
 	return nil
@@ -720,6 +1100,15 @@ func (s *webhookSink) EmitResolvedTimestamp(
 	if err != nil {
 		return err
 	}
+	if s.format == changefeedbase.OptFormatCloudEvents {
+		// Every request holds a batch of CloudEvents.
+		payload = append(append([]byte{'['}, payload...), ']')
+	}
+	if s.compression != "" {
+		if payload, err = compressPayload(s.compression, payload); err != nil {
+			return err
+		}
+	}
 
 	select {
 	// check the webhook sink context in case workers have been terminated
@@ -731,10 +1120,21 @@ func (s *webhookSink) EmitResolvedTimestamp(
 	default:
 	}
 
+	if s.acknowledgeResolved {
+		s.acknowledged.Lock()
+		s.acknowledged.emitted.Forward(resolved)
+		s.acknowledged.Unlock()
+	}
+
 	// do worker logic directly here instead (there's no point using workers for
 	// resolved timestamps since there are no keys and everything must be
 	// in order)
-	if err := s.sendMessageWithRetries(ctx, payload); err != nil {
+	contentType := s.contentType()
+	if s.format == changefeedbase.OptFormatParquet || s.format == changefeedbase.OptFormatCSV {
+		// The resolved timestamps are encoded in JSON.
+		contentType = applicationTypeJSON
+	}
+	if err := s.sendMessageWithRetries(ctx, contentType, payload); err != nil {
 		s.exitWorkersWithError(err)
 		return err
 	}
@@ -745,35 +1145,42 @@ func (s *webhookSink) EmitResolvedTimestamp(
 func (s *webhookSink) Flush(ctx context.Context) error {
 	s.metrics.recordFlushRequestCallback()()
 
-	// Send flush request.
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-s.errChan:
-		return err
-	case s.batchChan <- webhookMessage{flushDone: &s.flushDone}:
+	// Send flush request to each worker.
+	for _, eventsChan := range s.eventsChans {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-s.errChan:
+			return err
+		case eventsChan <- webhookMessage{flush: true}:
+		}
 	}
 
-	// Wait for flush completion -- or an error.
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-s.errChan:
-		return err
-	case <-s.flushDone:
-		return s.sinkError()
+	// Wait for flush completion of each worker -- or an error.
+	for range s.eventsChans {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-s.errChan:
+			return err
+		case <-s.flushDone:
+		}
 	}
+	return s.sinkError()
 }
 
 func (s *webhookSink) Close() error {
 	s.exitWorkers()
 	// ignore errors here since we're closing the sink anyway
 	_ = s.workerGroup.Wait()
-	close(s.batchChan)
 	close(s.errChan)
 	for _, eventsChan := range s.eventsChans {
 		close(eventsChan)
 	}
-	s.client.CloseIdleConnections()
+	if s.releaseClient != nil {
+		_ = s.releaseClient()
+	} else {
+		s.client.CloseIdleConnections()
+	}
 	return nil
 }
@@ -0,0 +1,65 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn/connectionpb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+func parseAndValidateWebhookSinkURI(
+	ctx context.Context, execCfg interface{}, _ username.SQLUsername, uri *url.URL,
+) (externalconn.ExternalConnection, error) {
+	// Validate the webhook URI, including its CA and client certificates, by
+	// creating a webhook sink and throwing it away. No request is sent until a
+	// changefeed dials the sink.
+	encodingOpts := changefeedbase.EncodingOptions{
+		Format:       changefeedbase.OptFormatJSON,
+		Envelope:     changefeedbase.OptEnvelopeWrapped,
+		KeyInValue:   true,
+		TopicInValue: true,
+	}
+	// The sink strips the webhook- prefix of the scheme of its URL, so it
+	// is given a copy.
+	sinkURI := *uri
+	tlsReloader, err := makeConnectionTLSReloader(execCfg, &sinkURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Webhook URI")
+	}
+	s, err := makeWebhookSink(ctx, sinkURL{URL: &sinkURI}, changefeedbase.Targets{}, encodingOpts,
+		changefeedbase.WebhookSinkOptions{}, nil /* settings */, defaultWorkerCount(), timeutil.DefaultTimeSource{},
+		nilMetricsRecorderBuilder, tlsReloader)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Webhook URI")
+	}
+	// The sink was never dialed, so it has no workers to wait for.
+	s.(*webhookSink).exitWorkers()
+
+	connDetails := connectionpb.ConnectionDetails{
+		Provider: connectionpb.ConnectionProvider_webhook,
+		Details: &connectionpb.ConnectionDetails_SimpleURI{
+			SimpleURI: &connectionpb.SimpleURI{
+				URI: uri.String(),
+			},
+		},
+	}
+	return externalconn.NewExternalConnection(connDetails), nil
+}
+
+func init() {
+	externalconn.RegisterConnectionDetailsFromURIFactory(changefeedbase.SinkSchemeWebhookHTTPS,
+		parseAndValidateWebhookSinkURI)
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/utilccl"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSinkExternalConnection(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, stopServer := makeServer(t)
+	defer stopServer()
+
+	sqlDB := sqlutils.MakeSQLRunner(s.DB)
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+	sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+	enableEnterprise := utilccl.TestingDisableEnterprise()
+	enableEnterprise()
+
+	// The endpoint requires the clients to present a certificate.
+	cert, _, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSinkSecure(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+
+	clientCertPEM, clientKeyPEM, err := cdctest.GenerateClientCertAndKey(cert)
+	require.NoError(t, err)
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamSkipTLSVerify, "true")
+	params.Set(changefeedbase.SinkParamClientCert, base64.StdEncoding.EncodeToString(clientCertPEM))
+	params.Set(changefeedbase.SinkParamClientKey, base64.StdEncoding.EncodeToString(clientKeyPEM))
+	sinkDestHost.RawQuery = params.Encode()
+
+	sqlDB.ExpectErr(
+		t, `invalid Webhook URI: client_cert requires client_key to be set`,
+		`CREATE EXTERNAL CONNECTION nope AS 'webhook-https://nope/?client_cert=Zm9v'`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid Webhook URI: invalid client certificate data provided`,
+		`CREATE EXTERNAL CONNECTION nope AS 'webhook-https://nope/?client_cert=Zm9v&client_key=Zm9v'`,
+	)
+
+	// The changefeeds emitting to the external connection use its client
+	// certificate, and are opted in to key_in_value and topic_in_value like
+	// the changefeeds emitting to webhook URIs.
+	sqlDB.Exec(t, fmt.Sprintf(`CREATE EXTERNAL CONNECTION webhook AS 'webhook-%s'`, sinkDestHost.String()))
+	var jobID int
+	sqlDB.QueryRow(t,
+		`CREATE CHANGEFEED FOR foo INTO 'external://webhook' WITH webhook_client_timeout='30s'`,
+	).Scan(&jobID)
+	defer sqlDB.Exec(t, `CANCEL JOB $1`, jobID)
+
+	testutils.SucceedsSoon(t, func() error {
+		if sinkDest.Latest() == "" {
+			return errors.New("waiting for the webhook sink to receive the row")
+		}
+		return nil
+	})
+	payload := sinkDest.Pop()
+	require.Contains(t, payload, `"after": {"a": 1}`)
+	require.Contains(t, payload, `"key": [1]`)
+	require.Contains(t, payload, `"topic": "foo"`)
+}
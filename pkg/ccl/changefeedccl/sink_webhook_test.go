@@ -11,6 +11,7 @@ package changefeedccl
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -83,7 +84,8 @@ func setupWebhookSinkWithDetails(
 	if err != nil {
 		return nil, err
 	}
-	sinkSrc, err := makeWebhookSink(ctx, sinkURL{URL: u}, encodingOpts, sinkOpts, parallelism, source, nilMetricsRecorderBuilder)
+	sinkSrc, err := makeWebhookSink(ctx, sinkURL{URL: u}, AllTargets(details), encodingOpts, sinkOpts,
+		nil /* settings */, parallelism, source, nilMetricsRecorderBuilder, nil /* tlsReloader */)
 	if err != nil {
 		return nil, err
 	}
@@ -253,9 +255,23 @@ func TestWebhookSink(t *testing.T) {
 			value string
 		}{changefeedbase.OptWebhookSinkConfig,
 			`{"Retry":{"Backoff": "5ms"},"Flush":{"Bytes": 0, "Frequency": "0s", "Messages": 0}}`})
+	// The mock sink decompresses the requests according to their content
+	// coding.
+	optsGzip := getGenericWebhookSinkOptions(
+		struct {
+			key   string
+			value string
+		}{changefeedbase.OptCompression, changefeedbase.OptCompressionGzip})
+	optsZstd := getGenericWebhookSinkOptions(
+		struct {
+			key   string
+			value string
+		}{changefeedbase.OptCompression, changefeedbase.OptCompressionZstd})
 	for i := 1; i <= 4; i++ {
 		webhookSinkTestfn(i, opts.AsMap())
 		webhookSinkTestfn(i, optsZeroValueConfig.AsMap())
+		webhookSinkTestfn(i, optsGzip.AsMap())
+		webhookSinkTestfn(i, optsZstd.AsMap())
 	}
 }
 
@@ -459,6 +475,58 @@ func TestWebhookSinkConfig(t *testing.T) {
 		sinkDest.Close()
 	}
 
+	retryOptionsFn := func(parallelism int, statusCode int, expectedCalls int) {
+		// the sink_retry options override the Retry of the sink config
+		opts := getGenericWebhookSinkOptions(
+			struct {
+				key   string
+				value string
+			}{key: changefeedbase.OptSinkRetryMaxAttempts, value: "3"},
+			struct {
+				key   string
+				value string
+			}{key: changefeedbase.OptSinkRetryBackoff, value: "5ms"},
+			struct {
+				key   string
+				value string
+			}{key: changefeedbase.OptSinkRetryOn, value: string(changefeedbase.OptSinkRetryOnTransient)},
+		)
+		cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+		require.NoError(t, err)
+		sinkDest, err := cdctest.StartMockWebhookSink(cert)
+		require.NoError(t, err)
+
+		// error out indefinitely
+		sinkDest.SetStatusCodes([]int{statusCode})
+
+		sinkDestHost, err := url.Parse(sinkDest.URL())
+		require.NoError(t, err)
+
+		params := sinkDestHost.Query()
+		params.Set(changefeedbase.SinkParamCACert, certEncoded)
+		sinkDestHost.RawQuery = params.Encode()
+
+		details := jobspb.ChangefeedDetails{
+			SinkURI: fmt.Sprintf("webhook-%s", sinkDestHost.String()),
+			Opts:    opts.AsMap(),
+		}
+
+		sinkSrc, err := setupWebhookSinkWithDetails(context.Background(), details, parallelism, timeutil.DefaultTimeSource{})
+		require.NoError(t, err)
+
+		require.NoError(t, sinkSrc.EmitRow(context.Background(), nil, []byte("[1001]"), []byte("{\"after\":{\"col1\":\"val1\",\"rowid\":1000},\"key\":[1001],\"topic:\":\"foo\"}"), zeroTS, zeroTS, pool.alloc()))
+
+		require.EqualError(t, sinkSrc.Flush(context.Background()),
+			fmt.Sprintf("%d %s: ", statusCode, http.StatusText(statusCode)))
+
+		// transient errors are retried up to the maximum number of attempts,
+		// and the other errors are returned right away
+		require.Equal(t, expectedCalls, sinkDest.GetNumCalls())
+
+		require.NoError(t, sinkSrc.Close())
+		sinkDest.Close()
+	}
+
 	largeBatchSizeFn := func(parallelism int) {
 		opts := getGenericWebhookSinkOptions(struct {
 			key   string
@@ -638,6 +706,8 @@ func TestWebhookSinkConfig(t *testing.T) {
 		retryThenSuccessFn(i)
 		retryThenFailureDefaultFn(i)
 		retryThenFailureCustomFn(i)
+		retryOptionsFn(i, http.StatusServiceUnavailable, 3)
+		retryOptionsFn(i, http.StatusUnauthorized, 1)
 		largeBatchSizeFn(i)
 		largeBatchBytesFn(i)
 		largeBatchFrequencyFn(i)
@@ -690,3 +760,293 @@ func TestWebhookSinkShutsDownOnError(t *testing.T) {
 		webhookSinkTestfn(i)
 	}
 }
+
+func TestWebhookSinkAcknowledgedResolved(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamCACert, certEncoded)
+	sinkDestHost.RawQuery = params.Encode()
+
+	makeSink := func(config string) Sink {
+		opts := getGenericWebhookSinkOptions(struct {
+			key   string
+			value string
+		}{key: changefeedbase.OptWebhookSinkConfig, value: config})
+		details := jobspb.ChangefeedDetails{
+			SinkURI: fmt.Sprintf("webhook-%s", sinkDestHost.String()),
+			Opts:    opts.AsMap(),
+		}
+		sink, err := setupWebhookSinkWithDetails(ctx, details, 1 /* parallelism */, timeutil.DefaultTimeSource{})
+		require.NoError(t, err)
+		return sink
+	}
+	encodingOpts, err := getGenericWebhookSinkOptions().GetEncodingOptions()
+	require.NoError(t, err)
+	enc, err := makeJSONEncoder(encodingOpts, changefeedbase.Targets{})
+	require.NoError(t, err)
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	// The sinks do not require acknowledgements by default.
+	sink := makeSink(`{"Retry":{"Backoff": "5ms"}}`)
+	_, requiresAck := sink.(AcknowledgingSink).AcknowledgedResolved()
+	require.False(t, requiresAck)
+	require.NoError(t, sink.Close())
+
+	sink = makeSink(`{"Retry":{"Backoff": "5ms"},"AcknowledgeResolved": true}`)
+	defer func() { require.NoError(t, sink.Close()) }()
+	requireAcked := func(expected hlc.Timestamp) {
+		acked, requiresAck := sink.(AcknowledgingSink).AcknowledgedResolved()
+		require.True(t, requiresAck)
+		require.Equal(t, expected, acked)
+	}
+	requireAcked(hlc.Timestamp{})
+
+	// The endpoint acknowledges the resolved timestamps with its responses,
+	// but cannot acknowledge the resolved timestamps which were not emitted.
+	sinkDest.SetResponseHeader(acknowledgedResolvedHeader, `1.0000000000`)
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, enc, ts(2)))
+	requireAcked(ts(1))
+	sinkDest.SetResponseHeader(acknowledgedResolvedHeader, `3.0000000000`)
+	require.NoError(t, sink.EmitRow(ctx, nil, []byte("[1001]"), []byte("{\"after\":null,\"key\":[1001],\"topic:\":\"foo\"}"), zeroTS, zeroTS, zeroAlloc))
+	require.NoError(t, sink.Flush(ctx))
+	requireAcked(ts(2))
+
+	// The acknowledged resolved timestamp does not regress.
+	sinkDest.SetResponseHeader(acknowledgedResolvedHeader, `1.0000000000`)
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, enc, ts(4)))
+	requireAcked(ts(2))
+
+	sinkDest.SetResponseHeader(acknowledgedResolvedHeader, `foo`)
+	require.Regexp(t, `invalid Changefeed-Acknowledged-Resolved header "foo"`,
+		sink.EmitResolvedTimestamp(ctx, enc, ts(5)))
+}
+
+func TestWebhookSinkParallelism(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamCACert, certEncoded)
+	sinkDestHost.RawQuery = params.Encode()
+	u, err := url.Parse(fmt.Sprintf("webhook-%s", sinkDestHost.String()))
+	require.NoError(t, err)
+
+	opts := getGenericWebhookSinkOptions(struct {
+		key   string
+		value string
+	}{
+		key:   changefeedbase.OptWebhookSinkConfig,
+		value: `{"Retry":{"Backoff": "5ms"},"Flush":{"Messages": 100, "Frequency": "1h"},"Parallelism": 3}`,
+	})
+	encodingOpts, err := opts.GetEncodingOptions()
+	require.NoError(t, err)
+	sinkOpts, err := opts.GetWebhookSinkOptions()
+	require.NoError(t, err)
+
+	metrics, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	sinkSrc, err := makeWebhookSink(ctx, sinkURL{URL: u}, changefeedbase.Targets{}, encodingOpts, sinkOpts,
+		nil /* settings */, 1 /* parallelism */, timeutil.DefaultTimeSource{},
+		func(bool) metricsRecorder { return metrics }, nil /* tlsReloader */)
+	require.NoError(t, err)
+	require.NoError(t, sinkSrc.Dial())
+	defer func() { require.NoError(t, sinkSrc.Close()) }()
+
+	// The parallelism of the config overrides the default one.
+	require.Equal(t, 3, sinkSrc.(*webhookSink).parallelism)
+
+	const numKeys, numRows = 6, 10
+	var pool testAllocPool
+	for i := 0; i < numRows; i++ {
+		for k := 0; k < numKeys; k++ {
+			key := fmt.Sprintf("[%d]", k)
+			value := fmt.Sprintf(`{"after":{"a":%d,"i":%d},"key":%s,"topic:":"foo"}`, k, i, key)
+			require.NoError(t, sinkSrc.EmitRow(ctx, nil, []byte(key), []byte(value), zeroTS, zeroTS, pool.alloc()))
+		}
+	}
+	require.NoError(t, sinkSrc.Flush(ctx))
+	require.EqualValues(t, 0, pool.used())
+
+	// Each worker sent its messages in a single batch, and the messages of each
+	// key were sent in order.
+	require.LessOrEqual(t, sinkDest.GetNumCalls(), 3)
+	rows := make(map[int][]int)
+	for payload := sinkDest.Pop(); payload != ""; payload = sinkDest.Pop() {
+		var body struct {
+			Payload []struct {
+				After struct{ A, I int }
+			}
+		}
+		require.NoError(t, json.Unmarshal([]byte(payload), &body))
+		for _, p := range body.Payload {
+			rows[p.After.A] = append(rows[p.After.A], p.After.I)
+		}
+	}
+	require.Len(t, rows, numKeys)
+	for k := 0; k < numKeys; k++ {
+		require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, rows[k], "key %d", k)
+	}
+
+	// The retried requests are counted, and no batch is left in flight.
+	sinkDest.SetStatusCodes([]int{http.StatusInternalServerError, http.StatusOK})
+	require.NoError(t, sinkSrc.EmitRow(ctx, nil, []byte("[1]"),
+		[]byte(`{"after":{"a":1,"i":10},"key":[1],"topic:":"foo"}`), zeroTS, zeroTS, pool.alloc()))
+	require.NoError(t, sinkSrc.Flush(ctx))
+	require.EqualValues(t, 1, metrics.SinkRetries.Value())
+	require.EqualValues(t, 0, metrics.InFlightBatches.Value())
+}
+
+func TestWebhookSinkTopicConfigOverrides(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamCACert, certEncoded)
+	sinkDestHost.RawQuery = params.Encode()
+
+	mt := timeutil.NewManualTime(timeutil.Now())
+	makeSink := func(config string) (Sink, error) {
+		u, err := url.Parse(fmt.Sprintf("webhook-%s", sinkDestHost.String()))
+		require.NoError(t, err)
+		opts := getGenericWebhookSinkOptions(struct {
+			key   string
+			value string
+		}{key: changefeedbase.OptWebhookSinkConfig, value: config})
+		encodingOpts, err := opts.GetEncodingOptions()
+		require.NoError(t, err)
+		sinkOpts, err := opts.GetWebhookSinkOptions()
+		require.NoError(t, err)
+		return makeWebhookSink(ctx, sinkURL{URL: u}, makeChangefeedTargets(`t1`, `t2`), encodingOpts, sinkOpts,
+			nil /* settings */, 1 /* parallelism */, mt, nilMetricsRecorderBuilder, nil /* tlsReloader */)
+	}
+
+	t.Run("validates topic configurations", func(t *testing.T) {
+		_, err := makeSink(`{"Topics": {"t3": {"Flush": {"Messages": 1}}}}`)
+		require.Regexp(t, `overrides the configuration of topic "t3", which is not emitted to`, err)
+		_, err = makeSink(`{"Topics": {"t1": {"Retry": {"Max": 1}}}}`)
+		require.Regexp(t, `unknown field "Retry"`, err)
+		_, err = makeSink(`{"Topics": {"t1": {"Flush": {"Messages": 5}}}}`)
+		require.Regexp(t, `invalid configuration of topic "t1".*flush frequency is not set`, err)
+	})
+
+	t.Run("each topic is batched with its own configuration", func(t *testing.T) {
+		sinkSrc, err := makeSink(`{
+			"Retry": {"Backoff": "5ms"},
+			"Flush": {"Messages": 2, "Frequency": "1h"},
+			"Topics": {"t2": {"Flush": {"Messages": 10, "Frequency": "2h"}}}
+		}`)
+		require.NoError(t, err)
+		require.NoError(t, sinkSrc.Dial())
+		defer func() { require.NoError(t, sinkSrc.Close()) }()
+
+		var pool testAllocPool
+		emit := func(topicName string, i int) {
+			value := fmt.Sprintf(`{"after":{"i":%d},"key":[1],"topic":%q}`, i, topicName)
+			require.NoError(t, sinkSrc.EmitRow(
+				ctx, topic(topicName), []byte("[1]"), []byte(value), zeroTS, zeroTS, pool.alloc()))
+		}
+		// nextBatch waits for the next batch received by the endpoint and
+		// returns the topics of its rows.
+		nextBatch := func() []string {
+			var payload string
+			testutils.SucceedsSoon(t, func() error {
+				if payload = sinkDest.Pop(); payload == "" {
+					return errors.New("waiting for a batch")
+				}
+				return nil
+			})
+			var body struct {
+				Payload []struct{ Topic string }
+			}
+			require.NoError(t, json.Unmarshal([]byte(payload), &body))
+			var topics []string
+			for _, p := range body.Payload {
+				topics = append(topics, p.Topic)
+			}
+			return topics
+		}
+		waitForTimer := func(d time.Duration) {
+			testutils.SucceedsSoon(t, func() error {
+				if timers := mt.Timers(); len(timers) == 1 && timers[0] == mt.Now().Add(d) {
+					return nil
+				}
+				return errors.New("waiting for the timer of the batch worker")
+			})
+		}
+
+		// The batch of t1 is sent once it holds 2 messages, while t2 keeps
+		// batching its messages.
+		emit(`t2`, 0)
+		emit(`t1`, 1)
+		emit(`t1`, 2)
+		require.Equal(t, []string{`t1`, `t1`}, nextBatch())
+
+		// The flush frequency of t1 does not apply to the batch of t2.
+		waitForTimer(time.Hour)
+		mt.Advance(time.Hour)
+		waitForTimer(time.Hour)
+		require.Equal(t, "", sinkDest.Latest())
+
+		// The batch of t2 is sent once its own flush frequency elapses.
+		mt.Advance(time.Hour)
+		require.Equal(t, []string{`t2`}, nextBatch())
+
+		// A flush sends the batches of all the topics.
+		emit(`t1`, 3)
+		emit(`t2`, 4)
+		require.NoError(t, sinkSrc.Flush(ctx))
+		require.ElementsMatch(t, []string{`t1`, `t2`}, append(nextBatch(), nextBatch()...))
+		require.EqualValues(t, 0, pool.used())
+	})
+}
+
+func TestWebhookSinkCSVPayload(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	msg := func(header, val string) messagePayload {
+		m := messagePayload{val: []byte(val), emitTime: timeutil.Now()}
+		if header != `` {
+			m.csvHeader = []byte(header)
+		}
+		return m
+	}
+
+	// The records are separated by newlines.
+	encoded, err := encodePayloadCSVWebhook([]messagePayload{msg(``, `1,a`), msg(``, `2,b`)})
+	require.NoError(t, err)
+	require.Equal(t, "1,a\n2,b", string(encoded.data))
+
+	// The header rows precede the first record of the batch, and the records
+	// of another table.
+	encoded, err = encodePayloadCSVWebhook([]messagePayload{
+		msg(`id,name`, `1,a`), msg(`id,name`, `2,b`), msg(`id,value`, `1,1.5`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "id,name\n1,a\n2,b\nid,value\n1,1.5", string(encoded.data))
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/cockroachdb/cockroach-go/v2/crdb"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/kv"
@@ -1218,6 +1219,20 @@ func (s *fakeKafkaSink) Dial() error {
 	return kafka.Dial()
 }
 
+// EmitRowWithHeaders implements the HeaderedEventSink interface.
+func (s *fakeKafkaSink) EmitRowWithHeaders(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+	headers []messageHeader,
+) error {
+	return s.Sink.(HeaderedEventSink).EmitRowWithHeaders(
+		ctx, topic, key, value, updated, mvcc, alloc, partition, headers)
+}
+
 func (s *fakeKafkaSink) Topics() []string {
 	if sink, ok := s.Sink.(*kafkaSink); ok {
 		return sink.Topics()
@@ -1418,6 +1433,12 @@ func (k *kafkaFeed) Next() (*cdctest.TestFeedMessage, error) {
 		if err := decode(msg.Key, &fm.Key); err != nil {
 			return nil, err
 		}
+		if len(msg.Headers) > 0 {
+			fm.Headers = make(map[string]string, len(msg.Headers))
+			for _, h := range msg.Headers {
+				fm.Headers[string(h.Key)] = string(h.Value)
+			}
+		}
 		if err := decode(msg.Value, &fm.Value); err != nil {
 			return nil, err
 		}
@@ -1661,7 +1682,9 @@ func (f *webhookFeed) Close() error {
 }
 
 type mockPubsubMessage struct {
-	data string
+	data        string
+	orderingKey string
+	attributes  map[string]string
 	// TODO: implement error injection
 	// err error
 }
@@ -1701,8 +1724,10 @@ func (p *fakePubsubClient) closeTopics() {
 }
 
 // sendMessage sends a message to the topic
-func (p *fakePubsubClient) sendMessage(m []byte, _ string, _ string) error {
-	message := mockPubsubMessage{data: string(m)}
+func (p *fakePubsubClient) sendMessage(
+	m []byte, _ string, orderingKey string, attributes map[string]string,
+) error {
+	message := mockPubsubMessage{data: string(m), orderingKey: orderingKey, attributes: attributes}
 	p.buffer.push(message)
 	return nil
 }
@@ -1731,6 +1756,20 @@ func (p *fakePubsubSink) Dial() error {
 	return nil
 }
 
+// EmitRowWithHeaders implements the HeaderedEventSink interface.
+func (p *fakePubsubSink) EmitRowWithHeaders(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+	partition int32,
+	headers []messageHeader,
+) error {
+	return p.Sink.(HeaderedEventSink).EmitRowWithHeaders(
+		ctx, topic, key, value, updated, mvcc, alloc, partition, headers)
+}
+
 func (p *fakePubsubSink) Flush(ctx context.Context) error {
 	defer p.sync.addFlush()
 	return p.Sink.Flush(ctx)
@@ -1873,6 +1912,9 @@ func (p *pubsubFeed) Next() (*cdctest.TestFeedMessage, error) {
 					if err != nil {
 						return nil, err
 					}
+					if len(msg.attributes) > 0 {
+						m.Headers = msg.attributes
+					}
 					if isNew := p.markSeen(m); !isNew {
 						continue
 					}
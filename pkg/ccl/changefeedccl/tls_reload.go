@@ -0,0 +1,267 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// sinkURISource returns the current URI of a sink, e.g. the URI of the
+// external connection the sink was made from.
+type sinkURISource func(ctx context.Context) (string, error)
+
+// sinkTLSFileParams are the parameters of the files of the certificates of a
+// sink, along with the parameters which specify the same certificates inline.
+var sinkTLSFileParams = []struct{ file, inline string }{
+	{changefeedbase.SinkParamCACertFile, changefeedbase.SinkParamCACert},
+	{changefeedbase.SinkParamClientCertFile, changefeedbase.SinkParamClientCert},
+	{changefeedbase.SinkParamClientKeyFile, changefeedbase.SinkParamClientKey},
+}
+
+// sinkTLSReloader reloads the CA and client certificates of a sink, so that
+// rotated certificates are used by the connections the sink opens afterwards
+// without restarting its changefeed. The certificates are reloaded from the
+// external connection the sink was made from, or from the files specified by
+// its URI (see changefeedbase.SinkParamCACertFile), at most once per
+// changefeedbase.SinkTLSReloadInterval. The connections which are already open
+// keep the certificates of their handshake.
+type sinkTLSReloader struct {
+	settings  *cluster.Settings
+	uriSource sinkURISource
+	// usesFiles is set if the certificates are read from files, which requires
+	// the sink to use TLS.
+	usesFiles bool
+	// systemRoots is set if the CA certificate is trusted in addition to the
+	// root CAs of the system, rather than instead of them.
+	systemRoots bool
+	// skipVerify is set if the certificate of the server is not verified.
+	skipVerify bool
+
+	mu struct {
+		syncutil.Mutex
+		// rootCAs is nil if the root CAs of the system are trusted.
+		rootCAs    *x509.CertPool
+		clientCert *tls.Certificate
+		loaded     time.Time
+	}
+}
+
+// makeSinkTLSReloader returns the reloader of the certificates of the sink
+// with the specified URI, whose certificate file parameters it consumes. The
+// certificates are reloaded from the URI returned by uriSource, if set. Nil
+// is returned if the certificates of the sink cannot change.
+func makeSinkTLSReloader(
+	st *cluster.Settings, u *url.URL, uriSource sinkURISource,
+) (*sinkTLSReloader, error) {
+	uri := u.String()
+	q := u.Query()
+	var usesFiles bool
+	for _, p := range sinkTLSFileParams {
+		usesFiles = usesFiles || q.Get(p.file) != ``
+		q.Del(p.file)
+	}
+	u.RawQuery = q.Encode()
+
+	if uriSource == nil {
+		if !usesFiles {
+			return nil, nil
+		}
+		uriSource = func(context.Context) (string, error) { return uri, nil }
+	}
+	if st == nil {
+		return nil, errors.AssertionFailedf("reloading the certificates of a sink requires cluster settings")
+	}
+	return &sinkTLSReloader{settings: st, uriSource: uriSource, usesFiles: usesFiles}, nil
+}
+
+// makeConnectionTLSReloader returns the reloader of the certificates of the
+// sink with which an external connection being created validates its URI, so
+// that the certificate files the URI specifies are read as well.
+func makeConnectionTLSReloader(execCfg interface{}, u *url.URL) (*sinkTLSReloader, error) {
+	var st *cluster.Settings
+	if cfg, ok := execCfg.(*sql.ExecutorConfig); ok {
+		st = cfg.Settings
+	}
+	return makeSinkTLSReloader(st, u, nil /* uriSource */)
+}
+
+// apply loads the certificates of the sink, and makes the TLS configuration
+// of the sink use their latest version in the handshakes of its connections.
+// A nil configuration means the sink does not use TLS. The CA certificate is
+// trusted in addition to the root CAs of the system if systemRoots is set.
+func (r *sinkTLSReloader) apply(ctx context.Context, cfg *tls.Config, systemRoots bool) error {
+	if r == nil {
+		return nil
+	}
+	if cfg == nil {
+		if r.usesFiles {
+			return errors.Errorf(`%s, %s and %s require %s=true`, changefeedbase.SinkParamCACertFile,
+				changefeedbase.SinkParamClientCertFile, changefeedbase.SinkParamClientKeyFile,
+				changefeedbase.SinkParamTLSEnabled)
+		}
+		return nil
+	}
+
+	r.systemRoots = systemRoots
+	r.skipVerify = cfg.InsecureSkipVerify
+	rootCAs, clientCert, err := r.load(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.rootCAs, r.mu.clientCert, r.mu.loaded = rootCAs, clientCert, timeutil.Now()
+
+	// The certificate of the server is verified against the latest root CAs
+	// by VerifyConnection, since the RootCAs of the configuration cannot be
+	// changed once it is in use.
+	cfg.InsecureSkipVerify = true
+	cfg.RootCAs = nil
+	cfg.Certificates = nil
+	cfg.VerifyConnection = r.verifyConnection
+	cfg.GetClientCertificate = r.getClientCertificate
+	return nil
+}
+
+// verifyConnection verifies the certificate chain of the server like the
+// handshake does when InsecureSkipVerify is not set.
+func (r *sinkTLSReloader) verifyConnection(cs tls.ConnectionState) error {
+	rootCAs, _ := r.current(context.Background())
+	if r.skipVerify {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server did not provide a certificate")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         rootCAs,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func (r *sinkTLSReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if _, clientCert := r.current(context.Background()); clientCert != nil {
+		return clientCert, nil
+	}
+	// The server may not require a client certificate.
+	return &tls.Certificate{}, nil
+}
+
+// current returns the latest certificates of the sink, which it reloads if
+// they were not checked for more than the reload interval. The previous
+// certificates are kept if they cannot be reloaded, e.g. while the files of
+// the certificates are being replaced, or the external connection is being
+// recreated.
+func (r *sinkTLSReloader) current(ctx context.Context) (*x509.CertPool, *tls.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if timeutil.Since(r.mu.loaded) < changefeedbase.SinkTLSReloadInterval.Get(&r.settings.SV) {
+		return r.mu.rootCAs, r.mu.clientCert
+	}
+	r.mu.loaded = timeutil.Now()
+	rootCAs, clientCert, err := r.load(ctx)
+	if err != nil {
+		log.Warningf(ctx, "failed to reload the certificates of the sink: %v", err)
+		return r.mu.rootCAs, r.mu.clientCert
+	}
+	r.mu.rootCAs, r.mu.clientCert = rootCAs, clientCert
+	return rootCAs, clientCert
+}
+
+// load reads the certificates specified by the current URI of the sink.
+func (r *sinkTLSReloader) load(ctx context.Context) (*x509.CertPool, *tls.Certificate, error) {
+	uri, err := r.uriSource(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := sinkURL{URL: parsed}
+
+	var caCert, clientCert, clientKey []byte
+	for i, dest := range []*[]byte{&caCert, &clientCert, &clientKey} {
+		p := sinkTLSFileParams[i]
+		if err := u.decodeBase64(p.inline, dest); err != nil {
+			return nil, nil, err
+		}
+		file := u.consumeParam(p.file)
+		if file == `` {
+			continue
+		}
+		if *dest != nil {
+			return nil, nil, errors.Errorf(`only one of %s and %s can be specified`, p.inline, p.file)
+		}
+		if *dest, err = readSinkTLSFile(ctx, r.settings, file); err != nil {
+			return nil, nil, errors.Wrapf(err, `reading %s`, p.file)
+		}
+	}
+
+	var rootCAs *x509.CertPool
+	if caCert != nil {
+		if r.systemRoots {
+			if rootCAs, err = x509.SystemCertPool(); err != nil {
+				return nil, nil, errors.Wrap(err, "could not load system root CA pool")
+			}
+		}
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caCert) {
+			return nil, nil, errors.Errorf(`invalid %s`, changefeedbase.SinkParamCACert)
+		}
+	}
+	if clientCert != nil && clientKey == nil {
+		return nil, nil, errors.Errorf(`%s requires %s to be set`, changefeedbase.SinkParamClientCert, changefeedbase.SinkParamClientKey)
+	} else if clientKey != nil && clientCert == nil {
+		return nil, nil, errors.Errorf(`%s requires %s to be set`, changefeedbase.SinkParamClientKey, changefeedbase.SinkParamClientCert)
+	}
+	if clientCert == nil {
+		return rootCAs, nil, nil
+	}
+	cert, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, `invalid client certificate data provided`)
+	}
+	return rootCAs, &cert, nil
+}
+
+// readSinkTLSFile reads the specified file of the external IO directory of
+// the node, which is where nodelocal:// URIs point to.
+func readSinkTLSFile(ctx context.Context, st *cluster.Settings, name string) ([]byte, error) {
+	storage, err := blobs.NewLocalStorage(st.ExternalIODir)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := storage.ReadFile(name, 0 /* offset */)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close(ctx) }()
+	return ioctx.ReadAll(ctx, r)
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkTLSReloader(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	dir, dirCleanupFn := testutils.TempDir(t)
+	defer dirCleanupFn()
+	st := cluster.MakeTestingClusterSettings()
+	st.ExternalIODir = dir
+	changefeedbase.SinkTLSReloadInterval.Override(ctx, &st.SV, 0)
+
+	// Each server requires a client certificate, and its certificate is signed
+	// by its own CA.
+	newServer := func() (*cdctest.MockWebhookSink, string, []byte, []byte) {
+		caCert, caCertBase64, err := cdctest.NewCACertBase64Encoded()
+		require.NoError(t, err)
+		server, err := cdctest.StartMockWebhookSinkSecure(caCert)
+		require.NoError(t, err)
+		clientCert, clientKey, err := cdctest.GenerateClientCertAndKey(caCert)
+		require.NoError(t, err)
+		return server, caCertBase64, clientCert, clientKey
+	}
+	server1, ca1, clientCert, clientKey := newServer()
+	defer server1.Close()
+	server2, ca2, _, _ := newServer()
+	defer server2.Close()
+
+	writeFile := func(name string, data []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+	writeCACert := func(caCertBase64 string) {
+		caCert, err := base64.StdEncoding.DecodeString(caCertBase64)
+		require.NoError(t, err)
+		writeFile("ca.crt", caCert)
+	}
+	writeCACert(ca1)
+	writeFile("client.crt", clientCert)
+	writeFile("client.key", clientKey)
+
+	// The sink only needs a reloader if its certificates can change.
+	u, err := url.Parse(`webhook-https://sink/?ca_cert=Zm9v`)
+	require.NoError(t, err)
+	r, err := makeSinkTLSReloader(st, u, nil /* uriSource */)
+	require.NoError(t, err)
+	require.Nil(t, r)
+	require.NoError(t, r.apply(ctx, &tls.Config{}, true /* systemRoots */))
+
+	// The reloader consumes the file parameters of the URI.
+	u, err = url.Parse(`webhook-https://sink/?ca_cert_file=ca.crt&client_cert_file=client.crt&client_key_file=client.key&foo=bar`)
+	require.NoError(t, err)
+	r, err = makeSinkTLSReloader(st, u, nil /* uriSource */)
+	require.NoError(t, err)
+	require.Equal(t, `foo=bar`, u.RawQuery)
+	require.EqualError(t, r.apply(ctx, nil /* cfg */, false /* systemRoots */),
+		`ca_cert_file, client_cert_file and client_key_file require tls_enabled=true`)
+
+	cfg := &tls.Config{}
+	require.NoError(t, r.apply(ctx, cfg, false /* systemRoots */))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+	post := func(server *cdctest.MockWebhookSink) error {
+		resp, err := client.Post(server.URL(), "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	require.NoError(t, post(server1))
+	require.Regexp(t, `certificate signed by unknown authority`, post(server2))
+
+	// The new connections use the rotated certificates.
+	writeCACert(ca2)
+	require.NoError(t, post(server2))
+	require.Regexp(t, `certificate signed by unknown authority`, post(server1))
+
+	// The previous certificates are kept while the new ones cannot be read.
+	require.NoError(t, os.Remove(filepath.Join(dir, "ca.crt")))
+	require.NoError(t, post(server2))
+
+	// The certificates are reloaded from the current URI of the sink, e.g. the
+	// URI of its external connection.
+	uri := `webhook-https://sink/?ca_cert=` + url.QueryEscape(ca1) +
+		`&client_cert_file=client.crt&client_key_file=client.key`
+	u, err = url.Parse(uri)
+	require.NoError(t, err)
+	r, err = makeSinkTLSReloader(st, u, func(context.Context) (string, error) { return uri, nil })
+	require.NoError(t, err)
+	cfg = &tls.Config{}
+	require.NoError(t, r.apply(ctx, cfg, false /* systemRoots */))
+	client.Transport = &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}
+	require.NoError(t, post(server1))
+	uri = strings.Replace(uri, url.QueryEscape(ca1), url.QueryEscape(ca2), 1)
+	require.NoError(t, post(server2))
+
+	for _, tc := range []struct {
+		params string
+		err    string
+	}{
+		{`ca_cert=Zm9v&ca_cert_file=ca.crt`, `only one of ca_cert and ca_cert_file can be specified`},
+		{`ca_cert_file=../ca.crt`, `outside of external-io-dir is not allowed`},
+		{`client_cert_file=client.crt`, `client_cert requires client_key to be set`},
+	} {
+		u, err := url.Parse(`kafka://sink/?` + tc.params)
+		require.NoError(t, err)
+		r, err := makeSinkTLSReloader(st, u, nil /* uriSource */)
+		require.NoError(t, err)
+		require.Regexp(t, tc.err, r.apply(ctx, &tls.Config{}, false /* systemRoots */))
+	}
+}
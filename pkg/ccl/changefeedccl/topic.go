@@ -224,8 +224,16 @@ func (tn *TopicNamer) nameFromComponents(
 	return str
 }
 
+// eventDescriptorTopic is implemented by the topics whose rows are described
+// by an event descriptor, which gives the columns of the rows.
+type eventDescriptorTopic interface {
+	TopicDescriptor
+	getEventDescriptor() *cdcevent.EventDescriptor
+}
+
 type tableDescriptorTopic struct {
 	cdcevent.Metadata
+	desc            *cdcevent.EventDescriptor
 	spec            changefeedbase.Target
 	identifierCache TopicIdentifier
 }
@@ -255,10 +263,15 @@ func (tdt *tableDescriptorTopic) GetTargetSpecification() changefeedbase.Target
 	return tdt.spec
 }
 
-var _ TopicDescriptor = &tableDescriptorTopic{}
+func (tdt *tableDescriptorTopic) getEventDescriptor() *cdcevent.EventDescriptor {
+	return tdt.desc
+}
+
+var _ eventDescriptorTopic = &tableDescriptorTopic{}
 
 type columnFamilyTopic struct {
 	cdcevent.Metadata
+	desc            *cdcevent.EventDescriptor
 	spec            changefeedbase.Target
 	identifierCache TopicIdentifier
 }
@@ -289,7 +302,11 @@ func (cft *columnFamilyTopic) GetTargetSpecification() changefeedbase.Target {
 	return cft.spec
 }
 
-var _ TopicDescriptor = &columnFamilyTopic{}
+func (cft *columnFamilyTopic) getEventDescriptor() *cdcevent.EventDescriptor {
+	return cft.desc
+}
+
+var _ eventDescriptorTopic = &columnFamilyTopic{}
 
 type noTopic struct{}
 
@@ -314,17 +331,19 @@ func (n noTopic) GetTargetSpecification() changefeedbase.Target {
 var _ TopicDescriptor = &noTopic{}
 
 func makeTopicDescriptorFromSpec(
-	s changefeedbase.Target, src cdcevent.Metadata,
+	s changefeedbase.Target, src *cdcevent.EventDescriptor,
 ) (TopicDescriptor, error) {
 	switch s.Type {
 	case jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY:
 		return &tableDescriptorTopic{
-			Metadata: src,
+			Metadata: src.Metadata,
+			desc:     src,
 			spec:     s,
 		}, nil
 	case jobspb.ChangefeedTargetSpecification_EACH_FAMILY, jobspb.ChangefeedTargetSpecification_COLUMN_FAMILY:
 		return &columnFamilyTopic{
-			Metadata: src,
+			Metadata: src.Metadata,
+			desc:     src,
 			spec:     s,
 		}, nil
 	default:
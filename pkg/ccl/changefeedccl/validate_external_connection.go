@@ -0,0 +1,210 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn"
+	"github.com/cockroachdb/cockroach/pkg/cloud/externalconn/connectionpb"
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/featureflag"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/privilege"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/syntheticprivilege"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/errors"
+)
+
+func init() {
+	sql.AddPlanHook("validate external connection", validateExternalConnectionPlanHook)
+}
+
+// The steps of VALIDATE EXTERNAL CONNECTION, in the order in which they run.
+const (
+	// validateStepResolve loads the external connection and checks that it
+	// represents a resource a changefeed can emit to.
+	validateStepResolve = "resolve"
+	// validateStepConnect makes the sink of the resource and dials it, which
+	// performs the handshake and authentication of the sinks that have one.
+	validateStepConnect = "connect"
+	// validateStepPublish emits a resolved timestamp message to the sink and
+	// flushes it.
+	validateStepPublish = "publish"
+)
+
+// validationTopicName is the topic to which VALIDATE EXTERNAL CONNECTION
+// publishes its test message.
+const validationTopicName = "crdb_external_connection_validation"
+
+var validateExternalConnectionHeader = colinfo.ResultColumns{
+	{Name: "step", Typ: types.String},
+	{Name: "ok", Typ: types.Bool},
+	{Name: "error", Typ: types.String},
+}
+
+// validateExternalConnectionPlanHook implements sql.PlanHookFn.
+func validateExternalConnectionPlanHook(
+	ctx context.Context, stmt tree.Statement, p sql.PlanHookState,
+) (sql.PlanHookRowFn, colinfo.ResultColumns, []sql.PlanNode, bool, error) {
+	validateStmt, ok := stmt.(*tree.ValidateExternalConnection)
+	if !ok {
+		return nil, nil, nil, false, nil
+	}
+
+	nameFn, err := p.TypeAsString(ctx, validateStmt.ConnectionLabel, `VALIDATE EXTERNAL CONNECTION`)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	fn := func(ctx context.Context, _ []sql.PlanNode, resultsCh chan<- tree.Datums) error {
+		ctx, span := tracing.ChildSpan(ctx, stmt.StatementTag())
+		defer span.Finish()
+
+		if err := featureflag.CheckEnabled(
+			ctx,
+			p.ExecCfg(),
+			featureChangefeedEnabled,
+			"CHANGEFEED",
+		); err != nil {
+			return err
+		}
+		if !p.ExecCfg().Settings.Version.IsActive(ctx, clusterversion.SystemExternalConnectionsTable) {
+			return pgerror.Newf(pgcode.FeatureNotSupported,
+				"External Connections are not supported until upgrade to version %v is finalized",
+				clusterversion.ByKey(clusterversion.SystemExternalConnectionsTable))
+		}
+
+		name, err := nameFn()
+		if err != nil {
+			return err
+		}
+		ecPrivilege := &syntheticprivilege.ExternalConnectionPrivilege{
+			ConnectionName: name,
+		}
+		if err := p.CheckPrivilege(ctx, ecPrivilege, privilege.USAGE); err != nil {
+			return err
+		}
+
+		return validateExternalConnection(ctx, p, name, func(step string, stepErr error) error {
+			row := tree.Datums{tree.NewDString(step), tree.MakeDBool(stepErr == nil), tree.DNull}
+			if stepErr != nil {
+				row[2] = tree.NewDString(stepErr.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case resultsCh <- row:
+				return nil
+			}
+		})
+	}
+	return fn, validateExternalConnectionHeader, nil, false, nil
+}
+
+// validateExternalConnection runs the steps a changefeed emitting to the
+// external connection would take before its first row is delivered, and
+// reports the outcome of each of them. It stops at the first step which
+// fails, so that the errors of a step are never a consequence of an earlier
+// one.
+func validateExternalConnection(
+	ctx context.Context, p sql.PlanHookState, name string, report func(string, error) error,
+) error {
+	uri, err := resolveSinkConnection(ctx, p, name)
+	if err != nil {
+		return report(validateStepResolve, err)
+	}
+	if err := report(validateStepResolve, nil); err != nil {
+		return err
+	}
+
+	details := validationSinkDetails(uri)
+	now := p.ExecCfg().Clock.Now()
+	sink, err := getSink(ctx, &p.ExecCfg().DistSQLSrv.ServerConfig, details,
+		fixedLowerBoundOracle(now), p.User(), jobspb.InvalidJobID, (*sliMetrics)(nil))
+	if err != nil {
+		return report(validateStepConnect, changefeedbase.MaybeStripRetryableErrorMarker(err))
+	}
+	if err := report(validateStepConnect, nil); err != nil {
+		return errors.CombineErrors(err, sink.Close())
+	}
+
+	err = publishValidationMessage(ctx, sink, details, now)
+	err = errors.CombineErrors(err, sink.Close())
+	return report(validateStepPublish, changefeedbase.MaybeStripRetryableErrorMarker(err))
+}
+
+// resolveSinkConnection returns the URI of the resource the external
+// connection represents.
+func resolveSinkConnection(ctx context.Context, p sql.PlanHookState, name string) (string, error) {
+	ec, err := externalconn.LoadExternalConnection(ctx, name, p.ExecCfg().InternalExecutor, p.Txn())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to load external connection object")
+	}
+	switch d := ec.ConnectionProto().Details.(type) {
+	case *connectionpb.ConnectionDetails_SimpleURI:
+		return d.SimpleURI.URI, nil
+	default:
+		return "", errors.Newf("cannot connect to %T; unsupported resource for a Sink connection", d)
+	}
+}
+
+// validationSinkDetails returns the details of a changefeed which emits the
+// test message of VALIDATE EXTERNAL CONNECTION to the specified sink.
+func validationSinkDetails(uri string) jobspb.ChangefeedDetails {
+	return jobspb.ChangefeedDetails{
+		SinkURI: uri,
+		Opts: map[string]string{
+			changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+		},
+		TargetSpecifications: []jobspb.ChangefeedTargetSpecification{{
+			Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+			StatementTimeName: validationTopicName,
+		}},
+	}
+}
+
+// publishValidationMessage emits a resolved timestamp message, which the
+// consumers of changefeeds already expect, and waits for the sink to deliver
+// it.
+func publishValidationMessage(
+	ctx context.Context, sink Sink, details jobspb.ChangefeedDetails, resolved hlc.Timestamp,
+) error {
+	encodingOpts, err := changefeedbase.MakeStatementOptions(details.Opts).GetEncodingOptions()
+	if err != nil {
+		return err
+	}
+	encoder, err := getEncoder(encodingOpts, AllTargets(details))
+	if err != nil {
+		return err
+	}
+	if err := sink.EmitResolvedTimestamp(ctx, encoder, resolved); err != nil {
+		return err
+	}
+	return sink.Flush(ctx)
+}
+
+// fixedLowerBoundOracle names the files of the cloud storage sinks which are
+// made outside of a changefeed.
+type fixedLowerBoundOracle hlc.Timestamp
+
+var _ timestampLowerBoundOracle = fixedLowerBoundOracle{}
+
+func (o fixedLowerBoundOracle) inclusiveLowerBoundTS() hlc.Timestamp {
+	return hlc.Timestamp(o)
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateExternalConnection(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, stopServer := makeServer(t)
+	defer stopServer()
+	sqlDB := sqlutils.MakeSQLRunner(s.DB)
+
+	cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamCACert, certEncoded)
+	sinkDestHost.RawQuery = params.Encode()
+
+	// Each step is reported, and the test message is delivered to the sink.
+	sqlDB.Exec(t, fmt.Sprintf(`CREATE EXTERNAL CONNECTION webhook AS 'webhook-%s'`, sinkDestHost.String()))
+	sqlDB.CheckQueryResults(t, `VALIDATE EXTERNAL CONNECTION 'webhook'`, [][]string{
+		{"resolve", "true", "NULL"},
+		{"connect", "true", "NULL"},
+		{"publish", "true", "NULL"},
+	})
+	require.Contains(t, sinkDest.Pop(), `"resolved"`)
+
+	// The validation stops at the first step which fails.
+	sqlDB.CheckQueryResults(t, `VALIDATE EXTERNAL CONNECTION 'missing'`, [][]string{
+		{"resolve", "false", `failed to load external connection object: external connection with name missing does not exist`},
+	})
+
+	sqlDB.Exec(t, `CREATE EXTERNAL CONNECTION nope AS 'kafka://nope'`)
+	rows := sqlDB.QueryStr(t, `VALIDATE EXTERNAL CONNECTION 'nope'`)
+	require.Len(t, rows, 2)
+	require.Equal(t, []string{"resolve", "true", "NULL"}, rows[0])
+	require.Equal(t, []string{"connect", "false"}, rows[1][:2])
+	require.Contains(t, rows[1][2], `client has run out of available brokers`)
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+// cdc-verify continuously verifies the delivery guarantees of a changefeed
+// against a real sink. It runs a write workload against a test table of the
+// cluster, creates a changefeed on it, and checks the output of the changefeed
+// read from the sink. See the cdcverify package for details.
+//
+// The violations are logged, recorded in the <table>_results table of the
+// cluster and counted in metrics, which are served in the Prometheus format if
+// --metrics-addr is set. The binary exits with an error if violations were
+// found by the time --duration elapsed.
+package main
+
+import (
+	"context"
+	gosql "database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcverify"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	// Import all the cloud provider storage we care about.
+	_ "github.com/cockroachdb/cockroach/pkg/cloud/amazon"
+	_ "github.com/cockroachdb/cockroach/pkg/cloud/azure"
+	_ "github.com/cockroachdb/cockroach/pkg/cloud/gcp"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+	_ "github.com/lib/pq"
+)
+
+var (
+	dbURL        = flag.String("url", "postgresql://root@localhost:26257/defaultdb?sslmode=disable", "URL of the cluster")
+	sinkURI      = flag.String("sink", "", "URI of the sink of the changefeed (kafka:// or cloud storage)")
+	duration     = flag.Duration("duration", 0, "duration of the verification, or 0 to run until interrupted")
+	metricsAddr  = flag.String("metrics-addr", "", "address at which the metrics are served, if set")
+	pollInterval = flag.Duration("poll-interval", 5*time.Second, "interval between the listings of cloud storage sinks")
+
+	cfg = cdcverify.DefaultConfig()
+)
+
+func init() {
+	flag.StringVar(&cfg.Table, "table", cfg.Table, "name of the table written by the workload")
+	flag.IntVar(&cfg.Keys, "keys", cfg.Keys, "number of distinct keys written by the workload")
+	flag.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "number of concurrent writers")
+	flag.DurationVar(&cfg.WriteInterval, "write-interval", cfg.WriteInterval, "interval between two writes of each writer")
+	flag.IntVar(&cfg.PayloadBytes, "payload-bytes", cfg.PayloadBytes, "size of the payload of each write")
+	flag.DurationVar(&cfg.Resolved, "resolved", cfg.Resolved, "frequency of the resolved timestamps of the changefeed")
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *sinkURI == "" {
+		return errors.New("--sink is required")
+	}
+	cfg.SinkURI = *sinkURI
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	db, err := gosql.Open("postgres", *dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	v := cdcverify.New(db, cfg)
+	if *metricsAddr != "" {
+		serveMetrics(v.Metrics())
+	}
+	if err := v.Setup(ctx); err != nil {
+		return errors.Wrap(err, "setting up the verification")
+	}
+	fmt.Printf("verifying changefeed job %d\n", v.JobID())
+
+	r, err := makeSinkReader(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "creating the sink reader")
+	}
+	defer r.Close()
+	if err := v.Run(ctx, r); err != nil {
+		return err
+	}
+
+	m := v.Metrics()
+	fmt.Printf("rows written: %d, received: %d, verified: %d, duplicates: %d\n",
+		m.RowsWritten.Count(), m.RowsReceived.Count(), m.RowsVerified.Count(), m.DuplicateRows.Count())
+	if violations := v.Violations(); len(violations) > 0 {
+		return errors.Newf("found %d violations:\n%s", len(violations), strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+// makeSinkReader returns a reader of the sink of the changefeed.
+func makeSinkReader(ctx context.Context, cfg cdcverify.Config) (cdcverify.SinkReader, error) {
+	u, err := url.Parse(cfg.SinkURI)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "kafka" {
+		es, err := cloud.ExternalStorageFromURI(ctx, cfg.SinkURI,
+			base.ExternalIODirConfig{}, cluster.MakeClusterSettings(),
+			nil, username.RootUserName(), nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return cdcverify.NewCloudStorageReader(es, *pollInterval), nil
+	}
+
+	// The topic is created by the changefeed when it emits its first message,
+	// so wait for it to exist.
+	var r cdcverify.SinkReader
+	opts := retry.Options{MaxBackoff: 5 * time.Second, MaxRetries: 20}
+	for re := retry.StartWithCtx(ctx, opts); re.Next(); {
+		r, err = cdcverify.NewKafkaReader([]string{u.Host}, cfg.Table)
+		if !errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+			break
+		}
+	}
+	return r, err
+}
+
+// serveMetrics serves the metrics in the Prometheus format at --metrics-addr.
+func serveMetrics(m *cdcverify.Metrics) {
+	registry := metric.NewRegistry()
+	registry.AddMetricStruct(m)
+	exporter := metric.MakePrometheusExporter()
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if err := exporter.ScrapeAndPrintAsText(w, func(pm *metric.PrometheusExporter) {
+			pm.ScrapeRegistry(registry, true /* includeChildMetrics */)
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	go func() {
+		if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "serving metrics: %v\n", err)
+		}
+	}()
+}
@@ -1065,6 +1065,13 @@ func TestTenantLogic_kv_builtin_functions_tenant(
 	runLogicTest(t, "kv_builtin_functions_tenant")
 }
 
+func TestTenantLogic_large_objects(
+	t *testing.T,
+) {
+	defer leaktest.AfterTest(t)()
+	runLogicTest(t, "large_objects")
+}
+
 func TestTenantLogic_limit(
 	t *testing.T,
 ) {
@@ -10,12 +10,15 @@ package sqlccl
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"net/url"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/security/username"
 	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
@@ -87,6 +90,92 @@ func TestAuthenticateWithSessionRevivalToken(t *testing.T) {
 		require.True(t, b)
 	})
 
+	t.Run("decode token", func(t *testing.T) {
+		var claims string
+		err := tenantDB.QueryRow(
+			"SELECT crdb_internal.decode_session_revival_token(decode($1, 'base64'))",
+			token,
+		).Scan(&claims)
+		require.NoError(t, err)
+		var decoded struct {
+			User      string    `json:"user"`
+			Algorithm string    `json:"algorithm"`
+			IssuedAt  time.Time `json:"issued_at"`
+			ExpiresAt time.Time `json:"expires_at"`
+			Valid     bool      `json:"valid"`
+			Error     string    `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(claims), &decoded))
+		require.Equal(t, username.TestUser, decoded.User)
+		require.Equal(t, x509.Ed25519.String(), decoded.Algorithm)
+		require.True(t, decoded.IssuedAt.Before(decoded.ExpiresAt))
+		require.True(t, decoded.Valid)
+		require.Empty(t, decoded.Error)
+
+		// A correctly signed token which has expired is decoded, but not valid.
+		cm, err := tenant.RPCContext().SecurityContext.GetCertificateManager()
+		require.NoError(t, err)
+		signingCert, err := cm.GetTenantSigningCert()
+		require.NoError(t, err)
+		key, err := security.PEMToPrivateKey(signingCert.KeyFileContents)
+		require.NoError(t, err)
+		issuedAt := timeutil.Now().Add(-20 * time.Minute)
+		issuedAtProto, err := pbtypes.TimestampProto(issuedAt)
+		require.NoError(t, err)
+		expiresAtProto, err := pbtypes.TimestampProto(issuedAt.Add(10 * time.Minute))
+		require.NoError(t, err)
+		payloadBytes, err := protoutil.Marshal(&sessiondatapb.SessionRevivalToken_Payload{
+			User:      username.TestUser,
+			Algorithm: x509.Ed25519.String(),
+			IssuedAt:  issuedAtProto,
+			ExpiresAt: expiresAtProto,
+		})
+		require.NoError(t, err)
+		expiredToken, err := protoutil.Marshal(&sessiondatapb.SessionRevivalToken{
+			Payload:   payloadBytes,
+			Signature: ed25519.Sign(key.(ed25519.PrivateKey), payloadBytes),
+		})
+		require.NoError(t, err)
+		err = tenantDB.QueryRow(
+			"SELECT crdb_internal.decode_session_revival_token($1)", expiredToken,
+		).Scan(&claims)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal([]byte(claims), &decoded))
+		require.Equal(t, username.TestUser, decoded.User)
+		require.False(t, decoded.Valid)
+		require.Contains(t, decoded.Error, "token expiration time is in the past")
+
+		var valid bool
+		err = tenantDB.QueryRow(
+			"SELECT crdb_internal.validate_session_revival_token($1)", expiredToken,
+		).Scan(&valid)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "token expiration time is in the past")
+
+		_, err = tenantDB.Exec("SELECT crdb_internal.decode_session_revival_token('garbage')")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid session revival token")
+	})
+
+	t.Run("decode token requires admin", func(t *testing.T) {
+		pgURL, cleanup := sqlutils.PGUrl(
+			t,
+			tenant.SQLAddr(),
+			"TestToken3",
+			url.UserPassword(username.TestUser, "hunter2"),
+		)
+		defer cleanup()
+
+		conn, err := pgx.Connect(ctx, pgURL.String())
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close(ctx)) }()
+		_, err = conn.Exec(ctx,
+			"SELECT crdb_internal.decode_session_revival_token(decode($1, 'base64'))", token)
+		require.Error(t, err)
+		require.Contains(t, err.Error(),
+			"only users with the admin role are allowed to decode session revival tokens")
+	})
+
 	t.Run("use a token with invalid signature", func(t *testing.T) {
 		pgURL, cleanup := sqlutils.PGUrl(
 			t,
@@ -138,4 +227,13 @@ func TestAuthenticateWithSessionRevivalToken(t *testing.T) {
 		_, err = pgx.Connect(ctx, pgURL.String())
 		require.Contains(t, err.Error(), "crdb:session_revival_token_base64: illegal base64 data")
 	})
+
+	t.Run("tokens are disabled by the cluster setting", func(t *testing.T) {
+		sql.AllowSessionRevival.Override(ctx, &tenant.ClusterSettings().SV, false)
+		defer sql.AllowSessionRevival.Override(ctx, &tenant.ClusterSettings().SV, true)
+		_, err := tenantDB.Exec(
+			"SELECT crdb_internal.decode_session_revival_token(decode($1, 'base64'))", token)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "session revival tokens are not supported on this cluster")
+	})
 }
@@ -124,6 +124,8 @@ var forbiddenSystemTables = map[string]struct{}{
 
 	"system.zones": {}, // the contents of crdb_internal.zones is easier to use.
 
+	"system.large_objects": {}, // avoid downloading user data.
+
 	"system.statement_bundle_chunks": {}, // avoid downloading a large table that's hard to interpret currently.
 	"system.statement_statistics":    {}, // historical data, usually too much to download.
 	"system.transaction_statistics":  {}, // ditto
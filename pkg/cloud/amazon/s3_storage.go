@@ -12,11 +12,14 @@ package amazon
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/url"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -78,6 +81,21 @@ const (
 	// assume.
 	AssumeRoleParam = "ASSUME_ROLE"
 
+	// AWSUsePathStyleParam is the query parameter in an S3 URI which, if true,
+	// addresses the bucket in the path of the requests rather than in their
+	// host. The requests to a custom endpoint always use path-style addressing.
+	AWSUsePathStyleParam = "AWS_USE_PATH_STYLE"
+
+	// AWSCABundleParam is the query parameter in an S3 URI for the
+	// base64-encoded PEM certificates of the CAs to trust in addition to the
+	// root CAs of the system, e.g. the CA of an S3-compatible endpoint.
+	AWSCABundleParam = "AWS_CA_BUNDLE"
+
+	// S3RequesterPaysParam is the query parameter in an S3 URI which, if true,
+	// acknowledges that the requests are billed to the requester, as "requester
+	// pays" buckets require.
+	S3RequesterPaysParam = "S3_REQUESTER_PAYS"
+
 	// scheme component of an S3 URI.
 	scheme = "s3"
 )
@@ -147,6 +165,8 @@ type s3ClientConfig struct {
 	// copied from ExternalStorage_S3.
 	endpoint, region, bucket, accessKey, secret, tempToken, auth, roleARN string
 	delegateRoleARNs                                                      []string
+	usePathStyle                                                          bool
+	caBundle                                                              string
 	// log.V(2) decides session init params so include it in key.
 	verbose bool
 }
@@ -163,6 +183,8 @@ func clientConfig(conf *cloudpb.ExternalStorage_S3) s3ClientConfig {
 		verbose:          log.V(2),
 		roleARN:          conf.RoleARN,
 		delegateRoleARNs: conf.DelegateRoleARNs,
+		usePathStyle:     conf.UsePathStyle,
+		caBundle:         conf.CABundle,
 	}
 }
 
@@ -203,6 +225,15 @@ func S3URI(bucket, path string, conf *cloudpb.ExternalStorage_S3) string {
 		roles := append(conf.DelegateRoleARNs, conf.RoleARN)
 		q.Set(AssumeRoleParam, strings.Join(roles, ","))
 	}
+	if conf.UsePathStyle {
+		q.Set(AWSUsePathStyleParam, "true")
+	}
+	if conf.CABundle != "" {
+		q.Set(AWSCABundleParam, base64.StdEncoding.EncodeToString([]byte(conf.CABundle)))
+	}
+	if conf.RequesterPays {
+		q.Set(S3RequesterPaysParam, "true")
+	}
 
 	s3URL := url.URL{
 		Scheme:   "s3",
@@ -249,6 +280,33 @@ func parseS3URL(_ cloud.ExternalStorageURIContext, uri *url.URL) (cloudpb.Extern
 	// characters to recover the original secret.
 	conf.S3Config.Secret = strings.Replace(conf.S3Config.Secret, " ", "+", -1)
 
+	for _, p := range []struct {
+		param string
+		dest  *bool
+	}{
+		{AWSUsePathStyleParam, &conf.S3Config.UsePathStyle},
+		{S3RequesterPaysParam, &conf.S3Config.RequesterPays},
+	} {
+		if v := s3URL.ConsumeParam(p.param); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return cloudpb.ExternalStorage{}, errors.Wrapf(err, "parsing %s", p.param)
+			}
+			*p.dest = b
+		}
+	}
+	if caBundle := s3URL.ConsumeParam(AWSCABundleParam); caBundle != "" {
+		pem, err := base64.StdEncoding.DecodeString(caBundle)
+		if err != nil {
+			return cloudpb.ExternalStorage{}, errors.Wrapf(err, "decoding %s", AWSCABundleParam)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return cloudpb.ExternalStorage{}, errors.Errorf(
+				"%s does not contain any PEM-encoded certificate", AWSCABundleParam)
+		}
+		conf.S3Config.CABundle = string(pem)
+	}
+
 	// Validate that all the passed in parameters are supported.
 	if unknownParams := s3URL.RemainingQueryParams(); len(unknownParams) > 0 {
 		return cloudpb.ExternalStorage{}, errors.Errorf(
@@ -419,8 +477,12 @@ func newClient(
 		if conf.region == "" {
 			conf.region = "default-region"
 		}
-
-		client, err := cloud.MakeHTTPClient(settings)
+	}
+	if conf.usePathStyle {
+		opts.Config.S3ForcePathStyle = aws.Bool(true)
+	}
+	if conf.endpoint != "" || conf.caBundle != "" {
+		client, err := cloud.MakeHTTPClientWithCA(settings, []byte(conf.caBundle))
 		if err != nil {
 			return s3Client{}, "", err
 		}
@@ -562,6 +624,7 @@ func (s *s3Storage) Writer(ctx context.Context, basename string) (io.WriteCloser
 			ServerSideEncryption: nilIfEmpty(s.conf.ServerEncMode),
 			SSEKMSKeyId:          nilIfEmpty(s.conf.ServerKMSID),
 			StorageClass:         nilIfEmpty(s.conf.StorageClass),
+			RequestPayer:         s.requestPayer(),
 		})
 		return errors.Wrap(err, "upload failed")
 	}), nil
@@ -574,7 +637,11 @@ func (s *s3Storage) openStreamAt(
 	if err != nil {
 		return nil, err
 	}
-	req := &s3.GetObjectInput{Bucket: s.bucket, Key: aws.String(path.Join(s.prefix, basename))}
+	req := &s3.GetObjectInput{
+		Bucket:       s.bucket,
+		Key:          aws.String(path.Join(s.prefix, basename)),
+		RequestPayer: s.requestPayer(),
+	}
 	if pos != 0 {
 		req.Range = aws.String(fmt.Sprintf("bytes=%d-", pos))
 	}
@@ -689,6 +756,7 @@ func (s *s3Storage) List(ctx context.Context, prefix, delim string, fn cloud.Lis
 	} else {
 		s3Input = &s3.ListObjectsInput{Bucket: s.bucket, Prefix: aws.String(dest), Delimiter: nilIfEmpty(delim)}
 	}
+	s3Input.RequestPayer = s.requestPayer()
 
 	if err := client.ListObjectsPagesWithContext(
 		ctx, s3Input, pageFn,
@@ -708,8 +776,9 @@ func (s *s3Storage) Delete(ctx context.Context, basename string) error {
 		cloud.Timeout.Get(&s.settings.SV),
 		func(ctx context.Context) error {
 			_, err := client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
-				Bucket: s.bucket,
-				Key:    aws.String(path.Join(s.prefix, basename)),
+				Bucket:       s.bucket,
+				Key:          aws.String(path.Join(s.prefix, basename)),
+				RequestPayer: s.requestPayer(),
 			})
 			return err
 		})
@@ -726,8 +795,9 @@ func (s *s3Storage) Size(ctx context.Context, basename string) (int64, error) {
 		func(ctx context.Context) error {
 			var err error
 			out, err = client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-				Bucket: s.bucket,
-				Key:    aws.String(path.Join(s.prefix, basename)),
+				Bucket:       s.bucket,
+				Key:          aws.String(path.Join(s.prefix, basename)),
+				RequestPayer: s.requestPayer(),
 			})
 			return err
 		})
@@ -741,6 +811,15 @@ func (s *s3Storage) Close() error {
 	return nil
 }
 
+// requestPayer returns the payer of the requests to a "requester pays" bucket,
+// if the storage acknowledges it.
+func (s *s3Storage) requestPayer() *string {
+	if s.conf.RequesterPays {
+		return aws.String(s3.RequestPayerRequester)
+	}
+	return nil
+}
+
 func nilIfEmpty(s string) *string {
 	if s == "" {
 		return nil
@@ -11,10 +11,17 @@
 package amazon
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -29,7 +36,9 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
+	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
@@ -317,6 +326,112 @@ func TestS3DisallowCustomEndpoints(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestS3URIParams(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	caBundle := "-----BEGIN CERTIFICATE-----\nMIIBhTCCASugAwIBAgIQIRi6zePL6mKjOipn+dNuaTAKBggqhkjOPQQDAjASMRAw\nDgYDVQQKEwdBY21lIENvMB4XDTE3MTAyMDE5NDMwNloXDTE4MTAyMDE5NDMwNlow\nEjEQMA4GA1UEChMHQWNtZSBDbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABD0d\n7VNhbWvZLWPuj/RtHFjvtJBEwOkhbN/BnnE8rnZR8+sbwnc/KhCk3FhnpHZnQz7B\n5aETbbIgmuvewdjvSBSjYzBhMA4GA1UdDwEB/wQEAwICpDATBgNVHSUEDDAKBggr\nBgEFBQcDATAPBgNVHRMBAf8EBTADAQH/MCkGA1UdEQQiMCCCDmxvY2FsaG9zdDo1\nNDUzgg4xMjcuMC4wLjE6NTQ1MzAKBggqhkjOPQQDAgNIADBFAiEA2zpJEPQyz6/l\nWf86aX6PepsntZv2GYlA5UpabfT2EZICICpJ5h/iI+i341gBmLiAFQOyTDT+/wQc\n6MF9+Yw1Yy0t\n-----END CERTIFICATE-----\n"
+	q := make(url.Values)
+	q.Set(AWSAccessKeyParam, "key")
+	q.Set(AWSSecretParam, "secret")
+	q.Set(AWSEndpointParam, "https://minio.local:9000")
+	q.Set(AWSUsePathStyleParam, "true")
+	q.Set(AWSCABundleParam, base64.StdEncoding.EncodeToString([]byte(caBundle)))
+	q.Set(S3RequesterPaysParam, "true")
+	uri := fmt.Sprintf("s3://bucket/path?%s", q.Encode())
+
+	conf, err := cloud.ExternalStorageConfFromURI(uri, username.RootUserName())
+	require.NoError(t, err)
+	require.True(t, conf.S3Config.UsePathStyle)
+	require.Equal(t, caBundle, conf.S3Config.CABundle)
+	require.True(t, conf.S3Config.RequesterPays)
+
+	// The parameters survive the serialization of the URI.
+	roundTripped, err := cloud.ExternalStorageConfFromURI(
+		S3URI(conf.S3Config.Bucket, conf.S3Config.Prefix, conf.S3Config), username.RootUserName())
+	require.NoError(t, err)
+	require.Equal(t, conf, roundTripped)
+
+	for _, tc := range []struct {
+		param, value, err string
+	}{
+		{AWSUsePathStyleParam, "maybe", `parsing AWS_USE_PATH_STYLE`},
+		{S3RequesterPaysParam, "maybe", `parsing S3_REQUESTER_PAYS`},
+		{AWSCABundleParam, "not base64", `decoding AWS_CA_BUNDLE`},
+		{AWSCABundleParam, base64.StdEncoding.EncodeToString([]byte("foo")),
+			`AWS_CA_BUNDLE does not contain any PEM-encoded certificate`},
+	} {
+		q := make(url.Values)
+		q.Set(AWSAccessKeyParam, "key")
+		q.Set(AWSSecretParam, "secret")
+		q.Set(tc.param, tc.value)
+		_, err := cloud.ExternalStorageConfFromURI(
+			fmt.Sprintf("s3://bucket/path?%s", q.Encode()), username.RootUserName())
+		require.Regexp(t, tc.err, err)
+	}
+}
+
+// TestS3CompatibleEndpoint checks the requests to an S3-compatible endpoint
+// whose certificate is signed by a custom CA.
+func TestS3CompatibleEndpoint(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	var mu syncutil.Mutex
+	var paths, payers []string
+	objects := make(map[string][]byte)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		payers = append(payers, r.Header.Get("x-amz-request-payer"))
+		switch r.Method {
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			objects[r.URL.Path] = body
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			_, _ = w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer srv.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	q := make(url.Values)
+	q.Set(AWSAccessKeyParam, "key")
+	q.Set(AWSSecretParam, "secret")
+	q.Set(AWSEndpointParam, srv.URL)
+	q.Set(S3RegionParam, "us-east-1")
+	q.Set(AWSCABundleParam, base64.StdEncoding.EncodeToString(caBundle))
+	q.Set(S3RequesterPaysParam, "true")
+	s, err := makeS3Storage(ctx, fmt.Sprintf("s3://bucket/prefix?%s", q.Encode()), username.RootUserName())
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, cloud.WriteFile(ctx, s, "file", bytes.NewReader([]byte("data"))))
+	r, err := s.ReadFile(ctx, "file")
+	require.NoError(t, err)
+	data, err := ioctx.ReadAll(ctx, r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close(ctx))
+	require.Equal(t, "data", string(data))
+
+	// The bucket is addressed in the path of the requests to the endpoint, and
+	// the requests acknowledge that the requester pays for them.
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"PUT /bucket/prefix/file", "GET /bucket/prefix/file"}, paths)
+	require.Equal(t, []string{"requester", "requester"}, payers)
+}
+
 func TestS3DisallowImplicitCredentials(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	dest := cloudpb.ExternalStorage{S3Config: &cloudpb.ExternalStorage_S3{Endpoint: "http://do-not-go-there", Auth: cloud.AuthParamImplicit}}
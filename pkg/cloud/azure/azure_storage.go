@@ -16,9 +16,12 @@ import (
 	"io"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/cloud"
@@ -27,6 +30,8 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
 	"github.com/cockroachdb/cockroach/pkg/util/ioctx"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/cockroachdb/errors"
 	"github.com/gogo/protobuf/types"
@@ -39,9 +44,25 @@ const (
 	AzureAccountKeyParam = "AZURE_ACCOUNT_KEY"
 	// AzureEnvironmentKeyParam is the query parameter for the environment name in an azure URI.
 	AzureEnvironmentKeyParam = "AZURE_ENVIRONMENT"
+	// AzureSASTokenParam is the query parameter for the shared access signature
+	// in an azure URI, used instead of the account key.
+	AzureSASTokenParam = "AZURE_SAS_TOKEN"
+	// AzureClientIDParam is the query parameter for the client ID of the
+	// user-assigned managed identity used by implicit authentication.
+	AzureClientIDParam = "AZURE_CLIENT_ID"
+	// AzureImmutableStorageParam is the query parameter set for containers with
+	// immutability policies, whose blobs cannot be overwritten.
+	AzureImmutableStorageParam = "AZURE_IMMUTABLE_STORAGE"
 
 	scheme                   = "azure"
 	externalConnectionScheme = "azure-storage"
+
+	// tokenRefreshWithin is how long before their expiration the managed
+	// identity tokens are refreshed.
+	tokenRefreshWithin = 5 * time.Minute
+	// tokenRefreshRetry is how long after a failed refresh of a managed identity
+	// token it is retried.
+	tokenRefreshRetry = 30 * time.Second
 )
 
 func parseAzureURL(
@@ -56,6 +77,16 @@ func parseAzureURL(
 		AccountName: azureURL.ConsumeParam(AzureAccountNameParam),
 		AccountKey:  azureURL.ConsumeParam(AzureAccountKeyParam),
 		Environment: azureURL.ConsumeParam(AzureEnvironmentKeyParam),
+		SASToken:    strings.TrimPrefix(azureURL.ConsumeParam(AzureSASTokenParam), "?"),
+		Auth:        azureURL.ConsumeParam(cloud.AuthParam),
+		ClientID:    azureURL.ConsumeParam(AzureClientIDParam),
+	}
+	if immutable := azureURL.ConsumeParam(AzureImmutableStorageParam); immutable != "" {
+		var err error
+		conf.AzureConfig.Immutable, err = strconv.ParseBool(immutable)
+		if err != nil {
+			return cloudpb.ExternalStorage{}, errors.Wrapf(err, "parsing %s", AzureImmutableStorageParam)
+		}
 	}
 
 	// Validate that all the passed in parameters are supported.
@@ -67,8 +98,8 @@ func parseAzureURL(
 	if conf.AzureConfig.AccountName == "" {
 		return conf, errors.Errorf("azure uri missing %q parameter", AzureAccountNameParam)
 	}
-	if conf.AzureConfig.AccountKey == "" {
-		return conf, errors.Errorf("azure uri missing %q parameter", AzureAccountKeyParam)
+	if err := validateAzureCredentials(conf.AzureConfig); err != nil {
+		return conf, err
 	}
 	if conf.AzureConfig.Environment == "" {
 		// Default to AzurePublicCloud if not specified for backwards compatibility
@@ -78,6 +109,40 @@ func parseAzureURL(
 	return conf, nil
 }
 
+// validateAzureCredentials checks that the config authenticates either with
+// the account key, with a shared access signature or implicitly with a managed
+// identity.
+func validateAzureCredentials(conf *cloudpb.ExternalStorage_Azure) error {
+	switch conf.Auth {
+	case "", cloud.AuthParamSpecified:
+		if conf.AccountKey == "" && conf.SASToken == "" {
+			return errors.Errorf("azure uri missing %q or %q parameter",
+				AzureAccountKeyParam, AzureSASTokenParam)
+		}
+		if conf.AccountKey != "" && conf.SASToken != "" {
+			return errors.Errorf("azure uri cannot set both %q and %q parameters",
+				AzureAccountKeyParam, AzureSASTokenParam)
+		}
+		if conf.ClientID != "" {
+			return errors.Errorf("%s requires %s to be set to '%s'",
+				AzureClientIDParam, cloud.AuthParam, cloud.AuthParamImplicit)
+		}
+		if conf.SASToken != "" {
+			if _, err := url.ParseQuery(conf.SASToken); err != nil {
+				return errors.Wrapf(err, "parsing %s", AzureSASTokenParam)
+			}
+		}
+	case cloud.AuthParamImplicit:
+		if conf.AccountKey != "" || conf.SASToken != "" {
+			return errors.Errorf("%s is set to '%s', but %s or %s is set",
+				cloud.AuthParam, cloud.AuthParamImplicit, AzureAccountKeyParam, AzureSASTokenParam)
+		}
+	default:
+		return errors.Errorf("unsupported value %s for %s", conf.Auth, cloud.AuthParam)
+	}
+	return nil
+}
+
 type azureStorage struct {
 	conf      *cloudpb.ExternalStorage_Azure
 	ioConf    base.ExternalIODirConfig
@@ -96,19 +161,46 @@ func makeAzureStorage(
 	if conf == nil {
 		return nil, errors.Errorf("azure upload requested but info missing")
 	}
-	credential, err := azblob.NewSharedKeyCredential(conf.AccountName, conf.AccountKey)
-	if err != nil {
-		return nil, errors.Wrap(err, "azure credential")
+	if err := validateAzureCredentials(conf); err != nil {
+		return nil, err
 	}
 	env, err := azure.EnvironmentFromName(conf.Environment)
 	if err != nil {
 		return nil, errors.Wrap(err, "azure environment")
 	}
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
 	u, err := url.Parse(fmt.Sprintf("https://%s.blob.%s", conf.AccountName, env.StorageEndpointSuffix))
 	if err != nil {
 		return nil, errors.Wrap(err, "azure: account name is not valid")
 	}
+
+	var credential azblob.Credential
+	switch {
+	case conf.Auth == cloud.AuthParamImplicit:
+		if args.IOConf.DisableImplicitCredentials {
+			return nil, errors.New(
+				"implicit credentials disallowed for azure due to --external-io-implicit-credentials flag")
+		}
+		spt, err := adal.NewServicePrincipalTokenFromManagedIdentity(env.ResourceIdentifiers.Storage,
+			&adal.ManagedIdentityOptions{ClientID: conf.ClientID})
+		if err != nil {
+			return nil, errors.Wrap(err, "azure managed identity")
+		}
+		if err := spt.EnsureFresh(); err != nil {
+			return nil, errors.Wrap(err, "azure managed identity token")
+		}
+		credential = azblob.NewTokenCredential(spt.OAuthToken(), managedIdentityTokenRefresher(spt))
+	case conf.SASToken != "":
+		// The shared access signature authorizes the requests through the query
+		// of their URLs, which extend the URL of the service.
+		u.RawQuery = conf.SASToken
+		credential = azblob.NewAnonymousCredential()
+	default:
+		credential, err = azblob.NewSharedKeyCredential(conf.AccountName, conf.AccountKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "azure credential")
+		}
+	}
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
 	serviceURL := azblob.NewServiceURL(*u, p)
 	return &azureStorage{
 		conf:      conf,
@@ -119,6 +211,25 @@ func makeAzureStorage(
 	}, nil
 }
 
+// managedIdentityTokenRefresher refreshes the token of the managed identity
+// ahead of its expiration, so that long-running jobs such as changefeeds keep
+// authenticating. Failed refreshes are retried until the token expires, after
+// which the requests fail.
+func managedIdentityTokenRefresher(spt *adal.ServicePrincipalToken) azblob.TokenRefresher {
+	return func(credential azblob.TokenCredential) time.Duration {
+		if err := spt.EnsureFresh(); err != nil {
+			log.Warningf(context.Background(), "failed to refresh azure managed identity token: %v", err)
+			return tokenRefreshRetry
+		}
+		token := spt.Token()
+		credential.SetToken(token.OAuthToken())
+		if d := timeutil.Until(token.Expires()) - tokenRefreshWithin; d > tokenRefreshRetry {
+			return d
+		}
+		return tokenRefreshRetry
+	}
+}
+
 func (s *azureStorage) getBlob(basename string) azblob.BlockBlobURL {
 	name := path.Join(s.prefix, basename)
 	return s.container.NewBlockBlobURL(name)
@@ -146,17 +257,42 @@ func (s *azureStorage) Writer(ctx context.Context, basename string) (io.WriteClo
 	sp.RecordStructured(&types.StringValue{Value: fmt.Sprintf("azure.Writer: %s",
 		path.Join(s.prefix, basename))})
 	blob := s.getBlob(basename)
+	opts := azblob.UploadStreamToBlockBlobOptions{
+		BufferSize: int(cloud.WriteChunkSize.Get(&s.settings.SV)),
+	}
+	if s.conf.Immutable {
+		// Blobs of immutable containers are only ever created, and the writes of
+		// existing ones fail before uploading anything.
+		opts.AccessConditions.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	}
 	return cloud.BackgroundPipe(ctx, func(ctx context.Context, r io.Reader) error {
 		defer sp.Finish()
-		_, err := azblob.UploadStreamToBlockBlob(
-			ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{
-				BufferSize: int(cloud.WriteChunkSize.Get(&s.settings.SV)),
-			},
-		)
+		_, err := azblob.UploadStreamToBlockBlob(ctx, r, blob, opts)
+		if err != nil && s.conf.Immutable && isBlobExistsError(err) {
+			// nolint:errwrap
+			return errors.Wrapf(
+				errors.Wrap(cloud.ErrFileAlreadyExists, "azure blob already exists"),
+				"%v",
+				err.Error(),
+			)
+		}
 		return err
 	}), nil
 }
 
+// isBlobExistsError returns whether the write of a blob failed because it
+// already exists and cannot be overwritten.
+func isBlobExistsError(err error) bool {
+	if azerr := (azblob.StorageError)(nil); errors.As(err, &azerr) {
+		switch azerr.ServiceCode() {
+		case azblob.ServiceCodeBlobAlreadyExists, azblob.ServiceCodeConditionNotMet,
+			azblob.ServiceCodeType(azblob.StorageErrorCodeBlobImmutableDueToPolicy):
+			return true
+		}
+	}
+	return false
+}
+
 // ReadFile is shorthand for ReadFileAt with offset 0.
 func (s *azureStorage) ReadFile(ctx context.Context, basename string) (ioctx.ReadCloserCtx, error) {
 	reader, _, err := s.ReadFileAt(ctx, basename, 0)
@@ -267,5 +403,6 @@ func (s *azureStorage) Close() error {
 
 func init() {
 	cloud.RegisterExternalStorageProvider(cloudpb.ExternalStorageProvider_azure,
-		parseAzureURL, makeAzureStorage, cloud.RedactedParams(AzureAccountKeyParam), scheme, externalConnectionScheme)
+		parseAzureURL, makeAzureStorage, cloud.RedactedParams(AzureAccountKeyParam, AzureSASTokenParam),
+		scheme, externalConnectionScheme)
 }
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/cloud"
 	"github.com/cockroachdb/cockroach/pkg/cloud/cloudpb"
 	"github.com/cockroachdb/cockroach/pkg/cloud/cloudtestutils"
@@ -110,6 +111,50 @@ func TestParseAzureURL(t *testing.T) {
 
 		require.Equal(t, azure.USGovernmentCloud.Name, sut.AzureConfig.Environment)
 	})
+
+	t.Run("Credentials", func(t *testing.T) {
+		for _, tt := range []struct {
+			params   string
+			expected cloudpb.ExternalStorage_Azure
+			err      string
+		}{
+			{
+				params:   "AZURE_SAS_TOKEN=" + url.QueryEscape("?sv=2020-08-04&sig=abc"),
+				expected: cloudpb.ExternalStorage_Azure{SASToken: "sv=2020-08-04&sig=abc"},
+			},
+			{
+				params:   "AUTH=implicit",
+				expected: cloudpb.ExternalStorage_Azure{Auth: "implicit"},
+			},
+			{
+				params:   "AUTH=implicit&AZURE_CLIENT_ID=client&AZURE_IMMUTABLE_STORAGE=true",
+				expected: cloudpb.ExternalStorage_Azure{Auth: "implicit", ClientID: "client", Immutable: true},
+			},
+			{params: "", err: `azure uri missing "AZURE_ACCOUNT_KEY" or "AZURE_SAS_TOKEN" parameter`},
+			{params: "AZURE_ACCOUNT_KEY=key&AZURE_SAS_TOKEN=sig%3Dabc", err: `cannot set both`},
+			{params: "AZURE_SAS_TOKEN=sig%3Dabc&AZURE_CLIENT_ID=client", err: `AZURE_CLIENT_ID requires AUTH to be set to 'implicit'`},
+			{params: "AUTH=implicit&AZURE_ACCOUNT_KEY=key", err: `AUTH is set to 'implicit', but AZURE_ACCOUNT_KEY or AZURE_SAS_TOKEN is set`},
+			{params: "AUTH=foo", err: `unsupported value foo for AUTH`},
+			{params: "AZURE_ACCOUNT_KEY=key&AZURE_IMMUTABLE_STORAGE=maybe", err: `parsing AZURE_IMMUTABLE_STORAGE`},
+		} {
+			t.Run(tt.params, func(t *testing.T) {
+				u, err := url.Parse("azure://container/path?AZURE_ACCOUNT_NAME=account&" + tt.params)
+				require.NoError(t, err)
+
+				sut, err := parseAzureURL(cloud.ExternalStorageURIContext{}, u)
+				if tt.err != "" {
+					require.Regexp(t, tt.err, err)
+					return
+				}
+				require.NoError(t, err)
+				tt.expected.Container = "container"
+				tt.expected.Prefix = "path"
+				tt.expected.AccountName = "account"
+				tt.expected.Environment = azure.PublicCloud.Name
+				require.Equal(t, tt.expected, *sut.AzureConfig)
+			})
+		}
+	})
 }
 
 func TestMakeAzureStorageURLFromEnvironment(t *testing.T) {
@@ -138,3 +183,35 @@ func TestMakeAzureStorageURLFromEnvironment(t *testing.T) {
 		})
 	}
 }
+
+func TestMakeAzureStorageWithSASToken(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sut, err := makeAzureStorage(context.Background(), cloud.ExternalStorageContext{}, cloudpb.ExternalStorage{
+		AzureConfig: &cloudpb.ExternalStorage_Azure{
+			Container:   "container",
+			Prefix:      "path",
+			AccountName: "account",
+			SASToken:    "sv=2020-08-04&sig=abc",
+			Environment: azure.PublicCloud.Name,
+		},
+	})
+	require.NoError(t, err)
+
+	// The shared access signature authorizes the requests to the blobs.
+	u := sut.(*azureStorage).getBlob("file").URL()
+	require.Equal(t, "https://account.blob.core.windows.net/container/path/file?sv=2020-08-04&sig=abc", u.String())
+
+	// Implicit credentials can be disallowed.
+	_, err = makeAzureStorage(context.Background(), cloud.ExternalStorageContext{
+		IOConf: base.ExternalIODirConfig{DisableImplicitCredentials: true},
+	}, cloudpb.ExternalStorage{
+		AzureConfig: &cloudpb.ExternalStorage_Azure{
+			Container:   "container",
+			AccountName: "account",
+			Auth:        cloud.AuthParamImplicit,
+			Environment: azure.PublicCloud.Name,
+		},
+	})
+	require.Regexp(t, `implicit credentials disallowed for azure`, err)
+}
@@ -70,15 +70,26 @@ var HTTPRetryOptions = retry.Options{
 // MakeHTTPClient makes an http client configured with the common settings used
 // for interacting with cloud storage (timeouts, retries, CA certs, etc).
 func MakeHTTPClient(settings *cluster.Settings) (*http.Client, error) {
+	return MakeHTTPClientWithCA(settings, nil /* caBundle */)
+}
+
+// MakeHTTPClientWithCA is like MakeHTTPClient, but the client additionally
+// trusts the PEM-encoded CA certificates of caBundle, e.g. the certificates of
+// the custom endpoint of a storage.
+func MakeHTTPClientWithCA(settings *cluster.Settings, caBundle []byte) (*http.Client, error) {
 	var tlsConf *tls.Config
-	if pem := httpCustomCA.Get(&settings.SV); pem != "" {
+	pem := httpCustomCA.Get(&settings.SV)
+	if pem != "" || len(caBundle) > 0 {
 		roots, err := x509.SystemCertPool()
 		if err != nil {
 			return nil, errors.Wrap(err, "could not load system root CA pool")
 		}
-		if !roots.AppendCertsFromPEM([]byte(pem)) {
+		if pem != "" && !roots.AppendCertsFromPEM([]byte(pem)) {
 			return nil, errors.Errorf("failed to parse root CA certificate from %q", pem)
 		}
+		if len(caBundle) > 0 && !roots.AppendCertsFromPEM(caBundle) {
+			return nil, errors.New("failed to parse the certificates of the CA bundle")
+		}
 		tlsConf = &tls.Config{RootCAs: roots}
 	}
 	t := http.DefaultTransport.(*http.Transport).Clone()
@@ -118,6 +118,11 @@ type SQLConnI interface {
 // This error is raised by the ReadFile method.
 var ErrFileDoesNotExist = errors.New("external_storage: file doesn't exist")
 
+// ErrFileAlreadyExists is a sentinel error for indicating that a file could
+// not be written because the storage does not allow it to be overwritten.
+// This error is raised by the Writer of immutable storages.
+var ErrFileAlreadyExists = errors.New("external_storage: file already exists")
+
 // ErrListingUnsupported is a marker for indicating listing is unsupported.
 var ErrListingUnsupported = errors.New("listing is not supported")
 
@@ -294,6 +294,12 @@ const (
 	// ids in sequences' back references and attempts a best-effort-based matching
 	// to update those column IDs.
 	UpdateInvalidColumnIDsInSequenceBackReferences
+	// SystemLargeObjectsTable adds system.large_objects, which stores the large
+	// objects of the lo_* functions, and system.large_object_oid_seq.
+	SystemLargeObjectsTable
+	// SystemAdvisoryLocksTable adds system.advisory_locks, which stores the
+	// session-level locks of the pg_advisory_lock family of functions.
+	SystemAdvisoryLocksTable
 
 	// *************************************************
 	// Step (1): Add new versions here.
@@ -492,6 +498,14 @@ var rawVersionsSingleton = keyedVersions{
 		Key:     UpdateInvalidColumnIDsInSequenceBackReferences,
 		Version: roachpb.Version{Major: 22, Minor: 1, Internal: 66},
 	},
+	{
+		Key:     SystemLargeObjectsTable,
+		Version: roachpb.Version{Major: 22, Minor: 1, Internal: 68},
+	},
+	{
+		Key:     SystemAdvisoryLocksTable,
+		Version: roachpb.Version{Major: 22, Minor: 1, Internal: 70},
+	},
 
 	// *************************************************
 	// Step (2): Add new versions here.
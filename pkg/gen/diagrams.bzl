@@ -262,6 +262,7 @@ DIAGRAMS_SRCS = [
   "//docs/generated/sql/bnf:upsert.html",
   "//docs/generated/sql/bnf:use.html",
   "//docs/generated/sql/bnf:validate_constraint.html",
+  "//docs/generated/sql/bnf:validate_external_connection.html",
   "//docs/generated/sql/bnf:values_clause.html",
   "//docs/generated/sql/bnf:window_definition.html",
   "//docs/generated/sql/bnf:with_clause.html",
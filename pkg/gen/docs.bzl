@@ -277,6 +277,7 @@ DOCS_SRCS = [
   "//docs/generated/sql/bnf:upsert_stmt.bnf",
   "//docs/generated/sql/bnf:use_stmt.bnf",
   "//docs/generated/sql/bnf:validate_constraint.bnf",
+  "//docs/generated/sql/bnf:validate_external_connection_stmt.bnf",
   "//docs/generated/sql/bnf:values_clause.bnf",
   "//docs/generated/sql/bnf:window_definition.bnf",
   "//docs/generated/sql/bnf:with_clause.bnf",
@@ -6,6 +6,7 @@ PROTOBUF_SRCS = [
   "//pkg/build:build_go_proto",
   "//pkg/ccl/backupccl/backuppb:backuppb_go_proto",
   "//pkg/ccl/baseccl:baseccl_go_proto",
+  "//pkg/ccl/changefeedccl/cdcsinkpb:cdcsinkpb_go_proto",
   "//pkg/ccl/sqlproxyccl/tenant:tenant_go_proto",
   "//pkg/ccl/storageccl/engineccl/enginepbccl:enginepbccl_go_proto",
   "//pkg/ccl/streamingccl/streampb:streampb_go_proto",
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"reflect"
 	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/kv"
@@ -114,6 +115,9 @@ type Record struct {
 	// CreatedBy, if set, annotates this record with the information on
 	// this job creator.
 	CreatedBy *CreatedByInfo
+	// Retention, if positive, overrides the jobs.retention_time cluster setting
+	// for this job once it reaches a terminal state.
+	Retention time.Duration
 }
 
 // AppendDescription appends description to this records Description with a
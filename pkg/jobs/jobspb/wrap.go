@@ -48,6 +48,7 @@ var (
 	_ Details = StreamReplicationDetails{}
 	_ Details = RowLevelTTLDetails{}
 	_ Details = SchemaTelemetryDetails{}
+	_ Details = ChangefeedCompactionDetails{}
 )
 
 // ProgressDetails is a marker interface for job progress details proto structs.
@@ -67,6 +68,7 @@ var (
 	_ ProgressDetails = StreamReplicationProgress{}
 	_ ProgressDetails = RowLevelTTLProgress{}
 	_ ProgressDetails = SchemaTelemetryProgress{}
+	_ ProgressDetails = ChangefeedCompactionProgress{}
 )
 
 // Type returns the payload's job type.
@@ -137,6 +139,8 @@ func DetailsType(d isPayload_Details) Type {
 		return TypeRowLevelTTL
 	case *Payload_SchemaTelemetry:
 		return TypeAutoSchemaTelemetry
+	case *Payload_ChangefeedCompaction:
+		return TypeChangefeedCompaction
 	default:
 		panic(errors.AssertionFailedf("Payload.Type called on a payload with an unknown details type: %T", d))
 	}
@@ -183,6 +187,8 @@ func WrapProgressDetails(details ProgressDetails) interface {
 		return &Progress_RowLevelTTL{RowLevelTTL: &d}
 	case SchemaTelemetryProgress:
 		return &Progress_SchemaTelemetry{SchemaTelemetry: &d}
+	case ChangefeedCompactionProgress:
+		return &Progress_ChangefeedCompaction{ChangefeedCompaction: &d}
 	default:
 		panic(errors.AssertionFailedf("WrapProgressDetails: unknown details type %T", d))
 	}
@@ -224,6 +230,8 @@ func (p *Payload) UnwrapDetails() Details {
 		return *d.RowLevelTTL
 	case *Payload_SchemaTelemetry:
 		return *d.SchemaTelemetry
+	case *Payload_ChangefeedCompaction:
+		return *d.ChangefeedCompaction
 	default:
 		return nil
 	}
@@ -265,6 +273,8 @@ func (p *Progress) UnwrapDetails() ProgressDetails {
 		return *d.RowLevelTTL
 	case *Progress_SchemaTelemetry:
 		return *d.SchemaTelemetry
+	case *Progress_ChangefeedCompaction:
+		return *d.ChangefeedCompaction
 	default:
 		return nil
 	}
@@ -319,6 +329,8 @@ func WrapPayloadDetails(details Details) interface {
 		return &Payload_RowLevelTTL{RowLevelTTL: &d}
 	case SchemaTelemetryDetails:
 		return &Payload_SchemaTelemetry{SchemaTelemetry: &d}
+	case ChangefeedCompactionDetails:
+		return &Payload_ChangefeedCompaction{ChangefeedCompaction: &d}
 	default:
 		panic(errors.AssertionFailedf("jobs.WrapPayloadDetails: unknown details type %T", d))
 	}
@@ -354,7 +366,7 @@ const (
 func (Type) SafeValue() {}
 
 // NumJobTypes is the number of jobs types.
-const NumJobTypes = 18
+const NumJobTypes = 19
 
 // MarshalJSONPB implements jsonpb.JSONPBMarshaller to  redact sensitive sink URI
 // parameters from ChangefeedDetails.
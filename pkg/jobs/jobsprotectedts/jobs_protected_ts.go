@@ -69,8 +69,10 @@ func MakeStatusFunc(
 			if err != nil {
 				return false, err
 			}
-			isTerminal := j.CheckTerminalStatus(ctx, txn)
-			return isTerminal, nil
+			if isTerminal := j.CheckTerminalStatus(ctx, txn); isTerminal {
+				return true, nil
+			}
+			return jr.MaybeExpireProtectedTimestamps(ctx, txn, j)
 		}
 	case Schedules:
 		return func(ctx context.Context, txn *kv.Txn, meta []byte) (shouldRemove bool, _ error) {
@@ -306,6 +306,7 @@ func (r *Registry) makePayload(ctx context.Context, record *Record) jobspb.Paylo
 		Noncancelable:          record.NonCancelable,
 		CreationClusterVersion: r.settings.Version.ActiveVersion(ctx).Version,
 		CreationClusterID:      r.clusterID.Get(),
+		RetentionMicros:        record.Retention.Microseconds(),
 	}
 }
 
@@ -899,15 +900,21 @@ func (r *Registry) maybeCancelJobs(ctx context.Context, s sqlliveness.Session) {
 const cleanupPageSize = 100
 
 func (r *Registry) cleanupOldJobs(ctx context.Context, olderThan time.Time) error {
-	var maxID jobspb.JobID
-	for {
-		var done bool
-		var err error
-		done, maxID, err = r.cleanupOldJobsPage(ctx, olderThan, maxID, cleanupPageSize)
-		if err != nil || done {
-			return err
+	for _, query := range []string{expiredJobsQuery, retainedJobsQuery} {
+		var maxID jobspb.JobID
+		for {
+			var done bool
+			var err error
+			done, maxID, err = r.cleanupOldJobsPage(ctx, query, olderThan, maxID, cleanupPageSize)
+			if err != nil {
+				return err
+			}
+			if done {
+				break
+			}
 		}
 	}
+	return nil
 }
 
 // TODO (sajjad): Why are we returning column 'created' in this query? It's not
@@ -917,21 +924,31 @@ const expiredJobsQuery = "SELECT id, payload, status, created FROM system.jobs "
 	"ORDER BY id " + // the ordering is important as we keep track of the maximum ID we've seen
 	"LIMIT $3"
 
-// cleanupOldJobsPage deletes up to cleanupPageSize job rows with ID > minID.
-// minID is supposed to be the maximum ID returned by the previous page (0 if no
-// previous page).
+// retainedJobsQuery reads the terminal jobs which expiredJobsQuery does not
+// cover, whose record may expire before the cluster-wide retention if the job
+// specifies its own retention (see Payload.RetentionMicros).
+const retainedJobsQuery = "SELECT id, payload, status, created FROM system.jobs " +
+	"WHERE status IN ('" + string(StatusSucceeded) + "', '" + string(StatusCanceled) + "', '" +
+	string(StatusFailed) + "') AND (created >= $1) AND (id > $2) " +
+	"ORDER BY id " +
+	"LIMIT $3"
+
+// cleanupOldJobsPage deletes the expired jobs among up to cleanupPageSize job
+// rows with ID > minID read by the query. minID is supposed to be the maximum
+// ID returned by the previous page (0 if no previous page).
 func (r *Registry) cleanupOldJobsPage(
-	ctx context.Context, olderThan time.Time, minID jobspb.JobID, pageSize int,
+	ctx context.Context, query string, olderThan time.Time, minID jobspb.JobID, pageSize int,
 ) (done bool, maxID jobspb.JobID, retErr error) {
-	it, err := r.ex.QueryIterator(ctx, "gc-jobs", nil /* txn */, expiredJobsQuery, olderThan, minID, pageSize)
+	it, err := r.ex.QueryIterator(ctx, "gc-jobs", nil /* txn */, query, olderThan, minID, pageSize)
 	if err != nil {
 		return false, 0, err
 	}
 	// We have to make sure to close the iterator since we might return from the
 	// for loop early (before Next() returns false).
 	defer func() { retErr = errors.CombineErrors(retErr, it.Close()) }()
-	toDelete := tree.NewDArray(types.Int)
+	toDelete := make(map[jobspb.JobID]expiredJob)
 	oldMicros := timeutil.ToUnixMicros(olderThan)
+	nowMicros := timeutil.ToUnixMicros(timeutil.Now())
 
 	var ok bool
 	var numRows int
@@ -943,12 +960,17 @@ func (r *Registry) cleanupOldJobsPage(
 			return false, 0, err
 		}
 		remove := false
-		switch Status(*row[2].(*tree.DString)) {
+		status := Status(*row[2].(*tree.DString))
+		switch status {
 		case StatusSucceeded, StatusCanceled, StatusFailed:
-			remove = payload.FinishedMicros < oldMicros
+			if payload.RetentionMicros > 0 {
+				remove = payload.FinishedMicros < nowMicros-payload.RetentionMicros
+			} else {
+				remove = payload.FinishedMicros < oldMicros
+			}
 		}
 		if remove {
-			toDelete.Array = append(toDelete.Array, row[0])
+			toDelete[jobspb.JobID(*row[0].(*tree.DInt))] = expiredJob{status: status, payload: payload}
 		}
 	}
 	if err != nil {
@@ -959,16 +981,8 @@ func (r *Registry) cleanupOldJobsPage(
 	}
 
 	log.VEventf(ctx, 2, "read potentially expired jobs: %d", numRows)
-	if len(toDelete.Array) > 0 {
-		log.Infof(ctx, "attempting to clean up %d expired job records", len(toDelete.Array))
-		const stmt = `DELETE FROM system.jobs WHERE id = ANY($1)`
-		var nDeleted int
-		if nDeleted, err = r.ex.Exec(
-			ctx, "gc-jobs", nil /* txn */, stmt, toDelete,
-		); err != nil {
-			return false, 0, errors.Wrap(err, "deleting old jobs")
-		}
-		log.Infof(ctx, "cleaned up %d expired job records", nDeleted)
+	if err := r.deleteExpiredJobs(ctx, toDelete); err != nil {
+		return false, 0, err
 	}
 	// If we got as many rows as we asked for, there might be more.
 	morePages := numRows == pageSize
@@ -979,6 +993,110 @@ func (r *Registry) cleanupOldJobsPage(
 	return !morePages, maxID, nil
 }
 
+// expiredJob is a terminal job whose record is to be deleted by the jobs
+// garbage collector.
+type expiredJob struct {
+	status  Status
+	payload *jobspb.Payload
+}
+
+// deleteExpiredJobs deletes the records of the jobs, and invokes the
+// RecordRemovalCleaner of the jobs whose records were deleted.
+func (r *Registry) deleteExpiredJobs(ctx context.Context, toDelete map[jobspb.JobID]expiredJob) error {
+	if len(toDelete) == 0 {
+		return nil
+	}
+	ids := tree.NewDArray(types.Int)
+	for id := range toDelete {
+		ids.Array = append(ids.Array, tree.NewDInt(tree.DInt(id)))
+	}
+	log.Infof(ctx, "attempting to clean up %d expired job records", len(toDelete))
+	const stmt = `DELETE FROM system.jobs WHERE id = ANY($1) RETURNING id, progress`
+	rows, err := r.ex.QueryBuffered(ctx, "gc-jobs", nil /* txn */, stmt, ids)
+	if err != nil {
+		return errors.Wrap(err, "deleting old jobs")
+	}
+	log.Infof(ctx, "cleaned up %d expired job records", len(rows))
+	for _, row := range rows {
+		id := jobspb.JobID(*row[0].(*tree.DInt))
+		job := toDelete[id]
+		r.maybeCleanupRemovedRecord(ctx, id, job.status, job.payload, row[1])
+	}
+	return nil
+}
+
+// maybeCleanupRemovedRecord invokes the RecordRemovalCleaner of the job, if
+// its resumer implements one, once the job record has been removed. Failures
+// are logged.
+func (r *Registry) maybeCleanupRemovedRecord(
+	ctx context.Context, id jobspb.JobID, status Status, payload *jobspb.Payload, progressDatum tree.Datum,
+) {
+	if constructors[payload.Type()] == nil {
+		return
+	}
+	job := &Job{id: id, registry: r}
+	job.mu.payload = *payload
+	job.mu.status = status
+	if progressDatum != tree.DNull {
+		progress, err := UnmarshalProgress(progressDatum)
+		if err != nil {
+			log.Warningf(ctx, "job %d: failed to unmarshal progress of removed job record: %v", id, err)
+			return
+		}
+		job.mu.progress = *progress
+	}
+	resumer, err := r.createResumer(job, r.settings)
+	if err != nil {
+		return
+	}
+	cleaner, ok := resumer.(RecordRemovalCleaner)
+	if !ok {
+		return
+	}
+	execCtx, cleanup := r.execCtx("gc-jobs", payload.UsernameProto.Decode())
+	defer cleanup()
+	if err := cleaner.OnRecordRemoval(ctx, execCtx); err != nil {
+		log.Warningf(ctx, "job %d: failed to clean up after removed job record: %v", id, err)
+	}
+}
+
+// MaybeExpireProtectedTimestamps determines whether the protected timestamp
+// records of the paused job have expired, as decided by the job's
+// ProtectedTimestampExpirer, in which case the records are released and the
+// job is marked as failed. It returns whether the records of the job should be
+// released.
+func (r *Registry) MaybeExpireProtectedTimestamps(
+	ctx context.Context, txn *kv.Txn, job *Job,
+) (bool, error) {
+	if job.Status() != StatusPaused {
+		return false, nil
+	}
+	resumer, err := r.createResumer(job, r.settings)
+	if err != nil {
+		// Jobs without a registered resumer cannot expire their records.
+		return false, nil //nolint:returnerrcheck
+	}
+	expirer, ok := resumer.(ProtectedTimestampExpirer)
+	if !ok {
+		return false, nil
+	}
+	expiredErr := expirer.CheckProtectedTimestampExpiration(ctx, r.clock.Now())
+	if expiredErr == nil {
+		return false, nil
+	}
+	log.Infof(ctx, "job %d: protected timestamp record expired: %v", job.ID(), expiredErr)
+	// A failed job does not revert, so the records are released along with
+	// the failure of the job rather than by OnFailOrCancel.
+	execCtx, cleanup := r.execCtx("expire-pts", job.Payload().UsernameProto.Decode())
+	defer cleanup()
+	if err := job.failed(ctx, txn, expiredErr, func(ctx context.Context, txn *kv.Txn) error {
+		return expirer.ReleaseExpiredProtectedTimestamps(ctx, execCtx, txn)
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // getJobFn attempts to get a resumer from the given job id. If the job id
 // does not have a resumer then it returns an error message suitable for users.
 func (r *Registry) getJobFn(
@@ -1141,6 +1259,35 @@ type PauseRequester interface {
 	OnPauseRequest(ctx context.Context, execCtx interface{}, txn *kv.Txn, details *jobspb.Progress) error
 }
 
+// RecordRemovalCleaner is an extension of Resumer which allows job implementers
+// to release resources associated with a terminal job (e.g. protected
+// timestamp records) when its record is removed by the jobs garbage collector.
+type RecordRemovalCleaner interface {
+	Resumer
+
+	// OnRecordRemoval is called once the record of the job has been deleted
+	// from system.jobs. execCtx is a sql.JobExecCtx.
+	OnRecordRemoval(ctx context.Context, execCtx interface{}) error
+}
+
+// ProtectedTimestampExpirer is an extension of Resumer which allows job
+// implementers to expire the protected timestamp records held by a paused job,
+// so that a job left paused does not hold back garbage collection forever.
+type ProtectedTimestampExpirer interface {
+	Resumer
+
+	// CheckProtectedTimestampExpiration is called by the protected timestamp
+	// reconciler while the job is paused. If it returns an error, the
+	// protected timestamp records of the job are released and the job is
+	// marked as failed with that error.
+	CheckProtectedTimestampExpiration(ctx context.Context, now hlc.Timestamp) error
+
+	// ReleaseExpiredProtectedTimestamps is called in the transaction that
+	// marks the job as failed once its records expired, to release all the
+	// protected timestamp records of the job. execCtx is a sql.JobExecCtx.
+	ReleaseExpiredProtectedTimestamps(ctx context.Context, execCtx interface{}, txn *kv.Txn) error
+}
+
 // JobResultsReporter is an interface for reporting the results of the job execution.
 // Resumer implementations may also implement this interface if they wish to return
 // data to the user upon successful completion.
@@ -282,6 +282,110 @@ func TestRegistryGCPagination(t *testing.T) {
 	require.Zero(t, count)
 }
 
+type recordRemovalResumer struct {
+	FakeResumer
+	onRecordRemoval func() error
+}
+
+var _ RecordRemovalCleaner = recordRemovalResumer{}
+
+func (r recordRemovalResumer) OnRecordRemoval(context.Context, interface{}) error {
+	return r.onRecordRemoval()
+}
+
+func TestRegistryGCJobRetention(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	defer ResetConstructors()()
+
+	var removed []jobspb.JobID
+	RegisterConstructor(jobspb.TypeImport, func(job *Job, _ *cluster.Settings) Resumer {
+		return recordRemovalResumer{onRecordRemoval: func() error {
+			removed = append(removed, job.ID())
+			return nil
+		}}
+	}, UsesTenantCostControl)
+
+	ctx := context.Background()
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SpanConfig: &spanconfig.TestingKnobs{
+				// This test directly modifies `system.jobs` and makes over its contents
+				// by querying it. We disable the auto span config reconciliation job
+				// from getting created so that we don't have to special case it in the
+				// test itself.
+				ManagerDisableJobCreation: true,
+			},
+		},
+	})
+	defer s.Stopper().Stop(ctx)
+	db := sqlutils.MakeSQLRunner(sqlDB)
+
+	ts := timeutil.Now()
+	writeJob := func(created, finished time.Time, status Status, retention time.Duration) jobspb.JobID {
+		payload, err := protoutil.Marshal(&jobspb.Payload{
+			Details:         jobspb.WrapPayloadDetails(jobspb.ImportDetails{}),
+			StartedMicros:   timeutil.ToUnixMicros(created),
+			FinishedMicros:  timeutil.ToUnixMicros(finished),
+			RetentionMicros: retention.Microseconds(),
+		})
+		require.NoError(t, err)
+		progress, err := protoutil.Marshal(&jobspb.Progress{
+			Details: jobspb.WrapProgressDetails(jobspb.ImportProgress{}),
+		})
+		require.NoError(t, err)
+
+		var id jobspb.JobID
+		db.QueryRow(t,
+			`INSERT INTO system.jobs (status, payload, progress, created) VALUES ($1, $2, $3, $4) RETURNING id`,
+			status, payload, progress, created).Scan(&id)
+		return id
+	}
+
+	twoHoursAgo := ts.Add(-2 * time.Hour)
+	tenMinutesAgo := ts.Add(-10 * time.Minute)
+	// Jobs which finished longer ago than their retention.
+	expiredSucceeded := writeJob(twoHoursAgo, twoHoursAgo, StatusSucceeded, time.Hour)
+	expiredFailed := writeJob(twoHoursAgo, twoHoursAgo, StatusFailed, time.Hour)
+	// Jobs which are still within their retention.
+	retainedSucceeded := writeJob(twoHoursAgo, tenMinutesAgo, StatusSucceeded, time.Hour)
+	// Non-terminal jobs are never removed, regardless of their retention.
+	running := writeJob(twoHoursAgo, time.Time{}, StatusRunning, time.Minute)
+	paused := writeJob(twoHoursAgo, time.Time{}, StatusPaused, time.Minute)
+	// Jobs without an explicit retention use the cluster-wide retention.
+	defaultRetention := writeJob(twoHoursAgo, twoHoursAgo, StatusSucceeded, 0)
+
+	// The cluster-wide retention (a day here) is longer than the retention of
+	// the individual jobs.
+	require.NoError(t, s.JobRegistry().(*Registry).cleanupOldJobs(ctx, ts.Add(-24*time.Hour)))
+
+	exists := func(id jobspb.JobID) bool {
+		var count int
+		db.QueryRow(t, `SELECT count(*) FROM system.jobs WHERE id = $1`, id).Scan(&count)
+		return count > 0
+	}
+	for _, id := range []jobspb.JobID{expiredSucceeded, expiredFailed} {
+		require.False(t, exists(id), "expected job %d to be removed", id)
+	}
+	for _, id := range []jobspb.JobID{retainedSucceeded, running, paused, defaultRetention} {
+		require.True(t, exists(id), "expected job %d to be retained", id)
+	}
+	require.ElementsMatch(t, []jobspb.JobID{expiredSucceeded, expiredFailed}, removed)
+
+	// The jobs whose own retention expired are read in pages, like the jobs
+	// created before the cluster-wide retention.
+	removed = nil
+	var expired []jobspb.JobID
+	for i := 0; i < cleanupPageSize+1; i++ {
+		expired = append(expired, writeJob(twoHoursAgo, twoHoursAgo, StatusCanceled, time.Hour))
+	}
+	require.NoError(t, s.JobRegistry().(*Registry).cleanupOldJobs(ctx, ts.Add(-24*time.Hour)))
+	for _, id := range expired {
+		require.False(t, exists(id), "expected job %d to be removed", id)
+	}
+	require.ElementsMatch(t, expired, removed)
+}
+
 func TestBatchJobsCreation(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
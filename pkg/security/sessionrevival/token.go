@@ -85,13 +85,7 @@ func ValidateSessionRevivalToken(
 		return err
 	}
 
-	token := &sessiondatapb.SessionRevivalToken{}
-	payload := &sessiondatapb.SessionRevivalToken_Payload{}
-	err = protoutil.Unmarshal(tokenBytes, token)
-	if err != nil {
-		return err
-	}
-	err = protoutil.Unmarshal(token.Payload, payload)
+	token, payload, err := decodeToken(tokenBytes)
 	if err != nil {
 		return err
 	}
@@ -114,6 +108,30 @@ func ValidateSessionRevivalToken(
 	return errors.New("invalid signature")
 }
 
+// DecodeSessionRevivalToken decodes the payload of a session revival token,
+// which holds the claims of the token. The token is not validated; use
+// ValidateSessionRevivalToken for that.
+func DecodeSessionRevivalToken(
+	tokenBytes []byte,
+) (*sessiondatapb.SessionRevivalToken_Payload, error) {
+	_, payload, err := decodeToken(tokenBytes)
+	return payload, err
+}
+
+func decodeToken(
+	tokenBytes []byte,
+) (*sessiondatapb.SessionRevivalToken, *sessiondatapb.SessionRevivalToken_Payload, error) {
+	token := &sessiondatapb.SessionRevivalToken{}
+	payload := &sessiondatapb.SessionRevivalToken_Payload{}
+	if err := protoutil.Unmarshal(tokenBytes, token); err != nil {
+		return nil, nil, err
+	}
+	if err := protoutil.Unmarshal(token.Payload, payload); err != nil {
+		return nil, nil, err
+	}
+	return token, payload, nil
+}
+
 func validatePayloadContents(
 	payload *sessiondatapb.SessionRevivalToken_Payload, user username.SQLUsername,
 ) error {
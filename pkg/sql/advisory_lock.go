@@ -0,0 +1,308 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/clusterunique"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgnotice"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlliveness"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq/oid"
+)
+
+// Advisory locks are the session-level locks of the pg_advisory_lock family
+// of functions. Like in Postgres, they are specific to a database, and can be
+// acquired in exclusive or shared mode. The locks are stored in the
+// system.advisory_locks table, with one row per lock, mode and holding
+// session. Each lock is acquired in its own transaction, regardless of the
+// transaction of the calling statement, and is held until it is released or
+// the session is closed. The locks of the sessions of a SQL instance whose
+// sqlliveness session has expired, e.g. because the instance crashed, are
+// free.
+
+const advisoryLocksEnabledName = "sql.advisory_locks.enabled"
+
+var advisoryLocksEnabled = settings.RegisterBoolSetting(
+	settings.TenantWritable,
+	advisoryLocksEnabledName,
+	"set to true to enable the pg_advisory_lock family of functions, which store "+
+		"session-level locks in the system.advisory_locks table",
+	false,
+).WithPublic()
+
+// advisoryLockRetryOptions are the options of the loop waiting for an advisory
+// lock held by another session.
+var advisoryLockRetryOptions = retry.Options{
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+}
+
+// advisoryLockKey identifies an advisory lock held by a session, in either
+// mode.
+type advisoryLockKey struct {
+	databaseID descpb.ID
+	id         eval.AdvisoryLockID
+	shared     bool
+}
+
+// objSubID returns the kind of key of the lock, which is 1 for a single INT8
+// key and 2 for a pair of INT4 keys, like the objsubid column of the pg_locks
+// table of Postgres.
+func (k advisoryLockKey) objSubID() int {
+	if k.id.TwoKeys {
+		return 2
+	}
+	return 1
+}
+
+// mode returns the name of the mode of the lock in Postgres.
+func (k advisoryLockKey) mode() string {
+	if k.shared {
+		return "ShareLock"
+	}
+	return "ExclusiveLock"
+}
+
+// advisoryLocks are the advisory locks held by a session, with the number of
+// times each of them was acquired.
+type advisoryLocks struct {
+	held map[advisoryLockKey]int
+}
+
+// releaseAll releases all the advisory locks held by the session.
+func (l *advisoryLocks) releaseAll(
+	ctx context.Context, db *kv.DB, ie sqlutil.InternalExecutor, sessionID clusterunique.ID,
+) error {
+	if len(l.held) == 0 {
+		return nil
+	}
+	held := l.held
+	l.held = nil
+	return db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		for key := range held {
+			if err := deleteAdvisoryLock(ctx, ie, txn, key, sessionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteAdvisoryLock deletes the row recording that the session holds the
+// advisory lock.
+func deleteAdvisoryLock(
+	ctx context.Context,
+	ie sqlutil.InternalExecutor,
+	txn *kv.Txn,
+	key advisoryLockKey,
+	sessionID clusterunique.ID,
+) error {
+	_, err := ie.ExecEx(ctx, "advisory-unlock", txn, sessiondata.NodeUserSessionDataOverride, `
+DELETE FROM system.advisory_locks
+ WHERE database_id = $1 AND objsubid = $2 AND objid = $3 AND session_id = $4 AND shared = $5`,
+		tree.NewDOid(oid.Oid(key.databaseID)), key.objSubID(), key.id.Key,
+		sessionID.GetBytes(), key.shared,
+	)
+	return err
+}
+
+func (p *planner) checkAdvisoryLocksEnabled(ctx context.Context) error {
+	if !advisoryLocksEnabled.Get(&p.ExecCfg().Settings.SV) {
+		return errors.WithHintf(
+			pgerror.New(pgcode.FeatureNotSupported, "advisory locks are not enabled"),
+			"To enable the advisory lock functions, use `SET CLUSTER SETTING %s = true`.",
+			advisoryLocksEnabledName,
+		)
+	}
+	if !p.ExecCfg().Settings.Version.IsActive(ctx, clusterversion.SystemAdvisoryLocksTable) {
+		return pgerror.Newf(pgcode.FeatureNotSupported,
+			"advisory locks are not supported until upgrade to version %v is finalized",
+			clusterversion.ByKey(clusterversion.SystemAdvisoryLocksTable))
+	}
+	return nil
+}
+
+// makeAdvisoryLockKey returns the key of the advisory lock in the current
+// database.
+func (p *planner) makeAdvisoryLockKey(
+	ctx context.Context, id eval.AdvisoryLockID, shared bool,
+) (advisoryLockKey, error) {
+	if p.CurrentDatabase() == "" {
+		return advisoryLockKey{}, pgerror.New(pgcode.UndefinedDatabase,
+			"advisory locks require a current database")
+	}
+	db, err := p.Descriptors().GetImmutableDatabaseByName(
+		ctx, p.Txn(), p.CurrentDatabase(), tree.DatabaseLookupFlags{Required: true},
+	)
+	if err != nil {
+		return advisoryLockKey{}, err
+	}
+	return advisoryLockKey{databaseID: db.GetID(), id: id, shared: shared}, nil
+}
+
+// AcquireAdvisoryLock implements the eval.AdvisoryLockOperator interface.
+func (p *planner) AcquireAdvisoryLock(
+	ctx context.Context, id eval.AdvisoryLockID, shared bool, wait bool,
+) (bool, error) {
+	if err := p.checkAdvisoryLocksEnabled(ctx); err != nil {
+		return false, err
+	}
+	key, err := p.makeAdvisoryLockKey(ctx, id, shared)
+	if err != nil {
+		return false, err
+	}
+	if n := p.advisoryLocks.held[key]; n > 0 {
+		p.advisoryLocks.held[key] = n + 1
+		return true, nil
+	}
+	for r := retry.StartWithCtx(ctx, advisoryLockRetryOptions); r.Next(); {
+		acquired, err := p.tryAcquireAdvisoryLock(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		if acquired {
+			if p.advisoryLocks.held == nil {
+				p.advisoryLocks.held = make(map[advisoryLockKey]int)
+			}
+			p.advisoryLocks.held[key] = 1
+			return true, nil
+		}
+		if !wait {
+			return false, nil
+		}
+	}
+	return false, ctx.Err()
+}
+
+// tryAcquireAdvisoryLock records the session as a holder of the advisory lock,
+// unless the lock is held by another session in a conflicting mode, and
+// returns whether it was acquired. The rows of the holders in a conflicting
+// mode whose session is closed are deleted.
+func (p *planner) tryAcquireAdvisoryLock(ctx context.Context, key advisoryLockKey) (bool, error) {
+	instanceSession, err := p.ExecCfg().SQLLiveness.Session(ctx)
+	if err != nil {
+		return false, err
+	}
+	ie := p.ExecCfg().InternalExecutor
+	sessionID := p.ExtendedEvalContext().SessionID
+	databaseID := tree.NewDOid(oid.Oid(key.databaseID))
+	var acquired bool
+	err = p.ExecCfg().DB.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		acquired = false
+		rows, err := ie.QueryBufferedEx(ctx, "advisory-lock-holders", txn,
+			sessiondata.NodeUserSessionDataOverride, `
+SELECT session_id, shared, sqlliveness_session FROM system.advisory_locks
+ WHERE database_id = $1 AND objsubid = $2 AND objid = $3`,
+			databaseID, key.objSubID(), key.id.Key)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			holder := clusterunique.IDFromBytes([]byte(tree.MustBeDBytes(row[0])))
+			holderShared := bool(tree.MustBeDBool(row[1]))
+			if holder == sessionID || (key.shared && holderShared) {
+				continue
+			}
+			held, err := p.advisoryLockHeldByOtherSession(
+				ctx, instanceSession.ID(), sqlliveness.SessionID(tree.MustBeDBytes(row[2])), holder,
+			)
+			if err != nil || held {
+				return err
+			}
+			stale := advisoryLockKey{databaseID: key.databaseID, id: key.id, shared: holderShared}
+			if err := deleteAdvisoryLock(ctx, ie, txn, stale, holder); err != nil {
+				return err
+			}
+		}
+		if _, err := ie.ExecEx(ctx, "advisory-lock", txn, sessiondata.NodeUserSessionDataOverride, `
+UPSERT INTO system.advisory_locks
+  (database_id, objsubid, objid, session_id, shared, sqlliveness_session)
+VALUES ($1, $2, $3, $4, $5, $6)`,
+			databaseID, key.objSubID(), key.id.Key, sessionID.GetBytes(), key.shared,
+			instanceSession.ID().UnsafeBytes(),
+		); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	return acquired, err
+}
+
+// advisoryLockHeldByOtherSession returns whether the holder of an advisory
+// lock is another session which is still open. The sessions of the other SQL
+// instances are assumed to be open as long as the sqlliveness session of their
+// instance is alive.
+func (p *planner) advisoryLockHeldByOtherSession(
+	ctx context.Context,
+	instanceSession sqlliveness.SessionID,
+	holderInstanceSession sqlliveness.SessionID,
+	holder clusterunique.ID,
+) (bool, error) {
+	if holder == p.ExtendedEvalContext().SessionID {
+		return false, nil
+	}
+	if holderInstanceSession == instanceSession {
+		return p.ExecCfg().SessionRegistry.hasSession(holder), nil
+	}
+	return p.ExecCfg().SQLLiveness.IsAlive(ctx, holderInstanceSession)
+}
+
+// ReleaseAdvisoryLock implements the eval.AdvisoryLockOperator interface. The
+// locks held by the session can be released even if the advisory locks have
+// been disabled since they were acquired.
+func (p *planner) ReleaseAdvisoryLock(
+	ctx context.Context, id eval.AdvisoryLockID, shared bool,
+) (bool, error) {
+	key, err := p.makeAdvisoryLockKey(ctx, id, shared)
+	if err != nil {
+		return false, err
+	}
+	n := p.advisoryLocks.held[key]
+	switch {
+	case n == 0:
+		p.BufferClientNotice(ctx, pgnotice.NewWithSeverityf("WARNING",
+			"you don't own a lock of type %s", key.mode()))
+		return false, nil
+	case n > 1:
+		p.advisoryLocks.held[key] = n - 1
+		return true, nil
+	}
+	delete(p.advisoryLocks.held, key)
+	if err := deleteAdvisoryLock(
+		ctx, p.ExecCfg().InternalExecutor, nil /* txn */, key, p.ExtendedEvalContext().SessionID,
+	); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseAllAdvisoryLocks implements the eval.AdvisoryLockOperator interface.
+func (p *planner) ReleaseAllAdvisoryLocks(ctx context.Context) error {
+	return p.advisoryLocks.releaseAll(
+		ctx, p.ExecCfg().DB, p.ExecCfg().InternalExecutor, p.ExtendedEvalContext().SessionID,
+	)
+}
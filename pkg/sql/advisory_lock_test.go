@@ -0,0 +1,204 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package sql_test
+
+import (
+	"context"
+	gosql "database/sql"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+func TestAdvisoryLocks(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, `SET CLUSTER SETTING sql.advisory_locks.enabled = true`)
+
+	// Each of the sessions uses a single connection, so that closing it closes
+	// the session.
+	openSession := func() (*gosql.DB, *sqlutils.SQLRunner) {
+		conn := serverutils.OpenDBConn(
+			t, s.ServingSQLAddr(), "defaultdb", false /* insecure */, s.Stopper(),
+		)
+		conn.SetMaxOpenConns(1)
+		return conn, sqlutils.MakeSQLRunner(conn)
+	}
+	_, session1 := openSession()
+	conn2, session2 := openSession()
+	_, session3 := openSession()
+
+	tryLock := func(session *sqlutils.SQLRunner, args string) bool {
+		var acquired bool
+		session.QueryRow(t, `SELECT pg_try_advisory_lock(`+args+`)`).Scan(&acquired)
+		return acquired
+	}
+	unlock := func(session *sqlutils.SQLRunner, args string) bool {
+		var released bool
+		session.QueryRow(t, `SELECT pg_advisory_unlock(`+args+`)`).Scan(&released)
+		return released
+	}
+	tryLockShared := func(session *sqlutils.SQLRunner, args string) bool {
+		var acquired bool
+		session.QueryRow(t, `SELECT pg_try_advisory_lock_shared(`+args+`)`).Scan(&acquired)
+		return acquired
+	}
+	unlockShared := func(session *sqlutils.SQLRunner, args string) bool {
+		var released bool
+		session.QueryRow(t, `SELECT pg_advisory_unlock_shared(`+args+`)`).Scan(&released)
+		return released
+	}
+
+	t.Run("contention", func(t *testing.T) {
+		// The locks are reentrant.
+		session1.Exec(t, `SELECT pg_advisory_lock(1)`)
+		if !tryLock(session1, "1") {
+			t.Fatal("expected session 1 to acquire the lock it holds")
+		}
+		if tryLock(session2, "1") {
+			t.Fatal("expected session 2 to fail to acquire the lock held by session 1")
+		}
+		if unlock(session2, "1") {
+			t.Fatal("expected session 2 to fail to release the lock held by session 1")
+		}
+		// The locks identified by two keys are distinct from the locks
+		// identified by a single key.
+		if !tryLock(session2, "0, 1") {
+			t.Fatal("expected session 2 to acquire the lock identified by two keys")
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn2.Exec(`SELECT pg_advisory_lock(1)`)
+			done <- err
+		}()
+		// The lock was acquired twice, so it is held until it is released
+		// twice.
+		if !unlock(session1, "1") {
+			t.Fatal("expected session 1 to release the lock")
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("expected session 2 to wait for the lock, got %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if !unlock(session1, "1") {
+			t.Fatal("expected session 1 to release the lock")
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if tryLock(session1, "1") {
+			t.Fatal("expected session 1 to fail to acquire the lock held by session 2")
+		}
+	})
+
+	t.Run("per database", func(t *testing.T) {
+		session3.Exec(t, `USE postgres`)
+		defer session3.Exec(t, `USE defaultdb`)
+		if !tryLock(session3, "1") {
+			t.Fatal("expected session 3 to acquire the lock in another database")
+		}
+		session3.Exec(t, `SELECT pg_advisory_unlock_all()`)
+	})
+
+	t.Run("not transactional", func(t *testing.T) {
+		session1.Exec(t, `BEGIN`)
+		if !tryLock(session1, "2") {
+			t.Fatal("expected session 1 to acquire the lock")
+		}
+		session1.Exec(t, `ROLLBACK`)
+		if tryLock(session3, "2") {
+			t.Fatal("expected the lock to be held after the transaction is rolled back")
+		}
+		session1.Exec(t, `SELECT pg_advisory_unlock_all()`)
+		if !tryLock(session3, "2") {
+			t.Fatal("expected session 3 to acquire the lock released by session 1")
+		}
+	})
+
+	t.Run("system table", func(t *testing.T) {
+		// The locks are stored in system.advisory_locks rather than in the
+		// current database.
+		sqlDB.CheckQueryResults(t,
+			`SELECT objsubid, objid, shared FROM system.advisory_locks ORDER BY objsubid, objid`,
+			[][]string{{"1", "1", "false"}, {"1", "2", "false"}, {"2", "1", "false"}},
+		)
+		sqlDB.CheckQueryResults(t, `SELECT count(*) FROM [SHOW TABLES FROM defaultdb]`,
+			[][]string{{"0"}})
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		if !tryLockShared(session1, "3") || !tryLockShared(session3, "3") {
+			t.Fatal("expected both sessions to acquire the shared lock")
+		}
+		if tryLock(session3, "3") {
+			t.Fatal("expected session 3 to fail to acquire the lock held in shared mode by session 1")
+		}
+		if unlock(session1, "3") {
+			t.Fatal("expected session 1 to fail to release the lock it holds in shared mode only")
+		}
+		if !unlockShared(session1, "3") {
+			t.Fatal("expected session 1 to release the shared lock")
+		}
+		// The shared lock held by a session does not prevent the session from
+		// acquiring the lock in exclusive mode.
+		if !tryLock(session3, "3") {
+			t.Fatal("expected session 3 to acquire the lock it holds in shared mode")
+		}
+		if tryLockShared(session1, "3") {
+			t.Fatal("expected session 1 to fail to acquire the lock held in exclusive mode by session 3")
+		}
+		session3.Exec(t, `SELECT pg_advisory_unlock_all()`)
+		if !tryLockShared(session1, "3") {
+			t.Fatal("expected session 1 to acquire the shared lock released by session 3")
+		}
+		session1.Exec(t, `SELECT pg_advisory_unlock_shared(3)`)
+	})
+
+	t.Run("release on disconnect", func(t *testing.T) {
+		if err := conn2.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testutils.SucceedsSoon(t, func() error {
+			if !tryLock(session1, "1") || !tryLock(session1, "0, 1") {
+				return errors.New("the locks of session 2 are still held")
+			}
+			return nil
+		})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		sqlDB.Exec(t, `SET CLUSTER SETTING sql.advisory_locks.enabled = false`)
+		session3.ExpectErr(t, "advisory locks are not enabled", `SELECT pg_try_advisory_lock(4)`)
+		session3.ExpectErr(t, "advisory locks are not enabled", `SELECT pg_advisory_lock_shared(4)`)
+		if unlock(session3, "4") {
+			t.Fatal("expected session 3 to fail to release a lock it does not hold")
+		}
+		// The locks held by a session can still be released.
+		if !unlock(session1, "1") {
+			t.Fatal("expected session 1 to release the lock")
+		}
+	})
+}
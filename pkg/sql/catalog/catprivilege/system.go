@@ -64,10 +64,13 @@ var (
 		catconstants.SpanCountTableName,
 		catconstants.SystemPrivilegeTableName,
 		catconstants.SystemExternalConnectionsTableName,
+		catconstants.LargeObjectsTableName,
+		catconstants.AdvisoryLocksTableName,
 	}
 
 	readWriteSystemSequences = []catconstants.SystemTableName{
 		catconstants.RoleIDSequenceName,
+		catconstants.LargeObjectOIDSequenceName,
 	}
 
 	systemSuperuserPrivileges = func() map[descpb.NameInfo]privilege.List {
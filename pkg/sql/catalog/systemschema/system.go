@@ -698,6 +698,40 @@ CREATE TABLE system.external_connections (
 	CONSTRAINT "primary" PRIMARY KEY (connection_name),
 	FAMILY "primary" (connection_name, created, updated, connection_type, connection_details, owner)
 );`
+
+	// LargeObjectsTableSchema stores the large objects of the lo_* functions,
+	// in chunks. The owner of a large object is recorded in its first chunk,
+	// which always exists.
+	LargeObjectsTableSchema = `
+CREATE TABLE system.large_objects (
+	loid OID NOT NULL,
+	pageno INT4 NOT NULL,
+	data BYTES NOT NULL,
+	owner STRING NULL,
+	CONSTRAINT "primary" PRIMARY KEY (loid, pageno),
+	FAMILY "primary" (loid, pageno, data, owner)
+);`
+
+	// LargeObjectOIDSequenceSchema starts at the first OID Postgres assigns to
+	// user objects.
+	LargeObjectOIDSequenceSchema = `
+CREATE SEQUENCE system.large_object_oid_seq START 16384 MINVALUE 16384 MAXVALUE 4294967295;`
+
+	// AdvisoryLocksTableSchema stores the session-level locks of the
+	// pg_advisory_lock family of functions. A lock is held by a session until
+	// it is released, the session is closed, or the sqlliveness session of the
+	// SQL instance of the session expires.
+	AdvisoryLocksTableSchema = `
+CREATE TABLE system.advisory_locks (
+	database_id OID NOT NULL,
+	objsubid INT2 NOT NULL,
+	objid INT8 NOT NULL,
+	session_id BYTES NOT NULL,
+	shared BOOL NOT NULL,
+	sqlliveness_session BYTES NOT NULL,
+	CONSTRAINT "primary" PRIMARY KEY (database_id, objsubid, objid, session_id, shared),
+	FAMILY "primary" (database_id, objsubid, objid, session_id, shared, sqlliveness_session)
+);`
 )
 
 func pk(name string) descpb.IndexDescriptor {
@@ -2562,6 +2596,115 @@ var (
 			},
 		),
 	)
+
+	LargeObjectsTable = registerSystemTable(
+		LargeObjectsTableSchema,
+		systemTable(
+			catconstants.LargeObjectsTableName,
+			descpb.InvalidID, // dynamically assigned
+			[]descpb.ColumnDescriptor{
+				{Name: "loid", ID: 1, Type: types.Oid},
+				{Name: "pageno", ID: 2, Type: types.Int4},
+				{Name: "data", ID: 3, Type: types.Bytes},
+				{Name: "owner", ID: 4, Type: types.String, Nullable: true},
+			},
+			[]descpb.ColumnFamilyDescriptor{
+				{
+					Name:        "primary",
+					ID:          0,
+					ColumnNames: []string{"loid", "pageno", "data", "owner"},
+					ColumnIDs:   []descpb.ColumnID{1, 2, 3, 4},
+				},
+			},
+			descpb.IndexDescriptor{
+				Name:                "primary",
+				ID:                  1,
+				Unique:              true,
+				KeyColumnNames:      []string{"loid", "pageno"},
+				KeyColumnDirections: []catpb.IndexColumn_Direction{catpb.IndexColumn_ASC, catpb.IndexColumn_ASC},
+				KeyColumnIDs:        []descpb.ColumnID{1, 2},
+			},
+		),
+	)
+
+	// LargeObjectOIDSequence is the descriptor for the sequence allocating the
+	// OIDs of large objects.
+	LargeObjectOIDSequence = registerSystemTable(
+		LargeObjectOIDSequenceSchema,
+		systemTable(
+			catconstants.LargeObjectOIDSequenceName,
+			descpb.InvalidID, // dynamically assigned
+			[]descpb.ColumnDescriptor{
+				{Name: tabledesc.SequenceColumnName, ID: tabledesc.SequenceColumnID, Type: types.Int},
+			},
+			[]descpb.ColumnFamilyDescriptor{{
+				Name:            "primary",
+				ID:              keys.SequenceColumnFamilyID,
+				ColumnNames:     []string{tabledesc.SequenceColumnName},
+				ColumnIDs:       []descpb.ColumnID{tabledesc.SequenceColumnID},
+				DefaultColumnID: tabledesc.SequenceColumnID,
+			}},
+			descpb.IndexDescriptor{
+				ID:                  keys.SequenceIndexID,
+				Name:                tabledesc.LegacyPrimaryKeyIndexName,
+				KeyColumnIDs:        []descpb.ColumnID{tabledesc.SequenceColumnID},
+				KeyColumnNames:      []string{tabledesc.SequenceColumnName},
+				KeyColumnDirections: []catpb.IndexColumn_Direction{catpb.IndexColumn_ASC},
+			},
+		),
+		func(tbl *descpb.TableDescriptor) {
+			opts := &descpb.TableDescriptor_SequenceOpts{
+				Increment: 1,
+				MinValue:  16384,
+				MaxValue:  math.MaxUint32,
+				Start:     16384,
+				CacheSize: 1,
+			}
+			tbl.SequenceOpts = opts
+			tbl.NextColumnID = 0
+			tbl.NextFamilyID = 0
+			tbl.NextIndexID = 0
+			tbl.NextMutationID = 0
+			// See RoleIDSequence.
+			tbl.NextConstraintID = 0
+			tbl.PrimaryIndex.ConstraintID = 0
+		},
+	)
+
+	AdvisoryLocksTable = registerSystemTable(
+		AdvisoryLocksTableSchema,
+		systemTable(
+			catconstants.AdvisoryLocksTableName,
+			descpb.InvalidID, // dynamically assigned
+			[]descpb.ColumnDescriptor{
+				{Name: "database_id", ID: 1, Type: types.Oid},
+				{Name: "objsubid", ID: 2, Type: types.Int2},
+				{Name: "objid", ID: 3, Type: types.Int},
+				{Name: "session_id", ID: 4, Type: types.Bytes},
+				{Name: "shared", ID: 5, Type: types.Bool},
+				{Name: "sqlliveness_session", ID: 6, Type: types.Bytes},
+			},
+			[]descpb.ColumnFamilyDescriptor{
+				{
+					Name:        "primary",
+					ID:          0,
+					ColumnNames: []string{"database_id", "objsubid", "objid", "session_id", "shared", "sqlliveness_session"},
+					ColumnIDs:   []descpb.ColumnID{1, 2, 3, 4, 5, 6},
+				},
+			},
+			descpb.IndexDescriptor{
+				Name:           "primary",
+				ID:             1,
+				Unique:         true,
+				KeyColumnNames: []string{"database_id", "objsubid", "objid", "session_id", "shared"},
+				KeyColumnDirections: []catpb.IndexColumn_Direction{
+					catpb.IndexColumn_ASC, catpb.IndexColumn_ASC, catpb.IndexColumn_ASC,
+					catpb.IndexColumn_ASC, catpb.IndexColumn_ASC,
+				},
+				KeyColumnIDs: []descpb.ColumnID{1, 2, 3, 4, 5},
+			},
+		),
+	)
 )
 
 type descRefByName struct {
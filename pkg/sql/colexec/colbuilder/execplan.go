@@ -224,9 +224,6 @@ func supportedNatively(core *execinfrapb.ProcessorCoreUnion) error {
 
 	case core.Windower != nil:
 		for _, wf := range core.Windower.WindowFns {
-			if wf.FilterColIdx != tree.NoColumnIdx {
-				return errors.Newf("window functions with FILTER clause are not supported")
-			}
 			if wf.Func.AggregateFunc != nil {
 				if !colexecagg.IsAggOptimized(*wf.Func.AggregateFunc) {
 					return errors.Newf("default aggregate window functions not supported")
@@ -1413,7 +1410,9 @@ func NewColOperator(
 					switch *wf.Func.AggregateFunc {
 					case execinfrapb.CountRows:
 						// count_rows has a specialized implementation.
-						result.Root = colexecwindow.NewCountRowsOperator(windowArgs, wf.Frame, &wf.Ordering)
+						result.Root = colexecwindow.NewCountRowsOperator(
+							windowArgs, wf.Frame, &wf.Ordering, int(wf.FilterColIdx),
+						)
 					default:
 						aggArgs := colexecagg.NewAggregatorArgs{
 							Allocator:  windowArgs.MainAllocator,
@@ -1436,9 +1435,11 @@ func NewColOperator(
 						var aggFnsAlloc *colexecagg.AggregateFuncsAlloc
 						if (aggType != execinfrapb.Min && aggType != execinfrapb.Max) ||
 							wf.Frame.Exclusion != execinfrapb.WindowerSpec_Frame_NO_EXCLUSION ||
+							wf.FilterColIdx != tree.NoColumnIdx ||
 							!colexecwindow.WindowFrameCanShrink(wf.Frame, &wf.Ordering) {
 							// Min and max window functions have specialized implementations
-							// when the frame can shrink and has a default exclusion clause.
+							// when the frame can shrink and has a default exclusion clause
+							// and no FILTER clause.
 							aggFnsAlloc, _, toClose, err = colexecagg.NewAggregateFuncsAlloc(
 								&aggArgs, aggregations, 1 /* allocSize */, colexecagg.WindowAggKind,
 							)
@@ -1448,7 +1449,7 @@ func NewColOperator(
 						}
 						result.Root = colexecwindow.NewWindowAggregatorOperator(
 							windowArgs, aggType, wf.Frame, &wf.Ordering, argIdxs,
-							aggArgs.OutputTypes[0], aggFnsAlloc,
+							int(wf.FilterColIdx), aggArgs.OutputTypes[0], aggFnsAlloc,
 						)
 						result.ToClose = append(result.ToClose, toClose...)
 						returnType = aggArgs.OutputTypes[0]
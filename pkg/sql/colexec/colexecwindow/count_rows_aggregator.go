@@ -18,13 +18,19 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/colexecop"
 	"github.com/cockroachdb/cockroach/pkg/sql/colmem"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 )
 
 // NewCountRowsOperator creates a new Operator that computes the count_rows
-// aggregate window function.
+// aggregate window function. filterColIdx is the index of the boolean column of
+// the FILTER clause, or tree.NoColumnIdx if there is none: only the rows for
+// which it is true are counted.
 func NewCountRowsOperator(
-	args *WindowArgs, frame *execinfrapb.WindowerSpec_Frame, ordering *execinfrapb.Ordering,
+	args *WindowArgs,
+	frame *execinfrapb.WindowerSpec_Frame,
+	ordering *execinfrapb.Ordering,
+	filterColIdx int,
 ) colexecop.ClosableOperator {
 	// Because the buffer is potentially used multiple times per-row, it is
 	// important to prevent it from spilling to disk if possible. For this reason,
@@ -33,7 +39,12 @@ func NewCountRowsOperator(
 	bufferMemLimit := int64(float64(args.MemoryLimit) * 0.5)
 	mainMemLimit := args.MemoryLimit - bufferMemLimit
 	framer := newWindowFramer(args.EvalCtx, frame, ordering, args.InputTypes, args.PeersColIdx)
-	colsToStore := framer.getColsToStore(nil /* oldColsToStore */)
+	var colsToStore []int
+	if filterColIdx != tree.NoColumnIdx {
+		// The filter column is the first stored column.
+		colsToStore = append(colsToStore, filterColIdx)
+	}
+	colsToStore = framer.getColsToStore(colsToStore)
 	buffer := colexecutils.NewSpillingBuffer(
 		args.BufferAllocator, bufferMemLimit, args.QueueCfg,
 		args.FdSemaphore, args.InputTypes, args.DiskAcc, colsToStore...)
@@ -45,6 +56,7 @@ func NewCountRowsOperator(
 		allocator:    args.MainAllocator,
 		outputColIdx: args.OutputColIdx,
 		framer:       framer,
+		filtered:     filterColIdx != tree.NoColumnIdx,
 	}
 	return newBufferedWindowOperator(args, windower, types.Int, mainMemLimit)
 }
@@ -55,6 +67,14 @@ type countRowsWindowAggregator struct {
 	allocator    *colmem.Allocator
 	outputColIdx int
 	framer       windowFramer
+
+	// filtered is true if there is a FILTER clause, in which case the filter
+	// column is stored first in the buffer, and cnt is the number of rows which
+	// pass the filter in the frame of the previous row of the partition. The
+	// frames are then processed as sliding windows, like for the aggregate
+	// functions which support removing rows.
+	filtered bool
+	cnt      int
 }
 
 var _ bufferedWindower = &countRowsWindowAggregator{}
@@ -68,6 +88,7 @@ func (a *countRowsWindowAggregator) transitionToProcessing() {
 func (a *countRowsWindowAggregator) startNewPartition() {
 	a.partitionSize = 0
 	a.buffer.Reset(a.Ctx)
+	a.cnt = 0
 }
 
 // Init implements the bufferedWindower interface.
@@ -91,6 +112,10 @@ func (a *countRowsWindowAggregator) processBatch(batch coldata.Batch, startIdx,
 		return
 	}
 	outVec := batch.ColVec(a.outputColIdx)
+	if a.filtered {
+		a.processBatchWithFilter(outVec, startIdx, endIdx)
+		return
+	}
 	a.allocator.PerformOperation([]coldata.Vec{outVec}, func() {
 		outCol := outVec.Int64()
 		_, _ = outCol[startIdx], outCol[endIdx-1]
@@ -105,3 +130,45 @@ func (a *countRowsWindowAggregator) processBatch(batch coldata.Batch, startIdx,
 		}
 	})
 }
+
+// processBatchWithFilter is like processBatch, but only counts the rows which
+// pass the filter.
+func (a *countRowsWindowAggregator) processBatchWithFilter(
+	outVec coldata.Vec, startIdx, endIdx int,
+) {
+	a.allocator.PerformOperation([]coldata.Vec{outVec}, func() {
+		outCol := outVec.Int64()
+		_, _ = outCol[startIdx], outCol[endIdx-1]
+		for i := startIdx; i < endIdx; i++ {
+			a.framer.next(a.Ctx)
+			toAdd, toRemove := a.framer.slidingWindowIntervals()
+			a.cnt -= a.countFiltered(toRemove)
+			a.cnt += a.countFiltered(toAdd)
+			//gcassert:bce
+			outCol[i] = int64(a.cnt)
+		}
+	})
+}
+
+// countFiltered returns the number of rows in the intervals which pass the
+// filter.
+func (a *countRowsWindowAggregator) countFiltered(intervals []windowInterval) int {
+	var cnt int
+	for _, interval := range intervals {
+		for idx := interval.start; idx < interval.end; {
+			vec, start, end := a.buffer.GetVecWithTuple(a.Ctx, 0 /* colIdx */, idx)
+			if end-start > interval.end-idx {
+				// This is the last batch in the current interval.
+				end = start + interval.end - idx
+			}
+			idx += end - start
+			col, nulls := vec.Bool(), vec.Nulls()
+			for j := start; j < end; j++ {
+				if col[j] && !nulls.NullAt(j) {
+					cnt++
+				}
+			}
+		}
+	}
+	return cnt
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/colexecop"
 	"github.com/cockroachdb/cockroach/pkg/sql/colmem"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 )
 
@@ -43,12 +44,16 @@ type slidingWindowAggregateFunc interface {
 // NewWindowAggregatorOperator creates a new Operator that computes aggregate
 // window functions. outputColIdx specifies in which coldata.Vec the operator
 // should put its output (if there is no such column, a new column is appended).
+// filterColIdx is the index of the boolean column of the FILTER clause, or
+// tree.NoColumnIdx if there is none: only the rows for which it is true are
+// aggregated.
 func NewWindowAggregatorOperator(
 	args *WindowArgs,
 	aggType execinfrapb.AggregatorSpec_Func,
 	frame *execinfrapb.WindowerSpec_Frame,
 	ordering *execinfrapb.Ordering,
 	argIdxs []int,
+	filterColIdx int,
 	outputType *types.T,
 	aggAlloc *colexecagg.AggregateFuncsAlloc,
 ) colexecop.ClosableOperator {
@@ -59,7 +64,14 @@ func NewWindowAggregatorOperator(
 	bufferMemLimit := int64(float64(args.MemoryLimit) * 0.5)
 	mainMemLimit := args.MemoryLimit - bufferMemLimit
 	framer := newWindowFramer(args.EvalCtx, frame, ordering, args.InputTypes, args.PeersColIdx)
-	colsToStore := framer.getColsToStore(append([]int{}, argIdxs...))
+	colsToStore := append([]int{}, argIdxs...)
+	filterIdx := tree.NoColumnIdx
+	if filterColIdx != tree.NoColumnIdx {
+		// The filter column is stored right after the argument columns.
+		filterIdx = len(colsToStore)
+		colsToStore = append(colsToStore, filterColIdx)
+	}
+	colsToStore = framer.getColsToStore(colsToStore)
 	buffer := colexecutils.NewSpillingBuffer(
 		args.BufferAllocator, bufferMemLimit, args.QueueCfg,
 		args.FdSemaphore, args.InputTypes, args.DiskAcc, colsToStore...)
@@ -76,6 +88,7 @@ func NewWindowAggregatorOperator(
 		allocator:    args.MainAllocator,
 		outputColIdx: args.OutputColIdx,
 		inputIdxs:    inputIdxs,
+		filterIdx:    filterIdx,
 		framer:       framer,
 		vecs:         make([]coldata.Vec, len(inputIdxs)),
 	}
@@ -90,9 +103,11 @@ func NewWindowAggregatorOperator(
 			// In the case when the window frame for a given row does not necessarily
 			// include all rows from the previous frame, min and max require a
 			// specialized implementation that maintains a dequeue of seen values.
-			if frame.Exclusion != execinfrapb.WindowerSpec_Frame_NO_EXCLUSION {
+			if frame.Exclusion != execinfrapb.WindowerSpec_Frame_NO_EXCLUSION ||
+				filterColIdx != tree.NoColumnIdx {
 				// TODO(drewk): extend the implementations to work with non-default
-				// exclusion. For now, we have to use the quadratic-time method.
+				// exclusion and with a FILTER clause. For now, we have to use the
+				// quadratic-time method.
 				windower = &windowAggregator{windowAggregatorBase: base, agg: agg}
 			} else {
 				switch aggType {
@@ -129,6 +144,12 @@ type windowAggregatorBase struct {
 	inputIdxs    []uint32
 	vecs         []coldata.Vec
 	framer       windowFramer
+
+	// filterIdx is the index of the FILTER column in the buffer, or
+	// tree.NoColumnIdx if there is no FILTER clause. filterVec is the vector of
+	// that column containing the rows being aggregated.
+	filterIdx int
+	filterVec coldata.Vec
 }
 
 type windowAggregator struct {
@@ -208,13 +229,27 @@ func (a *windowAggregator) processBatch(batch coldata.Batch, startIdx, endIdx in
 						for j, idx := range a.inputIdxs {
 							a.vecs[j], start, end = a.buffer.GetVecWithTuple(a.Ctx, int(idx), intervalIdx)
 						}
+						if a.filterIdx != tree.NoColumnIdx {
+							a.filterVec, start, end = a.buffer.GetVecWithTuple(a.Ctx, a.filterIdx, intervalIdx)
+						}
 						if intervalLen < (end - start) {
 							// This is the last batch in the current interval.
 							end = start + intervalLen
 						}
 						intervalIdx += end - start
 						intervalLen -= end - start
-						a.agg.Compute(a.vecs, a.inputIdxs, start, end, nil /* sel */)
+						if a.filterIdx == tree.NoColumnIdx {
+							a.agg.Compute(a.vecs, a.inputIdxs, start, end, nil /* sel */)
+						} else {
+							// Only the runs of consecutive rows passing the filter are
+							// aggregated, so that the rows are removed from the aggregation in
+							// the same way as they were added.
+							runStart, runEnd := nextFilteredRun(a.filterVec, start, end)
+							for runStart < runEnd {
+								a.agg.Compute(a.vecs, a.inputIdxs, runStart, runEnd, nil /* sel */)
+								runStart, runEnd = nextFilteredRun(a.filterVec, runEnd, end)
+							}
+						}
 					}
 				}
 			}
@@ -256,13 +291,27 @@ func (a *slidingWindowAggregator) processBatch(batch coldata.Batch, startIdx, en
 						for j, idx := range a.inputIdxs {
 							a.vecs[j], start, end = a.buffer.GetVecWithTuple(a.Ctx, int(idx), intervalIdx)
 						}
+						if a.filterIdx != tree.NoColumnIdx {
+							a.filterVec, start, end = a.buffer.GetVecWithTuple(a.Ctx, a.filterIdx, intervalIdx)
+						}
 						if intervalLen < (end - start) {
 							// This is the last batch in the current interval.
 							end = start + intervalLen
 						}
 						intervalIdx += end - start
 						intervalLen -= end - start
-						a.agg.Remove(a.vecs, a.inputIdxs, start, end)
+						if a.filterIdx == tree.NoColumnIdx {
+							a.agg.Remove(a.vecs, a.inputIdxs, start, end)
+						} else {
+							// Only the runs of consecutive rows passing the filter are
+							// aggregated, so that the rows are removed from the aggregation in
+							// the same way as they were added.
+							runStart, runEnd := nextFilteredRun(a.filterVec, start, end)
+							for runStart < runEnd {
+								a.agg.Remove(a.vecs, a.inputIdxs, runStart, runEnd)
+								runStart, runEnd = nextFilteredRun(a.filterVec, runEnd, end)
+							}
+						}
 					}
 				}
 			}
@@ -278,13 +327,27 @@ func (a *slidingWindowAggregator) processBatch(batch coldata.Batch, startIdx, en
 						for j, idx := range a.inputIdxs {
 							a.vecs[j], start, end = a.buffer.GetVecWithTuple(a.Ctx, int(idx), intervalIdx)
 						}
+						if a.filterIdx != tree.NoColumnIdx {
+							a.filterVec, start, end = a.buffer.GetVecWithTuple(a.Ctx, a.filterIdx, intervalIdx)
+						}
 						if intervalLen < (end - start) {
 							// This is the last batch in the current interval.
 							end = start + intervalLen
 						}
 						intervalIdx += end - start
 						intervalLen -= end - start
-						a.agg.Compute(a.vecs, a.inputIdxs, start, end, nil /* sel */)
+						if a.filterIdx == tree.NoColumnIdx {
+							a.agg.Compute(a.vecs, a.inputIdxs, start, end, nil /* sel */)
+						} else {
+							// Only the runs of consecutive rows passing the filter are
+							// aggregated, so that the rows are removed from the aggregation in
+							// the same way as they were added.
+							runStart, runEnd := nextFilteredRun(a.filterVec, start, end)
+							for runStart < runEnd {
+								a.agg.Compute(a.vecs, a.inputIdxs, runStart, runEnd, nil /* sel */)
+								runStart, runEnd = nextFilteredRun(a.filterVec, runEnd, end)
+							}
+						}
 					}
 				}
 			}
@@ -38,9 +38,14 @@ type windowFnTestCase struct {
 	tuples       []colexectestutils.Tuple
 	expected     []colexectestutils.Tuple
 	windowerSpec execinfrapb.WindowerSpec
+	// filtered indicates that the FilterColIdx of the window functions is set.
+	filtered bool
 }
 
 func (tc *windowFnTestCase) init() {
+	if tc.filtered {
+		return
+	}
 	for i := range tc.windowerSpec.WindowFns {
 		tc.windowerSpec.WindowFns[i].FilterColIdx = tree.NoColumnIdx
 	}
@@ -88,6 +93,38 @@ func TestWindowFunctions(t *testing.T) {
 	countFn := execinfrapb.AggregatorSpec_COUNT
 	avgFn := execinfrapb.AggregatorSpec_AVG
 	maxFn := execinfrapb.AggregatorSpec_MAX
+	countRowsFn := execinfrapb.AggregatorSpec_COUNT_ROWS
+
+	// slidingFrame is ROWS BETWEEN 1 PRECEDING AND CURRENT ROW, and
+	// currentRowFrame is ROWS BETWEEN CURRENT ROW AND CURRENT ROW.
+	slidingFrame := &execinfrapb.WindowerSpec_Frame{
+		Mode: execinfrapb.WindowerSpec_Frame_ROWS,
+		Bounds: execinfrapb.WindowerSpec_Frame_Bounds{
+			Start: execinfrapb.WindowerSpec_Frame_Bound{
+				BoundType: execinfrapb.WindowerSpec_Frame_OFFSET_PRECEDING, IntOffset: 1,
+			},
+			End: &execinfrapb.WindowerSpec_Frame_Bound{
+				BoundType: execinfrapb.WindowerSpec_Frame_CURRENT_ROW,
+			},
+		},
+	}
+	currentRowFrame := &execinfrapb.WindowerSpec_Frame{
+		Mode: execinfrapb.WindowerSpec_Frame_ROWS,
+		Bounds: execinfrapb.WindowerSpec_Frame_Bounds{
+			Start: execinfrapb.WindowerSpec_Frame_Bound{
+				BoundType: execinfrapb.WindowerSpec_Frame_CURRENT_ROW,
+			},
+			End: &execinfrapb.WindowerSpec_Frame_Bound{
+				BoundType: execinfrapb.WindowerSpec_Frame_CURRENT_ROW,
+			},
+		},
+	}
+	// The filtered test cases order the rows by the first column, aggregate the
+	// second one and filter them by the third one.
+	filteredTuples := colexectestutils.Tuples{
+		{1, 1, true}, {2, 2, false}, {3, 3, true}, {4, 4, nil}, {5, 5, true}, {6, 6, true},
+	}
+	filteredOrdering := execinfrapb.Ordering{Columns: []execinfrapb.Ordering_Column{{ColIdx: 0}}}
 
 	for _, spillForced := range []bool{true} {
 		flowCtx.Cfg.TestingKnobs.ForceDiskSpill = spillForced
@@ -1031,6 +1068,105 @@ func TestWindowFunctions(t *testing.T) {
 					},
 				},
 			},
+			// With FILTER.
+			{
+				tuples: filteredTuples,
+				expected: colexectestutils.Tuples{
+					{1, 1, true, dec("1")}, {2, 2, false, dec("1")}, {3, 3, true, dec("3")},
+					{4, 4, nil, dec("3")}, {5, 5, true, dec("5")}, {6, 6, true, dec("11")},
+				},
+				windowerSpec: execinfrapb.WindowerSpec{
+					WindowFns: []execinfrapb.WindowerSpec_WindowFn{
+						{
+							Func:         execinfrapb.WindowerSpec_Func{AggregateFunc: &sumFn},
+							ArgsIdxs:     []uint32{1},
+							Ordering:     filteredOrdering,
+							Frame:        slidingFrame,
+							FilterColIdx: 2,
+							OutputColIdx: 3,
+						},
+					},
+				},
+				filtered: true,
+			},
+			{
+				tuples: filteredTuples,
+				expected: colexectestutils.Tuples{
+					{1, 1, true, dec("1")}, {2, 2, false, nil}, {3, 3, true, dec("3")},
+					{4, 4, nil, nil}, {5, 5, true, dec("5")}, {6, 6, true, dec("6")},
+				},
+				windowerSpec: execinfrapb.WindowerSpec{
+					WindowFns: []execinfrapb.WindowerSpec_WindowFn{
+						{
+							Func:         execinfrapb.WindowerSpec_Func{AggregateFunc: &sumFn},
+							ArgsIdxs:     []uint32{1},
+							Ordering:     filteredOrdering,
+							Frame:        currentRowFrame,
+							FilterColIdx: 2,
+							OutputColIdx: 3,
+						},
+					},
+				},
+				filtered: true,
+			},
+			{
+				tuples: filteredTuples,
+				expected: colexectestutils.Tuples{
+					{1, 1, true, 1}, {2, 2, false, 1}, {3, 3, true, 1},
+					{4, 4, nil, 1}, {5, 5, true, 1}, {6, 6, true, 2},
+				},
+				windowerSpec: execinfrapb.WindowerSpec{
+					WindowFns: []execinfrapb.WindowerSpec_WindowFn{
+						{
+							Func:         execinfrapb.WindowerSpec_Func{AggregateFunc: &countRowsFn},
+							Ordering:     filteredOrdering,
+							Frame:        slidingFrame,
+							FilterColIdx: 2,
+							OutputColIdx: 3,
+						},
+					},
+				},
+				filtered: true,
+			},
+			{
+				tuples: filteredTuples,
+				expected: colexectestutils.Tuples{
+					{1, 1, true, 1}, {2, 2, false, 1}, {3, 3, true, 2},
+					{4, 4, nil, 2}, {5, 5, true, 3}, {6, 6, true, 4},
+				},
+				windowerSpec: execinfrapb.WindowerSpec{
+					WindowFns: []execinfrapb.WindowerSpec_WindowFn{
+						{
+							Func:         execinfrapb.WindowerSpec_Func{AggregateFunc: &countFn},
+							ArgsIdxs:     []uint32{1},
+							Ordering:     filteredOrdering,
+							FilterColIdx: 2,
+							OutputColIdx: 3,
+						},
+					},
+				},
+				filtered: true,
+			},
+			{
+				tuples: filteredTuples,
+				expected: colexectestutils.Tuples{
+					{1, 1, true, 1}, {2, 2, false, 1}, {3, 3, true, 3},
+					{4, 4, nil, 3}, {5, 5, true, 5}, {6, 6, true, 6},
+				},
+				windowerSpec: execinfrapb.WindowerSpec{
+					WindowFns: []execinfrapb.WindowerSpec_WindowFn{
+						{
+							Func:         execinfrapb.WindowerSpec_Func{AggregateFunc: &maxFn},
+							ArgsIdxs:     []uint32{1},
+							Ordering:     filteredOrdering,
+							Frame:        slidingFrame,
+							FilterColIdx: 2,
+							OutputColIdx: 3,
+						},
+					},
+				},
+				filtered: true,
+			},
 		} {
 			log.Infof(ctx, "spillForced=%t/%s", spillForced, tc.windowerSpec.WindowFns[0].Func.String())
 			var toClose []colexecop.Closers
@@ -1040,6 +1176,9 @@ func TestWindowFunctions(t *testing.T) {
 				ct := make([]*types.T, len(tc.tuples[0]))
 				for i := range ct {
 					ct[i] = types.Int
+					if _, ok := tc.tuples[0][i].(bool); ok {
+						ct[i] = types.Bool
+					}
 				}
 				resultType := types.Int
 				fun := tc.windowerSpec.WindowFns[0].Func
@@ -1110,6 +1249,7 @@ func BenchmarkWindowFunctions(b *testing.B) {
 		partitionColIdx = 3
 		orderColIdx     = 4
 		peersColIdx     = 5
+		filterColIdx    = 6
 	)
 
 	sourceTypes := []*types.T{
@@ -1117,6 +1257,21 @@ func BenchmarkWindowFunctions(b *testing.B) {
 		types.Bool, // Partition column
 		types.Int,  // Ordering column
 		types.Bool, // Peer groups column
+		types.Bool, // Filter column
+	}
+
+	// The filtered aggregate functions are computed over a sliding frame in
+	// order to exercise the removal of the filtered rows from the aggregation.
+	slidingFrame := &execinfrapb.WindowerSpec_Frame{
+		Mode: execinfrapb.WindowerSpec_Frame_ROWS,
+		Bounds: execinfrapb.WindowerSpec_Frame_Bounds{
+			Start: execinfrapb.WindowerSpec_Frame_Bound{
+				BoundType: execinfrapb.WindowerSpec_Frame_OFFSET_PRECEDING, IntOffset: 10,
+			},
+			End: &execinfrapb.WindowerSpec_Frame_Bound{
+				BoundType: execinfrapb.WindowerSpec_Frame_CURRENT_ROW,
+			},
+		},
 	}
 
 	queueCfg, cleanup := colcontainerutils.NewTestingDiskQueueCfg(b, false /* inMem */)
@@ -1130,7 +1285,7 @@ func BenchmarkWindowFunctions(b *testing.B) {
 	}()
 
 	getWindowFn := func(
-		fun execinfrapb.WindowerSpec_Func, source colexecop.Operator, partition, order bool,
+		fun execinfrapb.WindowerSpec_Func, source colexecop.Operator, partition, order, filter bool,
 	) (op colexecop.Operator) {
 		var err error
 		outputIdx := len(sourceTypes)
@@ -1191,14 +1346,16 @@ func BenchmarkWindowFunctions(b *testing.B) {
 					&execinfrapb.Ordering{Columns: orderingCols}, []int{arg1ColIdx, arg2ColIdx})
 			}
 		} else if fun.AggregateFunc != nil {
+			frame, filterCol := NormalizeWindowFrame(nil), tree.NoColumnIdx
+			if filter {
+				frame, filterCol = NormalizeWindowFrame(slidingFrame), filterColIdx
+			}
 			var argIdxs []int
 			switch *fun.AggregateFunc {
 			case execinfrapb.CountRows:
 				// CountRows has a specialized implementation.
 				return NewCountRowsOperator(
-					args,
-					NormalizeWindowFrame(nil),
-					&execinfrapb.Ordering{Columns: orderingCols},
+					args, frame, &execinfrapb.Ordering{Columns: orderingCols}, filterCol,
 				)
 			default:
 				// Supported aggregate functions other than CountRows take one argument.
@@ -1225,8 +1382,8 @@ func BenchmarkWindowFunctions(b *testing.B) {
 			)
 			require.NoError(b, err)
 			op = NewWindowAggregatorOperator(
-				args, *fun.AggregateFunc, NormalizeWindowFrame(nil),
-				&execinfrapb.Ordering{Columns: orderingCols}, []int{arg1ColIdx},
+				args, *fun.AggregateFunc, frame,
+				&execinfrapb.Ordering{Columns: orderingCols}, []int{arg1ColIdx}, filterCol,
 				aggArgs.OutputTypes[0], aggFnsAlloc,
 			)
 			allClosers = append(allClosers, toClose...)
@@ -1248,12 +1405,14 @@ func BenchmarkWindowFunctions(b *testing.B) {
 		partitionCol := vecs[partitionColIdx].Bool()
 		orderCol := vecs[orderColIdx].Int64()
 		peersCol := vecs[peersColIdx].Bool()
+		filterCol := vecs[filterColIdx].Bool()
 		for i := 0; i < length; i++ {
 			argCol1[i] = int64(1 + (i+arg1Offset)%arg1Range)
 			argCol2[i] = 1
 			partitionCol[i] = i%partitionSize == 0
 			orderCol[i] = int64(i / peerGroupSize)
 			peersCol[i] = i%peerGroupSize == 0
+			filterCol[i] = i%3 != 0
 		}
 		vecs[arg3ColIdx].Nulls().SetNulls()
 		return vecs
@@ -1265,7 +1424,7 @@ func BenchmarkWindowFunctions(b *testing.B) {
 		rowsOptions = []int{4 * coldata.BatchSize()}
 	}
 
-	runBench := func(fun execinfrapb.WindowerSpec_Func, fnName string, numArgs int) {
+	runBench := func(fun execinfrapb.WindowerSpec_Func, fnName string, numArgs int, filter bool) {
 		b.Run(fnName, func(b *testing.B) {
 			for _, nRows := range rowsOptions {
 				if !isWindowFnLinear(fun) && nRows == 32*coldata.BatchSize() {
@@ -1289,7 +1448,7 @@ func BenchmarkWindowFunctions(b *testing.B) {
 										source := colexectestutils.NewChunkingBatchSource(
 											testAllocator, sourceTypes, vecs, nRows,
 										)
-										s := getWindowFn(fun, source, partitionInput, orderInput)
+										s := getWindowFn(fun, source, partitionInput, orderInput, filter)
 										s.Init(ctx)
 										b.StartTimer()
 										for b := s.Next(); b.Length() != 0; b = s.Next() {
@@ -1308,7 +1467,7 @@ func BenchmarkWindowFunctions(b *testing.B) {
 	for windowFnIdx := 0; windowFnIdx < len(execinfrapb.WindowerSpec_WindowFunc_name); windowFnIdx++ {
 		windowFn := execinfrapb.WindowerSpec_WindowFunc(windowFnIdx)
 		numArgs := windowFnMaxNumArgs[windowFn]
-		runBench(execinfrapb.WindowerSpec_Func{WindowFunc: &windowFn}, windowFn.String(), numArgs)
+		runBench(execinfrapb.WindowerSpec_Func{WindowFunc: &windowFn}, windowFn.String(), numArgs, false /* filter */)
 	}
 
 	// We need <= because an entry for index=6 was omitted by mistake.
@@ -1329,6 +1488,19 @@ func BenchmarkWindowFunctions(b *testing.B) {
 		if aggFn != execinfrapb.CountRows {
 			numArgs = 1
 		}
-		runBench(execinfrapb.WindowerSpec_Func{AggregateFunc: &aggFn}, aggFn.String(), numArgs)
+		runBench(execinfrapb.WindowerSpec_Func{AggregateFunc: &aggFn}, aggFn.String(), numArgs, false /* filter */)
+	}
+
+	// Benchmark the aggregate functions with a FILTER clause over a sliding
+	// frame, which filters out a third of the rows.
+	for _, aggFn := range []execinfrapb.AggregatorSpec_Func{
+		execinfrapb.CountRows, execinfrapb.Count, execinfrapb.Sum, execinfrapb.Avg, execinfrapb.Max,
+	} {
+		aggFn := aggFn
+		var numArgs int
+		if aggFn != execinfrapb.CountRows {
+			numArgs = 1
+		}
+		runBench(execinfrapb.WindowerSpec_Func{AggregateFunc: &aggFn}, aggFn.String()+"/filter", numArgs, true /* filter */)
 	}
 }
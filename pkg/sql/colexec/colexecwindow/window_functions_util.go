@@ -13,6 +13,7 @@ package colexecwindow
 import (
 	"testing"
 
+	"github.com/cockroachdb/cockroach/pkg/col/coldata"
 	"github.com/cockroachdb/cockroach/pkg/sql/colcontainer"
 	"github.com/cockroachdb/cockroach/pkg/sql/colexecerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/colexecop"
@@ -284,3 +285,19 @@ func WindowFrameCanShrink(
 	}
 	return true
 }
+
+// nextFilteredRun returns the first run [runStart, runEnd) of consecutive rows
+// in [startIdx, endIdx) for which the boolean FILTER column is true (and not
+// NULL). If there is no such row, the run is empty.
+func nextFilteredRun(filter coldata.Vec, startIdx, endIdx int) (runStart, runEnd int) {
+	col, nulls := filter.Bool(), filter.Nulls()
+	runStart = startIdx
+	for runStart < endIdx && (!col[runStart] || nulls.NullAt(runStart)) {
+		runStart++
+	}
+	runEnd = runStart
+	for runEnd < endIdx && col[runEnd] && !nulls.NullAt(runEnd) {
+		runEnd++
+	}
+	return runStart, runEnd
+}
@@ -1130,6 +1130,12 @@ func (ex *connExecutor) close(ctx context.Context, closeType closeType) {
 		}
 	}
 
+	if err := ex.advisoryLocks.releaseAll(
+		ctx, ex.server.cfg.DB, ex.server.cfg.InternalExecutor, ex.sessionID,
+	); err != nil {
+		log.Warningf(ctx, "error releasing advisory locks at session close: %s", err)
+	}
+
 	if closeType != panicClose {
 		// Close all statements, prepared portals, and cursors.
 		ex.extraTxnState.prepStmtsNamespace.resetToEmpty(
@@ -1140,6 +1146,7 @@ func (ex *connExecutor) close(ctx context.Context, closeType closeType) {
 		)
 		ex.extraTxnState.prepStmtsNamespaceMemAcc.Close(ctx)
 		ex.extraTxnState.sqlCursors.closeAll()
+		ex.extraTxnState.largeObjectDescriptors.closeAll()
 	}
 
 	if ex.sessionTracing.Enabled() {
@@ -1328,6 +1335,10 @@ type connExecutor struct {
 		// once the transaction finishes.
 		sqlCursors cursorMap
 
+		// largeObjectDescriptors are the large objects opened in the
+		// transaction, which are closed once the transaction finishes.
+		largeObjectDescriptors largeObjectDescriptors
+
 		// shouldExecuteOnTxnFinish indicates that ex.onTxnFinish will be called
 		// when txn is finished (either committed or aborted). It is true when
 		// txn is started but can remain false when txn is executed within
@@ -1500,6 +1511,10 @@ type connExecutor struct {
 	// temporary schema, which requires special cleanup on close.
 	hasCreatedTemporarySchema bool
 
+	// advisoryLocks are the session-level advisory locks held by the session,
+	// which are released on close.
+	advisoryLocks advisoryLocks
+
 	// stmtDiagnosticsRecorder is used to track which queries need to have
 	// information collected.
 	stmtDiagnosticsRecorder *stmtdiagnostics.Registry
@@ -1682,6 +1697,7 @@ func (ex *connExecutor) resetExtraTxnState(ctx context.Context, ev txnEvent) {
 
 	// Close all cursors.
 	ex.extraTxnState.sqlCursors.closeAll()
+	ex.extraTxnState.largeObjectDescriptors.closeAll()
 
 	ex.extraTxnState.createdSequences = make(map[descpb.ID]struct{})
 
@@ -2720,6 +2736,8 @@ func (ex *connExecutor) initEvalCtx(ctx context.Context, evalCtx *extendedEvalCo
 			Tenant:                         p,
 			Regions:                        p,
 			JoinTokenCreator:               p,
+			LargeObjects:                   p,
+			AdvisoryLocks:                  p,
 			Gossip:                         p,
 			PreparedStatementState:         &ex.extraTxnState.prepStmtsNamespace,
 			SessionDataStack:               ex.sessionDataStack,
@@ -2827,6 +2845,8 @@ func (ex *connExecutor) initPlanner(ctx context.Context, p *planner) {
 	p.noticeSender = nil
 	p.preparedStatements = ex.getPrepStmtsAccessor()
 	p.sqlCursors = ex.getCursorAccessor()
+	p.largeObjectDescriptors = &ex.extraTxnState.largeObjectDescriptors
+	p.advisoryLocks = &ex.advisoryLocks
 	p.createdSequences = ex.getCreatedSequencesAccessor()
 
 	p.queryCacheSession.Init()
@@ -31,9 +31,24 @@ WITH payload AS (
     crdb_internal.pb_to_json(
       'cockroach.sql.jobs.jobspb.Payload', 
       payload, false, true
-    )->'changefeed' AS changefeed_details 
+    )->'changefeed' AS changefeed_details, 
+    crdb_internal.pb_to_json(
+      'cockroach.sql.jobs.jobspb.Progress', 
+      progress, false, true
+    )->'changefeed' AS changefeed_progress 
   FROM 
     system.jobs
+),
+protected AS (
+  SELECT 
+    meta, 
+    min(ts) AS ts 
+  FROM 
+    system.protected_ts_records 
+  WHERE 
+    meta_type = 'jobs' 
+  GROUP BY 
+    meta
 ) 
 SELECT 
   job_id, 
@@ -63,10 +78,15 @@ SELECT
       table_id = ANY (descriptor_ids)
   ) AS full_table_names, 
   changefeed_details->'opts'->>'topics' AS topics,
-  COALESCE(changefeed_details->'opts'->>'format','json') AS format 
+  COALESCE(changefeed_details->'opts'->>'format','json') AS format, 
+  protected.ts IS NOT NULL AS protected_timestamp_active, 
+  protected.ts AS protected_timestamp, 
+  COALESCE(changefeed_progress->'emittedStats', '[]') AS emitted_stats, 
+  COALESCE(changefeed_progress->'retryLog', '[]') AS retry_log 
 FROM 
   crdb_internal.jobs 
-  INNER JOIN payload ON id = job_id`
+  INNER JOIN payload ON id = job_id 
+  LEFT JOIN protected ON protected.meta = job_id::STRING::BYTES`
 	)
 
 	var whereClause, orderbyClause string
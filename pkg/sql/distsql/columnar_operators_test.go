@@ -43,66 +43,70 @@ const nullProbability = 0.2
 const randTypesProbability = 0.5
 
 var aggregateFuncToNumArguments = map[execinfrapb.AggregatorSpec_Func]int{
-	execinfrapb.AnyNotNull:              1,
-	execinfrapb.Avg:                     1,
-	execinfrapb.BoolAnd:                 1,
-	execinfrapb.BoolOr:                  1,
-	execinfrapb.ConcatAgg:               1,
-	execinfrapb.Count:                   1,
-	execinfrapb.Max:                     1,
-	execinfrapb.Min:                     1,
-	execinfrapb.Stddev:                  1,
-	execinfrapb.Sum:                     1,
-	execinfrapb.SumInt:                  1,
-	execinfrapb.Variance:                1,
-	execinfrapb.XorAgg:                  1,
-	execinfrapb.CountRows:               0,
-	execinfrapb.Sqrdiff:                 1,
-	execinfrapb.FinalVariance:           3,
-	execinfrapb.FinalVarPop:             3,
-	execinfrapb.FinalStddev:             3,
-	execinfrapb.FinalStddevPop:          3,
-	execinfrapb.ArrayAgg:                1,
-	execinfrapb.JSONAgg:                 1,
-	execinfrapb.JSONBAgg:                1,
-	execinfrapb.StringAgg:               2,
-	execinfrapb.BitAnd:                  1,
-	execinfrapb.BitOr:                   1,
-	execinfrapb.Corr:                    2,
-	execinfrapb.PercentileDiscImpl:      2,
-	execinfrapb.PercentileContImpl:      2,
-	execinfrapb.JSONObjectAgg:           2,
-	execinfrapb.JSONBObjectAgg:          2,
-	execinfrapb.VarPop:                  1,
-	execinfrapb.StddevPop:               1,
-	execinfrapb.StMakeline:              1,
-	execinfrapb.StExtent:                1,
-	execinfrapb.StUnion:                 1,
-	execinfrapb.StCollect:               1,
-	execinfrapb.CovarPop:                2,
-	execinfrapb.CovarSamp:               2,
-	execinfrapb.RegrIntercept:           2,
-	execinfrapb.RegrR2:                  2,
-	execinfrapb.RegrSlope:               2,
-	execinfrapb.RegrSxx:                 2,
-	execinfrapb.RegrSxy:                 2,
-	execinfrapb.RegrSyy:                 2,
-	execinfrapb.RegrCount:               2,
-	execinfrapb.RegrAvgx:                2,
-	execinfrapb.RegrAvgy:                2,
-	execinfrapb.TransitionRegrAggregate: 2,
-	execinfrapb.FinalCovarPop:           1,
-	execinfrapb.FinalRegrSxx:            1,
-	execinfrapb.FinalRegrSxy:            1,
-	execinfrapb.FinalRegrSyy:            1,
-	execinfrapb.FinalRegrAvgx:           1,
-	execinfrapb.FinalRegrAvgy:           1,
-	execinfrapb.FinalRegrIntercept:      1,
-	execinfrapb.FinalRegrR2:             1,
-	execinfrapb.FinalRegrSlope:          1,
-	execinfrapb.FinalCovarSamp:          1,
-	execinfrapb.FinalCorr:               1,
-	execinfrapb.FinalSqrdiff:            3,
+	execinfrapb.AnyNotNull:                 1,
+	execinfrapb.Avg:                        1,
+	execinfrapb.BoolAnd:                    1,
+	execinfrapb.BoolOr:                     1,
+	execinfrapb.ConcatAgg:                  1,
+	execinfrapb.Count:                      1,
+	execinfrapb.Max:                        1,
+	execinfrapb.Min:                        1,
+	execinfrapb.Stddev:                     1,
+	execinfrapb.Sum:                        1,
+	execinfrapb.SumInt:                     1,
+	execinfrapb.Variance:                   1,
+	execinfrapb.XorAgg:                     1,
+	execinfrapb.CountRows:                  0,
+	execinfrapb.Sqrdiff:                    1,
+	execinfrapb.FinalVariance:              3,
+	execinfrapb.FinalVarPop:                3,
+	execinfrapb.FinalStddev:                3,
+	execinfrapb.FinalStddevPop:             3,
+	execinfrapb.ArrayAgg:                   1,
+	execinfrapb.JSONAgg:                    1,
+	execinfrapb.JSONBAgg:                   1,
+	execinfrapb.StringAgg:                  2,
+	execinfrapb.BitAnd:                     1,
+	execinfrapb.BitOr:                      1,
+	execinfrapb.Corr:                       2,
+	execinfrapb.PercentileDiscImpl:         2,
+	execinfrapb.PercentileContImpl:         2,
+	execinfrapb.JSONObjectAgg:              2,
+	execinfrapb.JSONBObjectAgg:             2,
+	execinfrapb.VarPop:                     1,
+	execinfrapb.StddevPop:                  1,
+	execinfrapb.StMakeline:                 1,
+	execinfrapb.StExtent:                   1,
+	execinfrapb.StUnion:                    1,
+	execinfrapb.StCollect:                  1,
+	execinfrapb.CovarPop:                   2,
+	execinfrapb.CovarSamp:                  2,
+	execinfrapb.RegrIntercept:              2,
+	execinfrapb.RegrR2:                     2,
+	execinfrapb.RegrSlope:                  2,
+	execinfrapb.RegrSxx:                    2,
+	execinfrapb.RegrSxy:                    2,
+	execinfrapb.RegrSyy:                    2,
+	execinfrapb.RegrCount:                  2,
+	execinfrapb.RegrAvgx:                   2,
+	execinfrapb.RegrAvgy:                   2,
+	execinfrapb.TransitionRegrAggregate:    2,
+	execinfrapb.FinalCovarPop:              1,
+	execinfrapb.FinalRegrSxx:               1,
+	execinfrapb.FinalRegrSxy:               1,
+	execinfrapb.FinalRegrSyy:               1,
+	execinfrapb.FinalRegrAvgx:              1,
+	execinfrapb.FinalRegrAvgy:              1,
+	execinfrapb.FinalRegrIntercept:         1,
+	execinfrapb.FinalRegrR2:                1,
+	execinfrapb.FinalRegrSlope:             1,
+	execinfrapb.FinalCovarSamp:             1,
+	execinfrapb.FinalCorr:                  1,
+	execinfrapb.FinalSqrdiff:               3,
+	execinfrapb.Locf:                       1,
+	execinfrapb.ApproxPercentile:           2,
+	execinfrapb.TransitionApproxPercentile: 2,
+	execinfrapb.FinalApproxPercentile:      1,
 }
 
 // TestAggregateFuncToNumArguments ensures that all aggregate functions are
@@ -181,6 +185,12 @@ func TestAggregatorAgainstProcessor(t *testing.T) {
 				execinfrapb.PercentileContImpl:
 				// We skip percentile functions because those can only be
 				// planned as window functions.
+			case execinfrapb.ApproxPercentile,
+				execinfrapb.TransitionApproxPercentile,
+				execinfrapb.FinalApproxPercentile:
+				// We skip approx_percentile functions because they require a
+				// valid fraction and valid encoded t-digests, which random
+				// inputs are unlikely to be.
 			default:
 				found = true
 			}
@@ -2037,6 +2037,14 @@ func (r *SessionRegistry) deregister(
 	delete(r.sessionsByCancelKey, queryCancelKey)
 }
 
+// hasSession returns whether the session is registered on this node.
+func (r *SessionRegistry) hasSession(id clusterunique.ID) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.sessions[id]
+	return ok
+}
+
 type registrySession interface {
 	user() username.SQLUsername
 	cancelQuery(queryID clusterunique.ID) bool
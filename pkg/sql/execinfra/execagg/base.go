@@ -39,8 +39,11 @@ func GetAggregateInfo(
 		return builtins.NewAnyNotNullAggregate, inputTypes[0], nil
 	}
 
-	_, builtins := builtinsregistry.GetBuiltinProperties(strings.ToLower(fn.String()))
-	for _, b := range builtins {
+	def, ok := builtinsregistry.GetIndexed(strings.ToLower(fn.String()))
+	if !ok {
+		return nil, nil, errors.AssertionFailedf("unknown builtin aggregate %s", fn)
+	}
+	for _, b := range def.OverloadsWithArity(len(inputTypes)) {
 		typs := b.Types.Types()
 		if len(typs) != len(inputTypes) {
 			continue
@@ -132,8 +135,11 @@ func GetWindowFunctionInfo(
 			"function is neither an aggregate nor a window function",
 		)
 	}
-	_, builtins := builtinsregistry.GetBuiltinProperties(strings.ToLower(funcStr))
-	for _, b := range builtins {
+	def, ok := builtinsregistry.GetIndexed(strings.ToLower(funcStr))
+	if !ok {
+		return nil, nil, errors.AssertionFailedf("unknown builtin aggregate/window function %s", funcStr)
+	}
+	for _, b := range def.OverloadsWithArity(len(inputTypes)) {
 		typs := b.Types.Types()
 		if len(typs) != len(inputTypes) {
 			continue
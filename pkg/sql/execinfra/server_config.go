@@ -157,6 +157,10 @@ type ServerConfig struct {
 	ExternalStorage        cloud.ExternalStorageFactory
 	ExternalStorageFromURI cloud.ExternalStorageFromURIFactory
 
+	// ExternalIODirConfig is the configuration of the external storage and
+	// of the KMS opened by the processors.
+	ExternalIODirConfig base.ExternalIODirConfig
+
 	// ProtectedTimestampProvider maintains the state of the protected timestamp
 	// subsystem. It is queried during the GC process and in the handling of
 	// AdminVerifyProtectedTimestampRequest.
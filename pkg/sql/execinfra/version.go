@@ -64,7 +64,7 @@ import "github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 //
 // ATTENTION: When updating these fields, add a brief description of what
 // changed to the version history below.
-const Version execinfrapb.DistSQLVersion = 69
+const Version execinfrapb.DistSQLVersion = 71
 
 // MinAcceptedVersion is the oldest version that the server is compatible with.
 // A server will not accept flows with older versions.
@@ -76,6 +76,19 @@ const MinAcceptedVersion execinfrapb.DistSQLVersion = 69
 
 Please add new entries at the top.
 
+- Version: 71 (MinAcceptedVersion: 69)
+  - The approx_percentile aggregate function was introduced, along with its
+    local and final stages. They would be unrecognized by a server running
+    older versions, hence the version bump. However, a server running v71 can
+    still process all plans from servers running v69, thus the
+    MinAcceptedVersion is kept at 69.
+
+- Version: 70 (MinAcceptedVersion: 69)
+  - The locf aggregate function was introduced. It would be unrecognized by a
+    server running older versions, hence the version bump. However, a server
+    running v70 can still process all plans from servers running v69, thus the
+    MinAcceptedVersion is kept at 69.
+
 - Version: 69 (MinAcceptedVersion: 69)
   - ProducerMessage no longer includes the typing information.
 
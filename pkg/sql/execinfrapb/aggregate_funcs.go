@@ -34,43 +34,47 @@ const (
 	ArrayAgg       = AggregatorSpec_ARRAY_AGG
 	JSONAgg        = AggregatorSpec_JSON_AGG
 	// JSONBAgg is an alias for JSONAgg, they do the same thing.
-	JSONBAgg                = AggregatorSpec_JSONB_AGG
-	StringAgg               = AggregatorSpec_STRING_AGG
-	BitAnd                  = AggregatorSpec_BIT_AND
-	BitOr                   = AggregatorSpec_BIT_OR
-	Corr                    = AggregatorSpec_CORR
-	PercentileDiscImpl      = AggregatorSpec_PERCENTILE_DISC_IMPL
-	PercentileContImpl      = AggregatorSpec_PERCENTILE_CONT_IMPL
-	JSONObjectAgg           = AggregatorSpec_JSON_OBJECT_AGG
-	JSONBObjectAgg          = AggregatorSpec_JSONB_OBJECT_AGG
-	VarPop                  = AggregatorSpec_VAR_POP
-	StddevPop               = AggregatorSpec_STDDEV_POP
-	StMakeline              = AggregatorSpec_ST_MAKELINE
-	StExtent                = AggregatorSpec_ST_EXTENT
-	StUnion                 = AggregatorSpec_ST_UNION
-	StCollect               = AggregatorSpec_ST_COLLECT
-	CovarPop                = AggregatorSpec_COVAR_POP
-	CovarSamp               = AggregatorSpec_COVAR_SAMP
-	RegrIntercept           = AggregatorSpec_REGR_INTERCEPT
-	RegrR2                  = AggregatorSpec_REGR_R2
-	RegrSlope               = AggregatorSpec_REGR_SLOPE
-	RegrSxx                 = AggregatorSpec_REGR_SXX
-	RegrSyy                 = AggregatorSpec_REGR_SYY
-	RegrSxy                 = AggregatorSpec_REGR_SXY
-	RegrCount               = AggregatorSpec_REGR_COUNT
-	RegrAvgx                = AggregatorSpec_REGR_AVGX
-	RegrAvgy                = AggregatorSpec_REGR_AVGY
-	TransitionRegrAggregate = AggregatorSpec_TRANSITION_REGRESSION_AGGREGATE
-	FinalCovarPop           = AggregatorSpec_FINAL_COVAR_POP
-	FinalRegrSxx            = AggregatorSpec_FINAL_REGR_SXX
-	FinalRegrSxy            = AggregatorSpec_FINAL_REGR_SXY
-	FinalRegrSyy            = AggregatorSpec_FINAL_REGR_SYY
-	FinalRegrAvgx           = AggregatorSpec_FINAL_REGR_AVGX
-	FinalRegrAvgy           = AggregatorSpec_FINAL_REGR_AVGY
-	FinalRegrIntercept      = AggregatorSpec_FINAL_REGR_INTERCEPT
-	FinalRegrR2             = AggregatorSpec_FINAL_REGR_R2
-	FinalRegrSlope          = AggregatorSpec_FINAL_REGR_SLOPE
-	FinalCovarSamp          = AggregatorSpec_FINAL_COVAR_SAMP
-	FinalCorr               = AggregatorSpec_FINAL_CORR
-	FinalSqrdiff            = AggregatorSpec_FINAL_SQRDIFF
+	JSONBAgg                   = AggregatorSpec_JSONB_AGG
+	StringAgg                  = AggregatorSpec_STRING_AGG
+	BitAnd                     = AggregatorSpec_BIT_AND
+	BitOr                      = AggregatorSpec_BIT_OR
+	Corr                       = AggregatorSpec_CORR
+	PercentileDiscImpl         = AggregatorSpec_PERCENTILE_DISC_IMPL
+	PercentileContImpl         = AggregatorSpec_PERCENTILE_CONT_IMPL
+	JSONObjectAgg              = AggregatorSpec_JSON_OBJECT_AGG
+	JSONBObjectAgg             = AggregatorSpec_JSONB_OBJECT_AGG
+	VarPop                     = AggregatorSpec_VAR_POP
+	StddevPop                  = AggregatorSpec_STDDEV_POP
+	StMakeline                 = AggregatorSpec_ST_MAKELINE
+	StExtent                   = AggregatorSpec_ST_EXTENT
+	StUnion                    = AggregatorSpec_ST_UNION
+	StCollect                  = AggregatorSpec_ST_COLLECT
+	CovarPop                   = AggregatorSpec_COVAR_POP
+	CovarSamp                  = AggregatorSpec_COVAR_SAMP
+	RegrIntercept              = AggregatorSpec_REGR_INTERCEPT
+	RegrR2                     = AggregatorSpec_REGR_R2
+	RegrSlope                  = AggregatorSpec_REGR_SLOPE
+	RegrSxx                    = AggregatorSpec_REGR_SXX
+	RegrSyy                    = AggregatorSpec_REGR_SYY
+	RegrSxy                    = AggregatorSpec_REGR_SXY
+	RegrCount                  = AggregatorSpec_REGR_COUNT
+	RegrAvgx                   = AggregatorSpec_REGR_AVGX
+	RegrAvgy                   = AggregatorSpec_REGR_AVGY
+	TransitionRegrAggregate    = AggregatorSpec_TRANSITION_REGRESSION_AGGREGATE
+	FinalCovarPop              = AggregatorSpec_FINAL_COVAR_POP
+	FinalRegrSxx               = AggregatorSpec_FINAL_REGR_SXX
+	FinalRegrSxy               = AggregatorSpec_FINAL_REGR_SXY
+	FinalRegrSyy               = AggregatorSpec_FINAL_REGR_SYY
+	FinalRegrAvgx              = AggregatorSpec_FINAL_REGR_AVGX
+	FinalRegrAvgy              = AggregatorSpec_FINAL_REGR_AVGY
+	FinalRegrIntercept         = AggregatorSpec_FINAL_REGR_INTERCEPT
+	FinalRegrR2                = AggregatorSpec_FINAL_REGR_R2
+	FinalRegrSlope             = AggregatorSpec_FINAL_REGR_SLOPE
+	FinalCovarSamp             = AggregatorSpec_FINAL_COVAR_SAMP
+	FinalCorr                  = AggregatorSpec_FINAL_CORR
+	FinalSqrdiff               = AggregatorSpec_FINAL_SQRDIFF
+	Locf                       = AggregatorSpec_LOCF
+	ApproxPercentile           = AggregatorSpec_APPROX_PERCENTILE
+	TransitionApproxPercentile = AggregatorSpec_TRANSITION_APPROX_PERCENTILE
+	FinalApproxPercentile      = AggregatorSpec_FINAL_APPROX_PERCENTILE
 )
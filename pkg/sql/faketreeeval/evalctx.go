@@ -255,6 +255,13 @@ func (*DummyEvalPlanner) ValidateSessionRevivalToken(token *tree.DBytes) (*tree.
 	return nil, errors.WithStack(errEvalPlanner)
 }
 
+// DecodeSessionRevivalToken is part of the Planner interface.
+func (*DummyEvalPlanner) DecodeSessionRevivalToken(
+	ctx context.Context, token *tree.DBytes,
+) (*tree.DJSON, error) {
+	return nil, errors.WithStack(errEvalPlanner)
+}
+
 // RevalidateUniqueConstraintsInCurrentDB is part of the Planner interface.
 func (*DummyEvalPlanner) RevalidateUniqueConstraintsInCurrentDB(ctx context.Context) error {
 	return errors.WithStack(errEvalPlanner)
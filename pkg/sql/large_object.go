@@ -0,0 +1,396 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package sql
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq/oid"
+)
+
+// Large objects are emulated for compatibility with applications which use
+// the lo_* functions of Postgres. Their data is stored, in chunks of
+// largeObjectChunkSize bytes, in the system.large_objects table, and their
+// OIDs are allocated from the system.large_object_oid_seq sequence. Unlike in
+// Postgres, the large objects are shared by all the databases of the cluster.
+// All the operations on large objects are performed in the transaction of the
+// calling statement, so they are committed or rolled back with it. Like in
+// Postgres with lo_compat_privileges disabled, a large object can only be
+// accessed by its owner, i.e. the user who created it, and by admins.
+
+const largeObjectsCompatibilityEnabledName = "sql.large_objects.compatibility.enabled"
+
+var largeObjectsCompatibilityEnabled = settings.RegisterBoolSetting(
+	settings.TenantWritable,
+	largeObjectsCompatibilityEnabledName,
+	"set to true to enable the lo_* large object compatibility functions, which store "+
+		"large objects in the system.large_objects table",
+	false,
+).WithPublic()
+
+const (
+	// largeObjectChunkSize is the size of the chunks the large objects are
+	// stored in.
+	largeObjectChunkSize = 64 << 10
+	// largeObjectMaxSize is the maximum size of a large object.
+	largeObjectMaxSize = 16 << 20
+
+	// largeObjectModeWrite and largeObjectModeRead are the modes large objects
+	// are opened with, which match the INV_WRITE and INV_READ flags of
+	// Postgres.
+	largeObjectModeWrite = 0x20000
+	largeObjectModeRead  = 0x40000
+)
+
+// largeObjectDescriptor is a large object opened with lo_open.
+type largeObjectDescriptor struct {
+	loid oid.Oid
+	mode int32
+	// pos is the offset of the next read or write.
+	pos int64
+}
+
+// largeObjectDescriptors are the large objects opened in a transaction. Like
+// in Postgres, the descriptors are closed when the transaction finishes.
+type largeObjectDescriptors struct {
+	descriptors map[int32]*largeObjectDescriptor
+	next        int32
+}
+
+func (d *largeObjectDescriptors) closeAll() {
+	d.descriptors = nil
+	d.next = 0
+}
+
+func (d *largeObjectDescriptors) get(fd int32) (*largeObjectDescriptor, error) {
+	desc, ok := d.descriptors[fd]
+	if !ok {
+		return nil, pgerror.Newf(pgcode.UndefinedObject, "invalid large-object descriptor: %d", fd)
+	}
+	return desc, nil
+}
+
+func (p *planner) checkLargeObjectsEnabled(ctx context.Context) error {
+	if !largeObjectsCompatibilityEnabled.Get(&p.ExecCfg().Settings.SV) {
+		return errors.WithHintf(
+			pgerror.New(pgcode.FeatureNotSupported, "large objects are not supported"),
+			"To enable the large object compatibility functions, use `SET CLUSTER SETTING %s = true`.",
+			largeObjectsCompatibilityEnabledName,
+		)
+	}
+	if !p.ExecCfg().Settings.Version.IsActive(ctx, clusterversion.SystemLargeObjectsTable) {
+		return pgerror.Newf(pgcode.FeatureNotSupported,
+			"large objects are not supported until upgrade to version %v is finalized",
+			clusterversion.ByKey(clusterversion.SystemLargeObjectsTable))
+	}
+	return nil
+}
+
+// queryLargeObjects runs the statement in the transaction of the planner. The
+// statement runs as the node user since the user might not have privileges on
+// system.large_objects; the access to the large objects is checked by
+// checkLargeObjectAccess instead.
+func (p *planner) queryLargeObjects(
+	ctx context.Context, opName string, stmt string, qargs ...interface{},
+) (tree.Datums, error) {
+	return p.QueryRowEx(ctx, opName, sessiondata.NodeUserSessionDataOverride, stmt, qargs...)
+}
+
+// checkLargeObjectAccess returns an error if the large object does not exist,
+// or if the current user is neither its owner nor an admin.
+func (p *planner) checkLargeObjectAccess(ctx context.Context, loid oid.Oid) error {
+	row, err := p.queryLargeObjects(ctx, "lo-owner",
+		`SELECT owner FROM system.large_objects WHERE loid = $1 AND pageno = 0`, tree.NewDOid(loid))
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return largeObjectNotFoundError(loid)
+	}
+	if owner, ok := tree.AsDString(row[0]); ok &&
+		username.MakeSQLUsernameFromPreNormalizedString(string(owner)) == p.User() {
+		return nil
+	}
+	hasAdmin, err := p.HasAdminRole(ctx)
+	if err != nil {
+		return err
+	}
+	if !hasAdmin {
+		return pgerror.Newf(pgcode.InsufficientPrivilege, "permission denied for large object %d", loid)
+	}
+	return nil
+}
+
+// largeObjectSize returns the size of the large object, or an error if it
+// does not exist.
+func (p *planner) largeObjectSize(ctx context.Context, loid oid.Oid) (int64, error) {
+	row, err := p.queryLargeObjects(ctx, "lo-size",
+		`SELECT pageno, length(data) FROM system.large_objects WHERE loid = $1 ORDER BY pageno DESC LIMIT 1`,
+		tree.NewDOid(loid))
+	if err != nil {
+		return 0, err
+	}
+	if row == nil {
+		return 0, largeObjectNotFoundError(loid)
+	}
+	return int64(tree.MustBeDInt(row[0]))*largeObjectChunkSize + int64(tree.MustBeDInt(row[1])), nil
+}
+
+func largeObjectNotFoundError(loid oid.Oid) error {
+	return pgerror.Newf(pgcode.UndefinedObject, "large object %d does not exist", loid)
+}
+
+// insertLargeObject records the large object with its first chunk, which is
+// always stored, even if it is empty, to record that the large object exists
+// and who owns it. It returns false if the OID is already in use.
+func (p *planner) insertLargeObject(ctx context.Context, loid oid.Oid) (bool, error) {
+	row, err := p.queryLargeObjects(ctx, "lo-create",
+		`INSERT INTO system.large_objects VALUES ($1, 0, '', $2) ON CONFLICT DO NOTHING RETURNING loid`,
+		tree.NewDOid(loid), p.User().Normalized())
+	if err != nil {
+		return false, err
+	}
+	return row != nil, nil
+}
+
+// LargeObjectCreate implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectCreate(ctx context.Context, loid oid.Oid) (oid.Oid, error) {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return 0, err
+	}
+	if loid != 0 {
+		inserted, err := p.insertLargeObject(ctx, loid)
+		if err != nil {
+			return 0, err
+		}
+		if !inserted {
+			return 0, pgerror.Newf(pgcode.DuplicateObject, "large object %d already exists", loid)
+		}
+		return loid, nil
+	}
+	// The OIDs allocated from the sequence may already be in use, by large
+	// objects created with an explicit OID or restored from a backup, in which
+	// case the next ones are tried.
+	for {
+		row, err := p.queryLargeObjects(ctx, "lo-next-oid",
+			`SELECT nextval('system.large_object_oid_seq')`)
+		if err != nil {
+			return 0, err
+		}
+		loid = oid.Oid(tree.MustBeDInt(row[0]))
+		inserted, err := p.insertLargeObject(ctx, loid)
+		if err != nil {
+			return 0, err
+		}
+		if inserted {
+			return loid, nil
+		}
+	}
+}
+
+// LargeObjectPut implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectPut(
+	ctx context.Context, loid oid.Oid, offset int64, data []byte,
+) error {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return err
+	}
+	if err := p.checkLargeObjectAccess(ctx, loid); err != nil {
+		return err
+	}
+	if offset < 0 {
+		return pgerror.Newf(pgcode.InvalidParameterValue, "invalid large object offset: %d", offset)
+	}
+	if offset+int64(len(data)) > largeObjectMaxSize {
+		return pgerror.Newf(pgcode.ProgramLimitExceeded,
+			"large object %d would exceed the maximum size of %d bytes", loid, largeObjectMaxSize)
+	}
+	for len(data) > 0 {
+		pageno := offset / largeObjectChunkSize
+		pageOffset := int(offset % largeObjectChunkSize)
+		row, err := p.queryLargeObjects(ctx, "lo-get-chunk",
+			`SELECT data FROM system.large_objects WHERE loid = $1 AND pageno = $2`, tree.NewDOid(loid), pageno)
+		if err != nil {
+			return err
+		}
+		var chunk []byte
+		if row != nil {
+			chunk = []byte(tree.MustBeDBytes(row[0]))
+		}
+		n := largeObjectChunkSize - pageOffset
+		if n > len(data) {
+			n = len(data)
+		}
+		if len(chunk) < pageOffset+n {
+			// Missing data up to the offset reads as zeros.
+			chunk = append(chunk, make([]byte, pageOffset+n-len(chunk))...)
+		}
+		copy(chunk[pageOffset:], data[:n])
+		// The owner is only recorded in the first chunk, so it is left out.
+		if _, err := p.queryLargeObjects(ctx, "lo-put-chunk",
+			`UPSERT INTO system.large_objects (loid, pageno, data) VALUES ($1, $2, $3)`,
+			tree.NewDOid(loid), pageno, chunk,
+		); err != nil {
+			return err
+		}
+		data = data[n:]
+		offset += int64(n)
+	}
+	return nil
+}
+
+// LargeObjectGet implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectGet(
+	ctx context.Context, loid oid.Oid, offset int64, length int64,
+) ([]byte, error) {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return nil, err
+	}
+	if err := p.checkLargeObjectAccess(ctx, loid); err != nil {
+		return nil, err
+	}
+	size, err := p.largeObjectSize(ctx, loid)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, pgerror.Newf(pgcode.InvalidParameterValue, "invalid large object offset: %d", offset)
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	if length <= 0 {
+		return []byte{}, nil
+	}
+	res := make([]byte, length)
+	it, err := p.QueryIteratorEx(ctx, "lo-get", sessiondata.NodeUserSessionDataOverride,
+		`SELECT pageno, data FROM system.large_objects WHERE loid = $1 AND pageno BETWEEN $2 AND $3`,
+		tree.NewDOid(loid), offset/largeObjectChunkSize, (offset+length-1)/largeObjectChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = it.Close() }()
+	var ok bool
+	for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
+		row := it.Cur()
+		chunkOffset := int64(tree.MustBeDInt(row[0])) * largeObjectChunkSize
+		chunk := []byte(tree.MustBeDBytes(row[1]))
+		// Copy the part of the chunk which overlaps with the requested range;
+		// the missing data reads as zeros.
+		if chunkOffset < offset {
+			skip := offset - chunkOffset
+			if skip >= int64(len(chunk)) {
+				continue
+			}
+			chunk = chunk[skip:]
+			chunkOffset = offset
+		}
+		copy(res[chunkOffset-offset:], chunk)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LargeObjectUnlink implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectUnlink(ctx context.Context, loid oid.Oid) error {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return err
+	}
+	if err := p.checkLargeObjectAccess(ctx, loid); err != nil {
+		return err
+	}
+	_, err := p.queryLargeObjects(ctx, "lo-unlink",
+		`DELETE FROM system.large_objects WHERE loid = $1`, tree.NewDOid(loid))
+	return err
+}
+
+// LargeObjectOpen implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectOpen(ctx context.Context, loid oid.Oid, mode int32) (int32, error) {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return 0, err
+	}
+	if mode&(largeObjectModeRead|largeObjectModeWrite) == 0 {
+		return 0, pgerror.Newf(pgcode.InvalidParameterValue, "invalid large object mode: %d", mode)
+	}
+	if err := p.checkLargeObjectAccess(ctx, loid); err != nil {
+		return 0, err
+	}
+	d := p.largeObjectDescriptors
+	if d.descriptors == nil {
+		d.descriptors = make(map[int32]*largeObjectDescriptor)
+	}
+	fd := d.next
+	d.next++
+	d.descriptors[fd] = &largeObjectDescriptor{loid: loid, mode: mode}
+	return fd, nil
+}
+
+// LargeObjectClose implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectClose(ctx context.Context, fd int32) error {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return err
+	}
+	if _, err := p.largeObjectDescriptors.get(fd); err != nil {
+		return err
+	}
+	delete(p.largeObjectDescriptors.descriptors, fd)
+	return nil
+}
+
+// LargeObjectWrite implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectWrite(ctx context.Context, fd int32, data []byte) (int32, error) {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return 0, err
+	}
+	desc, err := p.largeObjectDescriptors.get(fd)
+	if err != nil {
+		return 0, err
+	}
+	if desc.mode&largeObjectModeWrite == 0 {
+		return 0, pgerror.Newf(pgcode.ObjectNotInPrerequisiteState,
+			"large object descriptor %d was not opened for writing", fd)
+	}
+	if err := p.LargeObjectPut(ctx, desc.loid, desc.pos, data); err != nil {
+		return 0, err
+	}
+	desc.pos += int64(len(data))
+	return int32(len(data)), nil
+}
+
+// LargeObjectRead implements the eval.LargeObjectOperator interface.
+func (p *planner) LargeObjectRead(ctx context.Context, fd int32, length int32) ([]byte, error) {
+	if err := p.checkLargeObjectsEnabled(ctx); err != nil {
+		return nil, err
+	}
+	desc, err := p.largeObjectDescriptors.get(fd)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, pgerror.Newf(pgcode.InvalidParameterValue, "invalid large object read length: %d", length)
+	}
+	data, err := p.LargeObjectGet(ctx, desc.loid, desc.pos, int64(length))
+	if err != nil {
+		return nil, err
+	}
+	desc.pos += int64(len(data))
+	return data, nil
+}
@@ -33,9 +33,16 @@ func TestEncodeRestrictedSQLIdent(t *testing.T) {
 		{`foo"`, `"foo"""`},
 		{`fo"o"`, `"fo""o"""`},
 		{`fOo`, `"fOo"`},
+		{`Foo`, `"Foo"`},
+		{`FOO`, `"FOO"`},
+		{`foo bar`, `"foo bar"`},
+		{`café`, `café`},
+		{`CAFÉ`, `"CAFÉ"`},
 		{`_foo`, `_foo`},
 		{`-foo`, `"-foo"`},
 		{`select`, `"select"`},
+		{`user`, `"user"`},
+		{`name`, `name`},
 		{`integer`, `"integer"`},
 		// N.B. These type names are examples of type names that *should* be
 		// unrestricted (left out of the reserved keyword list) because they're not
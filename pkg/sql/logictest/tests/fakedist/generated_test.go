@@ -1045,6 +1045,13 @@ func TestLogic_kv_builtin_functions(
 	runLogicTest(t, "kv_builtin_functions")
 }
 
+func TestLogic_large_objects(
+	t *testing.T,
+) {
+	defer leaktest.AfterTest(t)()
+	runLogicTest(t, "large_objects")
+}
+
 func TestLogic_limit(
 	t *testing.T,
 ) {
@@ -1122,6 +1122,13 @@ func TestLogic_kv_builtin_functions_local(
 	runLogicTest(t, "kv_builtin_functions_local")
 }
 
+func TestLogic_large_objects(
+	t *testing.T,
+) {
+	defer leaktest.AfterTest(t)()
+	runLogicTest(t, "large_objects")
+}
+
 func TestLogic_limit(
 	t *testing.T,
 ) {
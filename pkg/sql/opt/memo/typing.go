@@ -195,6 +195,7 @@ func init() {
 	typingFuncMap[opt.ConstNotNullAggOp] = typeAsFirstArg
 	typingFuncMap[opt.AnyNotNullAggOp] = typeAsFirstArg
 	typingFuncMap[opt.FirstAggOp] = typeAsFirstArg
+	typingFuncMap[opt.LocfAggOp] = typeAsFirstArg
 
 	typingFuncMap[opt.LagOp] = typeAsFirstArg
 	typingFuncMap[opt.LeadOp] = typeAsFirstArg
@@ -253,7 +253,7 @@ func (a aggregateInfo) isOrderingSensitive() bool {
 	}
 	switch a.def.Name {
 	case "array_agg", "concat_agg", "string_agg", "json_agg", "jsonb_agg", "json_object_agg", "jsonb_object_agg",
-		"st_makeline", "st_collect", "st_memcollect":
+		"st_makeline", "st_collect", "st_memcollect", "locf":
 		return true
 	default:
 		return false
@@ -828,6 +828,10 @@ func (b *Builder) constructAggregate(name string, args []opt.ScalarExpr) opt.Sca
 		return b.factory.ConstructRegressionSYY(args[0], args[1])
 	case "regr_count":
 		return b.factory.ConstructRegressionCount(args[0], args[1])
+	case "locf":
+		return b.factory.ConstructLocfAgg(args[0])
+	case "approx_percentile":
+		return b.factory.ConstructApproxPercentile(args[0], args[1])
 	case "max":
 		return b.factory.ConstructMax(args[0])
 	case "min":
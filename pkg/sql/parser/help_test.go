@@ -422,6 +422,9 @@ func TestContextualHelp(t *testing.T) {
 		{`TRUNCATE foo ??`, `TRUNCATE`},
 		{`TRUNCATE foo, ??`, `TRUNCATE`},
 
+		{`VALIDATE EXTERNAL CONNECTION ??`, `VALIDATE EXTERNAL CONNECTION`},
+		{`VALIDATE EXTERNAL CONNECTION blah ??`, `VALIDATE EXTERNAL CONNECTION`},
+
 		{`SELECT 1 ??`, `SELECT`},
 		{`SELECT * FROM ??`, `<SOURCE>`},
 		{`SELECT 1 FROM foo ??`, `SELECT`},
@@ -1127,6 +1127,7 @@ func (u *sqlSymUnion) functionObjs() tree.FuncObjs {
 %type <tree.Statement> drop_ddl_stmt
 %type <tree.Statement> drop_database_stmt
 %type <tree.Statement> drop_external_connection_stmt
+%type <tree.Statement> validate_external_connection_stmt
 %type <tree.Statement> drop_index_stmt
 %type <tree.Statement> drop_role_stmt
 %type <tree.Statement> drop_schema_stmt
@@ -1533,7 +1534,7 @@ func (u *sqlSymUnion) functionObjs() tree.FuncObjs {
 %type <*tree.BackupTargetList> opt_backup_targets
 
 %type <tree.GrantTargetList> grant_targets targets_roles target_types
-%type <tree.TableExpr> changefeed_target_expr
+%type <tree.TableExpr> changefeed_target_expr changefeed_from_expr
 %type <*tree.GrantTargetList> opt_on_targets_roles
 %type <tree.RoleSpecList> for_grantee_clause
 %type <privilege.List> privileges
@@ -1692,6 +1693,7 @@ stmt_without_legacy_transaction:
 | fetch_cursor_stmt         // EXTEND WITH HELP: FETCH
 | move_cursor_stmt          // EXTEND WITH HELP: MOVE
 | reindex_stmt
+| validate_external_connection_stmt // EXTEND WITH HELP: VALIDATE EXTERNAL CONNECTION
 
 // %Help: ALTER
 // %Category: Group
@@ -3439,6 +3441,25 @@ drop_external_connection_stmt:
 	}
 	| DROP EXTERNAL CONNECTION error // SHOW HELP: DROP EXTERNAL CONNECTION
 
+// %Help: VALIDATE EXTERNAL CONNECTION - validate an external connection
+// %Category: Misc
+// %Text:
+// VALIDATE EXTERNAL CONNECTION <name>
+//
+// Connects to the resource that the external connection represents, the
+// way a changefeed emitting to it would, and publishes a test message.
+//
+// Name:
+//   Unique name for this external connection.
+validate_external_connection_stmt:
+	VALIDATE EXTERNAL CONNECTION string_or_placeholder
+	{
+      $$.val = &tree.ValidateExternalConnection{
+            ConnectionLabel: $4.expr(),
+      }
+	}
+	| VALIDATE EXTERNAL CONNECTION error // SHOW HELP: VALIDATE EXTERNAL CONNECTION
+
 // %Help: RESTORE - restore data from external storage
 // %Category: CCL
 // %Text:
@@ -4702,7 +4723,7 @@ create_changefeed_stmt:
     }
   }
 | CREATE CHANGEFEED /*$3=*/ opt_changefeed_sink /*$4=*/ opt_with_options
-  AS SELECT /*$7=*/target_list FROM /*$9=*/changefeed_target_expr /*$10=*/opt_where_clause
+  AS SELECT /*$7=*/target_list FROM /*$9=*/changefeed_from_expr /*$10=*/opt_where_clause
   {
     target, err := tree.ChangefeedTargetFromTableExpr($9.tblExpr())
     if err != nil {
@@ -4750,6 +4771,21 @@ changefeed_target:
 
 changefeed_target_expr: insert_target
 
+// changefeed_from_expr is the changefeed target table, optionally joined with
+// reference tables which are looked up as each event is emitted. The join
+// types and conditions supported by changefeeds are verified when the
+// changefeed is created.
+changefeed_from_expr:
+  changefeed_target_expr
+| changefeed_from_expr JOIN changefeed_target_expr join_qual
+  {
+    $$.val = &tree.JoinTableExpr{Left: $1.tblExpr(), Right: $3.tblExpr(), Cond: $4.joinCond()}
+  }
+| changefeed_from_expr join_type JOIN changefeed_target_expr join_qual
+  {
+    $$.val = &tree.JoinTableExpr{JoinType: $2, Left: $1.tblExpr(), Right: $4.tblExpr(), Cond: $5.joinCond()}
+  }
+
 opt_table_prefix:
   TABLE
   {}
@@ -468,4 +468,16 @@ var DistAggregationTable = map[execinfrapb.AggregatorSpec_Func]DistAggregationIn
 			},
 		},
 	},
+
+	// The local stage computes the t-digests of the values, which are merged
+	// by the final stage.
+	execinfrapb.ApproxPercentile: {
+		LocalStage: []execinfrapb.AggregatorSpec_Func{execinfrapb.TransitionApproxPercentile},
+		FinalStage: []FinalStageInfo{
+			{
+				Fn:        execinfrapb.FinalApproxPercentile,
+				LocalIdxs: passThroughLocalIdxs,
+			},
+		},
+	},
 }
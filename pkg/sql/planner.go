@@ -198,6 +198,14 @@ type planner struct {
 
 	createdSequences createdSequences
 
+	// largeObjectDescriptors are the large objects opened in the current
+	// transaction (see LargeObjectOpen).
+	largeObjectDescriptors *largeObjectDescriptors
+
+	// advisoryLocks are the advisory locks held by the session (see
+	// AcquireAdvisoryLock).
+	advisoryLocks *advisoryLocks
+
 	// autoCommit indicates whether the plan is allowed (but not required) to
 	// commit the transaction along with other KV operations. Committing the txn
 	// might be beneficial because it may enable the 1PC optimization. Note that
@@ -384,6 +392,8 @@ func newInternalPlanner(
 	p.extendedEvalCtx.Tenant = p
 	p.extendedEvalCtx.Regions = p
 	p.extendedEvalCtx.JoinTokenCreator = p
+	p.extendedEvalCtx.LargeObjects = p
+	p.extendedEvalCtx.AdvisoryLocks = p
 	p.extendedEvalCtx.Gossip = p
 	p.extendedEvalCtx.ClusterID = execCfg.NodeInfo.LogicalClusterID()
 	p.extendedEvalCtx.ClusterName = execCfg.RPCContext.ClusterName()
@@ -402,6 +412,8 @@ func newInternalPlanner(
 	p.queryCacheSession.Init()
 	p.optPlanningCtx.init(p)
 	p.createdSequences = emptyCreatedSequences{}
+	p.largeObjectDescriptors = &largeObjectDescriptors{}
+	p.advisoryLocks = &advisoryLocks{}
 
 	p.schemaResolver.descCollection = p.Descriptors()
 	p.schemaResolver.sessionDataStack = sds
@@ -31,8 +31,10 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/arith"
 	"github.com/cockroachdb/cockroach/pkg/util/bitarray"
 	"github.com/cockroachdb/cockroach/pkg/util/duration"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
 	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/tdigest"
 	"github.com/cockroachdb/errors"
 	"github.com/twpayne/go-geom"
 )
@@ -330,6 +332,17 @@ var aggregates = map[string]builtinDefinition{
 			"Calculates the boolean value of `AND`ing all selected values."),
 	),
 
+	"locf": collectOverloads(aggProps(), allMaxMinAggregateTypes,
+		func(t *types.T) tree.Overload {
+			info := "Identifies the last non-null selected value. When used as a window " +
+				"function over rows ordered by time, carries the last observation forward " +
+				"into rows without one (e.g. gaps filled with time_bucket_gapfill). " +
+				"Compatible with TimescaleDB."
+			return makeImmutableAggOverloadWithReturnType(
+				[]*types.T{t}, tree.IdentityReturnType(0), newLocfAggregate, info,
+			)
+		}),
+
 	"max": collectOverloads(aggProps(), allMaxMinAggregateTypes,
 		func(t *types.T) tree.Overload {
 			info := "Identifies the maximum selected value."
@@ -554,6 +567,27 @@ var aggregates = map[string]builtinDefinition{
 			"Returns an arbitrary not-NULL value, or NULL if none exists.",
 		))),
 
+	"approx_percentile": makeApproxPercentileBuiltin(
+		types.Float,
+		newApproxPercentileAggregate,
+		"Approximate percentile: returns an estimate of the value corresponding to the "+
+			"specified fraction in the ordering of the selected values, without sorting them. "+
+			"The estimate is computed with a t-digest, and its rank is typically within 1% of "+
+			"the specified fraction for values drawn from a continuous distribution. The "+
+			"fraction must be the same for all rows.",
+	),
+
+	"transition_approx_percentile": makePrivate(makeApproxPercentileBuiltin(
+		types.Bytes,
+		newTransitionApproxPercentileAggregate,
+		"Calculates the t-digest of the selected values for approx_percentile in local stage.",
+	)),
+
+	"final_approx_percentile": makePrivate(makeBuiltin(aggProps(),
+		makeImmutableAggOverload([]*types.T{types.Bytes}, types.Float, newFinalApproxPercentileAggregate,
+			"Calculates the approximate percentile of the selected values in final stage."),
+	)),
+
 	// Ordered-set aggregations.
 	"percentile_disc": makeBuiltin(aggProps(),
 		makeImmutableAggOverloadWithReturnType(
@@ -829,6 +863,18 @@ func makeRegressionAggregate(
 	)
 }
 
+func makeApproxPercentileBuiltin(
+	ret *types.T,
+	aggregateFunc func([]*types.T, *eval.Context, tree.Datums) eval.AggregateFunc,
+	info string,
+) builtinDefinition {
+	return makeBuiltin(aggProps(),
+		makeImmutableAggOverload([]*types.T{types.Float, types.Float}, ret, aggregateFunc, info),
+		makeImmutableAggOverload([]*types.T{types.Int, types.Float}, ret, aggregateFunc, info),
+		makeImmutableAggOverload([]*types.T{types.Decimal, types.Float}, ret, aggregateFunc, info),
+	)
+}
+
 type stMakeLineAgg struct {
 	flatCoords []float64
 	layout     geom.Layout
@@ -1185,6 +1231,8 @@ var _ eval.AggregateFunc = &decimalVarianceAggregate{}
 var _ eval.AggregateFunc = &floatStdDevAggregate{}
 var _ eval.AggregateFunc = &decimalStdDevAggregate{}
 var _ eval.AggregateFunc = &anyNotNullAggregate{}
+var _ eval.AggregateFunc = &locfAggregate{}
+var _ eval.AggregateFunc = &approxPercentileAggregate{}
 var _ eval.AggregateFunc = &concatAggregate{}
 var _ eval.AggregateFunc = &boolAndAggregate{}
 var _ eval.AggregateFunc = &boolOrAggregate{}
@@ -1251,6 +1299,8 @@ const sizeOfDecimalVarPopAggregate = int64(unsafe.Sizeof(decimalVarPopAggregate{
 const sizeOfFloatStdDevAggregate = int64(unsafe.Sizeof(floatStdDevAggregate{}))
 const sizeOfDecimalStdDevAggregate = int64(unsafe.Sizeof(decimalStdDevAggregate{}))
 const sizeOfAnyNotNullAggregate = int64(unsafe.Sizeof(anyNotNullAggregate{}))
+const sizeOfLocfAggregate = int64(unsafe.Sizeof(locfAggregate{}))
+const sizeOfApproxPercentileAggregate = int64(unsafe.Sizeof(approxPercentileAggregate{}))
 const sizeOfConcatAggregate = int64(unsafe.Sizeof(concatAggregate{}))
 const sizeOfBoolAndAggregate = int64(unsafe.Sizeof(boolAndAggregate{}))
 const sizeOfBoolOrAggregate = int64(unsafe.Sizeof(boolOrAggregate{}))
@@ -1424,6 +1474,56 @@ func (a *anyNotNullAggregate) Size() int64 {
 	return sizeOfAnyNotNullAggregate
 }
 
+// locfAggregate returns the last non-NULL value passed to Add (or NULL if no
+// such value). Used as a window function over rows ordered by time, it carries
+// the last observation forward into the rows with NULL values, such as the
+// buckets produced by time_bucket_gapfill which have no observations.
+type locfAggregate struct {
+	singleDatumAggregateBase
+
+	val tree.Datum
+}
+
+func newLocfAggregate(_ []*types.T, evalCtx *eval.Context, _ tree.Datums) eval.AggregateFunc {
+	return &locfAggregate{
+		singleDatumAggregateBase: makeSingleDatumAggregateBase(evalCtx),
+		val:                      tree.DNull,
+	}
+}
+
+// Add sets the value to the passed datum, unless it is NULL.
+func (a *locfAggregate) Add(ctx context.Context, datum tree.Datum, _ ...tree.Datum) error {
+	if datum == tree.DNull {
+		return nil
+	}
+	if err := a.updateMemoryUsage(ctx, int64(datum.Size())); err != nil {
+		return err
+	}
+	a.val = datum
+	return nil
+}
+
+// Result returns the last non-NULL value passed to Add.
+func (a *locfAggregate) Result() (tree.Datum, error) {
+	return a.val, nil
+}
+
+// Reset implements eval.AggregateFunc interface.
+func (a *locfAggregate) Reset(ctx context.Context) {
+	a.val = tree.DNull
+	a.reset(ctx)
+}
+
+// Close is part of the eval.AggregateFunc interface.
+func (a *locfAggregate) Close(ctx context.Context) {
+	a.close(ctx)
+}
+
+// Size is part of the eval.AggregateFunc interface.
+func (a *locfAggregate) Size() int64 {
+	return sizeOfLocfAggregate
+}
+
 type arrayAggregate struct {
 	arr *tree.DArray
 	// Note that we do not embed singleDatumAggregateBase struct to help with
@@ -4720,6 +4820,185 @@ func (a *percentileContAggregate) Size() int64 {
 	return sizeOfPercentileContAggregate
 }
 
+// approxPercentileMode is the stage of the computation of approx_percentile
+// performed by an approxPercentileAggregate.
+type approxPercentileMode int
+
+const (
+	// approxPercentileValues computes the percentile of the values.
+	approxPercentileValues approxPercentileMode = iota
+	// approxPercentileTransition computes the encoded state of the values, to
+	// be merged by the final stage.
+	approxPercentileTransition
+	// approxPercentileFinal merges the encoded states of the local stages, and
+	// computes the percentile of their values.
+	approxPercentileFinal
+)
+
+// approxPercentileAggregate estimates a percentile of its values using a
+// t-digest. Since t-digests can be merged, the computation can be distributed:
+// the local stages encode their digests, along with the fraction, and the final
+// stage merges them.
+type approxPercentileAggregate struct {
+	singleDatumAggregateBase
+
+	mode      approxPercentileMode
+	arguments tree.Datums
+	digest    *tdigest.TDigest
+	// fraction is the requested fraction, which is either a *tree.DFloat or
+	// tree.DNull. It is nil until the first value is added.
+	fraction tree.Datum
+}
+
+func newApproxPercentileAggregate(
+	_ []*types.T, evalCtx *eval.Context, arguments tree.Datums,
+) eval.AggregateFunc {
+	return makeApproxPercentileAggregate(evalCtx, arguments, approxPercentileValues)
+}
+
+func newTransitionApproxPercentileAggregate(
+	_ []*types.T, evalCtx *eval.Context, arguments tree.Datums,
+) eval.AggregateFunc {
+	return makeApproxPercentileAggregate(evalCtx, arguments, approxPercentileTransition)
+}
+
+func newFinalApproxPercentileAggregate(
+	_ []*types.T, evalCtx *eval.Context, arguments tree.Datums,
+) eval.AggregateFunc {
+	return makeApproxPercentileAggregate(evalCtx, arguments, approxPercentileFinal)
+}
+
+func makeApproxPercentileAggregate(
+	evalCtx *eval.Context, arguments tree.Datums, mode approxPercentileMode,
+) *approxPercentileAggregate {
+	return &approxPercentileAggregate{
+		singleDatumAggregateBase: makeSingleDatumAggregateBase(evalCtx),
+		mode:                     mode,
+		arguments:                arguments,
+		digest:                   tdigest.New(tdigest.DefaultCompression),
+	}
+}
+
+// Add implements the eval.AggregateFunc interface.
+func (a *approxPercentileAggregate) Add(
+	ctx context.Context, datum tree.Datum, others ...tree.Datum,
+) error {
+	if datum == tree.DNull {
+		return nil
+	}
+	if a.mode == approxPercentileFinal {
+		if err := a.mergeState(tree.MustBeDBytes(datum)); err != nil {
+			return err
+		}
+		return a.updateMemoryUsage(ctx, a.digest.Size())
+	}
+
+	if a.fraction == nil {
+		// The fraction is the second argument, which is either a column or a
+		// constant argument.
+		if len(others) > 0 {
+			a.fraction = others[0]
+		} else {
+			a.fraction = a.arguments[0]
+		}
+	}
+	var x float64
+	switch t := datum.(type) {
+	case *tree.DFloat:
+		x = float64(*t)
+	case *tree.DInt:
+		x = float64(*t)
+	case *tree.DDecimal:
+		var err error
+		if x, err = t.Float64(); err != nil {
+			return err
+		}
+	default:
+		return errors.AssertionFailedf("unexpected approx_percentile input type %s", datum.ResolvedType())
+	}
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return pgerror.Newf(pgcode.InvalidParameterValue,
+			"approx_percentile does not support %s values", datum)
+	}
+	a.digest.Add(x)
+	return a.updateMemoryUsage(ctx, a.digest.Size())
+}
+
+// Result implements the eval.AggregateFunc interface.
+func (a *approxPercentileAggregate) Result() (tree.Datum, error) {
+	if a.digest.Count() == 0 {
+		return tree.DNull, nil
+	}
+	if a.mode == approxPercentileTransition {
+		return tree.NewDBytes(tree.DBytes(a.encodeState())), nil
+	}
+	if a.fraction == tree.DNull {
+		return tree.DNull, nil
+	}
+	fraction := float64(tree.MustBeDFloat(a.fraction))
+	if fraction < 0 || fraction > 1.0 {
+		return nil, pgerror.Newf(pgcode.NumericValueOutOfRange,
+			"percentile value %f is not between 0 and 1", fraction)
+	}
+	return tree.NewDFloat(tree.DFloat(a.digest.Quantile(fraction))), nil
+}
+
+// encodeState encodes the fraction and the digest of the local stage. The
+// encoding starts with a byte which is 0 if the fraction is NULL, followed by
+// the fraction and by the encoded digest.
+func (a *approxPercentileAggregate) encodeState() []byte {
+	buf := []byte{0}
+	var fraction float64
+	if a.fraction != tree.DNull {
+		buf[0] = 1
+		fraction = float64(tree.MustBeDFloat(a.fraction))
+	}
+	buf = encoding.EncodeUntaggedFloatValue(buf, fraction)
+	return a.digest.Encode(buf)
+}
+
+// mergeState merges a state encoded by encodeState into the aggregate.
+func (a *approxPercentileAggregate) mergeState(state tree.DBytes) error {
+	buf := []byte(state)
+	if len(buf) == 0 {
+		return errors.AssertionFailedf("empty approx_percentile state")
+	}
+	hasFraction := buf[0] != 0
+	buf, fraction, err := encoding.DecodeUntaggedFloatValue(buf[1:])
+	if err != nil {
+		return errors.NewAssertionErrorWithWrappedErrf(err, "invalid approx_percentile state")
+	}
+	digest, err := tdigest.Decode(buf)
+	if err != nil {
+		return errors.NewAssertionErrorWithWrappedErrf(err, "invalid approx_percentile state")
+	}
+	if a.fraction == nil {
+		a.fraction = tree.DNull
+		if hasFraction {
+			a.fraction = tree.NewDFloat(tree.DFloat(fraction))
+		}
+	}
+	a.digest.Merge(digest)
+	return nil
+}
+
+// Reset implements eval.AggregateFunc interface.
+func (a *approxPercentileAggregate) Reset(ctx context.Context) {
+	a.digest = tdigest.New(tdigest.DefaultCompression)
+	a.fraction = nil
+	a.reset(ctx)
+}
+
+// Close is part of the eval.AggregateFunc interface.
+func (a *approxPercentileAggregate) Close(ctx context.Context) {
+	a.close(ctx)
+}
+
+// Size is part of the eval.AggregateFunc interface.
+func (a *approxPercentileAggregate) Size() int64 {
+	return sizeOfApproxPercentileAggregate
+}
+
 type jsonObjectAggregate struct {
 	singleDatumAggregateBase
 
@@ -13,15 +13,12 @@ package builtins
 import (
 	"fmt"
 	"sort"
-	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/server/telemetry"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinsregistry"
-	"github.com/cockroachdb/cockroach/pkg/sql/sem/catconstants"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqltelemetry"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
-	"github.com/cockroachdb/errors"
 )
 
 // AllBuiltinNames is an array containing all the built-in function
@@ -53,22 +50,31 @@ func init() {
 	initReplicationBuiltins()
 	initPgcryptoBuiltins()
 	initProbeRangesBuiltins()
+	initLargeObjectBuiltins()
+	initVectorBuiltins()
+
+	// Build the index of the builtins once they are all registered; builtins
+	// may no longer be registered afterwards.
+	builtinsregistry.Freeze()
+	tree.GetBuiltinFunctionInSchema = builtinsregistry.GetQualified
+	tree.GetBuiltinOverloadsWithFirstArgFamily = builtinsregistry.GetOverloadsWithFirstArgFamily
 
 	tree.FunDefs = make(map[string]*tree.FunctionDefinition)
 	tree.ResolvedBuiltinFuncDefs = make(map[string]*tree.ResolvedFunctionDefinition)
-	builtinsregistry.Iterate(func(name string, props *tree.FunctionProperties, overloads []tree.Overload) {
-		fDef := tree.NewFunctionDefinition(name, props, overloads)
-		addResolvedFuncDef(tree.ResolvedBuiltinFuncDefs, fDef)
-		tree.FunDefs[name] = fDef
-		if !fDef.ShouldDocument() {
+	builtinsregistry.IterateIndexed(func(fn *builtinsregistry.IndexedFunction) {
+		for _, q := range fn.Qualified {
+			tree.ResolvedBuiltinFuncDefs[q.QualifiedName] = q.Definition
+		}
+		tree.FunDefs[fn.Name] = fn.Definition
+		if !fn.Definition.ShouldDocument() {
 			// Avoid listing help for undocumented functions.
 			return
 		}
-		AllBuiltinNames = append(AllBuiltinNames, name)
-		if props.Class == tree.AggregateClass {
-			AllAggregateBuiltinNames = append(AllAggregateBuiltinNames, name)
-		} else if props.Class == tree.WindowClass {
-			AllWindowBuiltinNames = append(AllWindowBuiltinNames, name)
+		AllBuiltinNames = append(AllBuiltinNames, fn.Name)
+		if fn.Props.Class == tree.AggregateClass {
+			AllAggregateBuiltinNames = append(AllAggregateBuiltinNames, fn.Name)
+		} else if fn.Props.Class == tree.WindowClass {
+			AllWindowBuiltinNames = append(AllWindowBuiltinNames, fn.Name)
 		}
 	})
 
@@ -80,22 +86,8 @@ func init() {
 func addResolvedFuncDef(
 	resolved map[string]*tree.ResolvedFunctionDefinition, def *tree.FunctionDefinition,
 ) {
-	parts := strings.Split(def.Name, ".")
-	if len(parts) > 2 || len(parts) == 0 {
-		// This shouldn't happen in theory.
-		panic(errors.AssertionFailedf("invalid builtin function name: %s", def.Name))
-	}
-
-	if len(parts) == 2 {
-		resolved[def.Name] = tree.QualifyBuiltinFunctionDefinition(def, parts[0])
-		return
-	}
-
-	resolvedName := catconstants.PgCatalogName + "." + def.Name
-	resolved[resolvedName] = tree.QualifyBuiltinFunctionDefinition(def, catconstants.PgCatalogName)
-	if def.AvailableOnPublicSchema {
-		resolvedName = catconstants.PublicSchemaName + "." + def.Name
-		resolved[resolvedName] = tree.QualifyBuiltinFunctionDefinition(def, catconstants.PublicSchemaName)
+	for _, q := range builtinsregistry.QualifyDefinition(def) {
+		resolved[q.QualifiedName] = q.Definition
 	}
 }
 
@@ -11,6 +11,7 @@
 package builtins
 
 import (
+	"context"
 	"encoding/csv"
 	"io"
 	"os"
@@ -241,3 +242,139 @@ func TestAddResolvedFuncDef(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterAfterFreezePanics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	require.Panics(t, func() {
+		builtinsregistry.Register("registered_after_freeze", &tree.FunctionProperties{}, nil)
+	})
+	_, ok := builtinsregistry.GetIndexed("registered_after_freeze")
+	require.False(t, ok)
+}
+
+func TestIndexedBuiltins(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	builtinsregistry.IterateIndexed(func(fn *builtinsregistry.IndexedFunction) {
+		require.Same(t, tree.FunDefs[fn.Name], fn.Definition)
+		for _, q := range fn.Qualified {
+			name := fn.Name[strings.LastIndexByte(fn.Name, '.')+1:]
+			require.Same(t, tree.ResolvedBuiltinFuncDefs[q.QualifiedName], q.Definition)
+			require.Same(t, q.Definition, builtinsregistry.GetQualified(q.Schema, name))
+		}
+
+		// The indexed overloads must be the same as the ones found by scanning
+		// all the overloads of the function.
+		for n := 0; n < 5; n++ {
+			var expected []*tree.Overload
+			for _, o := range fn.Definition.Definition {
+				if o.Types.MatchLen(n) {
+					expected = append(expected, o)
+				}
+			}
+			require.ElementsMatch(t, expected, fn.OverloadsWithArity(n), "%s with %d arguments", fn.Name, n)
+		}
+		for _, family := range []types.Family{types.IntFamily, types.StringFamily, types.ArrayFamily} {
+			var expected []*tree.Overload
+			for _, o := range fn.Definition.Definition {
+				if o.Types.Length() == 0 {
+					continue
+				}
+				if f := o.Types.GetAt(0).Family(); f == family || f == types.AnyFamily {
+					expected = append(expected, o)
+				}
+			}
+			require.ElementsMatch(t, expected, fn.OverloadsWithFirstArgFamily(family), "%s on %s", fn.Name, family)
+		}
+	})
+
+	require.Nil(t, builtinsregistry.GetQualified("pg_catalog", "no_such_function"))
+	require.Nil(t, builtinsregistry.GetQualified("crdb_internal", "length"))
+	require.NotNil(t, builtinsregistry.GetQualified("crdb_internal", "cluster_id"))
+}
+
+func TestTypeCheckWithFirstArgFamily(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+	semaCtx := tree.MakeSemaContext()
+
+	// The overloads of a function called with a typed first argument are
+	// narrowed down to those accepting its type family.
+	overloads, ok := tree.GetBuiltinOverloadsWithFirstArgFamily("length", types.BytesFamily)
+	require.True(t, ok)
+	require.Len(t, overloads, 1)
+	expr := &tree.FuncExpr{
+		Func:  tree.WrapFunction("length"),
+		Exprs: tree.Exprs{tree.NewDBytes("abc")},
+	}
+	typed, err := tree.TypeCheck(ctx, expr, &semaCtx, types.Any)
+	require.NoError(t, err)
+	require.Same(t, overloads[0], typed.(*tree.FuncExpr).ResolvedOverload())
+
+	_, ok = tree.GetBuiltinOverloadsWithFirstArgFamily("no_such_function", types.BytesFamily)
+	require.False(t, ok)
+}
+
+// BenchmarkBuiltinLookup compares resolving builtins on the schemas of a search
+// path by their qualified name against the lookups into the registry index,
+// for a function with many overloads and for a miss.
+func BenchmarkBuiltinLookup(b *testing.B) {
+	schemas := []string{"public", "pg_catalog"}
+	for _, name := range []string{"array_append", "no_such_function"} {
+		b.Run(name, func(b *testing.B) {
+			b.Run("qualified-name", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					for _, schema := range schemas {
+						if tree.ResolvedBuiltinFuncDefs[schema+"."+name] != nil {
+							break
+						}
+					}
+				}
+			})
+			b.Run("indexed", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					for _, schema := range schemas {
+						if builtinsregistry.GetQualified(schema, name) != nil {
+							break
+						}
+					}
+				}
+			})
+			b.Run("resolve", func(b *testing.B) {
+				b.ReportAllocs()
+				fName := tree.MakeFunctionNameFromPrefix(tree.ObjectNamePrefix{}, tree.Name(name))
+				for i := 0; i < b.N; i++ {
+					if _, err := tree.GetBuiltinFuncDefinition(&fName, tree.EmptySearchPath); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkBuiltinOverloadsWithArity compares finding the overloads of a
+// builtin with many overloads which accept a number of arguments by scanning
+// them against using the registry index.
+func BenchmarkBuiltinOverloadsWithArity(b *testing.B) {
+	const name = "array_append"
+	b.Run("scan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var overloads []*tree.Overload
+			for _, o := range tree.FunDefs[name].Definition {
+				if o.Types.MatchLen(2) {
+					overloads = append(overloads, o)
+				}
+			}
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fn, _ := builtinsregistry.GetIndexed(name)
+			_ = fn.OverloadsWithArity(2)
+		}
+	})
+}
@@ -19,6 +19,7 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/binary"
 	gojson "encoding/json"
 	"fmt"
 	"hash"
@@ -62,6 +63,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/roleoption"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc/keyside"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc/valueside"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/asof"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinconstants"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/pgformat"
@@ -76,6 +78,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sqltelemetry"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/arith"
 	"github.com/cockroachdb/cockroach/pkg/util/duration"
 	"github.com/cockroachdb/cockroach/pkg/util/encoding"
 	"github.com/cockroachdb/cockroach/pkg/util/errorutil/unimplemented"
@@ -1967,7 +1970,10 @@ var regularBuiltins = map[string]builtinDefinition{
 				return tree.NewDString(buf.String()), nil
 			},
 			types.String,
-			"Return `val` suitably quoted to serve as identifier in a SQL statement.",
+			"Return `val` suitably quoted to serve as identifier in a SQL statement. "+
+				"`val` is only quoted if needed, that is if it is a reserved keyword, contains "+
+				"upper case characters, or contains characters which are not permitted in an "+
+				"unquoted identifier. Embedded quotes are doubled.",
 			volatility.Immutable,
 		)),
 
@@ -3013,6 +3019,14 @@ value if you rely on the HLC for accuracy.`,
 		},
 	),
 
+	"time_bucket": makeBuiltin(
+		tree.FunctionProperties{Category: builtinconstants.CategoryDateAndTime},
+		makeTimeBucketOverload(types.TimestampTZ, false /* withOrigin */),
+		makeTimeBucketOverload(types.TimestampTZ, true /* withOrigin */),
+		makeTimeBucketOverload(types.Timestamp, false /* withOrigin */),
+		makeTimeBucketOverload(types.Timestamp, true /* withOrigin */),
+	),
+
 	"row_to_json": makeBuiltin(defProps(),
 		tree.Overload{
 			Types:      tree.ArgTypes{{"row", types.AnyTuple}},
@@ -3917,6 +3931,8 @@ value if you rely on the HLC for accuracy.`,
 
 	"jsonb_strip_nulls": makeBuiltin(jsonProps(), jsonStripNullsImpl),
 
+	"jsonb_deep_merge": makeBuiltin(jsonProps(), jsonDeepMergeImpl),
+
 	"json_array_length": makeBuiltin(jsonProps(), jsonArrayLengthImpl),
 
 	"jsonb_array_length": makeBuiltin(jsonProps(), jsonArrayLengthImpl),
@@ -5418,6 +5434,89 @@ value if you rely on the HLC for accuracy.`,
 		},
 	),
 
+	// Return the decoded datum for a given raw value, along with details about
+	// its encoding.
+	"crdb_internal.pretty_value": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         builtinconstants.CategorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types: tree.ArgTypes{
+				{"raw_value", types.Bytes},
+				{"type_oid", types.Oid},
+			},
+			ReturnType: tree.FixedReturnType(prettyValueType),
+			Fn: func(ctx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrettyValuePrivilege(ctx); err != nil {
+					return nil, err
+				}
+				typ, err := resolvePrettyValueType(ctx, tree.MustBeDOid(args[1]).Oid)
+				if err != nil {
+					return nil, err
+				}
+				return prettyValue(
+					nil /* key */, []byte(tree.MustBeDBytes(args[0])), typ, 0, /* colID */
+				), nil
+			},
+			Info: "Decodes the raw value as the given type and returns the decoded datum " +
+				"along with details about its encoding. If the value encodes multiple " +
+				"columns, the first column is decoded. " +
+				"This function is used only by CockroachDB's developers for debugging purposes.",
+			Volatility: volatility.Stable,
+		},
+		tree.Overload{
+			Types: tree.ArgTypes{
+				{"raw_value", types.Bytes},
+				{"table", types.RegClass},
+				{"column_id", types.Int},
+			},
+			ReturnType: tree.FixedReturnType(prettyValueType),
+			Fn: func(ctx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrettyValuePrivilege(ctx); err != nil {
+					return nil, err
+				}
+				colID := descpb.ColumnID(tree.MustBeDInt(args[2]))
+				typ, err := resolvePrettyValueColumnType(ctx, tree.MustBeDOid(args[1]), colID)
+				if err != nil {
+					return nil, err
+				}
+				return prettyValue(nil /* key */, []byte(tree.MustBeDBytes(args[0])), typ, colID), nil
+			},
+			Info: "Decodes the given column of the table from the raw value and returns the " +
+				"decoded datum along with details about its encoding. " +
+				"This function is used only by CockroachDB's developers for debugging purposes.",
+			Volatility: volatility.Stable,
+		},
+		tree.Overload{
+			Types: tree.ArgTypes{
+				{"raw_key", types.Bytes},
+				{"raw_value", types.Bytes},
+				{"table", types.RegClass},
+				{"column_id", types.Int},
+			},
+			ReturnType: tree.FixedReturnType(prettyValueType),
+			Fn: func(ctx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrettyValuePrivilege(ctx); err != nil {
+					return nil, err
+				}
+				colID := descpb.ColumnID(tree.MustBeDInt(args[3]))
+				typ, err := resolvePrettyValueColumnType(ctx, tree.MustBeDOid(args[2]), colID)
+				if err != nil {
+					return nil, err
+				}
+				return prettyValue(
+					roachpb.Key(tree.MustBeDBytes(args[0])), []byte(tree.MustBeDBytes(args[1])), typ, colID,
+				), nil
+			},
+			Info: "Decodes the given column of the table from the raw key/value pair and " +
+				"returns the decoded datum along with details about its encoding, " +
+				"including the validity of the value's checksum. " +
+				"This function is used only by CockroachDB's developers for debugging purposes.",
+			Volatility: volatility.Stable,
+		},
+	),
+
 	// Return statistics about a range.
 	"crdb_internal.range_stats": makeBuiltin(
 		tree.FunctionProperties{
@@ -6684,6 +6783,23 @@ table's zone configuration this will return NULL.`,
 			Volatility: volatility.Volatile,
 		},
 	),
+	"crdb_internal.decode_session_revival_token": makeBuiltin(
+		tree.FunctionProperties{
+			Category: builtinconstants.CategorySystemInfo,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"token", types.Bytes}},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				token := tree.MustBeDBytes(args[0])
+				return evalCtx.Planner.DecodeSessionRevivalToken(evalCtx.Ctx(), &token)
+			},
+			Info: `Decode the claims of a token that was created by create_session_revival_token ` +
+				`as JSON, and validate it for the user it was issued to. The result includes whether ` +
+				`the token is valid, and if not, why. Requires the admin role.`,
+			Volatility: volatility.Volatile,
+		},
+	),
 
 	"crdb_internal.validate_ttl_scheduled_jobs": makeBuiltin(
 		tree.FunctionProperties{
@@ -8293,6 +8409,24 @@ var jsonStripNullsImpl = tree.Overload{
 	Volatility: volatility.Immutable,
 }
 
+var jsonDeepMergeImpl = tree.Overload{
+	Types:      tree.ArgTypes{{"left", types.Jsonb}, {"right", types.Jsonb}},
+	ReturnType: tree.FixedReturnType(types.Jsonb),
+	Fn: func(_ *eval.Context, args tree.Datums) (tree.Datum, error) {
+		j, err := json.DeepMerge(tree.MustBeDJSON(args[0]).JSON, tree.MustBeDJSON(args[1]).JSON)
+		if err != nil {
+			return nil, err
+		}
+		return tree.NewDJSON(j), nil
+	},
+	Info: "Returns the result of recursively merging right into left. Unlike the `||` " +
+		"operator, which merges objects shallowly, objects present at the same key in " +
+		"both inputs are merged key by key. Otherwise, the value from right replaces " +
+		"the value from left: arrays are replaced rather than concatenated, and " +
+		"conflicting scalars resolve to the value from right.",
+	Volatility: volatility.Immutable,
+}
+
 var jsonArrayLengthImpl = tree.Overload{
 	Types:      tree.ArgTypes{{"json", types.Jsonb}},
 	ReturnType: tree.FixedReturnType(types.Int),
@@ -9454,6 +9588,94 @@ func decodeEscape(input string) ([]byte, error) {
 	return result, nil
 }
 
+// timeBucketDefaultOrigin is the timestamp on which the buckets of time_bucket
+// are aligned when no origin is specified. It is a Monday, so that weekly
+// buckets start on Mondays, as in TimescaleDB.
+var timeBucketDefaultOrigin = time.Date(2000, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+var errTimeBucketWidthOutOfRange = pgerror.New(pgcode.DatetimeFieldOverflow,
+	"time_bucket interval out of range")
+
+// timeBucketWidth returns the width in microseconds of the buckets of
+// time_bucket. Days are considered to be 24 hours long; intervals with months
+// are rejected since the width of their buckets would vary.
+func timeBucketWidth(d duration.Duration) (int64, error) {
+	if d.Months != 0 {
+		return 0, pgerror.New(pgcode.FeatureNotSupported,
+			"time_bucket does not support intervals containing months or years")
+	}
+	const microsPerDay = int64(24 * time.Hour / time.Microsecond)
+	if d.Days > math.MaxInt64/microsPerDay || d.Days < math.MinInt64/microsPerDay {
+		return 0, errTimeBucketWidthOutOfRange
+	}
+	width, ok := arith.AddWithOverflow(d.Days*microsPerDay, d.Nanos()/int64(time.Microsecond))
+	if !ok {
+		return 0, errTimeBucketWidthOutOfRange
+	}
+	if width <= 0 {
+		return 0, pgerror.New(pgcode.InvalidParameterValue,
+			"time_bucket interval must be at least 1 microsecond")
+	}
+	return width, nil
+}
+
+// timeBucket returns the start of the bucket of the specified width which
+// contains ts, where the buckets are aligned on origin.
+func timeBucket(ts, origin time.Time, width int64) time.Time {
+	// Work in microseconds, the precision of timestamps, so that timestamps far
+	// from the origin do not overflow.
+	diff := ts.UnixMicro() - origin.UnixMicro()
+	offset := diff % width
+	if offset < 0 {
+		offset += width
+	}
+	return time.UnixMicro(ts.UnixMicro() - offset).UTC()
+}
+
+func makeTimeBucketOverload(typ *types.T, withOrigin bool) tree.Overload {
+	argTypes := tree.ArgTypes{{"bucket_width", types.Interval}, {"ts", typ}}
+	info := "Returns the start of the bucket of width `bucket_width` which contains `ts`. " +
+		"Buckets are aligned on 2000-01-03 00:00:00 UTC (a Monday) unless `origin` is " +
+		"specified. Days are considered to be 24 hours long; intervals containing months " +
+		"are not supported. Compatible with TimescaleDB."
+	if withOrigin {
+		argTypes = append(argTypes, tree.ArgTypes{{"origin", typ}}...)
+	}
+	return tree.Overload{
+		Types:      argTypes,
+		ReturnType: tree.FixedReturnType(typ),
+		Fn: func(_ *eval.Context, args tree.Datums) (tree.Datum, error) {
+			width, err := timeBucketWidth(tree.MustBeDInterval(args[0]).Duration)
+			if err != nil {
+				return nil, err
+			}
+			origin := timeBucketDefaultOrigin
+			if withOrigin {
+				origin = datumTime(args[2])
+			}
+			bucket := timeBucket(datumTime(args[1]), origin, width)
+			if typ.Family() == types.TimestampTZFamily {
+				return tree.MakeDTimestampTZ(bucket, time.Microsecond)
+			}
+			return tree.MakeDTimestamp(bucket, time.Microsecond)
+		},
+		Info:       info,
+		Volatility: volatility.Immutable,
+	}
+}
+
+// datumTime returns the time of a TIMESTAMP or TIMESTAMPTZ datum.
+func datumTime(d tree.Datum) time.Time {
+	switch t := d.(type) {
+	case *tree.DTimestampTZ:
+		return t.Time
+	case *tree.DTimestamp:
+		return t.Time
+	default:
+		panic(errors.AssertionFailedf("unexpected datum type %T", d))
+	}
+}
+
 func truncateTimestamp(fromTime time.Time, timeSpan string) (*tree.DTimestampTZ, error) {
 	year := fromTime.Year()
 	month := fromTime.Month()
@@ -9963,3 +10185,143 @@ func prettyStatement(p tree.PrettyCfg, stmt string) (string, error) {
 	}
 	return formattedStmt.String(), nil
 }
+
+var prettyValueType = types.MakeLabeledTuple(
+	[]*types.T{types.String, types.Jsonb},
+	[]string{"value", "details"},
+)
+
+func checkPrettyValuePrivilege(ctx *eval.Context) error {
+	isAdmin, err := ctx.SessionAccessor.HasAdminRole(ctx.Context)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return errors.New("crdb_internal.pretty_value() requires admin privilege")
+	}
+	return nil
+}
+
+// resolvePrettyValueType returns the type with the given OID, which may be
+// either a builtin or a user defined type.
+func resolvePrettyValueType(ctx *eval.Context, typOid oid.Oid) (*types.T, error) {
+	if typ, ok := types.OidToType[typOid]; ok {
+		return typ, nil
+	}
+	return ctx.Planner.ResolveTypeByOID(ctx.Context, typOid)
+}
+
+// resolvePrettyValueColumnType returns the type of the column with the given
+// ID in the given table.
+func resolvePrettyValueColumnType(
+	ctx *eval.Context, table *tree.DOid, colID descpb.ColumnID,
+) (*types.T, error) {
+	row, err := ctx.Planner.QueryRowEx(
+		ctx.Ctx(), "crdb_internal.pretty_value",
+		sessiondata.NoSessionDataOverride,
+		`SELECT a.atttypid
+       FROM crdb_internal.table_columns AS c
+       JOIN pg_catalog.pg_attribute AS a ON a.attrelid = c.descriptor_id AND a.attname = c.column_name
+      WHERE c.descriptor_id = $1 AND c.column_id = $2`,
+		tree.NewDInt(tree.DInt(table.Oid)), tree.NewDInt(tree.DInt(colID)))
+	if err != nil {
+		return nil, err
+	}
+	if len(row) == 0 {
+		return nil, pgerror.Newf(pgcode.UndefinedColumn,
+			"column with ID %d does not exist in table %s", colID, table)
+	}
+	return resolvePrettyValueType(ctx, tree.MustBeDOid(row[0]).Oid)
+}
+
+// prettyValue decodes the raw bytes of a KV value as a datum of the given
+// type. It returns the datum formatted as text along with a JSON object
+// describing the encoding of the value. If the value encodes a tuple of
+// columns, the column with the given ID is decoded, or the first column if
+// colID is 0. If the key is specified, the checksum of the value is verified.
+//
+// Decoding errors are reported in the JSON object rather than returned, so
+// that a corrupted value doesn't fail the query inspecting it.
+func prettyValue(key roachpb.Key, rawValue []byte, typ *types.T, colID descpb.ColumnID) tree.Datum {
+	v := roachpb.Value{RawBytes: rawValue}
+	details := json.NewObjectBuilder(5 /* numAddsHint */)
+	result := func(d tree.Datum, err error) tree.Datum {
+		value := tree.Datum(tree.DNull)
+		if err != nil {
+			details.Add("error", json.FromString(err.Error()))
+		} else if d != tree.DNull {
+			value = tree.NewDString(tree.AsStringWithFlags(d, tree.FmtPgwireText))
+		}
+		return tree.NewDTuple(prettyValueType, value, tree.NewDJSON(details.Build()))
+	}
+
+	details.Add("tag", json.FromString(v.GetTag().String()))
+	if err := v.VerifyHeader(); err != nil {
+		return result(nil, err)
+	}
+	if len(rawValue) == 0 {
+		// An empty value is a deletion tombstone.
+		return result(tree.DNull, nil)
+	}
+	if checksum := binary.BigEndian.Uint32(rawValue); checksum != 0 {
+		details.Add("checksum", json.FromString(fmt.Sprintf("%08x", checksum)))
+		if key != nil {
+			details.Add("checksum_valid", json.FromBool(v.Verify(key) == nil))
+		}
+	}
+
+	var a tree.DatumAlloc
+	if v.GetTag() != roachpb.ValueType_TUPLE {
+		// Single column families are encoded using the legacy encoding.
+		d, err := valueside.UnmarshalLegacy(&a, typ, v)
+		return result(d, err)
+	}
+	b, err := v.GetTuple()
+	if err != nil {
+		return result(nil, err)
+	}
+	var lastColID descpb.ColumnID
+	for len(b) > 0 {
+		_, _, colIDDelta, encType, err := encoding.DecodeValueTag(b)
+		if err != nil {
+			return result(nil, err)
+		}
+		lastColID += descpb.ColumnID(colIDDelta)
+		if colID == 0 || lastColID == colID {
+			details.Add("column_id", json.FromInt(int(lastColID)))
+			details.Add("encoding", json.FromString(encType.String()))
+			if !valueEncodingMatchesType(encType, typ) {
+				return result(nil, errors.Newf("value encoded as %s cannot be decoded as %s", encType, typ.SQLString()))
+			}
+			d, _, err := valueside.Decode(&a, typ, b)
+			return result(d, err)
+		}
+		_, n, err := encoding.PeekValueLength(b)
+		if err != nil {
+			return result(nil, err)
+		}
+		b = b[n:]
+	}
+	// Columns which are NULL are omitted from the tuple.
+	return result(tree.DNull, nil)
+}
+
+// valueEncodingMatchesType returns whether a value with the given encoding
+// type could have been encoded from a datum of the given type. Values with an
+// unknown encoding for the type are assumed to match.
+func valueEncodingMatchesType(encType encoding.Type, typ *types.T) bool {
+	if encType == encoding.Null {
+		return true
+	}
+	switch typ.Family() {
+	case types.ArrayFamily:
+		return encType == encoding.Array
+	case types.BoolFamily:
+		return encType == encoding.True || encType == encoding.False
+	}
+	expected, err := valueside.DatumTypeToArrayElementEncodingType(typ)
+	if err != nil {
+		return true
+	}
+	return encType == expected
+}
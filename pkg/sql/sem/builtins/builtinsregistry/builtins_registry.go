@@ -21,8 +21,12 @@ import "github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 var registry = map[string]definition{}
 
 // Register registers a builtin. Intending to be called at init time, it panics
-// if a function of the same name has already been registered.
+// if a function of the same name has already been registered, or if the
+// registry has already been frozen.
 func Register(name string, props *tree.FunctionProperties, overloads []tree.Overload) {
+	if index.frozen {
+		panic("builtin registered after the registry was frozen: " + name)
+	}
 	if _, exists := registry[name]; exists {
 		panic("duplicate builtin: " + name)
 	}
@@ -0,0 +1,238 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package builtinsregistry
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catconstants"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// IndexedFunction is an immutable descriptor of a builtin function, built once
+// all the builtins have been registered (see Freeze).
+type IndexedFunction struct {
+	// Name is the name the function was registered with.
+	Name       string
+	Props      *tree.FunctionProperties
+	Overloads  []tree.Overload
+	Definition *tree.FunctionDefinition
+	// Qualified holds the definitions of the function qualified with each of
+	// the schemas it is available on.
+	Qualified []QualifiedDefinition
+
+	// byArity holds, for each number of arguments up to maxArity, the
+	// overloads which accept that number of arguments.
+	byArity [][]*tree.Overload
+	// variadic holds the overloads which accept a variable number of
+	// arguments.
+	variadic []*tree.Overload
+	// byFirstFamily holds the overloads whose first parameter accepts the
+	// family, including the overloads whose first parameter accepts any type.
+	byFirstFamily map[types.Family][]*tree.Overload
+	// anyFirst holds the overloads whose first parameter accepts any type.
+	anyFirst []*tree.Overload
+}
+
+// QualifiedDefinition is the definition of a builtin function qualified with
+// a schema it is available on.
+type QualifiedDefinition struct {
+	Schema string
+	// QualifiedName is the schema qualified name of the function, e.g.
+	// "pg_catalog.concat".
+	QualifiedName string
+	Definition    *tree.ResolvedFunctionDefinition
+}
+
+// OverloadsWithArity returns the overloads of the function which accept the
+// specified number of arguments. The returned slice must not be modified.
+func (f *IndexedFunction) OverloadsWithArity(n int) []*tree.Overload {
+	if n < 0 {
+		return nil
+	}
+	if n < len(f.byArity) {
+		return f.byArity[n]
+	}
+	return f.variadic
+}
+
+// OverloadsWithFirstArgFamily returns the overloads of the function whose
+// first parameter accepts a value of the specified type family. The returned
+// slice must not be modified.
+func (f *IndexedFunction) OverloadsWithFirstArgFamily(family types.Family) []*tree.Overload {
+	if overloads, ok := f.byFirstFamily[family]; ok {
+		return overloads
+	}
+	return f.anyFirst
+}
+
+// qualifiedKey identifies a builtin function available on a schema. Using it
+// as a map key allows looking up qualified functions without building their
+// qualified name.
+type qualifiedKey struct {
+	schema, name string
+}
+
+// index is the immutable index of the registered functions, built by Freeze.
+// Functions may no longer be registered once it is set.
+var index struct {
+	frozen    bool
+	functions map[string]*IndexedFunction
+	qualified map[qualifiedKey]*tree.ResolvedFunctionDefinition
+}
+
+// Freeze builds the index of the registered builtins. It is intended to be
+// called once, after all the builtins have been registered at init time;
+// registering functions afterwards panics.
+func Freeze() {
+	if index.frozen {
+		panic(errors.AssertionFailedf("builtins registry already frozen"))
+	}
+	index.frozen = true
+	index.functions = make(map[string]*IndexedFunction, len(registry))
+	index.qualified = make(map[qualifiedKey]*tree.ResolvedFunctionDefinition, len(registry))
+	for name, def := range registry {
+		f := makeIndexedFunction(name, def)
+		index.functions[name] = f
+		for _, q := range f.Qualified {
+			index.qualified[qualifiedKey{schema: q.Schema, name: name[strings.LastIndexByte(name, '.')+1:]}] = q.Definition
+		}
+	}
+}
+
+// GetIndexed returns the indexed descriptor of the builtin function registered
+// with the specified name. It may only be called once the registry is frozen.
+func GetIndexed(name string) (*IndexedFunction, bool) {
+	f, ok := index.functions[name]
+	return f, ok
+}
+
+// GetQualified returns the definition of the builtin function with the
+// specified unqualified name available on the specified schema, or nil if
+// there is none. It does not allocate.
+func GetQualified(schema, name string) *tree.ResolvedFunctionDefinition {
+	return index.qualified[qualifiedKey{schema: schema, name: name}]
+}
+
+// GetOverloadsWithFirstArgFamily returns the overloads of the builtin function
+// registered with the specified name whose first parameter accepts a value of
+// the specified type family, and whether there is such a builtin. The returned
+// slice must not be modified.
+func GetOverloadsWithFirstArgFamily(name string, family types.Family) ([]*tree.Overload, bool) {
+	f, ok := index.functions[name]
+	if !ok {
+		return nil, false
+	}
+	return f.OverloadsWithFirstArgFamily(family), true
+}
+
+// IterateIndexed iterates the indexed functions. It may only be called once
+// the registry is frozen.
+func IterateIndexed(f func(fn *IndexedFunction)) {
+	for _, fn := range index.functions {
+		f(fn)
+	}
+}
+
+// QualifyDefinition returns the definitions of the builtin function qualified
+// with each of the schemas it is available on. Functions registered with a
+// qualified name are only available on that schema; other functions are
+// available on pg_catalog, and also on public if AvailableOnPublicSchema is
+// set.
+func QualifyDefinition(def *tree.FunctionDefinition) []QualifiedDefinition {
+	parts := strings.Split(def.Name, ".")
+	if len(parts) > 2 || len(parts) == 0 {
+		// This shouldn't happen in theory.
+		panic(errors.AssertionFailedf("invalid builtin function name: %s", def.Name))
+	}
+
+	if len(parts) == 2 {
+		return []QualifiedDefinition{{
+			Schema:        parts[0],
+			QualifiedName: def.Name,
+			Definition:    tree.QualifyBuiltinFunctionDefinition(def, parts[0]),
+		}}
+	}
+
+	qualified := []QualifiedDefinition{{
+		Schema:        catconstants.PgCatalogName,
+		QualifiedName: catconstants.PgCatalogName + "." + def.Name,
+		Definition:    tree.QualifyBuiltinFunctionDefinition(def, catconstants.PgCatalogName),
+	}}
+	if def.AvailableOnPublicSchema {
+		qualified = append(qualified, QualifiedDefinition{
+			Schema:        catconstants.PublicSchemaName,
+			QualifiedName: catconstants.PublicSchemaName + "." + def.Name,
+			Definition:    tree.QualifyBuiltinFunctionDefinition(def, catconstants.PublicSchemaName),
+		})
+	}
+	return qualified
+}
+
+func makeIndexedFunction(name string, def definition) *IndexedFunction {
+	fnDef := tree.NewFunctionDefinition(name, def.props, def.overloads)
+	f := &IndexedFunction{
+		Name:          name,
+		Props:         def.props,
+		Overloads:     def.overloads,
+		Definition:    fnDef,
+		Qualified:     QualifyDefinition(fnDef),
+		byFirstFamily: make(map[types.Family][]*tree.Overload),
+	}
+
+	// Overloads are indexed by the pointers of the function definition, which
+	// carry the function properties.
+	overloads := fnDef.Definition
+	maxArity := 0
+	for _, o := range overloads {
+		if _, ok := o.Types.(tree.ArgTypes); ok && o.Types.Length() > maxArity {
+			maxArity = o.Types.Length()
+		} else if !ok {
+			f.variadic = append(f.variadic, o)
+		}
+	}
+	f.byArity = make([][]*tree.Overload, maxArity+1)
+	for n := range f.byArity {
+		for _, o := range overloads {
+			if o.Types.MatchLen(n) {
+				f.byArity[n] = append(f.byArity[n], o)
+			}
+		}
+	}
+
+	for _, o := range overloads {
+		if first := firstArgType(o); first != nil && first.Family() == types.AnyFamily {
+			f.anyFirst = append(f.anyFirst, o)
+		}
+	}
+	for _, o := range overloads {
+		first := firstArgType(o)
+		if first == nil || first.Family() == types.AnyFamily {
+			continue
+		}
+		if _, ok := f.byFirstFamily[first.Family()]; !ok {
+			f.byFirstFamily[first.Family()] = append([]*tree.Overload(nil), f.anyFirst...)
+		}
+		f.byFirstFamily[first.Family()] = append(f.byFirstFamily[first.Family()], o)
+	}
+	return f
+}
+
+// firstArgType returns the type of the first parameter of the overload, or nil
+// if it has none.
+func firstArgType(o *tree.Overload) *types.T {
+	if o.Types.Length() == 0 {
+		return nil
+	}
+	return o.Types.GetAt(0)
+}
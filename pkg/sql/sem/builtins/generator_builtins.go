@@ -20,10 +20,12 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvclient"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
 	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/protoreflect"
+	"github.com/cockroachdb/cockroach/pkg/sql/roleoption"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinconstants"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
@@ -197,6 +199,26 @@ var generators = map[string]builtinDefinition{
 			volatility.Immutable,
 		),
 	),
+	"time_bucket_gapfill": makeBuiltin(genProps(),
+		makeGeneratorOverload(
+			tree.ArgTypes{{"bucket_width", types.Interval}, {"start", types.TimestampTZ}, {"finish", types.TimestampTZ}},
+			types.TimestampTZ,
+			makeTimeBucketGapfillGenerator,
+			"Produces a virtual table containing the start of every bucket of width `bucket_width`, "+
+				"as returned by time_bucket, from the bucket containing `start` up to `finish`, exclusive. "+
+				"Joining with it fills the gaps of bucketed time series. Compatible with TimescaleDB.",
+			volatility.Immutable,
+		),
+		makeGeneratorOverload(
+			tree.ArgTypes{{"bucket_width", types.Interval}, {"start", types.Timestamp}, {"finish", types.Timestamp}},
+			types.Timestamp,
+			makeTimeBucketGapfillGenerator,
+			"Produces a virtual table containing the start of every bucket of width `bucket_width`, "+
+				"as returned by time_bucket, from the bucket containing `start` up to `finish`, exclusive. "+
+				"Joining with it fills the gaps of bucketed time series. Compatible with TimescaleDB.",
+			volatility.Immutable,
+		),
+	),
 	// crdb_internal.testing_callback is a generator function intended for internal unit tests.
 	// You give it a name and it calls a callback that had to have been installed
 	// on a TestServer through its eval.TestingKnobs.CallbackGenerators.
@@ -233,6 +255,29 @@ var generators = map[string]builtinDefinition{
 			volatility.Immutable,
 		),
 	),
+	"pg_partition_tree": makeBuiltin(genProps(),
+		makeGeneratorOverload(
+			tree.ArgTypes{{"table", types.RegClass}},
+			pgPartitionTreeGeneratorType,
+			makePGPartitionTreeGenerator,
+			"Produces the partition tree of a table, as defined by the partitioning of its "+
+				"primary index. The first row is the table itself, and each partition has a row "+
+				"with its name, the name of its parent, whether it has no subpartitions, and "+
+				"its level in the tree. Produces no rows if the table is not partitioned.",
+			volatility.Stable,
+		),
+	),
+	"pg_partition_ancestors": makeBuiltin(genProps(),
+		makeGeneratorOverload(
+			tree.ArgTypes{{"table", types.RegClass}},
+			types.RegClass,
+			makePGPartitionAncestorsGenerator,
+			"Produces the ancestors of a table in its partition tree, including the table itself. "+
+				"Since partitions are not relations, this is the table itself if it is "+
+				"partitioned, and no rows otherwise.",
+			volatility.Stable,
+		),
+	),
 	`pg_options_to_table`: makeBuiltin(
 		genProps(),
 		makeGeneratorOverload(
@@ -390,6 +435,7 @@ var generators = map[string]builtinDefinition{
 	"jsonb_to_record":    makeBuiltin(recordGenProps(), jsonToRecordImpl),
 	"json_to_recordset":  makeBuiltin(recordGenProps(), jsonToRecordSetImpl),
 	"jsonb_to_recordset": makeBuiltin(recordGenProps(), jsonToRecordSetImpl),
+	"jsonb_to_rows":      makeBuiltin(recordGenProps(), jsonbToRowsImpl),
 
 	"crdb_internal.check_consistency": makeBuiltin(
 		tree.FunctionProperties{
@@ -462,6 +508,39 @@ var generators = map[string]builtinDefinition{
 			volatility.Volatile,
 		),
 	),
+	"crdb_internal.changefeed_emitted_stats": makeBuiltin(
+		tree.FunctionProperties{
+			Class:    tree.GeneratorClass,
+			Category: builtinconstants.CategorySystemInfo,
+		},
+		makeGeneratorOverload(
+			tree.ArgTypes{
+				{Name: "job_id", Typ: types.Int},
+			},
+			changefeedEmittedStatsGeneratorType,
+			makeChangefeedEmittedStatsGenerator,
+			"Returns the cumulative number of messages, and bytes, emitted by the "+
+				"changefeed job for each of its target tables, as of its last checkpoint. "+
+				"The messages emitted again after the changefeed restarts are counted again.",
+			volatility.Volatile,
+		),
+	),
+	"crdb_internal.changefeed_retry_log": makeBuiltin(
+		tree.FunctionProperties{
+			Class:    tree.GeneratorClass,
+			Category: builtinconstants.CategorySystemInfo,
+		},
+		makeGeneratorOverload(
+			tree.ArgTypes{
+				{Name: "job_id", Typ: types.Int},
+			},
+			changefeedRetryLogGeneratorType,
+			makeChangefeedRetryLogGenerator,
+			"Returns the most recent retryable errors which caused the flow of the "+
+				"changefeed job to restart, oldest first, as of its last checkpoint.",
+			volatility.Volatile,
+		),
+	),
 	"crdb_internal.show_create_all_schemas": makeBuiltin(
 		tree.FunctionProperties{
 			Class: tree.GeneratorClass,
@@ -918,6 +997,40 @@ func makeTSTZSeriesGenerator(_ *eval.Context, args tree.Datums) (eval.ValueGener
 	}, nil
 }
 
+// timeBucketGapfillNext returns the buckets of time_bucket_gapfill, stopping
+// before the finish timestamp.
+func timeBucketGapfillNext(s *seriesValueGenerator) (bool, error) {
+	start := s.start.(time.Time)
+	if !s.nextOK || !start.Before(s.stop.(time.Time)) {
+		return false, nil
+	}
+	s.value = start
+	next, ok := arith.AddWithOverflow(start.UnixMicro(), s.step.(int64))
+	s.start, s.nextOK = time.UnixMicro(next).UTC(), ok
+	return true, nil
+}
+
+func makeTimeBucketGapfillGenerator(
+	_ *eval.Context, args tree.Datums,
+) (eval.ValueGenerator, error) {
+	width, err := timeBucketWidth(tree.MustBeDInterval(args[0]).Duration)
+	if err != nil {
+		return nil, err
+	}
+	g := &seriesValueGenerator{
+		origStart: timeBucket(datumTime(args[1]), timeBucketDefaultOrigin, width),
+		stop:      datumTime(args[2]),
+		step:      width,
+		next:      timeBucketGapfillNext,
+	}
+	if args[1].ResolvedType().Family() == types.TimestampTZFamily {
+		g.genType, g.genValue = seriesTSTZValueGeneratorType, seriesGenTSTZValue
+	} else {
+		g.genType, g.genValue = seriesTSValueGeneratorType, seriesGenTSValue
+	}
+	return g, nil
+}
+
 // ResolvedType implements the tree.ValueGenerator interface.
 func (s *seriesValueGenerator) ResolvedType() *types.T {
 	return s.genType
@@ -1414,6 +1527,23 @@ var jsonToRecordSetImpl = makeGeneratorOverload(
 	volatility.Stable,
 )
 
+var jsonbToRowsImpl = makeGeneratorOverload(
+	tree.ArgTypes{{"input", types.Jsonb}, {"path_and_columns", types.Jsonb}},
+	// NOTE: this type will never actually get used. It is replaced in the
+	// optimizer by looking at the most recent AS alias clause.
+	types.EmptyTuple,
+	makeJSONBToRowsGenerator,
+	"Builds a set of records from the elements of the JSON array at the path of "+
+		"`path_and_columns`. It is an object such as "+
+		"`{\"path\": [\"a\", \"b\"], \"columns\": {\"x\": [\"c\", \"d\"]}}`, "+
+		"where the path is the path of the array, in the format of the #> operator, "+
+		"and each of the optional columns maps a column of the record to the path of "+
+		"its value within the elements. The value of each of the other columns is the "+
+		"value of the key with the name of the column. The values are coerced to the "+
+		"types of the columns, which are given in the column definition list.",
+	volatility.Immutable,
+)
+
 var jsonEachGeneratorLabels = []string{"key", "value"}
 
 var jsonEachGeneratorType = types.MakeLabeledTuple(
@@ -1826,6 +1956,169 @@ func (j *jsonRecordSetGenerator) Next(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// jsonbToRowsGenerator is the generator of jsonb_to_rows, a subset of the
+// JSON_TABLE function of the SQL standard which does not support nested paths.
+type jsonbToRowsGenerator struct {
+	evalCtx *eval.Context
+	input   json.JSON
+	// path is the path of the array whose elements are the rows, and
+	// columnPaths are the paths of the columns within the elements, which are
+	// keyed by the name of the column.
+	path        []string
+	columnPaths map[string][]string
+
+	arr       json.JSON
+	nextIndex int
+	values    tree.Datums
+	types     []*types.T
+	paths     [][]string
+}
+
+var _ eval.AliasAwareValueGenerator = &jsonbToRowsGenerator{}
+
+func makeJSONBToRowsGenerator(
+	evalCtx *eval.Context, args tree.Datums,
+) (eval.ValueGenerator, error) {
+	g := &jsonbToRowsGenerator{
+		evalCtx: evalCtx,
+		input:   tree.MustBeDJSON(args[0]).JSON,
+	}
+	spec := tree.MustBeDJSON(args[1]).JSON
+	if spec.Type() != json.ObjectJSONType {
+		return nil, pgerror.New(pgcode.InvalidParameterValue,
+			"path_and_columns argument to jsonb_to_rows must be an object")
+	}
+	iter, err := spec.ObjectIter()
+	if err != nil {
+		return nil, err
+	}
+	for iter.Next() {
+		switch iter.Key() {
+		case "path":
+			if g.path, err = jsonbToRowsPath(iter.Value()); err != nil {
+				return nil, errors.Wrap(err, "invalid path")
+			}
+		case "columns":
+			if iter.Value().Type() != json.ObjectJSONType {
+				return nil, pgerror.New(pgcode.InvalidParameterValue,
+					"columns of jsonb_to_rows must be an object")
+			}
+			colIter, err := iter.Value().ObjectIter()
+			if err != nil {
+				return nil, err
+			}
+			g.columnPaths = make(map[string][]string)
+			for colIter.Next() {
+				path, err := jsonbToRowsPath(colIter.Value())
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid path of column %q", colIter.Key())
+				}
+				g.columnPaths[colIter.Key()] = path
+			}
+		default:
+			return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+				"unknown key %q in path_and_columns argument to jsonb_to_rows", iter.Key())
+		}
+	}
+	return g, nil
+}
+
+// jsonbToRowsPath returns the path represented by a JSON array of strings.
+func jsonbToRowsPath(j json.JSON) ([]string, error) {
+	if j.Type() != json.ArrayJSONType {
+		return nil, pgerror.New(pgcode.InvalidParameterValue, "expected an array of strings")
+	}
+	path := make([]string, j.Len())
+	for i := range path {
+		elem, err := j.FetchValIdx(i)
+		if err != nil {
+			return nil, err
+		}
+		if elem.Type() != json.StringJSONType {
+			return nil, pgerror.New(pgcode.InvalidParameterValue, "expected an array of strings")
+		}
+		s, err := elem.AsText()
+		if err != nil {
+			return nil, err
+		}
+		path[i] = *s
+	}
+	return path, nil
+}
+
+// SetAlias is part of the eval.AliasAwareValueGenerator interface.
+func (g *jsonbToRowsGenerator) SetAlias(types []*types.T, labels []string) error {
+	if len(types) != len(labels) {
+		return errors.AssertionFailedf("unexpected mismatched types/labels list in jsonb_to_rows generator %v %v", types, labels)
+	}
+	g.types = types
+	g.paths = make([][]string, len(labels))
+	for i, label := range labels {
+		if path, ok := g.columnPaths[label]; ok {
+			g.paths[i] = path
+		} else {
+			g.paths[i] = []string{label}
+		}
+	}
+	return nil
+}
+
+// ResolvedType is part of the eval.ValueGenerator interface.
+func (g *jsonbToRowsGenerator) ResolvedType() *types.T {
+	return types.AnyTuple
+}
+
+// Start is part of the eval.ValueGenerator interface.
+func (g *jsonbToRowsGenerator) Start(_ context.Context, _ *kv.Txn) error {
+	g.values = make(tree.Datums, len(g.types))
+	g.nextIndex = -1
+	arr, err := json.FetchPath(g.input, g.path)
+	if err != nil {
+		return err
+	}
+	// A missing or null array produces no rows.
+	if arr != nil && arr.Type() != json.NullJSONType && arr.Type() != json.ArrayJSONType {
+		return pgerror.New(pgcode.InvalidParameterValue,
+			"path of jsonb_to_rows must refer to an array")
+	}
+	g.arr = arr
+	return nil
+}
+
+// Next is part of the eval.ValueGenerator interface.
+func (g *jsonbToRowsGenerator) Next(_ context.Context) (bool, error) {
+	if g.arr == nil || g.arr.Type() != json.ArrayJSONType {
+		return false, nil
+	}
+	g.nextIndex++
+	elem, err := g.arr.FetchValIdx(g.nextIndex)
+	if err != nil || elem == nil {
+		return false, err
+	}
+	for i, path := range g.paths {
+		v, err := json.FetchPath(elem, path)
+		if err != nil {
+			return false, err
+		}
+		if v == nil {
+			g.values[i] = tree.DNull
+			continue
+		}
+		if g.values[i], err = eval.PopulateDatumWithJSON(g.evalCtx, v, g.types[i]); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Values is part of the eval.ValueGenerator interface.
+func (g *jsonbToRowsGenerator) Values() (tree.Datums, error) {
+	return g.values, nil
+}
+
+// Close is part of the eval.ValueGenerator interface.
+func (g *jsonbToRowsGenerator) Close(_ context.Context) {}
+
 type checkConsistencyGenerator struct {
 	consistencyChecker eval.ConsistencyCheckRunner
 	from, to           roachpb.Key
@@ -2296,6 +2589,149 @@ func (p *payloadsForTraceGenerator) Close(_ context.Context) {
 	}
 }
 
+var changefeedEmittedStatsGeneratorType = types.MakeLabeledTuple(
+	[]*types.T{types.Int, types.Int, types.Int},
+	[]string{"table_id", "emitted_messages", "emitted_bytes"},
+)
+
+func makeChangefeedEmittedStatsGenerator(
+	ctx *eval.Context, args tree.Datums,
+) (eval.ValueGenerator, error) {
+	const query = `
+SELECT (s->>'tableId')::INT8, (s->>'messages')::INT8, (s->>'bytes')::INT8
+  FROM system.jobs,
+       jsonb_array_elements(
+         crdb_internal.pb_to_json('cockroach.sql.jobs.jobspb.Progress', progress, true)
+           ->'changefeed'->'emittedStats'
+       ) AS s
+ WHERE id = $1
+ ORDER BY 1`
+	return makeChangefeedProgressGenerator(
+		ctx, "crdb_internal.changefeed_emitted_stats", changefeedEmittedStatsGeneratorType, query,
+		int64(tree.MustBeDInt(args[0])),
+	)
+}
+
+var changefeedRetryLogGeneratorType = types.MakeLabeledTuple(
+	[]*types.T{types.TimestampTZ, types.String, types.String, types.Int},
+	[]string{"time", "error_class", "error", "instance_id"},
+)
+
+func makeChangefeedRetryLogGenerator(
+	ctx *eval.Context, args tree.Datums,
+) (eval.ValueGenerator, error) {
+	const query = `
+SELECT (e->>'time')::TIMESTAMPTZ, e->>'errorClass', e->>'error', (e->>'instanceId')::INT8
+  FROM system.jobs,
+       jsonb_array_elements(
+         crdb_internal.pb_to_json('cockroach.sql.jobs.jobspb.Progress', progress, true)
+           ->'changefeed'->'retryLog'
+       ) WITH ORDINALITY AS r (e, i)
+ WHERE id = $1
+ ORDER BY i`
+	return makeChangefeedProgressGenerator(
+		ctx, "crdb_internal.changefeed_retry_log", changefeedRetryLogGeneratorType, query,
+		int64(tree.MustBeDInt(args[0])),
+	)
+}
+
+// changefeedProgressGenerator is a value generator that iterates over the
+// rows of a query extracting a part of the progress of a changefeed job.
+type changefeedProgressGenerator struct {
+	typ *types.T
+	it  eval.InternalRows
+}
+
+func makeChangefeedProgressGenerator(
+	ctx *eval.Context, opName string, typ *types.T, query string, jobID int64,
+) (eval.ValueGenerator, error) {
+	if err := checkChangefeedJobAccess(ctx, opName, jobID); err != nil {
+		return nil, err
+	}
+	// The access to the job was checked, so its progress is read as the node
+	// user.
+	it, err := ctx.Planner.QueryIteratorEx(
+		ctx.Ctx(),
+		opName,
+		sessiondata.NodeUserSessionDataOverride,
+		query,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &changefeedProgressGenerator{typ: typ, it: it}, nil
+}
+
+// checkChangefeedJobAccess returns an error unless the current user may
+// access the changefeed job like SHOW CHANGEFEED JOB does: the user must be an
+// admin, own the job, or have the CONTROLJOB or CONTROLCHANGEFEED role option
+// if the job is not owned by an admin.
+func checkChangefeedJobAccess(ctx *eval.Context, opName string, jobID int64) error {
+	isAdmin, err := ctx.SessionAccessor.HasAdminRole(ctx.Ctx())
+	if err != nil || isAdmin {
+		return err
+	}
+	row, err := ctx.Planner.QueryRowEx(
+		ctx.Ctx(),
+		opName,
+		sessiondata.NodeUserSessionDataOverride,
+		`SELECT crdb_internal.pb_to_json('cockroach.sql.jobs.jobspb.Payload', payload, true)->>'usernameProto'
+  FROM system.jobs
+ WHERE id = $1`,
+		jobID,
+	)
+	if err != nil {
+		return err
+	}
+	if row == nil || row[0] == tree.DNull {
+		// The job does not exist, so there is nothing to show.
+		return nil
+	}
+	owner := username.SQLUsernameProto(tree.MustBeDString(row[0])).Decode()
+	if owner == ctx.SessionData().User() {
+		return nil
+	}
+	ownedByAdmin, err := ctx.Planner.UserHasAdminRole(ctx.Ctx(), owner)
+	if err != nil {
+		return err
+	}
+	if !ownedByAdmin {
+		for _, option := range []roleoption.Option{roleoption.CONTROLJOB, roleoption.CONTROLCHANGEFEED} {
+			if ok, err := ctx.SessionAccessor.HasRoleOption(ctx.Ctx(), option); err != nil || ok {
+				return err
+			}
+		}
+	}
+	return pgerror.Newf(pgcode.InsufficientPrivilege,
+		"user %s does not have privileges for job %d", ctx.SessionData().User(), jobID)
+}
+
+// ResolvedType implements the tree.ValueGenerator interface.
+func (g *changefeedProgressGenerator) ResolvedType() *types.T {
+	return g.typ
+}
+
+// Start implements the tree.ValueGenerator interface.
+func (g *changefeedProgressGenerator) Start(_ context.Context, _ *kv.Txn) error {
+	return nil
+}
+
+// Next implements the tree.ValueGenerator interface.
+func (g *changefeedProgressGenerator) Next(ctx context.Context) (bool, error) {
+	return g.it.Next(ctx)
+}
+
+// Values implements the tree.ValueGenerator interface.
+func (g *changefeedProgressGenerator) Values() (tree.Datums, error) {
+	return g.it.Cur(), nil
+}
+
+// Close implements the tree.ValueGenerator interface.
+func (g *changefeedProgressGenerator) Close(_ context.Context) {
+	_ = g.it.Close()
+}
+
 var showCreateAllSchemasGeneratorType = types.String
 var showCreateAllTypesGeneratorType = types.String
 var showCreateAllTablesGeneratorType = types.String
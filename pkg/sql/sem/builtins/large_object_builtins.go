@@ -0,0 +1,236 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package builtins
+
+import (
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinconstants"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+)
+
+func initLargeObjectBuiltins() {
+	for k, v := range largeObjectBuiltins {
+		v.props.Category = builtinconstants.CategoryCompatibility
+		// The large objects are manipulated through the planner.
+		v.props.DistsqlBlocklist = true
+		registerBuiltin(k, v)
+	}
+}
+
+// largeObjectsInfo is appended to the descriptions of the large object
+// functions.
+const largeObjectsInfo = " Large objects are emulated for compatibility, and stored in the " +
+	"system.large_objects table, in the transaction of the statement. They can only be " +
+	"accessed by their owner and by admins, and are limited to 16 MiB. The large object " +
+	"functions require the sql.large_objects.compatibility.enabled cluster setting."
+
+// largeObjectBuiltins are the functions manipulating large objects, which are
+// emulated for compatibility with Postgres (see eval.LargeObjectOperator).
+var largeObjectBuiltins = map[string]builtinDefinition{
+	"lo_create": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"loid", types.Oid}},
+			ReturnType: tree.FixedReturnType(types.Oid),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				loid, err := evalCtx.LargeObjects.LargeObjectCreate(evalCtx.Context, tree.MustBeDOid(args[0]).Oid)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDOid(loid), nil
+			},
+			Info: "Creates an empty large object with the given OID, or with an unused OID if it is " +
+				"zero, and returns its OID." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"lo_put": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"loid", types.Oid}, {"offset", types.Int}, {"data", types.Bytes}},
+			ReturnType: tree.FixedReturnType(types.Void),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				err := evalCtx.LargeObjects.LargeObjectPut(
+					evalCtx.Context,
+					tree.MustBeDOid(args[0]).Oid,
+					int64(tree.MustBeDInt(args[1])),
+					[]byte(tree.MustBeDBytes(args[2])),
+				)
+				if err != nil {
+					return nil, err
+				}
+				return tree.DVoidDatum, nil
+			},
+			Info: "Writes the data to the large object at the given offset, extending the large " +
+				"object as needed." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"lo_get": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"loid", types.Oid}},
+			ReturnType: tree.FixedReturnType(types.Bytes),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				data, err := evalCtx.LargeObjects.LargeObjectGet(
+					evalCtx.Context, tree.MustBeDOid(args[0]).Oid, 0 /* offset */, -1, /* length */
+				)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDBytes(tree.DBytes(data)), nil
+			},
+			Info:       "Returns the contents of the large object." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"loid", types.Oid}, {"offset", types.Int}, {"length", types.Int}},
+			ReturnType: tree.FixedReturnType(types.Bytes),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				length, err := largeObjectInt32Arg(args[2], "length")
+				if err != nil {
+					return nil, err
+				}
+				if length < 0 {
+					return nil, pgerror.Newf(pgcode.InvalidParameterValue, "requested length cannot be negative")
+				}
+				data, err := evalCtx.LargeObjects.LargeObjectGet(
+					evalCtx.Context, tree.MustBeDOid(args[0]).Oid, int64(tree.MustBeDInt(args[1])), int64(length),
+				)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDBytes(tree.DBytes(data)), nil
+			},
+			Info: "Returns at most `length` bytes of the large object, starting at the given offset." +
+				largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"lo_unlink": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"loid", types.Oid}},
+			ReturnType: tree.FixedReturnType(types.Int),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				if err := evalCtx.LargeObjects.LargeObjectUnlink(evalCtx.Context, tree.MustBeDOid(args[0]).Oid); err != nil {
+					return nil, err
+				}
+				return tree.NewDInt(1), nil
+			},
+			Info:       "Deletes the large object, and returns 1." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"lo_open": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"loid", types.Oid}, {"mode", types.Int}},
+			ReturnType: tree.FixedReturnType(types.Int),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				mode, err := largeObjectInt32Arg(args[1], "mode")
+				if err != nil {
+					return nil, err
+				}
+				fd, err := evalCtx.LargeObjects.LargeObjectOpen(evalCtx.Context, tree.MustBeDOid(args[0]).Oid, mode)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDInt(tree.DInt(fd)), nil
+			},
+			Info: "Opens the large object for reading (mode 262144, i.e. INV_READ), or for reading and " +
+				"writing (mode 131072, i.e. INV_WRITE), and returns a descriptor which is valid until " +
+				"the end of the transaction." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"lo_close": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"fd", types.Int}},
+			ReturnType: tree.FixedReturnType(types.Int),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				fd, err := largeObjectInt32Arg(args[0], "fd")
+				if err != nil {
+					return nil, err
+				}
+				if err := evalCtx.LargeObjects.LargeObjectClose(evalCtx.Context, fd); err != nil {
+					return nil, err
+				}
+				return tree.NewDInt(0), nil
+			},
+			Info:       "Closes the large object descriptor, and returns 0." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"lowrite": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"fd", types.Int}, {"data", types.Bytes}},
+			ReturnType: tree.FixedReturnType(types.Int),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				fd, err := largeObjectInt32Arg(args[0], "fd")
+				if err != nil {
+					return nil, err
+				}
+				n, err := evalCtx.LargeObjects.LargeObjectWrite(evalCtx.Context, fd, []byte(tree.MustBeDBytes(args[1])))
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDInt(tree.DInt(n)), nil
+			},
+			Info: "Writes the data to the large object descriptor at its current position, and " +
+				"returns the number of bytes written." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+	"loread": makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"fd", types.Int}, {"length", types.Int}},
+			ReturnType: tree.FixedReturnType(types.Bytes),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				fd, err := largeObjectInt32Arg(args[0], "fd")
+				if err != nil {
+					return nil, err
+				}
+				length, err := largeObjectInt32Arg(args[1], "length")
+				if err != nil {
+					return nil, err
+				}
+				data, err := evalCtx.LargeObjects.LargeObjectRead(evalCtx.Context, fd, length)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDBytes(tree.DBytes(data)), nil
+			},
+			Info: "Reads at most `length` bytes from the large object descriptor at its current " +
+				"position." + largeObjectsInfo,
+			Volatility: volatility.Volatile,
+		},
+	),
+}
+
+// largeObjectInt32Arg returns the value of an INT4 argument of the large object
+// functions.
+func largeObjectInt32Arg(d tree.Datum, name string) (int32, error) {
+	i := tree.MustBeDInt(d)
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		return 0, pgerror.Newf(pgcode.NumericValueOutOfRange, "%s out of range: %d", name, i)
+	}
+	return int32(i), nil
+}
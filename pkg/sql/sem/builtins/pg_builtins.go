@@ -12,6 +12,7 @@ package builtins
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -58,6 +59,94 @@ func makeNotUsableFalseBuiltin() builtinDefinition {
 	)
 }
 
+// advisoryLocksInfo is appended to the descriptions of the advisory lock
+// functions.
+const advisoryLocksInfo = " Like in PostgreSQL, the advisory locks are specific to the " +
+	"current database, and the locks identified by a single key are distinct from the locks " +
+	"identified by two keys. The advisory locks are stored in the system.advisory_locks table. " +
+	"Unless the sql.advisory_locks.enabled cluster setting is set, the advisory locks cannot " +
+	"be acquired."
+
+// makeAdvisoryLockBuiltin creates a builtin for the advisory locks, which
+// takes either a single INT8 key or a pair of INT4 keys.
+func makeAdvisoryLockBuiltin(
+	returnType *types.T,
+	fn func(evalCtx *eval.Context, id eval.AdvisoryLockID) (tree.Datum, error),
+	info string,
+) builtinDefinition {
+	return makeBuiltin(
+		// The advisory locks are held by the session.
+		tree.FunctionProperties{DistsqlBlocklist: true},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"key", types.Int}},
+			ReturnType: tree.FixedReturnType(returnType),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				return fn(evalCtx, eval.AdvisoryLockID{Key: int64(tree.MustBeDInt(args[0]))})
+			},
+			Info:       info + advisoryLocksInfo,
+			Volatility: volatility.Volatile,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"key1", types.Int4}, {"key2", types.Int4}},
+			ReturnType: tree.FixedReturnType(returnType),
+			Fn: func(evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				key1, key2 := int64(tree.MustBeDInt(args[0])), int64(tree.MustBeDInt(args[1]))
+				for _, key := range []int64{key1, key2} {
+					if key < math.MinInt32 || key > math.MaxInt32 {
+						return nil, pgerror.Newf(pgcode.NumericValueOutOfRange,
+							"advisory lock key out of range: %d", key)
+					}
+				}
+				return fn(evalCtx, eval.AdvisoryLockID{Key: key1<<32 | int64(uint32(key2)), TwoKeys: true})
+			},
+			Info:       info + advisoryLocksInfo,
+			Volatility: volatility.Volatile,
+		},
+	)
+}
+
+// makeAcquireAdvisoryLockBuiltin creates a builtin acquiring an advisory lock
+// in exclusive or shared mode. If wait is set, the builtin waits until the lock
+// is available and returns void, otherwise it returns whether the lock was
+// acquired.
+func makeAcquireAdvisoryLockBuiltin(shared, wait bool, info string) builtinDefinition {
+	returnType := types.Bool
+	if wait {
+		returnType = types.Void
+	}
+	return makeAdvisoryLockBuiltin(
+		returnType,
+		func(evalCtx *eval.Context, id eval.AdvisoryLockID) (tree.Datum, error) {
+			acquired, err := evalCtx.AdvisoryLocks.AcquireAdvisoryLock(evalCtx.Context, id, shared, wait)
+			if err != nil {
+				return nil, err
+			}
+			if wait {
+				return tree.DVoidDatum, nil
+			}
+			return tree.MakeDBool(tree.DBool(acquired)), nil
+		},
+		info,
+	)
+}
+
+// makeReleaseAdvisoryLockBuiltin creates a builtin releasing an advisory lock
+// held in exclusive or shared mode, which returns whether the lock was held by
+// the session.
+func makeReleaseAdvisoryLockBuiltin(shared bool, info string) builtinDefinition {
+	return makeAdvisoryLockBuiltin(
+		types.Bool,
+		func(evalCtx *eval.Context, id eval.AdvisoryLockID) (tree.Datum, error) {
+			released, err := evalCtx.AdvisoryLocks.ReleaseAdvisoryLock(evalCtx.Context, id, shared)
+			if err != nil {
+				return nil, err
+			}
+			return tree.MakeDBool(tree.DBool(released)), nil
+		},
+		info,
+	)
+}
+
 // typeBuiltinsHaveUnderscore is a map to keep track of which types have i/o
 // builtins with underscores in between their type name and the i/o builtin
 // name, like date_in vs int8in. There seems to be no other way to
@@ -639,6 +728,29 @@ var pgBuiltins = map[string]builtinDefinition{
 		},
 	),
 
+	"pg_partition_root": makeBuiltin(defProps(),
+		tree.Overload{
+			Types:      tree.ArgTypes{{"table", types.RegClass}},
+			ReturnType: tree.FixedReturnType(types.RegClass),
+			Fn: func(ctx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				nodes, err := getPGPartitionTree(
+					ctx.Ctx(), ctx.Planner, "pg_partition_root", tree.MustBeDOid(args[0]),
+				)
+				if err != nil {
+					return nil, err
+				}
+				// A partitioned table is the root of its partition tree.
+				if len(nodes) == 0 {
+					return tree.DNull, nil
+				}
+				return args[0], nil
+			},
+			Info: "Returns the root of the partition tree of a table, which is the table itself if " +
+				"it is partitioned, and NULL otherwise.",
+			Volatility: volatility.Stable,
+		},
+	),
+
 	// pg_get_function_result returns the types of the result of an builtin
 	// function. Multi-return builtins currently are returned as anyelement, which
 	// is a known incompatibility with Postgres.
@@ -1079,30 +1191,61 @@ SELECT description
 		},
 	),
 
-	"pg_try_advisory_lock": makeBuiltin(defProps(),
-		tree.Overload{
-			Types:      tree.ArgTypes{{"int", types.Int}},
-			ReturnType: tree.FixedReturnType(types.Bool),
-			Fn: func(_ *eval.Context, _ tree.Datums) (tree.Datum, error) {
-				return tree.DBoolTrue, nil
-			},
-			Info:       notUsableInfo,
-			Volatility: volatility.Volatile,
-		},
+	// https://www.postgresql.org/docs/current/functions-admin.html#FUNCTIONS-ADVISORY-LOCKS
+	"pg_advisory_lock": makeAcquireAdvisoryLockBuiltin(
+		false /* shared */, true, /* wait */
+		"Acquires the session-level advisory lock in exclusive mode, waiting until it is "+
+			"available.",
+	),
+
+	"pg_try_advisory_lock": makeAcquireAdvisoryLockBuiltin(
+		false /* shared */, false, /* wait */
+		"Acquires the session-level advisory lock in exclusive mode if it is available, and "+
+			"returns whether it was acquired.",
+	),
+
+	"pg_advisory_unlock": makeReleaseAdvisoryLockBuiltin(
+		false, /* shared */
+		"Releases the session-level advisory lock held in exclusive mode, and returns whether "+
+			"it was held by the session. A lock acquired several times must be released as many "+
+			"times.",
 	),
 
-	"pg_advisory_unlock": makeBuiltin(defProps(),
+	"pg_advisory_unlock_all": makeBuiltin(
+		tree.FunctionProperties{DistsqlBlocklist: true},
 		tree.Overload{
-			Types:      tree.ArgTypes{{"int", types.Int}},
-			ReturnType: tree.FixedReturnType(types.Bool),
-			Fn: func(_ *eval.Context, _ tree.Datums) (tree.Datum, error) {
-				return tree.DBoolTrue, nil
+			Types:      tree.ArgTypes{},
+			ReturnType: tree.FixedReturnType(types.Void),
+			Fn: func(evalCtx *eval.Context, _ tree.Datums) (tree.Datum, error) {
+				if err := evalCtx.AdvisoryLocks.ReleaseAllAdvisoryLocks(evalCtx.Context); err != nil {
+					return nil, err
+				}
+				return tree.DVoidDatum, nil
 			},
-			Info:       notUsableInfo,
+			Info:       "Releases all the session-level advisory locks held by the session." + advisoryLocksInfo,
 			Volatility: volatility.Volatile,
 		},
 	),
 
+	"pg_advisory_lock_shared": makeAcquireAdvisoryLockBuiltin(
+		true /* shared */, true, /* wait */
+		"Acquires the session-level advisory lock in shared mode, waiting until it is not held "+
+			"in exclusive mode by another session.",
+	),
+
+	"pg_try_advisory_lock_shared": makeAcquireAdvisoryLockBuiltin(
+		true /* shared */, false, /* wait */
+		"Acquires the session-level advisory lock in shared mode if it is not held in exclusive "+
+			"mode by another session, and returns whether it was acquired.",
+	),
+
+	"pg_advisory_unlock_shared": makeReleaseAdvisoryLockBuiltin(
+		true, /* shared */
+		"Releases the session-level advisory lock held in shared mode, and returns whether it "+
+			"was held by the session. A lock acquired several times must be released as many "+
+			"times.",
+	),
+
 	// https://www.postgresql.org/docs/10/static/functions-string.html
 	// CockroachDB supports just UTF8 for now.
 	"pg_client_encoding": makeBuiltin(defProps(),
@@ -0,0 +1,201 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// pgPartitionNode is a node of the partition tree of a table. The partitions
+// of a table in CockroachDB are not relations, so they are identified by their
+// names.
+type pgPartitionNode struct {
+	name string
+	// parent is the name of the parent of the node, and is empty for the table
+	// itself.
+	parent string
+	level  int
+	isLeaf bool
+}
+
+// getPGPartitionTree returns the partition tree of a table, as defined by the
+// partitioning of its primary index: the table itself comes first, followed by
+// its partitions in depth-first order. No nodes are returned if the table does
+// not exist or is not partitioned.
+func getPGPartitionTree(
+	ctx context.Context, evalPlanner eval.Planner, opName string, relID *tree.DOid,
+) (nodes []pgPartitionNode, retErr error) {
+	row, err := evalPlanner.QueryRowEx(
+		ctx,
+		opName,
+		sessiondata.NoSessionDataOverride,
+		`SELECT name, database_name FROM "".crdb_internal.tables WHERE table_id = $1`,
+		int64(relID.Oid),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Virtual tables have no database, and are never partitioned.
+	if row == nil || row[1] == tree.DNull {
+		return nil, nil
+	}
+	tableName := string(tree.MustBeDString(row[0]))
+	dbName := string(tree.MustBeDString(row[1]))
+
+	query := fmt.Sprintf(`
+		SELECT p.name, p.parent_name
+		FROM %[1]s.crdb_internal.partitions AS p
+		JOIN %[1]s.crdb_internal.table_indexes AS i
+		ON p.table_id = i.descriptor_id AND p.index_id = i.index_id
+		WHERE p.table_id = $1 AND i.index_type = 'primary'
+		`, tree.NameString(dbName))
+	it, err := evalPlanner.QueryIteratorEx(
+		ctx,
+		opName,
+		sessiondata.NoSessionDataOverride,
+		query,
+		int64(relID.Oid),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		retErr = errors.CombineErrors(retErr, it.Close())
+	}()
+
+	// children maps the name of each partition to the names of its
+	// subpartitions. The partitions of the table itself are keyed by the empty
+	// name.
+	children := make(map[string][]string)
+	var ok bool
+	for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
+		var parent string
+		if p := it.Cur()[1]; p != tree.DNull {
+			parent = string(tree.MustBeDString(p))
+		}
+		children[parent] = append(children[parent], string(tree.MustBeDString(it.Cur()[0])))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, nil
+	}
+
+	nodes = append(nodes, pgPartitionNode{name: tableName})
+	var addChildren func(parent, key string, level int)
+	addChildren = func(parent, key string, level int) {
+		names := children[key]
+		sort.Strings(names)
+		for _, name := range names {
+			nodes = append(nodes, pgPartitionNode{
+				name:   name,
+				parent: parent,
+				level:  level,
+				isLeaf: len(children[name]) == 0,
+			})
+			addChildren(name, name, level+1)
+		}
+	}
+	addChildren(tableName, "" /* key */, 1 /* level */)
+	return nodes, nil
+}
+
+// pgPartitionTreeGeneratorType is the type of the rows returned by
+// pg_partition_tree. Unlike in Postgres, the relid and parentrelid columns
+// contain the names of the table and its partitions, since the partitions are
+// not relations.
+var pgPartitionTreeGeneratorType = types.MakeLabeledTuple(
+	[]*types.T{types.String, types.String, types.Bool, types.Int},
+	[]string{"relid", "parentrelid", "isleaf", "level"},
+)
+
+func makePGPartitionTreeGenerator(
+	ctx *eval.Context, args tree.Datums,
+) (eval.ValueGenerator, error) {
+	nodes, err := getPGPartitionTree(
+		ctx.Ctx(), ctx.Planner, "pg_partition_tree", tree.MustBeDOid(args[0]),
+	)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]tree.Datums, len(nodes))
+	for i, n := range nodes {
+		parent := tree.DNull
+		if n.parent != "" {
+			parent = tree.NewDString(n.parent)
+		}
+		rows[i] = tree.Datums{
+			tree.NewDString(n.name),
+			parent,
+			tree.MakeDBool(tree.DBool(n.isLeaf)),
+			tree.NewDInt(tree.DInt(n.level)),
+		}
+	}
+	return &pgPartitionGenerator{typ: pgPartitionTreeGeneratorType, rows: rows}, nil
+}
+
+func makePGPartitionAncestorsGenerator(
+	ctx *eval.Context, args tree.Datums,
+) (eval.ValueGenerator, error) {
+	relID := tree.MustBeDOid(args[0])
+	nodes, err := getPGPartitionTree(ctx.Ctx(), ctx.Planner, "pg_partition_ancestors", relID)
+	if err != nil {
+		return nil, err
+	}
+	// A partitioned table is the root of its partition tree, so it is its only
+	// ancestor.
+	var rows []tree.Datums
+	if len(nodes) > 0 {
+		rows = append(rows, tree.Datums{relID})
+	}
+	return &pgPartitionGenerator{typ: types.RegClass, rows: rows}, nil
+}
+
+// pgPartitionGenerator is a value generator that returns the precomputed rows
+// of pg_partition_tree or pg_partition_ancestors.
+type pgPartitionGenerator struct {
+	typ  *types.T
+	rows []tree.Datums
+	idx  int
+}
+
+// ResolvedType implements the tree.ValueGenerator interface.
+func (g *pgPartitionGenerator) ResolvedType() *types.T { return g.typ }
+
+// Start implements the tree.ValueGenerator interface.
+func (g *pgPartitionGenerator) Start(_ context.Context, _ *kv.Txn) error {
+	g.idx = -1
+	return nil
+}
+
+// Next implements the tree.ValueGenerator interface.
+func (g *pgPartitionGenerator) Next(_ context.Context) (bool, error) {
+	g.idx++
+	return g.idx < len(g.rows), nil
+}
+
+// Values implements the tree.ValueGenerator interface.
+func (g *pgPartitionGenerator) Values() (tree.Datums, error) {
+	return g.rows[g.idx], nil
+}
+
+// Close implements the tree.ValueGenerator interface.
+func (g *pgPartitionGenerator) Close(_ context.Context) {}
@@ -0,0 +1,169 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package builtins
+
+import (
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinconstants"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+)
+
+func initVectorBuiltins() {
+	for k, v := range vectorBuiltins {
+		v.props.Category = builtinconstants.CategoryArray
+		registerBuiltin(k, v)
+	}
+}
+
+// vectorBuiltins are the functions computing the distances between vectors,
+// e.g. embeddings, which are stored as FLOAT8[] arrays. They are CockroachDB
+// extensions, similar to the functions of the pgvector extension of Postgres.
+var vectorBuiltins = map[string]builtinDefinition{
+	"cosine_distance": makeVectorBuiltin(cosineDistance,
+		"Returns the cosine distance between the two vectors, i.e. 1 minus the cosine of the "+
+			"angle between them, which ranges from 0 (same direction) to 2 (opposite directions). "+
+			"The distance is NaN if either of the vectors is zero."),
+	"inner_product": makeVectorBuiltin(innerProduct,
+		"Returns the inner product (dot product) of the two vectors."),
+	"l2_distance": makeVectorBuiltin(l2Distance,
+		"Returns the Euclidean (L2) distance between the two vectors."),
+}
+
+// makeVectorBuiltin returns the definition of a function computing a distance
+// between two FLOAT8[] vectors with the same number of elements, none of which
+// is NULL.
+func makeVectorBuiltin(fn func(a, b []float64) float64, info string) builtinDefinition {
+	return makeBuiltin(
+		tree.FunctionProperties{},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"a", types.FloatArray}, {"b", types.FloatArray}},
+			ReturnType: tree.FixedReturnType(types.Float),
+			Fn: func(_ *eval.Context, args tree.Datums) (tree.Datum, error) {
+				a, err := darrayToVector(tree.MustBeDArray(args[0]))
+				if err != nil {
+					return nil, err
+				}
+				b, err := darrayToVector(tree.MustBeDArray(args[1]))
+				if err != nil {
+					return nil, err
+				}
+				if len(a) != len(b) {
+					return nil, pgerror.Newf(pgcode.DataException,
+						"different vector dimensions %d and %d", len(a), len(b))
+				}
+				return tree.NewDFloat(tree.DFloat(fn(a, b))), nil
+			},
+			Info: info + " The vectors must have the same number of elements, and must not " +
+				"contain NULLs.",
+			Volatility: volatility.Immutable,
+		},
+	)
+}
+
+// darrayToVector returns the elements of a FLOAT8[] vector.
+func darrayToVector(arr *tree.DArray) ([]float64, error) {
+	if arr.HasNulls {
+		return nil, pgerror.New(pgcode.NullValueNotAllowed, "vector must not contain nulls")
+	}
+	v := make([]float64, len(arr.Array))
+	for i, d := range arr.Array {
+		v[i] = float64(tree.MustBeDFloat(d))
+	}
+	return v, nil
+}
+
+// The vector functions below are unrolled, with independent accumulators, so
+// that consecutive iterations don't wait for each other's additions and can
+// be pipelined by the CPU (the Go compiler does not vectorize loops). The
+// fixed-size subslices let the compiler eliminate the bounds checks within
+// each iteration. The results may differ from a naive loop in the last bits,
+// since the additions are performed in a different order.
+
+// innerProduct returns the inner product of two vectors of the same length.
+func innerProduct(a, b []float64) float64 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		x, y := a[i:i+4:i+4], b[i:i+4:i+4]
+		s0 += x[0] * y[0]
+		s1 += x[1] * y[1]
+		s2 += x[2] * y[2]
+		s3 += x[3] * y[3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// l2Distance returns the Euclidean distance between two vectors of the same
+// length.
+func l2Distance(a, b []float64) float64 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		x, y := a[i:i+4:i+4], b[i:i+4:i+4]
+		d0, d1, d2, d3 := x[0]-y[0], x[1]-y[1], x[2]-y[2], x[3]-y[3]
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+	}
+	for ; i < len(a); i++ {
+		d := a[i] - b[i]
+		s0 += d * d
+	}
+	return math.Sqrt((s0 + s1) + (s2 + s3))
+}
+
+// cosineDistance returns the cosine distance between two vectors of the same
+// length, which is NaN if either of them is zero.
+func cosineDistance(a, b []float64) float64 {
+	b = b[:len(a)]
+	// Unlike the other functions, cosineDistance accumulates three sums, so it
+	// is only unrolled twice to keep all the accumulators in registers.
+	var d0, d1, a0, a1, b0, b1 float64
+	i := 0
+	for ; i+2 <= len(a); i += 2 {
+		x, y := a[i:i+2:i+2], b[i:i+2:i+2]
+		d0 += x[0] * y[0]
+		d1 += x[1] * y[1]
+		a0 += x[0] * x[0]
+		a1 += x[1] * x[1]
+		b0 += y[0] * y[0]
+		b1 += y[1] * y[1]
+	}
+	if i < len(a) {
+		d0 += a[i] * b[i]
+		a0 += a[i] * a[i]
+		b0 += b[i] * b[i]
+	}
+	similarity := (d0 + d1) / math.Sqrt((a0+a1)*(b0+b1))
+	if math.IsNaN(similarity) {
+		return similarity
+	}
+	// The similarity may fall slightly outside of [-1, 1] because of rounding
+	// errors.
+	if similarity > 1 {
+		similarity = 1
+	} else if similarity < -1 {
+		similarity = -1
+	}
+	return 1 - similarity
+}
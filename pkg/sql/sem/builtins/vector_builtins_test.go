@@ -0,0 +1,169 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/stretchr/testify/require"
+)
+
+// The naive implementations of the vector functions, which the unrolled ones
+// are checked and benchmarked against.
+
+func naiveInnerProduct(a, b []float64) float64 {
+	var s float64
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+func naiveL2Distance(a, b []float64) float64 {
+	var s float64
+	for i := range a {
+		d := a[i] - b[i]
+		s += d * d
+	}
+	return math.Sqrt(s)
+}
+
+func naiveCosineDistance(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	return 1 - dot/math.Sqrt(normA*normB)
+}
+
+var vectorFunctions = []struct {
+	name  string
+	fn    func(a, b []float64) float64
+	naive func(a, b []float64) float64
+}{
+	{name: "inner_product", fn: innerProduct, naive: naiveInnerProduct},
+	{name: "l2_distance", fn: l2Distance, naive: naiveL2Distance},
+	{name: "cosine_distance", fn: cosineDistance, naive: naiveCosineDistance},
+}
+
+func TestVectorFunctions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		a, b           []float64
+		innerProduct   float64
+		l2Distance     float64
+		cosineDistance float64
+	}{
+		{
+			a:              []float64{1, 2, 3},
+			b:              []float64{4, 5, 6},
+			innerProduct:   32,
+			l2Distance:     5.196152422706632,
+			cosineDistance: 0.025368153802923787,
+		},
+		{
+			a:              []float64{1, 0, -1, 2.5, 3},
+			b:              []float64{-2, 1, 0.5, 4, 1},
+			innerProduct:   10.5,
+			l2Distance:     4.301162633521313,
+			cosineDistance: 0.4640432539745757,
+		},
+		{
+			a:              []float64{1, 2},
+			b:              []float64{1, 2},
+			innerProduct:   5,
+			l2Distance:     0,
+			cosineDistance: 0,
+		},
+		{
+			a:              []float64{1, 1},
+			b:              []float64{-1, -1},
+			innerProduct:   -2,
+			l2Distance:     2 * math.Sqrt2,
+			cosineDistance: 2,
+		},
+		{
+			a:              []float64{0, 0, 0},
+			b:              []float64{1, 2, 3},
+			innerProduct:   0,
+			l2Distance:     math.Sqrt(14),
+			cosineDistance: math.NaN(),
+		},
+		{
+			a:              []float64{},
+			b:              []float64{},
+			innerProduct:   0,
+			l2Distance:     0,
+			cosineDistance: math.NaN(),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v,%v", tc.a, tc.b), func(t *testing.T) {
+			require.InDelta(t, tc.innerProduct, innerProduct(tc.a, tc.b), 1e-12)
+			require.InDelta(t, tc.l2Distance, l2Distance(tc.a, tc.b), 1e-12)
+			if math.IsNaN(tc.cosineDistance) {
+				require.True(t, math.IsNaN(cosineDistance(tc.a, tc.b)))
+			} else {
+				require.InDelta(t, tc.cosineDistance, cosineDistance(tc.a, tc.b), 1e-12)
+			}
+		})
+	}
+
+	// The unrolled implementations agree with the naive ones, for all the
+	// lengths of the remainders of the unrolled loops.
+	rng, _ := randutil.NewTestRand()
+	for n := 1; n <= 20; n++ {
+		a, b := randomVector(rng.Float64, n), randomVector(rng.Float64, n)
+		for _, f := range vectorFunctions {
+			require.InDelta(t, f.naive(a, b), f.fn(a, b), 1e-9, "%s of %v and %v", f.name, a, b)
+		}
+	}
+}
+
+func randomVector(rnd func() float64, n int) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = rnd()*2 - 1
+	}
+	return v
+}
+
+func BenchmarkVectorFunctions(b *testing.B) {
+	rng, _ := randutil.NewTestRand()
+	const dims = 768
+	x, y := randomVector(rng.Float64, dims), randomVector(rng.Float64, dims)
+	for _, f := range vectorFunctions {
+		for _, impl := range []struct {
+			name string
+			fn   func(a, b []float64) float64
+		}{
+			{name: "unrolled", fn: f.fn},
+			{name: "naive", fn: f.naive},
+		} {
+			b.Run(fmt.Sprintf("%s/%s/dims=%d", f.name, impl.name, dims), func(b *testing.B) {
+				var res float64
+				for i := 0; i < b.N; i++ {
+					res += impl.fn(x, y)
+				}
+				if math.IsNaN(res) {
+					b.Fatal("unexpected NaN")
+				}
+			})
+		}
+	}
+}
@@ -84,6 +84,9 @@ const (
 	SystemPrivilegeTableName               SystemTableName = "privileges"
 	SystemExternalConnectionsTableName     SystemTableName = "external_connections"
 	RoleIDSequenceName                     SystemTableName = "role_id_seq"
+	LargeObjectsTableName                  SystemTableName = "large_objects"
+	LargeObjectOIDSequenceName             SystemTableName = "large_object_oid_seq"
+	AdvisoryLocksTableName                 SystemTableName = "advisory_locks"
 )
 
 // Oid for virtual database and table.
@@ -153,6 +153,12 @@ type Context struct {
 
 	JoinTokenCreator JoinTokenCreator
 
+	// LargeObjects provides access to the emulated large objects.
+	LargeObjects LargeObjectOperator
+
+	// AdvisoryLocks manages the advisory locks held by the session.
+	AdvisoryLocks AdvisoryLockOperator
+
 	Gossip GossipOperator
 
 	PreparedStatementState PreparedStatementState
@@ -285,6 +285,11 @@ type Planner interface {
 	// session revival token.
 	ValidateSessionRevivalToken(token *tree.DBytes) (*tree.DBool, error)
 
+	// DecodeSessionRevivalToken decodes the claims of the given session
+	// revival token as JSON, along with whether it is valid for the user it
+	// was issued to.
+	DecodeSessionRevivalToken(ctx context.Context, token *tree.DBytes) (*tree.DJSON, error)
+
 	// RevalidateUniqueConstraintsInCurrentDB verifies that all unique constraints
 	// defined on tables in the current database are valid. In other words, it
 	// verifies that for every table in the database with one or more unique
@@ -563,6 +568,61 @@ type JoinTokenCreator interface {
 	CreateJoinToken(ctx context.Context) (string, error)
 }
 
+// LargeObjectOperator is capable of manipulating the large objects emulated
+// for compatibility with the lo_* functions of Postgres. The methods return
+// errors when the large object compatibility functions are not enabled.
+type LargeObjectOperator interface {
+	// LargeObjectCreate creates an empty large object with the specified OID,
+	// or with an unused OID if it is zero, and returns its OID.
+	LargeObjectCreate(ctx context.Context, loid oid.Oid) (oid.Oid, error)
+	// LargeObjectPut writes the data to the large object at the offset,
+	// extending the large object as needed.
+	LargeObjectPut(ctx context.Context, loid oid.Oid, offset int64, data []byte) error
+	// LargeObjectGet reads at most length bytes of the large object from the
+	// offset, or all of the bytes from the offset if the length is negative.
+	LargeObjectGet(ctx context.Context, loid oid.Oid, offset int64, length int64) ([]byte, error)
+	// LargeObjectUnlink deletes the large object.
+	LargeObjectUnlink(ctx context.Context, loid oid.Oid) error
+	// LargeObjectOpen opens the large object with the specified mode, and
+	// returns a descriptor which is valid until the end of the transaction.
+	LargeObjectOpen(ctx context.Context, loid oid.Oid, mode int32) (int32, error)
+	// LargeObjectClose closes the large object descriptor.
+	LargeObjectClose(ctx context.Context, fd int32) error
+	// LargeObjectWrite writes the data to the large object descriptor at its
+	// current position, and returns the number of bytes written.
+	LargeObjectWrite(ctx context.Context, fd int32, data []byte) (int32, error)
+	// LargeObjectRead reads at most length bytes from the large object
+	// descriptor at its current position.
+	LargeObjectRead(ctx context.Context, fd int32, length int32) ([]byte, error)
+}
+
+// AdvisoryLockID identifies an advisory lock. Like in Postgres, the locks
+// identified by a single INT8 key are distinct from the locks identified by a
+// pair of INT4 keys.
+type AdvisoryLockID struct {
+	// Key is the INT8 key of the lock, or the pair of INT4 keys of the lock in
+	// its high and low 32 bits.
+	Key int64
+	// TwoKeys is set if the lock is identified by a pair of INT4 keys.
+	TwoKeys bool
+}
+
+// AdvisoryLockOperator is capable of acquiring and releasing the session-level
+// advisory locks of the pg_advisory_lock family of functions.
+type AdvisoryLockOperator interface {
+	// AcquireAdvisoryLock acquires the advisory lock for the session, in shared
+	// mode if shared is set and in exclusive mode otherwise. If the lock is held
+	// by another session in a conflicting mode, it waits until the lock is
+	// released if wait is set, and returns false otherwise.
+	AcquireAdvisoryLock(ctx context.Context, id AdvisoryLockID, shared bool, wait bool) (bool, error)
+	// ReleaseAdvisoryLock releases the advisory lock held in the given mode
+	// once, and returns false if the session does not hold it in that mode.
+	ReleaseAdvisoryLock(ctx context.Context, id AdvisoryLockID, shared bool) (bool, error)
+	// ReleaseAllAdvisoryLocks releases all the advisory locks held by the
+	// session.
+	ReleaseAllAdvisoryLocks(ctx context.Context) error
+}
+
 // GossipOperator is capable of manipulating the cluster's gossip network. The
 // methods will return errors when run by any tenant other than the system
 // tenant.
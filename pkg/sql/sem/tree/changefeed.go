@@ -98,7 +98,8 @@ func (cts *ChangefeedTargets) Format(ctx *FmtCtx) {
 }
 
 // ChangefeedTargetFromTableExpr returns ChangefeedTarget for the
-// specified table expression.
+// specified table expression. For joins, the target is the left-most
+// table; the joined tables are reference tables, and are not targets.
 func ChangefeedTargetFromTableExpr(e TableExpr) (ChangefeedTarget, error) {
 	switch t := e.(type) {
 	case TablePattern:
@@ -107,6 +108,8 @@ func ChangefeedTargetFromTableExpr(e TableExpr) (ChangefeedTarget, error) {
 		if tn, ok := t.Expr.(*TableName); ok {
 			return ChangefeedTarget{TableName: tn}, nil
 		}
+	case *JoinTableExpr:
+		return ChangefeedTargetFromTableExpr(t.Left)
 	}
 	return ChangefeedTarget{}, pgerror.Newf(
 		pgcode.InvalidName, "unsupported changefeed target type")
@@ -213,6 +213,25 @@ var FunDefs map[string]*FunctionDefinition
 // instances. Keys of the map is schema qualified function names.
 var ResolvedBuiltinFuncDefs map[string]*ResolvedFunctionDefinition
 
+// GetBuiltinFunctionInSchema returns the builtin function with the specified
+// unqualified name which is available on the schema, or nil if there is none.
+// It is overridden by the builtins package with a lookup into the builtins
+// registry index, which avoids building the qualified name of the function.
+var GetBuiltinFunctionInSchema = func(schema, name string) *ResolvedFunctionDefinition {
+	return ResolvedBuiltinFuncDefs[schema+"."+name]
+}
+
+// GetBuiltinOverloadsWithFirstArgFamily returns the overloads of the builtin
+// function with the specified name whose first parameter accepts a value of the
+// type family, and whether there is such a builtin. It is overridden by the
+// builtins package with a lookup into the builtins registry index, which
+// groups the overloads by the type family of their first parameter.
+var GetBuiltinOverloadsWithFirstArgFamily = func(
+	name string, family types.Family,
+) ([]*Overload, bool) {
+	return nil, false
+}
+
 // OidToBuiltinName contains a map from the hashed OID of all builtin functions
 // to their name. We populate this from the pg_catalog.go file in the sql
 // package because of dependency issues: we can't use oidHasher from this file.
@@ -312,6 +331,43 @@ func (fd *ResolvedFunctionDefinition) MatchOverload(
 	return ret[0], nil
 }
 
+// OverloadsInSearchPathOrder returns the overloads which can be resolved
+// given an explicit schema or a search path, ordered by the position of their
+// schema in the search path. If explicitSchema is not empty, only overloads
+// from that schema are returned and the search path is ignored. pg_catalog is
+// treated as the first schema unless it is explicitly listed in the search
+// path, which matches Postgres semantics.
+//
+// Within a single schema, UDF overloads are ordered before builtin overloads.
+// This allows a UDF to shadow a builtin of the same name which is also
+// available on that schema (i.e. builtins with AvailableOnPublicSchema set).
+func (fd *ResolvedFunctionDefinition) OverloadsInSearchPathOrder(
+	explicitSchema string, searchPath SearchPath,
+) ([]QualifiedOverload, error) {
+	ret := make([]QualifiedOverload, 0, len(fd.Overloads))
+	appendFromSchema := func(schema string) {
+		for _, isUDF := range []bool{true, false} {
+			for i := range fd.Overloads {
+				if fd.Overloads[i].Schema == schema && fd.Overloads[i].IsUDF == isUDF {
+					ret = append(ret, fd.Overloads[i])
+				}
+			}
+		}
+	}
+
+	if explicitSchema != "" {
+		appendFromSchema(explicitSchema)
+		return ret, nil
+	}
+	if err := searchPath.IterateSearchPath(func(schema string) error {
+		appendFromSchema(schema)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 func combineOverloads(a, b []QualifiedOverload) []QualifiedOverload {
 	return append(append(make([]QualifiedOverload, 0, len(a)+len(b)), a...), b...)
 }
@@ -409,7 +465,7 @@ func GetBuiltinFuncDefinition(
 	fName *FunctionName, searchPath SearchPath,
 ) (*ResolvedFunctionDefinition, error) {
 	if fName.ExplicitSchema {
-		return ResolvedBuiltinFuncDefs[fName.Schema()+"."+fName.Object()], nil
+		return GetBuiltinFunctionInSchema(fName.Schema(), fName.Object()), nil
 	}
 
 	// First try that if we can get function directly with the function name.
@@ -419,16 +475,12 @@ func GetBuiltinFuncDefinition(
 		return def, nil
 	}
 
-	// Then try if it's in pg_catalog.
-	if def, ok := ResolvedBuiltinFuncDefs[catconstants.PgCatalogName+"."+fName.Object()]; ok {
-		return def, nil
-	}
-
-	// If not in pg_catalog, go through search path.
+	// Go through the search path. pg_catalog is implicitly the first schema of
+	// the search path unless it's explicitly listed, in which case builtins
+	// available on an earlier schema (e.g. public) are resolved first.
 	var resolvedDef *ResolvedFunctionDefinition
 	if err := searchPath.IterateSearchPath(func(schema string) error {
-		fullName := schema + "." + fName.Object()
-		if def, ok := ResolvedBuiltinFuncDefs[fullName]; ok {
+		if def := GetBuiltinFunctionInSchema(schema, fName.Object()); def != nil {
 			resolvedDef = def
 			return iterutil.StopIteration()
 		}
@@ -436,6 +488,10 @@ func GetBuiltinFuncDefinition(
 	}); err != nil {
 		return nil, err
 	}
+	if resolvedDef != nil {
+		return resolvedDef, nil
+	}
 
-	return resolvedDef, nil
+	// Fall back to pg_catalog in case the search path is empty.
+	return GetBuiltinFunctionInSchema(catconstants.PgCatalogName, fName.Object()), nil
 }
@@ -29,6 +29,7 @@ func TestBuiltinFunctionResolver(t *testing.T) {
 	testCases := []struct {
 		testName       string
 		fnName         tree.UnresolvedName
+		path           []string
 		expectedSchema string
 		expectNoFound  bool
 	}{
@@ -67,12 +68,33 @@ func TestBuiltinFunctionResolver(t *testing.T) {
 			fnName:         tree.UnresolvedName{NumParts: 1, Parts: tree.NameParts{"json_to_pb", "", "", ""}},
 			expectedSchema: "crdb_internal",
 		},
+		{
+			testName:       "implicit pg_catalog before public",
+			fnName:         tree.UnresolvedName{NumParts: 1, Parts: tree.NameParts{"st_makeline", "", "", ""}},
+			path:           []string{"public"},
+			expectedSchema: "pg_catalog",
+		},
+		{
+			testName:       "explicit pg_catalog after public",
+			fnName:         tree.UnresolvedName{NumParts: 1, Parts: tree.NameParts{"st_makeline", "", "", ""}},
+			path:           []string{"public", "pg_catalog"},
+			expectedSchema: "public",
+		},
+		{
+			testName:       "explicit pg_catalog after public but not available on public",
+			fnName:         tree.UnresolvedName{NumParts: 1, Parts: tree.NameParts{"lower", "", "", ""}},
+			path:           []string{"public", "pg_catalog"},
+			expectedSchema: "pg_catalog",
+		},
 	}
 
-	path := sessiondata.MakeSearchPath([]string{"crdb_internal"})
-
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
+			schemas := tc.path
+			if schemas == nil {
+				schemas = []string{"crdb_internal"}
+			}
+			path := sessiondata.MakeSearchPath(schemas)
 			fnName, err := tc.fnName.ToFunctionName()
 			require.NoError(t, err)
 			funcDef, err := tree.GetBuiltinFuncDefinition(fnName, &path)
@@ -206,3 +228,78 @@ func TestMatchOverload(t *testing.T) {
 		})
 	}
 }
+
+func TestOverloadsInSearchPathOrder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	fd := &tree.ResolvedFunctionDefinition{
+		Name: "f",
+		Overloads: []tree.QualifiedOverload{
+			{
+				Schema:   "pg_catalog",
+				Overload: &tree.Overload{Oid: 1, IsUDF: false, Types: tree.ArgTypes{tree.ArgType{Typ: types.Int}}},
+			},
+			{
+				Schema:   "public",
+				Overload: &tree.Overload{Oid: 2, IsUDF: false, Types: tree.ArgTypes{tree.ArgType{Typ: types.Int}}},
+			},
+			{
+				Schema:   "public",
+				Overload: &tree.Overload{Oid: 3, IsUDF: true, Types: tree.ArgTypes{tree.ArgType{Typ: types.Int}}},
+			},
+			{
+				Schema:   "sc1",
+				Overload: &tree.Overload{Oid: 4, IsUDF: true, Types: tree.ArgTypes{tree.ArgType{Typ: types.Int}}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		testName       string
+		explicitSchema string
+		path           []string
+		expectedOids   []oid.Oid
+	}{
+		{
+			testName:     "implicit pg_catalog in path",
+			path:         []string{"public", "sc1"},
+			expectedOids: []oid.Oid{1, 3, 2, 4},
+		},
+		{
+			testName:     "explicit pg_catalog in path",
+			path:         []string{"sc1", "public", "pg_catalog"},
+			expectedOids: []oid.Oid{4, 3, 2, 1},
+		},
+		{
+			testName:     "schema not in path is filtered out",
+			path:         []string{"public"},
+			expectedOids: []oid.Oid{1, 3, 2},
+		},
+		{
+			testName:       "explicit schema",
+			explicitSchema: "public",
+			path:           []string{"sc1"},
+			expectedOids:   []oid.Oid{3, 2},
+		},
+		{
+			testName:       "explicit schema without overloads",
+			explicitSchema: "sc2",
+			path:           []string{"sc1"},
+			expectedOids:   []oid.Oid{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			path := sessiondata.MakeSearchPath(tc.path)
+			ols, err := fd.OverloadsInSearchPathOrder(tc.explicitSchema, &path)
+			require.NoError(t, err)
+			oids := make([]oid.Oid, len(ols))
+			for i := range ols {
+				oids[i] = ols[i].Oid
+			}
+			require.Equal(t, tc.expectedOids, oids)
+		})
+	}
+}
@@ -85,11 +85,11 @@ func (ref *ResolvableFunctionReference) Resolve(
 			// defined within virtual schema and don't belong to any database catalog.
 			return nil, errors.AssertionFailedf("invalid builtin function name: %q", t.Name)
 		}
-		fullName := t.Name
-		if len(parts) == 1 {
-			fullName = catconstants.PgCatalogName + "." + t.Name
+		schema, name := catconstants.PgCatalogName, t.Name
+		if len(parts) == 2 {
+			schema, name = parts[0], parts[1]
 		}
-		fd := ResolvedBuiltinFuncDefs[fullName]
+		fd := GetBuiltinFunctionInSchema(schema, name)
 		ref.FunctionReference = fd
 		return fd, nil
 	case *UnresolvedName:
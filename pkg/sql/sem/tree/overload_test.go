@@ -434,6 +434,34 @@ func TestGetMostSignificantOverload(t *testing.T) {
 			searchPath:  makeSearchPath([]string{"sc3", "sc2", "sc1", "pg_catalog"}),
 			expectedOID: 2,
 		},
+		{
+			testName: "udf shadows builtin available on public schema",
+			overloads: []overloadImpl{
+				QualifiedOverload{Schema: "public", Overload: &Overload{Oid: 1, Types: ArgTypes{}, ReturnType: returnTyper}},
+				QualifiedOverload{Schema: "public", Overload: &Overload{Oid: 2, IsUDF: true, Types: ArgTypes{}, ReturnType: returnTyper}},
+			},
+			searchPath:  makeSearchPath([]string{"public"}),
+			expectedOID: 2,
+		},
+		{
+			testName: "udf shadows builtin on public schema before explicit pg_catalog",
+			overloads: []overloadImpl{
+				QualifiedOverload{Schema: "pg_catalog", Overload: &Overload{Oid: 1, Types: ArgTypes{}, ReturnType: returnTyper}},
+				QualifiedOverload{Schema: "public", Overload: &Overload{Oid: 2, Types: ArgTypes{}, ReturnType: returnTyper}},
+				QualifiedOverload{Schema: "public", Overload: &Overload{Oid: 3, IsUDF: true, Types: ArgTypes{}, ReturnType: returnTyper}},
+			},
+			searchPath:  makeSearchPath([]string{"public", "pg_catalog"}),
+			expectedOID: 3,
+		},
+		{
+			testName: "builtin on implicit pg_catalog wins over udf on public schema",
+			overloads: []overloadImpl{
+				QualifiedOverload{Schema: "pg_catalog", Overload: &Overload{Oid: 1, Types: ArgTypes{}, ReturnType: returnTyper}},
+				QualifiedOverload{Schema: "public", Overload: &Overload{Oid: 2, IsUDF: true, Types: ArgTypes{}, ReturnType: returnTyper}},
+			},
+			searchPath:  makeSearchPath([]string{"public"}),
+			expectedOID: 1,
+		},
 		{
 			testName: "unique schema not in path",
 			overloads: []overloadImpl{
@@ -181,6 +181,7 @@ var _ CCLOnlyStatement = &Import{}
 var _ CCLOnlyStatement = &Export{}
 var _ CCLOnlyStatement = &ScheduledBackup{}
 var _ CCLOnlyStatement = &StreamIngestion{}
+var _ CCLOnlyStatement = &ValidateExternalConnection{}
 
 // StatementReturnType implements the Statement interface.
 func (*AlterChangefeed) StatementReturnType() StatementReturnType { return Rows }
@@ -1890,6 +1891,17 @@ func (*UnionClause) StatementType() StatementType { return TypeDML }
 // StatementTag returns a short string identifying the type of statement.
 func (*UnionClause) StatementTag() string { return "UNION" }
 
+// StatementReturnType implements the Statement interface.
+func (*ValidateExternalConnection) StatementReturnType() StatementReturnType { return Rows }
+
+// StatementType implements the Statement interface.
+func (*ValidateExternalConnection) StatementType() StatementType { return TypeDML }
+
+// StatementTag returns a short string identifying the type of statement.
+func (*ValidateExternalConnection) StatementTag() string { return "VALIDATE EXTERNAL CONNECTION" }
+
+func (*ValidateExternalConnection) cclOnlyStatement() {}
+
 // StatementReturnType implements the Statement interface.
 func (*ValuesClause) StatementReturnType() StatementReturnType { return Rows }
 
@@ -2161,4 +2173,5 @@ func (n *Unsplit) String() string                             { return AsString(
 func (n *Truncate) String() string                            { return AsString(n) }
 func (n *UnionClause) String() string                         { return AsString(n) }
 func (n *Update) String() string                              { return AsString(n) }
+func (n *ValidateExternalConnection) String() string          { return AsString(n) }
 func (n *ValuesClause) String() string                        { return AsString(n) }
@@ -1032,6 +1032,39 @@ func CheckIsWindowOrAgg(def *ResolvedFunctionDefinition) error {
 	return nil
 }
 
+// knownTypeFamily returns the type family of the expression if its type is
+// known before it is type checked, i.e. if it is a datum or a typed column
+// reference.
+func knownTypeFamily(expr Expr) (types.Family, bool) {
+	var typ *types.T
+	switch e := expr.(type) {
+	case Datum:
+		typ = e.ResolvedType()
+	case *IndexedVar:
+		typ = e.typ
+	case *Subquery, *UnresolvedName, *ColumnItem, UnqualifiedStar, *AllColumnsSelector:
+		// These are typed by type checking, if at all.
+	case VariableExpr:
+		if typed, ok := e.(TypedExpr); ok {
+			typ = typed.ResolvedType()
+		}
+	}
+	if typ == nil || typ.Family() == types.UnknownFamily || typ.Family() == types.AnyFamily {
+		return 0, false
+	}
+	return typ.Family(), true
+}
+
+// containsOverload returns whether the overload is one of the overloads.
+func containsOverload(overloads []*Overload, o *Overload) bool {
+	for _, other := range overloads {
+		if other == o {
+			return true
+		}
+	}
+	return false
+}
+
 // TypeCheck implements the Expr interface.
 func (expr *FuncExpr) TypeCheck(
 	ctx context.Context, semaCtx *SemaContext, desired *types.T,
@@ -1073,8 +1106,22 @@ func (expr *FuncExpr) TypeCheck(
 		}
 	}
 
+	// If the type of the first argument is known before it is type checked,
+	// the builtin overloads which cannot accept it are skipped using the index
+	// of the builtins.
+	var firstArgOverloads []*Overload
+	filterFirstArg := false
+	if len(expr.Exprs) > 0 {
+		if family, ok := knownTypeFamily(expr.Exprs[0]); ok {
+			firstArgOverloads, filterFirstArg = GetBuiltinOverloadsWithFirstArgFamily(def.Name, family)
+		}
+	}
 	overloadImpls := make([]overloadImpl, 0, len(def.Overloads))
 	for i := range def.Overloads {
+		if filterFirstArg && !def.Overloads[i].IsUDF &&
+			!containsOverload(firstArgOverloads, def.Overloads[i].Overload) {
+			continue
+		}
 		overloadImpls = append(overloadImpls, def.Overloads[i])
 	}
 	typedSubExprs, fns, err := typeCheckOverloadedExprs(ctx, semaCtx, desired, overloadImpls, false, expr.Exprs...)
@@ -2929,9 +2976,10 @@ func (stripFuncsVisitor) VisitPost(expr Expr) Expr { return expr }
 
 // getMostSignificantOverload returns the overload from the most significant
 // schema. If there are more than one overload available from the most
-// significant schema, ambiguity error will be thrown. If search path is not
-// given or no UDF found, there should be only one candidate overload and be
-// returned. Otherwise, ambiguity error is also thrown.
+// significant schema, ambiguity error will be thrown unless exactly one of
+// them is a UDF, in which case the UDF shadows the builtins. If search path is
+// not given or no UDF found, there should be only one candidate overload and
+// be returned. Otherwise, ambiguity error is also thrown.
 //
 // Note: even the input is a slice of overloadImpl, they're essentially a slice
 // of QualifiedOverload. Also, the input should not be empty.
@@ -2983,23 +3031,18 @@ func getMostSignificantOverload(
 		// the search path as well. This is because we only resolve functions from
 		// explicit schema or schemas on the search path. So if overloads are from
 		// the same schema, overloads are either from an explicit schema or from a
-		// schema on search path.
-		return checkAmbiguity(overloads)
+		// schema on search path. A UDF shadows a builtin from the same schema.
+		return checkAmbiguity(preferUDFOverloads(overloads))
 	}
 
-	found := false
-	var ret QualifiedOverload
+	var fromSchema []overloadImpl
 	err := searchPath.IterateSearchPath(func(schema string) error {
 		for i := range overloads {
 			if overloads[i].(QualifiedOverload).Schema == schema {
-				if found {
-					return ambiguousError()
-				}
-				found = true
-				ret = overloads[i].(QualifiedOverload)
+				fromSchema = append(fromSchema, overloads[i])
 			}
 		}
-		if found {
+		if len(fromSchema) > 0 {
 			return iterutil.StopIteration()
 		}
 		return nil
@@ -3007,11 +3050,28 @@ func getMostSignificantOverload(
 	if err != nil {
 		return QualifiedOverload{}, err
 	}
-	if !found {
+	if len(fromSchema) == 0 {
 		// This should never happen. Otherwise, it means we get function from a
 		// schema no on the given search path or we try to resolve a function on an
 		// explicit schema, but get some function from other schemas are fetched.
 		return QualifiedOverload{}, pgerror.Newf(pgcode.UndefinedFunction, "unknown signature: %s", getFuncSig())
 	}
-	return ret, nil
+	return checkAmbiguity(preferUDFOverloads(fromSchema))
+}
+
+// preferUDFOverloads returns only the UDF overloads of the given candidates if
+// there is any. Otherwise, the candidates are returned as is. This is used to
+// let a UDF shadow a builtin with a compatible signature from the same schema,
+// which can happen for builtins available on the public schema.
+func preferUDFOverloads(overloads []overloadImpl) []overloadImpl {
+	var udfs []overloadImpl
+	for _, o := range overloads {
+		if o.(QualifiedOverload).IsUDF {
+			udfs = append(udfs, o)
+		}
+	}
+	if len(udfs) == 0 {
+		return overloads
+	}
+	return udfs
 }
@@ -0,0 +1,25 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tree
+
+// ValidateExternalConnection represents a VALIDATE EXTERNAL CONNECTION
+// statement.
+type ValidateExternalConnection struct {
+	ConnectionLabel Expr
+}
+
+var _ Statement = &ValidateExternalConnection{}
+
+// Format implements the NodeFormatter interface.
+func (node *ValidateExternalConnection) Format(ctx *FmtCtx) {
+	ctx.WriteString("VALIDATE EXTERNAL CONNECTION ")
+	ctx.FormatNode(node.ConnectionLabel)
+}
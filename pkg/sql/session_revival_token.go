@@ -11,13 +11,19 @@
 package sql
 
 import (
+	"context"
+	"time"
+
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/security/sessionrevival"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	pbtypes "github.com/gogo/protobuf/types"
 )
 
 // AllowSessionRevival is true if the cluster is allowed to create session
@@ -88,3 +94,47 @@ func (p *planner) ValidateSessionRevivalToken(token *tree.DBytes) (*tree.DBool,
 	}
 	return tree.DBoolTrue, nil
 }
+
+// DecodeSessionRevivalToken decodes the claims of a session revival token,
+// and validates it for the user it was issued to. The claims are returned as a
+// JSON object, along with whether the token is valid and if not, why.
+func (p *planner) DecodeSessionRevivalToken(
+	ctx context.Context, token *tree.DBytes,
+) (*tree.DJSON, error) {
+	if !AllowSessionRevival.Get(&p.ExecCfg().Settings.SV) || p.ExecCfg().Codec.ForSystemTenant() {
+		return nil, pgerror.New(pgcode.FeatureNotSupported, "session revival tokens are not supported on this cluster")
+	}
+	if err := p.RequireAdminRole(ctx, "decode session revival tokens"); err != nil {
+		return nil, err
+	}
+	cm, err := p.ExecCfg().RPCContext.SecurityContext.GetCertificateManager()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := sessionrevival.DecodeSessionRevivalToken([]byte(*token))
+	if err != nil {
+		return nil, pgerror.Wrap(err, pgcode.InvalidParameterValue, "invalid session revival token")
+	}
+	issuedAt, err := pbtypes.TimestampFromProto(payload.IssuedAt)
+	if err != nil {
+		return nil, pgerror.Wrap(err, pgcode.InvalidParameterValue, "invalid session revival token")
+	}
+	expiresAt, err := pbtypes.TimestampFromProto(payload.ExpiresAt)
+	if err != nil {
+		return nil, pgerror.Wrap(err, pgcode.InvalidParameterValue, "invalid session revival token")
+	}
+
+	b := json.NewObjectBuilder(6)
+	b.Add("user", json.FromString(payload.User))
+	b.Add("algorithm", json.FromString(payload.Algorithm))
+	b.Add("issued_at", json.FromString(issuedAt.UTC().Format(time.RFC3339Nano)))
+	b.Add("expires_at", json.FromString(expiresAt.UTC().Format(time.RFC3339Nano)))
+	user := username.MakeSQLUsernameFromPreNormalizedString(payload.User)
+	if err := sessionrevival.ValidateSessionRevivalToken(cm, user, []byte(*token)); err != nil {
+		b.Add("valid", json.FalseJSONValue)
+		b.Add("error", json.FromString(err.Error()))
+	} else {
+		b.Add("valid", json.TrueJSONValue)
+	}
+	return tree.NewDJSON(b.Build()), nil
+}
@@ -878,6 +878,7 @@ func TestLint(t *testing.T) {
 			`\bgrpc\.NewServer\(`,
 			"--",
 			"*.go",
+			":!ccl/changefeedccl/sink_grpc_test.go",
 			":!rpc/context_test.go",
 			":!rpc/context.go",
 			":!rpc/nodedialer/nodedialer_test.go",
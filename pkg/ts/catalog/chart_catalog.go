@@ -1366,6 +1366,12 @@ var charts = []sectionDescription{
 					"changefeed.buffer_entries.out",
 				},
 			},
+			{
+				Title: "Oversized Entries",
+				Metrics: []string{
+					"changefeed.buffer_entries.oversized",
+				},
+			},
 			{
 				Title: "Errors",
 				Metrics: []string{
@@ -1423,6 +1429,19 @@ var charts = []sectionDescription{
 					"changefeed.replan_count",
 				},
 			},
+			{
+				Title: "Suppressed Duplicates",
+				Metrics: []string{
+					"changefeed.suppress_duplicates.suppressed",
+					"changefeed.suppress_duplicates.evictions",
+				},
+			},
+			{
+				Title: "Dead Letter Messages",
+				Metrics: []string{
+					"changefeed.dead_letter_messages",
+				},
+			},
 			{
 				Title: "Flushed Bytes",
 				Metrics: []string{
@@ -1452,6 +1471,7 @@ var charts = []sectionDescription{
 				Metrics: []string{
 					"changefeed.checkpoint_hist_nanos",
 					"changefeed.flush_hist_nanos",
+					"changefeed.resolved_flush_delay_nanos",
 					"changefeed.sink_batch_hist_nanos",
 				},
 			},
@@ -1467,6 +1487,7 @@ var charts = []sectionDescription{
 				Metrics: []string{
 					"changefeed.buffer_pushback_nanos",
 					"changefeed.queue_time_nanos",
+					"changefeed.sink_backpressure_nanos",
 				},
 			},
 			{
@@ -1489,6 +1510,21 @@ var charts = []sectionDescription{
 					"changefeed.internal_retry_message_count",
 				},
 			},
+			{
+				Title: "Sink Requests",
+				Metrics: []string{
+					"changefeed.sink_batches_in_flight",
+					"changefeed.sink_retries",
+					"changefeed.sink_errors",
+				},
+			},
+			{
+				Title: "Sink Connection Pool",
+				Metrics: []string{
+					"changefeed.sink_connection_pool.connections",
+					"changefeed.sink_connection_pool.sinks",
+				},
+			},
 		},
 	},
 	{
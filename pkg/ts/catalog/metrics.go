@@ -46,6 +46,7 @@ var histogramMetricsNames = map[string]struct{}{
 	"sql.mem.sql.session.max":                   {},
 	"sql.stats.flush.duration":                  {},
 	"changefeed.checkpoint_hist_nanos":          {},
+	"changefeed.resolved_flush_delay_nanos":     {},
 	"admission.wait_durations.sql-sql-response": {},
 	"admission.wait_durations.sql-kv-response":  {},
 	"sql.exec.latency":                          {},
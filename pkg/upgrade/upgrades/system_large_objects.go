@@ -0,0 +1,35 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package upgrades
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/systemschema"
+	"github.com/cockroachdb/cockroach/pkg/upgrade"
+)
+
+// systemLargeObjectsTableMigration creates the system.large_objects table and
+// the system.large_object_oid_seq sequence.
+func systemLargeObjectsTableMigration(
+	ctx context.Context, _ clusterversion.ClusterVersion, d upgrade.TenantDeps, _ *jobs.Job,
+) error {
+	if err := createSystemTable(
+		ctx, d.DB, d.Codec, systemschema.LargeObjectsTable,
+	); err != nil {
+		return err
+	}
+	return createSystemTable(
+		ctx, d.DB, d.Codec, systemschema.LargeObjectOIDSequence,
+	)
+}
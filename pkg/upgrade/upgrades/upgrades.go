@@ -159,6 +159,16 @@ var upgrades = []upgrade.Upgrade{
 		NoPrecondition,
 		updateInvalidColumnIDsInSequenceBackReferences,
 	),
+	upgrade.NewTenantUpgrade("add the system.large_objects table and its OID sequence",
+		toCV(clusterversion.SystemLargeObjectsTable),
+		NoPrecondition,
+		systemLargeObjectsTableMigration,
+	),
+	upgrade.NewTenantUpgrade("add the system.advisory_locks table",
+		toCV(clusterversion.SystemAdvisoryLocksTable),
+		NoPrecondition,
+		systemAdvisoryLocksTableMigration,
+	),
 }
 
 func init() {
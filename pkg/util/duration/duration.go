@@ -349,13 +349,25 @@ func (d Duration) AsInt64() (int64, bool) {
 }
 
 // AsFloat64 converts a duration to a float64 number of seconds.
+//
+// This matches the epoch of an interval in Postgres: years are DaysPerYear
+// days long, the remaining months DaysPerMonth days long, and days 24 hours
+// long. The terms are computed as floats and summed in the same order as in
+// Postgres, so that the results match exactly and large durations do not
+// overflow.
 func (d Duration) AsFloat64() float64 {
-	numYears := d.Months / 12
-	numMonthsInYear := d.Months % 12
-	return (float64(d.Nanos()) / float64(time.Second)) +
-		float64(d.Days*SecsPerDay) +
-		float64(numYears*SecsPerDay)*DaysPerYear +
-		float64(numMonthsInYear*DaysPerMonth*SecsPerDay)
+	numYears := d.Months / MonthsPerYear
+	numMonthsInYear := d.Months % MonthsPerYear
+	// Postgres intervals have microsecond precision; only add the remaining
+	// nanoseconds separately so that the common case is computed the same way.
+	result := float64(d.nanos/nanosInMicro) / float64(time.Second/time.Microsecond)
+	if rem := d.nanos % nanosInMicro; rem != 0 {
+		result += float64(rem) / float64(time.Second)
+	}
+	result += (DaysPerYear * SecsPerDay) * float64(numYears)
+	result += (DaysPerMonth * SecsPerDay) * float64(numMonthsInYear)
+	result += SecsPerDay * float64(d.Days)
+	return result
 }
 
 // AsBigInt converts a duration to an apd.BigInt with the number of nanoseconds.
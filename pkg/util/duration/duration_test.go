@@ -548,6 +548,39 @@ func TestFloatMath(t *testing.T) {
 	}
 }
 
+// TestAsFloat64 verifies that the number of seconds of a duration matches the
+// epoch of the interval in Postgres, which considers years to be 365.25 days
+// long, the remaining months 30 days long, and days 24 hours long.
+func TestAsFloat64(t *testing.T) {
+	const nanosInMinute = nanosInSecond * 60
+	const nanosInHour = nanosInMinute * 60
+
+	testCases := []struct {
+		d        Duration
+		expected float64
+	}{
+		// SELECT extract(epoch FROM interval '1 year 2 mons 3 days 04:05:06.789')
+		{
+			Duration{Months: 14, Days: 3, nanos: nanosInHour*4 + nanosInMinute*5 + nanosInSecond*6 + 789*nanosInMicro*1000},
+			37015506.789000005,
+		},
+		// SELECT extract(epoch FROM interval '-1 year -1 mons 1 day -00:00:00.25')
+		{Duration{Months: -13, Days: 1, nanos: -nanosInSecond / 4}, -34063200.25},
+		// SELECT extract(epoch FROM interval '13 mons')
+		{Duration{Months: 13}, 34149600},
+		// SELECT extract(epoch FROM interval '1 mon 15 days')
+		{Duration{Months: 1, Days: 15}, 3888000},
+		// SELECT extract(epoch FROM interval '00:00:00.000001')
+		{Duration{nanos: nanosInMicro}, 1e-06},
+		{Duration{Days: 1, nanos: 1}, 86400.000000001},
+		// The number of seconds of the days overflows an int64.
+		{Duration{Days: 1 << 50}, 9.727775195120271e+19},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, tc.d.AsFloat64(), "%s", tc.d)
+	}
+}
+
 func TestTruncate(t *testing.T) {
 	zero := time.Duration(0).String()
 	testCases := []struct {
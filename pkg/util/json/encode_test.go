@@ -155,6 +155,8 @@ func TestJSONEncodeRoundTrip(t *testing.T) {
 		`-1`,
 		`1000000000000000`,
 		`100000000000000000000000000000000000`,
+		`123456789012345678901234567890`,
+		`0.1234567890123456789012345678901234567890`,
 		`0e1`,
 		`[]`,
 		`["hello"]`,
@@ -166,7 +166,9 @@ type JSON interface {
 	RemovePath(path []string) (JSON, bool, error)
 	doRemovePath(path []string) (JSON, bool, error)
 
-	// Concat implements the `||` operator.
+	// Concat implements the `||` operator. Objects are merged shallowly: for
+	// keys present in both objects, the value of the other object is used as
+	// is, even if both values are objects. See DeepMerge for a recursive merge.
 	Concat(other JSON) (JSON, error)
 
 	// AsText returns the JSON document as a string, with quotes around strings removed, and null as nil.
@@ -2219,6 +2221,50 @@ func (j jsonObject) Concat(other JSON) (JSON, error) {
 	}
 }
 
+// DeepMerge recursively merges the right JSON document into the left one.
+// Unlike Concat, nested objects present at the same key in both documents are
+// merged key by key. In all other cases, the value from the right document
+// replaces the value from the left one: in particular, arrays are replaced
+// rather than concatenated, and conflicting scalars resolve to the right value.
+func DeepMerge(left, right JSON) (JSON, error) {
+	if left.Type() != ObjectJSONType || right.Type() != ObjectJSONType {
+		return right, nil
+	}
+	decoded, err := left.tryDecode()
+	if err != nil {
+		return nil, err
+	}
+	l := decoded.(jsonObject)
+	decoded, err = right.tryDecode()
+	if err != nil {
+		return nil, err
+	}
+	r := decoded.(jsonObject)
+
+	// Since both objects are sorted, merge them the same way Concat does,
+	// recursing into the values of the keys present in both objects.
+	result := make(jsonObject, 0, len(l)+len(r))
+	rightIdx := 0
+	for _, kv := range l {
+		for rightIdx < len(r) && r[rightIdx].k < kv.k {
+			result = append(result, r[rightIdx])
+			rightIdx++
+		}
+		if rightIdx < len(r) && r[rightIdx].k == kv.k {
+			v, err := DeepMerge(kv.v, r[rightIdx].v)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, jsonKeyValuePair{k: kv.k, v: v})
+			rightIdx++
+		} else {
+			result = append(result, kv)
+		}
+	}
+	result = append(result, r[rightIdx:]...)
+	return result, nil
+}
+
 func (j jsonString) AsText() (*string, error) {
 	s := string(j)
 	return &s, nil
@@ -70,8 +70,12 @@ func TestJSONOrdering(t *testing.T) {
 		`"b"`,
 		`"bb"`,
 		`1`,
+		`1.000000000000000000000000000001`,
+		`1.000000000000000000000000000002`,
 		`2`,
 		`100`,
+		`123456789012345678901234567890`,
+		`123456789012345678901234567891`,
 		`false`,
 		`true`,
 		// In Postgres's sorting rules, the empty array comes before everything (even null),
@@ -152,6 +156,8 @@ func TestJSONRoundTrip(t *testing.T) {
 		`1.00`,
 		`1.00000000000`,
 		`100000000000000000000000000000000000000000`,
+		`123456789012345678901234567890`,
+		`0.1234567890123456789012345678901234567890`,
 		`1.3e100`,
 		`true`,
 		` true `,
@@ -1962,6 +1968,64 @@ func TestConcat(t *testing.T) {
 	}
 }
 
+func TestDeepMerge(t *testing.T) {
+	cases := map[string][]struct {
+		mergeWith string
+		expected  string
+	}{
+		`null`: {
+			{`{"a": 1}`, `{"a": 1}`},
+			{`1`, `1`},
+		},
+		`[1, 2]`: {
+			{`[3]`, `[3]`},
+			{`{"a": 1}`, `{"a": 1}`},
+		},
+		`{"a": 1}`: {
+			{`null`, `null`},
+			{`[2]`, `[2]`},
+			{`{}`, `{"a": 1}`},
+			{`{"b": 2}`, `{"a": 1, "b": 2}`},
+			{`{"a": 2}`, `{"a": 2}`},
+			{`{"a": null}`, `{"a": null}`},
+			{`{"a": {"b": 2}}`, `{"a": {"b": 2}}`},
+		},
+		`{"a": {"b": 1, "c": {"d": 1, "e": [1, 2]}}, "f": 1}`: {
+			{`{"a": {"c": {"d": 2}}}`, `{"a": {"b": 1, "c": {"d": 2, "e": [1, 2]}}, "f": 1}`},
+			{`{"a": {"c": {"e": [3]}}}`, `{"a": {"b": 1, "c": {"d": 1, "e": [3]}}, "f": 1}`},
+			{`{"a": {"c": 1}, "g": 2}`, `{"a": {"b": 1, "c": 1}, "f": 1, "g": 2}`},
+			{`{"a": {"b": {"x": 1}}}`, `{"a": {"b": {"x": 1}, "c": {"d": 1, "e": [1, 2]}}, "f": 1}`},
+			{`{"f": {"x": 1}}`, `{"a": {"b": 1, "c": {"d": 1, "e": [1, 2]}}, "f": {"x": 1}}`},
+		},
+	}
+
+	for k, tcs := range cases {
+		left := jsonTestShorthand(k)
+		runDecodedAndEncoded(t, k, left, func(t *testing.T, left JSON) {
+			for _, tc := range tcs {
+				right := jsonTestShorthand(tc.mergeWith)
+				runDecodedAndEncoded(t, tc.mergeWith, right, func(t *testing.T, right JSON) {
+					result, err := DeepMerge(left, right)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					expectedResult := jsonTestShorthand(tc.expected)
+
+					cmp, err := result.Compare(expectedResult)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if cmp != 0 {
+						t.Fatalf("expected deep merge of %v and %v = %v, got %v", left, right, expectedResult, result)
+					}
+				})
+			}
+		})
+	}
+}
+
 func TestJSONRandomRoundTrip(t *testing.T) {
 	rng := rand.New(rand.NewSource(timeutil.Now().Unix()))
 	for i := 0; i < 1000; i++ {
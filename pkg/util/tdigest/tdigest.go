@@ -0,0 +1,253 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package tdigest implements the merging t-digest, a compact sketch of a
+// distribution of values which estimates its quantiles.
+//
+// A t-digest summarizes the values as a sorted list of centroids, each of which
+// has a mean and a weight (the number of values it summarizes). The centroids
+// near the extremes of the distribution are kept small, so the estimates of
+// the extreme quantiles are more accurate than the ones of the median. The
+// number of centroids is bounded by the compression parameter, regardless of
+// the number of values. Digests can be merged, so a digest can be built in
+// parallel over partitions of the values.
+//
+// For more detailed information about the algorithm, see "Computing Extremely
+// Accurate Quantiles Using t-Digests" by Ted Dunning and Otmar Ertl, at
+// https://arxiv.org/abs/1902.04023.
+package tdigest
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+	"github.com/cockroachdb/errors"
+)
+
+// DefaultCompression is the compression which results in the estimates of the
+// quantiles being within 1% of the requested rank (and typically within 0.2%)
+// for continuous distributions, while keeping the encoded digests around a
+// kilobyte. The distributions with many repeated values can have larger errors
+// in rank, since the estimates interpolate between the repeated values.
+const DefaultCompression = 100
+
+// centroid summarizes a set of values by their mean and their number.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest is a merging t-digest. The zero value is not usable, use New.
+type TDigest struct {
+	compression float64
+	// centroids are the compressed centroids, sorted by mean.
+	centroids []centroid
+	// unmerged are the centroids which were added since the last compression.
+	unmerged []centroid
+	// weight is the total weight of the centroids and of the unmerged
+	// centroids.
+	weight   float64
+	min, max float64
+}
+
+// New returns an empty t-digest with the given compression. A larger
+// compression results in more accurate estimates at the cost of larger
+// digests.
+func New(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add adds a value to the digest.
+func (t *TDigest) Add(x float64) {
+	t.add(centroid{mean: x, weight: 1})
+}
+
+func (t *TDigest) add(c centroid) {
+	t.unmerged = append(t.unmerged, c)
+	t.weight += c.weight
+	t.min = math.Min(t.min, c.mean)
+	t.max = math.Max(t.max, c.mean)
+	if len(t.unmerged) >= t.bufferSize() {
+		t.compress()
+	}
+}
+
+// Merge adds the values summarized by another digest to this digest.
+func (t *TDigest) Merge(other *TDigest) {
+	if other.weight == 0 {
+		return
+	}
+	for _, c := range other.centroids {
+		t.add(c)
+	}
+	for _, c := range other.unmerged {
+		t.add(c)
+	}
+	// The centroids carry the means of the values, so the extremes of the
+	// other digest need to be merged separately.
+	t.min = math.Min(t.min, other.min)
+	t.max = math.Max(t.max, other.max)
+}
+
+// Count returns the number of values summarized by the digest.
+func (t *TDigest) Count() float64 {
+	return t.weight
+}
+
+// Quantile returns the estimate of the value at the given quantile, which
+// must be between 0 and 1. It returns NaN if the digest is empty.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	if len(t.centroids) == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return t.min
+	}
+	if q >= 1 {
+		return t.max
+	}
+	if len(t.centroids) == 1 {
+		return t.centroids[0].mean
+	}
+
+	// The values summarized by each centroid are assumed to be spread around
+	// its mean, so that half of its weight comes before the mean. The estimate
+	// interpolates linearly between the means of the neighboring centroids, and
+	// between the extremes and the first and last centroids.
+	target := q * t.weight
+	first := t.centroids[0]
+	if target < first.weight/2 {
+		return t.min + (first.mean-t.min)*target/(first.weight/2)
+	}
+	cumulative := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, cur := t.centroids[i-1], t.centroids[i]
+		step := (prev.weight + cur.weight) / 2
+		if target < cumulative+step {
+			return prev.mean + (cur.mean-prev.mean)*(target-cumulative)/step
+		}
+		cumulative += step
+	}
+	last := t.centroids[len(t.centroids)-1]
+	return last.mean + (t.max-last.mean)*(target-cumulative)/(last.weight/2)
+}
+
+// bufferSize is the number of unmerged centroids after which the digest is
+// compressed.
+func (t *TDigest) bufferSize() int {
+	return 5 * int(math.Ceil(t.compression))
+}
+
+// compress merges the unmerged centroids into the compressed centroids, and
+// merges the neighboring centroids as long as they stay within the size limit
+// given by the k1 scale function of the t-digest paper.
+func (t *TDigest) compress() {
+	if len(t.unmerged) == 0 {
+		return
+	}
+	all := append(t.centroids, t.unmerged...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(all))
+	cur := all[0]
+	// soFar is the total weight of the centroids before cur, and limit is the
+	// total weight up to which cur can grow.
+	var soFar float64
+	limit := t.weight * t.kInverse(t.k(0)+1)
+	for _, c := range all[1:] {
+		if soFar+cur.weight+c.weight <= limit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		merged = append(merged, cur)
+		soFar += cur.weight
+		limit = t.weight * t.kInverse(t.k(soFar/t.weight)+1)
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+	t.unmerged = t.unmerged[:0]
+}
+
+// k is the k1 scale function, which maps a quantile to the index of the
+// centroid containing it. The centroids are limited to a unit of k, which makes
+// them smaller near the extremes.
+func (t *TDigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// kInverse is the inverse of the k1 scale function.
+func (t *TDigest) kInverse(k float64) float64 {
+	return (math.Sin(math.Min(k*2*math.Pi/t.compression, math.Pi/2)) + 1) / 2
+}
+
+// Size returns the approximate size of the digest in memory, in bytes.
+func (t *TDigest) Size() int64 {
+	return int64(unsafe.Sizeof(*t)) +
+		int64(cap(t.centroids)+cap(t.unmerged))*int64(unsafe.Sizeof(centroid{}))
+}
+
+const (
+	encodingVersion   = 1
+	encodedHeaderSize = 1 + 3*8 + 4
+	encodedCentroid   = 2 * 8
+)
+
+// Encode appends the encoding of the digest to buf, and returns the result.
+func (t *TDigest) Encode(buf []byte) []byte {
+	t.compress()
+	buf = append(buf, encodingVersion)
+	buf = encoding.EncodeUntaggedFloatValue(buf, t.compression)
+	buf = encoding.EncodeUntaggedFloatValue(buf, t.min)
+	buf = encoding.EncodeUntaggedFloatValue(buf, t.max)
+	buf = encoding.EncodeUint32Ascending(buf, uint32(len(t.centroids)))
+	for _, c := range t.centroids {
+		buf = encoding.EncodeUntaggedFloatValue(buf, c.mean)
+		buf = encoding.EncodeUntaggedFloatValue(buf, c.weight)
+	}
+	return buf
+}
+
+// Decode decodes a digest encoded by Encode.
+func Decode(buf []byte) (*TDigest, error) {
+	if len(buf) < encodedHeaderSize {
+		return nil, errors.Newf("t-digest encoding too short: %d bytes", len(buf))
+	}
+	if v := buf[0]; v != encodingVersion {
+		return nil, errors.Newf("unknown t-digest encoding version %d", v)
+	}
+	// The length of the header was checked above, so the decoding of the
+	// header cannot fail.
+	buf, compression, _ := encoding.DecodeUntaggedFloatValue(buf[1:])
+	t := New(compression)
+	buf, t.min, _ = encoding.DecodeUntaggedFloatValue(buf)
+	buf, t.max, _ = encoding.DecodeUntaggedFloatValue(buf)
+	buf, n, _ := encoding.DecodeUint32Ascending(buf)
+	if len(buf) != int(n)*encodedCentroid {
+		return nil, errors.Newf(
+			"invalid t-digest encoding: expected %d bytes of centroids, found %d",
+			int(n)*encodedCentroid, len(buf),
+		)
+	}
+	t.centroids = make([]centroid, n)
+	for i := range t.centroids {
+		buf, t.centroids[i].mean, _ = encoding.DecodeUntaggedFloatValue(buf)
+		buf, t.centroids[i].weight, _ = encoding.DecodeUntaggedFloatValue(buf)
+		t.weight += t.centroids[i].weight
+	}
+	return t, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tdigest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/stretchr/testify/require"
+)
+
+var testQuantiles = []float64{0, 0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999, 1}
+
+var testDistributions = map[string]func(rng *rand.Rand) float64{
+	"uniform":     func(rng *rand.Rand) float64 { return rng.Float64() * 1000 },
+	"normal":      func(rng *rand.Rand) float64 { return rng.NormFloat64()*100 + 50 },
+	"exponential": func(rng *rand.Rand) float64 { return rng.ExpFloat64() },
+}
+
+// rankError returns the difference between q and the fraction of the sorted
+// values which are smaller than the estimate. If the estimate is one of the
+// values, any rank of that value is accepted.
+func rankError(sorted []float64, q, estimate float64) float64 {
+	lo := sort.SearchFloat64s(sorted, estimate)
+	hi := sort.Search(len(sorted), func(i int) bool { return sorted[i] > estimate })
+	if lo != hi {
+		// The estimate is one of the values, so it is exact as long as q falls
+		// within its ranks.
+		rankLo, rankHi := float64(lo)/float64(len(sorted)), float64(hi)/float64(len(sorted))
+		if q >= rankLo && q <= rankHi {
+			return 0
+		}
+		return math.Min(math.Abs(q-rankLo), math.Abs(q-rankHi))
+	}
+	return math.Abs(q - float64(lo)/float64(len(sorted)))
+}
+
+func TestQuantileAccuracy(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	const n = 100000
+	for name, gen := range testDistributions {
+		t.Run(name, func(t *testing.T) {
+			d := New(DefaultCompression)
+			values := make([]float64, n)
+			for i := range values {
+				values[i] = gen(rng)
+				d.Add(values[i])
+			}
+			sort.Float64s(values)
+			require.Equal(t, float64(n), d.Count())
+			for _, q := range testQuantiles {
+				estimate := d.Quantile(q)
+				require.LessOrEqualf(t, rankError(values, q, estimate), 0.01,
+					"quantile %f: estimate %f", q, estimate)
+			}
+			require.Equal(t, values[0], d.Quantile(0))
+			require.Equal(t, values[n-1], d.Quantile(1))
+			// The number of centroids is bounded by the compression.
+			require.LessOrEqual(t, len(d.centroids), DefaultCompression)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	const n, parts = 100000, 7
+	gen := testDistributions["normal"]
+	merged := New(DefaultCompression)
+	var values []float64
+	for i := 0; i < parts; i++ {
+		d := New(DefaultCompression)
+		// Use partitions of different sizes, one of which is empty.
+		for j := 0; j < i*n/(parts*(parts-1)/2); j++ {
+			v := gen(rng)
+			values = append(values, v)
+			d.Add(v)
+		}
+		merged.Merge(d)
+	}
+	sort.Float64s(values)
+	require.Equal(t, float64(len(values)), merged.Count())
+	for _, q := range testQuantiles {
+		estimate := merged.Quantile(q)
+		require.LessOrEqualf(t, rankError(values, q, estimate), 0.01,
+			"quantile %f: estimate %f", q, estimate)
+	}
+}
+
+func TestSmallDigests(t *testing.T) {
+	d := New(DefaultCompression)
+	require.True(t, math.IsNaN(d.Quantile(0.5)))
+	require.Equal(t, float64(0), d.Count())
+
+	d.Add(3)
+	for _, q := range testQuantiles {
+		require.Equal(t, float64(3), d.Quantile(q))
+	}
+
+	// The digests of few values are exact at the extremes, and interpolate
+	// linearly between the values.
+	d = New(DefaultCompression)
+	for _, v := range []float64{4, 1, 3, 2} {
+		d.Add(v)
+	}
+	require.Equal(t, float64(1), d.Quantile(0))
+	require.Equal(t, 2.5, d.Quantile(0.5))
+	require.Equal(t, float64(4), d.Quantile(1))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	for _, n := range []int{0, 1, 10, 10000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			d := New(DefaultCompression)
+			for i := 0; i < n; i++ {
+				d.Add(rng.NormFloat64())
+			}
+			encoded := d.Encode(nil)
+			decoded, err := Decode(encoded)
+			require.NoError(t, err)
+			require.Equal(t, d.Count(), decoded.Count())
+			for _, q := range testQuantiles {
+				expected, actual := d.Quantile(q), decoded.Quantile(q)
+				if n == 0 {
+					require.True(t, math.IsNaN(actual))
+				} else {
+					require.Equal(t, expected, actual)
+				}
+			}
+			require.Equal(t, encoded, decoded.Encode(nil))
+
+			_, err = Decode(encoded[:len(encoded)-1])
+			require.Error(t, err)
+		})
+	}
+
+	_, err := Decode([]byte{2})
+	require.Regexp(t, "too short", err)
+}